@@ -21,6 +21,14 @@
 
 var log = logging.GetContextLoggerFunc("kopia/cli")
 
+// exitCode is the process exit code requested by a command that completed without an error.
+var exitCode int
+
+// ExitCode returns the process exit code requested by the command that was executed.
+func ExitCode() int {
+	return exitCode
+}
+
 var (
 	defaultColor = color.New()
 	warningColor = color.New(color.FgYellow)
@@ -128,6 +136,10 @@ func maybeRepositoryAction(act func(ctx context.Context, rep repo.Repository) er
 			startMemoryTracking(ctx)
 			defer finishMemoryTracking(ctx)
 
+			setupLowMemoryMode(ctx)
+
+			defer startDiagnostics(ctx)()
+
 			if *metricsListenAddr != "" {
 				mux := http.NewServeMux()
 				if err := initPrometheus(mux); err != nil {
@@ -151,6 +163,10 @@ func maybeRepositoryAction(act func(ctx context.Context, rep repo.Repository) er
 				}
 			}
 
+			if rep != nil {
+				warnAboutCorruptCacheEntries(ctx, rep)
+			}
+
 			if rep != nil && required {
 				if cerr := rep.Close(ctx); cerr != nil {
 					return errors.Wrap(cerr, "unable to close repository")
@@ -162,6 +178,19 @@ func maybeRepositoryAction(act func(ctx context.Context, rep repo.Repository) er
 	}
 }
 
+// warnAboutCorruptCacheEntries warns when corrupted cache entries have been found while running the command,
+// which may indicate problems with the disk holding the cache.
+func warnAboutCorruptCacheEntries(ctx context.Context, rep repo.Repository) {
+	dr, ok := rep.(*repo.DirectRepository)
+	if !ok {
+		return
+	}
+
+	if n := dr.Content.Stats.CorruptCacheEntries(); n > 0 {
+		log(ctx).Warningf("Found %v corrupted cache entries, which have been fetched again from the repository. Check the disk holding the cache directory %v.", n, dr.Content.CachingOptions.CacheDirectory)
+	}
+}
+
 func maybeRunMaintenance(ctx context.Context, rep repo.Repository) error {
 	if !*enableAutomaticMaintenance {
 		return nil
@@ -171,6 +200,11 @@ func maybeRunMaintenance(ctx context.Context, rep repo.Repository) error {
 		return nil
 	}
 
+	// dry run of maintenance must not write anything, including automatic maintenance after the command.
+	if *maintenanceRunDryRun {
+		return nil
+	}
+
 	err := snapshotmaintenance.Run(ctx, rep, maintenance.ModeAuto, false)
 	if err == nil {
 		return nil
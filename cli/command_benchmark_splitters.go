@@ -2,11 +2,18 @@
 
 import (
 	"context"
+	"io"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"sort"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/repo/splitter"
 )
@@ -16,8 +23,87 @@
 	benchmarkSplitterRandSeed   = benchmarkSplitterCommand.Flag("rand-seed", "Random seed").Default("42").Int64()
 	benchmarkSplitterBlockSize  = benchmarkSplitterCommand.Flag("data-size", "Size of a data to split").Default("32MB").Bytes()
 	benchmarkSplitterBlockCount = benchmarkSplitterCommand.Flag("block-count", "Number of data blocks to split").Default("16").Int()
+	benchmarkSplitterSource     = benchmarkSplitterCommand.Flag("source", "Split sample data read from files in the provided directory instead of random data").String()
+	benchmarkSplitterSampleSize = benchmarkSplitterCommand.Flag("sample-size", "Maximum amount of sample data read from the source").Default("128MB").Bytes()
+	benchmarkSplitterAutoApply  = benchmarkSplitterCommand.Flag("auto-apply", "Use the best splitter for new objects in the connected repository").Bool()
 )
 
+// readSplitterSamples reads up to maxBytes of data from files in the provided directory, one sample per file.
+func readSplitterSamples(ctx context.Context, dir string, maxBytes int64) ([][]byte, error) {
+	var (
+		samples [][]byte
+		total   int64
+	)
+
+	errSampleComplete := errors.New("sample complete")
+
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			log(ctx).Debugf("unable to read %v: %v", path, err)
+			return nil
+		}
+
+		if !fi.Mode().IsRegular() || fi.Size() == 0 {
+			return nil
+		}
+
+		f, err := os.Open(path) //nolint:gosec
+		if err != nil {
+			log(ctx).Debugf("unable to open %v: %v", path, err)
+			return nil
+		}
+
+		defer f.Close() //nolint:errcheck
+
+		b, err := ioutil.ReadAll(io.LimitReader(f, maxBytes-total))
+		if err != nil {
+			log(ctx).Debugf("unable to read %v: %v", path, err)
+			return nil
+		}
+
+		samples = append(samples, b)
+		total += int64(len(b))
+
+		if total >= maxBytes {
+			return errSampleComplete
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, errSampleComplete) {
+		return nil, errors.Wrap(err, "unable to read sample data")
+	}
+
+	if len(samples) == 0 {
+		return nil, errors.Errorf("no sample data found in %v", dir)
+	}
+
+	log(ctx).Infof("read %v of sample data from %v files in %v", units.BytesStringBase10(total), len(samples), dir)
+
+	return samples, nil
+}
+
+// selectSplitter benchmarks splitters on the provided samples, prints the results and returns the best splitter.
+func selectSplitter(samples [][]byte, seed int64) string {
+	results := splitter.Benchmark(splitter.BenchmarkAlgorithms(), samples, seed)
+
+	for ndx, r := range results {
+		printStdout("%3v. %-25v %6v ms segments:%v unique:%v estimated cost:%v\n",
+			ndx,
+			r.Splitter,
+			r.Duration.Nanoseconds()/1e6,
+			r.Segments,
+			units.BytesStringBase10(r.UniqueBytes),
+			units.BytesStringBase10(r.EstimatedCost()))
+	}
+
+	best := splitter.SelectBest(results)
+
+	printStdout("Best splitter: %v\n", best)
+
+	return best
+}
+
 func runBenchmarkSplitterAction(ctx context.Context, rep repo.Repository) error {
 	type benchResult struct {
 		splitter     string
@@ -34,21 +120,40 @@ type benchResult struct {
 
 	var results []benchResult
 
+	var dr *repo.DirectRepository
+
+	if *benchmarkSplitterAutoApply {
+		var ok bool
+
+		if dr, ok = rep.(*repo.DirectRepository); !ok {
+			return errors.Errorf("--auto-apply requires a directly connected repository")
+		}
+	}
+
 	// generate data blocks
 	var dataBlocks [][]byte
 
-	rnd := rand.New(rand.NewSource(*benchmarkSplitterRandSeed)) //nolint:gosec
-
-	for i := 0; i < *benchmarkSplitterBlockCount; i++ {
-		b := make([]byte, *benchmarkSplitterBlockSize)
-		if _, err := rnd.Read(b); err != nil {
+	if *benchmarkSplitterSource != "" {
+		samples, err := readSplitterSamples(ctx, *benchmarkSplitterSource, int64(*benchmarkSplitterSampleSize))
+		if err != nil {
 			return err
 		}
 
-		dataBlocks = append(dataBlocks, b)
-	}
+		dataBlocks = samples
+	} else {
+		rnd := rand.New(rand.NewSource(*benchmarkSplitterRandSeed)) //nolint:gosec
 
-	log(ctx).Infof("splitting %v blocks of %v each", *benchmarkSplitterBlockCount, *benchmarkSplitterBlockSize)
+		for i := 0; i < *benchmarkSplitterBlockCount; i++ {
+			b := make([]byte, *benchmarkSplitterBlockSize)
+			if _, err := rnd.Read(b); err != nil {
+				return err
+			}
+
+			dataBlocks = append(dataBlocks, b)
+		}
+
+		log(ctx).Infof("splitting %v blocks of %v each", *benchmarkSplitterBlockCount, *benchmarkSplitterBlockSize)
+	}
 
 	for _, sp := range splitter.SupportedAlgorithms() {
 		fact := splitter.GetFactory(sp)
@@ -113,6 +218,20 @@ type benchResult struct {
 			r.min, r.p10, r.p25, r.p50, r.p75, r.p90, r.max)
 	}
 
+	printStdout("-----------------------------------------------------------------\n")
+
+	best := selectSplitter(dataBlocks, *benchmarkSplitterRandSeed)
+
+	if dr == nil {
+		return nil
+	}
+
+	if err := dr.SetSplitter(ctx, best); err != nil {
+		return errors.Wrap(err, "unable to set splitter")
+	}
+
+	log(ctx).Infof("Splitter %v will be used for new objects.", best)
+
 	return nil
 }
 
@@ -14,6 +14,7 @@
 	blobGarbageCollectParallel      = blobGarbageCollectCommand.Flag("parallel", "Number of parallel blob scans").Default("16").Int()
 	blobGarbageCollectMinAge        = blobGarbageCollectCommand.Flag("min-age", "Garbage-collect blobs with minimum age").Default("24h").Duration()
 	blobGarbageCollectPrefix        = blobGarbageCollectCommand.Flag("prefix", "Only GC blobs with given prefix").String()
+	blobGarbageCollectQuarantine    = blobGarbageCollectCommand.Flag("quarantine", "Move unused blobs to quarantine for the specified period instead of deleting them").Duration()
 )
 
 func runBlobGarbageCollectCommand(ctx context.Context, rep *repo.DirectRepository) error {
@@ -24,6 +25,8 @@ func runBlobGarbageCollectCommand(ctx context.Context, rep *repo.DirectRepositor
 		MinAge:   *blobGarbageCollectMinAge,
 		Parallel: *blobGarbageCollectParallel,
 		Prefix:   blob.ID(*blobGarbageCollectPrefix),
+
+		QuarantinePeriod: *blobGarbageCollectQuarantine,
 	}
 
 	n, err := maintenance.DeleteUnreferencedBlobs(ctx, rep, opts)
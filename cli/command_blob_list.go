@@ -25,7 +25,12 @@ func runBlobList(ctx context.Context, rep *repo.DirectRepository) error {
 			return nil
 		}
 
-		fmt.Printf("%-70v %10v %v\n", b.BlobID, b.Length, formatTimestamp(b.Timestamp))
+		var cold string
+		if b.Cold {
+			cold = " (cold)"
+		}
+
+		fmt.Printf("%-70v %10v %v%v\n", b.BlobID, b.Length, formatTimestamp(b.Timestamp), cold)
 		return nil
 	})
 }
@@ -3,20 +3,36 @@
 import (
 	"context"
 	"fmt"
+	"math/rand"
 	"strconv"
+	"time"
 
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
 	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/repo/blob"
+	blobmetrics "github.com/kopia/kopia/repo/blob/metrics"
 )
 
 var (
 	blobStatsCommand = blobCommands.Command("stats", "Content statistics")
 	blobStatsRaw     = blobStatsCommand.Flag("raw", "Raw numbers").Short('r').Bool()
 	blobStatsPrefix  = blobStatsCommand.Flag("prefix", "Blob name prefix").String()
+
+	blobStatsLive         = blobStatsCommand.Flag("live", "Periodically probe the storage and show latency and throughput of storage operations").Bool()
+	blobStatsLiveInterval = blobStatsCommand.Flag("live-interval", "Interval between probes of the storage").Default("5s").Duration()
+	blobStatsLiveDuration = blobStatsCommand.Flag("live-duration", "Stop probing after the provided duration (0=until interrupted)").Default("0").Duration()
+	blobStatsLiveSamples  = blobStatsCommand.Flag("live-samples", "Number of blobs read during each probe").Default("3").Int()
+	blobStatsLiveReadMB   = blobStatsCommand.Flag("live-read-mb", "Maximum amount of data read from each sampled blob").PlaceHolder("MB").Default("4").Int64()
 )
 
 func runBlobStatsCommand(ctx context.Context, rep *repo.DirectRepository) error {
+	if *blobStatsLive {
+		return runBlobStatsLive(ctx, rep)
+	}
+
 	var sizeThreshold int64 = 10
 
 	countMap := map[int64]int{}
@@ -84,6 +100,90 @@ func(b blob.Metadata) error {
 	return nil
 }
 
+// runBlobStatsLive periodically lists and reads sample blobs and prints latency percentiles and throughput of
+// all storage methods invoked by this process, including those issued while opening the repository.
+func runBlobStatsLive(ctx context.Context, rep *repo.DirectRepository) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	onCtrlC(cancel)
+
+	var deadline time.Time
+	if d := *blobStatsLiveDuration; d > 0 {
+		deadline = clock.Now().Add(d)
+	}
+
+	for {
+		if err := probeBlobStorage(ctx, rep); err != nil && ctx.Err() == nil {
+			log(ctx).Warningf("storage probe failed: %v", err)
+		}
+
+		printBlobStorageMethodStats()
+
+		if !deadline.IsZero() && clock.Now().After(deadline) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*blobStatsLiveInterval):
+		}
+	}
+}
+
+// probeBlobStorage lists blobs with the selected prefix and reads the beginning of a few of them.
+func probeBlobStorage(ctx context.Context, rep *repo.DirectRepository) error {
+	var samples []blob.Metadata
+
+	if err := rep.Blobs.ListBlobs(ctx, blob.ID(*blobStatsPrefix), func(b blob.Metadata) error {
+		if len(samples) < *blobStatsLiveSamples {
+			samples = append(samples, b)
+		} else if i := rand.Intn(len(samples) + 1); i < len(samples) { //nolint:gosec
+			samples[i] = b
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "unable to list blobs")
+	}
+
+	maxRead := *blobStatsLiveReadMB << 20 //nolint:gomnd
+
+	for _, b := range samples {
+		length := b.Length
+		if length > maxRead {
+			length = maxRead
+		}
+
+		if _, err := rep.Blobs.GetBlob(ctx, b.BlobID, 0, length); err != nil {
+			return errors.Wrapf(err, "unable to read blob %v", b.BlobID)
+		}
+	}
+
+	return nil
+}
+
+func printBlobStorageMethodStats() {
+	fmt.Printf("\n%-12v %-12v %8v %6v %10v %10v %10v %12v\n", "STORAGE", "METHOD", "COUNT", "ERRORS", "P50", "P90", "P99", "THROUGHPUT")
+
+	for _, s := range blobmetrics.Stats() {
+		if s.Count == 0 {
+			continue
+		}
+
+		throughput := ""
+		if s.TotalBytes > 0 {
+			throughput = units.BytesStringBase10(int64(s.BytesPerSecond())) + "/s"
+		}
+
+		fmt.Printf("%-12v %-12v %8v %6v %10v %10v %10v %12v\n",
+			s.Storage, s.Method, s.Count, s.Errors,
+			s.P50.Round(time.Microsecond), s.P90.Round(time.Microsecond), s.P99.Round(time.Microsecond),
+			throughput)
+	}
+}
+
 func init() {
 	blobStatsCommand.Action(directRepositoryAction(runBlobStatsCommand))
 }
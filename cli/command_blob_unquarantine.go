@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/maintenance"
+)
+
+var (
+	blobUnquarantineCommand = blobCommands.Command("unquarantine", "Restore blobs quarantined by garbage collection")
+	blobUnquarantineBlobIDs = blobUnquarantineCommand.Arg("blobIDs", "Original IDs of quarantined blobs").Strings()
+	blobUnquarantineAll     = blobUnquarantineCommand.Flag("all", "Restore all quarantined blobs").Bool()
+)
+
+func runBlobUnquarantineCommand(ctx context.Context, rep *repo.DirectRepository) error {
+	advancedCommand(ctx)
+
+	var ids []blob.ID
+
+	for _, b := range *blobUnquarantineBlobIDs {
+		ids = append(ids, blob.ID(b))
+	}
+
+	if len(ids) == 0 && !*blobUnquarantineAll {
+		return errors.Errorf("must specify blob IDs or --all")
+	}
+
+	n, err := maintenance.UnquarantineBlobs(ctx, rep, ids)
+
+	log(ctx).Infof("Restored %v blobs from quarantine.", n)
+
+	return errors.Wrap(err, "error restoring quarantined blobs")
+}
+
+func init() {
+	blobUnquarantineCommand.Action(directRepositoryAction(runBlobUnquarantineCommand))
+}
@@ -6,6 +6,7 @@
 
 	"github.com/pkg/errors"
 
+	"github.com/kopia/kopia/internal/blockcache"
 	"github.com/kopia/kopia/internal/retry"
 	"github.com/kopia/kopia/repo"
 )
@@ -32,6 +33,14 @@ func runCacheClearCommand(ctx context.Context, rep *repo.DirectRepository) error
 			return err
 		}
 
+		if f := rep.Content.CachingOptions.BlockCacheFile; f != "" {
+			log(ctx).Infof("Clearing block cache: %v.", f)
+
+			if err := blockcache.Reset(ctx, f); err != nil && !os.IsNotExist(errors.Cause(err)) {
+				return errors.Wrap(err, "unable to clear block cache")
+			}
+		}
+
 		log(ctx).Infof("Cache cleared.")
 
 		return nil
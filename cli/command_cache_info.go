@@ -10,6 +10,7 @@
 
 	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/content"
 )
 
 var (
@@ -48,11 +49,26 @@ func runCacheInfoCommand(ctx context.Context, rep *repo.DirectRepository) error
 		maybeLimit := ""
 		if l, ok := path2Limit[ent.Name()]; ok {
 			maybeLimit = fmt.Sprintf(" (limit %v)", units.BytesStringBase10(l))
+
+			if rep.Content.CachingOptions.AutoSize {
+				maybeLimit = fmt.Sprintf(" (auto-sized, minimum %v)", units.BytesStringBase10(l))
+			}
 		}
 
 		fmt.Printf("%v: %v files %v%v\n", subdir, fileCount, units.BytesStringBase10(totalFileSize), maybeLimit)
 	}
 
+	if f := rep.Content.CachingOptions.BlockCacheFile; f != "" {
+		fmt.Printf("%v: block cache for contents (limit %v)\n", f, units.BytesStringBase10(rep.Content.CachingOptions.MaxCacheSizeBytes))
+	}
+
+	corrupt, err := content.CorruptCacheEntriesTotal(rep.Content.CachingOptions.CacheDirectory)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Corrupted entries found and fetched again: %v\n", corrupt)
+
 	return nil
 }
 
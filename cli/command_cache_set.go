@@ -2,6 +2,7 @@
 
 import (
 	"context"
+	"path/filepath"
 
 	"github.com/pkg/errors"
 
@@ -16,10 +17,21 @@
 	cacheSetContentCacheSizeMB     = cacheSetParamsCommand.Flag("content-cache-size-mb", "Size of local content cache").PlaceHolder("MB").Default("-1").Int64()
 	cacheSetMaxMetadataCacheSizeMB = cacheSetParamsCommand.Flag("metadata-cache-size-mb", "Size of local metadata cache").PlaceHolder("MB").Default("-1").Int64()
 	cacheSetMaxListCacheDuration   = cacheSetParamsCommand.Flag("max-list-cache-duration", "Duration of index cache").Default("-1ns").Duration()
+	cacheSetMergeIndexes           = cacheSetParamsCommand.Flag("merge-indexes", "Merge cached indexes into a single file to speed up lookups ('true', 'false')").Enum("true", "false")
+	cacheSetLazyIndexLoading       = cacheSetParamsCommand.Flag("lazy-index-loading", "Download indexes on demand instead of when opening the repository ('true', 'false')").Enum("true", "false")
+	cacheSetSharedIndexCache       = cacheSetParamsCommand.Flag("shared-index-cache", "Share cached indexes with other kopia processes of the current user connected to the same repository ('true', 'false')").Enum("true", "false")
+	cacheSetIndexMmap              = cacheSetParamsCommand.Flag("index-mmap", "Access cached indexes using memory-mapped files ('true', 'false')").Enum("true", "false")
+	cacheSetReadAheadMB            = cacheSetParamsCommand.Flag("read-ahead-mb", "Amount of data read ahead in the background when contents of a pack are read sequentially (0=disabled)").PlaceHolder("MB").Default("-1").Int64()
+	cacheSetAutoSize               = cacheSetParamsCommand.Flag("auto", "Periodically adjust cache sizes based on repository size, working set and available disk space, using configured sizes as minimums ('true', 'false')").Enum("true", "false")
+	cacheSetPerHostDirectory       = cacheSetParamsCommand.Flag("per-host-cache-directory", "Use a separate subdirectory of the cache directory for each host, when it's on a network filesystem shared by multiple hosts ('true', 'false')").Enum("true", "false")
+	cacheSetBlockCacheFile         = cacheSetParamsCommand.Flag("block-cache-file", "Store the content cache in a preallocated file or raw block device accessed using direct IO ('none' to use the cache directory)").PlaceHolder("PATH").String()
 )
 
 func runCacheSetCommand(ctx context.Context, rep *repo.DirectRepository) error {
-	opts := rep.Content.CachingOptions.CloneOrDefault()
+	opts, err := rep.CachingConfig()
+	if err != nil {
+		return errors.Wrap(err, "unable to read caching configuration")
+	}
 
 	changed := 0
 
@@ -49,6 +61,76 @@ func runCacheSetCommand(ctx context.Context, rep *repo.DirectRepository) error {
 		changed++
 	}
 
+	if v := *cacheSetMergeIndexes; v != "" {
+		log(ctx).Infof("changing merging of cached indexes to %v", v)
+		opts.MergeCommittedIndexes = v == "true"
+		changed++
+	}
+
+	if v := *cacheSetLazyIndexLoading; v != "" {
+		log(ctx).Infof("changing lazy loading of indexes to %v", v)
+		opts.LazyIndexLoading = v == "true"
+		changed++
+	}
+
+	if v := *cacheSetSharedIndexCache; v != "" {
+		log(ctx).Infof("changing sharing of cached indexes to %v", v)
+
+		opts.SharedIndexCacheDirectory = ""
+
+		if v == "true" {
+			dir, err := repo.DefaultSharedIndexCacheDirectory()
+			if err != nil {
+				return err
+			}
+
+			opts.SharedIndexCacheDirectory = dir
+		}
+
+		changed++
+	}
+
+	if v := *cacheSetIndexMmap; v != "" {
+		log(ctx).Infof("changing memory-mapping of cached indexes to %v", v)
+		opts.DisableIndexMmap = v == "false"
+		changed++
+	}
+
+	if v := *cacheSetReadAheadMB; v != -1 {
+		v *= 1e6 // convert MB to bytes
+		log(ctx).Infof("changing read-ahead to %v", units.BytesStringBase10(v))
+		opts.ReadAheadBytes = v
+		changed++
+	}
+
+	if v := *cacheSetAutoSize; v != "" {
+		log(ctx).Infof("changing automatic sizing of caches to %v", v)
+		opts.AutoSize = v == "true"
+		changed++
+	}
+
+	if v := *cacheSetPerHostDirectory; v != "" {
+		log(ctx).Infof("changing per-host cache directory to %v", v)
+		opts.PerHostCacheDirectory = v == "true"
+		changed++
+	}
+
+	if v := *cacheSetBlockCacheFile; v != "" {
+		opts.BlockCacheFile = ""
+
+		if v != "none" {
+			f, err := filepath.Abs(v)
+			if err != nil {
+				return errors.Wrap(err, "unable to determine block cache file")
+			}
+
+			opts.BlockCacheFile = f
+		}
+
+		log(ctx).Infof("changing block cache file to %q", opts.BlockCacheFile)
+		changed++
+	}
+
 	if changed == 0 {
 		return errors.Errorf("no changes")
 	}
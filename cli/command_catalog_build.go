@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/snapshotcatalog"
+)
+
+var (
+	catalogCommands = app.Command("catalog", "Commands to manage searchable catalogs of snapshot contents.")
+
+	catalogBuildCommand  = catalogCommands.Command("build", "Build catalogs of snapshots that don't have one yet.")
+	catalogBuildSource   = catalogBuildCommand.Arg("source", "Only build catalogs of snapshots of the provided source.").String()
+	catalogBuildMetadata = catalogBuildCommand.Flag("metadata", "Extract metadata of files with the provided extensions (e.g. 'jpg,pdf' or 'all') into the catalogs.").Strings()
+)
+
+func catalogBuildOptions(metadataExtensions []string) (snapshotcatalog.BuildOptions, error) {
+	exts, err := snapshotcatalog.ParseMetadataExtensions(metadataExtensions)
+	if err != nil {
+		return snapshotcatalog.BuildOptions{}, errors.Wrap(err, "invalid metadata extensions")
+	}
+
+	return snapshotcatalog.BuildOptions{MetadataExtensions: exts}, nil
+}
+
+func runCatalogBuildCommand(ctx context.Context, rep repo.Repository) error {
+	opt, err := catalogBuildOptions(*catalogBuildMetadata)
+	if err != nil {
+		return err
+	}
+
+	manifestIDs, _, err := findManifestIDs(ctx, rep, *catalogBuildSource)
+	if err != nil {
+		return err
+	}
+
+	manifests, err := snapshot.LoadSnapshots(ctx, rep, manifestIDs)
+	if err != nil {
+		return err
+	}
+
+	built, err := snapshotcatalog.BuildMissing(ctx, rep, manifests, opt)
+	if err != nil {
+		return err
+	}
+
+	log(ctx).Infof("Built %v catalogs.", built)
+
+	return nil
+}
+
+func init() {
+	catalogBuildCommand.Action(repositoryAction(runCatalogBuildCommand))
+}
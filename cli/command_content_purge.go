@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/maintenance"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+var (
+	contentPurgeCommand = contentCommands.Command("purge", "Permanently remove contents from the repository, rewriting all snapshots that reference them.")
+	contentPurgeIDs     = contentPurgeCommand.Arg("id", "IDs of contents to purge").Required().Strings()
+	contentPurgeReason  = contentPurgeCommand.Flag("reason", "Reason for the purge, recorded in the audit trail").String()
+	contentPurgeConfirm = contentPurgeCommand.Flag("delete", "Confirm purge").Bool()
+)
+
+type snapshotPurgeReferences struct {
+	manifest *snapshot.Manifest
+	paths    []string
+}
+
+func runContentPurgeCommand(ctx context.Context, rep *repo.DirectRepository) error {
+	contentIDs := toContentIDs(*contentPurgeIDs)
+
+	for _, cid := range contentIDs {
+		if _, err := rep.Content.ContentInfo(ctx, cid); err != nil {
+			return errors.Wrapf(err, "unable to get info for content %v", cid)
+		}
+	}
+
+	refs, err := findContentPurgeReferences(ctx, rep, contentIDs)
+	if err != nil {
+		return err
+	}
+
+	if !*contentPurgeConfirm {
+		for _, r := range refs {
+			log(ctx).Infof("Would remove %v from %v", strings.Join(r.paths, ", "), describeSnapshot(r.manifest))
+		}
+
+		log(ctx).Infof("Would purge %v contents referenced by %v snapshots (pass --delete to confirm)", len(contentIDs), len(refs))
+
+		return nil
+	}
+
+	rec := &maintenance.PurgeRecord{
+		ContentIDs: contentIDs,
+		Reason:     *contentPurgeReason,
+	}
+
+	for _, r := range refs {
+		res, err := snapshotfs.Redact(ctx, rep, r.manifest, r.paths)
+		if err != nil {
+			return errors.Wrapf(err, "error redacting snapshot %v", r.manifest.ID)
+		}
+
+		log(ctx).Infof("Removing %v from %v", strings.Join(res.RemovedPaths, ", "), describeSnapshot(r.manifest))
+
+		if err := replaceWithRedactedSnapshot(ctx, rep, r.manifest, res.Manifest); err != nil {
+			return errors.Wrapf(err, "error replacing snapshot %v", r.manifest.ID)
+		}
+
+		for _, p := range res.RemovedPaths {
+			rec.References = append(rec.References, r.manifest.Source.String()+"@"+formatTimestamp(r.manifest.StartTime)+":"+p)
+		}
+	}
+
+	if err := maintenance.SchedulePurge(ctx, rep, rec); err != nil {
+		return errors.Wrap(err, "unable to schedule purge")
+	}
+
+	log(ctx).Infof("Purged %v contents from %v snapshots. The data will be physically removed from the repository by full maintenance.", len(contentIDs), len(refs))
+
+	return nil
+}
+
+// findContentPurgeReferences returns snapshots and paths within them that reference any of the provided contents.
+func findContentPurgeReferences(ctx context.Context, rep repo.Repository, contentIDs []content.ID) ([]snapshotPurgeReferences, error) {
+	ids, err := snapshot.ListSnapshotManifests(ctx, rep, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list snapshot manifests")
+	}
+
+	manifests, err := snapshot.LoadSnapshots(ctx, rep, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load snapshot manifests")
+	}
+
+	var result []snapshotPurgeReferences
+
+	finder := snapshotfs.NewContentReferenceFinder(rep, contentIDs)
+
+	for _, m := range manifests {
+		paths, err := finder.FindReferences(ctx, m)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error searching snapshot %v", m.ID)
+		}
+
+		if len(paths) == 0 {
+			continue
+		}
+
+		if err := policy.EnsureNotHeld(ctx, rep, m); err != nil {
+			return nil, err
+		}
+
+		if paths[0] == "/" {
+			return nil, errors.Errorf("contents are referenced by the root directory of %v, delete the snapshot instead", describeSnapshot(m))
+		}
+
+		result = append(result, snapshotPurgeReferences{m, paths})
+	}
+
+	return result, nil
+}
+
+func describeSnapshot(m *snapshot.Manifest) string {
+	return "snapshot " + string(m.ID) + " of " + m.Source.String() + " at " + formatTimestamp(m.StartTime)
+}
+
+func init() {
+	contentPurgeCommand.Action(directRepositoryAction(runContentPurgeCommand))
+}
@@ -70,7 +70,7 @@ func runContentVerifyCommand(ctx context.Context, rep *repo.DirectRepository) er
 		return nil
 	}
 
-	return errors.Errorf("encountered %v errors", errorCount)
+	return withCategory(ErrorCategoryCorruption, errors.Errorf("encountered %v errors", errorCount))
 }
 
 func contentVerify(ctx context.Context, r *repo.DirectRepository, ci *content.Info, blobMap map[blob.ID]blob.Metadata) error {
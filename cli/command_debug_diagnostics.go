@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/kopia/kopia/internal/controlsocket"
+	"github.com/kopia/kopia/internal/diagnostics"
+)
+
+var (
+	debugDiagnosticsCommand      = debugCommands.Command("diagnostics", "Toggle runtime diagnostics of a command started with --diagnostics-control-socket")
+	debugDiagnosticsSocketPath   = debugDiagnosticsCommand.Arg("control-socket", "Path of the control socket").Required().String()
+	debugDiagnosticsPprof        = debugDiagnosticsCommand.Flag("pprof-listen-addr", "Expose net/http/pprof endpoints on a given host:port ('off' to disable)").String()
+	debugDiagnosticsDumpInterval = debugDiagnosticsCommand.Flag("dump-interval", "Periodically dump heap and goroutine profiles (0 to disable)").IsSetByUser(&debugDiagnosticsDumpIntervalSet).Duration()
+	debugDiagnosticsDumpNow      = debugDiagnosticsCommand.Flag("dump-now", "Dump heap and goroutine profiles immediately").Bool()
+
+	debugDiagnosticsDumpIntervalSet bool
+)
+
+func init() {
+	debugDiagnosticsCommand.Action(noRepositoryAction(runDebugDiagnostics))
+}
+
+func runDebugDiagnostics(ctx context.Context) error {
+	var pprofArgs, intervalArgs []string
+
+	// without flags only the current settings are printed.
+	if *debugDiagnosticsPprof != "" {
+		pprofArgs = append(pprofArgs, *debugDiagnosticsPprof)
+	}
+
+	if debugDiagnosticsDumpIntervalSet {
+		intervalArgs = append(intervalArgs, debugDiagnosticsDumpInterval.String())
+	}
+
+	addr, err := controlsocket.Send(ctx, *debugDiagnosticsSocketPath, diagnostics.ControlCmdPprof, pprofArgs...)
+	if err != nil {
+		return err
+	}
+
+	interval, err := controlsocket.Send(ctx, *debugDiagnosticsSocketPath, diagnostics.ControlCmdDumpInterval, intervalArgs...)
+	if err != nil {
+		return err
+	}
+
+	if addr == diagnostics.PprofOff {
+		printStdout("Pprof endpoints: disabled\n")
+	} else {
+		printStdout("Pprof endpoints: http://%v/debug/pprof/\n", addr)
+	}
+
+	if interval == "0s" {
+		printStdout("Profile dumps:   disabled\n")
+	} else {
+		printStdout("Profile dumps:   every %v\n", interval)
+	}
+
+	if *debugDiagnosticsDumpNow {
+		files, err := controlsocket.Send(ctx, *debugDiagnosticsSocketPath, diagnostics.ControlCmdDump)
+		if err != nil {
+			return err
+		}
+
+		printStdout("Dumped profiles: %v\n", files)
+	}
+
+	return nil
+}
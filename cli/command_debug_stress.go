@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/stress"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/namespace"
+)
+
+var (
+	debugStressCommand   = debugCommands.Command("stress", "Qualify storage by running randomized snapshot, verification and maintenance cycles with injected faults against a scratch repository.")
+	debugStressDuration  = debugStressCommand.Flag("duration", "Duration of the stress test").Default("5m").Duration()
+	debugStressParallel  = debugStressCommand.Flag("parallel", "Number of concurrent workers").Default("4").Int()
+	debugStressFaultRate = debugStressCommand.Flag("fault-rate", "Probability of injecting a fault into each storage operation").Default("0.01").Float64()
+	debugStressSeed      = debugStressCommand.Flag("seed", "Seed of the random number generator (defaults to current time)").Int64()
+	debugStressKeepData  = debugStressCommand.Flag("keep-data", "Do not delete the scratch repository after the test").Bool()
+)
+
+const debugStressNamespaceRandomBytes = 4
+
+func runDebugStressWithStorage(ctx context.Context, st blob.Storage) error {
+	rnd := make([]byte, debugStressNamespaceRandomBytes)
+	if _, err := rand.Read(rnd); err != nil {
+		return errors.Wrap(err, "unable to generate namespace")
+	}
+
+	// scratch repository is created in a unique namespace, so that the storage does not need to be empty.
+	ns, err := namespace.NewWrapper(st, "stress-"+hex.EncodeToString(rnd))
+	if err != nil {
+		return err
+	}
+
+	seed := *debugStressSeed
+	if seed == 0 {
+		seed = clock.Now().UnixNano()
+	}
+
+	log(ctx).Infof("Running stress test in %v for %v with seed %v.", ns.DisplayName(), *debugStressDuration, seed)
+
+	stats, err := stress.Run(ctx, ns, stress.Options{
+		Duration:  *debugStressDuration,
+		Parallel:  *debugStressParallel,
+		FaultRate: *debugStressFaultRate,
+		Seed:      seed,
+	})
+
+	if stats != nil {
+		printStdout("Snapshots:        %v (%v failed)\n", stats.Snapshots, stats.SnapshotFailures)
+		printStdout("Verifications:    %v (%v failed)\n", stats.Verifications, stats.VerifyFailures)
+		printStdout("Restores:         %v (%v failed)\n", stats.Restores, stats.RestoreFailures)
+		printStdout("Maintenance:      %v (%v failed)\n", stats.Maintenance, stats.MaintenanceFailure)
+		printStdout("Injected faults:  %v\n", stats.InjectedFaults)
+		printStdout("Integrity errors: %v\n", stats.IntegrityErrors)
+	}
+
+	if *debugStressKeepData {
+		log(ctx).Infof("Scratch repository was kept in %v.", ns.DisplayName())
+		return err
+	}
+
+	if cerr := deleteAllBlobs(ctx, ns); cerr != nil {
+		log(ctx).Warningf("unable to delete scratch repository: %v", cerr)
+	}
+
+	return err
+}
+
+func deleteAllBlobs(ctx context.Context, st blob.Storage) error {
+	blobs, err := blob.ListAllBlobs(ctx, st, "")
+	if err != nil {
+		return errors.Wrap(err, "unable to list blobs")
+	}
+
+	for _, bm := range blobs {
+		if err := st.DeleteBlob(ctx, bm.BlobID); err != nil {
+			return errors.Wrapf(err, "unable to delete %v", bm.BlobID)
+		}
+	}
+
+	return nil
+}
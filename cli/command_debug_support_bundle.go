@@ -0,0 +1,292 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/ospath"
+	"github.com/kopia/kopia/internal/scrubber"
+	"github.com/kopia/kopia/internal/supportbundle"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/maintenance"
+)
+
+var (
+	debugCommands = app.Command("debug", "Commands for diagnosing problems.")
+
+	debugSupportBundleCommand     = debugCommands.Command("support-bundle", "Create an archive with diagnostic information to attach to bug reports, with secrets removed.")
+	debugSupportBundleOutput      = debugSupportBundleCommand.Flag("output", "Output file").Short('o').String()
+	debugSupportBundleMaxLogFiles = debugSupportBundleCommand.Flag("max-log-files", "Maximum number of most recent log files to include").Default("10").Int()
+	debugSupportBundleMaxErrors   = debugSupportBundleCommand.Flag("max-errors", "Maximum number of most recent errors to include").Default("200").Int()
+)
+
+// supportBundleLogsSubdirs are subdirectories of the log directory with logs of CLI commands and operations.
+var supportBundleLogsSubdirs = []string{"cli-logs", "snapshot-logs", "restore-logs", "maintenance-logs"}
+
+func init() {
+	debugSupportBundleCommand.Action(optionalRepositoryAction(runDebugSupportBundle))
+}
+
+func runDebugSupportBundle(ctx context.Context, rep repo.Repository) error {
+	now := clock.Now()
+
+	fname := *debugSupportBundleOutput
+	if fname == "" {
+		fname = "kopia-support-" + now.Format("20060102-150405") + ".zip"
+	}
+
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600) //nolint:gosec
+	if err != nil {
+		return errors.Wrap(err, "unable to create support bundle")
+	}
+
+	b := supportbundle.New(f, now)
+	registerSupportBundleSecrets(ctx, b)
+
+	err = writeSupportBundle(ctx, b, rep)
+	if cerr := b.Close(); err == nil {
+		err = cerr
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = errors.Wrap(cerr, "unable to close support bundle")
+	}
+
+	if err != nil {
+		os.Remove(fname) //nolint:errcheck
+		return err
+	}
+
+	log(ctx).Infof("Wrote support bundle with %v files to %v", len(b.Names()), fname)
+	log(ctx).Infof("Secrets have been removed, but please review the contents before sharing it.")
+
+	return nil
+}
+
+// registerSupportBundleSecrets registers all known secrets, so that they are scrubbed wherever they appear.
+func registerSupportBundleSecrets(ctx context.Context, b *supportbundle.Bundle) {
+	b.AddSecret(*password)
+	b.AddSecret(*serverPassword)
+
+	if pass, ok := repo.GetPersistedPassword(ctx, repositoryConfigFileName()); ok {
+		b.AddSecret(pass)
+	}
+
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2) //nolint:gomnd
+		if len(parts) == 2 && supportbundle.IsSensitiveName(parts[0]) {
+			b.AddSecret(parts[1])
+		}
+	}
+}
+
+func writeSupportBundle(ctx context.Context, b *supportbundle.Bundle, rep repo.Repository) error {
+	info := map[string]interface{}{
+		"version":    repo.BuildVersion,
+		"buildInfo":  repo.BuildInfo,
+		"createTime": clock.Now(),
+		"configFile": repositoryConfigFileName(),
+		"connected":  rep != nil,
+	}
+
+	if _, err := os.Stat(repositoryConfigFileName()); err == nil && rep == nil {
+		info["repositoryError"] = "unable to open repository, see logs for details"
+	}
+
+	if err := b.AddJSON("bundle.json", info); err != nil {
+		return err
+	}
+
+	if err := b.AddJSON("environment.json", supportBundleEnvironment()); err != nil {
+		return err
+	}
+
+	if cfg, err := ioutil.ReadFile(repositoryConfigFileName()); err == nil {
+		if err := b.AddJSONFile("config/repository.config", cfg); err != nil {
+			return err
+		}
+	}
+
+	if dr, ok := rep.(*repo.DirectRepository); ok {
+		if err := writeSupportBundleRepository(ctx, b, dr); err != nil {
+			return err
+		}
+	}
+
+	return writeSupportBundleLogs(ctx, b)
+}
+
+func supportBundleEnvironment() map[string]interface{} {
+	hostname, _ := os.Hostname()
+
+	env := map[string]string{}
+
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2) //nolint:gomnd
+		if len(parts) == 2 && strings.HasPrefix(parts[0], "KOPIA_") {
+			env[parts[0]] = parts[1]
+		}
+	}
+
+	return map[string]interface{}{
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+		"goVersion":  runtime.Version(),
+		"numCPU":     runtime.NumCPU(),
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+		"hostname":   hostname,
+		"env":        env,
+	}
+}
+
+func writeSupportBundleRepository(ctx context.Context, b *supportbundle.Bundle, rep *repo.DirectRepository) error {
+	ci := rep.Blobs.ConnectionInfo()
+
+	params := map[string]interface{}{
+		"clientOptions":      rep.ClientOptions(),
+		"storageType":        ci.Type,
+		"storageConfig":      scrubber.ScrubSensitiveData(reflect.ValueOf(ci.Config)).Interface(),
+		"hash":               rep.Content.Format.Hash,
+		"encryption":         rep.Content.Format.Encryption,
+		"splitter":           rep.Objects.Format.Splitter,
+		"formatVersion":      rep.Content.Format.Version,
+		"maxPackSize":        rep.Content.Format.MaxPackSize,
+		"manifestCompressor": rep.Manifests.Compressor(),
+		"cachingOptions":     rep.Content.CachingOptions,
+	}
+
+	if p, err := maintenance.GetParams(ctx, rep); err == nil {
+		params["maintenance"] = p
+	} else {
+		params["maintenanceError"] = err.Error()
+	}
+
+	if s, err := maintenance.GetSchedule(ctx, rep); err == nil {
+		params["maintenanceSchedule"] = s
+	} else {
+		params["maintenanceScheduleError"] = err.Error()
+	}
+
+	if err := b.AddJSON("repository.json", params); err != nil {
+		return err
+	}
+
+	return b.AddJSON("cache.json", supportBundleCacheStats(rep))
+}
+
+func supportBundleCacheStats(rep *repo.DirectRepository) map[string]interface{} {
+	opt := rep.Content.CachingOptions
+
+	dirs := map[string]interface{}{}
+
+	entries, _ := ioutil.ReadDir(opt.CacheDirectory)
+	for _, ent := range entries {
+		if !ent.IsDir() {
+			continue
+		}
+
+		fileCount, totalFileSize, err := scanCacheDir(filepath.Join(opt.CacheDirectory, ent.Name()))
+		if err != nil {
+			dirs[ent.Name()] = map[string]interface{}{"error": err.Error()}
+			continue
+		}
+
+		dirs[ent.Name()] = map[string]interface{}{
+			"files": fileCount,
+			"bytes": totalFileSize,
+		}
+	}
+
+	return map[string]interface{}{
+		"directory":            opt.CacheDirectory,
+		"maxContentCacheBytes": opt.MaxCacheSizeBytes,
+		"maxMetadataBytes":     opt.MaxMetadataCacheSizeBytes,
+		"subdirectories":       dirs,
+	}
+}
+
+// logDirFromFlags returns the directory where log files are written, as configured using --log-dir.
+func logDirFromFlags() string {
+	if f := app.GetFlag("log-dir"); f != nil {
+		if v := f.Model().Value.String(); v != "" {
+			return v
+		}
+	}
+
+	return ospath.LogsDir()
+}
+
+func writeSupportBundleLogs(ctx context.Context, b *supportbundle.Bundle) error {
+	var logs []os.FileInfo
+
+	logDirs := map[os.FileInfo]string{}
+
+	for _, subdir := range supportBundleLogsSubdirs {
+		dir := filepath.Join(logDirFromFlags(), subdir)
+
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log(ctx).Warningf("unable to read log directory %v: %v", dir, err)
+			}
+
+			continue
+		}
+
+		for _, e := range entries {
+			if e.Mode().IsRegular() && strings.HasSuffix(e.Name(), ".log") {
+				logs = append(logs, e)
+				logDirs[e] = dir
+			}
+		}
+	}
+
+	// most recent first
+	sort.Slice(logs, func(i, j int) bool {
+		return logs[i].ModTime().After(logs[j].ModTime())
+	})
+
+	if len(logs) > *debugSupportBundleMaxLogFiles {
+		logs = logs[0:*debugSupportBundleMaxLogFiles]
+	}
+
+	var errorLines []string
+
+	// process in chronological order, so that errors are ordered the same way.
+	for i := len(logs) - 1; i >= 0; i-- {
+		data, err := ioutil.ReadFile(filepath.Join(logDirs[logs[i]], logs[i].Name()))
+		if err != nil {
+			log(ctx).Warningf("unable to read log file %v: %v", logs[i].Name(), err)
+			continue
+		}
+
+		if err := b.AddText("logs/"+logs[i].Name(), data); err != nil {
+			return err
+		}
+
+		lines, err := supportbundle.ErrorLines(bytes.NewReader(data), *debugSupportBundleMaxErrors)
+		if err != nil {
+			log(ctx).Warningf("unable to scan log file %v: %v", logs[i].Name(), err)
+		}
+
+		for _, l := range lines {
+			errorLines = append(errorLines, logs[i].Name()+": "+l)
+		}
+	}
+
+	if len(errorLines) > *debugSupportBundleMaxErrors {
+		errorLines = errorLines[len(errorLines)-*debugSupportBundleMaxErrors:]
+	}
+
+	return b.AddText("errors.log", []byte(strings.Join(errorLines, "\n")+"\n"))
+}
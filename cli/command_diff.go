@@ -2,6 +2,7 @@
 
 import (
 	"context"
+	"encoding/json"
 	"os"
 	"strings"
 
@@ -9,6 +10,7 @@
 
 	"github.com/kopia/kopia/fs"
 	"github.com/kopia/kopia/internal/diff"
+	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/snapshot/snapshotfs"
 )
@@ -19,6 +21,10 @@
 	diffSecondObjectPath = diffCommand.Arg("object-path2", "Second object/path").Required().String()
 	diffCompareFiles     = diffCommand.Flag("files", "Compare files by launching diff command for all pairs of (old,new)").Short('f').Bool()
 	diffCommandCommand   = diffCommand.Flag("diff-command", "Displays differences between two repository objects (files or directories)").Default(defaultDiffCommand()).Envar("KOPIA_DIFF").String()
+	diffSummary          = diffCommand.Flag("summary", "Show list of changed entries with summary of differences (implied by --content, --json and --stats-only)").Bool()
+	diffContents         = diffCommand.Flag("content", "Count bytes of added and modified files which are not shared with previous versions").Bool()
+	diffJSON             = diffCommand.Flag("json", "Output differences as JSON").Bool()
+	diffStatsOnly        = diffCommand.Flag("stats-only", "Only show summary of differences").Bool()
 )
 
 func runDiffCommand(ctx context.Context, rep repo.Repository) error {
@@ -39,6 +45,90 @@ func runDiffCommand(ctx context.Context, rep repo.Repository) error {
 		return errors.New("arguments do diff must both be directories or both non-directories")
 	}
 
+	if !*diffSummary && !*diffContents && !*diffJSON && !*diffStatsOnly {
+		return runComparer(ctx, ent1, ent2, isDir1)
+	}
+
+	opt := snapshotfs.DiffOptions{CompareContents: *diffContents}
+
+	if *diffJSON {
+		res, err := snapshotfs.Diff(ctx, rep, ent1, ent2, opt)
+		if err != nil {
+			return err
+		}
+
+		if *diffStatsOnly {
+			res.Entries = nil
+		}
+
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+
+		return errors.Wrap(e.Encode(res), "unable to encode differences")
+	}
+
+	stats, err := snapshotfs.DiffEntries(ctx, rep, ent1, ent2, opt, func(e *snapshotfs.DiffEntry) error {
+		if !*diffStatsOnly {
+			printDiffEntry(e)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	printDiffStats(stats, opt)
+
+	return nil
+}
+
+func printDiffEntry(e *snapshotfs.DiffEntry) {
+	if e.Directory {
+		printStdout("%v directory %v\n", e.Kind, e.Path)
+		return
+	}
+
+	var details []string
+
+	switch e.Kind {
+	case snapshotfs.DiffAdded:
+		details = append(details, units.BytesStringBase10(e.NewSize))
+	case snapshotfs.DiffRemoved:
+		details = append(details, units.BytesStringBase10(e.OldSize))
+	default:
+		if e.ContentChanged {
+			details = append(details, units.BytesStringBase10(e.OldSize)+" -> "+units.BytesStringBase10(e.NewSize))
+		}
+
+		if len(e.Attributes) > 0 {
+			details = append(details, strings.Join(e.Attributes, ","))
+		}
+	}
+
+	if e.ChangedBytes != nil && e.Kind != snapshotfs.DiffRemoved {
+		details = append(details, units.BytesStringBase10(*e.ChangedBytes)+" changed")
+	}
+
+	printStdout("%v file %v (%v)\n", e.Kind, e.Path, strings.Join(details, ", "))
+}
+
+func printDiffStats(s *snapshotfs.DiffStats, opt snapshotfs.DiffOptions) {
+	sizeDelta := "+" + units.BytesStringBase10(s.SizeDelta)
+	if s.SizeDelta < 0 {
+		sizeDelta = "-" + units.BytesStringBase10(-s.SizeDelta)
+	}
+
+	printStdout("\nFiles: %v added, %v removed, %v modified. Directories: %v added, %v removed.\n",
+		s.AddedFiles, s.RemovedFiles, s.ModifiedFiles, s.AddedDirectories, s.RemovedDirectories)
+	printStdout("Size change: %v\n", sizeDelta)
+
+	if opt.CompareContents {
+		printStdout("Changed bytes: %v\n", units.BytesStringBase10(s.ChangedBytes))
+	}
+}
+
+func runComparer(ctx context.Context, ent1, ent2 fs.Entry, isDir bool) error {
 	d, err := diff.NewComparer(os.Stdout)
 	if err != nil {
 		return err
@@ -51,7 +141,7 @@ func runDiffCommand(ctx context.Context, rep repo.Repository) error {
 		d.DiffArguments = parts[1:]
 	}
 
-	if isDir1 {
+	if isDir {
 		return d.Compare(ctx, ent1, ent2)
 	}
 
@@ -0,0 +1,209 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/repo/object"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotcatalog"
+)
+
+const findDateFormat = "2006-01-02"
+
+var (
+	findCommand = app.Command("find", "Find files in snapshot catalogs (see 'kopia catalog build').")
+
+	findPattern = findCommand.Arg("pattern", "File name pattern (or path pattern if it contains '/')").String()
+	findByHash  = findCommand.Flag("by-hash", "Find files with contents identical to the provided local file or object ID.").PlaceHolder("FILE-OR-OBJECTID").String()
+	findSource  = findCommand.Flag("source", "Only search snapshots of the provided source.").String()
+	findBefore  = findCommand.Flag("before", "Only search snapshots started before the provided date ("+findDateFormat+" or '"+timeFormat+"').").String()
+	findAfter   = findCommand.Flag("after", "Only search snapshots started after the provided date ("+findDateFormat+" or '"+timeFormat+"').").String()
+	findLong    = findCommand.Flag("long", "Long output").Short('l').Bool()
+
+	findMetadata = findCommand.Flag("metadata", "Only find files with metadata matching the provided pattern, e.g. 'dateTaken=2019-*' or 'title=*budget*' (see 'kopia catalog build --metadata').").PlaceHolder("KEY=PATTERN").Strings()
+)
+
+func parseFindTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.ParseInLocation(findDateFormat, s, time.Local); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(timeFormat, s)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid time %q", s)
+	}
+
+	return t, nil
+}
+
+func runFindCommand(ctx context.Context, rep repo.Repository) error {
+	q := snapshotcatalog.Query{
+		Pattern: *findPattern,
+	}
+
+	if q.Pattern == "" && *findByHash == "" && len(*findMetadata) == 0 {
+		return errors.New("must specify pattern, --by-hash or --metadata")
+	}
+
+	for _, kv := range *findMetadata {
+		parts := strings.SplitN(kv, "=", 2) //nolint:gomnd
+		if len(parts) != 2 || parts[0] == "" {
+			return errors.Errorf("invalid metadata pattern %q, expected key=pattern", kv)
+		}
+
+		if q.Metadata == nil {
+			q.Metadata = map[string]string{}
+		}
+
+		q.Metadata[parts[0]] = parts[1]
+	}
+
+	if *findByHash != "" {
+		oids, err := objectIDsForFindByHash(ctx, rep, *findByHash)
+		if err != nil {
+			return err
+		}
+
+		q.ObjectIDs = oids
+	}
+
+	if *findSource != "" {
+		si, err := snapshot.ParseSourceInfo(*findSource, rep.ClientOptions().Hostname, rep.ClientOptions().Username)
+		if err != nil {
+			return errors.Wrapf(err, "invalid source: '%s'", *findSource)
+		}
+
+		q.Source = &si
+	}
+
+	var err error
+
+	if q.Before, err = parseFindTime(*findBefore); err != nil {
+		return err
+	}
+
+	if q.After, err = parseFindTime(*findAfter); err != nil {
+		return err
+	}
+
+	return snapshotcatalog.Find(ctx, rep, q, func(cm *snapshotcatalog.Manifest, e *snapshotcatalog.Entry) error {
+		if *findLong {
+			fmt.Printf("%v %v %12d %v %-34v %v/%v%v\n",
+				formatTimestamp(cm.StartTime), cm.SnapshotID, e.FileSize, formatTimestamp(e.ModTime), e.ObjectID, cm.Source, e.Path, formatCatalogMetadata(e.Metadata))
+		} else {
+			fmt.Printf("%v %v/%v\n", formatTimestamp(cm.StartTime), cm.Source, e.Path)
+		}
+
+		return nil
+	})
+}
+
+func formatCatalogMetadata(md map[string]string) string {
+	var parts []string
+
+	for k, v := range md {
+		parts = append(parts, fmt.Sprintf("%v=%q", k, v))
+	}
+
+	if len(parts) == 0 {
+		return ""
+	}
+
+	sort.Strings(parts)
+
+	return " " + strings.Join(parts, " ")
+}
+
+func init() {
+	findCommand.Action(repositoryAction(runFindCommand))
+}
+
+// objectIDsForFindByHash returns object IDs the provided local file may have been stored as
+// or the provided object ID if it's not a local file.
+func objectIDsForFindByHash(ctx context.Context, rep repo.Repository, fileOrObjectID string) ([]object.ID, error) {
+	st, err := os.Stat(fileOrObjectID)
+	if err != nil {
+		oid, perr := object.ParseID(fileOrObjectID)
+		if perr != nil {
+			return nil, errors.Errorf("%q is neither a local file nor a valid object ID", fileOrObjectID)
+		}
+
+		return []object.ID{oid}, nil
+	}
+
+	if !st.Mode().IsRegular() {
+		return nil, errors.Errorf("%q is not a regular file", fileOrObjectID)
+	}
+
+	dr, ok := rep.(*repo.DirectRepository)
+	if !ok {
+		return nil, errors.New("finding local files by hash requires direct repository connection")
+	}
+
+	// object ID depends on compression, compute it for all compressors used by policies.
+	compressors := map[compression.Name]bool{"": true}
+
+	pols, err := policy.ListPolicies(ctx, rep)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list policies")
+	}
+
+	for _, pol := range pols {
+		if c := pol.CompressionPolicy.CompressorName; c != "" && c != "none" {
+			compressors[c] = true
+		}
+	}
+
+	var result []object.ID
+
+	for comp := range compressors {
+		oid, err := computeFileObjectID(ctx, dr, fileOrObjectID, comp)
+		if err != nil {
+			return nil, err
+		}
+
+		log(ctx).Debugf("object ID of %v with compression %q: %v", fileOrObjectID, comp, oid)
+
+		if !containsObjectID(result, oid) {
+			result = append(result, oid)
+		}
+	}
+
+	return result, nil
+}
+
+func computeFileObjectID(ctx context.Context, rep *repo.DirectRepository, fname string, comp compression.Name) (object.ID, error) {
+	f, err := os.Open(fname) //nolint:gosec
+	if err != nil {
+		return "", errors.Wrap(err, "unable to open file")
+	}
+	defer f.Close() //nolint:errcheck
+
+	oid, err := rep.Objects.ComputeObjectID(ctx, f, object.WriterOptions{Compressor: comp})
+
+	return oid, errors.Wrapf(err, "unable to compute object ID of %v", fname)
+}
+
+func containsObjectID(oids []object.ID, oid object.ID) bool {
+	for _, o := range oids {
+		if o == oid {
+			return true
+		}
+	}
+
+	return false
+}
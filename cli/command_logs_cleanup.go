@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/kopia/kopia/internal/logretention"
+	"github.com/kopia/kopia/internal/units"
+)
+
+var (
+	logsCommands = app.Command("logs", "Commands to manipulate local log files.")
+
+	logsCleanupCommand  = logsCommands.Command("cleanup", "Remove old log files")
+	logsCleanupMaxCount = logsCleanupCommand.Flag("max-count", "Maximum number of log files to retain in each log subdirectory").Default("1000").Int()
+	logsCleanupMaxAge   = logsCleanupCommand.Flag("max-age", "Maximum age of log files to retain").Default("720h").Duration()
+	logsCleanupMaxSize  = logsCleanupCommand.Flag("max-total-size", "Maximum total size of log files to retain").Default("1GB").Bytes()
+	logsCleanupDryRun   = logsCleanupCommand.Flag("dry-run", "Print log files that would be removed without removing them").Bool()
+)
+
+func runLogsCleanup(ctx context.Context) error {
+	dir := logDirFromFlags()
+
+	removed, err := logretention.Sweep(ctx, dir, logretention.Options{
+		MaxCount: *logsCleanupMaxCount,
+		MaxAge:   *logsCleanupMaxAge,
+		MaxSize:  int64(*logsCleanupMaxSize),
+		DryRun:   *logsCleanupDryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	var totalSize int64
+
+	for _, f := range removed {
+		totalSize += f.Size
+
+		if *logsCleanupDryRun {
+			printStdout("would remove %v\n", f.Path)
+		}
+	}
+
+	if *logsCleanupDryRun {
+		printStdout("Would remove %v log files (%v) from %v.\n", len(removed), units.BytesStringBase10(totalSize), dir)
+	} else {
+		printStdout("Removed %v log files (%v) from %v.\n", len(removed), units.BytesStringBase10(totalSize), dir)
+	}
+
+	return nil
+}
+
+func init() {
+	logsCleanupCommand.Action(noRepositoryAction(runLogsCleanup))
+}
@@ -44,17 +44,12 @@ func init() {
 }
 
 func listDirectory(ctx context.Context, d fs.Directory, prefix, indent string) error {
-	entries, err := d.Readdir(ctx)
-	if err != nil {
+	if err := iterateDirectory(ctx, d, func(ctx context.Context, e fs.Entry) error {
+		return printDirectoryEntry(ctx, e, prefix, indent)
+	}); err != nil {
 		return err
 	}
 
-	for _, e := range entries {
-		if err := printDirectoryEntry(ctx, e, prefix, indent); err != nil {
-			return err
-		}
-	}
-
 	if dws, ok := d.(fs.DirectoryWithSummary); ok && *lsCommandErrorSummary {
 		if ds, _ := dws.Summary(ctx); ds != nil && ds.NumFailed > 0 {
 			errorColor.Fprintf(os.Stderr, "\nNOTE: Encountered %v errors while snapshotting this directory:\n\n", ds.NumFailed) //nolint:errcheck
@@ -4,13 +4,16 @@
 	"context"
 	"encoding/json"
 	"os"
+	"sort"
 	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/repo/maintenance"
+	"github.com/kopia/kopia/snapshot/snapshotscrub"
 )
 
 var (
@@ -44,6 +47,68 @@ func runMaintenanceInfoCommand(ctx context.Context, rep *repo.DirectRepository)
 	printStdout("Full Cycle:\n")
 	displayCycleInfo(&p.FullCycle, s.NextFullMaintenanceTime, rep)
 
+	printStdout("Restore Test:\n")
+	printStdout("  scheduled: %v\n", p.RestoreTest.Enabled)
+
+	if p.RestoreTest.Enabled {
+		printStdout("  interval: %v\n", p.RestoreTest.Interval)
+		printStdout("  sample size: %v files\n", p.RestoreTest.SampleSize)
+
+		if p.RestoreTest.ScratchDir != "" {
+			printStdout("  scratch directory: %v\n", p.RestoreTest.ScratchDir)
+		}
+	}
+
+	printStdout("Content Verification:\n")
+	printStdout("  scheduled: %v\n", p.ContentVerification.Enabled)
+
+	if p.ContentVerification.Enabled {
+		printStdout("  interval: %v\n", p.ContentVerification.Interval)
+		printStdout("  contents per run: %v%%\n", snapshotscrub.OptionsFromParams(p.ContentVerification).Percent)
+	}
+
+	printStdout("Storage Budget:\n")
+
+	if p.StorageBudget.MaxBytes > 0 {
+		printStdout("  max storage: %v\n", units.BytesStringBase10(p.StorageBudget.MaxBytes))
+	} else {
+		printStdout("  max storage: unlimited\n")
+	}
+
+	printStdout("Snapshot Thinning:\n")
+
+	if len(p.Thinning.Rules) == 0 {
+		printStdout("  no rules\n")
+	}
+
+	for _, r := range p.Thinning.Rules {
+		printStdout("  one snapshot per %v when older than %v\n", r.Interval, r.OlderThan)
+	}
+
+	printStdout("Blob GC:\n")
+
+	if p.BlobGC.QuarantinePeriod > 0 {
+		printStdout("  quarantine period: %v\n", p.BlobGC.QuarantinePeriod)
+	} else {
+		printStdout("  quarantine period: none\n")
+	}
+
+	printStdout("Clock Skew:\n")
+	printStdout("  max skew: %v\n", p.ClockSkew.MaxSkewOrDefault())
+	printStdout("  refuse maintenance: %v\n", p.ClockSkew.RefuseMaintenance)
+
+	var clients []string
+	for client := range s.ClockSkew {
+		clients = append(clients, client)
+	}
+
+	sort.Strings(clients)
+
+	for _, client := range clients {
+		cs := s.ClockSkew[client]
+		printStdout("  %v: %v (measured %v)\n", client, cs.Skew, formatTimestamp(cs.Time))
+	}
+
 	printStdout("Recent Maintenance Runs:\n")
 
 	for run, timings := range s.Runs {
@@ -65,9 +130,38 @@ func runMaintenanceInfoCommand(ctx context.Context, rep *repo.DirectRepository)
 		}
 	}
 
+	if len(s.Purges) > 0 {
+		printStdout("Content Purges:\n")
+
+		for _, r := range s.Purges {
+			displayPurgeRecord(r)
+		}
+	}
+
 	return nil
 }
 
+func displayPurgeRecord(r *maintenance.PurgeRecord) {
+	status := "pending"
+	if !r.Pending() {
+		status = "completed " + formatTimestamp(r.CompletedTime)
+	}
+
+	printStdout("  %v by %v (%v): %v contents, %v packs\n", formatTimestamp(r.RequestTime), r.RequestedBy, status, len(r.ContentIDs), len(r.PackBlobIDs))
+
+	if r.Reason != "" {
+		printStdout("    reason: %v\n", r.Reason)
+	}
+
+	for _, ref := range r.References {
+		printStdout("    removed: %v\n", ref)
+	}
+
+	if len(r.NotPurged) > 0 {
+		printStdout("    not purged (in use again): %v\n", r.NotPurged)
+	}
+}
+
 func displayCycleInfo(c *maintenance.CycleParams, t time.Time, rep *repo.DirectRepository) {
 	printStdout("  scheduled: %v\n", c.Enabled)
 
@@ -2,16 +2,23 @@
 
 import (
 	"context"
+	"encoding/json"
+	"os"
+	"strings"
 
+	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/repo/maintenance"
 	"github.com/kopia/kopia/snapshot/snapshotmaintenance"
 )
 
 var (
-	maintenanceRunCommand = maintenanceCommands.Command("run", "Run repository maintenance").Default()
-	maintenanceRunFull    = maintenanceRunCommand.Flag("full", "Full maintenance").Bool()
-	maintenanceRunForce   = maintenanceRunCommand.Flag("force", "Run maintenance even if not owned (unsafe)").Hidden().Bool()
+	maintenanceRunCommand    = maintenanceCommands.Command("run", "Run repository maintenance").Default()
+	maintenanceRunFull       = maintenanceRunCommand.Flag("full", "Full maintenance").Bool()
+	maintenanceRunForce      = maintenanceRunCommand.Flag("force", "Run maintenance even if not owned (unsafe)").Hidden().Bool()
+	maintenanceRunDryRun     = maintenanceRunCommand.Flag("dry-run", "Print changes that maintenance would make without making them").Bool()
+	maintenanceRunMaxSamples = maintenanceRunCommand.Flag("max-samples", "Maximum number of sample IDs to print for each action in dry-run mode").Default("10").Int()
+	maintenanceRunJSON       = maintenanceRunCommand.Flag("json", "Output dry-run preview as JSON").Bool()
 )
 
 func runMaintenanceCommand(ctx context.Context, rep *repo.DirectRepository) error {
@@ -20,9 +27,91 @@ func runMaintenanceCommand(ctx context.Context, rep *repo.DirectRepository) erro
 		mode = maintenance.ModeFull
 	}
 
+	if *maintenanceRunDryRun {
+		return runMaintenanceDryRun(ctx, rep, mode)
+	}
+
 	return snapshotmaintenance.Run(ctx, rep, mode, *maintenanceRunForce)
 }
 
+func runMaintenanceDryRun(ctx context.Context, rep *repo.DirectRepository, mode maintenance.Mode) error {
+	p, err := snapshotmaintenance.Preview(ctx, rep, mode, *maintenanceRunMaxSamples)
+	if err != nil {
+		return err
+	}
+
+	if *maintenanceRunJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+
+		return e.Encode(p)
+	}
+
+	printStdout("Dry run of %v maintenance at %v, no changes have been made.\n\n", p.Mode, formatTimestamp(p.Time))
+
+	if p.SnapshotsToThin != nil {
+		printStdout("%-30v %v\n", "Snapshots to thin:", p.SnapshotsToThin.Count)
+
+		for _, desc := range p.SnapshotsToThin.Samples {
+			printStdout("  %v\n", desc)
+		}
+
+		if more := p.SnapshotsToThin.Count - len(p.SnapshotsToThin.Samples); more > 0 {
+			printStdout("  ... and %v more\n", more)
+		}
+	}
+
+	if p.SnapshotsToExpire != nil {
+		printStdout("%-30v %v\n", "Snapshots to expire:", p.SnapshotsToExpire.Count)
+		printPreviewSamples(p.SnapshotsToExpire)
+	}
+
+	if p.ContentsToMarkDeleted != nil {
+		printPreviewItems("Contents to mark as deleted:", p.ContentsToMarkDeleted)
+	}
+
+	if p.ContentsToUndelete != nil {
+		printPreviewItems("Contents to undelete:", p.ContentsToUndelete)
+	}
+
+	if p.Mode == maintenance.ModeFull {
+		if p.SafeDropTime.IsZero() {
+			printStdout("Deleted contents will not be dropped, not enough time has passed since previous snapshot GC.\n")
+		} else {
+			printStdout("Contents deleted before %v will be dropped from the index.\n", formatTimestamp(p.SafeDropTime))
+		}
+
+		printPreviewItems("Contents to drop from index:", &p.ContentsToDrop)
+		printPreviewItems("Contents to purge:", &p.ContentsToPurge)
+	}
+
+	printPreviewItems("Index blobs to compact:", &p.IndexBlobsToCompact)
+	printPreviewItems("Contents to rewrite:", &p.ContentsToRewrite)
+	printPreviewItems("Blobs to delete:", &p.BlobsToDelete)
+
+	printStdout("\nStorage to be reclaimed: %v\n", units.BytesStringBase10(p.ReclaimedBytes()))
+
+	return nil
+}
+
+func printPreviewItems(title string, pi *maintenance.PreviewItems) {
+	printStdout("%-30v %v (%v)\n", title, pi.Count, units.BytesStringBase10(pi.Bytes))
+	printPreviewSamples(pi)
+}
+
+func printPreviewSamples(pi *maintenance.PreviewItems) {
+	if len(pi.Samples) == 0 {
+		return
+	}
+
+	more := ""
+	if pi.Count > len(pi.Samples) {
+		more = ", ..."
+	}
+
+	printStdout("  %v%v\n", strings.Join(pi.Samples, ", "), more)
+}
+
 func init() {
 	maintenanceRunCommand.Action(directRepositoryAction(runMaintenanceCommand))
 }
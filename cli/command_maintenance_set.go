@@ -2,12 +2,15 @@
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/repo/maintenance"
+	"github.com/kopia/kopia/snapshot/snapshotthin"
 )
 
 var (
@@ -23,6 +26,26 @@
 
 	maintenanceSetPauseQuick = maintenanceSetCommand.Flag("pause-quick", "Pause quick maintenance for a specified duration").DurationList()
 	maintenanceSetPauseFull  = maintenanceSetCommand.Flag("pause-full", "Pause full maintenance for a specified duration").DurationList()
+
+	maintenanceSetEnableRestoreTest     = maintenanceSetCommand.Flag("enable-restore-test", "Enable or disable periodic test restores of random samples of files from latest snapshots").BoolList()
+	maintenanceSetRestoreTestInterval   = maintenanceSetCommand.Flag("restore-test-interval", "Set restore test interval").DurationList()
+	maintenanceSetRestoreTestSampleSize = maintenanceSetCommand.Flag("restore-test-sample-size", "Set number of files restored during each restore test").Ints()
+	maintenanceSetRestoreTestScratchDir = maintenanceSetCommand.Flag("restore-test-scratch-dir", "Set local directory where files are restored during restore tests (empty for system temporary directory)").Strings()
+
+	maintenanceSetEnableContentVerification   = maintenanceSetCommand.Flag("enable-content-verification", "Enable or disable periodic verification of a percentage of repository contents").BoolList()
+	maintenanceSetContentVerificationInterval = maintenanceSetCommand.Flag("content-verification-interval", "Set content verification interval").DurationList()
+	maintenanceSetContentVerificationPercent  = maintenanceSetCommand.Flag("content-verification-percent", "Set percentage of contents verified during each content verification").Float64List()
+
+	maintenanceSetStorageBudgetSet bool
+	maintenanceSetStorageBudget    = maintenanceSetCommand.Flag("storage-budget", "Expire oldest snapshots during full maintenance when snapshots use more storage (0 disables)").IsSetByUser(&maintenanceSetStorageBudgetSet).Bytes()
+
+	maintenanceSetThinningRules      = maintenanceSetCommand.Flag("thinning-rule", "Keep one snapshot per INTERVAL among snapshots older than AGE during full maintenance, specified as AGE:INTERVAL, e.g. 720h:24h (replaces existing rules)").Strings()
+	maintenanceSetClearThinningRules = maintenanceSetCommand.Flag("clear-thinning-rules", "Remove all snapshot thinning rules").Bool()
+
+	maintenanceSetBlobGCQuarantine = maintenanceSetCommand.Flag("blob-gc-quarantine", "Move unreferenced blobs to quarantine for the specified period before deleting them (0 deletes immediately)").DurationList()
+
+	maintenanceSetMaxClockSkew      = maintenanceSetCommand.Flag("max-clock-skew", "Set maximum tolerated clock skew between the client running maintenance and the storage (0 for default)").DurationList()
+	maintenanceSetRefuseOnClockSkew = maintenanceSetCommand.Flag("refuse-on-clock-skew", "Refuse to run maintenance when clock skew exceeds the maximum instead of warning").BoolList()
 )
 
 func setMaintenanceOwnerFromFlags(ctx context.Context, p *maintenance.Params, rep *repo.DirectRepository, changed *bool) {
@@ -63,6 +86,82 @@ func setMaintenanceEnabledAndIntervalFromFlags(ctx context.Context, c *maintenan
 	}
 }
 
+func setRestoreTestParamsFromFlags(ctx context.Context, p *maintenance.RestoreTestParams, changed *bool) {
+	if v := *maintenanceSetEnableRestoreTest; len(v) > 0 {
+		p.Enabled = v[len(v)-1]
+		*changed = true
+
+		log(ctx).Infof("Periodic restore test enabled: %v.", p.Enabled)
+	}
+
+	if v := *maintenanceSetRestoreTestInterval; len(v) > 0 {
+		p.Interval = v[len(v)-1]
+		*changed = true
+
+		log(ctx).Infof("Interval for restore test set to %v.", p.Interval)
+	}
+
+	if v := *maintenanceSetRestoreTestSampleSize; len(v) > 0 {
+		p.SampleSize = v[len(v)-1]
+		*changed = true
+
+		log(ctx).Infof("Restore test sample size set to %v.", p.SampleSize)
+	}
+
+	if v := *maintenanceSetRestoreTestScratchDir; len(v) > 0 {
+		p.ScratchDir = v[len(v)-1]
+		*changed = true
+
+		log(ctx).Infof("Restore test scratch directory set to %q.", p.ScratchDir)
+	}
+}
+
+func setClockSkewParamsFromFlags(ctx context.Context, p *maintenance.ClockSkewParams, changed *bool) {
+	if v := *maintenanceSetMaxClockSkew; len(v) > 0 {
+		p.MaxSkew = v[len(v)-1]
+		*changed = true
+
+		log(ctx).Infof("Maximum clock skew set to %v.", p.MaxSkewOrDefault())
+	}
+
+	if v := *maintenanceSetRefuseOnClockSkew; len(v) > 0 {
+		p.RefuseMaintenance = v[len(v)-1]
+		*changed = true
+
+		log(ctx).Infof("Refuse maintenance on excessive clock skew: %v.", p.RefuseMaintenance)
+	}
+}
+
+func setContentVerificationParamsFromFlags(ctx context.Context, p *maintenance.ContentVerificationParams, changed *bool) error {
+	if v := *maintenanceSetEnableContentVerification; len(v) > 0 {
+		p.Enabled = v[len(v)-1]
+		*changed = true
+
+		log(ctx).Infof("Periodic content verification enabled: %v.", p.Enabled)
+	}
+
+	if v := *maintenanceSetContentVerificationInterval; len(v) > 0 {
+		p.Interval = v[len(v)-1]
+		*changed = true
+
+		log(ctx).Infof("Interval for content verification set to %v.", p.Interval)
+	}
+
+	if v := *maintenanceSetContentVerificationPercent; len(v) > 0 {
+		pct := v[len(v)-1]
+		if pct <= 0 || pct > 100 {
+			return errors.Errorf("content verification percentage must be greater than 0 and at most 100")
+		}
+
+		p.Percent = pct
+		*changed = true
+
+		log(ctx).Infof("Content verification will verify %v%% of contents during each run.", p.Percent)
+	}
+
+	return nil
+}
+
 func runMaintenanceSetParams(ctx context.Context, rep *repo.DirectRepository) error {
 	p, err := maintenance.GetParams(ctx, rep)
 	if err != nil {
@@ -79,6 +178,31 @@ func runMaintenanceSetParams(ctx context.Context, rep *repo.DirectRepository) er
 	setMaintenanceOwnerFromFlags(ctx, p, rep, &changedParams)
 	setMaintenanceEnabledAndIntervalFromFlags(ctx, &p.QuickCycle, "quick", *maintenanceSetEnableQuick, *maintenanceSetQuickFrequency, &changedParams)
 	setMaintenanceEnabledAndIntervalFromFlags(ctx, &p.FullCycle, "full", *maintenanceSetEnableFull, *maintenanceSetFullFrequency, &changedParams)
+	setRestoreTestParamsFromFlags(ctx, &p.RestoreTest, &changedParams)
+
+	if err := setContentVerificationParamsFromFlags(ctx, &p.ContentVerification, &changedParams); err != nil {
+		return err
+	}
+
+	if maintenanceSetStorageBudgetSet {
+		p.StorageBudget.MaxBytes = int64(*maintenanceSetStorageBudget)
+		changedParams = true
+
+		log(ctx).Infof("Storage budget set to %v.", units.BytesStringBase10(p.StorageBudget.MaxBytes))
+	}
+
+	if err := setThinningParamsFromFlags(ctx, &p.Thinning, &changedParams); err != nil {
+		return err
+	}
+
+	if v := *maintenanceSetBlobGCQuarantine; len(v) > 0 {
+		p.BlobGC.QuarantinePeriod = v[len(v)-1]
+		changedParams = true
+
+		log(ctx).Infof("Blob GC quarantine period set to %v.", p.BlobGC.QuarantinePeriod)
+	}
+
+	setClockSkewParamsFromFlags(ctx, &p.ClockSkew, &changedParams)
 
 	if v := *maintenanceSetPauseQuick; len(v) > 0 {
 		pauseDuration := v[len(v)-1]
@@ -118,3 +242,57 @@ func runMaintenanceSetParams(ctx context.Context, rep *repo.DirectRepository) er
 func init() {
 	maintenanceSetCommand.Action(directRepositoryAction(runMaintenanceSetParams))
 }
+
+func setThinningParamsFromFlags(ctx context.Context, p *maintenance.ThinningParams, changed *bool) error {
+	if *maintenanceSetClearThinningRules {
+		p.Rules = nil
+		*changed = true
+
+		log(ctx).Infof("Snapshot thinning rules removed.")
+	}
+
+	if len(*maintenanceSetThinningRules) == 0 {
+		return nil
+	}
+
+	var rules []maintenance.ThinningRule
+
+	for _, v := range *maintenanceSetThinningRules {
+		r, err := parseThinningRule(v)
+		if err != nil {
+			return err
+		}
+
+		rules = append(rules, r)
+
+		log(ctx).Infof("Keeping one snapshot per %v among snapshots older than %v.", r.Interval, r.OlderThan)
+	}
+
+	if err := snapshotthin.Validate(rules); err != nil {
+		return err
+	}
+
+	p.Rules = rules
+	*changed = true
+
+	return nil
+}
+
+func parseThinningRule(s string) (maintenance.ThinningRule, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 { // nolint:gomnd
+		return maintenance.ThinningRule{}, errors.Errorf("invalid thinning rule %q, expected AGE:INTERVAL", s)
+	}
+
+	olderThan, err := time.ParseDuration(parts[0])
+	if err != nil {
+		return maintenance.ThinningRule{}, errors.Wrapf(err, "invalid age in thinning rule %q", s)
+	}
+
+	interval, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return maintenance.ThinningRule{}, errors.Wrapf(err, "invalid interval in thinning rule %q", s)
+	}
+
+	return maintenance.ThinningRule{OlderThan: olderThan, Interval: interval}, nil
+}
@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/maintenance"
+	"github.com/kopia/kopia/snapshot/snapshotscrub"
+)
+
+var (
+	maintenanceVerifyContentCommand  = maintenanceCommands.Command("verify-content", "Download and verify a percentage of contents that were verified least recently")
+	maintenanceVerifyContentPercent  = maintenanceVerifyContentCommand.Flag("percent", "Percentage of contents to verify (defaults to maintenance parameters)").Float64()
+	maintenanceVerifyContentParallel = maintenanceVerifyContentCommand.Flag("parallel", "Parallelism").Default("8").Int()
+	maintenanceVerifyContentJSON     = maintenanceVerifyContentCommand.Flag("json", "Output result as JSON").Bool()
+)
+
+func runMaintenanceVerifyContentCommand(ctx context.Context, rep *repo.DirectRepository) error {
+	p, err := maintenance.GetParams(ctx, rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get maintenance params")
+	}
+
+	opt := snapshotscrub.OptionsFromParams(p.ContentVerification)
+	opt.Parallel = *maintenanceVerifyContentParallel
+
+	if v := *maintenanceVerifyContentPercent; v != 0 {
+		if v < 0 || v > 100 {
+			return errors.Errorf("percentage must be greater than 0 and at most 100")
+		}
+
+		opt.Percent = v
+	}
+
+	var res *snapshotscrub.Result
+
+	runErr := maintenance.ReportRun(ctx, rep, snapshotscrub.RunType, func() error {
+		var err error
+
+		res, err = snapshotscrub.Run(ctx, rep, opt)
+		if err != nil {
+			return err
+		}
+
+		return res.Err()
+	})
+	if res == nil {
+		return runErr
+	}
+
+	if *maintenanceVerifyContentJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+
+		if encErr := e.Encode(res); encErr != nil {
+			return errors.Wrap(encErr, "unable to encode result")
+		}
+
+		return res.Err()
+	}
+
+	printStdout("Verified %v of %v contents (%v not verified yet).\n", res.Verified, res.TotalContents, res.NeverVerified)
+
+	if !res.OldestVerification.IsZero() {
+		printStdout("Least recent verification: %v\n", formatTimestamp(res.OldestVerification))
+	}
+
+	for _, cc := range res.Corrupt {
+		printStdout("\nCorrupt content %v in pack %v: %v\n", cc.ContentID, cc.PackBlobID, cc.Error)
+
+		if len(cc.Snapshots) == 0 {
+			printStdout("  not referenced by any snapshot\n")
+		}
+
+		for _, s := range cc.Snapshots {
+			printStdout("  referenced by snapshot %v\n", s)
+		}
+	}
+
+	return res.Err()
+}
+
+func init() {
+	maintenanceVerifyContentCommand.Action(directRepositoryAction(runMaintenanceVerifyContentCommand))
+}
@@ -24,6 +24,10 @@
 
 	mountFuseAllowOther         = mountCommand.Flag("fuse-allow-other", "Allows other users to access the file system.").Bool()
 	mountFuseAllowNonEmptyMount = mountCommand.Flag("fuse-allow-non-empty-mount", "Allows the mounting over a non-empty directory. The files in it will be shadowed by the freshly created mount.").Bool()
+	mountPreferWebDAV           = mountCommand.Flag("webdav", "Mount using operating system WebDAV client instead of FUSE, FUSE-T or WinFsp (used automatically when none of them is available).").Bool()
+	mountFuseAttrTTL            = mountCommand.Flag("fuse-attr-ttl", "Duration for which file attributes are cached by the kernel.").Default("5m").Duration()
+	mountFuseEntryTTL           = mountCommand.Flag("fuse-entry-ttl", "Duration for which directory entries are cached.").Default("5m").Duration()
+	mountFuseReadAheadMB        = mountCommand.Flag("fuse-read-ahead-mb", "Amount of data to read in the background when reading files sequentially.").PlaceHolder("MB").Default("1").Int()
 )
 
 func runMountCommand(ctx context.Context, rep repo.Repository) error {
@@ -49,6 +53,11 @@ func runMountCommand(ctx context.Context, rep repo.Repository) error {
 		mount.Options{
 			FuseAllowOther:         *mountFuseAllowOther,
 			FuseAllowNonEmptyMount: *mountFuseAllowNonEmptyMount,
+			FuseAttrTTL:            *mountFuseAttrTTL,
+			FuseEntryTTL:           *mountFuseEntryTTL,
+			FuseMaxCachedEntries:   maxCachedEntries,
+			FuseReadAheadSize:      *mountFuseReadAheadMB << 20, //nolint:gomnd
+			PreferWebDAV:           *mountPreferWebDAV,
 		})
 
 	if mountErr != nil {
@@ -41,6 +41,7 @@
   #   "noParentDotFiles": true
   #   "noParentIgnore": true
   #   "oneFileSystem": false
+  #   "securityDescriptors": false
 `
 
 const policyEditSchedulingHelpText = `
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/snapshot/policy"
+)
+
+var (
+	policyProfileCommands = policyCommands.Command("profile", "Manage policy profiles adopted by clients connecting to the server for the first time.")
+
+	policyProfileSetCommand    = policyProfileCommands.Command("set", "Create or update a policy profile.")
+	policyProfileSetName       = policyProfileSetCommand.Arg("name", "Profile name").Required().String()
+	policyProfileSetMatch      = policyProfileSetCommand.Flag("match", "Pattern of client identities (user@host) the profile applies to, e.g. '*@laptop-*'").Required().Strings()
+	policyProfileSetFromFile   = policyProfileSetCommand.Flag("from-file", "Read profile policy from a JSON file (in the format of 'policy show --json')").ExistingFile()
+	policyProfileSetFromPolicy = policyProfileSetCommand.Flag("from-policy", "Copy profile policy from the policy defined on a target ('global','user@host','@host') or a path").String()
+
+	policyProfileListCommand = policyProfileCommands.Command("list", "List policy profiles.").Alias("ls")
+
+	policyProfileShowCommand = policyProfileCommands.Command("show", "Show policy profile.")
+	policyProfileShowName    = policyProfileShowCommand.Arg("name", "Profile name").Required().String()
+
+	policyProfileRemoveCommand = policyProfileCommands.Command("remove", "Remove policy profile.").Alias("rm").Alias("delete")
+	policyProfileRemoveName    = policyProfileRemoveCommand.Arg("name", "Profile name").Required().String()
+)
+
+func init() {
+	policyProfileSetCommand.Action(repositoryAction(runPolicyProfileSet))
+	policyProfileListCommand.Action(repositoryAction(runPolicyProfileList))
+	policyProfileShowCommand.Action(repositoryAction(runPolicyProfileShow))
+	policyProfileRemoveCommand.Action(repositoryAction(runPolicyProfileRemove))
+}
+
+func runPolicyProfileSet(ctx context.Context, rep repo.Repository) error {
+	if (*policyProfileSetFromFile == "") == (*policyProfileSetFromPolicy == "") {
+		return errors.New("must pass either '--from-file' or '--from-policy'")
+	}
+
+	p, err := profilePolicyFromFlags(ctx, rep)
+	if err != nil {
+		return err
+	}
+
+	if err := policy.SetProfile(ctx, rep, &policy.Profile{
+		Name:   *policyProfileSetName,
+		Match:  *policyProfileSetMatch,
+		Policy: p,
+	}); err != nil {
+		return errors.Wrap(err, "unable to save profile")
+	}
+
+	log(ctx).Infof("Saved policy profile %v applying to %v", *policyProfileSetName, strings.Join(*policyProfileSetMatch, ", "))
+
+	return nil
+}
+
+func profilePolicyFromFlags(ctx context.Context, rep repo.Repository) (*policy.Policy, error) {
+	if *policyProfileSetFromFile != "" {
+		f, err := os.Open(*policyProfileSetFromFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to open policy file")
+		}
+		defer f.Close() //nolint:errcheck,gosec
+
+		p := &policy.Policy{}
+
+		d := json.NewDecoder(f)
+		d.DisallowUnknownFields()
+
+		if err := d.Decode(p); err != nil {
+			return nil, errors.Wrap(err, "invalid policy file")
+		}
+
+		return p, nil
+	}
+
+	global := false
+	targets := []string{*policyProfileSetFromPolicy}
+
+	if *policyProfileSetFromPolicy == "global" {
+		global, targets = true, nil
+	}
+
+	si, err := policyTargets(ctx, rep, &global, &targets)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := policy.GetDefinedPolicy(ctx, rep, si[0])
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get policy for %v", si[0])
+	}
+
+	return p, nil
+}
+
+func runPolicyProfileList(ctx context.Context, rep repo.Repository) error {
+	profiles, err := policy.ListProfiles(ctx, rep)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range profiles {
+		printStdout("%v %v\n", p.Name, strings.Join(p.Match, " "))
+	}
+
+	return nil
+}
+
+func runPolicyProfileShow(ctx context.Context, rep repo.Repository) error {
+	p, err := policy.GetProfile(ctx, rep, *policyProfileShowName)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get profile %v", *policyProfileShowName)
+	}
+
+	printStdout("%v", prettyJSON(p))
+
+	return nil
+}
+
+func runPolicyProfileRemove(ctx context.Context, rep repo.Repository) error {
+	return errors.Wrapf(policy.RemoveProfile(ctx, rep, *policyProfileRemoveName), "unable to remove profile %v", *policyProfileRemoveName)
+}
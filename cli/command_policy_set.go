@@ -6,10 +6,13 @@
 	"strconv"
 	"strings"
 
+	byteunits "github.com/alecthomas/units"
 	"github.com/pkg/errors"
 
+	"github.com/kopia/kopia/internal/ospriority"
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/snapshot/apprecipe"
 	"github.com/kopia/kopia/snapshot/policy"
 )
 
@@ -23,6 +26,16 @@
 	// Frequency.
 	policySetInterval   = policySetCommand.Flag("snapshot-interval", "Interval between snapshots").DurationList()
 	policySetTimesOfDay = policySetCommand.Flag("snapshot-time", "Times of day when to take snapshot (HH:mm)").Strings()
+	policySetRPO        = policySetCommand.Flag("rpo", "Report sources without successful snapshot within the provided time (recovery point objective, 0 to inherit)").DurationList()
+
+	// Source groups.
+	policySetAddGroup    = policySetCommand.Flag("add-group", "List of source groups to add the source to").PlaceHolder("GROUP").Strings()
+	policySetRemoveGroup = policySetCommand.Flag("remove-group", "List of source groups to remove the source from").PlaceHolder("GROUP").Strings()
+	policySetClearGroups = policySetCommand.Flag("clear-groups", "Remove the source from all groups").Bool()
+
+	// Host conditions.
+	policySetMinBatteryPercent       = policySetCommand.Flag("min-battery-percent", "Defer scheduled snapshots when on battery below given charge percentage (or 'inherit')").PlaceHolder("N").String()
+	policySetSkipOnMeteredConnection = policySetCommand.Flag("skip-on-metered-connection", "Defer scheduled snapshots when on metered network connection ('true', 'false', 'inherit')").Enum(booleanEnumValues...)
 
 	// Expiration policies.
 	policySetKeepLatest  = policySetCommand.Flag("keep-latest", "Number of most recent backups to keep per source (or 'inherit')").PlaceHolder("N").String()
@@ -31,6 +44,7 @@
 	policySetKeepWeekly  = policySetCommand.Flag("keep-weekly", "Number of most-recent weekly backups to keep per source (or 'inherit')").PlaceHolder("N").String()
 	policySetKeepMonthly = policySetCommand.Flag("keep-monthly", "Number of most-recent monthly backups to keep per source (or 'inherit')").PlaceHolder("N").String()
 	policySetKeepAnnual  = policySetCommand.Flag("keep-annual", "Number of most-recent annual backups to keep per source (or 'inherit')").PlaceHolder("N").String()
+	policySetLegalHold   = policySetCommand.Flag("legal-hold", "Freeze retention and prevent deletion of all snapshots, holds of parent policies can't be released ('true', 'false', 'inherit')").Enum(booleanEnumValues...)
 
 	// Files to ignore.
 	policySetAddIgnore    = policySetCommand.Flag("add-ignore", "List of paths to add to the ignore list").PlaceHolder("PATTERN").Strings()
@@ -63,10 +77,36 @@
 	// Ignore other mounted fileystems.
 	policyOneFileSystem = policySetCommand.Flag("one-file-system", "Stay in parent filesystem when finding files ('true', 'false', 'inherit')").Enum(booleanEnumValues...)
 
+	// Windows ACLs.
+	policySecurityDescriptors = policySetCommand.Flag("security-descriptors", "Capture Windows security descriptors (ACLs) of files and directories ('true', 'false', 'inherit')").Enum(booleanEnumValues...)
+
 	// Error handling behavior.
 	policyIgnoreFileErrors      = policySetCommand.Flag("ignore-file-errors", "Ignore errors reading files while traversing ('true', 'false', 'inherit')").Enum(booleanEnumValues...)
 	policyIgnoreDirectoryErrors = policySetCommand.Flag("ignore-dir-errors", "Ignore errors reading directories while traversing ('true', 'false', 'inherit").Enum(booleanEnumValues...)
 
+	// Upload resource usage.
+	policySetMaxParallelFileReads = policySetCommand.Flag("max-parallel-file-reads", "Maximum number of files read in parallel (or 'inherit')").PlaceHolder("N").String()
+	policySetCPUNiceness          = policySetCommand.Flag("cpu-niceness", "CPU niceness (0-19) of snapshots (or 'inherit')").PlaceHolder("N").String()
+	policySetIOPriority           = policySetCommand.Flag("io-priority", "IO priority of snapshots").Enum(inheritPolicyString, policy.IOPriorityNormal, policy.IOPriorityLow, policy.IOPriorityIdle)
+	policySetUploadHints          = policySetCommand.Flag("upload-hints", "Where to persist hints which avoid re-hashing unchanged files when previous snapshots are unavailable").Enum(inheritPolicyString, policy.UploadHintsNone, policy.UploadHintsLocal, policy.UploadHintsRepository)
+	policySetMaxUploadSpeed       = policySetCommand.Flag("max-upload-speed", "Limit upload speed of snapshots per second, 0 for unlimited (or 'inherit')").PlaceHolder("BYTES").String()
+	policySetBandwidthWindows     = policySetCommand.Flag("bandwidth-window", "Limit bandwidth of snapshots during the time window, bandwidth is unlimited outside of all windows (or 'inherit')").PlaceHolder("'[DAYS] HH:MM-HH:MM [upload=SPEED] [download=SPEED]'").Strings()
+	policySetDeltaUpload          = policySetCommand.Flag("delta-upload", "Reuse chunks of changed files with the same fingerprint as in the previous snapshot instead of compressing, encrypting and uploading them again ('true', 'false', 'inherit')").Enum(booleanEnumValues...)
+
+	// Anomaly detection.
+	policySetDetectAnomalies               = policySetCommand.Flag("detect-anomalies", "Detect anomalies such as mass deletion or encryption of files when taking snapshots ('true', 'false', 'inherit')").Enum(booleanEnumValues...)
+	policySetMaxFileCountDropPercent       = policySetCommand.Flag("anomaly-max-file-count-drop", "Maximum decrease of the number of files in percent, 0 disables the check (or 'inherit')").PlaceHolder("PERCENT").String()
+	policySetMaxSizeDropPercent            = policySetCommand.Flag("anomaly-max-size-drop", "Maximum decrease of the total size of files in percent, 0 disables the check (or 'inherit')").PlaceHolder("PERCENT").String()
+	policySetMaxChangedFilesPercent        = policySetCommand.Flag("anomaly-max-changed-files", "Maximum percentage of changed files, 0 disables the check (or 'inherit')").PlaceHolder("PERCENT").String()
+	policySetMaxHighEntropyIncreasePercent = policySetCommand.Flag("anomaly-max-entropy-increase", "Maximum increase of the percentage of changed files that look encrypted, 0 disables the check (or 'inherit')").PlaceHolder("PERCENT").String()
+	policySetMaxExtensionChangePercent     = policySetCommand.Flag("anomaly-max-extension-change", "Maximum increase of the percentage of files renamed to a different extension, 0 disables the check (or 'inherit')").PlaceHolder("PERCENT").String()
+	policySetAnomalyPreventExpiration      = policySetCommand.Flag("anomaly-prevent-expiration", "Keep snapshots taken before a snapshot with unacknowledged anomalies ('true', 'false', 'inherit')").Enum(booleanEnumValues...)
+	policySetPauseExpirationOnRansomware   = policySetCommand.Flag("pause-expiration-on-ransomware", "Pause expiration of snapshots while the most recent snapshots show unacknowledged signs of ransomware ('true', 'false', 'inherit')").Enum(booleanEnumValues...)
+
+	// Application-consistent snapshots.
+	policySetAppRecipe     = policySetCommand.Flag("app-recipe", "Snapshot the database using a built-in recipe instead of reading files (or 'inherit')").Enum(append([]string{inheritPolicyString}, apprecipe.Names()...)...)
+	policySetAppRecipeArgs = policySetCommand.Flag("app-recipe-arg", "Argument passed to commands of the recipe which connect to the database, such as connection parameters").PlaceHolder("ARG").Strings()
+
 	// General policy.
 	policySetInherit = policySetCommand.Flag(inheritPolicyString, "Enable or disable inheriting policies from the parent").BoolList()
 )
@@ -135,6 +175,18 @@ func setPolicyFromFlags(ctx context.Context, p *policy.Policy, changeCount *int)
 		return errors.Wrap(err, "scheduling policy")
 	}
 
+	if err := setUploadPolicyFromFlags(ctx, &p.UploadPolicy, changeCount); err != nil {
+		return errors.Wrap(err, "upload policy")
+	}
+
+	if err := setAnomalyPolicyFromFlags(ctx, &p.AnomalyPolicy, changeCount); err != nil {
+		return errors.Wrap(err, "anomaly policy")
+	}
+
+	if err := setApplicationPolicyFromFlags(ctx, &p.ApplicationPolicy, changeCount); err != nil {
+		return errors.Wrap(err, "application policy")
+	}
+
 	if err := applyPolicyNumber64(ctx, "maximum file size", &p.FilesPolicy.MaxFileSize, *policySetMaxFileSize, changeCount); err != nil {
 		return errors.Wrap(err, "maximum file size")
 	}
@@ -214,6 +266,28 @@ func setFilesPolicyFromFlags(ctx context.Context, fp *policy.FilesPolicy, change
 		printStderr(" - setting one file system to %v\n", val)
 	}
 
+	switch {
+	case *policySecurityDescriptors == "":
+	case *policySecurityDescriptors == inheritPolicyString:
+		*changeCount++
+
+		fp.SecurityDescriptors = nil
+
+		printStderr(" - inherit capturing security descriptors from parent\n")
+
+	default:
+		val, err := strconv.ParseBool(*policySecurityDescriptors)
+		if err != nil {
+			return err
+		}
+
+		*changeCount++
+
+		fp.SecurityDescriptors = &val
+
+		printStderr(" - setting capturing security descriptors to %v\n", val)
+	}
+
 	return nil
 }
 
@@ -283,6 +357,28 @@ func setRetentionPolicyFromFlags(ctx context.Context, rp *policy.RetentionPolicy
 		}
 	}
 
+	switch {
+	case *policySetLegalHold == "":
+	case *policySetLegalHold == inheritPolicyString:
+		*changeCount++
+
+		rp.LegalHold = nil
+
+		log(ctx).Infof(" - inherit legal hold from parent\n")
+
+	default:
+		val, err := strconv.ParseBool(*policySetLegalHold)
+		if err != nil {
+			return err
+		}
+
+		*changeCount++
+
+		rp.LegalHold = &val
+
+		log(ctx).Infof(" - setting legal hold to %v\n", val)
+	}
+
 	return nil
 }
 
@@ -297,6 +393,16 @@ func setSchedulingPolicyFromFlags(ctx context.Context, sp *policy.SchedulingPoli
 		break
 	}
 
+	// It's not really a list, just optional value.
+	for _, rpo := range *policySetRPO {
+		*changeCount++
+
+		sp.SetMaxSnapshotAge(rpo)
+		log(ctx).Infof(" - setting recovery point objective to %v\n", sp.MaxSnapshotAge())
+
+		break
+	}
+
 	if len(*policySetTimesOfDay) > 0 {
 		var timesOfDay []policy.TimeOfDay
 
@@ -326,9 +432,197 @@ func setSchedulingPolicyFromFlags(ctx context.Context, sp *policy.SchedulingPoli
 		}
 	}
 
+	if err := applyPolicyNumber(ctx, "minimum battery percentage", &sp.MinBatteryPercent, *policySetMinBatteryPercent, changeCount); err != nil {
+		return err
+	}
+
+	switch {
+	case *policySetSkipOnMeteredConnection == "":
+	case *policySetSkipOnMeteredConnection == inheritPolicyString:
+		*changeCount++
+
+		sp.SkipOnMeteredConnection = nil
+
+		log(ctx).Infof(" - inherit skipping snapshots on metered connection from parent\n")
+
+	default:
+		val, err := strconv.ParseBool(*policySetSkipOnMeteredConnection)
+		if err != nil {
+			return err
+		}
+
+		*changeCount++
+
+		sp.SkipOnMeteredConnection = &val
+
+		log(ctx).Infof(" - setting skip snapshots on metered connection to %v\n", val)
+	}
+
+	if *policySetClearGroups {
+		*changeCount++
+
+		sp.Groups = nil
+
+		log(ctx).Infof(" - removing source from all groups\n")
+	} else {
+		sp.Groups = addRemoveDedupeAndSort(ctx, "source groups", sp.Groups, *policySetAddGroup, *policySetRemoveGroup, changeCount)
+	}
+
 	return nil
 }
 
+func setUploadPolicyFromFlags(ctx context.Context, up *policy.UploadPolicy, changeCount *int) error {
+	if err := applyPolicyNumber(ctx, "maximum number of files read in parallel", &up.MaxParallelFileReads, *policySetMaxParallelFileReads, changeCount); err != nil {
+		return err
+	}
+
+	if err := applyPolicyNumber(ctx, "CPU niceness", &up.CPUNiceness, *policySetCPUNiceness, changeCount); err != nil {
+		return err
+	}
+
+	if err := applyPolicyBool(ctx, "delta upload", &up.DeltaUpload, *policySetDeltaUpload, changeCount); err != nil {
+		return err
+	}
+
+	if err := (ospriority.Settings{Niceness: up.CPUNicenessOrDefault(0)}).Validate(); err != nil {
+		return err
+	}
+
+	if v := *policySetIOPriority; v != "" {
+		*changeCount++
+
+		if v == inheritPolicyString {
+			log(ctx).Infof(" - resetting IO priority to default value inherited from parent\n")
+
+			up.IOPriority = ""
+		} else {
+			log(ctx).Infof(" - setting IO priority to %v\n", v)
+
+			up.IOPriority = v
+		}
+	}
+
+	if v := *policySetUploadHints; v != "" {
+		*changeCount++
+
+		if v == inheritPolicyString {
+			log(ctx).Infof(" - resetting upload hints to default value inherited from parent\n")
+
+			up.UploadHints = ""
+		} else {
+			log(ctx).Infof(" - setting upload hints to %v\n", v)
+
+			up.UploadHints = v
+		}
+	}
+
+	if err := applyPolicyBytesPtr(ctx, "maximum upload speed", &up.MaxUploadBytesPerSecond, *policySetMaxUploadSpeed, changeCount); err != nil {
+		return err
+	}
+
+	return setBandwidthScheduleFromFlags(ctx, up, changeCount)
+}
+
+func setBandwidthScheduleFromFlags(ctx context.Context, up *policy.UploadPolicy, changeCount *int) error {
+	if len(*policySetBandwidthWindows) == 0 {
+		return nil
+	}
+
+	*changeCount++
+
+	var schedule []policy.BandwidthWindow
+
+	for _, s := range *policySetBandwidthWindows {
+		if s == inheritPolicyString {
+			log(ctx).Infof(" - resetting bandwidth schedule to default value inherited from parent\n")
+
+			up.BandwidthSchedule = nil
+
+			return nil
+		}
+
+		var w policy.BandwidthWindow
+		if err := w.Parse(s); err != nil {
+			return errors.Wrapf(err, "unable to parse bandwidth window %q", s)
+		}
+
+		log(ctx).Infof(" - adding bandwidth window %v\n", w)
+
+		schedule = append(schedule, w)
+	}
+
+	up.BandwidthSchedule = schedule
+
+	return nil
+}
+
+func setApplicationPolicyFromFlags(ctx context.Context, ap *policy.ApplicationPolicy, changeCount *int) error {
+	switch v := *policySetAppRecipe; v {
+	case "":
+	case inheritPolicyString:
+		*changeCount++
+
+		log(ctx).Infof(" - resetting application recipe to default value inherited from parent\n")
+
+		ap.Recipe = ""
+		ap.Args = nil
+	default:
+		*changeCount++
+
+		log(ctx).Infof(" - setting application recipe to %v\n", v)
+
+		ap.Recipe = v
+	}
+
+	if len(*policySetAppRecipeArgs) > 0 {
+		if ap.Recipe == "" {
+			return errors.New("recipe arguments require an application recipe")
+		}
+
+		*changeCount++
+
+		log(ctx).Infof(" - setting application recipe arguments to %v\n", *policySetAppRecipeArgs)
+
+		ap.Args = *policySetAppRecipeArgs
+	}
+
+	return nil
+}
+
+func setAnomalyPolicyFromFlags(ctx context.Context, ap *policy.AnomalyPolicy, changeCount *int) error {
+	if err := applyPolicyBool(ctx, "anomaly detection", &ap.Detect, *policySetDetectAnomalies, changeCount); err != nil {
+		return err
+	}
+
+	cases := []struct {
+		desc string
+		max  **int
+		flag string
+	}{
+		{"maximum file count drop percentage", &ap.MaxFileCountDropPercent, *policySetMaxFileCountDropPercent},
+		{"maximum size drop percentage", &ap.MaxSizeDropPercent, *policySetMaxSizeDropPercent},
+		{"maximum changed files percentage", &ap.MaxChangedFilesPercent, *policySetMaxChangedFilesPercent},
+		{"maximum high entropy files increase percentage", &ap.MaxHighEntropyIncreasePercent, *policySetMaxHighEntropyIncreasePercent},
+		{"maximum extension change percentage", &ap.MaxExtensionChangePercent, *policySetMaxExtensionChangePercent},
+	}
+
+	for _, c := range cases {
+		if err := applyPolicyNumber(ctx, c.desc, c.max, c.flag, changeCount); err != nil {
+			return err
+		}
+
+		if *c.max != nil && (**c.max < 0 || **c.max > 100) {
+			return errors.Errorf("%v must be between 0 and 100", c.desc)
+		}
+	}
+
+	if err := applyPolicyBool(ctx, "anomaly expiration safeguard", &ap.PreventExpiration, *policySetAnomalyPreventExpiration, changeCount); err != nil {
+		return err
+	}
+
+	return applyPolicyBool(ctx, "pause expiration on ransomware", &ap.PauseExpirationOnRansomware, *policySetPauseExpirationOnRansomware, changeCount)
+}
+
 func setCompressionPolicyFromFlags(ctx context.Context, p *policy.CompressionPolicy, changeCount *int) error {
 	if err := applyPolicyNumber64(ctx, "minimum file size subject to compression", &p.MinSize, *policySetCompressionMinSize, changeCount); err != nil {
 		return errors.Wrap(err, "minimum file size subject to compression")
@@ -438,6 +732,35 @@ func applyPolicyNumber(ctx context.Context, desc string, val **int, str string,
 	return nil
 }
 
+func applyPolicyBool(ctx context.Context, desc string, val **bool, str string, changeCount *int) error {
+	switch str {
+	case "":
+		// not changed
+		return nil
+
+	case inheritPolicyString:
+		*changeCount++
+
+		log(ctx).Infof(" - resetting %v to a default value inherited from parent.\n", desc)
+
+		*val = nil
+
+		return nil
+	}
+
+	v, err := strconv.ParseBool(str)
+	if err != nil {
+		return errors.Wrapf(err, "can't parse the %v %q", desc, str)
+	}
+
+	*changeCount++
+
+	log(ctx).Infof(" - setting %v to %v.\n", desc, v)
+	*val = &v
+
+	return nil
+}
+
 func applyPolicyNumber64(ctx context.Context, desc string, val *int64, str string, changeCount *int) error {
 	if str == "" {
 		// not changed
@@ -467,12 +790,47 @@ func applyPolicyNumber64(ctx context.Context, desc string, val *int64, str strin
 	return nil
 }
 
+func applyPolicyBytesPtr(ctx context.Context, desc string, val **int64, str string, changeCount *int) error {
+	if str == "" {
+		// not changed
+		return nil
+	}
+
+	if str == inheritPolicyString || str == "default" {
+		*changeCount++
+
+		log(ctx).Infof(" - resetting %v to a default value inherited from parent.\n", desc)
+
+		*val = nil
+
+		return nil
+	}
+
+	v, err := byteunits.ParseStrictBytes(str)
+	if err != nil {
+		return errors.Wrapf(err, "can't parse the %v %q", desc, str)
+	}
+
+	if v < 0 {
+		return errors.Errorf("invalid %v %q", desc, str)
+	}
+
+	*changeCount++
+
+	log(ctx).Infof(" - setting %v to %v.\n", desc, v)
+	*val = &v
+
+	return nil
+}
+
 func supportedCompressionAlgorithms() []string {
 	var res []string
 	for name := range compression.ByName {
 		res = append(res, string(name))
 	}
 
+	res = append(res, string(compression.ZstdDictionaryName))
+
 	sort.Strings(res)
 
 	return append([]string{inheritPolicyString, "none"}, res...)
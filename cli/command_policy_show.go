@@ -84,6 +84,12 @@ func printPolicy(p *policy.Policy, parents []*policy.Policy) {
 	printSchedulingPolicy(p, parents)
 	printStdout("\n")
 	printCompressionPolicy(p, parents)
+	printStdout("\n")
+	printUploadPolicy(p, parents)
+	printStdout("\n")
+	printAnomalyPolicy(p, parents)
+	printStdout("\n")
+	printApplicationPolicy(p, parents)
 }
 
 func printRetentionPolicy(p *policy.Policy, parents []*policy.Policy) {
@@ -118,6 +124,11 @@ func printRetentionPolicy(p *policy.Policy, parents []*policy.Policy) {
 		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
 			return pol.RetentionPolicy.KeepLatest != nil
 		}))
+	printStdout("  Legal hold:      %5v           %v\n",
+		p.RetentionPolicy.LegalHoldOrDefault(false),
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.RetentionPolicy.LegalHold != nil
+		}))
 }
 
 func printFilesPolicy(p *policy.Policy, parents []*policy.Policy) {
@@ -166,6 +177,12 @@ func printFilesPolicy(p *policy.Policy, parents []*policy.Policy) {
 		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
 			return pol.FilesPolicy.OneFileSystem != nil
 		}))
+
+	printStdout("  Capture security descriptors:   %5v       %v\n",
+		p.FilesPolicy.SecurityDescriptorsOrDefault(false),
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.FilesPolicy.SecurityDescriptors != nil
+		}))
 }
 
 func printErrorHandlingPolicy(p *policy.Policy, parents []*policy.Policy) {
@@ -197,6 +214,14 @@ func printSchedulingPolicy(p *policy.Policy, parents []*policy.Policy) {
 		any = true
 	}
 
+	if p.SchedulingPolicy.MaxSnapshotAge() != 0 {
+		printStdout("  RPO:                 %10v  %v\n", p.SchedulingPolicy.MaxSnapshotAge(), getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.SchedulingPolicy.MaxSnapshotAge() != 0
+		}))
+
+		any = true
+	}
+
 	if len(p.SchedulingPolicy.TimesOfDay) > 0 {
 		printStdout("  Snapshot times:\n")
 
@@ -216,6 +241,35 @@ func printSchedulingPolicy(p *policy.Policy, parents []*policy.Policy) {
 		any = true
 	}
 
+	if len(p.SchedulingPolicy.Groups) > 0 {
+		printStdout("  Source groups:\n")
+
+		for _, g := range p.SchedulingPolicy.Groups {
+			g := g
+			printStdout("    %-30v %v\n", g, getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+				return pol.SchedulingPolicy.InGroup(g)
+			}))
+		}
+
+		any = true
+	}
+
+	if v := p.SchedulingPolicy.MinBatteryPercentOrDefault(0); v > 0 {
+		printStdout("  Min battery percent: %9v%%  %v\n", v, getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.SchedulingPolicy.MinBatteryPercent != nil
+		}))
+
+		any = true
+	}
+
+	if p.SchedulingPolicy.SkipOnMeteredConnectionOrDefault(false) {
+		printStdout("  Skip on metered connection:      %v\n", getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.SchedulingPolicy.SkipOnMeteredConnection != nil
+		}))
+
+		any = true
+	}
+
 	if !any {
 		printStdout("  None\n")
 	}
@@ -269,6 +323,141 @@ func printCompressionPolicy(p *policy.Policy, parents []*policy.Policy) {
 	}
 }
 
+func printUploadPolicy(p *policy.Policy, parents []*policy.Policy) {
+	printStdout("Upload:\n")
+
+	printStdout("  Max parallel file reads: %5v       %v\n",
+		valueOrNotSet(p.UploadPolicy.MaxParallelFileReads),
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.UploadPolicy.MaxParallelFileReads != nil
+		}))
+
+	printStdout("  CPU niceness:            %5v       %v\n",
+		p.UploadPolicy.CPUNicenessOrDefault(0),
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.UploadPolicy.CPUNiceness != nil
+		}))
+
+	printStdout("  IO priority:             %6v      %v\n",
+		p.UploadPolicy.IOPriority,
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.UploadPolicy.IOPriority != ""
+		}))
+
+	printStdout("  Delta upload:            %5v       %v\n",
+		p.UploadPolicy.DeltaUploadOrDefault(false),
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.UploadPolicy.DeltaUpload != nil
+		}))
+
+	printStdout("  Upload hints:        %10v      %v\n",
+		p.UploadPolicy.UploadHints,
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.UploadPolicy.UploadHints != ""
+		}))
+
+	printStdout("  Max upload speed:    %10v      %v\n",
+		uploadSpeedString(p.UploadPolicy.MaxUploadBytesPerSecondOrDefault(0)),
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.UploadPolicy.MaxUploadBytesPerSecond != nil
+		}))
+
+	if len(p.UploadPolicy.BandwidthSchedule) == 0 {
+		printStdout("  Bandwidth schedule:   unlimited\n")
+		return
+	}
+
+	printStdout("  Bandwidth schedule:                  %v\n",
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.UploadPolicy.BandwidthSchedule != nil
+		}))
+
+	for _, w := range p.UploadPolicy.BandwidthSchedule {
+		printStdout("    %v\n", w)
+	}
+
+	printStdout("    unlimited outside of the above\n")
+}
+
+func uploadSpeedString(bytesPerSecond int64) string {
+	if bytesPerSecond <= 0 {
+		return "unlimited"
+	}
+
+	return units.BytesStringBase10(bytesPerSecond) + "/s"
+}
+
+func printAnomalyPolicy(p *policy.Policy, parents []*policy.Policy) {
+	printStdout("Anomaly detection:\n")
+
+	printStdout("  Detect anomalies:        %5v       %v\n",
+		p.AnomalyPolicy.DetectOrDefault(false),
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.AnomalyPolicy.Detect != nil
+		}))
+
+	printStdout("  Max file count drop:     %4v%%       %v\n",
+		valueOrNotSet(p.AnomalyPolicy.MaxFileCountDropPercent),
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.AnomalyPolicy.MaxFileCountDropPercent != nil
+		}))
+
+	printStdout("  Max size drop:           %4v%%       %v\n",
+		valueOrNotSet(p.AnomalyPolicy.MaxSizeDropPercent),
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.AnomalyPolicy.MaxSizeDropPercent != nil
+		}))
+
+	printStdout("  Max changed files:       %4v%%       %v\n",
+		valueOrNotSet(p.AnomalyPolicy.MaxChangedFilesPercent),
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.AnomalyPolicy.MaxChangedFilesPercent != nil
+		}))
+
+	printStdout("  Max entropy increase:    %4v%%       %v\n",
+		valueOrNotSet(p.AnomalyPolicy.MaxHighEntropyIncreasePercent),
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.AnomalyPolicy.MaxHighEntropyIncreasePercent != nil
+		}))
+
+	printStdout("  Max extension change:    %4v%%       %v\n",
+		valueOrNotSet(p.AnomalyPolicy.MaxExtensionChangePercent),
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.AnomalyPolicy.MaxExtensionChangePercent != nil
+		}))
+
+	printStdout("  Prevent expiration:      %5v       %v\n",
+		p.AnomalyPolicy.PreventExpirationOrDefault(false),
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.AnomalyPolicy.PreventExpiration != nil
+		}))
+
+	printStdout("  Pause on ransomware:     %5v       %v\n",
+		p.AnomalyPolicy.PauseExpirationOnRansomwareOrDefault(true),
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.AnomalyPolicy.PauseExpirationOnRansomware != nil
+		}))
+}
+
+func printApplicationPolicy(p *policy.Policy, parents []*policy.Policy) {
+	printStdout("Application-consistent snapshots:\n")
+
+	if p.ApplicationPolicy.Recipe == "" {
+		printStdout("  Recipe:                   none\n")
+		return
+	}
+
+	printStdout("  Recipe:               %9v       %v\n",
+		p.ApplicationPolicy.Recipe,
+		getDefinitionPoint(parents, func(pol *policy.Policy) bool {
+			return pol.ApplicationPolicy.Recipe != ""
+		}))
+
+	for _, a := range p.ApplicationPolicy.Args {
+		printStdout("    %v\n", a)
+	}
+}
+
 func valueOrNotSet(p *int) string {
 	if p == nil {
 		return "-"
@@ -2,6 +2,7 @@
 
 import (
 	"context"
+	"path/filepath"
 	"time"
 
 	"github.com/alecthomas/kingpin"
@@ -9,6 +10,9 @@
 
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/namespace"
+	"github.com/kopia/kopia/repo/blob/probe"
+	"github.com/kopia/kopia/repo/blob/staging"
 	"github.com/kopia/kopia/repo/content"
 )
 
@@ -19,11 +23,27 @@
 	connectMaxCacheSizeMB         int64
 	connectMaxMetadataCacheSizeMB int64
 	connectMaxListCacheDuration   time.Duration
+	connectMergeIndexes           bool
+	connectLazyIndexLoading       bool
+	connectReadAheadMB            int64
+	connectSharedIndexCache       bool
+	connectIndexMmap              bool
+	connectPrefetch               bool
 	connectHostname               string
 	connectUsername               string
 	connectCheckForUpdates        bool
 	connectReadonly               bool
 	connectDescription            string
+	connectNamespace              string
+	connectStorageProbe           string
+	connectStagingDirectory       string
+	connectBlockCacheFile         string
+)
+
+const (
+	storageProbeNone = "none"
+	storageProbeWarn = "warn"
+	storageProbeFail = "fail"
 )
 
 func setupConnectOptions(cmd *kingpin.CmdClause) {
@@ -34,21 +54,105 @@ func setupConnectOptions(cmd *kingpin.CmdClause) {
 	cmd.Flag("content-cache-size-mb", "Size of local content cache").PlaceHolder("MB").Default("5000").Int64Var(&connectMaxCacheSizeMB)
 	cmd.Flag("metadata-cache-size-mb", "Size of local metadata cache").PlaceHolder("MB").Default("5000").Int64Var(&connectMaxMetadataCacheSizeMB)
 	cmd.Flag("max-list-cache-duration", "Duration of index cache").Default("600s").Hidden().DurationVar(&connectMaxListCacheDuration)
+	cmd.Flag("merge-indexes", "Merge cached indexes into a single file to speed up lookups").BoolVar(&connectMergeIndexes)
+	cmd.Flag("lazy-index-loading", "Download indexes on demand instead of when opening the repository, which speeds up opening large repositories with cold cache").BoolVar(&connectLazyIndexLoading)
+	cmd.Flag("read-ahead-mb", "Amount of data read ahead in the background when contents of a pack are read sequentially, which speeds up restores from high-latency storage (0=disabled)").PlaceHolder("MB").Int64Var(&connectReadAheadMB)
+	cmd.Flag("shared-index-cache", "Share cached indexes with other kopia processes of the current user connected to the same repository, such as the server").BoolVar(&connectSharedIndexCache)
+	cmd.Flag("index-mmap", "Access cached indexes using memory-mapped files, disable on network filesystems or emulated environments where mmap is unreliable").Default("true").Envar("KOPIA_INDEX_MMAP").BoolVar(&connectIndexMmap)
+	cmd.Flag("prefetch", "Prefetch indexes, manifests and recent metadata into local cache in the background after connecting").BoolVar(&connectPrefetch)
 	cmd.Flag("override-hostname", "Override hostname used by this repository connection").Hidden().StringVar(&connectHostname)
 	cmd.Flag("override-username", "Override username used by this repository connection").Hidden().StringVar(&connectUsername)
 	cmd.Flag("check-for-updates", "Periodically check for Kopia updates on GitHub").Default("true").Envar(checkForUpdatesEnvar).BoolVar(&connectCheckForUpdates)
 	cmd.Flag("readonly", "Make repository read-only to avoid accidental changes").BoolVar(&connectReadonly)
 	cmd.Flag("description", "Human-readable description of the repository").StringVar(&connectDescription)
+	cmd.Flag("namespace", "Namespace of the repository, which allows multiple repositories to share the same storage location").StringVar(&connectNamespace)
+	cmd.Flag("staging-directory", "Stage written blobs in a local directory and upload them in the background, which allows snapshots to complete while connectivity to the storage is intermittent. Only pack blobs are staged. The directory can be used by only one process at a time, other processes write directly to the storage.").PlaceHolder("PATH").StringVar(&connectStagingDirectory)
+	cmd.Flag("block-cache-file", "Store the content cache in a preallocated file or raw block device accessed using direct IO instead of individual files in the cache directory").PlaceHolder("PATH").StringVar(&connectBlockCacheFile)
+	cmd.Flag("storage-probe", "Verify that the storage provides consistency guarantees required by the repository by writing, listing, reading and deleting a temporary blob, and warn or fail if it doesn't").Default(storageProbeNone).EnumVar(&connectStorageProbe, storageProbeNone, storageProbeWarn, storageProbeFail)
+}
+
+// probeStorage runs the storage consistency probe requested using --storage-probe, if any.
+func probeStorage(ctx context.Context, st blob.Storage) error {
+	if connectStorageProbe == storageProbeNone {
+		return nil
+	}
+
+	log(ctx).Infof("Probing storage consistency...")
+
+	res, err := probe.Run(ctx, st, probe.Options{})
+	if err != nil {
+		return errors.Wrap(err, "unable to probe storage")
+	}
+
+	for _, w := range res.Warnings {
+		log(ctx).Warningf("Storage probe: %v", w)
+	}
+
+	for _, f := range res.Failures {
+		log(ctx).Errorf("Storage probe: %v", f)
+	}
+
+	if !res.ConditionalWrites {
+		log(ctx).Infof("Storage does not support conditional writes.")
+	}
+
+	if res.OK() {
+		log(ctx).Infof("Storage provides required consistency guarantees.")
+		return nil
+	}
+
+	if connectStorageProbe == storageProbeFail {
+		return res.Err()
+	}
+
+	log(ctx).Warningf("The storage does not provide consistency guarantees required by the repository, which may lead to data loss.")
+
+	return nil
+}
+
+// storageInNamespace returns the storage wrapped in the namespace specified using --namespace, if any.
+func storageInNamespace(st blob.Storage) (blob.Storage, error) {
+	if connectNamespace == "" {
+		return st, nil
+	}
+
+	return namespace.NewWrapper(st, connectNamespace)
 }
 
 func connectOptions() *repo.ConnectOptions {
+	var sharedIndexCacheDirectory string
+
+	if connectSharedIndexCache {
+		// when the user cache directory can't be determined, indexes are cached in the cache directory of the connection.
+		sharedIndexCacheDirectory, _ = repo.DefaultSharedIndexCacheDirectory()
+	}
+
+	var stagingOptions *staging.Options
+
+	if connectStagingDirectory != "" {
+		dir, err := filepath.Abs(connectStagingDirectory)
+		if err != nil {
+			dir = connectStagingDirectory
+		}
+
+		stagingOptions = &staging.Options{Directory: dir}
+	}
+
 	return &repo.ConnectOptions{
+		Staging:            stagingOptions,
 		PersistCredentials: connectPersistCredentials,
+		Prefetch:           connectPrefetch,
 		CachingOptions: content.CachingOptions{
 			CacheDirectory:            connectCacheDirectory,
 			MaxCacheSizeBytes:         connectMaxCacheSizeMB << 20,         //nolint:gomnd
 			MaxMetadataCacheSizeBytes: connectMaxMetadataCacheSizeMB << 20, //nolint:gomnd
 			MaxListCacheDurationSec:   int(connectMaxListCacheDuration.Seconds()),
+			MergeCommittedIndexes:     connectMergeIndexes,
+			LazyIndexLoading:          connectLazyIndexLoading,
+			ReadAheadBytes:            connectReadAheadMB << 20, //nolint:gomnd
+			SharedIndexCacheDirectory: sharedIndexCacheDirectory,
+			DisableIndexMmap:          !connectIndexMmap,
+			BlockCacheFile:            connectBlockCacheFile,
 		},
 		ClientOptions: repo.ClientOptions{
 			Hostname:    connectHostname,
@@ -64,6 +168,15 @@ func init() {
 }
 
 func runConnectCommandWithStorage(ctx context.Context, st blob.Storage) error {
+	st, err := storageInNamespace(st)
+	if err != nil {
+		return err
+	}
+
+	if err := probeStorage(ctx, st); err != nil {
+		return err
+	}
+
 	password, err := getPasswordFromFlags(ctx, false, false)
 	if err != nil {
 		return errors.Wrap(err, "getting password")
@@ -14,6 +14,7 @@
 
 	connectAPIServerURL             = connectAPIServerCommand.Flag("url", "Server URL").Required().String()
 	connectAPIServerCertFingerprint = connectAPIServerCommand.Flag("server-cert-fingerprint", "Server certificate fingerprint").String()
+	connectAPIServerStandbyURLs     = connectAPIServerCommand.Flag("standby-url", "URL of a read-only standby server used for restores and browsing when the server is down (can be repeated)").Strings()
 )
 
 func runConnectAPIServerCommand(ctx context.Context) error {
@@ -27,6 +28,10 @@ func runConnectAPIServerCommand(ctx context.Context) error {
 		TrustedServerCertificateFingerprint: strings.ToLower(*connectAPIServerCertFingerprint),
 	}
 
+	for _, u := range *connectAPIServerStandbyURLs {
+		as.StandbyURLs = append(as.StandbyURLs, strings.TrimSuffix(u, "/"))
+	}
+
 	configFile := repositoryConfigFileName()
 	if err := repo.ConnectAPIServer(ctx, configFile, as, password, connectOptions()); err != nil {
 		return err
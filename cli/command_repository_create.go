@@ -7,6 +7,7 @@
 
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/compression"
 	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/repo/encryption"
 	"github.com/kopia/kopia/repo/hashing"
@@ -22,10 +23,20 @@
 	createBlockHashFormat       = createCommand.Flag("block-hash", "Content hash algorithm.").PlaceHolder("ALGO").Default(hashing.DefaultAlgorithm).Enum(hashing.SupportedAlgorithms()...)
 	createBlockEncryptionFormat = createCommand.Flag("encryption", "Content encryption algorithm.").PlaceHolder("ALGO").Default(encryption.DefaultAlgorithm).Enum(encryption.SupportedAlgorithms(false)...)
 	createSplitter              = createCommand.Flag("object-splitter", "The splitter to use for new objects in the repository").Default(splitter.DefaultAlgorithm).Enum(splitter.SupportedAlgorithms()...)
+	createSelectSplitterFrom    = createCommand.Flag("select-splitter-from", "Benchmark splitters on sample data from the provided directory and use the best one instead of --object-splitter").PlaceHolder("PATH").String()
+	createManifestCompression   = createCommand.Flag("manifest-compression", "Compressor to use for manifests instead of gzip (requires newer kopia clients)").PlaceHolder("ALGO").String()
+	createLocalIndexECCBytes    = createCommand.Flag("local-index-ecc", "Number of error correction bytes protecting each 255-byte block of local indexes in packs, even number up to 64 (requires newer kopia clients to recover indexes)").PlaceHolder("N").Int()
+	createRetentionMode         = createCommand.Flag("retention-mode", "Lock pack and index blobs using the provided retention mode (requires storage with object lock enabled, such as S3)").Enum(blob.RetentionModeGovernance, blob.RetentionModeCompliance)
+	createRetentionPeriod       = createCommand.Flag("retention-period", "Period for which pack and index blobs are locked against deletion").Duration()
 
 	createOnly = createCommand.Flag("create-only", "Create repository, but don't connect to it.").Short('c').Bool()
 )
 
+const (
+	splitterSelectionSampleSize = 128 << 20
+	splitterSelectionSeed       = 42
+)
+
 func init() {
 	setupConnectOptions(createCommand)
 }
@@ -33,13 +44,20 @@ func init() {
 func newRepositoryOptionsFromFlags() *repo.NewRepositoryOptions {
 	return &repo.NewRepositoryOptions{
 		BlockFormat: content.FormattingOptions{
-			Hash:       *createBlockHashFormat,
-			Encryption: *createBlockEncryptionFormat,
+			Hash:               *createBlockHashFormat,
+			Encryption:         *createBlockEncryptionFormat,
+			LocalIndexECCBytes: *createLocalIndexECCBytes,
+			RetentionMode:      *createRetentionMode,
+			RetentionPeriod:    *createRetentionPeriod,
 		},
 
 		ObjectFormat: object.Format{
 			Splitter: *createSplitter,
 		},
+
+		ManifestCompression: compression.Name(*createManifestCompression),
+
+		VerifyCriticalWrites: *verifyWrites,
 	}
 }
 
@@ -57,13 +75,36 @@ func ensureEmpty(ctx context.Context, s blob.Storage) error {
 }
 
 func runCreateCommandWithStorage(ctx context.Context, st blob.Storage) error {
-	err := ensureEmpty(ctx, st)
+	// validate options before writing anything to the storage.
+	if err := content.ValidateLocalIndexECCBytes(*createLocalIndexECCBytes); err != nil {
+		return err
+	}
+
+	st, err := storageInNamespace(st)
+	if err != nil {
+		return err
+	}
+
+	err = ensureEmpty(ctx, st)
 	if err != nil {
 		return errors.Wrap(err, "unable to get repository storage")
 	}
 
+	if err := probeStorage(ctx, st); err != nil {
+		return err
+	}
+
 	options := newRepositoryOptionsFromFlags()
 
+	if dir := *createSelectSplitterFrom; dir != "" {
+		samples, err := readSplitterSamples(ctx, dir, splitterSelectionSampleSize)
+		if err != nil {
+			return errors.Wrap(err, "unable to select splitter")
+		}
+
+		options.ObjectFormat.Splitter = selectSplitter(samples, splitterSelectionSeed)
+	}
+
 	password, err := getPasswordFromFlags(ctx, true, false)
 	if err != nil {
 		return errors.Wrap(err, "getting password")
@@ -74,6 +115,10 @@ func runCreateCommandWithStorage(ctx context.Context, st blob.Storage) error {
 	log(ctx).Infof("  encryption:          %v", options.BlockFormat.Encryption)
 	log(ctx).Infof("  splitter:            %v", options.ObjectFormat.Splitter)
 
+	if options.BlockFormat.RetentionMode != "" {
+		log(ctx).Infof("  retention:           %v for %v", options.BlockFormat.RetentionMode, options.BlockFormat.RetentionPeriod)
+	}
+
 	if err := repo.Initialize(ctx, st, options, password); err != nil {
 		return errors.Wrap(err, "cannot initialize repository")
 	}
@@ -105,5 +150,10 @@ func populateRepository(ctx context.Context, password string) error {
 	printStdout("To change the policy use:\n  kopia policy set --global <options>\n")
 	printStdout("or\n  kopia policy set <dir> <options>\n")
 
-	return setDefaultMaintenanceParameters(ctx, rep.(maintenance.MaintainableRepository))
+	if err := setDefaultMaintenanceParameters(ctx, rep.(maintenance.MaintainableRepository)); err != nil {
+		return err
+	}
+
+	// flush explicitly to report storage errors, such as retention not being supported by the storage.
+	return errors.Wrap(rep.Flush(ctx), "unable to write initial repository contents")
 }
@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/snapshot/snapshotfsck"
+)
+
+// Exit codes of 'repository fsck', suitable for monitoring systems.
+const (
+	fsckExitCodeWarning  = 2
+	fsckExitCodeError    = 3
+	fsckExitCodeCritical = 4
+)
+
+var (
+	repositoryFsckCommand = repositoryCommands.Command("fsck", "Check consistency of blobs, indexes, objects and manifests. "+
+		"Exits with code 0 if no problems were found, 2 on warnings, 3 on errors and 4 if some snapshot data can't be restored.")
+	repositoryFsckLevel       = repositoryFsckCommand.Flag("level", "Check level (quick, standard, full)").Default(string(snapshotfsck.LevelStandard)).Enum(fsckLevels()...)
+	repositoryFsckParallel    = repositoryFsckCommand.Flag("parallel", "Parallelism").Default("16").Int()
+	repositoryFsckJSON        = repositoryFsckCommand.Flag("json", "Output report as JSON").Bool()
+	repositoryFsckMaxFindings = repositoryFsckCommand.Flag("max-findings", "Maximum number of findings to print (0=unlimited)").Default("100").Int()
+)
+
+func fsckLevels() []string {
+	var result []string
+
+	for _, l := range snapshotfsck.Levels {
+		result = append(result, string(l))
+	}
+
+	return result
+}
+
+func runRepositoryFsckCommand(ctx context.Context, rep *repo.DirectRepository) error {
+	report, err := snapshotfsck.Run(ctx, rep, snapshotfsck.Options{
+		Level:    snapshotfsck.Level(*repositoryFsckLevel),
+		Parallel: *repositoryFsckParallel,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *repositoryFsckJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+
+		if err := e.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		printFsckReport(report)
+	}
+
+	switch report.MaxSeverity() {
+	case snapshotfsck.SeverityCritical:
+		exitCode = fsckExitCodeCritical
+	case snapshotfsck.SeverityError:
+		exitCode = fsckExitCodeError
+	case snapshotfsck.SeverityWarning:
+		exitCode = fsckExitCodeWarning
+	}
+
+	return nil
+}
+
+func printFsckReport(report *snapshotfsck.Report) {
+	for i, f := range report.Findings {
+		if *repositoryFsckMaxFindings > 0 && i >= *repositoryFsckMaxFindings {
+			printStdout("... and %v more findings\n", len(report.Findings)-i)
+			break
+		}
+
+		printStdout("%-8v %-8v %v: %v\n", strings.ToUpper(f.Severity.String()), f.Layer, f.Subject, f.Message)
+	}
+
+	var checked []string
+
+	for k, v := range report.Checked {
+		checked = append(checked, fmt.Sprintf("%v:%v", k, v))
+	}
+
+	sort.Strings(checked)
+
+	if len(report.Findings) > 0 {
+		printStdout("\n")
+	}
+
+	printStdout("Checked (%v level) %v in %v.\n", report.Level, strings.Join(checked, " "), report.EndTime.Sub(report.StartTime).Truncate(time.Millisecond))
+	printStdout("Found %v critical, %v errors, %v warnings, %v informational.\n",
+		report.CountBySeverity(snapshotfsck.SeverityCritical),
+		report.CountBySeverity(snapshotfsck.SeverityError),
+		report.CountBySeverity(snapshotfsck.SeverityWarning),
+		report.CountBySeverity(snapshotfsck.SeverityInfo))
+}
+
+func init() {
+	repositoryFsckCommand.Action(directRepositoryAction(runRepositoryFsckCommand))
+}
@@ -5,7 +5,6 @@
 
 	"github.com/pkg/errors"
 
-	"github.com/kopia/kopia/internal/gather"
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/repo/blob"
 	"github.com/kopia/kopia/repo/content"
@@ -42,11 +41,17 @@ func maybeRecoverFormatBlob(ctx context.Context, st blob.Storage) error {
 	case "auto":
 		log(ctx).Infof("looking for format blob...")
 
-		if _, err := st.GetBlob(ctx, repo.FormatBlobID, 0, -1); err == nil {
-			log(ctx).Infof("format blob already exists, not recovering, pass --recover-format=yes")
+		// the format blob and its replicas are repaired from the newest valid copy.
+		err := repo.RepairFormatBlobCopies(ctx, st, *repairDryDrun)
+		if err == nil {
+			log(ctx).Infof("format blob already exists, not recovering from pack blobs, pass --recover-format=yes")
 			return nil
 		}
 
+		if !errors.Is(err, blob.ErrBlobNotFound) {
+			return errors.Wrap(err, "unable to repair format blob")
+		}
+
 	case "no":
 		return nil
 	}
@@ -67,8 +72,8 @@ func recoverFormatBlob(ctx context.Context, st blob.Storage, prefixes []string)
 			log(ctx).Infof("looking for replica of format blob in %v...", bi.BlobID)
 			if b, err := repo.RecoverFormatBlob(ctx, st, bi.BlobID, bi.Length); err == nil {
 				if !*repairDryDrun {
-					if puterr := st.PutBlob(ctx, repo.FormatBlobID, gather.FromSlice(b)); puterr != nil {
-						return puterr
+					if puterr := repo.WriteRecoveredFormatBlob(ctx, st, b); puterr != nil {
+						return errors.Wrap(puterr, "unable to write recovered format blob")
 					}
 				}
 
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/kopia/kopia/internal/units"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/replication"
+)
+
+var (
+	repositoryReplicateCommand          = repositoryCommands.Command("replicate", "Incrementally replicates blobs of this repository to another storage")
+	repositoryReplicateParallel         = repositoryReplicateCommand.Flag("parallel", "Copy parallelism.").Default("4").Int()
+	repositoryReplicateDelete           = repositoryReplicateCommand.Flag("delete", "Delete previously replicated blobs which no longer exist in the source.").Bool()
+	repositoryReplicateDryRun           = repositoryReplicateCommand.Flag("dry-run", "Do not perform copying.").Short('n').Bool()
+	repositoryReplicateVerify           = repositoryReplicateCommand.Flag("verify", "Verification of copied blobs").Default(replication.VerifyLength).Enum(replication.VerifyNone, replication.VerifyLength, replication.VerifyFull)
+	repositoryReplicateStateFile        = repositoryReplicateCommand.Flag("state-file", "File in which the replication state is persisted (defaults to a file next to the repository configuration)").String()
+	repositoryReplicateMaxUploadSpeed   = repositoryReplicateCommand.Flag("upload-limit", "Maximum upload speed to the destination per second (0=unlimited)").Default("0").Bytes()
+	repositoryReplicateMaxDownloadSpeed = repositoryReplicateCommand.Flag("download-limit", "Maximum download speed from the source per second (0=unlimited)").Default("0").Bytes()
+)
+
+func defaultReplicationStateFile(dst blob.Storage) string {
+	return filepath.Join(repositoryConfigFileName()+".replication", replication.DestinationID(dst)+".json")
+}
+
+func runReplicateWithStorage(ctx context.Context, src, dst blob.Storage) error {
+	log(ctx).Infof("Replicating repository:")
+	log(ctx).Infof("  Source:      %v", src.DisplayName())
+	log(ctx).Infof("  Destination: %v", dst.DisplayName())
+
+	stateFile := *repositoryReplicateStateFile
+	if stateFile == "" {
+		stateFile = defaultReplicationStateFile(dst)
+	}
+
+	beginSyncProgress()
+
+	s, err := replication.Replicate(ctx, src, dst, replication.Options{
+		StateFile:                 stateFile,
+		Parallel:                  *repositoryReplicateParallel,
+		Delete:                    *repositoryReplicateDelete,
+		Verify:                    *repositoryReplicateVerify,
+		DryRun:                    *repositoryReplicateDryRun,
+		MaxUploadBytesPerSecond:   int64(*repositoryReplicateMaxUploadSpeed),
+		MaxDownloadBytesPerSecond: int64(*repositoryReplicateMaxDownloadSpeed),
+		Progress: func(s replication.Stats) {
+			outputSyncProgress(fmt.Sprintf("  Copied %v/%v blobs (%v/%v), deleted %v/%v.",
+				s.CopiedBlobs, s.ToCopyBlobs,
+				units.BytesStringBase10(s.CopiedBytes), units.BytesStringBase10(s.ToCopyBytes),
+				s.DeletedBlobs, s.ToDelete))
+		},
+	})
+
+	if s != nil && s.CopiedBlobs+s.DeletedBlobs > 0 {
+		finishSyncProcess()
+	}
+
+	if err != nil {
+		return err // nolint:wrapcheck
+	}
+
+	log(ctx).Infof("Found %v BLOBs in the source (%v), %v already replicated, %v to copy (%v), %v to delete.",
+		s.SourceBlobs, units.BytesStringBase10(s.SourceBytes),
+		s.InSyncBlobs, s.ToCopyBlobs, units.BytesStringBase10(s.ToCopyBytes), s.ToDelete)
+
+	if *repositoryReplicateDryRun {
+		return nil
+	}
+
+	log(ctx).Infof("Replication finished: copied %v BLOBs (%v), deleted %v.",
+		s.CopiedBlobs, units.BytesStringBase10(s.CopiedBytes), s.DeletedBlobs)
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/units"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob/staging"
+)
+
+var (
+	stagingCommands = repositoryCommands.Command("staging", "Commands to manage blobs staged locally for upload.")
+
+	stagingStatusCommand = stagingCommands.Command("status", "Display the status of staged uploads.")
+	stagingStatusJSON    = stagingStatusCommand.Flag("json", "Show raw JSON data").Short('j').Bool()
+
+	stagingFlushCommand = stagingCommands.Command("flush", "Wait until all staged blobs have been uploaded to the storage.").Alias("sync")
+	stagingFlushTimeout = stagingFlushCommand.Flag("timeout", "Maximum time to wait for uploads to complete (0 waits indefinitely)").Default("0").Duration()
+)
+
+func stagerOf(rep *repo.DirectRepository) (staging.Stager, error) {
+	s := rep.Stager()
+	if s == nil {
+		return nil, errors.New("repository was not connected with a staging directory, use 'kopia repository connect --staging-directory'")
+	}
+
+	return s, nil
+}
+
+func runStagingStatusCommand(ctx context.Context, rep *repo.DirectRepository) error {
+	s, err := stagerOf(rep)
+	if err != nil {
+		return err
+	}
+
+	st := s.Status()
+
+	if *stagingStatusJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+
+		return e.Encode(st)
+	}
+
+	printStdout("Pending operations: %v\n", st.PendingOperations)
+	printStdout("Pending bytes:      %v\n", units.BytesStringBase10(st.PendingBytes))
+
+	if st.LastError != "" {
+		printStdout("Last error:         %v (%v)\n", st.LastError, formatTimestamp(st.LastErrorTime))
+	}
+
+	return nil
+}
+
+func runStagingFlushCommand(ctx context.Context, rep *repo.DirectRepository) error {
+	s, err := stagerOf(rep)
+	if err != nil {
+		return err
+	}
+
+	// contents written by this process are staged only when the repository is flushed.
+	if err := rep.Flush(ctx); err != nil {
+		return errors.Wrap(err, "unable to flush repository")
+	}
+
+	if n := s.Status().PendingOperations; n > 0 {
+		log(ctx).Infof("Uploading %v staged operations...", n)
+	}
+
+	if *stagingFlushTimeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, *stagingFlushTimeout)
+		defer cancel()
+	}
+
+	t0 := clock.Now()
+
+	if err := s.Flush(ctx); err != nil {
+		return withCategory(ErrorCategoryStorageUnreachable, err)
+	}
+
+	log(ctx).Infof("All staged blobs uploaded in %v.", clock.Since(t0).Truncate(time.Millisecond))
+
+	return nil
+}
+
+func init() {
+	stagingStatusCommand.Action(directRepositoryAction(runStagingStatusCommand))
+	stagingFlushCommand.Action(directRepositoryAction(runStagingFlushCommand))
+}
@@ -48,9 +48,18 @@ func runStatusCommand(ctx context.Context, rep repo.Repository) error {
 	fmt.Printf("Hash:                %v\n", dr.Content.Format.Hash)
 	fmt.Printf("Encryption:          %v\n", dr.Content.Format.Encryption)
 	fmt.Printf("Splitter:            %v\n", dr.Objects.Format.Splitter)
+
+	if mc := dr.Manifests.Compressor(); mc != "" {
+		fmt.Printf("Manifest compressor: %v\n", mc)
+	}
+
 	fmt.Printf("Format version:      %v\n", dr.Content.Format.Version)
 	fmt.Printf("Max pack length:     %v\n", units.BytesStringBase2(int64(dr.Content.Format.MaxPackSize)))
 
+	if f := dr.Content.Format; f.RetentionMode != "" {
+		fmt.Printf("Blob retention:      %v for %v\n", f.RetentionMode, f.RetentionPeriod)
+	}
+
 	if !*statusReconnectToken {
 		return nil
 	}
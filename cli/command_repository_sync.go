@@ -270,7 +270,7 @@ func syncCopyBlob(ctx context.Context, m blob.Metadata, src, dst blob.Storage) e
 		return errors.Wrapf(err, "error reading blob '%v' from source", m.BlobID)
 	}
 
-	if err := dst.PutBlob(ctx, m.BlobID, gather.FromSlice(data)); err != nil {
+	if err := dst.PutBlob(ctx, m.BlobID, gather.FromSlice(data), blob.PutOptions{}); err != nil {
 		return errors.Wrapf(err, "error writing blob '%v' to destination", m.BlobID)
 	}
 
@@ -313,7 +313,7 @@ func ensureRepositoriesHaveSameFormatBlob(ctx context.Context, src, dst blob.Sto
 				return errors.Errorf("destination repository does not have a format blob")
 			}
 
-			return dst.PutBlob(ctx, repo.FormatBlobID, gather.FromSlice(srcData))
+			return dst.PutBlob(ctx, repo.FormatBlobID, gather.FromSlice(srcData), blob.PutOptions{})
 		}
 
 		return errors.Wrap(err, "error reading destination repository format blob")
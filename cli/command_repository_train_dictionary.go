@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/repo/content"
+)
+
+var (
+	trainDictionaryCommand         = repositoryCommands.Command("train-compression-dictionary", "Train zstd dictionary on the contents of the repository, used by the 'zstd-dictionary' compressor. Repositories using dictionaries can't be opened by older versions of kopia.")
+	trainDictionaryPrefix          = trainDictionaryCommand.Flag("prefix", "Prefix of contents to sample, by default contents of files").String()
+	trainDictionaryMaxSizeKB       = trainDictionaryCommand.Flag("max-size-kb", "Maximum size of the dictionary in KB").Default("64").Int()
+	trainDictionaryMaxSamples      = trainDictionaryCommand.Flag("max-samples", "Maximum number of contents to sample").Default("10000").Int()
+	trainDictionaryMaxSampleSizeKB = trainDictionaryCommand.Flag("max-sample-size-kb", "Maximum size of sampled contents in KB, larger contents are skipped").Default("64").Int()
+)
+
+func runTrainDictionaryCommand(ctx context.Context, rep *repo.DirectRepository) error {
+	samples, err := rep.ZstdDictionarySamples(ctx, content.ID(*trainDictionaryPrefix), *trainDictionaryMaxSamples, *trainDictionaryMaxSampleSizeKB<<10)
+	if err != nil {
+		return errors.Wrap(err, "unable to sample contents")
+	}
+
+	log(ctx).Infof("Training dictionary using %v samples...", len(samples))
+
+	d, err := compression.TrainZstdDictionary(samples, *trainDictionaryMaxSizeKB<<10)
+	if err != nil {
+		return errors.Wrap(err, "unable to train dictionary")
+	}
+
+	if err := rep.AddZstdDictionary(ctx, d); err != nil {
+		return errors.Wrap(err, "unable to add dictionary")
+	}
+
+	log(ctx).Infof("Added dictionary %v (%v bytes). Set compression of sources to '%v' to use it.", d.ID, len(d.Data), compression.ZstdDictionaryName)
+
+	return nil
+}
+
+func init() {
+	trainDictionaryCommand.Action(directRepositoryAction(runTrainDictionaryCommand))
+}
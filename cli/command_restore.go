@@ -5,17 +5,25 @@
 	"compress/gzip"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/alecthomas/kingpin"
+	byteunits "github.com/alecthomas/units"
 	"github.com/pkg/errors"
 
+	"github.com/kopia/kopia/fs"
 	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/controlsocket"
+	"github.com/kopia/kopia/internal/ospriority"
 	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/snapshot"
 	"github.com/kopia/kopia/snapshot/restore"
 	"github.com/kopia/kopia/snapshot/snapshotfs"
 )
@@ -40,6 +48,19 @@
 directory named 'sd2'
 
 'restore kffbb7c28ea6c34d6cbe555d1cf80faa9/subdir1/subdir2 sd2'
+
+Instead of the target path, the contents can be restored into an existing directory
+of a running Kubernetes pod or Docker container, which only requires 'tar' to be
+available in the container:
+
+'restore kffbb7c28ea6c34d6cbe555d1cf80faa9 --to-k8s-pod ns/pod:/data'
+'restore kffbb7c28ea6c34d6cbe555d1cf80faa9 --to-docker container:/data'
+
+When the target path is '-', the contents are written to standard output as
+a tar stream (or in the format specified using --output-format), which can be
+piped into other tools:
+
+'restore kffbb7c28ea6c34d6cbe555d1cf80faa9 --output-format=tgz - | ssh host tar xzf -'
 `
 	restoreCommandSourcePathHelp = `Source directory ID/path in the form of a
 directory ID and optionally a sub-directory path. For example,
@@ -48,21 +69,35 @@
 `
 
 	bitsPerByte = 8
+
+	// niceness and IO priority used by 'restore --nice'.
+	restoreNiceNiceness = 10
+	restoreNiceIOClass  = ospriority.IOLow
+
+	// control socket command which adjusts the write speed of restore in progress.
+	restoreControlCmdMaxWriteSpeed = "max-write-speed"
 )
 
 var (
-	restoreCommand                = app.Command("restore", restoreCommandHelp)
-	restoreSourceID               = ""
-	restoreTargetPath             = ""
-	restoreOverwriteDirectories   = true
-	restoreOverwriteFiles         = true
-	restoreConsistentAttributes   = false
-	restoreMode                   = restoreModeAuto
-	restoreParallel               = 8
-	restoreIgnorePermissionErrors = true
-	restoreSkipTimes              = false
-	restoreSkipOwners             = false
-	restoreSkipPermissions        = false
+	restoreCommand                 = app.Command("restore", restoreCommandHelp)
+	restoreSourceID                = ""
+	restoreTargetPath              = ""
+	restoreOverwriteDirectories    = true
+	restoreOverwriteFiles          = true
+	restoreConsistentAttributes    = false
+	restoreMode                    = restoreModeAuto
+	restoreParallel                = 8
+	restoreIgnorePermissionErrors  = true
+	restoreSkipTimes               = false
+	restoreSkipOwners              = false
+	restoreSkipPermissions         = false
+	restoreSkipSecurityDescriptors = false
+	restoreMetadataSidecars        = false
+	restoreMaxWriteSpeed           byteunits.Base2Bytes
+	restoreSyncBatchSize           byteunits.Base2Bytes
+	restoreNice                    = false
+	restoreControlSocket           = ""
+	restoreFromSource              = false
 )
 
 const (
@@ -72,43 +107,75 @@
 	restoreModeZipNoCompress = "zip-nocompress"
 	restoreModeTar           = "tar"
 	restoreModeTgz           = "tgz"
+
+	// restoreTargetStdout is the target path which writes the restored contents to standard output.
+	restoreTargetStdout = "-"
 )
 
 func addRestoreFlags(cmd *kingpin.CmdClause) {
 	cmd.Arg("source", restoreCommandSourcePathHelp).Required().StringVar(&restoreSourceID)
-	cmd.Arg("target-path", "Path of the directory for the contents to be restored").Required().StringVar(&restoreTargetPath)
+	cmd.Flag("from-source", "Restore the latest snapshot of the source specified as a path or user@host:path, such as the virtual path passed to 'snapshot create --stdin-file'").BoolVar(&restoreFromSource)
+	addRestoreOutputFlags(cmd)
+}
+
+// addRestoreOutputFlags adds the target path argument and flags controlling how restored contents are written.
+func addRestoreOutputFlags(cmd *kingpin.CmdClause) {
+	cmd.Arg("target-path", "Path of the directory for the contents to be restored, or '-' to write them to standard output").SetValue(restoreTargetPathValue{&restoreTargetPath})
 	cmd.Flag("overwrite-directories", "Overwrite existing directories").BoolVar(&restoreOverwriteDirectories)
 	cmd.Flag("overwrite-files", "Specifies whether or not to overwrite already existing files").BoolVar(&restoreOverwriteFiles)
 	cmd.Flag("consistent-attributes", "When multiple snapshots match, fail if they have inconsistent attributes").Envar("KOPIA_RESTORE_CONSISTENT_ATTRIBUTES").BoolVar(&restoreConsistentAttributes)
 	cmd.Flag("mode", "Override restore mode").EnumVar(&restoreMode, restoreModeAuto, restoreModeLocal, restoreModeZip, restoreModeZipNoCompress, restoreModeTar, restoreModeTgz)
+	cmd.Flag("output-format", "Format of the archive written to the target path or standard output").EnumVar(&restoreMode, restoreModeZip, restoreModeZipNoCompress, restoreModeTar, restoreModeTgz)
 	cmd.Flag("parallel", "Restore parallelism (1=disable)").IntVar(&restoreParallel)
 	cmd.Flag("skip-owners", "Skip owners during restore").BoolVar(&restoreSkipOwners)
 	cmd.Flag("skip-permissions", "Skip permissions during restore").BoolVar(&restoreSkipPermissions)
 	cmd.Flag("skip-times", "Skip times during restore").BoolVar(&restoreSkipTimes)
+	cmd.Flag("skip-security-descriptors", "Skip Windows security descriptors (ACLs) during restore").BoolVar(&restoreSkipSecurityDescriptors)
 	cmd.Flag("ignore-permission-errors", "Ignore permission errors").BoolVar(&restoreIgnorePermissionErrors)
+	cmd.Flag("max-write-speed", "Maximum speed of writing restored files per second (0=unlimited), can be adjusted using 'kopia restore-control'").BytesVar(&restoreMaxWriteSpeed)
+	cmd.Flag("sync-batch-size", "Sync restored files to stable storage in batches of this size instead of individually (0=sync each file)").BytesVar(&restoreSyncBatchSize)
+	cmd.Flag("nice", "Lower CPU and IO priority of the restore").BoolVar(&restoreNice)
+	cmd.Flag("control-socket", "Path of the socket that allows adjusting restore in progress").StringVar(&restoreControlSocket)
+	addRestoreExecFlags(cmd)
+	cmd.Flag("metadata-sidecars", "Write metadata that can't be restored on this operating system to '"+restore.MetadataSidecarDir+"' (see 'kopia restore-metadata')").BoolVar(&restoreMetadataSidecars)
 }
 
 func restoreOutput(ctx context.Context) (restore.Output, error) {
+	if o, err := restoreExecOutput(ctx); o != nil || err != nil {
+		return o, err
+	}
+
+	if restoreTargetPath == "" {
+		return nil, errors.Errorf("target path must be specified")
+	}
+
 	p, err := filepath.Abs(restoreTargetPath)
 	if err != nil {
 		return nil, err
 	}
 
 	m := detectRestoreMode(ctx, restoreMode)
+	if m == restoreModeLocal && restoreTargetPath == restoreTargetStdout {
+		return nil, errors.Errorf("restoring to standard output requires an archive format")
+	}
+
 	switch m {
 	case restoreModeLocal:
 		return &restore.FilesystemOutput{
-			TargetPath:             p,
-			OverwriteDirectories:   restoreOverwriteDirectories,
-			OverwriteFiles:         restoreOverwriteFiles,
-			IgnorePermissionErrors: restoreIgnorePermissionErrors,
-			SkipOwners:             restoreSkipOwners,
-			SkipPermissions:        restoreSkipPermissions,
-			SkipTimes:              restoreSkipTimes,
+			TargetPath:              p,
+			OverwriteDirectories:    restoreOverwriteDirectories,
+			OverwriteFiles:          restoreOverwriteFiles,
+			IgnorePermissionErrors:  restoreIgnorePermissionErrors,
+			SkipOwners:              restoreSkipOwners,
+			SkipPermissions:         restoreSkipPermissions,
+			SkipTimes:               restoreSkipTimes,
+			SkipSecurityDescriptors: restoreSkipSecurityDescriptors,
+			WriteMetadataSidecars:   restoreMetadataSidecars,
+			SyncBatchSize:           int64(restoreSyncBatchSize),
 		}, nil
 
 	case restoreModeZip, restoreModeZipNoCompress:
-		f, err := os.Create(restoreTargetPath)
+		f, err := createRestoreOutputFile()
 		if err != nil {
 			return nil, errors.Wrap(err, "unable to create output file")
 		}
@@ -121,7 +188,7 @@ func restoreOutput(ctx context.Context) (restore.Output, error) {
 		return restore.NewZipOutput(f, method), nil
 
 	case restoreModeTar:
-		f, err := os.Create(restoreTargetPath)
+		f, err := createRestoreOutputFile()
 		if err != nil {
 			return nil, errors.Wrap(err, "unable to create output file")
 		}
@@ -129,24 +196,71 @@ func restoreOutput(ctx context.Context) (restore.Output, error) {
 		return restore.NewTarOutput(f), nil
 
 	case restoreModeTgz:
-		f, err := os.Create(restoreTargetPath)
+		f, err := createRestoreOutputFile()
 		if err != nil {
 			return nil, errors.Wrap(err, "unable to create output file")
 		}
 
-		return restore.NewTarOutput(gzip.NewWriter(f)), nil
+		return restore.NewTarOutput(gzipFileWriter{gzip.NewWriter(f), f}), nil
 
 	default:
 		return nil, errors.Errorf("unknown mode %v", m)
 	}
 }
 
+// restoreTargetPathValue stores the target path argument, which is needed because kingpin parses '-' as an empty argument.
+type restoreTargetPathValue struct {
+	p *string
+}
+
+func (v restoreTargetPathValue) Set(s string) error {
+	if s == "" {
+		s = restoreTargetStdout
+	}
+
+	*v.p = s
+
+	return nil
+}
+
+func (v restoreTargetPathValue) String() string {
+	return *v.p
+}
+
+// createRestoreOutputFile creates the archive file at the target path or returns standard output.
+func createRestoreOutputFile() (io.WriteCloser, error) {
+	if restoreTargetPath == restoreTargetStdout {
+		return os.Stdout, nil
+	}
+
+	return os.Create(restoreTargetPath)
+}
+
+// gzipFileWriter compresses data written to the underlying file and closes it when closed.
+type gzipFileWriter struct {
+	*gzip.Writer
+	f io.Closer
+}
+
+func (w gzipFileWriter) Close() error {
+	if err := w.Writer.Close(); err != nil {
+		w.f.Close() //nolint:errcheck
+		return errors.Wrap(err, "unable to finish compression")
+	}
+
+	return w.f.Close()
+}
+
 func detectRestoreMode(ctx context.Context, m string) string {
 	if m != "auto" {
 		return m
 	}
 
 	switch {
+	case restoreTargetPath == restoreTargetStdout:
+		log(ctx).Infof("Restoring to a tar stream on standard output...")
+		return restoreModeTar
+
 	case strings.HasSuffix(restoreTargetPath, ".zip"):
 		log(ctx).Infof("Restoring to a zip file (%v)...", restoreTargetPath)
 		return restoreModeZip
@@ -160,7 +274,7 @@ func detectRestoreMode(ctx context.Context, m string) string {
 		return restoreModeTgz
 
 	default:
-		log(ctx).Infof("Restoring to local filesystem (%v) with parallelism=%v...", restoreTargetPath, restoreParallel)
+		log(ctx).Infof("Restoring to local filesystem (%v) with parallelism=%v...", restoreTargetPath, limitParallelism(restoreParallel))
 		return restoreModeLocal
 	}
 }
@@ -170,9 +284,13 @@ func printRestoreStats(ctx context.Context, st restore.Stats) {
 }
 
 func runRestoreCommand(ctx context.Context, rep repo.Repository) error {
-	output, err := restoreOutput(ctx)
-	if err != nil {
-		return errors.Wrap(err, "unable to initialize output")
+	if restoreFromSource {
+		rootEntry, err := latestSnapshotRootOfSource(ctx, rep, restoreSourceID)
+		if err != nil {
+			return err
+		}
+
+		return restoreRootEntry(ctx, rep, rootEntry)
 	}
 
 	rootEntry, err := snapshotfs.FilesystemEntryFromIDWithPath(ctx, rep, restoreSourceID, restoreConsistentAttributes)
@@ -180,10 +298,70 @@ func runRestoreCommand(ctx context.Context, rep repo.Repository) error {
 		return errors.Wrap(err, "unable to get filesystem entry")
 	}
 
+	return restoreRootEntry(ctx, rep, rootEntry)
+}
+
+// latestSnapshotRootOfSource returns the root entry of the latest complete snapshot of the provided source.
+func latestSnapshotRootOfSource(ctx context.Context, rep repo.Repository, source string) (fs.Entry, error) {
+	si, err := snapshot.ParseSourceInfo(source, rep.ClientOptions().Hostname, rep.ClientOptions().Username)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid source %q", source)
+	}
+
+	manifests, err := snapshot.ListSnapshots(ctx, rep, si)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list snapshots of %v", si)
+	}
+
+	for _, m := range snapshot.SortByTime(manifests, true) {
+		if m.IncompleteReason != "" {
+			continue
+		}
+
+		log(ctx).Infof("Restoring snapshot %v of %v taken at %v", m.ID, si, formatTimestamp(m.StartTime))
+
+		return snapshotfs.SnapshotRoot(rep, m)
+	}
+
+	return nil, errors.Errorf("no complete snapshots of %v", si)
+}
+
+// restoreRootEntry restores the provided entry to the output specified by restore flags.
+func restoreRootEntry(ctx context.Context, rep repo.Repository, rootEntry fs.Entry) error {
+	output, err := restoreOutput(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to initialize output")
+	}
+
+	if restoreNice {
+		defer lowerRestorePriority(ctx)()
+	}
+
+	if fso, ok := output.(*restore.FilesystemOutput); ok {
+		if restoreMaxWriteSpeed > 0 || restoreControlSocket != "" {
+			fso.SetMaxWriteSpeed(int64(restoreMaxWriteSpeed))
+		}
+
+		if restoreControlSocket != "" {
+			cs, err := listenRestoreControlSocket(fso)
+			if err != nil {
+				return err
+			}
+
+			defer cs.Close() //nolint:errcheck
+		}
+	} else if restoreMaxWriteSpeed > 0 || restoreControlSocket != "" {
+		return errors.Errorf("--max-write-speed and --control-socket are only supported when restoring to local filesystem")
+	}
+
+	return restoreEntryWithProgress(ctx, rep, output, rootEntry)
+}
+
+func restoreEntryWithProgress(ctx context.Context, rep repo.Repository, output restore.Output, rootEntry fs.Entry) error {
 	t0 := clock.Now()
 
 	st, err := restore.Entry(ctx, rep, output, rootEntry, restore.Options{
-		Parallel: restoreParallel,
+		Parallel: limitParallelism(restoreParallel),
 		ProgressCallback: func(ctx context.Context, stats restore.Stats) {
 			restoredCount := stats.RestoredFileCount + stats.RestoredDirCount + stats.RestoredSymlinkCount
 			enqueuedCount := stats.EnqueuedFileCount + stats.EnqueuedDirCount + stats.EnqueuedSymlinkCount
@@ -213,6 +391,12 @@ func runRestoreCommand(ctx context.Context, rep repo.Repository) error {
 				maybeRemaining)
 		},
 	})
+	if errors.Is(err, blob.ErrBlobArchived) {
+		printRestoreStats(ctx, st)
+
+		return errors.Wrap(err, "some of the data is stored in an archive tier, retry the restore once its rehydration completes")
+	}
+
 	if err != nil {
 		return err
 	}
@@ -222,6 +406,40 @@ func runRestoreCommand(ctx context.Context, rep repo.Repository) error {
 	return nil
 }
 
+func lowerRestorePriority(ctx context.Context) func() {
+	restorePriority, err := ospriority.Lower(ospriority.Settings{
+		Niceness: restoreNiceNiceness,
+		IOClass:  restoreNiceIOClass,
+	})
+	if err != nil {
+		log(ctx).Warningf("unable to lower process priority: %v", err)
+		return func() {}
+	}
+
+	return func() {
+		if err := restorePriority(); err != nil {
+			log(ctx).Debugf("unable to restore process priority: %v", err)
+		}
+	}
+}
+
+func listenRestoreControlSocket(fso *restore.FilesystemOutput) (*controlsocket.Server, error) {
+	return controlsocket.Listen(restoreControlSocket, map[string]controlsocket.Handler{
+		restoreControlCmdMaxWriteSpeed: func(args []string) (string, error) {
+			if len(args) > 0 {
+				v, err := strconv.ParseInt(args[0], 10, 64)
+				if err != nil || v < 0 {
+					return "", errors.Errorf("invalid write speed: %q", args[0])
+				}
+
+				fso.SetMaxWriteSpeed(v)
+			}
+
+			return strconv.FormatInt(fso.MaxWriteSpeed(), 10), nil
+		},
+	})
+}
+
 func init() {
 	addRestoreFlags(restoreCommand)
 	restoreCommand.Action(repositoryAction(runRestoreCommand))
@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/kopia/kopia/internal/controlsocket"
+	"github.com/kopia/kopia/internal/units"
+)
+
+var (
+	restoreControlCommand       = app.Command("restore-control", "Adjust restore in progress that was started with --control-socket")
+	restoreControlSocketPath    = restoreControlCommand.Arg("control-socket", "Path of the control socket").Required().String()
+	restoreControlMaxWriteSpeed = restoreControlCommand.Flag("max-write-speed", "Maximum speed of writing restored files per second (0=unlimited)").IsSetByUser(&restoreControlMaxWriteSpeedSet).Bytes()
+
+	restoreControlMaxWriteSpeedSet bool
+)
+
+func init() {
+	restoreControlCommand.Action(noRepositoryAction(runRestoreControl))
+}
+
+func runRestoreControl(ctx context.Context) error {
+	var args []string
+
+	// without flags only the current settings are printed.
+	if restoreControlMaxWriteSpeedSet {
+		args = append(args, strconv.FormatInt(int64(*restoreControlMaxWriteSpeed), 10))
+	}
+
+	resp, err := controlsocket.Send(ctx, *restoreControlSocketPath, restoreControlCmdMaxWriteSpeed, args...)
+	if err != nil {
+		return err
+	}
+
+	v, err := strconv.ParseInt(resp, 10, 64)
+	if err != nil || v == 0 {
+		printStdout("Max write speed: unlimited\n")
+		return nil
+	}
+
+	printStdout("Max write speed: %v/s\n", units.BytesStringBase2(v))
+
+	return nil
+}
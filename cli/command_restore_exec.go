@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/snapshot/restore"
+)
+
+var (
+	restoreToK8sPod     = ""
+	restoreK8sContainer = ""
+	restoreKubectlExe   = "kubectl"
+	restoreToDocker     = ""
+	restoreDockerExe    = "docker"
+)
+
+func addRestoreExecFlags(cmd *kingpin.CmdClause) {
+	cmd.Flag("to-k8s-pod", "Restore into a directory of a running Kubernetes pod through 'kubectl exec', in the form of [NAMESPACE/]POD:PATH").PlaceHolder("NS/POD:PATH").StringVar(&restoreToK8sPod)
+	cmd.Flag("k8s-container", "Name of the container in the pod to restore into").StringVar(&restoreK8sContainer)
+	cmd.Flag("kubectl", "Path of the kubectl executable").Default(restoreKubectlExe).StringVar(&restoreKubectlExe)
+	cmd.Flag("to-docker", "Restore into a directory of a running Docker container through 'docker exec', in the form of CONTAINER:PATH").PlaceHolder("CONTAINER:PATH").StringVar(&restoreToDocker)
+	cmd.Flag("docker", "Path of the docker executable").Default(restoreDockerExe).StringVar(&restoreDockerExe)
+}
+
+// splitExecTarget splits exec restore target in the form of 'NAME:PATH'.
+func splitExecTarget(target string) (name, path string, err error) {
+	p := strings.Index(target, ":")
+	if p <= 0 || p == len(target)-1 {
+		return "", "", errors.Errorf("invalid target %q, must be NAME:PATH", target)
+	}
+
+	return target[0:p], target[p+1:], nil
+}
+
+// extractTarArgs returns command line of tar which extracts the archive read from standard input into the provided path.
+func extractTarArgs(path string) []string {
+	return []string{"tar", "-x", "-f", "-", "-C", path}
+}
+
+// k8sPodRestoreArgs returns kubectl arguments which extract tar from standard input in the provided pod and path.
+func k8sPodRestoreArgs(target, container string) ([]string, error) {
+	pod, path, err := splitExecTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"exec", "-i"}
+
+	if p := strings.Index(pod, "/"); p >= 0 {
+		if p == 0 || p == len(pod)-1 {
+			return nil, errors.Errorf("invalid pod %q, must be NAMESPACE/POD", pod)
+		}
+
+		args = append(args, "--namespace", pod[0:p])
+		pod = pod[p+1:]
+	}
+
+	if container != "" {
+		args = append(args, "--container", container)
+	}
+
+	args = append(args, pod, "--")
+
+	return append(args, extractTarArgs(path)...), nil
+}
+
+// dockerRestoreArgs returns docker arguments which extract tar from standard input in the provided container and path.
+func dockerRestoreArgs(target string) ([]string, error) {
+	container, path, err := splitExecTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]string{"exec", "-i", container}, extractTarArgs(path)...), nil
+}
+
+// restoreExecOutput returns output which restores into a container if requested, nil otherwise.
+func restoreExecOutput(ctx context.Context) (restore.Output, error) {
+	var (
+		exe  string
+		args []string
+		err  error
+	)
+
+	switch {
+	case restoreToK8sPod != "" && restoreToDocker != "":
+		return nil, errors.Errorf("--to-k8s-pod and --to-docker are mutually exclusive")
+
+	case restoreToK8sPod != "":
+		exe = restoreKubectlExe
+		args, err = k8sPodRestoreArgs(restoreToK8sPod, restoreK8sContainer)
+
+	case restoreToDocker != "":
+		exe = restoreDockerExe
+		args, err = dockerRestoreArgs(restoreToDocker)
+
+	default:
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if restoreTargetPath != "" {
+		return nil, errors.Errorf("target path can't be specified when restoring into a container")
+	}
+
+	log(ctx).Infof("Restoring using '%v %v'...", exe, strings.Join(args, " "))
+
+	o, err := restore.NewExecTarOutput(exec.CommandContext(ctx, exe, args...)) //nolint:gosec
+	if err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
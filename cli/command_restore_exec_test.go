@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestK8sPodRestoreArgs(t *testing.T) {
+	cases := []struct {
+		target    string
+		container string
+		want      []string
+	}{
+		{"ns/pod:/data", "", []string{"exec", "-i", "--namespace", "ns", "pod", "--", "tar", "-x", "-f", "-", "-C", "/data"}},
+		{"pod:/data", "", []string{"exec", "-i", "pod", "--", "tar", "-x", "-f", "-", "-C", "/data"}},
+		{"ns/pod:/data", "app", []string{"exec", "-i", "--namespace", "ns", "--container", "app", "pod", "--", "tar", "-x", "-f", "-", "-C", "/data"}},
+	}
+
+	for _, tc := range cases {
+		got, err := k8sPodRestoreArgs(tc.target, tc.container)
+		require.NoError(t, err, tc.target)
+		require.Equal(t, tc.want, got, tc.target)
+	}
+
+	for _, target := range []string{"pod", "pod:", ":/data", "/pod:/data", "ns/:/data"} {
+		_, err := k8sPodRestoreArgs(target, "")
+		require.Error(t, err, target)
+	}
+}
+
+func TestDockerRestoreArgs(t *testing.T) {
+	got, err := dockerRestoreArgs("container:/data")
+	require.NoError(t, err)
+	require.Equal(t, []string{"exec", "-i", "container", "tar", "-x", "-f", "-", "-C", "/data"}, got)
+
+	for _, target := range []string{"container", "container:", ":/data"} {
+		_, err := dockerRestoreArgs(target)
+		require.Error(t, err, target)
+	}
+}
@@ -0,0 +1,28 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/kopia/kopia/snapshot/restore"
+)
+
+var (
+	restoreMetadataCommand                = app.Command("restore-metadata", "Apply metadata stored in sidecar files by 'restore --metadata-sidecars' on another operating system.")
+	restoreMetadataPath                   = restoreMetadataCommand.Arg("path", "Directory that contains '"+restore.MetadataSidecarDir+"'").Required().ExistingDir()
+	restoreMetadataIgnorePermissionErrors = restoreMetadataCommand.Flag("ignore-permission-errors", "Ignore permission errors").Bool()
+)
+
+func runRestoreMetadataCommand(ctx context.Context) error {
+	n, err := restore.ApplySidecarMetadata(ctx, *restoreMetadataPath, *restoreMetadataIgnorePermissionErrors)
+	if err != nil {
+		return err
+	}
+
+	log(ctx).Infof("Applied metadata of %v entries.", n)
+
+	return nil
+}
+
+func init() {
+	restoreMetadataCommand.Action(noRepositoryAction(runRestoreMetadataCommand))
+}
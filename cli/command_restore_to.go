@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/snapshot/restore"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+const restoreToCommandHelp = `Restore a directory or file from a snapshot directly into object storage.
+
+Restored files are stored as objects whose keys are their paths relative to the
+restored directory, preceded by the optional prefix. Symbolic links and empty
+directories are not restored.`
+
+var restoreToCommand = app.Command("restore-to", restoreToCommandHelp)
+
+// restoreToStorageTypes are storage types which store blobs as objects keyed by blob ID, which
+// makes them suitable for restoring files.
+var restoreToStorageTypes = map[string]bool{
+	"azure":  true,
+	"b2":     true,
+	"google": true,
+	"oci":    true,
+	"s3":     true,
+}
+
+func addRestoreToFlags(cmd *kingpin.CmdClause) {
+	cmd.Arg("source", restoreCommandSourcePathHelp).Required().StringVar(&restoreSourceID)
+	cmd.Flag("consistent-attributes", "When multiple snapshots match, fail if they have inconsistent attributes").Envar("KOPIA_RESTORE_CONSISTENT_ATTRIBUTES").BoolVar(&restoreConsistentAttributes)
+	cmd.Flag("parallel", "Restore parallelism (1=disable)").IntVar(&restoreParallel)
+}
+
+func runRestoreToStorage(ctx context.Context, rep repo.Repository, st blob.Storage) error {
+	rootEntry, err := snapshotfs.FilesystemEntryFromIDWithPath(ctx, rep, restoreSourceID, restoreConsistentAttributes)
+	if err != nil {
+		return errors.Wrap(err, "unable to get filesystem entry")
+	}
+
+	log(ctx).Infof("Restoring to %v with parallelism=%v...", st.DisplayName(), limitParallelism(restoreParallel))
+
+	return restoreEntryWithProgress(ctx, rep, restore.NewStorageOutput(st), rootEntry)
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kopia/kopia/internal/apiclient"
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/clockskew"
+	"github.com/kopia/kopia/internal/serverapi"
+	"github.com/kopia/kopia/internal/units"
+)
+
+var (
+	serverClientsCommands = serverCommands.Command("clients", "Commands to manage clients of the server")
+
+	serverClientsListCommand   = serverClientsCommands.Command("list", "List clients that have connected to the server").Alias("ls")
+	serverClientsListOutdated  = serverClientsListCommand.Flag("outdated", "Only list clients running version different from the server").Bool()
+	serverClientsListSilentFor = serverClientsListCommand.Flag("silent-for", "Only list clients not seen for at least the provided duration").Duration()
+)
+
+func init() {
+	serverClientsListCommand.Action(serverAction(runServerClientsList))
+}
+
+func runServerClientsList(ctx context.Context, cli *apiclient.KopiaAPIClient) error {
+	resp, err := serverapi.ListClients(ctx, cli)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range resp.Clients {
+		outdated := c.Version != resp.ServerVersion
+
+		if *serverClientsListOutdated && !outdated {
+			continue
+		}
+
+		if d := *serverClientsListSilentFor; d > 0 && clock.Since(c.LastSeen) < d {
+			continue
+		}
+
+		var maybeOutdated string
+		if outdated {
+			maybeOutdated = " (outdated)"
+		}
+
+		printStdout("%v@%v %v%v %v/%v cache:%v last seen %v ago\n",
+			c.Username, c.Hostname,
+			c.Version, maybeOutdated,
+			c.OS, c.Arch,
+			units.BytesStringBase10(c.CacheSizeBytes),
+			clock.Since(c.LastSeen).Truncate(time.Second))
+
+		printStdout("  last snapshot: %v\n", lastSnapshotResult(c.LastSnapshot))
+
+		if c.PolicyProfile != "" {
+			printStdout("  policy profile: %v\n", c.PolicyProfile)
+		}
+
+		if clockskew.Exceeds(c.ClockSkew, clockskew.DefaultMaxSkew) {
+			printStdout("  clock skew: %v\n", c.ClockSkew)
+		}
+	}
+
+	return nil
+}
+
+func lastSnapshotResult(ls *serverapi.ClientLastSnapshot) string {
+	if ls == nil {
+		return "none"
+	}
+
+	result := "ok"
+
+	switch {
+	case ls.IncompleteReason != "":
+		result = "incomplete: " + ls.IncompleteReason
+	case ls.ErrorCount > 0:
+		result = fmt.Sprintf("%v errors", ls.ErrorCount)
+	}
+
+	return fmt.Sprintf("%v %v (%v)", formatTimestamp(ls.StartTime), ls.Source.Path, result)
+}
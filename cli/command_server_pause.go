@@ -7,12 +7,15 @@
 	"github.com/kopia/kopia/internal/serverapi"
 )
 
-var serverPauseCommand = serverCommands.Command("pause", "Pause the scheduled snapshots for one or more sources")
+var (
+	serverPauseCommand = serverCommands.Command("pause", "Pause snapshots by all clients of the repository, checkpointing snapshots in progress")
+	serverPauseReason  = serverPauseCommand.Flag("reason", "Reason for pausing snapshots, shown to clients").String()
+)
 
 func init() {
 	serverPauseCommand.Action(serverAction(runServerPause))
 }
 
 func runServerPause(ctx context.Context, cli *apiclient.KopiaAPIClient) error {
-	return cli.Post(ctx, "sources/pause", &serverapi.Empty{}, &serverapi.Empty{})
+	return cli.Post(ctx, "sources/pause", &serverapi.PauseRequest{Reason: *serverPauseReason}, &serverapi.PauseResponse{})
 }
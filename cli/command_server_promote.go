@@ -0,0 +1,24 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/kopia/kopia/internal/apiclient"
+	"github.com/kopia/kopia/internal/serverapi"
+)
+
+var serverPromoteCommand = serverCommands.Command("promote", "Promote a standby Kopia server to accept writes, take snapshots and run maintenance.")
+
+func init() {
+	serverPromoteCommand.Action(serverAction(runServerPromote))
+}
+
+func runServerPromote(ctx context.Context, cli *apiclient.KopiaAPIClient) error {
+	if err := cli.Post(ctx, "promote", &serverapi.Empty{}, &serverapi.Empty{}); err != nil {
+		return err
+	}
+
+	log(ctx).Infof("Server promoted.")
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/apiclient"
+	"github.com/kopia/kopia/internal/authhook"
+	"github.com/kopia/kopia/internal/serverapi"
+	"github.com/kopia/kopia/internal/userdb"
+)
+
+var (
+	serverReloadCommand      = serverCommands.Command("reload", "Reload configuration of a running server (users, access rules, TLS certificates and log levels) without interrupting uploads in progress. Requires administrative access. Sending SIGHUP to the server has the same effect.")
+	serverReloadLogLevel     = serverReloadCommand.Flag("set-log-level", "Change console log level of the server").Enum(logLevelNames...)
+	serverReloadFileLogLevel = serverReloadCommand.Flag("set-file-log-level", "Change file log level of the server").Enum(logLevelNames...)
+)
+
+var logLevelNames = []string{"debug", "info", "warning", "error"}
+
+// logLevelSetter changes log levels of the running process.
+var logLevelSetter func(consoleLevel, fileLevel string) error
+
+// SetLogLevelSetter sets the function used by the server to change log levels without restarting.
+func SetLogLevelSetter(f func(consoleLevel, fileLevel string) error) {
+	logLevelSetter = f
+}
+
+func init() {
+	serverReloadCommand.Action(serverAction(runServerReload))
+}
+
+func runServerReload(ctx context.Context, cli *apiclient.KopiaAPIClient) error {
+	return cli.Post(ctx, "reload", &serverapi.ReloadRequest{
+		LogLevel:     *serverReloadLogLevel,
+		FileLogLevel: *serverReloadFileLogLevel,
+	}, &serverapi.Empty{})
+}
+
+// serverConfigReloader reloads parts of server configuration that can be changed while the server is running.
+type serverConfigReloader struct {
+	userDB   *userdb.Database
+	authHook authhook.Hook
+	tlsCert  *reloadableCertificate
+}
+
+func (r *serverConfigReloader) reload(ctx context.Context, req *serverapi.ReloadRequest) error {
+	if r.userDB != nil {
+		if err := r.userDB.Reload(); err != nil {
+			return errors.Wrap(err, "unable to reload user database")
+		}
+
+		log(ctx).Infof("reloaded users from %v", *serverStartHtpasswdFile)
+	}
+
+	if r.authHook != nil {
+		// access rules are evaluated by the hook again instead of using cached decisions.
+		authhook.Reset(r.authHook)
+
+		log(ctx).Infof("discarded cached decisions of the authorization hook")
+	}
+
+	if r.tlsCert != nil {
+		if err := r.tlsCert.load(); err != nil {
+			return err
+		}
+
+		log(ctx).Infof("reloaded TLS certificate from %v", r.tlsCert.certFile)
+	}
+
+	if req.LogLevel != "" || req.FileLogLevel != "" {
+		if logLevelSetter == nil {
+			return errors.Errorf("changing log levels is not supported")
+		}
+
+		if err := logLevelSetter(req.LogLevel, req.FileLogLevel); err != nil {
+			return errors.Wrap(err, "unable to change log levels")
+		}
+
+		log(ctx).Infof("changed log levels (console: %q, file: %q)", req.LogLevel, req.FileLogLevel)
+	}
+
+	return nil
+}
+
+// reloadOnSIGHUP reloads server configuration each time the process receives SIGHUP, which no longer
+// terminates the server.
+func (r *serverConfigReloader) reloadOnSIGHUP(ctx context.Context) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+
+	go func() {
+		for range c {
+			log(ctx).Infof("reloading configuration due to SIGHUP")
+
+			if err := r.reload(ctx, &serverapi.ReloadRequest{}); err != nil {
+				log(ctx).Errorf("unable to reload configuration: %v", err)
+			}
+		}
+	}()
+}
+
+// reloadableCertificate holds TLS certificate loaded from PEM files, which can be replaced
+// while the server is running. New certificate is used for all subsequent TLS handshakes.
+type reloadableCertificate struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func (c *reloadableCertificate) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "unable to load TLS certificate")
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *reloadableCertificate) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
@@ -7,12 +7,12 @@
 	"github.com/kopia/kopia/internal/serverapi"
 )
 
-var serverResumeCommand = serverCommands.Command("resume", "Resume the scheduled snapshots for one or more sources")
+var serverResumeCommand = serverCommands.Command("resume", "Resume snapshots paused with 'server pause'")
 
 func init() {
 	serverResumeCommand.Action(serverAction(runServerResume))
 }
 
 func runServerResume(ctx context.Context, cli *apiclient.KopiaAPIClient) error {
-	return cli.Post(ctx, "sources/resume", &serverapi.Empty{}, &serverapi.Empty{})
+	return cli.Post(ctx, "sources/resume", &serverapi.Empty{}, &serverapi.PauseResponse{})
 }
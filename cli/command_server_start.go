@@ -6,6 +6,7 @@
 	"crypto/rand"
 	"crypto/subtle"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"html"
 	"io"
@@ -18,11 +19,14 @@
 	"contrib.go.opencensus.io/exporter/prometheus"
 	"github.com/pkg/errors"
 	prom "github.com/prometheus/client_golang/prometheus"
-	htpasswd "github.com/tg123/go-htpasswd"
 
+	"github.com/kopia/kopia/internal/authhook"
 	"github.com/kopia/kopia/internal/clock"
 	"github.com/kopia/kopia/internal/server"
+	"github.com/kopia/kopia/internal/serverapi"
+	"github.com/kopia/kopia/internal/userdb"
 	"github.com/kopia/kopia/repo"
+	blobmetrics "github.com/kopia/kopia/repo/blob/metrics"
 )
 
 var (
@@ -30,10 +34,19 @@
 	serverStartHTMLPath        = serverStartCommand.Flag("html", "Server the provided HTML at the root URL").ExistingDir()
 	serverStartUI              = serverStartCommand.Flag("ui", "Start the server with HTML UI").Default("true").Bool()
 	serverStartRefreshInterval = serverStartCommand.Flag("refresh-interval", "Frequency for refreshing repository status").Default("10s").Duration()
-
-	serverStartRandomPassword = serverStartCommand.Flag("random-password", "Generate random password and print to stderr").Hidden().Bool()
-	serverStartAutoShutdown   = serverStartCommand.Flag("auto-shutdown", "Auto shutdown the server if API requests not received within given time").Hidden().Duration()
-	serverStartHtpasswdFile   = serverStartCommand.Flag("htpasswd-file", "Path to htpasswd file that contains allowed user@hostname entries").Hidden().ExistingFile()
+	serverStartUploadJournal   = serverStartCommand.Flag("upload-journal-dir", "Persist contents uploaded by clients until flushed, so that uploads can resume after server restart").String()
+	serverStartStandby         = serverStartCommand.Flag("standby", "Start as a read-only standby of a replicated repository until promoted using 'kopia server promote'").Bool()
+
+	serverStartRandomPassword  = serverStartCommand.Flag("random-password", "Generate random password and print to stderr").Hidden().Bool()
+	serverStartAutoShutdown    = serverStartCommand.Flag("auto-shutdown", "Auto shutdown the server if API requests not received within given time").Hidden().Duration()
+	serverStartHtpasswdFile    = serverStartCommand.Flag("htpasswd-file", "Path to htpasswd file that contains allowed user@hostname entries").Hidden().ExistingFile()
+	serverStartSessionDuration = serverStartCommand.Flag("session-duration", "Duration of browser sessions of users with two-factor authentication, after which they must log in again").Default("8h").Duration()
+
+	serverStartAuthHookCommand      = serverStartCommand.Flag("auth-hook-command", "Command that authorizes API requests, receives JSON request on stdin and allows it by exiting with code 0").String()
+	serverStartAuthHookURL          = serverStartCommand.Flag("auth-hook-url", "URL that authorizes API requests, receives POSTed JSON request and allows it by responding with 2xx status").String()
+	serverStartAuthHookTimeout      = serverStartCommand.Flag("auth-hook-timeout", "Timeout of a single auth hook call").Default("10s").Duration()
+	serverStartAuthHookCacheTTL     = serverStartCommand.Flag("auth-hook-cache-ttl", "How long to remember auth hook results (0 to disable)").Default("1m").Duration()
+	serverStartAuthHookAuthenticate = serverStartCommand.Flag("auth-hook-authenticate", "Also authenticate users using the auth hook instead of password or htpasswd file").Bool()
 )
 
 func init() {
@@ -42,16 +55,41 @@ func init() {
 }
 
 func runServer(ctx context.Context, rep repo.Repository) error {
+	authHook, err := authHookFromFlags()
+	if err != nil {
+		return err
+	}
+
+	// the server is long-running, so repositories it opens are always prefetched in the background.
+	repoOptions := applyOptionsFromFlags(ctx, nil)
+	repoOptions.BackgroundPrefetch = true
+
 	srv, err := server.New(ctx, server.Options{
-		ConfigFile:      repositoryConfigFileName(),
-		ConnectOptions:  connectOptions(),
-		RefreshInterval: *serverStartRefreshInterval,
+		AuthorizationHook: authHook,
+		UIUsername:        serverUIUsername(),
+		ConfigFile:        repositoryConfigFileName(),
+		ConnectOptions:    connectOptions(),
+		RefreshInterval:   *serverStartRefreshInterval,
+		UploadJournalDir:  *serverStartUploadJournal,
+		RepositoryOptions: repoOptions,
+		ParallelUploads:   limitParallelism(0),
+		Standby:           *serverStartStandby,
 	})
 	if err != nil {
 		return errors.Wrap(err, "unable to initialize server")
 	}
 
-	maybeAutoUpgradeRepository(ctx, rep)
+	// standby server must not write to the replicated repository, including automatic maintenance after the command.
+	if *serverStartStandby {
+		*enableAutomaticMaintenance = false
+	} else {
+		maybeAutoUpgradeRepository(ctx, rep)
+		maybeSyncUpdatePolicy(ctx, rep)
+	}
+
+	if dr, ok := rep.(*repo.DirectRepository); ok && !*backgroundPrefetch && !*lowMemory {
+		dr.StartBackgroundPrefetch(ctx)
+	}
 
 	if err = srv.SetRepository(ctx, rep); err != nil {
 		return errors.Wrap(err, "error connecting to repository")
@@ -71,6 +109,9 @@ func runServer(ctx context.Context, rep repo.Repository) error {
 	httpServer := &http.Server{Addr: stripProtocol(*serverAddress)}
 	srv.OnShutdown = httpServer.Shutdown
 
+	reloader := &serverConfigReloader{authHook: authHook}
+	srv.OnReload = reloader.reload
+
 	onCtrlC(func() {
 		log(ctx).Infof("Shutting down...")
 
@@ -79,14 +120,14 @@ func runServer(ctx context.Context, rep repo.Repository) error {
 		}
 	})
 
-	mux, err = requireCredentials(mux)
+	mux, err = requireCredentials(mux, reloader, authHook)
 	if err != nil {
 		return errors.Wrap(err, "unable to setup credentials")
 	}
 
 	// init prometheus after adding interceptors that require credentials, so that this
 	// handler can be called without auth
-	if err = initPrometheus(mux); err != nil {
+	if err = initPrometheus(mux, srv.MetricsCollector()); err != nil {
 		return errors.Wrap(err, "error initializing Prometheus")
 	}
 
@@ -103,7 +144,9 @@ func runServer(ctx context.Context, rep repo.Repository) error {
 
 	httpServer.Handler = handler
 
-	err = startServerWithOptionalTLS(ctx, httpServer)
+	reloader.reloadOnSIGHUP(ctx)
+
+	err = startServerWithOptionalTLS(ctx, httpServer, reloader)
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -111,7 +154,7 @@ func runServer(ctx context.Context, rep repo.Repository) error {
 	return srv.SetRepository(ctx, nil)
 }
 
-func initPrometheus(mux *http.ServeMux) error {
+func initPrometheus(mux *http.ServeMux, collectors ...prom.Collector) error {
 	reg := prom.NewRegistry()
 	if err := reg.Register(prom.NewProcessCollector(prom.ProcessCollectorOpts{})); err != nil {
 		return errors.Wrap(err, "error registering process collector")
@@ -121,6 +164,16 @@ func initPrometheus(mux *http.ServeMux) error {
 		return errors.Wrap(err, "error registering go collector")
 	}
 
+	if err := reg.Register(blobmetrics.Collector()); err != nil {
+		return errors.Wrap(err, "error registering blob storage collector")
+	}
+
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return errors.Wrap(err, "error registering collector")
+		}
+	}
+
 	pe, err := prometheus.NewExporter(prometheus.Options{
 		Registry: reg,
 	})
@@ -193,15 +246,64 @@ func serveIndexFileForKnownUIRoutes(fs http.FileSystem) http.Handler {
 	})
 }
 
-func requireCredentials(handler http.Handler) (*http.ServeMux, error) {
+// authHookFromFlags returns the auth hook specified using command-line flags or nil if none was specified.
+func authHookFromFlags() (authhook.Hook, error) {
+	var h authhook.Hook
+
 	switch {
+	case *serverStartAuthHookCommand != "" && *serverStartAuthHookURL != "":
+		return nil, errors.New("only one of '--auth-hook-command' and '--auth-hook-url' can be specified")
+
+	case *serverStartAuthHookCommand != "":
+		h = authhook.NewExec(*serverStartAuthHookCommand, nil, *serverStartAuthHookTimeout)
+
+	case *serverStartAuthHookURL != "":
+		h = authhook.NewHTTP(*serverStartAuthHookURL, *serverStartAuthHookTimeout)
+
+	case *serverStartAuthHookAuthenticate:
+		return nil, errors.New("'--auth-hook-authenticate' requires '--auth-hook-command' or '--auth-hook-url'")
+
+	default:
+		return nil, nil
+	}
+
+	return authhook.Cached(h, *serverStartAuthHookCacheTTL), nil
+}
+
+// serverUIUsername returns the username of the UI when the server authenticates it using a single password,
+// users from the user database or the authentication hook are regular repository users.
+func serverUIUsername() string {
+	if *serverStartAuthHookAuthenticate || *serverStartHtpasswdFile != "" {
+		return ""
+	}
+
+	return *serverUsername
+}
+
+func requireCredentials(mux *http.ServeMux, reloader *serverConfigReloader, authHook authhook.Hook) (*http.ServeMux, error) {
+	var handler http.Handler = mux
+
+	switch {
+	case *serverStartAuthHookAuthenticate:
+		handler = requireAuth{inner: handler, authHook: authHook}
+
 	case *serverStartHtpasswdFile != "":
-		f, err := htpasswd.New(*serverStartHtpasswdFile, htpasswd.DefaultSystems, nil)
+		db, err := userdb.Open(*serverStartHtpasswdFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to open user database")
+		}
+
+		// users can be added or removed by reloading the database while the server is running.
+		reloader.userDB = db
+
+		sessions, err := newSessionManager(db, *serverStartSessionDuration)
 		if err != nil {
 			return nil, err
 		}
 
-		handler = requireAuth{inner: handler, htpasswdFile: f}
+		mux.Handle(changePasswordPath, handleChangePassword(db))
+
+		handler = requireAuth{inner: handler, userDB: db, sessions: sessions}
 
 	case *serverPassword != "":
 		handler = requireAuth{
@@ -227,17 +329,19 @@ func requireCredentials(handler http.Handler) (*http.ServeMux, error) {
 		}
 	}
 
-	mux := http.NewServeMux()
-	mux.Handle("/", handler)
+	outer := http.NewServeMux()
+	outer.Handle("/", handler)
 
-	return mux, nil
+	return outer, nil
 }
 
 type requireAuth struct {
 	inner            http.Handler
 	expectedUsername string
 	expectedPassword string
-	htpasswdFile     *htpasswd.File
+	userDB           *userdb.Database
+	sessions         *sessionManager
+	authHook         authhook.Hook
 }
 
 func (a requireAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -251,11 +355,40 @@ func (a requireAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	var valid int
 
-	if a.htpasswdFile != nil {
-		if a.htpasswdFile.Match(user, pass) {
+	switch {
+	case a.authHook != nil:
+		allowed, err := a.authHook.Check(r.Context(), &authhook.Request{
+			Action:   authhook.ActionAuthenticate,
+			Username: user,
+			Password: pass,
+		})
+		if err != nil {
+			log(r.Context()).Warningf("unable to authenticate %v: %v", user, err)
+		}
+
+		if allowed {
 			valid = 1
 		}
-	} else {
+
+	case a.userDB != nil:
+		switch err := a.authenticateUser(w, r, user, pass); {
+		case err == nil:
+			valid = 1
+
+		case errors.Is(err, userdb.ErrPasswordChangeRequired), errors.Is(err, userdb.ErrPasswordExpired):
+			// credentials are valid, but the only allowed operation is changing the password.
+			if r.URL.Path != changePasswordPath {
+				http.Error(w, err.Error()+"\n", http.StatusForbidden)
+				return
+			}
+
+			valid = 1
+
+		case errors.Is(err, userdb.ErrAccountLocked):
+			log(r.Context()).Warningf("rejected login of %v: %v", user, err)
+		}
+
+	default:
 		valid = subtle.ConstantTimeCompare([]byte(user), []byte(a.expectedUsername)) *
 			subtle.ConstantTimeCompare([]byte(pass), []byte(a.expectedPassword))
 	}
@@ -269,3 +402,56 @@ func (a requireAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	a.inner.ServeHTTP(w, r)
 }
+
+// authenticateUser verifies credentials of the user. Browsers keep sending the one-time code the user
+// logged in with, so it's only verified when the session is created, afterwards the session cookie
+// is verified instead. The state of the account is checked on every request.
+func (a requireAuth) authenticateUser(w http.ResponseWriter, r *http.Request, user, pass string) error {
+	if !a.userDB.TOTPEnabled(user) {
+		return a.userDB.Authenticate(user, pass)
+	}
+
+	if a.sessions.valid(r, user) {
+		return a.userDB.AccountStatus(user)
+	}
+
+	return a.sessions.login(w, r, user, pass)
+}
+
+// changePasswordPath is the URL path used by users to change their own password.
+const changePasswordPath = "/api/v1/current-user/password"
+
+func handleChangePassword(db *userdb.Database) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed.\n", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, _, _ := r.BasicAuth()
+
+		var req serverapi.ChangePasswordRequest
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Malformed request body.\n", http.StatusBadRequest)
+			return
+		}
+
+		if err := db.Update(func() error { return db.ChangePassword(user, req.NewPassword) }); err != nil {
+			if errors.Is(err, userdb.ErrPasswordPolicyViolation) {
+				http.Error(w, err.Error()+"\n", http.StatusBadRequest)
+				return
+			}
+
+			log(r.Context()).Errorf("unable to change password of %v: %v", user, err)
+			http.Error(w, "Unable to change password.\n", http.StatusInternalServerError)
+
+			return
+		}
+
+		log(r.Context()).Infof("user %v changed password", user)
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, "{}")
+	})
+}
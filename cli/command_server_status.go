@@ -3,12 +3,17 @@
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/kopia/kopia/internal/apiclient"
+	"github.com/kopia/kopia/internal/clock"
 	"github.com/kopia/kopia/internal/serverapi"
 )
 
-var serverStatusCommand = serverCommands.Command("status", "Status of Kopia server")
+var (
+	serverStatusCommand = serverCommands.Command("status", "Status of Kopia server")
+	serverStatusRPO     = serverStatusCommand.Flag("rpo", "Show recovery point objective status of sources that have it configured").Bool()
+)
 
 func init() {
 	serverStatusCommand.Action(serverAction(runServerStatus))
@@ -20,9 +25,45 @@ func runServerStatus(ctx context.Context, cli *apiclient.KopiaAPIClient) error {
 		return err
 	}
 
+	if p := status.Pause; p != nil {
+		fmt.Printf("Snapshots paused by %v since %v: %v\n", p.PausedBy, formatTimestamp(p.Since), p.Reason)
+	}
+
+	if *serverStatusRPO {
+		printRPOStatus(status.Sources)
+		return nil
+	}
+
 	for _, src := range status.Sources {
 		fmt.Printf("%15v %v\n", src.Status, src.Source)
+
+		if src.DeferReason != "" {
+			fmt.Printf("%15v snapshot deferred: %v\n", "", src.DeferReason)
+		}
 	}
 
 	return nil
 }
+
+func printRPOStatus(sources []*serverapi.SourceStatus) {
+	now := clock.Now()
+
+	for _, src := range sources {
+		maxAge := src.SchedulingPolicy.MaxSnapshotAge()
+		if maxAge == 0 {
+			continue
+		}
+
+		age := "never"
+		if t := src.LastCompleteSnapshotTime; t != nil {
+			age = fmt.Sprintf("%v ago", now.Sub(*t).Truncate(time.Second))
+		}
+
+		violated := ""
+		if src.RPOViolated {
+			violated = "VIOLATED"
+		}
+
+		fmt.Printf("%-10v RPO %-10v last successful snapshot %-15v %v\n", violated, maxAge, age, src.Source)
+	}
+}
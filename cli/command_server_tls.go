@@ -41,7 +41,7 @@ func generateServerCertificate(ctx context.Context) (*x509.Certificate, *rsa.Pri
 		*serverStartTLSGenerateCertNames)
 }
 
-func startServerWithOptionalTLS(ctx context.Context, httpServer *http.Server) error {
+func startServerWithOptionalTLS(ctx context.Context, httpServer *http.Server, reloader *serverConfigReloader) error {
 	l, err := net.Listen("tcp", httpServer.Addr)
 	if err != nil {
 		return errors.Wrap(err, "listen error")
@@ -50,7 +50,7 @@ func startServerWithOptionalTLS(ctx context.Context, httpServer *http.Server) er
 
 	httpServer.Addr = l.Addr().String()
 
-	return startServerWithOptionalTLSAndListener(ctx, httpServer, l)
+	return startServerWithOptionalTLSAndListener(ctx, httpServer, l, reloader)
 }
 
 func maybeGenerateTLS(ctx context.Context) error {
@@ -89,18 +89,30 @@ func maybeGenerateTLS(ctx context.Context) error {
 	return nil
 }
 
-func startServerWithOptionalTLSAndListener(ctx context.Context, httpServer *http.Server, listener net.Listener) error {
+func startServerWithOptionalTLSAndListener(ctx context.Context, httpServer *http.Server, listener net.Listener, reloader *serverConfigReloader) error {
 	if err := maybeGenerateTLS(ctx); err != nil {
 		return err
 	}
 
 	switch {
 	case *serverStartTLSCertFile != "" && *serverStartTLSKeyFile != "":
-		// PEM files provided
+		// PEM files provided, they are re-read when server configuration is reloaded.
+		cert := &reloadableCertificate{certFile: *serverStartTLSCertFile, keyFile: *serverStartTLSKeyFile}
+		if err := cert.load(); err != nil {
+			return err
+		}
+
+		reloader.tlsCert = cert
+
+		httpServer.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: cert.getCertificate,
+		}
+
 		fmt.Fprintf(os.Stderr, "SERVER ADDRESS: https://%v\n", httpServer.Addr)
 		showServerUIPrompt(ctx)
 
-		return httpServer.ServeTLS(listener, *serverStartTLSCertFile, *serverStartTLSKeyFile)
+		return httpServer.ServeTLS(listener, "", "")
 
 	case *serverStartTLSGenerateCert:
 		// PEM files not provided, generate in-memory TLS cert/key but don't persit.
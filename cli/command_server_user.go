@@ -0,0 +1,292 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/apiclient"
+	"github.com/kopia/kopia/internal/serverapi"
+	"github.com/kopia/kopia/internal/userdb"
+)
+
+var (
+	serverUserCommands     = serverCommands.Command("user", "Manage users of the server stored in htpasswd file")
+	serverUserHtpasswdFile = serverUserCommands.Flag("htpasswd-file", "Path to htpasswd file that contains allowed user@hostname entries").Envar("KOPIA_SERVER_HTPASSWD_FILE").String()
+
+	serverUserAddCommand    = serverUserCommands.Command("add", "Add a new user")
+	serverUserAddUsername   = serverUserAddCommand.Arg("username", "User name in the form user@hostname").Required().String()
+	serverUserAddPassword   = serverUserAddCommand.Flag("user-password", "Password of the user (prompted if not provided)").String()
+	serverUserAddMustChange = serverUserAddCommand.Flag("must-change-password", "Require the user to change the password on first login").Bool()
+
+	serverUserSetPasswordCommand    = serverUserCommands.Command("set-password", "Set password of an existing user")
+	serverUserSetPasswordUsername   = serverUserSetPasswordCommand.Arg("username", "User name in the form user@hostname").Required().String()
+	serverUserSetPasswordPassword   = serverUserSetPasswordCommand.Flag("user-password", "New password of the user (prompted if not provided)").String()
+	serverUserSetPasswordMustChange = serverUserSetPasswordCommand.Flag("must-change-password", "Require the user to change the password on next login").Bool()
+
+	serverUserDeleteCommand  = serverUserCommands.Command("delete", "Delete a user").Alias("rm")
+	serverUserDeleteUsername = serverUserDeleteCommand.Arg("username", "User name in the form user@hostname").Required().String()
+
+	serverUserListCommand = serverUserCommands.Command("list", "List users").Alias("ls")
+
+	serverUserImportCommand    = serverUserCommands.Command("import", "Add or update users from CSV file with 'username,password' lines")
+	serverUserImportFile       = serverUserImportCommand.Arg("file", "CSV file").Required().ExistingFile()
+	serverUserImportMustChange = serverUserImportCommand.Flag("must-change-password", "Require imported users to change passwords on first login").Default("true").Bool()
+
+	serverUserPolicyCommand           = serverUserCommands.Command("policy", "Show or change password policy")
+	serverUserPolicyMinLength         = serverUserPolicyCommand.Flag("min-length", "Minimum password length").Ints()
+	serverUserPolicyMinCharClasses    = serverUserPolicyCommand.Flag("min-char-classes", "Minimum number of character classes (lowercase, uppercase, digits, other) in password").Ints()
+	serverUserPolicyMaxAge            = serverUserPolicyCommand.Flag("max-age", "Maximum age of passwords, after which they must be changed (0 disables expiration)").DurationList()
+	serverUserPolicyMaxFailedAttempts = serverUserPolicyCommand.Flag("max-failed-attempts", "Number of failed login attempts after which the account is temporarily locked (0 disables lockout)").Ints()
+	serverUserPolicyLockoutDuration   = serverUserPolicyCommand.Flag("lockout-duration", "Duration of account lockout").DurationList()
+
+	serverUserChangePasswordCommand = serverUserCommands.Command("change-password", "Change password of the current user on a running server")
+
+	serverUserTOTPCommands = serverUserCommands.Command("totp", "Manage two-factor authentication using time-based one-time passwords (TOTP). Users with TOTP enabled log in by appending the current code, or one of the recovery codes, to their password. Only enable it for users accessing the server using a web browser.")
+
+	serverUserTOTPEnrollCommand  = serverUserTOTPCommands.Command("enroll", "Enable TOTP for a user, or generate new secret and recovery codes")
+	serverUserTOTPEnrollUsername = serverUserTOTPEnrollCommand.Arg("username", "User name in the form user@hostname").Required().String()
+
+	serverUserTOTPDisableCommand  = serverUserTOTPCommands.Command("disable", "Disable TOTP for a user")
+	serverUserTOTPDisableUsername = serverUserTOTPDisableCommand.Arg("username", "User name in the form user@hostname").Required().String()
+)
+
+func init() {
+	serverUserAddCommand.Action(noRepositoryAction(runServerUserAdd))
+	serverUserSetPasswordCommand.Action(noRepositoryAction(runServerUserSetPassword))
+	serverUserDeleteCommand.Action(noRepositoryAction(runServerUserDelete))
+	serverUserListCommand.Action(noRepositoryAction(runServerUserList))
+	serverUserImportCommand.Action(noRepositoryAction(runServerUserImport))
+	serverUserPolicyCommand.Action(noRepositoryAction(runServerUserPolicy))
+	serverUserChangePasswordCommand.Action(serverAction(runServerUserChangePassword))
+	serverUserTOTPEnrollCommand.Action(noRepositoryAction(runServerUserTOTPEnroll))
+	serverUserTOTPDisableCommand.Action(noRepositoryAction(runServerUserTOTPDisable))
+}
+
+func openUserDatabase() (*userdb.Database, error) {
+	if *serverUserHtpasswdFile == "" {
+		return nil, errors.Errorf("--htpasswd-file must be specified")
+	}
+
+	db, err := userdb.Open(*serverUserHtpasswdFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open user database")
+	}
+
+	return db, nil
+}
+
+func askForNewUserPassword(username, provided string) (string, error) {
+	if provided != "" {
+		return provided, nil
+	}
+
+	for {
+		p1, err := askPass(fmt.Sprintf("Enter new password for %v: ", username))
+		if err != nil {
+			return "", errors.Wrap(err, "password entry")
+		}
+
+		p2, err := askPass("Re-enter password for verification: ")
+		if err != nil {
+			return "", errors.Wrap(err, "password verification")
+		}
+
+		if p1 == p2 {
+			return p1, nil
+		}
+
+		fmt.Println("Passwords don't match!")
+	}
+}
+
+// updateUserDatabase opens the user database, applies the change and saves it. The change is applied again
+// if the database is concurrently modified, for example by a running server.
+func updateUserDatabase(ctx context.Context, change func(db *userdb.Database) error) error {
+	db, err := openUserDatabase()
+	if err != nil {
+		return err
+	}
+
+	if err := db.Update(func() error { return change(db) }); err != nil {
+		return err
+	}
+
+	log(ctx).Infof("User database updated, use 'kopia server reload' or send SIGHUP to a running server to apply changes.")
+
+	return nil
+}
+
+func runServerUserAdd(ctx context.Context) error {
+	return updateUserDatabase(ctx, func(db *userdb.Database) error {
+		pass, err := askForNewUserPassword(*serverUserAddUsername, *serverUserAddPassword)
+		if err != nil {
+			return err
+		}
+
+		return db.AddUser(*serverUserAddUsername, pass, *serverUserAddMustChange)
+	})
+}
+
+func runServerUserSetPassword(ctx context.Context) error {
+	return updateUserDatabase(ctx, func(db *userdb.Database) error {
+		pass, err := askForNewUserPassword(*serverUserSetPasswordUsername, *serverUserSetPasswordPassword)
+		if err != nil {
+			return err
+		}
+
+		return db.SetPassword(*serverUserSetPasswordUsername, pass, *serverUserSetPasswordMustChange)
+	})
+}
+
+func runServerUserDelete(ctx context.Context) error {
+	return updateUserDatabase(ctx, func(db *userdb.Database) error {
+		return db.DeleteUser(*serverUserDeleteUsername)
+	})
+}
+
+func runServerUserImport(ctx context.Context) error {
+	f, err := os.Open(*serverUserImportFile)
+	if err != nil {
+		return errors.Wrap(err, "unable to open CSV file")
+	}
+
+	defer f.Close() //nolint:errcheck
+
+	return updateUserDatabase(ctx, func(db *userdb.Database) error {
+		n, err := db.ImportCSV(f, *serverUserImportMustChange)
+		if err != nil {
+			return err
+		}
+
+		printStderr("Imported %v users.\n", n)
+
+		return nil
+	})
+}
+
+func runServerUserList(ctx context.Context) error {
+	db, err := openUserDatabase()
+	if err != nil {
+		return err
+	}
+
+	for _, u := range db.Users() {
+		var flags []string
+
+		if u.MustChangePassword {
+			flags = append(flags, "must change password")
+		}
+
+		if u.PasswordExpired {
+			flags = append(flags, "password expired")
+		}
+
+		if u.TOTPEnabled {
+			flags = append(flags, fmt.Sprintf("2FA (%v recovery codes left)", u.RecoveryCodesLeft))
+		}
+
+		changed := "unknown"
+		if !u.PasswordChanged.IsZero() {
+			changed = formatTimestamp(u.PasswordChanged)
+		}
+
+		printStdout("%-40v password changed %v %v\n", u.Username, changed, strings.Join(flags, ", "))
+	}
+
+	return nil
+}
+
+func runServerUserPolicy(ctx context.Context) error {
+	db, err := openUserDatabase()
+	if err != nil {
+		return err
+	}
+
+	p := db.Policy()
+	changed := false
+
+	// we use lists to distinguish between flag not set and set to zero, in which case we pick the last value
+	setIntFromFlag(&p.MinLength, *serverUserPolicyMinLength, &changed)
+	setIntFromFlag(&p.MinCharClasses, *serverUserPolicyMinCharClasses, &changed)
+	setIntFromFlag(&p.MaxFailedAttempts, *serverUserPolicyMaxFailedAttempts, &changed)
+	setDurationFromFlag(&p.MaxAge, *serverUserPolicyMaxAge, &changed)
+	setDurationFromFlag(&p.LockoutDuration, *serverUserPolicyLockoutDuration, &changed)
+
+	if changed {
+		if err := db.Update(func() error { return db.SetPolicy(p) }); err != nil {
+			return err
+		}
+	}
+
+	printStdout("Minimum password length:    %v\n", p.MinLength)
+	printStdout("Minimum character classes:  %v\n", p.MinCharClasses)
+	printStdout("Maximum password age:       %v\n", orDisabled(p.MaxAge, p.MaxAge > 0))
+	printStdout("Maximum failed attempts:    %v\n", orDisabled(p.MaxFailedAttempts, p.MaxFailedAttempts > 0))
+	printStdout("Lockout duration:           %v\n", p.LockoutDuration)
+
+	return nil
+}
+
+func setIntFromFlag(target *int, flag []int, changed *bool) {
+	if len(flag) > 0 {
+		*target = flag[len(flag)-1]
+		*changed = true
+	}
+}
+
+func setDurationFromFlag(target *time.Duration, flag []time.Duration, changed *bool) {
+	if len(flag) > 0 {
+		*target = flag[len(flag)-1]
+		*changed = true
+	}
+}
+
+func orDisabled(v interface{}, enabled bool) interface{} {
+	if !enabled {
+		return "disabled"
+	}
+
+	return v
+}
+
+func runServerUserChangePassword(ctx context.Context, cli *apiclient.KopiaAPIClient) error {
+	pass, err := askForNewUserPassword(*serverUsername, "")
+	if err != nil {
+		return err
+	}
+
+	return cli.Post(ctx, "current-user/password", &serverapi.ChangePasswordRequest{NewPassword: pass}, &serverapi.Empty{})
+}
+
+func runServerUserTOTPEnroll(ctx context.Context) error {
+	return updateUserDatabase(ctx, func(db *userdb.Database) error {
+		e, err := db.EnrollTOTP(*serverUserTOTPEnrollUsername)
+		if err != nil {
+			return err
+		}
+
+		printStdout("Add the following account to the authenticator app by opening the URL or entering the secret manually:\n\n")
+		printStdout("  URL:    %v\n", e.URL)
+		printStdout("  Secret: %v\n\n", e.Secret)
+		printStdout("Recovery codes, each can be used once instead of the code from the authenticator app:\n\n")
+
+		for _, c := range e.RecoveryCodes {
+			printStdout("  %v\n", c)
+		}
+
+		printStdout("\nTo log in, append the current code or a recovery code to the password.\n")
+
+		return nil
+	})
+}
+
+func runServerUserTOTPDisable(ctx context.Context) error {
+	return updateUserDatabase(ctx, func(db *userdb.Database) error {
+		return db.DisableTOTP(*serverUserTOTPDisableUsername)
+	})
+}
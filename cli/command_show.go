@@ -2,16 +2,21 @@
 
 import (
 	"context"
+	"io"
 	"os"
 
+	"github.com/pkg/errors"
+
 	"github.com/kopia/kopia/internal/iocopy"
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/snapshot/snapshotfs"
 )
 
 var (
-	catCommand     = app.Command("show", "Displays contents of a repository object.").Alias("cat")
-	catCommandPath = catCommand.Arg("object-path", "Path").Required().String()
+	catCommand       = app.Command("show", "Displays contents of a repository object.").Alias("cat")
+	catCommandPath   = catCommand.Arg("object-path", "Path").Required().String()
+	catCommandOffset = catCommand.Flag("offset", "Offset of the first byte to display").Default("0").Int64()
+	catCommandLength = catCommand.Flag("length", "Number of bytes to display (-1 displays until the end of the object)").Default("-1").Int64()
 )
 
 func runCatCommand(ctx context.Context, rep repo.Repository) error {
@@ -20,6 +25,10 @@ func runCatCommand(ctx context.Context, rep repo.Repository) error {
 		return err
 	}
 
+	if *catCommandOffset < 0 {
+		return errors.Errorf("invalid offset: %v", *catCommandOffset)
+	}
+
 	r, err := rep.OpenObject(ctx, oid)
 	if err != nil {
 		return err
@@ -27,7 +36,22 @@ func runCatCommand(ctx context.Context, rep repo.Repository) error {
 
 	defer r.Close() //nolint:errcheck
 
-	_, err = iocopy.Copy(os.Stdout, r)
+	if *catCommandOffset > r.Length() {
+		return errors.Errorf("offset %v is beyond the end of the object (length %v)", *catCommandOffset, r.Length())
+	}
+
+	// seeking only fetches contents that overlap with the requested range.
+	if _, err = r.Seek(*catCommandOffset, io.SeekStart); err != nil {
+		return errors.Wrap(err, "unable to seek")
+	}
+
+	var src io.Reader = r
+
+	if *catCommandLength >= 0 {
+		src = io.LimitReader(r, *catCommandLength)
+	}
+
+	_, err = iocopy.Copy(os.Stdout, src)
 
 	return err
 }
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+)
+
+var (
+	snapshotCloneCommand = snapshotCommands.Command("clone", "Clone a snapshot to a different source identity (user@host:/path) without uploading any data. "+
+		"The new snapshot references the same objects as the original one.")
+	snapshotCloneID       = snapshotCloneCommand.Arg("id", "Snapshot ID to clone").Required().String()
+	snapshotCloneAs       = snapshotCloneCommand.Flag("as", "Destination source (user@host:/path, or user@host to keep the original path)").Required().String()
+	snapshotCloneDryRun   = snapshotCloneCommand.Flag("dry-run", "Do not actually clone the snapshot, only print what would happen").Short('n').Bool()
+	snapshotCloneKeepPins = snapshotCloneCommand.Flag("keep-pins", "Carry over pins of the original snapshot, which protect the clone from being expired").Bool()
+)
+
+func runSnapshotCloneCommand(ctx context.Context, rep repo.Repository) error {
+	m, err := snapshot.LoadSnapshot(ctx, rep, manifest.ID(*snapshotCloneID))
+	if err != nil {
+		return errors.Wrapf(err, "error loading snapshot %v", *snapshotCloneID)
+	}
+
+	overrides, err := snapshot.ParseSourceInfo(*snapshotCloneAs, rep.ClientOptions().Hostname, rep.ClientOptions().Username)
+	if err != nil {
+		return errors.Wrap(err, "invalid destination")
+	}
+
+	dstSource := getCopyDestination(m.Source, overrides)
+	if dstSource == m.Source {
+		return errors.Errorf("destination is the same as the source of the snapshot: %v", dstSource)
+	}
+
+	dstSnapshots, err := snapshot.ListSnapshots(ctx, rep, dstSource)
+	if err != nil {
+		return errors.Wrap(err, "error listing destination snapshots")
+	}
+
+	if snapshotExists(dstSnapshots, dstSource, m) {
+		log(ctx).Infof("%v (%v) already exists", dstSource, formatTimestamp(m.StartTime))
+		return nil
+	}
+
+	if *snapshotCloneDryRun {
+		log(ctx).Infof("Would clone snapshot %v of %v (%v) to %v", m.ID, m.Source, formatTimestamp(m.StartTime), dstSource)
+		return nil
+	}
+
+	newID, err := snapshot.SaveSnapshot(ctx, rep, cloneManifest(m, dstSource, *snapshotCloneKeepPins))
+	if err != nil {
+		return errors.Wrap(err, "unable to save snapshot")
+	}
+
+	log(ctx).Infof("Cloned snapshot %v to %v as %v", m.ID, dstSource, newID)
+
+	return nil
+}
+
+// cloneManifest returns a copy of the snapshot manifest for the provided source. Group membership and anomalies
+// belong to the history of the original source and are not copied, pins are only copied when requested.
+func cloneManifest(m *snapshot.Manifest, dst snapshot.SourceInfo, keepPins bool) *snapshot.Manifest {
+	c := *m
+
+	c.ID = ""
+	c.Source = dst
+	c.Group = ""
+	c.Anomalies = nil
+
+	if !keepPins {
+		c.Pins = nil
+	}
+
+	return &c
+}
+
+func init() {
+	snapshotCloneCommand.Action(repositoryAction(runSnapshotCloneCommand))
+}
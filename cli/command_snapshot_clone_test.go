@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kopia/kopia/snapshot"
+)
+
+func TestCloneManifest(t *testing.T) {
+	src := snapshot.SourceInfo{UserName: "u1", Host: "host1", Path: "/p"}
+	dst := snapshot.SourceInfo{UserName: "u2", Host: "host2", Path: "/q"}
+
+	m := &snapshot.Manifest{
+		ID:          "abc",
+		Source:      src,
+		Description: "desc",
+		Group:       "group1",
+		Pins:        []string{"release-1.0"},
+		Anomalies:   []snapshot.Anomaly{{}},
+	}
+
+	c := cloneManifest(m, dst, false)
+
+	if c.ID != "" || c.Source != dst || c.Description != "desc" {
+		t.Errorf("unexpected clone: %+v", c)
+	}
+
+	if c.Group != "" || c.Pins != nil || c.Anomalies != nil {
+		t.Errorf("history of the original snapshot was cloned: %+v", c)
+	}
+
+	if c = cloneManifest(m, dst, true); !reflect.DeepEqual(c.Pins, m.Pins) {
+		t.Errorf("unexpected pins of clone: %v, want %v", c.Pins, m.Pins)
+	}
+
+	// the original manifest is not modified.
+	if m.ID != "abc" || m.Source != src || m.Group != "group1" || len(m.Anomalies) != 1 {
+		t.Errorf("original manifest was modified: %+v", m)
+	}
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot/snapshotcommit"
+)
+
+var (
+	snapshotCommitmentCommands = snapshotCommands.Command("commitment", "Compute and verify cryptographic commitments to snapshots, suitable for anchoring in external transparency logs.")
+
+	snapshotCommitmentShowCommand = snapshotCommitmentCommands.Command("show", "Print commitment to the snapshot in JSON format.")
+	snapshotCommitmentShowID      = snapshotCommitmentShowCommand.Arg("id", "Snapshot ID").Required().String()
+	snapshotCommitmentShowDigest  = snapshotCommitmentShowCommand.Flag("digest-only", "Only print the digest of the commitment").Bool()
+
+	snapshotCommitmentVerifyCommand = snapshotCommitmentCommands.Command("verify", "Verify that the snapshot matches a previously computed commitment.")
+	snapshotCommitmentVerifyFile    = snapshotCommitmentVerifyCommand.Arg("file", "File containing commitment in JSON format").Required().ExistingFile()
+)
+
+func init() {
+	snapshotCommitmentShowCommand.Action(directRepositoryAction(runSnapshotCommitmentShowCommand))
+	snapshotCommitmentVerifyCommand.Action(directRepositoryAction(runSnapshotCommitmentVerifyCommand))
+}
+
+func runSnapshotCommitmentShowCommand(ctx context.Context, rep *repo.DirectRepository) error {
+	c, err := snapshotcommit.Compute(ctx, rep, manifest.ID(*snapshotCommitmentShowID))
+	if err != nil {
+		return errors.Wrap(err, "unable to compute commitment")
+	}
+
+	if *snapshotCommitmentShowDigest {
+		printStdout("%v\n", c.Digest)
+		return nil
+	}
+
+	printStdout("%v", prettyJSON(c))
+
+	return nil
+}
+
+func runSnapshotCommitmentVerifyCommand(ctx context.Context, rep *repo.DirectRepository) error {
+	f, err := os.Open(*snapshotCommitmentVerifyFile)
+	if err != nil {
+		return errors.Wrap(err, "unable to open commitment file")
+	}
+	defer f.Close() //nolint:errcheck
+
+	var c snapshotcommit.Commitment
+
+	if err := json.NewDecoder(f).Decode(&c); err != nil {
+		return errors.Wrap(err, "invalid commitment file")
+	}
+
+	if err := snapshotcommit.Verify(ctx, rep, &c); err != nil {
+		return errors.Wrapf(err, "snapshot %v does not match the commitment", c.SnapshotID)
+	}
+
+	log(ctx).Infof("Snapshot %v matches the commitment with digest %v.", c.SnapshotID, c.Digest)
+
+	return nil
+}
@@ -9,15 +9,19 @@
 
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
 )
 
 var (
 	snapshotCopyCommand = snapshotCommands.Command("copy-history", snapshotCopyMoveHelp("copy"))
 	snapshotMoveCommand = snapshotCommands.Command("move-history", snapshotCopyMoveHelp("move"))
 
-	snapshotCopyOrMoveDryRun      bool
-	snapshotCopyOrMoveSource      string
-	snapshotCopyOrMoveDestination string
+	snapshotCopyOrMoveDryRun          bool
+	snapshotCopyOrMovePolicies        bool
+	snapshotCopyOrMoveSourceFlag      string
+	snapshotCopyOrMoveDestinationFlag string
+	snapshotCopyOrMoveSourceArg       string
+	snapshotCopyOrMoveDestinationArg  string
 )
 
 func snapshotCopyMoveHelp(verb string) string {
@@ -41,13 +45,20 @@ func snapshotCopyMoveHelp(verb string) string {
 	user1@host1:/path1  @host2              VERB to user1@host2:/path1
 	user1@host1:/path1  user2@host2         VERB to user2@host2:/path1
 	user1@host1:/path1  user2@host2:/path2  VERB snapshots from single path.
+
+	Source and destination can also be specified using --from and --to flags instead of arguments.
+	When --policies is specified, policies defined for the source are also VERBed,
+	so that retention and scheduling continue under the new identity.
 `, "VERB", verb)
 }
 
 func registerSnapshotCopyFlags(cmd *kingpin.CmdClause) {
 	cmd.Flag("dry-run", "Do not actually copy snapshots, only print what would happen").Short('n').BoolVar(&snapshotCopyOrMoveDryRun)
-	cmd.Arg("source", "Source (user@host or user@host:path)").Required().StringVar(&snapshotCopyOrMoveSource)
-	cmd.Arg("destination", "Destination (defaults to current user@host)").StringVar(&snapshotCopyOrMoveDestination)
+	cmd.Flag("policies", "Also copy or move policies defined for the source").BoolVar(&snapshotCopyOrMovePolicies)
+	cmd.Flag("from", "Source (user@host or user@host:path)").StringVar(&snapshotCopyOrMoveSourceFlag)
+	cmd.Flag("to", "Destination (defaults to current user@host)").StringVar(&snapshotCopyOrMoveDestinationFlag)
+	cmd.Arg("source", "Source (user@host or user@host:path)").StringVar(&snapshotCopyOrMoveSourceArg)
+	cmd.Arg("destination", "Destination (defaults to current user@host)").StringVar(&snapshotCopyOrMoveDestinationArg)
 }
 
 // runSnapshotCopyCommand copies snapshot manifests of the specified source
@@ -98,6 +109,12 @@ func runSnapshotCopyCommand(ctx context.Context, rep repo.Repository, isMoveComm
 			continue
 		}
 
+		if isMoveCommand {
+			if err := policy.EnsureNotHeld(ctx, rep, manifest); err != nil {
+				return err
+			}
+		}
+
 		if snapshotExists(dstSnapshots, dstSource, manifest) {
 			if isMoveCommand && !snapshotCopyOrMoveDryRun {
 				log(ctx).Infof("%v (%v) already exists - deleting source", dstSource, formatTimestamp(manifest.StartTime))
@@ -134,9 +151,93 @@ func runSnapshotCopyCommand(ctx context.Context, rep repo.Repository, isMoveComm
 		}
 	}
 
+	if snapshotCopyOrMovePolicies {
+		return copyOrMovePolicies(ctx, rep, si, di, isMoveCommand)
+	}
+
 	return nil
 }
 
+// copyOrMovePolicies copies or moves policies defined for the source, its users or paths
+// (including policies of subdirectories) to the respective destination.
+func copyOrMovePolicies(ctx context.Context, rep repo.Repository, si, di snapshot.SourceInfo, isMoveCommand bool) error {
+	policies, err := policy.ListPolicies(ctx, rep)
+	if err != nil {
+		return errors.Wrap(err, "error listing policies")
+	}
+
+	for _, pol := range policies {
+		target := pol.Target()
+
+		dst, ok := getPolicyCopyDestination(target, si, di)
+		if !ok || dst == target {
+			continue
+		}
+
+		if _, err := policy.GetDefinedPolicy(ctx, rep, dst); err == nil {
+			log(ctx).Infof("policy for %v already exists, not replacing it with policy for %v", dst, target)
+			continue
+		} else if !errors.Is(err, policy.ErrPolicyNotFound) {
+			return errors.Wrapf(err, "error getting policy for %v", dst)
+		}
+
+		log(ctx).Infof("%v policy for %v => %v", getCopySnapshotAction(isMoveCommand), target, dst)
+
+		if snapshotCopyOrMoveDryRun {
+			continue
+		}
+
+		if err := policy.SetPolicy(ctx, rep, dst, pol); err != nil {
+			return errors.Wrapf(err, "unable to set policy for %v", dst)
+		}
+
+		if isMoveCommand {
+			if err := policy.RemovePolicy(ctx, rep, target); err != nil {
+				return errors.Wrapf(err, "unable to remove policy for %v", target)
+			}
+		}
+	}
+
+	return nil
+}
+
+// getPolicyCopyDestination returns the destination of a policy defined for the provided target, if the target
+// is matched by the source. Policies of subdirectories of the source path are moved along with it.
+func getPolicyCopyDestination(target, si, di snapshot.SourceInfo) (snapshot.SourceInfo, bool) {
+	if target.Host == "" || target.Host != si.Host {
+		return target, false
+	}
+
+	if si.UserName != "" && target.UserName != si.UserName {
+		return target, false
+	}
+
+	if si.Path == "" {
+		return getCopyDestination(target, snapshot.SourceInfo{Host: di.Host, UserName: di.UserName}), true
+	}
+
+	if target.UserName == "" {
+		return target, false
+	}
+
+	var subdir string
+
+	switch {
+	case target.Path == si.Path:
+	case strings.HasPrefix(target.Path, si.Path+"/"), strings.HasPrefix(target.Path, si.Path+"\\"):
+		subdir = target.Path[len(si.Path):]
+	default:
+		return target, false
+	}
+
+	dst := getCopyDestination(target, di)
+	if di.Path != "" {
+		dst.Path = di.Path + subdir
+	}
+
+	return dst, true
+}
+
 func getCopySnapshotAction(isMoveCommand bool) string {
 	action := "copying"
 	if isMoveCommand {
@@ -150,18 +251,45 @@ func getCopySnapshotAction(isMoveCommand bool) string {
 	return action
 }
 
+// flagOrArg returns the value specified using either a flag or a positional argument, but not both.
+func flagOrArg(flagValue, argValue, flagName, argName string) (string, error) {
+	if flagValue != "" && argValue != "" {
+		return "", errors.Errorf("%v can't be specified using both --%v and argument %v", argName, flagName, argValue)
+	}
+
+	if flagValue != "" {
+		return flagValue, nil
+	}
+
+	return argValue, nil
+}
+
 func getCopySourceAndDestination(rep repo.Repository) (si, di snapshot.SourceInfo, err error) {
-	si, err = snapshot.ParseSourceInfo(snapshotCopyOrMoveSource, rep.ClientOptions().Hostname, rep.ClientOptions().Username)
+	source, err := flagOrArg(snapshotCopyOrMoveSourceFlag, snapshotCopyOrMoveSourceArg, "from", "source")
+	if err != nil {
+		return si, di, err
+	}
+
+	destination, err := flagOrArg(snapshotCopyOrMoveDestinationFlag, snapshotCopyOrMoveDestinationArg, "to", "destination")
+	if err != nil {
+		return si, di, err
+	}
+
+	if source == "" {
+		return si, di, errors.Errorf("source must be specified")
+	}
+
+	si, err = snapshot.ParseSourceInfo(source, rep.ClientOptions().Hostname, rep.ClientOptions().Username)
 	if err != nil {
 		return si, di, errors.Wrap(err, "invalid source")
 	}
 
-	if snapshotCopyOrMoveDestination == "" {
+	if destination == "" {
 		// no destination - assume current user@hostname
 		di.UserName = rep.ClientOptions().Username
 		di.Host = rep.ClientOptions().Hostname
 	} else {
-		di, err = snapshot.ParseSourceInfo(snapshotCopyOrMoveDestination, rep.ClientOptions().Hostname, rep.ClientOptions().Username)
+		di, err = snapshot.ParseSourceInfo(destination, rep.ClientOptions().Hostname, rep.ClientOptions().Username)
 		if err != nil {
 			return si, di, errors.Wrap(err, "invalid destination")
 		}
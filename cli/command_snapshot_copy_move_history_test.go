@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/kopia/kopia/snapshot"
+)
+
+func TestGetPolicyCopyDestination(t *testing.T) {
+	src := func(user, host, path string) snapshot.SourceInfo {
+		return snapshot.SourceInfo{UserName: user, Host: host, Path: path}
+	}
+
+	cases := []struct {
+		target, si, di snapshot.SourceInfo
+		want           snapshot.SourceInfo
+		wantOK         bool
+	}{
+		// host rename moves host, user and path policies.
+		{src("", "host1", ""), src("", "host1", ""), src("", "host2", ""), src("", "host2", ""), true},
+		{src("u1", "host1", ""), src("", "host1", ""), src("", "host2", ""), src("u1", "host2", ""), true},
+		{src("u1", "host1", "/p"), src("", "host1", ""), src("", "host2", ""), src("u1", "host2", "/p"), true},
+		{src("u1", "host3", "/p"), src("", "host1", ""), src("", "host2", ""), src("u1", "host3", "/p"), false},
+		{src("", "", ""), src("", "host1", ""), src("", "host2", ""), src("", "", ""), false},
+
+		// user rename does not move host policy or policies of other users.
+		{src("", "host1", ""), src("u1", "host1", ""), src("u2", "host1", ""), src("", "host1", ""), false},
+		{src("u3", "host1", ""), src("u1", "host1", ""), src("u2", "host1", ""), src("u3", "host1", ""), false},
+		{src("u1", "host1", "/p"), src("u1", "host1", ""), src("u2", "host1", ""), src("u2", "host1", "/p"), true},
+
+		// path move includes subdirectories, but not other paths with the same prefix.
+		{src("u1", "host1", "/p"), src("u1", "host1", "/p"), src("u2", "host2", "/q"), src("u2", "host2", "/q"), true},
+		{src("u1", "host1", "/p/sub"), src("u1", "host1", "/p"), src("u2", "host2", "/q"), src("u2", "host2", "/q/sub"), true},
+		{src("u1", "host1", "/p2"), src("u1", "host1", "/p"), src("u2", "host2", "/q"), src("u1", "host1", "/p2"), false},
+		{src("u1", "host1", ""), src("u1", "host1", "/p"), src("u2", "host2", "/q"), src("u1", "host1", ""), false},
+		{src("u1", "host1", "/p/sub"), src("u1", "host1", "/p"), src("", "host2", ""), src("u1", "host2", "/p/sub"), true},
+	}
+
+	for _, tc := range cases {
+		got, ok := getPolicyCopyDestination(tc.target, tc.si, tc.di)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("getPolicyCopyDestination(%v, %v, %v) = %v, %v, want %v, %v", tc.target, tc.si, tc.di, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestFlagOrArg(t *testing.T) {
+	if got, err := flagOrArg("a", "", "from", "source"); err != nil || got != "a" {
+		t.Errorf("unexpected result: %v, %v", got, err)
+	}
+
+	if got, err := flagOrArg("", "b", "from", "source"); err != nil || got != "b" {
+		t.Errorf("unexpected result: %v, %v", got, err)
+	}
+
+	if _, err := flagOrArg("a", "b", "from", "source"); err == nil {
+		t.Errorf("expected error when both flag and argument are specified")
+	}
+}
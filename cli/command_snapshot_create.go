@@ -2,22 +2,33 @@
 
 import (
 	"context"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/kopia/kopia/fs/virtualfs"
 	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/manifest"
 	"github.com/kopia/kopia/snapshot"
 	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotcatalog"
+	"github.com/kopia/kopia/snapshot/snapshotcreate"
 	"github.com/kopia/kopia/snapshot/snapshotfs"
+	"github.com/kopia/kopia/snapshot/snapshotgroup"
+	"github.com/kopia/kopia/snapshot/snapshothealth"
+	"github.com/kopia/kopia/snapshot/snapshotpause"
 )
 
 const (
 	maxSnapshotDescriptionLength = 1024
 	timeFormat                   = "2006-01-02 15:04:05 MST"
+	snapshotPauseCheckInterval   = time.Minute
 )
 
 var (
@@ -25,6 +36,8 @@
 
 	snapshotCreateSources                 = snapshotCreateCommand.Arg("source", "Files or directories to create snapshot(s) of.").ExistingFilesOrDirs()
 	snapshotCreateAll                     = snapshotCreateCommand.Flag("all", "Create snapshots for files or directories previously backed up by this user on this computer").Bool()
+	snapshotCreateIgnorePause             = snapshotCreateCommand.Flag("ignore-pause", "Create snapshots even if snapshots have been paused repository-wide").Bool()
+	snapshotCreateGroups                  = snapshotCreateCommand.Flag("group", "Create snapshots of all sources of this user on this computer that belong to the provided group").PlaceHolder("GROUP").Strings()
 	snapshotCreateCheckpointUploadLimitMB = snapshotCreateCommand.Flag("upload-limit-mb", "Stop the backup process after the specified amount of data (in MB) has been uploaded.").PlaceHolder("MB").Default("0").Int64()
 	snapshotCreateCheckpointInterval      = snapshotCreateCommand.Flag("checkpoint-interval", "Frequency for creating periodic checkpoint.").Duration()
 	snapshotCreateDescription             = snapshotCreateCommand.Flag("description", "Free-form snapshot description.").String()
@@ -32,12 +45,20 @@
 	snapshotCreateParallelUploads         = snapshotCreateCommand.Flag("parallel", "Upload N files in parallel").PlaceHolder("N").Default("0").Int()
 	snapshotCreateStartTime               = snapshotCreateCommand.Flag("start-time", "Override snapshot start timestamp.").String()
 	snapshotCreateEndTime                 = snapshotCreateCommand.Flag("end-time", "Override snapshot end timestamp.").String()
+	snapshotCreateBuildCatalog            = snapshotCreateCommand.Flag("catalog", "Build searchable catalog of the snapshot (see 'kopia find').").Bool()
+	snapshotCreateCatalogMetadata         = snapshotCreateCommand.Flag("catalog-metadata", "Extract metadata of files with the provided extensions (e.g. 'jpg,pdf' or 'all') into the catalog, implies --catalog.").Strings()
+	snapshotCreateAtomicGroup             = snapshotCreateCommand.Flag("atomic-group", "Save snapshots of all sources as a snapshot group with the provided name, only if all of them succeed.").PlaceHolder("NAME").String()
+	snapshotCreateDirectoryDeltas         = snapshotCreateCommand.Flag("directory-deltas", "Store large directories with few changes as deltas against previous snapshot (not readable by older versions of kopia).").Hidden().Bool()
+	snapshotCreateProfilePaths            = snapshotCreateCommand.Flag("profile-paths", "Measure time spent scanning, reading and hashing each directory and report the slowest directories and files.").Bool()
+	snapshotCreateProfilePathsTop         = snapshotCreateCommand.Flag("profile-paths-top", "Number of slowest directories and files to report with --profile-paths.").PlaceHolder("N").Default("10").Int()
+	snapshotCreateStdinFile               = snapshotCreateCommand.Flag("stdin-file", "Create a snapshot of a single file read from standard input, stored under the provided virtual path (e.g. 'pg_dump db | kopia snapshot create --stdin-file=/dumps/db.sql').").PlaceHolder("PATH").String()
 )
 
 func runSnapshotCommand(ctx context.Context, rep repo.Repository) error {
 	sources := *snapshotCreateSources
 
 	maybeAutoUpgradeRepository(ctx, rep)
+	maybeSyncUpdatePolicy(ctx, rep)
 
 	if *snapshotCreateAll {
 		local, err := getLocalBackupPaths(ctx, rep)
@@ -48,6 +69,27 @@ func runSnapshotCommand(ctx context.Context, rep repo.Repository) error {
 		sources = append(sources, local...)
 	}
 
+	for _, g := range *snapshotCreateGroups {
+		groupSources, err := getLocalGroupPaths(ctx, rep, g)
+		if err != nil {
+			return err
+		}
+
+		if len(groupSources) == 0 {
+			return errors.Errorf("no sources in group %q", g)
+		}
+
+		sources = append(sources, groupSources...)
+	}
+
+	if p := *snapshotCreateStdinFile; p != "" {
+		if len(sources) > 0 {
+			return errors.New("--stdin-file can't be combined with other snapshot sources")
+		}
+
+		sources = append(sources, p)
+	}
+
 	if len(sources) == 0 {
 		return errors.New("no snapshot sources")
 	}
@@ -60,25 +102,53 @@ func runSnapshotCommand(ctx context.Context, rep repo.Repository) error {
 		return errors.New("description too long")
 	}
 
+	if _, err := catalogBuildOptions(*snapshotCreateCatalogMetadata); err != nil {
+		return err
+	}
+
+	if !*snapshotCreateIgnorePause {
+		if err := checkSnapshotsNotPaused(ctx, rep); err != nil {
+			return err
+		}
+	}
+
 	u := setupUploader(rep)
 
-	var finalErrors []string
+	if !*snapshotCreateIgnorePause {
+		watchCtx, cancelWatch := context.WithCancel(ctx)
+		defer cancelWatch()
 
-	for _, snapshotDir := range sources {
-		if u.IsCanceled() {
-			log(ctx).Infof("Upload canceled")
-			break
-		}
+		go snapshotpause.Watch(watchCtx, rep, snapshotPauseCheckInterval, func(st *snapshotpause.State) {
+			log(ctx).Warningf("Snapshots have been paused by %v, checkpointing: %v", st.PausedBy, st.Reason)
+			u.Cancel()
+		})
+	}
+
+	var sourceInfos []snapshot.SourceInfo
 
+	for _, snapshotDir := range sources {
 		dir, err := filepath.Abs(snapshotDir)
 		if err != nil {
 			return errors.Errorf("invalid source: '%s': %s", snapshotDir, err)
 		}
 
-		sourceInfo := snapshot.SourceInfo{
+		sourceInfos = append(sourceInfos, snapshot.SourceInfo{
 			Path:     filepath.Clean(dir),
 			Host:     rep.ClientOptions().Hostname,
 			UserName: rep.ClientOptions().Username,
+		})
+	}
+
+	if g := *snapshotCreateAtomicGroup; g != "" {
+		return snapshotAtomicGroup(ctx, rep, u, g, sourceInfos)
+	}
+
+	var finalErrors []string
+
+	for _, sourceInfo := range sourceInfos {
+		if u.IsCanceled() {
+			log(ctx).Infof("Upload canceled")
+			break
 		}
 
 		if err := snapshotSingleSource(ctx, rep, u, sourceInfo); err != nil {
@@ -90,7 +160,25 @@ func runSnapshotCommand(ctx context.Context, rep repo.Repository) error {
 		return nil
 	}
 
-	return errors.Errorf("encountered %v errors:\n%v", len(finalErrors), strings.Join(finalErrors, "\n"))
+	err := errors.Errorf("encountered %v errors:\n%v", len(finalErrors), strings.Join(finalErrors, "\n"))
+	if len(finalErrors) < len(sourceInfos) {
+		return withCategory(ErrorCategoryPartialSuccess, err)
+	}
+
+	return err
+}
+
+func checkSnapshotsNotPaused(ctx context.Context, rep repo.Repository) error {
+	st, err := snapshotpause.Get(ctx, rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to determine whether snapshots are paused")
+	}
+
+	if st != nil {
+		return errors.Errorf("snapshots have been paused by %v since %v: %v (use --ignore-pause to override)", st.PausedBy, formatTimestamp(st.Since), st.Reason)
+	}
+
+	return nil
 }
 
 func validateStartEndTime(st, et string) error {
@@ -120,7 +208,8 @@ func setupUploader(rep repo.Repository) *snapshotfs.Uploader {
 	}
 
 	u.ForceHashPercentage = *snapshotCreateForceHash
-	u.ParallelUploads = *snapshotCreateParallelUploads
+	u.ParallelUploads = limitParallelism(*snapshotCreateParallelUploads)
+	u.UseDirectoryDeltas = *snapshotCreateDirectoryDeltas
 	onCtrlC(u.Cancel)
 
 	u.Progress = progress
@@ -142,129 +231,193 @@ func startTimeAfterEndTime(startTime, endTime time.Time) bool {
 		startTime.After(endTime)
 }
 
-func snapshotSingleSource(ctx context.Context, rep repo.Repository, u *snapshotfs.Uploader, sourceInfo snapshot.SourceInfo) error {
-	log(ctx).Infof("Snapshotting %v ...", sourceInfo)
-
-	t0 := clock.Now()
-
-	localEntry, err := getLocalFSEntry(ctx, sourceInfo.Path)
-	if err != nil {
-		return errors.Wrap(err, "unable to get local filesystem entry")
-	}
+// pendingSnapshot is a snapshot that has been uploaded but whose manifest has not been saved yet.
+type pendingSnapshot struct {
+	manifest     *snapshot.Manifest
+	healthReport *snapshothealth.Report
+	startTime    time.Time
+	profile      *snapshotfs.PathProfile
+}
 
-	previous, err := findPreviousSnapshotManifest(ctx, rep, sourceInfo, nil)
+func snapshotSingleSource(ctx context.Context, rep repo.Repository, u *snapshotfs.Uploader, sourceInfo snapshot.SourceInfo) error {
+	ps, err := uploadSingleSource(ctx, rep, u, sourceInfo)
 	if err != nil {
 		return err
 	}
 
-	policyTree, err := policy.TreeForSource(ctx, rep, sourceInfo)
+	snapID, err := snapshot.SaveSnapshot(ctx, rep, ps.manifest)
 	if err != nil {
-		return errors.Wrap(err, "unable to get policy tree")
+		return errors.Wrap(err, "cannot save manifest")
 	}
 
-	log(ctx).Debugf("uploading %v using %v previous manifests", sourceInfo, len(previous))
-
-	manifest, err := u.Upload(ctx, localEntry, policyTree, sourceInfo, previous...)
-	if err != nil {
+	if err := finishSingleSource(ctx, rep, ps, snapID); err != nil {
 		return err
 	}
 
-	manifest.Description = *snapshotCreateDescription
+	return errors.Wrap(rep.Flush(ctx), "flush error")
+}
+
+// uploadSingleSource uploads the provided source and returns the snapshot manifest without saving it.
+func uploadSingleSource(ctx context.Context, rep repo.Repository, u *snapshotfs.Uploader, sourceInfo snapshot.SourceInfo) (*pendingSnapshot, error) {
+	log(ctx).Infof("Snapshotting %v ...", sourceInfo)
+
+	t0 := clock.Now()
+
 	startTimeOverride, _ := parseTimestamp(*snapshotCreateStartTime)
 	endTimeOverride, _ := parseTimestamp(*snapshotCreateEndTime)
 
-	if !startTimeOverride.IsZero() {
-		if endTimeOverride.IsZero() {
-			// Calculate the correct end time based on current duration if they're not specified
-			duration := manifest.EndTime.Sub(manifest.StartTime)
-			manifest.EndTime = startTimeOverride.Add(duration)
-		}
-
-		manifest.StartTime = startTimeOverride
+	opt := snapshotcreate.Options{
+		Description: *snapshotCreateDescription,
+		StartTime:   startTimeOverride,
+		EndTime:     endTimeOverride,
+		LocalEntry:  getLocalFSEntry,
 	}
 
-	if !endTimeOverride.IsZero() {
-		if startTimeOverride.IsZero() {
-			inverseDuration := manifest.StartTime.Sub(manifest.EndTime)
-			manifest.StartTime = endTimeOverride.Add(inverseDuration)
-		}
+	if *snapshotCreateStdinFile != "" {
+		// the virtual file is always considered modified, since its contents can't be compared without reading them.
+		opt.Entry = virtualfs.NewStreamingFile(filepath.Base(sourceInfo.Path), clock.Now(), os.Stdin)
+	}
 
-		manifest.EndTime = endTimeOverride
+	if *snapshotCreateProfilePaths {
+		u.Profile = snapshotfs.NewPathProfile(*snapshotCreateProfilePathsTop)
+		defer func() { u.Profile = nil }()
 	}
 
-	snapID, err := snapshot.SaveSnapshot(ctx, rep, manifest)
+	p, err := snapshotcreate.Upload(ctx, rep, u, sourceInfo, opt)
 	if err != nil {
-		return errors.Wrap(err, "cannot save manifest")
+		return nil, err
 	}
 
-	if _, err = policy.ApplyRetentionPolicy(ctx, rep, sourceInfo, true); err != nil {
-		return errors.Wrap(err, "unable to apply retention policy")
+	return &pendingSnapshot{p.Manifest, p.HealthReport, t0, u.Profile}, nil
+}
+
+// finishSingleSource performs post-snapshot tasks after the manifest of the snapshot has been saved,
+// the caller is responsible for flushing the repository.
+func finishSingleSource(ctx context.Context, rep repo.Repository, ps *pendingSnapshot, snapID manifest.ID) error {
+	man := ps.manifest
+	sourceInfo := man.Source
+
+	notifyAnomalies(ctx, man, ps.healthReport)
+
+	if (*snapshotCreateBuildCatalog || len(*snapshotCreateCatalogMetadata) > 0) && man.IncompleteReason == "" {
+		opt, err := catalogBuildOptions(*snapshotCreateCatalogMetadata)
+		if err != nil {
+			return err
+		}
+
+		if _, err = snapshotcatalog.Build(ctx, rep, man, opt); err != nil {
+			return errors.Wrap(err, "unable to build snapshot catalog")
+		}
 	}
 
-	if ferr := rep.Flush(ctx); ferr != nil {
-		return errors.Wrap(ferr, "flush error")
+	if err := snapshotcreate.ApplyRetentionPolicy(ctx, rep, sourceInfo); err != nil {
+		return err
 	}
 
 	progress.Finish()
 
 	var maybePartial string
-	if manifest.IncompleteReason != "" {
+	if man.IncompleteReason != "" {
 		maybePartial = " partial"
 	}
 
-	if ds := manifest.RootEntry.DirSummary; ds != nil {
+	if ds := man.RootEntry.DirSummary; ds != nil {
 		if ds.NumFailed > 0 {
 			log(ctx).Warningf("Ignored %v errors while snapshotting %v.", ds.NumFailed, sourceInfo)
 		}
 	}
 
-	log(ctx).Infof("Created%v snapshot with root %v and ID %v in %v", maybePartial, manifest.RootObjectID(), snapID, clock.Since(t0).Truncate(time.Second))
+	log(ctx).Infof("Created%v snapshot with root %v and ID %v in %v", maybePartial, man.RootObjectID(), snapID, clock.Since(ps.startTime).Truncate(time.Second))
 
-	return err
+	if ps.profile != nil {
+		printPathProfile(ps.profile, *snapshotCreateProfilePathsTop)
+	}
+
+	return nil
 }
 
-// findPreviousSnapshotManifest returns the list of previous snapshots for a given source, including
-// last complete snapshot and possibly some number of incomplete snapshots following it.
-func findPreviousSnapshotManifest(ctx context.Context, rep repo.Repository, sourceInfo snapshot.SourceInfo, noLaterThan *time.Time) ([]*snapshot.Manifest, error) {
-	man, err := snapshot.ListSnapshots(ctx, rep, sourceInfo)
-	if err != nil {
-		return nil, errors.Wrap(err, "error listing previous snapshots")
+func printPathProfile(p *snapshotfs.PathProfile, n int) {
+	printStdout("\nSlowest directories:\n")
+	printStdout("  %-10v %-10v %-10v %-10v %6v %10v  %v\n", "TOTAL", "SCAN", "READ", "HASH", "FILES", "BYTES", "PATH")
+
+	for _, d := range p.SlowestDirectories(n) {
+		printStdout("  %-10v %-10v %-10v %-10v %6v %10v  %v\n",
+			d.TotalTime().Truncate(time.Millisecond),
+			d.ScanTime.Truncate(time.Millisecond),
+			d.ReadTime.Truncate(time.Millisecond),
+			d.HashTime.Truncate(time.Millisecond),
+			d.Files,
+			units.BytesStringBase10(d.Bytes),
+			d.Path)
 	}
 
-	// phase 1 - find latest complete snapshot.
-	var previousComplete *snapshot.Manifest
+	printStdout("\nSlowest files:\n")
+	printStdout("  %-10v %-10v %-10v %10v  %v\n", "TOTAL", "READ", "HASH", "BYTES", "PATH")
 
-	var previousCompleteStartTime time.Time
+	for _, f := range p.SlowestFiles(n) {
+		printStdout("  %-10v %-10v %-10v %10v  %v\n",
+			f.TotalTime().Truncate(time.Millisecond),
+			f.ReadTime.Truncate(time.Millisecond),
+			f.HashTime.Truncate(time.Millisecond),
+			units.BytesStringBase10(f.Bytes),
+			f.Path)
+	}
+}
 
-	var result []*snapshot.Manifest
+// snapshotAtomicGroup uploads all sources and saves their snapshots as members of a snapshot group
+// only if all of them have completed successfully.
+func snapshotAtomicGroup(ctx context.Context, rep repo.Repository, u *snapshotfs.Uploader, groupName string, sources []snapshot.SourceInfo) error {
+	var pending []*pendingSnapshot
 
-	for _, p := range man {
-		if noLaterThan != nil && p.StartTime.After(*noLaterThan) {
-			continue
+	for _, sourceInfo := range sources {
+		if u.IsCanceled() {
+			return errors.Errorf("upload canceled, snapshot group %q was not created", groupName)
+		}
+
+		ps, err := uploadSingleSource(ctx, rep, u, sourceInfo)
+		if err != nil {
+			return errors.Wrapf(err, "error snapshotting %v, snapshot group %q was not created", sourceInfo, groupName)
 		}
 
-		if p.IncompleteReason == "" && (previousComplete == nil || p.StartTime.After(previousComplete.StartTime)) {
-			previousComplete = p
-			previousCompleteStartTime = p.StartTime
+		if ps.manifest.IncompleteReason != "" {
+			return errors.Errorf("snapshot of %v is incomplete (%v), snapshot group %q was not created", sourceInfo, ps.manifest.IncompleteReason, groupName)
 		}
+
+		pending = append(pending, ps)
 	}
 
-	if previousComplete != nil {
-		result = append(result, previousComplete)
+	var members []*snapshot.Manifest
+
+	for _, ps := range pending {
+		members = append(members, ps.manifest)
 	}
 
-	// add all incomplete snapshots after that
-	for _, p := range man {
-		if noLaterThan != nil && p.StartTime.After(*noLaterThan) {
-			continue
-		}
+	g, err := snapshotgroup.Commit(ctx, rep, groupName, *snapshotCreateDescription, members)
+	if err != nil {
+		return errors.Wrap(err, "unable to commit snapshot group")
+	}
+
+	var finalErrors []string
 
-		if p.IncompleteReason != "" && p.StartTime.After(previousCompleteStartTime) {
-			result = append(result, p)
+	for i, ps := range pending {
+		if err := finishSingleSource(ctx, rep, ps, g.Members[i].SnapshotID); err != nil {
+			finalErrors = append(finalErrors, err.Error())
 		}
 	}
 
-	return result, nil
+	// manifests written after the group has been committed are flushed together.
+	if err := rep.Flush(ctx); err != nil {
+		return errors.Wrap(err, "flush error")
+	}
+
+	log(ctx).Infof("Created snapshot group %q with ID %v and %v members", groupName, g.ID, len(g.Members))
+
+	if len(finalErrors) == 0 {
+		return nil
+	}
+
+	// the group was committed, so only some of its members are incomplete.
+	return withCategory(ErrorCategoryPartialSuccess, errors.Errorf("encountered %v errors:\n%v", len(finalErrors), strings.Join(finalErrors, "\n")))
 }
 
 func getLocalBackupPaths(ctx context.Context, rep repo.Repository) ([]string, error) {
@@ -286,6 +439,51 @@ func getLocalBackupPaths(ctx context.Context, rep repo.Repository) ([]string, er
 	return result, nil
 }
 
+// getLocalGroupPaths returns paths of sources of the current user on this computer that have policies
+// or snapshots and belong to the provided group according to their effective policy.
+func getLocalGroupPaths(ctx context.Context, rep repo.Repository, group string) ([]string, error) {
+	candidates := map[snapshot.SourceInfo]bool{}
+
+	sources, err := snapshot.ListSources(ctx, rep)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list sources")
+	}
+
+	for _, src := range sources {
+		candidates[src] = true
+	}
+
+	policies, err := policy.ListPolicies(ctx, rep)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list policies")
+	}
+
+	for _, pol := range policies {
+		candidates[pol.Target()] = true
+	}
+
+	var result []string
+
+	for src := range candidates {
+		if src.Host != rep.ClientOptions().Hostname || src.UserName != rep.ClientOptions().Username || src.Path == "" {
+			continue
+		}
+
+		pol, _, err := policy.GetEffectivePolicy(ctx, rep, src)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to get effective policy for %v", src)
+		}
+
+		if pol.SchedulingPolicy.InGroup(group) {
+			result = append(result, src.Path)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result, nil
+}
+
 func init() {
 	snapshotCreateCommand.Action(repositoryAction(runSnapshotCommand))
 }
@@ -10,6 +10,7 @@
 	"github.com/kopia/kopia/repo/manifest"
 	"github.com/kopia/kopia/repo/object"
 	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
 )
 
 var (
@@ -39,6 +40,10 @@ func runDeleteCommand(ctx context.Context, rep repo.Repository) error {
 func deleteSnapshot(ctx context.Context, rep repo.Repository, m *snapshot.Manifest) error {
 	desc := fmt.Sprintf("snapshot %v of %v at %v", m.ID, m.Source, formatTimestamp(m.StartTime))
 
+	if err := policy.EnsureNotHeld(ctx, rep, m); err != nil {
+		return err
+	}
+
 	if !*snapshotDeleteConfirm {
 		log(ctx).Infof("Would delete %v (pass --delete to confirm)\n", desc)
 		return nil
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/snapshot/snapshotgroup"
+)
+
+var (
+	snapshotGroupCommands = snapshotCommands.Command("group", "Manage snapshot groups created using 'kopia snapshot create --atomic-group'.")
+
+	snapshotGroupListCommand = snapshotGroupCommands.Command("list", "List snapshot groups.").Alias("ls")
+	snapshotGroupListName    = snapshotGroupListCommand.Arg("name", "Name of the snapshot group").String()
+
+	snapshotGroupRestoreCommand = snapshotGroupCommands.Command("restore", "Restore all snapshots of a group into subdirectories of the target path named after their sources.")
+	snapshotGroupRestoreID      = ""
+)
+
+func init() {
+	snapshotGroupRestoreCommand.Arg("group", "Snapshot group ID or name (restores the latest group with that name)").Required().StringVar(&snapshotGroupRestoreID)
+	addRestoreOutputFlags(snapshotGroupRestoreCommand)
+
+	snapshotGroupListCommand.Action(repositoryAction(runSnapshotGroupListCommand))
+	snapshotGroupRestoreCommand.Action(repositoryAction(runSnapshotGroupRestoreCommand))
+}
+
+func runSnapshotGroupListCommand(ctx context.Context, rep repo.Repository) error {
+	groups, err := snapshotgroup.List(ctx, rep, *snapshotGroupListName)
+	if err != nil {
+		return err
+	}
+
+	for _, g := range groups {
+		printStdout("%v %v %v", g.ID, g.Name, formatTimestamp(g.StartTime))
+
+		if g.Description != "" {
+			printStdout(" %q", g.Description)
+		}
+
+		printStdout("\n")
+
+		for _, m := range g.Members {
+			printStdout("  %v %v\n", m.SnapshotID, m.Source)
+		}
+	}
+
+	return nil
+}
+
+func runSnapshotGroupRestoreCommand(ctx context.Context, rep repo.Repository) error {
+	g, err := snapshotgroup.Find(ctx, rep, snapshotGroupRestoreID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to find snapshot group %q", snapshotGroupRestoreID)
+	}
+
+	rootEntry, err := g.RootEntry(ctx, rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get snapshot group root")
+	}
+
+	log(ctx).Infof("Restoring snapshot group %q (%v) with %v members...", g.Name, g.ID, len(g.Members))
+
+	return restoreRootEntry(ctx, rep, rootEntry)
+}
@@ -0,0 +1,213 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshothealth"
+)
+
+const anomalyNotifyTimeout = time.Minute
+
+var (
+	snapshotHealthCommands = snapshotCommands.Command("health", "Show health of snapshot sources and manage detected anomalies.")
+
+	snapshotHealthListCommand = snapshotHealthCommands.Command("list", "Show health score and detected anomalies of each source.").Default().Alias("ls")
+	snapshotHealthListSources = snapshotHealthListCommand.Arg("source", "Sources to show").Strings()
+
+	snapshotHealthAckCommand = snapshotHealthCommands.Command("acknowledge", "Acknowledge anomalies of snapshots as expected, which allows expiration of earlier snapshots.").Alias("ack")
+	snapshotHealthAckIDs     = snapshotHealthAckCommand.Arg("id", "Snapshot ID").Required().Strings()
+
+	snapshotCreateAnomalyNotifyCommand = snapshotCreateCommand.Flag("anomaly-notify-command", "Command to run when anomalies are detected, receives JSON description of the anomalies on standard input").Envar("KOPIA_ANOMALY_NOTIFY_COMMAND").String()
+)
+
+func init() {
+	snapshotHealthListCommand.Action(repositoryAction(runSnapshotHealthListCommand))
+	snapshotHealthAckCommand.Action(repositoryAction(runSnapshotHealthAckCommand))
+}
+
+// anomalyNotification is passed to the anomaly notification command.
+type anomalyNotification struct {
+	Source     snapshot.SourceInfo    `json:"source"`
+	SnapshotID manifest.ID            `json:"snapshotID"`
+	StartTime  time.Time              `json:"startTime"`
+	Report     *snapshothealth.Report `json:"report"`
+}
+
+// notifyAnomalies runs the anomaly notification command, if any, after the snapshot has been saved.
+func notifyAnomalies(ctx context.Context, man *snapshot.Manifest, r *snapshothealth.Report) {
+	if *snapshotCreateAnomalyNotifyCommand == "" || r == nil || len(r.Anomalies) == 0 {
+		return
+	}
+
+	b, err := json.Marshal(&anomalyNotification{
+		Source:     man.Source,
+		SnapshotID: man.ID,
+		StartTime:  man.StartTime,
+		Report:     r,
+	})
+	if err != nil {
+		log(ctx).Warningf("unable to marshal anomaly notification: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, anomalyNotifyTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, *snapshotCreateAnomalyNotifyCommand) //nolint:gosec
+	cmd.Stdin = bytes.NewReader(b)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log(ctx).Warningf("anomaly notification command failed: %v %s", err, out)
+	}
+}
+
+func runSnapshotHealthListCommand(ctx context.Context, rep repo.Repository) error {
+	sources, err := snapshot.ListSources(ctx, rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to list sources")
+	}
+
+	if len(*snapshotHealthListSources) > 0 {
+		sources = nil
+
+		for _, s := range *snapshotHealthListSources {
+			si, err := snapshot.ParseSourceInfo(s, rep.ClientOptions().Hostname, rep.ClientOptions().Username)
+			if err != nil {
+				return errors.Wrapf(err, "invalid source %v", s)
+			}
+
+			sources = append(sources, si)
+		}
+	}
+
+	acked, err := snapshothealth.AcknowledgedSnapshotIDs(ctx, rep)
+	if err != nil {
+		return err
+	}
+
+	for _, src := range sources {
+		if err := printSourceHealth(ctx, rep, src, acked); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func printSourceHealth(ctx context.Context, rep repo.Repository, src snapshot.SourceInfo, acked map[manifest.ID]bool) error {
+	snapshots, err := snapshot.ListSnapshots(ctx, rep, src)
+	if err != nil {
+		return errors.Wrapf(err, "unable to list snapshots of %v", src)
+	}
+
+	snapshots = snapshot.SortByTime(snapshots, false)
+
+	var latest *snapshot.Manifest
+
+	for _, m := range snapshots {
+		if m.IncompleteReason == "" {
+			latest = m
+		}
+	}
+
+	if latest == nil {
+		printStdout("%v: no complete snapshots\n", src)
+		return nil
+	}
+
+	pol, _, err := policy.GetEffectivePolicy(ctx, rep, src)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get effective policy of %v", src)
+	}
+
+	r := snapshothealth.Evaluate(latest, snapshots, pol.AnomalyPolicy.DetectionOptions())
+
+	if r.Baseline == nil {
+		printStdout("%v: not enough snapshots to compute health score\n", src)
+	} else {
+		printStdout("%v: score %v/%v based on %v previous snapshots\n", src, r.Score, snapshothealth.MaxScore, r.Baseline.SnapshotCount)
+	}
+
+	// anomalies of the latest snapshot taken without anomaly detection are not recorded in its manifest.
+	if len(latest.Anomalies) == 0 && len(r.Anomalies) > 0 && !acked[latest.ID] {
+		printStdout("  %v %v (not recorded, unacknowledged):\n", latest.ID, formatTimestamp(latest.StartTime))
+
+		for _, a := range r.Anomalies {
+			printStdout("    %v\n", a.Description)
+		}
+	}
+
+	for _, m := range snapshots {
+		if len(m.Anomalies) == 0 {
+			continue
+		}
+
+		status := "unacknowledged"
+		if acked[m.ID] {
+			status = "acknowledged"
+		}
+
+		printStdout("  %v %v (%v):\n", m.ID, formatTimestamp(m.StartTime), status)
+
+		for _, a := range m.Anomalies {
+			printStdout("    %v\n", a.Description)
+		}
+	}
+
+	return nil
+}
+
+func runSnapshotHealthAckCommand(ctx context.Context, rep repo.Repository) error {
+	for _, id := range *snapshotHealthAckIDs {
+		m, err := snapshot.LoadSnapshot(ctx, rep, manifest.ID(id))
+		if err != nil {
+			return errors.Wrapf(err, "error loading snapshot %v", id)
+		}
+
+		if len(m.Anomalies) == 0 {
+			// snapshots taken without anomaly detection may still block expiration due to signs of ransomware.
+			indicators, err := snapshotRansomwareIndicators(ctx, rep, m)
+			if err != nil {
+				return err
+			}
+
+			if len(indicators) == 0 {
+				log(ctx).Infof("Snapshot %v has no anomalies.", id)
+				continue
+			}
+		}
+
+		if err := snapshothealth.Acknowledge(ctx, rep, m); err != nil {
+			return errors.Wrapf(err, "unable to acknowledge anomalies of %v", id)
+		}
+
+		log(ctx).Infof("Acknowledged anomalies of %v.", describeSnapshot(m))
+	}
+
+	return nil
+}
+
+// snapshotRansomwareIndicators returns signs of ransomware in the provided snapshot according to the current policy of its source.
+func snapshotRansomwareIndicators(ctx context.Context, rep repo.Repository, m *snapshot.Manifest) ([]snapshot.Anomaly, error) {
+	history, err := snapshot.ListSnapshots(ctx, rep, m.Source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list snapshots of %v", m.Source)
+	}
+
+	pol, _, err := policy.GetEffectivePolicy(ctx, rep, m.Source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get effective policy of %v", m.Source)
+	}
+
+	return policy.RansomwareIndicators(m, history, pol), nil
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/legalhold"
+)
+
+var (
+	snapshotHoldCommands = snapshotCommands.Command("hold", "Manage legal holds, which protect snapshots from deletion and modification.")
+
+	snapshotHoldSetCommand = snapshotHoldCommands.Command("set", "Put snapshots under legal hold.")
+	snapshotHoldSetIDs     = snapshotHoldSetCommand.Arg("id", "Snapshot ID").Required().Strings()
+	snapshotHoldSetReason  = snapshotHoldSetCommand.Flag("reason", "Reason for the legal hold").String()
+
+	snapshotHoldClearCommand = snapshotHoldCommands.Command("clear", "Release snapshots from legal hold.")
+	snapshotHoldClearIDs     = snapshotHoldClearCommand.Arg("id", "Snapshot ID").Required().Strings()
+
+	snapshotHoldListCommand = snapshotHoldCommands.Command("list", "List snapshots under legal hold.").Alias("ls")
+)
+
+func init() {
+	snapshotHoldSetCommand.Action(repositoryAction(runSnapshotHoldSetCommand))
+	snapshotHoldClearCommand.Action(repositoryAction(runSnapshotHoldClearCommand))
+	snapshotHoldListCommand.Action(repositoryAction(runSnapshotHoldListCommand))
+}
+
+func runSnapshotHoldSetCommand(ctx context.Context, rep repo.Repository) error {
+	for _, id := range *snapshotHoldSetIDs {
+		m, err := snapshot.LoadSnapshot(ctx, rep, manifest.ID(id))
+		if err != nil {
+			return errors.Wrapf(err, "error loading snapshot %v", id)
+		}
+
+		if _, err := legalhold.Set(ctx, rep, m, *snapshotHoldSetReason); err != nil {
+			return errors.Wrapf(err, "unable to put %v under legal hold", id)
+		}
+
+		log(ctx).Infof("Put %v under legal hold.", describeSnapshot(m))
+	}
+
+	return nil
+}
+
+func runSnapshotHoldClearCommand(ctx context.Context, rep repo.Repository) error {
+	for _, id := range *snapshotHoldClearIDs {
+		h, err := legalhold.Get(ctx, rep, manifest.ID(id))
+		if err != nil {
+			return errors.Wrapf(err, "unable to get legal hold of %v", id)
+		}
+
+		if h == nil {
+			log(ctx).Infof("Snapshot %v is not under legal hold.", id)
+			continue
+		}
+
+		if err := legalhold.Clear(ctx, rep, manifest.ID(id)); err != nil {
+			return errors.Wrapf(err, "unable to release %v from legal hold", id)
+		}
+
+		log(ctx).Infof("Released snapshot %v of %v from legal hold.", id, h.Source)
+	}
+
+	return nil
+}
+
+func runSnapshotHoldListCommand(ctx context.Context, rep repo.Repository) error {
+	holds, err := legalhold.List(ctx, rep)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(holds, func(i, j int) bool {
+		return holds[i].Since.Before(holds[j].Since)
+	})
+
+	for _, h := range holds {
+		printStdout("%v %v at %v held by %v since %v", h.SnapshotID, h.Source, formatTimestamp(h.StartTime), h.HeldBy, formatTimestamp(h.Since))
+
+		if h.Reason != "" {
+			printStdout(": %v", h.Reason)
+		}
+
+		printStdout("\n")
+	}
+
+	return nil
+}
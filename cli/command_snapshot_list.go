@@ -136,6 +136,10 @@ func outputManifestGroups(ctx context.Context, rep repo.Repository, manifests []
 			pol.RetentionPolicy.ComputeRetentionReasons(snapshotGroup)
 		}
 
+		if err := policy.MarkHeldSnapshots(ctx, rep, snapshotGroup); err != nil {
+			log(ctx).Warningf("unable to determine legal holds for %v", src)
+		}
+
 		if err := outputManifestFromSingleSource(ctx, rep, snapshotGroup, relPathParts); err != nil {
 			return err
 		}
@@ -231,6 +235,22 @@ func entryBits(ctx context.Context, m *snapshot.Manifest, ent fs.Entry, lastTota
 		bits = append(bits, "incomplete:"+m.IncompleteReason)
 	}
 
+	if m.Group != "" {
+		bits = append(bits, "group:"+m.Group)
+	}
+
+	if m.Application != nil {
+		bits = append(bits, "app:"+m.Application.Recipe)
+	}
+
+	if len(m.Pins) > 0 {
+		bits = append(bits, "pins:"+strings.Join(m.Pins, ","))
+	}
+
+	if len(m.RedactedPaths) > 0 {
+		bits = append(bits, fmt.Sprintf("redacted:%v", len(m.RedactedPaths)))
+	}
+
 	bits = append(bits,
 		maybeHumanReadableBytes(*snapshotListShowHumanReadable, ent.Size()),
 		fmt.Sprintf("%v", ent.Mode()))
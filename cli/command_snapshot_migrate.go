@@ -11,6 +11,7 @@
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/snapshot"
 	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotcreate"
 	"github.com/kopia/kopia/snapshot/snapshotfs"
 )
 
@@ -249,7 +250,7 @@ func migrateSingleSourceSnapshot(ctx context.Context, uploader *snapshotfs.Uploa
 
 	log(ctx).Infof("migrating snapshot of %v at %v", s, formatTimestamp(m.StartTime))
 
-	previous, err := findPreviousSnapshotManifest(ctx, destRepo, m.Source, &m.StartTime)
+	previous, err := snapshotcreate.FindPreviousManifests(ctx, destRepo, m.Source, &m.StartTime)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+)
+
+var (
+	snapshotPinCommand = snapshotCommands.Command("pin", "Pin snapshots, which protects them from being expired by the retention policy until unpinned.")
+	snapshotPinIDs     = snapshotPinCommand.Arg("id", "Snapshot ID").Required().Strings()
+	snapshotPinReasons = snapshotPinCommand.Flag("reason", "Name of the pin (e.g. 'legal-hold' or 'release-1.0')").Required().Strings()
+
+	snapshotUnpinCommand = snapshotCommands.Command("unpin", "Remove pins from snapshots.")
+	snapshotUnpinIDs     = snapshotUnpinCommand.Arg("id", "Snapshot ID").Required().Strings()
+	snapshotUnpinReasons = snapshotUnpinCommand.Flag("reason", "Name of the pin to remove (all pins are removed when not specified)").Strings()
+)
+
+func init() {
+	snapshotPinCommand.Action(repositoryAction(runSnapshotPinCommand))
+	snapshotUnpinCommand.Action(repositoryAction(runSnapshotUnpinCommand))
+}
+
+func runSnapshotPinCommand(ctx context.Context, rep repo.Repository) error {
+	for _, r := range *snapshotPinReasons {
+		if strings.TrimSpace(r) == "" {
+			return errors.New("pin name must not be empty")
+		}
+	}
+
+	return updateSnapshotPins(ctx, rep, *snapshotPinIDs, func(m *snapshot.Manifest) bool {
+		return m.UpdatePins(*snapshotPinReasons, nil)
+	})
+}
+
+func runSnapshotUnpinCommand(ctx context.Context, rep repo.Repository) error {
+	return updateSnapshotPins(ctx, rep, *snapshotUnpinIDs, func(m *snapshot.Manifest) bool {
+		if len(*snapshotUnpinReasons) == 0 {
+			return m.UpdatePins(nil, m.Pins)
+		}
+
+		return m.UpdatePins(nil, *snapshotUnpinReasons)
+	})
+}
+
+func updateSnapshotPins(ctx context.Context, rep repo.Repository, ids []string, update func(m *snapshot.Manifest) bool) error {
+	for _, id := range ids {
+		m, err := snapshot.LoadSnapshot(ctx, rep, manifest.ID(id))
+		if err != nil {
+			return errors.Wrapf(err, "error loading snapshot %v", id)
+		}
+
+		if !update(m) {
+			log(ctx).Infof("Pins of %v are unchanged.", describeSnapshot(m))
+			continue
+		}
+
+		if err := snapshot.UpdateSnapshot(ctx, rep, m); err != nil {
+			return errors.Wrapf(err, "unable to update pins of %v", id)
+		}
+
+		if len(m.Pins) == 0 {
+			log(ctx).Infof("Unpinned %v.", describeSnapshot(m))
+		} else {
+			log(ctx).Infof("Pinned %v: %v", describeSnapshot(m), strings.Join(m.Pins, ", "))
+		}
+	}
+
+	return nil
+}
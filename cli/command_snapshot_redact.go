@@ -0,0 +1,190 @@
+package cli
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotcatalog"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+var (
+	snapshotRedactCommand      = snapshotCommands.Command("redact", "Remove files or directories from existing snapshots.")
+	snapshotRedactIDs          = snapshotRedactCommand.Arg("id", "Snapshot ID").Required().Strings()
+	snapshotRedactPaths        = snapshotRedactCommand.Flag("path", "Path to remove, relative to snapshot root or absolute within the source").Required().Strings()
+	snapshotRedactAllSnapshots = snapshotRedactCommand.Flag("all-snapshots", "Redact all snapshots of the source(s) of the provided snapshots").Bool()
+	snapshotRedactConfirm      = snapshotRedactCommand.Flag("delete", "Confirm redaction").Bool()
+)
+
+func init() {
+	snapshotRedactCommand.Action(repositoryAction(runSnapshotRedactCommand))
+}
+
+func runSnapshotRedactCommand(ctx context.Context, rep repo.Repository) error {
+	manifests, err := snapshotsToRedact(ctx, rep)
+	if err != nil {
+		return err
+	}
+
+	redacted := 0
+
+	for _, m := range manifests {
+		ok, err := redactSnapshot(ctx, rep, m)
+		if err != nil {
+			return errors.Wrapf(err, "error redacting snapshot %v", m.ID)
+		}
+
+		if ok {
+			redacted++
+		}
+	}
+
+	if redacted == 0 {
+		return errors.Errorf("none of the provided paths were found in the snapshots")
+	}
+
+	if !*snapshotRedactConfirm {
+		log(ctx).Infof("Would redact %v snapshots (pass --delete to confirm)", redacted)
+		return nil
+	}
+
+	log(ctx).Infof("Redacted %v snapshots. Removed data will be deleted from the repository by full maintenance.", redacted)
+
+	return nil
+}
+
+func snapshotsToRedact(ctx context.Context, rep repo.Repository) ([]*snapshot.Manifest, error) {
+	var result []*snapshot.Manifest
+
+	seen := map[manifest.ID]bool{}
+	sources := map[snapshot.SourceInfo]bool{}
+
+	for _, id := range *snapshotRedactIDs {
+		m, err := snapshot.LoadSnapshot(ctx, rep, manifest.ID(id))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error loading snapshot %v", id)
+		}
+
+		if !seen[m.ID] {
+			seen[m.ID] = true
+
+			result = append(result, m)
+		}
+
+		sources[m.Source] = true
+	}
+
+	if !*snapshotRedactAllSnapshots {
+		return result, nil
+	}
+
+	for src := range sources {
+		all, err := snapshot.ListSnapshots(ctx, rep, src)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing snapshots of %v", src)
+		}
+
+		for _, m := range all {
+			if !seen[m.ID] {
+				seen[m.ID] = true
+
+				result = append(result, m)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// redactPathsForSnapshot returns paths to be redacted relative to the root of the provided snapshot.
+func redactPathsForSnapshot(m *snapshot.Manifest) []string {
+	var result []string
+
+	for _, p := range *snapshotRedactPaths {
+		p = filepath.ToSlash(p)
+		src := filepath.ToSlash(m.Source.Path)
+
+		if src != "" && strings.HasPrefix(p, src+"/") {
+			p = strings.TrimPrefix(p, src+"/")
+		}
+
+		result = append(result, p)
+	}
+
+	return result
+}
+
+func redactSnapshot(ctx context.Context, rep repo.Repository, m *snapshot.Manifest) (bool, error) {
+	if err := policy.EnsureNotHeld(ctx, rep, m); err != nil {
+		return false, err
+	}
+
+	res, err := snapshotfs.Redact(ctx, rep, m, redactPathsForSnapshot(m))
+	if err != nil {
+		return false, err
+	}
+
+	desc := describeSnapshot(m)
+
+	if res.Manifest == nil {
+		log(ctx).Debugf("nothing to redact in %v", desc)
+		return false, nil
+	}
+
+	if !*snapshotRedactConfirm {
+		log(ctx).Infof("Would remove %v from %v", strings.Join(res.RemovedPaths, ", "), desc)
+		return true, nil
+	}
+
+	log(ctx).Infof("Removing %v from %v", strings.Join(res.RemovedPaths, ", "), desc)
+
+	return true, replaceWithRedactedSnapshot(ctx, rep, m, res.Manifest)
+}
+
+// replaceWithRedactedSnapshot saves the redacted snapshot manifest and deletes the original one.
+func replaceWithRedactedSnapshot(ctx context.Context, rep repo.Repository, original, redacted *snapshot.Manifest) error {
+	newID, err := snapshot.SaveSnapshot(ctx, rep, redacted)
+	if err != nil {
+		return errors.Wrap(err, "unable to save redacted snapshot")
+	}
+
+	redacted.ID = newID
+
+	if err := rebuildCatalogIfPresent(ctx, rep, original, redacted); err != nil {
+		return err
+	}
+
+	return errors.Wrap(rep.DeleteManifest(ctx, original.ID), "unable to delete original snapshot")
+}
+
+func rebuildCatalogIfPresent(ctx context.Context, rep repo.Repository, original, redacted *snapshot.Manifest) error {
+	catalogs, err := snapshotcatalog.List(ctx, rep, &original.Source)
+	if err != nil {
+		return errors.Wrap(err, "unable to list catalogs")
+	}
+
+	for _, cm := range catalogs {
+		if cm.SnapshotID != original.ID {
+			continue
+		}
+
+		if err := rep.DeleteManifest(ctx, cm.ID); err != nil {
+			return errors.Wrap(err, "unable to delete catalog of original snapshot")
+		}
+
+		_, err := snapshotcatalog.Build(ctx, rep, redacted, snapshotcatalog.BuildOptions{
+			MetadataExtensions: cm.MetadataExtensions,
+		})
+
+		return errors.Wrap(err, "unable to build catalog of redacted snapshot")
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/snapshot/snapshotreport"
+)
+
+var (
+	snapshotReportCommand      = snapshotCommands.Command("report", "Summarize snapshot outcomes of all sources over a period of time, optionally sending the report by email or to a webhook.")
+	snapshotReportPeriod       = snapshotReportCommand.Flag("period", "Reporting period ending now").Default("24h").Duration()
+	snapshotReportMaxTopErrors = snapshotReportCommand.Flag("max-top-errors", "Maximum number of most frequent errors to report").Default("10").Int()
+	snapshotReportJSON         = snapshotReportCommand.Flag("json", "Output report as JSON").Bool()
+	snapshotReportWebhookURL   = snapshotReportCommand.Flag("webhook-url", "POST JSON report to the provided URL").String()
+	snapshotReportSMTPServer   = snapshotReportCommand.Flag("smtp-server", "SMTP server (host:port) used to email the report").String()
+	snapshotReportSMTPUsername = snapshotReportCommand.Flag("smtp-username", "SMTP username").String()
+	snapshotReportSMTPPassword = snapshotReportCommand.Flag("smtp-password", "SMTP password").Envar("KOPIA_REPORT_SMTP_PASSWORD").String()
+	snapshotReportMailFrom     = snapshotReportCommand.Flag("mail-from", "Sender of the report email").String()
+	snapshotReportMailTo       = snapshotReportCommand.Flag("mail-to", "Recipient of the report email").Strings()
+)
+
+func runSnapshotReportCommand(ctx context.Context, rep repo.Repository) error {
+	now := clock.Now()
+
+	report, err := snapshotreport.Generate(ctx, rep, snapshotreport.Options{
+		Start:        now.Add(-*snapshotReportPeriod),
+		End:          now,
+		MaxTopErrors: *snapshotReportMaxTopErrors,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *snapshotReportJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+
+		if err := e.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		report.WriteText(os.Stdout)
+	}
+
+	if *snapshotReportWebhookURL != "" {
+		if err := snapshotreport.PostWebhook(ctx, *snapshotReportWebhookURL, report); err != nil {
+			return err
+		}
+
+		log(ctx).Infof("Report posted to %v", *snapshotReportWebhookURL)
+	}
+
+	if *snapshotReportSMTPServer != "" {
+		if err := snapshotreport.SendEmail(snapshotreport.EmailOptions{
+			SMTPServer: *snapshotReportSMTPServer,
+			Username:   *snapshotReportSMTPUsername,
+			Password:   *snapshotReportSMTPPassword,
+			From:       *snapshotReportMailFrom,
+			To:         *snapshotReportMailTo,
+		}, report); err != nil {
+			return err
+		}
+
+		log(ctx).Infof("Report sent to %v", *snapshotReportMailTo)
+	}
+
+	return nil
+}
+
+func init() {
+	snapshotReportCommand.Action(repositoryAction(runSnapshotReportCommand))
+}
@@ -10,6 +10,7 @@
 
 	"github.com/pkg/errors"
 
+	"github.com/kopia/kopia/fs"
 	"github.com/kopia/kopia/internal/clock"
 	"github.com/kopia/kopia/internal/iocopy"
 	"github.com/kopia/kopia/internal/parallelwork"
@@ -61,6 +62,9 @@ func (v *verifier) progressCallback(ctx context.Context, enqueued, active, compl
 	log(ctx).Infof("Found %v objects, verifying %v, completed %v objects%v.", enqueued, active, completed, maybeTimeRemaining)
 }
 
+// errTooManyErrors stops iterating directory entries once the error threshold has been reached.
+var errTooManyErrors = errors.New("too many errors")
+
 func (v *verifier) tooManyErrors() bool {
 	v.mu.Lock()
 	defer v.mu.Unlock()
@@ -120,15 +124,10 @@ func (v *verifier) doVerifyDirectory(ctx context.Context, oid object.ID, path st
 
 	d := snapshotfs.DirectoryEntry(v.rep, oid, nil)
 
-	entries, err := d.Readdir(ctx)
-	if err != nil {
-		v.reportError(ctx, path, errors.Wrapf(err, "error reading %v", oid))
-		return nil
-	}
-
-	for _, e := range entries {
+	// entries are streamed, so that large directories are not loaded into memory at once.
+	if err := fs.IterateEntries(ctx, d, func(ctx context.Context, e fs.Entry) error {
 		if v.tooManyErrors() {
-			break
+			return errTooManyErrors
 		}
 
 		objectID := e.(object.HasObjectID).ObjectID()
@@ -139,6 +138,10 @@ func (v *verifier) doVerifyDirectory(ctx context.Context, oid object.ID, path st
 		} else {
 			v.enqueueVerifyObject(ctx, objectID, childPath)
 		}
+
+		return nil
+	}); err != nil && !errors.Is(err, errTooManyErrors) {
+		v.reportError(ctx, path, errors.Wrapf(err, "error reading %v", oid))
 	}
 
 	return nil
@@ -191,7 +194,7 @@ func runVerifyCommand(ctx context.Context, rep repo.Repository) error {
 	}
 
 	v.workQueue.ProgressCallback = v.progressCallback
-	if err := v.workQueue.Process(ctx, *verifyCommandParallel); err != nil {
+	if err := v.workQueue.Process(ctx, limitParallelism(*verifyCommandParallel)); err != nil {
 		return errors.Wrap(err, "error processing work queue")
 	}
 
@@ -199,7 +202,7 @@ func runVerifyCommand(ctx context.Context, rep repo.Repository) error {
 		return nil
 	}
 
-	return errors.Errorf("encountered %v errors", len(v.errors))
+	return withCategory(ErrorCategoryCorruption, errors.Errorf("encountered %v errors", len(v.errors)))
 }
 
 func enqueueRootsToVerify(ctx context.Context, v *verifier, rep repo.Repository) error {
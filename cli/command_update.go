@@ -0,0 +1,247 @@
+package cli
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/selfupdate"
+	"github.com/kopia/kopia/repo"
+)
+
+var (
+	updateCommands = app.Command("update", "Commands to update Kopia.")
+
+	updateCheckCommand = updateCommands.Command("check", "Check for available updates")
+	updateCheckChannel = updateCheckCommand.Flag("channel", "Release channel (stable or beta)").Enum(selfupdate.ChannelStable, selfupdate.ChannelBeta)
+
+	updateInstallCommand        = updateCommands.Command("install", "Download, verify and install the latest release")
+	updateInstallChannel        = updateInstallCommand.Flag("channel", "Release channel (stable or beta)").Enum(selfupdate.ChannelStable, selfupdate.ChannelBeta)
+	updateInstallRelease        = updateInstallCommand.Flag("release", "Install specific release version").String()
+	updateInstallForce          = updateInstallCommand.Flag("force", "Install even if the release is not newer than the current version").Bool()
+	updateInstallTrustedKeyFile = updateInstallCommand.Flag("trusted-key-file", "File containing armored OpenPGP public key used to verify releases").Envar("KOPIA_UPDATE_TRUSTED_KEY_FILE").ExistingFile()
+	updateInstallExecutable     = updateInstallCommand.Flag("executable", "Path to the executable to replace").Hidden().String()
+
+	updateSetPolicyCommand = updateCommands.Command("set-policy", "Set update policy for all clients of the repository")
+	updateSetPolicyChannel = updateSetPolicyCommand.Flag("channel", "Release channel (stable or beta)").Enum(selfupdate.ChannelStable, selfupdate.ChannelBeta)
+	updateSetPolicyPin     = updateSetPolicyCommand.Flag("pin-version", "Pin all clients to the specified version").String()
+	updateSetPolicyUnpin   = updateSetPolicyCommand.Flag("unpin", "Remove version pin").Bool()
+)
+
+func init() {
+	updateCheckCommand.Action(optionalRepositoryAction(runUpdateCheck))
+	updateInstallCommand.Action(optionalRepositoryAction(runUpdateInstall))
+	updateSetPolicyCommand.Action(repositoryAction(runUpdateSetPolicy))
+}
+
+// effectiveUpdateState returns the update state with channel and pinned version
+// determined by the command-line flags, repository update policy and persisted state in this order.
+// The channel selected using the command-line flag is remembered for automatic update checks.
+func effectiveUpdateState(ctx context.Context, rep repo.Repository, channelFlag string) (*updateState, error) {
+	us, err := getUpdateState()
+	if err != nil {
+		us = &updateState{}
+	} else if channelFlag != "" && channelFlag != us.Channel {
+		us.Channel = channelFlag
+		us.AvailableVersion = ""
+		us.NextCheckTime = clock.Now()
+
+		if err := writeUpdateState(us); err != nil {
+			return nil, errors.Wrap(err, "unable to write update state")
+		}
+	}
+
+	if rep != nil {
+		if err := syncUpdatePolicy(ctx, rep, us); err != nil {
+			return nil, err
+		}
+	}
+
+	if channelFlag != "" {
+		us.Channel = channelFlag
+	}
+
+	return us, nil
+}
+
+// syncUpdatePolicy applies the repository update policy to the provided update state.
+func syncUpdatePolicy(ctx context.Context, rep repo.Repository, us *updateState) error {
+	pol, err := selfupdate.GetPolicy(ctx, rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get update policy")
+	}
+
+	if pol.Channel != "" {
+		us.Channel = pol.Channel
+	}
+
+	us.PinnedVersion = pol.PinnedVersion
+
+	return nil
+}
+
+// maybeSyncUpdatePolicy persists the repository update policy in the update state file,
+// so that automatic update checks honor it.
+func maybeSyncUpdatePolicy(ctx context.Context, rep repo.Repository) {
+	us, err := getUpdateState()
+	if err != nil {
+		// automatic update checks are disabled.
+		return
+	}
+
+	before := *us
+
+	if err := syncUpdatePolicy(ctx, rep, us); err != nil {
+		log(ctx).Debugf("unable to sync update policy: %v", err)
+		return
+	}
+
+	if *us == before {
+		return
+	}
+
+	// previously available version may not be applicable anymore, check again on next run.
+	us.AvailableVersion = ""
+	us.NextCheckTime = clock.Now()
+
+	if err := writeUpdateState(us); err != nil {
+		log(ctx).Debugf("unable to write update state: %v", err)
+	}
+}
+
+func runUpdateCheck(ctx context.Context, rep repo.Repository) error {
+	us, err := effectiveUpdateState(ctx, rep, *updateCheckChannel)
+	if err != nil {
+		return err
+	}
+
+	rel, err := selfupdate.FindRelease(ctx, selfupdate.Options{}, us.channel(), us.PinnedVersion)
+	if err != nil {
+		return errors.Wrap(err, "unable to find release")
+	}
+
+	printStdout("Current version: %v\n", ensureVPrefix(repo.BuildVersion))
+
+	if us.PinnedVersion != "" {
+		printStdout("Pinned version:  %v\n", rel.Version())
+	} else {
+		printStdout("Latest %v:   %v\n", us.channel(), rel.Version())
+	}
+
+	if selfupdate.IsNewer(rel.Version(), repo.BuildVersion) {
+		printStdout("Run 'kopia update install' to install it.\n")
+	}
+
+	return nil
+}
+
+func runUpdateInstall(ctx context.Context, rep repo.Repository) error {
+	us, err := effectiveUpdateState(ctx, rep, *updateInstallChannel)
+	if err != nil {
+		return err
+	}
+
+	version := *updateInstallRelease
+	if version == "" {
+		version = us.PinnedVersion
+	} else if us.PinnedVersion != "" && ensureVPrefix(version) != ensureVPrefix(us.PinnedVersion) {
+		return errors.Errorf("repository update policy pins the version to %v", us.PinnedVersion)
+	}
+
+	opt := selfupdate.Options{}
+
+	if *updateInstallTrustedKeyFile != "" {
+		key, err := ioutil.ReadFile(*updateInstallTrustedKeyFile)
+		if err != nil {
+			return errors.Wrap(err, "unable to read trusted key file")
+		}
+
+		opt.TrustedKey = string(key)
+	}
+
+	rel, err := selfupdate.FindRelease(ctx, opt, us.channel(), version)
+	if err != nil {
+		return errors.Wrap(err, "unable to find release")
+	}
+
+	// pinned versions are installed even if they are older, to allow rollbacks.
+	if !*updateInstallForce && version == "" && !selfupdate.IsNewer(rel.Version(), repo.BuildVersion) {
+		printStderr("Kopia %v is up to date.\n", ensureVPrefix(repo.BuildVersion))
+		return nil
+	}
+
+	exePath, err := installedExecutablePath()
+	if err != nil {
+		return err
+	}
+
+	printStderr("Downloading and verifying Kopia %v...\n", rel.Version())
+
+	contents, err := selfupdate.DownloadExecutable(ctx, opt, rel)
+	if err != nil {
+		return errors.Wrap(err, "unable to download release")
+	}
+
+	if err := selfupdate.ReplaceExecutable(exePath, contents); err != nil {
+		return err
+	}
+
+	printStderr("Installed Kopia %v in %v\n", rel.Version(), exePath)
+
+	return nil
+}
+
+func installedExecutablePath() (string, error) {
+	if *updateInstallExecutable != "" {
+		return *updateInstallExecutable, nil
+	}
+
+	p, err := os.Executable()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to determine executable path")
+	}
+
+	p, err = filepath.EvalSymlinks(p)
+
+	return p, errors.Wrap(err, "unable to determine executable path")
+}
+
+func runUpdateSetPolicy(ctx context.Context, rep repo.Repository) error {
+	pol, err := selfupdate.GetPolicy(ctx, rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get update policy")
+	}
+
+	if *updateSetPolicyChannel != "" {
+		pol.Channel = *updateSetPolicyChannel
+	}
+
+	if *updateSetPolicyPin != "" {
+		pol.PinnedVersion = ensureVPrefix(*updateSetPolicyPin)
+	}
+
+	if *updateSetPolicyUnpin {
+		pol.PinnedVersion = ""
+	}
+
+	if err := selfupdate.SetPolicy(ctx, rep, pol); err != nil {
+		return err
+	}
+
+	printStderr("Update channel: %v\n", stringOrNotSet(pol.Channel))
+	printStderr("Pinned version: %v\n", stringOrNotSet(pol.PinnedVersion))
+
+	return nil
+}
+
+func stringOrNotSet(s string) string {
+	if s == "" {
+		return "(not set)"
+	}
+
+	return s
+}
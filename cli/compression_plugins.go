@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/compression"
+	compressionplugin "github.com/kopia/kopia/repo/compression/plugin"
+)
+
+var (
+	compressionPlugins = app.Flag("compression-plugin", "Executable of a plugin providing additional compressors (can be repeated)").Envar("KOPIA_COMPRESSION_PLUGINS").Strings()
+
+	enableCompressorCommand = repositoryCommands.Command("enable-compressor", "Allow data in the repository to be compressed by a compressor provided by a plugin.")
+	enableCompressorName    = enableCompressorCommand.Arg("name", "Compressor name").Required().String()
+)
+
+func loadCompressionPlugins(_ *kingpin.ParseContext) error {
+	ctx := rootContext()
+
+	for _, p := range *compressionPlugins {
+		if _, err := compressionplugin.Load(ctx, p, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runEnableCompressorCommand(ctx context.Context, rep *repo.DirectRepository) error {
+	return errors.Wrap(rep.EnablePluginCompressor(ctx, compression.Name(*enableCompressorName)), "unable to enable compressor")
+}
+
+func init() {
+	app.PreAction(loadCompressionPlugins)
+	enableCompressorCommand.Action(directRepositoryAction(runEnableCompressorCommand))
+}
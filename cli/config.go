@@ -24,6 +24,14 @@
 	enableCaching      = app.Flag("caching", "Enables caching of objects (disable with --no-caching)").Default("true").Hidden().Bool()
 	enableListCaching  = app.Flag("list-caching", "Enables caching of list results (disable with --no-list-caching)").Default("true").Hidden().Bool()
 	metricsListenAddr  = app.Flag("metrics-listen-addr", "Expose Prometheus metrics on a given host:port").Hidden().String()
+	verifyWrites       = app.Flag("verify-critical-writes", "Read back index, format and metadata blobs after upload to verify they have been stored").Envar("KOPIA_VERIFY_CRITICAL_WRITES").Bool()
+	backgroundPrefetch = app.Flag("background-prefetch", "Prefetch indexes, manifests and recent metadata in the background after opening the repository").Envar("KOPIA_BACKGROUND_PREFETCH").Bool()
+	eagerIndexMerge    = app.Flag("eager-index-compaction", "Merge small index blobs whenever indexes are written instead of waiting for maintenance").Default("false").Envar("KOPIA_EAGER_INDEX_COMPACTION").Bool()
+
+	objectCacheSize     = app.Flag("object-cache-size", "Size of in-memory cache of fully assembled small objects, which speeds up repeated reads when browsing snapshots (0 disables, which is the default)").Default("0").Envar("KOPIA_OBJECT_CACHE_SIZE").Bytes()
+	uploadConcurrency   = app.Flag("upload-concurrency", "Number of packs uploaded to the repository in parallel in the background (0 uploads packs as they are filled)").Default("0").Envar("KOPIA_UPLOAD_CONCURRENCY").Int()
+	uploadMemoryBudget  = app.Flag("upload-memory-budget", "Maximum total size of packs being uploaded in the background (defaults to one pack per concurrent upload)").Envar("KOPIA_UPLOAD_MEMORY_BUDGET").Bytes()
+	maxCachedObjectSize = app.Flag("max-cached-object-size", "Maximum size of an object kept in the in-memory object cache").Default("1MB").Envar("KOPIA_MAX_CACHED_OBJECT_SIZE").Bytes()
 
 	configPath = app.Flag("config-file", "Specify the config file to use.").Default(defaultConfigFileName()).Envar("KOPIA_CONFIG_PATH").String()
 )
@@ -83,6 +91,15 @@ func applyOptionsFromFlags(ctx context.Context, opts *repo.Options) *repo.Option
 		opts.ObjectManagerOptions.Trace = log(ctx).Debugf
 	}
 
+	opts.VerifyCriticalWrites = *verifyWrites
+	opts.BackgroundPrefetch = *backgroundPrefetch
+	opts.LowMemory = *lowMemory
+	opts.UploadConcurrency = *uploadConcurrency
+	opts.UploadMemoryBudget = int64(*uploadMemoryBudget)
+	opts.EagerIndexCompaction = *eagerIndexMerge
+	opts.ObjectManagerOptions.ObjectCacheSize = int64(*objectCacheSize)
+	opts.ObjectManagerOptions.MaxCachedObjectSize = int64(*maxCachedObjectSize)
+
 	return opts
 }
 
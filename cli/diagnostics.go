@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/kopia/kopia/internal/controlsocket"
+	"github.com/kopia/kopia/internal/diagnostics"
+)
+
+var (
+	diagnosticsPprofListenAddr = app.Flag("diagnostics-pprof-listen-addr", "Expose net/http/pprof endpoints on a given host:port").Hidden().Envar("KOPIA_DIAGNOSTICS_PPROF_LISTEN_ADDR").String()
+	diagnosticsDumpInterval    = app.Flag("diagnostics-dump-interval", "Periodically dump heap and goroutine profiles").Hidden().Envar("KOPIA_DIAGNOSTICS_DUMP_INTERVAL").Duration()
+	diagnosticsDumpDir         = app.Flag("diagnostics-dump-dir", "Directory where profile dumps are written (defaults to 'diagnostics' subdirectory of the log directory)").Hidden().String()
+	diagnosticsMaxDumps        = app.Flag("diagnostics-max-dumps", "Maximum number of profile dumps of each kind to retain").Hidden().Default("20").Int()
+	diagnosticsControlSocket   = app.Flag("diagnostics-control-socket", "Path of the socket that allows toggling diagnostics of a running command using 'kopia debug diagnostics'").Hidden().Envar("KOPIA_DIAGNOSTICS_CONTROL_SOCKET").String()
+)
+
+// startDiagnostics enables runtime diagnostics according to command-line flags and returns a function
+// that disables them.
+func startDiagnostics(ctx context.Context) func() {
+	dir := *diagnosticsDumpDir
+	if dir == "" {
+		dir = filepath.Join(logDirFromFlags(), "diagnostics")
+	}
+
+	c := diagnostics.NewController(dir)
+	c.MaxDumps = *diagnosticsMaxDumps
+
+	if *diagnosticsPprofListenAddr != "" {
+		if _, err := c.EnablePprof(ctx, *diagnosticsPprofListenAddr); err != nil {
+			log(ctx).Warningf("unable to start pprof endpoints: %v", err)
+		}
+	}
+
+	if *diagnosticsDumpInterval > 0 {
+		log(ctx).Infof("Dumping profiles to %v every %v", dir, *diagnosticsDumpInterval)
+		c.SetDumpInterval(ctx, *diagnosticsDumpInterval)
+	}
+
+	var cs *controlsocket.Server
+
+	if *diagnosticsControlSocket != "" {
+		var err error
+
+		if cs, err = controlsocket.Listen(*diagnosticsControlSocket, c.ControlHandlers(ctx)); err != nil {
+			log(ctx).Warningf("unable to listen on diagnostics control socket: %v", err)
+		}
+	}
+
+	return func() {
+		if cs != nil {
+			cs.Close() //nolint:errcheck
+		}
+
+		c.Close(ctx)
+	}
+}
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/apiclient"
+	"github.com/kopia/kopia/internal/userdb"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/snapshot/legalhold"
+	"github.com/kopia/kopia/snapshot/snapshotcommit"
+)
+
+// ErrorCategory is a stable category of command failure, which determines the process exit code.
+type ErrorCategory string
+
+// Supported error categories.
+const (
+	ErrorCategoryUnknown            ErrorCategory = "unknown"
+	ErrorCategoryAuthFailure        ErrorCategory = "auth-failure"
+	ErrorCategoryStorageUnreachable ErrorCategory = "storage-unreachable"
+	ErrorCategoryCorruption         ErrorCategory = "corruption-detected"
+	ErrorCategoryPolicyViolation    ErrorCategory = "policy-violation"
+	ErrorCategoryPartialSuccess     ErrorCategory = "partial-success"
+)
+
+// exitCodeByCategory maps error categories to process exit codes, which don't overlap with
+// the exit codes of 'repository fsck'.
+var exitCodeByCategory = map[ErrorCategory]int{
+	ErrorCategoryUnknown:            1,
+	ErrorCategoryAuthFailure:        10,
+	ErrorCategoryStorageUnreachable: 11,
+	ErrorCategoryCorruption:         12,
+	ErrorCategoryPolicyViolation:    13,
+	ErrorCategoryPartialSuccess:     14,
+}
+
+var jsonErrors = app.Flag("json-errors", "Print errors to stderr as JSON objects including error category and exit code").Envar("KOPIA_JSON_ERRORS").Bool()
+
+// categorizedError is an error explicitly assigned a category by the command that returned it.
+type categorizedError struct {
+	category ErrorCategory
+	err      error
+}
+
+func (e categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e categorizedError) Unwrap() error {
+	return e.err
+}
+
+// withCategory returns the error annotated with the provided category.
+func withCategory(category ErrorCategory, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return categorizedError{category, err}
+}
+
+// categoryErrors lists sentinel errors of each category, in the order they are checked.
+var categoryErrors = []struct {
+	category ErrorCategory
+	errs     []error
+}{
+	{ErrorCategoryAuthFailure, []error{repo.ErrInvalidPassword, apiclient.ErrAccessDenied, userdb.ErrInvalidCredentials, userdb.ErrAccountLocked}},
+	{ErrorCategoryCorruption, []error{content.ErrInvalidChecksum, snapshotcommit.ErrMismatch, blob.ErrWriteVerificationFailed}},
+	{ErrorCategoryPolicyViolation, []error{legalhold.ErrHeld, userdb.ErrPasswordPolicyViolation}},
+}
+
+// ClassifyError returns the category of the error returned by a command.
+func ClassifyError(err error) ErrorCategory {
+	var ce categorizedError
+	if errors.As(err, &ce) {
+		return ce.category
+	}
+
+	for _, c := range categoryErrors {
+		for _, e := range c.errs {
+			if errors.Is(err, e) {
+				return c.category
+			}
+		}
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EHOSTUNREACH) {
+		return ErrorCategoryStorageUnreachable
+	}
+
+	return ErrorCategoryUnknown
+}
+
+// ExitCodeForError returns the process exit code for the error returned by a command.
+func ExitCodeForError(err error) int {
+	return exitCodeByCategory[ClassifyError(err)]
+}
+
+// errorReport is the JSON representation of the error printed when --json-errors is set.
+type errorReport struct {
+	Error    string        `json:"error"`
+	Category ErrorCategory `json:"category"`
+	ExitCode int           `json:"exitCode"`
+}
+
+// ReportError prints the error returned by a command to stderr, either as text or as a JSON object
+// and returns the process exit code for it.
+func ReportError(err error) int {
+	category := ClassifyError(err)
+	code := exitCodeByCategory[category]
+
+	if *jsonErrors {
+		_ = json.NewEncoder(os.Stderr).Encode(errorReport{err.Error(), category, code})
+		return code
+	}
+
+	fmt.Fprintf(os.Stderr, "%v: error: %v, try --help\n", app.Name, err) //nolint:errcheck
+
+	return code
+}
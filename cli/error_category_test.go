@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/apiclient"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/snapshot/legalhold"
+)
+
+func TestClassifyError(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		want ErrorCategory
+	}{
+		{"generic", errors.New("some error"), ErrorCategoryUnknown},
+		{"invalid password", errors.Wrap(repo.ErrInvalidPassword, "open repository"), ErrorCategoryAuthFailure},
+		{"access denied", errors.Wrap(apiclient.ErrAccessDenied, "server error: 401 Unauthorized"), ErrorCategoryAuthFailure},
+		{"network", errors.Wrap(&net.OpError{Op: "dial", Err: errors.New("connection refused")}, "unable to list blobs"), ErrorCategoryStorageUnreachable},
+		{"corruption", errors.Wrap(content.ErrInvalidChecksum, "error reading object"), ErrorCategoryCorruption},
+		{"legal hold", errors.Wrap(legalhold.ErrHeld, "unable to delete snapshot"), ErrorCategoryPolicyViolation},
+		{"partial", errors.Wrap(withCategory(ErrorCategoryPartialSuccess, errors.New("encountered 1 errors")), "snapshot"), ErrorCategoryPartialSuccess},
+	} {
+		if got := ClassifyError(tc.err); got != tc.want {
+			t.Errorf("%v: got category %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	codes := map[int]ErrorCategory{}
+
+	for cat, code := range exitCodeByCategory {
+		if other, ok := codes[code]; ok {
+			t.Errorf("categories %v and %v share exit code %v", cat, other, code)
+		}
+
+		codes[code] = cat
+	}
+}
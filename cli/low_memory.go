@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"context"
+	"runtime/debug"
+
+	"github.com/kopia/kopia/fs"
+)
+
+const (
+	// lowMemoryGCPercent makes garbage collection more aggressive in low-memory mode, trading CPU time for smaller heap.
+	lowMemoryGCPercent = 25
+
+	// lowMemoryMaxParallelism is the maximum parallelism of uploads, restores and verification in low-memory mode.
+	lowMemoryMaxParallelism = 2
+)
+
+var lowMemory = app.Flag("low-memory", "Reduce memory usage at the expense of performance, for devices with little RAM such as NAS boxes").Envar("KOPIA_LOW_MEMORY").Bool()
+
+// setupLowMemoryMode applies process-wide settings of low-memory mode, repository settings are applied when it is opened.
+func setupLowMemoryMode(ctx context.Context) {
+	if !*lowMemory {
+		return
+	}
+
+	debug.SetGCPercent(lowMemoryGCPercent)
+
+	log(ctx).Debugf("low-memory mode enabled")
+}
+
+// limitParallelism returns the provided parallelism limited in low-memory mode, where zero, which normally
+// selects parallelism automatically, is replaced with the limit.
+func limitParallelism(n int) int {
+	if !*lowMemory {
+		return n
+	}
+
+	if n <= 0 || n > lowMemoryMaxParallelism {
+		return lowMemoryMaxParallelism
+	}
+
+	return n
+}
+
+// iterateDirectory invokes the callback for entries of the directory sorted by name, except in low-memory mode,
+// where entries are streamed in the order provided by the directory without reading the entire listing first.
+func iterateDirectory(ctx context.Context, d fs.Directory, cb func(ctx context.Context, e fs.Entry) error) error {
+	if *lowMemory {
+		return fs.IterateEntries(ctx, d, cb)
+	}
+
+	entries, err := d.Readdir(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := cb(ctx, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
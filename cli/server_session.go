@@ -0,0 +1,169 @@
+package cli
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/userdb"
+)
+
+// sessionCookieName is the name of the cookie identifying browser sessions of users with two-factor authentication.
+const sessionCookieName = "Kopia-Session"
+
+const sessionKeyLength = 32
+
+// sessionLoginReuseWindow is the time during which requests with the credentials used to create a session
+// are given the same session, which covers requests sent by the browser before it received the cookie.
+// It's shorter than the time during which the one-time code is valid.
+const sessionLoginReuseWindow = time.Minute
+
+// sessionManager issues and verifies signed session cookies, which allow users with two-factor authentication
+// to keep using the UI after the one-time code they logged in with has expired. Browsers keep sending
+// the original credentials, which are only accepted together with a valid session cookie.
+//
+// Sessions are bound to the current credentials of the user and are invalidated when the password
+// or TOTP secret changes or when the server restarts.
+//
+// The one-time code is only verified when the session is created. Browsers send several requests with
+// the same code before they receive the session cookie, those requests are given the session created
+// by the first one instead of being rejected as replays of the code.
+type sessionManager struct {
+	db       *userdb.Database
+	key      []byte
+	duration time.Duration
+
+	mu     sync.Mutex
+	logins map[string]*sessionLogin // recent logins by HMAC of the credentials
+}
+
+type sessionLogin struct {
+	cookie      *http.Cookie
+	fingerprint string
+	reuseUntil  time.Time
+}
+
+func newSessionManager(db *userdb.Database, duration time.Duration) (*sessionManager, error) {
+	key := make([]byte, sessionKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.Wrap(err, "unable to generate session key")
+	}
+
+	return &sessionManager{
+		db:       db,
+		key:      key,
+		duration: duration,
+		logins:   map[string]*sessionLogin{},
+	}, nil
+}
+
+func (m *sessionManager) signature(username string, expires int64) (string, bool) {
+	fp, ok := m.db.CredentialsFingerprint(username)
+	if !ok {
+		return "", false
+	}
+
+	h := hmac.New(sha256.New, m.key)
+	fmt.Fprintf(h, "%v|%v|%v", username, expires, fp)
+
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// issue returns the session cookie for the authenticated user.
+func (m *sessionManager) issue(r *http.Request, username string) (*http.Cookie, bool) {
+	expires := clock.Now().Add(m.duration)
+
+	sig, ok := m.signature(username, expires.Unix())
+	if !ok {
+		return nil, false
+	}
+
+	return &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    fmt.Sprintf("%v.%v", expires.Unix(), sig),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	}, true
+}
+
+func (m *sessionManager) credentialsKey(username, password string) string {
+	h := hmac.New(sha256.New, m.key)
+	fmt.Fprintf(h, "%v|%v", username, password)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// login verifies credentials including the one-time code and sets the session cookie. Logins are serialized,
+// so that concurrent requests with the same credentials share the session created by the first of them.
+func (m *sessionManager) login(w http.ResponseWriter, r *http.Request, username, password string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := clock.Now()
+	key := m.credentialsKey(username, password)
+
+	for k, l := range m.logins {
+		if !now.Before(l.reuseUntil) {
+			delete(m.logins, k)
+		}
+	}
+
+	if l := m.logins[key]; l != nil {
+		if fp, ok := m.db.CredentialsFingerprint(username); ok && fp == l.fingerprint {
+			http.SetCookie(w, l.cookie)
+
+			return m.db.AccountStatus(username)
+		}
+	}
+
+	if err := m.db.Authenticate(username, password); err != nil {
+		return err
+	}
+
+	c, ok := m.issue(r, username)
+	if !ok {
+		return userdb.ErrInvalidCredentials
+	}
+
+	fp, _ := m.db.CredentialsFingerprint(username)
+	m.logins[key] = &sessionLogin{c, fp, now.Add(sessionLoginReuseWindow)}
+
+	http.SetCookie(w, c)
+
+	return nil
+}
+
+// valid returns true if the request has a valid session cookie for the user.
+func (m *sessionManager) valid(r *http.Request, username string) bool {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return false
+	}
+
+	p := strings.Index(c.Value, ".")
+	if p < 0 {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(c.Value[0:p], 10, 64)
+	if err != nil || clock.Now().Unix() >= expires {
+		return false
+	}
+
+	sig, ok := m.signature(username, expires)
+
+	return ok && hmac.Equal([]byte(sig), []byte(c.Value[p+1:]))
+}
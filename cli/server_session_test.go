@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/userdb"
+)
+
+func TestRequireAuthTOTPSession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kopia-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	db, err := userdb.Open(filepath.Join(dir, "htpasswd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AddUser("foo@bar", "password1", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.SetPolicy(userdb.Policy{MinLength: 8, MaxFailedAttempts: 1, LockoutDuration: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := db.EnrollTOTP("foo@bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := newSessionManager(db, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := requireAuth{
+		inner:    http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		userDB:   db,
+		sessions: sessions,
+	}
+
+	request := func(password string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/sources", nil)
+		r.SetBasicAuth("foo@bar", password)
+
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	// single-use recovery code is subject to the same replay protection as TOTP codes.
+	creds := "password1" + e.RecoveryCodes[0]
+
+	first := request(creds, nil)
+	if first.Code != http.StatusOK || len(first.Result().Cookies()) != 1 {
+		t.Fatalf("unexpected login response: %v %v", first.Code, first.Result().Cookies())
+	}
+
+	// requests sent by the browser before it received the cookie get the same session.
+	second := request(creds, nil)
+	if second.Code != http.StatusOK || len(second.Result().Cookies()) != 1 {
+		t.Fatalf("unexpected response to concurrent login: %v", second.Code)
+	}
+
+	if got, want := second.Result().Cookies()[0].Value, first.Result().Cookies()[0].Value; got != want {
+		t.Fatalf("unexpected session %v, want %v", got, want)
+	}
+
+	if w := request(creds, first.Result().Cookies()); w.Code != http.StatusOK {
+		t.Fatalf("unexpected response with session: %v", w.Code)
+	}
+
+	// account state is checked on every request, even with valid session.
+	if err := db.Authenticate("foo@bar", "wrong"); err == nil {
+		t.Fatalf("wrong password accepted")
+	}
+
+	if w := request(creds, first.Result().Cookies()); w.Code != http.StatusUnauthorized {
+		t.Fatalf("request of locked account with session was accepted: %v", w.Code)
+	}
+}
@@ -2,15 +2,20 @@
 
 import (
 	"context"
+	"strings"
 
 	"github.com/alecthomas/kingpin"
+	"github.com/pkg/errors"
 
 	"github.com/kopia/kopia/repo/blob"
 	"github.com/kopia/kopia/repo/blob/azure"
 )
 
 func init() {
-	var azOptions azure.Options
+	var (
+		azOptions     azure.Options
+		azAccessTiers []string
+	)
 
 	RegisterStorageConnectFlags(
 		"azure",
@@ -22,8 +27,23 @@ func(cmd *kingpin.CmdClause) {
 			cmd.Flag("prefix", "Prefix to use for objects in the bucket").StringVar(&azOptions.Prefix)
 			cmd.Flag("max-download-speed", "Limit the download speed.").PlaceHolder("BYTES_PER_SEC").IntVar(&azOptions.MaxDownloadSpeedBytesPerSecond)
 			cmd.Flag("max-upload-speed", "Limit the upload speed.").PlaceHolder("BYTES_PER_SEC").IntVar(&azOptions.MaxUploadSpeedBytesPerSecond)
+			cmd.Flag("access-tier", "Access tier of blobs with the given ID prefix (prefix=Hot|Cool|Archive)").PlaceHolder("PREFIX=TIER").StringsVar(&azAccessTiers)
+			cmd.Flag("rehydrate-tier", "Access tier to which archived blobs are rehydrated when they are read").EnumVar(&azOptions.RehydrateTier, azure.AccessTierHot, azure.AccessTierCool)
 		},
 		func(ctx context.Context, isNew bool) (blob.Storage, error) {
+			for _, kv := range azAccessTiers {
+				parts := strings.SplitN(kv, "=", 2) //nolint:gomnd
+				if len(parts) != 2 {
+					return nil, errors.Errorf("invalid access tier %q, expected prefix=tier", kv)
+				}
+
+				if azOptions.AccessTiers == nil {
+					azOptions.AccessTiers = map[string]string{}
+				}
+
+				azOptions.AccessTiers[parts[0]] = parts[1]
+			}
+
 			return azure.New(ctx, &azOptions)
 		},
 	)
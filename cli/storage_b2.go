@@ -22,6 +22,7 @@ func(cmd *kingpin.CmdClause) {
 			cmd.Flag("prefix", "Prefix to use for objects in the bucket").StringVar(&b2options.Prefix)
 			cmd.Flag("max-download-speed", "Limit the download speed.").PlaceHolder("BYTES_PER_SEC").IntVar(&b2options.MaxDownloadSpeedBytesPerSecond)
 			cmd.Flag("max-upload-speed", "Limit the upload speed.").PlaceHolder("BYTES_PER_SEC").IntVar(&b2options.MaxUploadSpeedBytesPerSecond)
+			cmd.Flag("part-size", "Size of parts in which larger blobs are uploaded using multipart upload.").PlaceHolder("BYTES").IntVar(&b2options.PartSizeBytes)
 		},
 		func(ctx context.Context, isNew bool) (blob.Storage, error) {
 			return b2.New(ctx, &b2options)
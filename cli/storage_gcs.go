@@ -26,6 +26,8 @@ func(cmd *kingpin.CmdClause) {
 			cmd.Flag("credentials-file", "Use the provided JSON file with credentials").ExistingFileVar(&options.ServiceAccountCredentialsFile)
 			cmd.Flag("max-download-speed", "Limit the download speed.").PlaceHolder("BYTES_PER_SEC").IntVar(&options.MaxDownloadSpeedBytesPerSecond)
 			cmd.Flag("max-upload-speed", "Limit the upload speed.").PlaceHolder("BYTES_PER_SEC").IntVar(&options.MaxUploadSpeedBytesPerSecond)
+			cmd.Flag("max-concurrent-requests", "Maximum number of concurrent requests, reduced automatically when the server is throttling.").PlaceHolder("N").IntVar(&options.MaxConcurrentRequests)
+			cmd.Flag("max-requests-per-second", "Maximum number of requests per second, reduced automatically when the server is throttling.").PlaceHolder("N").IntVar(&options.MaxRequestsPerSecond)
 			cmd.Flag("embed-credentials", "Embed GCS credentials JSON in Kopia configuration").BoolVar(&embedCredentials)
 		},
 		func(ctx context.Context, isNew bool) (blob.Storage, error) {
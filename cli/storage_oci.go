@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"context"
+	"io/ioutil"
+
+	"github.com/alecthomas/kingpin"
+
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/oci"
+)
+
+func init() {
+	var options oci.Options
+
+	var embedPrivateKey bool
+
+	RegisterStorageConnectFlags(
+		"oci",
+		"an Oracle Cloud Infrastructure Object Storage bucket",
+		func(cmd *kingpin.CmdClause) {
+			cmd.Flag("bucket", "Name of the Object Storage bucket").Required().StringVar(&options.BucketName)
+			cmd.Flag("oci-namespace", "Object Storage namespace of the tenancy").Required().StringVar(&options.Namespace)
+			cmd.Flag("region", "Region where the bucket is located (overrides OCI_REGION environment variable)").Envar("OCI_REGION").StringVar(&options.Region)
+			cmd.Flag("endpoint", "Object Storage endpoint, by default derived from the region").StringVar(&options.Endpoint)
+			cmd.Flag("prefix", "Prefix to use for objects in the bucket").StringVar(&options.Prefix)
+			cmd.Flag("tenancy", "OCID of the tenancy (overrides OCI_TENANCY environment variable)").Required().Envar("OCI_TENANCY").StringVar(&options.TenancyOCID)
+			cmd.Flag("user", "OCID of the user (overrides OCI_USER environment variable)").Required().Envar("OCI_USER").StringVar(&options.UserOCID)
+			cmd.Flag("fingerprint", "Fingerprint of the API signing key (overrides OCI_FINGERPRINT environment variable)").Required().Envar("OCI_FINGERPRINT").StringVar(&options.Fingerprint)
+			cmd.Flag("private-key-file", "PEM file with the API signing key (overrides OCI_PRIVATE_KEY_FILE environment variable)").Required().Envar("OCI_PRIVATE_KEY_FILE").ExistingFileVar(&options.PrivateKeyFile)
+			cmd.Flag("private-key-passphrase", "Passphrase of encrypted API signing key (overrides OCI_PRIVATE_KEY_PASSPHRASE environment variable)").Envar("OCI_PRIVATE_KEY_PASSPHRASE").StringVar(&options.PrivateKeyPassphrase)
+			cmd.Flag("embed-private-key", "Embed the API signing key in Kopia configuration").BoolVar(&embedPrivateKey)
+			cmd.Flag("max-download-speed", "Limit the download speed.").PlaceHolder("BYTES_PER_SEC").IntVar(&options.MaxDownloadSpeedBytesPerSecond)
+			cmd.Flag("max-upload-speed", "Limit the upload speed.").PlaceHolder("BYTES_PER_SEC").IntVar(&options.MaxUploadSpeedBytesPerSecond)
+		},
+		func(ctx context.Context, isNew bool) (blob.Storage, error) {
+			if embedPrivateKey {
+				data, err := ioutil.ReadFile(options.PrivateKeyFile)
+				if err != nil {
+					return nil, err
+				}
+
+				options.PrivateKey = string(data)
+				options.PrivateKeyFile = ""
+			}
+
+			return oci.New(ctx, &options)
+		},
+	)
+}
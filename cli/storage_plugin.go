@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"context"
+	"strings"
+
+	"github.com/alecthomas/kingpin"
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/plugin"
+)
+
+func init() {
+	var (
+		opt          plugin.Options
+		pluginConfig []string
+	)
+
+	RegisterStorageConnectFlags(
+		"plugin",
+		"a storage provided by an external plugin",
+		func(cmd *kingpin.CmdClause) {
+			cmd.Flag("exec", "Path to plugin executable").Required().StringVar(&opt.Executable)
+			cmd.Flag("plugin-args", "Pass additional parameters to the plugin").StringsVar(&opt.Args)
+			cmd.Flag("plugin-env", "Pass additional environment (key=value) to the plugin").StringsVar(&opt.Env)
+			cmd.Flag("option", "Plugin-specific configuration option (key=value)").StringsVar(&pluginConfig)
+		},
+		func(ctx context.Context, isNew bool) (blob.Storage, error) {
+			opt.Config = map[string]string{}
+
+			for _, kv := range pluginConfig {
+				parts := strings.SplitN(kv, "=", 2) //nolint:gomnd
+				if len(parts) != 2 || parts[0] == "" {
+					return nil, errors.Errorf("invalid plugin option %q, expected key=value", kv)
+				}
+
+				opt.Config[parts[0]] = parts[1]
+			}
+
+			return plugin.New(ctx, &opt)
+		},
+	)
+}
@@ -58,6 +58,36 @@ func RegisterStorageConnectFlags(
 		return runRepairCommandWithStorage(ctx, st)
 	})
 
+	// Set up 'debug stress' subcommand
+	cc = debugStressCommand.Command(name, "Stress test repository in "+description)
+	flags(cc)
+	cc.Action(func(_ *kingpin.ParseContext) error {
+		ctx := rootContext()
+		st, err := connect(ctx, true)
+		if err != nil {
+			return errors.Wrap(err, "can't connect to storage")
+		}
+
+		return runDebugStressWithStorage(ctx, st)
+	})
+
+	// Set up 'restore-to' subcommand
+	if restoreToStorageTypes[name] {
+		cc = restoreToCommand.Command(name, "Restore snapshot contents to "+description)
+		flags(cc)
+		addRestoreToFlags(cc)
+		cc.Action(repositoryAction(func(ctx context.Context, rep repo.Repository) error {
+			st, err := connect(ctx, false)
+			if err != nil {
+				return errors.Wrap(err, "can't connect to storage")
+			}
+
+			defer st.Close(ctx) //nolint:errcheck
+
+			return runRestoreToStorage(ctx, rep, st)
+		}))
+	}
+
 	// Set up 'sync-to' subcommand
 	cc = repositorySyncCommand.Command(name, "Synchronize repository data to another repository in "+description)
 	flags(cc)
@@ -80,4 +110,31 @@ func RegisterStorageConnectFlags(
 
 		return runSyncWithStorage(ctx, dr.BlobStorage(), st)
 	})
+
+	// Set up 'replicate' subcommand
+	cc = repositoryReplicateCommand.Command(name, "Replicate repository blobs to "+description)
+	flags(cc)
+	cc.Action(func(_ *kingpin.ParseContext) error {
+		ctx := rootContext()
+		st, err := connect(ctx, false)
+		if err != nil {
+			return errors.Wrap(err, "can't connect to storage")
+		}
+
+		defer st.Close(ctx) //nolint:errcheck
+
+		rep, err := openRepository(ctx, nil, true)
+		if err != nil {
+			return errors.Wrap(err, "open repository")
+		}
+
+		defer rep.Close(ctx) //nolint:errcheck
+
+		dr, ok := rep.(*repo.DirectRepository)
+		if !ok {
+			return errors.Errorf("replication only supports directly-connected repositories")
+		}
+
+		return runReplicateWithStorage(ctx, dr.BlobStorage(), st)
+	})
 }
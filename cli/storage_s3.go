@@ -27,6 +27,11 @@ func(cmd *kingpin.CmdClause) {
 			cmd.Flag("disable-tls-verification", "Disable TLS (HTTPS) certificate verification").BoolVar(&s3options.DoNotVerifyTLS)
 			cmd.Flag("max-download-speed", "Limit the download speed.").PlaceHolder("BYTES_PER_SEC").IntVar(&s3options.MaxDownloadSpeedBytesPerSecond)
 			cmd.Flag("max-upload-speed", "Limit the upload speed.").PlaceHolder("BYTES_PER_SEC").IntVar(&s3options.MaxUploadSpeedBytesPerSecond)
+			cmd.Flag("max-concurrent-requests", "Maximum number of concurrent requests, reduced automatically when the server is throttling.").PlaceHolder("N").IntVar(&s3options.MaxConcurrentRequests)
+			cmd.Flag("max-requests-per-second", "Maximum number of requests per second, reduced automatically when the server is throttling.").PlaceHolder("N").IntVar(&s3options.MaxRequestsPerSecond)
+			cmd.Flag("sse", "Server-side encryption of stored objects").EnumVar(&s3options.ServerSideEncryption, s3.SupportedServerSideEncryption...)
+			cmd.Flag("sse-kms-key-id", "ID of the KMS key used with SSE-KMS (the default key is used if not specified)").StringVar(&s3options.SSEKMSKeyID)
+			cmd.Flag("sse-customer-key", "Base64-encoded 256-bit key used with SSE-C (overrides KOPIA_S3_SSE_CUSTOMER_KEY environment variable)").Envar("KOPIA_S3_SSE_CUSTOMER_KEY").StringVar(&s3options.SSECustomerKey)
 		},
 		func(ctx context.Context, isNew bool) (blob.Storage, error) {
 			return s3.New(ctx, &s3options)
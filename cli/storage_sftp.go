@@ -3,6 +3,7 @@
 import (
 	"context"
 	"io/ioutil"
+	"time"
 
 	"github.com/alecthomas/kingpin"
 	"github.com/pkg/errors"
@@ -16,6 +17,7 @@ func init() {
 		options          sftp.Options
 		connectFlat      bool
 		embedCredentials bool
+		keepalive        time.Duration
 	)
 
 	RegisterStorageConnectFlags(
@@ -36,6 +38,9 @@ func(cmd *kingpin.CmdClause) {
 			cmd.Flag("ssh-command", "SSH command").Default("ssh").StringVar(&options.SSHCommand)
 			cmd.Flag("ssh-args", "Arguments to external SSH command").StringVar(&options.SSHArguments)
 
+			cmd.Flag("max-connections", "Maximum number of concurrent SFTP connections").Default("1").IntVar(&options.MaxConnections)
+			cmd.Flag("keepalive", "Interval between SSH keepalive requests, lost connections are re-established (0 to disable)").Default("30s").DurationVar(&keepalive)
+
 			cmd.Flag("flat", "Use flat directory structure").BoolVar(&connectFlat)
 		},
 		func(ctx context.Context, isNew bool) (blob.Storage, error) {
@@ -74,6 +79,12 @@ func(ctx context.Context, isNew bool) (blob.Storage, error) {
 				sftpo.DirectoryShards = []int{}
 			}
 
+			if keepalive > 0 {
+				sftpo.KeepaliveSeconds = int(keepalive.Round(time.Second).Seconds())
+			} else {
+				sftpo.KeepaliveSeconds = -1
+			}
+
 			return sftp.New(ctx, &sftpo)
 		})
 }
@@ -17,6 +17,7 @@
 	"golang.org/x/mod/semver"
 
 	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/selfupdate"
 	"github.com/kopia/kopia/repo"
 )
 
@@ -33,9 +34,8 @@
 )
 
 const (
-	latestReleaseGitHubURL = "https://api.github.com/repos/kopia/kopia/releases/latest"
-	checksumsURL           = "https://github.com/kopia/kopia/releases/download/%v/checksums.txt.sig"
-	autoUpdateNotice       = `
+	checksumsURL     = "https://github.com/kopia/kopia/releases/download/%v/checksums.txt.sig"
+	autoUpdateNotice = `
 NOTICE: Kopia will check for updates on GitHub every 7 days, starting 24 hours after first use.
 To disable this behavior, set environment variable ` + checkForUpdatesEnvar + `=false
 Alternatively you can remove the file "%v".
@@ -43,7 +43,7 @@
 `
 	updateAvailableNotice = `
 Upgrade of Kopia from %v to %v is available.
-Visit https://github.com/kopia/kopia/releases/latest to download it.
+Run 'kopia update install' or visit https://github.com/kopia/kopia/releases/latest to install it.
 
 `
 )
@@ -54,6 +54,16 @@ type updateState struct {
 	NextCheckTime    time.Time `json:"nextCheckTimestamp"`
 	NextNotifyTime   time.Time `json:"nextNotifyTimestamp"`
 	AvailableVersion string    `json:"availableVersion"`
+	Channel          string    `json:"channel,omitempty"`
+	PinnedVersion    string    `json:"pinnedVersion,omitempty"`
+}
+
+func (us *updateState) channel() string {
+	if us.Channel == "" {
+		return selfupdate.ChannelStable
+	}
+
+	return us.Channel
 }
 
 // updateStateFilename returns the name of the update state.
@@ -111,35 +121,18 @@ func maybeInitializeUpdateCheck(ctx context.Context) {
 	}
 }
 
-// getLatestReleaseNameFromGitHub gets the name of the release marked 'latest' on GitHub.
-func getLatestReleaseNameFromGitHub(ctx context.Context) (string, error) {
+// getLatestReleaseNameFromGitHub gets the name of the newest release on the channel the user
+// is subscribed to or the pinned version.
+func getLatestReleaseNameFromGitHub(ctx context.Context, us *updateState) (string, error) {
 	ctx, cancel := context.WithTimeout(ctx, githubTimeout)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", latestReleaseGitHubURL, nil)
-	if err != nil {
-		return "", errors.Wrap(err, "unable to get latest release from github")
-	}
-
-	resp, err := http.DefaultClient.Do(req)
+	rel, err := selfupdate.FindRelease(ctx, selfupdate.Options{}, us.channel(), us.PinnedVersion)
 	if err != nil {
 		return "", errors.Wrap(err, "unable to get latest release from github")
 	}
-	defer resp.Body.Close() //nolint:errcheck
-
-	if resp.StatusCode != http.StatusOK {
-		return "", errors.Errorf("invalid status code from GitHub: %v", resp.StatusCode)
-	}
-
-	var responseObject struct {
-		Name string `json:"name"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&responseObject); err != nil {
-		return "", errors.Wrap(err, "invalid Github API response")
-	}
 
-	return responseObject.Name, nil
+	return rel.Version(), nil
 }
 
 // verifyGitHubReleaseIsComplete downloads checksum file to verify that the release is complete.
@@ -217,7 +210,7 @@ func maybeCheckGithub(ctx context.Context, us *updateState) error {
 		return errors.Wrap(err, "unable to write update state")
 	}
 
-	newAvailableVersion, err := getLatestReleaseNameFromGitHub(ctx)
+	newAvailableVersion, err := getLatestReleaseNameFromGitHub(ctx, us)
 	if err != nil {
 		return errors.Wrap(err, "update to get latest release from GitHub")
 	}
@@ -52,6 +52,19 @@ type Directory interface {
 	Readdir(ctx context.Context) (Entries, error)
 }
 
+// DirectoryIterator is optionally implemented by Directory that can stream their entries without
+// materializing the entire listing in memory. Entries may be delivered in any order.
+type DirectoryIterator interface {
+	IterateEntries(ctx context.Context, cb func(ctx context.Context, e Entry) error) error
+}
+
+// EntryWithSecurityDescriptor is optionally implemented by entries that can provide the Windows security descriptor
+// (owner, group, DACL and SACL) of the underlying file in SDDL format. Empty string is returned on platforms
+// that don't support security descriptors.
+type EntryWithSecurityDescriptor interface {
+	SecurityDescriptor() (string, error)
+}
+
 // DirectoryWithSummary is optionally implemented by Directory that provide summary.
 type DirectoryWithSummary interface {
 	Summary(ctx context.Context) (*DirectorySummary, error)
@@ -76,6 +89,27 @@ func ReadDirAndFindChild(ctx context.Context, d Directory, name string) (Entry,
 	return e, nil
 }
 
+// IterateEntries invokes the provided callback for each entry in the directory, stopping at the first error.
+// Directories implementing DirectoryIterator are streamed, others are read in full using Readdir().
+func IterateEntries(ctx context.Context, d Directory, cb func(ctx context.Context, e Entry) error) error {
+	if it, ok := d.(DirectoryIterator); ok {
+		return it.IterateEntries(ctx, cb)
+	}
+
+	entries, err := d.Readdir(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := cb(ctx, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // MaxFailedEntriesPerDirectorySummary is the maximum number of failed entries per directory summary.
 const MaxFailedEntriesPerDirectorySummary = 10
 
@@ -92,30 +92,33 @@ func isCorrectCacheDirSignature(ctx context.Context, f fs.File) (bool, error) {
 	return string(sig) == validSignature, nil
 }
 
-func (d *ignoreDirectory) skipCacheDirectory(ctx context.Context, entries fs.Entries, relativePath string, policyTree *policy.Tree) fs.Entries {
+// findChildFunc looks up a direct child of a directory by name, returning nil if not found.
+type findChildFunc func(name string) fs.Entry
+
+func (d *ignoreDirectory) isCacheDirectory(ctx context.Context, findChild findChildFunc, relativePath string, policyTree *policy.Tree) bool {
 	if !policyTree.EffectivePolicy().FilesPolicy.IgnoreCacheDirectoriesOrDefault(true) {
-		return entries
+		return false
 	}
 
-	f, ok := entries.FindByName(repo.CacheDirMarkerFile).(fs.File)
-	if ok {
-		correct, err := isCorrectCacheDirSignature(ctx, f)
-		if err != nil {
-			log(ctx).Debugf("unable to check cache dir signature, assuming not a cache directory: %v", err)
-			return entries
-		}
+	f, ok := findChild(repo.CacheDirMarkerFile).(fs.File)
+	if !ok {
+		return false
+	}
 
-		if correct {
-			// if the given directory contains a marker file used for kopia cache, pretend the directory was empty.
-			for _, oi := range d.parentContext.onIgnore {
-				oi(relativePath, d)
-			}
+	correct, err := isCorrectCacheDirSignature(ctx, f)
+	if err != nil {
+		log(ctx).Debugf("unable to check cache dir signature, assuming not a cache directory: %v", err)
+		return false
+	}
 
-			return nil
+	if correct {
+		// if the given directory contains a marker file used for kopia cache, pretend the directory was empty.
+		for _, oi := range d.parentContext.onIgnore {
+			oi(relativePath, d)
 		}
 	}
 
-	return entries
+	return correct
 }
 
 func (d *ignoreDirectory) Readdir(ctx context.Context) (fs.Entries, error) {
@@ -124,9 +127,11 @@ func (d *ignoreDirectory) Readdir(ctx context.Context) (fs.Entries, error) {
 		return nil, err
 	}
 
-	entries = d.skipCacheDirectory(ctx, entries, d.relativePath, d.policyTree)
+	if d.isCacheDirectory(ctx, entries.FindByName, d.relativePath, d.policyTree) {
+		return nil, nil
+	}
 
-	thisContext, err := d.buildContext(ctx, entries)
+	thisContext, err := d.buildContext(ctx, entries.FindByName)
 	if err != nil {
 		return nil, err
 	}
@@ -134,29 +139,83 @@ func (d *ignoreDirectory) Readdir(ctx context.Context) (fs.Entries, error) {
 	result := make(fs.Entries, 0, len(entries))
 
 	for _, e := range entries {
-		if !thisContext.shouldIncludeByName(d.relativePath+"/"+e.Name(), e) {
-			continue
+		if e = d.maybeWrapEntry(thisContext, e); e != nil {
+			result = append(result, e)
 		}
+	}
 
-		if maxSize := thisContext.maxFileSize; maxSize > 0 && e.Size() > maxSize {
-			continue
+	return result, nil
+}
+
+// IterateEntries streams entries of the underlying directory that are not ignored.
+// Ignore rules are determined upfront by looking up dot-ignore files individually.
+func (d *ignoreDirectory) IterateEntries(ctx context.Context, cb func(ctx context.Context, e fs.Entry) error) error {
+	if _, ok := d.Directory.(fs.DirectoryIterator); !ok {
+		// underlying directory does not support streaming, avoid listing it once per looked up child.
+		entries, err := d.Readdir(ctx)
+		if err != nil {
+			return err
 		}
 
-		if !thisContext.shouldIncludeByDevice(e, d) {
-			continue
+		for _, e := range entries {
+			if err := cb(ctx, e); err != nil {
+				return err
+			}
 		}
 
-		if dir, ok := e.(fs.Directory); ok {
-			e = &ignoreDirectory{d.relativePath + "/" + e.Name(), thisContext, d.policyTree.Child(e.Name()), dir}
+		return nil
+	}
+
+	findChild := func(name string) fs.Entry {
+		e, err := d.Directory.Child(ctx, name)
+		if err != nil {
+			return nil
 		}
 
-		result = append(result, e)
+		return e
 	}
 
-	return result, nil
+	if d.isCacheDirectory(ctx, findChild, d.relativePath, d.policyTree) {
+		return nil
+	}
+
+	thisContext, err := d.buildContext(ctx, findChild)
+	if err != nil {
+		return err
+	}
+
+	return fs.IterateEntries(ctx, d.Directory, func(ctx context.Context, e fs.Entry) error {
+		if e = d.maybeWrapEntry(thisContext, e); e != nil {
+			return cb(ctx, e)
+		}
+
+		return nil
+	})
+}
+
+// maybeWrapEntry returns nil if the provided entry should be ignored, otherwise returns the entry
+// wrapped so that ignore rules also apply to its descendants.
+func (d *ignoreDirectory) maybeWrapEntry(thisContext *ignoreContext, e fs.Entry) fs.Entry {
+	if !thisContext.shouldIncludeByName(d.relativePath+"/"+e.Name(), e) {
+		return nil
+	}
+
+	if maxSize := thisContext.maxFileSize; maxSize > 0 && e.Size() > maxSize {
+		return nil
+	}
+
+	if !thisContext.shouldIncludeByDevice(e, d) {
+		return nil
+	}
+
+	if dir, ok := e.(fs.Directory); ok {
+		return &ignoreDirectory{d.relativePath + "/" + e.Name(), thisContext, d.policyTree.Child(e.Name()), dir}
+	}
+
+	return e
 }
 
-func (d *ignoreDirectory) buildContext(ctx context.Context, entries fs.Entries) (*ignoreContext, error) {
+func (d *ignoreDirectory) buildContext(ctx context.Context, findChild findChildFunc) (*ignoreContext, error) {
 	effectiveDotIgnoreFiles := d.parentContext.dotIgnoreFiles
 
 	pol := d.policyTree.DefinedPolicy()
@@ -167,7 +226,7 @@ func (d *ignoreDirectory) buildContext(ctx context.Context, entries fs.Entries)
 	var foundDotIgnoreFiles bool
 
 	for _, dotfile := range effectiveDotIgnoreFiles {
-		if e := entries.FindByName(dotfile); e != nil {
+		if e := findChild(dotfile); e != nil {
 			foundDotIgnoreFiles = true
 		}
 	}
@@ -191,7 +250,7 @@ func (d *ignoreDirectory) buildContext(ctx context.Context, entries fs.Entries)
 		}
 	}
 
-	if err := newic.loadDotIgnoreFiles(ctx, d.relativePath, entries, effectiveDotIgnoreFiles); err != nil {
+	if err := newic.loadDotIgnoreFiles(ctx, d.relativePath, findChild, effectiveDotIgnoreFiles); err != nil {
 		return nil, err
 	}
 
@@ -227,9 +286,9 @@ func (c *ignoreContext) overrideFromPolicy(fp *policy.FilesPolicy, dirPath strin
 	return nil
 }
 
-func (c *ignoreContext) loadDotIgnoreFiles(ctx context.Context, dirPath string, entries fs.Entries, dotIgnoreFiles []string) error {
+func (c *ignoreContext) loadDotIgnoreFiles(ctx context.Context, dirPath string, findChild findChildFunc, dotIgnoreFiles []string) error {
 	for _, dotIgnoreFile := range dotIgnoreFiles {
-		e := entries.FindByName(dotIgnoreFile)
+		e := findChild(dotIgnoreFile)
 		if e == nil {
 			// no dotfile
 			continue
@@ -320,7 +379,10 @@ func New(dir fs.Directory, policyTree *policy.Tree, options ...Option) fs.Direct
 	return &ignoreDirectory{".", rootContext, policyTree, dir}
 }
 
-var _ fs.Directory = &ignoreDirectory{}
+var (
+	_ fs.Directory         = &ignoreDirectory{}
+	_ fs.DirectoryIterator = &ignoreDirectory{}
+)
 
 // ReportIgnoredFiles returns an Option causing ignorefs to call the provided function whenever a file or directory is ignored.
 func ReportIgnoredFiles(f IgnoreCallback) Option {
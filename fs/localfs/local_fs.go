@@ -77,7 +77,14 @@ func (e *filesystemEntry) Device() fs.DeviceInfo {
 	return e.device
 }
 
-var _ os.FileInfo = (*filesystemEntry)(nil)
+func (e *filesystemEntry) SecurityDescriptor() (string, error) {
+	return platformSpecificSecurityDescriptor(e.fullPath())
+}
+
+var (
+	_ os.FileInfo                    = (*filesystemEntry)(nil)
+	_ fs.EntryWithSecurityDescriptor = (*filesystemEntry)(nil)
+)
 
 func newEntry(fi os.FileInfo, parentDir string) filesystemEntry {
 	return filesystemEntry{
@@ -129,14 +136,33 @@ type entryWithError struct {
 }
 
 func (fsd *filesystemDirectory) Readdir(ctx context.Context) (fs.Entries, error) {
+	var entries fs.Entries
+
+	err := fsd.IterateEntries(ctx, func(ctx context.Context, e fs.Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+
+	sort.Sort(sortedEntries(entries))
+
+	// return any error encountered when listing or reading the directory
+	return entries, err
+}
+
+// IterateEntries streams directory entries to the provided callback in no particular order,
+// keeping at most dirListingPrefetch entries in memory at any given time.
+func (fsd *filesystemDirectory) IterateEntries(ctx context.Context, cb func(ctx context.Context, e fs.Entry) error) error {
 	fullPath := fsd.fullPath()
 
 	f, direrr := os.Open(fullPath) //nolint:gosec
 	if direrr != nil {
-		return nil, direrr
+		return direrr
 	}
 	defer f.Close() //nolint:errcheck,gosec
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	// start feeding directory entry names to namesCh
 	namesCh := make(chan string, dirListingPrefetch)
 
@@ -148,7 +174,11 @@ func (fsd *filesystemDirectory) Readdir(ctx context.Context) (fs.Entries, error)
 		for {
 			names, err := f.Readdirnames(numEntriesToRead)
 			for _, name := range names {
-				namesCh <- name
+				select {
+				case namesCh <- name:
+				case <-ctx.Done():
+					return
+				}
 			}
 
 			if err == nil {
@@ -178,6 +208,8 @@ func (fsd *filesystemDirectory) Readdir(ctx context.Context) (fs.Entries, error)
 			defer workersWG.Done()
 
 			for n := range namesCh {
+				var ewe entryWithError
+
 				fi, staterr := os.Lstat(fullPath + "/" + n)
 
 				switch {
@@ -185,17 +217,22 @@ func (fsd *filesystemDirectory) Readdir(ctx context.Context) (fs.Entries, error)
 					// lost the race - ignore.
 					continue
 				case staterr != nil:
-					entriesCh <- entryWithError{err: errors.Errorf("unable to stat directory entry %q: %v", n, staterr)}
-					continue
+					ewe.err = errors.Errorf("unable to stat directory entry %q: %v", n, staterr)
+				default:
+					e, fierr := entryFromChildFileInfo(fi, fullPath)
+					if fierr != nil {
+						log(ctx).Warningf("unable to create directory entry %q: %v", fi.Name(), fierr)
+						continue
+					}
+
+					ewe.entry = e
 				}
 
-				e, fierr := entryFromChildFileInfo(fi, fullPath)
-				if fierr != nil {
-					log(ctx).Warningf("unable to create directory entry %q: %v", fi.Name(), fierr)
-					continue
+				select {
+				case entriesCh <- ewe:
+				case <-ctx.Done():
+					return
 				}
-
-				entriesCh <- entryWithError{entry: e}
 			}
 		}()
 	}
@@ -206,26 +243,41 @@ func (fsd *filesystemDirectory) Readdir(ctx context.Context) (fs.Entries, error)
 		close(entriesCh)
 	}()
 
-	// drain the entriesCh into a slice and sort it
-	var entries fs.Entries
+	var (
+		firstErr error
+		cbErr    error
+	)
 
+	// deliver entries to the callback as they arrive.
 	for e := range entriesCh {
 		if e.err != nil {
 			// only return the first error
-			if readDirErr == nil {
-				readDirErr = e.err
+			if firstErr == nil {
+				firstErr = e.err
 			}
 
 			continue
 		}
 
-		entries = append(entries, e.entry)
+		if cbErr != nil {
+			continue
+		}
+
+		if cbErr = cb(ctx, e.entry); cbErr != nil {
+			// stop producers, the remaining entries are drained and discarded.
+			cancel()
+		}
 	}
 
-	sort.Sort(sortedEntries(entries))
+	if cbErr != nil {
+		return cbErr
+	}
 
-	// return any error encountered when listing or reading the directory
-	return entries, readDirErr
+	if readDirErr != nil {
+		return readDirErr
+	}
+
+	return firstErr
 }
 
 type fileWithMetadata struct {
@@ -307,7 +359,8 @@ func entryFromChildFileInfo(fi os.FileInfo, parentDir string) (fs.Entry, error)
 }
 
 var (
-	_ fs.Directory = &filesystemDirectory{}
-	_ fs.File      = &filesystemFile{}
-	_ fs.Symlink   = &filesystemSymlink{}
+	_ fs.Directory         = &filesystemDirectory{}
+	_ fs.DirectoryIterator = &filesystemDirectory{}
+	_ fs.File              = &filesystemFile{}
+	_ fs.Symlink           = &filesystemSymlink{}
 )
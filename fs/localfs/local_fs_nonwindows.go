@@ -29,3 +29,8 @@ func platformSpecificDeviceInfo(fi os.FileInfo) fs.DeviceInfo {
 
 	return oi
 }
+
+func platformSpecificSecurityDescriptor(path string) (string, error) {
+	// security descriptors are only supported on Windows.
+	return "", nil
+}
@@ -1,6 +1,8 @@
 package localfs
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -139,6 +141,66 @@ func verifyChild(t *testing.T, dir fs.Directory) {
 	}
 }
 
+func TestIterateEntries(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	tmp, err := ioutil.TempDir("", "kopia")
+	if err != nil {
+		t.Fatalf("cannot create temp directory: %v", err)
+	}
+
+	defer os.RemoveAll(tmp)
+
+	const numFiles = 1000
+
+	for i := 0; i < numFiles; i++ {
+		assertNoError(t, ioutil.WriteFile(filepath.Join(tmp, fmt.Sprintf("f%v", i)), []byte{1, 2, 3}, 0o777))
+	}
+
+	dir, err := Directory(tmp)
+	if err != nil {
+		t.Fatalf("error opening directory: %v", err)
+	}
+
+	seen := map[string]bool{}
+
+	if err = fs.IterateEntries(ctx, dir, func(ctx context.Context, e fs.Entry) error {
+		if seen[e.Name()] {
+			t.Errorf("duplicate entry: %v", e.Name())
+		}
+
+		seen[e.Name()] = true
+
+		return nil
+	}); err != nil {
+		t.Fatalf("error iterating: %v", err)
+	}
+
+	if got, want := len(seen), numFiles; got != want {
+		t.Errorf("unexpected number of entries: %v, want %v", got, want)
+	}
+
+	// callback errors stop the iteration and are returned as-is.
+	errStop := errors.New("stop")
+	cnt := 0
+
+	if err = fs.IterateEntries(ctx, dir, func(ctx context.Context, e fs.Entry) error {
+		cnt++
+
+		if cnt == 10 {
+			return errStop
+		}
+
+		return nil
+	}); err != errStop {
+		t.Errorf("unexpected error: %v, want %v", err, errStop)
+	}
+
+	if got, want := cnt, 10; got != want {
+		t.Errorf("unexpected number of callbacks: %v, want %v", got, want)
+	}
+}
+
 func assertNoError(t *testing.T, err error) {
 	t.Helper()
 
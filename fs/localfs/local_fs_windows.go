@@ -3,9 +3,17 @@
 import (
 	"os"
 
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+
 	"github.com/kopia/kopia/fs"
 )
 
+const (
+	ownerGroupAndDACL = windows.OWNER_SECURITY_INFORMATION | windows.GROUP_SECURITY_INFORMATION | windows.DACL_SECURITY_INFORMATION
+	withSACL          = ownerGroupAndDACL | windows.SACL_SECURITY_INFORMATION
+)
+
 func platformSpecificOwnerInfo(fi os.FileInfo) fs.OwnerInfo {
 	return fs.OwnerInfo{}
 }
@@ -13,3 +21,22 @@ func platformSpecificOwnerInfo(fi os.FileInfo) fs.OwnerInfo {
 func platformSpecificDeviceInfo(fi os.FileInfo) fs.DeviceInfo {
 	return fs.DeviceInfo{}
 }
+
+func platformSpecificSecurityDescriptor(path string) (string, error) {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, withSACL)
+	if errors.Is(err, windows.ERROR_PRIVILEGE_NOT_HELD) || errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+		// reading SACL requires SeSecurityPrivilege, capture the rest of the descriptor without it.
+		sd, err = windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, ownerGroupAndDACL)
+	}
+
+	if errors.Is(err, windows.ERROR_NOT_SUPPORTED) || errors.Is(err, windows.ERROR_INVALID_FUNCTION) {
+		// some network shares and non-NTFS filesystems don't support security descriptors.
+		return "", nil
+	}
+
+	if err != nil {
+		return "", errors.Wrap(err, "unable to get security descriptor")
+	}
+
+	return sd.String(), nil
+}
@@ -0,0 +1,117 @@
+// Package virtualfs implements virtual filesystem entries whose contents don't come from the local filesystem.
+package virtualfs
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+)
+
+// ErrReaderAlreadyUsed is returned when a streaming file is opened more than once.
+var ErrReaderAlreadyUsed = errors.New("cannot open a streaming file more than once")
+
+// streamingFile is a virtual file whose contents are read from a stream, such as standard input.
+// Its size is unknown until the stream has been read completely.
+type streamingFile struct {
+	name    string
+	modTime time.Time
+	size    int64
+
+	mu     sync.Mutex
+	reader io.Reader // nil once the file has been opened
+}
+
+// NewStreamingFile returns a virtual file with the provided name whose contents are read from the provided
+// reader, which can only be done once.
+func NewStreamingFile(name string, modTime time.Time, r io.Reader) fs.File {
+	return &streamingFile{name: name, modTime: modTime, reader: r}
+}
+
+func (f *streamingFile) IsDir() bool {
+	return false
+}
+
+func (f *streamingFile) Name() string {
+	return f.name
+}
+
+func (f *streamingFile) ModTime() time.Time {
+	return f.modTime
+}
+
+func (f *streamingFile) Mode() os.FileMode {
+	return 0o600 // nolint:gomnd
+}
+
+func (f *streamingFile) Size() int64 {
+	return f.size
+}
+
+func (f *streamingFile) Owner() fs.OwnerInfo {
+	return fs.OwnerInfo{}
+}
+
+func (f *streamingFile) Device() fs.DeviceInfo {
+	return fs.DeviceInfo{}
+}
+
+func (f *streamingFile) Sys() interface{} {
+	return nil
+}
+
+func (f *streamingFile) Open(ctx context.Context) (fs.Reader, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.reader == nil {
+		return nil, ErrReaderAlreadyUsed
+	}
+
+	r := &streamingReader{f: f, r: f.reader}
+	f.reader = nil
+
+	return r, nil
+}
+
+// streamingReader reads the stream of a streaming file.
+type streamingReader struct {
+	f      *streamingFile
+	r      io.Reader
+	offset int64
+}
+
+func (r *streamingReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.offset += int64(n)
+
+	return n, err // nolint:wrapcheck
+}
+
+// Seek only supports determining the current offset, since the stream can't be read again.
+func (r *streamingReader) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekCurrent {
+		return r.offset, nil
+	}
+
+	return 0, errors.New("streaming file is not seekable")
+}
+
+// Entry returns the file with the size equal to the number of bytes read so far.
+func (r *streamingReader) Entry() (fs.Entry, error) {
+	return &streamingFile{name: r.f.name, modTime: r.f.modTime, size: r.offset}, nil
+}
+
+func (r *streamingReader) Close() error {
+	return nil
+}
+
+var (
+	_ fs.File   = (*streamingFile)(nil)
+	_ fs.Reader = (*streamingReader)(nil)
+)
@@ -0,0 +1,59 @@
+package virtualfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestStreamingFile(t *testing.T) {
+	ctx := context.Background()
+	modTime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := []byte("some streamed data")
+
+	f := NewStreamingFile("dump.sql", modTime, bytes.NewReader(data))
+
+	if f.Name() != "dump.sql" || f.IsDir() || !f.ModTime().Equal(modTime) || f.Size() != 0 {
+		t.Fatalf("unexpected attributes: %v %v %v %v", f.Name(), f.IsDir(), f.ModTime(), f.Size())
+	}
+
+	r, err := f.Open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close() //nolint:errcheck
+
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Errorf("unexpected contents: %q", got)
+	}
+
+	if off, err := r.Seek(0, io.SeekCurrent); err != nil || off != int64(len(data)) {
+		t.Errorf("unexpected offset: %v %v", off, err)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err == nil {
+		t.Errorf("unexpected success seeking to the start")
+	}
+
+	e, err := r.Entry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Size() != int64(len(data)) {
+		t.Errorf("unexpected size after reading: %v", e.Size())
+	}
+
+	if _, err := f.Open(ctx); err != ErrReaderAlreadyUsed {
+		t.Errorf("unexpected error opening the file again: %v", err)
+	}
+}
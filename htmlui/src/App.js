@@ -5,6 +5,7 @@ import Nav from 'react-bootstrap/Nav';
 import Navbar from 'react-bootstrap/Navbar';
 import { BrowserRouter as Router, NavLink, Route, Switch, Redirect } from 'react-router-dom';
 import './App.css';
+import { ClientsTable } from "./ClientsTable";
 import { DirectoryObject } from "./DirectoryObject";
 import logo from './kopia-flat.svg';
 import { PoliciesTable } from "./PoliciesTable";
@@ -22,6 +23,7 @@ function App() {
           <Nav className="mr-auto">
             <NavLink className="nav-link" activeClassName="active" to="/snapshots">Snapshots</NavLink>
             <NavLink className="nav-link" activeClassName="active" to="/policies">Policies</NavLink>
+            <NavLink className="nav-link" activeClassName="active" to="/clients">Clients</NavLink>
             <NavLink className="nav-link" activeClassName="active" to="/repo">Repository</NavLink>
           </Nav>
         </Navbar.Collapse>
@@ -33,6 +35,7 @@ function App() {
           <Route path="/snapshots/dir/:oid" component={DirectoryObject} />
           <Route path="/snapshots" component={SourcesTable} />
           <Route path="/policies" component={PoliciesTable} />
+          <Route path="/clients" component={ClientsTable} />
           <Route path="/repo" component={RepoStatus} />
           <Route exact path="/">
             <Redirect to="/snapshots" />
@@ -0,0 +1,110 @@
+import axios from 'axios';
+import moment from 'moment';
+import React, { Component } from 'react';
+import Badge from 'react-bootstrap/Badge';
+import Row from 'react-bootstrap/Row';
+import Spinner from 'react-bootstrap/Spinner';
+import MyTable from './Table';
+import { compare, redirectIfNotConnected, sizeDisplayName } from './uiutil';
+
+export class ClientsTable extends Component {
+    constructor() {
+        super();
+        this.state = {
+            clients: [],
+            serverVersion: "",
+            isLoading: false,
+            error: null,
+        };
+
+        this.fetchClients = this.fetchClients.bind(this);
+    }
+
+    componentDidMount() {
+        this.setState({ isLoading: true });
+        this.fetchClients();
+    }
+
+    fetchClients() {
+        axios.get('/api/v1/clients').then(result => {
+            this.setState({
+                clients: result.data.clients,
+                serverVersion: result.data.serverVersion,
+                isLoading: false,
+            });
+        }).catch(error => {
+            redirectIfNotConnected(error);
+            this.setState({
+                error,
+                isLoading: false
+            });
+        });
+    }
+
+    lastSnapshotCell(ls) {
+        if (!ls) {
+            return <Badge variant="secondary">none</Badge>;
+        }
+
+        let result = <Badge variant="success">ok</Badge>;
+        if (ls.incomplete) {
+            result = <Badge variant="warning" title={ls.incomplete}>incomplete</Badge>;
+        } else if (ls.errorCount > 0) {
+            result = <Badge variant="danger">{ls.errorCount} errors</Badge>;
+        }
+
+        return <p title={ls.source.path + "\n" + moment(ls.startTime).toLocaleString()}>{moment(ls.startTime).fromNow()}&nbsp;{result}</p>;
+    }
+
+    render() {
+        let { clients, serverVersion, isLoading, error } = this.state;
+        if (error) {
+            return <p>{error.message}</p>;
+        }
+        if (isLoading) {
+            return <Spinner animation="border" variant="primary" />;
+        }
+
+        const columns = [{
+            id: 'client',
+            Header: 'Client',
+            accessor: x => x.username + '@' + x.hostname,
+            sortType: (a, b) => compare(a.original.hostname + a.original.username, b.original.hostname + b.original.username),
+        }, {
+            id: 'version',
+            Header: 'Version',
+            width: 200,
+            accessor: x => x.version,
+            Cell: x => <>{x.cell.value}{x.cell.value !== serverVersion && <>&nbsp;<Badge variant="warning">outdated</Badge></>}</>,
+        }, {
+            id: 'os',
+            Header: 'OS',
+            width: 120,
+            accessor: x => x.os + '/' + x.arch,
+        }, {
+            id: 'cacheSize',
+            Header: 'Cache Size',
+            width: 120,
+            accessor: x => x.cacheSizeBytes,
+            Cell: x => sizeDisplayName(x.cell.value),
+        }, {
+            id: 'lastSeen',
+            Header: 'Last Seen',
+            width: 160,
+            accessor: x => x.lastSeen,
+            Cell: x => <p title={moment(x.cell.value).toLocaleString()}>{moment(x.cell.value).fromNow()}</p>,
+        }, {
+            id: 'lastSnapshot',
+            Header: 'Last Snapshot',
+            width: 200,
+            accessor: x => x.lastSnapshot ? x.lastSnapshot.startTime : null,
+            Cell: x => this.lastSnapshotCell(x.row.original.lastSnapshot),
+        }]
+
+        return <div className="padded">
+            <Row>
+                <MyTable data={clients} columns={columns} />
+            </Row>
+        </div>;
+    }
+}
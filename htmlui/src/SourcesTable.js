@@ -1,4 +1,4 @@
-import { faStopCircle, faSync, faUserFriends } from '@fortawesome/free-solid-svg-icons';
+import { faPause, faPlay, faStopCircle, faSync, faUserFriends } from '@fortawesome/free-solid-svg-icons';
 import { FontAwesomeIcon } from '@fortawesome/react-fontawesome';
 import axios from 'axios';
 import moment from 'moment';
@@ -31,6 +31,7 @@ export class SourcesTable extends Component {
 
             localSourceName: "",
             multiUser: false,
+            pause: null,
             selectedOwner: localSnapshots,
             selectedDirectory: "",
         };
@@ -46,6 +47,8 @@ export class SourcesTable extends Component {
         this.createPolicy = this.createPolicy.bind(this);
         this.cancelSnapshot = this.cancelSnapshot.bind(this);
         this.startSnapshot = this.startSnapshot.bind(this);
+        this.pauseSnapshots = this.pauseSnapshots.bind(this);
+        this.resumeSnapshots = this.resumeSnapshots.bind(this);
     }
 
     componentDidMount() {
@@ -64,6 +67,7 @@ export class SourcesTable extends Component {
                 localSourceName: result.data.localUsername + "@" + result.data.localHost,
                 multiUser: result.data.multiUser,
                 sources: result.data.sources,
+                pause: result.data.pause,
                 isLoading: false,
             });
         }).catch(error => {
@@ -222,6 +226,10 @@ export class SourcesTable extends Component {
                 </>;
 
             default:
+                if (x.row.original.deferReason) {
+                    return <Badge variant="warning" title={x.row.original.deferReason}>Deferred</Badge>;
+                }
+
                 return "";
         }
     }
@@ -242,6 +250,27 @@ export class SourcesTable extends Component {
         });
     }
 
+    pauseSnapshots() {
+        const reason = window.prompt("Pause snapshots by all clients of the repository.\n\nReason:", "");
+        if (reason === null) {
+            return;
+        }
+
+        axios.post('/api/v1/sources/pause', { reason }).then(result => {
+            this.fetchSourcesWithoutSpinner();
+        }).catch(error => {
+            alert('failed');
+        });
+    }
+
+    resumeSnapshots() {
+        axios.post('/api/v1/sources/resume', {}).then(result => {
+            this.fetchSourcesWithoutSpinner();
+        }).catch(error => {
+            alert('failed');
+        });
+    }
+
     render() {
         let { sources, isLoading, error } = this.state;
         if (error) {
@@ -352,6 +381,9 @@ export class SourcesTable extends Component {
                     <Button variant="primary"><FontAwesomeIcon icon={faSync} /></Button>
                 </ButtonGroup>
             </ButtonToolbar>}
+            {this.state.pause && <p>
+                <Badge variant="warning">Paused</Badge>&nbsp;Snapshots have been paused by {this.state.pause.pausedBy} {moment(this.state.pause.since).fromNow()}{this.state.pause.reason && <>: {this.state.pause.reason}</>}
+            </p>}
             <ButtonToolbar>
                 <InputGroup>
                     <FormControl
@@ -378,6 +410,10 @@ export class SourcesTable extends Component {
                     <Dropdown.Item href="#" onClick={this.snapshotEveryDay}>Snapshot Every Day</Dropdown.Item>
                     {/* <Dropdown.Item href="#" onClick={this.createPolicy}>Create Policy</Dropdown.Item> */}
                 </DropdownButton>
+                &nbsp;
+                {this.state.pause ?
+                    <Button variant="outline-success" onClick={this.resumeSnapshots}><FontAwesomeIcon icon={faPlay} />&nbsp;Resume Snapshots</Button> :
+                    <Button variant="outline-warning" onClick={this.pauseSnapshots}><FontAwesomeIcon icon={faPause} />&nbsp;Pause Snapshots</Button>}
             </ButtonToolbar>
             <hr />
             <Row>
@@ -8,6 +8,7 @@
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
 
 	"github.com/pkg/errors"
 
@@ -18,6 +19,9 @@
 
 var log = logging.GetContextLoggerFunc("client")
 
+// ErrAccessDenied is returned when the server rejects the credentials or denies access to the requested resource.
+var ErrAccessDenied = errors.New("access denied")
+
 // KopiaAPIClient provides helper methods for communicating with Kopia API server.
 type KopiaAPIClient struct {
 	BaseURL    string
@@ -95,7 +99,19 @@ func requestReader(reqPayload interface{}) (io.Reader, string, error) {
 }
 
 func decodeResponse(resp *http.Response, respPayload interface{}) error {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		if msg := errorMessage(resp); msg != "" {
+			return errors.Wrapf(ErrAccessDenied, "server error: %v: %v", resp.Status, msg)
+		}
+
+		return errors.Wrapf(ErrAccessDenied, "server error: %v", resp.Status)
+	}
+
 	if resp.StatusCode != http.StatusOK {
+		if msg := errorMessage(resp); msg != "" {
+			return errors.Errorf("server error: %v: %v", resp.Status, msg)
+		}
+
 		return errors.Errorf("server error: %v", resp.Status)
 	}
 
@@ -117,6 +133,31 @@ func decodeResponse(resp *http.Response, respPayload interface{}) error {
 	return nil
 }
 
+// maxErrorMessageLength is the maximum length of error message read from the response.
+const maxErrorMessageLength = 4096
+
+// errorMessage returns the error message from JSON error response or plain text response body.
+func errorMessage(resp *http.Response) string {
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorMessageLength))
+	if err != nil {
+		return ""
+	}
+
+	var er struct {
+		Error string `json:"error"`
+	}
+
+	if json.Unmarshal(b, &er) == nil {
+		return er.Error
+	}
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		return strings.TrimSpace(string(b))
+	}
+
+	return ""
+}
+
 // Options encapsulates all optional parameters for KopiaAPIClient.
 type Options struct {
 	BaseURL string
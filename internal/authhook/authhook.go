@@ -0,0 +1,213 @@
+// Package authhook implements authentication and authorization of API server requests by an external
+// command or HTTP endpoint, which allows integrating the server with custom policy engines.
+//
+// Each check is described by a Request, which is passed as JSON to the standard input of the command
+// or POSTed to the endpoint. The command allows the request by exiting with code 0 and denies it by exiting
+// with any other code. The endpoint allows the request by responding with 2xx status and denies it
+// by responding with 401 or 403. Any other outcome is an error and results in the request being rejected.
+package authhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+)
+
+// Actions of hook requests.
+const (
+	ActionAuthenticate = "authenticate"
+	ActionAuthorize    = "authorize"
+)
+
+// Request describes a single check performed by the hook.
+type Request struct {
+	Action   string `json:"action"`
+	Username string `json:"username"`
+
+	// Password is only set when authenticating.
+	Password string `json:"password,omitempty"`
+
+	// Operation is only set when authorizing and consists of HTTP method and route of the API call,
+	// for example "DELETE /api/v1/manifests/{manifestID}".
+	Operation string `json:"operation,omitempty"`
+
+	// Labels of the manifest affected by the operation, if any, for example {"type":"snapshot",...}.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Hook checks requests using an external mechanism.
+type Hook interface {
+	// Check returns true if the request is allowed or false if it's denied.
+	Check(ctx context.Context, req *Request) (bool, error)
+}
+
+type execHook struct {
+	command string
+	args    []string
+	timeout time.Duration
+}
+
+func (h *execHook) Check(ctx context.Context, req *Request) (bool, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return false, errors.Wrap(err, "unable to marshal request")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.command, h.args...) //nolint:gosec
+	cmd.Stdin = bytes.NewReader(b)
+
+	err = cmd.Run()
+
+	var ee *exec.ExitError
+
+	switch {
+	case err == nil:
+		return true, nil
+
+	case ctx.Err() != nil:
+		return false, errors.Wrap(ctx.Err(), "auth hook command did not complete")
+
+	case errors.As(err, &ee):
+		return false, nil
+
+	default:
+		return false, errors.Wrap(err, "unable to run auth hook command")
+	}
+}
+
+// NewExec returns a hook that runs the provided command for each check.
+func NewExec(command string, args []string, timeout time.Duration) Hook {
+	return &execHook{command, args, timeout}
+}
+
+type httpHook struct {
+	url    string
+	client *http.Client
+}
+
+func (h *httpHook) Check(ctx context.Context, req *Request) (bool, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return false, errors.Wrap(err, "unable to marshal request")
+	}
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(b))
+	if err != nil {
+		return false, errors.Wrap(err, "unable to create request")
+	}
+
+	hreq.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(hreq)
+	if err != nil {
+		return false, errors.Wrap(err, "unable to call auth hook endpoint")
+	}
+
+	defer resp.Body.Close() //nolint:errcheck
+
+	io.Copy(ioutil.Discard, resp.Body) //nolint:errcheck
+
+	switch {
+	case resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices:
+		return true, nil
+
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		return false, nil
+
+	default:
+		return false, errors.Errorf("unexpected auth hook response: %v", resp.Status)
+	}
+}
+
+// NewHTTP returns a hook that POSTs each check to the provided URL.
+func NewHTTP(url string, timeout time.Duration) Hook {
+	return &httpHook{url, &http.Client{Timeout: timeout}}
+}
+
+type cachedResult struct {
+	allowed bool
+	expires time.Time
+}
+
+type cachedHook struct {
+	inner Hook
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	results map[[sha256.Size]byte]cachedResult
+}
+
+func (h *cachedHook) Check(ctx context.Context, req *Request) (bool, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return false, errors.Wrap(err, "unable to marshal request")
+	}
+
+	// key by the hash of the request, so that passwords are not kept in memory.
+	key := sha256.Sum256(b)
+	now := clock.Now()
+
+	h.mu.Lock()
+	r, ok := h.results[key]
+	h.mu.Unlock()
+
+	if ok && now.Before(r.expires) {
+		return r.allowed, nil
+	}
+
+	allowed, err := h.inner.Check(ctx, req)
+	if err != nil {
+		// errors are not cached.
+		return false, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for k, v := range h.results {
+		if !now.Before(v.expires) {
+			delete(h.results, k)
+		}
+	}
+
+	h.results[key] = cachedResult{allowed, now.Add(h.ttl)}
+
+	return allowed, nil
+}
+
+// Cached returns a hook that remembers results of the provided hook for a given amount of time.
+func Cached(h Hook, ttl time.Duration) Hook {
+	if ttl <= 0 {
+		return h
+	}
+
+	return &cachedHook{inner: h, ttl: ttl, results: map[[sha256.Size]byte]cachedResult{}}
+}
+
+// Reset forgets results remembered by a hook returned by Cached(), so that changes of the external
+// policy take effect immediately. Other hooks are not affected.
+func Reset(h Hook) {
+	c, ok := h.(*cachedHook)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.results = map[[sha256.Size]byte]cachedResult{}
+}
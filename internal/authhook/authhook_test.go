@@ -0,0 +1,106 @@
+package authhook_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/authhook"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+func TestExecHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses shell script")
+	}
+
+	ctx := testlogging.Context(t)
+
+	script := filepath.Join(t.TempDir(), "hook.sh")
+	require.NoError(t, ioutil.WriteFile(script, []byte("#!/bin/sh\ngrep -q '\"username\":\"alice\"'\n"), 0o700))
+
+	h := authhook.NewExec(script, nil, 10*time.Second)
+
+	allowed, err := h.Check(ctx, &authhook.Request{Action: authhook.ActionAuthorize, Username: "alice"})
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	allowed, err = h.Check(ctx, &authhook.Request{Action: authhook.ActionAuthorize, Username: "bob"})
+	require.NoError(t, err)
+	require.False(t, allowed)
+
+	_, err = authhook.NewExec(filepath.Join(t.TempDir(), "no-such-file"), nil, 10*time.Second).Check(ctx, &authhook.Request{})
+	require.Error(t, err)
+}
+
+func TestHTTPHook(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	calls := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		var req authhook.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case req.Operation == "DELETE /api/v1/manifests/{manifestID}" && req.Labels["type"] == "snapshot":
+			w.WriteHeader(http.StatusForbidden)
+		case req.Username == "error":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+
+	h := authhook.NewHTTP(srv.URL, 10*time.Second)
+
+	allowed, err := h.Check(ctx, &authhook.Request{Action: authhook.ActionAuthorize, Username: "alice", Operation: "GET /api/v1/sources"})
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	deleteSnapshot := &authhook.Request{
+		Action:    authhook.ActionAuthorize,
+		Username:  "alice",
+		Operation: "DELETE /api/v1/manifests/{manifestID}",
+		Labels:    map[string]string{"type": "snapshot"},
+	}
+
+	allowed, err = h.Check(ctx, deleteSnapshot)
+	require.NoError(t, err)
+	require.False(t, allowed)
+
+	_, err = h.Check(ctx, &authhook.Request{Action: authhook.ActionAuthorize, Username: "error"})
+	require.Error(t, err)
+
+	// cached results don't call the endpoint again.
+	cached := authhook.Cached(h, time.Minute)
+	calls = 0
+
+	for i := 0; i < 3; i++ {
+		allowed, err = cached.Check(ctx, deleteSnapshot)
+		require.NoError(t, err)
+		require.False(t, allowed)
+	}
+
+	require.Equal(t, 1, calls)
+
+	// reset results are checked again.
+	authhook.Reset(cached)
+
+	_, err = cached.Check(ctx, deleteSnapshot)
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+}
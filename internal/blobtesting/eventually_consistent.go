@@ -149,8 +149,8 @@ func (s *eventuallyConsistentStorage) GetMetadata(ctx context.Context, id blob.I
 	return s.realStorage.GetMetadata(ctx, id)
 }
 
-func (s *eventuallyConsistentStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes) error {
-	if err := s.realStorage.PutBlob(ctx, id, data); err != nil {
+func (s *eventuallyConsistentStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	if err := s.realStorage.PutBlob(ctx, id, data, opts); err != nil {
 		return err
 	}
 
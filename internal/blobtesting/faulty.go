@@ -47,12 +47,12 @@ func (s *FaultyStorage) GetMetadata(ctx context.Context, id blob.ID) (blob.Metad
 }
 
 // PutBlob implements blob.Storage.
-func (s *FaultyStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes) error {
+func (s *FaultyStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
 	if err := s.getNextFault(ctx, "PutBlob", id); err != nil {
 		return err
 	}
 
-	return s.Base.PutBlob(ctx, id, data)
+	return s.Base.PutBlob(ctx, id, data, opts)
 }
 
 // SetTime implements blob.Storage.
@@ -17,11 +17,18 @@
 // DataMap is a map of blob ID to their contents.
 type DataMap map[blob.ID][]byte
 
+// ErrBlobLocked is returned by map storage when attempting to delete or overwrite a blob before its retention expires.
+var ErrBlobLocked = errors.New("blob is locked")
+
+// legalHoldUntil is the retention time of blobs written with legal hold.
+var legalHoldUntil = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+
 type mapStorage struct {
-	data    DataMap
-	keyTime map[blob.ID]time.Time
-	timeNow func() time.Time
-	mutex   sync.RWMutex
+	data        DataMap
+	keyTime     map[blob.ID]time.Time
+	retainUntil map[blob.ID]time.Time
+	timeNow     func() time.Time
+	mutex       sync.RWMutex
 }
 
 func (s *mapStorage) GetBlob(ctx context.Context, id blob.ID, offset, length int64) ([]byte, error) {
@@ -67,27 +74,76 @@ func (s *mapStorage) GetMetadata(ctx context.Context, id blob.ID) (blob.Metadata
 	return blob.Metadata{}, blob.ErrBlobNotFound
 }
 
-func (s *mapStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes) error {
+// PutBlobIfNotExists implements blob.ConditionalPutter.
+func (s *mapStorage) PutBlobIfNotExists(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	if err := blob.ValidateRetentionMode(opts.RetentionMode); err != nil {
+		return errors.Wrap(blob.ErrUnsupportedPutBlobOption, err.Error())
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.data[id]; exists {
+		return blob.ErrBlobAlreadyExists
+	}
+
+	s.putBlobLocked(id, data, opts)
+
+	return nil
+}
+
+func (s *mapStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	if err := blob.ValidateRetentionMode(opts.RetentionMode); err != nil {
+		return errors.Wrap(blob.ErrUnsupportedPutBlobOption, err.Error())
+	}
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	s.putBlobLocked(id, data, opts)
+
+	return nil
+}
+
+func (s *mapStorage) putBlobLocked(id blob.ID, data blob.Bytes, opts blob.PutOptions) {
 	s.keyTime[id] = s.timeNow()
 
+	// similar to versioned storage, overwriting locked blob is allowed, but it remains locked.
+	switch {
+	case opts.LegalHold:
+		s.retainUntil[id] = legalHoldUntil
+	case opts.RetentionMode != "" && opts.RetentionPeriod > 0:
+		if t := s.timeNow().Add(opts.RetentionPeriod); t.After(s.retainUntil[id]) {
+			s.retainUntil[id] = t
+		}
+	}
+
 	var b bytes.Buffer
 
 	data.WriteTo(&b)
 
 	s.data[id] = b.Bytes()
-
-	return nil
 }
 
 func (s *mapStorage) DeleteBlob(ctx context.Context, id blob.ID) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	if err := s.checkNotLocked(id); err != nil {
+		return err
+	}
+
 	delete(s.data, id)
 	delete(s.keyTime, id)
+	delete(s.retainUntil, id)
+
+	return nil
+}
+
+func (s *mapStorage) checkNotLocked(id blob.ID) error {
+	if t, ok := s.retainUntil[id]; ok && s.timeNow().Before(t) {
+		return errors.Wrapf(ErrBlobLocked, "%v is locked until %v", id, t)
+	}
 
 	return nil
 }
@@ -178,5 +234,5 @@ func NewMapStorage(data DataMap, keyTime map[blob.ID]time.Time, timeNow func() t
 		timeNow = clock.Now
 	}
 
-	return &mapStorage{data: data, keyTime: keyTime, timeNow: timeNow}
+	return &mapStorage{data: data, keyTime: keyTime, retainUntil: map[blob.ID]time.Time{}, timeNow: timeNow}
 }
@@ -38,7 +38,7 @@ func VerifyStorage(ctx context.Context, t testingT, r blob.Storage) {
 
 	// Now add blocks.
 	for _, b := range blocks {
-		if err := r.PutBlob(ctx, b.blk, gather.FromSlice(b.contents)); err != nil {
+		if err := r.PutBlob(ctx, b.blk, gather.FromSlice(b.contents), blob.PutOptions{}); err != nil {
 			t.Errorf("can't put blob: %v", err)
 		}
 
@@ -50,7 +50,7 @@ func VerifyStorage(ctx context.Context, t testingT, r blob.Storage) {
 
 	// Overwrite blocks.
 	for _, b := range blocks {
-		if err := r.PutBlob(ctx, b.blk, gather.FromSlice(b.contents)); err != nil {
+		if err := r.PutBlob(ctx, b.blk, gather.FromSlice(b.contents), blob.PutOptions{}); err != nil {
 			t.Errorf("can't put blob: %v", err)
 		}
 
@@ -0,0 +1,109 @@
+package blockcache
+
+import (
+	"container/list"
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+var errInvalidMap = errors.New("invalid allocation map")
+
+// encodeMap serializes entries of the provided LRU list, most recently used first, until the encoded map
+// reaches the provided maximum length. Each entry is stored as the length of its blob ID followed by
+// the blob ID, block number, length and timestamp, all encoded as varints.
+func encodeMap(lru *list.List, maxLength int64) (encoded []byte, written int) {
+	var (
+		scratch []byte
+		tmp     [binary.MaxVarintLen64]byte
+	)
+
+	appendUvarint := func(v uint64) {
+		scratch = append(scratch, tmp[0:binary.PutUvarint(tmp[:], v)]...)
+	}
+
+	for el := lru.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*entry)
+
+		scratch = scratch[:0]
+
+		appendUvarint(uint64(len(e.id)))
+		scratch = append(scratch, e.id...)
+		appendUvarint(uint64(e.offset / blockSize))
+		appendUvarint(uint64(e.length))
+		scratch = append(scratch, tmp[0:binary.PutVarint(tmp[:], e.timestamp.UnixNano())]...)
+
+		if int64(len(encoded)+len(scratch)) > maxLength {
+			break
+		}
+
+		encoded = append(encoded, scratch...)
+		written++
+	}
+
+	return encoded, written
+}
+
+// decodeMap returns entries of the map serialized by encodeMap.
+func decodeMap(b []byte) ([]*entry, error) {
+	var result []*entry
+
+	next := func() (uint64, error) {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return 0, errInvalidMap
+		}
+
+		b = b[n:]
+
+		return v, nil
+	}
+
+	for len(b) > 0 {
+		idLength, err := next()
+		if err != nil {
+			return nil, err
+		}
+
+		if idLength > uint64(len(b)) {
+			return nil, errInvalidMap
+		}
+
+		id := blob.ID(b[0:idLength])
+		b = b[idLength:]
+
+		block, err := next()
+		if err != nil {
+			return nil, err
+		}
+
+		length, err := next()
+		if err != nil {
+			return nil, err
+		}
+
+		if block > math.MaxInt64/blockSize || length > math.MaxInt64 {
+			return nil, errInvalidMap
+		}
+
+		ts, n := binary.Varint(b)
+		if n <= 0 {
+			return nil, errInvalidMap
+		}
+
+		b = b[n:]
+
+		result = append(result, &entry{
+			id:        id,
+			offset:    int64(block) * blockSize,
+			length:    int64(length),
+			timestamp: time.Unix(0, ts),
+		})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,110 @@
+package blockcache
+
+import "sort"
+
+// extent is a contiguous range of bytes in the data area.
+type extent struct {
+	offset int64
+	length int64
+}
+
+// allocator keeps track of free extents of the data area, sorted by offset.
+type allocator struct {
+	free []extent
+}
+
+func newAllocator(capacity int64) *allocator {
+	return &allocator{free: []extent{{0, capacity}}}
+}
+
+// allocate returns the offset of the first free extent of at least the provided length.
+func (a *allocator) allocate(length int64) (int64, bool) {
+	if length == 0 {
+		return 0, true
+	}
+
+	for i, e := range a.free {
+		if e.length < length {
+			continue
+		}
+
+		if e.length == length {
+			a.free = append(a.free[:i], a.free[i+1:]...)
+		} else {
+			a.free[i] = extent{e.offset + length, e.length - length}
+		}
+
+		return e.offset, true
+	}
+
+	return 0, false
+}
+
+// reserve marks the provided extent, which must be free, as used.
+func (a *allocator) reserve(offset, length int64) bool {
+	if length == 0 {
+		return true
+	}
+
+	i := sort.Search(len(a.free), func(i int) bool {
+		return a.free[i].offset+a.free[i].length > offset
+	})
+
+	if i == len(a.free) || a.free[i].offset > offset || a.free[i].offset+a.free[i].length < offset+length {
+		return false
+	}
+
+	e := a.free[i]
+
+	var replacement []extent
+
+	if e.offset < offset {
+		replacement = append(replacement, extent{e.offset, offset - e.offset})
+	}
+
+	if end := offset + length; end < e.offset+e.length {
+		replacement = append(replacement, extent{end, e.offset + e.length - end})
+	}
+
+	a.free = append(a.free[:i], append(replacement, a.free[i+1:]...)...)
+
+	return true
+}
+
+// release returns the provided extent to the pool of free extents, merging it with adjacent free extents.
+func (a *allocator) release(offset, length int64) {
+	if length == 0 {
+		return
+	}
+
+	i := sort.Search(len(a.free), func(i int) bool {
+		return a.free[i].offset > offset
+	})
+
+	// merge with the following extent
+	if i < len(a.free) && a.free[i].offset == offset+length {
+		length += a.free[i].length
+		a.free = append(a.free[:i], a.free[i+1:]...)
+	}
+
+	// merge with the preceding extent
+	if i > 0 && a.free[i-1].offset+a.free[i-1].length == offset {
+		a.free[i-1].length += length
+		return
+	}
+
+	a.free = append(a.free, extent{})
+	copy(a.free[i+1:], a.free[i:])
+	a.free[i] = extent{offset, length}
+}
+
+// freeBytes returns the total size of free extents.
+func (a *allocator) freeBytes() int64 {
+	var total int64
+
+	for _, e := range a.free {
+		total += e.length
+	}
+
+	return total
+}
@@ -0,0 +1,50 @@
+package blockcache
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllocator(t *testing.T) {
+	a := newAllocator(100)
+
+	for _, want := range []int64{0, 10, 20} {
+		if got, ok := a.allocate(10); !ok || got != want {
+			t.Fatalf("unexpected allocation: %v %v, want %v", got, ok, want)
+		}
+	}
+
+	if _, ok := a.allocate(71); ok {
+		t.Fatalf("unexpected successful allocation")
+	}
+
+	a.release(0, 10)
+	a.release(20, 10)
+
+	if want := []extent{{0, 10}, {20, 80}}; !reflect.DeepEqual(a.free, want) {
+		t.Fatalf("unexpected free extents: %v, want %v", a.free, want)
+	}
+
+	// releasing the middle extent merges all of them.
+	a.release(10, 10)
+
+	if want := []extent{{0, 100}}; !reflect.DeepEqual(a.free, want) {
+		t.Fatalf("unexpected free extents: %v, want %v", a.free, want)
+	}
+
+	if !a.reserve(40, 20) {
+		t.Fatalf("unable to reserve free extent")
+	}
+
+	if a.reserve(50, 5) {
+		t.Fatalf("unexpected reservation of used extent")
+	}
+
+	if want := []extent{{0, 40}, {60, 40}}; !reflect.DeepEqual(a.free, want) {
+		t.Fatalf("unexpected free extents: %v, want %v", a.free, want)
+	}
+
+	if got := a.freeBytes(); got != 80 {
+		t.Fatalf("unexpected free bytes: %v", got)
+	}
+}
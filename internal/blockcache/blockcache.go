@@ -0,0 +1,586 @@
+// Package blockcache implements cache storage in a single preallocated file or raw block device accessed using
+// direct IO, which avoids the filesystem metadata overhead of storing millions of small cache files.
+//
+// The file starts with a header block followed by the allocation map region and the data area.
+// The allocation map is only persisted when the storage is closed and is invalidated when it's opened,
+// so the cache starts empty after a crash. When the map does not fit in its region, least recently used
+// entries are left out of it.
+package blockcache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/logging"
+)
+
+var log = logging.GetContextLoggerFunc("blockcache")
+
+const (
+	// blockSize is the unit of allocation and alignment of direct IO.
+	blockSize = 4096
+
+	// minMapRegionSize is the minimum size of the region where the allocation map is persisted.
+	minMapRegionSize = 1 << 20
+
+	// mapRegionFraction is the fraction of the capacity reserved for the allocation map.
+	mapRegionFraction = 256
+
+	// evictionFraction is the fraction of the capacity evicted at once when the data area is full.
+	evictionFraction = 10
+
+	headerMagic   = "KOPIABC1"
+	headerVersion = 2
+
+	storageType = "blockcache"
+)
+
+// Options describes the block cache storage.
+type Options struct {
+	Path     string `json:"path"`
+	Capacity int64  `json:"capacity"`
+}
+
+// header is stored in the first block of the file.
+type header struct {
+	Magic      [8]byte
+	Version    uint32
+	_          uint32
+	DataOffset int64
+	DataLength int64
+	MapLength  int64
+	MapHash    [sha256.Size]byte
+}
+
+// entry describes a single cached blob.
+type entry struct {
+	id        blob.ID
+	offset    int64
+	length    int64
+	timestamp time.Time
+}
+
+// Storage implements blob.Storage in a single file or block device.
+type Storage struct {
+	Options
+
+	f      *os.File
+	unlock func()
+
+	dataOffset int64
+	dataLength int64
+
+	mu      sync.RWMutex
+	entries map[blob.ID]*list.Element // values are *entry
+	lru     *list.List                // entries ordered by timestamp, most recent first
+	alloc   *allocator
+	closed  bool
+}
+
+func (s *Storage) getEntryLocked(id blob.ID) *entry {
+	el := s.entries[id]
+	if el == nil {
+		return nil
+	}
+
+	return el.Value.(*entry)
+}
+
+// addEntryLocked adds the entry, which must be the most recently used one.
+func (s *Storage) addEntryLocked(e *entry) {
+	s.removeEntryLocked(e.id)
+	s.entries[e.id] = s.lru.PushFront(e)
+}
+
+func (s *Storage) removeEntryLocked(id blob.ID) {
+	el := s.entries[id]
+	if el == nil {
+		return
+	}
+
+	e := s.lru.Remove(el).(*entry)
+	s.alloc.release(e.offset, roundUp(e.length))
+	delete(s.entries, id)
+}
+
+// setTimestampLocked changes the timestamp of the entry and moves it to the corresponding position in LRU list.
+func (s *Storage) setTimestampLocked(el *list.Element, t time.Time) {
+	e := el.Value.(*entry)
+	e.timestamp = t
+
+	// find the first entry which is not more recent.
+	mark := s.lru.Front()
+	for mark != nil && (mark == el || mark.Value.(*entry).timestamp.After(t)) {
+		mark = mark.Next()
+	}
+
+	if mark == nil {
+		s.lru.MoveToBack(el)
+	} else {
+		s.lru.MoveBefore(el, mark)
+	}
+}
+
+func roundUp(n int64) int64 {
+	return (n + blockSize - 1) / blockSize * blockSize
+}
+
+// alignedBuffer returns a buffer of the provided length whose address is aligned as required by direct IO.
+func alignedBuffer(n int64) []byte {
+	b := make([]byte, n+blockSize)
+
+	//nolint:gosec
+	off := int64(blockSize-uintptr(unsafe.Pointer(&b[0]))%blockSize) % blockSize
+
+	return b[off : off+n]
+}
+
+// mapRegionSize returns the size of the allocation map region for a file of the provided total size.
+func mapRegionSize(totalSize int64) int64 {
+	n := roundUp(totalSize / mapRegionFraction)
+	if n < minMapRegionSize {
+		return minMapRegionSize
+	}
+
+	return n
+}
+
+// GetBlob implements blob.Storage.
+func (s *Storage) GetBlob(ctx context.Context, id blob.ID, offset, length int64) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e := s.getEntryLocked(id)
+	if e == nil {
+		return nil, blob.ErrBlobNotFound
+	}
+
+	if length < 0 {
+		length = e.length - offset
+	}
+
+	if offset < 0 || length < 0 || offset+length > e.length {
+		return nil, errors.Errorf("invalid offset/length")
+	}
+
+	start := e.offset + offset
+	alignedStart := start / blockSize * blockSize
+	buf := alignedBuffer(roundUp(start+length) - alignedStart)
+
+	if _, err := s.f.ReadAt(buf, s.dataOffset+alignedStart); err != nil {
+		return nil, errors.Wrapf(err, "error reading %v", id)
+	}
+
+	skip := start - alignedStart
+
+	return append([]byte(nil), buf[skip:skip+length]...), nil
+}
+
+// GetMetadata implements blob.Storage.
+func (s *Storage) GetMetadata(ctx context.Context, id blob.ID) (blob.Metadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e := s.getEntryLocked(id)
+	if e == nil {
+		return blob.Metadata{}, blob.ErrBlobNotFound
+	}
+
+	return blob.Metadata{BlobID: id, Length: e.length, Timestamp: e.timestamp}, nil
+}
+
+// PutBlob implements blob.Storage.
+func (s *Storage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	if opts.HasRetentionOptions() {
+		return blob.ErrUnsupportedPutBlobOption
+	}
+
+	length := int64(data.Length())
+	allocated := roundUp(length)
+
+	buf := alignedBuffer(allocated)
+	if _, err := io.ReadFull(data.Reader(), buf[0:length]); err != nil {
+		return errors.Wrap(err, "error reading data")
+	}
+
+	offset, err := s.allocate(ctx, allocated)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.f.WriteAt(buf, s.dataOffset+offset); err != nil {
+		s.mu.Lock()
+		s.alloc.release(offset, allocated)
+		s.mu.Unlock()
+
+		return errors.Wrapf(err, "error writing %v", id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addEntryLocked(&entry{id: id, offset: offset, length: length, timestamp: clock.Now()})
+
+	return nil
+}
+
+// allocate returns the offset of a free extent of the provided length, evicting least recently used entries if needed.
+func (s *Storage) allocate(ctx context.Context, length int64) (int64, error) {
+	if length > s.dataLength {
+		return 0, errors.Errorf("blob of %v bytes exceeds the capacity of the cache", length)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		if offset, ok := s.alloc.allocate(length); ok {
+			return offset, nil
+		}
+
+		if len(s.entries) == 0 {
+			return 0, errors.Errorf("unable to allocate %v bytes", length)
+		}
+
+		s.evictLocked(ctx, length+s.dataLength/evictionFraction)
+	}
+}
+
+// evictLocked removes least recently used entries until the provided number of bytes has been freed.
+func (s *Storage) evictLocked(ctx context.Context, target int64) {
+	var freed int64
+
+	for freed < target && s.lru.Len() > 0 {
+		e := s.lru.Back().Value.(*entry)
+		s.removeEntryLocked(e.id)
+
+		freed += roundUp(e.length)
+	}
+
+	log(ctx).Debugf("evicted %v bytes from %v", freed, s.Path)
+}
+
+// SetTime implements blob.Storage.
+func (s *Storage) SetTime(ctx context.Context, id blob.ID, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el := s.entries[id]
+	if el == nil {
+		return blob.ErrBlobNotFound
+	}
+
+	s.setTimestampLocked(el, t)
+
+	return nil
+}
+
+// TouchBlob updates the timestamp of the provided blob if it's older than the threshold.
+func (s *Storage) TouchBlob(ctx context.Context, id blob.ID, threshold time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el := s.entries[id]
+	if el == nil {
+		return blob.ErrBlobNotFound
+	}
+
+	if now := clock.Now(); now.Sub(el.Value.(*entry).timestamp) > threshold {
+		s.setTimestampLocked(el, now)
+	}
+
+	return nil
+}
+
+// DeleteBlob implements blob.Storage.
+func (s *Storage) DeleteBlob(ctx context.Context, id blob.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeEntryLocked(id)
+
+	return nil
+}
+
+// ListBlobs implements blob.Storage.
+func (s *Storage) ListBlobs(ctx context.Context, prefix blob.ID, cb func(bm blob.Metadata) error) error {
+	var result []blob.Metadata
+
+	s.mu.RLock()
+	for id, el := range s.entries {
+		if strings.HasPrefix(string(id), string(prefix)) {
+			e := el.Value.(*entry)
+			result = append(result, blob.Metadata{BlobID: id, Length: e.length, Timestamp: e.timestamp})
+		}
+	}
+	s.mu.RUnlock()
+
+	// invoke callbacks without holding the lock, since they are allowed to modify the storage.
+	for _, bm := range result {
+		if err := cb(bm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ConnectionInfo implements blob.Storage.
+func (s *Storage) ConnectionInfo() blob.ConnectionInfo {
+	return blob.ConnectionInfo{
+		Type:   storageType,
+		Config: &s.Options,
+	}
+}
+
+// DisplayName implements blob.Storage.
+func (s *Storage) DisplayName() string {
+	return fmt.Sprintf("Block Cache: %v", s.Path)
+}
+
+// Close persists the allocation map and closes the underlying file.
+func (s *Storage) Close(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+
+	defer s.unlock()
+
+	if err := s.writeMapLocked(); err != nil {
+		log(ctx).Warningf("unable to persist allocation map of %v, cache will start empty: %v", s.Path, err)
+	}
+
+	return errors.Wrap(s.f.Close(), "error closing block cache")
+}
+
+func (s *Storage) writeMapLocked() error {
+	m, written := encodeMap(s.lru, s.dataOffset-blockSize)
+	if written < s.lru.Len() {
+		log(context.Background()).Debugf("allocation map of %v does not fit in the reserved space, persisted %v of %v entries", s.Path, written, s.lru.Len())
+	}
+
+	buf := alignedBuffer(roundUp(int64(len(m))))
+	copy(buf, m)
+
+	if _, err := s.f.WriteAt(buf, blockSize); err != nil {
+		return errors.Wrap(err, "unable to write allocation map")
+	}
+
+	h := header{
+		Version:    headerVersion,
+		DataOffset: s.dataOffset,
+		DataLength: s.dataLength,
+		MapLength:  int64(len(m)),
+		MapHash:    sha256.Sum256(m),
+	}
+
+	copy(h.Magic[:], headerMagic)
+
+	return writeHeader(s.f, &h)
+}
+
+func writeHeader(f *os.File, h *header) error {
+	var b bytes.Buffer
+
+	if err := binary.Write(&b, binary.LittleEndian, h); err != nil {
+		return errors.Wrap(err, "unable to serialize header")
+	}
+
+	buf := alignedBuffer(blockSize)
+	copy(buf, b.Bytes())
+
+	if _, err := f.WriteAt(buf, 0); err != nil {
+		return errors.Wrap(err, "unable to write header")
+	}
+
+	return errors.Wrap(f.Sync(), "unable to sync header")
+}
+
+// readMap returns entries of the allocation map persisted in the file, most recently used first,
+// or nil if it's missing or invalid.
+func readMap(f *os.File, dataOffset, dataLength int64) []*entry {
+	buf := alignedBuffer(blockSize)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		return nil
+	}
+
+	var h header
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &h); err != nil {
+		return nil
+	}
+
+	if string(h.Magic[:]) != headerMagic || h.Version != headerVersion || h.DataOffset != dataOffset || h.DataLength != dataLength {
+		return nil
+	}
+
+	if h.MapLength <= 0 || h.MapLength > dataOffset-blockSize {
+		return nil
+	}
+
+	mbuf := alignedBuffer(roundUp(h.MapLength))
+	if _, err := f.ReadAt(mbuf, blockSize); err != nil {
+		return nil
+	}
+
+	m := mbuf[0:h.MapLength]
+	if sha256.Sum256(m) != h.MapHash {
+		return nil
+	}
+
+	entries, err := decodeMap(m)
+	if err != nil {
+		return nil
+	}
+
+	return entries
+}
+
+// openFile opens the provided file or device for direct IO, falling back to buffered IO
+// if the filesystem does not support it.
+func openFile(ctx context.Context, path string, create bool) (*os.File, error) {
+	flags := os.O_RDWR
+	if create {
+		flags |= os.O_CREATE
+	}
+
+	f, err := os.OpenFile(path, flags|directIOFlag, 0o600) //nolint:gosec
+	if err == nil || directIOFlag == 0 || !errors.Is(err, syscall.EINVAL) {
+		return f, errors.Wrap(err, "unable to open block cache")
+	}
+
+	log(ctx).Debugf("direct IO not supported for %v, using buffered IO", path)
+
+	f, err = os.OpenFile(path, flags, 0o600) //nolint:gosec
+
+	return f, errors.Wrap(err, "unable to open block cache")
+}
+
+// Open opens or creates block cache storage in the provided file or block device. Regular files are preallocated
+// to hold the provided capacity in addition to the allocation map, block devices use their entire size.
+func Open(ctx context.Context, path string, capacity int64) (*Storage, error) {
+	fi, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "unable to stat block cache")
+	}
+
+	isDevice := err == nil && fi.Mode()&os.ModeDevice != 0
+
+	f, err := openFile(ctx, path, !isDevice)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := lockFile(f)
+	if err != nil {
+		f.Close() //nolint:errcheck,gosec
+		return nil, errors.Wrapf(err, "block cache %v is in use", path)
+	}
+
+	s, err := open(ctx, f, path, capacity, isDevice)
+	if err != nil {
+		unlock()
+		f.Close() //nolint:errcheck,gosec
+
+		return nil, err
+	}
+
+	s.unlock = unlock
+
+	return s, nil
+}
+
+func open(ctx context.Context, f *os.File, path string, capacity int64, isDevice bool) (*Storage, error) {
+	totalSize, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to determine size of block cache")
+	}
+
+	if !isDevice {
+		want := roundUp(capacity)
+		want += blockSize + mapRegionSize(want)
+
+		if totalSize < want {
+			if err := preallocate(f, want); err != nil {
+				return nil, errors.Wrap(err, "unable to preallocate block cache")
+			}
+
+			totalSize = want
+		}
+	}
+
+	dataOffset := blockSize + mapRegionSize(totalSize)
+	dataLength := (totalSize - dataOffset) / blockSize * blockSize
+
+	if dataLength <= 0 {
+		return nil, errors.Errorf("block cache %v is too small", path)
+	}
+
+	s := &Storage{
+		Options:    Options{Path: path, Capacity: dataLength},
+		f:          f,
+		dataOffset: dataOffset,
+		dataLength: dataLength,
+		entries:    map[blob.ID]*list.Element{},
+		lru:        list.New(),
+		alloc:      newAllocator(dataLength),
+	}
+
+	for _, e := range readMap(f, dataOffset, dataLength) {
+		if s.entries[e.id] != nil || !s.alloc.reserve(e.offset, roundUp(e.length)) {
+			log(ctx).Debugf("ignoring invalid block cache entry %v", e.id)
+			continue
+		}
+
+		s.entries[e.id] = s.lru.PushBack(e)
+	}
+
+	// invalidate persisted map, so that the cache starts empty if the process crashes before it's closed.
+	if err := writeHeader(f, &header{}); err != nil {
+		return nil, err
+	}
+
+	log(ctx).Debugf("opened block cache %v with %v entries, %v bytes free", path, len(s.entries), s.alloc.freeBytes())
+
+	return s, nil
+}
+
+// Reset clears the block cache in the provided file or device, which must not be in use.
+func Reset(ctx context.Context, path string) error {
+	f, err := openFile(ctx, path, false)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close() //nolint:errcheck,gosec
+
+	unlock, err := lockFile(f)
+	if err != nil {
+		return errors.Wrapf(err, "block cache %v is in use", path)
+	}
+
+	defer unlock()
+
+	return writeHeader(f, &header{})
+}
+
+var _ blob.Storage = (*Storage)(nil)
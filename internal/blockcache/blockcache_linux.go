@@ -0,0 +1,18 @@
+package blockcache
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const directIOFlag = unix.O_DIRECT
+
+func preallocate(f *os.File, size int64) error {
+	if err := unix.Fallocate(int(f.Fd()), 0, 0, size); err != nil {
+		// not all filesystems support fallocate()
+		return f.Truncate(size)
+	}
+
+	return nil
+}
@@ -0,0 +1,12 @@
+// +build !linux
+
+package blockcache
+
+import "os"
+
+// direct IO is only supported on Linux.
+const directIOFlag = 0
+
+func preallocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
@@ -0,0 +1,209 @@
+package blockcache
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+const testCapacity = 10 << 20
+
+func TestBlockCacheStorage(t *testing.T) {
+	ctx := testlogging.Context(t)
+	path := filepath.Join(t.TempDir(), "cache.bin")
+
+	st, err := Open(ctx, path, testCapacity)
+	if err != nil {
+		t.Fatalf("unable to open block cache: %v", err)
+	}
+
+	defer st.Close(ctx)
+
+	blobtesting.VerifyStorage(ctx, t, st)
+}
+
+func TestBlockCachePersistence(t *testing.T) {
+	ctx := testlogging.Context(t)
+	path := filepath.Join(t.TempDir(), "cache.bin")
+
+	st, err := Open(ctx, path, testCapacity)
+	if err != nil {
+		t.Fatalf("unable to open block cache: %v", err)
+	}
+
+	if _, err := Open(ctx, path, testCapacity); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Fatalf("unexpected error when opening block cache twice: %v", err)
+	}
+
+	data := bytes.Repeat([]byte{1, 2, 3}, 5000)
+
+	if err := st.PutBlob(ctx, "blob1", gather.FromSlice(data), blob.PutOptions{}); err != nil {
+		t.Fatalf("unable to put blob: %v", err)
+	}
+
+	if err := st.Close(ctx); err != nil {
+		t.Fatalf("unable to close block cache: %v", err)
+	}
+
+	st, err = Open(ctx, path, testCapacity)
+	if err != nil {
+		t.Fatalf("unable to reopen block cache: %v", err)
+	}
+
+	blobtesting.AssertGetBlob(ctx, t, st, "blob1", data)
+
+	// simulate a crash - the allocation map is not persisted.
+	st.closed = true
+	st.unlock()
+	st.f.Close()
+
+	st, err = Open(ctx, path, testCapacity)
+	if err != nil {
+		t.Fatalf("unable to reopen block cache: %v", err)
+	}
+
+	blobtesting.AssertGetBlobNotFound(ctx, t, st, "blob1")
+
+	if err := st.PutBlob(ctx, "blob2", gather.FromSlice(data), blob.PutOptions{}); err != nil {
+		t.Fatalf("unable to put blob: %v", err)
+	}
+
+	if err := st.Close(ctx); err != nil {
+		t.Fatalf("unable to close block cache: %v", err)
+	}
+
+	if err := Reset(ctx, path); err != nil {
+		t.Fatalf("unable to reset block cache: %v", err)
+	}
+
+	st, err = Open(ctx, path, testCapacity)
+	if err != nil {
+		t.Fatalf("unable to reopen block cache: %v", err)
+	}
+
+	defer st.Close(ctx)
+
+	blobtesting.AssertGetBlobNotFound(ctx, t, st, "blob2")
+}
+
+func TestBlockCacheEviction(t *testing.T) {
+	ctx := testlogging.Context(t)
+	path := filepath.Join(t.TempDir(), "cache.bin")
+
+	st, err := Open(ctx, path, 100*blockSize)
+	if err != nil {
+		t.Fatalf("unable to open block cache: %v", err)
+	}
+
+	defer st.Close(ctx)
+
+	data := bytes.Repeat([]byte{1}, 10*blockSize)
+
+	if err := st.PutBlob(ctx, "too-big", gather.FromSlice(bytes.Repeat(data, 20)), blob.PutOptions{}); err == nil {
+		t.Fatalf("unexpected success when writing blob exceeding capacity")
+	}
+
+	for _, id := range []blob.ID{"b1", "b2", "b3", "b4", "b5", "b6", "b7", "b8", "b9", "b10", "b11"} {
+		if err := st.PutBlob(ctx, id, gather.FromSlice(data), blob.PutOptions{}); err != nil {
+			t.Fatalf("unable to put blob: %v", err)
+		}
+	}
+
+	// the oldest blobs have been evicted to make room for the newest one.
+	blobtesting.AssertGetBlobNotFound(ctx, t, st, "b1")
+	blobtesting.AssertGetBlob(ctx, t, st, "b11", data)
+}
+
+func TestBlockCacheMapOverflow(t *testing.T) {
+	ctx := testlogging.Context(t)
+	path := filepath.Join(t.TempDir(), "cache.bin")
+
+	st, err := Open(ctx, path, testCapacity)
+	if err != nil {
+		t.Fatalf("unable to open block cache: %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := st.PutBlob(ctx, blob.ID(fmt.Sprintf("blob%v", i)), gather.FromSlice([]byte{byte(i)}), blob.PutOptions{}); err != nil {
+			t.Fatalf("unable to put blob: %v", err)
+		}
+	}
+
+	// blob0 becomes the most recently used.
+	if err := st.SetTime(ctx, "blob0", clock.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("unable to set time: %v", err)
+	}
+
+	// shrink the map region, so that only some entries fit.
+	m, _ := encodeMap(st.lru, st.dataOffset-blockSize)
+	st.dataOffset = blockSize + int64(len(m)/2)
+
+	mostRecent := st.lru.Front().Value.(*entry).id
+	if mostRecent != "blob0" {
+		t.Fatalf("unexpected most recently used entry: %v", mostRecent)
+	}
+
+	if err := st.writeMapLocked(); err != nil {
+		t.Fatalf("unable to write map: %v", err)
+	}
+
+	entries := readMap(st.f, st.dataOffset, st.dataLength)
+	if len(entries) == 0 || len(entries) >= 100 {
+		t.Fatalf("unexpected number of persisted entries: %v", len(entries))
+	}
+
+	// least recently used entries are left out.
+	if got := entries[0].id; got != "blob0" {
+		t.Errorf("unexpected first persisted entry: %v", got)
+	}
+
+	if got, want := entries[len(entries)-1].id, blob.ID(fmt.Sprintf("blob%v", 100-len(entries)+1)); got != want {
+		t.Errorf("unexpected last persisted entry: %v, want %v", got, want)
+	}
+
+	st.closed = true
+	st.unlock()
+	st.f.Close()
+}
+
+func TestBlockCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := testlogging.Context(t)
+	path := filepath.Join(t.TempDir(), "cache.bin")
+
+	st, err := Open(ctx, path, 100*blockSize)
+	if err != nil {
+		t.Fatalf("unable to open block cache: %v", err)
+	}
+
+	defer st.Close(ctx)
+
+	data := bytes.Repeat([]byte{1}, 10*blockSize)
+
+	for _, id := range []blob.ID{"b1", "b2", "b3", "b4", "b5", "b6", "b7", "b8", "b9", "b10"} {
+		if err := st.PutBlob(ctx, id, gather.FromSlice(data), blob.PutOptions{}); err != nil {
+			t.Fatalf("unable to put blob: %v", err)
+		}
+	}
+
+	if err := st.SetTime(ctx, "b1", clock.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("unable to set time: %v", err)
+	}
+
+	if err := st.PutBlob(ctx, "b11", gather.FromSlice(data), blob.PutOptions{}); err != nil {
+		t.Fatalf("unable to put blob: %v", err)
+	}
+
+	// recently touched blob is retained.
+	blobtesting.AssertGetBlob(ctx, t, st, "b1", data)
+	blobtesting.AssertGetBlobNotFound(ctx, t, st, "b2")
+	blobtesting.AssertGetBlob(ctx, t, st, "b11", data)
+}
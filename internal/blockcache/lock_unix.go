@@ -0,0 +1,22 @@
+// +build linux darwin
+
+package blockcache
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+func lockFile(f *os.File) (func(), error) {
+	fd := int(f.Fd())
+
+	if err := unix.Flock(fd, unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		return nil, errors.Wrap(err, "flock")
+	}
+
+	return func() {
+		unix.Flock(fd, unix.LOCK_UN) //nolint:errcheck
+	}, nil
+}
@@ -0,0 +1,25 @@
+package blockcache
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+)
+
+// lockedRegionOffset is the offset of a single byte locked to mark the file as in use, it's far beyond
+// the end of the file because Windows prevents other handles from accessing locked regions.
+const lockedRegionOffset = 1 << 62
+
+func lockFile(f *os.File) (func(), error) {
+	h := windows.Handle(f.Fd())
+	ol := &windows.Overlapped{Offset: lockedRegionOffset & 0xFFFFFFFF, OffsetHigh: lockedRegionOffset >> 32} //nolint:gomnd
+
+	if err := windows.LockFileEx(h, windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, ol); err != nil {
+		return nil, errors.Wrap(err, "LockFileEx")
+	}
+
+	return func() {
+		windows.UnlockFileEx(h, 0, 1, 0, ol) //nolint:errcheck
+	}, nil
+}
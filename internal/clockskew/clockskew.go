@@ -0,0 +1,76 @@
+// Package clockskew estimates differences between the local clock and clocks of servers and storage providers.
+//
+// Garbage collection decides whether contents and blobs are safe to delete by comparing their timestamps with
+// the local clock, so clients whose clocks differ significantly from others risk deleting data still in use.
+package clockskew
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/logging"
+)
+
+var log = logging.GetContextLoggerFunc("kopia/clockskew")
+
+// DefaultMaxSkew is the default maximum tolerated clock skew. It's well below the safety margins used by
+// garbage collection and index loading, which assume clocks of all clients are within one hour.
+const DefaultMaxSkew = 10 * time.Minute
+
+// BlobIDPrefix is the prefix of temporary blobs written to measure clock skew of the storage.
+const BlobIDPrefix blob.ID = "kopia.clockskew."
+
+const blobIDSuffixLength = 4
+
+// Estimate returns the difference between the remote clock and the local clock given the remote time
+// observed while the local clock moved from before to after. Positive skew means the local clock is behind.
+func Estimate(before, after, remote time.Time) time.Duration {
+	midpoint := before.Add(after.Sub(before) / 2) //nolint:gomnd
+
+	return remote.Sub(midpoint)
+}
+
+// Exceeds returns true if the absolute value of skew exceeds max.
+func Exceeds(skew, max time.Duration) bool {
+	if skew < 0 {
+		skew = -skew
+	}
+
+	return skew > max
+}
+
+// MeasureStorage estimates the clock skew between the local clock returned by now and the storage
+// by writing a temporary blob and comparing its timestamp with the local time. The blob is always removed.
+func MeasureStorage(ctx context.Context, st blob.Storage, now func() time.Time) (time.Duration, error) {
+	suffix := make([]byte, blobIDSuffixLength)
+	if _, err := rand.Read(suffix); err != nil {
+		return 0, errors.Wrap(err, "unable to generate blob ID")
+	}
+
+	id := BlobIDPrefix + blob.ID(fmt.Sprintf("%x", suffix))
+
+	before := now()
+
+	if err := st.PutBlob(ctx, id, gather.FromSlice([]byte(id)), blob.PutOptions{}); err != nil {
+		return 0, errors.Wrap(err, "unable to write clock skew blob")
+	}
+
+	defer func() {
+		if err := st.DeleteBlob(ctx, id); err != nil {
+			log(ctx).Debugf("unable to delete clock skew blob %v: %v", id, err)
+		}
+	}()
+
+	md, err := st.GetMetadata(ctx, id)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to get clock skew blob metadata")
+	}
+
+	return Estimate(before, now(), md.Timestamp), nil
+}
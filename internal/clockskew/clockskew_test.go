@@ -0,0 +1,55 @@
+package clockskew
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/faketime"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+func TestEstimate(t *testing.T) {
+	t0 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		before, after, remote time.Time
+		want                  time.Duration
+	}{
+		{t0, t0, t0, 0},
+		{t0, t0.Add(2 * time.Second), t0.Add(time.Second), 0},
+		{t0, t0.Add(2 * time.Second), t0.Add(time.Hour), time.Hour - time.Second},
+		{t0, t0, t0.Add(-time.Minute), -time.Minute},
+	}
+
+	for _, tc := range cases {
+		if got := Estimate(tc.before, tc.after, tc.remote); got != tc.want {
+			t.Errorf("invalid estimate for %v: %v, want %v", tc, got, tc.want)
+		}
+	}
+
+	if !Exceeds(-time.Hour, DefaultMaxSkew) || !Exceeds(time.Hour, DefaultMaxSkew) || Exceeds(time.Minute, DefaultMaxSkew) {
+		t.Errorf("unexpected result of Exceeds()")
+	}
+}
+
+func TestMeasureStorage(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	storageTime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := blobtesting.DataMap{}
+	st := blobtesting.NewMapStorage(data, nil, faketime.Frozen(storageTime))
+
+	skew, err := MeasureStorage(ctx, st, faketime.Frozen(storageTime.Add(-time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if skew != time.Hour {
+		t.Errorf("unexpected skew: %v", skew)
+	}
+
+	if len(data) != 0 {
+		t.Errorf("clock skew blob was not removed: %v", data)
+	}
+}
@@ -0,0 +1,164 @@
+// Package controlsocket implements a simple line-based control protocol over a local socket,
+// which allows adjusting settings of long-running operations while they are in progress.
+//
+// Each request is a single line consisting of a command followed by space-separated arguments.
+// Each response is a single line starting with "OK" or "ERR" followed by a message.
+package controlsocket
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	responseOK    = "OK"
+	responseError = "ERR"
+)
+
+// Handler handles a single command with the provided arguments and returns the response message.
+type Handler func(args []string) (string, error)
+
+// Server accepts control commands on a local socket.
+type Server struct {
+	path     string
+	listener net.Listener
+	handlers map[string]Handler
+	wg       sync.WaitGroup
+}
+
+// Listen starts accepting control commands on the socket with the provided path.
+func Listen(path string, handlers map[string]Handler) (*Server, error) {
+	// remove stale socket left behind by a previous process.
+	if st, err := os.Stat(path); err == nil && st.Mode()&os.ModeSocket != 0 {
+		os.Remove(path) //nolint:errcheck
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to listen on control socket")
+	}
+
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close() //nolint:errcheck
+		return nil, errors.Wrap(err, "unable to set permissions of control socket")
+	}
+
+	s := &Server{
+		path:     path,
+		listener: l,
+		handlers: handlers,
+	}
+
+	s.wg.Add(1)
+
+	go s.acceptLoop()
+
+	return s, nil
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close() //nolint:errcheck
+
+			s.handleConnection(conn)
+		}()
+	}
+}
+
+func (s *Server) handleConnection(conn net.Conn) {
+	sc := bufio.NewScanner(conn)
+
+	for sc.Scan() {
+		if _, err := conn.Write([]byte(s.dispatch(sc.Text()) + "\n")); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) dispatch(line string) string {
+	parts := strings.Fields(line)
+	if len(parts) == 0 {
+		return responseError + " empty command"
+	}
+
+	h := s.handlers[parts[0]]
+	if h == nil {
+		return responseError + " unknown command: " + parts[0]
+	}
+
+	msg, err := h(parts[1:])
+	if err != nil {
+		return responseError + " " + err.Error()
+	}
+
+	return strings.TrimSpace(responseOK + " " + msg)
+}
+
+// Close stops accepting commands and removes the socket.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+
+	os.Remove(s.path) //nolint:errcheck
+
+	return errors.Wrap(err, "unable to close control socket")
+}
+
+// Send sends a single command to the control socket with the provided path and returns the response message.
+func Send(ctx context.Context, path, command string, args ...string) (string, error) {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to connect to control socket")
+	}
+	defer conn.Close() //nolint:errcheck
+
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl) //nolint:errcheck
+	}
+
+	req := strings.Join(append([]string{command}, args...), " ")
+
+	if _, err := conn.Write([]byte(req + "\n")); err != nil {
+		return "", errors.Wrap(err, "unable to send command")
+	}
+
+	resp, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", errors.Wrap(err, "unable to read response")
+	}
+
+	resp = strings.TrimSpace(resp)
+
+	switch {
+	case resp == responseOK:
+		return "", nil
+
+	case strings.HasPrefix(resp, responseOK+" "):
+		return strings.TrimPrefix(resp, responseOK+" "), nil
+
+	case strings.HasPrefix(resp, responseError+" "):
+		return "", errors.New(strings.TrimPrefix(resp, responseError+" "))
+
+	default:
+		return "", errors.Errorf("invalid response: %q", resp)
+	}
+}
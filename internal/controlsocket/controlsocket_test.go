@@ -0,0 +1,66 @@
+package controlsocket_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/controlsocket"
+)
+
+func TestControlSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are not supported on all Windows versions")
+	}
+
+	// unix socket paths are limited in length, avoid long test temporary directories.
+	dir, err := ioutil.TempDir("", "ctl")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(dir)
+
+	sockPath := filepath.Join(dir, "s")
+
+	var got []string
+
+	s, err := controlsocket.Listen(sockPath, map[string]controlsocket.Handler{
+		"echo": func(args []string) (string, error) {
+			got = append(got, args...)
+			return strings.Join(args, ","), nil
+		},
+		"fail": func(args []string) (string, error) {
+			return "", errors.New("something failed")
+		},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := controlsocket.Send(ctx, sockPath, "echo", "a", "b")
+	require.NoError(t, err)
+	require.Equal(t, "a,b", resp)
+	require.Equal(t, []string{"a", "b"}, got)
+
+	_, err = controlsocket.Send(ctx, sockPath, "fail")
+	require.EqualError(t, err, "something failed")
+
+	_, err = controlsocket.Send(ctx, sockPath, "no-such-command")
+	require.Error(t, err)
+
+	require.NoError(t, s.Close())
+
+	_, err = os.Stat(sockPath)
+	require.True(t, os.IsNotExist(err))
+
+	_, err = controlsocket.Send(ctx, sockPath, "echo")
+	require.Error(t, err)
+}
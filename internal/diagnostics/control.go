@@ -0,0 +1,70 @@
+package diagnostics
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/controlsocket"
+)
+
+// Control socket commands handled by the controller.
+const (
+	// ControlCmdPprof enables pprof endpoints on the provided address or disables them if the address is "off".
+	ControlCmdPprof = "pprof"
+
+	// ControlCmdDumpInterval changes the interval of periodic profile dumps, "0" disables them.
+	ControlCmdDumpInterval = "dump-interval"
+
+	// ControlCmdDump dumps profiles immediately.
+	ControlCmdDump = "dump"
+
+	// PprofOff is the argument of ControlCmdPprof which disables pprof endpoints.
+	PprofOff = "off"
+)
+
+// ControlHandlers returns control socket handlers that allow toggling diagnostics at runtime.
+// Each command reports the resulting setting, commands without arguments only report current setting.
+func (c *Controller) ControlHandlers(ctx context.Context) map[string]controlsocket.Handler {
+	return map[string]controlsocket.Handler{
+		ControlCmdPprof: func(args []string) (string, error) {
+			if len(args) > 0 {
+				if args[0] == PprofOff {
+					c.DisablePprof()
+				} else if _, err := c.EnablePprof(ctx, args[0]); err != nil {
+					return "", err
+				}
+			}
+
+			if a := c.PprofAddress(); a != "" {
+				return a, nil
+			}
+
+			return PprofOff, nil
+		},
+
+		ControlCmdDumpInterval: func(args []string) (string, error) {
+			if len(args) > 0 {
+				d, err := time.ParseDuration(args[0])
+				if err != nil || d < 0 {
+					return "", errors.Errorf("invalid dump interval: %q", args[0])
+				}
+
+				c.SetDumpInterval(ctx, d)
+			}
+
+			return c.DumpInterval().String(), nil
+		},
+
+		ControlCmdDump: func(args []string) (string, error) {
+			files, err := c.Dump(ctx)
+			if err != nil {
+				return "", err
+			}
+
+			return strings.Join(files, " "), nil
+		},
+	}
+}
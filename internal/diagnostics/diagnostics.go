@@ -0,0 +1,276 @@
+// Package diagnostics provides runtime diagnostics of long-running operations: net/http/pprof endpoints
+// and periodic dumps of heap and goroutine profiles, both of which can be toggled while the process is running.
+package diagnostics
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/repo/logging"
+)
+
+var log = logging.GetContextLoggerFunc("diagnostics")
+
+const (
+	heapDumpPrefix      = "heap-"
+	goroutineDumpPrefix = "goroutine-"
+
+	// DefaultMaxDumps is the default number of most recent dumps of each kind that are retained.
+	DefaultMaxDumps = 20
+
+	dumpTimeFormat = "20060102-150405.000"
+	dumpFileMode   = 0o600
+	dumpDirMode    = 0o700
+)
+
+// Controller manages pprof endpoints and periodic profile dumps.
+type Controller struct {
+	// DumpDir is the directory where profile dumps are written.
+	DumpDir string
+
+	// MaxDumps is the maximum number of dumps of each kind to retain in DumpDir.
+	MaxDumps int
+
+	// changeMu serializes changes of pprof endpoints and periodic dumps, mu protects fields read concurrently.
+	changeMu sync.Mutex
+	mu       sync.Mutex
+
+	server   *http.Server
+	addr     string
+	interval time.Duration
+	stopDump chan struct{}
+	dumpWG   sync.WaitGroup
+}
+
+// NewController creates a controller which writes profile dumps to the provided directory.
+func NewController(dumpDir string) *Controller {
+	return &Controller{
+		DumpDir:  dumpDir,
+		MaxDumps: DefaultMaxDumps,
+	}
+}
+
+// PprofAddress returns the address where pprof endpoints are served or an empty string if disabled.
+func (c *Controller) PprofAddress() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.addr
+}
+
+// EnablePprof starts serving pprof endpoints on the provided address, replacing previous listener if any.
+func (c *Controller) EnablePprof(ctx context.Context, addr string) (string, error) {
+	c.changeMu.Lock()
+	defer c.changeMu.Unlock()
+
+	c.disablePprofLocked()
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to listen")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	srv := &http.Server{Handler: mux}
+
+	c.mu.Lock()
+	c.server = srv
+	c.addr = l.Addr().String()
+	c.mu.Unlock()
+
+	go func() {
+		if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log(ctx).Warningf("pprof server failed: %v", err)
+		}
+	}()
+
+	log(ctx).Infof("Serving pprof endpoints on http://%v/debug/pprof/", l.Addr())
+
+	return l.Addr().String(), nil
+}
+
+// DisablePprof stops serving pprof endpoints.
+func (c *Controller) DisablePprof() {
+	c.changeMu.Lock()
+	defer c.changeMu.Unlock()
+
+	c.disablePprofLocked()
+}
+
+func (c *Controller) disablePprofLocked() {
+	c.mu.Lock()
+	srv := c.server
+	c.server = nil
+	c.addr = ""
+	c.mu.Unlock()
+
+	if srv != nil {
+		srv.Close() //nolint:errcheck
+	}
+}
+
+// DumpInterval returns the interval between periodic profile dumps or zero if disabled.
+func (c *Controller) DumpInterval() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.interval
+}
+
+// SetDumpInterval changes the interval between periodic profile dumps, zero disables them.
+func (c *Controller) SetDumpInterval(ctx context.Context, interval time.Duration) {
+	c.changeMu.Lock()
+	defer c.changeMu.Unlock()
+
+	c.mu.Lock()
+	stop := c.stopDump
+	c.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		c.dumpWG.Wait()
+
+		stop = nil
+	}
+
+	if interval > 0 {
+		stop = make(chan struct{})
+
+		c.dumpWG.Add(1)
+
+		go c.dumpPeriodically(ctx, interval, stop)
+	} else {
+		interval = 0
+	}
+
+	c.mu.Lock()
+	c.stopDump = stop
+	c.interval = interval
+	c.mu.Unlock()
+}
+
+func (c *Controller) dumpPeriodically(ctx context.Context, interval time.Duration, stop <-chan struct{}) {
+	defer c.dumpWG.Done()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+
+		case <-t.C:
+			if _, err := c.Dump(ctx); err != nil {
+				log(ctx).Warningf("unable to dump profiles: %v", err)
+			}
+		}
+	}
+}
+
+// Dump writes heap and goroutine profiles to the dump directory and returns the names of files written.
+func (c *Controller) Dump(ctx context.Context) ([]string, error) {
+	if err := os.MkdirAll(c.DumpDir, dumpDirMode); err != nil {
+		return nil, errors.Wrap(err, "unable to create dump directory")
+	}
+
+	suffix := clock.Now().Format(dumpTimeFormat)
+
+	// collect garbage so that the heap profile reflects live objects.
+	runtime.GC()
+
+	heapFile := filepath.Join(c.DumpDir, heapDumpPrefix+suffix+".pprof")
+	if err := writeProfile(heapFile, "heap", 0); err != nil {
+		return nil, err
+	}
+
+	goroutineFile := filepath.Join(c.DumpDir, goroutineDumpPrefix+suffix+".txt")
+	if err := writeProfile(goroutineFile, "goroutine", 2); err != nil { //nolint:gomnd
+		return nil, err
+	}
+
+	var ms runtime.MemStats
+
+	runtime.ReadMemStats(&ms)
+	log(ctx).Debugf("dumped profiles to %v (heap in use %v, goroutines %v)", c.DumpDir, ms.HeapInuse, runtime.NumGoroutine())
+
+	for _, prefix := range []string{heapDumpPrefix, goroutineDumpPrefix} {
+		if err := removeOldDumps(c.DumpDir, prefix, c.MaxDumps); err != nil {
+			log(ctx).Debugf("unable to remove old dumps: %v", err)
+		}
+	}
+
+	return []string{heapFile, goroutineFile}, nil
+}
+
+// Close stops pprof endpoints and periodic dumps.
+func (c *Controller) Close(ctx context.Context) {
+	c.DisablePprof()
+	c.SetDumpInterval(ctx, 0)
+}
+
+func writeProfile(fname, name string, debug int) error {
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, dumpFileMode) //nolint:gosec
+	if err != nil {
+		return errors.Wrap(err, "unable to create profile file")
+	}
+
+	if err := rpprof.Lookup(name).WriteTo(f, debug); err != nil {
+		f.Close() //nolint:errcheck
+		return errors.Wrapf(err, "unable to write %v profile", name)
+	}
+
+	return errors.Wrap(f.Close(), "unable to close profile file")
+}
+
+func removeOldDumps(dir, prefix string, maxDumps int) error {
+	if maxDumps <= 0 {
+		return nil
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "unable to read dump directory")
+	}
+
+	var names []string
+
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), prefix) {
+			names = append(names, e.Name())
+		}
+	}
+
+	// names embed timestamps, so lexicographical order is chronological.
+	sort.Strings(names)
+
+	for len(names) > maxDumps {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return errors.Wrap(err, "unable to remove dump")
+		}
+
+		names = names[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package diagnostics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/controlsocket"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+func TestPprof(t *testing.T) {
+	ctx := testlogging.Context(t)
+	c := NewController(t.TempDir())
+
+	defer c.Close(ctx)
+
+	addr, err := c.EnablePprof(ctx, "127.0.0.1:0")
+	require.NoError(t, err)
+	require.Equal(t, addr, c.PprofAddress())
+
+	resp, err := http.Get("http://" + addr + "/debug/pprof/goroutine?debug=1")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	c.DisablePprof()
+	require.Empty(t, c.PprofAddress())
+
+	_, err = http.Get("http://" + addr + "/debug/pprof/")
+	require.Error(t, err)
+}
+
+func TestDump(t *testing.T) {
+	ctx := testlogging.Context(t)
+	dir := t.TempDir()
+
+	c := NewController(dir)
+	c.MaxDumps = 2
+
+	for i := 0; i < 4; i++ {
+		files, err := c.Dump(ctx)
+		require.NoError(t, err)
+		require.Len(t, files, 2)
+
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	heaps, err := filepath.Glob(filepath.Join(dir, heapDumpPrefix+"*"))
+	require.NoError(t, err)
+	require.Len(t, heaps, 2)
+
+	goroutines, err := filepath.Glob(filepath.Join(dir, goroutineDumpPrefix+"*"))
+	require.NoError(t, err)
+	require.Len(t, goroutines, 2)
+
+	data, err := ioutil.ReadFile(goroutines[1])
+	require.NoError(t, err)
+	require.Contains(t, string(data), "goroutine")
+}
+
+func TestConcurrentChanges(t *testing.T) {
+	ctx := testlogging.Context(t)
+	c := NewController(t.TempDir())
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			c.SetDumpInterval(ctx, time.Duration(i%3)*time.Hour)
+			c.DumpInterval()
+
+			if _, err := c.EnablePprof(ctx, "127.0.0.1:0"); err != nil {
+				t.Errorf("unable to enable pprof: %v", err)
+			}
+
+			c.PprofAddress()
+		}(i)
+	}
+
+	wg.Wait()
+
+	c.mu.Lock()
+	require.Equal(t, c.interval != 0, c.stopDump != nil, "dump interval does not match dump loop")
+	c.mu.Unlock()
+
+	c.Close(ctx)
+	require.Zero(t, c.DumpInterval())
+	require.Empty(t, c.PprofAddress())
+}
+
+func TestControlHandlers(t *testing.T) {
+	ctx := testlogging.Context(t)
+	dir := t.TempDir()
+
+	c := NewController(dir)
+	defer c.Close(ctx)
+
+	sock := filepath.Join(dir, "control")
+
+	s, err := controlsocket.Listen(sock, c.ControlHandlers(ctx))
+	require.NoError(t, err)
+
+	defer s.Close()
+
+	resp, err := controlsocket.Send(ctx, sock, ControlCmdPprof)
+	require.NoError(t, err)
+	require.Equal(t, PprofOff, resp)
+
+	resp, err = controlsocket.Send(ctx, sock, ControlCmdPprof, "127.0.0.1:0")
+	require.NoError(t, err)
+	require.Equal(t, c.PprofAddress(), resp)
+
+	resp, err = controlsocket.Send(ctx, sock, ControlCmdPprof, PprofOff)
+	require.NoError(t, err)
+	require.Equal(t, PprofOff, resp)
+
+	resp, err = controlsocket.Send(ctx, sock, ControlCmdDumpInterval, "10ms")
+	require.NoError(t, err)
+	require.Equal(t, "10ms", resp)
+
+	require.Eventually(t, func() bool {
+		heaps, _ := filepath.Glob(filepath.Join(dir, heapDumpPrefix+"*"))
+		return len(heaps) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	resp, err = controlsocket.Send(ctx, sock, ControlCmdDumpInterval, "0")
+	require.NoError(t, err)
+	require.Equal(t, "0s", resp)
+
+	_, err = controlsocket.Send(ctx, sock, ControlCmdDumpInterval, "bogus")
+	require.Error(t, err)
+
+	resp, err = controlsocket.Send(ctx, sock, ControlCmdDump)
+	require.NoError(t, err)
+	require.Contains(t, resp, heapDumpPrefix)
+}
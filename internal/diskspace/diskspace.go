@@ -0,0 +1,31 @@
+// Package diskspace determines the amount of free space on the filesystem of a directory.
+package diskspace
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Available returns the number of bytes available to the current user on the filesystem the provided
+// path is located on. If the path does not exist yet, its nearest existing parent directory is examined.
+func Available(path string) (int64, error) {
+	p, err := filepath.Abs(path)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to determine absolute path")
+	}
+
+	for {
+		if _, err := os.Stat(p); err == nil {
+			return available(p)
+		}
+
+		parent := filepath.Dir(p)
+		if parent == p {
+			return 0, errors.Errorf("no existing parent directory of %v", path)
+		}
+
+		p = parent
+	}
+}
@@ -0,0 +1,9 @@
+// +build !linux,!darwin,!windows
+
+package diskspace
+
+import "github.com/pkg/errors"
+
+func available(path string) (int64, error) {
+	return 0, errors.Errorf("determining free disk space is not supported on this platform")
+}
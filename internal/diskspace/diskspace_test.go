@@ -0,0 +1,24 @@
+package diskspace
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestAvailable(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" && runtime.GOOS != "windows" {
+		t.Skip("not supported on this platform")
+	}
+
+	dir := t.TempDir()
+
+	n, err := Available(filepath.Join(dir, "no-such-dir", "subdir"))
+	if err != nil {
+		t.Fatalf("unable to determine available space: %v", err)
+	}
+
+	if n <= 0 {
+		t.Errorf("unexpected available space: %v", n)
+	}
+}
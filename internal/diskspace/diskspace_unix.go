@@ -0,0 +1,18 @@
+// +build linux darwin
+
+package diskspace
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+func available(path string) (int64, error) {
+	var st unix.Statfs_t
+
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, errors.Wrap(err, "statfs")
+	}
+
+	return int64(st.Bavail) * int64(st.Bsize), nil //nolint:unconvert
+}
@@ -0,0 +1,21 @@
+package diskspace
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+)
+
+func available(path string) (int64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid path")
+	}
+
+	var freeBytesAvailable, totalBytes, totalFreeBytes uint64
+
+	if err := windows.GetDiskFreeSpaceEx(p, &freeBytesAvailable, &totalBytes, &totalFreeBytes); err != nil {
+		return 0, errors.Wrap(err, "unable to get free disk space")
+	}
+
+	return int64(freeBytesAvailable), nil
+}
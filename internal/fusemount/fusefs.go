@@ -10,6 +10,7 @@
 	"io/ioutil"
 	"os"
 	"sync"
+	"time"
 
 	"bazil.org/fuse"
 	fusefs "bazil.org/fuse/fs"
@@ -17,10 +18,31 @@
 	"golang.org/x/net/context"
 
 	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/clock"
 )
 
+// Options specifies caching and read-ahead behavior of FUSE nodes.
+type Options struct {
+	// AttrTTL is the duration for which the kernel may cache attributes of entries, zero uses the default.
+	AttrTTL time.Duration
+
+	// EntryTTL is the duration for which the kernel may cache results of name lookups, zero uses the default.
+	// Directory listings are also cached in memory for the same amount of time.
+	EntryTTL time.Duration
+
+	// ReadAheadSize is the number of bytes read in the background following sequential reads of a file.
+	// Zero disables read-ahead.
+	ReadAheadSize int
+
+	// MaxCachedEntries limits the total number of directory entries cached in memory, zero uses the default.
+	MaxCachedEntries int
+
+	listings *listingCache
+}
+
 type fuseNode struct {
 	entry fs.Entry
+	opts  *Options
 }
 
 func (n *fuseNode) Attr(ctx context.Context, a *fuse.Attr) error {
@@ -31,6 +53,10 @@ func (n *fuseNode) Attr(ctx context.Context, a *fuse.Attr) error {
 	a.Uid = m.Owner().UserID
 	a.Gid = m.Owner().GroupID
 
+	if n.opts.AttrTTL > 0 {
+		a.Valid = n.opts.AttrTTL
+	}
+
 	return nil
 }
 
@@ -46,35 +72,147 @@ func (f *fuseFileNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fu
 		return nil, err
 	}
 
-	return &fuseFileHandle{reader: reader, size: f.entry.Size()}, nil
+	return &fuseFileHandle{
+		file:          f.entry.(fs.File),
+		reader:        reader,
+		size:          f.entry.Size(),
+		readAheadSize: f.opts.ReadAheadSize,
+	}, nil
 }
 
 type fuseFileHandle struct {
 	mu     sync.Mutex
+	file   fs.File
 	reader fs.Reader
 	size   int64
+
+	readAheadSize int
+	nextOffset    int64      // offset immediately following the last read, used to detect sequential reads
+	ahead         *readAhead // most recent read-ahead, if any
+	aheadReader   fs.Reader  // reader used exclusively by read-ahead
+}
+
+// readAhead holds data read in the background, data and err may only be accessed after done is closed.
+type readAhead struct {
+	offset int64
+	data   []byte
+	err    error
+	done   chan struct{}
+}
+
+func (ra *readAhead) end() int64 {
+	return ra.offset + int64(len(ra.data))
 }
 
 func (f *fuseFileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	_, err := f.reader.Seek(req.Offset, io.SeekStart)
-	if err != nil {
-		return err
-	}
-
-	n, err := f.reader.Read(resp.Data[:req.Size])
+	n, err := f.readAt(resp.Data[:req.Size], req.Offset)
 	if err != nil {
 		return err
 	}
 
 	resp.Data = resp.Data[:n]
 
+	sequential := req.Offset == f.nextOffset
+	f.nextOffset = req.Offset + int64(n)
+
+	if sequential {
+		f.maybeStartReadAhead()
+	}
+
 	return nil
 }
 
+func (f *fuseFileHandle) readAt(b []byte, offset int64) (int, error) {
+	n := 0
+
+	if ra := f.ahead; ra != nil {
+		<-ra.done
+
+		if ra.err == nil && offset >= ra.offset && offset < ra.end() {
+			n = copy(b, ra.data[offset-ra.offset:])
+		}
+	}
+
+	if n == len(b) || offset+int64(n) >= f.size {
+		return n, nil
+	}
+
+	if _, err := f.reader.Seek(offset+int64(n), io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	n2, err := io.ReadFull(f.reader, b[n:])
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+
+	return n + n2, nil
+}
+
+// maybeStartReadAhead starts reading data following the most recent read in the background,
+// unless enough of it has already been read ahead.
+func (f *fuseFileHandle) maybeStartReadAhead() {
+	if f.readAheadSize <= 0 || f.nextOffset >= f.size {
+		return
+	}
+
+	if ra := f.ahead; ra != nil {
+		<-ra.done
+
+		if ra.err == nil && f.nextOffset >= ra.offset && ra.end()-f.nextOffset >= int64(f.readAheadSize/2) { //nolint:gomnd
+			return
+		}
+	}
+
+	if f.aheadReader == nil {
+		// read-ahead outlives the request, so it can't use request context.
+		r, err := f.file.Open(context.Background())
+		if err != nil {
+			return
+		}
+
+		f.aheadReader = r
+	}
+
+	ra := &readAhead{
+		offset: f.nextOffset,
+		data:   make([]byte, f.readAheadSize),
+		done:   make(chan struct{}),
+	}
+
+	f.ahead = ra
+
+	go func(r fs.Reader) {
+		defer close(ra.done)
+
+		if _, ra.err = r.Seek(ra.offset, io.SeekStart); ra.err != nil {
+			return
+		}
+
+		n, err := io.ReadFull(r, ra.data)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			ra.err = err
+		}
+
+		ra.data = ra.data[:n]
+	}(f.aheadReader)
+}
+
 func (f *fuseFileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.ahead != nil {
+		<-f.ahead.done
+	}
+
+	if f.aheadReader != nil {
+		f.aheadReader.Close() //nolint:errcheck
+	}
+
 	return f.reader.Close()
 }
 
@@ -95,14 +233,43 @@ func (f *fuseFileNode) ReadAll(ctx context.Context) ([]byte, error) {
 
 type fuseDirectoryNode struct {
 	fuseNode
+
+	mu sync.Mutex // serializes reading of the directory
 }
 
 func (dir *fuseDirectoryNode) directory() fs.Directory {
 	return dir.entry.(fs.Directory)
 }
 
-func (dir *fuseDirectoryNode) Lookup(ctx context.Context, fileName string) (fusefs.Node, error) {
+// readdir returns directory entries, which are cached for the duration of entry TTL to avoid
+// re-reading directory objects on each lookup.
+func (dir *fuseDirectoryNode) readdir(ctx context.Context) (fs.Entries, map[string]fs.Entry, error) {
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
+
+	if l := dir.opts.listings.get(dir); l != nil {
+		return l.entries, l.byName, nil
+	}
+
 	entries, err := dir.directory().Readdir(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byName := make(map[string]fs.Entry, len(entries))
+	for _, e := range entries {
+		byName[e.Name()] = e
+	}
+
+	if dir.opts.EntryTTL > 0 {
+		dir.opts.listings.add(dir, entries, byName, clock.Now().Add(dir.opts.EntryTTL))
+	}
+
+	return entries, byName, nil
+}
+
+func (dir *fuseDirectoryNode) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fusefs.Node, error) {
+	_, byName, err := dir.readdir(ctx)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, fuse.ENOENT
@@ -111,16 +278,22 @@ func (dir *fuseDirectoryNode) Lookup(ctx context.Context, fileName string) (fuse
 		return nil, err
 	}
 
-	e := entries.FindByName(fileName)
+	e := byName[req.Name]
 	if e == nil {
 		return nil, fuse.ENOENT
 	}
 
-	return newFuseNode(e)
+	if dir.opts.EntryTTL > 0 {
+		resp.EntryValid = dir.opts.EntryTTL
+	}
+
+	return newFuseNode(e, dir.opts)
 }
 
+var _ fusefs.NodeRequestLookuper = (*fuseDirectoryNode)(nil)
+
 func (dir *fuseDirectoryNode) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
-	entries, err := dir.directory().Readdir(ctx)
+	entries, _, err := dir.readdir(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -156,24 +329,31 @@ func (sl *fuseSymlinkNode) Readlink(ctx context.Context, req *fuse.ReadlinkReque
 	return sl.entry.(fs.Symlink).Readlink(ctx)
 }
 
-func newFuseNode(e fs.Entry) (fusefs.Node, error) {
+func newFuseNode(e fs.Entry, opts *Options) (fusefs.Node, error) {
 	switch e := e.(type) {
 	case fs.Directory:
-		return newDirectoryNode(e), nil
+		return newDirectoryNode(e, opts), nil
 	case fs.File:
-		return &fuseFileNode{fuseNode{e}}, nil
+		return &fuseFileNode{fuseNode{e, opts}}, nil
 	case fs.Symlink:
-		return &fuseSymlinkNode{fuseNode{e}}, nil
+		return &fuseSymlinkNode{fuseNode{e, opts}}, nil
 	default:
 		return nil, errors.Errorf("entry type not supported: %v", e.Mode())
 	}
 }
 
-func newDirectoryNode(dir fs.Directory) fusefs.Node {
-	return &fuseDirectoryNode{fuseNode{dir}}
+func newDirectoryNode(dir fs.Directory, opts *Options) fusefs.Node {
+	return &fuseDirectoryNode{fuseNode: fuseNode{dir, opts}}
 }
 
 // NewDirectoryNode returns FUSE Node for a given fs.Directory.
-func NewDirectoryNode(dir fs.Directory) fusefs.Node {
-	return newDirectoryNode(dir)
+func NewDirectoryNode(dir fs.Directory, opts Options) fusefs.Node {
+	maxEntries := opts.MaxCachedEntries
+	if maxEntries == 0 {
+		maxEntries = defaultMaxCachedEntries
+	}
+
+	opts.listings = newListingCache(maxEntries)
+
+	return newDirectoryNode(dir, &opts)
 }
@@ -0,0 +1,192 @@
+// +build !windows
+
+package fusemount
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+
+	"github.com/kopia/kopia/internal/mockfs"
+)
+
+func TestFileReadAhead(t *testing.T) {
+	ctx := context.Background()
+
+	data := make([]byte, 1000000)
+	rand.Read(data)
+
+	dir := mockfs.NewDirectory()
+	f := dir.AddFile("f1", data, 0644)
+
+	for _, readAheadSize := range []int{0, 1000, 65536, 2000000} {
+		node := &fuseFileNode{fuseNode{f, &Options{ReadAheadSize: readAheadSize}}}
+
+		h, err := node.Open(ctx, &fuse.OpenRequest{}, &fuse.OpenResponse{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fh := h.(*fuseFileHandle)
+
+		var got []byte
+
+		// sequential reads with occasional random reads in between.
+		for off := int64(0); off < int64(len(data)); {
+			if off%3 == 0 {
+				randomOffset := rand.Int63n(int64(len(data)))
+				if b := mustRead(ctx, t, fh, randomOffset, 4096); !bytes.Equal(b, data[randomOffset:min(randomOffset+4096, int64(len(data)))]) {
+					t.Fatalf("invalid data at random offset %v", randomOffset)
+				}
+			}
+
+			b := mustRead(ctx, t, fh, off, 16384)
+			got = append(got, b...)
+			off += int64(len(b))
+		}
+
+		if !bytes.Equal(got, data) {
+			t.Fatalf("invalid data read with read-ahead %v", readAheadSize)
+		}
+
+		if err := fh.Release(ctx, &fuse.ReleaseRequest{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDirectoryListingCache(t *testing.T) {
+	ctx := context.Background()
+
+	dir := mockfs.NewDirectory()
+	dir.AddFile("f1", []byte{1, 2, 3}, 0644)
+	dir.AddDir("d1", 0755)
+
+	readdirCount := 0
+
+	dir.OnReaddir(func() {
+		readdirCount++
+	})
+
+	node := NewDirectoryNode(dir, Options{EntryTTL: time.Hour}).(*fuseDirectoryNode)
+
+	for i := 0; i < 3; i++ {
+		resp := &fuse.LookupResponse{}
+
+		if _, err := node.Lookup(ctx, &fuse.LookupRequest{Name: "f1"}, resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.EntryValid != time.Hour {
+			t.Errorf("invalid entry TTL: %v", resp.EntryValid)
+		}
+
+		if _, err := node.Lookup(ctx, &fuse.LookupRequest{Name: "no-such-file"}, &fuse.LookupResponse{}); err != fuse.ENOENT {
+			t.Errorf("unexpected error %v", err)
+		}
+
+		dirents, err := node.ReadDirAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(dirents) != 2 {
+			t.Errorf("unexpected entries %v", dirents)
+		}
+	}
+
+	if readdirCount != 1 {
+		t.Errorf("directory was read %v times, expected once", readdirCount)
+	}
+
+	// without TTL each operation reads the directory.
+	readdirCount = 0
+	node = NewDirectoryNode(dir, Options{}).(*fuseDirectoryNode)
+
+	node.Lookup(ctx, &fuse.LookupRequest{Name: "f1"}, &fuse.LookupResponse{}) //nolint:errcheck
+	node.Lookup(ctx, &fuse.LookupRequest{Name: "f1"}, &fuse.LookupResponse{}) //nolint:errcheck
+
+	if readdirCount != 2 {
+		t.Errorf("directory was read %v times, expected twice", readdirCount)
+	}
+}
+
+func TestDirectoryListingCacheEviction(t *testing.T) {
+	ctx := context.Background()
+
+	root := mockfs.NewDirectory()
+	rootNode := NewDirectoryNode(root, Options{EntryTTL: time.Hour, MaxCachedEntries: 5}).(*fuseDirectoryNode)
+	listings := rootNode.opts.listings
+
+	var nodes []*fuseDirectoryNode
+
+	for _, name := range []string{"d1", "d2", "d3"} {
+		d := root.AddDir(name, 0755)
+		d.AddFile("f1", []byte{1}, 0644)
+		d.AddFile("f2", []byte{2}, 0644)
+
+		n, err := newFuseNode(d, rootNode.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		nodes = append(nodes, n.(*fuseDirectoryNode))
+	}
+
+	for _, n := range nodes {
+		if _, err := n.ReadDirAll(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// only the two most recently read directories fit in the cache.
+	if got, want := listings.totalEntries, 4; got != want {
+		t.Errorf("unexpected number of cached entries: %v, want %v", got, want)
+	}
+
+	if listings.get(nodes[0]) != nil {
+		t.Errorf("least recently used listing was not evicted")
+	}
+
+	if listings.get(nodes[1]) == nil || listings.get(nodes[2]) == nil {
+		t.Errorf("most recently used listings were evicted")
+	}
+
+	// directories too large to fit are not cached at all.
+	big := root.AddDir("big", 0755)
+	for _, name := range []string{"f1", "f2", "f3", "f4", "f5", "f6"} {
+		big.AddFile(name, []byte{1}, 0644)
+	}
+
+	bigNode, _ := newFuseNode(big, rootNode.opts)
+	if _, err := bigNode.(*fuseDirectoryNode).ReadDirAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := listings.totalEntries, 4; got != want {
+		t.Errorf("unexpected number of cached entries: %v, want %v", got, want)
+	}
+}
+
+func mustRead(ctx context.Context, t *testing.T, fh *fuseFileHandle, offset int64, size int) []byte {
+	t.Helper()
+
+	resp := &fuse.ReadResponse{Data: make([]byte, size)}
+	if err := fh.Read(ctx, &fuse.ReadRequest{Offset: offset, Size: size}, resp); err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+
+	return append([]byte(nil), resp.Data...)
+}
+
+func min(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
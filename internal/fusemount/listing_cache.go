@@ -0,0 +1,94 @@
+// +build !windows
+
+package fusemount
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/clock"
+)
+
+const defaultMaxCachedEntries = 100000
+
+// cachedListing is a directory listing held in listingCache.
+type cachedListing struct {
+	dir         *fuseDirectoryNode
+	entries     fs.Entries
+	byName      map[string]fs.Entry
+	expireAfter time.Time
+}
+
+// listingCache holds directory listings of all nodes of a mount, bounded by the total number of entries.
+// Expired listings are removed when accessed, least recently used listings are evicted when the cache is full.
+type listingCache struct {
+	mu           sync.Mutex
+	maxEntries   int
+	totalEntries int
+	lru          *list.List // of *cachedListing, most recently used first
+	byDir        map[*fuseDirectoryNode]*list.Element
+}
+
+func newListingCache(maxEntries int) *listingCache {
+	return &listingCache{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		byDir:      map[*fuseDirectoryNode]*list.Element{},
+	}
+}
+
+// get returns the cached listing of the provided directory or nil if it's not cached or has expired.
+func (c *listingCache) get(dir *fuseDirectoryNode) *cachedListing {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := c.byDir[dir]
+	if e == nil {
+		return nil
+	}
+
+	l := e.Value.(*cachedListing)
+	if !clock.Now().Before(l.expireAfter) {
+		c.removeLocked(e)
+		return nil
+	}
+
+	c.lru.MoveToFront(e)
+
+	return l
+}
+
+// add caches the listing of the provided directory until the given time, evicting least recently used listings
+// to make room for it.
+func (c *listingCache) add(dir *fuseDirectoryNode, entries fs.Entries, byName map[string]fs.Entry, expireAfter time.Time) {
+	if c == nil || len(entries) > c.maxEntries {
+		// no point caching since it would not fit anyway.
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e := c.byDir[dir]; e != nil {
+		c.removeLocked(e)
+	}
+
+	c.byDir[dir] = c.lru.PushFront(&cachedListing{dir, entries, byName, expireAfter})
+	c.totalEntries += len(entries)
+
+	for c.totalEntries > c.maxEntries {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+func (c *listingCache) removeLocked(e *list.Element) {
+	l := c.lru.Remove(e).(*cachedListing)
+	c.totalEntries -= len(l.entries)
+	delete(c.byDir, l.dir)
+}
@@ -0,0 +1,81 @@
+// Package hostconditions detects power and network conditions of the local machine,
+// such as running on battery or using a metered network connection.
+package hostconditions
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/repo/logging"
+)
+
+var log = logging.GetContextLoggerFunc("kopia/hostconditions")
+
+// meteredCacheDuration is how long the metered state is reused, since determining it requires
+// spawning a process on most platforms.
+const meteredCacheDuration = 5 * time.Minute
+
+// UnknownBatteryPercent indicates that the battery charge level could not be determined.
+const UnknownBatteryPercent = -1
+
+// Conditions describes the current power and network conditions.
+type Conditions struct {
+	// OnBattery is true when the machine is running on battery power.
+	OnBattery bool `json:"onBattery"`
+
+	// BatteryPercent is the remaining battery charge or UnknownBatteryPercent.
+	BatteryPercent int `json:"batteryPercent"`
+
+	// Metered is true when the primary network connection is metered.
+	Metered bool `json:"metered"`
+}
+
+// Get returns current conditions. Conditions that can't be determined on the current platform
+// are reported as favorable (on AC power, not metered).
+func Get(ctx context.Context) Conditions {
+	c := Conditions{
+		BatteryPercent: UnknownBatteryPercent,
+	}
+
+	if err := getPowerConditions(ctx, &c); err != nil {
+		log(ctx).Debugf("unable to determine power conditions: %v", err)
+	}
+
+	m, err := defaultMeteredCache.get(ctx)
+	if err != nil {
+		log(ctx).Debugf("unable to determine whether network connection is metered: %v", err)
+	}
+
+	c.Metered = m
+
+	return c
+}
+
+var defaultMeteredCache = &meteredCache{check: isMetered}
+
+// meteredCache remembers the result of checking whether the network connection is metered for meteredCacheDuration.
+type meteredCache struct {
+	check func(ctx context.Context) (bool, error)
+
+	mu         sync.Mutex
+	metered    bool
+	err        error
+	validUntil time.Time
+}
+
+func (c *meteredCache) get(ctx context.Context) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := clock.Now()
+	if now.Before(c.validUntil) {
+		return c.metered, c.err
+	}
+
+	c.metered, c.err = c.check(ctx)
+	c.validUntil = now.Add(meteredCacheDuration)
+
+	return c.metered, c.err
+}
@@ -0,0 +1,91 @@
+// +build darwin
+
+package hostconditions
+
+import (
+	"context"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var pmsetBatteryPercentRegexp = regexp.MustCompile(`(\d+)%`)
+
+func getPowerConditions(ctx context.Context, c *Conditions) error {
+	out, err := exec.CommandContext(ctx, "pmset", "-g", "batt").Output()
+	if err != nil {
+		return errors.Wrap(err, "unable to run pmset")
+	}
+
+	parsePmsetOutput(string(out), c)
+
+	return nil
+}
+
+// parsePmsetOutput parses the output of 'pmset -g batt', which looks like:
+//
+//	Now drawing from 'Battery Power'
+//	 -InternalBattery-0 (id=1234)	85%; discharging; 4:20 remaining present: true
+func parsePmsetOutput(out string, c *Conditions) {
+	c.OnBattery = strings.Contains(out, "'Battery Power'")
+
+	if m := pmsetBatteryPercentRegexp.FindStringSubmatch(out); m != nil {
+		if v, err := strconv.Atoi(m[1]); err == nil {
+			c.BatteryPercent = v
+		}
+	}
+}
+
+// meteredDHCPVendorOption is sent as DHCP vendor-specific information by Android and iOS personal hotspots,
+// which is what macOS uses to treat a connection as expensive.
+const meteredDHCPVendorOption = "ANDROID_METERED"
+
+var routeInterfaceRegexp = regexp.MustCompile(`(?m)^\s*interface:\s*(\S+)`)
+
+// isMetered determines whether the interface of the default route got its DHCP lease from a metered hotspot.
+func isMetered(ctx context.Context) (bool, error) {
+	out, err := exec.CommandContext(ctx, "route", "-n", "get", "default").Output()
+	if err != nil {
+		return false, errors.Wrap(err, "unable to determine default route")
+	}
+
+	iface := parseRouteInterface(string(out))
+	if iface == "" {
+		return false, errors.Errorf("unable to determine interface of default route: %q", out)
+	}
+
+	packet, err := exec.CommandContext(ctx, "ipconfig", "getpacket", iface).Output()
+	if err != nil {
+		// interfaces not configured using DHCP, such as VPN tunnels, have no packet.
+		return false, nil
+	}
+
+	return isMeteredDHCPPacket(string(packet)), nil
+}
+
+// parseRouteInterface returns the interface from the output of 'route -n get default', which looks like:
+//
+//	   route to: default
+//	destination: default
+//	       mask: default
+//	    gateway: 192.168.1.1
+//	  interface: en0
+func parseRouteInterface(out string) string {
+	if m := routeInterfaceRegexp.FindStringSubmatch(out); m != nil {
+		return m[1]
+	}
+
+	return ""
+}
+
+// isMeteredDHCPPacket determines whether the output of 'ipconfig getpacket' includes the metered vendor option,
+// which is printed as a hex dump followed by its text:
+//
+//	vendor_specific (opaque):
+//	0000  41 4e 44 52 4f 49 44 5f  4d 45 54 45 52 45 44     ANDROID_METERED
+func isMeteredDHCPPacket(out string) bool {
+	return strings.Contains(out, meteredDHCPVendorOption)
+}
@@ -0,0 +1,59 @@
+// +build darwin
+
+package hostconditions
+
+import "testing"
+
+func TestParsePmsetOutput(t *testing.T) {
+	cases := []struct {
+		out  string
+		want Conditions
+	}{
+		{
+			out:  "Now drawing from 'AC Power'\n -InternalBattery-0 (id=1234)\t100%; charged; 0:00 remaining present: true\n",
+			want: Conditions{BatteryPercent: 100},
+		},
+		{
+			out:  "Now drawing from 'Battery Power'\n -InternalBattery-0 (id=1234)\t85%; discharging; 4:20 remaining present: true\n",
+			want: Conditions{OnBattery: true, BatteryPercent: 85},
+		},
+		{
+			out:  "Now drawing from 'AC Power'\n",
+			want: Conditions{BatteryPercent: UnknownBatteryPercent},
+		},
+	}
+
+	for _, tc := range cases {
+		got := Conditions{BatteryPercent: UnknownBatteryPercent}
+		parsePmsetOutput(tc.out, &got)
+
+		if got != tc.want {
+			t.Errorf("unexpected conditions for %q: %+v, want %+v", tc.out, got, tc.want)
+		}
+	}
+}
+
+func TestParseRouteInterface(t *testing.T) {
+	out := "   route to: default\ndestination: default\n       mask: default\n    gateway: 192.168.1.1\n  interface: en0\n      flags: <UP,GATEWAY,DONE,STATIC,PRCLONING>\n"
+
+	if got, want := parseRouteInterface(out), "en0"; got != want {
+		t.Errorf("unexpected interface: %q, want %q", got, want)
+	}
+
+	if got := parseRouteInterface("route: writing to routing socket: not in table\n"); got != "" {
+		t.Errorf("unexpected interface: %q", got)
+	}
+}
+
+func TestIsMeteredDHCPPacket(t *testing.T) {
+	metered := "op = BOOTREPLY\nvendor_specific (opaque):\n0000  41 4e 44 52 4f 49 44 5f  4d 45 54 45 52 45 44     ANDROID_METERED\nend (none):\n"
+	unmetered := "op = BOOTREPLY\nrouter (ip_mult): {192.168.1.1}\nend (none):\n"
+
+	if !isMeteredDHCPPacket(metered) {
+		t.Errorf("metered packet not detected")
+	}
+
+	if isMeteredDHCPPacket(unmetered) {
+		t.Errorf("unmetered packet detected as metered")
+	}
+}
@@ -0,0 +1,111 @@
+// +build linux
+
+package hostconditions
+
+import (
+	"context"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const powerSupplyDir = "/sys/class/power_supply"
+
+// NetworkManager metered states, see NMMetered.
+const (
+	nmMeteredYes      = 1
+	nmMeteredGuessYes = 3
+)
+
+func getPowerConditions(ctx context.Context, c *Conditions) error {
+	return readPowerSupplies(powerSupplyDir, c)
+}
+
+// readPowerSupplies determines power conditions based on the power_supply class in sysfs.
+func readPowerSupplies(dir string, c *Conditions) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "unable to list power supplies")
+	}
+
+	var (
+		hasBattery, onMains bool
+		totalPercent, count int
+	)
+
+	for _, e := range entries {
+		supplyDir := filepath.Join(dir, e.Name())
+
+		switch readSysfsString(supplyDir, "type") {
+		case "Mains", "USB":
+			if readSysfsString(supplyDir, "online") == "1" {
+				onMains = true
+			}
+
+		case "Battery":
+			if readSysfsString(supplyDir, "scope") == "Device" {
+				// battery of a peripheral device, such as a mouse.
+				continue
+			}
+
+			hasBattery = true
+
+			if readSysfsString(supplyDir, "status") == "Discharging" {
+				c.OnBattery = true
+			}
+
+			if v, err := strconv.Atoi(readSysfsString(supplyDir, "capacity")); err == nil {
+				totalPercent += v
+				count++
+			}
+		}
+	}
+
+	if !hasBattery || onMains {
+		c.OnBattery = false
+	}
+
+	if count > 0 {
+		c.BatteryPercent = totalPercent / count
+	}
+
+	return nil
+}
+
+func readSysfsString(dir, name string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, name)) //nolint:gosec
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+// isMetered queries NetworkManager for the metered state of the primary connection.
+func isMetered(ctx context.Context) (bool, error) {
+	out, err := exec.CommandContext(ctx, "busctl", "get-property",
+		"org.freedesktop.NetworkManager",
+		"/org/freedesktop/NetworkManager",
+		"org.freedesktop.NetworkManager",
+		"Metered").Output()
+	if err != nil {
+		return false, errors.Wrap(err, "unable to query NetworkManager")
+	}
+
+	// output is in the form "u <value>"
+	parts := strings.Fields(string(out))
+	if len(parts) != 2 { //nolint:gomnd
+		return false, errors.Errorf("unexpected output from NetworkManager: %q", out)
+	}
+
+	v, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false, errors.Wrap(err, "invalid metered state")
+	}
+
+	return v == nmMeteredYes || v == nmMeteredGuessYes, nil
+}
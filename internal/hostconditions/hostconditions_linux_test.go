@@ -0,0 +1,77 @@
+// +build linux
+
+package hostconditions
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPowerSupplies(t *testing.T) {
+	cases := []struct {
+		name     string
+		supplies map[string]map[string]string
+		want     Conditions
+	}{
+		{
+			name: "desktop",
+			supplies: map[string]map[string]string{
+				"AC": {"type": "Mains", "online": "1"},
+			},
+			want: Conditions{BatteryPercent: UnknownBatteryPercent},
+		},
+		{
+			name: "laptop on AC",
+			supplies: map[string]map[string]string{
+				"AC":   {"type": "Mains", "online": "1"},
+				"BAT0": {"type": "Battery", "status": "Charging", "capacity": "40"},
+			},
+			want: Conditions{BatteryPercent: 40},
+		},
+		{
+			name: "laptop on battery",
+			supplies: map[string]map[string]string{
+				"AC":    {"type": "Mains", "online": "0"},
+				"BAT0":  {"type": "Battery", "status": "Discharging", "capacity": "20"},
+				"mouse": {"type": "Battery", "scope": "Device", "status": "Discharging", "capacity": "90"},
+			},
+			want: Conditions{OnBattery: true, BatteryPercent: 20},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "power-supply")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(dir)
+
+			for name, attrs := range tc.supplies {
+				if err := os.Mkdir(filepath.Join(dir, name), 0700); err != nil {
+					t.Fatal(err)
+				}
+
+				for k, v := range attrs {
+					if err := ioutil.WriteFile(filepath.Join(dir, name, k), []byte(v+"\n"), 0600); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			got := Conditions{BatteryPercent: UnknownBatteryPercent}
+			if err := readPowerSupplies(dir, &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tc.want {
+				t.Errorf("unexpected conditions: %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
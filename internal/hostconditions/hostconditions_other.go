@@ -0,0 +1,15 @@
+// +build !linux,!darwin,!windows
+
+package hostconditions
+
+import (
+	"context"
+)
+
+func getPowerConditions(ctx context.Context, c *Conditions) error {
+	return nil
+}
+
+func isMetered(ctx context.Context) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,43 @@
+package hostconditions
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+func TestMeteredCache(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var calls int
+
+	c := &meteredCache{check: func(ctx context.Context) (bool, error) {
+		calls++
+		return true, errors.New("some error")
+	}}
+
+	for i := 0; i < 3; i++ {
+		m, err := c.get(ctx)
+		if !m || err == nil {
+			t.Fatalf("unexpected result: %v %v", m, err)
+		}
+	}
+
+	if calls != 1 {
+		t.Fatalf("unexpected number of checks: %v", calls)
+	}
+
+	// expire the cached state.
+	c.validUntil = c.validUntil.Add(-meteredCacheDuration)
+
+	if _, err := c.get(ctx); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	if calls != 2 {
+		t.Fatalf("unexpected number of checks after expiration: %v", calls)
+	}
+}
@@ -0,0 +1,71 @@
+// +build windows
+
+package hostconditions
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+)
+
+var procGetSystemPowerStatus = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetSystemPowerStatus")
+
+// systemPowerStatus corresponds to SYSTEM_POWER_STATUS structure.
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+const (
+	acLineOffline         = 0
+	batteryFlagNoBattery  = 128
+	batteryPercentUnknown = 255
+)
+
+func getPowerConditions(ctx context.Context, c *Conditions) error {
+	var st systemPowerStatus
+
+	if r, _, err := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&st))); r == 0 {
+		return errors.Wrap(err, "GetSystemPowerStatus failed")
+	}
+
+	if st.BatteryFlag&batteryFlagNoBattery != 0 {
+		return nil
+	}
+
+	c.OnBattery = st.ACLineStatus == acLineOffline
+
+	if st.BatteryLifePercent != batteryPercentUnknown {
+		c.BatteryPercent = int(st.BatteryLifePercent)
+	}
+
+	return nil
+}
+
+// connection cost is only available through WinRT, which is easiest to reach via PowerShell.
+// Since starting PowerShell is expensive, the result is cached by the caller, see meteredCache.
+const connectionCostScript = `[void][Windows.Networking.Connectivity.NetworkInformation, Windows, ContentType=WindowsRuntime];` +
+	`$p = [Windows.Networking.Connectivity.NetworkInformation]::GetInternetConnectionProfile();` +
+	`if ($p) { $p.GetConnectionCost().NetworkCostType }`
+
+func isMetered(ctx context.Context) (bool, error) {
+	out, err := exec.CommandContext(ctx, "powershell.exe", "-NoProfile", "-NonInteractive", "-Command", connectionCostScript).Output()
+	if err != nil {
+		return false, errors.Wrap(err, "unable to determine network cost")
+	}
+
+	switch strings.TrimSpace(string(out)) {
+	case "Fixed", "Variable":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
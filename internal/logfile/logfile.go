@@ -4,13 +4,12 @@
 import (
 	"context"
 	"fmt"
-	"io/ioutil"
-	"math"
+	"io"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/alecthomas/kingpin"
@@ -20,6 +19,7 @@
 
 	"github.com/kopia/kopia/cli"
 	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/logretention"
 	"github.com/kopia/kopia/internal/ospath"
 	"github.com/kopia/kopia/repo/content"
 	repologging "github.com/kopia/kopia/repo/logging"
@@ -39,10 +39,13 @@
 	logDir                = cli.App().Flag("log-dir", "Directory where log files should be written.").Envar("KOPIA_LOG_DIR").Default(ospath.LogsDir()).String()
 	logDirMaxFiles        = cli.App().Flag("log-dir-max-files", "Maximum number of log files to retain").Envar("KOPIA_LOG_DIR_MAX_FILES").Default("1000").Hidden().Int()
 	logDirMaxAge          = cli.App().Flag("log-dir-max-age", "Maximum age of log files to retain").Envar("KOPIA_LOG_DIR_MAX_AGE").Hidden().Default("720h").Duration()
+	logDirMaxSize         = cli.App().Flag("log-dir-max-size", "Maximum total size of log files to retain in the log directory (0 means unlimited)").Envar("KOPIA_LOG_DIR_MAX_SIZE").Default("1GB").Bytes()
+	logFileMaxSegmentSize = cli.App().Flag("log-file-max-segment-size", "Maximum size of a single log file, after which logging continues in a new file").Envar("KOPIA_LOG_FILE_MAX_SEGMENT_SIZE").Default("50MB").Hidden().Bytes()
 	contentLogDirMaxFiles = cli.App().Flag("content-log-dir-max-files", "Maximum number of content log files to retain").Envar("KOPIA_CONTENT_LOG_DIR_MAX_FILES").Default("5000").Hidden().Int()
 	contentLogDirMaxAge   = cli.App().Flag("content-log-dir-max-age", "Maximum age of content log files to retain").Envar("KOPIA_CONTENT_LOG_DIR_MAX_AGE").Default("720h").Hidden().Duration()
 	logLevel              = cli.App().Flag("log-level", "Console log level").Default("info").Enum(logLevels...)
 	fileLogLevel          = cli.App().Flag("file-log-level", "File log level").Default("debug").Enum(logLevels...)
+	operationLogLevel     = cli.App().Flag("operation-log-level", "Log level of snapshot, restore and maintenance log files").Default("debug").Enum(logLevels...)
 	forceColor            = cli.App().Flag("force-color", "Force color output").Hidden().Envar("KOPIA_FORCE_COLOR").Bool()
 	disableColor          = cli.App().Flag("disable-color", "Disable color output").Hidden().Envar("KOPIA_DISABLE_COLOR").Bool()
 	consoleLogTimestamps  = cli.App().Flag("console-timestamps", "Log timestamps to stderr.").Hidden().Default("false").Envar("KOPIA_CONSOLE_TIMESTAMPS").Bool()
@@ -50,27 +53,52 @@
 
 var log = repologging.GetContextLoggerFunc("kopia")
 
-const (
-	logFileNamePrefix = "kopia-"
-	logFileNameSuffix = ".log"
-)
+// level filters of the active console and log file backends, which can be changed using SetLevels().
+var consoleLevelFilter, fileLevelFilter *levelFilter
+
+// operationLogSubdirs maps commands performing long-running operations to log subdirectories,
+// so that each kind of operation is retained independently of other commands.
+var operationLogSubdirs = map[string]string{
+	"snapshot create":  "snapshot-logs",
+	"snapshot restore": "restore-logs",
+	"restore":          "restore-logs",
+	"maintenance run":  "maintenance-logs",
+}
 
 // Initialize is invoked as part of command execution to create log file just before it's needed.
-func Initialize(ctx *kingpin.ParseContext) error {
+func Initialize(kpc *kingpin.ParseContext) error {
+	// kingpin does not provide context to pre-actions, this context is used by background sweeps of log directories
+	// started by log backends throughout the lifetime of the process.
+	ctx := context.Background()
 	now := clock.Now()
 
 	suffix := "unknown"
-	if c := ctx.SelectedCommand; c != nil {
+	subdir := "cli-logs"
+	level := *fileLogLevel
+
+	if c := kpc.SelectedCommand; c != nil {
 		suffix = strings.ReplaceAll(c.FullCommand(), " ", "-")
+
+		if sd, ok := operationLogSubdirs[c.FullCommand()]; ok {
+			subdir = sd
+			level = *operationLogLevel
+		}
 	}
 
+	consoleLevelFilter = setupConsoleBackend()
+	fileLevelFilter = setupLogFileBackend(ctx, now, subdir, suffix, level)
+
 	// activate backends
 	logging.SetBackend(
-		setupConsoleBackend(),
-		setupLogFileBackend(now, suffix),
-		setupContentLogFileBackend(now, suffix),
+		consoleLevelFilter,
+		fileLevelFilter,
+		setupContentLogFileBackend(ctx, now, suffix),
 	)
 
+	if *logFile == "" && *logDirMaxSize > 0 {
+		go enforceLogDirMaxSize(ctx)
+	}
+
 	if *forceColor {
 		color.NoColor = false
 	}
@@ -82,7 +110,37 @@ func Initialize(ctx *kingpin.ParseContext) error {
 	return nil
 }
 
-func setupConsoleBackend() logging.Backend {
+// SetLevels changes console and log file levels of a running process. Empty level leaves
+// the corresponding level unchanged.
+func SetLevels(consoleLevel, fileLevel string) error {
+	for _, l := range []string{consoleLevel, fileLevel} {
+		if l != "" && !isValidLogLevel(l) {
+			return errors.Errorf("invalid log level %q, must be one of %v", l, strings.Join(logLevels, ", "))
+		}
+	}
+
+	if consoleLevel != "" && consoleLevelFilter != nil {
+		consoleLevelFilter.setLevel(logLevelFromFlag(consoleLevel))
+	}
+
+	if fileLevel != "" && fileLevelFilter != nil {
+		fileLevelFilter.setLevel(logLevelFromFlag(fileLevel))
+	}
+
+	return nil
+}
+
+func isValidLogLevel(l string) bool {
+	for _, v := range logLevels {
+		if v == l {
+			return true
+		}
+	}
+
+	return false
+}
+
+func setupConsoleBackend() *levelFilter {
 	var (
 		prefix         = "%{color}"
 		suffix         = "%{message}%{color:reset}"
@@ -101,12 +159,10 @@ func setupConsoleBackend() logging.Backend {
 	l.SetLevel(logging.CRITICAL, content.FormatLogModule)
 
 	// log everything else at a level specified using --log-level
-	l.SetLevel(logLevelFromFlag(*logLevel), "")
-
-	return l
+	return newLevelFilter(l, logLevelFromFlag(*logLevel))
 }
 
-func setupLogFileBasedLogger(now time.Time, subdir, suffix, logFileOverride string, maxFiles int, maxAge time.Duration) logging.Backend {
+func setupLogFileBasedLogger(ctx context.Context, now time.Time, subdir, suffix, logFileOverride string, maxFiles int, maxAge time.Duration) logging.Backend {
 	var logFileName, symlinkName string
 
 	if logFileOverride != "" {
@@ -119,7 +175,7 @@ func setupLogFileBasedLogger(now time.Time, subdir, suffix, logFileOverride stri
 	}
 
 	if logFileName == "" {
-		logBaseName := fmt.Sprintf("%v%v-%v-%v%v", logFileNamePrefix, now.Format("20060102-150405"), os.Getpid(), suffix, logFileNameSuffix)
+		logBaseName := fmt.Sprintf("%v%v-%v-%v%v", logretention.LogFileNamePrefix, now.Format("20060102-150405"), os.Getpid(), suffix, logretention.LogFileNameSuffix)
 		logFileName = filepath.Join(*logDir, subdir, logBaseName)
 		symlinkName = "latest.log"
 	}
@@ -132,36 +188,41 @@ func setupLogFileBasedLogger(now time.Time, subdir, suffix, logFileOverride stri
 	}
 
 	// do not scrub directory if custom log file has been provided.
-	if logFileOverride == "" && shouldSweepLog(maxFiles, maxAge) {
-		go sweepLogDir(context.TODO(), logDir, maxFiles, maxAge)
+	if opt := (logretention.Options{MaxCount: maxFiles, MaxAge: maxAge}); logFileOverride == "" && opt.Enabled() {
+		go sweepLogDir(ctx, logDir, opt)
 	}
 
-	return &onDemandBackend{
+	b := &onDemandBackend{
+		ctx:             ctx,
 		logDir:          logDir,
 		logFileBaseName: logFileBaseName,
 		symlinkName:     symlinkName,
 	}
+
+	if logFileOverride == "" {
+		b.maxSegmentSize = int64(*logFileMaxSegmentSize)
+	}
+
+	return b
 }
 
-func setupLogFileBackend(now time.Time, suffix string) logging.Backend {
+func setupLogFileBackend(ctx context.Context, now time.Time, subdir, suffix, level string) *levelFilter {
 	l := logging.AddModuleLevel(
 		logging.NewBackendFormatter(
-			setupLogFileBasedLogger(now, "cli-logs", suffix, *logFile, *logDirMaxFiles, *logDirMaxAge),
+			setupLogFileBasedLogger(ctx, now, subdir, suffix, *logFile, *logDirMaxFiles, *logDirMaxAge),
 			fileLogFormat))
 
 	// do not output content logs to the regular log file
 	l.SetLevel(logging.CRITICAL, content.FormatLogModule)
 
-	// log everything else at a level specified using --file-level
-	l.SetLevel(logLevelFromFlag(*fileLogLevel), "")
-
-	return l
+	// log everything else at a level specified using --file-log-level or --operation-log-level
+	return newLevelFilter(l, logLevelFromFlag(level))
 }
 
-func setupContentLogFileBackend(now time.Time, suffix string) logging.Backend {
+func setupContentLogFileBackend(ctx context.Context, now time.Time, suffix string) logging.Backend {
 	l := logging.AddModuleLevel(
 		logging.NewBackendFormatter(
-			setupLogFileBasedLogger(now, "content-logs", suffix, *contentLogFile, *contentLogDirMaxFiles, *contentLogDirMaxAge),
+			setupLogFileBasedLogger(ctx, now, "content-logs", suffix, *contentLogFile, *contentLogDirMaxFiles, *contentLogDirMaxAge),
 			contentLogFormat))
 
 	// only log content entries
@@ -173,49 +234,16 @@ func setupContentLogFileBackend(now time.Time, suffix string) logging.Backend {
 	return l
 }
 
-func shouldSweepLog(maxFiles int, maxAge time.Duration) bool {
-	return maxFiles > 0 || maxAge > 0
-}
-
-func sweepLogDir(ctx context.Context, dirname string, maxCount int, maxAge time.Duration) {
-	var timeCutoff time.Time
-	if maxAge > 0 {
-		timeCutoff = clock.Now().Add(-maxAge)
+func sweepLogDir(ctx context.Context, dirname string, opt logretention.Options) {
+	if _, err := logretention.Sweep(ctx, dirname, opt); err != nil {
+		log(ctx).Warningf("unable to sweep log directory: %v", err)
 	}
+}
 
-	if maxCount == 0 {
-		maxCount = math.MaxInt32
-	}
-
-	entries, err := ioutil.ReadDir(dirname)
-	if err != nil {
-		log(ctx).Warningf("unable to read log directory: %v", err)
-		return
-	}
-
-	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].ModTime().After(entries[j].ModTime())
-	})
-
-	cnt := 0
-
-	for _, e := range entries {
-		if !strings.HasPrefix(e.Name(), logFileNamePrefix) {
-			continue
-		}
-
-		if !strings.HasSuffix(e.Name(), logFileNameSuffix) {
-			continue
-		}
-
-		cnt++
-
-		if cnt > maxCount || e.ModTime().Before(timeCutoff) {
-			if err = os.Remove(filepath.Join(dirname, e.Name())); err != nil && !os.IsNotExist(err) {
-				log(ctx).Warningf("unable to remove log file: %v", err)
-			}
-		}
-	}
+// enforceLogDirMaxSize removes oldest log files in all subdirectories of the log directory
+// until their total size is below --log-dir-max-size.
+func enforceLogDirMaxSize(ctx context.Context) {
+	sweepLogDir(ctx, *logDir, logretention.Options{MaxSize: int64(*logDirMaxSize)})
 }
 
 func logLevelFromFlag(levelString string) logging.Level {
@@ -233,10 +261,32 @@ func logLevelFromFlag(levelString string) logging.Level {
 	}
 }
 
+// levelFilter limits the level of messages passed to the underlying backend to a level,
+// which can be safely changed while logging is in progress.
+type levelFilter struct {
+	logging.LeveledBackend
+
+	level int32
+}
+
+func newLevelFilter(b logging.LeveledBackend, level logging.Level) *levelFilter {
+	return &levelFilter{LeveledBackend: b, level: int32(level)}
+}
+
+func (b *levelFilter) setLevel(level logging.Level) {
+	atomic.StoreInt32(&b.level, int32(level))
+}
+
+func (b *levelFilter) IsEnabledFor(level logging.Level, module string) bool {
+	return level <= logging.Level(atomic.LoadInt32(&b.level)) && b.LeveledBackend.IsEnabledFor(level, module)
+}
+
 type onDemandBackend struct {
+	ctx             context.Context
 	logDir          string
 	logFileBaseName string
 	symlinkName     string
+	maxSegmentSize  int64
 
 	backend logging.Backend
 	once    sync.Once
@@ -244,14 +294,19 @@ type onDemandBackend struct {
 
 func (w *onDemandBackend) Log(level logging.Level, depth int, rec *logging.Record) error {
 	w.once.Do(func() {
-		lf := filepath.Join(w.logDir, w.logFileBaseName)
-		f, err := os.Create(lf)
-		if err != nil {
+		rw := &rotatingWriter{
+			ctx:             w.ctx,
+			logDir:          w.logDir,
+			logFileBaseName: w.logFileBaseName,
+			maxSegmentSize:  w.maxSegmentSize,
+		}
+
+		if err := rw.openSegment(); err != nil {
 			fmt.Fprintf(os.Stderr, "unable to open log file: %v\n", err)
 			return
 		}
 
-		w.backend = logging.NewLogBackend(f, "", 0)
+		w.backend = logging.NewLogBackend(rw, "", 0)
 
 		if w.symlinkName != "" {
 			symlink := filepath.Join(w.logDir, w.symlinkName)
@@ -266,3 +321,63 @@ func (w *onDemandBackend) Log(level logging.Level, depth int, rec *logging.Recor
 
 	return w.backend.Log(level, depth+1, rec)
 }
+
+// rotatingWriter writes log to a file, which is rotated when it reaches the maximum segment size.
+// Segments after the first one are named "<name>.<n>.log".
+type rotatingWriter struct {
+	ctx             context.Context
+	logDir          string
+	logFileBaseName string
+	maxSegmentSize  int64
+
+	mu          sync.Mutex
+	f           io.WriteCloser
+	segment     int
+	segmentSize int64
+}
+
+func (w *rotatingWriter) segmentFileName() string {
+	if w.segment == 0 {
+		return filepath.Join(w.logDir, w.logFileBaseName)
+	}
+
+	base := strings.TrimSuffix(w.logFileBaseName, logretention.LogFileNameSuffix)
+
+	return filepath.Join(w.logDir, fmt.Sprintf("%v.%v%v", base, w.segment, logretention.LogFileNameSuffix))
+}
+
+func (w *rotatingWriter) openSegment() error {
+	f, err := os.Create(w.segmentFileName())
+	if err != nil {
+		return errors.Wrap(err, "unable to create log file")
+	}
+
+	w.f = f
+	w.segmentSize = 0
+
+	return nil
+}
+
+func (w *rotatingWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.maxSegmentSize > 0 && w.segmentSize > 0 && w.segmentSize+int64(len(b)) > w.maxSegmentSize {
+		w.f.Close() //nolint:errcheck
+		w.segment++
+
+		if err := w.openSegment(); err != nil {
+			return 0, err
+		}
+
+		// long-running commands can fill the log directory, enforce the limit on each rotation.
+		if *logDirMaxSize > 0 {
+			go enforceLogDirMaxSize(w.ctx)
+		}
+	}
+
+	n, err := w.f.Write(b)
+	w.segmentSize += int64(n)
+
+	return n, err
+}
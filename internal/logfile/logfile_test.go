@@ -0,0 +1,40 @@
+package logfile
+
+import (
+	"testing"
+
+	logging "github.com/op/go-logging"
+)
+
+func TestLevelFilter(t *testing.T) {
+	consoleLevelFilter = newLevelFilter(logging.AddModuleLevel(logging.NewMemoryBackend(10)), logging.INFO)
+	fileLevelFilter = newLevelFilter(logging.AddModuleLevel(logging.NewMemoryBackend(10)), logging.DEBUG)
+
+	defer func() {
+		consoleLevelFilter, fileLevelFilter = nil, nil
+	}()
+
+	if consoleLevelFilter.IsEnabledFor(logging.DEBUG, "") {
+		t.Errorf("debug unexpectedly enabled on console")
+	}
+
+	if err := SetLevels("debug", "warning"); err != nil {
+		t.Fatalf("unable to set levels: %v", err)
+	}
+
+	if !consoleLevelFilter.IsEnabledFor(logging.DEBUG, "") {
+		t.Errorf("debug not enabled on console")
+	}
+
+	if fileLevelFilter.IsEnabledFor(logging.INFO, "") || !fileLevelFilter.IsEnabledFor(logging.WARNING, "") {
+		t.Errorf("unexpected file log level")
+	}
+
+	if err := SetLevels("", "bogus"); err == nil {
+		t.Errorf("expected error for invalid log level")
+	}
+
+	if fileLevelFilter.IsEnabledFor(logging.INFO, "") {
+		t.Errorf("invalid level should not change file log level")
+	}
+}
@@ -0,0 +1,142 @@
+// Package logretention implements retention of log files.
+package logretention
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/repo/logging"
+)
+
+var log = logging.GetContextLoggerFunc("logretention")
+
+const (
+	// LogFileNamePrefix is the prefix of all log files written by kopia.
+	LogFileNamePrefix = "kopia-"
+
+	// LogFileNameSuffix is the suffix of all log files written by kopia.
+	LogFileNameSuffix = ".log"
+)
+
+// Options specifies which log files to retain.
+type Options struct {
+	MaxCount int           // maximum number of files in each directory
+	MaxAge   time.Duration // maximum age of files
+	MaxSize  int64         // maximum total size of files
+	DryRun   bool          // only return files that would be deleted
+}
+
+// Enabled returns true if any retention limit is specified.
+func (o Options) Enabled() bool {
+	return o.MaxCount > 0 || o.MaxAge > 0 || o.MaxSize > 0
+}
+
+// IsLogFile determines whether the provided file name is a kopia log file.
+func IsLogFile(name string) bool {
+	return strings.HasPrefix(name, LogFileNamePrefix) && strings.HasSuffix(name, LogFileNameSuffix)
+}
+
+// File describes a log file.
+type File struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Sweep removes log files in the provided directory that exceed retention limits, most recent files are retained first.
+// MaxCount is applied to each directory, MaxAge and MaxSize are applied to log files in the directory and all subdirectories.
+// Returns the list of removed files.
+func Sweep(ctx context.Context, dirname string, opt Options) ([]File, error) {
+	var timeCutoff time.Time
+	if opt.MaxAge > 0 {
+		timeCutoff = clock.Now().Add(-opt.MaxAge)
+	}
+
+	maxCount := opt.MaxCount
+	if maxCount == 0 {
+		maxCount = math.MaxInt32
+	}
+
+	maxSize := opt.MaxSize
+	if maxSize == 0 {
+		maxSize = math.MaxInt64
+	}
+
+	files, err := listLogFiles(dirname)
+	if err != nil {
+		return nil, err
+	}
+
+	// most recent first
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime.After(files[j].ModTime)
+	})
+
+	var (
+		removed   []File
+		totalSize int64
+	)
+
+	countPerDir := map[string]int{}
+
+	for _, f := range files {
+		dir := filepath.Dir(f.Path)
+		countPerDir[dir]++
+		totalSize += f.Size
+
+		if countPerDir[dir] <= maxCount && !f.ModTime.Before(timeCutoff) && totalSize <= maxSize {
+			continue
+		}
+
+		removed = append(removed, f)
+
+		if opt.DryRun {
+			continue
+		}
+
+		if err := os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+			log(ctx).Warningf("unable to remove log file: %v", err)
+		}
+	}
+
+	return removed, nil
+}
+
+func listLogFiles(dirname string) ([]File, error) {
+	entries, err := ioutil.ReadDir(dirname)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read log directory")
+	}
+
+	var result []File
+
+	for _, e := range entries {
+		if e.IsDir() {
+			sub, err := listLogFiles(filepath.Join(dirname, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			result = append(result, sub...)
+
+			continue
+		}
+
+		if !e.Mode().IsRegular() || !IsLogFile(e.Name()) {
+			continue
+		}
+
+		result = append(result, File{filepath.Join(dirname, e.Name()), e.Size(), e.ModTime()})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,113 @@
+package logretention
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSweep(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "kopia-logretention")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+
+	// files in each subdirectory, from newest to oldest, 100 bytes each.
+	files := []string{
+		"a/kopia-1.log",
+		"b/kopia-2.log",
+		"a/kopia-3.log",
+		"a/kopia-4.log",
+		"b/kopia-5.log",
+		"a/not-a-log.txt",
+	}
+
+	for i, f := range files {
+		fname := filepath.Join(dir, f)
+
+		if err := os.MkdirAll(filepath.Dir(fname), 0o700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(fname, make([]byte, 100), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		ts := now.Add(-time.Duration(i) * time.Hour)
+		if err := os.Chtimes(fname, ts, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		opt  Options
+		want []string
+	}{
+		{Options{}, nil},
+		{Options{MaxCount: 2}, []string{"a/kopia-4.log"}},
+		{Options{MaxAge: 150 * time.Minute}, []string{"a/kopia-4.log", "b/kopia-5.log"}},
+		{Options{MaxSize: 250}, []string{"a/kopia-3.log", "a/kopia-4.log", "b/kopia-5.log"}},
+		{Options{MaxCount: 1, MaxSize: 250}, []string{"a/kopia-3.log", "a/kopia-4.log", "b/kopia-5.log"}},
+	}
+
+	for _, tc := range cases {
+		tc.opt.DryRun = true
+
+		removed, err := Sweep(ctx, dir, tc.opt)
+		if err != nil {
+			t.Fatalf("sweep error: %v", err)
+		}
+
+		var got []string
+
+		for _, f := range removed {
+			rel, _ := filepath.Rel(dir, f.Path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+
+		sort.Strings(got)
+
+		if !equalStrings(got, tc.want) {
+			t.Errorf("unexpected files removed with %+v: %v, want %v", tc.opt, got, tc.want)
+		}
+	}
+
+	// actually remove files.
+	if _, err := Sweep(ctx, dir, Options{MaxSize: 250}); err != nil {
+		t.Fatalf("sweep error: %v", err)
+	}
+
+	for _, f := range []string{"a/kopia-3.log", "b/kopia-5.log"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); !os.IsNotExist(err) {
+			t.Errorf("file %v was not removed: %v", f, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "a/not-a-log.txt")); err != nil {
+		t.Errorf("non-log file was removed: %v", err)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
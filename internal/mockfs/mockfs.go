@@ -272,10 +272,24 @@ func (imf *File) Open(ctx context.Context) (fs.Reader, error) {
 
 type inmemorySymlink struct {
 	entry
+	target string
 }
 
 func (imsl *inmemorySymlink) Readlink(ctx context.Context) (string, error) {
-	panic("not implemented yet")
+	return imsl.target, nil
+}
+
+// AddSymlink adds a fake symlink with a given name, target and permissions.
+func (imd *Directory) AddSymlink(name, target string, permissions os.FileMode) {
+	imd, name = imd.resolveSubdir(name)
+
+	imd.addChild(&inmemorySymlink{
+		entry: entry{
+			name: name,
+			mode: permissions | os.ModeSymlink,
+		},
+		target: target,
+	})
 }
 
 // NewDirectory returns new mock directory.
@@ -3,6 +3,7 @@
 
 import (
 	"context"
+	"time"
 
 	"github.com/kopia/kopia/repo/logging"
 )
@@ -23,4 +24,16 @@ type Options struct {
 	// Allows the mounting over a non-empty directory. The files in it will be shadowed by the freshly created mount.
 	// Supported only on Fuse.
 	FuseAllowNonEmptyMount bool
+	// Duration for which the kernel may cache attributes of files and directories. Supported only on Fuse.
+	FuseAttrTTL time.Duration
+	// Duration for which the kernel may cache directory entry lookups and directory listings are cached in memory.
+	// Supported only on Fuse.
+	FuseEntryTTL time.Duration
+	// Maximum number of directory entries cached in memory, zero uses the default. Supported only on Fuse.
+	FuseMaxCachedEntries int
+	// Number of bytes to read in the background following sequential file reads. Supported only on Fuse.
+	FuseReadAheadSize int
+	// Mount using operating system WebDAV client instead of FUSE, FUSE-T or WinFsp. This is the default when none of them
+	// is available.
+	PreferWebDAV bool
 }
@@ -0,0 +1,196 @@
+// +build windows,!cgo darwin,cgo,fuset
+
+package mount
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/winfsp/cgofuse/fuse"
+
+	"github.com/kopia/kopia/fs"
+)
+
+// cgofuseSupported indicates whether this build supports mounting using cgofuse, which uses
+// WinFsp on Windows and FUSE-T on macOS. FUSE-T requires cgo and its headers, so it's enabled
+// using 'fuset' build tag.
+const cgofuseSupported = true
+
+// cgofuseFS exposes pathFS as read-only cgofuse filesystem.
+type cgofuseFS struct {
+	fuse.FileSystemBase
+
+	ctx     context.Context
+	p       *pathFS
+	mounted chan struct{}
+}
+
+func (f *cgofuseFS) Init() {
+	close(f.mounted)
+}
+
+func (f *cgofuseFS) errno(op, pathname string, err error) int {
+	switch {
+	case isNotExist(err):
+		return -fuse.ENOENT
+	case errors.Is(err, errNotDirectory):
+		return -fuse.ENOTDIR
+	default:
+		log(f.ctx).Warningf("%v %v failed: %v", op, pathname, err)
+		return -fuse.EIO
+	}
+}
+
+func fillStat(e fs.Entry, stat *fuse.Stat_t) {
+	m := e.Mode()
+
+	switch {
+	case m.IsDir():
+		stat.Mode = fuse.S_IFDIR
+	case m&os.ModeSymlink != 0:
+		stat.Mode = fuse.S_IFLNK
+	default:
+		stat.Mode = fuse.S_IFREG
+	}
+
+	stat.Mode |= uint32(m.Perm())
+	stat.Nlink = 1
+	stat.Size = e.Size()
+	stat.Uid = e.Owner().UserID
+	stat.Gid = e.Owner().GroupID
+
+	t := fuse.NewTimespec(e.ModTime())
+	stat.Atim, stat.Mtim, stat.Ctim, stat.Birthtim = t, t, t, t
+}
+
+func (f *cgofuseFS) Getattr(pathname string, stat *fuse.Stat_t, fh uint64) int {
+	e, err := f.p.lookup(f.ctx, pathname)
+	if err != nil {
+		return f.errno("getattr", pathname, err)
+	}
+
+	fillStat(e, stat)
+
+	return 0
+}
+
+func (f *cgofuseFS) Readdir(pathname string, fill func(name string, stat *fuse.Stat_t, ofst int64) bool, ofst int64, fh uint64) int {
+	entries, err := f.p.readdir(f.ctx, pathname)
+	if err != nil {
+		return f.errno("readdir", pathname, err)
+	}
+
+	fill(".", nil, 0)
+	fill("..", nil, 0)
+
+	for _, e := range entries {
+		stat := &fuse.Stat_t{}
+		fillStat(e, stat)
+
+		if !fill(e.Name(), stat, 0) {
+			break
+		}
+	}
+
+	return 0
+}
+
+func (f *cgofuseFS) Readlink(pathname string) (int, string) {
+	target, err := f.p.readlink(f.ctx, pathname)
+	if err != nil {
+		return f.errno("readlink", pathname, err), ""
+	}
+
+	return 0, target
+}
+
+func (f *cgofuseFS) Open(pathname string, flags int) (int, uint64) {
+	if flags&fuse.O_ACCMODE != fuse.O_RDONLY {
+		return -fuse.EROFS, ^uint64(0)
+	}
+
+	fh, err := f.p.open(f.ctx, pathname)
+	if err != nil {
+		return f.errno("open", pathname, err), ^uint64(0)
+	}
+
+	return 0, fh
+}
+
+func (f *cgofuseFS) Read(pathname string, buff []byte, ofst int64, fh uint64) int {
+	n, err := f.p.read(fh, buff, ofst)
+	if err != nil {
+		return f.errno("read", pathname, err)
+	}
+
+	return n
+}
+
+func (f *cgofuseFS) Release(pathname string, fh uint64) int {
+	if err := f.p.release(fh); err != nil {
+		return f.errno("release", pathname, err)
+	}
+
+	return 0
+}
+
+// mountCgofuse mounts the directory using cgofuse at the provided mount point, which is a directory
+// or a drive letter on Windows, and returns once the filesystem is mounted.
+func mountCgofuse(ctx context.Context, entry fs.Directory, mountPoint string, isTempDir bool, options []string) (Controller, error) {
+	f := &cgofuseFS{
+		ctx:     ctx,
+		p:       newPathFS(entry),
+		mounted: make(chan struct{}),
+	}
+
+	host := fuse.NewFileSystemHost(f)
+	host.SetCapReaddirPlus(true)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		log(ctx).Debugf("mount finished with %v", host.Mount(mountPoint, options))
+	}()
+
+	select {
+	case <-f.mounted:
+		return cgofuseController{host, mountPoint, done, isTempDir}, nil
+
+	case <-done:
+		return nil, errors.Errorf("unable to mount %v", mountPoint)
+	}
+}
+
+type cgofuseController struct {
+	host       *fuse.FileSystemHost
+	mountPoint string
+	done       chan struct{}
+	isTempDir  bool
+}
+
+func (c cgofuseController) MountPath() string {
+	return c.mountPoint
+}
+
+func (c cgofuseController) Unmount(ctx context.Context) error {
+	if !c.host.Unmount() {
+		return errors.Errorf("unable to unmount %v", c.mountPoint)
+	}
+
+	<-c.done
+
+	if c.isTempDir {
+		if err := os.Remove(c.mountPoint); err != nil {
+			return errors.Wrap(err, "unable to remove temporary mount point")
+		}
+	}
+
+	return nil
+}
+
+func (c cgofuseController) Done() <-chan struct{} {
+	return c.done
+}
@@ -0,0 +1,20 @@
+// +build !windows cgo
+// +build !darwin !cgo !fuset
+
+package mount
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+)
+
+// cgofuseSupported indicates whether this build supports mounting using cgofuse, which uses
+// WinFsp on Windows and FUSE-T on macOS.
+const cgofuseSupported = false
+
+func mountCgofuse(ctx context.Context, entry fs.Directory, mountPoint string, isTempDir bool, options []string) (Controller, error) {
+	return nil, errors.New("this build of kopia does not support WinFsp or FUSE-T")
+}
@@ -4,6 +4,7 @@
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
 
@@ -23,6 +24,25 @@ func (r *root) Root() (fusefs.Node, error) {
 	return r.Node, nil
 }
 
+// toFuseTMountOptions returns options of FUSE-T, which accepts the same options as libfuse.
+func (mo *Options) toFuseTMountOptions() []string {
+	options := []string{"-o", "ro,fsname=kopia,volname=Kopia"}
+
+	if mo.FuseAllowOther {
+		options = append(options, "-o", "allow_other")
+	}
+
+	if mo.FuseAttrTTL > 0 {
+		options = append(options, "-o", fmt.Sprintf("attr_timeout=%v", mo.FuseAttrTTL.Seconds()))
+	}
+
+	if mo.FuseEntryTTL > 0 {
+		options = append(options, "-o", fmt.Sprintf("entry_timeout=%v", mo.FuseEntryTTL.Seconds()))
+	}
+
+	return options
+}
+
 func (mo *Options) toFuseMountOptions() []fuse.MountOption {
 	options := []fuse.MountOption{
 		fuse.ReadOnly(),
@@ -42,22 +62,42 @@ func (mo *Options) toFuseMountOptions() []fuse.MountOption {
 	return options
 }
 
-// Directory mounts the given directory using FUSE.
-func Directory(ctx context.Context, entry fs.Directory, mountPoint string, mountOptions Options) (Controller, error) {
-	isTempDir := false
+// resolveMountPoint returns the provided mount point or creates a temporary directory if it's "*".
+func resolveMountPoint(mountPoint string) (actualMountPoint string, isTempDir bool, err error) {
+	if mountPoint != "*" {
+		return mountPoint, false, nil
+	}
 
-	if mountPoint == "*" {
-		var err error
+	mountPoint, err = ioutil.TempDir("", "kopia-mount")
+	if err != nil {
+		return "", false, err
+	}
 
-		mountPoint, err = ioutil.TempDir("", "kopia-mount")
-		if err != nil {
-			return nil, err
-		}
+	return mountPoint, true, nil
+}
 
-		isTempDir = true
+// Directory mounts the given directory using FUSE, or using FUSE-T or operating system WebDAV client
+// if FUSE is not available.
+func Directory(ctx context.Context, entry fs.Directory, mountPoint string, mountOptions Options) (Controller, error) {
+	if mountOptions.PreferWebDAV {
+		return directoryWebDAVMounted(ctx, entry, mountPoint)
 	}
 
-	rootNode := fusemount.NewDirectoryNode(entry)
+	if err := checkFuseAvailable(); err != nil {
+		return directoryWithoutFuse(ctx, entry, mountPoint, mountOptions, err)
+	}
+
+	mountPoint, isTempDir, err := resolveMountPoint(mountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	rootNode := fusemount.NewDirectoryNode(entry, fusemount.Options{
+		AttrTTL:          mountOptions.FuseAttrTTL,
+		EntryTTL:         mountOptions.FuseEntryTTL,
+		ReadAheadSize:    mountOptions.FuseReadAheadSize,
+		MaxCachedEntries: mountOptions.FuseMaxCachedEntries,
+	})
 
 	options := append(
 		mountOptions.toFuseMountOptions(),
@@ -125,3 +165,96 @@ func (fc fuseController) Unmount(ctx context.Context) error {
 func (fc fuseController) Done() <-chan struct{} {
 	return fc.done
 }
+
+// directoryWithoutFuse mounts the given directory using FUSE-T, which does not require kernel extensions on macOS,
+// or using operating system WebDAV client, whichever is available.
+func directoryWithoutFuse(ctx context.Context, entry fs.Directory, mountPoint string, mountOptions Options, fuseErr error) (Controller, error) {
+	fuseTErr := checkFuseTAvailable()
+	if fuseTErr == nil {
+		log(ctx).Infof("FUSE is not available (%v), using FUSE-T.", fuseErr)
+
+		return directoryFuseT(ctx, entry, mountPoint, mountOptions)
+	}
+
+	if !webdavMountSupported {
+		return nil, errors.Wrap(fuseErr, "FUSE is not available")
+	}
+
+	log(ctx).Infof("FUSE is not available (%v, %v), falling back to WebDAV.", fuseErr, fuseTErr)
+
+	return directoryWebDAVMounted(ctx, entry, mountPoint)
+}
+
+func directoryFuseT(ctx context.Context, entry fs.Directory, mountPoint string, mountOptions Options) (Controller, error) {
+	mountPoint, isTempDir, err := resolveMountPoint(mountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := mountCgofuse(ctx, entry, mountPoint, isTempDir, mountOptions.toFuseTMountOptions())
+	if err != nil {
+		if isTempDir {
+			os.Remove(mountPoint) //nolint:errcheck
+		}
+
+		return nil, errors.Wrap(err, "unable to mount using FUSE-T")
+	}
+
+	return c, nil
+}
+
+// directoryWebDAVMounted exposes the provided directory over WebDAV and mounts it
+// using operating system WebDAV client, which does not require FUSE.
+func directoryWebDAVMounted(ctx context.Context, entry fs.Directory, mountPoint string) (Controller, error) {
+	mountPoint, isTempDir, err := resolveMountPoint(mountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := DirectoryWebDAV(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mountWebDAV(ctx, c.MountPath(), mountPoint); err != nil {
+		if uerr := c.Unmount(ctx); uerr != nil {
+			log(ctx).Warningf("unable to stop webdav server: %v", uerr)
+		}
+
+		if isTempDir {
+			os.Remove(mountPoint) //nolint:errcheck
+		}
+
+		return nil, errors.Wrap(err, "unable to mount webdav server")
+	}
+
+	return webdavMountController{c, mountPoint, isTempDir}, nil
+}
+
+type webdavMountController struct {
+	inner      Controller
+	mountPoint string
+	isTempDir  bool
+}
+
+func (c webdavMountController) MountPath() string {
+	return c.mountPoint
+}
+
+func (c webdavMountController) Unmount(ctx context.Context) error {
+	if err := unmountWebDAV(ctx, c.mountPoint); err != nil {
+		return errors.Wrap(err, "unmount error")
+	}
+
+	if c.isTempDir {
+		if err := os.Remove(c.mountPoint); err != nil {
+			return errors.Wrap(err, "unable to remove temporary mount point")
+		}
+	}
+
+	return c.inner.Unmount(ctx)
+}
+
+func (c webdavMountController) Done() <-chan struct{} {
+	return c.inner.Done()
+}
@@ -0,0 +1,47 @@
+// +build !windows
+
+package mount
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+func TestFuseTMountOptions(t *testing.T) {
+	cases := []struct {
+		opts Options
+		want []string
+	}{
+		{Options{}, []string{"-o", "ro,fsname=kopia,volname=Kopia"}},
+		{
+			Options{FuseAllowOther: true, FuseAttrTTL: 90 * time.Second, FuseEntryTTL: 1500 * time.Millisecond},
+			[]string{"-o", "ro,fsname=kopia,volname=Kopia", "-o", "allow_other", "-o", "attr_timeout=90", "-o", "entry_timeout=1.5"},
+		},
+	}
+
+	for _, tc := range cases {
+		if got := tc.opts.toFuseTMountOptions(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("unexpected options for %+v: %v, want %v", tc.opts, got, tc.want)
+		}
+	}
+}
+
+func TestDirectoryWithoutFuse(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("FUSE-T and WebDAV may be available on macOS")
+	}
+
+	ctx := testlogging.Context(t)
+	fuseErr := errors.New("no fuse")
+
+	if _, err := directoryWithoutFuse(ctx, mockfs.NewDirectory(), "*", Options{}, fuseErr); !errors.Is(err, fuseErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
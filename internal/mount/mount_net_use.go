@@ -13,12 +13,22 @@
 	"github.com/kopia/kopia/fs"
 )
 
-// Directory mounts a given directory under a provided drive letter.
-func Directory(ctx context.Context, entry fs.Directory, driveLetter string, _ Options) (Controller, error) {
+// Directory mounts a given directory under a provided drive letter using WinFsp or using
+// operating system WebDAV client if WinFsp is not available.
+func Directory(ctx context.Context, entry fs.Directory, driveLetter string, mountOptions Options) (Controller, error) {
 	if !isValidWindowsDriveOrAsterisk(driveLetter) {
 		return nil, errors.Errorf("must be a valid drive letter or asteris")
 	}
 
+	if !mountOptions.PreferWebDAV {
+		err := checkWinFspAvailable()
+		if err == nil {
+			return directoryWinFsp(ctx, entry, driveLetter, mountOptions)
+		}
+
+		log(ctx).Infof("WinFsp is not available (%v), falling back to WebDAV.", err)
+	}
+
 	c, err := DirectoryWebDAV(ctx, entry)
 	if err != nil {
 		return nil, err
@@ -49,7 +59,7 @@ func netUse(ctx context.Context, args ...string) (string, error) {
 func netUseMount(ctx context.Context, driveLetter, webdavURL string) (string, error) {
 	out, err := netUse(ctx, driveLetter, webdavURL)
 	if err != nil {
-		return "", errors.Wrapf(err, "unable to run 'net use' (%v)", out)
+		return "", errors.Wrapf(err, "unable to run 'net use' (%v), make sure that 'WebClient' service is running", out)
 	}
 
 	if driveLetter != "*" {
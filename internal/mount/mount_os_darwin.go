@@ -0,0 +1,61 @@
+package mount
+
+import (
+	"context"
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// webdavMountSupported indicates whether mounting WebDAV without additional software is supported.
+const webdavMountSupported = true
+
+// macFUSE (formerly OSXFUSE) installation locations, FUSE requires kernel extension on macOS.
+var macFuseFilesystems = []string{
+	"/Library/Filesystems/macfuse.fs",
+	"/Library/Filesystems/osxfuse.fs",
+}
+
+func checkFuseAvailable() error {
+	for _, p := range macFuseFilesystems {
+		if _, err := os.Stat(p); err == nil {
+			return nil
+		}
+	}
+
+	return errors.New("macFUSE is not installed, see https://osxfuse.github.io/")
+}
+
+// fuseTLibrary is the location of FUSE-T library, FUSE-T implements FUSE without kernel extensions.
+const fuseTLibrary = "/usr/local/lib/libfuse-t.dylib"
+
+func checkFuseTAvailable() error {
+	if _, err := os.Stat(fuseTLibrary); err != nil {
+		return errors.New("FUSE-T is not installed, see https://www.fuse-t.org/")
+	}
+
+	if !cgofuseSupported {
+		return errors.New("FUSE-T is installed, but this build of kopia does not support it, build kopia using CGO_ENABLED=1 and '-tags fuset'")
+	}
+
+	return nil
+}
+
+func mountWebDAV(ctx context.Context, webdavURL, mountPoint string) error {
+	out, err := exec.CommandContext(ctx, "mount_webdav", "-S", "-v", "Kopia", webdavURL, mountPoint).CombinedOutput() //nolint:gosec
+	if err != nil {
+		return errors.Wrapf(err, "mount_webdav failed (%s)", out)
+	}
+
+	return nil
+}
+
+func unmountWebDAV(ctx context.Context, mountPoint string) error {
+	out, err := exec.CommandContext(ctx, "umount", mountPoint).CombinedOutput() //nolint:gosec
+	if err != nil {
+		return errors.Wrapf(err, "umount failed (%s)", out)
+	}
+
+	return nil
+}
@@ -0,0 +1,33 @@
+// +build !windows,!darwin
+
+package mount
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// webdavMountSupported indicates whether mounting WebDAV without additional software is supported.
+const webdavMountSupported = false
+
+func checkFuseAvailable() error {
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		return errors.New("/dev/fuse not found, install FUSE using your system package manager (e.g. 'fuse' package) and make sure 'fuse' kernel module is loaded")
+	}
+
+	return nil
+}
+
+func checkFuseTAvailable() error {
+	return errors.New("FUSE-T is only supported on macOS")
+}
+
+func mountWebDAV(ctx context.Context, webdavURL, mountPoint string) error {
+	return errors.New("mounting WebDAV is not supported on this operating system, install FUSE instead")
+}
+
+func unmountWebDAV(ctx context.Context, mountPoint string) error {
+	return nil
+}
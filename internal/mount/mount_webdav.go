@@ -58,6 +58,7 @@ func DirectoryWebDAV(ctx context.Context, entry fs.Directory) (Controller, error
 
 	go func() {
 		log(ctx).Debugf("web server finished with %v", srv.Serve(l))
+		close(done)
 	}()
 
 	return webdavController{"http://" + l.Addr().String(), srv, done}, nil
@@ -0,0 +1,98 @@
+// +build windows
+
+package mount
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+
+	"github.com/kopia/kopia/fs"
+)
+
+var winfspDLLNames = map[string]string{
+	"amd64": "winfsp-x64.dll",
+	"386":   "winfsp-x86.dll",
+	"arm64": "winfsp-a64.dll",
+}
+
+// checkWinFspAvailable returns an error if WinFsp is not installed, the location of WinFsp is determined
+// the same way cgofuse does, which would otherwise panic when mounting.
+func checkWinFspAvailable() error {
+	if !cgofuseSupported {
+		return errors.New("this build of kopia does not support WinFsp, build kopia using CGO_ENABLED=0")
+	}
+
+	dllName := winfspDLLNames[runtime.GOARCH]
+	if dllName == "" {
+		return errors.Errorf("WinFsp is not supported on %v", runtime.GOARCH)
+	}
+
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\WinFsp`, registry.QUERY_VALUE|registry.WOW64_32KEY)
+	if err != nil {
+		return errors.New("WinFsp is not installed, see https://winfsp.dev/")
+	}
+
+	defer k.Close() //nolint:errcheck
+
+	installDir, _, err := k.GetStringValue("InstallDir")
+	if err != nil {
+		return errors.Wrap(err, "unable to determine WinFsp installation directory")
+	}
+
+	if _, err := os.Stat(filepath.Join(installDir, "bin", dllName)); err != nil {
+		return errors.Wrap(err, "WinFsp installation is incomplete")
+	}
+
+	return nil
+}
+
+// toWinFspMountOptions returns options of WinFsp, which makes the current user the owner of all files.
+func (mo *Options) toWinFspMountOptions() []string {
+	options := []string{"-o", "uid=-1,gid=-1,volname=Kopia,FileSystemName=kopia"}
+
+	if mo.FuseAttrTTL > 0 {
+		options = append(options, "-o", fmt.Sprintf("FileInfoTimeout=%v", mo.FuseAttrTTL.Milliseconds()))
+	}
+
+	return options
+}
+
+// freeDriveLetter returns the last drive letter that is not in use, which is what 'net use *' picks.
+func freeDriveLetter() (string, error) {
+	used, err := windows.GetLogicalDrives()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to list drives")
+	}
+
+	for d := 'Z'; d >= 'D'; d-- {
+		if used&(1<<uint(d-'A')) == 0 {
+			return string(d) + ":", nil
+		}
+	}
+
+	return "", errors.New("no drive letters available")
+}
+
+func directoryWinFsp(ctx context.Context, entry fs.Directory, driveLetter string, mountOptions Options) (Controller, error) {
+	if driveLetter == "*" {
+		var err error
+
+		if driveLetter, err = freeDriveLetter(); err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := mountCgofuse(ctx, entry, driveLetter, false, mountOptions.toWinFspMountOptions())
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to mount using WinFsp")
+	}
+
+	return c, nil
+}
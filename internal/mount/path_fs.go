@@ -0,0 +1,172 @@
+package mount
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+)
+
+var errNotDirectory = errors.New("not a directory")
+
+// pathFS provides read-only access to a directory using slash-separated paths relative to its root,
+// which is how path-based FUSE implementations, such as WinFsp and FUSE-T, refer to files.
+type pathFS struct {
+	root fs.Directory
+
+	mu         sync.Mutex
+	nextHandle uint64
+	handles    map[uint64]*pathFSHandle
+}
+
+// pathFSHandle is a file opened by pathFS, reads of the same handle may be issued concurrently.
+type pathFSHandle struct {
+	mu     sync.Mutex
+	reader fs.Reader
+}
+
+func newPathFS(root fs.Directory) *pathFS {
+	return &pathFS{
+		root:    root,
+		handles: map[uint64]*pathFSHandle{},
+	}
+}
+
+// lookup returns the entry with the provided path.
+func (p *pathFS) lookup(ctx context.Context, pathname string) (fs.Entry, error) {
+	var e fs.Entry = p.root
+
+	for _, name := range strings.Split(pathname, "/") {
+		if name == "" {
+			continue
+		}
+
+		dir, ok := e.(fs.Directory)
+		if !ok {
+			return nil, errNotDirectory
+		}
+
+		child, err := dir.Child(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		e = child
+	}
+
+	return e, nil
+}
+
+// readdir returns entries of the directory with the provided path.
+func (p *pathFS) readdir(ctx context.Context, pathname string) (fs.Entries, error) {
+	e, err := p.lookup(ctx, pathname)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, ok := e.(fs.Directory)
+	if !ok {
+		return nil, errNotDirectory
+	}
+
+	return dir.Readdir(ctx)
+}
+
+// readlink returns the target of the symbolic link with the provided path.
+func (p *pathFS) readlink(ctx context.Context, pathname string) (string, error) {
+	e, err := p.lookup(ctx, pathname)
+	if err != nil {
+		return "", err
+	}
+
+	sl, ok := e.(fs.Symlink)
+	if !ok {
+		return "", errors.Errorf("not a symbolic link: %v", pathname)
+	}
+
+	return sl.Readlink(ctx)
+}
+
+// open opens the file with the provided path and returns its handle.
+func (p *pathFS) open(ctx context.Context, pathname string) (uint64, error) {
+	e, err := p.lookup(ctx, pathname)
+	if err != nil {
+		return 0, err
+	}
+
+	f, ok := e.(fs.File)
+	if !ok {
+		return 0, errors.Errorf("not a file: %v", pathname)
+	}
+
+	r, err := f.Open(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nextHandle++
+	p.handles[p.nextHandle] = &pathFSHandle{reader: r}
+
+	return p.nextHandle, nil
+}
+
+func (p *pathFS) handle(fh uint64) (*pathFSHandle, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h := p.handles[fh]
+	if h == nil {
+		return nil, errors.Errorf("invalid file handle: %v", fh)
+	}
+
+	return h, nil
+}
+
+// read reads data of the open file at the provided offset, returning fewer bytes only at the end of the file.
+func (p *pathFS) read(fh uint64, b []byte, offset int64) (int, error) {
+	h, err := p.handle(fh)
+	if err != nil {
+		return 0, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, err := h.reader.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(h.reader, b)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// release closes the open file.
+func (p *pathFS) release(fh uint64) error {
+	p.mu.Lock()
+	h := p.handles[fh]
+	delete(p.handles, fh)
+	p.mu.Unlock()
+
+	if h == nil {
+		return errors.Errorf("invalid file handle: %v", fh)
+	}
+
+	return h.reader.Close()
+}
+
+// isNotExist returns true if the error returned by pathFS indicates that the entry does not exist.
+func isNotExist(err error) bool {
+	return errors.Is(err, fs.ErrEntryNotFound) || os.IsNotExist(err)
+}
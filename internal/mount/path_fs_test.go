@@ -0,0 +1,102 @@
+package mount
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+func TestPathFS(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	root := mockfs.NewDirectory()
+	root.AddDir("dir1", 0o755)
+	root.AddFile("dir1/file1", []byte("hello, world"), 0o644)
+	root.AddDir("dir1/dir2", 0o755)
+	root.AddSymlink("dir1/link1", "file1", 0o777)
+
+	p := newPathFS(root)
+
+	for _, pathname := range []string{"/", "", "/dir1", "/dir1/", "dir1/dir2", "/dir1/file1"} {
+		if _, err := p.lookup(ctx, pathname); err != nil {
+			t.Errorf("unable to look up %q: %v", pathname, err)
+		}
+	}
+
+	if _, err := p.lookup(ctx, "/dir1/no-such-file"); !isNotExist(err) {
+		t.Errorf("unexpected error looking up missing file: %v", err)
+	}
+
+	if _, err := p.lookup(ctx, "/dir1/file1/x"); !errors.Is(err, errNotDirectory) {
+		t.Errorf("unexpected error looking up child of a file: %v", err)
+	}
+
+	entries, err := p.readdir(ctx, "/dir1")
+	if err != nil {
+		t.Fatalf("readdir error: %v", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+
+	if got, want := len(names), 3; got != want {
+		t.Errorf("unexpected entries: %v", names)
+	}
+
+	if _, err := p.readdir(ctx, "/dir1/file1"); !errors.Is(err, errNotDirectory) {
+		t.Errorf("unexpected error listing a file: %v", err)
+	}
+
+	target, err := p.readlink(ctx, "/dir1/link1")
+	if err != nil || target != "file1" {
+		t.Errorf("unexpected symlink target %q: %v", target, err)
+	}
+
+	if _, err := p.open(ctx, "/dir1/dir2"); err == nil {
+		t.Errorf("unexpected success opening a directory")
+	}
+
+	fh, err := p.open(ctx, "/dir1/file1")
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+
+	buf := make([]byte, 5)
+
+	for _, tc := range []struct {
+		offset int64
+		want   string
+	}{
+		{7, "world"},
+		{0, "hello"},
+		{10, "ld"},
+		{20, ""},
+	} {
+		n, err := p.read(fh, buf, tc.offset)
+		if err != nil {
+			t.Fatalf("read error at %v: %v", tc.offset, err)
+		}
+
+		if got := buf[0:n]; !bytes.Equal(got, []byte(tc.want)) {
+			t.Errorf("unexpected data at %v: %q, want %q", tc.offset, got, tc.want)
+		}
+	}
+
+	if err := p.release(fh); err != nil {
+		t.Fatalf("release error: %v", err)
+	}
+
+	if _, err := p.read(fh, buf, 0); err == nil {
+		t.Errorf("unexpected success reading released handle")
+	}
+
+	if err := p.release(fh); err == nil {
+		t.Errorf("unexpected success releasing handle twice")
+	}
+}
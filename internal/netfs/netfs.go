@@ -0,0 +1,40 @@
+// Package netfs detects directories located on network filesystems.
+package netfs
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Info describes the filesystem a directory is located on.
+type Info struct {
+	// Network is true if the filesystem is provided by a remote server, such as NFS or SMB.
+	Network bool
+
+	// Type is the name of the filesystem type if known.
+	Type string
+}
+
+// Detect returns information about the filesystem the provided path is located on. If the path does not
+// exist yet, its nearest existing parent directory is examined.
+func Detect(path string) (Info, error) {
+	p, err := filepath.Abs(path)
+	if err != nil {
+		return Info{}, errors.Wrap(err, "unable to determine absolute path")
+	}
+
+	for {
+		if _, err := os.Stat(p); err == nil {
+			return detect(p)
+		}
+
+		parent := filepath.Dir(p)
+		if parent == p {
+			return Info{}, errors.Errorf("no existing parent directory of %v", path)
+		}
+
+		p = parent
+	}
+}
@@ -0,0 +1,36 @@
+package netfs
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+var networkFilesystemTypes = map[string]bool{
+	"nfs":    true,
+	"smbfs":  true,
+	"afpfs":  true,
+	"webdav": true,
+	"cifs":   true,
+}
+
+func detect(path string) (Info, error) {
+	var st unix.Statfs_t
+
+	if err := unix.Statfs(path, &st); err != nil {
+		return Info{}, errors.Wrap(err, "statfs")
+	}
+
+	var name []byte
+
+	for _, c := range st.Fstypename {
+		if c == 0 {
+			break
+		}
+
+		name = append(name, byte(c))
+	}
+
+	t := string(name)
+
+	return Info{Network: networkFilesystemTypes[t], Type: t}, nil
+}
@@ -0,0 +1,34 @@
+package netfs
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Filesystem magic numbers of network filesystems, see statfs(2).
+var networkFilesystemTypes = map[uint32]string{
+	unix.NFS_SUPER_MAGIC: "nfs",
+	unix.SMB_SUPER_MAGIC: "smb",
+	0xFF534D42:           "cifs",
+	0xFE534D42:           "smb2",
+	0x564c:               "ncp",
+	0x6B414653:           "afs",
+	0x73757245:           "coda",
+	0x19830326:           "fhgfs",
+	0x47504653:           "gpfs",
+	0x0BD00BD0:           "lustre",
+	0x00C36400:           "ceph",
+}
+
+func detect(path string) (Info, error) {
+	var st unix.Statfs_t
+
+	if err := unix.Statfs(path, &st); err != nil {
+		return Info{}, errors.Wrap(err, "statfs")
+	}
+
+	// the type of the field varies by architecture, on 32-bit platforms magic numbers may be negative.
+	t, ok := networkFilesystemTypes[uint32(st.Type)] //nolint:unconvert
+
+	return Info{Network: ok, Type: t}, nil
+}
@@ -0,0 +1,8 @@
+// +build !linux,!darwin,!windows
+
+package netfs
+
+// network filesystems are not detected on other platforms.
+func detect(path string) (Info, error) {
+	return Info{}, nil
+}
@@ -0,0 +1,24 @@
+package netfs
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectNonExistentPath(t *testing.T) {
+	dir := t.TempDir()
+
+	want, err := Detect(dir)
+	if err != nil {
+		t.Fatalf("unable to detect filesystem of %v: %v", dir, err)
+	}
+
+	got, err := Detect(filepath.Join(dir, "no-such-dir", "subdir"))
+	if err != nil {
+		t.Fatalf("unable to detect filesystem of non-existent directory: %v", err)
+	}
+
+	if got != want {
+		t.Errorf("unexpected filesystem of non-existent directory: %+v, want %+v", got, want)
+	}
+}
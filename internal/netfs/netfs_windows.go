@@ -0,0 +1,32 @@
+package netfs
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+)
+
+func detect(path string) (Info, error) {
+	// UNC paths always refer to network shares.
+	if strings.HasPrefix(path, `\\`) && !strings.HasPrefix(path, `\\?\`) {
+		return Info{Network: true, Type: "smb"}, nil
+	}
+
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return Info{}, errors.Wrap(err, "invalid path")
+	}
+
+	var volume [windows.MAX_PATH + 1]uint16
+
+	if err := windows.GetVolumePathName(p, &volume[0], uint32(len(volume))); err != nil {
+		return Info{}, errors.Wrap(err, "unable to get volume path")
+	}
+
+	if windows.GetDriveType(&volume[0]) == windows.DRIVE_REMOTE {
+		return Info{Network: true, Type: "smb"}, nil
+	}
+
+	return Info{}, nil
+}
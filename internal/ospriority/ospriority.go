@@ -0,0 +1,169 @@
+// Package ospriority lowers CPU and IO priority of the current process.
+package ospriority
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// IOClass represents IO priority class.
+type IOClass string
+
+// Supported IO priority classes.
+const (
+	IONormal IOClass = "normal"
+	IOLow    IOClass = "low"
+	IOIdle   IOClass = "idle"
+)
+
+// maxNiceness is the largest (least favorable) Unix niceness.
+const maxNiceness = 19
+
+// Settings describes the desired priority of the process.
+type Settings struct {
+	// Niceness is Unix-style niceness between 0 (normal) and 19 (lowest priority).
+	Niceness int
+
+	// IOClass is the IO priority class.
+	IOClass IOClass
+}
+
+// IsDefault returns true if the settings don't lower the priority of the process.
+func (s Settings) IsDefault() bool {
+	return s.Niceness == 0 && (s.IOClass == "" || s.IOClass == IONormal)
+}
+
+// Validate validates the settings.
+func (s Settings) Validate() error {
+	if s.Niceness < 0 || s.Niceness > maxNiceness {
+		return errors.Errorf("niceness must be between 0 and %v", maxNiceness)
+	}
+
+	switch s.IOClass {
+	case "", IONormal, IOLow, IOIdle:
+		return nil
+	default:
+		return errors.Errorf("unsupported IO priority class: %q", s.IOClass)
+	}
+}
+
+// lowerProcess lowers the priority of the process and returns a function that restores it, replaced in tests.
+var lowerProcess = lower
+
+// active holds settings of callers of Lower which have not restored the priority yet, applied holds
+// the settings currently in effect.
+var (
+	activeMu       sync.Mutex
+	active         = map[int]Settings{}
+	nextActiveID   int
+	applied        Settings
+	restoreApplied func() error
+)
+
+// Lower lowers the priority of the current process according to the provided settings and
+// returns a function that attempts to restore the previous priority.
+//
+// Priority is a property of the entire process, so while multiple callers are active it is lowered
+// only as much as the least restrictive of their settings.
+// Restoring the original priority may not be permitted by the operating system for unprivileged users.
+func Lower(s Settings) (restore func() error, err error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	activeMu.Lock()
+	defer activeMu.Unlock()
+
+	id := nextActiveID
+	nextActiveID++
+
+	active[id] = s
+
+	if err := applyEffectiveLocked(); err != nil {
+		delete(active, id)
+		return nil, err
+	}
+
+	return func() error {
+		activeMu.Lock()
+		defer activeMu.Unlock()
+
+		if _, ok := active[id]; !ok {
+			return nil
+		}
+
+		delete(active, id)
+
+		return applyEffectiveLocked()
+	}, nil
+}
+
+// effectiveLocked returns the least restrictive of the settings of all active callers.
+func effectiveLocked() Settings {
+	var (
+		result Settings
+		first  = true
+	)
+
+	for _, s := range active {
+		if first || s.Niceness < result.Niceness {
+			result.Niceness = s.Niceness
+		}
+
+		if first || ioClassRank(s.IOClass) < ioClassRank(result.IOClass) {
+			result.IOClass = s.IOClass
+		}
+
+		first = false
+	}
+
+	if ioClassRank(result.IOClass) == 0 {
+		result.IOClass = IONormal
+	}
+
+	return result
+}
+
+// ioClassRank orders IO classes from the highest priority to the lowest.
+func ioClassRank(c IOClass) int {
+	switch c {
+	case IOLow:
+		return 1
+	case IOIdle:
+		return 2 //nolint:gomnd
+	default:
+		return 0
+	}
+}
+
+// applyEffectiveLocked changes the priority of the process when effective settings of active callers change.
+func applyEffectiveLocked() error {
+	e := effectiveLocked()
+	if e == applied || (e.IsDefault() && restoreApplied == nil) {
+		return nil
+	}
+
+	if restoreApplied != nil {
+		if err := restoreApplied(); err != nil {
+			return err
+		}
+
+		restoreApplied = nil
+		applied = Settings{}
+	}
+
+	if e.IsDefault() {
+		return nil
+	}
+
+	r, err := lowerProcess(e)
+	if err != nil {
+		return err
+	}
+
+	restoreApplied = r
+	applied = e
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package ospriority
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Darwin-specific setpriority(2) constants which put the process in background state,
+// throttling both its CPU and disk IO.
+const (
+	prioDarwinProcess = 4
+	prioDarwinBG      = 0x1000
+)
+
+func lower(s Settings) (func() error, error) {
+	oldNice, err := unix.Getpriority(unix.PRIO_PROCESS, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get niceness")
+	}
+
+	if s.Niceness > oldNice {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, 0, s.Niceness); err != nil {
+			return nil, errors.Wrap(err, "unable to set niceness")
+		}
+	}
+
+	background := s.IOClass == IOLow || s.IOClass == IOIdle
+	if background {
+		if err := unix.Setpriority(prioDarwinProcess, 0, prioDarwinBG); err != nil {
+			return nil, errors.Wrap(err, "unable to set background IO priority")
+		}
+	}
+
+	return func() error {
+		if background {
+			if err := unix.Setpriority(prioDarwinProcess, 0, 0); err != nil {
+				return errors.Wrap(err, "unable to restore IO priority")
+			}
+		}
+
+		return errors.Wrap(unix.Setpriority(unix.PRIO_PROCESS, 0, oldNice), "unable to restore niceness")
+	}, nil
+}
@@ -0,0 +1,117 @@
+package ospriority
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Linux IO priority constants, see ioprio_set(2).
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+	ioprioLowestBE   = 7
+
+	// getpriority(2) system call returns 20-niceness on Linux.
+	getpriorityOffset = 20
+)
+
+// On Linux both niceness and IO priority are per-thread attributes, so they are applied to
+// all threads of the process. Threads created later inherit them from their creators.
+func lower(s Settings) (func() error, error) {
+	pid := os.Getpid()
+
+	oldNice, err := getNiceness(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	oldIOPrio, err := getIOPriority(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	nice := oldNice
+	if s.Niceness > nice {
+		nice = s.Niceness
+	}
+
+	ioprio := oldIOPrio
+
+	switch s.IOClass {
+	case IOLow:
+		ioprio = ioprioClassBE<<ioprioClassShift | ioprioLowestBE
+	case IOIdle:
+		ioprio = ioprioClassIdle << ioprioClassShift
+	}
+
+	if err := applyToAllThreads(nice, ioprio); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return applyToAllThreads(oldNice, oldIOPrio)
+	}, nil
+}
+
+func applyToAllThreads(nice, ioprio int) error {
+	tids, err := threadIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, tid := range tids {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, tid, nice); err != nil {
+			return errors.Wrap(err, "unable to set niceness")
+		}
+
+		if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(ioprio)); errno != 0 {
+			return errors.Wrap(errno, "unable to set IO priority")
+		}
+	}
+
+	return nil
+}
+
+func getNiceness(tid int) (int, error) {
+	v, err := unix.Getpriority(unix.PRIO_PROCESS, tid)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to get niceness")
+	}
+
+	return getpriorityOffset - v, nil
+}
+
+func getIOPriority(tid int) (int, error) {
+	v, _, errno := unix.Syscall(unix.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(tid), 0)
+	if errno != 0 {
+		return 0, errors.Wrap(errno, "unable to get IO priority")
+	}
+
+	return int(v), nil
+}
+
+func threadIDs() ([]int, error) {
+	entries, err := ioutil.ReadDir("/proc/self/task")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list threads")
+	}
+
+	var result []int
+
+	for _, e := range entries {
+		tid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+
+		result = append(result, tid)
+	}
+
+	return result, nil
+}
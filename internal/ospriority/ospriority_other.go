@@ -0,0 +1,26 @@
+// +build !linux,!darwin,!windows
+
+package ospriority
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+func lower(s Settings) (func() error, error) {
+	oldNice, err := unix.Getpriority(unix.PRIO_PROCESS, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get niceness")
+	}
+
+	if s.Niceness > oldNice {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, 0, s.Niceness); err != nil {
+			return nil, errors.Wrap(err, "unable to set niceness")
+		}
+	}
+
+	// IO priority is not supported on this platform.
+	return func() error {
+		return errors.Wrap(unix.Setpriority(unix.PRIO_PROCESS, 0, oldNice), "unable to restore niceness")
+	}, nil
+}
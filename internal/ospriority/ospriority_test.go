@@ -0,0 +1,117 @@
+package ospriority
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		s       Settings
+		wantErr bool
+	}{
+		{Settings{}, false},
+		{Settings{Niceness: 19, IOClass: IOIdle}, false},
+		{Settings{Niceness: 5, IOClass: IOLow}, false},
+		{Settings{Niceness: -1}, true},
+		{Settings{Niceness: 20}, true},
+		{Settings{IOClass: "realtime"}, true},
+	}
+
+	for _, tc := range cases {
+		if err := tc.s.Validate(); (err != nil) != tc.wantErr {
+			t.Errorf("unexpected validation result for %+v: %v", tc.s, err)
+		}
+	}
+}
+
+func TestLowerDefaultIsNoOp(t *testing.T) {
+	restore, err := Lower(Settings{IOClass: IONormal})
+	if err != nil {
+		t.Fatalf("unable to lower priority: %v", err)
+	}
+
+	if err := restore(); err != nil {
+		t.Fatalf("unable to restore priority: %v", err)
+	}
+
+	if _, err := Lower(Settings{Niceness: 100}); err == nil {
+		t.Fatalf("expected error for invalid settings")
+	}
+}
+
+func TestLowerConcurrentCallers(t *testing.T) {
+	var current Settings
+
+	defer func(old func(s Settings) (func() error, error)) { lowerProcess = old }(lowerProcess)
+
+	lowerProcess = func(s Settings) (func() error, error) {
+		if !current.IsDefault() {
+			t.Fatalf("priority lowered twice: %+v, %+v", current, s)
+		}
+
+		current = s
+
+		return func() error {
+			current = Settings{}
+			return nil
+		}, nil
+	}
+
+	restore1, err := Lower(Settings{Niceness: 10, IOClass: IOIdle})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (Settings{Niceness: 10, IOClass: IOIdle}); current != want {
+		t.Fatalf("unexpected priority: %+v, want %+v", current, want)
+	}
+
+	// concurrent caller with less restrictive settings.
+	restore2, err := Lower(Settings{Niceness: 5, IOClass: IOLow})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (Settings{Niceness: 5, IOClass: IOLow}); current != want {
+		t.Fatalf("unexpected priority: %+v, want %+v", current, want)
+	}
+
+	// concurrent caller which does not want lower priority.
+	restore3, err := Lower(Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !current.IsDefault() {
+		t.Fatalf("priority was not restored: %+v", current)
+	}
+
+	if err := restore3(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (Settings{Niceness: 5, IOClass: IOLow}); current != want {
+		t.Fatalf("unexpected priority: %+v, want %+v", current, want)
+	}
+
+	if err := restore2(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (Settings{Niceness: 10, IOClass: IOIdle}); current != want {
+		t.Fatalf("unexpected priority: %+v, want %+v", current, want)
+	}
+
+	if err := restore1(); err != nil {
+		t.Fatal(err)
+	}
+
+	// restoring twice is a no-op.
+	if err := restore1(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !current.IsDefault() {
+		t.Fatalf("priority was not restored: %+v", current)
+	}
+}
@@ -0,0 +1,50 @@
+package ospriority
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+)
+
+// niceness at or above which the process runs in idle priority class.
+const idleNiceness = 10
+
+func lower(s Settings) (func() error, error) {
+	h, err := windows.GetCurrentProcess()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get current process")
+	}
+
+	oldClass, err := windows.GetPriorityClass(h)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get priority class")
+	}
+
+	switch {
+	case s.Niceness >= idleNiceness:
+		err = windows.SetPriorityClass(h, windows.IDLE_PRIORITY_CLASS)
+	case s.Niceness > 0:
+		err = windows.SetPriorityClass(h, windows.BELOW_NORMAL_PRIORITY_CLASS)
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to set priority class")
+	}
+
+	// background processing mode lowers IO and memory priority of the process.
+	background := s.IOClass == IOLow || s.IOClass == IOIdle
+	if background {
+		if err := windows.SetPriorityClass(h, windows.PROCESS_MODE_BACKGROUND_BEGIN); err != nil {
+			return nil, errors.Wrap(err, "unable to begin background mode")
+		}
+	}
+
+	return func() error {
+		if background {
+			if err := windows.SetPriorityClass(h, windows.PROCESS_MODE_BACKGROUND_END); err != nil {
+				return errors.Wrap(err, "unable to end background mode")
+			}
+		}
+
+		return errors.Wrap(windows.SetPriorityClass(h, oldClass), "unable to restore priority class")
+	}, nil
+}
@@ -0,0 +1,162 @@
+// Package pluginproc implements the process model shared by all kinds of kopia plugins.
+//
+// Plugins are executables started by kopia with a magic cookie in their environment, which lets them
+// detect being launched by kopia. The plugin prints a handshake line with protocol version to stdout
+// and then serves net/rpc requests on stdin/stdout, using stderr for logging. The plugin exits
+// when its stdin is closed.
+package pluginproc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Protocol describes the handshake and RPC service of a particular kind of plugin.
+type Protocol struct {
+	MagicCookieKey   string
+	MagicCookieValue string
+	HandshakePrefix  string
+	Version          int
+	ServiceName      string
+}
+
+// Client is a connection to a running plugin.
+type Client struct {
+	protocol Protocol
+	cmd      *exec.Cmd
+	rpc      *rpc.Client
+}
+
+// Call invokes the provided RPC method and waits for the result or until the context is canceled.
+// Errors returned by the plugin are of type rpc.ServerError.
+func (c *Client) Call(ctx context.Context, method string, args, reply interface{}) error {
+	call := c.rpc.Go(c.protocol.ServiceName+"."+method, args, reply, make(chan *rpc.Call, 1))
+
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close disconnects from the plugin and makes sure it's gone.
+func (c *Client) Close() {
+	if c.rpc != nil {
+		c.rpc.Close() //nolint:errcheck
+	}
+
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill() //nolint:errcheck
+		c.cmd.Wait()         //nolint:errcheck
+	}
+}
+
+// conn combines plugin stdout (read) and stdin (write) into a single connection.
+type conn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// Start starts the plugin and waits for it to complete the handshake.
+func Start(ctx context.Context, p Protocol, executable string, args, env []string, startupTimeout time.Duration) (*Client, error) {
+	// the plugin must not be tied to the context, which may be canceled after the plugin is started.
+	c := &Client{
+		protocol: p,
+		cmd:      exec.Command(executable, args...), //nolint:gosec
+	}
+
+	c.cmd.Env = append(append(os.Environ(), env...), p.MagicCookieKey+"="+p.MagicCookieValue)
+	c.cmd.Stderr = os.Stderr
+
+	stdin, err := c.cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get plugin stdin")
+	}
+
+	stdout, err := c.cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get plugin stdout")
+	}
+
+	if err = c.cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, "unable to start plugin")
+	}
+
+	br := bufio.NewReader(stdout)
+
+	if err = waitForHandshake(p, br, startupTimeout); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	c.rpc = rpc.NewClient(conn{br, stdin})
+
+	return c, nil
+}
+
+// waitForHandshake reads the handshake line printed by the plugin and verifies the protocol version.
+func waitForHandshake(p Protocol, r *bufio.Reader, startupTimeout time.Duration) error {
+	lineChan := make(chan string, 1)
+	errChan := make(chan error, 1)
+
+	go func() {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			errChan <- err
+			return
+		}
+
+		lineChan <- strings.TrimSpace(l)
+	}()
+
+	select {
+	case l := <-lineChan:
+		if !strings.HasPrefix(l, p.HandshakePrefix) {
+			return errors.Errorf("unexpected plugin handshake: %q", l)
+		}
+
+		if v, err := strconv.Atoi(strings.TrimPrefix(l, p.HandshakePrefix)); err != nil || v != p.Version {
+			return errors.Errorf("unsupported plugin protocol version: %q, want %v", l, p.Version)
+		}
+
+		return nil
+
+	case err := <-errChan:
+		return errors.Wrap(err, "plugin exited before handshake")
+
+	case <-time.After(startupTimeout):
+		return errors.Errorf("timed out waiting for plugin to start")
+	}
+}
+
+// Serve implements the plugin side of the protocol by serving the provided RPC receiver on stdin/stdout.
+// It returns when kopia disconnects from the plugin.
+func Serve(p Protocol, rcvr interface{}) error {
+	if os.Getenv(p.MagicCookieKey) != p.MagicCookieValue {
+		return errors.New("this program is a kopia plugin and is not meant to be executed directly")
+	}
+
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(p.ServiceName, rcvr); err != nil {
+		return errors.Wrap(err, "unable to register service")
+	}
+
+	if _, err := fmt.Fprintf(os.Stdout, "%v%v\n", p.HandshakePrefix, p.Version); err != nil {
+		return errors.Wrap(err, "unable to write handshake")
+	}
+
+	srv.ServeConn(conn{os.Stdin, os.Stdout})
+
+	return nil
+}
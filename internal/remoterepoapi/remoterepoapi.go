@@ -3,6 +3,7 @@
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/kopia/kopia/repo/manifest"
 	"github.com/kopia/kopia/repo/object"
@@ -14,6 +15,10 @@ type Parameters struct {
 	HashFunction string `json:"hash"`
 	HMACSecret   []byte `json:"hmacSecret"`
 
+	// UploadJournal is true when the server journals uploaded contents, so that they survive server restart
+	// and writes can be retried when the server can't be reached.
+	UploadJournal bool `json:"uploadJournal,omitempty"`
+
 	object.Format
 }
 
@@ -28,3 +33,27 @@ type ManifestWithMetadata struct {
 	Payload  json.RawMessage         `json:"payload"`
 	Metadata *manifest.EntryMetadata `json:"metadata"`
 }
+
+// ClientInfo describes the client reported to the server when connecting.
+// sent to /api/v1/clients/report.
+type ClientInfo struct {
+	Username       string `json:"username"`
+	Hostname       string `json:"hostname"`
+	Version        string `json:"version"`
+	BuildInfo      string `json:"buildInfo,omitempty"`
+	OS             string `json:"os"`
+	Arch           string `json:"arch"`
+	CacheSizeBytes int64  `json:"cacheSizeBytes"`
+
+	// CacheSizeTime is the time when the cache size was measured, zero if it was not measured for this report.
+	CacheSizeTime time.Time `json:"cacheSizeTime,omitempty"`
+
+	// PolicyProfile is the name of the policy profile adopted by the client, assigned by the server.
+	PolicyProfile string `json:"policyProfile,omitempty"`
+
+	// ClientTime is the time of the client clock when the report was sent.
+	ClientTime time.Time `json:"clientTime,omitempty"`
+
+	// ServerTime is the time of the server clock when the report was received, returned by the server.
+	ServerTime time.Time `json:"serverTime,omitempty"`
+}
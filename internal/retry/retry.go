@@ -62,7 +62,16 @@ func internalRetry(ctx context.Context, desc string, attempt AttemptFunc, isRetr
 		}
 
 		log(ctx).Debugf("got error %v when %v (#%v), sleeping for %v before retrying", err, desc, i, sleepAmount)
-		time.Sleep(sleepAmount)
+
+		t := time.NewTimer(sleepAmount)
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return v, err
+		}
+
 		sleepAmount = time.Duration(float64(sleepAmount) * factor)
 
 		if sleepAmount > max {
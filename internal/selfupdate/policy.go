@@ -0,0 +1,81 @@
+package selfupdate
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/manifest"
+)
+
+// PolicyManifestType is the value of the "type" label for update policy manifests.
+const PolicyManifestType = "updatepolicy"
+
+// Policy is stored in the repository and controls updates of all clients connected to it,
+// which allows administrators of managed fleets to pin the version of Kopia.
+type Policy struct {
+	Channel       string `json:"channel,omitempty"`
+	PinnedVersion string `json:"pinnedVersion,omitempty"`
+}
+
+func policyLabels() map[string]string {
+	return map[string]string{
+		manifest.TypeLabelKey: PolicyManifestType,
+	}
+}
+
+// GetPolicy returns the update policy stored in the repository or an empty policy if none has been set.
+func GetPolicy(ctx context.Context, rep repo.Repository) (*Policy, error) {
+	entries, err := rep.FindManifests(ctx, policyLabels())
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to find update policy manifests")
+	}
+
+	if len(entries) == 0 {
+		return &Policy{}, nil
+	}
+
+	latest := entries[0]
+
+	for _, e := range entries[1:] {
+		if e.ModTime.After(latest.ModTime) {
+			latest = e
+		}
+	}
+
+	p := &Policy{}
+	if _, err := rep.GetManifest(ctx, latest.ID, p); err != nil {
+		return nil, errors.Wrap(err, "unable to load update policy manifest")
+	}
+
+	return p, nil
+}
+
+// SetPolicy replaces the update policy stored in the repository.
+func SetPolicy(ctx context.Context, rep repo.Repository, p *Policy) error {
+	if p.Channel != "" {
+		if err := ValidateChannel(p.Channel); err != nil {
+			return err
+		}
+	}
+
+	entries, err := rep.FindManifests(ctx, policyLabels())
+	if err != nil {
+		return errors.Wrap(err, "unable to find update policy manifests")
+	}
+
+	for _, e := range entries {
+		if err := rep.DeleteManifest(ctx, e.ID); err != nil {
+			return errors.Wrap(err, "unable to delete update policy manifest")
+		}
+	}
+
+	if *p != (Policy{}) {
+		if _, err := rep.PutManifest(ctx, policyLabels(), p); err != nil {
+			return errors.Wrap(err, "unable to save update policy manifest")
+		}
+	}
+
+	return errors.Wrap(rep.Flush(ctx), "unable to flush repository")
+}
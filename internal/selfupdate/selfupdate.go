@@ -0,0 +1,448 @@
+// Package selfupdate implements downloading of signed Kopia releases and atomic replacement
+// of the running executable.
+//
+// Each release publishes checksums.txt, which contains SHA256 checksums of all release archives,
+// and checksums.txt.sig, which is a detached OpenPGP signature of checksums.txt.
+// An archive is only installed after the signature has been verified using a trusted key
+// and the checksum of the downloaded archive matches the signed one.
+package selfupdate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/mod/semver"
+
+	"github.com/kopia/kopia/repo/logging"
+)
+
+var log = logging.GetContextLoggerFunc("kopia/selfupdate")
+
+// Supported release channels.
+const (
+	ChannelStable = "stable"
+	ChannelBeta   = "beta"
+)
+
+const (
+	// DefaultReleasesURL is the GitHub API URL of Kopia releases.
+	DefaultReleasesURL = "https://api.github.com/repos/kopia/kopia/releases"
+
+	// DefaultDownloadURL is the base URL from which release assets are downloaded.
+	DefaultDownloadURL = "https://github.com/kopia/kopia/releases/download"
+
+	checksumsFile          = "checksums.txt"
+	checksumsSignatureFile = "checksums.txt.sig"
+
+	// maxExecutableSize protects against decompression bombs.
+	maxExecutableSize = 1 << 30
+)
+
+// TrustedReleaseKey is the armored OpenPGP public key used to verify release signatures.
+// Official builds set it at link time, other builds must provide the key explicitly.
+var TrustedReleaseKey = ""
+
+// Options provides options for finding and downloading releases.
+type Options struct {
+	ReleasesURL string
+	DownloadURL string
+	HTTPClient  *http.Client
+
+	// TrustedKey is the armored OpenPGP public key ring, defaults to TrustedReleaseKey.
+	TrustedKey string
+}
+
+func (o *Options) releasesURL() string {
+	if o.ReleasesURL != "" {
+		return strings.TrimSuffix(o.ReleasesURL, "/")
+	}
+
+	return DefaultReleasesURL
+}
+
+func (o *Options) downloadURL() string {
+	if o.DownloadURL != "" {
+		return strings.TrimSuffix(o.DownloadURL, "/")
+	}
+
+	return DefaultDownloadURL
+}
+
+func (o *Options) httpClient() *http.Client {
+	if o.HTTPClient != nil {
+		return o.HTTPClient
+	}
+
+	return http.DefaultClient
+}
+
+// Release describes a single release.
+type Release struct {
+	Name       string `json:"name"`
+	TagName    string `json:"tag_name"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+}
+
+// Version returns the version of the release with the 'v' prefix.
+func (r *Release) Version() string {
+	v := r.TagName
+	if v == "" {
+		v = r.Name
+	}
+
+	if strings.HasPrefix(v, "v") {
+		return v
+	}
+
+	return "v" + v
+}
+
+// ValidateChannel returns an error if the provided release channel is not supported.
+func ValidateChannel(channel string) error {
+	switch channel {
+	case ChannelStable, ChannelBeta:
+		return nil
+	default:
+		return errors.Errorf("unsupported release channel %q, must be %q or %q", channel, ChannelStable, ChannelBeta)
+	}
+}
+
+// FindRelease returns the newest release on the provided channel or the pinned version, if provided.
+func FindRelease(ctx context.Context, opt Options, channel, pinnedVersion string) (*Release, error) {
+	if pinnedVersion != "" {
+		var rel Release
+
+		if err := getJSON(ctx, opt.httpClient(), opt.releasesURL()+"/tags/"+ensureVPrefix(pinnedVersion), &rel); err != nil {
+			return nil, errors.Wrapf(err, "unable to find release %v", pinnedVersion)
+		}
+
+		return &rel, nil
+	}
+
+	if err := ValidateChannel(channel); err != nil {
+		return nil, err
+	}
+
+	var releases []*Release
+
+	if err := getJSON(ctx, opt.httpClient(), opt.releasesURL(), &releases); err != nil {
+		return nil, errors.Wrap(err, "unable to list releases")
+	}
+
+	var best *Release
+
+	for _, r := range releases {
+		if r.Draft || !semver.IsValid(r.Version()) {
+			continue
+		}
+
+		if r.Prerelease && channel != ChannelBeta {
+			continue
+		}
+
+		if best == nil || semver.Compare(r.Version(), best.Version()) > 0 {
+			best = r
+		}
+	}
+
+	if best == nil {
+		return nil, errors.Errorf("no releases found on channel %q", channel)
+	}
+
+	return best, nil
+}
+
+// IsNewer returns true if the provided release version is newer than the current one.
+func IsNewer(releaseVersion, currentVersion string) bool {
+	return semver.Compare(ensureVPrefix(releaseVersion), ensureVPrefix(currentVersion)) > 0
+}
+
+// ArchiveName returns the name of the release archive for the provided version and platform.
+func ArchiveName(version, goos, goarch string) string {
+	osName := goos
+	if goos == "darwin" {
+		osName = "macOS"
+	}
+
+	archName := goarch
+	if goarch == "amd64" {
+		archName = "x64"
+	}
+
+	ext := ".tar.gz"
+	if goos == "windows" {
+		ext = ".zip"
+	}
+
+	return fmt.Sprintf("kopia-%v-%v-%v%v", strings.TrimPrefix(version, "v"), osName, archName, ext)
+}
+
+// DownloadExecutable downloads the release archive for the current platform, verifies
+// its signed checksum and returns the contents of the Kopia executable contained in it.
+func DownloadExecutable(ctx context.Context, opt Options, rel *Release) ([]byte, error) {
+	keyring, err := trustedKeyRing(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	base := opt.downloadURL() + "/" + rel.Version() + "/"
+
+	checksums, err := download(ctx, opt.httpClient(), base+checksumsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := download(ctx, opt.httpClient(), base+checksumsSignatureFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := VerifySignature(keyring, checksums, sig); err != nil {
+		return nil, err
+	}
+
+	archiveName := ArchiveName(rel.Version(), runtime.GOOS, runtime.GOARCH)
+
+	expected, err := findChecksum(checksums, archiveName)
+	if err != nil {
+		return nil, err
+	}
+
+	log(ctx).Debugf("downloading %v", archiveName)
+
+	archive, err := download(ctx, opt.httpClient(), base+archiveName)
+	if err != nil {
+		return nil, err
+	}
+
+	if actual := sha256.Sum256(archive); hex.EncodeToString(actual[:]) != expected {
+		return nil, errors.Errorf("checksum mismatch for %v", archiveName)
+	}
+
+	return extractExecutable(archiveName, archive)
+}
+
+func trustedKeyRing(opt Options) (openpgp.EntityList, error) {
+	key := opt.TrustedKey
+	if key == "" {
+		key = TrustedReleaseKey
+	}
+
+	if key == "" {
+		return nil, errors.Errorf("no trusted release signing key available, unable to verify release")
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid trusted release signing key")
+	}
+
+	return keyring, nil
+}
+
+// VerifySignature verifies the detached signature of the provided data.
+// The signature can be either binary or armored.
+func VerifySignature(keyring openpgp.KeyRing, data, sig []byte) error {
+	var err error
+
+	if bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(data), bytes.NewReader(sig))
+	} else {
+		_, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(data), bytes.NewReader(sig))
+	}
+
+	return errors.Wrap(err, "invalid release signature")
+}
+
+// findChecksum returns the hex-encoded SHA256 checksum of the provided file
+// from the contents of checksums.txt as produced by sha256sum.
+func findChecksum(checksums []byte, fname string) (string, error) {
+	s := bufio.NewScanner(bytes.NewReader(checksums))
+
+	for s.Scan() {
+		parts := strings.Fields(s.Text())
+		if len(parts) == 2 && strings.TrimPrefix(parts[1], "*") == fname { //nolint:gomnd
+			return strings.ToLower(parts[0]), nil
+		}
+	}
+
+	return "", errors.Errorf("checksum of %v not found in release", fname)
+}
+
+func extractExecutable(archiveName string, archive []byte) ([]byte, error) {
+	if strings.HasSuffix(archiveName, ".zip") {
+		return extractFromZip(archive, "kopia.exe")
+	}
+
+	return extractFromTarGz(archive, "kopia")
+}
+
+func extractFromTarGz(archive []byte, exeName string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open archive")
+	}
+
+	tr := tar.NewReader(gz)
+
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.Errorf("%v not found in archive", exeName)
+		}
+
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read archive")
+		}
+
+		if h.Typeflag == tar.TypeReg && path.Base(h.Name) == exeName {
+			return readLimited(tr)
+		}
+	}
+}
+
+func extractFromZip(archive []byte, exeName string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open archive")
+	}
+
+	for _, f := range zr.File {
+		if path.Base(f.Name) != exeName {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read archive")
+		}
+		defer r.Close() //nolint:errcheck
+
+		return readLimited(r)
+	}
+
+	return nil, errors.Errorf("%v not found in archive", exeName)
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxExecutableSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to extract executable")
+	}
+
+	if len(b) > maxExecutableSize {
+		return nil, errors.Errorf("executable is too large")
+	}
+
+	return b, nil
+}
+
+// ReplaceExecutable atomically replaces the provided executable file with new contents.
+func ReplaceExecutable(exePath string, contents []byte) error {
+	st, err := os.Stat(exePath)
+	if err != nil {
+		return errors.Wrap(err, "unable to stat executable")
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(exePath), "."+filepath.Base(exePath)+".new")
+	if err != nil {
+		return errors.Wrap(err, "unable to create temporary file")
+	}
+
+	tmpName := f.Name()
+	defer os.Remove(tmpName) //nolint:errcheck
+
+	if _, err := f.Write(contents); err != nil {
+		f.Close() //nolint:errcheck
+		return errors.Wrap(err, "unable to write new executable")
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close() //nolint:errcheck
+		return errors.Wrap(err, "unable to write new executable")
+	}
+
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "unable to write new executable")
+	}
+
+	if err := os.Chmod(tmpName, st.Mode().Perm()); err != nil {
+		return errors.Wrap(err, "unable to set permissions")
+	}
+
+	if runtime.GOOS == "windows" {
+		// running executables can't be overwritten on Windows, but they can be renamed.
+		oldName := exePath + ".old"
+		os.Remove(oldName) //nolint:errcheck
+
+		if err := os.Rename(exePath, oldName); err != nil {
+			return errors.Wrap(err, "unable to move old executable")
+		}
+
+		if err := os.Rename(tmpName, exePath); err != nil {
+			os.Rename(oldName, exePath) //nolint:errcheck
+			return errors.Wrap(err, "unable to install new executable")
+		}
+
+		return nil
+	}
+
+	return errors.Wrap(os.Rename(tmpName, exePath), "unable to install new executable")
+}
+
+func getJSON(ctx context.Context, cli *http.Client, url string, result interface{}) error {
+	b, err := download(ctx, cli, url)
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(json.Unmarshal(b, result), "invalid response")
+}
+
+func download(ctx context.Context, cli *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to download %v", url)
+	}
+
+	resp, err := cli.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to download %v", url)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("invalid status code %v when downloading %v", resp.StatusCode, url)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+
+	return b, errors.Wrapf(err, "unable to download %v", url)
+}
+
+func ensureVPrefix(s string) string {
+	if strings.HasPrefix(s, "v") {
+		return s
+	}
+
+	return "v" + s
+}
@@ -0,0 +1,229 @@
+package selfupdate_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/selfupdate"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+const releasesJSON = `[
+	{"name":"v0.9.0-rc1","tag_name":"v0.9.0-rc1","prerelease":true},
+	{"name":"v0.8.1","tag_name":"v0.8.1"},
+	{"name":"v1.0.0","tag_name":"v1.0.0","draft":true},
+	{"name":"v0.8.0","tag_name":"v0.8.0"}
+]`
+
+func TestArchiveName(t *testing.T) {
+	require.Equal(t, "kopia-0.8.1-linux-x64.tar.gz", selfupdate.ArchiveName("v0.8.1", "linux", "amd64"))
+	require.Equal(t, "kopia-0.8.1-macOS-arm64.tar.gz", selfupdate.ArchiveName("0.8.1", "darwin", "arm64"))
+	require.Equal(t, "kopia-0.8.1-windows-x64.zip", selfupdate.ArchiveName("v0.8.1", "windows", "amd64"))
+}
+
+func TestFindRelease(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/releases":
+			fmt.Fprint(w, releasesJSON)
+		case "/releases/tags/v0.8.0":
+			fmt.Fprint(w, `{"name":"v0.8.0","tag_name":"v0.8.0"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	opt := selfupdate.Options{ReleasesURL: srv.URL + "/releases"}
+
+	rel, err := selfupdate.FindRelease(ctx, opt, selfupdate.ChannelStable, "")
+	require.NoError(t, err)
+	require.Equal(t, "v0.8.1", rel.Version())
+
+	rel, err = selfupdate.FindRelease(ctx, opt, selfupdate.ChannelBeta, "")
+	require.NoError(t, err)
+	require.Equal(t, "v0.9.0-rc1", rel.Version())
+
+	rel, err = selfupdate.FindRelease(ctx, opt, selfupdate.ChannelStable, "0.8.0")
+	require.NoError(t, err)
+	require.Equal(t, "v0.8.0", rel.Version())
+
+	_, err = selfupdate.FindRelease(ctx, opt, selfupdate.ChannelStable, "v0.7.0")
+	require.Error(t, err)
+
+	_, err = selfupdate.FindRelease(ctx, opt, "nightly", "")
+	require.Error(t, err)
+
+	require.True(t, selfupdate.IsNewer("v0.8.1", "0.8.0"))
+	require.False(t, selfupdate.IsNewer("v0.8.0", "v0.8.0"))
+}
+
+// nolint:funlen
+func TestDownloadExecutable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test archive is only built for tar.gz platforms")
+	}
+
+	ctx := testlogging.Context(t)
+
+	signer, err := openpgp.NewEntity("Kopia Builder", "", "builder@example.com", nil)
+	require.NoError(t, err)
+
+	otherSigner, err := openpgp.NewEntity("Someone Else", "", "else@example.com", nil)
+	require.NoError(t, err)
+
+	exeContents := []byte("#!/bin/sh\necho new version\n")
+	archiveName := selfupdate.ArchiveName("v0.8.1", runtime.GOOS, runtime.GOARCH)
+	archive := makeTarGz(t, "kopia-0.8.1/kopia", exeContents)
+	sum := sha256.Sum256(archive)
+	checksums := []byte(hex.EncodeToString(sum[:]) + "  " + archiveName + "\n")
+
+	files := map[string][]byte{
+		"/download/v0.8.1/" + archiveName:         archive,
+		"/download/v0.8.1/checksums.txt":          checksums,
+		"/download/v0.8.1/checksums.txt.sig":      detachSign(t, signer, checksums),
+		"/download/v0.8.1/checksums.txt.sig.evil": detachSign(t, otherSigner, checksums),
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write(b) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	rel := &selfupdate.Release{Name: "v0.8.1", TagName: "v0.8.1"}
+	opt := selfupdate.Options{
+		DownloadURL: srv.URL + "/download",
+		TrustedKey:  armoredPublicKey(t, signer),
+	}
+
+	got, err := selfupdate.DownloadExecutable(ctx, opt, rel)
+	require.NoError(t, err)
+	require.Equal(t, exeContents, got)
+
+	// no trusted key.
+	_, err = selfupdate.DownloadExecutable(ctx, selfupdate.Options{DownloadURL: opt.DownloadURL}, rel)
+	require.Error(t, err)
+
+	// signed by untrusted key.
+	files["/download/v0.8.1/checksums.txt.sig"] = files["/download/v0.8.1/checksums.txt.sig.evil"]
+	_, err = selfupdate.DownloadExecutable(ctx, opt, rel)
+	require.Error(t, err)
+
+	// tampered archive.
+	files["/download/v0.8.1/checksums.txt.sig"] = detachSign(t, signer, checksums)
+	files["/download/v0.8.1/"+archiveName] = makeTarGz(t, "kopia-0.8.1/kopia", []byte("malicious"))
+	_, err = selfupdate.DownloadExecutable(ctx, opt, rel)
+	require.Error(t, err)
+
+	// replace executable.
+	exePath := filepath.Join(t.TempDir(), "kopia")
+	require.NoError(t, ioutil.WriteFile(exePath, []byte("old"), 0o755))
+	require.NoError(t, selfupdate.ReplaceExecutable(exePath, got))
+
+	installed, err := ioutil.ReadFile(exePath)
+	require.NoError(t, err)
+	require.Equal(t, exeContents, installed)
+
+	st, err := os.Stat(exePath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o755), st.Mode().Perm())
+
+	entries, err := ioutil.ReadDir(filepath.Dir(exePath))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestPolicy(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	p, err := selfupdate.GetPolicy(ctx, env.Repository)
+	require.NoError(t, err)
+	require.Equal(t, &selfupdate.Policy{}, p)
+
+	require.Error(t, selfupdate.SetPolicy(ctx, env.Repository, &selfupdate.Policy{Channel: "nightly"}))
+	require.NoError(t, selfupdate.SetPolicy(ctx, env.Repository, &selfupdate.Policy{Channel: selfupdate.ChannelBeta}))
+	require.NoError(t, selfupdate.SetPolicy(ctx, env.Repository, &selfupdate.Policy{Channel: selfupdate.ChannelStable, PinnedVersion: "v0.8.0"}))
+
+	p, err = selfupdate.GetPolicy(ctx, env.Repository)
+	require.NoError(t, err)
+	require.Equal(t, &selfupdate.Policy{Channel: selfupdate.ChannelStable, PinnedVersion: "v0.8.0"}, p)
+
+	require.NoError(t, selfupdate.SetPolicy(ctx, env.Repository, &selfupdate.Policy{}))
+
+	p, err = selfupdate.GetPolicy(ctx, env.Repository)
+	require.NoError(t, err)
+	require.Equal(t, &selfupdate.Policy{}, p)
+}
+
+func makeTarGz(t *testing.T, name string, contents []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "kopia-0.8.1/README.md", Mode: 0o644, Size: 2, Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte("hi"))
+	require.NoError(t, err)
+
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o755, Size: int64(len(contents)), Typeflag: tar.TypeReg}))
+	_, err = tw.Write(contents)
+	require.NoError(t, err)
+
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	return buf.Bytes()
+}
+
+func detachSign(t *testing.T, signer *openpgp.Entity, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	require.NoError(t, openpgp.DetachSign(&buf, signer, bytes.NewReader(data), nil))
+
+	return buf.Bytes()
+}
+
+func armoredPublicKey(t *testing.T, e *openpgp.Entity) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, e.Serialize(w))
+	require.NoError(t, w.Close())
+
+	return buf.String()
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/kopia/kopia/internal/authhook"
+	"github.com/kopia/kopia/internal/remoterepoapi"
+	"github.com/kopia/kopia/repo/manifest"
+)
+
+// authorize checks whether the authenticated user may perform the request using the authorization hook, if any.
+// Hook failures deny the request.
+func (s *Server) authorize(ctx context.Context, r *http.Request, body []byte) *apiError {
+	h := s.options.AuthorizationHook
+	if h == nil {
+		return nil
+	}
+
+	user, _, _ := r.BasicAuth()
+
+	req := &authhook.Request{
+		Action:    authhook.ActionAuthorize,
+		Username:  user,
+		Operation: r.Method + " " + routeTemplate(r),
+		Labels:    s.affectedManifestLabels(ctx, r, body),
+	}
+
+	allowed, err := h.Check(ctx, req)
+	if err != nil {
+		log(ctx).Warningf("unable to authorize %v of %v: %v", req.Operation, user, err)
+		return accessDeniedError("unable to authorize request")
+	}
+
+	if !allowed {
+		log(ctx).Debugf("authorization hook denied %v of %v", req.Operation, user)
+		return accessDeniedError("operation not permitted")
+	}
+
+	return nil
+}
+
+// routeTemplate returns the route of the request with variables left unexpanded, e.g. /api/v1/manifests/{manifestID}.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if t, err := route.GetPathTemplate(); err == nil {
+			return t
+		}
+	}
+
+	return r.URL.Path
+}
+
+// affectedManifestLabels returns labels of the manifest or snapshot the request reads, deletes, replaces or creates.
+func (s *Server) affectedManifestLabels(ctx context.Context, r *http.Request, body []byte) map[string]string {
+	mid := mux.Vars(r)["manifestID"]
+	if mid == "" {
+		mid = mux.Vars(r)["snapshotID"]
+	}
+
+	if mid != "" && s.rep != nil {
+		var data json.RawMessage
+
+		md, err := s.rep.GetManifest(ctx, manifest.ID(mid), &data)
+		if err != nil {
+			return nil
+		}
+
+		return md.Labels
+	}
+
+	if r.Method == http.MethodPost && routeTemplate(r) == "/api/v1/manifests" {
+		var req remoterepoapi.ManifestWithMetadata
+
+		if err := json.Unmarshal(body, &req); err != nil || req.Metadata == nil {
+			return nil
+		}
+
+		return req.Metadata.Labels
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/authhook"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/serverapi"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+type denyAllHook struct{}
+
+func (denyAllHook) Check(ctx context.Context, req *authhook.Request) (bool, error) {
+	return false, nil
+}
+
+func TestAuthorizationDenied(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	srv, err := New(ctx, Options{RefreshInterval: time.Hour, AuthorizationHook: denyAllHook{}})
+	must(t, err)
+	must(t, srv.SetRepository(ctx, env.Repository))
+
+	defer srv.StopAllSourceManagers(ctx)
+
+	hs := httptest.NewServer(srv.APIHandlers())
+	defer hs.Close()
+
+	resp, err := http.Get(hs.URL + "/api/v1/repo/status")
+	must(t, err)
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status code: %v", resp.StatusCode)
+	}
+
+	if got, want := resp.Header.Get("Content-Type"), "application/json"; got != want {
+		t.Fatalf("unexpected content type: %v, want %v", got, want)
+	}
+
+	var er serverapi.ErrorResponse
+
+	must(t, json.NewDecoder(resp.Body).Decode(&er))
+
+	if er.Error == "" {
+		t.Fatalf("missing error message")
+	}
+}
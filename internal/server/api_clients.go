@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/clockskew"
+	"github.com/kopia/kopia/internal/remoterepoapi"
+	"github.com/kopia/kopia/internal/serverapi"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+)
+
+// clientManifestType is the value of the "type" label for manifests describing clients of the server.
+const clientManifestType = "client"
+
+// clientInfoSaveInterval is the interval after which unchanged client information is saved again,
+// clients report on every connection, so saving each report would write a manifest every time.
+const clientInfoSaveInterval = time.Hour
+
+func clientLabels(username, hostname string) map[string]string {
+	return map[string]string{
+		manifest.TypeLabelKey: clientManifestType,
+		"username":            username,
+		"hostname":            hostname,
+	}
+}
+
+// recordClientSeen records the time of the last request made by an authenticated client.
+func (s *Server) recordClientSeen(r *http.Request) {
+	if u, _, ok := r.BasicAuth(); ok && strings.Contains(u, "@") {
+		s.clientsLastSeen.Store(u, clock.Now())
+	}
+}
+
+func (s *Server) handleClientReport(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
+	var ci remoterepoapi.ClientInfo
+
+	if err := json.Unmarshal(body, &ci); err != nil {
+		return nil, requestError(serverapi.ErrorMalformedRequest, "malformed request body")
+	}
+
+	// prefer identity of the authenticated user over the reported one.
+	if u, _, ok := r.BasicAuth(); ok {
+		if p := strings.Split(u, "@"); len(p) == 2 { //nolint:gomnd
+			ci.Username, ci.Hostname = p[0], p[1]
+		}
+	}
+
+	if ci.Username == "" || ci.Hostname == "" {
+		return nil, requestError(serverapi.ErrorMalformedRequest, "missing username or hostname")
+	}
+
+	previous, err := getClientInfo(ctx, s.rep, ci.Username, ci.Hostname)
+	if err != nil {
+		return nil, internalServerError(err)
+	}
+
+	if previous != nil {
+		ci.PolicyProfile = previous.PolicyProfile
+
+		// clients measure cache size only occasionally.
+		if ci.CacheSizeTime.IsZero() {
+			ci.CacheSizeBytes = previous.CacheSizeBytes
+			ci.CacheSizeTime = previous.CacheSizeTime
+		}
+	} else {
+		// bootstrap host policy of clients connecting for the first time.
+		p, err := policy.ApplyProfile(ctx, s.rep, ci.Username, ci.Hostname)
+		if err != nil {
+			return nil, internalServerError(err)
+		}
+
+		if p != nil {
+			log(ctx).Infof("applied policy profile %v to %v@%v", p.Name, ci.Username, ci.Hostname)
+			ci.PolicyProfile = p.Name
+		}
+	}
+
+	now := clock.Now()
+
+	var skew time.Duration
+
+	if !ci.ClientTime.IsZero() {
+		skew = clockskew.Estimate(ci.ClientTime, ci.ClientTime, now)
+
+		if clockskew.Exceeds(skew, clockskew.DefaultMaxSkew) {
+			log(ctx).Warningf("clock of %v@%v differs from the server clock by %v", ci.Username, ci.Hostname, skew)
+		}
+	}
+
+	ci.ServerTime = now
+
+	if previous != nil && !clientInfoChanged(previous.ClientInfo, ci) && now.Sub(previous.LastReported) < clientInfoSaveInterval {
+		// the client is recorded as seen by recordClientSeen().
+		return &ci, nil
+	}
+
+	if err := saveClientInfo(ctx, s.rep, &serverapi.ClientStatus{
+		ClientInfo:   ci,
+		LastReported: now,
+		ClockSkew:    skew,
+	}); err != nil {
+		return nil, internalServerError(err)
+	}
+
+	return &ci, nil
+}
+
+// clientInfoChanged determines whether the reported client information differs from the saved one,
+// ignoring the times of the reports.
+func clientInfoChanged(saved, reported remoterepoapi.ClientInfo) bool {
+	saved.ClientTime = reported.ClientTime
+	saved.ServerTime = reported.ServerTime
+
+	return saved != reported
+}
+
+// getClientInfo returns the last reported status of a given client or nil if the client was never seen.
+func getClientInfo(ctx context.Context, rep repo.Repository, username, hostname string) (*serverapi.ClientStatus, error) {
+	entries, err := rep.FindManifests(ctx, clientLabels(username, hostname))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to find client manifests")
+	}
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	cs := &serverapi.ClientStatus{}
+	if _, err := rep.GetManifest(ctx, entries[0].ID, cs); err != nil {
+		return nil, errors.Wrap(err, "unable to load client manifest")
+	}
+
+	return cs, nil
+}
+
+func saveClientInfo(ctx context.Context, rep repo.Repository, cs *serverapi.ClientStatus) error {
+	labels := clientLabels(cs.Username, cs.Hostname)
+
+	old, err := rep.FindManifests(ctx, labels)
+	if err != nil {
+		return errors.Wrap(err, "unable to find client manifests")
+	}
+
+	if _, err := rep.PutManifest(ctx, labels, cs); err != nil {
+		return errors.Wrap(err, "unable to save client manifest")
+	}
+
+	for _, e := range old {
+		if err := rep.DeleteManifest(ctx, e.ID); err != nil {
+			return errors.Wrap(err, "unable to delete old client manifest")
+		}
+	}
+
+	return errors.Wrap(rep.Flush(ctx), "unable to flush repository")
+}
+
+func (s *Server) handleClientList(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
+	entries, err := s.rep.FindManifests(ctx, map[string]string{
+		manifest.TypeLabelKey: clientManifestType,
+	})
+	if err != nil {
+		return nil, internalServerError(err)
+	}
+
+	resp := &serverapi.ClientsResponse{
+		ServerVersion: repo.BuildVersion,
+		Clients:       []*serverapi.ClientStatus{},
+	}
+
+	for _, e := range entries {
+		cs := &serverapi.ClientStatus{}
+		if _, err := s.rep.GetManifest(ctx, e.ID, cs); err != nil {
+			return nil, internalServerError(err)
+		}
+
+		cs.LastSeen = cs.LastReported
+
+		if v, ok := s.clientsLastSeen.Load(cs.Username + "@" + cs.Hostname); ok {
+			if t := v.(time.Time); t.After(cs.LastSeen) {
+				cs.LastSeen = t
+			}
+		}
+
+		cs.LastSnapshot, err = lastSnapshotOfClient(ctx, s.rep, cs.Username, cs.Hostname)
+		if err != nil {
+			return nil, internalServerError(err)
+		}
+
+		resp.Clients = append(resp.Clients, cs)
+	}
+
+	sort.Slice(resp.Clients, func(i, j int) bool {
+		a, b := resp.Clients[i], resp.Clients[j]
+		if a.Hostname != b.Hostname {
+			return a.Hostname < b.Hostname
+		}
+
+		return a.Username < b.Username
+	})
+
+	return resp, nil
+}
+
+func lastSnapshotOfClient(ctx context.Context, rep repo.Repository, username, hostname string) (*serverapi.ClientLastSnapshot, error) {
+	entries, err := rep.FindManifests(ctx, map[string]string{
+		manifest.TypeLabelKey: snapshot.ManifestType,
+		"username":            username,
+		"hostname":            hostname,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to find snapshots")
+	}
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	latest := entries[0]
+
+	for _, e := range entries[1:] {
+		if e.ModTime.After(latest.ModTime) {
+			latest = e
+		}
+	}
+
+	m, err := snapshot.LoadSnapshot(ctx, rep, latest.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load snapshot")
+	}
+
+	ls := &serverapi.ClientLastSnapshot{
+		Source:           m.Source,
+		StartTime:        m.StartTime,
+		EndTime:          m.EndTime,
+		IncompleteReason: m.IncompleteReason,
+	}
+
+	if m.RootEntry != nil && m.RootEntry.DirSummary != nil {
+		ls.ErrorCount = m.RootEntry.DirSummary.NumFailed
+	}
+
+	return ls, nil
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/remoterepoapi"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/manifest"
+)
+
+func TestClientReportThrottlesWrites(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	srv, err := New(ctx, Options{RefreshInterval: time.Hour})
+	must(t, err)
+	must(t, srv.SetRepository(ctx, env.Repository))
+
+	defer srv.StopAllSourceManagers(ctx)
+
+	report := func(ci remoterepoapi.ClientInfo) manifest.ID {
+		t.Helper()
+
+		ci.ClientTime = clock.Now()
+
+		body, err := json.Marshal(ci)
+		must(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/clients/report", nil)
+		r.SetBasicAuth("user@host", "password")
+
+		if _, aerr := srv.handleClientReport(ctx, r, body); aerr != nil {
+			t.Fatalf("unable to report client: %v", aerr.message)
+		}
+
+		entries, err := env.Repository.FindManifests(ctx, clientLabels("user", "host"))
+		must(t, err)
+
+		if len(entries) != 1 {
+			t.Fatalf("unexpected number of client manifests: %v", len(entries))
+		}
+
+		return entries[0].ID
+	}
+
+	ci := remoterepoapi.ClientInfo{Version: "1.0", CacheSizeBytes: 100, CacheSizeTime: clock.Now()}
+
+	id1 := report(ci)
+
+	// unchanged reports are not saved, even without cache size.
+	ci.CacheSizeBytes = 0
+	ci.CacheSizeTime = time.Time{}
+
+	if id2 := report(ci); id2 != id1 {
+		t.Errorf("unchanged client information was saved again")
+	}
+
+	ci.Version = "1.1"
+
+	id3 := report(ci)
+	if id3 == id1 {
+		t.Errorf("changed client information was not saved")
+	}
+
+	cs, err := getClientInfo(ctx, env.Repository, "user", "host")
+	must(t, err)
+
+	if cs.Version != "1.1" || cs.CacheSizeBytes != 100 {
+		t.Errorf("unexpected client status: %+v", cs)
+	}
+}
@@ -67,5 +67,11 @@ func (s *Server) handleContentPut(ctx context.Context, r *http.Request, data []b
 		return nil, requestError(serverapi.ErrorMalformedRequest, "mismatched content ID")
 	}
 
+	if s.uploadJournal != nil {
+		if err := s.uploadJournal.add(cid, data); err != nil {
+			return nil, internalServerError(err)
+		}
+	}
+
 	return &serverapi.Empty{}, nil
 }
@@ -2,6 +2,7 @@
 
 import (
 	"fmt"
+	"net/http"
 
 	"github.com/kopia/kopia/internal/serverapi"
 )
@@ -20,6 +21,14 @@ func notFoundError(message string) *apiError {
 	return &apiError{404, serverapi.ErrorNotFound, message}
 }
 
+func accessDeniedError(message string) *apiError {
+	return &apiError{403, serverapi.ErrorAccessDenied, message}
+}
+
+func standbyError() *apiError {
+	return &apiError{http.StatusServiceUnavailable, serverapi.ErrorStandby, "server is a read-only standby, promote it to accept writes"}
+}
+
 func internalServerError(err error) *apiError {
 	return &apiError{500, serverapi.ErrorInternal, fmt.Sprintf("internal server error: %v", err)}
 }
@@ -9,13 +9,18 @@
 	"github.com/gorilla/mux"
 
 	"github.com/kopia/kopia/internal/remoterepoapi"
+	"github.com/kopia/kopia/internal/selfupdate"
 	"github.com/kopia/kopia/internal/serverapi"
 	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/legalhold"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotpause"
 )
 
 func (s *Server) handleManifestGet(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
 	// password already validated by a wrapper, no need to check here.
-	userAtHost, _, _ := r.BasicAuth()
+	userAtHost := s.requestUserAtHost(r)
 
 	mid := manifest.ID(mux.Vars(r)["manifestID"])
 
@@ -43,6 +48,10 @@ func (s *Server) handleManifestGet(ctx context.Context, r *http.Request, body []
 func (s *Server) handleManifestDelete(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
 	mid := manifest.ID(mux.Vars(r)["manifestID"])
 
+	if aerr := s.ensureManifestWritable(ctx, r, mid); aerr != nil {
+		return nil, aerr
+	}
+
 	err := s.rep.DeleteManifest(ctx, mid)
 	if errors.Is(err, manifest.ErrNotFound) {
 		return nil, notFoundError("manifest not found")
@@ -55,9 +64,109 @@ func (s *Server) handleManifestDelete(ctx context.Context, r *http.Request, body
 	return &serverapi.Empty{}, nil
 }
 
+func (s *Server) handleManifestReplace(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
+	mid := manifest.ID(mux.Vars(r)["manifestID"])
+
+	var req remoterepoapi.ManifestWithMetadata
+
+	if err := json.Unmarshal(body, &req); err != nil || req.Metadata == nil {
+		return nil, requestError(serverapi.ErrorMalformedRequest, "malformed request")
+	}
+
+	if aerr := s.ensureManifestWritable(ctx, r, mid); aerr != nil {
+		return nil, aerr
+	}
+
+	if aerr := s.ensureLabelsWritable(r, req.Metadata.Labels); aerr != nil {
+		return nil, aerr
+	}
+
+	err := s.rep.ReplaceManifest(ctx, mid, req.Metadata.Labels, req.Payload)
+	if errors.Is(err, manifest.ErrNotFound) {
+		return nil, notFoundError("manifest not found")
+	}
+
+	if err != nil {
+		return nil, internalServerError(err)
+	}
+
+	return &manifest.EntryMetadata{ID: mid}, nil
+}
+
+// ensureManifestWritable prevents remote clients from changing manifests of other users and repository-wide
+// manifests reserved for the UI, from deleting or replacing snapshots under legal hold and from releasing legal holds.
+func (s *Server) ensureManifestWritable(ctx context.Context, r *http.Request, mid manifest.ID) *apiError {
+	var data json.RawMessage
+
+	md, err := s.rep.GetManifest(ctx, mid, &data)
+	if errors.Is(err, manifest.ErrNotFound) {
+		return notFoundError("manifest not found")
+	}
+
+	if err != nil {
+		return internalServerError(err)
+	}
+
+	// manifests of other users are not visible to the client.
+	if !manifestMatchesUser(md, s.requestUserAtHost(r)) {
+		return notFoundError("manifest not found")
+	}
+
+	if aerr := s.ensureLabelsWritable(r, md.Labels); aerr != nil {
+		return aerr
+	}
+
+	switch md.Labels[manifest.TypeLabelKey] {
+	case legalhold.ManifestType:
+		return accessDeniedError("legal holds can only be released using direct repository connection")
+
+	case policy.ManifestType:
+		pol := &policy.Policy{}
+		if err := json.Unmarshal(data, pol); err != nil {
+			return internalServerError(err)
+		}
+
+		if pol.RetentionPolicy.LegalHoldOrDefault(false) {
+			return accessDeniedError("policies with legal hold can only be changed using direct repository connection")
+		}
+
+	case snapshot.ManifestType:
+		m, err := snapshot.LoadSnapshot(ctx, s.rep, mid)
+		if err != nil {
+			return internalServerError(err)
+		}
+
+		if err := policy.EnsureNotHeld(ctx, s.rep, m); err != nil {
+			if errors.Is(err, legalhold.ErrHeld) {
+				return accessDeniedError(err.Error())
+			}
+
+			return internalServerError(err)
+		}
+	}
+
+	return nil
+}
+
+// ensureLabelsWritable prevents remote clients from writing manifests with the provided labels
+// into namespaces of other users or of the UI.
+func (s *Server) ensureLabelsWritable(r *http.Request, labels map[string]string) *apiError {
+	userAtHost := s.requestUserAtHost(r)
+
+	if uiOnlyManifestTypes[labels[manifest.TypeLabelKey]] && userAtHost != "" {
+		return accessDeniedError("manifest can only be changed by administrators")
+	}
+
+	if !manifestMatchesUser(&manifest.EntryMetadata{Labels: labels}, userAtHost) {
+		return accessDeniedError("manifest can only be written for the user making the request")
+	}
+
+	return nil
+}
+
 func (s *Server) handleManifestList(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
 	// password already validated by a wrapper, no need to check here.
-	userAtHost, _, _ := r.BasicAuth()
+	userAtHost := s.requestUserAtHost(r)
 
 	labels := map[string]string{}
 
@@ -73,11 +182,41 @@ func (s *Server) handleManifestList(ctx context.Context, r *http.Request, body [
 	return filterManifests(m, userAtHost), nil
 }
 
+// repositoryWideManifestTypes are types of manifests that are not owned by any user
+// and are readable by all users.
+var repositoryWideManifestTypes = map[string]bool{
+	selfupdate.PolicyManifestType: true,
+	snapshotpause.ManifestType:    true,
+	legalhold.ManifestType:        true,
+}
+
+// uiOnlyManifestTypes are types of repository-wide manifests affecting all clients,
+// which can only be created, replaced or deleted by the UI.
+var uiOnlyManifestTypes = map[string]bool{
+	selfupdate.PolicyManifestType: true,
+	snapshotpause.ManifestType:    true,
+}
+
+// requestUserAtHost returns the user making the request, whose access is limited to own manifests,
+// or an empty string for requests made by the UI.
+func (s *Server) requestUserAtHost(r *http.Request) string {
+	userAtHost, _, _ := r.BasicAuth()
+	if userAtHost == s.options.UIUsername {
+		return ""
+	}
+
+	return userAtHost
+}
+
 func manifestMatchesUser(m *manifest.EntryMetadata, userAtHost string) bool {
 	if userAtHost == "" {
 		return true
 	}
 
+	if repositoryWideManifestTypes[m.Labels[manifest.TypeLabelKey]] {
+		return true
+	}
+
 	actualUser := m.Labels["username"] + "@" + m.Labels["hostname"]
 
 	return actualUser == userAtHost
@@ -98,10 +237,14 @@ func filterManifests(manifests []*manifest.EntryMetadata, userAtHost string) []*
 func (s *Server) handleManifestCreate(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
 	var req remoterepoapi.ManifestWithMetadata
 
-	if err := json.Unmarshal(body, &req); err != nil {
+	if err := json.Unmarshal(body, &req); err != nil || req.Metadata == nil {
 		return nil, requestError(serverapi.ErrorMalformedRequest, "malformed request")
 	}
 
+	if aerr := s.ensureLabelsWritable(r, req.Metadata.Labels); aerr != nil {
+		return nil, aerr
+	}
+
 	id, err := s.rep.PutManifest(ctx, req.Metadata.Labels, req.Payload)
 	if err != nil {
 		return nil, internalServerError(err)
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/remoterepoapi"
+	"github.com/kopia/kopia/internal/selfupdate"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/snapshotpause"
+)
+
+func TestManifestMatchesUser(t *testing.T) {
+	cases := []struct {
+		labels     map[string]string
+		userAtHost string
+		want       bool
+	}{
+		{map[string]string{manifest.TypeLabelKey: "snapshot", "username": "foo", "hostname": "bar"}, "", true},
+		{map[string]string{manifest.TypeLabelKey: "snapshot", "username": "foo", "hostname": "bar"}, "foo@bar", true},
+		{map[string]string{manifest.TypeLabelKey: "snapshot", "username": "foo", "hostname": "bar"}, "foo@baz", false},
+
+		// pause applies to the entire repository, all clients must be able to see it.
+		{map[string]string{manifest.TypeLabelKey: snapshotpause.ManifestType}, "foo@bar", true},
+	}
+
+	for _, tc := range cases {
+		if got := manifestMatchesUser(&manifest.EntryMetadata{Labels: tc.labels}, tc.userAtHost); got != tc.want {
+			t.Errorf("manifestMatchesUser(%v, %q) = %v, want %v", tc.labels, tc.userAtHost, got, tc.want)
+		}
+	}
+}
+
+func TestManifestWritesRequireOwner(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	id, err := snapshot.SaveSnapshot(ctx, env.Repository, &snapshot.Manifest{
+		Source: snapshot.SourceInfo{Host: "host", UserName: "userb", Path: "/src"},
+	})
+	must(t, err)
+
+	srv, hs := newTestServer(ctx, t, &env)
+
+	defer srv.StopAllSourceManagers(ctx)
+	defer hs.Close()
+
+	manifestURL := hs.URL + "/api/v1/manifests/" + string(id)
+	withLabels := func(user string) *remoterepoapi.ManifestWithMetadata {
+		return &remoterepoapi.ManifestWithMetadata{
+			Payload: json.RawMessage(`{}`),
+			Metadata: &manifest.EntryMetadata{Labels: map[string]string{
+				manifest.TypeLabelKey: snapshot.ManifestType,
+				"username":            user,
+				"hostname":            "host",
+				"path":                "/src",
+			}},
+		}
+	}
+
+	for _, tc := range []struct {
+		method     string
+		url        string
+		user       string
+		body       interface{}
+		wantStatus int
+	}{
+		// snapshots of other users can't be replaced or deleted.
+		{http.MethodPut, manifestURL, "usera@host", withLabels("usera"), http.StatusNotFound},
+		{http.MethodPut, manifestURL, "usera@host", withLabels("userb"), http.StatusNotFound},
+		{http.MethodDelete, manifestURL, "usera@host", nil, http.StatusNotFound},
+
+		// manifests can't be moved to or created for other users.
+		{http.MethodPut, manifestURL, "userb@host", withLabels("usera"), http.StatusForbidden},
+		{http.MethodPost, hs.URL + "/api/v1/manifests", "usera@host", withLabels("userb"), http.StatusForbidden},
+
+		{http.MethodPost, hs.URL + "/api/v1/manifests", "usera@host", withLabels("usera"), http.StatusOK},
+		{http.MethodPut, manifestURL, "userb@host", withLabels("userb"), http.StatusOK},
+	} {
+		if got := requestStatusAs(ctx, t, tc.method, tc.url, tc.user, tc.body); got != tc.wantStatus {
+			t.Errorf("unexpected status of %v %v by %v: %v, want %v", tc.method, tc.url, tc.user, got, tc.wantStatus)
+		}
+	}
+}
+
+func TestPauseManifestRequiresUI(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	_, err := snapshotpause.Pause(ctx, env.Repository, "maintenance")
+	must(t, err)
+
+	entries, err := env.Repository.FindManifests(ctx, map[string]string{manifest.TypeLabelKey: snapshotpause.ManifestType})
+	must(t, err)
+
+	if len(entries) != 1 {
+		t.Fatalf("unexpected pause manifests: %v", entries)
+	}
+
+	srv, hs := newTestServer(ctx, t, &env)
+
+	defer srv.StopAllSourceManagers(ctx)
+	defer hs.Close()
+
+	pauseURL := hs.URL + "/api/v1/manifests/" + string(entries[0].ID)
+	pauseManifest := &remoterepoapi.ManifestWithMetadata{
+		Payload:  json.RawMessage(`{}`),
+		Metadata: &manifest.EntryMetadata{Labels: map[string]string{manifest.TypeLabelKey: snapshotpause.ManifestType}},
+	}
+
+	for _, tc := range []struct {
+		method     string
+		url        string
+		body       interface{}
+		wantStatus int
+	}{
+		{http.MethodGet, pauseURL, nil, http.StatusOK},
+		{http.MethodPut, pauseURL, pauseManifest, http.StatusForbidden},
+		{http.MethodDelete, pauseURL, nil, http.StatusForbidden},
+		{http.MethodPost, hs.URL + "/api/v1/manifests", pauseManifest, http.StatusForbidden},
+		{http.MethodPost, hs.URL + "/api/v1/sources/resume", nil, http.StatusForbidden},
+		{http.MethodPost, hs.URL + "/api/v1/sources/pause", nil, http.StatusForbidden},
+	} {
+		if got := requestStatusAs(ctx, t, tc.method, tc.url, "user@host", tc.body); got != tc.wantStatus {
+			t.Errorf("unexpected status of %v %v: %v, want %v", tc.method, tc.url, got, tc.wantStatus)
+		}
+	}
+
+	if got, want := requestStatusAs(ctx, t, http.MethodPost, hs.URL+"/api/v1/sources/resume", "ui", nil), http.StatusOK; got != want {
+		t.Errorf("unexpected status of resume by the UI: %v, want %v", got, want)
+	}
+}
+
+func TestUpdatePolicyManifestRequiresUI(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	srv, hs := newTestServer(ctx, t, &env)
+
+	defer srv.StopAllSourceManagers(ctx)
+	defer hs.Close()
+
+	updatePolicy := &remoterepoapi.ManifestWithMetadata{
+		Payload:  json.RawMessage(`{}`),
+		Metadata: &manifest.EntryMetadata{Labels: map[string]string{manifest.TypeLabelKey: selfupdate.PolicyManifestType}},
+	}
+
+	// update policy steers updates of all clients.
+	if got, want := requestStatusAs(ctx, t, http.MethodPost, hs.URL+"/api/v1/manifests", "user@host", updatePolicy), http.StatusForbidden; got != want {
+		t.Errorf("unexpected status of update policy created by user: %v, want %v", got, want)
+	}
+
+	if got, want := requestStatusAs(ctx, t, http.MethodPost, hs.URL+"/api/v1/manifests", "ui", updatePolicy), http.StatusOK; got != want {
+		t.Errorf("unexpected status of update policy created by the UI: %v, want %v", got, want)
+	}
+}
+
+func newTestServer(ctx context.Context, t *testing.T, env *repotesting.Environment) (*Server, *httptest.Server) {
+	t.Helper()
+
+	srv, err := New(ctx, Options{RefreshInterval: time.Hour, UIUsername: "ui"})
+	must(t, err)
+	must(t, srv.SetRepository(ctx, env.Repository))
+
+	return srv, httptest.NewServer(srv.APIHandlers())
+}
+
+func requestStatusAs(ctx context.Context, t *testing.T, method, url, user string, body interface{}) int {
+	t.Helper()
+
+	var b []byte
+
+	if body != nil {
+		var err error
+
+		b, err = json.Marshal(body)
+		must(t, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(b))
+	must(t, err)
+
+	req.SetBasicAuth(user, "password")
+
+	resp, err := http.DefaultClient.Do(req)
+	must(t, err)
+
+	resp.Body.Close() //nolint:errcheck
+
+	return resp.StatusCode
+}
@@ -2,17 +2,27 @@
 
 import (
 	"errors"
+	"mime"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 
+	"github.com/kopia/kopia/fs"
 	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/repo/manifest"
 	"github.com/kopia/kopia/repo/object"
+	"github.com/kopia/kopia/snapshot"
 	"github.com/kopia/kopia/snapshot/snapshotfs"
 )
 
 func (s *Server) handleObjectGet(w http.ResponseWriter, r *http.Request) {
+	if aerr := s.authorize(r.Context(), r, nil); aerr != nil {
+		http.Error(w, aerr.message, aerr.httpErrorCode)
+		return
+	}
+
 	oidstr := mux.Vars(r)["objectID"]
 
 	oid, err := object.ParseID(oidstr)
@@ -27,6 +37,13 @@ func (s *Server) handleObjectGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err != nil {
+		http.Error(w, "unable to open object", http.StatusInternalServerError)
+		return
+	}
+
+	defer obj.Close() //nolint:errcheck
+
 	if snapshotfs.IsDirectoryID(oid) {
 		w.Header().Set("Content-Type", "application/json")
 	}
@@ -34,7 +51,7 @@ func (s *Server) handleObjectGet(w http.ResponseWriter, r *http.Request) {
 	fname := oid.String()
 	if p := r.URL.Query().Get("fname"); p != "" {
 		fname = p
-		w.Header().Set("Content-Disposition", "attachment; filename=\""+p+"\"")
+		w.Header().Set("Content-Disposition", attachmentDisposition(p))
 	}
 
 	mtime := clock.Now()
@@ -45,5 +62,78 @@ func (s *Server) handleObjectGet(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// ServeContent handles HTTP Range requests by seeking within the object, which only
+	// fetches contents overlapping the requested ranges.
 	http.ServeContent(w, r, fname, mtime, obj)
 }
+
+// handleSnapshotFileGet streams the contents of the file at the 'path' within the snapshot,
+// supporting HTTP Range requests so that clients can download files without mounting or restoring.
+func (s *Server) handleSnapshotFileGet(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ctx := r.Context()
+
+	if aerr := s.authorize(ctx, r, nil); aerr != nil {
+		http.Error(w, aerr.message, aerr.httpErrorCode)
+		return
+	}
+
+	if s.rep == nil {
+		http.Error(w, "not connected", http.StatusBadRequest)
+		return
+	}
+
+	man, err := s.loadUserSnapshot(ctx, r, manifest.ID(mux.Vars(r)["snapshotID"]))
+	if errors.Is(err, snapshot.ErrSnapshotNotFound) {
+		http.Error(w, "snapshot not found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, "unable to load snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	root, err := snapshotfs.SnapshotRoot(s.rep, man)
+	if err != nil {
+		http.Error(w, "unable to open snapshot root", http.StatusInternalServerError)
+		return
+	}
+
+	e, err := snapshotfs.GetNestedEntry(ctx, root, strings.Split(r.URL.Query().Get("path"), "/"))
+	if err != nil {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+
+	f, ok := e.(fs.File)
+	if !ok {
+		http.Error(w, "not a file", http.StatusBadRequest)
+		return
+	}
+
+	rd, err := f.Open(ctx)
+	if err != nil {
+		http.Error(w, "unable to open file", http.StatusInternalServerError)
+		return
+	}
+
+	defer rd.Close() //nolint:errcheck
+
+	// object IDs identify file contents, which makes them strong validators for conditional range requests.
+	if h, ok := e.(object.HasObjectID); ok {
+		w.Header().Set("ETag", "\""+h.ObjectID().String()+"\"")
+	}
+
+	w.Header().Set("Content-Disposition", attachmentDisposition(e.Name()))
+
+	http.ServeContent(w, r, e.Name(), e.ModTime(), rd)
+}
+
+// attachmentDisposition returns Content-Disposition header value of the downloaded file with the provided name,
+// which is quoted or encoded as necessary.
+func attachmentDisposition(fname string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": fname})
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/apiclient"
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/serverapi"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/object"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+func TestSnapshotFileGet(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	contents := bytes.Repeat([]byte("0123456789"), 1000)
+
+	sourceDir := mockfs.NewDirectory()
+	sourceDir.AddDir("d1", 0o777).AddFile("f1", contents, 0o777)
+
+	si := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"}
+
+	policyTree, err := policy.TreeForSource(ctx, env.Repository, si)
+	must(t, err)
+
+	man, err := snapshotfs.NewUploader(env.Repository).Upload(ctx, sourceDir, policyTree, si)
+	must(t, err)
+
+	_, err = snapshot.SaveSnapshot(ctx, env.Repository, man)
+	must(t, err)
+	must(t, env.Repository.Flush(ctx))
+
+	srv, err := New(ctx, Options{RefreshInterval: time.Hour})
+	must(t, err)
+	must(t, srv.SetRepository(ctx, env.Repository))
+
+	defer srv.StopAllSourceManagers(ctx)
+
+	hs := httptest.NewServer(srv.APIHandlers())
+	defer hs.Close()
+
+	cli, err := apiclient.NewKopiaAPIClient(apiclient.Options{BaseURL: hs.URL})
+	must(t, err)
+
+	got, err := serverapi.GetSnapshotFile(ctx, cli, man.ID, "d1/f1")
+	must(t, err)
+
+	if !bytes.Equal(got, contents) {
+		t.Fatalf("unexpected file contents: %v bytes", len(got))
+	}
+
+	if _, err = serverapi.GetSnapshotFile(ctx, cli, man.ID, "d1/no-such-file"); !errors.Is(err, object.ErrObjectNotFound) {
+		t.Fatalf("unexpected error for missing file: %v", err)
+	}
+
+	if _, err = serverapi.GetSnapshotFile(ctx, cli, man.ID, "d1"); err == nil {
+		t.Fatalf("unexpected success when downloading a directory")
+	}
+
+	resp := mustGetRange(ctx, t, hs.URL+"/api/v1/snapshots/"+string(man.ID)+"/file?path=d1/f1", "bytes=1000-1099")
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("unexpected status: %v", resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	must(t, err)
+
+	if !bytes.Equal(b, contents[1000:1100]) {
+		t.Fatalf("unexpected range contents: %q", b)
+	}
+
+	if resp.Header.Get("ETag") == "" {
+		t.Fatalf("missing ETag")
+	}
+}
+
+func TestSnapshotFileGetAccess(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	const fname = "my \"quoted\" file.txt"
+
+	sourceDir := mockfs.NewDirectory()
+	sourceDir.AddFile(fname, []byte("contents"), 0o777)
+
+	si := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"}
+
+	policyTree, err := policy.TreeForSource(ctx, env.Repository, si)
+	must(t, err)
+
+	man, err := snapshotfs.NewUploader(env.Repository).Upload(ctx, sourceDir, policyTree, si)
+	must(t, err)
+
+	_, err = snapshot.SaveSnapshot(ctx, env.Repository, man)
+	must(t, err)
+	must(t, env.Repository.Flush(ctx))
+
+	srv, err := New(ctx, Options{RefreshInterval: time.Hour, UIUsername: "kopia"})
+	must(t, err)
+	must(t, srv.SetRepository(ctx, env.Repository))
+
+	defer srv.StopAllSourceManagers(ctx)
+
+	hs := httptest.NewServer(srv.APIHandlers())
+	defer hs.Close()
+
+	for _, tc := range []struct {
+		username string
+		allowed  bool
+	}{
+		{"user@host", true},
+		{"kopia", true},
+		{"other@host", false},
+		{"user@otherhost", false},
+	} {
+		cli, err := apiclient.NewKopiaAPIClient(apiclient.Options{BaseURL: hs.URL, Username: tc.username, Password: "password"})
+		must(t, err)
+
+		_, err = serverapi.GetSnapshotFile(ctx, cli, man.ID, fname)
+
+		switch {
+		case tc.allowed && err != nil:
+			t.Errorf("unexpected error for %v: %v", tc.username, err)
+		case !tc.allowed && !errors.Is(err, object.ErrObjectNotFound):
+			t.Errorf("unexpected error for %v: %v", tc.username, err)
+		}
+	}
+
+	resp := mustGetRange(ctx, t, hs.URL+"/api/v1/snapshots/"+string(man.ID)+"/file?path="+url.QueryEscape(fname), "bytes=0-")
+	defer resp.Body.Close() //nolint:errcheck
+
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	must(t, err)
+
+	if got := params["filename"]; got != fname {
+		t.Fatalf("unexpected file name: %q", got)
+	}
+}
+
+func mustGetRange(ctx context.Context, t *testing.T, url, byteRange string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	must(t, err)
+
+	req.Header.Set("Range", byteRange)
+
+	resp, err := http.DefaultClient.Do(req)
+	must(t, err)
+
+	return resp
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/kopia/kopia/internal/serverapi"
+	"github.com/kopia/kopia/snapshot/snapshotpause"
+)
+
+func (s *Server) handlePause(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
+	var req serverapi.PauseRequest
+
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, requestError(serverapi.ErrorMalformedRequest, "malformed request body")
+		}
+	}
+
+	st, err := snapshotpause.Pause(ctx, s.rep, req.Reason)
+	if err != nil {
+		return nil, internalServerError(err)
+	}
+
+	log(ctx).Infof("snapshots paused: %v", req.Reason)
+
+	// running uploads are canceled, which saves their progress as checkpoints.
+	for _, mgr := range s.sourceManagers {
+		if u := mgr.currentUploader(); u != nil {
+			u.Cancel()
+		}
+	}
+
+	return &serverapi.PauseResponse{Pause: st}, nil
+}
+
+func (s *Server) handleResume(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
+	if err := snapshotpause.Resume(ctx, s.rep); err != nil {
+		return nil, internalServerError(err)
+	}
+
+	log(ctx).Infof("snapshots resumed")
+
+	return &serverapi.PauseResponse{}, nil
+}
@@ -32,11 +32,29 @@ func (s *Server) handleRepoParameters(ctx context.Context, r *http.Request, body
 		HashFunction: dr.Content.Format.Hash,
 		HMACSecret:   dr.Content.Format.HMACSecret,
 		Format:       dr.Objects.Format,
+
+		UploadJournal: s.uploadJournal != nil,
 	}
 
 	return rp, nil
 }
 
+// handleRepoZstdDictionaries returns dictionaries of the "zstd-dictionary" compressor, which are needed by API clients
+// to read and write data compressed using them.
+func (s *Server) handleRepoZstdDictionaries(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
+	dr, ok := s.rep.(*repo.DirectRepository)
+	if !ok {
+		return nil, requestError(serverapi.ErrorNotConnected, "compression dictionaries require direct repository connection")
+	}
+
+	dicts := dr.ZstdDictionaries()
+	if dicts == nil {
+		dicts = []compression.ZstdDictionary{}
+	}
+
+	return dicts, nil
+}
+
 func (s *Server) handleRepoStatus(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
 	if s.rep == nil {
 		return &serverapi.StatusResponse{
@@ -56,6 +74,7 @@ func (s *Server) handleRepoStatus(ctx context.Context, r *http.Request, body []b
 			Splitter:      dr.Objects.Format.Splitter,
 			Storage:       dr.Blobs.ConnectionInfo().Type,
 			ClientOptions: dr.ClientOptions(),
+			Standby:       s.isStandby(),
 		}, nil
 	}
 
@@ -66,6 +85,7 @@ type remoteRepository interface {
 	result := &serverapi.StatusResponse{
 		Connected:     true,
 		ClientOptions: s.rep.ClientOptions(),
+		Standby:       s.isStandby(),
 	}
 
 	if rr, ok := s.rep.(remoteRepository); ok {
@@ -229,6 +249,8 @@ func (s *Server) handleRepoSupportedAlgorithms(ctx context.Context, r *http.Requ
 		res.CompressionAlgorithms = append(res.CompressionAlgorithms, string(k))
 	}
 
+	res.CompressionAlgorithms = append(res.CompressionAlgorithms, string(compression.ZstdDictionaryName))
+
 	sort.Strings(res.CompressionAlgorithms)
 
 	return res, nil
@@ -264,7 +286,7 @@ func (s *Server) connectAndOpen(ctx context.Context, conn blob.ConnectionInfo, p
 }
 
 func (s *Server) open(ctx context.Context, password string) *apiError {
-	rep, err := repo.Open(ctx, s.options.ConfigFile, password, nil)
+	rep, err := repo.Open(ctx, s.options.ConfigFile, password, s.options.RepositoryOptions)
 	if err != nil {
 		return repoErrorToAPIError(err)
 	}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/repo/object"
+)
+
+func TestAPIClientUsesZstdDictionaries(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	var samples [][]byte
+
+	for i := 0; i < 500; i++ {
+		samples = append(samples, []byte(fmt.Sprintf(`{"name":"file-%v.txt","type":"f","mode":"0644","mtime":"2020-01-01T10:%02d:00Z","obj":"%032x"}`, i, i%60, i*7919)))
+	}
+
+	d, err := compression.TrainZstdDictionary(samples, 16<<10)
+	must(t, err)
+	must(t, env.Repository.AddZstdDictionary(ctx, d))
+
+	data := samples[42]
+
+	w := env.Repository.NewObjectWriter(ctx, object.WriterOptions{Compressor: compression.ZstdDictionaryName})
+	_, err = w.Write(data)
+	must(t, err)
+
+	oid, err := w.Result()
+	must(t, err)
+	must(t, w.Close())
+	must(t, env.Repository.Flush(ctx))
+
+	srv, err := New(ctx, Options{RefreshInterval: time.Hour})
+	must(t, err)
+	must(t, srv.SetRepository(ctx, env.Repository))
+
+	defer srv.StopAllSourceManagers(ctx)
+
+	hs := httptest.NewServer(srv.APIHandlers())
+	defer hs.Close()
+
+	configFile := filepath.Join(t.TempDir(), "kopia.config")
+
+	must(t, repo.ConnectAPIServer(ctx, configFile, &repo.APIServerInfo{BaseURL: hs.URL}, "password", &repo.ConnectOptions{
+		ClientOptions: repo.ClientOptions{Username: "user", Hostname: "host"},
+	}))
+
+	rep, err := repo.Open(ctx, configFile, "password", nil)
+	must(t, err)
+
+	defer rep.Close(ctx)
+
+	r, err := rep.OpenObject(ctx, oid)
+	must(t, err)
+
+	defer r.Close()
+
+	got, err := ioutil.ReadAll(r)
+	must(t, err)
+
+	if string(got) != string(data) {
+		t.Fatalf("unexpected object contents: %q", got)
+	}
+}
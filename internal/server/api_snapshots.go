@@ -2,12 +2,19 @@
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/url"
 
+	"github.com/gorilla/mux"
+
+	"github.com/kopia/kopia/fs"
 	"github.com/kopia/kopia/internal/serverapi"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/manifest"
 	"github.com/kopia/kopia/snapshot"
 	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
 )
 
 func (s *Server) handleSnapshotList(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
@@ -37,6 +44,10 @@ func (s *Server) handleSnapshotList(ctx context.Context, r *http.Request, body [
 			pol.RetentionPolicy.ComputeRetentionReasons(grp)
 		}
 
+		if err := policy.MarkHeldSnapshots(ctx, s.rep, grp); err != nil {
+			return nil, internalServerError(err)
+		}
+
 		for _, m := range grp {
 			resp.Snapshots = append(resp.Snapshots, convertSnapshotManifest(m))
 		}
@@ -45,6 +56,83 @@ func (s *Server) handleSnapshotList(ctx context.Context, r *http.Request, body [
 	return resp, nil
 }
 
+func (s *Server) handleSnapshotCommitment(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
+	dr, ok := s.rep.(*repo.DirectRepository)
+	if !ok {
+		return nil, requestError(serverapi.ErrorNotConnected, "commitments require direct repository connection")
+	}
+
+	m, err := s.loadUserSnapshot(ctx, r, manifest.ID(mux.Vars(r)["snapshotID"]))
+	if errors.Is(err, snapshot.ErrSnapshotNotFound) {
+		return nil, notFoundError("snapshot not found")
+	}
+
+	if err != nil {
+		return nil, internalServerError(err)
+	}
+
+	c, err := s.commitments.Compute(ctx, dr, m.ID)
+	if err != nil {
+		return nil, internalServerError(err)
+	}
+
+	return c, nil
+}
+
+// handleSnapshotDiff returns differences between the snapshot and the other snapshot,
+// content-level changes are computed when 'content' query parameter is 'true'.
+func (s *Server) handleSnapshotDiff(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
+	var roots [2]fs.Entry
+
+	for i, id := range []string{mux.Vars(r)["snapshotID"], mux.Vars(r)["otherSnapshotID"]} {
+		m, err := s.loadUserSnapshot(ctx, r, manifest.ID(id))
+		if errors.Is(err, snapshot.ErrSnapshotNotFound) {
+			return nil, notFoundError("snapshot not found")
+		}
+
+		if err != nil {
+			return nil, internalServerError(err)
+		}
+
+		roots[i], err = snapshotfs.SnapshotRoot(s.rep, m)
+		if err != nil {
+			return nil, internalServerError(err)
+		}
+	}
+
+	res, err := snapshotfs.Diff(ctx, s.rep, roots[0], roots[1], snapshotfs.DiffOptions{
+		CompareContents: r.URL.Query().Get("content") == "true",
+	})
+	if err != nil {
+		return nil, internalServerError(err)
+	}
+
+	return res, nil
+}
+
+// loadUserSnapshot loads the snapshot manifest, which must be visible to the user making the request
+// using the same rules that apply to manifests.
+func (s *Server) loadUserSnapshot(ctx context.Context, r *http.Request, id manifest.ID) (*snapshot.Manifest, error) {
+	m := &snapshot.Manifest{}
+
+	md, err := s.rep.GetManifest(ctx, id, m)
+	if errors.Is(err, manifest.ErrNotFound) {
+		return nil, snapshot.ErrSnapshotNotFound
+	}
+
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	if md.Labels[manifest.TypeLabelKey] != snapshot.ManifestType || !manifestMatchesUser(md, s.requestUserAtHost(r)) {
+		return nil, snapshot.ErrSnapshotNotFound
+	}
+
+	m.ID = id
+
+	return m, nil
+}
+
 func sourceMatchesURLFilter(src snapshot.SourceInfo, query url.Values) bool {
 	if v := query.Get("host"); v != "" && src.Host != v {
 		return false
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+func TestSnapshotDiffRequiresOwner(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	si := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"}
+
+	policyTree, err := policy.TreeForSource(ctx, env.Repository, si)
+	must(t, err)
+
+	sourceDir := mockfs.NewDirectory()
+
+	var ids []manifest.ID
+
+	for i := 0; i < 2; i++ {
+		sourceDir.AddFile(fmt.Sprintf("f%v", i), []byte{byte(i)}, 0o777)
+
+		man, err := snapshotfs.NewUploader(env.Repository).Upload(ctx, sourceDir, policyTree, si)
+		must(t, err)
+
+		id, err := snapshot.SaveSnapshot(ctx, env.Repository, man)
+		must(t, err)
+
+		ids = append(ids, id)
+	}
+
+	must(t, env.Repository.Flush(ctx))
+
+	srv, err := New(ctx, Options{RefreshInterval: time.Hour, UIUsername: "ui"})
+	must(t, err)
+	must(t, srv.SetRepository(ctx, env.Repository))
+
+	defer srv.StopAllSourceManagers(ctx)
+
+	hs := httptest.NewServer(srv.APIHandlers())
+	defer hs.Close()
+
+	url := hs.URL + "/api/v1/snapshots/" + string(ids[0]) + "/diff/" + string(ids[1])
+
+	for _, tc := range []struct {
+		user       string
+		wantStatus int
+	}{
+		{"ui", http.StatusOK},
+		{"user@host", http.StatusOK},
+		{"other@host", http.StatusNotFound},
+	} {
+		if got := getStatusAs(ctx, t, url, tc.user); got != tc.wantStatus {
+			t.Errorf("unexpected status of diff requested by %v: %v, want %v", tc.user, got, tc.wantStatus)
+		}
+	}
+}
+
+func TestSnapshotCommitmentRequiresOwner(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	si := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"}
+
+	policyTree, err := policy.TreeForSource(ctx, env.Repository, si)
+	must(t, err)
+
+	sourceDir := mockfs.NewDirectory()
+	sourceDir.AddFile("f", []byte{1}, 0o777)
+
+	man, err := snapshotfs.NewUploader(env.Repository).Upload(ctx, sourceDir, policyTree, si)
+	must(t, err)
+
+	id, err := snapshot.SaveSnapshot(ctx, env.Repository, man)
+	must(t, err)
+	must(t, env.Repository.Flush(ctx))
+
+	srv, err := New(ctx, Options{RefreshInterval: time.Hour, UIUsername: "ui"})
+	must(t, err)
+	must(t, srv.SetRepository(ctx, env.Repository))
+
+	defer srv.StopAllSourceManagers(ctx)
+
+	hs := httptest.NewServer(srv.APIHandlers())
+	defer hs.Close()
+
+	url := hs.URL + "/api/v1/snapshots/" + string(id) + "/commitment"
+
+	for _, tc := range []struct {
+		user       string
+		wantStatus int
+	}{
+		{"ui", http.StatusOK},
+		{"user@host", http.StatusOK},
+		{"other@host", http.StatusNotFound},
+	} {
+		if got := getStatusAs(ctx, t, url, tc.user); got != tc.wantStatus {
+			t.Errorf("unexpected status of commitment requested by %v: %v, want %v", tc.user, got, tc.wantStatus)
+		}
+	}
+}
+
+func getStatusAs(ctx context.Context, t *testing.T, url, user string) int {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	must(t, err)
+
+	req.SetBasicAuth(user, "password")
+
+	resp, err := http.DefaultClient.Do(req)
+	must(t, err)
+
+	resp.Body.Close() //nolint:errcheck
+
+	return resp.StatusCode
+}
@@ -4,6 +4,7 @@
 	"context"
 	"encoding/json"
 	"net/http"
+	"net/url"
 	"os"
 	"sort"
 
@@ -13,6 +14,7 @@
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/snapshot"
 	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotpause"
 )
 
 func (s *Server) handleSourcesList(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
@@ -26,20 +28,83 @@ func (s *Server) handleSourcesList(ctx context.Context, r *http.Request, body []
 	}
 
 	for _, v := range s.sourceManagers {
-		if !sourceMatchesURLFilter(v.src, r.URL.Query()) {
+		st := v.Status()
+
+		if !sourceStatusMatchesURLFilter(st, r.URL.Query()) {
 			continue
 		}
 
-		resp.Sources = append(resp.Sources, v.Status())
+		resp.Sources = append(resp.Sources, st)
 	}
 
 	sort.Slice(resp.Sources, func(i, j int) bool {
 		return resp.Sources[i].Source.String() < resp.Sources[j].Source.String()
 	})
 
+	pause, err := snapshotpause.Get(ctx, s.rep)
+	if err != nil {
+		return nil, internalServerError(err)
+	}
+
+	resp.Pause = pause
+
 	return resp, nil
 }
 
+func (s *Server) handleGroupsList(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
+	groups := map[string]*serverapi.GroupStatus{}
+
+	for _, v := range s.sourceManagers {
+		st := v.Status()
+
+		for _, g := range st.SchedulingPolicy.Groups {
+			gs := groups[g]
+			if gs == nil {
+				gs = &serverapi.GroupStatus{
+					Name:         g,
+					StatusCounts: map[string]int{},
+				}
+				groups[g] = gs
+			}
+
+			gs.Sources = append(gs.Sources, st.Source)
+			gs.StatusCounts[st.Status]++
+		}
+	}
+
+	resp := &serverapi.GroupsResponse{
+		Groups: []*serverapi.GroupStatus{},
+	}
+
+	for _, gs := range groups {
+		sort.Slice(gs.Sources, func(i, j int) bool {
+			return gs.Sources[i].String() < gs.Sources[j].String()
+		})
+
+		resp.Groups = append(resp.Groups, gs)
+	}
+
+	sort.Slice(resp.Groups, func(i, j int) bool {
+		return resp.Groups[i].Name < resp.Groups[j].Name
+	})
+
+	return resp, nil
+}
+
+// sourceStatusMatchesURLFilter returns true if the source matches the source filter and,
+// when the "group" parameter is provided, belongs to the specified group.
+func sourceStatusMatchesURLFilter(st *serverapi.SourceStatus, query url.Values) bool {
+	if !sourceMatchesURLFilter(st.Source, query) {
+		return false
+	}
+
+	if v := query.Get("group"); v != "" && !st.SchedulingPolicy.InGroup(v) {
+		return false
+	}
+
+	return true
+}
+
 func (s *Server) handleSourcesCreate(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
 	var req serverapi.CreateSnapshotSourceRequest
 
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are aggregated over all sources, because they are exposed without authentication
+// and must not reveal user names, host names or paths of the sources.
+var (
+	oldestSuccessfulSnapshotDesc = prometheus.NewDesc(
+		"kopia_sources_oldest_last_successful_snapshot_timestamp_seconds",
+		"Unix time of the end of the last successful snapshot of the source whose last successful snapshot is the oldest.",
+		nil, nil)
+
+	sourcesWithRPODesc = prometheus.NewDesc(
+		"kopia_sources_with_rpo",
+		"Number of sources with recovery point objective, the maximum allowed age of the last successful snapshot.",
+		nil, nil)
+
+	sourcesRPOViolatedDesc = prometheus.NewDesc(
+		"kopia_sources_rpo_violated",
+		"Number of sources without successful snapshot within their recovery point objective.",
+		nil, nil)
+)
+
+type sourceMetricsCollector struct {
+	s *Server
+}
+
+func (c sourceMetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- oldestSuccessfulSnapshotDesc
+	ch <- sourcesWithRPODesc
+	ch <- sourcesRPOViolatedDesc
+}
+
+func (c sourceMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.s.mu.RLock()
+	defer c.s.mu.RUnlock()
+
+	var (
+		oldest            int64
+		withRPO, violated int
+	)
+
+	for _, sm := range c.s.sourceManagers {
+		st := sm.Status()
+
+		if t := st.LastCompleteSnapshotTime; t != nil && (oldest == 0 || t.Unix() < oldest) {
+			oldest = t.Unix()
+		}
+
+		if st.SchedulingPolicy.MaxSnapshotAge() == 0 {
+			continue
+		}
+
+		withRPO++
+
+		if st.RPOViolated {
+			violated++
+		}
+	}
+
+	if oldest != 0 {
+		ch <- prometheus.MustNewConstMetric(oldestSuccessfulSnapshotDesc, prometheus.GaugeValue, float64(oldest))
+	}
+
+	ch <- prometheus.MustNewConstMetric(sourcesWithRPODesc, prometheus.GaugeValue, float64(withRPO))
+	ch <- prometheus.MustNewConstMetric(sourcesRPOViolatedDesc, prometheus.GaugeValue, float64(violated))
+}
+
+// MetricsCollector returns prometheus collector that exposes snapshot freshness and RPO status aggregated
+// over all sources.
+func (s *Server) MetricsCollector() prometheus.Collector {
+	return sourceMetricsCollector{s}
+}
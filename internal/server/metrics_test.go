@@ -0,0 +1,60 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+func TestMetricsDoNotRevealSources(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	si := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"}
+
+	man, err := snapshotfs.NewUploader(env.Repository).Upload(ctx, mockfs.NewDirectory(), policy.BuildTree(nil, policy.DefaultPolicy), si)
+	must(t, err)
+
+	_, err = snapshot.SaveSnapshot(ctx, env.Repository, man)
+	must(t, err)
+	must(t, env.Repository.Flush(ctx))
+
+	srv, err := New(ctx, Options{RefreshInterval: time.Hour})
+	must(t, err)
+	must(t, srv.SetRepository(ctx, env.Repository))
+
+	defer srv.StopAllSourceManagers(ctx)
+
+	ch := make(chan prometheus.Metric, 10)
+	srv.MetricsCollector().Collect(ch)
+	close(ch)
+
+	n := 0
+
+	for m := range ch {
+		var pb dto.Metric
+
+		must(t, m.Write(&pb))
+
+		if len(pb.Label) != 0 {
+			t.Errorf("metric %v has labels: %v", m.Desc(), pb.Label)
+		}
+
+		n++
+	}
+
+	if n == 0 {
+		t.Fatalf("no metrics collected")
+	}
+}
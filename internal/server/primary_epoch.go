@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// primaryEpochBlobID is the blob recording the server that was most recently promoted to accept writes.
+// It's only written by promotion, repositories served by a single server never have it.
+const primaryEpochBlobID blob.ID = "kopia.primary"
+
+// primaryEpoch identifies the server accepting writes, each promotion increments the epoch, which fences
+// servers promoted earlier.
+type primaryEpoch struct {
+	Epoch      int64     `json:"epoch"`
+	Owner      string    `json:"owner"`
+	PromotedAt time.Time `json:"promotedAt"`
+}
+
+func readPrimaryEpoch(ctx context.Context, st blob.Storage) (*primaryEpoch, error) {
+	b, err := st.GetBlob(ctx, primaryEpochBlobID, 0, -1)
+	if errors.Is(err, blob.ErrBlobNotFound) {
+		return &primaryEpoch{}, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read primary epoch")
+	}
+
+	pe := &primaryEpoch{}
+	if err := json.Unmarshal(b, pe); err != nil {
+		return nil, errors.Wrap(err, "invalid primary epoch")
+	}
+
+	return pe, nil
+}
+
+func serverOwner(rep repo.Repository) string {
+	co := rep.ClientOptions()
+	return co.Username + "@" + co.Hostname
+}
+
+// initPrimaryEpoch records the epoch the server is started with, a server that is not the owner of the
+// current epoch was superseded by another server promoted while it was down and starts as a standby.
+func (s *Server) initPrimaryEpoch(ctx context.Context) error {
+	dr, ok := s.rep.(*repo.DirectRepository)
+	if !ok {
+		return nil
+	}
+
+	pe, err := readPrimaryEpoch(ctx, dr.BlobStorage())
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreInt64(&s.primaryEpoch, pe.Epoch)
+
+	if pe.Epoch != 0 && pe.Owner != serverOwner(dr) && !s.isStandby() {
+		log(ctx).Errorf("%v was promoted to accept writes at %v, starting as a standby", pe.Owner, pe.PromotedAt)
+		atomic.StoreInt32(&s.standby, 1)
+	}
+
+	return nil
+}
+
+// checkFenced stops accepting writes when another server was promoted after this one and returns true.
+func (s *Server) checkFenced(ctx context.Context, rep repo.Repository) (bool, error) {
+	dr, ok := rep.(*repo.DirectRepository)
+	if !ok || s.isStandby() {
+		return false, nil
+	}
+
+	pe, err := readPrimaryEpoch(ctx, dr.BlobStorage())
+	if err != nil {
+		return false, err
+	}
+
+	if pe.Epoch <= atomic.LoadInt64(&s.primaryEpoch) {
+		return false, nil
+	}
+
+	if atomic.CompareAndSwapInt32(&s.standby, 0, 1) {
+		log(ctx).Errorf("%v was promoted to accept writes at %v, no longer accepting writes", pe.Owner, pe.PromotedAt)
+	}
+
+	return true, nil
+}
+
+// advancePrimaryEpoch makes the server the owner of the next epoch, which fences the previous primary.
+func (s *Server) advancePrimaryEpoch(ctx context.Context) error {
+	dr, ok := s.rep.(*repo.DirectRepository)
+	if !ok {
+		return nil
+	}
+
+	pe, err := readPrimaryEpoch(ctx, dr.BlobStorage())
+	if err != nil {
+		return err
+	}
+
+	next := &primaryEpoch{
+		Epoch:      pe.Epoch + 1,
+		Owner:      serverOwner(dr),
+		PromotedAt: clock.Now(),
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(next); err != nil {
+		return errors.Wrap(err, "unable to encode primary epoch")
+	}
+
+	if err := dr.BlobStorage().PutBlob(ctx, primaryEpochBlobID, gather.FromSlice(buf.Bytes()), blob.PutOptions{}); err != nil {
+		return errors.Wrap(err, "unable to write primary epoch")
+	}
+
+	atomic.StoreInt64(&s.primaryEpoch, next.Epoch)
+
+	return nil
+}
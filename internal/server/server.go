@@ -8,17 +8,21 @@
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 
+	"github.com/kopia/kopia/internal/authhook"
 	"github.com/kopia/kopia/internal/serverapi"
 	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/repo/logging"
 	"github.com/kopia/kopia/repo/maintenance"
 	"github.com/kopia/kopia/snapshot"
 	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotcommit"
 	"github.com/kopia/kopia/snapshot/snapshotmaintenance"
 )
 
@@ -32,6 +36,10 @@ type apiRequestFunc func(ctx context.Context, r *http.Request, body []byte) (int
 type Server struct {
 	OnShutdown func(ctx context.Context) error
 
+	// OnReload is invoked to reload server configuration (user database, TLS certificates, log levels)
+	// without interrupting requests in progress.
+	OnReload func(ctx context.Context, req *serverapi.ReloadRequest) error
+
 	options   Options
 	rep       repo.Repository
 	cancelRep context.CancelFunc
@@ -42,6 +50,16 @@ type Server struct {
 	sourceManagers  map[snapshot.SourceInfo]*sourceManager
 	mounts          sync.Map // object.ID -> mount.Controller
 	uploadSemaphore chan struct{}
+	clientsLastSeen sync.Map // user@host -> time.Time
+	uploadJournal   *uploadJournal
+	commitments     *snapshotcommit.Cache
+
+	// standby is non-zero while the server only serves reads, until it's promoted.
+	standby int32
+
+	// primaryEpoch is the epoch of the repository primary at the time the server started or was promoted,
+	// see primaryEpochBlobID.
+	primaryEpoch int64
 }
 
 // APIHandlers handles API requests.
@@ -50,22 +68,32 @@ func (s *Server) APIHandlers() http.Handler {
 
 	// sources
 	m.HandleFunc("/api/v1/sources", s.handleAPI(s.handleSourcesList)).Methods(http.MethodGet)
-	m.HandleFunc("/api/v1/sources", s.handleAPI(s.handleSourcesCreate)).Methods(http.MethodPost)
-	m.HandleFunc("/api/v1/sources/upload", s.handleAPI(s.handleUpload)).Methods(http.MethodPost)
+	m.HandleFunc("/api/v1/sources", s.handleAPIWrite(s.handleSourcesCreate)).Methods(http.MethodPost)
+	m.HandleFunc("/api/v1/sources/upload", s.handleAPIWrite(s.handleUpload)).Methods(http.MethodPost)
 	m.HandleFunc("/api/v1/sources/cancel", s.handleAPI(s.handleCancel)).Methods(http.MethodPost)
+	m.HandleFunc("/api/v1/sources/pause", s.handleUIAPIWrite(s.handlePause)).Methods(http.MethodPost)
+	m.HandleFunc("/api/v1/sources/resume", s.handleUIAPIWrite(s.handleResume)).Methods(http.MethodPost)
+
+	// source groups
+	m.HandleFunc("/api/v1/groups", s.handleAPI(s.handleGroupsList)).Methods(http.MethodGet)
 
 	// snapshots
 	m.HandleFunc("/api/v1/snapshots", s.handleAPI(s.handleSnapshotList)).Methods(http.MethodGet)
+	m.HandleFunc("/api/v1/snapshots/{snapshotID}/commitment", s.handleAPI(s.handleSnapshotCommitment)).Methods(http.MethodGet)
+	m.HandleFunc("/api/v1/snapshots/{snapshotID}/diff/{otherSnapshotID}", s.handleAPI(s.handleSnapshotDiff)).Methods(http.MethodGet)
+	m.HandleFunc("/api/v1/snapshots/{snapshotID}/file", s.handleSnapshotFileGet).Methods(http.MethodGet)
 
 	m.HandleFunc("/api/v1/policy", s.handleAPI(s.handlePolicyGet)).Methods(http.MethodGet)
-	m.HandleFunc("/api/v1/policy", s.handleAPI(s.handlePolicyPut)).Methods(http.MethodPut)
-	m.HandleFunc("/api/v1/policy", s.handleAPI(s.handlePolicyDelete)).Methods(http.MethodDelete)
+	m.HandleFunc("/api/v1/policy", s.handleAPIWrite(s.handlePolicyPut)).Methods(http.MethodPut)
+	m.HandleFunc("/api/v1/policy", s.handleAPIWrite(s.handlePolicyDelete)).Methods(http.MethodDelete)
 
 	m.HandleFunc("/api/v1/policies", s.handleAPI(s.handlePolicyList)).Methods(http.MethodGet)
 
 	m.HandleFunc("/api/v1/refresh", s.handleAPI(s.handleRefresh)).Methods(http.MethodPost)
-	m.HandleFunc("/api/v1/flush", s.handleAPI(s.handleFlush)).Methods(http.MethodPost)
+	m.HandleFunc("/api/v1/flush", s.handleAPIWrite(s.handleFlush)).Methods(http.MethodPost)
+	m.HandleFunc("/api/v1/promote", s.handleUIAPI(s.handlePromote)).Methods(http.MethodPost)
 	m.HandleFunc("/api/v1/shutdown", s.handleAPIPossiblyNotConnected(s.handleShutdown)).Methods(http.MethodPost)
+	m.HandleFunc("/api/v1/reload", s.handleAPIPossiblyNotConnected(s.handleReload)).Methods(http.MethodPost)
 
 	m.HandleFunc("/api/v1/objects/{objectID}", s.handleObjectGet).Methods(http.MethodGet)
 
@@ -73,20 +101,22 @@ func (s *Server) APIHandlers() http.Handler {
 	m.HandleFunc("/api/v1/repo/connect", s.handleAPIPossiblyNotConnected(s.handleRepoConnect)).Methods(http.MethodPost)
 	m.HandleFunc("/api/v1/repo/exists", s.handleAPIPossiblyNotConnected(s.handleRepoExists)).Methods(http.MethodPost)
 	m.HandleFunc("/api/v1/repo/create", s.handleAPIPossiblyNotConnected(s.handleRepoCreate)).Methods(http.MethodPost)
-	m.HandleFunc("/api/v1/repo/description", s.handleAPI(s.handleRepoSetDescription)).Methods(http.MethodPost)
+	m.HandleFunc("/api/v1/repo/description", s.handleAPIWrite(s.handleRepoSetDescription)).Methods(http.MethodPost)
 
 	m.HandleFunc("/api/v1/repo/disconnect", s.handleAPI(s.handleRepoDisconnect)).Methods(http.MethodPost)
 	m.HandleFunc("/api/v1/repo/algorithms", s.handleAPIPossiblyNotConnected(s.handleRepoSupportedAlgorithms)).Methods(http.MethodGet)
-	m.HandleFunc("/api/v1/repo/sync", s.handleAPI(s.handleRepoSync)).Methods(http.MethodPost)
+	m.HandleFunc("/api/v1/repo/sync", s.handleAPIWrite(s.handleRepoSync)).Methods(http.MethodPost)
 	m.HandleFunc("/api/v1/repo/parameters", s.handleAPI(s.handleRepoParameters)).Methods(http.MethodGet)
+	m.HandleFunc("/api/v1/repo/zstd-dictionaries", s.handleAPI(s.handleRepoZstdDictionaries)).Methods(http.MethodGet)
 
 	m.HandleFunc("/api/v1/contents/{contentID}", s.handleAPI(s.handleContentInfo)).Methods(http.MethodGet).Queries("info", "1")
 	m.HandleFunc("/api/v1/contents/{contentID}", s.handleAPI(s.handleContentGet)).Methods(http.MethodGet)
-	m.HandleFunc("/api/v1/contents/{contentID}", s.handleAPI(s.handleContentPut)).Methods(http.MethodPut)
+	m.HandleFunc("/api/v1/contents/{contentID}", s.handleAPIWrite(s.handleContentPut)).Methods(http.MethodPut)
 
 	m.HandleFunc("/api/v1/manifests/{manifestID}", s.handleAPI(s.handleManifestGet)).Methods(http.MethodGet)
-	m.HandleFunc("/api/v1/manifests/{manifestID}", s.handleAPI(s.handleManifestDelete)).Methods(http.MethodDelete)
-	m.HandleFunc("/api/v1/manifests", s.handleAPI(s.handleManifestCreate)).Methods(http.MethodPost)
+	m.HandleFunc("/api/v1/manifests/{manifestID}", s.handleAPIWrite(s.handleManifestDelete)).Methods(http.MethodDelete)
+	m.HandleFunc("/api/v1/manifests/{manifestID}", s.handleAPIWrite(s.handleManifestReplace)).Methods(http.MethodPut)
+	m.HandleFunc("/api/v1/manifests", s.handleAPIWrite(s.handleManifestCreate)).Methods(http.MethodPost)
 	m.HandleFunc("/api/v1/manifests", s.handleAPI(s.handleManifestList)).Methods(http.MethodGet)
 
 	m.HandleFunc("/api/v1/mounts", s.handleAPI(s.handleMountCreate)).Methods(http.MethodPost)
@@ -94,6 +124,9 @@ func (s *Server) APIHandlers() http.Handler {
 	m.HandleFunc("/api/v1/mounts/{rootObjectID}", s.handleAPI(s.handleMountGet)).Methods(http.MethodGet)
 	m.HandleFunc("/api/v1/mounts", s.handleAPI(s.handleMountList)).Methods(http.MethodGet)
 
+	m.HandleFunc("/api/v1/clients", s.handleAPI(s.handleClientList)).Methods(http.MethodGet)
+	m.HandleFunc("/api/v1/clients/report", s.handleAPIWrite(s.handleClientReport)).Methods(http.MethodPost)
+
 	m.HandleFunc("/api/v1/current-user", s.handleAPIPossiblyNotConnected(s.handleCurrentUser)).Methods(http.MethodGet)
 
 	return m
@@ -109,6 +142,40 @@ func (s *Server) handleAPI(f apiRequestFunc) http.HandlerFunc {
 	})
 }
 
+// handleAPIWrite handles API requests that modify the repository, which are rejected while the server is a standby.
+func (s *Server) handleAPIWrite(f apiRequestFunc) http.HandlerFunc {
+	return s.handleAPI(func(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
+		if s.isStandby() {
+			return nil, standbyError()
+		}
+
+		return f(ctx, r, body)
+	})
+}
+
+// handleUIAPI handles administrative API requests, which are rejected unless made by the UI.
+func (s *Server) handleUIAPI(f apiRequestFunc) http.HandlerFunc {
+	return s.handleAPI(func(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
+		if s.requestUserAtHost(r) != "" {
+			return nil, accessDeniedError("operation requires administrative access")
+		}
+
+		return f(ctx, r, body)
+	})
+}
+
+// handleUIAPIWrite handles administrative API requests that modify the repository, which are rejected
+// unless made by the UI and while the server is a standby.
+func (s *Server) handleUIAPIWrite(f apiRequestFunc) http.HandlerFunc {
+	return s.handleUIAPI(func(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
+		if s.isStandby() {
+			return nil, standbyError()
+		}
+
+		return f(ctx, r, body)
+	})
+}
+
 func (s *Server) handleAPIPossiblyNotConnected(f apiRequestFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// we must pre-read request body before acquiring the lock as it sometimes leads to deadlock
@@ -127,6 +194,21 @@ func (s *Server) handleAPIPossiblyNotConnected(f apiRequestFunc) http.HandlerFun
 
 		log(ctx).Debugf("request %v (%v bytes)", r.URL, len(body))
 
+		s.recordClientSeen(r)
+
+		if aerr := s.authorize(ctx, r, body); aerr != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(aerr.httpErrorCode)
+
+			_ = json.NewEncoder(w).Encode(&serverapi.ErrorResponse{
+				Code:  aerr.apiErrorCode,
+				Error: aerr.message,
+			})
+
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		e := json.NewEncoder(w)
 		e.SetIndent("", "  ")
@@ -166,10 +248,63 @@ func (s *Server) handleRefresh(ctx context.Context, r *http.Request, body []byte
 }
 
 func (s *Server) handleFlush(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
+	var journalSeq uint64
+
+	// contents written before another server was promoted are not committed by this one.
+	if fenced, err := s.checkFenced(ctx, s.rep); err != nil {
+		return nil, internalServerError(err)
+	} else if fenced {
+		return nil, standbyError()
+	}
+
+	if s.uploadJournal != nil {
+		// contents journaled after this point may not be included in the flush.
+		journalSeq = s.uploadJournal.lastSeq()
+	}
+
 	if err := s.rep.Flush(ctx); err != nil {
 		return nil, internalServerError(err)
 	}
 
+	if s.uploadJournal != nil {
+		s.uploadJournal.flushed(ctx, journalSeq)
+	}
+
+	return &serverapi.Empty{}, nil
+}
+
+func (s *Server) isStandby() bool {
+	return atomic.LoadInt32(&s.standby) != 0
+}
+
+// handlePromote promotes a standby server to accept writes, which also starts scheduled snapshots and maintenance.
+func (s *Server) handlePromote(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
+	if !s.isStandby() {
+		return nil, requestError(serverapi.ErrorMalformedRequest, "server is not a standby")
+	}
+
+	if s.rep.ClientOptions().ReadOnly {
+		return nil, requestError(serverapi.ErrorMalformedRequest, "repository is connected in read-only mode, reconnect it to accept writes")
+	}
+
+	// pick up the latest state replicated from the primary before accepting writes.
+	if err := s.rep.Refresh(ctx); err != nil {
+		return nil, internalServerError(err)
+	}
+
+	// fence the previous primary, which stops accepting writes once it notices the new epoch.
+	if err := s.advancePrimaryEpoch(ctx); err != nil {
+		return nil, internalServerError(err)
+	}
+
+	if err := s.replayUploadJournal(ctx); err != nil {
+		return nil, internalServerError(err)
+	}
+
+	atomic.StoreInt32(&s.standby, 0)
+
+	log(ctx).Infof("standby server promoted, accepting writes")
+
 	return &serverapi.Empty{}, nil
 }
 
@@ -187,13 +322,37 @@ func (s *Server) handleShutdown(ctx context.Context, r *http.Request, body []byt
 	return &serverapi.Empty{}, nil
 }
 
+func (s *Server) handleReload(ctx context.Context, r *http.Request, body []byte) (interface{}, *apiError) {
+	var req serverapi.ReloadRequest
+
+	if s.requestUserAtHost(r) != "" {
+		return nil, accessDeniedError("operation requires administrative access")
+	}
+
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, requestError(serverapi.ErrorMalformedRequest, "malformed request body")
+	}
+
+	if s.OnReload == nil {
+		return nil, requestError(serverapi.ErrorMalformedRequest, "reload is not supported")
+	}
+
+	log(ctx).Infof("reloading configuration due to API request")
+
+	if err := s.OnReload(ctx, &req); err != nil {
+		return nil, internalServerError(err)
+	}
+
+	return &serverapi.Empty{}, nil
+}
+
 func (s *Server) forAllSourceManagersMatchingURLFilter(ctx context.Context, c func(s *sourceManager, ctx context.Context) serverapi.SourceActionResponse, values url.Values) (interface{}, *apiError) {
 	resp := &serverapi.MultipleSourceActionResponse{
 		Sources: map[string]serverapi.SourceActionResponse{},
 	}
 
 	for src, mgr := range s.sourceManagers {
-		if !sourceMatchesURLFilter(src, values) {
+		if !sourceStatusMatchesURLFilter(mgr.Status(), values) {
 			continue
 		}
 
@@ -259,6 +418,20 @@ func (s *Server) SetRepository(ctx context.Context, rep repo.Repository) error {
 		return nil
 	}
 
+	if err := s.initPrimaryEpoch(ctx); err != nil {
+		s.rep = nil
+
+		return err
+	}
+
+	if !s.isStandby() {
+		if err := s.replayUploadJournal(ctx); err != nil {
+			s.rep = nil
+
+			return err
+		}
+	}
+
 	if err := s.syncSourcesLocked(ctx); err != nil {
 		s.stopAllSourceManagersLocked(ctx)
 		s.rep = nil
@@ -273,6 +446,38 @@ func (s *Server) SetRepository(ctx context.Context, rep repo.Repository) error {
 	return nil
 }
 
+// replayUploadJournal writes contents uploaded by API clients but not flushed before the server stopped,
+// so that clients reconnecting after restart can complete their flush without uploading them again.
+func (s *Server) replayUploadJournal(ctx context.Context) error {
+	dr, ok := s.rep.(*repo.DirectRepository)
+	if !ok || s.uploadJournal == nil {
+		return nil
+	}
+
+	journalSeq := s.uploadJournal.lastSeq()
+
+	n, err := s.uploadJournal.replay(ctx, func(data []byte, prefix content.ID) (content.ID, error) {
+		return dr.Content.WriteContent(ctx, data, prefix)
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to replay upload journal")
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	log(ctx).Infof("recovered %v contents from upload journal", n)
+
+	if err := dr.Flush(ctx); err != nil {
+		return errors.Wrap(err, "unable to flush recovered contents")
+	}
+
+	s.uploadJournal.flushed(ctx, journalSeq)
+
+	return nil
+}
+
 func (s *Server) refreshPeriodically(ctx context.Context, r repo.Repository) {
 	for {
 		select {
@@ -284,6 +489,10 @@ func (s *Server) refreshPeriodically(ctx context.Context, r repo.Repository) {
 				log(ctx).Warningf("error refreshing repository: %v", err)
 			}
 
+			if _, err := s.checkFenced(ctx, r); err != nil {
+				log(ctx).Warningf("unable to check primary epoch: %v", err)
+			}
+
 			if err := s.SyncSources(ctx); err != nil {
 				log(ctx).Warningf("unable to sync sources: %v", err)
 			}
@@ -298,6 +507,10 @@ func (s *Server) periodicMaintenance(ctx context.Context, r repo.Repository) {
 			return
 
 		case <-time.After(maintenanceAttemptFrequency):
+			if s.isStandby() {
+				continue
+			}
+
 			if err := snapshotmaintenance.Run(ctx, r, maintenance.ModeAuto, false); err != nil {
 				log(ctx).Warningf("unable to run maintenance: %v", err)
 			}
@@ -396,6 +609,27 @@ type Options struct {
 	ConfigFile      string
 	ConnectOptions  *repo.ConnectOptions
 	RefreshInterval time.Duration
+
+	// RepositoryOptions are used when opening the repository after connecting to it using the API.
+	RepositoryOptions *repo.Options
+
+	// AuthorizationHook, when set, is consulted before each API request is handled.
+	AuthorizationHook authhook.Hook
+
+	// UIUsername is the username used by the UI and administrators, which is not restricted to manifests
+	// of a single user and may perform administrative actions.
+	UIUsername string
+
+	// UploadJournalDir, when set, enables persisting contents written by API clients until they are
+	// flushed, so that they survive server restart.
+	UploadJournalDir string
+
+	// ParallelUploads, when non-zero, overrides the number of files uploaded in parallel by snapshots of all sources.
+	ParallelUploads int
+
+	// Standby starts the server as a read-only standby of a replicated repository, which serves restores and
+	// browsing but doesn't take snapshots, run maintenance or accept writes until it's promoted.
+	Standby bool
 }
 
 // New creates a Server.
@@ -405,6 +639,20 @@ func New(ctx context.Context, options Options) (*Server, error) {
 		options:         options,
 		sourceManagers:  map[snapshot.SourceInfo]*sourceManager{},
 		uploadSemaphore: make(chan struct{}, 1),
+		commitments:     snapshotcommit.NewCache(),
+	}
+
+	if options.Standby {
+		s.standby = 1
+	}
+
+	if options.UploadJournalDir != "" {
+		j, err := newUploadJournal(options.UploadJournalDir)
+		if err != nil {
+			return nil, err
+		}
+
+		s.uploadJournal = j
 	}
 
 	return s, nil
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/serverapi"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+func TestReloadRequiresAdministrativeAccess(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	srv, err := New(ctx, Options{RefreshInterval: time.Hour, UIUsername: "ui"})
+	must(t, err)
+
+	reloads := 0
+	srv.OnReload = func(ctx context.Context, req *serverapi.ReloadRequest) error {
+		reloads++
+		return nil
+	}
+
+	hs := httptest.NewServer(srv.APIHandlers())
+	defer hs.Close()
+
+	for _, tc := range []struct {
+		user       string
+		wantStatus int
+	}{
+		{"user@host", http.StatusForbidden},
+		{"ui", http.StatusOK},
+	} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, hs.URL+"/api/v1/reload", strings.NewReader("{}"))
+		must(t, err)
+
+		req.SetBasicAuth(tc.user, "password")
+
+		resp, err := http.DefaultClient.Do(req)
+		must(t, err)
+
+		resp.Body.Close() //nolint:errcheck
+
+		if resp.StatusCode != tc.wantStatus {
+			t.Errorf("unexpected status of reload requested by %v: %v, want %v", tc.user, resp.StatusCode, tc.wantStatus)
+		}
+	}
+
+	if reloads != 1 {
+		t.Errorf("unexpected number of reloads: %v", reloads)
+	}
+}
@@ -2,25 +2,35 @@
 
 import (
 	"context"
+	"fmt"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/kopia/kopia/fs"
 	"github.com/kopia/kopia/fs/localfs"
 	"github.com/kopia/kopia/internal/clock"
 	"github.com/kopia/kopia/internal/ctxutil"
+	"github.com/kopia/kopia/internal/hostconditions"
 	"github.com/kopia/kopia/internal/serverapi"
 	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/apprecipe"
 	"github.com/kopia/kopia/snapshot/policy"
 	"github.com/kopia/kopia/snapshot/snapshotfs"
+	"github.com/kopia/kopia/snapshot/snapshothealth"
+	"github.com/kopia/kopia/snapshot/snapshotpause"
+	"github.com/kopia/kopia/snapshot/uploadhints"
 )
 
 const (
-	statusRefreshInterval       = 15 * time.Second // how frequently to refresh source status
-	failedSnapshotRetryInterval = 5 * time.Minute
-	refreshTimeout              = 30 * time.Second // max amount of time to refresh a single source
-	oneDay                      = 24 * time.Hour
+	statusRefreshInterval         = 15 * time.Second // how frequently to refresh source status
+	failedSnapshotRetryInterval   = 5 * time.Minute
+	refreshTimeout                = 30 * time.Second // max amount of time to refresh a single source
+	deferredSnapshotRetryInterval = time.Minute      // how frequently to re-check conditions of deferred snapshots
+	pauseCheckInterval            = time.Minute      // how frequently running uploads check whether snapshots have been paused
+	oneDay                        = 24 * time.Hour
 )
 
 // sourceManager manages the state machine of each source
@@ -45,9 +55,13 @@ type sourceManager struct {
 	pol                                policy.SchedulingPolicy
 	state                              string
 	nextSnapshotTime                   *time.Time
+	deferredUntil                      time.Time
+	deferReason                        string
 	lastSnapshot                       *snapshot.Manifest
 	lastCompleteSnapshot               *snapshot.Manifest
 	manifestsSinceLastCompleteSnapshot []*snapshot.Manifest
+	created                            time.Time
+	rpoViolationReported               bool
 
 	progress *snapshotfs.CountingUploadProgress
 }
@@ -62,6 +76,13 @@ func (s *sourceManager) Status() *serverapi.SourceStatus {
 		NextSnapshotTime: s.nextSnapshotTime,
 		SchedulingPolicy: s.pol,
 		LastSnapshot:     s.lastSnapshot,
+		DeferReason:      s.deferReason,
+		RPOViolated:      s.rpoViolatedLocked(clock.Now()),
+	}
+
+	if s.lastCompleteSnapshot != nil {
+		t := s.lastCompleteSnapshot.EndTime
+		st.LastCompleteSnapshotTime = &t
 	}
 
 	if st.Status == "UPLOADING" {
@@ -73,12 +94,52 @@ func (s *sourceManager) Status() *serverapi.SourceStatus {
 	return st
 }
 
+// rpoViolatedLocked returns true if there has been no successful snapshot within the recovery point objective.
+// Sources that have never been successfully snapshotted are measured from the time the server started managing them.
+func (s *sourceManager) rpoViolatedLocked(now time.Time) bool {
+	maxAge := s.pol.MaxSnapshotAge()
+	if maxAge == 0 {
+		return false
+	}
+
+	last := s.created
+	if s.lastCompleteSnapshot != nil {
+		last = s.lastCompleteSnapshot.EndTime
+	}
+
+	return now.Sub(last) > maxAge
+}
+
+// checkRPO reports when the source starts or stops violating its recovery point objective.
+func (s *sourceManager) checkRPO(ctx context.Context) {
+	s.mu.Lock()
+	violated := s.rpoViolatedLocked(clock.Now())
+	changed := violated != s.rpoViolationReported
+	s.rpoViolationReported = violated
+	maxAge := s.pol.MaxSnapshotAge()
+	s.mu.Unlock()
+
+	switch {
+	case !changed:
+	case violated:
+		log(ctx).Warningf("RPO violation: no successful snapshot of %v within %v", s.src, maxAge)
+	default:
+		log(ctx).Infof("RPO of %v is met again", s.src)
+	}
+}
+
 func (s *sourceManager) setStatus(stat string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.state = stat
 }
 
+func (s *sourceManager) setDeferReason(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deferReason = reason
+}
+
 func (s *sourceManager) currentUploader() *snapshotfs.Uploader {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -115,11 +176,18 @@ func (s *sourceManager) run(ctx context.Context) {
 func (s *sourceManager) runLocal(ctx context.Context) {
 	s.refreshStatus(ctx)
 
+	// snapshots requested explicitly are not subject to host conditions, only to pausing.
+	requested := false
+
 	for {
 		var waitTime time.Duration
 
 		if s.nextSnapshotTime != nil {
 			waitTime = clock.Until(*s.nextSnapshotTime)
+			if d := clock.Until(s.deferredUntil); d > waitTime {
+				waitTime = d
+			}
+
 			log(ctx).Debugf("time to next snapshot %v is %v", s.src, waitTime)
 		} else {
 			log(ctx).Debugf("no scheduled snapshot for %v", s.src)
@@ -134,6 +202,8 @@ func (s *sourceManager) runLocal(ctx context.Context) {
 		case <-s.snapshotRequests:
 			nt := clock.Now()
 			s.nextSnapshotTime = &nt
+			s.deferredUntil = time.Time{}
+			requested = true
 
 			continue
 
@@ -141,6 +211,18 @@ func (s *sourceManager) runLocal(ctx context.Context) {
 			s.refreshStatus(ctx)
 
 		case <-time.After(waitTime):
+			if reason := s.snapshotDeferReason(ctx, requested); reason != "" {
+				log(ctx).Infof("deferring snapshot of %v: %v", s.src, reason)
+				s.setDeferReason(reason)
+				s.deferredUntil = clock.Now().Add(deferredSnapshotRetryInterval)
+
+				continue
+			}
+
+			requested = false
+			s.deferredUntil = time.Time{}
+			s.setDeferReason("")
+
 			log(ctx).Debugf("snapshotting %v", s.src)
 
 			if err := s.snapshot(ctx); err != nil {
@@ -154,6 +236,58 @@ func (s *sourceManager) runLocal(ctx context.Context) {
 	}
 }
 
+// snapshotDeferReason returns the reason why the snapshot should be deferred or empty string if it can proceed.
+// All snapshots are deferred while paused or while the server is a standby, scheduled ones also according to the scheduling policy and current host conditions.
+func (s *sourceManager) snapshotDeferReason(ctx context.Context, requested bool) string {
+	if s.server.isStandby() {
+		return "server is a read-only standby"
+	}
+
+	if pause, err := snapshotpause.Get(ctx, s.server.rep); err != nil {
+		log(ctx).Warningf("unable to determine whether snapshots are paused: %v", err)
+	} else if pause != nil {
+		return pauseDescription(pause)
+	}
+
+	if requested {
+		return ""
+	}
+
+	s.mu.RLock()
+	pol := s.pol
+	s.mu.RUnlock()
+
+	minBattery := pol.MinBatteryPercentOrDefault(0)
+	skipMetered := pol.SkipOnMeteredConnectionOrDefault(false)
+
+	if minBattery == 0 && !skipMetered {
+		return ""
+	}
+
+	return deferReasonForConditions(minBattery, skipMetered, hostconditions.Get(ctx))
+}
+
+func pauseDescription(st *snapshotpause.State) string {
+	desc := fmt.Sprintf("snapshots paused by %v", st.PausedBy)
+	if st.Reason != "" {
+		desc += ": " + st.Reason
+	}
+
+	return desc
+}
+
+func deferReasonForConditions(minBattery int, skipMetered bool, c hostconditions.Conditions) string {
+	if minBattery > 0 && c.OnBattery && c.BatteryPercent != hostconditions.UnknownBatteryPercent && c.BatteryPercent < minBattery {
+		return fmt.Sprintf("on battery power with %v%% charge remaining (minimum %v%%)", c.BatteryPercent, minBattery)
+	}
+
+	if skipMetered && c.Metered {
+		return "network connection is metered"
+	}
+
+	return ""
+}
+
 func (s *sourceManager) backoffBeforeNextSnapshot() {
 	if s.nextSnapshotTime == nil {
 		return
@@ -235,29 +369,80 @@ func (s *sourceManager) snapshot(ctx context.Context) error {
 	default:
 	}
 
-	localEntry, err := localfs.NewEntry(s.src.Path)
-	if err != nil {
-		return errors.Wrap(err, "unable to create local filesystem")
-	}
-
 	u := snapshotfs.NewUploader(s.server.rep)
+	u.ParallelUploads = s.server.options.ParallelUploads
 
 	policyTree, err := policy.TreeForSource(ctx, s.server.rep, s.src)
 	if err != nil {
 		return errors.Wrap(err, "unable to create policy getter")
 	}
 
+	sess, err := apprecipe.StartForPolicy(ctx, policyTree.EffectivePolicy())
+	if err != nil {
+		return errors.Wrap(err, "unable to prepare application for snapshot")
+	}
+
+	var localEntry fs.Entry
+
+	if sess != nil {
+		defer sess.Finish(ctx)
+
+		localEntry = sess.Root(filepath.Base(s.src.Path))
+	} else {
+		localEntry, err = localfs.NewEntry(s.src.Path)
+		if err != nil {
+			return errors.Wrap(err, "unable to create local filesystem")
+		}
+	}
+
 	u.Progress = s.progress
 
+	u.Hints, err = uploadhints.Open(ctx, s.server.rep, s.src, policyTree.EffectivePolicy())
+	if err != nil {
+		return errors.Wrap(err, "unable to load upload hints")
+	}
+
 	log(ctx).Debugf("starting upload of %v", s.src)
 	s.setUploader(u)
+
+	watchCtx, cancelWatch := context.WithCancel(ctx)
+	go snapshotpause.Watch(watchCtx, s.server.rep, pauseCheckInterval, func(st *snapshotpause.State) {
+		log(ctx).Infof("checkpointing upload of %v because %v", s.src, pauseDescription(st))
+		u.Cancel()
+	})
+
 	manifest, err := u.Upload(ctx, localEntry, policyTree, s.src, s.manifestsSinceLastCompleteSnapshot...)
+
+	cancelWatch()
 	s.setUploader(nil)
 
 	if err != nil {
 		return errors.Wrap(err, "upload error")
 	}
 
+	if err := u.Hints.Save(ctx, s.server.rep, manifest); err != nil {
+		log(ctx).Warningf("unable to save upload hints of %v: %v", s.src, err)
+	}
+
+	if sess != nil {
+		if err := sess.Err(); err != nil {
+			return errors.Wrap(err, "application dump failed")
+		}
+
+		manifest.Application = sess.Info()
+	}
+
+	if pol := policyTree.EffectivePolicy(); pol.AnomalyPolicy.DetectOrDefault(false) && manifest.IncompleteReason == "" {
+		r, err := snapshothealth.DetectAnomalies(ctx, s.server.rep, manifest, pol.AnomalyPolicy.DetectionOptions())
+		if err != nil {
+			return errors.Wrap(err, "unable to detect anomalies")
+		}
+
+		for _, a := range r.Anomalies {
+			log(ctx).Warningf("anomaly detected in %v: %v", s.src, a.Description)
+		}
+	}
+
 	snapshotID, err := snapshot.SaveSnapshot(ctx, s.server.rep, manifest)
 	if err != nil {
 		return errors.Wrap(err, "unable to save snapshot")
@@ -343,6 +528,8 @@ func (s *sourceManager) refreshStatus(ctx context.Context) {
 		s.nextSnapshotTime = nil
 		s.lastSnapshot = nil
 	}
+
+	s.checkRPO(ctx)
 }
 
 func newSourceManager(src snapshot.SourceInfo, server *Server) *sourceManager {
@@ -353,6 +540,7 @@ func newSourceManager(src snapshot.SourceInfo, server *Server) *sourceManager {
 		closed:           make(chan struct{}),
 		snapshotRequests: make(chan struct{}, 1),
 		progress:         &snapshotfs.CountingUploadProgress{},
+		created:          clock.Now(),
 	}
 
 	return m
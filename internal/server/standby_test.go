@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/apiclient"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/serverapi"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo"
+)
+
+func TestStandbyServer(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	mid, err := env.Repository.PutManifest(ctx, map[string]string{"type": "test", "username": "user", "hostname": "host"}, map[string]string{"k": "v"})
+	must(t, err)
+	must(t, env.Repository.Flush(ctx))
+
+	srv, err := New(ctx, Options{RefreshInterval: time.Hour, Standby: true})
+	must(t, err)
+	must(t, srv.SetRepository(ctx, env.Repository))
+
+	defer srv.StopAllSourceManagers(ctx)
+
+	standby := httptest.NewServer(srv.APIHandlers())
+	defer standby.Close()
+
+	// primary server that is down.
+	primary := httptest.NewServer(nil)
+	primary.Close()
+
+	configFile := filepath.Join(t.TempDir(), "kopia.config")
+
+	must(t, repo.ConnectAPIServer(ctx, configFile, &repo.APIServerInfo{
+		BaseURL:     primary.URL,
+		StandbyURLs: []string{standby.URL},
+	}, "password", &repo.ConnectOptions{
+		ClientOptions: repo.ClientOptions{Username: "user", Hostname: "host"},
+	}))
+
+	rep, err := repo.Open(ctx, configFile, "password", nil)
+	must(t, err)
+
+	defer rep.Close(ctx)
+
+	// reads fail over to the standby server.
+	var payload map[string]string
+
+	if _, err = rep.GetManifest(ctx, mid, &payload); err != nil {
+		t.Fatalf("unable to read manifest from standby: %v", err)
+	}
+
+	if payload["k"] != "v" {
+		t.Fatalf("unexpected manifest payload: %v", payload)
+	}
+
+	cli, err := apiclient.NewKopiaAPIClient(apiclient.Options{BaseURL: standby.URL})
+	must(t, err)
+
+	var status serverapi.StatusResponse
+
+	must(t, cli.Get(ctx, "repo/status", nil, &status))
+
+	if !status.Standby {
+		t.Fatalf("server is not reported as standby")
+	}
+
+	// standby rejects writes until promoted.
+	if err = cli.Post(ctx, "flush", &serverapi.Empty{}, &serverapi.Empty{}); err == nil || !strings.Contains(err.Error(), "standby") {
+		t.Fatalf("unexpected flush error on standby: %v", err)
+	}
+
+	must(t, cli.Post(ctx, "promote", &serverapi.Empty{}, &serverapi.Empty{}))
+	must(t, cli.Post(ctx, "flush", &serverapi.Empty{}, &serverapi.Empty{}))
+
+	if err = cli.Post(ctx, "promote", &serverapi.Empty{}, &serverapi.Empty{}); err == nil {
+		t.Fatalf("promoting server that is not a standby succeeded")
+	}
+}
+
+func TestStandbyPromotionFencesPrimary(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	newServer := func(standby bool) (*Server, *apiclient.KopiaAPIClient, *apiclient.KopiaAPIClient) {
+		srv, err := New(ctx, Options{RefreshInterval: time.Hour, Standby: standby, UIUsername: "kopia"})
+		must(t, err)
+		must(t, srv.SetRepository(ctx, env.Repository))
+
+		t.Cleanup(func() { srv.StopAllSourceManagers(ctx) })
+
+		hs := httptest.NewServer(srv.APIHandlers())
+		t.Cleanup(hs.Close)
+
+		ui, err := apiclient.NewKopiaAPIClient(apiclient.Options{BaseURL: hs.URL, Username: "kopia", Password: "password"})
+		must(t, err)
+
+		user, err := apiclient.NewKopiaAPIClient(apiclient.Options{BaseURL: hs.URL, Username: "user@host", Password: "password"})
+		must(t, err)
+
+		return srv, ui, user
+	}
+
+	primary, primaryUI, _ := newServer(false)
+	_, standbyUI, standbyUser := newServer(true)
+
+	must(t, primaryUI.Post(ctx, "flush", &serverapi.Empty{}, &serverapi.Empty{}))
+
+	// only the UI can promote the server.
+	if err := standbyUser.Post(ctx, "promote", &serverapi.Empty{}, &serverapi.Empty{}); err == nil {
+		t.Fatalf("repository user promoted the standby")
+	}
+
+	must(t, standbyUI.Post(ctx, "promote", &serverapi.Empty{}, &serverapi.Empty{}))
+	must(t, standbyUI.Post(ctx, "flush", &serverapi.Empty{}, &serverapi.Empty{}))
+
+	// the previous primary stops accepting writes once it notices the promotion.
+	if err := primaryUI.Post(ctx, "flush", &serverapi.Empty{}, &serverapi.Empty{}); err == nil || !strings.Contains(err.Error(), "standby") {
+		t.Fatalf("unexpected flush error on fenced primary: %v", err)
+	}
+
+	if !primary.isStandby() {
+		t.Fatalf("fenced primary is not a standby")
+	}
+
+	// server restarted by the owner of the current epoch accepts writes.
+	_, restartedUI, _ := newServer(false)
+	must(t, restartedUI.Post(ctx, "flush", &serverapi.Empty{}, &serverapi.Empty{}))
+}
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/content"
+)
+
+const (
+	uploadJournalDirMode      = 0o700
+	uploadJournalFileMode     = 0o600
+	uploadJournalSeqDigits    = 16
+	uploadJournalSuffix       = ".journal"
+	uploadJournalRecordHeader = 8 // length and CRC32 of the record payload
+)
+
+// uploadJournal persists contents written by API clients until they are flushed, so that after server
+// restart they can be written again and clients can complete the flush without uploading them again.
+//
+// Contents are appended to segment files named "<sequence>.journal", where sequence is the sequence number
+// of the first content in the segment. Concurrent writers share a single fsync of the segment. A new segment
+// is started whenever a flush begins, which allows removing only the contents written before the flush started.
+type uploadJournal struct {
+	dir string
+
+	// syncMu serializes syncing and rotation of segments, writers waiting for it are often
+	// covered by the sync performed by another writer.
+	syncMu    sync.Mutex
+	syncedSeq uint64
+
+	mu           sync.Mutex
+	seq          uint64
+	segment      *os.File // nil if no content was added since the segment was rotated
+	segmentStart uint64
+	newSegment   bool // segment was created since the last sync of the directory
+}
+
+type uploadJournalSegment struct {
+	startSeq uint64
+	fileName string
+}
+
+func newUploadJournal(dir string) (*uploadJournal, error) {
+	if err := os.MkdirAll(dir, uploadJournalDirMode); err != nil {
+		return nil, errors.Wrap(err, "unable to create upload journal directory")
+	}
+
+	j := &uploadJournal{dir: dir}
+
+	segments, err := j.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(segments) > 0 {
+		last := segments[len(segments)-1]
+
+		n, err := j.readSegment(context.Background(), last, func(contentID content.ID, data []byte) error { return nil })
+		if err != nil {
+			return nil, err
+		}
+
+		j.seq = last.startSeq + uint64(n) - 1
+		j.syncedSeq = j.seq
+	}
+
+	return j, nil
+}
+
+// add persists the content before it's acknowledged to the client.
+func (j *uploadJournal) add(contentID content.ID, data []byte) error {
+	j.mu.Lock()
+
+	if j.segment == nil {
+		f, err := os.OpenFile(filepath.Join(j.dir, segmentFileName(j.seq+1)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, uploadJournalFileMode) //nolint:gosec
+		if err != nil {
+			j.mu.Unlock()
+			return errors.Wrap(err, "unable to create upload journal segment")
+		}
+
+		j.segment = f
+		j.segmentStart = j.seq + 1
+		j.newSegment = true
+	}
+
+	if _, err := j.segment.Write(encodeJournalRecord(contentID, data)); err != nil {
+		j.mu.Unlock()
+		return errors.Wrap(err, "unable to write upload journal")
+	}
+
+	j.seq++
+	seq, segmentStart := j.seq, j.segmentStart
+	j.mu.Unlock()
+
+	return j.syncUpTo(seq, segmentStart)
+}
+
+// syncUpTo makes contents up to the provided sequence number in the segment durable, unless another writer
+// or rotation of the segment already did.
+func (j *uploadJournal) syncUpTo(seq, segmentStart uint64) error {
+	j.syncMu.Lock()
+	defer j.syncMu.Unlock()
+
+	if j.syncedSeq >= seq {
+		return nil
+	}
+
+	j.mu.Lock()
+	f, target, newSegment := j.segment, j.seq, j.newSegment
+	current := f != nil && j.segmentStart == segmentStart
+	j.newSegment = false
+	j.mu.Unlock()
+
+	if !current {
+		// the segment was rotated, but could not be synced.
+		return errors.New("unable to sync upload journal segment")
+	}
+
+	if err := f.Sync(); err != nil {
+		return errors.Wrap(err, "unable to sync upload journal")
+	}
+
+	if newSegment {
+		syncJournalDir(j.dir)
+	}
+
+	j.syncedSeq = target
+
+	return nil
+}
+
+// lastSeq returns the sequence number of the most recently added content and starts a new segment,
+// so that contents added afterwards are retained when the contents up to it are flushed.
+func (j *uploadJournal) lastSeq() uint64 {
+	j.syncMu.Lock()
+	defer j.syncMu.Unlock()
+
+	j.mu.Lock()
+	f, seq, newSegment := j.segment, j.seq, j.newSegment
+	j.segment = nil
+	j.newSegment = false
+	j.mu.Unlock()
+
+	if newSegment {
+		syncJournalDir(j.dir)
+	}
+
+	if f != nil {
+		// writers waiting for the sync of the segment are covered by this sync.
+		if err := f.Sync(); err != nil {
+			log(context.Background()).Warningf("unable to sync upload journal segment: %v", err)
+		} else if seq > j.syncedSeq {
+			j.syncedSeq = seq
+		}
+
+		if err := f.Close(); err != nil {
+			log(context.Background()).Warningf("unable to close upload journal segment: %v", err)
+		}
+	}
+
+	return seq
+}
+
+// flushed removes segments with contents up to the provided sequence number, after they have been flushed.
+func (j *uploadJournal) flushed(ctx context.Context, upTo uint64) {
+	segments, err := j.segments()
+	if err != nil {
+		log(ctx).Warningf("unable to list upload journal: %v", err)
+		return
+	}
+
+	j.mu.Lock()
+	currentStart, hasCurrent := j.segmentStart, j.segment != nil
+	j.mu.Unlock()
+
+	for _, s := range segments {
+		// segments are rotated when the flush starts, so segments starting after it contain only newer contents.
+		if s.startSeq > upTo || (hasCurrent && s.startSeq == currentStart) {
+			break
+		}
+
+		if err := os.Remove(filepath.Join(j.dir, s.fileName)); err != nil && !os.IsNotExist(err) {
+			log(ctx).Warningf("unable to remove upload journal segment: %v", err)
+		}
+	}
+}
+
+// replay writes all journaled contents using the provided function and returns the number of contents written.
+func (j *uploadJournal) replay(ctx context.Context, write func(data []byte, prefix content.ID) (content.ID, error)) (int, error) {
+	segments, err := j.segments()
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+
+	for _, s := range segments {
+		n, err := j.readSegment(ctx, s, func(contentID content.ID, data []byte) error {
+			actual, err := write(data, contentID.Prefix())
+			if err != nil {
+				return errors.Wrapf(err, "unable to write content %v", contentID)
+			}
+
+			if actual != contentID {
+				// corrupted entry, the content will be uploaded again by the client.
+				log(ctx).Warningf("upload journal entry of %v does not match content ID %v", contentID, actual)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		total += n
+	}
+
+	return total, nil
+}
+
+// readSegment invokes the callback for each complete record in the segment and returns the number of records.
+// Incomplete or corrupted records at the end of the segment, left by a crash during write, are ignored.
+func (j *uploadJournal) readSegment(ctx context.Context, s uploadJournalSegment, cb func(contentID content.ID, data []byte) error) (int, error) {
+	f, err := os.Open(filepath.Join(j.dir, s.fileName)) //nolint:gosec
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to open upload journal segment %v", s.fileName)
+	}
+
+	defer f.Close() //nolint:errcheck,gosec
+
+	r := bufio.NewReader(f)
+	n := 0
+
+	for {
+		contentID, data, err := decodeJournalRecord(r)
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+
+		if err != nil {
+			log(ctx).Warningf("ignoring incomplete upload journal record in %v: %v", s.fileName, err)
+			return n, nil
+		}
+
+		if err := cb(contentID, data); err != nil {
+			return n, err
+		}
+
+		n++
+	}
+}
+
+// segments returns journal segments sorted by sequence number.
+func (j *uploadJournal) segments() ([]uploadJournalSegment, error) {
+	files, err := ioutil.ReadDir(j.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read upload journal directory")
+	}
+
+	var result []uploadJournalSegment
+
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), uploadJournalSuffix)
+		if name == f.Name() || len(name) != uploadJournalSeqDigits {
+			// unrelated files.
+			continue
+		}
+
+		seq, err := strconv.ParseUint(name, 16, 64)
+		if err != nil {
+			continue
+		}
+
+		result = append(result, uploadJournalSegment{seq, f.Name()})
+	}
+
+	sort.Slice(result, func(i, k int) bool {
+		return result[i].startSeq < result[k].startSeq
+	})
+
+	return result, nil
+}
+
+func segmentFileName(startSeq uint64) string {
+	return fmt.Sprintf("%0*x%v", uploadJournalSeqDigits, startSeq, uploadJournalSuffix)
+}
+
+// encodeJournalRecord returns the record consisting of payload length, CRC32 of the payload and the payload,
+// which is the length of the content ID followed by content ID and data.
+func encodeJournalRecord(contentID content.ID, data []byte) []byte {
+	payloadLen := 1 + len(contentID) + len(data)
+	b := make([]byte, uploadJournalRecordHeader+payloadLen)
+
+	payload := b[uploadJournalRecordHeader:]
+	payload[0] = byte(len(contentID))
+	copy(payload[1:], contentID)
+	copy(payload[1+len(contentID):], data)
+
+	binary.LittleEndian.PutUint32(b[0:4], uint32(payloadLen))
+	binary.LittleEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(payload))
+
+	return b
+}
+
+func decodeJournalRecord(r io.Reader) (content.ID, []byte, error) {
+	var header [uploadJournalRecordHeader]byte
+
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return "", nil, io.EOF
+		}
+
+		return "", nil, errors.Wrap(err, "unable to read record header")
+	}
+
+	payload := make([]byte, binary.LittleEndian.Uint32(header[0:4]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", nil, errors.Wrap(err, "unable to read record")
+	}
+
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) || len(payload) == 0 || int(payload[0]) >= len(payload) {
+		return "", nil, errors.New("invalid record checksum")
+	}
+
+	idLen := int(payload[0])
+
+	return content.ID(payload[1 : 1+idLen]), payload[1+idLen:], nil
+}
+
+// syncJournalDir makes creation of segment files durable, which is not supported on all platforms.
+func syncJournalDir(dir string) {
+	f, err := os.Open(dir) //nolint:gosec
+	if err != nil {
+		return
+	}
+
+	f.Sync()  //nolint:errcheck,gosec
+	f.Close() //nolint:errcheck,gosec
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/kopia/kopia/repo/content"
+)
+
+func TestUploadJournal(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "kopia-upload-journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	j, err := newUploadJournal(dir)
+	if err != nil {
+		t.Fatalf("unable to create journal: %v", err)
+	}
+
+	must(t, j.add("k1234", []byte{1, 2, 3}))
+	must(t, j.add("4567", []byte{4, 5, 6}))
+
+	flushSeq := j.lastSeq()
+
+	must(t, j.add("78ab", []byte{7, 8, 9}))
+
+	// contents added after the flush started are retained.
+	j.flushed(ctx, flushSeq)
+
+	// reopen the journal, simulating server restart.
+	j, err = newUploadJournal(dir)
+	if err != nil {
+		t.Fatalf("unable to reopen journal: %v", err)
+	}
+
+	if got, want := j.lastSeq(), uint64(3); got != want {
+		t.Errorf("unexpected sequence after reopen: %v, want %v", got, want)
+	}
+
+	var replayed []content.ID
+
+	n, err := j.replay(ctx, func(data []byte, prefix content.ID) (content.ID, error) {
+		cid := prefix + "78ab"
+		replayed = append(replayed, cid)
+
+		return cid, nil
+	})
+	if err != nil {
+		t.Fatalf("replay error: %v", err)
+	}
+
+	if n != 1 || len(replayed) != 1 || replayed[0] != "78ab" {
+		t.Fatalf("unexpected replayed contents: %v", replayed)
+	}
+
+	j.flushed(ctx, j.lastSeq())
+
+	segments, err := j.segments()
+	if err != nil {
+		t.Fatalf("unable to list segments: %v", err)
+	}
+
+	if len(segments) != 0 {
+		t.Errorf("unexpected segments after flush: %v", segments)
+	}
+}
+
+func TestUploadJournalConcurrentWritesAndTruncation(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	j, err := newUploadJournal(dir)
+	if err != nil {
+		t.Fatalf("unable to create journal: %v", err)
+	}
+
+	var eg errgroup.Group
+
+	for i := 0; i < 20; i++ {
+		i := i
+
+		eg.Go(func() error {
+			return j.add(content.ID(fmt.Sprintf("%04x", i)), []byte{byte(i)})
+		})
+	}
+
+	must(t, eg.Wait())
+
+	segments, err := j.segments()
+	must(t, err)
+
+	if len(segments) != 1 {
+		t.Fatalf("unexpected segments: %v", segments)
+	}
+
+	// simulate a crash in the middle of writing a record.
+	f, err := os.OpenFile(filepath.Join(dir, segments[0].fileName), os.O_APPEND|os.O_WRONLY, 0)
+	must(t, err)
+
+	_, err = f.Write(encodeJournalRecord("abcd", []byte{1, 2, 3})[0:10])
+	must(t, err)
+	must(t, f.Close())
+
+	j, err = newUploadJournal(dir)
+	if err != nil {
+		t.Fatalf("unable to reopen journal: %v", err)
+	}
+
+	if got, want := j.lastSeq(), uint64(20); got != want {
+		t.Errorf("unexpected sequence after reopen: %v, want %v", got, want)
+	}
+
+	n, err := j.replay(ctx, func(data []byte, prefix content.ID) (content.ID, error) {
+		return content.ID(fmt.Sprintf("%04x", data[0])), nil
+	})
+	must(t, err)
+
+	if n != 20 {
+		t.Fatalf("unexpected number of replayed contents: %v", n)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
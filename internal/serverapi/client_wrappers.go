@@ -2,11 +2,14 @@
 
 import (
 	"context"
+	"net/url"
 	"strings"
 
 	"github.com/kopia/kopia/internal/apiclient"
+	"github.com/kopia/kopia/repo/manifest"
 	"github.com/kopia/kopia/repo/object"
 	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/snapshotcommit"
 )
 
 // CreateSnapshotSource creates snapshot source with a given path.
@@ -79,6 +82,26 @@ func ListSources(ctx context.Context, c *apiclient.KopiaAPIClient, match *snapsh
 	return resp, nil
 }
 
+// ListClients lists the clients that have connected to the server.
+func ListClients(ctx context.Context, c *apiclient.KopiaAPIClient) (*ClientsResponse, error) {
+	resp := &ClientsResponse{}
+	if err := c.Get(ctx, "clients", nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// ListGroups lists the groups of snapshot sources managed by the server.
+func ListGroups(ctx context.Context, c *apiclient.KopiaAPIClient) (*GroupsResponse, error) {
+	resp := &GroupsResponse{}
+	if err := c.Get(ctx, "groups", nil, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
 // ListSnapshots lists the snapshots managed by the server for a given source filter.
 func ListSnapshots(ctx context.Context, c *apiclient.KopiaAPIClient, match *snapshot.SourceInfo) (*SnapshotsResponse, error) {
 	resp := &SnapshotsResponse{}
@@ -89,6 +112,16 @@ func ListSnapshots(ctx context.Context, c *apiclient.KopiaAPIClient, match *snap
 	return resp, nil
 }
 
+// GetSnapshotCommitment returns the cryptographic commitment to the provided snapshot.
+func GetSnapshotCommitment(ctx context.Context, c *apiclient.KopiaAPIClient, snapshotID manifest.ID) (*snapshotcommit.Commitment, error) {
+	resp := &snapshotcommit.Commitment{}
+	if err := c.Get(ctx, "snapshots/"+string(snapshotID)+"/commitment", snapshot.ErrSnapshotNotFound, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
 // ListPolicies lists the policies managed by the server for a given target filter.
 func ListPolicies(ctx context.Context, c *apiclient.KopiaAPIClient, match *snapshot.SourceInfo) (*PoliciesResponse, error) {
 	resp := &PoliciesResponse{}
@@ -110,6 +143,17 @@ func GetObject(ctx context.Context, c *apiclient.KopiaAPIClient, objectID string
 	return b, nil
 }
 
+// GetSnapshotFile returns the contents of the file at the provided path within the snapshot.
+func GetSnapshotFile(ctx context.Context, c *apiclient.KopiaAPIClient, snapshotID manifest.ID, path string) ([]byte, error) {
+	var b []byte
+
+	if err := c.Get(ctx, "snapshots/"+string(snapshotID)+"/file?path="+url.QueryEscape(path), object.ErrObjectNotFound, &b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
 func matchSourceParameters(match *snapshot.SourceInfo) string {
 	if match == nil {
 		return ""
@@ -5,6 +5,7 @@
 	"time"
 
 	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/remoterepoapi"
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/repo/blob"
 	"github.com/kopia/kopia/repo/manifest"
@@ -12,6 +13,7 @@
 	"github.com/kopia/kopia/snapshot"
 	"github.com/kopia/kopia/snapshot/policy"
 	"github.com/kopia/kopia/snapshot/snapshotfs"
+	"github.com/kopia/kopia/snapshot/snapshotpause"
 )
 
 // StatusResponse is the response of 'status' HTTP API command.
@@ -26,6 +28,9 @@ type StatusResponse struct {
 	Storage      string `json:"storage,omitempty"`
 	APIServerURL string `json:"apiServerURL,omitempty"`
 
+	// Standby is set when the server is a read-only standby that must be promoted to accept writes.
+	Standby bool `json:"standby,omitempty"`
+
 	repo.ClientOptions
 }
 
@@ -38,6 +43,19 @@ type SourcesResponse struct {
 	MultiUser bool `json:"multiUser"`
 
 	Sources []*SourceStatus `json:"sources"`
+
+	// Pause is set when snapshots are paused repository-wide.
+	Pause *snapshotpause.State `json:"pause,omitempty"`
+}
+
+// PauseRequest contains request to pause snapshots repository-wide.
+type PauseRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// PauseResponse contains the pause state after pausing or resuming snapshots.
+type PauseResponse struct {
+	Pause *snapshotpause.State `json:"pause,omitempty"`
 }
 
 // SourceStatus describes the status of a single source.
@@ -48,6 +66,56 @@ type SourceStatus struct {
 	LastSnapshot     *snapshot.Manifest         `json:"lastSnapshot,omitempty"`
 	NextSnapshotTime *time.Time                 `json:"nextSnapshotTime,omitempty"`
 	UploadCounters   *snapshotfs.UploadCounters `json:"upload,omitempty"`
+
+	// DeferReason describes why the scheduled snapshot has been deferred, if any.
+	DeferReason string `json:"deferReason,omitempty"`
+
+	// LastCompleteSnapshotTime is the end time of the last successful snapshot, if any.
+	LastCompleteSnapshotTime *time.Time `json:"lastCompleteSnapshotTime,omitempty"`
+
+	// RPOViolated is true when there has been no successful snapshot within the recovery point objective
+	// specified in the scheduling policy.
+	RPOViolated bool `json:"rpoViolated,omitempty"`
+}
+
+// GroupsResponse is the response of 'groups' HTTP API command.
+type GroupsResponse struct {
+	Groups []*GroupStatus `json:"groups"`
+}
+
+// GroupStatus describes the status of a single group of sources.
+type GroupStatus struct {
+	Name         string                `json:"name"`
+	Sources      []snapshot.SourceInfo `json:"sources"`
+	StatusCounts map[string]int        `json:"statusCounts"`
+}
+
+// ClientsResponse is the response of 'clients' HTTP API command.
+type ClientsResponse struct {
+	ServerVersion string          `json:"serverVersion"`
+	Clients       []*ClientStatus `json:"clients"`
+}
+
+// ClientStatus describes a client of the server.
+type ClientStatus struct {
+	remoterepoapi.ClientInfo
+
+	LastReported time.Time           `json:"lastReported"`
+	LastSeen     time.Time           `json:"lastSeen"`
+	LastSnapshot *ClientLastSnapshot `json:"lastSnapshot,omitempty"`
+
+	// ClockSkew is the difference between the server clock and the client clock at the time of the last report,
+	// positive when the client clock is behind.
+	ClockSkew time.Duration `json:"clockSkew,omitempty"`
+}
+
+// ClientLastSnapshot describes the result of the most recent snapshot taken by a client.
+type ClientLastSnapshot struct {
+	Source           snapshot.SourceInfo `json:"source"`
+	StartTime        time.Time           `json:"startTime"`
+	EndTime          time.Time           `json:"endTime"`
+	IncompleteReason string              `json:"incomplete,omitempty"`
+	ErrorCount       int                 `json:"errorCount"`
 }
 
 // PolicyListEntry describes single policy.
@@ -71,6 +139,7 @@ type Empty struct {
 
 // Supported error codes.
 const (
+	ErrorAccessDenied       APIErrorCode = "ACCESS_DENIED"
 	ErrorInternal           APIErrorCode = "INTERNAL"
 	ErrorAlreadyConnected   APIErrorCode = "ALREADY_CONNECTED"
 	ErrorAlreadyInitialized APIErrorCode = "ALREADY_INITIALIZED"
@@ -81,6 +150,7 @@ type Empty struct {
 	ErrorNotFound           APIErrorCode = "NOT_FOUND"
 	ErrorNotInitialized     APIErrorCode = "NOT_INITIALIZED"
 	ErrorPathNotFound       APIErrorCode = "PATH_NOT_FOUND"
+	ErrorStandby            APIErrorCode = "STANDBY"
 	ErrorStorageConnection  APIErrorCode = "STORAGE_CONNECTION"
 )
 
@@ -188,3 +258,15 @@ type CurrentUserResponse struct {
 	Username string `json:"username"`
 	Hostname string `json:"hostname"`
 }
+
+// ReloadRequest contains request to reload server configuration.
+// Empty log levels leave the current levels unchanged.
+type ReloadRequest struct {
+	LogLevel     string `json:"logLevel,omitempty"`
+	FileLogLevel string `json:"fileLogLevel,omitempty"`
+}
+
+// ChangePasswordRequest contains request to change password of the current user.
+type ChangePasswordRequest struct {
+	NewPassword string `json:"newPassword"`
+}
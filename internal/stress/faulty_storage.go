@@ -0,0 +1,96 @@
+package stress
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// errInjectedFault is returned by faultyStorage for randomly selected operations.
+var errInjectedFault = errors.New("injected storage fault")
+
+// faultyStorage randomly fails storage operations with the configured probability.
+type faultyStorage struct {
+	blob.Storage
+
+	mu        sync.Mutex
+	rnd       *rand.Rand
+	faultRate float64
+
+	faults int64
+}
+
+func (s *faultyStorage) enable(faultRate float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faultRate = faultRate
+}
+
+func (s *faultyStorage) maybeFail() error {
+	s.mu.Lock()
+	fail := s.faultRate > 0 && s.rnd.Float64() < s.faultRate
+	s.mu.Unlock()
+
+	if !fail {
+		return nil
+	}
+
+	atomic.AddInt64(&s.faults, 1)
+
+	return errInjectedFault
+}
+
+func (s *faultyStorage) GetBlob(ctx context.Context, id blob.ID, offset, length int64) ([]byte, error) {
+	if err := s.maybeFail(); err != nil {
+		return nil, err
+	}
+
+	return s.Storage.GetBlob(ctx, id, offset, length)
+}
+
+func (s *faultyStorage) GetMetadata(ctx context.Context, id blob.ID) (blob.Metadata, error) {
+	if err := s.maybeFail(); err != nil {
+		return blob.Metadata{}, err
+	}
+
+	return s.Storage.GetMetadata(ctx, id)
+}
+
+func (s *faultyStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	if err := s.maybeFail(); err != nil {
+		return err
+	}
+
+	return s.Storage.PutBlob(ctx, id, data, opts)
+}
+
+func (s *faultyStorage) SetTime(ctx context.Context, id blob.ID, t time.Time) error {
+	if err := s.maybeFail(); err != nil {
+		return err
+	}
+
+	return s.Storage.SetTime(ctx, id, t)
+}
+
+func (s *faultyStorage) DeleteBlob(ctx context.Context, id blob.ID) error {
+	if err := s.maybeFail(); err != nil {
+		return err
+	}
+
+	return s.Storage.DeleteBlob(ctx, id)
+}
+
+func (s *faultyStorage) ListBlobs(ctx context.Context, prefix blob.ID, callback func(blob.Metadata) error) error {
+	if err := s.maybeFail(); err != nil {
+		return err
+	}
+
+	return s.Storage.ListBlobs(ctx, prefix, callback)
+}
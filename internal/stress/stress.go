@@ -0,0 +1,451 @@
+// Package stress implements randomized stress testing of repository operations against a blob storage,
+// with injected storage faults, verifying that data committed by successful operations remains intact.
+package stress
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/fs/localfs"
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/logging"
+	"github.com/kopia/kopia/repo/maintenance"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/restore"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+	"github.com/kopia/kopia/snapshot/snapshotmaintenance"
+)
+
+var log = logging.GetContextLoggerFunc("stress")
+
+// ErrIntegrity is returned when data written by a successful operation could not be read back correctly.
+var ErrIntegrity = errors.New("repository integrity check failed")
+
+const (
+	stressPassword = "stress-test-password"
+
+	maxFilesPerSnapshot = 20
+	maxFileSize         = 256 << 10
+	numSources          = 3
+)
+
+// Options specifies options for Run.
+type Options struct {
+	Duration  time.Duration // how long to run randomized operations
+	Parallel  int           // number of concurrent workers
+	FaultRate float64       // probability of injecting a fault into each storage operation
+	Seed      int64         // seed of the random number generator
+}
+
+// Stats summarizes the outcome of the stress run.
+type Stats struct {
+	Snapshots          int64 `json:"snapshots"`
+	SnapshotFailures   int64 `json:"snapshotFailures"`
+	Verifications      int64 `json:"verifications"`
+	VerifyFailures     int64 `json:"verifyFailures"`
+	Restores           int64 `json:"restores"`
+	RestoreFailures    int64 `json:"restoreFailures"`
+	Maintenance        int64 `json:"maintenance"`
+	MaintenanceFailure int64 `json:"maintenanceFailures"`
+	InjectedFaults     int64 `json:"injectedFaults"`
+	IntegrityErrors    int64 `json:"integrityErrors"`
+}
+
+// snapshotRecord describes a snapshot that was successfully saved and flushed,
+// together with the expected hashes of its files.
+type snapshotRecord struct {
+	manifestID manifest.ID
+	files      map[string][sha256.Size]byte
+}
+
+type runner struct {
+	opt   Options
+	rep   *repo.DirectRepository
+	st    *faultyStorage
+	stats Stats
+
+	mu        sync.Mutex
+	rnd       *rand.Rand
+	snapshots []snapshotRecord
+}
+
+// Run creates a new repository in the provided storage, which must be empty, and runs randomized concurrent
+// snapshot, verification, restore and maintenance operations while injecting storage faults. After that, all
+// snapshots that were reported as successful are verified without fault injection.
+// Returns ErrIntegrity if any of them can't be read back correctly.
+func Run(ctx context.Context, st blob.Storage, opt Options) (*Stats, error) {
+	if opt.Parallel <= 0 {
+		opt.Parallel = 1
+	}
+
+	if err := repo.Initialize(ctx, st, &repo.NewRepositoryOptions{}, stressPassword); err != nil {
+		return nil, errors.Wrap(err, "unable to initialize repository")
+	}
+
+	fst := &faultyStorage{Storage: st, rnd: rand.New(rand.NewSource(opt.Seed))} //nolint:gosec
+
+	rep, err := repo.OpenWithConfig(ctx, fst, &repo.LocalConfig{}, stressPassword, &repo.Options{}, &content.CachingOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open repository")
+	}
+
+	defer rep.Close(ctx) //nolint:errcheck
+
+	r := &runner{
+		opt: opt,
+		rep: rep,
+		st:  fst,
+		rnd: rand.New(rand.NewSource(opt.Seed)), //nolint:gosec
+	}
+
+	fst.enable(opt.FaultRate)
+
+	deadline := clock.Now().Add(opt.Duration)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < opt.Parallel; i++ {
+		wg.Add(1)
+
+		go func(worker int) {
+			defer wg.Done()
+
+			for clock.Now().Before(deadline) && ctx.Err() == nil {
+				r.runRandomOperation(ctx, worker)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	fst.enable(0)
+	r.stats.InjectedFaults = atomic.LoadInt64(&fst.faults)
+
+	if err := r.verifyAll(ctx); err != nil {
+		return &r.stats, err
+	}
+
+	return &r.stats, ctx.Err()
+}
+
+func (r *runner) randomInt(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.rnd.Intn(n)
+}
+
+func (r *runner) runRandomOperation(ctx context.Context, worker int) {
+	const (
+		opSnapshot = iota
+		opVerify
+		opRestore
+		opMaintenance
+		numOps
+	)
+
+	switch r.randomInt(numOps) {
+	case opSnapshot:
+		if err := r.snapshot(ctx, worker); err != nil {
+			log(ctx).Debugf("snapshot failed: %v", err)
+			atomic.AddInt64(&r.stats.SnapshotFailures, 1)
+		} else {
+			atomic.AddInt64(&r.stats.Snapshots, 1)
+		}
+
+	case opVerify:
+		if err := r.verifyRandomSnapshot(ctx); err != nil {
+			log(ctx).Debugf("verification failed: %v", err)
+			atomic.AddInt64(&r.stats.VerifyFailures, 1)
+		} else {
+			atomic.AddInt64(&r.stats.Verifications, 1)
+		}
+
+	case opRestore:
+		if err := r.restoreRandomSnapshot(ctx); err != nil {
+			log(ctx).Debugf("restore failed: %v", err)
+			atomic.AddInt64(&r.stats.RestoreFailures, 1)
+		} else {
+			atomic.AddInt64(&r.stats.Restores, 1)
+		}
+
+	case opMaintenance:
+		mode := maintenance.ModeQuick
+		if r.randomInt(2) == 0 {
+			mode = maintenance.ModeFull
+		}
+
+		if err := snapshotmaintenance.Run(ctx, r.rep, mode, true); err != nil {
+			log(ctx).Debugf("maintenance failed: %v", err)
+			atomic.AddInt64(&r.stats.MaintenanceFailure, 1)
+		} else {
+			atomic.AddInt64(&r.stats.Maintenance, 1)
+		}
+	}
+}
+
+// randomDirectory returns a random directory tree and hashes of all files in it.
+func (r *runner) randomDirectory() (*mockfs.Directory, map[string][sha256.Size]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dir := mockfs.NewDirectory()
+	files := map[string][sha256.Size]byte{}
+
+	subdirs := []string{""}
+
+	for i := 0; i < 1+r.rnd.Intn(3); i++ {
+		name := randomName(r.rnd)
+		dir.AddDir(name, 0o755) //nolint:gomnd
+		subdirs = append(subdirs, name)
+	}
+
+	for i := 0; i < 1+r.rnd.Intn(maxFilesPerSnapshot); i++ {
+		data := make([]byte, r.rnd.Intn(maxFileSize))
+
+		// mix random and repeated data to exercise deduplication.
+		if r.rnd.Intn(2) == 0 {
+			r.rnd.Read(data) //nolint:errcheck
+		}
+
+		p := path.Join(subdirs[r.rnd.Intn(len(subdirs))], randomName(r.rnd))
+		if _, ok := files[p]; ok {
+			continue
+		}
+
+		dir.AddFile(p, data, 0o644) //nolint:gomnd
+
+		files[p] = sha256.Sum256(data)
+	}
+
+	return dir, files
+}
+
+func randomName(rnd *rand.Rand) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+
+	b := make([]byte, 8) //nolint:gomnd
+	for i := range b {
+		b[i] = letters[rnd.Intn(len(letters))]
+	}
+
+	return string(b)
+}
+
+func (r *runner) snapshot(ctx context.Context, worker int) error {
+	dir, files := r.randomDirectory()
+
+	src := snapshot.SourceInfo{
+		Host:     "stress",
+		UserName: "stress",
+		Path:     "/source" + string(rune('0'+worker%numSources)),
+	}
+
+	man, err := snapshotfs.NewUploader(r.rep).Upload(ctx, dir, policy.BuildTree(nil, policy.DefaultPolicy), src)
+	if err != nil {
+		return errors.Wrap(err, "upload error")
+	}
+
+	if man.IncompleteReason != "" {
+		return errors.Errorf("snapshot incomplete: %v", man.IncompleteReason)
+	}
+
+	manifestID, err := snapshot.SaveSnapshot(ctx, r.rep, man)
+	if err != nil {
+		return errors.Wrap(err, "unable to save snapshot")
+	}
+
+	if err := r.rep.Flush(ctx); err != nil {
+		return errors.Wrap(err, "flush error")
+	}
+
+	r.mu.Lock()
+	r.snapshots = append(r.snapshots, snapshotRecord{manifestID, files})
+	r.mu.Unlock()
+
+	return nil
+}
+
+// randomSnapshot returns a random successful snapshot, false if there are none yet.
+func (r *runner) randomSnapshot() (snapshotRecord, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.snapshots) == 0 {
+		return snapshotRecord{}, false
+	}
+
+	return r.snapshots[r.rnd.Intn(len(r.snapshots))], true
+}
+
+func (r *runner) verifyRandomSnapshot(ctx context.Context) error {
+	s, ok := r.randomSnapshot()
+	if !ok {
+		return nil
+	}
+
+	return r.verifySnapshot(ctx, s)
+}
+
+// restoreRandomSnapshot restores a random snapshot to a temporary directory and verifies restored files.
+func (r *runner) restoreRandomSnapshot(ctx context.Context) error {
+	s, ok := r.randomSnapshot()
+	if !ok {
+		return nil
+	}
+
+	root, err := r.snapshotRoot(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "kopia-stress-restore")
+	if err != nil {
+		return errors.Wrap(err, "unable to create temporary directory")
+	}
+
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	if _, err := restore.Entry(ctx, r.rep, &restore.FilesystemOutput{
+		TargetPath:      dir,
+		SkipOwners:      true,
+		SkipPermissions: true,
+		SkipTimes:       true,
+	}, root, restore.Options{
+		ProgressCallback: func(ctx context.Context, s restore.Stats) {},
+	}); err != nil {
+		return errors.Wrap(err, "restore error")
+	}
+
+	restored, err := localfs.NewEntry(dir)
+	if err != nil {
+		return errors.Wrap(err, "unable to read restored files")
+	}
+
+	return verifyFiles(ctx, restored, s.files)
+}
+
+// verifyAll verifies all successful snapshots, which must succeed since faults are no longer injected.
+func (r *runner) verifyAll(ctx context.Context) error {
+	r.mu.Lock()
+	snapshots := append([]snapshotRecord(nil), r.snapshots...)
+	r.mu.Unlock()
+
+	if err := r.rep.Refresh(ctx); err != nil {
+		return errors.Wrap(err, "unable to refresh repository")
+	}
+
+	for _, s := range snapshots {
+		if err := r.verifySnapshot(ctx, s); err != nil {
+			log(ctx).Errorf("snapshot %v failed verification: %v", s.manifestID, err)
+			r.stats.IntegrityErrors++
+		}
+	}
+
+	if r.stats.IntegrityErrors > 0 {
+		return errors.Wrapf(ErrIntegrity, "%v of %v snapshots could not be verified", r.stats.IntegrityErrors, len(snapshots))
+	}
+
+	return nil
+}
+
+func (r *runner) snapshotRoot(ctx context.Context, s snapshotRecord) (fs.Entry, error) {
+	man, err := snapshot.LoadSnapshot(ctx, r.rep, s.manifestID)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load snapshot")
+	}
+
+	root, err := snapshotfs.SnapshotRoot(r.rep, man)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get snapshot root")
+	}
+
+	return root, nil
+}
+
+func (r *runner) verifySnapshot(ctx context.Context, s snapshotRecord) error {
+	root, err := r.snapshotRoot(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	return verifyFiles(ctx, root, s.files)
+}
+
+// verifyFiles verifies that the tree contains exactly the files with the expected hashes.
+func verifyFiles(ctx context.Context, root fs.Entry, files map[string][sha256.Size]byte) error {
+	actual := map[string][sha256.Size]byte{}
+
+	if err := hashFiles(ctx, root, "", actual); err != nil {
+		return err
+	}
+
+	if len(actual) != len(files) {
+		return errors.Errorf("unexpected number of files: %v, want %v", len(actual), len(files))
+	}
+
+	for p, h := range files {
+		if actual[p] != h {
+			return errors.Errorf("invalid contents of %v", p)
+		}
+	}
+
+	return nil
+}
+
+func hashFiles(ctx context.Context, e fs.Entry, p string, result map[string][sha256.Size]byte) error {
+	switch e := e.(type) {
+	case fs.Directory:
+		entries, err := e.Readdir(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "unable to read directory %v", p)
+		}
+
+		for _, child := range entries {
+			if err := hashFiles(ctx, child, path.Join(p, child.Name()), result); err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	case fs.File:
+		rd, err := e.Open(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "unable to open %v", p)
+		}
+		defer rd.Close() //nolint:errcheck
+
+		h := sha256.New()
+		if _, err := io.Copy(h, rd); err != nil {
+			return errors.Wrapf(err, "unable to read %v", p)
+		}
+
+		var sum [sha256.Size]byte
+
+		copy(sum[:], h.Sum(nil))
+		result[p] = sum
+
+		return nil
+
+	default:
+		return errors.Errorf("unexpected entry %v", p)
+	}
+}
@@ -0,0 +1,38 @@
+package stress
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+func TestStress(t *testing.T) {
+	ctx := testlogging.Context(t)
+	st := blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil)
+
+	stats, err := Run(ctx, st, Options{
+		Duration:  2 * time.Second,
+		Parallel:  3,
+		FaultRate: 0.02,
+		Seed:      1,
+	})
+	if err != nil {
+		t.Fatalf("stress run failed: %v (%+v)", err, stats)
+	}
+
+	if stats.Snapshots == 0 {
+		t.Errorf("no successful snapshots: %+v", stats)
+	}
+
+	if stats.Restores == 0 {
+		t.Errorf("no successful restores: %+v", stats)
+	}
+
+	if stats.InjectedFaults == 0 {
+		t.Errorf("no faults were injected: %+v", stats)
+	}
+
+	t.Logf("stats: %+v", stats)
+}
@@ -0,0 +1,274 @@
+// Package supportbundle creates archives with diagnostic information which users can attach to bug reports.
+//
+// All data written to the bundle is scrubbed of secrets: values of JSON fields and key=value pairs whose
+// names suggest sensitive data are masked and all occurrences of explicitly registered secrets are replaced.
+package supportbundle
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Redacted is the value that replaces secrets in the bundle.
+const Redacted = "<redacted>"
+
+// minSecretLength is the minimum length of explicitly registered secrets, shorter values would
+// cause too many false positives when scrubbing text.
+const minSecretLength = 4
+
+// sensitiveNameParts are lowercase substrings of field and variable names that hold secrets.
+var sensitiveNameParts = []string{
+	"password",
+	"passwd",
+	"secret",
+	"token",
+	"credential",
+	"privatekey",
+	"accesskey",
+	"storagekey",
+	"keydata",
+	"apikey",
+}
+
+// sensitiveNames are lowercase field and variable names that hold secrets.
+var sensitiveNames = map[string]bool{
+	"key":    true,
+	"b2_key": true,
+}
+
+// sensitiveAssignmentRegexp matches key=value and key: value pairs in free-form text.
+var sensitiveAssignmentRegexp = regexp.MustCompile(`(?i)\b([a-z0-9_\-]*(?:password|passwd|secret|token|credential|accesskey|apikey)[a-z0-9_\-]*)(["']?\s*[:=]\s*["']?)([^\s"',}&]+)`)
+
+// IsSensitiveName determines whether a field or variable with the provided name holds a secret.
+func IsSensitiveName(name string) bool {
+	n := strings.ToLower(name)
+
+	if sensitiveNames[n] {
+		return true
+	}
+
+	for _, p := range sensitiveNameParts {
+		if strings.Contains(n, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Scrubber removes secrets from data.
+type Scrubber struct {
+	secrets []string
+}
+
+// AddSecret registers a value which will be replaced wherever it appears as a separate word.
+func (s *Scrubber) AddSecret(v string) {
+	if len(v) < minSecretLength {
+		return
+	}
+
+	s.secrets = append(s.secrets, v)
+
+	// replace longer secrets first, so that secrets which contain other secrets are fully removed.
+	sort.Slice(s.secrets, func(i, j int) bool {
+		return len(s.secrets[i]) > len(s.secrets[j])
+	})
+}
+
+// ScrubText removes secrets from free-form text.
+func (s *Scrubber) ScrubText(data []byte) []byte {
+	for _, v := range s.secrets {
+		data = replaceDelimited(data, []byte(v), []byte(Redacted))
+	}
+
+	return sensitiveAssignmentRegexp.ReplaceAll(data, []byte("${1}${2}"+Redacted))
+}
+
+// replaceDelimited replaces occurrences of the secret which are not a part of a longer word,
+// so that short secrets don't mangle unrelated text.
+func replaceDelimited(data, secret, replacement []byte) []byte {
+	var out []byte
+
+	for {
+		p := bytes.Index(data, secret)
+		if p < 0 {
+			return append(out, data...)
+		}
+
+		end := p + len(secret)
+		delimited := (p == 0 || !isWordByte(data[p-1])) && (end == len(data) || !isWordByte(data[end]))
+
+		out = append(out, data[0:p]...)
+
+		if delimited {
+			out = append(out, replacement...)
+		} else {
+			out = append(out, secret...)
+		}
+
+		data = data[end:]
+	}
+}
+
+func isWordByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '_'
+}
+
+// ScrubJSON removes secrets from JSON document, masking values of all fields with sensitive names.
+// Documents that can't be parsed are scrubbed as text.
+func (s *Scrubber) ScrubJSON(data []byte) []byte {
+	var v interface{}
+
+	if err := json.Unmarshal(data, &v); err != nil {
+		return s.ScrubText(data)
+	}
+
+	b, err := json.MarshalIndent(scrubValue(v), "", "  ")
+	if err != nil {
+		return s.ScrubText(data)
+	}
+
+	return s.ScrubText(b)
+}
+
+func scrubValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, fv := range v {
+			if IsSensitiveName(k) {
+				if fv != nil && fv != "" {
+					v[k] = Redacted
+				}
+
+				continue
+			}
+
+			v[k] = scrubValue(fv)
+		}
+
+		return v
+
+	case []interface{}:
+		for i := range v {
+			v[i] = scrubValue(v[i])
+		}
+
+		return v
+
+	default:
+		return v
+	}
+}
+
+// Bundle writes scrubbed files to a zip archive.
+type Bundle struct {
+	Scrubber
+
+	zw    *zip.Writer
+	now   time.Time
+	names []string
+}
+
+// New creates a bundle which writes the archive to the provided writer.
+func New(w io.Writer, now time.Time) *Bundle {
+	return &Bundle{
+		zw:  zip.NewWriter(w),
+		now: now,
+	}
+}
+
+// Names returns the names of files added to the bundle.
+func (b *Bundle) Names() []string {
+	return append([]string(nil), b.names...)
+}
+
+// AddText adds a text file to the bundle.
+func (b *Bundle) AddText(name string, data []byte) error {
+	return b.add(name, b.ScrubText(data))
+}
+
+// AddJSON adds the JSON representation of the provided value to the bundle.
+func (b *Bundle) AddJSON(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "unable to serialize %v", name)
+	}
+
+	return b.add(name, b.ScrubJSON(data))
+}
+
+// AddJSONFile adds the existing JSON document to the bundle.
+func (b *Bundle) AddJSONFile(name string, data []byte) error {
+	return b.add(name, b.ScrubJSON(data))
+}
+
+func (b *Bundle) add(name string, data []byte) error {
+	w, err := b.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: b.now,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to create %v", name)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return errors.Wrapf(err, "unable to write %v", name)
+	}
+
+	b.names = append(b.names, name)
+
+	return nil
+}
+
+// Close finishes writing the archive.
+func (b *Bundle) Close() error {
+	return errors.Wrap(b.zw.Close(), "unable to finish support bundle")
+}
+
+// ErrorLines returns up to maxLines most recent lines logged at error or fatal level
+// from a log file written by kopia.
+func ErrorLines(r io.Reader, maxLines int) ([]string, error) {
+	var result []string
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20) //nolint:gomnd
+
+	for s.Scan() {
+		if !isErrorLine(s.Text()) {
+			continue
+		}
+
+		result = append(result, s.Text())
+		if len(result) > maxLines {
+			result = result[1:]
+		}
+	}
+
+	return result, errors.Wrap(s.Err(), "error reading log")
+}
+
+// isErrorLine determines whether the log line has been written at error level or above,
+// log lines have the format "2006-01-02 15:04:05.000 E [file:line] message".
+func isErrorLine(l string) bool {
+	parts := strings.SplitN(l, " ", 4) //nolint:gomnd
+	if len(parts) < 3 {                //nolint:gomnd
+		return false
+	}
+
+	switch parts[2] {
+	case "E", "C":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,100 @@
+package supportbundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrubText(t *testing.T) {
+	var s Scrubber
+
+	s.AddSecret("pass")
+	s.AddSecret("x") // too short, ignored
+
+	cases := map[string]string{
+		"password for repo retrieved from password file": "password for repo retrieved from password file",
+		"opened with pass, ok":                           "opened with <redacted>, ok",
+		"connecting with secretAccessKey=abc123 now":     "connecting with secretAccessKey=<redacted> now",
+		`{"token": "abc"}`:                               `{"token": "<redacted>"}`,
+		"x marks the spot":                               "x marks the spot",
+	}
+
+	for input, want := range cases {
+		require.Equal(t, want, string(s.ScrubText([]byte(input))), "input: %v", input)
+	}
+}
+
+func TestScrubJSON(t *testing.T) {
+	var s Scrubber
+
+	s.AddSecret("hunter22")
+
+	got := string(s.ScrubJSON([]byte(`{"storage":{"type":"s3","config":{"bucket":"b","secretAccessKey":"xyz","sessionToken":""}},"description":"uses hunter22","list":[{"password":"p"}]}`)))
+
+	require.NotContains(t, got, "xyz")
+	require.NotContains(t, got, "hunter22")
+	require.NotContains(t, got, `"p"`)
+	require.Contains(t, got, `"bucket": "b"`)
+	require.Contains(t, got, `"sessionToken": ""`)
+}
+
+func TestIsSensitiveName(t *testing.T) {
+	for _, n := range []string{"KOPIA_PASSWORD", "AWS_SECRET_ACCESS_KEY", "storageKey", "key", "sessionToken", "credentials"} {
+		require.True(t, IsSensitiveName(n), n)
+	}
+
+	for _, n := range []string{"KOPIA_CONFIG_PATH", "bucket", "keyID", "hostname"} {
+		require.False(t, IsSensitiveName(n), n)
+	}
+}
+
+func TestErrorLines(t *testing.T) {
+	log := strings.Join([]string{
+		"2020-01-02 03:04:05.000 D [a.go:1] debug",
+		"2020-01-02 03:04:05.000 E [a.go:2] error 1",
+		"2020-01-02 03:04:05.000 I [a.go:3] info",
+		"2020-01-02 03:04:05.000 E [a.go:4] error 2",
+		"2020-01-02 03:04:05.000 C [a.go:5] critical",
+		"garbage",
+	}, "\n")
+
+	lines, err := ErrorLines(strings.NewReader(log), 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"2020-01-02 03:04:05.000 E [a.go:4] error 2",
+		"2020-01-02 03:04:05.000 C [a.go:5] critical",
+	}, lines)
+}
+
+func TestBundle(t *testing.T) {
+	var buf bytes.Buffer
+
+	b := New(&buf, time.Now())
+	b.AddSecret("topsecret")
+
+	require.NoError(t, b.AddText("a.log", []byte("password is topsecret")))
+	require.NoError(t, b.AddJSON("b.json", map[string]string{"password": "p1", "user": "u"}))
+	require.NoError(t, b.Close())
+	require.Equal(t, []string{"a.log", "b.json"}, b.Names())
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	for _, f := range zr.File {
+		r, err := f.Open()
+		require.NoError(t, err)
+
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		r.Close()
+
+		require.NotContains(t, string(data), "topsecret")
+		require.NotContains(t, string(data), "p1")
+	}
+}
@@ -0,0 +1,283 @@
+package throttle
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/repo/logging"
+)
+
+var log = logging.GetContextLoggerFunc("throttle")
+
+const (
+	// DefaultMaxConcurrentRequests is the default upper bound of concurrent requests allowed by AdaptiveLimiter.
+	DefaultMaxConcurrentRequests = 32
+
+	// decreaseCooldown prevents multiple throttled responses to requests that were sent concurrently
+	// from reducing the limit more than once.
+	decreaseCooldown = 1 * time.Second
+
+	decreaseFactor = 0.5
+)
+
+// AdaptiveLimiter limits the number of concurrent requests and optionally the rate of requests using
+// additive-increase/multiplicative-decrease: both limits are halved whenever the server signals throttling
+// and grow by one after the number of successful requests equal to the current limit.
+type AdaptiveLimiter struct {
+	minLimit float64
+	maxLimit float64
+	minRate  float64
+	maxRate  float64
+
+	mu           sync.Mutex
+	limit        float64
+	inFlight     int
+	released     chan struct{}
+	lastDecrease time.Time
+	rate         float64   // requests per second, zero if unlimited
+	nextRequest  time.Time // earliest time when the next request may be sent according to the rate
+}
+
+// NewAdaptiveLimiter returns a limiter which allows up to maxLimit concurrent requests and up to
+// maxRequestsPerSecond requests per second, zero means no limit of the rate.
+func NewAdaptiveLimiter(maxLimit, maxRequestsPerSecond int) *AdaptiveLimiter {
+	if maxLimit <= 0 {
+		maxLimit = DefaultMaxConcurrentRequests
+	}
+
+	if maxRequestsPerSecond < 0 {
+		maxRequestsPerSecond = 0
+	}
+
+	return &AdaptiveLimiter{
+		minLimit: 1,
+		maxLimit: float64(maxLimit),
+		limit:    float64(maxLimit),
+		minRate:  1,
+		maxRate:  float64(maxRequestsPerSecond),
+		rate:     float64(maxRequestsPerSecond),
+		released: make(chan struct{}),
+	}
+}
+
+// Rate returns the current limit of requests per second or zero if the rate is not limited.
+func (l *AdaptiveLimiter) Rate() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.rate)
+}
+
+// Limit returns the current limit of concurrent requests.
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.limit)
+}
+
+// Acquire waits until a request can be sent, each successful call must be followed by Release.
+func (l *AdaptiveLimiter) Acquire(ctx context.Context) error {
+	if err := l.acquireSlot(ctx); err != nil {
+		return err
+	}
+
+	if err := l.waitForRate(ctx); err != nil {
+		l.mu.Lock()
+		l.releaseSlotLocked()
+		l.mu.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+// waitForRate waits until the request may be sent without exceeding the current rate.
+func (l *AdaptiveLimiter) waitForRate(ctx context.Context) error {
+	l.mu.Lock()
+
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+
+	now := clock.Now()
+
+	sendAt := l.nextRequest
+	if sendAt.Before(now) {
+		sendAt = now
+	}
+
+	l.nextRequest = sendAt.Add(time.Duration(float64(time.Second) / l.rate))
+	l.mu.Unlock()
+
+	delay := sendAt.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *AdaptiveLimiter) acquireSlot(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+
+		if l.inFlight < int(l.limit) {
+			l.inFlight++
+			l.mu.Unlock()
+
+			return nil
+		}
+
+		ch := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release marks the request as completed and adjusts the limit depending on whether it was throttled.
+func (l *AdaptiveLimiter) Release(ctx context.Context, throttled bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if throttled {
+		if now := clock.Now(); now.Sub(l.lastDecrease) >= decreaseCooldown {
+			l.lastDecrease = now
+			l.limit = decrease(l.limit, l.minLimit)
+
+			if l.rate > 0 {
+				l.rate = decrease(l.rate, l.minRate)
+			}
+
+			log(ctx).Debugf("server is throttling requests, reduced concurrency to %v and rate to %v/s", int(l.limit), int(l.rate))
+		}
+	} else {
+		l.limit = increase(l.limit, l.maxLimit)
+
+		if l.rate > 0 {
+			l.rate = increase(l.rate, l.maxRate)
+		}
+	}
+
+	l.releaseSlotLocked()
+}
+
+func (l *AdaptiveLimiter) releaseSlotLocked() {
+	l.inFlight--
+
+	// wake up all waiters, they will re-check the limit.
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+func decrease(v, minValue float64) float64 {
+	v *= decreaseFactor
+	if v < minValue {
+		return minValue
+	}
+
+	return v
+}
+
+func increase(v, maxValue float64) float64 {
+	v += 1 / v
+	if v > maxValue {
+		return maxValue
+	}
+
+	return v
+}
+
+// IsThrottlingResponse determines whether the HTTP response indicates that the server is throttling requests.
+// S3 signals throttling with 503 SlowDown, other services use 429 Too Many Requests.
+func IsThrottlingResponse(resp *http.Response) bool {
+	if resp == nil {
+		return false
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	default:
+		return false
+	}
+}
+
+type adaptiveRoundTripper struct {
+	base    http.RoundTripper
+	limiter *AdaptiveLimiter
+}
+
+func (rt *adaptiveRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	if err := rt.limiter.Acquire(ctx); err != nil {
+		return nil, err
+	}
+
+	resp, err := rt.base.RoundTrip(req)
+	throttled := IsThrottlingResponse(resp)
+
+	if err != nil || resp == nil || resp.Body == nil {
+		rt.limiter.Release(ctx, throttled)
+		return resp, err
+	}
+
+	// the request is in progress until its response body has been read and closed.
+	resp.Body = &releasingBody{
+		ReadCloser: resp.Body,
+		release: func() {
+			rt.limiter.Release(ctx, throttled)
+		},
+	}
+
+	return resp, nil
+}
+
+// releasingBody invokes the provided function once when the response body is closed.
+type releasingBody struct {
+	io.ReadCloser
+
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+
+	return err
+}
+
+// NewAdaptiveRoundTripper returns http.RoundTripper that limits concurrency and rate of requests using the provided
+// limiter, reducing them when the server responds with throttling errors and ramping them back up after successful
+// requests. The concurrency slot of each request is held until its response body is closed.
+func NewAdaptiveRoundTripper(base http.RoundTripper, limiter *AdaptiveLimiter) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &adaptiveRoundTripper{
+		base:    base,
+		limiter: limiter,
+	}
+}
@@ -0,0 +1,190 @@
+package throttle
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := NewAdaptiveLimiter(8, 0)
+
+	if got, want := l.Limit(), 8; got != want {
+		t.Fatalf("unexpected initial limit: %v, want %v", got, want)
+	}
+
+	for i := 0; i < 8; i++ {
+		if err := l.Acquire(ctx); err != nil {
+			t.Fatalf("unable to acquire: %v", err)
+		}
+	}
+
+	// all slots are taken.
+	shortCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+
+	if err := l.Acquire(shortCtx); err == nil {
+		t.Fatalf("unexpected success acquiring over the limit")
+	}
+
+	// concurrent throttled responses only reduce the limit once.
+	l.Release(ctx, true)
+	l.Release(ctx, true)
+
+	if got, want := l.Limit(), 4; got != want {
+		t.Fatalf("unexpected limit after throttling: %v, want %v", got, want)
+	}
+
+	for i := 0; i < 6; i++ {
+		l.Release(ctx, false)
+	}
+
+	// limit grows by approximately one per the number of successful requests equal to the limit.
+	for i := 0; i < 100; i++ {
+		if err := l.Acquire(ctx); err != nil {
+			t.Fatalf("unable to acquire: %v", err)
+		}
+
+		l.Release(ctx, false)
+	}
+
+	if got, want := l.Limit(), 8; got != want {
+		t.Fatalf("unexpected limit after ramp up: %v, want %v", got, want)
+	}
+}
+
+type statusRoundTripper struct {
+	status int
+}
+
+func (rt statusRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: rt.status, Body: ioutil.NopCloser(strings.NewReader("body"))}, nil
+}
+
+func TestAdaptiveRoundTripper(t *testing.T) {
+	cases := []struct {
+		status    int
+		wantLimit int
+	}{
+		{http.StatusOK, 8},
+		{http.StatusNotFound, 8},
+		{http.StatusTooManyRequests, 4},
+		{http.StatusServiceUnavailable, 4},
+	}
+
+	for _, tc := range cases {
+		l := NewAdaptiveLimiter(8, 0)
+		rt := NewAdaptiveRoundTripper(statusRoundTripper{tc.status}, l)
+
+		req, err := http.NewRequest(http.MethodGet, "http://localhost/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if resp.StatusCode != tc.status {
+			t.Errorf("unexpected status: %v", resp.StatusCode)
+		}
+
+		resp.Body.Close()
+
+		if got := l.Limit(); got != tc.wantLimit {
+			t.Errorf("unexpected limit after %v: %v, want %v", tc.status, got, tc.wantLimit)
+		}
+	}
+}
+
+func TestAdaptiveRoundTripperHoldsSlotUntilBodyClosed(t *testing.T) {
+	l := NewAdaptiveLimiter(1, 0)
+	rt := NewAdaptiveRoundTripper(statusRoundTripper{http.StatusOK}, l)
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	shortCtx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err = rt.RoundTrip(req.WithContext(shortCtx)); err == nil {
+		t.Fatalf("unexpected success while the response body is open")
+	}
+
+	// closing twice releases the slot only once.
+	resp.Body.Close()
+	resp.Body.Close()
+
+	resp, err = rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("unexpected error after the response body was closed: %v", err)
+	}
+
+	resp.Body.Close()
+}
+
+func TestAdaptiveLimiterRate(t *testing.T) {
+	ctx := context.Background()
+	l := NewAdaptiveLimiter(100, 100)
+
+	start := time.Now()
+
+	for i := 0; i < 11; i++ {
+		if err := l.Acquire(ctx); err != nil {
+			t.Fatalf("unable to acquire: %v", err)
+		}
+
+		l.Release(ctx, false)
+	}
+
+	// 11 requests at 100 requests per second take at least 100ms.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("requests were not rate-limited: %v", elapsed)
+	}
+
+	if err := l.Acquire(ctx); err != nil {
+		t.Fatalf("unable to acquire: %v", err)
+	}
+
+	l.Release(ctx, true)
+
+	if got, want := l.Rate(), 50; got != want {
+		t.Fatalf("unexpected rate after throttling: %v, want %v", got, want)
+	}
+
+	// waiting for the rate honors cancellation and does not leak the concurrency slot.
+	l = NewAdaptiveLimiter(1, 1)
+
+	if err := l.Acquire(ctx); err != nil {
+		t.Fatalf("unable to acquire: %v", err)
+	}
+
+	l.Release(ctx, false)
+
+	shortCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+
+	if err := l.Acquire(shortCtx); err == nil {
+		t.Fatalf("unexpected success exceeding the rate")
+	}
+
+	l.mu.Lock()
+	inFlight := l.inFlight
+	l.mu.Unlock()
+
+	if inFlight != 0 {
+		t.Fatalf("concurrency slot leaked: %v", inFlight)
+	}
+}
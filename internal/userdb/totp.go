@@ -0,0 +1,244 @@
+package userdb
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Time-based one-time passwords (RFC 6238) used as a second authentication factor.
+// Users with TOTP enabled authenticate by appending the current code from their authenticator app,
+// or one of their single-use recovery codes, to the password.
+const (
+	totpIssuer       = "Kopia"
+	totpPeriod       = 30 * time.Second
+	totpDigits       = 6
+	totpSecretLength = 20
+	totpAllowedSkew  = 1 // number of periods before and after the current one, in which codes are accepted
+
+	recoveryCodeCount    = 10
+	recoveryCodeAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+	recoveryCodeHalf     = 5
+	recoveryCodeLength   = 2*recoveryCodeHalf + 1
+)
+
+var totpSecretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPEnrollment contains information needed to configure an authenticator app for the user.
+type TOTPEnrollment struct {
+	Secret        string   `json:"secret"`
+	URL           string   `json:"url"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// totpCode computes the code for the provided time step.
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	h := hmac.New(sha1.New, secret)
+	h.Write(msg[:]) //nolint:errcheck
+	sum := h.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	const modulo = 1000000
+
+	return fmt.Sprintf("%06d", v%modulo)
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isRecoveryCode(s string) bool {
+	if len(s) != recoveryCodeLength || s[recoveryCodeHalf] != '-' {
+		return false
+	}
+
+	for i, r := range s {
+		if i != recoveryCodeHalf && !strings.ContainsRune(recoveryCodeAlphabet, r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// splitSecondFactor splits the provided string into password and TOTP or recovery code suffix.
+func splitSecondFactor(s string) (password, code string) {
+	if n := len(s) - totpDigits; n > 0 && isDigits(s[n:]) {
+		return s[0:n], s[n:]
+	}
+
+	if n := len(s) - recoveryCodeLength; n > 0 && isRecoveryCode(s[n:]) {
+		return s[0:n], s[n:]
+	}
+
+	return s, ""
+}
+
+func hashRecoveryCode(code string) string {
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
+
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 2*recoveryCodeHalf)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "unable to generate recovery code")
+	}
+
+	for i := range b {
+		b[i] = recoveryCodeAlphabet[int(b[i])%len(recoveryCodeAlphabet)]
+	}
+
+	return string(b[0:recoveryCodeHalf]) + "-" + string(b[recoveryCodeHalf:]), nil
+}
+
+// EnrollTOTP enables TOTP for the user, replacing any previous secret and recovery codes.
+func (db *Database) EnrollTOTP(username string) (*TOTPEnrollment, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.hashes[username]; !ok {
+		return nil, errors.Wrap(ErrUserNotFound, username)
+	}
+
+	secret := make([]byte, totpSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.Wrap(err, "unable to generate TOTP secret")
+	}
+
+	e := &TOTPEnrollment{
+		Secret: totpSecretEncoding.EncodeToString(secret),
+	}
+
+	e.URL = (&url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + totpIssuer + ":" + username,
+		RawQuery: url.Values{"secret": {e.Secret}, "issuer": {totpIssuer}}.Encode(),
+	}).String()
+
+	var hashes []string
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		c, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		e.RecoveryCodes = append(e.RecoveryCodes, c)
+		hashes = append(hashes, hashRecoveryCode(c))
+	}
+
+	md := db.userMetadataLocked(username)
+	md.TOTPSecret = e.Secret
+	md.RecoveryCodes = hashes
+
+	delete(db.lastTOTPStep, username)
+
+	return e, nil
+}
+
+// DisableTOTP disables TOTP for the user.
+func (db *Database) DisableTOTP(username string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.hashes[username]; !ok {
+		return errors.Wrap(ErrUserNotFound, username)
+	}
+
+	md := db.userMetadataLocked(username)
+	md.TOTPSecret = ""
+	md.RecoveryCodes = nil
+
+	return nil
+}
+
+// TOTPEnabled returns true if the user must provide TOTP code when authenticating.
+func (db *Database) TOTPEnabled(username string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	md := db.meta.Users[username]
+
+	return md != nil && md.TOTPSecret != ""
+}
+
+// verifySecondFactorLocked verifies TOTP or recovery code. Used recovery codes are removed
+// and the database is saved, so that they can't be used again.
+func (db *Database) verifySecondFactorLocked(username string, md *userMetadata, code string) (bool, error) {
+	switch {
+	case len(code) == totpDigits:
+		secret, err := totpSecretEncoding.DecodeString(md.TOTPSecret)
+		if err != nil {
+			return false, errors.Wrap(err, "invalid TOTP secret")
+		}
+
+		current := totpStep(db.timeNow())
+
+		for step := current - totpAllowedSkew; step <= current+totpAllowedSkew; step++ {
+			// reject codes that have already been used.
+			if step <= db.lastTOTPStep[username] {
+				continue
+			}
+
+			if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+				db.lastTOTPStep[username] = step
+				return true, nil
+			}
+		}
+
+		return false, nil
+
+	case len(code) == recoveryCodeLength:
+		h := hashRecoveryCode(code)
+
+		for i, rc := range md.RecoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(rc), []byte(h)) != 1 {
+				continue
+			}
+
+			old := md.RecoveryCodes
+			md.RecoveryCodes = append(append([]string(nil), old[0:i]...), old[i+1:]...)
+
+			if err := db.saveLocked(); err != nil {
+				md.RecoveryCodes = old
+				return false, err
+			}
+
+			return true, nil
+		}
+
+		return false, nil
+
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,90 @@
+package userdb
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// test vectors from RFC 6238, truncated to 6 digits.
+	secret := []byte("12345678901234567890")
+
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1234567890:  "005924",
+		20000000000: "353130",
+	}
+
+	for unixTime, want := range cases {
+		if got := totpCode(secret, totpStep(time.Unix(unixTime, 0))); got != want {
+			t.Errorf("invalid code for %v: %v, want %v", unixTime, got, want)
+		}
+	}
+}
+
+func TestTOTPAuthentication(t *testing.T) {
+	db, ta, cleanup := openTestDatabase(t)
+	defer cleanup()
+
+	must(t, db.AddUser("foo@bar", "password1", false))
+
+	e, err := db.EnrollTOTP("foo@bar")
+	if err != nil {
+		t.Fatalf("unable to enroll: %v", err)
+	}
+
+	if !strings.HasPrefix(e.URL, "otpauth://totp/Kopia:foo@bar?") || len(e.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("unexpected enrollment: %v", e)
+	}
+
+	secret, err := totpSecretEncoding.DecodeString(e.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	currentCode := func() string {
+		return totpCode(secret, totpStep(db.timeNow()))
+	}
+
+	// password alone is no longer sufficient.
+	if err := db.Authenticate("foo@bar", "password1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Authenticate("foo@bar", "wrong"+currentCode()); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	must(t, db.Authenticate("foo@bar", "password1"+currentCode()))
+
+	// the same code can't be used twice.
+	if err := db.Authenticate("foo@bar", "password1"+currentCode()); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("code replay was accepted: %v", err)
+	}
+
+	ta.Advance(totpPeriod)
+	must(t, db.Authenticate("foo@bar", "password1"+currentCode()))
+
+	// recovery codes work once and are persisted as used.
+	must(t, db.Authenticate("foo@bar", "password1"+e.RecoveryCodes[0]))
+
+	if err := db.Authenticate("foo@bar", "password1"+e.RecoveryCodes[0]); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("recovery code reuse was accepted: %v", err)
+	}
+
+	db2, err := Open(db.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if users := db2.Users(); len(users) != 1 || !users[0].TOTPEnabled || users[0].RecoveryCodesLeft != recoveryCodeCount-1 {
+		t.Fatalf("unexpected users: %+v", users)
+	}
+
+	must(t, db.DisableTOTP("foo@bar"))
+	must(t, db.Authenticate("foo@bar", "password1"))
+}
@@ -0,0 +1,740 @@
+// Package userdb manages users of the API server stored in a htpasswd-compatible file.
+//
+// Password hashes are stored in the htpasswd file itself, so that it remains usable by other tools,
+// while password policy and per-user password metadata are stored in a separate JSON file next to it.
+package userdb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/natefinch/atomic"
+	"github.com/pkg/errors"
+	htpasswd "github.com/tg123/go-htpasswd"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/kopia/kopia/internal/clock"
+)
+
+// MetadataFileSuffix is appended to the name of htpasswd file to get the name of the metadata file.
+const MetadataFileSuffix = ".meta.json"
+
+// Errors returned by the user database.
+var (
+	ErrUserNotFound            = errors.New("user not found")
+	ErrUserExists              = errors.New("user already exists")
+	ErrInvalidCredentials      = errors.New("invalid username or password")
+	ErrAccountLocked           = errors.New("account is temporarily locked due to too many failed login attempts")
+	ErrPasswordChangeRequired  = errors.New("password must be changed before the account can be used")
+	ErrPasswordExpired         = errors.New("password has expired and must be changed")
+	ErrPasswordPolicyViolation = errors.New("password does not meet the password policy")
+	ErrConcurrentModification  = errors.New("user database was modified by another process")
+)
+
+// maxUpdateAttempts is the number of times Update applies the change, when the database keeps being
+// modified by other processes.
+const maxUpdateAttempts = 3
+
+// Policy specifies requirements for user passwords and account lockout.
+type Policy struct {
+	MinLength         int           `json:"minLength"`
+	MinCharClasses    int           `json:"minCharClasses"` // number of distinct classes (lowercase, uppercase, digits, other) required
+	MaxAge            time.Duration `json:"maxAge,omitempty"`
+	MaxFailedAttempts int           `json:"maxFailedAttempts,omitempty"`
+	LockoutDuration   time.Duration `json:"lockoutDuration,omitempty"`
+}
+
+// DefaultPolicy is the policy used when none has been set.
+var DefaultPolicy = Policy{
+	MinLength:      8,
+	MinCharClasses: 1,
+}
+
+const maxCharClasses = 4
+
+// Validate checks the policy for consistency.
+func (p Policy) Validate() error {
+	if p.MinLength < 0 || p.MaxAge < 0 || p.MaxFailedAttempts < 0 || p.LockoutDuration < 0 {
+		return errors.Errorf("policy values must not be negative")
+	}
+
+	if p.MinCharClasses < 0 || p.MinCharClasses > maxCharClasses {
+		return errors.Errorf("number of character classes must be between 0 and %v", maxCharClasses)
+	}
+
+	if p.MaxFailedAttempts > 0 && p.LockoutDuration == 0 {
+		return errors.Errorf("lockout duration must be specified when the number of failed attempts is limited")
+	}
+
+	return nil
+}
+
+// CheckPassword returns an error wrapping ErrPasswordPolicyViolation if the password
+// does not meet the policy.
+func (p Policy) CheckPassword(password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return errors.Wrapf(ErrPasswordPolicyViolation, "password must have at least %v characters", p.MinLength)
+	}
+
+	var lower, upper, digit, other int
+
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+
+	if lower+upper+digit+other < p.MinCharClasses {
+		return errors.Wrapf(ErrPasswordPolicyViolation, "password must contain characters of at least %v different kinds (lowercase, uppercase, digits, other)", p.MinCharClasses)
+	}
+
+	return nil
+}
+
+// UserInfo describes a single user.
+type UserInfo struct {
+	Username           string    `json:"username"`
+	PasswordChanged    time.Time `json:"passwordChanged,omitempty"`
+	MustChangePassword bool      `json:"mustChangePassword,omitempty"`
+	PasswordExpired    bool      `json:"passwordExpired,omitempty"`
+	TOTPEnabled        bool      `json:"totpEnabled,omitempty"`
+	RecoveryCodesLeft  int       `json:"recoveryCodesLeft,omitempty"`
+}
+
+type userMetadata struct {
+	PasswordChanged    time.Time `json:"passwordChanged,omitempty"`
+	MustChangePassword bool      `json:"mustChangePassword,omitempty"`
+	TOTPSecret         string    `json:"totpSecret,omitempty"`
+	RecoveryCodes      []string  `json:"recoveryCodes,omitempty"` // SHA256 hashes of unused recovery codes
+}
+
+type metadataFile struct {
+	Policy *Policy                  `json:"policy,omitempty"`
+	Users  map[string]*userMetadata `json:"users,omitempty"`
+}
+
+// loginFailures tracks failed logins of a single user, it's only kept in memory.
+type loginFailures struct {
+	count       int
+	lockedUntil time.Time
+}
+
+// Database is a user database backed by htpasswd file.
+type Database struct {
+	filename string
+	timeNow  func() time.Time
+
+	// updateMu serializes updates, so that each of them applies to the most recent state on disk.
+	updateMu sync.Mutex
+
+	mu           sync.Mutex
+	version      string // version of the files on disk the database was read from or written to
+	hashes       map[string]string
+	meta         metadataFile
+	failures     map[string]*loginFailures
+	lastTOTPStep map[string]int64 // last time step of a TOTP code used by each user, to prevent replays
+}
+
+// Open opens the user database stored in the provided htpasswd file.
+// Missing file is treated as empty database and will be created by Save().
+func Open(filename string) (*Database, error) {
+	db := &Database{
+		filename:     filename,
+		timeNow:      clock.Now,
+		failures:     map[string]*loginFailures{},
+		lastTOTPStep: map[string]int64{},
+	}
+
+	if err := db.Reload(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// diskVersion identifies the current contents of database files, so that changes made by other processes,
+// such as the CLI editing users of a running server, can be detected without reading the files.
+func (db *Database) diskVersion() (string, error) {
+	var parts []string
+
+	for _, fname := range []string{db.filename, db.filename + MetadataFileSuffix} {
+		st, err := os.Stat(fname)
+
+		switch {
+		case os.IsNotExist(err):
+			parts = append(parts, "-")
+		case err != nil:
+			return "", errors.Wrap(err, "unable to stat user database")
+		default:
+			parts = append(parts, fmt.Sprintf("%v:%v", st.ModTime().UnixNano(), st.Size()))
+		}
+	}
+
+	return strings.Join(parts, "/"), nil
+}
+
+// Refresh re-reads the database from disk if it was modified by another process.
+func (db *Database) Refresh() error {
+	v, err := db.diskVersion()
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	current := db.version
+	db.mu.Unlock()
+
+	if v == current {
+		return nil
+	}
+
+	return db.Reload()
+}
+
+// Reload re-reads the database from disk. Failed login counters are preserved.
+func (db *Database) Reload() error {
+	// files modified while being read will be read again by the next Refresh().
+	version, err := db.diskVersion()
+	if err != nil {
+		return err
+	}
+
+	hashes, err := readHtpasswdFile(db.filename)
+	if err != nil {
+		return err
+	}
+
+	var meta metadataFile
+
+	b, err := ioutil.ReadFile(db.filename + MetadataFileSuffix)
+
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return errors.Wrap(err, "unable to read user metadata")
+	default:
+		if err := json.Unmarshal(b, &meta); err != nil {
+			return errors.Wrap(err, "invalid user metadata")
+		}
+	}
+
+	if meta.Users == nil {
+		meta.Users = map[string]*userMetadata{}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.hashes = hashes
+	db.meta = meta
+	db.version = version
+
+	return nil
+}
+
+func readHtpasswdFile(filename string) (map[string]string, error) {
+	hashes := map[string]string{}
+
+	f, err := os.Open(filename) //nolint:gosec
+	if os.IsNotExist(err) {
+		return hashes, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open htpasswd file")
+	}
+
+	defer f.Close() //nolint:errcheck
+
+	s := bufio.NewScanner(f)
+
+	for lineNumber := 1; s.Scan(); lineNumber++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p := strings.Index(line, ":")
+		if p <= 0 {
+			return nil, errors.Errorf("malformed htpasswd file, line %v", lineNumber)
+		}
+
+		hashes[line[0:p]] = line[p+1:]
+	}
+
+	return hashes, errors.Wrap(s.Err(), "unable to read htpasswd file")
+}
+
+// Save writes the database to disk. ErrConcurrentModification is returned if the files were modified
+// by another process since they were read, in which case nothing is written.
+func (db *Database) Save() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.saveLocked()
+}
+
+// Update applies the change to the most recent state of the database and saves it, the change is applied
+// again if the database is concurrently modified by another process.
+func (db *Database) Update(change func() error) error {
+	db.updateMu.Lock()
+	defer db.updateMu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		if err := db.Refresh(); err != nil {
+			return err
+		}
+
+		if err := change(); err != nil {
+			return err
+		}
+
+		err := db.Save()
+		if !errors.Is(err, ErrConcurrentModification) || attempt >= maxUpdateAttempts {
+			return err
+		}
+
+		// discard the change, it will be applied to the state written by the other process.
+		if err := db.Reload(); err != nil {
+			return err
+		}
+	}
+}
+
+func (db *Database) saveLocked() error {
+	v, err := db.diskVersion()
+	if err != nil {
+		return err
+	}
+
+	if v != db.version {
+		return ErrConcurrentModification
+	}
+
+	var buf bytes.Buffer
+
+	for _, u := range db.usernamesLocked() {
+		buf.WriteString(u + ":" + db.hashes[u] + "\n")
+	}
+
+	if err := atomic.WriteFile(db.filename, &buf); err != nil {
+		return errors.Wrap(err, "unable to write htpasswd file")
+	}
+
+	b, err := json.MarshalIndent(db.meta, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "unable to serialize user metadata")
+	}
+
+	if err := atomic.WriteFile(db.filename+MetadataFileSuffix, bytes.NewReader(b)); err != nil {
+		return errors.Wrap(err, "unable to write user metadata")
+	}
+
+	db.version, err = db.diskVersion()
+
+	return err
+}
+
+// Policy returns the password policy.
+func (db *Database) Policy() Policy {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.policyLocked()
+}
+
+func (db *Database) policyLocked() Policy {
+	if db.meta.Policy == nil {
+		return DefaultPolicy
+	}
+
+	return *db.meta.Policy
+}
+
+// SetPolicy sets the password policy. When password expiration is enabled, existing passwords
+// whose age is unknown are considered to have been changed now.
+func (db *Database) SetPolicy(p Policy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.meta.Policy = &p
+
+	if p.MaxAge > 0 {
+		for u := range db.hashes {
+			if md := db.userMetadataLocked(u); md.PasswordChanged.IsZero() {
+				md.PasswordChanged = db.timeNow()
+			}
+		}
+	}
+
+	return nil
+}
+
+func (db *Database) usernamesLocked() []string {
+	var result []string
+
+	for u := range db.hashes {
+		result = append(result, u)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+func (db *Database) userMetadataLocked(username string) *userMetadata {
+	md := db.meta.Users[username]
+	if md == nil {
+		md = &userMetadata{}
+		db.meta.Users[username] = md
+	}
+
+	return md
+}
+
+// Users returns information about all users sorted by username.
+func (db *Database) Users() []UserInfo {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var result []UserInfo
+
+	for _, u := range db.usernamesLocked() {
+		ui := UserInfo{Username: u}
+
+		if md := db.meta.Users[u]; md != nil {
+			ui.PasswordChanged = md.PasswordChanged
+			ui.MustChangePassword = md.MustChangePassword
+			ui.PasswordExpired = db.isExpiredLocked(md)
+			ui.TOTPEnabled = md.TOTPSecret != ""
+			ui.RecoveryCodesLeft = len(md.RecoveryCodes)
+		}
+
+		result = append(result, ui)
+	}
+
+	return result
+}
+
+func (db *Database) isExpiredLocked(md *userMetadata) bool {
+	maxAge := db.policyLocked().MaxAge
+
+	return maxAge > 0 && !md.PasswordChanged.IsZero() && db.timeNow().Sub(md.PasswordChanged) > maxAge
+}
+
+func validateUsername(username string) error {
+	if username == "" || strings.ContainsAny(username, ":\r\n") || strings.TrimSpace(username) != username {
+		return errors.Errorf("invalid username %q", username)
+	}
+
+	return nil
+}
+
+// AddUser adds a new user with the provided password.
+func (db *Database) AddUser(username, password string, mustChangePassword bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.hashes[username]; ok {
+		return errors.Wrap(ErrUserExists, username)
+	}
+
+	return db.setPasswordLocked(username, password, mustChangePassword)
+}
+
+// SetPassword changes the password of an existing user.
+func (db *Database) SetPassword(username, password string, mustChangePassword bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.hashes[username]; !ok {
+		return errors.Wrap(ErrUserNotFound, username)
+	}
+
+	return db.setPasswordLocked(username, password, mustChangePassword)
+}
+
+// ChangePassword is used by users to change their own password, which must be different from the current one.
+func (db *Database) ChangePassword(username, newPassword string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	hash, ok := db.hashes[username]
+	if !ok {
+		return errors.Wrap(ErrUserNotFound, username)
+	}
+
+	if passwordMatches(hash, newPassword) {
+		return errors.Wrap(ErrPasswordPolicyViolation, "new password must be different from the current one")
+	}
+
+	return db.setPasswordLocked(username, newPassword, false)
+}
+
+func (db *Database) setPasswordLocked(username, password string, mustChangePassword bool) error {
+	if err := validateUsername(username); err != nil {
+		return err
+	}
+
+	if err := db.policyLocked().CheckPassword(password); err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "unable to hash password")
+	}
+
+	db.hashes[username] = string(hash)
+
+	md := db.userMetadataLocked(username)
+	md.PasswordChanged = db.timeNow()
+	md.MustChangePassword = mustChangePassword
+
+	delete(db.failures, username)
+
+	return nil
+}
+
+// DeleteUser removes the user.
+func (db *Database) DeleteUser(username string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.hashes[username]; !ok {
+		return errors.Wrap(ErrUserNotFound, username)
+	}
+
+	delete(db.hashes, username)
+	delete(db.meta.Users, username)
+	delete(db.failures, username)
+
+	return nil
+}
+
+// ImportCSV adds or updates users from CSV records of the form 'username,password', optionally preceded
+// by a header line. All passwords are validated before any changes are made, so either all users
+// are imported or none of them. Returns the number of imported users.
+func (db *Database) ImportCSV(r io.Reader, mustChangePassword bool) (int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	cr.TrimLeadingSpace = true
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to parse CSV")
+	}
+
+	if len(records) > 0 && strings.EqualFold(records[0][0], "username") && strings.EqualFold(records[0][1], "password") {
+		records = records[1:]
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	policy := db.policyLocked()
+	seen := map[string]bool{}
+
+	for i, rec := range records {
+		if err := validateUsername(rec[0]); err != nil {
+			return 0, errors.Wrapf(err, "record %v", i+1)
+		}
+
+		if seen[rec[0]] {
+			return 0, errors.Errorf("record %v: duplicate user %q", i+1, rec[0])
+		}
+
+		seen[rec[0]] = true
+
+		if err := policy.CheckPassword(rec[1]); err != nil {
+			return 0, errors.Wrapf(err, "record %v (%v)", i+1, rec[0])
+		}
+	}
+
+	for _, rec := range records {
+		if err := db.setPasswordLocked(rec[0], rec[1], mustChangePassword); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(records), nil
+}
+
+func passwordMatches(hash, password string) bool {
+	for _, p := range htpasswd.DefaultSystems {
+		if ep, err := p(hash); err == nil && ep != nil {
+			return ep.MatchesPassword(password)
+		}
+	}
+
+	return false
+}
+
+// Authenticate verifies user credentials. When the password is correct but must be changed before
+// the account can be used, ErrPasswordChangeRequired or ErrPasswordExpired is returned.
+// Users with TOTP enabled must append the current TOTP code or a recovery code to the password.
+// Too many failed attempts cause the account to be locked for the duration specified in the policy.
+func (db *Database) Authenticate(username, password string) error {
+	if err := db.Refresh(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+
+	if f := db.failures[username]; f != nil && db.timeNow().Before(f.lockedUntil) {
+		db.mu.Unlock()
+		return ErrAccountLocked
+	}
+
+	md := db.meta.Users[username]
+	totpEnabled := md != nil && md.TOTPSecret != ""
+	hash, ok := db.hashes[username]
+
+	db.mu.Unlock()
+
+	var code string
+
+	if totpEnabled {
+		password, code = splitSecondFactor(password)
+	}
+
+	// password hashing is slow by design, so it's done without holding the lock to not serialize logins.
+	valid := ok && passwordMatches(hash, password)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if current, stillOK := db.hashes[username]; stillOK != ok || current != hash {
+		// password was changed while it was being verified.
+		return ErrInvalidCredentials
+	}
+
+	now := db.timeNow()
+	policy := db.policyLocked()
+
+	f := db.failures[username]
+	if f != nil && now.Before(f.lockedUntil) {
+		// locked by concurrent attempts.
+		return ErrAccountLocked
+	}
+
+	if md = db.meta.Users[username]; totpEnabled != (md != nil && md.TOTPSecret != "") {
+		// TOTP was enabled or disabled while the password was being verified.
+		return ErrInvalidCredentials
+	}
+
+	if valid && totpEnabled {
+		v, err := db.verifySecondFactorLocked(username, md, code)
+		if err != nil {
+			return err
+		}
+
+		valid = v
+	}
+
+	if !valid {
+		if ok && policy.MaxFailedAttempts > 0 {
+			if f == nil {
+				f = &loginFailures{}
+				db.failures[username] = f
+			}
+
+			f.count++
+
+			if f.count >= policy.MaxFailedAttempts {
+				f.count = 0
+				f.lockedUntil = now.Add(policy.LockoutDuration)
+
+				return ErrAccountLocked
+			}
+		}
+
+		return ErrInvalidCredentials
+	}
+
+	delete(db.failures, username)
+
+	return db.passwordStatusLocked(md)
+}
+
+// AccountStatus returns the error Authenticate would return for correct credentials of the user, without
+// verifying them. It's used to check accounts of users authenticated by other means, such as sessions.
+func (db *Database) AccountStatus(username string) error {
+	if err := db.Refresh(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if f := db.failures[username]; f != nil && db.timeNow().Before(f.lockedUntil) {
+		return ErrAccountLocked
+	}
+
+	if _, ok := db.hashes[username]; !ok {
+		return ErrInvalidCredentials
+	}
+
+	return db.passwordStatusLocked(db.meta.Users[username])
+}
+
+func (db *Database) passwordStatusLocked(md *userMetadata) error {
+	if md == nil {
+		return nil
+	}
+
+	if md.MustChangePassword {
+		return ErrPasswordChangeRequired
+	}
+
+	if db.isExpiredLocked(md) {
+		return ErrPasswordExpired
+	}
+
+	return nil
+}
+
+// CredentialsFingerprint returns a value that changes whenever the password or TOTP secret of the user changes,
+// which can be used to invalidate sessions established using previous credentials.
+func (db *Database) CredentialsFingerprint(username string) (string, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	hash, ok := db.hashes[username]
+	if !ok {
+		return "", false
+	}
+
+	h := sha256.New()
+	h.Write([]byte(hash)) //nolint:errcheck
+
+	if md := db.meta.Users[username]; md != nil {
+		h.Write([]byte(md.TOTPSecret)) //nolint:errcheck
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), true
+}
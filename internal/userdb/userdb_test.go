@@ -0,0 +1,228 @@
+package userdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	htpasswd "github.com/tg123/go-htpasswd"
+
+	"github.com/kopia/kopia/internal/faketime"
+)
+
+func openTestDatabase(t *testing.T) (db *Database, ta *faketime.TimeAdvance, cleanup func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "kopia-userdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(filepath.Join(dir, "htpasswd"))
+	if err != nil {
+		t.Fatalf("unable to open database: %v", err)
+	}
+
+	ta = faketime.NewTimeAdvance(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), 0)
+	db.timeNow = ta.NowFunc()
+
+	return db, ta, func() { os.RemoveAll(dir) }
+}
+
+func TestUserDatabase(t *testing.T) {
+	db, _, cleanup := openTestDatabase(t)
+	defer cleanup()
+
+	must(t, db.AddUser("foo@bar", "password1", false))
+
+	if err := db.AddUser("foo@bar", "password2", false); !errors.Is(err, ErrUserExists) {
+		t.Fatalf("unexpected error when adding duplicate user: %v", err)
+	}
+
+	if err := db.AddUser("bar@baz", "short", false); !errors.Is(err, ErrPasswordPolicyViolation) {
+		t.Fatalf("expected policy violation, got %v", err)
+	}
+
+	must(t, db.Save())
+
+	// the file must be readable by htpasswd-compatible tools.
+	f, err := htpasswd.New(db.filename, htpasswd.DefaultSystems, nil)
+	if err != nil {
+		t.Fatalf("unable to read htpasswd file: %v", err)
+	}
+
+	if !f.Match("foo@bar", "password1") {
+		t.Fatalf("password does not match")
+	}
+
+	db2, err := Open(db.filename)
+	if err != nil {
+		t.Fatalf("unable to reopen: %v", err)
+	}
+
+	must(t, db2.Authenticate("foo@bar", "password1"))
+
+	if err := db2.Authenticate("foo@bar", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	must(t, db2.DeleteUser("foo@bar"))
+
+	if err := db2.Authenticate("foo@bar", "password1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("unexpected error for deleted user: %v", err)
+	}
+}
+
+func TestUserDatabaseConcurrentModification(t *testing.T) {
+	db, _, cleanup := openTestDatabase(t)
+	defer cleanup()
+
+	must(t, db.AddUser("foo@bar", "password1", false))
+	must(t, db.Save())
+
+	// another process, such as the CLI, adds user while the database is open.
+	other, err := Open(db.filename)
+	if err != nil {
+		t.Fatalf("unable to open: %v", err)
+	}
+
+	must(t, other.AddUser("bar@baz", "password2", false))
+	must(t, other.Save())
+
+	// changes made by other processes are picked up without explicit reload.
+	must(t, db.Authenticate("bar@baz", "password2"))
+
+	must(t, other.AddUser("baz@qux", "password3", false))
+	must(t, other.Save())
+
+	// saving stale state must not overwrite the changes.
+	must(t, db.ChangePassword("foo@bar", "password4"))
+
+	if err := db.Save(); !errors.Is(err, ErrConcurrentModification) {
+		t.Fatalf("unexpected error when saving stale database: %v", err)
+	}
+
+	must(t, db.Update(func() error { return db.ChangePassword("foo@bar", "password4") }))
+
+	reopened, err := Open(db.filename)
+	if err != nil {
+		t.Fatalf("unable to reopen: %v", err)
+	}
+
+	must(t, reopened.Authenticate("foo@bar", "password4"))
+	must(t, reopened.Authenticate("bar@baz", "password2"))
+	must(t, reopened.Authenticate("baz@qux", "password3"))
+}
+
+func TestUserDatabasePolicy(t *testing.T) {
+	db, ta, cleanup := openTestDatabase(t)
+	defer cleanup()
+
+	must(t, db.SetPolicy(Policy{
+		MinLength:         10,
+		MinCharClasses:    3,
+		MaxAge:            24 * time.Hour,
+		MaxFailedAttempts: 3,
+		LockoutDuration:   time.Hour,
+	}))
+
+	if err := db.AddUser("foo@bar", "alllowercase", false); !errors.Is(err, ErrPasswordPolicyViolation) {
+		t.Fatalf("expected policy violation, got %v", err)
+	}
+
+	must(t, db.AddUser("foo@bar", "Complex-Pass1", true))
+
+	// correct password, but must be changed.
+	if err := db.Authenticate("foo@bar", "Complex-Pass1"); !errors.Is(err, ErrPasswordChangeRequired) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.AccountStatus("foo@bar"); !errors.Is(err, ErrPasswordChangeRequired) {
+		t.Fatalf("unexpected account status: %v", err)
+	}
+
+	if err := db.ChangePassword("foo@bar", "Complex-Pass1"); !errors.Is(err, ErrPasswordPolicyViolation) {
+		t.Fatalf("expected error when reusing password, got %v", err)
+	}
+
+	must(t, db.ChangePassword("foo@bar", "Complex-Pass2"))
+	must(t, db.Authenticate("foo@bar", "Complex-Pass2"))
+
+	// lockout after 3 failed attempts.
+	for i := 0; i < 2; i++ {
+		if err := db.Authenticate("foo@bar", "bad"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := db.Authenticate("foo@bar", "bad"); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.Authenticate("foo@bar", "Complex-Pass2"); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("correct password accepted while locked: %v", err)
+	}
+
+	if err := db.AccountStatus("foo@bar"); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("unexpected account status: %v", err)
+	}
+
+	ta.Advance(time.Hour)
+	must(t, db.Authenticate("foo@bar", "Complex-Pass2"))
+
+	// password expires after max age.
+	ta.Advance(25 * time.Hour)
+
+	if err := db.Authenticate("foo@bar", "Complex-Pass2"); !errors.Is(err, ErrPasswordExpired) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.AccountStatus("foo@bar"); !errors.Is(err, ErrPasswordExpired) {
+		t.Fatalf("unexpected account status: %v", err)
+	}
+
+	if users := db.Users(); len(users) != 1 || !users[0].PasswordExpired {
+		t.Fatalf("unexpected users: %v", users)
+	}
+}
+
+func TestUserDatabaseImportCSV(t *testing.T) {
+	db, _, cleanup := openTestDatabase(t)
+	defer cleanup()
+
+	must(t, db.AddUser("existing@host", "password0", false))
+
+	// one invalid password causes the entire import to fail.
+	if _, err := db.ImportCSV(strings.NewReader("username,password\nfoo@bar,password1\nbar@baz,short\n"), true); err == nil {
+		t.Fatalf("expected import error")
+	}
+
+	if got := len(db.Users()); got != 1 {
+		t.Fatalf("partial import: %v users", got)
+	}
+
+	n, err := db.ImportCSV(strings.NewReader("username,password\nfoo@bar,password1\nexisting@host,password2\n"), true)
+	if err != nil {
+		t.Fatalf("import error: %v", err)
+	}
+
+	if n != 2 {
+		t.Fatalf("unexpected number of imported users: %v", n)
+	}
+
+	if err := db.Authenticate("existing@host", "password2"); !errors.Is(err, ErrPasswordChangeRequired) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -76,7 +76,14 @@ func main() {
 
 	app.Version(repo.BuildVersion + " build: " + repo.BuildInfo)
 	app.PreAction(logfile.Initialize)
+	cli.SetLogLevelSetter(logfile.SetLevels)
 	app.UsageTemplate(usageTemplate)
 
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	if _, err := app.Parse(os.Args[1:]); err != nil {
+		os.Exit(cli.ReportError(err))
+	}
+
+	if code := cli.ExitCode(); code != 0 {
+		os.Exit(code)
+	}
 }
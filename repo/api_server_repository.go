@@ -9,13 +9,19 @@
 	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/kopia/kopia/internal/apiclient"
 	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/clockskew"
 	"github.com/kopia/kopia/internal/remoterepoapi"
+	"github.com/kopia/kopia/internal/retry"
+	"github.com/kopia/kopia/repo/compression"
 	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/repo/hashing"
 	"github.com/kopia/kopia/repo/manifest"
@@ -26,16 +32,43 @@
 type APIServerInfo struct {
 	BaseURL                             string `json:"url"`
 	TrustedServerCertificateFingerprint string `json:"serverCertFingerprint"`
+
+	// StandbyURLs are URLs of read-only standby servers of a replicated repository, which serve reads
+	// when the primary server can't be reached. Standby servers must use the same certificate.
+	StandbyURLs []string `json:"standbyURLs,omitempty"`
 }
 
+// primaryRetryInterval is the time during which reads go to standby servers first after the primary
+// server could not be reached.
+const primaryRetryInterval = time.Minute
+
+const (
+	// cacheSizeReportMarkerFile is the file in the cache directory whose modification time is the time
+	// when the size of the cache was last reported to the server.
+	cacheSizeReportMarkerFile = ".last-cache-size-report"
+
+	cacheSizeReportInterval = 24 * time.Hour
+)
+
 // remoteRepository is an implementation of Repository that connects to an instance of
 // API server hosted by `kopia server`, instead of directly manipulating files in the BLOB storage.
 type apiServerRepository struct {
-	cli *apiclient.KopiaAPIClient
-	h   hashing.HashFunc
+	cli      *apiclient.KopiaAPIClient
+	standbys []*apiclient.KopiaAPIClient
+	h        hashing.HashFunc
 
 	omgr    *object.Manager
 	cliOpts ClientOptions
+
+	mu               sync.Mutex
+	primaryDownUntil time.Time
+
+	// written is non-zero when contents or manifests were written since the last flush.
+	written int32
+
+	// serverJournalsUploads is true when the server persists uploaded contents across restarts,
+	// which makes retrying writes when it can't be reached safe.
+	serverJournalsUploads bool
 }
 
 func (r *apiServerRepository) APIServerURL() string {
@@ -66,10 +99,45 @@ func (r *apiServerRepository) VerifyObject(ctx context.Context, id object.ID) ([
 	return r.omgr.VerifyObject(ctx, id)
 }
 
+// readClients returns clients in the order in which reads are attempted, which prefers standby servers
+// for a while after the primary server could not be reached.
+func (r *apiServerRepository) readClients() []*apiclient.KopiaAPIClient {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.standbys) > 0 && clock.Now().Before(r.primaryDownUntil) {
+		return append(append([]*apiclient.KopiaAPIClient{}, r.standbys...), r.cli)
+	}
+
+	return append([]*apiclient.KopiaAPIClient{r.cli}, r.standbys...)
+}
+
+// get performs HTTP GET on the primary server and fails over to standby servers when it can't be reached.
+func (r *apiServerRepository) get(ctx context.Context, urlSuffix string, onNotFound error, respPayload interface{}) error {
+	var err error
+
+	for _, cli := range r.readClients() {
+		err = cli.Get(ctx, urlSuffix, onNotFound, respPayload)
+		if err == nil || !isServerUnreachable(err) {
+			return err
+		}
+
+		if cli == r.cli && len(r.standbys) > 0 {
+			log(ctx).Debugf("primary server %v is unreachable, failing over to standby servers: %v", cli.BaseURL, err)
+
+			r.mu.Lock()
+			r.primaryDownUntil = clock.Now().Add(primaryRetryInterval)
+			r.mu.Unlock()
+		}
+	}
+
+	return err
+}
+
 func (r *apiServerRepository) GetManifest(ctx context.Context, id manifest.ID, data interface{}) (*manifest.EntryMetadata, error) {
 	var mm remoterepoapi.ManifestWithMetadata
 
-	if err := r.cli.Get(ctx, "manifests/"+string(id), manifest.ErrNotFound, &mm); err != nil {
+	if err := r.get(ctx, "manifests/"+string(id), manifest.ErrNotFound, &mm); err != nil {
 		return nil, err
 	}
 
@@ -91,6 +159,8 @@ func (r *apiServerRepository) PutManifest(ctx context.Context, labels map[string
 
 	resp := &manifest.EntryMetadata{}
 
+	r.markWritten()
+
 	if err := r.cli.Post(ctx, "manifests", req, resp); err != nil {
 		return "", err
 	}
@@ -107,14 +177,34 @@ func (r *apiServerRepository) FindManifests(ctx context.Context, labels map[stri
 
 	var mm []*manifest.EntryMetadata
 
-	if err := r.cli.Get(ctx, "manifests?"+uv.Encode(), nil, &mm); err != nil {
+	if err := r.get(ctx, "manifests?"+uv.Encode(), nil, &mm); err != nil {
 		return nil, err
 	}
 
 	return mm, nil
 }
 
+func (r *apiServerRepository) ReplaceManifest(ctx context.Context, id manifest.ID, labels map[string]string, payload interface{}) error {
+	v, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal JSON")
+	}
+
+	req := &remoterepoapi.ManifestWithMetadata{
+		Payload: json.RawMessage(v),
+		Metadata: &manifest.EntryMetadata{
+			Labels: labels,
+		},
+	}
+
+	r.markWritten()
+
+	return r.cli.Put(ctx, "manifests/"+string(id), req, &manifest.EntryMetadata{})
+}
+
 func (r *apiServerRepository) DeleteManifest(ctx context.Context, id manifest.ID) error {
+	r.markWritten()
+
 	return r.cli.Delete(ctx, "manifests/"+string(id), nil, nil)
 }
 
@@ -126,8 +216,46 @@ func (r *apiServerRepository) Refresh(ctx context.Context) error {
 	return nil
 }
 
+// isServerUnreachable determines whether the request failed because the server could not be reached,
+// for example because it's being restarted, as opposed to being canceled.
+func isServerUnreachable(err error) bool {
+	var ue *url.Error
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	return errors.As(err, &ue)
+}
+
+// isRetriableWrite returns a function that determines whether a write can be retried. Server that journals
+// uploads recovers contents after restart, so writes can be safely retried when it can't be reached.
+// Otherwise contents written before the restart would be lost even though the client considers them written.
+func (r *apiServerRepository) isRetriableWrite(ctx context.Context) func(err error) bool {
+	return func(err error) bool {
+		return r.serverJournalsUploads && ctx.Err() == nil && isServerUnreachable(err)
+	}
+}
+
+// Flush flushes writes on the server. It does nothing when nothing was written, so that repositories
+// used only for reading can be closed while only standby servers are reachable.
 func (r *apiServerRepository) Flush(ctx context.Context) error {
-	return r.cli.Post(ctx, "flush", nil, nil)
+	if !atomic.CompareAndSwapInt32(&r.written, 1, 0) {
+		return nil
+	}
+
+	err := retry.WithExponentialBackoffNoValue(ctx, "flush", func() error {
+		return r.cli.Post(ctx, "flush", nil, nil)
+	}, r.isRetriableWrite(ctx))
+	if err != nil {
+		atomic.StoreInt32(&r.written, 1)
+	}
+
+	return err
+}
+
+func (r *apiServerRepository) markWritten() {
+	atomic.StoreInt32(&r.written, 1)
 }
 
 func (r *apiServerRepository) Close(ctx context.Context) error {
@@ -141,7 +269,7 @@ func (r *apiServerRepository) Close(ctx context.Context) error {
 func (r *apiServerRepository) ContentInfo(ctx context.Context, contentID content.ID) (content.Info, error) {
 	var bi content.Info
 
-	if err := r.cli.Get(ctx, "contents/"+string(contentID)+"?info=1", content.ErrContentNotFound, &bi); err != nil {
+	if err := r.get(ctx, "contents/"+string(contentID)+"?info=1", content.ErrContentNotFound, &bi); err != nil {
 		return content.Info{}, err
 	}
 
@@ -151,7 +279,7 @@ func (r *apiServerRepository) ContentInfo(ctx context.Context, contentID content
 func (r *apiServerRepository) GetContent(ctx context.Context, contentID content.ID) ([]byte, error) {
 	var result []byte
 
-	if err := r.cli.Get(ctx, "contents/"+string(contentID), content.ErrContentNotFound, &result); err != nil {
+	if err := r.get(ctx, "contents/"+string(contentID), content.ErrContentNotFound, &result); err != nil {
 		return nil, err
 	}
 
@@ -167,7 +295,11 @@ func (r *apiServerRepository) WriteContent(ctx context.Context, data []byte, pre
 
 	contentID := prefix + content.ID(hex.EncodeToString(r.h(hashOutput[:0], data)))
 
-	if err := r.cli.Put(ctx, "contents/"+string(contentID), data, nil); err != nil {
+	r.markWritten()
+
+	if err := retry.WithExponentialBackoffNoValue(ctx, "WriteContent", func() error {
+		return r.cli.Put(ctx, "contents/"+string(contentID), data, nil)
+	}, r.isRetriableWrite(ctx)); err != nil {
 		return "", err
 	}
 
@@ -182,7 +314,7 @@ func (r *apiServerRepository) UpdateDescription(d string) {
 var _ Repository = (*apiServerRepository)(nil)
 
 // openAPIServer connects remote repository over Kopia API.
-func openAPIServer(ctx context.Context, si *APIServerInfo, cliOpts ClientOptions, password string) (Repository, error) {
+func openAPIServer(ctx context.Context, si *APIServerInfo, cliOpts ClientOptions, cacheDirectory, password string) (Repository, error) {
 	cli, err := apiclient.NewKopiaAPIClient(apiclient.Options{
 		BaseURL:                             si.BaseURL,
 		TrustedServerCertificateFingerprint: si.TrustedServerCertificateFingerprint,
@@ -199,9 +331,24 @@ func openAPIServer(ctx context.Context, si *APIServerInfo, cliOpts ClientOptions
 		cliOpts: cliOpts,
 	}
 
+	for _, u := range si.StandbyURLs {
+		scli, err := apiclient.NewKopiaAPIClient(apiclient.Options{
+			BaseURL:                             u,
+			TrustedServerCertificateFingerprint: si.TrustedServerCertificateFingerprint,
+			Username:                            cliOpts.Username + "@" + cliOpts.Hostname,
+			Password:                            password,
+			LogRequests:                         true,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to create standby API client")
+		}
+
+		rr.standbys = append(rr.standbys, scli)
+	}
+
 	var p remoterepoapi.Parameters
 
-	if err = cli.Get(ctx, "repo/parameters", nil, &p); err != nil {
+	if err = rr.get(ctx, "repo/parameters", nil, &p); err != nil {
 		return nil, errors.Wrap(err, "unable to get repository parameters")
 	}
 
@@ -211,6 +358,7 @@ func openAPIServer(ctx context.Context, si *APIServerInfo, cliOpts ClientOptions
 	}
 
 	rr.h = hf
+	rr.serverJournalsUploads = p.UploadJournal
 
 	// create object manager using rr as contentManager implementation.
 	omgr, err := object.NewObjectManager(ctx, rr, p.Format, object.ManagerOptions{})
@@ -220,9 +368,94 @@ func openAPIServer(ctx context.Context, si *APIServerInfo, cliOpts ClientOptions
 
 	rr.omgr = omgr
 
+	if len(p.Format.ZstdDictionaryIDs) > 0 {
+		var dicts []compression.ZstdDictionary
+
+		if err := rr.get(ctx, "repo/zstd-dictionaries", nil, &dicts); err != nil {
+			return nil, errors.Wrap(err, "unable to get compression dictionaries")
+		}
+
+		if err := omgr.SetZstdDictionaries(dicts); err != nil {
+			return nil, err
+		}
+	}
+
+	rr.reportClientInfo(ctx, cacheDirectory)
+
 	return rr, nil
 }
 
+// reportClientInfo reports version and platform of the client to the server, which maintains
+// the inventory of its clients. Failures are not fatal, since older servers don't support it.
+func (r *apiServerRepository) reportClientInfo(ctx context.Context, cacheDirectory string) {
+	ci := &remoterepoapi.ClientInfo{
+		Username:  r.cliOpts.Username,
+		Hostname:  r.cliOpts.Hostname,
+		Version:   BuildVersion,
+		BuildInfo: BuildInfo,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+
+	markerFile := filepath.Join(cacheDirectory, cacheSizeReportMarkerFile)
+
+	if cacheDirectory != "" && cacheSizeReportDue(markerFile) {
+		ci.CacheSizeBytes = directorySize(cacheDirectory)
+		ci.CacheSizeTime = clock.Now()
+	}
+
+	var resp remoterepoapi.ClientInfo
+
+	ci.ClientTime = clock.Now()
+
+	if err := r.cli.Post(ctx, "clients/report", ci, &resp); err != nil {
+		log(ctx).Debugf("unable to report client information: %v", err)
+		return
+	}
+
+	if !ci.CacheSizeTime.IsZero() {
+		if err := ioutil.WriteFile(markerFile, nil, 0o600); err != nil { //nolint:gomnd
+			log(ctx).Debugf("unable to write %v: %v", markerFile, err)
+		}
+	}
+
+	if !resp.ServerTime.IsZero() {
+		if skew := clockskew.Estimate(ci.ClientTime, clock.Now(), resp.ServerTime); clockskew.Exceeds(skew, clockskew.DefaultMaxSkew) {
+			log(ctx).Warningf("WARNING: The local clock differs from the server clock by %v, please synchronize the clock of this machine.", skew)
+		}
+	}
+
+	if resp.PolicyProfile != "" {
+		log(ctx).Debugf("client uses policy profile %v", resp.PolicyProfile)
+	}
+}
+
+// cacheSizeReportDue determines whether the cache size should be measured and reported to the server,
+// which is done once per cacheSizeReportInterval, since it requires walking the whole cache directory.
+func cacheSizeReportDue(markerFile string) bool {
+	st, err := os.Stat(markerFile)
+	if err != nil {
+		return true
+	}
+
+	return clock.Since(st.ModTime()) >= cacheSizeReportInterval
+}
+
+// directorySize returns the total size of files in the provided directory tree.
+func directorySize(dir string) int64 {
+	var total int64
+
+	_ = filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			total += fi.Size()
+		}
+
+		return nil
+	})
+
+	return total
+}
+
 // ConnectAPIServer sets up repository connection to a particular API server.
 func ConnectAPIServer(ctx context.Context, configFile string, si *APIServerInfo, password string, opt *ConnectOptions) error {
 	lc := LocalConfig{
@@ -243,5 +476,5 @@ func ConnectAPIServer(ctx context.Context, configFile string, si *APIServerInfo,
 		return errors.Wrap(err, "unable to write config file")
 	}
 
-	return verifyConnect(ctx, configFile, password, opt.PersistCredentials)
+	return verifyConnect(ctx, configFile, password, opt.PersistCredentials, opt.Prefetch)
 }
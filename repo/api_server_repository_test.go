@@ -0,0 +1,66 @@
+package repo
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+)
+
+func TestIsRetriableWrite(t *testing.T) {
+	ctx := context.Background()
+	unreachable := &url.Error{Op: "Put", URL: "https://server", Err: errors.New("connection refused")}
+	canceled := &url.Error{Op: "Put", URL: "https://server", Err: context.Canceled}
+
+	r := &apiServerRepository{}
+	if r.isRetriableWrite(ctx)(unreachable) {
+		t.Errorf("writes must not be retried when the server does not journal uploads")
+	}
+
+	r.serverJournalsUploads = true
+	if !r.isRetriableWrite(ctx)(unreachable) {
+		t.Errorf("writes should be retried when the server journals uploads")
+	}
+
+	if r.isRetriableWrite(ctx)(errors.Wrap(canceled, "write failed")) {
+		t.Errorf("canceled writes must not be retried")
+	}
+
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if r.isRetriableWrite(canceledCtx)(unreachable) {
+		t.Errorf("writes must not be retried after the context is canceled")
+	}
+}
+
+func TestCacheSizeReportDue(t *testing.T) {
+	markerFile := filepath.Join(t.TempDir(), cacheSizeReportMarkerFile)
+
+	if !cacheSizeReportDue(markerFile) {
+		t.Errorf("cache size was never reported")
+	}
+
+	if err := ioutil.WriteFile(markerFile, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if cacheSizeReportDue(markerFile) {
+		t.Errorf("cache size was just reported")
+	}
+
+	old := clock.Now().Add(-cacheSizeReportInterval)
+	if err := os.Chtimes(markerFile, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if !cacheSizeReportDue(markerFile) {
+		t.Errorf("cache size was reported %v ago", cacheSizeReportInterval)
+	}
+}
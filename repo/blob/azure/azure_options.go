@@ -14,4 +14,11 @@ type Options struct {
 
 	MaxUploadSpeedBytesPerSecond   int `json:"maxUploadSpeedBytesPerSecond,omitempty"`
 	MaxDownloadSpeedBytesPerSecond int `json:"maxDownloadSpeedBytesPerSecond,omitempty"`
+
+	// AccessTiers maps blob ID prefixes to access tiers (Hot, Cool or Archive) in which blobs are created when they are uploaded.
+	// When multiple prefixes match, the longest one is used. Blobs not matching any prefix use the default tier of the account.
+	AccessTiers map[string]string `json:"accessTiers,omitempty"`
+
+	// RehydrateTier is the access tier (Hot or Cool) to which archived blobs are rehydrated when they are read, defaults to Hot.
+	RehydrateTier string `json:"rehydrateTier,omitempty"`
 }
@@ -58,6 +58,10 @@ func (az *azStorage) GetBlob(ctx context.Context, b blob.ID, offset, length int6
 	}
 
 	v, err := exponentialBackoff(ctx, fmt.Sprintf("GetBlob(%q,%v,%v)", b, offset, length), attempt)
+	if serviceCode(err) == azblob.ServiceCodeBlobArchived {
+		return nil, az.rehydrateArchivedBlob(ctx, b)
+	}
+
 	if err != nil {
 		return nil, translateError(err)
 	}
@@ -77,10 +81,13 @@ func (az *azStorage) GetMetadata(ctx context.Context, b blob.ID) (blob.Metadata,
 			return nil, err
 		}
 
+		var props azblob.BlobGetPropertiesResponse
+
 		return blob.Metadata{
 			BlobID:    b,
 			Length:    fi.Size,
 			Timestamp: fi.ModTime,
+			Cold:      fi.As(&props) && isArchived(azblob.AccessTierType(props.AccessTier())),
 		}, nil
 	}
 
@@ -127,7 +134,15 @@ func translateError(err error) error {
 	}
 }
 
-func (az *azStorage) PutBlob(ctx context.Context, b blob.ID, data blob.Bytes) error {
+func (az *azStorage) PutBlob(ctx context.Context, b blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	if opts.HasRetentionOptions() {
+		return blob.ErrUnsupportedPutBlobOption
+	}
+
+	if tier := accessTierForBlob(az.AccessTiers, b); tier != "" {
+		ctx = withAccessTier(ctx, tier)
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -196,10 +211,13 @@ func (az *azStorage) ListBlobs(ctx context.Context, prefix blob.ID, callback fun
 			return err
 		}
 
+		var bi azblob.BlobItem
+
 		bm := blob.Metadata{
 			BlobID:    blob.ID(lo.Key[len(az.Prefix):]),
 			Length:    lo.Size,
 			Timestamp: lo.ModTime,
+			Cold:      lo.As(&bi) && isArchived(bi.Properties.AccessTier),
 		}
 
 		if err := callback(bm); err != nil {
@@ -241,17 +259,18 @@ func New(ctx context.Context, opt *Options) (blob.Storage, error) {
 		return nil, errors.New("container name must be specified")
 	}
 
+	if err := validateAccessTiers(opt); err != nil {
+		return nil, err
+	}
+
 	// create a credentials object.
 	credential, err := azureblob.NewCredential(azureblob.AccountName(opt.StorageAccount), azureblob.AccountKey(opt.StorageKey))
 	if err != nil {
 		return nil, err
 	}
 
-	// create a Pipeline with credentials.
-	pipeline := azureblob.NewPipeline(credential, azblob.PipelineOptions{})
-
 	// create a *blob.Bucket.
-	bucket, err := azureblob.OpenBucket(ctx, pipeline, azureblob.AccountName(opt.StorageAccount), opt.Container, &azureblob.Options{Credential: credential})
+	bucket, err := azureblob.OpenBucket(ctx, newPipeline(credential), azureblob.AccountName(opt.StorageAccount), opt.Container, &azureblob.Options{Credential: credential})
 	if err != nil {
 		return nil, err
 	}
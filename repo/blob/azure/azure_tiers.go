@@ -0,0 +1,154 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// Supported access tiers.
+const (
+	AccessTierHot     = string(azblob.AccessTierHot)
+	AccessTierCool    = string(azblob.AccessTierCool)
+	AccessTierArchive = string(azblob.AccessTierArchive)
+)
+
+// SupportedAccessTiers lists access tiers which can be assigned to blob prefixes.
+var SupportedAccessTiers = []string{AccessTierHot, AccessTierCool, AccessTierArchive}
+
+func validateAccessTiers(opt *Options) error {
+	for prefix, tier := range opt.AccessTiers {
+		if !isSupportedAccessTier(tier) {
+			return errors.Errorf("unsupported access tier %q for prefix %q, must be one of %v", tier, prefix, SupportedAccessTiers)
+		}
+	}
+
+	switch opt.RehydrateTier {
+	case "", AccessTierHot, AccessTierCool:
+		return nil
+	default:
+		return errors.Errorf("unsupported rehydration tier %q, must be %v or %v", opt.RehydrateTier, AccessTierHot, AccessTierCool)
+	}
+}
+
+func isSupportedAccessTier(tier string) bool {
+	for _, t := range SupportedAccessTiers {
+		if t == tier {
+			return true
+		}
+	}
+
+	return false
+}
+
+// accessTierForBlob returns the access tier assigned to the longest prefix of the provided blob ID or an empty string.
+func accessTierForBlob(tiers map[string]string, b blob.ID) string {
+	var longest, tier string
+
+	for prefix, t := range tiers {
+		if strings.HasPrefix(string(b), prefix) && len(prefix) >= len(longest) {
+			longest, tier = prefix, t
+		}
+	}
+
+	return tier
+}
+
+type accessTierContextKey struct{}
+
+// withAccessTier returns a context which causes blobs uploaded using it to be created in the provided access tier.
+func withAccessTier(ctx context.Context, tier string) context.Context {
+	return context.WithValue(ctx, accessTierContextKey{}, tier)
+}
+
+// newPipeline returns the same pipeline as azblob.NewPipeline() which also sets access tiers of uploaded blobs.
+// Upload options of the SDK don't support access tiers, so the header is added to requests which create blobs
+// before they are signed.
+func newPipeline(credential azblob.Credential) pipeline.Pipeline {
+	return pipeline.NewPipeline([]pipeline.Factory{
+		azblob.NewTelemetryPolicyFactory(azblob.TelemetryOptions{}),
+		azblob.NewUniqueRequestIDPolicyFactory(),
+		azblob.NewRetryPolicyFactory(azblob.RetryOptions{}),
+		pipeline.FactoryFunc(accessTierPolicy),
+		credential,
+		azblob.NewRequestLogPolicyFactory(azblob.RequestLogOptions{}),
+		pipeline.MethodFactoryMarker(),
+	}, pipeline.Options{})
+}
+
+func accessTierPolicy(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+	return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+		if tier, ok := ctx.Value(accessTierContextKey{}).(string); ok && isBlobUploadRequest(request.Request) {
+			request.Header.Set("x-ms-access-tier", tier)
+		}
+
+		return next.Do(ctx, request)
+	}
+}
+
+// isBlobUploadRequest returns true for Put Blob and Put Block List requests, which create block blobs.
+func isBlobUploadRequest(r *http.Request) bool {
+	if r.Method != http.MethodPut {
+		return false
+	}
+
+	switch r.URL.Query().Get("comp") {
+	case "blocklist":
+		return true
+	case "":
+		return r.Header.Get("x-ms-blob-type") == string(azblob.BlobBlockBlob)
+	default:
+		return false
+	}
+}
+
+func (az *azStorage) rehydrateTier() string {
+	if az.RehydrateTier != "" {
+		return az.RehydrateTier
+	}
+
+	return AccessTierHot
+}
+
+func (az *azStorage) setAccessTier(ctx context.Context, b blob.ID, tier string) error {
+	var containerURL *azblob.ContainerURL
+
+	if !az.bucket.As(&containerURL) {
+		return errors.New("unable to access Azure container")
+	}
+
+	_, err := containerURL.NewBlobURL(az.getObjectNameString(b)).SetTier(ctx, azblob.AccessTierType(tier), azblob.LeaseAccessConditions{})
+
+	return errors.Wrapf(err, "unable to set access tier of %v to %v", b, tier)
+}
+
+// rehydrateArchivedBlob initiates rehydration of an archived blob and returns an error describing it.
+func (az *azStorage) rehydrateArchivedBlob(ctx context.Context, b blob.ID) error {
+	tier := az.rehydrateTier()
+
+	if err := az.setAccessTier(ctx, b, tier); err != nil && serviceCode(err) != azblob.ServiceCodeBlobBeingRehydrated {
+		return errors.Wrapf(blob.ErrBlobArchived, "%v is in the archive tier and rehydration could not be initiated: %v", b, err)
+	}
+
+	return errors.Wrapf(blob.ErrBlobArchived, "%v is in the archive tier, rehydration to the %v tier is in progress and may take several hours", b, tier)
+}
+
+func isArchived(tier azblob.AccessTierType) bool {
+	return tier == azblob.AccessTierArchive
+}
+
+func serviceCode(err error) azblob.ServiceCodeType {
+	var se azblob.StorageError
+
+	if errors.As(err, &se) {
+		return se.ServiceCode()
+	}
+
+	return ""
+}
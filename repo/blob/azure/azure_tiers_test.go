@@ -0,0 +1,109 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/Azure/azure-pipeline-go/pipeline"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func TestAccessTierForBlob(t *testing.T) {
+	tiers := map[string]string{
+		"p":  AccessTierArchive,
+		"pa": AccessTierCool,
+		"q":  AccessTierHot,
+	}
+
+	cases := map[blob.ID]string{
+		"p1234":  AccessTierArchive,
+		"pa1234": AccessTierCool,
+		"q1234":  AccessTierHot,
+		"n1234":  "",
+	}
+
+	for id, want := range cases {
+		if got := accessTierForBlob(tiers, id); got != want {
+			t.Errorf("invalid tier for %v: %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestValidateAccessTiers(t *testing.T) {
+	cases := []struct {
+		opt     Options
+		wantErr bool
+	}{
+		{Options{}, false},
+		{Options{AccessTiers: map[string]string{"p": AccessTierArchive}, RehydrateTier: AccessTierCool}, false},
+		{Options{AccessTiers: map[string]string{"p": "Frozen"}}, true},
+		{Options{RehydrateTier: AccessTierArchive}, true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		if err := validateAccessTiers(&tc.opt); (err != nil) != tc.wantErr {
+			t.Errorf("unexpected error for %+v: %v", tc.opt, err)
+		}
+	}
+}
+
+func TestAccessTierPolicy(t *testing.T) {
+	const blobURL = "https://account.blob.core.windows.net/container/p1234"
+
+	cases := []struct {
+		desc     string
+		tier     string
+		method   string
+		query    string
+		blobType string
+		want     string
+	}{
+		{"put blob", AccessTierArchive, http.MethodPut, "", "BlockBlob", AccessTierArchive},
+		{"put block list", AccessTierCool, http.MethodPut, "?comp=blocklist", "", AccessTierCool},
+		{"put block", AccessTierArchive, http.MethodPut, "?comp=block&blockid=x", "", ""},
+		{"set tier", AccessTierArchive, http.MethodPut, "?comp=tier", "", ""},
+		{"get blob", AccessTierArchive, http.MethodGet, "", "", ""},
+		{"no tier", "", http.MethodPut, "", "BlockBlob", ""},
+	}
+
+	for _, tc := range cases {
+		u, err := url.Parse(blobURL + tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := pipeline.NewRequest(tc.method, *u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tc.blobType != "" {
+			req.Header.Set("x-ms-blob-type", tc.blobType)
+		}
+
+		ctx := context.Background()
+		if tc.tier != "" {
+			ctx = withAccessTier(ctx, tc.tier)
+		}
+
+		var got string
+
+		policy := accessTierPolicy(pipeline.PolicyFunc(func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			got = request.Header.Get("x-ms-access-tier")
+			return nil, nil
+		}), nil)
+
+		if _, err := policy.Do(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tc.want {
+			t.Errorf("%v: unexpected access tier %q, want %q", tc.desc, got, tc.want)
+		}
+	}
+}
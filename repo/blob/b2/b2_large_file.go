@@ -0,0 +1,306 @@
+package b2
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/efarrer/iothrottler"
+	"github.com/pkg/errors"
+	backblaze "gopkg.in/kothar/go-backblaze.v0"
+
+	"github.com/kopia/kopia/internal/ctxutil"
+	"github.com/kopia/kopia/internal/retry"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+const (
+	defaultAuthorizeURL = "https://api.backblazeb2.com/b2api/v2/b2_authorize_account"
+
+	// DefaultPartSizeBytes is the default size of parts of blobs uploaded using multipart upload.
+	DefaultPartSizeBytes = 100 << 20
+
+	// MinPartSizeBytes is the minimum size of parts of blobs uploaded using multipart upload.
+	MinPartSizeBytes = 5e6
+)
+
+// largeFileClient uploads large blobs in parts using the B2 large file API, which is not supported by
+// the B2 client library. Each part is retried independently and failed uploads are canceled, so that
+// their parts are not left behind in the bucket.
+type largeFileClient struct {
+	authorizeURL string
+	keyID        string
+	key          string
+	bucketID     string
+	httpClient   *http.Client
+	throttler    *iothrottler.IOThrottlerPool
+
+	mu        sync.Mutex
+	apiURL    string
+	authToken string
+}
+
+type uploadPartURL struct {
+	UploadURL          string `json:"uploadUrl"`
+	AuthorizationToken string `json:"authorizationToken"`
+}
+
+type largeFilePart struct {
+	PartNumber  int    `json:"partNumber"`
+	ContentSha1 string `json:"contentSha1"`
+}
+
+type largeFileInfo struct {
+	FileID string `json:"fileId"`
+}
+
+func (c *largeFileClient) authorize(ctx context.Context) (apiURL, authToken string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.authToken != "" {
+		return c.apiURL, c.authToken, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.authorizeURL, nil)
+	if err != nil {
+		return "", "", errors.Wrap(err, "unable to create request")
+	}
+
+	req.SetBasicAuth(c.keyID, c.key)
+
+	var resp struct {
+		APIURL             string `json:"apiUrl"`
+		AuthorizationToken string `json:"authorizationToken"`
+	}
+
+	if err := c.do(req, &resp); err != nil {
+		return "", "", err
+	}
+
+	c.apiURL = resp.APIURL
+	c.authToken = resp.AuthorizationToken
+
+	return c.apiURL, c.authToken, nil
+}
+
+// invalidateAuthorization causes the client to authorize again if the error indicates expired authorization.
+func (c *largeFileClient) invalidateAuthorization(err error) {
+	var b2err *backblaze.B2Error
+
+	if errors.As(err, &b2err) && b2err.Status == http.StatusUnauthorized {
+		c.mu.Lock()
+		c.authToken = ""
+		c.mu.Unlock()
+	}
+}
+
+// call invokes the provided B2 API method and parses its response.
+func (c *largeFileClient) call(ctx context.Context, method string, request, response interface{}) error {
+	apiURL, authToken, err := c.authorize(ctx)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(request)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+"/b2api/v2/"+method, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "unable to create request")
+	}
+
+	req.Header.Set("Authorization", authToken)
+
+	err = c.do(req, response)
+	c.invalidateAuthorization(err)
+
+	return err
+}
+
+// do executes the request, returning *backblaze.B2Error for errors reported by B2.
+func (c *largeFileClient) do(req *http.Request, response interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "request failed")
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "unable to read response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		b2err := &backblaze.B2Error{}
+		if json.Unmarshal(body, b2err) != nil || b2err.Status == 0 {
+			b2err.Status = resp.StatusCode
+			b2err.Message = string(body)
+		}
+
+		return b2err
+	}
+
+	return errors.Wrap(json.Unmarshal(body, response), "unable to parse response")
+}
+
+func (c *largeFileClient) uploadPart(ctx context.Context, u *uploadPartURL, partNumber int, data []byte, sha1Hash string) error {
+	throttled, err := c.throttler.AddReader(ioutil.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		return errors.Wrap(err, "unable to throttle upload")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.UploadURL, throttled)
+	if err != nil {
+		return errors.Wrap(err, "unable to create request")
+	}
+
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Authorization", u.AuthorizationToken)
+	req.Header.Set("X-Bz-Part-Number", strconv.Itoa(partNumber))
+	req.Header.Set("X-Bz-Content-Sha1", sha1Hash)
+
+	var resp largeFilePart
+
+	if err := c.do(req, &resp); err != nil {
+		return err
+	}
+
+	if resp.ContentSha1 != sha1Hash {
+		return errors.Errorf("SHA1 of uploaded part %v does not match local hash", partNumber)
+	}
+
+	return nil
+}
+
+// upload uploads the provided data in parts of the provided size as a large file with the provided name.
+func (c *largeFileClient) upload(ctx context.Context, fileName string, data blob.Bytes, partSize int) error {
+	var resp largeFileInfo
+
+	if err := c.call(ctx, "b2_start_large_file", map[string]interface{}{
+		"bucketId":    c.bucketID,
+		"fileName":    fileName,
+		"contentType": "b2/x-auto",
+	}, &resp); err != nil {
+		return errors.Wrap(err, "unable to start upload")
+	}
+
+	if err := c.uploadParts(ctx, resp.FileID, fileName, data, partSize); err != nil {
+		// cancel the upload even if the context was canceled, otherwise uploaded parts are kept and billed.
+		var cancelResp largeFileInfo
+
+		if cerr := c.call(ctxutil.Detach(ctx), "b2_cancel_large_file", map[string]string{"fileId": resp.FileID}, &cancelResp); cerr != nil {
+			return errors.Wrapf(err, "unable to cancel upload (%v)", cerr)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// uploadParts uploads parts of the started large file and finishes it.
+func (c *largeFileClient) uploadParts(ctx context.Context, fileID, fileName string, data blob.Bytes, partSize int) error {
+	var (
+		partHashes []string
+		uploadURL  *uploadPartURL
+	)
+
+	progressCallback := blob.ProgressCallback(ctx)
+	total := int64(data.Length())
+	r := data.Reader()
+
+	for offset, partNumber := 0, 1; offset < data.Length(); partNumber++ {
+		n := partSize
+		if remaining := data.Length() - offset; remaining < n {
+			n = remaining
+		}
+
+		part := make([]byte, n)
+		if _, err := io.ReadFull(r, part); err != nil {
+			return errors.Wrap(err, "unable to read data")
+		}
+
+		h := sha1.Sum(part) //nolint:gosec
+		sha1Hash := hex.EncodeToString(h[:])
+
+		// each part is retried independently and failures invalidate the upload URL.
+		if _, err := retry.WithExponentialBackoff(ctx, fmt.Sprintf("UploadPart(%q,%v)", fileName, partNumber), func() (interface{}, error) {
+			if uploadURL == nil {
+				u := &uploadPartURL{}
+				if err := c.call(ctx, "b2_get_upload_part_url", map[string]string{"fileId": fileID}, u); err != nil {
+					return nil, err
+				}
+
+				uploadURL = u
+			}
+
+			err := c.uploadPart(ctx, uploadURL, partNumber, part, sha1Hash)
+			if err != nil {
+				uploadURL = nil
+			}
+
+			return nil, err
+		}, isRetriablePartError); err != nil {
+			return errors.Wrapf(err, "unable to upload part %v", partNumber)
+		}
+
+		partHashes = append(partHashes, sha1Hash)
+		offset += n
+
+		if progressCallback != nil {
+			progressCallback(fileName, int64(offset), total)
+		}
+	}
+
+	var resp largeFileInfo
+
+	if _, err := exponentialBackoff(ctx, fmt.Sprintf("FinishLargeFile(%q)", fileName), func() (interface{}, error) {
+		return nil, c.call(ctx, "b2_finish_large_file", map[string]interface{}{
+			"fileId":        fileID,
+			"partSha1Array": partHashes,
+		}, &resp)
+	}); err != nil {
+		return errors.Wrap(err, "unable to finish upload")
+	}
+
+	return nil
+}
+
+// isRetriablePartError determines whether part upload should be retried, which includes network errors
+// in addition to errors retried by all other B2 operations.
+func isRetriablePartError(err error) bool {
+	var b2err *backblaze.B2Error
+
+	if !errors.As(err, &b2err) {
+		// network error, the part can be uploaded again.
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	if b2err.Status == http.StatusUnauthorized && b2err.Code == "expired_auth_token" {
+		return true
+	}
+
+	return isRetriableError(b2err)
+}
+
+func newLargeFileClient(opt *Options, bucketID string, throttler *iothrottler.IOThrottlerPool) *largeFileClient {
+	return &largeFileClient{
+		authorizeURL: defaultAuthorizeURL,
+		keyID:        opt.KeyID,
+		key:          opt.Key,
+		bucketID:     bucketID,
+		httpClient:   &http.Client{},
+		throttler:    throttler,
+	}
+}
@@ -0,0 +1,201 @@
+package b2
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/efarrer/iothrottler"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+// fakeLargeFileServer implements the subset of B2 API used for large file uploads.
+type fakeLargeFileServer struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu            sync.Mutex
+	nextFileID    int
+	unfinished    map[string]string         // file ID -> file name
+	parts         map[string]map[int][]byte // file ID -> part number -> data
+	finished      map[string][]byte         // file name -> data
+	uploadedParts int
+	failParts     map[int]int // part number -> number of remaining failures
+	rejectParts   map[int]bool
+	canceled      []string
+}
+
+func newFakeLargeFileServer(t *testing.T) *fakeLargeFileServer {
+	s := &fakeLargeFileServer{
+		t:           t,
+		unfinished:  map[string]string{},
+		parts:       map[string]map[int][]byte{},
+		finished:    map[string][]byte{},
+		failParts:   map[int]int{},
+		rejectParts: map[int]bool{},
+	}
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.srv.Close)
+
+	return s
+}
+
+func (s *fakeLargeFileServer) client() *largeFileClient {
+	return &largeFileClient{
+		authorizeURL: s.srv.URL + "/authorize",
+		keyID:        "key-id",
+		key:          "key",
+		bucketID:     "bucket-id",
+		httpClient:   http.DefaultClient,
+		throttler:    iothrottler.NewIOThrottlerPool(iothrottler.Unlimited),
+	}
+}
+
+func sha1Hex(b []byte) string {
+	h := sha1.Sum(b) //nolint:gosec
+	return hex.EncodeToString(h[:])
+}
+
+func (s *fakeLargeFileServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(s.t, err)
+
+	var req map[string]interface{}
+
+	if strings.HasPrefix(r.URL.Path, "/b2api/") {
+		require.Equal(s.t, "token", r.Header.Get("Authorization"))
+		require.NoError(s.t, json.Unmarshal(body, &req))
+	}
+
+	var resp interface{}
+
+	switch {
+	case r.URL.Path == "/authorize":
+		resp = map[string]string{"apiUrl": s.srv.URL, "authorizationToken": "token"}
+
+	case r.URL.Path == "/b2api/v2/b2_start_large_file":
+		resp = largeFileInfo{FileID: s.startLocked(req["fileName"].(string))}
+
+	case r.URL.Path == "/b2api/v2/b2_cancel_large_file":
+		fileID := req["fileId"].(string)
+
+		s.canceled = append(s.canceled, fileID)
+		delete(s.unfinished, fileID)
+		delete(s.parts, fileID)
+		resp = largeFileInfo{FileID: fileID}
+
+	case r.URL.Path == "/b2api/v2/b2_get_upload_part_url":
+		resp = uploadPartURL{UploadURL: s.srv.URL + "/upload/" + req["fileId"].(string), AuthorizationToken: "upload-token"}
+
+	case strings.HasPrefix(r.URL.Path, "/upload/"):
+		partNumber, err := strconv.Atoi(r.Header.Get("X-Bz-Part-Number"))
+		require.NoError(s.t, err)
+		require.Equal(s.t, sha1Hex(body), r.Header.Get("X-Bz-Content-Sha1"))
+
+		if s.failParts[partNumber] > 0 {
+			s.failParts[partNumber]--
+
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, `{"status":503,"code":"service_unavailable","message":"try again"}`)
+
+			return
+		}
+
+		if s.rejectParts[partNumber] {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"status":400,"code":"bad_request","message":"rejected"}`)
+
+			return
+		}
+
+		s.parts[strings.TrimPrefix(r.URL.Path, "/upload/")][partNumber] = body
+		s.uploadedParts++
+		resp = largeFilePart{PartNumber: partNumber, ContentSha1: sha1Hex(body)}
+
+	case r.URL.Path == "/b2api/v2/b2_finish_large_file":
+		fileID := req["fileId"].(string)
+
+		var data []byte
+
+		for i, h := range req["partSha1Array"].([]interface{}) {
+			p := s.parts[fileID][i+1]
+			require.Equal(s.t, sha1Hex(p), h)
+
+			data = append(data, p...)
+		}
+
+		s.finished[s.unfinished[fileID]] = data
+		delete(s.unfinished, fileID)
+		resp = largeFileInfo{FileID: fileID}
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	require.NoError(s.t, json.NewEncoder(w).Encode(resp))
+}
+
+func (s *fakeLargeFileServer) startLocked(fileName string) string {
+	s.nextFileID++
+
+	id := fmt.Sprintf("file-%v", s.nextFileID)
+	s.unfinished[id] = fileName
+	s.parts[id] = map[int][]byte{}
+
+	return id
+}
+
+func TestLargeFileUpload(t *testing.T) {
+	ctx := testlogging.Context(t)
+	s := newFakeLargeFileServer(t)
+	c := s.client()
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 10)
+
+	// part 2 fails once and is retried alone.
+	s.failParts[2] = 1
+
+	require.NoError(t, c.upload(ctx, "f1", gather.FromSlice(data), 30))
+	require.Equal(t, data, s.finished["f1"])
+	require.Equal(t, 6, s.uploadedParts)
+	require.Empty(t, s.unfinished)
+}
+
+func TestLargeFileUploadCanceledOnFailure(t *testing.T) {
+	ctx := testlogging.Context(t)
+	s := newFakeLargeFileServer(t)
+	c := s.client()
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 10)
+
+	s.rejectParts[3] = true
+
+	require.Error(t, c.upload(ctx, "f2", gather.FromSlice(data), 30))
+	require.Equal(t, []string{"file-1"}, s.canceled)
+	require.Empty(t, s.unfinished)
+	require.Empty(t, s.finished)
+
+	// the next upload starts from scratch.
+	s.rejectParts[3] = false
+
+	require.NoError(t, c.upload(ctx, "f2", gather.FromSlice(data), 30))
+	require.Equal(t, data, s.finished["f2"])
+	require.Equal(t, 2+6, s.uploadedParts)
+}
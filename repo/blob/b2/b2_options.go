@@ -13,4 +13,7 @@ type Options struct {
 
 	MaxUploadSpeedBytesPerSecond   int `json:"maxUploadSpeedBytesPerSecond,omitempty"`
 	MaxDownloadSpeedBytesPerSecond int `json:"maxDownloadSpeedBytesPerSecond,omitempty"`
+
+	// PartSizeBytes is the size of parts in which blobs larger than that are uploaded, DefaultPartSizeBytes if not set.
+	PartSizeBytes int `json:"partSize,omitempty"`
 }
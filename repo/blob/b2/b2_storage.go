@@ -30,6 +30,8 @@ type b2Storage struct {
 
 	downloadThrottler *iothrottler.IOThrottlerPool
 	uploadThrottler   *iothrottler.IOThrottlerPool
+
+	largeFiles *largeFileClient
 }
 
 func (s *b2Storage) GetBlob(ctx context.Context, id blob.ID, offset, length int64) ([]byte, error) {
@@ -164,13 +166,21 @@ func isRetriableError(err error) bool {
 	return false
 }
 
-func (s *b2Storage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes) error {
+func (s *b2Storage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	if opts.HasRetentionOptions() {
+		return blob.ErrUnsupportedPutBlobOption
+	}
+
 	progressCallback := blob.ProgressCallback(ctx)
 	if progressCallback != nil {
 		progressCallback(string(id), 0, int64(data.Length()))
 		defer progressCallback(string(id), int64(data.Length()), int64(data.Length()))
 	}
 
+	if partSize := s.partSize(); data.Length() > partSize {
+		return translateError(s.largeFiles.upload(ctx, s.getObjectNameString(id), data, partSize))
+	}
+
 	attempt := func() (interface{}, error) {
 		throttled, err := s.uploadThrottler.AddReader(ioutil.NopCloser(data.Reader()))
 		if err != nil {
@@ -190,6 +200,14 @@ func (s *b2Storage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes) er
 	return nil
 }
 
+func (s *b2Storage) partSize() int {
+	if s.PartSizeBytes > 0 {
+		return s.PartSizeBytes
+	}
+
+	return DefaultPartSizeBytes
+}
+
 func (s *b2Storage) SetTime(ctx context.Context, b blob.ID, t time.Time) error {
 	return blob.ErrSetTimeUnsupported
 }
@@ -283,6 +301,10 @@ func New(ctx context.Context, opt *Options) (blob.Storage, error) {
 		return nil, errors.New("bucket name must be specified")
 	}
 
+	if opt.PartSizeBytes != 0 && opt.PartSizeBytes < MinPartSizeBytes {
+		return nil, errors.Errorf("part size must be at least %v bytes", MinPartSizeBytes)
+	}
+
 	cli, err := backblaze.NewB2(backblaze.Credentials{KeyID: opt.KeyID, ApplicationKey: opt.Key})
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to create client")
@@ -307,6 +329,7 @@ func New(ctx context.Context, opt *Options) (blob.Storage, error) {
 		bucket:            bucket,
 		downloadThrottler: downloadThrottler,
 		uploadThrottler:   uploadThrottler,
+		largeFiles:        newLargeFileClient(opt, bucket.ID, uploadThrottler),
 	}, nil
 }
 
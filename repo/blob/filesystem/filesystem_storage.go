@@ -122,10 +122,6 @@ func (fs *fsImpl) GetBlobFromPath(ctx context.Context, dirPath, path string, off
 
 func (fs *fsImpl) GetMetadataFromPath(ctx context.Context, dirPath, path string) (blob.Metadata, error) {
 	fi, err := os.Stat(path)
-	if err != nil {
-		return blob.Metadata{}, err
-	}
-
 	if err != nil {
 		if os.IsNotExist(err) {
 			return blob.Metadata{}, blob.ErrBlobNotFound
@@ -235,6 +231,50 @@ func (fs *fsImpl) SetTimeInPath(ctx context.Context, dirPath, filePath string, n
 	return os.Chtimes(filePath, n, n)
 }
 
+// PutBlobIfNotExists implements blob.ConditionalPutter by hard-linking the fully-written temporary file,
+// which unlike rename fails when the target exists.
+func (fs *fsStorage) PutBlobIfNotExists(ctx context.Context, blobID blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	if opts.HasRetentionOptions() {
+		return blob.ErrUnsupportedPutBlobOption
+	}
+
+	_, path := fs.Storage.GetShardedPathAndFilePath(blobID)
+	impl := fs.Impl.(*fsImpl)
+
+	randSuffix := make([]byte, 8)
+	if _, err := rand.Read(randSuffix); err != nil {
+		return errors.Wrap(err, "can't get random bytes")
+	}
+
+	tempFile := fmt.Sprintf("%s.tmp.%x", path, randSuffix)
+
+	f, err := impl.createTempFileAndDir(tempFile)
+	if err != nil {
+		return errors.Wrap(err, "cannot create temporary file")
+	}
+
+	defer os.Remove(tempFile) //nolint:errcheck
+
+	if _, err = data.WriteTo(f); err != nil {
+		f.Close() //nolint:errcheck,gosec
+		return errors.Wrap(err, "can't write temporary file")
+	}
+
+	if err = f.Close(); err != nil {
+		return errors.Wrap(err, "can't close temporary file")
+	}
+
+	if err := os.Link(tempFile, path); err != nil {
+		if os.IsExist(err) {
+			return blob.ErrBlobAlreadyExists
+		}
+
+		return errors.Wrap(err, "unable to link blob file")
+	}
+
+	return nil
+}
+
 // TouchBlob updates file modification time to current time if it's sufficiently old.
 func (fs *fsStorage) TouchBlob(ctx context.Context, blobID blob.ID, threshold time.Duration) error {
 	_, path := fs.Storage.GetShardedPathAndFilePath(blobID)
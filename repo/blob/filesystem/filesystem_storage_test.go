@@ -1,6 +1,7 @@
 package filesystem
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"reflect"
@@ -73,11 +74,11 @@ func TestFileStorageTouch(t *testing.T) {
 	}
 
 	fs := r.(*fsStorage)
-	assertNoError(t, fs.PutBlob(ctx, t1, gather.FromSlice([]byte{1})))
+	assertNoError(t, fs.PutBlob(ctx, t1, gather.FromSlice([]byte{1}), blob.PutOptions{}))
 	time.Sleep(2 * time.Second) // sleep a bit to accommodate Apple filesystems with low timestamp resolution
-	assertNoError(t, fs.PutBlob(ctx, t2, gather.FromSlice([]byte{1})))
+	assertNoError(t, fs.PutBlob(ctx, t2, gather.FromSlice([]byte{1}), blob.PutOptions{}))
 	time.Sleep(2 * time.Second)
-	assertNoError(t, fs.PutBlob(ctx, t3, gather.FromSlice([]byte{1})))
+	assertNoError(t, fs.PutBlob(ctx, t3, gather.FromSlice([]byte{1}), blob.PutOptions{}))
 	time.Sleep(2 * time.Second) // sleep a bit to accommodate Apple filesystems with low timestamp resolution
 
 	verifyBlobTimestampOrder(t, fs, t1, t2, t3)
@@ -151,3 +152,52 @@ func assertNoError(t *testing.T, err error) {
 		t.Errorf("err: %v", err)
 	}
 }
+
+func TestFileStoragePutBlobIfNotExists(t *testing.T) {
+	t.Parallel()
+
+	ctx := testlogging.Context(t)
+
+	r, err := New(ctx, &Options{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cp := r.(blob.ConditionalPutter)
+
+	assertNoError(t, cp.PutBlobIfNotExists(ctx, t1, gather.FromSlice([]byte{1}), blob.PutOptions{}))
+
+	if err := cp.PutBlobIfNotExists(ctx, t1, gather.FromSlice([]byte{2}), blob.PutOptions{}); !errors.Is(err, blob.ErrBlobAlreadyExists) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := r.GetBlob(ctx, t1, 0, -1)
+	assertNoError(t, err)
+
+	if !reflect.DeepEqual(got, []byte{1}) {
+		t.Fatalf("existing blob was replaced: %v", got)
+	}
+
+	blobtesting.AssertListResults(ctx, t, r, "", t1)
+}
+
+func TestFileStorageGetMetadataNotFound(t *testing.T) {
+	t.Parallel()
+
+	ctx := testlogging.Context(t)
+
+	r, err := New(ctx, &Options{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.GetMetadata(ctx, t1); !errors.Is(err, blob.ErrBlobNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertNoError(t, r.PutBlob(ctx, t1, gather.FromSlice([]byte{1}), blob.PutOptions{}))
+
+	if _, err := r.GetMetadata(ctx, t2); !errors.Is(err, blob.ErrBlobNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -22,4 +22,12 @@ type Options struct {
 	MaxUploadSpeedBytesPerSecond int `json:"maxUploadSpeedBytesPerSecond,omitempty"`
 
 	MaxDownloadSpeedBytesPerSecond int `json:"maxDownloadSpeedBytesPerSecond,omitempty"`
+
+	// MaxConcurrentRequests is the upper bound of concurrent requests, which is reduced automatically
+	// while the server is throttling requests.
+	MaxConcurrentRequests int `json:"maxConcurrentRequests,omitempty"`
+
+	// MaxRequestsPerSecond is the upper bound of the rate of requests, which is reduced automatically
+	// while the server is throttling requests. Zero means no limit.
+	MaxRequestsPerSecond int `json:"maxRequestsPerSecond,omitempty"`
 }
@@ -6,6 +6,7 @@
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"time"
 
 	gcsclient "cloud.google.com/go/storage"
@@ -96,7 +97,7 @@ func exponentialBackoff(ctx context.Context, desc string, att retry.AttemptFunc)
 
 func isRetriableError(err error) bool {
 	if apiError, ok := err.(*googleapi.Error); ok {
-		return apiError.Code >= 500
+		return apiError.Code >= 500 || apiError.Code == http.StatusTooManyRequests
 	}
 
 	switch err {
@@ -122,7 +123,11 @@ func translateError(err error) error {
 	}
 }
 
-func (gcs *gcsStorage) PutBlob(ctx context.Context, b blob.ID, data blob.Bytes) error {
+func (gcs *gcsStorage) PutBlob(ctx context.Context, b blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	if opts.HasRetentionOptions() {
+		return blob.ErrUnsupportedPutBlobOption
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 
 	obj := gcs.bucket.Object(gcs.getObjectNameString(b))
@@ -286,7 +291,9 @@ func New(ctx context.Context, opt *Options) (blob.Storage, error) {
 	uploadThrottler := iothrottler.NewIOThrottlerPool(toBandwidth(opt.MaxUploadSpeedBytesPerSecond))
 
 	hc := oauth2.NewClient(ctx, ts)
-	hc.Transport = throttle.NewRoundTripper(hc.Transport, downloadThrottler, uploadThrottler)
+	hc.Transport = throttle.NewAdaptiveRoundTripper(
+		throttle.NewRoundTripper(hc.Transport, downloadThrottler, uploadThrottler),
+		throttle.NewAdaptiveLimiter(opt.MaxConcurrentRequests, opt.MaxRequestsPerSecond))
 
 	cli, err := gcsclient.NewClient(ctx, option.WithHTTPClient(hc))
 	if err != nil {
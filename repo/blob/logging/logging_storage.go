@@ -41,11 +41,11 @@ func (s *loggingStorage) GetMetadata(ctx context.Context, id blob.ID) (blob.Meta
 	return result, err
 }
 
-func (s *loggingStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes) error {
+func (s *loggingStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
 	t0 := clock.Now()
-	err := s.base.PutBlob(ctx, id, data)
+	err := s.base.PutBlob(ctx, id, data, opts)
 	dt := clock.Since(t0)
-	s.printf(s.prefix+"PutBlob(%q,len=%v)=%#v took %v", id, data.Length(), err, dt)
+	s.printf(s.prefix+"PutBlob(%q,len=%v,opts=%+v)=%#v took %v", id, data.Length(), opts, err, dt)
 
 	return err
 }
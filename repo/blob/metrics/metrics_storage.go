@@ -0,0 +1,159 @@
+// Package metrics implements wrapper around Storage that records latency and throughput of all operations
+// as Prometheus metrics.
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// Names of storage methods used as values of the 'method' label.
+const (
+	MethodGetBlob     = "GetBlob"
+	MethodGetMetadata = "GetMetadata"
+	MethodPutBlob     = "PutBlob"
+	MethodSetTime     = "SetTime"
+	MethodDeleteBlob  = "DeleteBlob"
+	MethodListBlobs   = "ListBlobs"
+)
+
+var metricLabels = []string{"storage", "method"}
+
+var (
+	latencySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kopia_blob_storage_latency_seconds",
+		Help:    "Latency of blob storage operations.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 16), // nolint:gomnd
+	}, metricLabels)
+
+	transferredBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kopia_blob_storage_bytes",
+		Help:    "Number of bytes transferred by blob storage operations.",
+		Buckets: prometheus.ExponentialBuckets(256, 4, 12), // nolint:gomnd
+	}, metricLabels)
+
+	errorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kopia_blob_storage_errors_total",
+		Help: "Number of failed blob storage operations.",
+	}, metricLabels)
+
+	listedBlobs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kopia_blob_storage_listed_blobs_total",
+		Help: "Number of blobs returned by blob storage list operations.",
+	}, []string{"storage"})
+)
+
+type metricsStorage struct {
+	base        blob.Storage
+	storageType string
+}
+
+func (s *metricsStorage) record(method string, t0 time.Time, bytes int64, err error) {
+	latencySeconds.WithLabelValues(s.storageType, method).Observe(clock.Since(t0).Seconds())
+
+	if err != nil {
+		errorCount.WithLabelValues(s.storageType, method).Inc()
+		return
+	}
+
+	if bytes >= 0 {
+		transferredBytes.WithLabelValues(s.storageType, method).Observe(float64(bytes))
+	}
+}
+
+func (s *metricsStorage) GetBlob(ctx context.Context, id blob.ID, offset, length int64) ([]byte, error) {
+	t0 := clock.Now()
+	result, err := s.base.GetBlob(ctx, id, offset, length)
+	s.record(MethodGetBlob, t0, int64(len(result)), err)
+
+	return result, err
+}
+
+func (s *metricsStorage) GetMetadata(ctx context.Context, id blob.ID) (blob.Metadata, error) {
+	t0 := clock.Now()
+	result, err := s.base.GetMetadata(ctx, id)
+	s.record(MethodGetMetadata, t0, -1, err)
+
+	return result, err
+}
+
+func (s *metricsStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	t0 := clock.Now()
+	err := s.base.PutBlob(ctx, id, data, opts)
+	s.record(MethodPutBlob, t0, int64(data.Length()), err)
+
+	return err
+}
+
+func (s *metricsStorage) SetTime(ctx context.Context, id blob.ID, t time.Time) error {
+	t0 := clock.Now()
+	err := s.base.SetTime(ctx, id, t)
+	s.record(MethodSetTime, t0, -1, err)
+
+	return err
+}
+
+func (s *metricsStorage) DeleteBlob(ctx context.Context, id blob.ID) error {
+	t0 := clock.Now()
+	err := s.base.DeleteBlob(ctx, id)
+	s.record(MethodDeleteBlob, t0, -1, err)
+
+	return err
+}
+
+func (s *metricsStorage) ListBlobs(ctx context.Context, prefix blob.ID, callback func(blob.Metadata) error) error {
+	t0 := clock.Now()
+	cnt := 0
+	err := s.base.ListBlobs(ctx, prefix, func(bi blob.Metadata) error {
+		cnt++
+		return callback(bi)
+	})
+
+	s.record(MethodListBlobs, t0, -1, err)
+	listedBlobs.WithLabelValues(s.storageType).Add(float64(cnt))
+
+	return err
+}
+
+func (s *metricsStorage) Close(ctx context.Context) error {
+	return s.base.Close(ctx)
+}
+
+func (s *metricsStorage) ConnectionInfo() blob.ConnectionInfo {
+	return s.base.ConnectionInfo()
+}
+
+func (s *metricsStorage) DisplayName() string {
+	return s.base.DisplayName()
+}
+
+// Collector returns prometheus collector that exposes metrics of all wrapped storage instances.
+func Collector() prometheus.Collector {
+	return collector{}
+}
+
+type collector struct{}
+
+func (collector) Describe(ch chan<- *prometheus.Desc) {
+	latencySeconds.Describe(ch)
+	transferredBytes.Describe(ch)
+	errorCount.Describe(ch)
+	listedBlobs.Describe(ch)
+}
+
+func (collector) Collect(ch chan<- prometheus.Metric) {
+	latencySeconds.Collect(ch)
+	transferredBytes.Collect(ch)
+	errorCount.Collect(ch)
+	listedBlobs.Collect(ch)
+}
+
+// NewWrapper returns a Storage wrapper that records latency and throughput of all storage commands.
+func NewWrapper(wrapped blob.Storage) blob.Storage {
+	return &metricsStorage{base: wrapped, storageType: wrapped.ConnectionInfo().Type}
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func TestMetricsStorage(t *testing.T) {
+	data := blobtesting.DataMap{}
+	kt := map[blob.ID]time.Time{}
+	underlying := blobtesting.NewMapStorage(data, kt, nil)
+
+	st := NewWrapper(underlying)
+
+	ctx := testlogging.Context(t)
+	blobtesting.VerifyStorage(ctx, t, st)
+
+	if err := st.Close(ctx); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	found := map[string]MethodStats{}
+
+	for _, s := range Stats() {
+		if s.Storage == underlying.ConnectionInfo().Type {
+			found[s.Method] = s
+		}
+	}
+
+	for _, m := range []string{MethodGetBlob, MethodPutBlob, MethodDeleteBlob, MethodListBlobs} {
+		if found[m].Count == 0 {
+			t.Errorf("no operations recorded for %v: %+v", m, found)
+		}
+	}
+
+	if found[MethodPutBlob].TotalBytes == 0 {
+		t.Errorf("no bytes recorded for %v", MethodPutBlob)
+	}
+
+	// VerifyStorage reads non-existent blobs.
+	if found[MethodGetBlob].Errors == 0 {
+		t.Errorf("no errors recorded for %v", MethodGetBlob)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	bucket := func(upperBound float64, count uint64) *dto.Bucket {
+		return &dto.Bucket{UpperBound: &upperBound, CumulativeCount: &count}
+	}
+
+	total := uint64(100)
+	h := &dto.Histogram{
+		SampleCount: &total,
+		Bucket: []*dto.Bucket{
+			bucket(1, 50),
+			bucket(2, 90),
+			bucket(4, 99),
+		},
+	}
+
+	cases := map[float64]float64{
+		0.25: 0.5,
+		0.5:  1,
+		0.7:  1.5,
+		0.99: 4,
+		1:    4,
+	}
+
+	for q, want := range cases {
+		if got := histogramQuantile(q, h); got != want {
+			t.Errorf("invalid quantile %v: %v, want %v", q, got, want)
+		}
+	}
+
+	if got := histogramQuantile(0.5, &dto.Histogram{}); got != 0 {
+		t.Errorf("invalid quantile of empty histogram: %v", got)
+	}
+}
@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// MethodStats summarizes operations of a single storage method recorded since the process started.
+type MethodStats struct {
+	Storage string
+	Method  string
+	Count   uint64
+	Errors  uint64
+
+	TotalDuration time.Duration
+	TotalBytes    int64
+
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+}
+
+// BytesPerSecond returns the average throughput of the method.
+func (s MethodStats) BytesPerSecond() float64 {
+	if s.TotalDuration <= 0 {
+		return 0
+	}
+
+	return float64(s.TotalBytes) / s.TotalDuration.Seconds()
+}
+
+type statsKey struct {
+	storage string
+	method  string
+}
+
+// Stats returns statistics of all storage methods invoked since the process started, sorted by storage and method.
+func Stats() []MethodStats {
+	result := map[statsKey]*MethodStats{}
+
+	get := func(m *dto.Metric) *MethodStats {
+		k := statsKey{}
+
+		for _, l := range m.GetLabel() {
+			switch l.GetName() {
+			case "storage":
+				k.storage = l.GetValue()
+			case "method":
+				k.method = l.GetValue()
+			}
+		}
+
+		if result[k] == nil {
+			result[k] = &MethodStats{Storage: k.storage, Method: k.method}
+		}
+
+		return result[k]
+	}
+
+	for _, m := range collect(latencySeconds) {
+		h := m.GetHistogram()
+		s := get(m)
+		s.Count = h.GetSampleCount()
+		s.TotalDuration = secondsToDuration(h.GetSampleSum())
+		s.P50 = secondsToDuration(histogramQuantile(0.5, h))  // nolint:gomnd
+		s.P90 = secondsToDuration(histogramQuantile(0.9, h))  // nolint:gomnd
+		s.P99 = secondsToDuration(histogramQuantile(0.99, h)) // nolint:gomnd
+	}
+
+	for _, m := range collect(transferredBytes) {
+		get(m).TotalBytes = int64(m.GetHistogram().GetSampleSum())
+	}
+
+	for _, m := range collect(errorCount) {
+		get(m).Errors = uint64(m.GetCounter().GetValue())
+	}
+
+	var list []MethodStats
+
+	for _, s := range result {
+		list = append(list, *s)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if l, r := list[i].Storage, list[j].Storage; l != r {
+			return l < r
+		}
+
+		return list[i].Method < list[j].Method
+	})
+
+	return list
+}
+
+func collect(c prometheus.Collector) []*dto.Metric {
+	ch := make(chan prometheus.Metric)
+
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	var result []*dto.Metric
+
+	for m := range ch {
+		var d dto.Metric
+		if err := m.Write(&d); err == nil {
+			result = append(result, &d)
+		}
+	}
+
+	return result
+}
+
+// histogramQuantile estimates the quantile of the histogram by linear interpolation within the bucket
+// containing it, the same way as Prometheus histogram_quantile() function.
+func histogramQuantile(q float64, h *dto.Histogram) float64 {
+	total := float64(h.GetSampleCount())
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * total
+	lowerBound, lowerCount := 0.0, 0.0
+
+	for _, b := range h.GetBucket() {
+		upperBound, upperCount := b.GetUpperBound(), float64(b.GetCumulativeCount())
+
+		if upperCount >= rank {
+			if upperCount == lowerCount {
+				return upperBound
+			}
+
+			return lowerBound + (upperBound-lowerBound)*(rank-lowerCount)/(upperCount-lowerCount)
+		}
+
+		lowerBound, lowerCount = upperBound, upperCount
+	}
+
+	// the quantile falls into the implicit +Inf bucket, return the highest finite bound.
+	return lowerBound
+}
+
+func secondsToDuration(s float64) time.Duration {
+	if math.IsNaN(s) {
+		return 0
+	}
+
+	return time.Duration(s * float64(time.Second))
+}
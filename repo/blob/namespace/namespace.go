@@ -0,0 +1,180 @@
+// Package namespace implements a wrapper that stores blobs of a repository under a namespace
+// prefix, so that multiple repositories can share a single storage location.
+package namespace
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// StorageType is the storage type of namespaced storage in blob.ConnectionInfo.
+const StorageType = "namespace"
+
+// maxNameLength is the maximum length of namespace name.
+const maxNameLength = 32
+
+// Blob IDs are stored as "_<namespace>_<blobID>", the leading underscore ensures that they never
+// match blob ID prefixes used by repositories that don't use namespaces, so they're never
+// considered for garbage collection by such repositories.
+const (
+	namePrefix = "_"
+	nameSuffix = "_"
+)
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Options defines options for namespaced storage.
+type Options struct {
+	Namespace string              `json:"namespace"`
+	Storage   blob.ConnectionInfo `json:"storage"`
+}
+
+// ValidateName checks whether the provided namespace name is valid.
+func ValidateName(name string) error {
+	if len(name) > maxNameLength || !validName.MatchString(name) {
+		return errors.Errorf("invalid namespace %q, must be up to %v lowercase letters, digits or dashes", name, maxNameLength)
+	}
+
+	return nil
+}
+
+type namespaceStorage struct {
+	base   blob.Storage
+	name   string
+	prefix blob.ID
+}
+
+func (s *namespaceStorage) physicalID(id blob.ID) blob.ID {
+	return s.prefix + id
+}
+
+func (s *namespaceStorage) GetBlob(ctx context.Context, id blob.ID, offset, length int64) ([]byte, error) {
+	return s.base.GetBlob(ctx, s.physicalID(id), offset, length)
+}
+
+func (s *namespaceStorage) GetMetadata(ctx context.Context, id blob.ID) (blob.Metadata, error) {
+	bm, err := s.base.GetMetadata(ctx, s.physicalID(id))
+	bm.BlobID = id
+
+	return bm, err
+}
+
+func (s *namespaceStorage) SetTime(ctx context.Context, id blob.ID, t time.Time) error {
+	return s.base.SetTime(ctx, s.physicalID(id), t)
+}
+
+func (s *namespaceStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	return s.base.PutBlob(ctx, s.physicalID(id), data, opts)
+}
+
+func (s *namespaceStorage) DeleteBlob(ctx context.Context, id blob.ID) error {
+	return s.base.DeleteBlob(ctx, s.physicalID(id))
+}
+
+func (s *namespaceStorage) ListBlobs(ctx context.Context, prefix blob.ID, callback func(blob.Metadata) error) error {
+	return s.base.ListBlobs(ctx, s.physicalID(prefix), func(bm blob.Metadata) error {
+		bm.BlobID = blob.ID(strings.TrimPrefix(string(bm.BlobID), string(s.prefix)))
+		return callback(bm)
+	})
+}
+
+func (s *namespaceStorage) Close(ctx context.Context) error {
+	return s.base.Close(ctx)
+}
+
+func (s *namespaceStorage) ConnectionInfo() blob.ConnectionInfo {
+	return blob.ConnectionInfo{
+		Type: StorageType,
+		Config: &Options{
+			Namespace: s.name,
+			Storage:   s.base.ConnectionInfo(),
+		},
+	}
+}
+
+func (s *namespaceStorage) DisplayName() string {
+	return fmt.Sprintf("%v, namespace %v", s.base.DisplayName(), s.name)
+}
+
+// NewWrapper returns a Storage wrapper that stores all blobs in the provided namespace of the underlying storage.
+func NewWrapper(wrapped blob.Storage, name string) (blob.Storage, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+
+	return &namespaceStorage{
+		base:   wrapped,
+		name:   name,
+		prefix: blob.ID(namePrefix + name + nameSuffix),
+	}, nil
+}
+
+// Name returns the namespace of the provided storage, or an empty string if the storage does not use a namespace.
+func Name(st blob.Storage) string {
+	ci := st.ConnectionInfo()
+	if ci.Type != StorageType {
+		return ""
+	}
+
+	if opt, ok := ci.Config.(*Options); ok {
+		return opt.Namespace
+	}
+
+	return ""
+}
+
+// Available returns sorted names of namespaces containing blobs in the storage underlying the provided one.
+// It lists all blobs stored in namespaces, so it should only be used to diagnose connection problems.
+func Available(ctx context.Context, st blob.Storage) ([]string, error) {
+	if ns, ok := st.(*namespaceStorage); ok {
+		st = ns.base
+	}
+
+	found := map[string]bool{}
+
+	if err := st.ListBlobs(ctx, namePrefix, func(bm blob.Metadata) error {
+		rest := strings.TrimPrefix(string(bm.BlobID), namePrefix)
+
+		if p := strings.Index(rest, nameSuffix); p > 0 && ValidateName(rest[0:p]) == nil {
+			found[rest[0:p]] = true
+		}
+
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "unable to list namespaced blobs")
+	}
+
+	var result []string
+
+	for n := range found {
+		result = append(result, n)
+	}
+
+	sort.Strings(result)
+
+	return result, nil
+}
+
+func init() {
+	blob.AddSupportedStorage(
+		StorageType,
+		func() interface{} { return &Options{} },
+		func(ctx context.Context, o interface{}) (blob.Storage, error) {
+			opt := o.(*Options)
+
+			base, err := blob.NewStorage(ctx, opt.Storage)
+			if err != nil {
+				return nil, errors.Wrap(err, "unable to open namespaced storage")
+			}
+
+			return NewWrapper(base, opt.Namespace)
+		})
+}
@@ -0,0 +1,114 @@
+package namespace
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func TestNamespaceStorage(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	underlying := blobtesting.NewMapStorage(data, map[blob.ID]time.Time{}, nil)
+
+	st, err := NewWrapper(underlying, "ns1")
+	if err != nil {
+		t.Fatalf("unable to create wrapper: %v", err)
+	}
+
+	blobtesting.VerifyStorage(ctx, t, st)
+
+	other, err := NewWrapper(underlying, "ns2")
+	if err != nil {
+		t.Fatalf("unable to create wrapper: %v", err)
+	}
+
+	if err := st.PutBlob(ctx, "p1234", gather.FromSlice([]byte{1, 2, 3, 4}), blob.PutOptions{}); err != nil {
+		t.Fatalf("unable to write blob: %v", err)
+	}
+
+	// blobs are not visible in other namespaces or at the root.
+	blobtesting.AssertGetBlobNotFound(ctx, t, other, "p1234")
+	blobtesting.AssertListResults(ctx, t, other, "")
+	blobtesting.AssertListResults(ctx, t, underlying, "p")
+	blobtesting.AssertGetBlob(ctx, t, st, "p1234", []byte{1, 2, 3, 4})
+	blobtesting.AssertListResults(ctx, t, st, "p", "p1234")
+
+	for id := range data {
+		if !strings.HasPrefix(string(id), "_ns1_") {
+			t.Errorf("unexpected underlying blob: %v", id)
+		}
+	}
+
+	if got, want := Name(st), "ns1"; got != want {
+		t.Errorf("unexpected namespace: %v, want %v", got, want)
+	}
+
+	if got := Name(underlying); got != "" {
+		t.Errorf("unexpected namespace of underlying storage: %v", got)
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	underlying := blobtesting.NewMapStorage(data, map[blob.ID]time.Time{}, nil)
+
+	for _, it := range []struct {
+		name string
+		id   blob.ID
+	}{
+		{"ns2", "p1234"},
+		{"ns1", "q5678"},
+		{"ns2", "kopia.repository"},
+	} {
+		st, err := NewWrapper(underlying, it.name)
+		if err != nil {
+			t.Fatalf("unable to create wrapper: %v", err)
+		}
+
+		if err := st.PutBlob(ctx, it.id, gather.FromSlice([]byte{1}), blob.PutOptions{}); err != nil {
+			t.Fatalf("unable to write blob: %v", err)
+		}
+	}
+
+	// blobs outside of namespaces are ignored.
+	if err := underlying.PutBlob(ctx, "_no-namespace", gather.FromSlice([]byte{1}), blob.PutOptions{}); err != nil {
+		t.Fatalf("unable to write blob: %v", err)
+	}
+
+	st, err := NewWrapper(underlying, "ns3")
+	if err != nil {
+		t.Fatalf("unable to create wrapper: %v", err)
+	}
+
+	for _, s := range []blob.Storage{underlying, st} {
+		names, err := Available(ctx, s)
+		if err != nil {
+			t.Fatalf("unable to list namespaces: %v", err)
+		}
+
+		if got, want := strings.Join(names, ","), "ns1,ns2"; got != want {
+			t.Errorf("unexpected namespaces: %v, want %v", got, want)
+		}
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"a", "my-repo", "repo1", strings.Repeat("x", 32)} {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("unexpected error for %q: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"", "-a", "A", "a_b", "a/b", "a.b", strings.Repeat("x", 33)} {
+		if err := ValidateName(name); err == nil {
+			t.Errorf("unexpected success for %q", name)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package oci
+
+// Options defines options for Oracle Cloud Infrastructure Object Storage-backed storage.
+type Options struct {
+	// BucketName is the name of the bucket where data is stored.
+	BucketName string `json:"bucket"`
+
+	// Prefix specifies additional string to prepend to all objects.
+	Prefix string `json:"prefix,omitempty"`
+
+	// Namespace is the Object Storage namespace of the tenancy which owns the bucket.
+	Namespace string `json:"namespace"`
+
+	// Region is the identifier of the region where the bucket is located, such as 'us-ashburn-1'.
+	Region string `json:"region,omitempty"`
+
+	// Endpoint overrides the Object Storage endpoint derived from the region.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// OCIDs of the tenancy and user and fingerprint of the API signing key of the user.
+	TenancyOCID string `json:"tenancy"`
+	UserOCID    string `json:"user"`
+	Fingerprint string `json:"fingerprint"`
+
+	// PrivateKeyFile specifies the name of the file with PEM-encoded API signing key.
+	PrivateKeyFile string `json:"privateKeyFile,omitempty"`
+
+	// PrivateKey specifies PEM-encoded API signing key.
+	PrivateKey string `json:"privateKey,omitempty" kopia:"sensitive"`
+
+	// PrivateKeyPassphrase specifies the passphrase of encrypted API signing key.
+	PrivateKeyPassphrase string `json:"privateKeyPassphrase,omitempty" kopia:"sensitive"`
+
+	MaxUploadSpeedBytesPerSecond   int `json:"maxUploadSpeedBytesPerSecond,omitempty"`
+	MaxDownloadSpeedBytesPerSecond int `json:"maxDownloadSpeedBytesPerSecond,omitempty"`
+}
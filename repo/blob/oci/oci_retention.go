@@ -0,0 +1,126 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+const hoursPerDay = 24
+
+// retentionRule is a retention rule of the bucket. Object Storage does not support retention of individual
+// objects, instead retention rules protect all objects in the bucket for the duration of the rule,
+// or indefinitely if the rule has no duration.
+type retentionRule struct {
+	DisplayName    string             `json:"displayName"`
+	Duration       *retentionDuration `json:"duration"`
+	TimeRuleLocked *time.Time         `json:"timeRuleLocked"`
+}
+
+type retentionDuration struct {
+	TimeAmount int64  `json:"timeAmount"`
+	TimeUnit   string `json:"timeUnit"`
+}
+
+type retentionRuleCollection struct {
+	Items []retentionRule `json:"items"`
+}
+
+// period returns the retention period of the rule, false if the rule retains objects indefinitely.
+func (r retentionRule) period() (time.Duration, bool) {
+	if r.Duration == nil {
+		return 0, false
+	}
+
+	days := r.Duration.TimeAmount
+	if r.Duration.TimeUnit == "YEARS" {
+		// shortest possible year, so that the period is never overestimated.
+		days *= 365
+	}
+
+	return time.Duration(days) * hoursPerDay * time.Hour, true
+}
+
+// locked returns true if the rule is locked, after which it can no longer be removed or shortened.
+func (r retentionRule) locked(now time.Time) bool {
+	return r.TimeRuleLocked != nil && !r.TimeRuleLocked.After(now)
+}
+
+// satisfies returns true if the rule protects objects as requested by the options, compliance mode
+// requires the rule to be locked and legal hold requires indefinite retention.
+func (r retentionRule) satisfies(opts blob.PutOptions, now time.Time) bool {
+	if opts.RetentionMode == blob.RetentionModeCompliance && !r.locked(now) {
+		return false
+	}
+
+	period, limited := r.period()
+	if !limited {
+		return true
+	}
+
+	return !opts.LegalHold && period >= opts.RetentionPeriod
+}
+
+// verifyRetention returns ErrUnsupportedPutBlobOption unless one of the retention rules of the bucket
+// protects objects as requested by the options. Since rules apply to the entire bucket, nothing needs to
+// be done for individual objects.
+func (s *ociStorage) verifyRetention(ctx context.Context, opts blob.PutOptions) error {
+	if err := blob.ValidateRetentionMode(opts.RetentionMode); err != nil {
+		return errors.Wrap(blob.ErrUnsupportedPutBlobOption, err.Error())
+	}
+
+	rules, err := s.retentionRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := clock.Now()
+
+	for _, r := range rules {
+		if r.satisfies(opts, now) {
+			return nil
+		}
+	}
+
+	return errors.Wrap(blob.ErrUnsupportedPutBlobOption, "bucket has no retention rule satisfying requested retention")
+}
+
+// retentionRules returns retention rules of the bucket, which are loaded once.
+func (s *ociStorage) retentionRules(ctx context.Context) ([]retentionRule, error) {
+	s.retentionMutex.Lock()
+	defer s.retentionMutex.Unlock()
+
+	if s.retentionLoaded {
+		return s.retentionRuleList, nil
+	}
+
+	v, err := exponentialBackoff(ctx, "ListRetentionRules", func() (interface{}, error) {
+		resp, err := s.do(ctx, http.MethodGet, s.bucketURL+"/retentionRules", nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		defer resp.Body.Close() //nolint:errcheck
+
+		var rc retentionRuleCollection
+		if err := json.NewDecoder(resp.Body).Decode(&rc); err != nil {
+			return nil, errors.Wrap(err, "unable to parse retention rules")
+		}
+
+		return rc.Items, nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list retention rules")
+	}
+
+	s.retentionRuleList = v.([]retentionRule)
+	s.retentionLoaded = true
+
+	return s.retentionRuleList, nil
+}
@@ -0,0 +1,62 @@
+package oci
+
+import (
+	"crypto/rsa"
+	"net/http"
+
+	"github.com/oracle/oci-go-sdk/common"
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+)
+
+// signedHeaders is the list of headers included in request signatures. Object Storage does not
+// require request bodies to be signed, which allows objects to be streamed during upload.
+const signedHeaders = "date (request-target) host"
+
+// keyProvider provides the API signing key to the SDK request signer. The key is parsed once,
+// so that encrypted keys are not decrypted for each request.
+type keyProvider struct {
+	keyID string
+	key   *rsa.PrivateKey
+}
+
+func (p *keyProvider) PrivateRSAKey() (*rsa.PrivateKey, error) {
+	return p.key, nil
+}
+
+func (p *keyProvider) KeyID() (string, error) {
+	return p.keyID, nil
+}
+
+// requestSigner signs requests using OCI API signing key as described in
+// https://docs.oracle.com/en-us/iaas/Content/API/Concepts/signingrequests.htm
+type requestSigner struct {
+	signer common.HTTPRequestSigner
+}
+
+func (s *requestSigner) sign(req *http.Request) error {
+	req.Header.Set("Date", clock.Now().UTC().Format(http.TimeFormat))
+
+	return errors.Wrap(s.signer.Sign(req), "unable to sign request")
+}
+
+func newRequestSigner(opt *Options, keyData []byte) (*requestSigner, error) {
+	var passphrase *string
+
+	if opt.PrivateKeyPassphrase != "" {
+		passphrase = &opt.PrivateKeyPassphrase
+	}
+
+	key, err := common.PrivateKeyFromBytes(keyData, passphrase)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse private key")
+	}
+
+	return &requestSigner{
+		signer: common.RequestSignerExcludeBody(&keyProvider{
+			keyID: opt.TenancyOCID + "/" + opt.UserOCID + "/" + opt.Fingerprint,
+			key:   key,
+		}),
+	}, nil
+}
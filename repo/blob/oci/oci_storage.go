@@ -0,0 +1,457 @@
+// Package oci implements Storage based on Oracle Cloud Infrastructure Object Storage.
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/efarrer/iothrottler"
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/retry"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+const (
+	ociStorageType = "oci"
+
+	defaultEndpointFormat = "https://objectstorage.%v.oraclecloud.com"
+
+	maxListLimit = 1000
+)
+
+type ociStorage struct {
+	Options
+
+	bucketURL  string
+	signer     *requestSigner
+	httpClient *http.Client
+
+	downloadThrottler *iothrottler.IOThrottlerPool
+	uploadThrottler   *iothrottler.IOThrottlerPool
+
+	retentionMutex    sync.Mutex
+	retentionLoaded   bool
+	retentionRuleList []retentionRule
+}
+
+// serviceError represents an error returned by Object Storage.
+type serviceError struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *serviceError) Error() string {
+	return fmt.Sprintf("OCI error %v (%v): %v", e.StatusCode, e.Code, e.Message)
+}
+
+type objectSummary struct {
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	TimeCreated  time.Time `json:"timeCreated"`
+	TimeModified time.Time `json:"timeModified"`
+}
+
+type listObjectsResponse struct {
+	Objects       []objectSummary `json:"objects"`
+	NextStartWith string          `json:"nextStartWith"`
+}
+
+func (s *ociStorage) GetBlob(ctx context.Context, b blob.ID, offset, length int64) ([]byte, error) {
+	if offset < 0 {
+		return nil, errors.Errorf("invalid offset")
+	}
+
+	if length == 0 {
+		// empty range can't be requested, just make sure the blob exists.
+		if _, err := s.GetMetadata(ctx, b); err != nil {
+			return nil, err
+		}
+
+		return []byte{}, nil
+	}
+
+	attempt := func() (interface{}, error) {
+		header := http.Header{}
+
+		switch {
+		case length > 0:
+			header.Set("Range", fmt.Sprintf("bytes=%v-%v", offset, offset+length-1))
+		case offset > 0:
+			header.Set("Range", fmt.Sprintf("bytes=%v-", offset))
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, s.objectURL(b), header, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		throttled, err := s.downloadThrottler.AddReader(resp.Body)
+		if err != nil {
+			resp.Body.Close() //nolint:errcheck
+			return nil, err
+		}
+
+		defer throttled.Close() //nolint:errcheck
+
+		return ioutil.ReadAll(throttled)
+	}
+
+	v, err := exponentialBackoff(ctx, fmt.Sprintf("GetBlob(%q,%v,%v)", b, offset, length), attempt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	fetched := v.([]byte)
+	if len(fetched) != int(length) && length >= 0 {
+		return nil, errors.Errorf("invalid offset/length")
+	}
+
+	return fetched, nil
+}
+
+func (s *ociStorage) GetMetadata(ctx context.Context, b blob.ID) (blob.Metadata, error) {
+	attempt := func() (interface{}, error) {
+		resp, err := s.do(ctx, http.MethodHead, s.objectURL(b), nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Body.Close() //nolint:errcheck
+
+		ts, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid Last-Modified header")
+		}
+
+		return blob.Metadata{
+			BlobID:    b,
+			Length:    resp.ContentLength,
+			Timestamp: ts,
+		}, nil
+	}
+
+	v, err := exponentialBackoff(ctx, fmt.Sprintf("GetMetadata(%q)", b), attempt)
+	if err != nil {
+		return blob.Metadata{}, translateError(err)
+	}
+
+	return v.(blob.Metadata), nil
+}
+
+func (s *ociStorage) PutBlob(ctx context.Context, b blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	if opts.HasRetentionOptions() {
+		if err := s.verifyRetention(ctx, opts); err != nil {
+			return err
+		}
+	}
+
+	attempt := func() (interface{}, error) {
+		var body io.Reader = http.NoBody
+
+		if data.Length() > 0 {
+			throttled, err := s.uploadThrottler.AddReader(ioutil.NopCloser(data.Reader()))
+			if err != nil {
+				return nil, err
+			}
+
+			body = throttled
+		}
+
+		header := http.Header{}
+		header.Set("Content-Type", "application/x-kopia")
+
+		resp, err := s.do(ctx, http.MethodPut, s.objectURL(b), header, body, int64(data.Length()))
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, resp.Body.Close()
+	}
+
+	_, err := exponentialBackoff(ctx, fmt.Sprintf("PutBlob(%q)", b), attempt)
+
+	return translateError(err)
+}
+
+func (s *ociStorage) SetTime(ctx context.Context, b blob.ID, t time.Time) error {
+	return blob.ErrSetTimeUnsupported
+}
+
+// DeleteBlob deletes the object with given ID.
+func (s *ociStorage) DeleteBlob(ctx context.Context, b blob.ID) error {
+	attempt := func() (interface{}, error) {
+		resp, err := s.do(ctx, http.MethodDelete, s.objectURL(b), nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, resp.Body.Close()
+	}
+
+	_, err := exponentialBackoff(ctx, fmt.Sprintf("DeleteBlob(%q)", b), attempt)
+	err = translateError(err)
+
+	// don't return error if blob is already deleted
+	if errors.Is(err, blob.ErrBlobNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// ListBlobs lists objects with given prefix.
+func (s *ociStorage) ListBlobs(ctx context.Context, prefix blob.ID, callback func(blob.Metadata) error) error {
+	start := ""
+
+	for {
+		q := url.Values{}
+		q.Set("prefix", s.getObjectNameString(prefix))
+		q.Set("fields", "name,size,timeCreated,timeModified")
+		q.Set("limit", strconv.Itoa(maxListLimit))
+
+		if start != "" {
+			q.Set("start", start)
+		}
+
+		v, err := exponentialBackoff(ctx, fmt.Sprintf("ListBlobs(%q)", prefix), func() (interface{}, error) {
+			resp, err := s.do(ctx, http.MethodGet, s.bucketURL+"/o?"+q.Encode(), nil, nil, 0)
+			if err != nil {
+				return nil, err
+			}
+
+			defer resp.Body.Close() //nolint:errcheck
+
+			var lr listObjectsResponse
+			if err := json.NewDecoder(resp.Body).Decode(&lr); err != nil {
+				return nil, errors.Wrap(err, "unable to parse list response")
+			}
+
+			return &lr, nil
+		})
+		if err != nil {
+			// not found means the bucket does not exist, which is not the same as missing blob.
+			return err
+		}
+
+		lr := v.(*listObjectsResponse)
+
+		for _, o := range lr.Objects {
+			bm := blob.Metadata{
+				BlobID:    blob.ID(o.Name[len(s.Prefix):]),
+				Length:    o.Size,
+				Timestamp: o.TimeModified,
+			}
+
+			if bm.Timestamp.IsZero() {
+				bm.Timestamp = o.TimeCreated
+			}
+
+			if err := callback(bm); err != nil {
+				return err
+			}
+		}
+
+		if lr.NextStartWith == "" {
+			return nil
+		}
+
+		start = lr.NextStartWith
+	}
+}
+
+// do executes signed request and returns the response, whose body must be closed by the caller,
+// or *serviceError if Object Storage returned an error.
+func (s *ociStorage) do(ctx context.Context, method, u string, header http.Header, body io.Reader, contentLength int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create request")
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	req.ContentLength = contentLength
+
+	if err := s.signer.sign(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "request failed")
+	}
+
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return resp, nil
+	}
+
+	defer resp.Body.Close() //nolint:errcheck
+
+	serr := &serviceError{}
+
+	if b, err := ioutil.ReadAll(resp.Body); err == nil && len(b) > 0 {
+		if json.Unmarshal(b, serr) != nil {
+			serr.Message = string(b)
+		}
+	}
+
+	serr.StatusCode = resp.StatusCode
+
+	return nil, serr
+}
+
+func exponentialBackoff(ctx context.Context, desc string, att retry.AttemptFunc) (interface{}, error) {
+	return retry.WithExponentialBackoff(ctx, desc, att, isRetriableError)
+}
+
+func isRetriableError(err error) bool {
+	var serr *serviceError
+
+	if !errors.As(err, &serr) {
+		// network error
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	// retry on server errors and throttling, not on client errors
+	return serr.StatusCode >= http.StatusInternalServerError || serr.StatusCode == http.StatusTooManyRequests
+}
+
+func translateError(err error) error {
+	var serr *serviceError
+
+	if errors.As(err, &serr) && serr.StatusCode == http.StatusNotFound {
+		return blob.ErrBlobNotFound
+	}
+
+	return err
+}
+
+func (s *ociStorage) getObjectNameString(b blob.ID) string {
+	return s.Prefix + string(b)
+}
+
+func (s *ociStorage) objectURL(b blob.ID) string {
+	return s.bucketURL + "/o/" + url.PathEscape(s.getObjectNameString(b))
+}
+
+func (s *ociStorage) ConnectionInfo() blob.ConnectionInfo {
+	return blob.ConnectionInfo{
+		Type:   ociStorageType,
+		Config: &s.Options,
+	}
+}
+
+func (s *ociStorage) DisplayName() string {
+	return fmt.Sprintf("OCI: %v/%v", s.Namespace, s.BucketName)
+}
+
+func (s *ociStorage) Close(ctx context.Context) error {
+	return nil
+}
+
+func toBandwidth(bytesPerSecond int) iothrottler.Bandwidth {
+	if bytesPerSecond <= 0 {
+		return iothrottler.Unlimited
+	}
+
+	return iothrottler.Bandwidth(bytesPerSecond) * iothrottler.BytesPerSecond
+}
+
+func privateKeyData(opt *Options) ([]byte, error) {
+	if opt.PrivateKey != "" {
+		return []byte(opt.PrivateKey), nil
+	}
+
+	if opt.PrivateKeyFile == "" {
+		return nil, errors.New("private key or private key file must be specified")
+	}
+
+	data, err := ioutil.ReadFile(opt.PrivateKeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read private key file")
+	}
+
+	return data, nil
+}
+
+// New creates new Oracle Cloud Infrastructure Object Storage-backed storage with specified options:
+//
+// - the 'BucketName', 'Namespace', 'TenancyOCID', 'UserOCID' and 'Fingerprint' fields are required,
+// as well as either 'Region' or 'Endpoint' and either 'PrivateKey' or 'PrivateKeyFile'.
+func New(ctx context.Context, opt *Options) (blob.Storage, error) {
+	if opt.BucketName == "" {
+		return nil, errors.New("bucket name must be specified")
+	}
+
+	if opt.Namespace == "" {
+		return nil, errors.New("namespace must be specified")
+	}
+
+	if opt.TenancyOCID == "" || opt.UserOCID == "" || opt.Fingerprint == "" {
+		return nil, errors.New("tenancy, user and fingerprint must be specified")
+	}
+
+	endpoint := opt.Endpoint
+	if endpoint == "" {
+		if opt.Region == "" {
+			return nil, errors.New("region or endpoint must be specified")
+		}
+
+		endpoint = fmt.Sprintf(defaultEndpointFormat, opt.Region)
+	}
+
+	keyData, err := privateKeyData(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := newRequestSigner(opt, keyData)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ociStorage{
+		Options:           *opt,
+		bucketURL:         strings.TrimSuffix(endpoint, "/") + "/n/" + url.PathEscape(opt.Namespace) + "/b/" + url.PathEscape(opt.BucketName),
+		signer:            signer,
+		httpClient:        &http.Client{},
+		downloadThrottler: iothrottler.NewIOThrottlerPool(toBandwidth(opt.MaxDownloadSpeedBytesPerSecond)),
+		uploadThrottler:   iothrottler.NewIOThrottlerPool(toBandwidth(opt.MaxUploadSpeedBytesPerSecond)),
+	}
+
+	// verify connection is functional by listing objects in the bucket, which will fail if the bucket
+	// does not exist or credentials are invalid. We list with a prefix that will not exist, to avoid
+	// iterating through any objects.
+	nonExistentPrefix := fmt.Sprintf("kopia-oci-storage-initializing-%v", clock.Now().UnixNano())
+	if err := s.ListBlobs(ctx, blob.ID(nonExistentPrefix), func(md blob.Metadata) error {
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "unable to list from the bucket")
+	}
+
+	return s, nil
+}
+
+func init() {
+	blob.AddSupportedStorage(
+		ociStorageType,
+		func() interface{} {
+			return &Options{}
+		},
+		func(ctx context.Context, o interface{}) (blob.Storage, error) {
+			return New(ctx, o.(*Options))
+		})
+}
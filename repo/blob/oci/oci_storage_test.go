@@ -0,0 +1,329 @@
+package oci
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+const (
+	testNamespace = "testns"
+	testBucket    = "test-bucket"
+
+	// fake server returns at most this many objects per page to exercise pagination.
+	fakeListPageSize = 2
+)
+
+type fakeObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// fakeObjectStorage implements the subset of Object Storage API used by the storage and verifies
+// request signatures.
+type fakeObjectStorage struct {
+	t     *testing.T
+	keyID string
+	key   *rsa.PublicKey
+
+	mu             sync.Mutex
+	objects        map[string]fakeObject
+	retentionRules []retentionRule
+}
+
+var authorizationRegexp = regexp.MustCompile(`^Signature version="1",headers="([^"]*)",keyId="([^"]*)",algorithm="rsa-sha256",signature="([^"]*)"$`)
+
+func (s *fakeObjectStorage) verifySignature(r *http.Request) bool {
+	m := authorizationRegexp.FindStringSubmatch(r.Header.Get("Authorization"))
+	if m == nil || m[1] != signedHeaders || m[2] != s.keyID {
+		return false
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(m[3])
+	require.NoError(s.t, err)
+
+	signingString := "date: " + r.Header.Get("Date") + "\n" +
+		"(request-target): " + strings.ToLower(r.Method) + " " + r.RequestURI + "\n" +
+		"host: " + r.Host
+	h := sha256.Sum256([]byte(signingString))
+
+	return rsa.VerifyPKCS1v15(s.key, crypto.SHA256, h[:], sig) == nil
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"code": code, "message": code}) //nolint:errcheck
+}
+
+func (s *fakeObjectStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.verifySignature(r) {
+		writeError(w, http.StatusUnauthorized, "NotAuthenticated")
+		return
+	}
+
+	bucketPath := "/n/" + testNamespace + "/b/" + testBucket + "/o"
+
+	switch {
+	case r.URL.Path == bucketPath && r.Method == http.MethodGet:
+		s.list(w, r)
+
+	case r.URL.Path == "/n/"+testNamespace+"/b/"+testBucket+"/retentionRules" && r.Method == http.MethodGet:
+		require.NoError(s.t, json.NewEncoder(w).Encode(retentionRuleCollection{s.retentionRules}))
+
+	case strings.HasPrefix(r.URL.Path, bucketPath+"/"):
+		name := strings.TrimPrefix(r.URL.Path, bucketPath+"/")
+		o, ok := s.objects[name]
+
+		switch r.Method {
+		case http.MethodPut:
+			data, err := ioutil.ReadAll(r.Body)
+			require.NoError(s.t, err)
+			require.Equal(s.t, int64(len(data)), r.ContentLength)
+
+			s.objects[name] = fakeObject{data, clock.Now()}
+
+		case http.MethodGet, http.MethodHead:
+			if !ok {
+				writeError(w, http.StatusNotFound, "ObjectNotFound")
+				return
+			}
+
+			http.ServeContent(w, r, name, o.modTime, bytes.NewReader(o.data))
+
+		case http.MethodDelete:
+			if !ok {
+				writeError(w, http.StatusNotFound, "ObjectNotFound")
+				return
+			}
+
+			delete(s.objects, name)
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	default:
+		writeError(w, http.StatusNotFound, "BucketNotFound")
+	}
+}
+
+func (s *fakeObjectStorage) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	start := r.URL.Query().Get("start")
+
+	var names []string
+
+	for n := range s.objects {
+		if strings.HasPrefix(n, prefix) && n >= start {
+			names = append(names, n)
+		}
+	}
+
+	sort.Strings(names)
+
+	var resp listObjectsResponse
+
+	if len(names) > fakeListPageSize {
+		resp.NextStartWith = names[fakeListPageSize]
+		names = names[0:fakeListPageSize]
+	}
+
+	for _, n := range names {
+		o := s.objects[n]
+		resp.Objects = append(resp.Objects, objectSummary{n, int64(len(o.data)), o.modTime, o.modTime})
+	}
+
+	require.NoError(s.t, json.NewEncoder(w).Encode(resp))
+}
+
+func generateTestKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	return key, string(keyPEM)
+}
+
+func newFakeServer(t *testing.T, key *rsa.PrivateKey, rules ...retentionRule) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(&fakeObjectStorage{
+		t:              t,
+		keyID:          "tenancy/user/fingerprint",
+		key:            &key.PublicKey,
+		objects:        map[string]fakeObject{},
+		retentionRules: rules,
+	})
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestOCIStorage(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	key, keyPEM := generateTestKey(t)
+	srv := newFakeServer(t, key)
+
+	for _, prefix := range []string{"", "some/prefix-"} {
+		st, err := New(ctx, &Options{
+			BucketName:  testBucket,
+			Prefix:      prefix,
+			Namespace:   testNamespace,
+			Endpoint:    srv.URL,
+			TenancyOCID: "tenancy",
+			UserOCID:    "user",
+			Fingerprint: "fingerprint",
+			PrivateKey:  keyPEM,
+		})
+		require.NoError(t, err)
+
+		blobtesting.VerifyStorage(ctx, t, st)
+		blobtesting.AssertConnectionInfoRoundTrips(ctx, t, st)
+		require.NoError(t, st.Close(ctx))
+	}
+}
+
+func TestOCIStorageInvalidCredentials(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	key, _ := generateTestKey(t)
+	srv := newFakeServer(t, key)
+
+	_, otherKeyPEM := generateTestKey(t)
+
+	_, err := New(ctx, &Options{
+		BucketName:  testBucket,
+		Namespace:   testNamespace,
+		Endpoint:    srv.URL,
+		TenancyOCID: "tenancy",
+		UserOCID:    "user",
+		Fingerprint: "fingerprint",
+		PrivateKey:  otherKeyPEM,
+	})
+	require.Error(t, err)
+	require.False(t, isRetriableError(err))
+}
+
+func TestOCIStorageBucketNotFound(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	key, keyPEM := generateTestKey(t)
+	srv := newFakeServer(t, key)
+
+	_, err := New(ctx, &Options{
+		BucketName:  "no-such-bucket",
+		Namespace:   testNamespace,
+		Endpoint:    srv.URL,
+		TenancyOCID: "tenancy",
+		UserOCID:    "user",
+		Fingerprint: "fingerprint",
+		PrivateKey:  keyPEM,
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "BucketNotFound")
+}
+
+func TestOCIStorageEncryptedPrivateKey(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	key, _ := generateTestKey(t)
+	srv := newFakeServer(t, key)
+
+	//nolint:staticcheck
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), []byte("secret"), x509.PEMCipherAES256)
+	require.NoError(t, err)
+
+	opt := &Options{
+		BucketName:  testBucket,
+		Namespace:   testNamespace,
+		Endpoint:    srv.URL,
+		TenancyOCID: "tenancy",
+		UserOCID:    "user",
+		Fingerprint: "fingerprint",
+		PrivateKey:  string(pem.EncodeToMemory(block)),
+	}
+
+	_, err = New(ctx, opt)
+	require.Error(t, err)
+
+	opt.PrivateKeyPassphrase = "secret"
+
+	st, err := New(ctx, opt)
+	require.NoError(t, err)
+
+	blobtesting.VerifyStorage(ctx, t, st)
+}
+
+func TestOCIStorageRetention(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	key, keyPEM := generateTestKey(t)
+	locked := clock.Now().Add(-time.Hour)
+
+	cases := []struct {
+		desc  string
+		rules []retentionRule
+		opts  blob.PutOptions
+		ok    bool
+	}{
+		{"no rules", nil, blob.PutOptions{RetentionMode: blob.RetentionModeGovernance, RetentionPeriod: time.Hour}, false},
+		{"rule too short", []retentionRule{{Duration: &retentionDuration{1, "DAYS"}}}, blob.PutOptions{RetentionMode: blob.RetentionModeGovernance, RetentionPeriod: 48 * time.Hour}, false},
+		{"rule long enough", []retentionRule{{Duration: &retentionDuration{1, "YEARS"}}}, blob.PutOptions{RetentionMode: blob.RetentionModeGovernance, RetentionPeriod: 48 * time.Hour}, true},
+		{"compliance requires locked rule", []retentionRule{{Duration: &retentionDuration{30, "DAYS"}}}, blob.PutOptions{RetentionMode: blob.RetentionModeCompliance, RetentionPeriod: time.Hour}, false},
+		{"compliance with locked rule", []retentionRule{{Duration: &retentionDuration{30, "DAYS"}, TimeRuleLocked: &locked}}, blob.PutOptions{RetentionMode: blob.RetentionModeCompliance, RetentionPeriod: time.Hour}, true},
+		{"legal hold requires indefinite rule", []retentionRule{{Duration: &retentionDuration{30, "DAYS"}}}, blob.PutOptions{LegalHold: true}, false},
+		{"legal hold with indefinite rule", []retentionRule{{}}, blob.PutOptions{LegalHold: true}, true},
+		{"invalid mode", []retentionRule{{}}, blob.PutOptions{RetentionMode: "bad", RetentionPeriod: time.Hour}, false},
+	}
+
+	for _, tc := range cases {
+		srv := newFakeServer(t, key, tc.rules...)
+
+		st, err := New(ctx, &Options{
+			BucketName:  testBucket,
+			Namespace:   testNamespace,
+			Endpoint:    srv.URL,
+			TenancyOCID: "tenancy",
+			UserOCID:    "user",
+			Fingerprint: "fingerprint",
+			PrivateKey:  keyPEM,
+		})
+		require.NoError(t, err)
+
+		err = st.PutBlob(ctx, "blob1", gather.FromSlice([]byte{1, 2, 3}), tc.opts)
+		if tc.ok {
+			require.NoError(t, err, tc.desc)
+		} else {
+			require.True(t, errors.Is(err, blob.ErrUnsupportedPutBlobOption), tc.desc)
+		}
+	}
+}
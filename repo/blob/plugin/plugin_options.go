@@ -0,0 +1,10 @@
+package plugin
+
+// Options defines options for storage provided by an external plugin.
+type Options struct {
+	Executable     string            `json:"executable"`               // path to plugin executable
+	Args           []string          `json:"args,omitempty"`           // additional plugin arguments
+	Env            []string          `json:"env,omitempty"`            // additional plugin environment variables
+	Config         map[string]string `json:"config,omitempty"`         // plugin-specific configuration passed when opening storage
+	StartupTimeout int               `json:"startupTimeout,omitempty"` // time to wait for the plugin to start
+}
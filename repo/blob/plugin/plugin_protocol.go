@@ -0,0 +1,69 @@
+package plugin
+
+import (
+	"time"
+
+	"github.com/kopia/kopia/internal/pluginproc"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// The plugin is started with MagicCookieKey=MagicCookieValue in its environment, which lets it
+// detect being launched by kopia.
+const (
+	MagicCookieKey   = "KOPIA_STORAGE_PLUGIN_MAGIC_COOKIE"
+	MagicCookieValue = "8f2a6d0e-storage-plugin"
+
+	// ProtocolVersion is incremented on incompatible changes to the RPC protocol.
+	ProtocolVersion = 2
+)
+
+// listBlobsPageSize is the maximum number of blobs returned by a single ListBlobsNext call.
+const listBlobsPageSize = 1000
+
+var protocol = pluginproc.Protocol{
+	MagicCookieKey:   MagicCookieKey,
+	MagicCookieValue: MagicCookieValue,
+	HandshakePrefix:  "KOPIA-STORAGE-PLUGIN|",
+	Version:          ProtocolVersion,
+	ServiceName:      "Plugin",
+}
+
+// OpenRequest is the request to open storage with plugin-specific configuration.
+type OpenRequest struct {
+	Config map[string]string
+}
+
+// OpenResponse is the response to OpenRequest.
+type OpenResponse struct {
+	DisplayName string
+}
+
+// PutBlobRequest is the request to write a blob.
+type PutBlobRequest struct {
+	BlobID  blob.ID
+	Data    []byte
+	Options blob.PutOptions
+}
+
+// GetBlobRequest is the request to read full or partial blob.
+type GetBlobRequest struct {
+	BlobID blob.ID
+	Offset int64
+	Length int64
+}
+
+// SetTimeRequest is the request to change modification time of a blob.
+type SetTimeRequest struct {
+	BlobID blob.ID
+	Time   time.Time
+}
+
+// ListBlobsPage is the response to ListBlobsNext with the next page of blobs of a listing,
+// Done is set when the listing has completed and no more blobs will be returned.
+type ListBlobsPage struct {
+	Blobs []blob.Metadata
+	Done  bool
+}
+
+// Empty is used for requests and responses without data.
+type Empty struct{}
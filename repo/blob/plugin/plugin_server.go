@@ -0,0 +1,250 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/internal/pluginproc"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// OpenFunc opens storage implemented by a plugin using plugin-specific configuration.
+type OpenFunc func(ctx context.Context, config map[string]string) (blob.Storage, error)
+
+// Serve implements the plugin side of the protocol and should be called from main() of the plugin.
+// It returns when kopia disconnects from the plugin.
+func Serve(open OpenFunc) error {
+	return pluginproc.Serve(protocol, &service{open: open})
+}
+
+// service exposes blob.Storage over net/rpc.
+type service struct {
+	open OpenFunc
+
+	mu            sync.Mutex
+	st            blob.Storage
+	listings      map[int64]*listing
+	nextListingID int64
+}
+
+// listing is a ListBlobs() in progress, whose results are returned in pages by ListBlobsNext.
+type listing struct {
+	blobs  chan blob.Metadata // closed when the listing completes
+	err    error              // valid after blobs has been closed
+	cancel context.CancelFunc
+}
+
+// stop cancels the listing and waits for it to complete.
+func (l *listing) stop() {
+	l.cancel()
+
+	for range l.blobs {
+	}
+}
+
+// translateError converts errors to the well-known ones, since only error messages are sent over RPC.
+func translateError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, blob.ErrBlobNotFound):
+		return blob.ErrBlobNotFound
+	case errors.Is(err, blob.ErrSetTimeUnsupported):
+		return blob.ErrSetTimeUnsupported
+	case errors.Is(err, blob.ErrUnsupportedPutBlobOption):
+		return blob.ErrUnsupportedPutBlobOption
+	default:
+		return err
+	}
+}
+
+func (s *service) storage() (blob.Storage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.st == nil {
+		return nil, errors.New("storage not open")
+	}
+
+	return s.st, nil
+}
+
+func (s *service) Open(req *OpenRequest, resp *OpenResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.st != nil {
+		return errors.New("storage already open")
+	}
+
+	st, err := s.open(context.Background(), req.Config)
+	if err != nil {
+		return err
+	}
+
+	s.st = st
+	resp.DisplayName = st.DisplayName()
+
+	return nil
+}
+
+func (s *service) PutBlob(req *PutBlobRequest, _ *Empty) error {
+	st, err := s.storage()
+	if err != nil {
+		return err
+	}
+
+	return translateError(st.PutBlob(context.Background(), req.BlobID, gather.FromSlice(req.Data), req.Options))
+}
+
+func (s *service) GetBlob(req *GetBlobRequest, resp *[]byte) error {
+	st, err := s.storage()
+	if err != nil {
+		return err
+	}
+
+	*resp, err = st.GetBlob(context.Background(), req.BlobID, req.Offset, req.Length)
+
+	return translateError(err)
+}
+
+func (s *service) GetMetadata(blobID blob.ID, resp *blob.Metadata) error {
+	st, err := s.storage()
+	if err != nil {
+		return err
+	}
+
+	*resp, err = st.GetMetadata(context.Background(), blobID)
+
+	return translateError(err)
+}
+
+func (s *service) SetTime(req *SetTimeRequest, _ *Empty) error {
+	st, err := s.storage()
+	if err != nil {
+		return err
+	}
+
+	return translateError(st.SetTime(context.Background(), req.BlobID, req.Time))
+}
+
+func (s *service) DeleteBlob(blobID blob.ID, _ *Empty) error {
+	st, err := s.storage()
+	if err != nil {
+		return err
+	}
+
+	return translateError(st.DeleteBlob(context.Background(), blobID))
+}
+
+// ListBlobsStart starts listing blobs with the provided prefix and returns the ID of the listing,
+// whose results are returned by ListBlobsNext.
+func (s *service) ListBlobsStart(prefix blob.ID, resp *int64) error {
+	st, err := s.storage()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	l := &listing{
+		blobs:  make(chan blob.Metadata, listBlobsPageSize),
+		cancel: cancel,
+	}
+
+	go func() {
+		defer close(l.blobs)
+
+		l.err = st.ListBlobs(ctx, prefix, func(bm blob.Metadata) error {
+			select {
+			case l.blobs <- bm:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listings == nil {
+		s.listings = map[int64]*listing{}
+	}
+
+	s.nextListingID++
+	s.listings[s.nextListingID] = l
+	*resp = s.nextListingID
+
+	return nil
+}
+
+// ListBlobsNext returns the next page of results of the listing, the listing is removed once it is done.
+func (s *service) ListBlobsNext(id int64, resp *ListBlobsPage) error {
+	l := s.listing(id)
+	if l == nil {
+		return errors.Errorf("listing %v not found", id)
+	}
+
+	for len(resp.Blobs) < listBlobsPageSize {
+		bm, ok := <-l.blobs
+		if !ok {
+			s.removeListing(id)
+
+			resp.Done = true
+
+			return translateError(l.err)
+		}
+
+		resp.Blobs = append(resp.Blobs, bm)
+	}
+
+	return nil
+}
+
+// ListBlobsCancel stops the listing before it is done.
+func (s *service) ListBlobsCancel(id int64, _ *Empty) error {
+	if l := s.removeListing(id); l != nil {
+		l.stop()
+	}
+
+	return nil
+}
+
+func (s *service) listing(id int64) *listing {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.listings[id]
+}
+
+func (s *service) removeListing(id int64) *listing {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l := s.listings[id]
+	delete(s.listings, id)
+
+	return l
+}
+
+func (s *service) Close(_ Empty, _ *Empty) error {
+	st, err := s.storage()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	listings := s.listings
+	s.listings = nil
+	s.mu.Unlock()
+
+	for _, l := range listings {
+		l.stop()
+	}
+
+	return st.Close(context.Background())
+}
@@ -0,0 +1,202 @@
+// Package plugin implements blob storage provided by an external plugin process, which allows
+// storage providers to be implemented and distributed separately from kopia.
+//
+// Plugins are executables that call Serve() from their main() function. Kopia starts the plugin
+// and communicates with it using net/rpc over its standard input and output (see pluginproc).
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"net/rpc"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/pluginproc"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/logging"
+)
+
+const (
+	pluginStorageType = "plugin"
+
+	// defaultStartupTimeout is the time we wait for the plugin to complete the handshake.
+	defaultStartupTimeout = 15 * time.Second
+)
+
+var log = logging.GetContextLoggerFunc("plugin")
+
+type pluginStorage struct {
+	Options
+
+	client      *pluginproc.Client
+	displayName string
+}
+
+func (p *pluginStorage) call(ctx context.Context, method string, args, reply interface{}) error {
+	return translateRemoteError(p.client.Call(ctx, method, args, reply))
+}
+
+// translateRemoteError maps error messages sent by the plugin back to well-known errors.
+func translateRemoteError(err error) error {
+	var se rpc.ServerError
+
+	if !errors.As(err, &se) {
+		return err
+	}
+
+	switch string(se) {
+	case blob.ErrBlobNotFound.Error():
+		return blob.ErrBlobNotFound
+	case blob.ErrSetTimeUnsupported.Error():
+		return blob.ErrSetTimeUnsupported
+	case blob.ErrUnsupportedPutBlobOption.Error():
+		return blob.ErrUnsupportedPutBlobOption
+	default:
+		return errors.New(string(se))
+	}
+}
+
+func (p *pluginStorage) PutBlob(ctx context.Context, blobID blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	var buf bytes.Buffer
+
+	buf.Grow(data.Length())
+
+	if _, err := data.WriteTo(&buf); err != nil {
+		return errors.Wrap(err, "unable to read blob data")
+	}
+
+	return p.call(ctx, "PutBlob", &PutBlobRequest{BlobID: blobID, Data: buf.Bytes(), Options: opts}, &Empty{})
+}
+
+func (p *pluginStorage) GetBlob(ctx context.Context, blobID blob.ID, offset, length int64) ([]byte, error) {
+	var resp []byte
+
+	if err := p.call(ctx, "GetBlob", &GetBlobRequest{BlobID: blobID, Offset: offset, Length: length}, &resp); err != nil {
+		return nil, err
+	}
+
+	// gob decodes empty slices as nil.
+	if resp == nil {
+		resp = []byte{}
+	}
+
+	return resp, nil
+}
+
+func (p *pluginStorage) GetMetadata(ctx context.Context, blobID blob.ID) (blob.Metadata, error) {
+	var resp blob.Metadata
+
+	err := p.call(ctx, "GetMetadata", blobID, &resp)
+
+	return resp, err
+}
+
+func (p *pluginStorage) SetTime(ctx context.Context, blobID blob.ID, t time.Time) error {
+	return p.call(ctx, "SetTime", &SetTimeRequest{BlobID: blobID, Time: t}, &Empty{})
+}
+
+func (p *pluginStorage) DeleteBlob(ctx context.Context, blobID blob.ID) error {
+	return p.call(ctx, "DeleteBlob", blobID, &Empty{})
+}
+
+// ListBlobs lists blobs in pages, so that large listings don't need to be sent in a single reply.
+func (p *pluginStorage) ListBlobs(ctx context.Context, prefix blob.ID, callback func(blob.Metadata) error) error {
+	var id int64
+
+	if err := p.call(ctx, "ListBlobsStart", prefix, &id); err != nil {
+		return err
+	}
+
+	for {
+		var page ListBlobsPage
+
+		if err := p.call(ctx, "ListBlobsNext", id, &page); err != nil {
+			p.cancelListing(ctx, id)
+			return err
+		}
+
+		for _, bm := range page.Blobs {
+			if err := callback(bm); err != nil {
+				p.cancelListing(ctx, id)
+				return err
+			}
+		}
+
+		if page.Done {
+			return nil
+		}
+	}
+}
+
+func (p *pluginStorage) cancelListing(ctx context.Context, id int64) {
+	if err := p.call(ctx, "ListBlobsCancel", id, &Empty{}); err != nil {
+		log(ctx).Debugf("unable to cancel listing: %v", err)
+	}
+}
+
+func (p *pluginStorage) ConnectionInfo() blob.ConnectionInfo {
+	return blob.ConnectionInfo{
+		Type:   pluginStorageType,
+		Config: &p.Options,
+	}
+}
+
+func (p *pluginStorage) DisplayName() string {
+	return p.displayName
+}
+
+func (p *pluginStorage) Close(ctx context.Context) error {
+	err := p.call(ctx, "Close", Empty{}, &Empty{})
+
+	p.client.Close()
+
+	return errors.Wrap(err, "error closing plugin storage")
+}
+
+// New starts the plugin and opens storage implemented by it.
+func New(ctx context.Context, opt *Options) (blob.Storage, error) {
+	if opt.Executable == "" {
+		return nil, errors.New("plugin executable must be specified")
+	}
+
+	startupTimeout := defaultStartupTimeout
+	if opt.StartupTimeout != 0 {
+		startupTimeout = time.Duration(opt.StartupTimeout) * time.Second
+	}
+
+	log(ctx).Debugf("starting plugin %v %v", opt.Executable, opt.Args)
+
+	client, err := pluginproc.Start(ctx, protocol, opt.Executable, opt.Args, opt.Env, startupTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &pluginStorage{
+		Options: *opt,
+		client:  client,
+	}
+
+	var resp OpenResponse
+
+	if err := p.call(ctx, "Open", &OpenRequest{Config: opt.Config}, &resp); err != nil {
+		client.Close()
+		return nil, errors.Wrap(err, "unable to open plugin storage")
+	}
+
+	p.displayName = resp.DisplayName
+
+	return p, nil
+}
+
+func init() {
+	blob.AddSupportedStorage(
+		pluginStorageType,
+		func() interface{} {
+			return &Options{}
+		},
+		func(ctx context.Context, o interface{}) (blob.Storage, error) {
+			return New(ctx, o.(*Options))
+		})
+}
@@ -0,0 +1,133 @@
+package plugin_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/filesystem"
+	"github.com/kopia/kopia/repo/blob/plugin"
+)
+
+// TestMain makes the test binary act as a plugin backed by filesystem storage when launched by the plugin storage.
+func TestMain(m *testing.M) {
+	if os.Getenv(plugin.MagicCookieKey) != "" {
+		if err := plugin.Serve(func(ctx context.Context, config map[string]string) (blob.Storage, error) {
+			return filesystem.New(ctx, &filesystem.Options{Path: config["path"]})
+		}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestPluginStorage(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	st, err := plugin.New(ctx, &plugin.Options{
+		Executable: os.Args[0],
+		Config:     map[string]string{"path": t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("unable to start plugin: %v", err)
+	}
+
+	defer st.Close(ctx)
+
+	blobtesting.VerifyStorage(ctx, t, st)
+	blobtesting.AssertConnectionInfoRoundTrips(ctx, t, st)
+
+	if got := st.DisplayName(); !strings.HasPrefix(got, "Filesystem: ") {
+		t.Errorf("unexpected display name: %q", got)
+	}
+}
+
+func TestPluginStorageListBlobsPages(t *testing.T) {
+	ctx := testlogging.Context(t)
+	dir := t.TempDir()
+
+	fst, err := filesystem.New(ctx, &filesystem.Options{Path: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const numBlobs = 2500
+
+	for i := 0; i < numBlobs; i++ {
+		if err := fst.PutBlob(ctx, blob.ID(fmt.Sprintf("blob%05v", i)), gather.FromSlice([]byte{1}), blob.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st, err := plugin.New(ctx, &plugin.Options{
+		Executable: os.Args[0],
+		Config:     map[string]string{"path": dir},
+	})
+	if err != nil {
+		t.Fatalf("unable to start plugin: %v", err)
+	}
+
+	defer st.Close(ctx)
+
+	seen := map[blob.ID]bool{}
+
+	if err := st.ListBlobs(ctx, "blob", func(bm blob.Metadata) error {
+		seen[bm.BlobID] = true
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(seen), numBlobs; got != want {
+		t.Fatalf("unexpected number of listed blobs: %v, want %v", got, want)
+	}
+
+	// stopping listing early cancels it and further listings are not affected.
+	errStop := errors.New("stop")
+	n := 0
+
+	if err := st.ListBlobs(ctx, "blob", func(bm blob.Metadata) error {
+		n++
+		return errStop
+	}); !errors.Is(err, errStop) || n != 1 {
+		t.Fatalf("unexpected result of stopped listing: %v after %v blobs", err, n)
+	}
+
+	n = 0
+
+	if err := st.ListBlobs(ctx, "blob0001", func(bm blob.Metadata) error {
+		n++
+		return nil
+	}); err != nil || n != 10 {
+		t.Fatalf("unexpected result of listing: %v after %v blobs", err, n)
+	}
+}
+
+func TestPluginStorageOpenFailure(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	if _, err := plugin.New(ctx, &plugin.Options{
+		Executable: os.Args[0],
+		Config:     map[string]string{"path": "/no/such/directory"},
+	}); err == nil {
+		t.Fatalf("unexpected success")
+	}
+}
+
+func TestPluginNotExecutedDirectly(t *testing.T) {
+	if err := plugin.Serve(nil); err == nil {
+		t.Fatalf("unexpected success")
+	}
+}
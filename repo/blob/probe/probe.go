@@ -0,0 +1,288 @@
+// Package probe verifies that blob storage provides the semantics required by the repository.
+package probe
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/logging"
+)
+
+var log = logging.GetContextLoggerFunc("kopia/probe")
+
+// BlobIDPrefix is the prefix of temporary blobs written by the probe.
+const BlobIDPrefix blob.ID = "kopia.probe."
+
+const (
+	defaultVisibilityTimeout = 10 * time.Second
+	visibilityCheckInterval  = 500 * time.Millisecond
+	probeBlobLength          = 1000
+	probeSuffixLength        = 3
+)
+
+// ErrProbeFailed is returned by Run when the storage does not provide the required semantics.
+var ErrProbeFailed = errors.New("storage consistency probe failed")
+
+// Options provides options for Run.
+type Options struct {
+	// VisibilityTimeout is the maximum time to wait for changes to become visible in blob listings.
+	VisibilityTimeout time.Duration
+}
+
+// Result describes the outcome of the probe.
+type Result struct {
+	// Failures contains descriptions of required semantics the storage doesn't provide.
+	Failures []string `json:"failures,omitempty"`
+
+	// Warnings contains descriptions of semantics the storage provides only eventually.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// ConditionalWrites is true when the storage supports writing blobs only if they don't exist
+	// and the probe verified that existing blobs are not replaced by such writes.
+	ConditionalWrites bool `json:"conditionalWrites"`
+}
+
+// OK returns true when the storage provides all required semantics.
+func (r *Result) OK() bool {
+	return len(r.Failures) == 0
+}
+
+// Err returns an error wrapping ErrProbeFailed describing the first failure, or nil.
+func (r *Result) Err() error {
+	if r.OK() {
+		return nil
+	}
+
+	return errors.Wrap(ErrProbeFailed, r.Failures[0])
+}
+
+type prober struct {
+	st     blob.Storage
+	opt    Options
+	result Result
+	id     blob.ID
+}
+
+// Run writes a temporary blob and verifies that the storage provides read-after-write, list-after-write,
+// overwrite and delete semantics, reports missing blobs correctly and, if supported, honors conditional writes. The temporary blob is always removed.
+// Errors are only returned when the probe can't be performed at all, such as when the storage is not writable.
+func Run(ctx context.Context, st blob.Storage, opt Options) (*Result, error) {
+	if opt.VisibilityTimeout == 0 {
+		opt.VisibilityTimeout = defaultVisibilityTimeout
+	}
+
+	// keep probe blob IDs short, so that sharded storage does not create directories for them.
+	suffix := make([]byte, probeSuffixLength)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, errors.Wrap(err, "unable to generate probe blob ID")
+	}
+
+	p := &prober{
+		st:  st,
+		opt: opt,
+		id:  BlobIDPrefix + blob.ID(fmt.Sprintf("%x", suffix)),
+	}
+
+	if err := p.run(ctx); err != nil {
+		// best-effort cleanup, the blob may not have been written.
+		if derr := st.DeleteBlob(ctx, p.id); derr != nil && !errors.Is(derr, blob.ErrBlobNotFound) {
+			log(ctx).Warningf("unable to delete probe blob %v: %v", p.id, derr)
+		}
+
+		return nil, err
+	}
+
+	return &p.result, nil
+}
+
+func (p *prober) failf(msg string, args ...interface{}) {
+	p.result.Failures = append(p.result.Failures, fmt.Sprintf(msg, args...))
+}
+
+func (p *prober) warnf(msg string, args ...interface{}) {
+	p.result.Warnings = append(p.result.Warnings, fmt.Sprintf(msg, args...))
+}
+
+func (p *prober) run(ctx context.Context) error {
+	p.checkNotFound(ctx, "before it was written")
+
+	data1 := randomData()
+	data2 := randomData()
+
+	if err := p.st.PutBlob(ctx, p.id, gather.FromSlice(data1), blob.PutOptions{}); err != nil {
+		return errors.Wrap(err, "unable to write probe blob")
+	}
+
+	p.checkContents(ctx, data1, "after write")
+	p.checkListed(ctx, true, "write")
+
+	if err := p.st.PutBlob(ctx, p.id, gather.FromSlice(data2), blob.PutOptions{}); err != nil {
+		return errors.Wrap(err, "unable to overwrite probe blob")
+	}
+
+	p.checkContents(ctx, data2, "after overwrite")
+
+	cp, conditional := p.st.(blob.ConditionalPutter)
+	if conditional {
+		p.checkConditionalWriteOfExistingBlob(ctx, cp, data2)
+	}
+
+	if err := p.st.DeleteBlob(ctx, p.id); err != nil {
+		return errors.Wrap(err, "unable to delete probe blob")
+	}
+
+	p.checkNotFound(ctx, "after it was deleted")
+	p.checkListed(ctx, false, "delete")
+
+	if conditional {
+		if err := p.checkConditionalWriteOfNewBlob(ctx, cp); err != nil {
+			return err
+		}
+	}
+
+	if err := p.st.DeleteBlob(ctx, p.id); err != nil && !errors.Is(err, blob.ErrBlobNotFound) {
+		p.failf("deleting non-existent blob returned unexpected error: %v", err)
+	}
+
+	return nil
+}
+
+// checkConditionalWriteOfExistingBlob verifies that conditional write of the existing blob with the provided contents
+// is rejected without replacing it.
+func (p *prober) checkConditionalWriteOfExistingBlob(ctx context.Context, cp blob.ConditionalPutter, existing []byte) {
+	err := cp.PutBlobIfNotExists(ctx, p.id, gather.FromSlice(randomData()), blob.PutOptions{})
+	if !errors.Is(err, blob.ErrBlobAlreadyExists) {
+		p.failf("conditional write of existing blob did not report it as existing, got: %v", err)
+		return
+	}
+
+	if got, err := p.st.GetBlob(ctx, p.id, 0, -1); err != nil || !bytes.Equal(got, existing) {
+		p.failf("conditional write replaced existing blob")
+		return
+	}
+
+	p.result.ConditionalWrites = true
+}
+
+// checkConditionalWriteOfNewBlob verifies that conditional write of a blob that does not exist succeeds.
+func (p *prober) checkConditionalWriteOfNewBlob(ctx context.Context, cp blob.ConditionalPutter) error {
+	data := randomData()
+
+	if err := cp.PutBlobIfNotExists(ctx, p.id, gather.FromSlice(data), blob.PutOptions{}); err != nil {
+		p.failf("conditional write of new blob failed: %v", err)
+		p.result.ConditionalWrites = false
+
+		return nil
+	}
+
+	if got, err := p.st.GetBlob(ctx, p.id, 0, -1); err != nil || !bytes.Equal(got, data) {
+		p.failf("conditional write of new blob did not write expected contents")
+		p.result.ConditionalWrites = false
+	}
+
+	return errors.Wrap(p.st.DeleteBlob(ctx, p.id), "unable to delete probe blob")
+}
+
+func (p *prober) checkNotFound(ctx context.Context, when string) {
+	if _, err := p.st.GetBlob(ctx, p.id, 0, -1); !errors.Is(err, blob.ErrBlobNotFound) {
+		p.failf("reading blob %v did not report it as not found, got: %v", when, err)
+	}
+
+	if _, err := p.st.GetMetadata(ctx, p.id); !errors.Is(err, blob.ErrBlobNotFound) {
+		p.failf("getting metadata of blob %v did not report it as not found, got: %v", when, err)
+	}
+}
+
+func (p *prober) checkContents(ctx context.Context, want []byte, when string) {
+	got, err := p.st.GetBlob(ctx, p.id, 0, -1)
+
+	switch {
+	case err != nil:
+		p.failf("unable to read blob %v: %v", when, err)
+	case !bytes.Equal(got, want):
+		p.failf("blob read %v has unexpected contents (got %v bytes, wanted %v)", when, len(got), len(want))
+	}
+
+	const offset, length = 100, 200
+
+	got, err = p.st.GetBlob(ctx, p.id, offset, length)
+
+	switch {
+	case err != nil:
+		p.failf("unable to read range of blob %v: %v", when, err)
+	case !bytes.Equal(got, want[offset:offset+length]):
+		p.failf("range of blob read %v has unexpected contents", when)
+	}
+
+	md, err := p.st.GetMetadata(ctx, p.id)
+
+	switch {
+	case err != nil:
+		p.failf("unable to get blob metadata %v: %v", when, err)
+	case md.Length != int64(len(want)):
+		p.failf("blob metadata %v has unexpected length %v, wanted %v", when, md.Length, len(want))
+	}
+}
+
+// checkListed verifies that listing reflects the blob write or deletion, tolerating storage that
+// becomes consistent within the visibility timeout with a warning.
+func (p *prober) checkListed(ctx context.Context, wantListed bool, op string) {
+	t0 := clock.Now()
+
+	for {
+		listed, err := p.isListed(ctx)
+		if err != nil {
+			p.failf("unable to list blobs after %v: %v", op, err)
+			return
+		}
+
+		if listed == wantListed {
+			if dt := clock.Since(t0); dt >= visibilityCheckInterval {
+				p.warnf("blob listing reflected %v only after %v, the storage is eventually consistent", op, dt.Truncate(time.Millisecond))
+			}
+
+			return
+		}
+
+		if clock.Since(t0) >= p.opt.VisibilityTimeout {
+			p.failf("blob listing did not reflect %v within %v", op, p.opt.VisibilityTimeout)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			p.failf("blob listing did not reflect %v: %v", op, ctx.Err())
+			return
+		case <-time.After(visibilityCheckInterval):
+		}
+	}
+}
+
+func (p *prober) isListed(ctx context.Context) (bool, error) {
+	listed := false
+
+	err := p.st.ListBlobs(ctx, BlobIDPrefix, func(bm blob.Metadata) error {
+		if bm.BlobID == p.id {
+			listed = true
+		}
+
+		return nil
+	})
+
+	return listed, err
+}
+
+func randomData() []byte {
+	b := make([]byte, probeBlobLength)
+	rand.Read(b) //nolint:errcheck
+
+	return b
+}
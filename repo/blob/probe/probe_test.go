@@ -0,0 +1,153 @@
+package probe_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/filesystem"
+	"github.com/kopia/kopia/repo/blob/probe"
+)
+
+// hiddenListingStorage never reports blobs written less than hideFor ago in listings.
+type hiddenListingStorage struct {
+	blob.Storage
+
+	hideFor time.Duration
+	written map[blob.ID]time.Time
+}
+
+func (s *hiddenListingStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	s.written[id] = time.Now()
+	return s.Storage.PutBlob(ctx, id, data, opts)
+}
+
+func (s *hiddenListingStorage) ListBlobs(ctx context.Context, prefix blob.ID, cb func(blob.Metadata) error) error {
+	return s.Storage.ListBlobs(ctx, prefix, func(bm blob.Metadata) error {
+		if time.Since(s.written[bm.BlobID]) < s.hideFor {
+			return nil
+		}
+
+		return cb(bm)
+	})
+}
+
+// untranslatedErrorStorage reports missing blobs using generic errors.
+type untranslatedErrorStorage struct {
+	blob.Storage
+}
+
+func (s untranslatedErrorStorage) GetBlob(ctx context.Context, id blob.ID, offset, length int64) ([]byte, error) {
+	v, err := s.Storage.GetBlob(ctx, id, offset, length)
+	if errors.Is(err, blob.ErrBlobNotFound) {
+		return nil, errors.New("404")
+	}
+
+	return v, err
+}
+
+// ignoredConditionStorage claims to support conditional writes, but overwrites existing blobs.
+type ignoredConditionStorage struct {
+	blob.Storage
+}
+
+func (s ignoredConditionStorage) PutBlobIfNotExists(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	return s.Storage.PutBlob(ctx, id, data, opts)
+}
+
+func TestProbe(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	fs, err := filesystem.New(ctx, &filesystem.Options{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		desc            string
+		st              blob.Storage
+		wantFailure     string
+		wantWarnings    int
+		wantConditional bool
+	}{
+		{
+			desc:            "map",
+			st:              blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil),
+			wantConditional: true,
+		},
+		{
+			desc:            "filesystem",
+			st:              fs,
+			wantConditional: true,
+		},
+		{
+			desc:        "ignored condition",
+			st:          ignoredConditionStorage{blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil)},
+			wantFailure: "conditional write of existing blob did not report it as existing",
+		},
+		{
+			desc: "eventually consistent listing",
+			st: &hiddenListingStorage{
+				Storage: blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil),
+				hideFor: 700 * time.Millisecond,
+				written: map[blob.ID]time.Time{},
+			},
+			wantWarnings: 1,
+		},
+		{
+			desc: "inconsistent listing",
+			st: &hiddenListingStorage{
+				Storage: blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil),
+				hideFor: time.Hour,
+				written: map[blob.ID]time.Time{},
+			},
+			wantFailure: "blob listing did not reflect write",
+		},
+		{
+			desc:        "untranslated errors",
+			st:          untranslatedErrorStorage{blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil)},
+			wantFailure: "reading blob before it was written did not report it as not found",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.desc, func(t *testing.T) {
+			res, err := probe.Run(ctx, tc.st, probe.Options{VisibilityTimeout: 2 * time.Second})
+			if err != nil {
+				t.Fatalf("unable to run probe: %v", err)
+			}
+
+			if tc.wantFailure == "" {
+				if err := res.Err(); err != nil {
+					t.Fatalf("unexpected failure: %v", err)
+				}
+			} else if err := res.Err(); !errors.Is(err, probe.ErrProbeFailed) || !strings.Contains(err.Error(), tc.wantFailure) {
+				t.Fatalf("unexpected error: %v, wanted %q", err, tc.wantFailure)
+			}
+
+			if got := len(res.Warnings); got != tc.wantWarnings {
+				t.Errorf("unexpected warnings: %v", res.Warnings)
+			}
+
+			if got := res.ConditionalWrites; got != tc.wantConditional {
+				t.Errorf("unexpected conditional writes: %v, want %v", got, tc.wantConditional)
+			}
+
+			remaining, err := blob.ListAllBlobs(ctx, tc.st, probe.BlobIDPrefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(remaining) != 0 {
+				t.Errorf("probe blobs were not removed: %v", remaining)
+			}
+		})
+	}
+}
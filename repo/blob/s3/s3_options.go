@@ -22,4 +22,21 @@ type Options struct {
 	MaxUploadSpeedBytesPerSecond int `json:"maxUploadSpeedBytesPerSecond,omitempty"`
 
 	MaxDownloadSpeedBytesPerSecond int `json:"maxDownloadSpeedBytesPerSecond,omitempty"`
+
+	// MaxConcurrentRequests is the upper bound of concurrent requests, which is reduced automatically
+	// while the server is throttling requests.
+	MaxConcurrentRequests int `json:"maxConcurrentRequests,omitempty"`
+
+	// MaxRequestsPerSecond is the upper bound of the rate of requests, which is reduced automatically
+	// while the server is throttling requests. Zero means no limit.
+	MaxRequestsPerSecond int `json:"maxRequestsPerSecond,omitempty"`
+
+	// ServerSideEncryption specifies server-side encryption of stored objects, one of SSES3, SSEKMS or SSEC.
+	ServerSideEncryption string `json:"serverSideEncryption,omitempty"`
+
+	// SSEKMSKeyID is the ID of the KMS key used with SSE-KMS, the default key is used if not set.
+	SSEKMSKeyID string `json:"sseKMSKeyID,omitempty"`
+
+	// SSECustomerKey is the base64-encoded 256-bit key used with SSE-C.
+	SSECustomerKey string `json:"sseCustomerKey,omitempty" kopia:"sensitive"`
 }
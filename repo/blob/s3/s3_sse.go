@@ -0,0 +1,58 @@
+package s3
+
+import (
+	"encoding/base64"
+
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/pkg/errors"
+)
+
+// Supported values of Options.ServerSideEncryption.
+const (
+	SSES3  = "SSE-S3"
+	SSEKMS = "SSE-KMS"
+	SSEC   = "SSE-C"
+)
+
+// SupportedServerSideEncryption lists supported server-side encryption types.
+var SupportedServerSideEncryption = []string{SSES3, SSEKMS, SSEC}
+
+// serverSideEncryption returns server-side encryption configured in the provided options, nil if not configured.
+func serverSideEncryption(opt *Options) (encrypt.ServerSide, error) {
+	if opt.ServerSideEncryption != SSEKMS && opt.SSEKMSKeyID != "" {
+		return nil, errors.Errorf("KMS key ID can only be used with %v", SSEKMS)
+	}
+
+	if opt.ServerSideEncryption != SSEC && opt.SSECustomerKey != "" {
+		return nil, errors.Errorf("customer key can only be used with %v", SSEC)
+	}
+
+	switch opt.ServerSideEncryption {
+	case "":
+		return nil, nil
+
+	case SSES3:
+		return encrypt.NewSSE(), nil
+
+	case SSEKMS:
+		sse, err := encrypt.NewSSEKMS(opt.SSEKMSKeyID, nil)
+		return sse, errors.Wrap(err, "invalid SSE-KMS configuration")
+
+	case SSEC:
+		if opt.DoNotUseTLS {
+			return nil, errors.Errorf("%v requires TLS", SSEC)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(opt.SSECustomerKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "customer key is not valid base64")
+		}
+
+		sse, err := encrypt.NewSSEC(key)
+
+		return sse, errors.Wrap(err, "invalid SSE-C customer key")
+
+	default:
+		return nil, errors.Errorf("unsupported server-side encryption %q, must be one of %v", opt.ServerSideEncryption, SupportedServerSideEncryption)
+	}
+}
@@ -0,0 +1,55 @@
+package s3
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+func TestServerSideEncryption(t *testing.T) {
+	validKey := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	cases := []struct {
+		opt      Options
+		wantType encrypt.Type
+		wantErr  bool
+	}{
+		{opt: Options{}},
+		{opt: Options{ServerSideEncryption: SSES3}, wantType: encrypt.S3},
+		{opt: Options{ServerSideEncryption: SSEKMS}, wantType: encrypt.KMS},
+		{opt: Options{ServerSideEncryption: SSEKMS, SSEKMSKeyID: "my-key"}, wantType: encrypt.KMS},
+		{opt: Options{ServerSideEncryption: SSEC, SSECustomerKey: validKey}, wantType: encrypt.SSEC},
+		{opt: Options{ServerSideEncryption: SSEC, SSECustomerKey: validKey, DoNotUseTLS: true}, wantErr: true},
+		{opt: Options{ServerSideEncryption: SSEC, SSECustomerKey: "not-base64!"}, wantErr: true},
+		{opt: Options{ServerSideEncryption: SSEC, SSECustomerKey: base64.StdEncoding.EncodeToString([]byte{1, 2, 3})}, wantErr: true},
+		{opt: Options{ServerSideEncryption: SSES3, SSEKMSKeyID: "my-key"}, wantErr: true},
+		{opt: Options{SSECustomerKey: validKey}, wantErr: true},
+		{opt: Options{ServerSideEncryption: "unknown"}, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		opt := tc.opt
+
+		sse, err := serverSideEncryption(&opt)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("expected error for %+v", tc.opt)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("unexpected error for %+v: %v", tc.opt, err)
+			continue
+		}
+
+		switch {
+		case tc.wantType == "" && sse != nil:
+			t.Errorf("unexpected server-side encryption for %+v: %v", tc.opt, sse.Type())
+		case tc.wantType != "" && (sse == nil || sse.Type() != tc.wantType):
+			t.Errorf("unexpected server-side encryption for %+v: %v, want %v", tc.opt, sse, tc.wantType)
+		}
+	}
+}
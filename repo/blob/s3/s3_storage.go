@@ -15,9 +15,12 @@
 	"github.com/efarrer/iothrottler"
 	minio "github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 	"github.com/pkg/errors"
 
+	"github.com/kopia/kopia/internal/clock"
 	"github.com/kopia/kopia/internal/retry"
+	"github.com/kopia/kopia/internal/throttle"
 	"github.com/kopia/kopia/repo/blob"
 )
 
@@ -32,13 +35,17 @@ type s3Storage struct {
 
 	cli *minio.Client
 
+	// sse is the server-side encryption of stored objects, nil if not used.
+	sse encrypt.ServerSide
+
 	downloadThrottler *iothrottler.IOThrottlerPool
 	uploadThrottler   *iothrottler.IOThrottlerPool
 }
 
 func (s *s3Storage) GetBlob(ctx context.Context, b blob.ID, offset, length int64) ([]byte, error) {
 	attempt := func() (interface{}, error) {
-		var opt minio.GetObjectOptions
+		// only SSE-C is passed on reads, the other types of server-side encryption are ignored.
+		opt := minio.GetObjectOptions{ServerSideEncryption: s.sse}
 
 		if length > 0 {
 			if err := opt.SetRange(offset, offset+length-1); err != nil {
@@ -88,8 +95,8 @@ func exponentialBackoff(ctx context.Context, desc string, att retry.AttemptFunc)
 
 func isRetriableError(err error) bool {
 	if me, ok := err.(minio.ErrorResponse); ok {
-		// retry on server errors, not on client errors
-		return me.StatusCode >= 500
+		// retry on server errors and throttling, not on other client errors
+		return me.StatusCode >= 500 || me.StatusCode == http.StatusTooManyRequests
 	}
 
 	if strings.Contains(strings.ToLower(err.Error()), "http") {
@@ -116,7 +123,7 @@ func translateError(err error) error {
 
 func (s *s3Storage) GetMetadata(ctx context.Context, b blob.ID) (blob.Metadata, error) {
 	v, err := retry.WithExponentialBackoff(ctx, fmt.Sprintf("GetMetadata(%v)", b), func() (interface{}, error) {
-		oi, err := s.cli.StatObject(ctx, s.BucketName, s.getObjectNameString(b), minio.StatObjectOptions{})
+		oi, err := s.cli.StatObject(ctx, s.BucketName, s.getObjectNameString(b), minio.StatObjectOptions{ServerSideEncryption: s.sse})
 		if err != nil {
 			return blob.Metadata{}, err
 		}
@@ -131,7 +138,11 @@ func (s *s3Storage) GetMetadata(ctx context.Context, b blob.ID) (blob.Metadata,
 	return v.(blob.Metadata), translateError(err)
 }
 
-func (s *s3Storage) PutBlob(ctx context.Context, b blob.ID, data blob.Bytes) error {
+func (s *s3Storage) PutBlob(ctx context.Context, b blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	if err := blob.ValidateRetentionMode(opts.RetentionMode); err != nil {
+		return errors.Wrap(blob.ErrUnsupportedPutBlobOption, err.Error())
+	}
+
 	return translateError(retry.WithExponentialBackoffNoValue(ctx, fmt.Sprintf("PutBlob(%v)", b), func() error {
 		throttled, err := s.uploadThrottler.AddReader(ioutil.NopCloser(data.Reader()))
 		if err != nil {
@@ -146,22 +157,43 @@ func (s *s3Storage) PutBlob(ctx context.Context, b blob.ID, data blob.Bytes) err
 			defer progressCallback(string(b), int64(combinedLength), int64(combinedLength))
 		}
 
-		uploadInfo, err := s.cli.PutObject(ctx, s.BucketName, s.getObjectNameString(b), throttled, int64(combinedLength), minio.PutObjectOptions{
-			ContentType: "application/x-kopia",
-			Progress:    newProgressReader(progressCallback, string(b), int64(combinedLength)),
-		})
+		putOpts := s.putObjectOptions(opts)
+		putOpts.Progress = newProgressReader(progressCallback, string(b), int64(combinedLength))
+
+		uploadInfo, err := s.cli.PutObject(ctx, s.BucketName, s.getObjectNameString(b), throttled, int64(combinedLength), putOpts)
 
 		if err == io.EOF && uploadInfo.Size == 0 {
 			// special case empty stream
-			_, err = s.cli.PutObject(ctx, s.BucketName, s.getObjectNameString(b), bytes.NewBuffer(nil), 0, minio.PutObjectOptions{
-				ContentType: "application/x-kopia",
-			})
+			_, err = s.cli.PutObject(ctx, s.BucketName, s.getObjectNameString(b), bytes.NewBuffer(nil), 0, s.putObjectOptions(opts))
 		}
 
 		return err
 	}, isRetriableError))
 }
 
+// putObjectOptions returns options for writing objects, which lock written objects using S3 Object Lock
+// when retention is requested.
+func (s *s3Storage) putObjectOptions(opts blob.PutOptions) minio.PutObjectOptions {
+	result := minio.PutObjectOptions{
+		ContentType:          "application/x-kopia",
+		ServerSideEncryption: s.sse,
+	}
+
+	if opts.RetentionMode != "" && opts.RetentionPeriod > 0 {
+		result.Mode = minio.RetentionMode(opts.RetentionMode)
+		result.RetainUntilDate = clock.Now().Add(opts.RetentionPeriod).UTC()
+	}
+
+	if opts.LegalHold {
+		result.LegalHold = minio.LegalHoldEnabled
+	}
+
+	// S3 requires Content-MD5 header on all writes of locked objects.
+	result.SendContentMd5 = opts.HasRetentionOptions()
+
+	return result
+}
+
 func (s *s3Storage) SetTime(ctx context.Context, b blob.ID, t time.Time) error {
 	return blob.ErrSetTimeUnsupported
 }
@@ -270,16 +302,29 @@ func New(ctx context.Context, opt *Options) (blob.Storage, error) {
 		return nil, errors.New("bucket name must be specified")
 	}
 
+	sse, err := serverSideEncryption(opt)
+	if err != nil {
+		return nil, err
+	}
+
 	minioOpts := &minio.Options{
 		Creds:  credentials.NewStaticV4(opt.AccessKeyID, opt.SecretAccessKey, opt.SessionToken),
 		Secure: !opt.DoNotUseTLS,
 		Region: opt.Region,
 	}
 
+	transport, err := minio.DefaultTransport(!opt.DoNotUseTLS)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create transport")
+	}
+
 	if opt.DoNotVerifyTLS {
-		minioOpts.Transport = getCustomTransport(true)
+		transport = getCustomTransport(true)
 	}
 
+	// back off when the server responds with SlowDown or other throttling errors.
+	minioOpts.Transport = throttle.NewAdaptiveRoundTripper(transport, throttle.NewAdaptiveLimiter(opt.MaxConcurrentRequests, opt.MaxRequestsPerSecond))
+
 	cli, err := minio.New(opt.Endpoint, minioOpts)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to create client")
@@ -301,6 +346,7 @@ func New(ctx context.Context, opt *Options) (blob.Storage, error) {
 		Options:           *opt,
 		ctx:               ctx,
 		cli:               cli,
+		sse:               sse,
 		downloadThrottler: downloadThrottler,
 		uploadThrottler:   uploadThrottler,
 	}, nil
@@ -3,8 +3,11 @@
 import (
 	"os"
 	"path/filepath"
+	"time"
 )
 
+const defaultKeepaliveInterval = 30 * time.Second
+
 // Options defines options for sftp-backed storage.
 type Options struct {
 	Path string `json:"path"`
@@ -22,6 +25,32 @@ type Options struct {
 	SSHArguments string `json:"sshArguments,omitempty"`
 
 	DirectoryShards []int `json:"dirShards"`
+
+	// MaxConnections is the maximum number of concurrent SFTP connections, 1 by default.
+	MaxConnections int `json:"maxConnections,omitempty"`
+
+	// KeepaliveSeconds is the interval between keepalive requests sent to the server, 0 uses the default
+	// and negative values disable keepalives.
+	KeepaliveSeconds int `json:"keepaliveSeconds,omitempty"`
+}
+
+func (sftpo *Options) maxConnections() int {
+	if sftpo.MaxConnections <= 0 {
+		return 1
+	}
+
+	return sftpo.MaxConnections
+}
+
+func (sftpo *Options) keepaliveInterval() time.Duration {
+	switch {
+	case sftpo.KeepaliveSeconds < 0:
+		return 0
+	case sftpo.KeepaliveSeconds == 0:
+		return defaultKeepaliveInterval
+	default:
+		return time.Duration(sftpo.KeepaliveSeconds) * time.Second
+	}
 }
 
 func (sftpo *Options) shards() []int {
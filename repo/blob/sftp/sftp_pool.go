@@ -0,0 +1,253 @@
+package sftp
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/kopia/kopia/internal/retry"
+)
+
+// sftpConnection is a single SFTP session along with the SSH connection or process carrying it.
+type sftpConnection struct {
+	cli       *sftp.Client
+	closeFunc closeFunc
+
+	// done is closed when the connection is lost.
+	done chan struct{}
+}
+
+func newSFTPConnection(cli *sftp.Client, cf closeFunc) *sftpConnection {
+	c := &sftpConnection{
+		cli:       cli,
+		closeFunc: cf,
+		done:      make(chan struct{}),
+	}
+
+	go func() {
+		cli.Wait() //nolint:errcheck
+		close(c.done)
+	}()
+
+	return c
+}
+
+func (c *sftpConnection) isBroken() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *sftpConnection) close() error {
+	var err error
+
+	if c.cli != nil {
+		err = c.cli.Close()
+	}
+
+	if c.closeFunc != nil {
+		if cerr := c.closeFunc(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// connectionLostError wraps errors caused by a lost or failed connection, which are retried on a new connection.
+type connectionLostError struct {
+	err error
+}
+
+func (e connectionLostError) Error() string {
+	return "connection lost: " + e.err.Error()
+}
+
+func (e connectionLostError) Unwrap() error {
+	return e.err
+}
+
+func isConnectionLost(err error) bool {
+	var cle connectionLostError
+
+	return errors.As(err, &cle)
+}
+
+// isConnectionError determines whether the error returned by an SFTP operation indicates a broken connection.
+// Other errors, such as io.EOF returned when reading past the end of a file, are not retried. Connections lost
+// without a transport error are detected by the SFTP client terminating, see isBroken().
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) {
+		return true
+	}
+
+	var ne net.Error
+
+	return errors.As(err, &ne)
+}
+
+type connectFunc func(ctx context.Context) (*sftpConnection, error)
+
+// connectionPool maintains up to a fixed number of SFTP connections, which are established on demand
+// and re-established after they are lost.
+type connectionPool struct {
+	connect connectFunc
+
+	// slots holds one entry per allowed connection, nil when the connection has not been established.
+	slots chan *sftpConnection
+}
+
+// newConnectionPool creates a pool of up to the provided number of connections, optionally
+// starting with an already established connection.
+func newConnectionPool(maxConnections int, connect connectFunc, initial *sftpConnection) *connectionPool {
+	p := &connectionPool{
+		connect: connect,
+		slots:   make(chan *sftpConnection, maxConnections),
+	}
+
+	p.slots <- initial
+
+	for i := 1; i < maxConnections; i++ {
+		p.slots <- nil
+	}
+
+	return p
+}
+
+// acquire returns a connection for exclusive use by the caller, which must be returned using release().
+func (p *connectionPool) acquire(ctx context.Context) (*sftpConnection, error) {
+	var c *sftpConnection
+
+	select {
+	case c = <-p.slots:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if c != nil && !c.isBroken() {
+		return c, nil
+	}
+
+	if c != nil {
+		log(ctx).Debugf("reconnecting lost SFTP connection")
+		c.close() //nolint:errcheck
+	}
+
+	c, err := p.connect(ctx)
+	if err != nil {
+		p.slots <- nil
+
+		if isConnectionError(err) {
+			return nil, connectionLostError{err}
+		}
+
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// release returns the connection to the pool, discarding it if the provided error indicates it was lost.
+func (p *connectionPool) release(c *sftpConnection, err error) {
+	if c.isBroken() || isConnectionLost(err) {
+		c.close() //nolint:errcheck
+
+		c = nil
+	}
+
+	p.slots <- c
+}
+
+// withConnection invokes the provided function with a pooled connection. When the connection is lost
+// during the operation, it is retried on a new connection with exponential backoff.
+func (p *connectionPool) withConnection(ctx context.Context, desc string, fn func(cli *sftp.Client) error) error {
+	return retry.WithExponentialBackoffNoValue(ctx, desc, func() error {
+		c, err := p.acquire(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = fn(c.cli)
+		if err != nil && (c.isBroken() || isConnectionError(err)) {
+			err = connectionLostError{err}
+		}
+
+		p.release(c, err)
+
+		return err
+	}, func(err error) bool {
+		// operations are not retried after the caller gives up.
+		return ctx.Err() == nil && isConnectionLost(err)
+	})
+}
+
+// close closes all connections, waiting for connections in use to be released.
+func (p *connectionPool) close() error {
+	var lastErr error
+
+	for i := 0; i < cap(p.slots); i++ {
+		if c := <-p.slots; c != nil {
+			if err := c.close(); err != nil {
+				lastErr = err
+			}
+		}
+	}
+
+	return lastErr
+}
+
+// startKeepalive periodically sends keepalive requests over the provided SSH connection, closing it when
+// the server stops responding so that the connection is re-established. The returned function stops it.
+func startKeepalive(conn ssh.Conn, interval time.Duration) func() {
+	stop := make(chan struct{})
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+
+			case <-t.C:
+				replied := make(chan error, 1)
+
+				go func() {
+					_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+					replied <- err
+				}()
+
+				select {
+				case <-stop:
+					return
+
+				case err := <-replied:
+					if err == nil {
+						continue
+					}
+
+				case <-time.After(interval):
+				}
+
+				// the keepalive failed or the server did not respond in time.
+				conn.Close() //nolint:errcheck
+
+				return
+			}
+		}
+	}()
+
+	return func() { close(stop) }
+}
@@ -0,0 +1,205 @@
+package sftp
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+type fakeConnector struct {
+	connectCount int32
+
+	mu   sync.Mutex
+	last *sftpConnection
+}
+
+func (f *fakeConnector) connect(ctx context.Context) (*sftpConnection, error) {
+	atomic.AddInt32(&f.connectCount, 1)
+
+	c := &sftpConnection{done: make(chan struct{})}
+
+	f.mu.Lock()
+	f.last = c
+	f.mu.Unlock()
+
+	return c, nil
+}
+
+func TestConnectionPoolReconnectsLostConnection(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var fc fakeConnector
+
+	p := newConnectionPool(1, fc.connect, nil)
+
+	calls := 0
+
+	err := p.withConnection(ctx, "test", func(cli *sftp.Client) error {
+		calls++
+
+		if calls == 1 {
+			// simulate the connection being lost in the middle of the operation.
+			close(fc.last.done)
+
+			return io.EOF
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, want := calls, 2; got != want {
+		t.Errorf("unexpected number of attempts: %v, want %v", got, want)
+	}
+
+	if got, want := atomic.LoadInt32(&fc.connectCount), int32(2); got != want {
+		t.Errorf("unexpected number of connections: %v, want %v", got, want)
+	}
+}
+
+func TestConnectionPoolDoesNotRetryOtherErrors(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var fc fakeConnector
+
+	p := newConnectionPool(1, fc.connect, nil)
+
+	for i := 0; i < 3; i++ {
+		calls := 0
+
+		err := p.withConnection(ctx, "test", func(cli *sftp.Client) error {
+			calls++
+			return blob.ErrBlobNotFound
+		})
+		if !errors.Is(err, blob.ErrBlobNotFound) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if calls != 1 {
+			t.Fatalf("unexpected number of attempts: %v", calls)
+		}
+	}
+
+	// the connection is reused.
+	if got, want := atomic.LoadInt32(&fc.connectCount), int32(1); got != want {
+		t.Errorf("unexpected number of connections: %v, want %v", got, want)
+	}
+}
+
+func TestConnectionPoolDoesNotRetryEOFOnHealthyConnection(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var fc fakeConnector
+
+	p := newConnectionPool(1, fc.connect, nil)
+
+	calls := 0
+
+	// reading a truncated file returns EOF, which does not indicate the connection was lost.
+	err := p.withConnection(ctx, "test", func(cli *sftp.Client) error {
+		calls++
+		return errors.Wrap(io.ErrUnexpectedEOF, "unable to read")
+	})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if calls != 1 {
+		t.Fatalf("unexpected number of attempts: %v", calls)
+	}
+}
+
+func TestConnectionPoolDoesNotRetryAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(testlogging.Context(t))
+	defer cancel()
+
+	var fc fakeConnector
+
+	p := newConnectionPool(1, fc.connect, nil)
+
+	calls := 0
+
+	err := p.withConnection(ctx, "test", func(cli *sftp.Client) error {
+		calls++
+
+		cancel()
+
+		return &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset")}
+	})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+
+	if calls != 1 {
+		t.Fatalf("unexpected number of attempts: %v", calls)
+	}
+}
+
+func TestConnectionPoolLimitsConnections(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	const maxConnections = 3
+
+	var (
+		fc         fakeConnector
+		wg         sync.WaitGroup
+		active     int32
+		maxActive  int32
+		activeLock sync.Mutex
+	)
+
+	p := newConnectionPool(maxConnections, fc.connect, nil)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := p.withConnection(ctx, "test", func(cli *sftp.Client) error {
+				activeLock.Lock()
+				active++
+				if active > maxActive {
+					maxActive = active
+				}
+				activeLock.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				activeLock.Lock()
+				active--
+				activeLock.Unlock()
+
+				return nil
+			}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if maxActive > maxConnections {
+		t.Errorf("too many concurrent connections: %v", maxActive)
+	}
+
+	if got := atomic.LoadInt32(&fc.connectCount); got > maxConnections {
+		t.Errorf("too many connections established: %v", got)
+	}
+
+	if err := p.close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+}
@@ -30,6 +30,8 @@
 	fsStorageChunkSuffix = ".f"
 
 	packetSize = 1 << 15
+
+	sshConnectTimeout = 30 * time.Second
 )
 
 var sftpDefaultShards = []int{3, 3}
@@ -44,12 +46,25 @@ type sftpStorage struct {
 type sftpImpl struct {
 	Options
 
-	closeFunc func() error
-	cli       *sftp.Client
+	pool *connectionPool
 }
 
 func (s *sftpImpl) GetBlobFromPath(ctx context.Context, dirPath, fullPath string, offset, length int64) ([]byte, error) {
-	r, err := s.cli.Open(fullPath)
+	var result []byte
+
+	err := s.pool.withConnection(ctx, "GetBlob("+fullPath+")", func(cli *sftp.Client) error {
+		var err error
+
+		result, err = getBlobFromPath(cli, fullPath, offset, length)
+
+		return err
+	})
+
+	return result, err
+}
+
+func getBlobFromPath(cli *sftp.Client, fullPath string, offset, length int64) ([]byte, error) {
+	r, err := cli.Open(fullPath)
 	if isNotExist(err) {
 		return nil, blob.ErrBlobNotFound
 	}
@@ -84,7 +99,16 @@ func (s *sftpImpl) GetBlobFromPath(ctx context.Context, dirPath, fullPath string
 }
 
 func (s *sftpImpl) GetMetadataFromPath(ctx context.Context, dirPath, fullPath string) (blob.Metadata, error) {
-	fi, err := s.cli.Stat(fullPath)
+	var fi os.FileInfo
+
+	err := s.pool.withConnection(ctx, "GetMetadata("+fullPath+")", func(cli *sftp.Client) error {
+		var err error
+
+		fi, err = cli.Stat(fullPath)
+
+		return err
+	})
+
 	if isNotExist(err) {
 		return blob.Metadata{}, blob.ErrBlobNotFound
 	}
@@ -100,11 +124,6 @@ func (s *sftpImpl) GetMetadataFromPath(ctx context.Context, dirPath, fullPath st
 }
 
 func (s *sftpImpl) PutBlobInPath(ctx context.Context, dirPath, fullPath string, data blob.Bytes) error {
-	randSuffix := make([]byte, 8)
-	if _, err := rand.Read(randSuffix); err != nil {
-		return errors.Wrap(err, "can't get random bytes")
-	}
-
 	progressCallback := blob.ProgressCallback(ctx)
 	combinedLength := data.Length()
 
@@ -113,14 +132,26 @@ func (s *sftpImpl) PutBlobInPath(ctx context.Context, dirPath, fullPath string,
 		defer progressCallback(fullPath, int64(combinedLength), int64(combinedLength))
 	}
 
+	return s.pool.withConnection(ctx, "PutBlob("+fullPath+")", func(cli *sftp.Client) error {
+		return putBlobInPath(cli, fullPath, data)
+	})
+}
+
+func putBlobInPath(cli *sftp.Client, fullPath string, data blob.Bytes) error {
+	randSuffix := make([]byte, 8)
+	if _, err := rand.Read(randSuffix); err != nil {
+		return errors.Wrap(err, "can't get random bytes")
+	}
+
 	tempFile := fmt.Sprintf("%s.tmp.%x", fullPath, randSuffix)
 
-	f, err := s.createTempFileAndDir(tempFile)
+	f, err := createTempFileAndDir(cli, tempFile)
 	if err != nil {
 		return errors.Wrap(err, "cannot create temporary file")
 	}
 
 	if _, err = data.WriteTo(f); err != nil {
+		f.Close() //nolint:errcheck
 		return errors.Wrap(err, "can't write temporary file")
 	}
 
@@ -128,9 +159,9 @@ func (s *sftpImpl) PutBlobInPath(ctx context.Context, dirPath, fullPath string,
 		return errors.Wrap(err, "can't close temporary file")
 	}
 
-	err = s.cli.PosixRename(tempFile, fullPath)
+	err = cli.PosixRename(tempFile, fullPath)
 	if err != nil {
-		if removeErr := s.cli.Remove(tempFile); removeErr != nil {
+		if removeErr := cli.Remove(tempFile); removeErr != nil {
 			fmt.Printf("warning: can't remove temp file: %v", removeErr)
 		}
 
@@ -141,20 +172,22 @@ func (s *sftpImpl) PutBlobInPath(ctx context.Context, dirPath, fullPath string,
 }
 
 func (s *sftpImpl) SetTimeInPath(ctx context.Context, dirPath, fullPath string, n time.Time) error {
-	return s.cli.Chtimes(fullPath, n, n)
+	return s.pool.withConnection(ctx, "SetTime("+fullPath+")", func(cli *sftp.Client) error {
+		return cli.Chtimes(fullPath, n, n)
+	})
 }
 
-func (s *sftpImpl) createTempFileAndDir(tempFile string) (*sftp.File, error) {
+func createTempFileAndDir(cli *sftp.Client, tempFile string) (*sftp.File, error) {
 	flags := os.O_CREATE | os.O_WRONLY | os.O_EXCL
 
-	f, err := s.cli.OpenFile(tempFile, flags)
+	f, err := cli.OpenFile(tempFile, flags)
 	if isNotExist(err) {
 		parentDir := path.Dir(tempFile)
-		if err = s.cli.MkdirAll(parentDir); err != nil {
+		if err = cli.MkdirAll(parentDir); err != nil {
 			return nil, errors.Wrap(err, "cannot create directory")
 		}
 
-		return s.cli.OpenFile(tempFile, flags)
+		return cli.OpenFile(tempFile, flags)
 	}
 
 	return f, err
@@ -173,16 +206,28 @@ func isNotExist(err error) bool {
 }
 
 func (s *sftpImpl) DeleteBlobInPath(ctx context.Context, dirPath, fullPath string) error {
-	err := s.cli.Remove(fullPath)
-	if err == nil || isNotExist(err) {
-		return nil
-	}
+	return s.pool.withConnection(ctx, "DeleteBlob("+fullPath+")", func(cli *sftp.Client) error {
+		err := cli.Remove(fullPath)
+		if err == nil || isNotExist(err) {
+			return nil
+		}
 
-	return err
+		return err
+	})
 }
 
 func (s *sftpImpl) ReadDir(ctx context.Context, dirname string) ([]os.FileInfo, error) {
-	return s.cli.ReadDir(dirname)
+	var result []os.FileInfo
+
+	err := s.pool.withConnection(ctx, "ReadDir("+dirname+")", func(cli *sftp.Client) error {
+		var err error
+
+		result, err = cli.ReadDir(dirname)
+
+		return err
+	})
+
+	return result, err
 }
 
 func (s *sftpStorage) ConnectionInfo() blob.ConnectionInfo {
@@ -198,12 +243,8 @@ func (s *sftpStorage) DisplayName() string {
 }
 
 func (s *sftpStorage) Close(ctx context.Context) error {
-	if err := s.Impl.(*sftpImpl).cli.Close(); err != nil {
-		return errors.Wrap(err, "closing SFTP client")
-	}
-
-	if err := s.Impl.(*sftpImpl).closeFunc(); err != nil {
-		return errors.Wrap(err, "closing SFTP connection")
+	if err := s.Impl.(*sftpImpl).pool.close(); err != nil {
+		return errors.Wrap(err, "closing SFTP connections")
 	}
 
 	return nil
@@ -290,12 +331,17 @@ func createSSHConfig(ctx context.Context, opts *Options) (*ssh.ClientConfig, err
 			ssh.PublicKeys(signer),
 		},
 		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshConnectTimeout,
 	}, nil
 }
 
 func getSFTPClientExternal(ctx context.Context, opt *Options) (*sftp.Client, closeFunc, error) {
 	var cmdArgs []string
 
+	if ka := opt.keepaliveInterval(); ka > 0 {
+		cmdArgs = append(cmdArgs, "-o", fmt.Sprintf("ServerAliveInterval=%v", int(ka.Seconds())))
+	}
+
 	if opt.SSHArguments != "" {
 		cmdArgs = append(cmdArgs, strings.Split(opt.SSHArguments, " ")...)
 	}
@@ -376,32 +422,58 @@ func getSFTPClient(ctx context.Context, opt *Options) (*sftp.Client, closeFunc,
 		return nil, nil, errors.Wrapf(err, "unable to create sftp client")
 	}
 
+	if ka := opt.keepaliveInterval(); ka > 0 {
+		stopKeepalive := startKeepalive(conn, ka)
+
+		return c, func() error {
+			stopKeepalive()
+			return conn.Close()
+		}, nil
+	}
+
 	return c, conn.Close, nil
 }
 
+func connectSFTP(opt *Options) connectFunc {
+	return func(ctx context.Context) (*sftpConnection, error) {
+		c, closeFunc, err := getSFTPClient(ctx, opt)
+		if err != nil {
+			return nil, err
+		}
+
+		return newSFTPConnection(c, closeFunc), nil
+	}
+}
+
 // New creates new ssh-backed storage in a specified host.
 func New(ctx context.Context, opts *Options) (blob.Storage, error) {
-	c, closeFunc, err := getSFTPClient(ctx, opts)
+	connect := connectSFTP(opts)
+
+	// establish the first connection eagerly without retrying, to report configuration errors right away.
+	c, err := connect(ctx)
 	if err != nil {
 		return nil, errors.Wrapf(err, "unable to create sftp client")
 	}
 
-	if _, err = c.Stat(opts.Path); err != nil {
+	if _, err = c.cli.Stat(opts.Path); err != nil {
 		if isNotExist(err) {
-			if err = c.MkdirAll(opts.Path); err != nil {
+			if err = c.cli.MkdirAll(opts.Path); err != nil {
+				c.close() //nolint:errcheck
 				return nil, errors.Wrap(err, "cannot create path")
 			}
 		} else {
+			c.close() //nolint:errcheck
 			return nil, errors.Wrapf(err, "path doesn't exist: %s", opts.Path)
 		}
 	}
 
+	pool := newConnectionPool(opts.maxConnections(), connect, c)
+
 	r := &sftpStorage{
 		sharded.Storage{
 			Impl: &sftpImpl{
-				Options:   *opts,
-				cli:       c,
-				closeFunc: closeFunc,
+				Options: *opts,
+				pool:    pool,
 			},
 			RootPath: opts.Path,
 			Suffix:   fsStorageChunkSuffix,
@@ -0,0 +1,775 @@
+// Package staging implements write-back wrapper around Storage, which stages written blobs in a local
+// directory and uploads them to the underlying storage in the background, retrying failed uploads.
+// This allows writes to complete while connectivity to the storage is intermittent.
+//
+// Writes and deletions are journaled in the staging directory and applied to the underlying storage
+// in the order they were made. The journal survives crashes and is resumed the next time the storage
+// is opened.
+//
+// When staged prefixes are configured, only blobs with those prefixes (such as packs) are staged.
+// Other blobs (such as indexes, manifests and the format blob) are written directly to the underlying
+// storage after all previously staged writes have been applied, so that for example indexes never become
+// visible before the packs they reference and other clients see them without delay.
+//
+// The staging directory can be used by only one process at a time.
+package staging
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/ctxutil"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/logging"
+)
+
+var log = logging.GetContextLoggerFunc("kopia/staging")
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+
+	entrySuffix  = ".entry"
+	tmpPrefix    = ".tmp-"
+	lockFileName = ".lock"
+
+	initialRetryInterval    = time.Second
+	defaultMaxRetryInterval = 5 * time.Minute
+
+	// DefaultMaxPendingAge is the default maximum age of staged writes that blobs written directly may follow,
+	// which is well below the minimum age of contents subject to garbage collection.
+	DefaultMaxPendingAge = 12 * time.Hour
+
+	dirMode = 0o700
+)
+
+// ErrDirectoryInUse is returned when the staging directory is used by another process.
+var ErrDirectoryInUse = errors.New("staging directory is in use by another process")
+
+// ErrStagedWritesTooOld is returned when a blob would be written directly after staged writes that are older than
+// the maximum pending age. Such blobs (for example indexes) would make old contents visible to other clients, whose
+// garbage collection could remove them before the snapshot referencing them completes.
+var ErrStagedWritesTooOld = errors.New("staged writes are too old")
+
+// Options provides options for the staging wrapper.
+type Options struct {
+	// Directory where blobs are staged until they are uploaded.
+	Directory string `json:"directory"`
+
+	// MaxRetryInterval is the maximum time between attempts to upload a staged blob.
+	MaxRetryInterval time.Duration `json:"maxRetryInterval,omitempty"`
+
+	// MaxPendingAge is the maximum age of writes staged by the current process that blobs
+	// written directly are allowed to follow.
+	MaxPendingAge time.Duration `json:"maxPendingAge,omitempty"`
+
+	// StagedPrefixes are prefixes of blobs which are staged, other blobs are written directly.
+	// When empty, all blobs are staged.
+	StagedPrefixes []blob.ID `json:"-"`
+}
+
+// Status describes writes which have not been applied to the underlying storage yet.
+type Status struct {
+	PendingOperations int       `json:"pendingOperations"`
+	PendingBytes      int64     `json:"pendingBytes"`
+	LastError         string    `json:"lastError,omitempty"`
+	LastErrorTime     time.Time `json:"lastErrorTime,omitempty"`
+}
+
+// Stager allows inspecting and flushing staged writes.
+type Stager interface {
+	Status() Status
+
+	// Flush waits until all staged writes have been applied to the underlying storage.
+	Flush(ctx context.Context) error
+}
+
+// Storage is a blob.Storage which stages writes locally.
+type Storage interface {
+	blob.Storage
+	Stager
+}
+
+// entry is a single write or deletion journaled in the staging directory.
+type entry struct {
+	Op        string          `json:"op"`
+	BlobID    blob.ID         `json:"id"`
+	Length    int64           `json:"length,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+	Options   blob.PutOptions `json:"options"`
+
+	path       string
+	dataOffset int64 // offset of blob data in the entry file, following the header line
+	seq        uint64
+	resumed    bool // journaled by a previous process
+}
+
+func (e *entry) metadata() blob.Metadata {
+	return blob.Metadata{
+		BlobID:    e.BlobID,
+		Length:    e.Length,
+		Timestamp: e.Timestamp,
+	}
+}
+
+type stagingStorage struct {
+	base blob.Storage
+	opt  Options
+	lock *flock.Flock
+
+	mu            sync.Mutex
+	nextSeq       uint64
+	pending       []*entry           // journaled operations in the order they must be applied
+	latest        map[blob.ID]*entry // most recent pending operation for each blob
+	pendingBytes  int64
+	lastError     error
+	lastErrorTime time.Time
+	changed       chan struct{} // closed and replaced when pending operations change
+
+	cancelUpload context.CancelFunc
+	closing      chan struct{}
+	done         chan struct{}
+}
+
+func (s *stagingStorage) lookup(id blob.ID) *entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.latest[id]
+}
+
+func (s *stagingStorage) GetBlob(ctx context.Context, id blob.ID, offset, length int64) ([]byte, error) {
+	for {
+		e := s.lookup(id)
+		if e == nil {
+			return s.base.GetBlob(ctx, id, offset, length)
+		}
+
+		if e.Op == opDelete {
+			return nil, blob.ErrBlobNotFound
+		}
+
+		b, err := readEntryData(e, offset, length)
+		if os.IsNotExist(err) {
+			// the entry was uploaded and removed in the meantime, look again.
+			continue
+		}
+
+		return b, err
+	}
+}
+
+func (s *stagingStorage) GetMetadata(ctx context.Context, id blob.ID) (blob.Metadata, error) {
+	e := s.lookup(id)
+	if e == nil {
+		return s.base.GetMetadata(ctx, id)
+	}
+
+	if e.Op == opDelete {
+		return blob.Metadata{}, blob.ErrBlobNotFound
+	}
+
+	return e.metadata(), nil
+}
+
+func (s *stagingStorage) SetTime(ctx context.Context, id blob.ID, t time.Time) error {
+	e := s.lookup(id)
+	if e == nil {
+		return s.base.SetTime(ctx, id, t)
+	}
+
+	if e.Op == opDelete {
+		return blob.ErrBlobNotFound
+	}
+
+	// the blob will get a fresh timestamp when it's uploaded.
+	return blob.ErrSetTimeUnsupported
+}
+
+func (s *stagingStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	if !s.isStaged(id) {
+		if err := s.waitForStagedWrites(ctx); err != nil {
+			return errors.Wrapf(err, "unable to write %v", id)
+		}
+
+		return s.base.PutBlob(ctx, id, data, opts)
+	}
+
+	return s.journal(&entry{
+		Op:        opPut,
+		BlobID:    id,
+		Length:    int64(data.Length()),
+		Timestamp: clock.Now(),
+		Options:   opts,
+	}, data)
+}
+
+func (s *stagingStorage) DeleteBlob(ctx context.Context, id blob.ID) error {
+	if !s.isStaged(id) {
+		if err := s.waitForStagedWrites(ctx); err != nil {
+			return errors.Wrapf(err, "unable to delete %v", id)
+		}
+
+		return s.base.DeleteBlob(ctx, id)
+	}
+
+	return s.journal(&entry{
+		Op:        opDelete,
+		BlobID:    id,
+		Timestamp: clock.Now(),
+	}, nil)
+}
+
+func (s *stagingStorage) isStaged(id blob.ID) bool {
+	if len(s.opt.StagedPrefixes) == 0 {
+		return true
+	}
+
+	for _, p := range s.opt.StagedPrefixes {
+		if strings.HasPrefix(string(id), string(p)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// waitForStagedWrites waits until all operations staged so far have been applied to the underlying storage.
+func (s *stagingStorage) waitForStagedWrites(ctx context.Context) error {
+	s.mu.Lock()
+	target := s.nextSeq
+
+	for _, e := range s.pending {
+		if e.Op == opPut && !e.resumed && clock.Now().Sub(e.Timestamp) > s.opt.MaxPendingAge {
+			s.mu.Unlock()
+
+			return errors.Wrapf(ErrStagedWritesTooOld, "%v was staged at %v, retry after it has been uploaded", e.BlobID, e.Timestamp)
+		}
+	}
+	s.mu.Unlock()
+
+	for {
+		s.mu.Lock()
+		done := len(s.pending) == 0 || s.pending[0].seq >= target
+		lastErr, changed := s.lastError, s.changed
+		s.mu.Unlock()
+
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return errors.Wrapf(ctx.Err(), "staged operations were not uploaded, last error: %v", lastErr)
+			}
+
+			return errors.Wrap(ctx.Err(), "staged operations were not uploaded")
+
+		case <-changed:
+		}
+	}
+}
+
+func (s *stagingStorage) ListBlobs(ctx context.Context, prefix blob.ID, cb func(bm blob.Metadata) error) error {
+	s.mu.Lock()
+	staged := make(map[blob.ID]*entry, len(s.latest))
+
+	for id, e := range s.latest {
+		staged[id] = e
+	}
+	s.mu.Unlock()
+
+	if err := s.base.ListBlobs(ctx, prefix, func(bm blob.Metadata) error {
+		if staged[bm.BlobID] != nil {
+			return nil
+		}
+
+		return cb(bm)
+	}); err != nil {
+		return err
+	}
+
+	for id, e := range staged {
+		if e.Op != opPut || !strings.HasPrefix(string(id), string(prefix)) {
+			continue
+		}
+
+		if err := cb(e.metadata()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *stagingStorage) ConnectionInfo() blob.ConnectionInfo {
+	return s.base.ConnectionInfo()
+}
+
+func (s *stagingStorage) DisplayName() string {
+	return s.base.DisplayName()
+}
+
+func (s *stagingStorage) Close(ctx context.Context) error {
+	// abort the upload in progress, it will be retried the next time the storage is opened.
+	s.cancelUpload()
+	close(s.closing)
+	<-s.done
+
+	if n := s.pendingCount(); n > 0 {
+		log(ctx).Infof("%v staged operations will be uploaded the next time the repository is opened", n)
+	}
+
+	if err := s.lock.Unlock(); err != nil {
+		log(ctx).Warningf("unable to unlock staging directory: %v", err)
+	}
+
+	return s.base.Close(ctx)
+}
+
+func (s *stagingStorage) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := Status{
+		PendingOperations: len(s.pending),
+		PendingBytes:      s.pendingBytes,
+		LastErrorTime:     s.lastErrorTime,
+	}
+
+	if s.lastError != nil {
+		st.LastError = s.lastError.Error()
+	}
+
+	return st
+}
+
+func (s *stagingStorage) Flush(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		n, lastErr, changed := len(s.pending), s.lastError, s.changed
+		s.mu.Unlock()
+
+		if n == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return errors.Wrapf(ctx.Err(), "%v staged operations were not uploaded, last error: %v", n, lastErr)
+			}
+
+			return errors.Wrapf(ctx.Err(), "%v staged operations were not uploaded", n)
+
+		case <-changed:
+		}
+	}
+}
+
+func (s *stagingStorage) pendingCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.pending)
+}
+
+// journal durably writes the entry followed by blob data to the staging directory and schedules it for upload.
+func (s *stagingStorage) journal(e *entry, data blob.Bytes) error {
+	tmpPath, dataOffset, err := s.writeTempEntry(e, data)
+	if err != nil {
+		return err
+	}
+
+	e.dataOffset = dataOffset
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// sequence numbers are assigned when the entry is complete, so that operations
+	// are applied in the order they were completed.
+	e.seq = s.nextSeq
+	e.path = filepath.Join(s.opt.Directory, entryFileName(e.seq))
+
+	if err := os.Rename(tmpPath, e.path); err != nil {
+		os.Remove(tmpPath) //nolint:errcheck
+
+		return errors.Wrap(err, "unable to commit staged entry")
+	}
+
+	syncDir(s.opt.Directory)
+
+	s.nextSeq++
+	s.addPendingLocked(e)
+
+	return nil
+}
+
+func (s *stagingStorage) writeTempEntry(e *entry, data blob.Bytes) (tmpPath string, dataOffset int64, err error) {
+	header, err := json.Marshal(e)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "unable to serialize staged entry")
+	}
+
+	header = append(header, '\n')
+
+	f, err := ioutil.TempFile(s.opt.Directory, tmpPrefix)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "unable to create staged entry")
+	}
+
+	tmpPath = f.Name()
+
+	if err := writeAndSync(f, header, data); err != nil {
+		os.Remove(tmpPath) //nolint:errcheck
+
+		return "", 0, errors.Wrap(err, "unable to write staged entry")
+	}
+
+	return tmpPath, int64(len(header)), nil
+}
+
+func writeAndSync(f *os.File, header []byte, data blob.Bytes) error {
+	defer f.Close() //nolint:errcheck
+
+	if _, err := f.Write(header); err != nil {
+		return err
+	}
+
+	if data != nil {
+		if _, err := data.WriteTo(f); err != nil {
+			return err
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func (s *stagingStorage) addPendingLocked(e *entry) {
+	s.pending = append(s.pending, e)
+	s.latest[e.BlobID] = e
+	s.pendingBytes += e.Length
+	s.notifyLocked()
+}
+
+func (s *stagingStorage) notifyLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// head returns the oldest pending entry or nil and the channel which is closed when pending entries change.
+func (s *stagingStorage) head() (*entry, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) == 0 {
+		return nil, s.changed
+	}
+
+	return s.pending[0], s.changed
+}
+
+func (s *stagingStorage) completed(ctx context.Context, e *entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending = s.pending[1:]
+	s.pendingBytes -= e.Length
+	s.lastError = nil
+
+	if s.latest[e.BlobID] == e {
+		delete(s.latest, e.BlobID)
+	}
+
+	if err := os.Remove(e.path); err != nil {
+		log(ctx).Warningf("unable to remove staged entry %v: %v", e.path, err)
+	}
+
+	s.notifyLocked()
+}
+
+func (s *stagingStorage) failed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastError = err
+	s.lastErrorTime = clock.Now()
+	s.notifyLocked()
+}
+
+// upload applies journaled entries to the underlying storage one by one, retrying failures with exponential backoff.
+func (s *stagingStorage) upload(ctx context.Context) {
+	defer close(s.done)
+
+	minRetryInterval := initialRetryInterval
+	if minRetryInterval > s.opt.MaxRetryInterval {
+		minRetryInterval = s.opt.MaxRetryInterval
+	}
+
+	retryInterval := minRetryInterval
+
+	for {
+		e, changed := s.head()
+		if e == nil {
+			select {
+			case <-changed:
+				continue
+			case <-s.closing:
+				return
+			}
+		}
+
+		if err := s.apply(ctx, e); err != nil {
+			if ctx.Err() != nil {
+				// upload was aborted by Close().
+				return
+			}
+
+			log(ctx).Warningf("unable to upload staged %v of %v, retrying in %v: %v", e.Op, e.BlobID, retryInterval, err)
+			s.failed(err)
+
+			select {
+			case <-time.After(retryInterval):
+			case <-s.closing:
+				return
+			}
+
+			if retryInterval *= 2; retryInterval > s.opt.MaxRetryInterval {
+				retryInterval = s.opt.MaxRetryInterval
+			}
+
+			continue
+		}
+
+		retryInterval = minRetryInterval
+
+		s.completed(ctx, e)
+	}
+}
+
+func (s *stagingStorage) apply(ctx context.Context, e *entry) error {
+	if e.Op == opDelete {
+		if err := s.base.DeleteBlob(ctx, e.BlobID); err != nil && !errors.Is(err, blob.ErrBlobNotFound) {
+			return err
+		}
+
+		return nil
+	}
+
+	f, err := os.Open(e.path)
+	if err != nil {
+		return errors.Wrap(err, "unable to open staged entry")
+	}
+
+	defer f.Close() //nolint:errcheck
+
+	return s.base.PutBlob(ctx, e.BlobID, fileBytes{f, e.dataOffset, e.Length}, e.Options)
+}
+
+// fileBytes exposes blob data stored in a section of an entry file as blob.Bytes.
+type fileBytes struct {
+	f      *os.File
+	offset int64
+	length int64
+}
+
+func (b fileBytes) Length() int {
+	return int(b.length)
+}
+
+func (b fileBytes) Reader() io.Reader {
+	return io.NewSectionReader(b.f, b.offset, b.length)
+}
+
+func (b fileBytes) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, b.Reader())
+}
+
+func readEntryData(e *entry, offset, length int64) ([]byte, error) {
+	if length < 0 {
+		offset, length = 0, e.Length
+	}
+
+	if offset < 0 || offset+length > e.Length {
+		return nil, errors.Errorf("invalid offset %v and length %v for staged blob %v of size %v", offset, length, e.BlobID, e.Length)
+	}
+
+	f, err := os.Open(e.path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close() //nolint:errcheck
+
+	b := make([]byte, length)
+	if _, err := f.ReadAt(b, e.dataOffset+offset); err != nil && length > 0 {
+		return nil, errors.Wrap(err, "unable to read staged blob")
+	}
+
+	return b, nil
+}
+
+func entryFileName(seq uint64) string {
+	return strconv.FormatUint(seq, 16) + entrySuffix
+}
+
+// loadJournal reads entries left in the staging directory by previous processes, in the order they were journaled.
+func (s *stagingStorage) loadJournal(ctx context.Context) error {
+	files, err := ioutil.ReadDir(s.opt.Directory)
+	if err != nil {
+		return errors.Wrap(err, "unable to read staging directory")
+	}
+
+	var seqs []uint64
+
+	for _, fi := range files {
+		name := fi.Name()
+
+		if strings.HasPrefix(name, tmpPrefix) {
+			// incomplete entries of a process that crashed before committing them.
+			os.Remove(filepath.Join(s.opt.Directory, name)) //nolint:errcheck
+			continue
+		}
+
+		if !strings.HasSuffix(name, entrySuffix) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, entrySuffix), 16, 64)
+		if err != nil {
+			continue
+		}
+
+		seqs = append(seqs, seq)
+	}
+
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+
+	for _, seq := range seqs {
+		e, err := readEntryHeader(filepath.Join(s.opt.Directory, entryFileName(seq)))
+		if err != nil {
+			return err
+		}
+
+		e.seq = seq
+		e.resumed = true
+
+		s.addPendingLocked(e)
+		s.nextSeq = seq + 1
+	}
+
+	if len(seqs) > 0 {
+		log(ctx).Infof("resuming upload of %v staged operations", len(seqs))
+	}
+
+	return nil
+}
+
+func readEntryHeader(path string) (*entry, error) {
+	f, err := os.Open(path) //nolint:gosec
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open staged entry")
+	}
+
+	defer f.Close() //nolint:errcheck
+
+	header, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read header of staged entry %v", path)
+	}
+
+	e := &entry{}
+	if err := json.Unmarshal(header, e); err != nil {
+		return nil, errors.Wrapf(err, "invalid header of staged entry %v", path)
+	}
+
+	e.path = path
+	e.dataOffset = int64(len(header))
+
+	return e, nil
+}
+
+// syncDir makes renames in the directory durable, which is not supported on all platforms.
+func syncDir(dir string) {
+	f, err := os.Open(dir) //nolint:gosec
+	if err != nil {
+		return
+	}
+
+	f.Sync()  //nolint:errcheck
+	f.Close() //nolint:errcheck
+}
+
+// NewWrapper returns a Storage wrapper that stages writes in the directory specified in the options
+// and uploads them to the underlying storage in the background.
+func NewWrapper(ctx context.Context, base blob.Storage, opt Options) (Storage, error) {
+	if opt.Directory == "" {
+		return nil, errors.New("staging directory not specified")
+	}
+
+	if opt.MaxRetryInterval == 0 {
+		opt.MaxRetryInterval = defaultMaxRetryInterval
+	}
+
+	if opt.MaxPendingAge == 0 {
+		opt.MaxPendingAge = DefaultMaxPendingAge
+	}
+
+	if err := os.MkdirAll(opt.Directory, dirMode); err != nil {
+		return nil, errors.Wrap(err, "unable to create staging directory")
+	}
+
+	l := flock.New(filepath.Join(opt.Directory, lockFileName))
+
+	ok, err := l.TryLock()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to lock staging directory")
+	}
+
+	if !ok {
+		return nil, ErrDirectoryInUse
+	}
+
+	s := &stagingStorage{
+		base:    base,
+		opt:     opt,
+		lock:    l,
+		latest:  map[blob.ID]*entry{},
+		changed: make(chan struct{}),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if err := s.loadJournal(ctx); err != nil {
+		l.Unlock() //nolint:errcheck
+		return nil, err
+	}
+
+	uploadCtx, cancel := context.WithCancel(ctxutil.Detach(ctx))
+	s.cancelUpload = cancel
+
+	go s.upload(uploadCtx)
+
+	return s, nil
+}
+
+var _ Storage = (*stagingStorage)(nil)
@@ -0,0 +1,201 @@
+package staging_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/staging"
+)
+
+var errOffline = errors.New("storage is offline")
+
+// offlineStorage fails all writes while it's offline.
+type offlineStorage struct {
+	blob.Storage
+
+	offline int32
+}
+
+func (s *offlineStorage) setOffline(v bool) {
+	if v {
+		atomic.StoreInt32(&s.offline, 1)
+	} else {
+		atomic.StoreInt32(&s.offline, 0)
+	}
+}
+
+func (s *offlineStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	if atomic.LoadInt32(&s.offline) != 0 {
+		return errOffline
+	}
+
+	return s.Storage.PutBlob(ctx, id, data, opts)
+}
+
+func (s *offlineStorage) DeleteBlob(ctx context.Context, id blob.ID) error {
+	if atomic.LoadInt32(&s.offline) != 0 {
+		return errOffline
+	}
+
+	return s.Storage.DeleteBlob(ctx, id)
+}
+
+func TestStagingStorage(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	data := blobtesting.DataMap{}
+	st, err := staging.NewWrapper(ctx, blobtesting.NewMapStorage(data, nil, nil), staging.Options{Directory: t.TempDir()})
+	mustNoError(t, err)
+
+	defer st.Close(ctx)
+
+	blobtesting.VerifyStorage(ctx, t, st)
+	mustNoError(t, st.Flush(ctx))
+
+	if got := st.Status().PendingOperations; got != 0 {
+		t.Fatalf("unexpected pending operations after flush: %v", got)
+	}
+}
+
+func TestStagingStorageOffline(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	data := blobtesting.DataMap{}
+	base := &offlineStorage{Storage: blobtesting.NewMapStorage(data, nil, nil)}
+	dir := t.TempDir()
+	opt := staging.Options{Directory: dir, MaxRetryInterval: 50 * time.Millisecond}
+
+	mustNoError(t, base.PutBlob(ctx, "p0", gather.FromSlice([]byte{1}), blob.PutOptions{}))
+	base.setOffline(true)
+
+	st, err := staging.NewWrapper(ctx, base, opt)
+	mustNoError(t, err)
+
+	if _, err = staging.NewWrapper(ctx, base, opt); !errors.Is(err, staging.ErrDirectoryInUse) {
+		t.Fatalf("unexpected error when opening staging directory in use: %v", err)
+	}
+
+	mustNoError(t, st.PutBlob(ctx, "p1", gather.FromSlice([]byte{2, 3, 4, 5}), blob.PutOptions{}))
+	mustNoError(t, st.PutBlob(ctx, "n1", gather.FromSlice([]byte{5}), blob.PutOptions{}))
+	mustNoError(t, st.DeleteBlob(ctx, "p0"))
+
+	// staged writes are visible immediately, but not applied to the underlying storage.
+	blobtesting.AssertGetBlob(ctx, t, st, "p1", []byte{2, 3, 4, 5})
+	blobtesting.AssertGetBlobNotFound(ctx, t, st, "p0")
+	blobtesting.AssertListResults(ctx, t, st, "", "n1", "p1")
+	blobtesting.AssertListResults(ctx, t, base, "", "p0")
+
+	shortCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+
+	if err = st.Flush(shortCtx); err == nil {
+		t.Fatalf("unexpected successful flush while offline")
+	}
+
+	if s := st.Status(); s.PendingOperations != 3 || s.PendingBytes != 5 || s.LastError == "" {
+		t.Fatalf("unexpected status: %+v", s)
+	}
+
+	// pending operations survive reopening.
+	mustNoError(t, st.Close(ctx))
+
+	base.setOffline(false)
+
+	st, err = staging.NewWrapper(ctx, base, opt)
+	mustNoError(t, err)
+
+	defer st.Close(ctx)
+
+	mustNoError(t, st.Flush(ctx))
+
+	blobtesting.AssertListResults(ctx, t, base, "", "n1", "p1")
+	blobtesting.AssertGetBlob(ctx, t, base, "p1", []byte{2, 3, 4, 5})
+}
+
+func TestStagingStorageDirectWrites(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	data := blobtesting.DataMap{}
+	base := &offlineStorage{Storage: blobtesting.NewMapStorage(data, nil, nil)}
+	opt := staging.Options{
+		Directory:        t.TempDir(),
+		MaxRetryInterval: 50 * time.Millisecond,
+		StagedPrefixes:   []blob.ID{"p"},
+	}
+
+	base.setOffline(true)
+
+	st, err := staging.NewWrapper(ctx, base, opt)
+	mustNoError(t, err)
+
+	defer st.Close(ctx)
+
+	mustNoError(t, st.PutBlob(ctx, "p1", gather.FromSlice([]byte{1, 2}), blob.PutOptions{}))
+
+	// blobs which are not staged are only written after staged blobs have been uploaded.
+	shortCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+
+	if err = st.PutBlob(shortCtx, "n1", gather.FromSlice([]byte{3}), blob.PutOptions{}); err == nil {
+		t.Fatalf("unexpected successful write while staged blobs are pending")
+	}
+
+	base.setOffline(false)
+
+	mustNoError(t, st.PutBlob(ctx, "n1", gather.FromSlice([]byte{3}), blob.PutOptions{}))
+
+	blobtesting.AssertListResults(ctx, t, base, "", "n1", "p1")
+
+	if got := st.Status().PendingOperations; got != 0 {
+		t.Fatalf("unexpected pending operations: %v", got)
+	}
+}
+
+func TestStagingStorageStaleWrites(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	data := blobtesting.DataMap{}
+	base := &offlineStorage{Storage: blobtesting.NewMapStorage(data, nil, nil)}
+	opt := staging.Options{
+		Directory:        t.TempDir(),
+		MaxRetryInterval: 50 * time.Millisecond,
+		MaxPendingAge:    10 * time.Millisecond,
+		StagedPrefixes:   []blob.ID{"p"},
+	}
+
+	base.setOffline(true)
+
+	st, err := staging.NewWrapper(ctx, base, opt)
+	mustNoError(t, err)
+
+	defer st.Close(ctx)
+
+	mustNoError(t, st.PutBlob(ctx, "p1", gather.FromSlice([]byte{1, 2}), blob.PutOptions{}))
+
+	time.Sleep(50 * time.Millisecond)
+
+	// indexes referencing contents staged long ago are refused.
+	if err = st.PutBlob(ctx, "n1", gather.FromSlice([]byte{3}), blob.PutOptions{}); !errors.Is(err, staging.ErrStagedWritesTooOld) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	base.setOffline(false)
+	mustNoError(t, st.Flush(ctx))
+
+	mustNoError(t, st.PutBlob(ctx, "n1", gather.FromSlice([]byte{3}), blob.PutOptions{}))
+}
+
+func mustNoError(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
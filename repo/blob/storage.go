@@ -1,7 +1,9 @@
 package blob
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"io"
 	"sync"
@@ -13,6 +15,56 @@
 // ErrSetTimeUnsupported is returned by implementations of Storage that don't support SetTime.
 var ErrSetTimeUnsupported = errors.Errorf("SetTime is not supported")
 
+// ErrUnsupportedPutBlobOption is returned by implementations of Storage that don't support the provided PutOptions.
+var ErrUnsupportedPutBlobOption = errors.New("unsupported put-blob option")
+
+// ErrBlobAlreadyExists is returned by ConditionalPutter when the blob already exists.
+var ErrBlobAlreadyExists = errors.New("blob already exists")
+
+// Supported retention modes.
+const (
+	// RetentionModeGovernance prevents deletion of blobs before the retention period expires,
+	// except by users with special permissions.
+	RetentionModeGovernance = "GOVERNANCE"
+
+	// RetentionModeCompliance prevents deletion of blobs by any user, including the root account,
+	// before the retention period expires.
+	RetentionModeCompliance = "COMPLIANCE"
+)
+
+// PutOptions represents options for writing a single blob to a storage.
+type PutOptions struct {
+	// RetentionMode and RetentionPeriod request the blob to be locked against deletion and overwrites
+	// for the specified duration after it's written.
+	RetentionMode   string
+	RetentionPeriod time.Duration
+
+	// LegalHold requests the blob to be locked against deletion until the hold is explicitly removed.
+	LegalHold bool
+}
+
+// HasRetentionOptions returns true when the options request the blob to be locked.
+func (o PutOptions) HasRetentionOptions() bool {
+	return o.RetentionMode != "" || o.RetentionPeriod != 0 || o.LegalHold
+}
+
+// ValidateRetentionMode returns an error if the provided retention mode is not supported.
+func ValidateRetentionMode(mode string) error {
+	switch mode {
+	case "", RetentionModeGovernance, RetentionModeCompliance:
+		return nil
+	default:
+		return errors.Errorf("unsupported retention mode %q", mode)
+	}
+}
+
+// ConditionalPutter is implemented by storage which can write blobs only if they don't exist yet.
+type ConditionalPutter interface {
+	// PutBlobIfNotExists uploads the blob unless a blob with the provided ID already exists,
+	// in which case the existing blob is left intact and ErrBlobAlreadyExists is returned.
+	PutBlobIfNotExists(ctx context.Context, blobID ID, data Bytes, opts PutOptions) error
+}
+
 // Bytes encapsulates a sequence of bytes, possibly stored in a non-contiguous buffers,
 // which can be written sequentially or treated as a io.Reader.
 type Bytes interface {
@@ -36,7 +88,8 @@ type Bytes interface {
 type Storage interface {
 	// PutBlob uploads the blob with given data to the repository or replaces existing blob with the provided
 	// id with contents gathered from the specified list of slices.
-	PutBlob(ctx context.Context, blobID ID, data Bytes) error
+	// Implementations which can't honor the provided options must return ErrUnsupportedPutBlobOption.
+	PutBlob(ctx context.Context, blobID ID, data Bytes, opts PutOptions) error
 
 	// SetTime changes last modification time of a given blob, if supported, returns ErrSetTimeUnsupported otherwise.
 	SetTime(ctx context.Context, blobID ID, t time.Time) error
@@ -75,6 +128,9 @@ type Metadata struct {
 	BlobID    ID        `json:"id"`
 	Length    int64     `json:"length"`
 	Timestamp time.Time `json:"timestamp"`
+
+	// Cold is true for blobs stored in an archive tier, which must be rehydrated before they can be read.
+	Cold bool `json:"cold,omitempty"`
 }
 
 func (m *Metadata) String() string {
@@ -85,6 +141,38 @@ func (m *Metadata) String() string {
 // ErrBlobNotFound is returned when a BLOB cannot be found in storage.
 var ErrBlobNotFound = errors.New("BLOB not found")
 
+// ErrBlobArchived is returned when reading a BLOB stored in an archive tier which has not been rehydrated.
+// Storage providers that support rehydration initiate it before returning this error.
+var ErrBlobArchived = errors.New("BLOB is archived")
+
+// ErrWriteVerificationFailed is returned by PutBlobAndVerify when the blob read back after upload
+// does not match the data that was written.
+var ErrWriteVerificationFailed = errors.New("blob write verification failed")
+
+// PutBlobAndVerify uploads the blob and immediately reads it back, comparing hashes of the data written
+// and read, which protects against storage backends that acknowledge writes they then lose.
+func PutBlobAndVerify(ctx context.Context, st Storage, blobID ID, data Bytes, opts PutOptions) error {
+	if err := st.PutBlob(ctx, blobID, data, opts); err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	if _, err := data.WriteTo(h); err != nil {
+		return errors.Wrap(err, "unable to hash blob data")
+	}
+
+	readBack, err := st.GetBlob(ctx, blobID, 0, -1)
+	if err != nil {
+		return errors.Wrapf(ErrWriteVerificationFailed, "unable to read back %v: %v", blobID, err)
+	}
+
+	if actual := sha256.Sum256(readBack); !bytes.Equal(actual[:], h.Sum(nil)) {
+		return errors.Wrapf(ErrWriteVerificationFailed, "%v does not match data written (wrote %v bytes, read %v)", blobID, data.Length(), len(readBack))
+	}
+
+	return nil
+}
+
 // ListAllBlobs returns Metadata for all blobs in a given storage that have the provided name prefix.
 func ListAllBlobs(ctx context.Context, st Storage, prefix ID) ([]Metadata, error) {
 	var result []Metadata
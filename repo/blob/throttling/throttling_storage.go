@@ -0,0 +1,275 @@
+// Package throttling implements wrapper around Storage that limits upload and download bandwidth.
+// Unlike throttling options of individual storage providers, limits can be changed at any time and
+// apply to subsequent transfers, which allows long-running snapshots to follow bandwidth schedules.
+package throttling
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// Limits describes maximum upload and download speed, zero means unlimited.
+type Limits struct {
+	UploadBytesPerSecond   int64 `json:"uploadBytesPerSecond,omitempty"`
+	DownloadBytesPerSecond int64 `json:"downloadBytesPerSecond,omitempty"`
+}
+
+// Throttler allows inspecting and changing bandwidth limits.
+type Throttler interface {
+	// Limits returns the limits set using SetLimits.
+	Limits() Limits
+	SetLimits(l Limits)
+
+	// EffectiveLimits returns the limits currently applied to transfers, which are the lowest
+	// of the limits set using SetLimits and all added limits.
+	EffectiveLimits() Limits
+
+	// AddLimits applies additional limits until they are removed.
+	AddLimits(l Limits) *AddedLimits
+}
+
+// AddedLimits are applied in addition to the limits of the throttler until they are removed, which allows
+// concurrent operations, such as snapshots of different sources, to each apply their own limits.
+type AddedLimits struct {
+	s      *throttlingStorage
+	limits Limits
+}
+
+// Limits returns the added limits.
+func (a *AddedLimits) Limits() Limits {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	return a.limits
+}
+
+// Set changes the added limits.
+func (a *AddedLimits) Set(l Limits) {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	a.limits = l
+	a.s.updateLocked()
+}
+
+// Remove stops applying the added limits.
+func (a *AddedLimits) Remove() {
+	a.s.mu.Lock()
+	defer a.s.mu.Unlock()
+
+	delete(a.s.added, a)
+	a.s.updateLocked()
+}
+
+// Storage is a blob.Storage with adjustable bandwidth limits.
+type Storage interface {
+	blob.Storage
+	Throttler
+}
+
+// bandwidthLimiter paces transfers so that their average speed doesn't exceed the limit.
+type bandwidthLimiter struct {
+	mu             sync.Mutex
+	bytesPerSecond int64
+	next           time.Time     // time when the next transfer may start
+	changed        chan struct{} // closed and replaced when the limit changes
+}
+
+func newBandwidthLimiter() *bandwidthLimiter {
+	return &bandwidthLimiter{changed: make(chan struct{})}
+}
+
+func (l *bandwidthLimiter) limit() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.bytesPerSecond
+}
+
+func (l *bandwidthLimiter) setLimit(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.bytesPerSecond == bytesPerSecond {
+		return
+	}
+
+	l.bytesPerSecond = bytesPerSecond
+	l.next = time.Time{}
+
+	// wake up pending transfers, so that they are paced according to the new limit.
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// wait blocks until the transfer of the provided number of bytes can start.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int64) error {
+	for {
+		l.mu.Lock()
+
+		if l.bytesPerSecond <= 0 {
+			l.mu.Unlock()
+			return nil
+		}
+
+		now := clock.Now()
+
+		start := l.next
+		if start.Before(now) {
+			start = now
+		}
+
+		l.next = start.Add(time.Duration(float64(n) / float64(l.bytesPerSecond) * float64(time.Second)))
+		changed := l.changed
+
+		l.mu.Unlock()
+
+		delay := start.Sub(now)
+		if delay <= 0 {
+			return nil
+		}
+
+		t := time.NewTimer(delay)
+
+		select {
+		case <-t.C:
+			return nil
+
+		case <-changed:
+			t.Stop()
+
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+type throttlingStorage struct {
+	base     blob.Storage
+	upload   *bandwidthLimiter
+	download *bandwidthLimiter
+
+	mu     sync.Mutex
+	limits Limits
+	added  map[*AddedLimits]bool
+}
+
+func (s *throttlingStorage) GetBlob(ctx context.Context, id blob.ID, offset, length int64) ([]byte, error) {
+	result, err := s.base.GetBlob(ctx, id, offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	// the length of full blobs is not known upfront, so downloads delay subsequent transfers instead.
+	if err := s.download.wait(ctx, int64(len(result))); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *throttlingStorage) GetMetadata(ctx context.Context, id blob.ID) (blob.Metadata, error) {
+	return s.base.GetMetadata(ctx, id)
+}
+
+func (s *throttlingStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	if err := s.upload.wait(ctx, int64(data.Length())); err != nil {
+		return err
+	}
+
+	return s.base.PutBlob(ctx, id, data, opts)
+}
+
+func (s *throttlingStorage) SetTime(ctx context.Context, id blob.ID, t time.Time) error {
+	return s.base.SetTime(ctx, id, t)
+}
+
+func (s *throttlingStorage) DeleteBlob(ctx context.Context, id blob.ID) error {
+	return s.base.DeleteBlob(ctx, id)
+}
+
+func (s *throttlingStorage) ListBlobs(ctx context.Context, prefix blob.ID, callback func(blob.Metadata) error) error {
+	return s.base.ListBlobs(ctx, prefix, callback)
+}
+
+func (s *throttlingStorage) Close(ctx context.Context) error {
+	return s.base.Close(ctx)
+}
+
+func (s *throttlingStorage) ConnectionInfo() blob.ConnectionInfo {
+	return s.base.ConnectionInfo()
+}
+
+func (s *throttlingStorage) DisplayName() string {
+	return s.base.DisplayName()
+}
+
+func (s *throttlingStorage) Limits() Limits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.limits
+}
+
+func (s *throttlingStorage) SetLimits(l Limits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limits = l
+	s.updateLocked()
+}
+
+func (s *throttlingStorage) EffectiveLimits() Limits {
+	return Limits{
+		UploadBytesPerSecond:   s.upload.limit(),
+		DownloadBytesPerSecond: s.download.limit(),
+	}
+}
+
+func (s *throttlingStorage) AddLimits(l Limits) *AddedLimits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := &AddedLimits{s: s, limits: l}
+	s.added[a] = true
+	s.updateLocked()
+
+	return a
+}
+
+// updateLocked applies the lowest of all limits to transfers.
+func (s *throttlingStorage) updateLocked() {
+	eff := s.limits
+
+	for a := range s.added {
+		eff.UploadBytesPerSecond = lowerLimit(eff.UploadBytesPerSecond, a.limits.UploadBytesPerSecond)
+		eff.DownloadBytesPerSecond = lowerLimit(eff.DownloadBytesPerSecond, a.limits.DownloadBytesPerSecond)
+	}
+
+	s.upload.setLimit(eff.UploadBytesPerSecond)
+	s.download.setLimit(eff.DownloadBytesPerSecond)
+}
+
+// lowerLimit returns the lower of two limits, where zero means unlimited.
+func lowerLimit(a, b int64) int64 {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+
+	return a
+}
+
+// NewWrapper returns a Storage wrapper that limits bandwidth of uploads and downloads, initially unlimited.
+func NewWrapper(wrapped blob.Storage) Storage {
+	return &throttlingStorage{
+		base:     wrapped,
+		upload:   newBandwidthLimiter(),
+		download: newBandwidthLimiter(),
+		added:    map[*AddedLimits]bool{},
+	}
+}
@@ -0,0 +1,138 @@
+package throttling
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func TestThrottlingStorage(t *testing.T) {
+	data := blobtesting.DataMap{}
+	kt := map[blob.ID]time.Time{}
+	st := NewWrapper(blobtesting.NewMapStorage(data, kt, nil))
+
+	ctx := testlogging.Context(t)
+	blobtesting.VerifyStorage(ctx, t, st)
+
+	if got, want := st.Limits(), (Limits{}); got != want {
+		t.Fatalf("unexpected initial limits: %v", got)
+	}
+
+	st.SetLimits(Limits{UploadBytesPerSecond: 100000, DownloadBytesPerSecond: 100000})
+
+	payload := make([]byte, 10000)
+
+	t0 := time.Now()
+
+	for i := 0; i < 5; i++ {
+		if err := st.PutBlob(ctx, "someblob", gather.FromSlice(payload), blob.PutOptions{}); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// the first transfer starts immediately, each subsequent one waits 100ms.
+	if dt := time.Since(t0); dt < 300*time.Millisecond {
+		t.Fatalf("uploads were not throttled: %v", dt)
+	}
+
+	t0 = time.Now()
+
+	for i := 0; i < 5; i++ {
+		if _, err := st.GetBlob(ctx, "someblob", 0, -1); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	if dt := time.Since(t0); dt < 300*time.Millisecond {
+		t.Fatalf("downloads were not throttled: %v", dt)
+	}
+}
+
+func TestThrottlingStorageLimitChange(t *testing.T) {
+	st := NewWrapper(blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil))
+	ctx := testlogging.Context(t)
+
+	// with 1 byte per second, the second upload would wait for hours.
+	st.SetLimits(Limits{UploadBytesPerSecond: 1})
+
+	if err := st.PutBlob(ctx, "someblob", gather.FromSlice(make([]byte, 10000)), blob.PutOptions{}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- st.PutBlob(ctx, "someblob", gather.FromSlice([]byte{1}), blob.PutOptions{})
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// lifting the limit releases pending transfers.
+	st.SetLimits(Limits{})
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatalf("pending upload was not released")
+	}
+
+	// canceled transfers return promptly.
+	st.SetLimits(Limits{UploadBytesPerSecond: 1})
+
+	if err := st.PutBlob(ctx, "someblob", gather.FromSlice(make([]byte, 10000)), blob.PutOptions{}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	if err := st.PutBlob(cctx, "someblob", gather.FromSlice([]byte{1}), blob.PutOptions{}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestThrottlingStorageAddedLimits(t *testing.T) {
+	st := NewWrapper(blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil))
+
+	st.SetLimits(Limits{UploadBytesPerSecond: 1000, DownloadBytesPerSecond: 2000})
+
+	a1 := st.AddLimits(Limits{UploadBytesPerSecond: 500})
+	a2 := st.AddLimits(Limits{UploadBytesPerSecond: 700, DownloadBytesPerSecond: 5000})
+
+	// the lowest of all limits applies, zero means unlimited.
+	if got, want := st.EffectiveLimits(), (Limits{UploadBytesPerSecond: 500, DownloadBytesPerSecond: 2000}); got != want {
+		t.Fatalf("unexpected effective limits: %v, want %v", got, want)
+	}
+
+	if got, want := st.Limits(), (Limits{UploadBytesPerSecond: 1000, DownloadBytesPerSecond: 2000}); got != want {
+		t.Fatalf("added limits changed configured limits: %v", got)
+	}
+
+	a1.Remove()
+
+	if got, want := st.EffectiveLimits(), (Limits{UploadBytesPerSecond: 700, DownloadBytesPerSecond: 2000}); got != want {
+		t.Fatalf("unexpected effective limits: %v, want %v", got, want)
+	}
+
+	st.SetLimits(Limits{})
+	a2.Set(Limits{DownloadBytesPerSecond: 3000})
+
+	if got, want := st.EffectiveLimits(), (Limits{DownloadBytesPerSecond: 3000}); got != want {
+		t.Fatalf("unexpected effective limits: %v, want %v", got, want)
+	}
+
+	a2.Remove()
+
+	if got, want := st.EffectiveLimits(), (Limits{}); got != want {
+		t.Fatalf("unexpected effective limits: %v, want %v", got, want)
+	}
+}
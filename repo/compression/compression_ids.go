@@ -22,4 +22,7 @@
 	headerPgzipDefault         HeaderID = 0x1300
 	headerPgzipBestSpeed       HeaderID = 0x1301
 	headerPgzipBestCompression HeaderID = 0x1302
+
+	// HeaderZstdDictionary is the header of data compressed by zstd using one of the dictionaries of the repository.
+	HeaderZstdDictionary HeaderID = 0x1400
 )
@@ -41,6 +41,37 @@ func RegisterCompressor(name Name, c Compressor) {
 	ByName[name] = c
 }
 
+// MinPluginHeaderID is the lowest header ID of compressors provided by plugins. The range is reserved for plugins,
+// so that their IDs never collide with the built-in compressors.
+const MinPluginHeaderID HeaderID = 0x80000000
+
+// IsPluginHeaderID returns true if the provided header ID belongs to a compressor provided by a plugin.
+func IsPluginHeaderID(id HeaderID) bool {
+	return id >= MinPluginHeaderID
+}
+
+// RegisterPluginCompressor registers compressor provided by a plugin at runtime. Unlike RegisterCompressor
+// it returns an error on conflicts, since those are caused by configuration and not programming errors.
+// It must be called before repositories are opened.
+func RegisterPluginCompressor(name Name, c Compressor) error {
+	if !IsPluginHeaderID(c.HeaderID()) {
+		return errors.Errorf("plugin compressor %q uses HeaderID %x outside of the range reserved for plugins", name, c.HeaderID())
+	}
+
+	if ByHeaderID[c.HeaderID()] != nil {
+		return errors.Errorf("compressor with HeaderID %x already registered", c.HeaderID())
+	}
+
+	if ByName[name] != nil {
+		return errors.Errorf("compressor with name %q already registered", name)
+	}
+
+	ByHeaderID[c.HeaderID()] = c
+	ByName[name] = c
+
+	return nil
+}
+
 func compressionHeader(id HeaderID) []byte {
 	b := make([]byte, 4)
 	binary.BigEndian.PutUint32(b, uint32(id))
@@ -78,3 +78,40 @@ func TestCompressor(t *testing.T) {
 		})
 	}
 }
+
+type fakePluginCompressor struct {
+	id HeaderID
+}
+
+func (c fakePluginCompressor) HeaderID() HeaderID                                  { return c.id }
+func (c fakePluginCompressor) Compress(output *bytes.Buffer, input []byte) error   { return nil }
+func (c fakePluginCompressor) Decompress(output *bytes.Buffer, input []byte) error { return nil }
+
+func TestRegisterPluginCompressor(t *testing.T) {
+	const name Name = "test-plugin"
+
+	if err := RegisterPluginCompressor(name, fakePluginCompressor{headerGzipDefault}); err == nil {
+		t.Errorf("unexpected success registering plugin compressor with built-in HeaderID")
+	}
+
+	if err := RegisterPluginCompressor("gzip", fakePluginCompressor{MinPluginHeaderID}); err == nil {
+		t.Errorf("unexpected success registering plugin compressor with built-in name")
+	}
+
+	if err := RegisterPluginCompressor(name, fakePluginCompressor{MinPluginHeaderID}); err != nil {
+		t.Fatalf("unable to register plugin compressor: %v", err)
+	}
+
+	defer func() {
+		delete(ByName, name)
+		delete(ByHeaderID, MinPluginHeaderID)
+	}()
+
+	if err := RegisterPluginCompressor("other-plugin", fakePluginCompressor{MinPluginHeaderID}); err == nil {
+		t.Errorf("unexpected success registering plugin compressor with duplicate HeaderID")
+	}
+
+	if !IsPluginHeaderID(ByName[name].HeaderID()) {
+		t.Errorf("plugin compressor not registered")
+	}
+}
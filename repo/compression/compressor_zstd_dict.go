@@ -0,0 +1,91 @@
+package compression
+
+import (
+	"bytes"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// ZstdDictionaryName is the name of the compressor which uses zstd with the dictionaries trained on
+// the repository data. Unlike other compressors it's not registered globally, because dictionaries are
+// stored in the repository format and the compressor is created when the repository is opened.
+const ZstdDictionaryName Name = "zstd-dictionary"
+
+// ZstdDictionary is a zstd dictionary in the format described in
+// https://github.com/facebook/zstd/blob/master/doc/zstd_compression_format.md#dictionary-format
+type ZstdDictionary struct {
+	ID   uint32 `json:"id"`
+	Data []byte `json:"data"`
+}
+
+// NewZstdDictionaryCompressor returns compressor which compresses using the last of the provided dictionaries
+// and decompresses data compressed using any of them. The ID of the dictionary is stored in each zstd frame.
+func NewZstdDictionaryCompressor(dicts []ZstdDictionary) (Compressor, error) {
+	if len(dicts) == 0 {
+		return nil, errors.Errorf("no dictionaries provided")
+	}
+
+	var all [][]byte
+
+	for _, d := range dicts {
+		all = append(all, d.Data)
+	}
+
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderDict(dicts[len(dicts)-1].Data), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid compression dictionary")
+	}
+
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderDicts(all...))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid compression dictionary")
+	}
+
+	return &zstdDictionaryCompressor{compressionHeader(HeaderZstdDictionary), enc, dec}, nil
+}
+
+type zstdDictionaryCompressor struct {
+	header []byte
+	enc    *zstd.Encoder
+	dec    *zstd.Decoder
+}
+
+func (c *zstdDictionaryCompressor) HeaderID() HeaderID {
+	return HeaderZstdDictionary
+}
+
+func (c *zstdDictionaryCompressor) Compress(output *bytes.Buffer, input []byte) error {
+	if _, err := output.Write(c.header); err != nil {
+		return errors.Wrap(err, "unable to write header")
+	}
+
+	// EncodeAll is safe for concurrent use.
+	if _, err := output.Write(c.enc.EncodeAll(input, nil)); err != nil {
+		return errors.Wrap(err, "unable to write compressed data")
+	}
+
+	return nil
+}
+
+func (c *zstdDictionaryCompressor) Decompress(output *bytes.Buffer, input []byte) error {
+	if len(input) < compressionHeaderSize {
+		return errors.Errorf("invalid compression header")
+	}
+
+	if !bytes.Equal(input[0:compressionHeaderSize], c.header) {
+		return errors.Errorf("invalid compression header")
+	}
+
+	// DecodeAll is safe for concurrent use.
+	b, err := c.dec.DecodeAll(input[compressionHeaderSize:], nil)
+	if err != nil {
+		return errors.Wrap(err, "decompression error")
+	}
+
+	if _, err := output.Write(b); err != nil {
+		return errors.Wrap(err, "unable to write decompressed data")
+	}
+
+	return nil
+}
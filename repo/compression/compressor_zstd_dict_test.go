@@ -0,0 +1,113 @@
+package compression
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// jsonSamples returns small JSON documents similar to directory listings, which have a lot of structure
+// in common but are too small to compress well on their own.
+func jsonSamples(seed int64, n int) [][]byte {
+	rnd := rand.New(rand.NewSource(seed))
+
+	var samples [][]byte
+
+	for i := 0; i < n; i++ {
+		var buf bytes.Buffer
+
+		buf.WriteString(`{"stream":"kopia:directory","entries":[`)
+
+		for j := 0; j < 1+rnd.Intn(3); j++ {
+			if j > 0 {
+				buf.WriteString(",")
+			}
+
+			fmt.Fprintf(&buf, `{"name":"file-%x.txt","type":"f","mode":"0644","mtime":"2020-01-%02dT10:%02d:00Z","uid":1000,"gid":1000,"obj":"%032x","size":%v}`,
+				rnd.Int63(), 1+rnd.Intn(28), rnd.Intn(60), rnd.Int63(), rnd.Intn(100000))
+		}
+
+		buf.WriteString(`],"summary":{"size":0,"files":0,"symlinks":0,"dirs":0,"maxTime":"2020-01-01T00:00:00Z","numFailed":0}}`)
+
+		samples = append(samples, buf.Bytes())
+	}
+
+	return samples
+}
+
+func compressedSize(t *testing.T, comp Compressor, samples [][]byte) int {
+	t.Helper()
+
+	total := 0
+
+	for _, s := range samples {
+		var cData, dData bytes.Buffer
+
+		require.NoError(t, comp.Compress(&cData, s))
+		require.NoError(t, comp.Decompress(&dData, cData.Bytes()))
+		require.True(t, bytes.Equal(s, dData.Bytes()))
+
+		total += cData.Len()
+	}
+
+	return total
+}
+
+func TestZstdDictionaryCompressor(t *testing.T) {
+	dict, err := TrainZstdDictionary(jsonSamples(1, 2000), 16<<10)
+	require.NoError(t, err)
+	require.LessOrEqual(t, len(dict.Data), 16<<10)
+
+	comp, err := NewZstdDictionaryCompressor([]ZstdDictionary{dict})
+	require.NoError(t, err)
+	require.Equal(t, HeaderZstdDictionary, comp.HeaderID())
+
+	testSamples := jsonSamples(2, 100)
+
+	withDict := compressedSize(t, comp, testSamples)
+	withoutDict := compressedSize(t, ByName["zstd"], testSamples)
+
+	t.Logf("compressed size with dictionary: %v, without: %v", withDict, withoutDict)
+	require.Less(t, withDict, withoutDict*3/4)
+
+	// data compressed using old dictionary can be decompressed after a new one is added.
+	dict2, err := TrainZstdDictionary(jsonSamples(3, 2000), 16<<10)
+	require.NoError(t, err)
+
+	var cData, dData bytes.Buffer
+
+	require.NoError(t, comp.Compress(&cData, testSamples[0]))
+
+	comp2, err := NewZstdDictionaryCompressor([]ZstdDictionary{dict, dict2})
+	require.NoError(t, err)
+	require.NoError(t, comp2.Decompress(&dData, cData.Bytes()))
+	require.Equal(t, testSamples[0], dData.Bytes())
+
+	// but not without it.
+	comp3, err := NewZstdDictionaryCompressor([]ZstdDictionary{dict2})
+	require.NoError(t, err)
+	require.Error(t, comp3.Decompress(&dData, cData.Bytes()))
+
+	// random and empty data round-trip too.
+	random := make([]byte, 10000)
+	rand.Read(random)
+
+	compressedSize(t, comp, [][]byte{random, {}})
+}
+
+func TestTrainZstdDictionaryErrors(t *testing.T) {
+	_, err := TrainZstdDictionary(jsonSamples(1, 100), 100)
+	require.Error(t, err)
+
+	random := make([][]byte, 100)
+	for i := range random {
+		random[i] = make([]byte, 1000)
+		rand.Read(random[i])
+	}
+
+	_, err = TrainZstdDictionary(random, 16<<10)
+	require.Error(t, err)
+}
@@ -0,0 +1,179 @@
+// Package plugin implements compressors provided by external plugin processes, such as drivers
+// of hardware compression offload boards, which are registered at runtime.
+//
+// Plugins are executables that call Serve() from their main() function with compressors they provide.
+// Each compressor must use a stable HeaderID at or above compression.MinPluginHeaderID and, like built-in
+// compressors, write the 4-byte big-endian HeaderID at the beginning of the compressed data.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/pluginproc"
+	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/repo/logging"
+)
+
+// The plugin is started with MagicCookieKey=MagicCookieValue in its environment, which lets it
+// detect being launched by kopia.
+const (
+	MagicCookieKey   = "KOPIA_COMPRESSION_PLUGIN_MAGIC_COOKIE"
+	MagicCookieValue = "3c9b71e4-compression-plugin"
+
+	// ProtocolVersion is incremented on incompatible changes to the RPC protocol.
+	ProtocolVersion = 1
+
+	startupTimeout = 15 * time.Second
+)
+
+var log = logging.GetContextLoggerFunc("compression-plugin")
+
+var protocol = pluginproc.Protocol{
+	MagicCookieKey:   MagicCookieKey,
+	MagicCookieValue: MagicCookieValue,
+	HandshakePrefix:  "KOPIA-COMPRESSION-PLUGIN|",
+	Version:          ProtocolVersion,
+	ServiceName:      "Plugin",
+}
+
+// Info describes a single compressor provided by a plugin.
+type Info struct {
+	Name     compression.Name
+	HeaderID compression.HeaderID
+}
+
+// Request is a request to compress or decompress data using a given compressor.
+type Request struct {
+	HeaderID compression.HeaderID
+	Data     []byte
+}
+
+// Empty is used for requests without data.
+type Empty struct{}
+
+// service exposes compressors over net/rpc.
+type service struct {
+	compressors map[compression.Name]compression.Compressor
+}
+
+func (s *service) byHeaderID(id compression.HeaderID) (compression.Compressor, error) {
+	for _, c := range s.compressors {
+		if c.HeaderID() == id {
+			return c, nil
+		}
+	}
+
+	return nil, errors.Errorf("unsupported compressor %x", id)
+}
+
+func (s *service) List(_ Empty, resp *[]Info) error {
+	for name, c := range s.compressors {
+		*resp = append(*resp, Info{name, c.HeaderID()})
+	}
+
+	return nil
+}
+
+func (s *service) Compress(req *Request, resp *[]byte) error {
+	c, err := s.byHeaderID(req.HeaderID)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := c.Compress(&buf, req.Data); err != nil {
+		return err
+	}
+
+	*resp = buf.Bytes()
+
+	return nil
+}
+
+func (s *service) Decompress(req *Request, resp *[]byte) error {
+	c, err := s.byHeaderID(req.HeaderID)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := c.Decompress(&buf, req.Data); err != nil {
+		return err
+	}
+
+	*resp = buf.Bytes()
+
+	return nil
+}
+
+// Serve implements the plugin side of the protocol and should be called from main() of the plugin.
+// It returns when kopia disconnects from the plugin.
+func Serve(compressors map[compression.Name]compression.Compressor) error {
+	return pluginproc.Serve(protocol, &service{compressors})
+}
+
+// pluginCompressor implements compression.Compressor by calling the plugin.
+type pluginCompressor struct {
+	client   *pluginproc.Client
+	headerID compression.HeaderID
+}
+
+func (c *pluginCompressor) HeaderID() compression.HeaderID {
+	return c.headerID
+}
+
+func (c *pluginCompressor) call(method string, output *bytes.Buffer, input []byte) error {
+	var resp []byte
+
+	if err := c.client.Call(context.Background(), method, &Request{c.headerID, input}, &resp); err != nil {
+		return errors.Wrapf(err, "plugin compressor %x failed", c.headerID)
+	}
+
+	output.Write(resp)
+
+	return nil
+}
+
+func (c *pluginCompressor) Compress(output *bytes.Buffer, input []byte) error {
+	return c.call("Compress", output, input)
+}
+
+func (c *pluginCompressor) Decompress(output *bytes.Buffer, input []byte) error {
+	return c.call("Decompress", output, input)
+}
+
+// Load starts the provided plugin executable and registers all compressors it provides.
+// The plugin keeps running until the process exits.
+func Load(ctx context.Context, executable string, args []string) ([]Info, error) {
+	client, err := pluginproc.Start(ctx, protocol, executable, args, nil, startupTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to start compression plugin %v", executable)
+	}
+
+	var infos []Info
+
+	if err := client.Call(ctx, "List", Empty{}, &infos); err != nil {
+		client.Close()
+		return nil, errors.Wrapf(err, "unable to list compressors of plugin %v", executable)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	for _, inf := range infos {
+		if err := compression.RegisterPluginCompressor(inf.Name, &pluginCompressor{client, inf.HeaderID}); err != nil {
+			client.Close()
+			return nil, errors.Wrapf(err, "unable to register compressor of plugin %v", executable)
+		}
+
+		log(ctx).Debugf("registered compressor %v (%x) provided by %v", inf.Name, inf.HeaderID, executable)
+	}
+
+	return infos, nil
+}
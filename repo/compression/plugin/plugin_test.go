@@ -0,0 +1,123 @@
+package plugin_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/repo/compression/plugin"
+)
+
+const testHeaderID compression.HeaderID = compression.MinPluginHeaderID + 1
+
+// reheadedCompressor uses another compressor while identifying the data with its own HeaderID.
+type reheadedCompressor struct {
+	inner    compression.Compressor
+	headerID compression.HeaderID
+}
+
+func (c *reheadedCompressor) HeaderID() compression.HeaderID {
+	return c.headerID
+}
+
+func (c *reheadedCompressor) Compress(output *bytes.Buffer, input []byte) error {
+	var tmp bytes.Buffer
+
+	if err := c.inner.Compress(&tmp, input); err != nil {
+		return err
+	}
+
+	b := tmp.Bytes()
+	binary.BigEndian.PutUint32(b, uint32(c.headerID))
+	output.Write(b)
+
+	return nil
+}
+
+func (c *reheadedCompressor) Decompress(output *bytes.Buffer, input []byte) error {
+	if id, err := compression.IDFromHeader(input); err != nil || id != c.headerID {
+		return fmt.Errorf("invalid compression header")
+	}
+
+	b := append([]byte(nil), input...)
+	binary.BigEndian.PutUint32(b, uint32(c.inner.HeaderID()))
+
+	return c.inner.Decompress(output, b)
+}
+
+// TestMain makes the test binary act as a plugin providing a single compressor when launched by Load().
+func TestMain(m *testing.M) {
+	if os.Getenv(plugin.MagicCookieKey) != "" {
+		if err := plugin.Serve(map[compression.Name]compression.Compressor{
+			"test-plugin": &reheadedCompressor{compression.ByName["gzip"], testHeaderID},
+		}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestPluginCompressor(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	infos, err := plugin.Load(ctx, os.Args[0], nil)
+	if err != nil {
+		t.Fatalf("unable to load plugin: %v", err)
+	}
+
+	if got, want := infos, []plugin.Info{{Name: "test-plugin", HeaderID: testHeaderID}}; len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("unexpected compressors: %v, want %v", got, want)
+	}
+
+	c := compression.ByHeaderID[testHeaderID]
+	if c == nil || compression.ByName["test-plugin"] != c {
+		t.Fatalf("compressor not registered")
+	}
+
+	data := bytes.Repeat([]byte("hello world "), 1000)
+
+	var compressed bytes.Buffer
+	if err := c.Compress(&compressed, data); err != nil {
+		t.Fatalf("compression error: %v", err)
+	}
+
+	if id, err := compression.IDFromHeader(compressed.Bytes()); err != nil || id != testHeaderID {
+		t.Fatalf("unexpected header: %v %v", id, err)
+	}
+
+	if compressed.Len() >= len(data) {
+		t.Errorf("compression not effective")
+	}
+
+	var decompressed bytes.Buffer
+	if err := c.Decompress(&decompressed, compressed.Bytes()); err != nil {
+		t.Fatalf("decompression error: %v", err)
+	}
+
+	if !bytes.Equal(decompressed.Bytes(), data) {
+		t.Errorf("invalid decompressed data")
+	}
+
+	if err := c.Decompress(&decompressed, []byte{0, 0, 0x10, 0}); err == nil {
+		t.Errorf("unexpected success decompressing invalid data")
+	}
+
+	// loading the same plugin again conflicts with already registered compressors.
+	if _, err := plugin.Load(ctx, os.Args[0], nil); err == nil {
+		t.Errorf("unexpected success loading plugin twice")
+	}
+}
+
+func TestPluginNotExecutedDirectly(t *testing.T) {
+	if err := plugin.Serve(nil); err == nil {
+		t.Fatalf("unexpected success")
+	}
+}
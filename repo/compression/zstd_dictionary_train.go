@@ -0,0 +1,406 @@
+package compression
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sort"
+
+	"github.com/klauspost/compress/huff0"
+	"github.com/pkg/errors"
+)
+
+const (
+	// dictSegmentSize is the size of segments of samples copied into the dictionary content.
+	dictSegmentSize = 64
+
+	// dictDmerSize is the size of substrings whose frequency determines the value of segments.
+	dictDmerSize = 8
+
+	// dictFrequencyTableBits is the log2 of the number of buckets counting frequencies of dmers.
+	dictFrequencyTableBits = 22
+
+	// minDictContentSize is the minimum size of useful dictionary content.
+	minDictContentSize = 256
+
+	// MinZstdDictionarySize is the minimum size of a trained dictionary.
+	MinZstdDictionarySize = 4096
+
+	// zstd reserves dictionary IDs below 32768 and above 2^31 for registered dictionaries.
+	minUserDictionaryID = 1 << 15
+	maxUserDictionaryID = 1<<31 - 1
+
+	fseMinTableLog = 5
+)
+
+// zstd magic number of dictionaries.
+var zstdDictionaryMagic = []byte{0x37, 0xa4, 0x30, 0xec}
+
+// default distributions of literal lengths, match lengths and offsets as defined by zstd format.
+var (
+	zstdDefaultLiteralLengthNorm = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	zstdDefaultMatchLengthNorm = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	zstdDefaultOffsetNorm = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+)
+
+const (
+	zstdDefaultLiteralLengthTableLog = 6
+	zstdDefaultMatchLengthTableLog   = 6
+	zstdDefaultOffsetTableLog        = 5
+)
+
+// TrainZstdDictionary builds zstd dictionary of at most maxSize bytes from the provided samples, which should
+// be representative of small contents stored in the repository, such as JSON metadata or source files.
+//
+// The content of the dictionary is selected similarly to the COVER algorithm of the reference zstd
+// implementation: samples are split into epochs and the segment containing the most substrings common
+// to multiple samples is selected from each epoch. Entropy tables use the literal distribution of samples
+// and the default distributions of sequences.
+func TrainZstdDictionary(samples [][]byte, maxSize int) (ZstdDictionary, error) {
+	if maxSize < MinZstdDictionarySize {
+		return ZstdDictionary{}, errors.Errorf("dictionary size must be at least %v", MinZstdDictionarySize)
+	}
+
+	id, err := randomDictionaryID()
+	if err != nil {
+		return ZstdDictionary{}, err
+	}
+
+	header, err := zstdDictionaryHeader(id, samples)
+	if err != nil {
+		return ZstdDictionary{}, err
+	}
+
+	content := selectDictionaryContent(samples, maxSize-len(header))
+	if len(content) < minDictContentSize {
+		return ZstdDictionary{}, errors.Errorf("samples don't have enough data in common to build a dictionary")
+	}
+
+	return ZstdDictionary{
+		ID:   id,
+		Data: append(header, content...),
+	}, nil
+}
+
+func randomDictionaryID() (uint32, error) {
+	var b [4]byte
+
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, errors.Wrap(err, "unable to generate dictionary ID")
+	}
+
+	return minUserDictionaryID + binary.LittleEndian.Uint32(b[:])%(maxUserDictionaryID-minUserDictionaryID), nil
+}
+
+// zstdDictionaryHeader returns dictionary magic, ID, entropy tables and repeat offsets.
+func zstdDictionaryHeader(id uint32, samples [][]byte) ([]byte, error) {
+	lits, err := literalsTable(samples)
+	if err != nil {
+		return nil, err
+	}
+
+	h := append([]byte(nil), zstdDictionaryMagic...)
+	h = append(h, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(h[4:], id)
+	h = append(h, lits...)
+	h = append(h, fseTableDescription(zstdDefaultOffsetNorm, zstdDefaultOffsetTableLog)...)
+	h = append(h, fseTableDescription(zstdDefaultMatchLengthNorm, zstdDefaultMatchLengthTableLog)...)
+	h = append(h, fseTableDescription(zstdDefaultLiteralLengthNorm, zstdDefaultLiteralLengthTableLog)...)
+
+	// default repeat offsets, which must not exceed the size of the content.
+	for _, o := range []uint32{1, 4, 8} {
+		var b [4]byte
+
+		binary.LittleEndian.PutUint32(b[:], o)
+		h = append(h, b[:]...)
+	}
+
+	return h, nil
+}
+
+// literalsTable returns Huffman table describing the distribution of bytes in samples. Every byte value
+// is included in the table, because the encoder must be able to use the table for any input.
+func literalsTable(samples [][]byte) ([]byte, error) {
+	in := make([]byte, 0, huff0.BlockSizeMax)
+
+	for i := 0; i < 256; i++ {
+		in = append(in, byte(i))
+	}
+
+	for _, s := range samples {
+		if n := huff0.BlockSizeMax - len(in); len(s) > n {
+			in = append(in, s[0:n]...)
+			break
+		}
+
+		in = append(in, s...)
+	}
+
+	var sc huff0.Scratch
+
+	if _, _, err := huff0.Compress1X(in, &sc); err == nil {
+		return append([]byte(nil), sc.OutTable...), nil
+	}
+
+	// samples are incompressible, use skewed distribution, since any valid table will do.
+	in = in[0:256]
+
+	for i := 0; i < 16384; i++ {
+		in = append(in, 0)
+	}
+
+	sc = huff0.Scratch{}
+
+	if _, _, err := huff0.Compress1X(in, &sc); err != nil {
+		return nil, errors.Wrap(err, "unable to build literals table")
+	}
+
+	return append([]byte(nil), sc.OutTable...), nil
+}
+
+// fseTableDescription encodes normalized FSE distribution as described in
+// https://github.com/facebook/zstd/blob/master/doc/zstd_compression_format.md#fse-table-description
+func fseTableDescription(norm []int16, tableLog uint) []byte {
+	var (
+		tableSize = 1 << tableLog
+		previous0 bool
+		charnum   int
+		out       []byte
+
+		bitStream = uint32(tableLog - fseMinTableLog)
+		bitCount  = uint(4)
+		remaining = int16(tableSize + 1) // +1 for extra accuracy
+		threshold = int16(tableSize)
+		nbBits    = tableLog + 1
+	)
+
+	flush := func() {
+		if bitCount > 16 {
+			out = append(out, byte(bitStream), byte(bitStream>>8))
+			bitStream >>= 16
+			bitCount -= 16
+		}
+	}
+
+	for remaining > 1 {
+		if previous0 {
+			start := charnum
+			for norm[charnum] == 0 {
+				charnum++
+			}
+
+			for charnum >= start+24 {
+				start += 24
+				bitStream += uint32(0xFFFF) << bitCount
+				out = append(out, byte(bitStream), byte(bitStream>>8))
+				bitStream >>= 16
+			}
+
+			for charnum >= start+3 {
+				start += 3
+				bitStream += 3 << bitCount
+				bitCount += 2
+			}
+
+			bitStream += uint32(charnum-start) << bitCount
+			bitCount += 2
+
+			flush()
+		}
+
+		count := norm[charnum]
+		charnum++
+		max := (2*threshold - 1) - remaining
+
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+
+		count++ // +1 for extra accuracy
+		if count >= threshold {
+			count += max
+		}
+
+		bitStream += uint32(count) << bitCount
+		bitCount += nbBits
+
+		if count < max {
+			bitCount--
+		}
+
+		previous0 = count == 1
+
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+
+		flush()
+	}
+
+	out = append(out, byte(bitStream), byte(bitStream>>8))
+
+	return out[0 : len(out)-2+int((bitCount+7)/8)]
+}
+
+type dictSegment struct {
+	data  []byte
+	score int
+}
+
+// selectDictionaryContent selects segments of samples containing substrings common to many samples,
+// ordered so that the most valuable segments are at the end of the content, closest to the compressed data.
+func selectDictionaryContent(samples [][]byte, maxContentSize int) []byte {
+	freq := make([]uint32, 1<<dictFrequencyTableBits)
+	lastSample := make([]int32, 1<<dictFrequencyTableBits)
+
+	// count number of samples containing each dmer.
+	for i, s := range samples {
+		for p := 0; p+dictDmerSize <= len(s); p++ {
+			h := dmerHash(s[p:])
+			if lastSample[h] != int32(i+1) {
+				lastSample[h] = int32(i + 1)
+				freq[h]++
+			}
+		}
+	}
+
+	score := func(h uint32) int {
+		// dmers present in a single sample are not worth including.
+		if freq[h] < 2 { // nolint:gomnd
+			return 0
+		}
+
+		return int(freq[h])
+	}
+
+	var total int
+
+	for _, s := range samples {
+		total += len(s)
+	}
+
+	numSegments := maxContentSize / dictSegmentSize
+	if numSegments == 0 || total == 0 {
+		return nil
+	}
+
+	epochSize := total / numSegments
+	if epochSize < dictSegmentSize {
+		epochSize = dictSegmentSize
+	}
+
+	var (
+		segments []dictSegment
+		epoch    []sampleRange
+		epochLen int
+	)
+
+	selectFromEpoch := func() {
+		// segments whose dmers are mostly unique to a single sample are not worth including.
+		if seg := bestSegment(epoch, score); seg.score >= dictSegmentSize-dictDmerSize+1 {
+			for p := 0; p+dictDmerSize <= len(seg.data); p++ {
+				freq[dmerHash(seg.data[p:])] = 0
+			}
+
+			segments = append(segments, seg)
+		}
+
+		epoch = nil
+		epochLen = 0
+	}
+
+	for _, s := range samples {
+		for len(s) > 0 {
+			n := epochSize - epochLen
+			if n > len(s) {
+				n = len(s)
+			}
+
+			epoch = append(epoch, s[0:n])
+			epochLen += n
+			s = s[n:]
+
+			if epochLen >= epochSize {
+				selectFromEpoch()
+			}
+		}
+	}
+
+	if epochLen > 0 {
+		selectFromEpoch()
+	}
+
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].score < segments[j].score
+	})
+
+	var content []byte
+
+	for _, seg := range segments {
+		content = append(content, seg.data...)
+	}
+
+	if len(content) > maxContentSize {
+		content = content[len(content)-maxContentSize:]
+	}
+
+	return content
+}
+
+// sampleRange is a part of a single sample.
+type sampleRange []byte
+
+// bestSegment returns the segment of the provided ranges with the highest total score of its dmers.
+func bestSegment(ranges []sampleRange, score func(h uint32) int) dictSegment {
+	var best dictSegment
+
+	for _, r := range ranges {
+		if len(r) < dictSegmentSize {
+			continue
+		}
+
+		// sliding window of dmers starting in [p, p+dictSegmentSize-dictDmerSize].
+		const dmersPerSegment = dictSegmentSize - dictDmerSize + 1
+
+		sum := 0
+
+		for p := 0; p < dmersPerSegment; p++ {
+			sum += score(dmerHash(r[p:]))
+		}
+
+		for p := 0; ; p++ {
+			if sum > best.score {
+				best = dictSegment{r[p : p+dictSegmentSize], sum}
+			}
+
+			if p+dictSegmentSize >= len(r) {
+				break
+			}
+
+			sum -= score(dmerHash(r[p:]))
+			sum += score(dmerHash(r[p+dmersPerSegment:]))
+		}
+	}
+
+	return best
+}
+
+func dmerHash(b []byte) uint32 {
+	const prime = 0xCF1BBCDCB7A56463
+
+	return uint32((binary.LittleEndian.Uint64(b) * prime) >> (64 - dictFrequencyTableBits))
+}
@@ -8,35 +8,90 @@
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 
 	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/namespace"
+	"github.com/kopia/kopia/repo/blob/staging"
 	"github.com/kopia/kopia/repo/content"
 )
 
 // ConnectOptions specifies options when persisting configuration to connect to a repository.
 type ConnectOptions struct {
 	PersistCredentials bool `json:"persistCredentials"`
+
+	// Prefetch warms up local caches in the background after connecting, so that the first operations are fast.
+	Prefetch bool `json:"prefetch,omitempty"`
 	ClientOptions
 
 	content.CachingOptions
+
+	// Staging enables staging of blob writes in a local directory, which are uploaded in the background.
+	Staging *staging.Options `json:"staging,omitempty"`
 }
 
 // ErrRepositoryNotInitialized is returned when attempting to connect to repository that has not
 // been initialized.
 var ErrRepositoryNotInitialized = errors.Errorf("repository not initialized in the provided storage")
 
+// ErrNamespaceMismatch is returned when the repository was created in a different storage namespace
+// than the one used to connect to it.
+var ErrNamespaceMismatch = errors.Errorf("repository namespace mismatch")
+
+// verifyNamespace ensures that the repository is accessed using the namespace it was created in.
+func verifyNamespace(st blob.Storage, f *formatBlob) error {
+	if actual := namespace.Name(st); actual != f.Namespace {
+		return errors.Wrapf(ErrNamespaceMismatch, "repository was created in namespace %q, but is accessed in namespace %q", f.Namespace, actual)
+	}
+
+	return nil
+}
+
+// notInitializedError returns ErrRepositoryNotInitialized, naming namespaces of other repositories
+// found in the storage, since they're not visible without the right namespace.
+func notInitializedError(ctx context.Context, st blob.Storage) error {
+	names, err := namespace.Available(ctx, st)
+	if err != nil {
+		log(ctx).Debugf("unable to list namespaces: %v", err)
+
+		return ErrRepositoryNotInitialized
+	}
+
+	if actual := namespace.Name(st); actual != "" {
+		names = withoutString(names, actual)
+	}
+
+	if len(names) == 0 {
+		return ErrRepositoryNotInitialized
+	}
+
+	return errors.Wrapf(ErrRepositoryNotInitialized, "storage contains repositories in namespaces: %v (use --namespace to select one)", strings.Join(names, ", "))
+}
+
+func withoutString(s []string, v string) []string {
+	var result []string
+
+	for _, it := range s {
+		if it != v {
+			result = append(result, it)
+		}
+	}
+
+	return result
+}
+
 // Connect connects to the repository in the specified storage and persists the configuration and credentials in the file provided.
 func Connect(ctx context.Context, configFile string, st blob.Storage, password string, opt *ConnectOptions) error {
 	if opt == nil {
 		opt = &ConnectOptions{}
 	}
 
-	formatBytes, err := st.GetBlob(ctx, FormatBlobID, 0, -1)
+	formatBytes, err := readFormatBlobBytes(ctx, st)
 	if err != nil {
 		if errors.Is(err, blob.ErrBlobNotFound) {
-			return ErrRepositoryNotInitialized
+			return notInitializedError(ctx, st)
 		}
 
 		return errors.Wrap(err, "unable to read format blob")
@@ -47,11 +102,16 @@ func Connect(ctx context.Context, configFile string, st blob.Storage, password s
 		return err
 	}
 
+	if err = verifyNamespace(st, f); err != nil {
+		return err
+	}
+
 	var lc LocalConfig
 
 	ci := st.ConnectionInfo()
 	lc.Storage = &ci
 	lc.ClientOptions = opt.ClientOptions.ApplyDefaults(ctx, "Repository in "+st.DisplayName())
+	lc.Staging = opt.Staging
 
 	if err = setupCaching(ctx, configFile, &lc, &opt.CachingOptions, f.UniqueID); err != nil {
 		return errors.Wrap(err, "unable to set up caching")
@@ -70,10 +130,10 @@ func Connect(ctx context.Context, configFile string, st blob.Storage, password s
 		return errors.Wrap(err, "unable to write config file")
 	}
 
-	return verifyConnect(ctx, configFile, password, opt.PersistCredentials)
+	return verifyConnect(ctx, configFile, password, opt.PersistCredentials, opt.Prefetch)
 }
 
-func verifyConnect(ctx context.Context, configFile, password string, persist bool) error {
+func verifyConnect(ctx context.Context, configFile, password string, persist, prefetch bool) error {
 	// now verify that the repository can be opened with the provided config file.
 	r, err := Open(ctx, configFile, password, nil)
 	if err != nil {
@@ -94,6 +154,10 @@ func verifyConnect(ctx context.Context, configFile, password string, persist boo
 		deletePassword(ctx, configFile)
 	}
 
+	if _, ok := r.(*DirectRepository); ok && prefetch {
+		startPrefetchAfterConnect(ctx, configFile, password)
+	}
+
 	return r.Close(ctx)
 }
 
@@ -138,12 +202,37 @@ func setupCaching(ctx context.Context, configPath string, lc *LocalConfig, opt *
 	lc.Caching.MaxCacheSizeBytes = opt.MaxCacheSizeBytes
 	lc.Caching.MaxMetadataCacheSizeBytes = opt.MaxMetadataCacheSizeBytes
 	lc.Caching.MaxListCacheDurationSec = opt.MaxListCacheDurationSec
+	lc.Caching.MergeCommittedIndexes = opt.MergeCommittedIndexes
+	lc.Caching.LazyIndexLoading = opt.LazyIndexLoading
+	lc.Caching.ReadAheadBytes = opt.ReadAheadBytes
+	lc.Caching.SharedIndexCacheDirectory = opt.SharedIndexCacheDirectory
+	lc.Caching.DisableIndexMmap = opt.DisableIndexMmap
+	lc.Caching.AutoSize = opt.AutoSize
+
+	lc.Caching.BlockCacheFile = opt.BlockCacheFile
+
+	if opt.BlockCacheFile != "" {
+		if lc.Caching.BlockCacheFile, err = filepath.Abs(opt.BlockCacheFile); err != nil {
+			return errors.Wrap(err, "error computing block cache file")
+		}
+	}
 
 	log(ctx).Debugf("Creating cache directory '%v' with max size %v", lc.Caching.CacheDirectory, lc.Caching.MaxCacheSizeBytes)
 
 	return nil
 }
 
+// DefaultSharedIndexCacheDirectory returns the default directory of the cache of index blobs shared by all
+// kopia processes of the current user, which is divided into subdirectories specific to each repository.
+func DefaultSharedIndexCacheDirectory() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to determine cache directory")
+	}
+
+	return filepath.Join(cacheDir, "kopia", "shared-indexes"), nil
+}
+
 // Disconnect removes the specified configuration file and any local cache directories.
 func Disconnect(ctx context.Context, configFile string) error {
 	cfg, err := loadConfigFromFile(configFile)
@@ -164,6 +253,16 @@ func Disconnect(ctx context.Context, configFile string) error {
 		log(ctx).Warningf("unable to remove maintenance lock file", maintenanceLock)
 	}
 
+	uploadHints := configFile + ".upload-hints"
+	if err := os.RemoveAll(uploadHints); err != nil {
+		log(ctx).Warningf("unable to remove upload hints: %v", err)
+	}
+
+	verifyState := configFile + ".verify-state"
+	if err := os.RemoveAll(verifyState); err != nil {
+		log(ctx).Warningf("unable to remove content verification state: %v", err)
+	}
+
 	return os.Remove(configFile)
 }
 
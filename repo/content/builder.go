@@ -5,6 +5,7 @@
 	"crypto/rand"
 	"encoding/binary"
 	"io"
+	"math"
 	"sort"
 
 	"github.com/pkg/errors"
@@ -83,7 +84,10 @@ func (b packIndexBuilder) Build(output io.Writer) error {
 	w := bufio.NewWriter(output)
 
 	// prepare extra data to be appended at the end of an index.
-	extraData := prepareExtraData(allContents, layout)
+	extraData, err := prepareExtraData(allContents, layout)
+	if err != nil {
+		return err
+	}
 
 	// write header
 	header := make([]byte, packHeaderSize)
@@ -121,7 +125,7 @@ func (b packIndexBuilder) Build(output io.Writer) error {
 	return w.Flush()
 }
 
-func prepareExtraData(allContents []*Info, layout *indexLayout) []byte {
+func prepareExtraData(allContents []*Info, layout *indexLayout) ([]byte, error) {
 	var extraData []byte
 
 	var hashBuf [maxContentIDSize]byte
@@ -139,9 +143,16 @@ func prepareExtraData(allContents []*Info, layout *indexLayout) []byte {
 		}
 	}
 
-	layout.extraDataOffset = uint32(packHeaderSize + layout.entryCount*(layout.keyLength+layout.entryLength))
+	// offsets are stored as 32-bit values, compute them using 64-bit arithmetic to detect overflows,
+	// which would otherwise silently produce a corrupted index.
+	extraDataOffset := int64(packHeaderSize) + int64(layout.entryCount)*int64(layout.keyLength+layout.entryLength)
+	if extraDataOffset+int64(len(extraData)) > math.MaxUint32 {
+		return nil, errors.Errorf("index too large: %v entries", layout.entryCount)
+	}
+
+	layout.extraDataOffset = uint32(extraDataOffset)
 
-	return extraData
+	return extraData, nil
 }
 
 func writeEntry(w io.Writer, it *Info, layout *indexLayout, entry []byte) error {
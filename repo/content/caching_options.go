@@ -6,8 +6,29 @@ type CachingOptions struct {
 	MaxCacheSizeBytes         int64  `json:"maxCacheSize,omitempty"`
 	MaxMetadataCacheSizeBytes int64  `json:"maxMetadataCacheSize,omitempty"`
 	MaxListCacheDurationSec   int    `json:"maxListCacheDuration,omitempty"`
+	MergeCommittedIndexes     bool   `json:"mergeCommittedIndexes,omitempty"`
+	LazyIndexLoading          bool   `json:"lazyIndexLoading,omitempty"`
+	ReadAheadBytes            int64  `json:"readAheadBytes,omitempty"`
+	SharedIndexCacheDirectory string `json:"sharedIndexCacheDirectory,omitempty"`
+	DisableIndexMmap          bool   `json:"disableIndexMmap,omitempty"`
 	HMACSecret                []byte `json:"-"`
 
+	// AutoSize causes sizes of data and metadata caches to be periodically adjusted based on the size
+	// of indexes, observed cache misses and available disk space. Configured sizes are used as minimums.
+	AutoSize bool `json:"autoSize,omitempty"`
+
+	// PerHostCacheDirectory causes each host to use its own subdirectory of the cache directory, which is needed
+	// when the cache directory is on a network filesystem shared by multiple hosts.
+	PerHostCacheDirectory bool `json:"perHostCacheDirectory,omitempty"`
+
+	// UseLockFiles causes processes sharing the cache to coordinate using exclusively created lock files
+	// instead of advisory locks, which are unreliable on network filesystems.
+	UseLockFiles bool `json:"-"`
+
+	// BlockCacheFile is a preallocated file or raw block device which stores the data cache using direct IO
+	// instead of individual files in the cache directory.
+	BlockCacheFile string `json:"blockCacheFile,omitempty"`
+
 	ownWritesCache ownWritesCache
 }
 
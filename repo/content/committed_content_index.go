@@ -3,19 +3,60 @@
 import (
 	"context"
 	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/kopia/kopia/repo/blob"
 )
 
+const (
+	// negativeLookupCacheTTL is the duration for which contents not found in committed indexes are remembered.
+	negativeLookupCacheTTL = 10 * time.Minute
+
+	// maxNegativeLookupCacheEntries bounds the memory used by the negative lookup cache.
+	maxNegativeLookupCacheEntries = 100000
+
+	// minIndexBlobsToMerge is the minimum number of committed index blobs for which merged index is built.
+	minIndexBlobsToMerge = 2
+
+	// lazyIndexLoadingClockSkew is the maximum assumed difference between clocks of clients writing
+	// contents and the storage, which is used when deciding whether index blobs that have not been loaded
+	// yet may contain newer entries for a content than the one already found.
+	lazyIndexLoadingClockSkew = 1 * time.Hour
+
+	// lazyIndexLoadingParallelism is the maximum number of index blobs fetched concurrently on demand.
+	lazyIndexLoadingParallelism = 8
+)
+
 type committedContentIndex struct {
-	cache committedContentIndexCache
+	cache        committedContentIndexCache
+	timeNow      func() time.Time
+	mergeIndexes bool
+	lazy         bool
+
+	// fetchIndexBlob downloads index blob which has not been loaded yet, used with lazy loading.
+	fetchIndexBlob func(ctx context.Context, indexBlob blob.ID) ([]byte, error)
 
 	mu     sync.Mutex
 	inUse  map[blob.ID]packIndex
 	merged mergedIndex
+
+	// mergedIndexFile contains entries from all index blobs in mergedBlobIDs, which are not in inUse.
+	mergedIndexFile packIndex
+	mergedBlobIDs   map[blob.ID]bool
+
+	// notFound maps IDs of contents known not to exist in merged index to the time when that
+	// knowledge expires, which saves repeated lookups in all indexes for the same absent contents.
+	// It is cleared whenever the set of indexes changes.
+	notFound    map[ID]time.Time
+	maxNotFound int
+
+	// pending contains index blobs which are in use but have not been loaded yet, newest first.
+	pending []IndexBlobInfo
 }
 
 type committedContentIndexCache interface {
@@ -25,20 +66,238 @@ type committedContentIndexCache interface {
 	expireUnused(ctx context.Context, used []blob.ID) error
 }
 
-func (b *committedContentIndex) getContent(contentID ID) (Info, error) {
+// committedContentIndexLocker is implemented by caches shared by multiple processes, which must not
+// download the same index blob concurrently.
+type committedContentIndexLocker interface {
+	// lockIndexBlob acquires the lock guarding the download of the provided index blob and returns the function which releases it.
+	lockIndexBlob(ctx context.Context, indexBlob blob.ID) (func(), error)
+}
+
+// committedContentIndexCacheCloser is implemented by caches which must release resources when the index is closed.
+type committedContentIndexCacheCloser interface {
+	close() error
+}
+
+// committedContentIndexMerger is implemented by caches which can merge multiple indexes into one.
+type committedContentIndexMerger interface {
+	// mergeIndexes returns the index which contains entries from provided sources, which together
+	// contain all entries from the provided set of index blobs.
+	mergeIndexes(ctx context.Context, indexBlobs []blob.ID, sources mergedIndex) (packIndex, error)
+}
+
+func (b *committedContentIndex) getContent(ctx context.Context, contentID ID) (Info, error) {
+	return b.getContentWithMissBehavior(ctx, contentID, true)
+}
+
+// getContentIfLoaded is like getContent(), but does not fetch pending index blobs to prove that the content
+// does not exist when it's not found in loaded index blobs. Pending index blobs which may contain newer entries
+// for contents that were found are still fetched, since they may mark the content as deleted.
+func (b *committedContentIndex) getContentIfLoaded(ctx context.Context, contentID ID) (Info, error) {
+	return b.getContentWithMissBehavior(ctx, contentID, false)
+}
+
+func (b *committedContentIndex) getContentWithMissBehavior(ctx context.Context, contentID ID, loadOnMiss bool) (Info, error) {
+	// most lookups only need the most recent index blobs, the number of index blobs loaded
+	// at once grows while the content is not found.
+	batchSize := 1
+
+	for {
+		info, toLoad, err := b.findContent(contentID, batchSize, loadOnMiss)
+		if err != nil {
+			return Info{}, err
+		}
+
+		if len(toLoad) == 0 {
+			return info, nil
+		}
+
+		if err := b.loadPending(ctx, toLoad); err != nil {
+			return Info{}, err
+		}
+
+		if batchSize < lazyIndexLoadingParallelism {
+			batchSize *= 2
+		}
+	}
+}
+
+// findContent returns the content found in loaded index blobs or up to batchSize most recent pending index blobs,
+// which must be loaded first because they may contain an entry for the content that's newer than the one found.
+// When loadOnMiss is false, contents not found in loaded index blobs are reported as not found without
+// remembering it, since pending index blobs may contain them.
+func (b *committedContentIndex) findContent(contentID ID, batchSize int, loadOnMiss bool) (Info, []IndexBlobInfo, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	now := b.timeNow()
+
+	if exp, ok := b.notFound[contentID]; ok {
+		if now.Before(exp) {
+			return Info{}, nil, ErrContentNotFound
+		}
+
+		delete(b.notFound, contentID)
+	}
+
 	info, err := b.merged.GetInfo(contentID)
+	if err != nil {
+		return Info{}, nil, err
+	}
+
+	if info == nil && !loadOnMiss {
+		return Info{}, nil, ErrContentNotFound
+	}
+
+	var toLoad []IndexBlobInfo
+
+	// pending index blobs are sorted newest first, none of the remaining ones can contain
+	// an entry for the content that's newer than the one already found.
+	for _, p := range b.pending {
+		if len(toLoad) >= batchSize || (info != nil && p.Timestamp.Before(info.Timestamp().Add(-lazyIndexLoadingClockSkew))) {
+			break
+		}
+
+		toLoad = append(toLoad, p)
+	}
+
+	if len(toLoad) > 0 {
+		return Info{}, toLoad, nil
+	}
+
 	if info != nil {
-		return *info, nil
+		return *info, nil, nil
+	}
+
+	if len(b.notFound) >= b.maxNotFound {
+		b.notFound = map[ID]time.Time{}
+	}
+
+	b.notFound[contentID] = now.Add(negativeLookupCacheTTL)
+
+	return Info{}, nil, ErrContentNotFound
+}
+
+// loadPending fetches the provided index blobs which have not been loaded yet in parallel, without holding
+// the lock, and starts using the ones that are still pending.
+func (b *committedContentIndex) loadPending(ctx context.Context, indexBlobs []IndexBlobInfo) error {
+	sem := make(chan struct{}, lazyIndexLoadingParallelism)
+
+	var eg errgroup.Group
+
+	for _, ib := range indexBlobs {
+		indexBlobID := ib.BlobID
+
+		sem <- struct{}{}
+
+		eg.Go(func() error {
+			defer func() {
+				<-sem
+			}()
+
+			log(ctx).Debugf("loading index blob %v on demand", indexBlobID)
+
+			return b.downloadToCache(ctx, indexBlobID, b.fetchIndexBlob)
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ib := range indexBlobs {
+		if !b.isPending(ib.BlobID) {
+			// loaded concurrently or no longer in use.
+			continue
+		}
+
+		ndx, err := b.openCachedIndex(ctx, ib.BlobID)
+		if err != nil {
+			return errors.Wrapf(err, "unable to open pack index %q", ib.BlobID)
+		}
+
+		b.inUse[ib.BlobID] = ndx
+		b.merged = append(b.merged, ndx)
+		b.pending = removePendingIndexBlob(b.pending, ib.BlobID)
+	}
+
+	return nil
+}
+
+// openCachedIndex opens the index blob from the cache. When the cached index file fails checksum verification,
+// it's evicted from the cache and the index blob is fetched again.
+func (b *committedContentIndex) openCachedIndex(ctx context.Context, indexBlobID blob.ID) (packIndex, error) {
+	ndx, err := b.cache.openIndex(ctx, indexBlobID)
+	if !isInvalidIndexCacheFile(err) {
+		return ndx, err
+	}
+
+	log(ctx).Warningf("refetching index blob %v: %v", indexBlobID, err)
+
+	if err := b.downloadToCache(ctx, indexBlobID, b.fetchIndexBlob); err != nil {
+		return nil, err
+	}
+
+	return b.cache.openIndex(ctx, indexBlobID)
+}
+
+// loadAllPending loads all index blobs which have not been loaded yet.
+func (b *committedContentIndex) loadAllPending(ctx context.Context) error {
+	b.mu.Lock()
+	pending := append([]IndexBlobInfo(nil), b.pending...)
+	b.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	return b.loadPending(ctx, pending)
+}
+
+func (b *committedContentIndex) isPending(indexBlobID blob.ID) bool {
+	for _, p := range b.pending {
+		if p.BlobID == indexBlobID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// downloadToCache fetches the provided index blob and adds it to the cache, unless another process sharing
+// the cache has already done so.
+func (b *committedContentIndex) downloadToCache(ctx context.Context, indexBlobID blob.ID, fetch func(ctx context.Context, indexBlob blob.ID) ([]byte, error)) error {
+	if l, ok := b.cache.(committedContentIndexLocker); ok {
+		unlock, err := l.lockIndexBlob(ctx, indexBlobID)
+		if err != nil {
+			return err
+		}
+
+		defer unlock()
+
+		has, err := b.cache.hasIndexBlobID(ctx, indexBlobID)
+		if err != nil {
+			return errors.Wrapf(err, "unable to check cache for pack index %q", indexBlobID)
+		}
+
+		if has {
+			log(ctx).Debugf("index blob %v was downloaded by another process", indexBlobID)
+			return nil
+		}
+	}
+
+	data, err := fetch(ctx, indexBlobID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to fetch index blob %q", indexBlobID)
 	}
 
-	if err == nil {
-		return Info{}, ErrContentNotFound
+	if err := b.cache.addContentToCache(ctx, indexBlobID, data); err != nil {
+		return errors.Wrap(err, "unable to add to committed content cache")
 	}
 
-	return Info{}, err
+	return nil
 }
 
 func (b *committedContentIndex) addContent(ctx context.Context, indexBlobID blob.ID, data []byte, use bool) error {
@@ -53,22 +312,33 @@ func (b *committedContentIndex) addContent(ctx context.Context, indexBlobID blob
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if b.inUse[indexBlobID] != nil {
+	if b.inUse[indexBlobID] != nil || b.mergedBlobIDs[indexBlobID] {
 		return nil
 	}
 
-	ndx, err := b.cache.openIndex(ctx, indexBlobID)
+	if b.isPending(indexBlobID) {
+		// index blob is being used now, so it's no longer pending.
+		b.pending = removePendingIndexBlob(b.pending, indexBlobID)
+	}
+
+	ndx, err := b.openCachedIndex(ctx, indexBlobID)
 	if err != nil {
 		return errors.Wrapf(err, "unable to open pack index %q", indexBlobID)
 	}
 
 	b.inUse[indexBlobID] = ndx
 	b.merged = append(b.merged, ndx)
+	b.notFound = map[ID]time.Time{}
 
 	return nil
 }
 
-func (b *committedContentIndex) listContents(r IDRange, cb func(i Info) error) error {
+func (b *committedContentIndex) listContents(ctx context.Context, r IDRange, cb func(i Info) error) error {
+	// listing requires all index blobs to be loaded.
+	if err := b.loadAllPending(ctx); err != nil {
+		return err
+	}
+
 	b.mu.Lock()
 	m := append(mergedIndex(nil), b.merged...)
 	b.mu.Unlock()
@@ -77,12 +347,12 @@ func (b *committedContentIndex) listContents(r IDRange, cb func(i Info) error) e
 }
 
 func (b *committedContentIndex) packFilesChanged(packFiles []blob.ID) bool {
-	if len(packFiles) != len(b.inUse) {
+	if len(packFiles) != len(b.inUse)+len(b.mergedBlobIDs)+len(b.pending) {
 		return true
 	}
 
 	for _, packFile := range packFiles {
-		if b.inUse[packFile] == nil {
+		if b.inUse[packFile] == nil && !b.mergedBlobIDs[packFile] && !b.isPending(packFile) {
 			return true
 		}
 	}
@@ -101,6 +371,16 @@ func (b *committedContentIndex) use(ctx context.Context, packFiles []blob.ID) (b
 		return false, nil
 	}
 
+	if m, ok := b.cache.(committedContentIndexMerger); ok && b.mergeIndexes && len(packFiles) >= minIndexBlobsToMerge {
+		err := b.useMergedLocked(ctx, m, packFiles)
+		if err == nil {
+			b.expireUnusedLocked(ctx, packFiles)
+			return true, nil
+		}
+
+		log(ctx).Warningf("unable to merge content indexes, using individual indexes: %v", err)
+	}
+
 	var newMerged mergedIndex
 
 	newInUse := map[blob.ID]packIndex{}
@@ -110,7 +390,7 @@ func (b *committedContentIndex) use(ctx context.Context, packFiles []blob.ID) (b
 	}()
 
 	for _, e := range packFiles {
-		ndx, err := b.cache.openIndex(ctx, e)
+		ndx, err := b.openCachedIndex(ctx, e)
 		if err != nil {
 			return false, errors.Wrapf(err, "unable to open pack index %q", e)
 		}
@@ -121,16 +401,160 @@ func (b *committedContentIndex) use(ctx context.Context, packFiles []blob.ID) (b
 
 	b.merged = newMerged
 	b.inUse = newInUse
+	b.mergedIndexFile = nil
+	b.mergedBlobIDs = nil
+	b.pending = nil
+	b.notFound = map[ID]time.Time{}
 
-	if err := b.cache.expireUnused(ctx, packFiles); err != nil {
-		log(ctx).Warningf("unable to expire unused content index files: %v", err)
+	b.expireUnusedLocked(ctx, packFiles)
+
+	newMerged = nil // prevent closing newMerged indices
+
+	return true, nil
+}
+
+// useLazy is like use() but only opens index blobs which are already in the cache, the remaining
+// ones are fetched on demand, starting with the most recent, when a content is not found.
+func (b *committedContentIndex) useLazy(ctx context.Context, indexBlobs []IndexBlobInfo) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var packFiles []blob.ID
+	for _, ib := range indexBlobs {
+		packFiles = append(packFiles, ib.BlobID)
+	}
+
+	if !b.packFilesChanged(packFiles) {
+		return false, nil
 	}
 
+	var (
+		newMerged mergedIndex
+		pending   []IndexBlobInfo
+	)
+
+	newInUse := map[blob.ID]packIndex{}
+
+	defer func() {
+		newMerged.Close() //nolint:errcheck
+	}()
+
+	for _, ib := range indexBlobs {
+		has, err := b.cache.hasIndexBlobID(ctx, ib.BlobID)
+		if err != nil {
+			return false, errors.Wrapf(err, "unable to check cache for pack index %q", ib.BlobID)
+		}
+
+		if !has {
+			pending = append(pending, ib)
+			continue
+		}
+
+		ndx, err := b.openCachedIndex(ctx, ib.BlobID)
+		if err != nil {
+			return false, errors.Wrapf(err, "unable to open pack index %q", ib.BlobID)
+		}
+
+		newMerged = append(newMerged, ndx)
+		newInUse[ib.BlobID] = ndx
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Timestamp.After(pending[j].Timestamp)
+	})
+
+	b.merged = newMerged
+	b.inUse = newInUse
+	b.mergedIndexFile = nil
+	b.mergedBlobIDs = nil
+	b.pending = pending
+	b.notFound = map[ID]time.Time{}
+
+	b.expireUnusedLocked(ctx, packFiles)
+
 	newMerged = nil // prevent closing newMerged indices
 
 	return true, nil
 }
 
+func removePendingIndexBlob(pending []IndexBlobInfo, indexBlobID blob.ID) []IndexBlobInfo {
+	var result []IndexBlobInfo
+
+	for _, p := range pending {
+		if p.BlobID != indexBlobID {
+			result = append(result, p)
+		}
+	}
+
+	return result
+}
+
+// useMergedLocked switches to the merged index covering all provided index blobs. When the set of index blobs
+// only grows, the merged index is rebuilt incrementally from the previous merged index and the new index blobs.
+func (b *committedContentIndex) useMergedLocked(ctx context.Context, m committedContentIndexMerger, packFiles []blob.ID) error {
+	reusePrevious := b.mergedIndexFile != nil
+
+	current := map[blob.ID]bool{}
+	for _, e := range packFiles {
+		current[e] = true
+	}
+
+	for e := range b.mergedBlobIDs {
+		if !current[e] {
+			reusePrevious = false
+		}
+	}
+
+	var sources, opened mergedIndex
+
+	defer func() {
+		opened.Close() //nolint:errcheck
+	}()
+
+	if reusePrevious {
+		sources = append(sources, b.mergedIndexFile)
+	}
+
+	for _, e := range packFiles {
+		if reusePrevious && b.mergedBlobIDs[e] {
+			continue
+		}
+
+		if ndx := b.inUse[e]; ndx != nil {
+			sources = append(sources, ndx)
+			continue
+		}
+
+		ndx, err := b.openCachedIndex(ctx, e)
+		if err != nil {
+			return errors.Wrapf(err, "unable to open pack index %q", e)
+		}
+
+		opened = append(opened, ndx)
+		sources = append(sources, ndx)
+	}
+
+	ndx, err := m.mergeIndexes(ctx, packFiles, sources)
+	if err != nil {
+		return err
+	}
+
+	b.merged = mergedIndex{ndx}
+	b.inUse = map[blob.ID]packIndex{}
+	b.mergedIndexFile = ndx
+	b.mergedBlobIDs = current
+	b.pending = nil
+	b.notFound = map[ID]time.Time{}
+
+	return nil
+}
+
+func (b *committedContentIndex) expireUnusedLocked(ctx context.Context, packFiles []blob.ID) {
+	if err := b.cache.expireUnused(ctx, packFiles); err != nil {
+		log(ctx).Warningf("unable to expire unused content index files: %v", err)
+	}
+}
+
 func (b *committedContentIndex) close() error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -141,23 +565,46 @@ func (b *committedContentIndex) close() error {
 		}
 	}
 
+	if b.mergedIndexFile != nil {
+		if err := b.mergedIndexFile.Close(); err != nil {
+			return errors.Wrap(err, "unable to close merged index")
+		}
+	}
+
+	if c, ok := b.cache.(committedContentIndexCacheCloser); ok {
+		return c.close()
+	}
+
 	return nil
 }
 
-func newCommittedContentIndex(caching *CachingOptions) *committedContentIndex {
+func newCommittedContentIndex(caching *CachingOptions, timeNow func() time.Time) *committedContentIndex {
 	var cache committedContentIndexCache
 
-	if caching.CacheDirectory != "" {
+	switch {
+	case caching.SharedIndexCacheDirectory != "":
+		cache = newSharedCommittedContentIndexCache(caching)
+
+	case caching.CacheDirectory != "":
 		dirname := filepath.Join(caching.CacheDirectory, "indexes")
-		cache = &diskCommittedContentIndexCache{dirname}
-	} else {
+		cache = &diskCommittedContentIndexCache{
+			dirname:     dirname,
+			disableMmap: caching.DisableIndexMmap,
+		}
+
+	default:
 		cache = &memoryCommittedContentIndexCache{
 			contents: map[blob.ID]packIndex{},
 		}
 	}
 
 	return &committedContentIndex{
-		cache: cache,
-		inUse: map[blob.ID]packIndex{},
+		cache:        cache,
+		timeNow:      timeNow,
+		mergeIndexes: caching.MergeCommittedIndexes,
+		lazy:         caching.LazyIndexLoading,
+		inUse:        map[blob.ID]packIndex{},
+		notFound:     map[ID]time.Time{},
+		maxNotFound:  maxNegativeLookupCacheEntries,
 	}
 }
@@ -1,14 +1,23 @@
 package content
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+	"go.opencensus.io/stats"
 	"golang.org/x/exp/mmap"
 
 	"github.com/kopia/kopia/internal/clock"
@@ -17,26 +26,280 @@
 
 const (
 	simpleIndexSuffix                      = ".sndx"
+	mergedIndexPrefix                      = "merged-"
+	mergedIndexSuffix                      = ".mndx"
 	unusedCommittedContentIndexCleanupTime = 1 * time.Hour // delete unused committed index blobs after 1 hour
+
+	// indexChecksumMagic followed by CRC32C of the preceding bytes is appended to each index file
+	// in the cache, so that files corrupted on disk are detected when opened.
+	indexChecksumMagic         = "kcrc"
+	indexChecksumTrailerLength = len(indexChecksumMagic) + 4
+)
+
+var (
+	// errCorruptedIndexCacheFile is returned when the index file in the cache fails checksum verification.
+	errCorruptedIndexCacheFile = errors.New("corrupted index cache file")
+
+	// errIndexCacheFileWithoutChecksum is returned for index files written to the cache by older versions of kopia,
+	// which are used without verification.
+	errIndexCacheFileWithoutChecksum = errors.New("index cache file without checksum")
+
+	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
 )
 
 type diskCommittedContentIndexCache struct {
 	dirname string
+
+	// disableMmap causes index files to be read using regular file I/O instead of being memory-mapped,
+	// which is unreliable on some network filesystems and emulated environments.
+	disableMmap bool
+
+	// verified contains index files written or verified by this process, which are opened without verifying
+	// their checksums again.
+	verifiedMu sync.Mutex
+	verified   map[string]verifiedIndexFile
+}
+
+// verifiedIndexFile identifies the version of the index file whose checksum has been verified.
+type verifiedIndexFile struct {
+	size    int64
+	modTime time.Time
+}
+
+func (c *diskCommittedContentIndexCache) isVerified(fullpath string, st os.FileInfo) bool {
+	c.verifiedMu.Lock()
+	defer c.verifiedMu.Unlock()
+
+	v, ok := c.verified[fullpath]
+
+	return ok && v.size == st.Size() && v.modTime.Equal(st.ModTime())
+}
+
+func (c *diskCommittedContentIndexCache) setVerified(fullpath string, st os.FileInfo) {
+	c.verifiedMu.Lock()
+	defer c.verifiedMu.Unlock()
+
+	if st == nil {
+		delete(c.verified, fullpath)
+		return
+	}
+
+	if c.verified == nil {
+		c.verified = map[string]verifiedIndexFile{}
+	}
+
+	c.verified[fullpath] = verifiedIndexFile{st.Size(), st.ModTime()}
+}
+
+// markWritten records the index file written by this process from verified data as verified.
+func (c *diskCommittedContentIndexCache) markWritten(fullpath string) {
+	if st, err := os.Stat(fullpath); err == nil {
+		c.setVerified(fullpath, st)
+	}
 }
 
 func (c *diskCommittedContentIndexCache) indexBlobPath(indexBlobID blob.ID) string {
 	return filepath.Join(c.dirname, string(indexBlobID)+simpleIndexSuffix)
 }
 
+// openIndex opens the cached index blob, index files which fail checksum verification are removed from the cache.
+// Checksum of each index file is verified only when it's opened by this process for the first time, unless
+// the file has been written by this process.
 func (c *diskCommittedContentIndexCache) openIndex(ctx context.Context, indexBlobID blob.ID) (packIndex, error) {
-	fullpath := c.indexBlobPath(indexBlobID)
+	return c.openIndexFile(ctx, c.indexBlobPath(indexBlobID))
+}
 
-	f, err := mmapOpenWithRetry(ctx, fullpath)
+// openIndexFile opens the index stored in the provided file using mmap or regular file I/O after verifying
+// its checksum if needed. Files which fail verification are removed.
+func (c *diskCommittedContentIndexCache) openIndexFile(ctx context.Context, fullpath string) (packIndex, error) {
+	t0 := clock.Now()
+
+	ndx, err := c.openAndVerifyIndexFile(ctx, fullpath)
 	if err != nil {
+		stats.Record(ctx, metricIndexCacheOpenErrors.M(1))
+
 		return nil, err
 	}
 
-	return openPackIndex(f)
+	stats.Record(ctx,
+		metricIndexCacheOpenCount.M(1),
+		metricIndexCacheOpenDuration.M(clock.Since(t0).Milliseconds()),
+	)
+
+	return ndx, nil
+}
+
+func (c *diskCommittedContentIndexCache) openAndVerifyIndexFile(ctx context.Context, fullpath string) (packIndex, error) {
+	st, err := os.Stat(fullpath)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open index file")
+	}
+
+	var f io.ReaderAt
+
+	if c.disableMmap {
+		f, err = os.Open(fullpath) //nolint:gosec
+	} else {
+		f, err = mmapOpenWithRetry(ctx, fullpath)
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open index file")
+	}
+
+	closeFile := func() {
+		if closer, ok := f.(io.Closer); ok {
+			closer.Close() //nolint:errcheck
+		}
+	}
+
+	if !c.isVerified(fullpath, st) {
+		if err = verifyIndexChecksum(f, st.Size()); err != nil && !errors.Is(err, errIndexCacheFileWithoutChecksum) {
+			closeFile()
+
+			if isInvalidIndexCacheFile(err) {
+				c.removeCorrupted(ctx, fullpath)
+			}
+
+			return nil, errors.Wrapf(err, "unable to verify index file %v", fullpath)
+		}
+
+		c.setVerified(fullpath, st)
+	}
+
+	ndx, err := openPackIndex(f)
+	if err != nil {
+		closeFile()
+
+		// index files without checksum may be corrupted too.
+		c.removeCorrupted(ctx, fullpath)
+
+		return nil, errors.Wrapf(errCorruptedIndexCacheFile, "unable to open index file %v: %v", fullpath, err)
+	}
+
+	return ndx, nil
+}
+
+// removeCorrupted removes the index file which failed verification from the cache.
+func (c *diskCommittedContentIndexCache) removeCorrupted(ctx context.Context, fullpath string) {
+	stats.Record(ctx, metricIndexCacheCorruptCount.M(1))
+
+	c.setVerified(fullpath, nil)
+
+	if err := os.Remove(fullpath); err != nil && !os.IsNotExist(err) {
+		log(ctx).Warningf("unable to remove invalid index file %v: %v", fullpath, err)
+	}
+}
+
+// isInvalidIndexCacheFile returns true if the error indicates the index file in the cache failed checksum verification.
+func isInvalidIndexCacheFile(err error) bool {
+	return errors.Is(err, errCorruptedIndexCacheFile)
+}
+
+// appendIndexChecksum returns a copy of the index data followed by the checksum trailer.
+func appendIndexChecksum(data []byte) []byte {
+	result := make([]byte, len(data), len(data)+indexChecksumTrailerLength)
+	copy(result, data)
+
+	result = append(result, indexChecksumMagic...)
+
+	var sum [4]byte
+
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(data, crc32cTable))
+
+	return append(result, sum[:]...)
+}
+
+// verifyIndexChecksum verifies the checksum trailer of the index file of the provided size.
+func verifyIndexChecksum(r io.ReaderAt, size int64) error {
+	dataLength := size - int64(indexChecksumTrailerLength)
+	if dataLength < 0 {
+		return errIndexCacheFileWithoutChecksum
+	}
+
+	trailer := make([]byte, indexChecksumTrailerLength)
+
+	if _, err := r.ReadAt(trailer, dataLength); err != nil && !errors.Is(err, io.EOF) {
+		return errors.Wrap(err, "unable to read index checksum")
+	}
+
+	if string(trailer[0:len(indexChecksumMagic)]) != indexChecksumMagic {
+		return errIndexCacheFileWithoutChecksum
+	}
+
+	h := crc32.New(crc32cTable)
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, dataLength)); err != nil {
+		return errors.Wrap(err, "unable to read index file")
+	}
+
+	if got, want := h.Sum32(), binary.BigEndian.Uint32(trailer[len(indexChecksumMagic):]); got != want {
+		return errors.Wrapf(errCorruptedIndexCacheFile, "checksum mismatch: %08x, expected %08x", got, want)
+	}
+
+	return nil
+}
+
+// mergedIndexPath returns the path of the merged index file for the provided set of index blobs.
+func (c *diskCommittedContentIndexCache) mergedIndexPath(indexBlobIDs []blob.ID) string {
+	sorted := append([]blob.ID(nil), indexBlobIDs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	h := sha256.New()
+
+	for _, id := range sorted {
+		h.Write([]byte(id)) //nolint:errcheck
+		h.Write([]byte{0})  //nolint:errcheck
+	}
+
+	return filepath.Join(c.dirname, mergedIndexPrefix+hex.EncodeToString(h.Sum(nil)[0:16])+mergedIndexSuffix)
+}
+
+// mergeIndexes writes all entries from the provided sources into a single sorted index file, unless
+// the file for the provided set of index blobs already exists, and opens it.
+func (c *diskCommittedContentIndexCache) mergeIndexes(ctx context.Context, indexBlobIDs []blob.ID, sources mergedIndex) (packIndex, error) {
+	fullpath := c.mergedIndexPath(indexBlobIDs)
+
+	if _, err := os.Stat(fullpath); err == nil {
+		ndx, err := c.openIndexFile(ctx, fullpath)
+		if !isInvalidIndexCacheFile(err) {
+			return ndx, err
+		}
+
+		// invalid merged index has been removed, rebuild it from sources.
+		log(ctx).Warningf("rebuilding merged index: %v", err)
+	}
+
+	b := packIndexBuilder{}
+
+	if err := sources.Iterate(AllIDs, func(i Info) error {
+		b.Add(i)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "unable to read source indexes")
+	}
+
+	var buf bytes.Buffer
+
+	if err := b.Build(&buf); err != nil {
+		return nil, errors.Wrap(err, "unable to build merged index")
+	}
+
+	tmpFile, err := writeTempFileAtomic(c.dirname, appendIndexChecksum(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(tmpFile, fullpath); err != nil {
+		return nil, errors.Wrap(err, "unable to write merged index")
+	}
+
+	c.markWritten(fullpath)
+
+	stats.Record(ctx, metricIndexCacheMergeCount.M(1))
+
+	log(ctx).Debugf("merged %v index blobs with %v entries into %v", len(indexBlobIDs), len(b), fullpath)
+
+	return c.openIndexFile(ctx, fullpath)
 }
 
 // mmapOpenWithRetry attempts mmap.Open() with exponential back-off to work around rare issue specific to Windows where
@@ -49,6 +312,11 @@ func mmapOpenWithRetry(ctx context.Context, path string) (*mmap.ReaderAt, error)
 
 	// retry milliseconds: 10, 20, 40, 80, 160, 320, 640, 1280, total ~2.5s
 	f, err := mmap.Open(path)
+	if err == nil {
+		return f, nil
+	}
+
+	t0 := clock.Now()
 	nextDelay := startingDelay
 
 	retryCount := 0
@@ -60,7 +328,23 @@ func mmapOpenWithRetry(ctx context.Context, path string) (*mmap.ReaderAt, error)
 		f, err = mmap.Open(path)
 	}
 
-	return f, err
+	waited := clock.Since(t0)
+
+	stats.Record(ctx,
+		metricIndexCacheMmapRetries.M(int64(retryCount)),
+		metricIndexCacheMmapRetryDuration.M(waited.Milliseconds()),
+	)
+
+	if err != nil {
+		stats.Record(ctx, metricIndexCacheMmapFailures.M(1))
+		log(ctx).Warningf("unable to mmap.Open() %v after %v retries in %v: %v", path, retryCount, waited, err)
+
+		return nil, err
+	}
+
+	log(ctx).Infof("mmap.Open() of %v succeeded after %v retries in %v", path, retryCount, waited)
+
+	return f, nil
 }
 
 func (c *diskCommittedContentIndexCache) hasIndexBlobID(ctx context.Context, indexBlobID blob.ID) (bool, error) {
@@ -86,7 +370,7 @@ func (c *diskCommittedContentIndexCache) addContentToCache(ctx context.Context,
 		return nil
 	}
 
-	tmpFile, err := writeTempFileAtomic(c.dirname, data)
+	tmpFile, err := writeTempFileAtomic(c.dirname, appendIndexChecksum(data))
 	if err != nil {
 		return err
 	}
@@ -102,8 +386,12 @@ func (c *diskCommittedContentIndexCache) addContentToCache(ctx context.Context,
 		if !exists {
 			return errors.Errorf("unsuccessful index write of content %q", indexBlobID)
 		}
+
+		return nil
 	}
 
+	c.markWritten(c.indexBlobPath(indexBlobID))
+
 	return nil
 }
 
@@ -133,35 +421,69 @@ func writeTempFileAtomic(dirname string, data []byte) (string, error) {
 }
 
 func (c *diskCommittedContentIndexCache) expireUnused(ctx context.Context, used []blob.ID) error {
+	return c.removeUnusedFiles(ctx, c.usedFileNames(used, nil))
+}
+
+// usedFileNames adds names of files in the cache which are used by the provided set of index blobs to the provided map.
+func (c *diskCommittedContentIndexCache) usedFileNames(used []blob.ID, result map[string]bool) map[string]bool {
+	if result == nil {
+		result = map[string]bool{}
+	}
+
+	for _, u := range used {
+		result[string(u)+simpleIndexSuffix] = true
+	}
+
+	result[filepath.Base(c.mergedIndexPath(used))] = true
+
+	return result
+}
+
+// removeUnusedFiles removes index files which are not used and have not been modified recently.
+func (c *diskCommittedContentIndexCache) removeUnusedFiles(ctx context.Context, used map[string]bool) error {
 	entries, err := ioutil.ReadDir(c.dirname)
 	if err != nil {
 		return errors.Wrap(err, "can't list cache")
 	}
 
-	remaining := map[blob.ID]os.FileInfo{}
+	remaining := map[string]os.FileInfo{}
 
 	for _, ent := range entries {
-		if strings.HasSuffix(ent.Name(), simpleIndexSuffix) {
-			n := strings.TrimSuffix(ent.Name(), simpleIndexSuffix)
-			remaining[blob.ID(n)] = ent
+		if used[ent.Name()] {
+			continue
 		}
-	}
 
-	for _, u := range used {
-		delete(remaining, u)
+		if strings.HasSuffix(ent.Name(), simpleIndexSuffix) || strings.HasSuffix(ent.Name(), mergedIndexSuffix) {
+			remaining[ent.Name()] = ent
+		}
 	}
 
+	var removed int
+
 	for _, rem := range remaining {
 		if clock.Since(rem.ModTime()) > unusedCommittedContentIndexCleanupTime {
 			log(ctx).Debugf("removing unused %v %v", rem.Name(), rem.ModTime())
 
-			if err := os.Remove(filepath.Join(c.dirname, rem.Name())); err != nil {
+			fullpath := filepath.Join(c.dirname, rem.Name())
+
+			if err := os.Remove(fullpath); err != nil {
 				log(ctx).Warningf("unable to remove unused index file: %v", err)
+				continue
 			}
+
+			c.setVerified(fullpath, nil)
+
+			removed++
+
+			stats.Record(ctx, metricIndexCacheExpiredCount.M(1))
 		} else {
 			log(ctx).Debugf("keeping unused %v because it's too new %v", rem.Name(), rem.ModTime())
 		}
 	}
 
+	if removed > 0 {
+		log(ctx).Debugf("removed %v of %v unused index files from cache", removed, len(remaining))
+	}
+
 	return nil
 }
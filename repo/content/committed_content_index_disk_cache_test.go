@@ -0,0 +1,148 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func testIndexBlobData(t *testing.T) []byte {
+	t.Helper()
+
+	b := make(packIndexBuilder)
+	b.Add(Info{ID: "abcdef", Length: 10, TimestampSeconds: 1000, PackBlobID: "p1", PackOffset: 5})
+
+	var buf bytes.Buffer
+	if err := b.Build(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestDiskCommittedContentIndexCacheChecksum(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	for _, disableMmap := range []bool{false, true} {
+		c := &diskCommittedContentIndexCache{dirname: t.TempDir(), disableMmap: disableMmap}
+
+		if err := c.addContentToCache(ctx, "n1", testIndexBlobData(t)); err != nil {
+			t.Fatal(err)
+		}
+
+		ndx, err := c.openIndex(ctx, "n1")
+		if err != nil {
+			t.Fatalf("unable to open index: %v", err)
+		}
+
+		if _, err = ndx.GetInfo("abcdef"); err != nil {
+			t.Errorf("unable to get info: %v", err)
+		}
+
+		ndx.Close() //nolint:errcheck
+
+		// flip one byte of the index entry, preserving size and modification time.
+		fname := c.indexBlobPath("n1")
+
+		st, err := os.Stat(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data[packHeaderSize] ^= 1
+
+		if err = ioutil.WriteFile(fname, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = os.Chtimes(fname, st.ModTime(), st.ModTime()); err != nil {
+			t.Fatal(err)
+		}
+
+		// files written by this process are not verified again.
+		ndx, err = c.openIndex(ctx, "n1")
+		if err != nil {
+			t.Fatalf("unable to open index: %v", err)
+		}
+
+		ndx.Close() //nolint:errcheck
+
+		// other processes verify the file when opening it for the first time.
+		c2 := &diskCommittedContentIndexCache{dirname: c.dirname, disableMmap: disableMmap}
+
+		if _, err = c2.openIndex(ctx, "n1"); !errors.Is(err, errCorruptedIndexCacheFile) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if has, _ := c2.hasIndexBlobID(ctx, "n1"); has {
+			t.Errorf("corrupted index file was not removed")
+		}
+
+		// index files without checksum written by older versions are used as is.
+		if err = ioutil.WriteFile(c.indexBlobPath("n2"), testIndexBlobData(t), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		ndx, err = c2.openIndex(ctx, "n2")
+		if err != nil {
+			t.Fatalf("unable to open index without checksum: %v", err)
+		}
+
+		if _, err = ndx.GetInfo("abcdef"); err != nil {
+			t.Errorf("unable to get info: %v", err)
+		}
+
+		ndx.Close() //nolint:errcheck
+	}
+}
+
+func TestCommittedContentIndexRefetchesCorruptedIndex(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	b := newCommittedContentIndex(&CachingOptions{CacheDirectory: t.TempDir()}, clock.Now)
+	c := b.cache.(*diskCommittedContentIndexCache)
+
+	fetchCount := 0
+
+	b.fetchIndexBlob = func(ctx context.Context, indexBlob blob.ID) ([]byte, error) {
+		fetchCount++
+		return testIndexBlobData(t), nil
+	}
+
+	if err := b.cache.addContentToCache(ctx, "n1", testIndexBlobData(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(c.indexBlobPath("n1"), []byte("garbage-index-data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.use(ctx, []blob.ID{"n1"}); err != nil {
+		t.Fatalf("unable to use index: %v", err)
+	}
+
+	if fetchCount != 1 {
+		t.Errorf("unexpected number of fetches: %v", fetchCount)
+	}
+
+	if _, err := b.getContent(ctx, "abcdef"); err != nil {
+		t.Errorf("unable to get content: %v", err)
+	}
+
+	if err := b.close(); err != nil {
+		t.Fatal(err)
+	}
+}
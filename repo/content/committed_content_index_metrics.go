@@ -0,0 +1,82 @@
+package content
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+// indexOpenDurationBounds are the bounds of the distribution of durations of opening cached index files in milliseconds.
+var indexOpenDurationBounds = []float64{1, 5, 10, 50, 100, 500, 1000, 2500, 5000} // nolint:gomnd
+
+// committed content index cache metrics.
+var (
+	metricIndexCacheOpenCount = stats.Int64(
+		"kopia/content/index_cache/open_count",
+		"Number of times an index file was opened from the committed index cache",
+		stats.UnitDimensionless,
+	)
+
+	metricIndexCacheOpenErrors = stats.Int64(
+		"kopia/content/index_cache/open_error_count",
+		"Number of times an index file could not be opened from the committed index cache",
+		stats.UnitDimensionless,
+	)
+
+	metricIndexCacheOpenDuration = stats.Int64(
+		"kopia/content/index_cache/open_duration",
+		"Duration of opening and verifying index files in the committed index cache",
+		stats.UnitMilliseconds,
+	)
+
+	metricIndexCacheMmapRetries = stats.Int64(
+		"kopia/content/index_cache/mmap_retry_count",
+		"Number of times memory-mapping an index file was retried",
+		stats.UnitDimensionless,
+	)
+
+	metricIndexCacheMmapRetryDuration = stats.Int64(
+		"kopia/content/index_cache/mmap_retry_duration",
+		"Time spent waiting before retrying memory-mapping of index files",
+		stats.UnitMilliseconds,
+	)
+
+	metricIndexCacheMmapFailures = stats.Int64(
+		"kopia/content/index_cache/mmap_failure_count",
+		"Number of times memory-mapping an index file has failed despite retries",
+		stats.UnitDimensionless,
+	)
+
+	metricIndexCacheCorruptCount = stats.Int64(
+		"kopia/content/index_cache/corrupt_count",
+		"Number of index files in the committed index cache which failed checksum verification and were removed",
+		stats.UnitDimensionless,
+	)
+
+	metricIndexCacheMergeCount = stats.Int64(
+		"kopia/content/index_cache/merge_count",
+		"Number of merged index files written to the committed index cache",
+		stats.UnitDimensionless,
+	)
+
+	metricIndexCacheExpiredCount = stats.Int64(
+		"kopia/content/index_cache/expired_count",
+		"Number of unused index files removed from the committed index cache",
+		stats.UnitDimensionless,
+	)
+)
+
+func init() {
+	if err := view.Register(
+		simpleAggregation(metricIndexCacheOpenCount, view.Count()),
+		simpleAggregation(metricIndexCacheOpenErrors, view.Count()),
+		simpleAggregation(metricIndexCacheOpenDuration, view.Distribution(indexOpenDurationBounds...)),
+		simpleAggregation(metricIndexCacheMmapRetries, view.Sum()),
+		simpleAggregation(metricIndexCacheMmapRetryDuration, view.Sum()),
+		simpleAggregation(metricIndexCacheMmapFailures, view.Count()),
+		simpleAggregation(metricIndexCacheCorruptCount, view.Count()),
+		simpleAggregation(metricIndexCacheMergeCount, view.Count()),
+		simpleAggregation(metricIndexCacheExpiredCount, view.Count()),
+	); err != nil {
+		panic("unable to register opencensus views: " + err.Error())
+	}
+}
@@ -0,0 +1,206 @@
+package content
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+const (
+	sharedIndexCacheLeasesDir   = "leases"
+	sharedIndexCacheLocksDir    = "locks"
+	sharedIndexCacheLeaseSuffix = ".lease"
+	sharedIndexCacheExpireLock  = "expire.lock"
+
+	// sharedIndexCacheLockStripes is the number of lock files protecting downloads of index blobs.
+	sharedIndexCacheLockStripes = 64
+
+	// sharedIndexCacheLockRetryDelay is the delay between attempts to acquire the download lock held by another process.
+	sharedIndexCacheLockRetryDelay = 50 * time.Millisecond
+
+	// sharedIndexCacheLeaseTTL is the time after which the lease of a process which stopped refreshing it is ignored.
+	sharedIndexCacheLeaseTTL = 24 * time.Hour
+)
+
+// sharedCommittedContentIndexCache is a disk cache of index blobs shared by all processes on the host connected to
+// the same repository, such as the CLI and the server.
+//
+// Downloads of index blobs are serialized using file locks, so that each index blob is downloaded only once.
+// Each process records index blobs it uses in its lease file and files used by any process whose lease is
+// recent are never expired.
+type sharedCommittedContentIndexCache struct {
+	diskCommittedContentIndexCache
+
+	leaseID      string
+	useLockFiles bool
+}
+
+// newSharedCommittedContentIndexCache returns the shared cache in a subdirectory of the shared index cache directory
+// specific to the repository, which is identified by the hash of its HMAC secret.
+func newSharedCommittedContentIndexCache(caching *CachingOptions) *sharedCommittedContentIndexCache {
+	h := sha256.Sum256(caching.HMACSecret)
+
+	// the cache may be shared by multiple hosts when it's on a network filesystem.
+	hostname, _ := os.Hostname()
+
+	return &sharedCommittedContentIndexCache{
+		diskCommittedContentIndexCache: diskCommittedContentIndexCache{
+			dirname:     filepath.Join(caching.SharedIndexCacheDirectory, hex.EncodeToString(h[0:8])),
+			disableMmap: caching.DisableIndexMmap,
+		},
+		leaseID:      fmt.Sprintf("%v-%v-%x", hostname, os.Getpid(), clock.Now().UnixNano()),
+		useLockFiles: caching.UseLockFiles,
+	}
+}
+
+// lockIndexBlob acquires the lock guarding the download of the provided index blob by any process
+// sharing the cache and returns the function which releases it.
+func (c *sharedCommittedContentIndexCache) lockIndexBlob(ctx context.Context, indexBlobID blob.ID) (func(), error) {
+	dir := filepath.Join(c.dirname, sharedIndexCacheLocksDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, errors.Wrap(err, "unable to create locks directory")
+	}
+
+	h := sha256.Sum256([]byte(indexBlobID))
+	l := newFileLock(filepath.Join(dir, fmt.Sprintf("%02x.lock", int(h[0])%sharedIndexCacheLockStripes)), c.useLockFiles)
+
+	ok, err := l.TryLockContext(ctx, sharedIndexCacheLockRetryDelay)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to lock index blob %v", indexBlobID)
+	}
+
+	if !ok {
+		return nil, errors.Errorf("unable to lock index blob %v", indexBlobID)
+	}
+
+	return func() {
+		l.Unlock() //nolint:errcheck
+	}, nil
+}
+
+// openIndex opens the index file after updating its modification time, which protects it from being expired by
+// other processes until this process records it in its lease.
+func (c *sharedCommittedContentIndexCache) openIndex(ctx context.Context, indexBlobID blob.ID) (packIndex, error) {
+	now := clock.Now()
+
+	if err := os.Chtimes(c.indexBlobPath(indexBlobID), now, now); err != nil {
+		log(ctx).Debugf("unable to update modification time of %v: %v", indexBlobID, err)
+	}
+
+	return c.diskCommittedContentIndexCache.openIndex(ctx, indexBlobID)
+}
+
+func (c *sharedCommittedContentIndexCache) leasePath(leaseID string) string {
+	return filepath.Join(c.dirname, sharedIndexCacheLeasesDir, leaseID+sharedIndexCacheLeaseSuffix)
+}
+
+func (c *sharedCommittedContentIndexCache) writeLease(used []blob.ID) error {
+	b, err := json.Marshal(used)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal lease")
+	}
+
+	tmpFile, err := writeTempFileAtomic(filepath.Join(c.dirname, sharedIndexCacheLeasesDir), b)
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(os.Rename(tmpFile, c.leasePath(c.leaseID)), "unable to write lease")
+}
+
+// usedByLeases adds names of files used by processes with recent leases to the provided map.
+func (c *sharedCommittedContentIndexCache) usedByLeases(ctx context.Context, used map[string]bool) error {
+	dir := filepath.Join(c.dirname, sharedIndexCacheLeasesDir)
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "unable to list leases")
+	}
+
+	for _, ent := range entries {
+		fname := filepath.Join(dir, ent.Name())
+
+		if !strings.HasSuffix(ent.Name(), sharedIndexCacheLeaseSuffix) {
+			continue
+		}
+
+		if clock.Since(ent.ModTime()) > sharedIndexCacheLeaseTTL {
+			log(ctx).Debugf("removing stale lease %v %v", ent.Name(), ent.ModTime())
+			os.Remove(fname) //nolint:errcheck
+
+			continue
+		}
+
+		b, err := ioutil.ReadFile(fname) //nolint:gosec
+		if err != nil {
+			if os.IsNotExist(err) {
+				// lease was removed concurrently by a process that has closed the repository.
+				continue
+			}
+
+			return errors.Wrap(err, "unable to read lease")
+		}
+
+		var ids []blob.ID
+
+		if err := json.Unmarshal(b, &ids); err != nil {
+			log(ctx).Warningf("ignoring invalid lease %v: %v", ent.Name(), err)
+			continue
+		}
+
+		c.usedFileNames(ids, used)
+	}
+
+	return nil
+}
+
+// expireUnused records index blobs used by this process in its lease and removes index files not used by
+// any process sharing the cache, unless another process is doing that at the same time.
+func (c *sharedCommittedContentIndexCache) expireUnused(ctx context.Context, used []blob.ID) error {
+	if err := c.writeLease(used); err != nil {
+		return err
+	}
+
+	l := newFileLock(filepath.Join(c.dirname, sharedIndexCacheExpireLock), c.useLockFiles)
+
+	ok, err := l.TryLock()
+	if err != nil {
+		return errors.Wrap(err, "unable to acquire expiration lock")
+	}
+
+	if !ok {
+		log(ctx).Debugf("shared index cache is being expired by another process")
+		return nil
+	}
+
+	defer l.Unlock() //nolint:errcheck
+
+	keep := c.usedFileNames(used, nil)
+
+	if err := c.usedByLeases(ctx, keep); err != nil {
+		return err
+	}
+
+	return c.removeUnusedFiles(ctx, keep)
+}
+
+// close removes the lease of this process, which allows other processes to expire index blobs it used.
+func (c *sharedCommittedContentIndexCache) close() error {
+	if err := os.Remove(c.leasePath(c.leaseID)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "unable to remove lease")
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package content
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func TestSharedIndexCacheDownloadsOnce(t *testing.T) {
+	t.Run("AdvisoryLocks", func(t *testing.T) {
+		verifySharedIndexCacheDownloadsOnce(t, &CachingOptions{SharedIndexCacheDirectory: t.TempDir()})
+	})
+
+	t.Run("LockFiles", func(t *testing.T) {
+		verifySharedIndexCacheDownloadsOnce(t, &CachingOptions{SharedIndexCacheDirectory: t.TempDir(), UseLockFiles: true})
+	})
+}
+
+func verifySharedIndexCacheDownloadsOnce(t *testing.T, caching *CachingOptions) {
+	t.Helper()
+
+	ctx := testlogging.Context(t)
+
+	b1 := newCommittedContentIndex(caching, clock.Now)
+	b2 := newCommittedContentIndex(caching, clock.Now)
+
+	fetchCount := 0
+
+	fetch := func(ctx context.Context, indexBlob blob.ID) ([]byte, error) {
+		fetchCount++
+		return []byte("index-data"), nil
+	}
+
+	for _, b := range []*committedContentIndex{b1, b2, b1} {
+		if err := b.downloadToCache(ctx, "n1", fetch); err != nil {
+			t.Fatalf("unable to download: %v", err)
+		}
+	}
+
+	if fetchCount != 1 {
+		t.Errorf("unexpected number of downloads: %v", fetchCount)
+	}
+
+	if has, err := b2.cache.hasIndexBlobID(ctx, "n1"); err != nil || !has {
+		t.Errorf("index blob not found in shared cache: %v %v", has, err)
+	}
+
+	// caches of different repositories are separate.
+	other := newCommittedContentIndex(&CachingOptions{
+		SharedIndexCacheDirectory: caching.SharedIndexCacheDirectory,
+		HMACSecret:                []byte("other-secret"),
+		UseLockFiles:              caching.UseLockFiles,
+	}, clock.Now)
+
+	if has, err := other.cache.hasIndexBlobID(ctx, "n1"); err != nil || has {
+		t.Errorf("index blob of another repository found in shared cache: %v %v", has, err)
+	}
+}
+
+func TestSharedIndexCacheExpiration(t *testing.T) {
+	ctx := testlogging.Context(t)
+	caching := &CachingOptions{SharedIndexCacheDirectory: t.TempDir()}
+
+	c1 := newCommittedContentIndex(caching, clock.Now).cache.(*sharedCommittedContentIndexCache)
+	c2 := newCommittedContentIndex(caching, clock.Now).cache.(*sharedCommittedContentIndexCache)
+
+	for _, id := range []blob.ID{"n1", "n2", "n3"} {
+		if err := c1.addContentToCache(ctx, id, []byte("index-data")); err != nil {
+			t.Fatal(err)
+		}
+
+		// make the files old enough to be expired.
+		old := clock.Now().Add(-2 * unusedCommittedContentIndexCleanupTime)
+		if err := os.Chtimes(c1.indexBlobPath(id), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// each process uses a different index blob, which must not be removed by the other one.
+	if err := c2.writeLease([]blob.ID{"n2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c1.expireUnused(ctx, []blob.ID{"n1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c2.expireUnused(ctx, []blob.ID{"n2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	verifyCached := func(id blob.ID, want bool) {
+		t.Helper()
+
+		if has, err := c1.hasIndexBlobID(ctx, id); err != nil || has != want {
+			t.Errorf("unexpected cache state of %v: %v %v, want %v", id, has, err, want)
+		}
+	}
+
+	verifyCached("n1", true)
+	verifyCached("n2", true)
+	verifyCached("n3", false)
+
+	// after the first process closes the repository, its index blobs can be expired.
+	if err := c1.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c2.expireUnused(ctx, []blob.ID{"n2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	verifyCached("n1", false)
+	verifyCached("n2", true)
+
+	// leases of processes which stopped refreshing them are ignored.
+	if err := c1.expireUnused(ctx, []blob.ID{"n1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := clock.Now().Add(-2 * sharedIndexCacheLeaseTTL)
+	if err := os.Chtimes(filepath.Join(c2.dirname, sharedIndexCacheLeasesDir, c2.leaseID+sharedIndexCacheLeaseSuffix), stale, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c1.expireUnused(ctx, []blob.ID{"n1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	verifyCached("n2", false)
+}
@@ -5,6 +5,7 @@
 	"os"
 	"path/filepath"
 
+	"github.com/kopia/kopia/internal/blockcache"
 	"github.com/kopia/kopia/internal/ctxutil"
 	"github.com/kopia/kopia/repo/blob"
 	"github.com/kopia/kopia/repo/blob/filesystem"
@@ -15,6 +16,9 @@
 type contentCache interface {
 	close()
 	getContent(ctx context.Context, cacheKey cacheKey, blobID blob.ID, offset, length int64) ([]byte, error)
+
+	// evictCorrupted removes the cached entry which failed verification, so that it's refetched from storage.
+	evictCorrupted(ctx context.Context, cacheKey cacheKey, blobID blob.ID)
 }
 
 func newCacheStorageOrNil(ctx context.Context, cacheDir string, maxBytes int64, subdir string) (blob.Storage, error) {
@@ -42,3 +46,30 @@ func newCacheStorageOrNil(ctx context.Context, cacheDir string, maxBytes int64,
 
 	return cacheStorage, nil
 }
+
+// newDataCacheStorageOrNil returns the storage for the data cache, which is stored in the block cache file
+// if one is configured, falling back to the cache directory if it can't be opened.
+func newDataCacheStorageOrNil(ctx context.Context, caching *CachingOptions) (blob.Storage, *blockcache.Storage, error) {
+	if caching.BlockCacheFile != "" && caching.MaxCacheSizeBytes > 0 {
+		bc, err := blockcache.Open(ctx, caching.BlockCacheFile, caching.MaxCacheSizeBytes)
+		if err == nil {
+			return bc, bc, nil
+		}
+
+		log(ctx).Warningf("unable to open block cache, using cache directory instead: %v", err)
+	}
+
+	st, err := newCacheStorageOrNil(ctx, caching.CacheDirectory, caching.MaxCacheSizeBytes, cacheNameData)
+
+	return st, nil, err
+}
+
+func closeBlockCache(ctx context.Context, bc *blockcache.Storage) {
+	if bc == nil {
+		return
+	}
+
+	if err := bc.Close(ctx); err != nil {
+		log(ctx).Warningf("error closing block cache: %v", err)
+	}
+}
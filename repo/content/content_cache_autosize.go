@@ -0,0 +1,200 @@
+package content
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kopia/kopia/internal/diskspace"
+	"github.com/kopia/kopia/internal/units"
+)
+
+const (
+	defaultAutoSizeFrequency = 10 * time.Minute
+
+	// metadataCacheBytesPerIndexByte is the estimated ratio of metadata contents to index size, which
+	// approximates the amount of metadata read when browsing or snapshotting the entire repository.
+	metadataCacheBytesPerIndexByte = 4
+
+	// missedBytesMultiplier is the headroom added for each byte fetched from the storage since the
+	// previous adjustment, which makes the cache grow until it holds the working set.
+	missedBytesMultiplier = 2
+
+	// maxGrowthFactor limits how much the cache can grow in a single adjustment, so that a burst of misses,
+	// such as a one-time read of the entire repository, does not immediately claim the disk.
+	maxGrowthFactor = 2
+
+	// shrinkFraction is the fraction of the difference between the size of the cache and its working set
+	// by which larger caches are shrunk in a single adjustment.
+	shrinkFraction = 0.25
+
+	// maxCacheDiskFraction is the maximum fraction of free disk space (including space already used by the cache)
+	// that automatically sized caches can occupy.
+	maxCacheDiskFraction = 0.5
+)
+
+// resizableCache is implemented by caches whose maximum size can be changed at runtime.
+type resizableCache interface {
+	usage() (retained, recent, missed int64)
+	maxSize() int64
+	setMaxSize(n int64)
+}
+
+// cacheUsage describes the observed usage of a single cache.
+type cacheUsage struct {
+	min      int64 // configured size, which is never reduced
+	current  int64 // current maximum size of the cache
+	retained int64 // bytes currently retained in the cache
+	recent   int64 // bytes in the cache which have been used recently
+	missed   int64 // bytes fetched from the storage since the previous adjustment
+}
+
+// adjustedSize returns the size of the cache moved toward its working set, which consists of recently used
+// bytes and bytes missed since the previous adjustment, but not below the provided minimum.
+func (u cacheUsage) adjustedSize(minSize int64) int64 {
+	minSize = maxInt64(minSize, u.min)
+	current := maxInt64(u.current, minSize)
+	target := maxInt64(minSize, u.recent+u.missed*missedBytesMultiplier)
+
+	switch {
+	case target > current:
+		return minInt64(target, current*maxGrowthFactor)
+
+	case target < current:
+		return maxInt64(target, current-int64(float64(current-target)*shrinkFraction))
+
+	default:
+		return current
+	}
+}
+
+// autoCacheSizes computes sizes of data and metadata caches based on their usage, the size of indexes
+// and available disk space, which is -1 if unknown.
+func autoCacheSizes(indexBytes int64, data, metadata cacheUsage, availableDisk int64) (dataSize, metadataSize int64) {
+	dataSize = data.adjustedSize(0)
+	metadataSize = metadata.adjustedSize(indexBytes * metadataCacheBytesPerIndexByte)
+
+	if availableDisk < 0 {
+		return dataSize, metadataSize
+	}
+
+	budget := int64(float64(availableDisk+data.retained+metadata.retained) * maxCacheDiskFraction)
+	if dataSize+metadataSize <= budget {
+		return dataSize, metadataSize
+	}
+
+	// metadata is read much more frequently than data, so it gets the budget first.
+	metadataSize = maxInt64(metadata.min, minInt64(metadataSize, budget-data.min))
+	dataSize = maxInt64(data.min, budget-metadataSize)
+
+	return dataSize, metadataSize
+}
+
+// cacheAutoSizer periodically adjusts maximum sizes of data and metadata caches.
+type cacheAutoSizer struct {
+	// values aligned to 8-bytes due to atomic access
+	indexBytes int64
+
+	cacheDirectory string
+	data           resizableCache
+	metadata       resizableCache
+	minData        int64
+	minMetadata    int64
+	frequency      time.Duration
+
+	wg     sync.WaitGroup
+	closed chan struct{}
+}
+
+// setIndexBytes records the total size of index blobs in the repository.
+func (s *cacheAutoSizer) setIndexBytes(n int64) {
+	atomic.StoreInt64(&s.indexBytes, n)
+}
+
+func (s *cacheAutoSizer) adjust(ctx context.Context) {
+	available, err := diskspace.Available(s.cacheDirectory)
+	if err != nil {
+		log(ctx).Debugf("unable to determine free space in cache directory: %v", err)
+
+		available = -1
+	}
+
+	data := cacheUsage{min: s.minData, current: s.data.maxSize()}
+	data.retained, data.recent, data.missed = s.data.usage()
+
+	metadata := cacheUsage{min: s.minMetadata, current: s.metadata.maxSize()}
+	metadata.retained, metadata.recent, metadata.missed = s.metadata.usage()
+
+	dataSize, metadataSize := autoCacheSizes(atomic.LoadInt64(&s.indexBytes), data, metadata, available)
+
+	if dataSize != s.data.maxSize() || metadataSize != s.metadata.maxSize() {
+		log(ctx).Debugf("adjusting cache sizes, data: %v metadata: %v", units.BytesStringBase10(dataSize), units.BytesStringBase10(metadataSize))
+	}
+
+	s.data.setMaxSize(dataSize)
+	s.metadata.setMaxSize(metadataSize)
+}
+
+func (s *cacheAutoSizer) run(ctx context.Context) {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.closed:
+			return
+
+		case <-time.After(s.frequency):
+			s.adjust(ctx)
+		}
+	}
+}
+
+func (s *cacheAutoSizer) close() {
+	close(s.closed)
+	s.wg.Wait()
+}
+
+// newCacheAutoSizer starts automatic sizing of the provided caches or returns nil if any of them can't be resized.
+func newCacheAutoSizer(ctx context.Context, cacheDirectory string, data, metadata contentCache) *cacheAutoSizer {
+	dc, ok1 := data.(resizableCache)
+	mc, ok2 := metadata.(resizableCache)
+
+	if !ok1 || !ok2 {
+		return nil
+	}
+
+	s := &cacheAutoSizer{
+		cacheDirectory: cacheDirectory,
+		data:           dc,
+		metadata:       mc,
+		minData:        dc.maxSize(),
+		minMetadata:    mc.maxSize(),
+		frequency:      defaultAutoSizeFrequency,
+		closed:         make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+
+	go s.run(ctx)
+
+	return s
+}
+
+func maxInt64(v int64, others ...int64) int64 {
+	for _, o := range others {
+		if o > v {
+			v = o
+		}
+	}
+
+	return v
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,143 @@
+package content
+
+import (
+	"testing"
+
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+func TestAutoCacheSizes(t *testing.T) {
+	const (
+		mb = int64(1e6)
+		gb = int64(1e9)
+	)
+
+	cases := []struct {
+		desc         string
+		indexBytes   int64
+		data         cacheUsage
+		metadata     cacheUsage
+		available    int64
+		wantData     int64
+		wantMetadata int64
+	}{
+		{
+			desc:         "idle",
+			data:         cacheUsage{min: 100 * mb, current: 100 * mb},
+			metadata:     cacheUsage{min: 100 * mb, current: 100 * mb},
+			available:    -1,
+			wantData:     100 * mb,
+			wantMetadata: 100 * mb,
+		},
+		{
+			desc:         "large indexes",
+			indexBytes:   1 * gb,
+			data:         cacheUsage{min: 100 * mb, current: 100 * mb},
+			metadata:     cacheUsage{min: 100 * mb, current: 100 * mb},
+			available:    100 * gb,
+			wantData:     100 * mb,
+			wantMetadata: 4 * gb,
+		},
+		{
+			desc:      "growing working set",
+			data:      cacheUsage{min: 100 * mb, current: 100 * mb, retained: 100 * mb, recent: 100 * mb, missed: 500 * mb},
+			metadata:  cacheUsage{min: 100 * mb, current: 150 * mb, retained: 100 * mb, recent: 100 * mb, missed: 25 * mb},
+			available: 100 * gb,
+			// data cache would need 1100MB, but its growth is limited.
+			wantData:     200 * mb,
+			wantMetadata: 150 * mb,
+		},
+		{
+			desc:      "shrinking working set",
+			data:      cacheUsage{min: 100 * mb, current: 1 * gb, retained: 1 * gb, recent: 200 * mb},
+			metadata:  cacheUsage{min: 100 * mb, current: 200 * mb, retained: 200 * mb},
+			available: 100 * gb,
+			// caches shrink gradually toward the working set, but not below configured sizes.
+			wantData:     800 * mb,
+			wantMetadata: 175 * mb,
+		},
+		{
+			desc:       "limited disk space",
+			indexBytes: 1 * gb,
+			data:       cacheUsage{min: 100 * mb, current: 4 * gb, retained: 1 * gb, recent: 1 * gb, missed: 5 * gb},
+			metadata:   cacheUsage{min: 100 * mb, current: 100 * mb},
+			available:  5 * gb,
+			// half of 6GB is split between metadata, which is limited to leave minimum for data.
+			wantData:     100 * mb,
+			wantMetadata: 2900 * mb,
+		},
+		{
+			desc:         "no disk space",
+			data:         cacheUsage{min: 100 * mb, current: 100 * mb, retained: 1 * gb, recent: 1 * gb, missed: 5 * gb},
+			metadata:     cacheUsage{min: 200 * mb, current: 200 * mb, missed: 5 * gb},
+			available:    0,
+			wantData:     100 * mb,
+			wantMetadata: 400 * mb,
+		},
+	}
+
+	for _, tc := range cases {
+		gotData, gotMetadata := autoCacheSizes(tc.indexBytes, tc.data, tc.metadata, tc.available)
+		if gotData != tc.wantData || gotMetadata != tc.wantMetadata {
+			t.Errorf("%v: got data=%v metadata=%v, want data=%v metadata=%v", tc.desc, gotData, gotMetadata, tc.wantData, tc.wantMetadata)
+		}
+	}
+}
+
+type fakeResizableCache struct {
+	contentCache
+
+	retained, recent, missed, max int64
+}
+
+func (c *fakeResizableCache) usage() (retained, recent, missed int64) {
+	return c.retained, c.recent, c.missed
+}
+
+func (c *fakeResizableCache) maxSize() int64     { return c.max }
+func (c *fakeResizableCache) setMaxSize(n int64) { c.max = n }
+
+func TestCacheAutoSizerAdjust(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	data := &fakeResizableCache{retained: 1000, recent: 1000, missed: 1000, max: 1000}
+	metadata := &fakeResizableCache{max: 500}
+
+	s := newCacheAutoSizer(ctx, t.TempDir(), data, metadata)
+	if s == nil {
+		t.Fatalf("auto-sizer was not created")
+	}
+
+	defer s.close()
+
+	s.setIndexBytes(1000)
+	s.adjust(ctx)
+
+	// growth of data cache is limited.
+	if got, want := data.max, int64(2000); got != want {
+		t.Errorf("unexpected data cache size: %v, want %v", got, want)
+	}
+
+	if got, want := metadata.max, int64(4000); got != want {
+		t.Errorf("unexpected metadata cache size: %v, want %v", got, want)
+	}
+
+	s.adjust(ctx)
+
+	if got, want := data.max, int64(3000); got != want {
+		t.Errorf("unexpected data cache size: %v, want %v", got, want)
+	}
+
+	// data cache decays toward its configured size when it's no longer used.
+	data.recent, data.missed = 0, 0
+
+	s.adjust(ctx)
+
+	if got, want := data.max, int64(2500); got != want {
+		t.Errorf("unexpected data cache size after decay: %v, want %v", got, want)
+	}
+
+	if newCacheAutoSizer(ctx, t.TempDir(), passthroughContentCache{}, metadata) != nil {
+		t.Errorf("auto-sizer should not be created for caches which can't be resized")
+	}
+}
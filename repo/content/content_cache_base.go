@@ -8,15 +8,25 @@
 	"time"
 
 	"github.com/pkg/errors"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 
 	"github.com/kopia/kopia/internal/clock"
 	"github.com/kopia/kopia/repo/blob"
 )
 
 const (
+	// names of caches, which are also names of their subdirectories in the cache directory.
+	cacheNameData     = "contents"
+	cacheNameMetadata = "metadata"
+
 	defaultSweepFrequency = 1 * time.Minute
 	defaultTouchThreshold = 10 * time.Minute
 	mutexAgeCutoff        = 5 * time.Minute
+
+	// workingSetDuration is the period in which cached items must have been used to be considered
+	// a part of the working set, which must be longer than touchThreshold.
+	workingSetDuration = 1 * time.Hour
 )
 
 type mutexLRU struct {
@@ -28,8 +38,15 @@ type mutexLRU struct {
 
 // cacheBase provides common implementation for per-content and per-blob caches.
 type cacheBase struct {
+	// values aligned to 8-bytes due to atomic access
+	maxSizeBytes  int64
+	retainedBytes int64 // number of bytes retained after the last sweep
+	recentBytes   int64 // number of bytes used within workingSetDuration as of the last sweep
+	missedBytes   int64 // number of bytes fetched from the underlying storage since the last call to usage()
+
+	name           string
 	cacheStorage   blob.Storage
-	maxSizeBytes   int64
+	stats          *Stats
 	sweepFrequency time.Duration
 	touchThreshold time.Duration
 
@@ -51,6 +68,39 @@ func (c *cacheBase) touch(ctx context.Context, blobID blob.ID) {
 	}
 }
 
+// removeCorrupted removes the cache entry that failed verification and records the corruption.
+func (c *cacheBase) removeCorrupted(ctx context.Context, blobID blob.ID) {
+	stats.Record(ctx, metricContentCacheCorruptCount.M(1))
+
+	if c.stats != nil {
+		c.stats.foundCorruptCacheEntry()
+	}
+
+	if err := c.cacheStorage.DeleteBlob(ctx, blobID); err != nil && !errors.Is(err, blob.ErrBlobNotFound) {
+		log(ctx).Warningf("unable to remove corrupted cache item %v: %v", blobID, err)
+	}
+}
+
+// recordMiss records the number of bytes fetched from the underlying storage because they were not found in the cache.
+func (c *cacheBase) recordMiss(n int) {
+	atomic.AddInt64(&c.missedBytes, int64(n))
+}
+
+// usage returns the number of bytes retained in the cache and the number of recently used bytes as of the most
+// recent sweep and the number of bytes missed since the previous call.
+func (c *cacheBase) usage() (retained, recent, missed int64) {
+	return atomic.LoadInt64(&c.retainedBytes), atomic.LoadInt64(&c.recentBytes), atomic.SwapInt64(&c.missedBytes, 0)
+}
+
+func (c *cacheBase) maxSize() int64 {
+	return atomic.LoadInt64(&c.maxSizeBytes)
+}
+
+// setMaxSize changes the maximum size of the cache, which takes effect on the next sweep.
+func (c *cacheBase) setMaxSize(n int64) {
+	atomic.StoreInt64(&c.maxSizeBytes, n)
+}
+
 func (c *cacheBase) close() {
 	close(c.closed)
 	c.asyncWG.Wait()
@@ -122,31 +172,63 @@ func (c *cacheBase) sweepDirectory(ctx context.Context) (err error) {
 
 	var h contentMetadataHeap
 
-	var totalRetainedSize int64
+	var totalRetainedSize, recentSize, scannedFiles, evictedFiles, evictedBytes int64
+
+	maxSizeBytes := c.maxSize()
+	recentCutoff := t0.Add(-workingSetDuration)
 
 	err = c.cacheStorage.ListBlobs(ctx, "", func(it blob.Metadata) error {
 		heap.Push(&h, it)
 		totalRetainedSize += it.Length
+		scannedFiles++
+
+		if it.Timestamp.After(recentCutoff) {
+			recentSize += it.Length
+		}
 
-		if totalRetainedSize > c.maxSizeBytes {
+		if totalRetainedSize > maxSizeBytes {
 			oldest := heap.Pop(&h).(blob.Metadata)
 			if delerr := c.cacheStorage.DeleteBlob(ctx, oldest.BlobID); delerr != nil {
 				log(ctx).Warningf("unable to remove %v: %v", oldest.BlobID, delerr)
 			} else {
 				totalRetainedSize -= oldest.Length
+				evictedFiles++
+				evictedBytes += oldest.Length
 			}
 		}
 		return nil
 	})
+
+	dur := clock.Since(t0)
+
 	if err != nil {
+		_ = stats.RecordWithTags(ctx, c.tagMutators(), metricCacheSweepErrors.M(1))
+
 		return errors.Wrap(err, "error listing cache")
 	}
 
-	log(ctx).Debugf("finished sweeping directory in %v and retained %v/%v bytes (%v %%)", clock.Since(t0), totalRetainedSize, c.maxSizeBytes, 100*totalRetainedSize/c.maxSizeBytes)
+	atomic.StoreInt64(&c.retainedBytes, totalRetainedSize)
+	atomic.StoreInt64(&c.recentBytes, recentSize)
+
+	_ = stats.RecordWithTags(ctx, c.tagMutators(),
+		metricCacheSweepDuration.M(dur.Milliseconds()),
+		metricCacheSweepScannedFiles.M(scannedFiles),
+		metricCacheSweepEvictedFiles.M(evictedFiles),
+		metricCacheSweepEvictedBytes.M(evictedBytes),
+		metricCacheRetainedBytes.M(totalRetainedSize),
+		metricCacheMaxBytes.M(maxSizeBytes),
+	)
+
+	log(ctx).Debugf("finished sweeping %v cache in %v, scanned %v files, evicted %v files (%v bytes) and retained %v/%v bytes (%v %%)",
+		c.name, dur, scannedFiles, evictedFiles, evictedBytes, totalRetainedSize, maxSizeBytes, 100*totalRetainedSize/maxSizeBytes)
 
 	return nil
 }
 
+func (c *cacheBase) tagMutators() []tag.Mutator {
+	return []tag.Mutator{tag.Upsert(tagKeyCache, c.name)}
+}
+
 func (c *cacheBase) sweepMutexes() {
 	cutoffTime := clock.Now().Add(-mutexAgeCutoff).UnixNano()
 
@@ -162,9 +244,11 @@ func (c *cacheBase) sweepMutexes() {
 	})
 }
 
-func newContentCacheBase(ctx context.Context, cacheStorage blob.Storage, maxSizeBytes int64, touchThreshold, sweepFrequency time.Duration) (*cacheBase, error) {
+func newContentCacheBase(ctx context.Context, name string, cacheStorage blob.Storage, maxSizeBytes int64, touchThreshold, sweepFrequency time.Duration, contentStats *Stats) (*cacheBase, error) {
 	c := &cacheBase{
+		name:           name,
 		cacheStorage:   cacheStorage,
+		stats:          contentStats,
 		maxSizeBytes:   maxSizeBytes,
 		closed:         make(chan struct{}),
 		touchThreshold: touchThreshold,
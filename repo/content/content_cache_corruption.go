@@ -0,0 +1,64 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/natefinch/atomic"
+	"github.com/pkg/errors"
+)
+
+// corruptCacheEntriesFile is the name of the file in the cache directory holding the total number of corrupted
+// cache entries found by all processes using the cache. The count is approximate, since concurrent updates
+// by multiple processes may be lost.
+const corruptCacheEntriesFile = "corrupt-entries"
+
+// CorruptCacheEntriesTotal returns the total number of corrupted entries which have been found in the provided
+// cache directory, evicted and refetched.
+func CorruptCacheEntriesTotal(cacheDir string) (int64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(cacheDir, corruptCacheEntriesFile)) //nolint:gosec
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to read count of corrupted cache entries")
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid count of corrupted cache entries")
+	}
+
+	return n, nil
+}
+
+func addCorruptCacheEntries(cacheDir string, n int64) error {
+	total, err := CorruptCacheEntriesTotal(cacheDir)
+	if err != nil {
+		// start over when the count can't be read.
+		total = 0
+	}
+
+	total += n
+
+	return atomic.WriteFile(filepath.Join(cacheDir, corruptCacheEntriesFile), bytes.NewReader([]byte(strconv.FormatInt(total, 10)+"\n")))
+}
+
+// recordCorruptCacheEntries adds corrupted cache entries found by the manager to the total count
+// kept in the cache directory.
+func (bm *Manager) recordCorruptCacheEntries(ctx context.Context) {
+	n := bm.Stats.CorruptCacheEntries()
+	if n == 0 || bm.CachingOptions.CacheDirectory == "" {
+		return
+	}
+
+	if err := addCorruptCacheEntries(bm.CachingOptions.CacheDirectory, int64(n)); err != nil {
+		log(ctx).Warningf("unable to record corrupted cache entries: %v", err)
+	}
+}
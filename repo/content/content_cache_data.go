@@ -50,6 +50,7 @@ func (c *contentCacheForData) getContent(ctx context.Context, cacheKey cacheKey,
 		stats.Record(ctx, metricContentCacheMissErrors.M(1))
 	} else {
 		stats.Record(ctx, metricContentCacheMissBytes.M(int64(len(b))))
+		c.recordMiss(len(b))
 	}
 
 	if errors.Is(err, blob.ErrBlobNotFound) {
@@ -63,6 +64,7 @@ func (c *contentCacheForData) getContent(ctx context.Context, cacheKey cacheKey,
 			blob.WithUploadProgressCallback(ctx, nil),
 			blob.ID(cacheKey),
 			gather.FromSlice(hmac.Append(b, c.hmacSecret)),
+			blob.PutOptions{},
 		); puterr != nil {
 			stats.Record(ctx, metricContentCacheStoreErrors.M(1))
 			log(ctx).Warningf("unable to write cache item %v: %v", cacheKey, puterr)
@@ -83,8 +85,9 @@ func (c *contentCacheForData) readAndVerifyCacheContent(ctx context.Context, cac
 			return b
 		}
 
-		// ignore malformed contents
+		// treat malformed contents as cache miss and evict them, so they are refetched from storage.
 		log(ctx).Warningf("malformed content %v: %v", cacheKey, err)
+		c.removeCorrupted(ctx, blob.ID(cacheKey))
 
 		return nil
 	}
@@ -96,12 +99,16 @@ func (c *contentCacheForData) readAndVerifyCacheContent(ctx context.Context, cac
 	return nil
 }
 
-func newContentCacheForData(ctx context.Context, st, cacheStorage blob.Storage, maxSizeBytes int64, hmacSecret []byte) (contentCache, error) {
+func (c *contentCacheForData) evictCorrupted(ctx context.Context, cacheKey cacheKey, blobID blob.ID) {
+	c.removeCorrupted(ctx, blob.ID(adjustCacheKey(cacheKey)))
+}
+
+func newContentCacheForData(ctx context.Context, st, cacheStorage blob.Storage, maxSizeBytes int64, hmacSecret []byte, contentStats *Stats) (contentCache, error) {
 	if cacheStorage == nil {
 		return passthroughContentCache{st}, nil
 	}
 
-	cb, err := newContentCacheBase(ctx, cacheStorage, maxSizeBytes, defaultTouchThreshold, defaultSweepFrequency)
+	cb, err := newContentCacheBase(ctx, cacheNameData, cacheStorage, maxSizeBytes, defaultTouchThreshold, defaultSweepFrequency, contentStats)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to create base cache")
 	}
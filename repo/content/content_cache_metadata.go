@@ -2,6 +2,7 @@
 
 import (
 	"context"
+	"sort"
 
 	"github.com/pkg/errors"
 	"go.opencensus.io/stats"
@@ -49,6 +50,51 @@ func (c *contentCacheForMetadata) sync(ctx context.Context) error {
 	return eg.Wait()
 }
 
+// prefetchRecent fetches the most recent metadata blobs into cache, until their total size reaches maxBytes.
+func (c *contentCacheForMetadata) prefetchRecent(ctx context.Context, maxBytes int64) error {
+	var blobs []blob.Metadata
+
+	if err := c.st.ListBlobs(ctx, PackBlobIDPrefixSpecial, func(bm blob.Metadata) error {
+		blobs = append(blobs, bm)
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "error listing blobs")
+	}
+
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].Timestamp.After(blobs[j].Timestamp)
+	})
+
+	sem := make(chan struct{}, metadataCacheSyncParallelism)
+
+	var (
+		eg    errgroup.Group
+		total int64
+	)
+
+	for _, bm := range blobs {
+		if total+bm.Length > maxBytes {
+			break
+		}
+
+		total += bm.Length
+		blobID := bm.BlobID
+
+		// acquire semaphore
+		sem <- struct{}{}
+		eg.Go(func() error {
+			defer func() {
+				<-sem
+			}()
+
+			_, err := c.getContent(ctx, "dummy", blobID, 0, 1)
+			return err
+		})
+	}
+
+	return eg.Wait()
+}
+
 func (c *contentCacheForMetadata) getContent(ctx context.Context, cacheKey cacheKey, blobID blob.ID, offset, length int64) ([]byte, error) {
 	m := c.perItemMutex(blobID)
 	m.Lock()
@@ -77,6 +123,7 @@ func (c *contentCacheForMetadata) getContent(ctx context.Context, cacheKey cache
 		stats.Record(ctx, metricContentCacheMissErrors.M(1))
 	} else {
 		stats.Record(ctx, metricContentCacheMissBytes.M(int64(len(blobData))))
+		c.recordMiss(len(blobData))
 	}
 
 	if errors.Is(err, blob.ErrBlobNotFound) {
@@ -94,6 +141,7 @@ func (c *contentCacheForMetadata) getContent(ctx context.Context, cacheKey cache
 			blob.WithUploadProgressCallback(ctx, nil),
 			blobID,
 			gather.FromSlice(blobData),
+			blob.PutOptions{},
 		); puterr != nil {
 			stats.Record(ctx, metricContentCacheStoreErrors.M(1))
 			log(ctx).Warningf("unable to write cache item %v: %v", blobID, puterr)
@@ -111,12 +159,20 @@ func (c *contentCacheForMetadata) getContent(ctx context.Context, cacheKey cache
 	return blobData[offset : offset+length], nil
 }
 
-func newContentCacheForMetadata(ctx context.Context, st, cacheStorage blob.Storage, maxSizeBytes int64) (contentCache, error) {
+func (c *contentCacheForMetadata) evictCorrupted(ctx context.Context, cacheKey cacheKey, blobID blob.ID) {
+	m := c.perItemMutex(blobID)
+	m.Lock()
+	defer m.Unlock()
+
+	c.removeCorrupted(ctx, blobID)
+}
+
+func newContentCacheForMetadata(ctx context.Context, st, cacheStorage blob.Storage, maxSizeBytes int64, contentStats *Stats) (contentCache, error) {
 	if cacheStorage == nil {
 		return passthroughContentCache{st}, nil
 	}
 
-	cb, err := newContentCacheBase(ctx, cacheStorage, maxSizeBytes, defaultTouchThreshold, defaultSweepFrequency)
+	cb, err := newContentCacheBase(ctx, cacheNameMetadata, cacheStorage, maxSizeBytes, defaultTouchThreshold, defaultSweepFrequency, contentStats)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to create base cache")
 	}
@@ -3,8 +3,15 @@
 import (
 	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
 )
 
+// tagKeyCache identifies the cache (contents or metadata) in metrics of cache sweeps.
+var tagKeyCache = tag.MustNewKey("cache")
+
+// sweepDurationBounds are the bounds of the distribution of cache sweep durations in milliseconds.
+var sweepDurationBounds = []float64{10, 50, 100, 500, 1000, 5000, 10000, 30000, 60000} // nolint:gomnd
+
 // content cache metrics.
 var (
 	metricContentCacheHitCount = stats.Int64(
@@ -42,8 +49,69 @@
 		"Number of time content could not be saved in the cache",
 		stats.UnitDimensionless,
 	)
+
+	metricContentCacheCorruptCount = stats.Int64(
+		"kopia/content/cache/corrupt_count",
+		"Number of time corrupted content was found in the cache and evicted",
+		stats.UnitDimensionless,
+	)
+)
+
+// cache sweep metrics.
+var (
+	metricCacheSweepDuration = stats.Int64(
+		"kopia/content/cache/sweep_duration",
+		"Duration of cache sweeps",
+		stats.UnitMilliseconds,
+	)
+
+	metricCacheSweepErrors = stats.Int64(
+		"kopia/content/cache/sweep_error_count",
+		"Number of cache sweeps which have failed",
+		stats.UnitDimensionless,
+	)
+
+	metricCacheSweepScannedFiles = stats.Int64(
+		"kopia/content/cache/sweep_scanned_files",
+		"Number of files scanned by cache sweeps",
+		stats.UnitDimensionless,
+	)
+
+	metricCacheSweepEvictedFiles = stats.Int64(
+		"kopia/content/cache/sweep_evicted_files",
+		"Number of files evicted by cache sweeps",
+		stats.UnitDimensionless,
+	)
+
+	metricCacheSweepEvictedBytes = stats.Int64(
+		"kopia/content/cache/sweep_evicted_bytes",
+		"Number of bytes evicted by cache sweeps",
+		stats.UnitBytes,
+	)
+
+	metricCacheRetainedBytes = stats.Int64(
+		"kopia/content/cache/retained_bytes",
+		"Number of bytes retained in the cache after the most recent sweep",
+		stats.UnitBytes,
+	)
+
+	metricCacheMaxBytes = stats.Int64(
+		"kopia/content/cache/max_bytes",
+		"Maximum size of the cache at the time of the most recent sweep",
+		stats.UnitBytes,
+	)
 )
 
+func aggregateByCache(m stats.Measure, agg *view.Aggregation) *view.View {
+	return &view.View{
+		Name:        m.Name(),
+		Aggregation: agg,
+		Description: m.Description(),
+		Measure:     m,
+		TagKeys:     []tag.Key{tagKeyCache},
+	}
+}
+
 func init() {
 	if err := view.Register(
 		simpleAggregation(metricContentCacheHitCount, view.Count()),
@@ -52,6 +120,14 @@ func init() {
 		simpleAggregation(metricContentCacheMissBytes, view.Sum()),
 		simpleAggregation(metricContentCacheMissErrors, view.Count()),
 		simpleAggregation(metricContentCacheStoreErrors, view.Count()),
+		simpleAggregation(metricContentCacheCorruptCount, view.Count()),
+		aggregateByCache(metricCacheSweepDuration, view.Distribution(sweepDurationBounds...)),
+		aggregateByCache(metricCacheSweepErrors, view.Count()),
+		aggregateByCache(metricCacheSweepScannedFiles, view.Sum()),
+		aggregateByCache(metricCacheSweepEvictedFiles, view.Sum()),
+		aggregateByCache(metricCacheSweepEvictedBytes, view.Sum()),
+		aggregateByCache(metricCacheRetainedBytes, view.LastValue()),
+		aggregateByCache(metricCacheMaxBytes, view.LastValue()),
 	); err != nil {
 		panic("unable to register opencensus views: " + err.Error())
 	}
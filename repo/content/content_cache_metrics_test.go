@@ -0,0 +1,88 @@
+package content
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"go.opencensus.io/stats/view"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/faketime"
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func TestCacheSweepMetrics(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	ta := faketime.NewTimeAdvance(clock.Now().Add(-time.Minute), time.Second)
+	cacheStorage := blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, ta.NowFunc())
+
+	const cacheName = "sweep-metrics-test"
+
+	cb, err := newContentCacheBase(ctx, cacheName, cacheStorage, 10000, 0, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("unable to create base cache: %v", err)
+	}
+
+	defer cb.close()
+
+	for _, id := range []blob.ID{"a", "b", "c"} {
+		assertNoError(t, cacheStorage.PutBlob(ctx, id, gather.FromSlice(bytes.Repeat([]byte{1}, 4000)), blob.PutOptions{}))
+	}
+
+	assertNoError(t, cb.sweepDirectory(ctx))
+
+	retained, recent, _ := cb.usage()
+	if retained != 8000 {
+		t.Errorf("unexpected retained bytes: %v", retained)
+	}
+
+	// recently used items count toward the working set even when they're evicted.
+	if recent != 12000 {
+		t.Errorf("unexpected recently used bytes: %v", recent)
+	}
+
+	cases := map[string]float64{
+		metricCacheSweepScannedFiles.Name(): 3,
+		metricCacheSweepEvictedFiles.Name(): 1,
+		metricCacheSweepEvictedBytes.Name(): 4000,
+		metricCacheRetainedBytes.Name():     8000,
+	}
+
+	for name, want := range cases {
+		if got := cacheMetricValue(t, name, cacheName); got != want {
+			t.Errorf("unexpected value of %v: %v, want %v", name, got, want)
+		}
+	}
+}
+
+// cacheMetricValue returns the value of the metric with the provided name for the cache.
+func cacheMetricValue(t *testing.T, name, cacheName string) float64 {
+	t.Helper()
+
+	rows, err := view.RetrieveData(name)
+	if err != nil {
+		t.Fatalf("unable to retrieve %v: %v", name, err)
+	}
+
+	for _, r := range rows {
+		if len(r.Tags) != 1 || r.Tags[0].Value != cacheName {
+			continue
+		}
+
+		switch d := r.Data.(type) {
+		case *view.SumData:
+			return d.Value
+		case *view.LastValueData:
+			return d.Value
+		default:
+			t.Fatalf("unexpected data of %v: %T", name, r.Data)
+		}
+	}
+
+	return 0
+}
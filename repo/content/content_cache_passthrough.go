@@ -13,6 +13,9 @@ type passthroughContentCache struct {
 
 func (c passthroughContentCache) close() {}
 
+func (c passthroughContentCache) evictCorrupted(ctx context.Context, cacheKey cacheKey, blobID blob.ID) {
+}
+
 func (c passthroughContentCache) getContent(ctx context.Context, cacheKey cacheKey, blobID blob.ID, offset, length int64) ([]byte, error) {
 	return c.st.GetBlob(ctx, blobID, offset, length)
 }
@@ -4,6 +4,7 @@
 	"bytes"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strings"
@@ -24,8 +25,8 @@ func newUnderlyingStorageForContentCacheTesting(t *testing.T) blob.Storage {
 	ctx := testlogging.Context(t)
 	data := blobtesting.DataMap{}
 	st := blobtesting.NewMapStorage(data, nil, nil)
-	assertNoError(t, st.PutBlob(ctx, "content-1", gather.FromSlice([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10})))
-	assertNoError(t, st.PutBlob(ctx, "content-4k", gather.FromSlice(bytes.Repeat([]byte{1, 2, 3, 4}, 1000)))) // 4000 bytes
+	assertNoError(t, st.PutBlob(ctx, "content-1", gather.FromSlice([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}), blob.PutOptions{}))
+	assertNoError(t, st.PutBlob(ctx, "content-4k", gather.FromSlice(bytes.Repeat([]byte{1, 2, 3, 4}, 1000)), blob.PutOptions{})) // 4000 bytes
 
 	return st
 }
@@ -51,7 +52,7 @@ func TestCacheExpiration(t *testing.T) {
 
 	underlyingStorage := newUnderlyingStorageForContentCacheTesting(t)
 
-	cb, err := newContentCacheBase(testlogging.Context(t), cacheStorage, 10000, 0, 500*time.Millisecond)
+	cb, err := newContentCacheBase(testlogging.Context(t), cacheNameData, cacheStorage, 10000, 0, 500*time.Millisecond, nil)
 	if err != nil {
 		t.Fatalf("unable to create base cache: %v", err)
 	}
@@ -118,7 +119,7 @@ func TestDiskContentCache(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	cache, err := newContentCacheForData(ctx, newUnderlyingStorageForContentCacheTesting(t), cacheStorage, maxBytes, nil)
+	cache, err := newContentCacheForData(ctx, newUnderlyingStorageForContentCacheTesting(t), cacheStorage, maxBytes, nil, nil)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -177,7 +178,7 @@ func verifyContentCache(t *testing.T, cache contentCache) {
 		// corrupt the data and write back
 		d[0] ^= 1
 
-		if puterr := cache.(*contentCacheForData).cacheStorage.PutBlob(ctx, cacheKey, gather.FromSlice(d)); puterr != nil {
+		if puterr := cache.(*contentCacheForData).cacheStorage.PutBlob(ctx, cacheKey, gather.FromSlice(d), blob.PutOptions{}); puterr != nil {
 			t.Fatalf("unable to write corrupted content: %v", puterr)
 		}
 
@@ -191,6 +192,38 @@ func verifyContentCache(t *testing.T, cache contentCache) {
 	})
 }
 
+func TestBlockCacheDataStorage(t *testing.T) {
+	ctx := testlogging.Context(t)
+	tmpDir := t.TempDir()
+
+	caching := &CachingOptions{
+		CacheDirectory:    tmpDir,
+		MaxCacheSizeBytes: 1 << 20,
+		BlockCacheFile:    filepath.Join(tmpDir, "cache.bin"),
+	}
+
+	st, bc, err := newDataCacheStorageOrNil(ctx, caching)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bc == nil || st != bc {
+		t.Fatalf("block cache not used: %v", st)
+	}
+
+	defer closeBlockCache(ctx, bc)
+
+	// block cache is in use, the cache directory is used instead.
+	st2, bc2, err := newDataCacheStorageOrNil(ctx, caching)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bc2 != nil || st2 == nil {
+		t.Fatalf("unexpected storage when block cache is in use: %v", st2)
+	}
+}
+
 func TestCacheFailureToOpen(t *testing.T) {
 	someError := errors.New("some error")
 
@@ -207,13 +240,13 @@ func TestCacheFailureToOpen(t *testing.T) {
 	}
 
 	// Will fail because of ListBlobs failure.
-	_, err := newContentCacheForData(testlogging.Context(t), underlyingStorage, faultyCache, 10000, nil)
+	_, err := newContentCacheForData(testlogging.Context(t), underlyingStorage, faultyCache, 10000, nil, nil)
 	if err == nil || !strings.Contains(err.Error(), someError.Error()) {
 		t.Errorf("invalid error %v, wanted: %v", err, someError)
 	}
 
 	// ListBlobs fails only once, next time it succeeds.
-	cache, err := newContentCacheForData(testlogging.Context(t), underlyingStorage, faultyCache, 10000, nil)
+	cache, err := newContentCacheForData(testlogging.Context(t), underlyingStorage, faultyCache, 10000, nil, nil)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -231,7 +264,7 @@ func TestCacheFailureToWrite(t *testing.T) {
 		Base: cacheStorage,
 	}
 
-	cache, err := newContentCacheForData(testlogging.Context(t), underlyingStorage, faultyCache, 10000, nil)
+	cache, err := newContentCacheForData(testlogging.Context(t), underlyingStorage, faultyCache, 10000, nil, nil)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -274,7 +307,7 @@ func TestCacheFailureToRead(t *testing.T) {
 		Base: cacheStorage,
 	}
 
-	cache, err := newContentCacheForData(testlogging.Context(t), underlyingStorage, faultyCache, 10000, nil)
+	cache, err := newContentCacheForData(testlogging.Context(t), underlyingStorage, faultyCache, 10000, nil, nil)
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
@@ -1,13 +1,48 @@
 package content
 
+import (
+	"time"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+const (
+	// FormatVersion1 is the format version of repositories readable by all versions of kopia.
+	FormatVersion1 = 1
+
+	// FormatVersion2 is the format version of repositories using features that versions of kopia supporting only
+	// FormatVersion1 would silently ignore or misinterpret, such as compression dictionaries. These versions
+	// refuse to open repositories using it.
+	FormatVersion2 = 2
+)
+
 // FormattingOptions describes the rules for formatting contents in repository.
 type FormattingOptions struct {
-	Version     int    `json:"version,omitempty"`     // version number, must be "1"
+	Version     int    `json:"version,omitempty"`     // version number, FormatVersion1 or FormatVersion2
 	Hash        string `json:"hash,omitempty"`        // identifier of the hash algorithm used
 	Encryption  string `json:"encryption,omitempty"`  // identifier of the encryption algorithm used
 	HMACSecret  []byte `json:"secret,omitempty"`      // HMAC secret used to generate encryption keys
 	MasterKey   []byte `json:"masterKey,omitempty"`   // master encryption key (SIV-mode encryption only)
 	MaxPackSize int    `json:"maxPackSize,omitempty"` // maximum size of a pack object
+
+	// number of Reed-Solomon parity bytes protecting each block of local index appended to packs (0 = disabled)
+	LocalIndexECCBytes int `json:"localIndexEccBytes,omitempty"`
+
+	// retention mode and period of pack and index blobs, which are locked against deletion by the storage
+	RetentionMode   string        `json:"retentionMode,omitempty"`
+	RetentionPeriod time.Duration `json:"retentionPeriod,omitempty"`
+}
+
+// RetentionOptions returns options for writing blobs which are locked for the configured retention period.
+func (f *FormattingOptions) RetentionOptions() blob.PutOptions {
+	if f.RetentionMode == "" || f.RetentionPeriod <= 0 {
+		return blob.PutOptions{}
+	}
+
+	return blob.PutOptions{
+		RetentionMode:   f.RetentionMode,
+		RetentionPeriod: f.RetentionPeriod,
+	}
 }
 
 // GetEncryptionAlgorithm implements encryption.Parameters.
@@ -39,28 +39,69 @@ func (bm *Manager) RecoverIndexFromPackBlob(ctx context.Context, packFile blob.I
 	return recovered, err
 }
 
+const (
+	postambleVersion    = 1
+	postambleVersionECC = 2
+
+	// number of Reed-Solomon parity bytes protecting ECC postamble.
+	postambleECCBytes = 16
+
+	// number of copies of postamble length at the end of ECC postamble.
+	postambleECCLengthCopies = 3
+)
+
 type packContentPostamble struct {
 	localIndexIV     []byte
 	localIndexOffset uint32
 	localIndexLength uint32
+
+	// number of Reed-Solomon parity bytes protecting each block of local index, which are stored
+	// immediately after it, zero if local index is not protected.
+	localIndexECCBytes int
 }
 
 func (p *packContentPostamble) toBytes() ([]byte, error) {
-	// 4 varints + IV + 4 bytes of checksum + 1 byte of postamble length
+	// 5 varints + IV + 4 bytes of checksum + 1 byte of postamble length
 	n := 0
-	buf := make([]byte, 4*binary.MaxVarintLen64+len(p.localIndexIV)+4+1)
+	buf := make([]byte, 5*binary.MaxVarintLen64+len(p.localIndexIV)+4+1)
+
+	version := postambleVersion
+	if p.localIndexECCBytes > 0 {
+		version = postambleVersionECC
+	}
 
-	n += binary.PutUvarint(buf[n:], uint64(1))                   // version flag
+	n += binary.PutUvarint(buf[n:], uint64(version))             // version flag
 	n += binary.PutUvarint(buf[n:], uint64(len(p.localIndexIV))) // length of local index IV
 	copy(buf[n:], p.localIndexIV)
 	n += len(p.localIndexIV)
 	n += binary.PutUvarint(buf[n:], uint64(p.localIndexOffset))
 	n += binary.PutUvarint(buf[n:], uint64(p.localIndexLength))
 
+	if version == postambleVersionECC {
+		n += binary.PutUvarint(buf[n:], uint64(p.localIndexECCBytes))
+	}
+
 	checksum := crc32.ChecksumIEEE(buf[0:n])
 	binary.BigEndian.PutUint32(buf[n:], checksum)
 	n += 4
 
+	if version == postambleVersionECC {
+		// protect postamble with its own parity bytes and store its length multiple times,
+		// so that it can be located and corrected even if a few bytes at the end of the pack are damaged.
+		if n > rsBlockSize(postambleECCBytes) {
+			return nil, errors.Errorf("postamble too long: %v", n)
+		}
+
+		result := append([]byte(nil), buf[0:n]...)
+		result = append(result, rsEncode(buf[0:n], postambleECCBytes)...)
+
+		for i := 0; i < postambleECCLengthCopies; i++ {
+			result = append(result, byte(n))
+		}
+
+		return result, nil
+	}
+
 	if n > 255 { // nolint:gomnd
 		return nil, errors.Errorf("postamble too long: %v", n)
 	}
@@ -79,6 +120,10 @@ func findPostamble(b []byte) *packContentPostamble {
 		return nil
 	}
 
+	if p := findECCPostamble(b); p != nil {
+		return p
+	}
+
 	// length of postamble is the last byte
 	postambleLength := int(b[len(b)-1])
 	if postambleLength < 5 { // nolint:gomnd
@@ -107,6 +152,53 @@ func findPostamble(b []byte) *packContentPostamble {
 	return decodePostamble(payload)
 }
 
+// findECCPostamble detects postamble protected by error correction code.
+func findECCPostamble(b []byte) *packContentPostamble {
+	if len(b) < postambleECCLengthCopies {
+		return nil
+	}
+
+	lengthCopies := b[len(b)-postambleECCLengthCopies:]
+	tried := map[byte]bool{}
+
+	// try each distinct copy of the length in case some of them are damaged.
+	for _, l := range lengthCopies {
+		if tried[l] {
+			continue
+		}
+
+		tried[l] = true
+
+		postambleLength := int(l)
+		if postambleLength < 5 || postambleLength > rsBlockSize(postambleECCBytes) { // nolint:gomnd
+			continue
+		}
+
+		postambleEnd := len(b) - postambleECCLengthCopies - postambleECCBytes
+		postambleStart := postambleEnd - postambleLength
+
+		if postambleStart < 0 {
+			continue
+		}
+
+		postambleBytes, err := rsCorrect(b[postambleStart:postambleEnd], b[postambleEnd:postambleEnd+postambleECCBytes], postambleECCBytes)
+		if err != nil {
+			continue
+		}
+
+		payload, checksumBytes := postambleBytes[0:len(postambleBytes)-4], postambleBytes[len(postambleBytes)-4:]
+		if binary.BigEndian.Uint32(checksumBytes) != crc32.ChecksumIEEE(payload) {
+			continue
+		}
+
+		if p := decodePostamble(payload); p != nil && p.localIndexECCBytes > 0 {
+			return p
+		}
+	}
+
+	return nil
+}
+
 func decodePostamble(payload []byte) *packContentPostamble {
 	flags, n := binary.Uvarint(payload)
 	if n <= 0 {
@@ -114,7 +206,7 @@ func decodePostamble(payload []byte) *packContentPostamble {
 		return nil
 	}
 
-	if flags != 1 {
+	if flags != postambleVersion && flags != postambleVersionECC {
 		// unsupported flag
 		return nil
 	}
@@ -150,10 +242,23 @@ func decodePostamble(payload []byte) *packContentPostamble {
 		return nil
 	}
 
+	payload = payload[n:]
+
+	var eccBytes uint64
+
+	if flags == postambleVersionECC {
+		eccBytes, n = binary.Uvarint(payload)
+		if n <= 0 || eccBytes == 0 || eccBytes >= gfSize {
+			// invalid number of parity bytes
+			return nil
+		}
+	}
+
 	return &packContentPostamble{
-		localIndexIV:     iv,
-		localIndexLength: uint32(length),
-		localIndexOffset: uint32(off),
+		localIndexIV:       iv,
+		localIndexLength:   uint32(length),
+		localIndexOffset:   uint32(off),
+		localIndexECCBytes: int(eccBytes),
 	}
 }
 
@@ -184,13 +289,18 @@ func (bm *lockFreeManager) writePackFileIndexRecoveryData(buf *gather.WriteBuffe
 	}
 
 	postamble := packContentPostamble{
-		localIndexIV:     localIndexIV,
-		localIndexOffset: uint32(localIndexOffset),
-		localIndexLength: uint32(len(encryptedLocalIndex)),
+		localIndexIV:       localIndexIV,
+		localIndexOffset:   uint32(localIndexOffset),
+		localIndexLength:   uint32(len(encryptedLocalIndex)),
+		localIndexECCBytes: bm.Format.LocalIndexECCBytes,
 	}
 
 	buf.Append(encryptedLocalIndex)
 
+	if postamble.localIndexECCBytes > 0 {
+		buf.Append(rsEncode(encryptedLocalIndex, postamble.localIndexECCBytes))
+	}
+
 	postambleBytes, err := postamble.toBytes()
 	if err != nil {
 		return err
@@ -225,6 +335,22 @@ func (bm *lockFreeManager) readPackFileLocalIndex(ctx context.Context, packFile
 		return nil, errors.Errorf("unable to find valid local index in file %v", packFile)
 	}
 
+	if nsym := postamble.localIndexECCBytes; nsym > 0 {
+		parityStart := uint64(postamble.localIndexOffset + postamble.localIndexLength)
+		parityEnd := parityStart + uint64(rsParityLength(len(encryptedLocalIndexBytes), nsym))
+
+		if parityEnd > uint64(len(payload)) {
+			return nil, errors.Errorf("unable to find valid local index parity in file %v", packFile)
+		}
+
+		corrected, err := rsCorrect(encryptedLocalIndexBytes, payload[parityStart:parityEnd], nsym)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to correct local index in file %v", packFile)
+		}
+
+		encryptedLocalIndexBytes = corrected
+	}
+
 	localIndexBytes, err := bm.decryptAndVerify(encryptedLocalIndexBytes, postamble.localIndexIV)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to decrypt local index")
@@ -100,3 +100,78 @@ func TestContentIndexRecovery(t *testing.T) {
 	verifyContent(ctx, t, bm, content2, seededRandomData(11, 100))
 	verifyContent(ctx, t, bm, content3, seededRandomData(12, 100))
 }
+
+func TestContentIndexRecoveryWithECC(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	for _, eccBytes := range []int{0, 8} {
+		data := blobtesting.DataMap{}
+		keyTime := map[blob.ID]time.Time{}
+		bm := newTestContentManager(t, data, keyTime, nil)
+		bm.Format.LocalIndexECCBytes = eccBytes
+
+		writeContentAndVerify(ctx, t, bm, seededRandomData(10, 100))
+		writeContentAndVerify(ctx, t, bm, seededRandomData(11, 100))
+		writeContentAndVerify(ctx, t, bm, seededRandomData(12, 100))
+
+		if err := bm.Flush(ctx); err != nil {
+			t.Errorf("flush error: %v", err)
+		}
+
+		bm.Close(ctx)
+
+		var packs []blob.ID
+
+		for blobID := range data {
+			if blobID[0] == PackBlobIDPrefixRegular[0] {
+				packs = append(packs, blobID)
+			}
+		}
+
+		if len(packs) != 1 {
+			t.Fatalf("unexpected packs: %v", packs)
+		}
+
+		payload := data[packs[0]]
+
+		postamble := findPostamble(payload)
+		if postamble == nil {
+			t.Fatalf("postamble not found")
+		}
+
+		if got, want := postamble.localIndexECCBytes, eccBytes; got != want {
+			t.Fatalf("unexpected ECC bytes in postamble: %v, want %v", got, want)
+		}
+
+		// damage a few bytes of local index.
+		for i := 0; i < 3; i++ {
+			payload[int(postamble.localIndexOffset)+i*13] ^= 0xff
+		}
+
+		if eccBytes > 0 {
+			// damage a few bytes of postamble, including one copy of its length.
+			payload[len(payload)-1] ^= 0xff
+			payload[len(payload)-10] ^= 0xff
+		}
+
+		bm = newTestContentManager(t, data, keyTime, nil)
+
+		infos, err := bm.RecoverIndexFromPackBlob(ctx, packs[0], int64(len(payload)), false)
+
+		if eccBytes == 0 {
+			if err == nil {
+				t.Errorf("unexpected success recovering damaged pack without ECC")
+			}
+		} else {
+			if err != nil {
+				t.Fatalf("error recovering damaged pack with ECC: %v", err)
+			}
+
+			if got, want := len(infos), 3; got != want {
+				t.Errorf("invalid # of contents recovered: %v, want %v", got, want)
+			}
+		}
+
+		bm.Close(ctx)
+	}
+}
@@ -0,0 +1,139 @@
+package content
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultSmallIndexSafetyEpoch is the default length of safety epochs used when merging small index blobs.
+	DefaultSmallIndexSafetyEpoch = defaultEventualConsistencySettleTime
+
+	// DefaultMinSmallIndexBlobsPerEpoch is the default minimum number of small index blobs written in a single
+	// safety epoch that are merged together.
+	DefaultMinSmallIndexBlobsPerEpoch = 4
+)
+
+// SmallIndexCompactionOptions provides options for merging of small index blobs.
+type SmallIndexCompactionOptions struct {
+	// MaxIndexBlobSize is the size below which index blobs are considered small,
+	// defaults to 1/verySmallContentFraction of the maximum pack size.
+	MaxIndexBlobSize int64
+
+	// MinBlobsPerEpoch is the minimum number of small index blobs written in a single safety epoch
+	// that are merged together, epochs with fewer small index blobs are left alone.
+	MinBlobsPerEpoch int
+
+	// SafetyEpoch is the length of time windows by which small index blobs are grouped based on their timestamps.
+	// Index blobs written in the current or previous epoch are never merged, which gives eventually-consistent
+	// storage time to make them visible to all clients. Because epochs are aligned the same way for all clients,
+	// concurrent compactions select identical inputs and produce identical index blobs.
+	SafetyEpoch time.Duration
+}
+
+func (o SmallIndexCompactionOptions) withDefaults(maxPackSize int) SmallIndexCompactionOptions {
+	if o.MaxIndexBlobSize <= 0 {
+		o.MaxIndexBlobSize = int64(maxPackSize / verySmallContentFraction)
+	}
+
+	if o.MinBlobsPerEpoch <= 1 {
+		o.MinBlobsPerEpoch = DefaultMinSmallIndexBlobsPerEpoch
+	}
+
+	if o.SafetyEpoch <= 0 {
+		o.SafetyEpoch = DefaultSmallIndexSafetyEpoch
+	}
+
+	return o
+}
+
+// CompactSmallIndexes merges small index blobs written in the same safety epoch and returns the number of index blobs
+// that were merged. Unlike CompactIndexes it never rewrites large or recently-written index blobs, which makes it cheap
+// enough to run whenever indexes are flushed.
+func (bm *Manager) CompactSmallIndexes(ctx context.Context, opt SmallIndexCompactionOptions) (int, error) {
+	bm.lock()
+	defer bm.unlock()
+
+	return bm.compactSmallIndexesLocked(ctx, opt)
+}
+
+func (bm *Manager) compactSmallIndexesLocked(ctx context.Context, opt SmallIndexCompactionOptions) (int, error) {
+	opt = opt.withDefaults(bm.maxPackSize)
+
+	indexBlobs, _, err := bm.loadPackIndexesUnlocked(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "error loading indexes")
+	}
+
+	merged := 0
+
+	for _, group := range smallIndexBlobsByEpoch(indexBlobs, opt) {
+		log(ctx).Debugf("merging %v small index blobs", len(group))
+
+		if err := bm.compactIndexBlobs(ctx, group, CompactOptions{}); err != nil {
+			return merged, errors.Wrap(err, "error merging small index blobs")
+		}
+
+		merged += len(group)
+	}
+
+	if merged == 0 {
+		return 0, nil
+	}
+
+	return merged, bm.indexBlobManager.cleanup(ctx)
+}
+
+// smallIndexBlobsByEpoch returns groups of small index blobs written in the same safety epoch, in the order of epochs.
+// The current and previous epochs are determined based on the timestamp of the most recent index blob
+// rather than the local clock, which may be skewed relative to the storage.
+func smallIndexBlobsByEpoch(indexBlobs []IndexBlobInfo, opt SmallIndexCompactionOptions) [][]IndexBlobInfo {
+	var latest time.Time
+
+	for _, b := range indexBlobs {
+		if b.Timestamp.After(latest) {
+			latest = b.Timestamp
+		}
+	}
+
+	cutoff := latest.Truncate(opt.SafetyEpoch).Add(-opt.SafetyEpoch)
+	byEpoch := map[time.Time][]IndexBlobInfo{}
+
+	for _, b := range indexBlobs {
+		if b.Length >= opt.MaxIndexBlobSize || !b.Timestamp.Before(cutoff) {
+			continue
+		}
+
+		epoch := b.Timestamp.Truncate(opt.SafetyEpoch).UTC()
+		byEpoch[epoch] = append(byEpoch[epoch], b)
+	}
+
+	var epochs []time.Time
+
+	for epoch, blobs := range byEpoch {
+		if len(blobs) >= opt.MinBlobsPerEpoch {
+			epochs = append(epochs, epoch)
+		}
+	}
+
+	sort.Slice(epochs, func(i, j int) bool {
+		return epochs[i].Before(epochs[j])
+	})
+
+	var result [][]IndexBlobInfo
+
+	for _, epoch := range epochs {
+		group := byEpoch[epoch]
+
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].BlobID < group[j].BlobID
+		})
+
+		result = append(result, group)
+	}
+
+	return result
+}
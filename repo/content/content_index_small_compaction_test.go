@@ -0,0 +1,164 @@
+package content
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/faketime"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func TestSmallIndexBlobsByEpoch(t *testing.T) {
+	t0 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ib := func(id blob.ID, length int64, ts time.Time) IndexBlobInfo {
+		return IndexBlobInfo{Metadata: blob.Metadata{BlobID: id, Length: length, Timestamp: ts}}
+	}
+
+	opt := SmallIndexCompactionOptions{
+		MaxIndexBlobSize: 1000,
+		MinBlobsPerEpoch: 2,
+		SafetyEpoch:      time.Hour,
+	}
+
+	groups := smallIndexBlobsByEpoch([]IndexBlobInfo{
+		// epoch 1 - merged
+		ib("n3", 100, t0.Add(1*time.Hour+3*time.Minute)),
+		ib("n1", 100, t0.Add(1*time.Hour+1*time.Minute)),
+		ib("n2", 5000, t0.Add(1*time.Hour+2*time.Minute)),
+		ib("n4", 100, t0.Add(1*time.Hour+4*time.Minute)),
+
+		// epoch 0 - merged
+		ib("n5", 100, t0.Add(10*time.Minute)),
+		ib("n6", 100, t0.Add(20*time.Minute)),
+
+		// epoch 2 - too few small blobs
+		ib("n7", 100, t0.Add(2*time.Hour)),
+		ib("n8", 5000, t0.Add(2*time.Hour+time.Minute)),
+
+		// epoch 3 - previous epoch, too recent
+		ib("n9", 100, t0.Add(3*time.Hour)),
+		ib("n10", 100, t0.Add(3*time.Hour+time.Minute)),
+
+		// epoch 4 - current epoch, too recent
+		ib("n11", 100, t0.Add(4*time.Hour)),
+		ib("n12", 100, t0.Add(4*time.Hour+30*time.Minute)),
+	}, opt)
+
+	var got [][]blob.ID
+
+	for _, g := range groups {
+		var ids []blob.ID
+
+		for _, b := range g {
+			ids = append(ids, b.BlobID)
+		}
+
+		got = append(got, ids)
+	}
+
+	want := [][]blob.ID{
+		{"n5", "n6"},
+		{"n1", "n3", "n4"},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("unexpected groups: %v, want %v", got, want)
+	}
+
+	for i := range want {
+		if len(got[i]) != len(want[i]) {
+			t.Fatalf("unexpected groups: %v, want %v", got, want)
+		}
+
+		for j := range want[i] {
+			if got[i][j] != want[i][j] {
+				t.Fatalf("unexpected groups: %v, want %v", got, want)
+			}
+		}
+	}
+}
+
+func TestEagerIndexCompaction(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	ta := faketime.NewTimeAdvance(fakeTime, 1*time.Second)
+	st := blobtesting.NewMapStorage(data, nil, ta.NowFunc())
+
+	bm, err := newManagerWithOptions(ctx, st, &FormattingOptions{
+		Hash:        "HMAC-SHA256",
+		Encryption:  "AES256-GCM-HMAC-SHA256",
+		HMACSecret:  hmacSecret,
+		MaxPackSize: 20 << 20,
+		Version:     1,
+	}, nil, ManagerOptions{TimeNow: ta.NowFunc(), EagerIndexCompaction: true})
+	if err != nil {
+		t.Fatalf("can't create content manager: %v", err)
+	}
+
+	defer bm.Close(ctx)
+
+	var ids []ID
+
+	writeAndFlush := func(i int) {
+		t.Helper()
+
+		ids = append(ids, writeContentAndVerify(ctx, t, bm, seededRandomData(i, 100)))
+
+		if err := bm.Flush(ctx); err != nil {
+			t.Fatalf("flush error: %v", err)
+		}
+	}
+
+	for i := 0; i < DefaultMinSmallIndexBlobsPerEpoch; i++ {
+		writeAndFlush(i)
+	}
+
+	// index blobs are too recent to be merged.
+	verifyActiveIndexBlobCount(ctx, t, bm, DefaultMinSmallIndexBlobsPerEpoch)
+
+	ta.Advance(2 * DefaultSmallIndexSafetyEpoch)
+	writeAndFlush(DefaultMinSmallIndexBlobsPerEpoch)
+
+	// all index blobs from the first epoch have been merged into one.
+	verifyActiveIndexBlobCount(ctx, t, bm, 2)
+
+	for i := DefaultMinSmallIndexBlobsPerEpoch + 1; i < 2*DefaultMinSmallIndexBlobsPerEpoch+1; i++ {
+		writeAndFlush(i)
+	}
+
+	ta.Advance(2 * DefaultSmallIndexSafetyEpoch)
+
+	// index written by another client makes the previous epoch eligible for merging.
+	other := newTestContentManagerWithStorage(t, st, ta.NowFunc())
+	defer other.Close(ctx)
+
+	writeContentAndVerify(ctx, t, other, seededRandomData(1000, 100))
+
+	if err := other.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	// flushes that don't write indexes don't merge them either.
+	bm.DisableIndexFlush(ctx)
+	writeAndFlush(2*DefaultMinSmallIndexBlobsPerEpoch + 1)
+	bm.EnableIndexFlush(ctx)
+
+	verifyActiveIndexBlobCount(ctx, t, bm, DefaultMinSmallIndexBlobsPerEpoch+3)
+
+	// pending index is written once flushes are enabled and small index blobs are merged.
+	if err := bm.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	verifyActiveIndexBlobCount(ctx, t, bm, 3)
+
+	bm2 := newTestContentManagerWithStorage(t, st, ta.NowFunc())
+	defer bm2.Close(ctx)
+
+	for i, id := range ids {
+		verifyContent(ctx, t, bm2, id, seededRandomData(i, 100))
+	}
+}
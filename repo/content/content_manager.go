@@ -36,6 +36,13 @@
 
 	maxHashSize                            = 64
 	defaultEncryptionBufferPoolSegmentSize = 8 << 20 // 8 MB
+
+	// lowMemoryEncryptionBufferPoolSegmentSize is the segment size of the encryption buffer pool in low-memory mode,
+	// larger contents are encrypted into buffers allocated on the heap, which are reclaimed by garbage collection.
+	lowMemoryEncryptionBufferPoolSegmentSize = 1 << 20 // 1 MB
+
+	// lowMemoryNegativeLookupCacheEntries is the maximum number of entries of the negative lookup cache in low-memory mode.
+	lowMemoryNegativeLookupCacheEntries = 10000
 )
 
 // PackBlobIDPrefixes contains all possible prefixes for pack blobs.
@@ -52,13 +59,11 @@
 	defaultMaxPreambleLength = 32
 	defaultPaddingUnit       = 4096
 
-	currentWriteVersion = 1
-
-	minSupportedWriteVersion = 1
-	maxSupportedWriteVersion = currentWriteVersion
+	minSupportedWriteVersion = FormatVersion1
+	maxSupportedWriteVersion = FormatVersion2
 
-	minSupportedReadVersion = 1
-	maxSupportedReadVersion = currentWriteVersion
+	minSupportedReadVersion = FormatVersion1
+	maxSupportedReadVersion = FormatVersion2
 
 	indexLoadAttempts = 10
 )
@@ -66,6 +71,9 @@
 // ErrContentNotFound is returned when content is not found.
 var ErrContentNotFound = errors.New("content not found")
 
+// ErrInvalidChecksum is returned when content can't be decrypted or fails checksum verification, which indicates corruption.
+var ErrInvalidChecksum = errors.New("invalid checksum")
+
 // IndexBlobInfo is an information about a single index blob managed by Manager.
 type IndexBlobInfo struct {
 	blob.Metadata
@@ -86,6 +94,10 @@ type Manager struct {
 	disableIndexFlushCount int
 	flushPackIndexesAfter  time.Time // time when those indexes should be flushed
 
+	uploads *packUploader // uploads packs in the background or nil if packs are uploaded synchronously
+
+	eagerIndexCompaction bool // merge small index blobs after flushing indexes
+
 	lockFreeManager
 }
 
@@ -129,7 +141,7 @@ func (bm *Manager) DeleteContent(ctx context.Context, contentID ID) error {
 	}
 
 	// see if the block existed before
-	bi, err := bm.committedContents.getContent(contentID)
+	bi, err := bm.committedContents.getContent(ctx, contentID)
 	if err != nil {
 		return err
 	}
@@ -222,6 +234,10 @@ func (bm *Manager) addToPackUnlocked(ctx context.Context, contentID ID, data []b
 	// at this point we're unlocked so different goroutines can encrypt and
 	// save to storage in parallel.
 	if shouldWrite {
+		if bm.uploads != nil {
+			return bm.writePackInBackground(ctx, pp)
+		}
+
 		if err := bm.writePackAndAddToIndex(ctx, pp, false); err != nil {
 			return errors.Wrap(err, "unable to write pack")
 		}
@@ -412,8 +428,19 @@ func (bm *Manager) Close(ctx context.Context) error {
 		return errors.Wrap(err, "error closed committed content index")
 	}
 
+	if bm.cacheAutoSizer != nil {
+		bm.cacheAutoSizer.close()
+	}
+
 	bm.contentCache.close()
 	bm.metadataCache.close()
+	closeBlockCache(ctx, bm.blockCache)
+	bm.recordCorruptCacheEntries(ctx)
+
+	if bm.readAhead != nil {
+		bm.readAhead.close()
+	}
+
 	bm.encryptionBufferPool.Close()
 
 	return nil
@@ -444,15 +471,33 @@ func (bm *Manager) Flush(ctx context.Context) error {
 		bm.cond.Wait()
 	}
 
+	// retry packs that failed to write, including those uploaded in the background.
+	fp := append([]*pendingPackInfo(nil), bm.failedPacks...)
+	for _, pp := range fp {
+		if err := bm.writePackAndAddToIndex(ctx, pp, true); err != nil {
+			return errors.Wrap(err, "error writing previously failed pack")
+		}
+	}
+
 	// finish all new pending packs
 	if err := bm.finishAllPacksLocked(ctx); err != nil {
 		return errors.Wrap(err, "error writing pending content")
 	}
 
+	// indexes are not written while flushes are disabled.
+	wroteIndex := len(bm.packIndexBuilder) > 0 && bm.disableIndexFlushCount == 0
+
 	if err := bm.flushPackIndexesLocked(ctx); err != nil {
 		return errors.Wrap(err, "error flushing indexes")
 	}
 
+	if wroteIndex && bm.eagerIndexCompaction {
+		// failure to merge small indexes does not affect contents that have just been flushed.
+		if _, err := bm.compactSmallIndexesLocked(ctx, SmallIndexCompactionOptions{}); err != nil {
+			log(ctx).Warningf("unable to merge small index blobs: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -460,7 +505,7 @@ func (bm *Manager) Flush(ctx context.Context) error {
 func (bm *Manager) RewriteContent(ctx context.Context, contentID ID) error {
 	formatLog(ctx).Debugf("rewrite-content %v", contentID)
 
-	pp, bi, err := bm.getContentInfo(contentID)
+	pp, bi, err := bm.getContentInfo(ctx, contentID)
 	if err != nil {
 		return err
 	}
@@ -479,7 +524,7 @@ func (bm *Manager) RewriteContent(ctx context.Context, contentID ID) error {
 func (bm *Manager) UndeleteContent(ctx context.Context, contentID ID) error {
 	log(ctx).Debugf("UndeleteContent(%q)", contentID)
 
-	pp, bi, err := bm.getContentInfo(contentID)
+	pp, bi, err := bm.getContentInfo(ctx, contentID)
 	if err != nil {
 		return err
 	}
@@ -541,12 +586,11 @@ func (bm *Manager) WriteContent(ctx context.Context, data []byte, prefix ID) (ID
 		return "", err
 	}
 
-	var hashOutput [maxHashSize]byte
-
-	contentID := prefix + ID(hex.EncodeToString(bm.hashData(hashOutput[:0], data)))
+	contentID := bm.computeContentID(data, prefix)
 
-	// content already tracked
-	if _, bi, err := bm.getContentInfo(contentID); err == nil {
+	// content already tracked, in low-memory mode a content which is not found in loaded indexes is
+	// written again instead of loading all remaining indexes to prove it does not exist.
+	if _, bi, err := bm.getContentInfoForWrite(ctx, contentID); err == nil {
 		if !bi.Deleted {
 			formatLog(ctx).Debugf("write-content %v already-exists", contentID)
 			return contentID, nil
@@ -562,6 +606,21 @@ func (bm *Manager) WriteContent(ctx context.Context, data []byte, prefix ID) (ID
 	return contentID, err
 }
 
+// ComputeContentID returns the ID of a content with the provided data and prefix without writing it.
+func (bm *Manager) ComputeContentID(data []byte, prefix ID) (ID, error) {
+	if err := ValidatePrefix(prefix); err != nil {
+		return "", err
+	}
+
+	return bm.computeContentID(data, prefix), nil
+}
+
+func (bm *Manager) computeContentID(data []byte, prefix ID) ID {
+	var hashOutput [maxHashSize]byte
+
+	return prefix + ID(hex.EncodeToString(bm.hashData(hashOutput[:0], data)))
+}
+
 // GetContent gets the contents of a given content. If the content is not found returns ErrContentNotFound.
 func (bm *Manager) GetContent(ctx context.Context, contentID ID) (v []byte, err error) {
 	defer func() {
@@ -577,7 +636,7 @@ func (bm *Manager) GetContent(ctx context.Context, contentID ID) (v []byte, err
 		}
 	}()
 
-	pp, bi, err := bm.getContentInfo(contentID)
+	pp, bi, err := bm.getContentInfo(ctx, contentID)
 	if err != nil {
 		return nil, err
 	}
@@ -612,19 +671,33 @@ func (bm *Manager) getOverlayContentInfo(contentID ID) (*pendingPackInfo, Info,
 	return nil, Info{}, false
 }
 
-func (bm *Manager) getContentInfo(contentID ID) (*pendingPackInfo, Info, error) {
+func (bm *Manager) getContentInfo(ctx context.Context, contentID ID) (*pendingPackInfo, Info, error) {
+	if pp, ci, ok := bm.getOverlayContentInfo(contentID); ok {
+		return pp, ci, nil
+	}
+
+	info, err := bm.committedContents.getContent(ctx, contentID)
+
+	return nil, info, err
+}
+
+func (bm *Manager) getContentInfoForWrite(ctx context.Context, contentID ID) (*pendingPackInfo, Info, error) {
+	if !bm.lowMemory {
+		return bm.getContentInfo(ctx, contentID)
+	}
+
 	if pp, ci, ok := bm.getOverlayContentInfo(contentID); ok {
 		return pp, ci, nil
 	}
 
-	info, err := bm.committedContents.getContent(contentID)
+	info, err := bm.committedContents.getContentIfLoaded(ctx, contentID)
 
 	return nil, info, err
 }
 
 // ContentInfo returns information about a single content.
 func (bm *Manager) ContentInfo(ctx context.Context, contentID ID) (Info, error) {
-	_, bi, err := bm.getContentInfo(contentID)
+	_, bi, err := bm.getContentInfo(ctx, contentID)
 	if err != nil {
 		log(ctx).Debugf("ContentInfo(%q) - error %v", err)
 		return Info{}, err
@@ -671,6 +744,24 @@ func (bm *Manager) SyncMetadataCache(ctx context.Context) error {
 	return nil
 }
 
+// PrefetchIndexes loads all committed index blobs which were not loaded when opening the repository
+// because of lazy index loading.
+func (bm *Manager) PrefetchIndexes(ctx context.Context) error {
+	return bm.committedContents.loadAllPending(ctx)
+}
+
+// PrefetchRecentMetadata fetches the most recent metadata blobs into metadata cache, until their total
+// size reaches the provided limit.
+func (bm *Manager) PrefetchRecentMetadata(ctx context.Context, maxBytes int64) error {
+	if cm, ok := bm.metadataCache.(*contentCacheForMetadata); ok {
+		return cm.prefetchRecent(ctx, maxBytes)
+	}
+
+	log(ctx).Debugf("metadata cache not enabled")
+
+	return nil
+}
+
 // DecryptBlob returns the contents of an encrypted blob that can be decrypted (n,m,l).
 func (bm *Manager) DecryptBlob(ctx context.Context, blobID blob.ID) ([]byte, error) {
 	return bm.indexBlobManager.getIndexBlob(ctx, blobID)
@@ -680,24 +771,45 @@ func (bm *Manager) DecryptBlob(ctx context.Context, blobID blob.ID) ([]byte, err
 type ManagerOptions struct {
 	RepositoryFormatBytes []byte
 	TimeNow               func() time.Time // Time provider
+
+	// VerifyCriticalWrites causes index blobs and packs of metadata contents (including manifests) to be read back
+	// after upload and compared with the data written, protecting against storage which loses acknowledged writes.
+	VerifyCriticalWrites bool
+
+	// LowMemory reduces memory usage at the expense of performance by using smaller buffer pools and lookup caches.
+	// Contents which are not found in indexes loaded so far are written without loading the remaining indexes,
+	// so they may be stored again.
+	LowMemory bool
+
+	// UploadConcurrency is the number of packs uploaded in parallel in the background, 0 causes packs to be
+	// uploaded by the goroutine which has filled them.
+	UploadConcurrency int
+
+	// UploadMemoryBudget limits the total size of packs being uploaded in the background,
+	// defaults to one pack per concurrent upload.
+	UploadMemoryBudget int64
+
+	// EagerIndexCompaction causes small index blobs to be merged whenever indexes are flushed
+	// instead of waiting for maintenance.
+	EagerIndexCompaction bool
 }
 
 // NewManager creates new content manager with given packing options and a formatter.
 func NewManager(ctx context.Context, st blob.Storage, f *FormattingOptions, caching *CachingOptions, options ManagerOptions) (*Manager, error) {
-	nowFn := options.TimeNow
-	if nowFn == nil {
-		nowFn = clock.Now
-	}
-
-	return newManagerWithOptions(ctx, st, f, caching, nowFn, options.RepositoryFormatBytes)
+	return newManagerWithOptions(ctx, st, f, caching, options)
 }
 
-func newManagerWithOptions(ctx context.Context, st blob.Storage, f *FormattingOptions, caching *CachingOptions, timeNow func() time.Time, repositoryFormatBytes []byte) (*Manager, error) {
-	if f.Version < minSupportedReadVersion || f.Version > currentWriteVersion {
+func newManagerWithOptions(ctx context.Context, st blob.Storage, f *FormattingOptions, caching *CachingOptions, options ManagerOptions) (*Manager, error) {
+	timeNow := options.TimeNow
+	if timeNow == nil {
+		timeNow = clock.Now
+	}
+
+	if f.Version < minSupportedReadVersion || f.Version > maxSupportedReadVersion {
 		return nil, errors.Errorf("can't handle repositories created using version %v (min supported %v, max supported %v)", f.Version, minSupportedReadVersion, maxSupportedReadVersion)
 	}
 
-	if f.Version < minSupportedWriteVersion || f.Version > currentWriteVersion {
+	if f.Version < minSupportedWriteVersion || f.Version > maxSupportedWriteVersion {
 		return nil, errors.Errorf("can't handle repositories created using version %v (min supported %v, max supported %v)", f.Version, minSupportedWriteVersion, maxSupportedWriteVersion)
 	}
 
@@ -706,6 +818,11 @@ func newManagerWithOptions(ctx context.Context, st blob.Storage, f *FormattingOp
 		return nil, err
 	}
 
+	encryptionBufferPoolSegmentSize := defaultEncryptionBufferPoolSegmentSize
+	if options.LowMemory {
+		encryptionBufferPoolSegmentSize = lowMemoryEncryptionBufferPoolSegmentSize
+	}
+
 	mu := &sync.RWMutex{}
 	m := &Manager{
 		lockFreeManager: lockFreeManager{
@@ -719,10 +836,12 @@ func newManagerWithOptions(ctx context.Context, st blob.Storage, f *FormattingOp
 			maxPreambleLength:       defaultMaxPreambleLength,
 			paddingUnit:             defaultPaddingUnit,
 			st:                      st,
-			repositoryFormatBytes:   repositoryFormatBytes,
+			repositoryFormatBytes:   options.RepositoryFormatBytes,
+			verifyCriticalWrites:    options.VerifyCriticalWrites,
+			lowMemory:               options.LowMemory,
 			checkInvariantsOnUnlock: os.Getenv("KOPIA_VERIFY_INVARIANTS") != "",
 			writeFormatVersion:      int32(f.Version),
-			encryptionBufferPool:    buf.NewPool(ctx, defaultEncryptionBufferPoolSegmentSize+encryptor.MaxOverhead(), "content-manager-encryption"),
+			encryptionBufferPool:    buf.NewPool(ctx, encryptionBufferPoolSegmentSize+encryptor.MaxOverhead(), "content-manager-encryption"),
 		},
 
 		mu:   mu,
@@ -731,6 +850,16 @@ func newManagerWithOptions(ctx context.Context, st blob.Storage, f *FormattingOp
 		flushPackIndexesAfter: timeNow().Add(flushPackIndexTimeout),
 		pendingPacks:          map[blob.ID]*pendingPackInfo{},
 		packIndexBuilder:      make(packIndexBuilder),
+		eagerIndexCompaction:  options.EagerIndexCompaction,
+	}
+
+	if options.UploadConcurrency > 0 {
+		budget := options.UploadMemoryBudget
+		if budget <= 0 {
+			budget = int64(options.UploadConcurrency) * int64(f.MaxPackSize)
+		}
+
+		m.uploads = newPackUploader(options.UploadConcurrency, budget)
 	}
 
 	if err := setupCaches(ctx, m, caching); err != nil {
@@ -744,15 +873,31 @@ func newManagerWithOptions(ctx context.Context, st blob.Storage, f *FormattingOp
 	return m, nil
 }
 
-func setupCaches(ctx context.Context, m *Manager, caching *CachingOptions) error {
+func setupCaches(ctx context.Context, m *Manager, caching *CachingOptions) (err error) {
 	caching = caching.CloneOrDefault()
 
-	dataCacheStorage, err := newCacheStorageOrNil(ctx, caching.CacheDirectory, caching.MaxCacheSizeBytes, "contents")
+	dataCacheStorage, blockCache, err := newDataCacheStorageOrNil(ctx, caching)
 	if err != nil {
 		return errors.Wrap(err, "unable to initialize data cache storage")
 	}
 
-	dataCache, err := newContentCacheForData(ctx, m.st, dataCacheStorage, caching.MaxCacheSizeBytes, caching.HMACSecret)
+	defer func() {
+		if err != nil {
+			closeBlockCache(ctx, blockCache)
+		}
+	}()
+
+	dataStorage := m.st
+
+	var readAhead *readAheadStorage
+
+	if caching.ReadAheadBytes > 0 {
+		// only data contents are read sequentially, metadata is usually accessed randomly.
+		readAhead = newReadAheadStorage(m.st, caching.ReadAheadBytes)
+		dataStorage = readAhead
+	}
+
+	dataCache, err := newContentCacheForData(ctx, dataStorage, dataCacheStorage, caching.MaxCacheSizeBytes, caching.HMACSecret, m.Stats)
 	if err != nil {
 		return errors.Wrap(err, "unable to initialize content cache")
 	}
@@ -762,12 +907,12 @@ func setupCaches(ctx context.Context, m *Manager, caching *CachingOptions) error
 		metadataCacheSize = caching.MaxCacheSizeBytes
 	}
 
-	metadataCacheStorage, err := newCacheStorageOrNil(ctx, caching.CacheDirectory, metadataCacheSize, "metadata")
+	metadataCacheStorage, err := newCacheStorageOrNil(ctx, caching.CacheDirectory, metadataCacheSize, cacheNameMetadata)
 	if err != nil {
 		return errors.Wrap(err, "unable to initialize data cache storage")
 	}
 
-	metadataCache, err := newContentCacheForMetadata(ctx, m.st, metadataCacheStorage, metadataCacheSize)
+	metadataCache, err := newContentCacheForMetadata(ctx, m.st, metadataCacheStorage, metadataCacheSize, m.Stats)
 	if err != nil {
 		return errors.Wrap(err, "unable to initialize metadata cache")
 	}
@@ -785,14 +930,23 @@ func setupCaches(ctx context.Context, m *Manager, caching *CachingOptions) error
 		}
 	}
 
-	contentIndex := newCommittedContentIndex(caching)
+	contentIndex := newCommittedContentIndex(caching, m.timeNow)
+	if m.lowMemory {
+		contentIndex.maxNotFound = lowMemoryNegativeLookupCacheEntries
+	}
 
 	// once everything is ready, set it up
 	m.CachingOptions = *caching
 	m.contentCache = dataCache
 	m.metadataCache = metadataCache
+	m.readAhead = readAhead
+	m.blockCache = blockCache
 	m.committedContents = contentIndex
 
+	if caching.AutoSize && caching.CacheDirectory != "" {
+		m.cacheAutoSizer = newCacheAutoSizer(ctx, caching.CacheDirectory, dataCache, metadataCache)
+	}
+
 	m.indexBlobManager = &indexBlobManagerImpl{
 		st:                               m.st,
 		encryptor:                        m.encryptor,
@@ -802,7 +956,11 @@ func setupCaches(ctx context.Context, m *Manager, caching *CachingOptions) error
 		listCache:                        listCache,
 		indexBlobCache:                   metadataCache,
 		maxEventualConsistencySettleTime: defaultEventualConsistencySettleTime,
+		verifyWrites:                     m.verifyCriticalWrites,
+		retention:                        m.Format.RetentionOptions(),
 	}
 
+	contentIndex.fetchIndexBlob = m.indexBlobManager.getIndexBlob
+
 	return nil
 }
@@ -41,6 +41,27 @@ func (bm *Manager) CompactIndexes(ctx context.Context, opt CompactOptions) error
 	return bm.indexBlobManager.cleanup(ctx)
 }
 
+// IndexBlobsToCompact returns index blobs that CompactIndexes would rewrite using the provided options,
+// without making any changes.
+func (bm *Manager) IndexBlobsToCompact(ctx context.Context, opt CompactOptions) ([]IndexBlobInfo, error) {
+	bm.lock()
+	defer bm.unlock()
+
+	indexBlobs, _, err := bm.loadPackIndexesUnlocked(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading indexes")
+	}
+
+	blobsToCompact := bm.getBlobsToCompact(ctx, indexBlobs, opt)
+
+	// mirror the no-op condition of compactIndexBlobs()
+	if len(blobsToCompact) <= 1 && opt.DropDeletedBefore.IsZero() && len(opt.DropContents) == 0 {
+		return nil, nil
+	}
+
+	return blobsToCompact, nil
+}
+
 func (bm *Manager) getBlobsToCompact(ctx context.Context, indexBlobs []IndexBlobInfo, opt CompactOptions) []IndexBlobInfo {
 	var nonCompactedBlobs, verySmallBlobs []IndexBlobInfo
 
@@ -162,7 +183,7 @@ func (bm *Manager) addIndexBlobsToBuilder(ctx context.Context, bld packIndexBuil
 
 	index, err := openPackIndex(bytes.NewReader(data))
 	if err != nil {
-		return errors.Wrapf(err, "unable to open index blob %q", indexBlob)
+		return errors.Wrapf(err, "unable to open index blob %q", indexBlob.BlobID)
 	}
 
 	_ = index.Iterate(AllIDs, func(i Info) error {
@@ -142,7 +142,7 @@ func (bm *Manager) IterateContents(ctx context.Context, opts IterateOptions, cal
 		_ = invokeCallback(*bi)
 	}
 
-	if err := bm.committedContents.listContents(opts.Range, invokeCallback); err != nil {
+	if err := bm.committedContents.listContents(ctx, opts.Range, invokeCallback); err != nil {
 		return err
 	}
 
@@ -7,11 +7,13 @@
 	cryptorand "crypto/rand"
 	"encoding/hex"
 	"io"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/kopia/kopia/internal/blockcache"
 	"github.com/kopia/kopia/internal/buf"
 	"github.com/kopia/kopia/internal/gather"
 	"github.com/kopia/kopia/repo/blob"
@@ -32,7 +34,10 @@ type lockFreeManager struct {
 	indexBlobManager  indexBlobManager
 	contentCache      contentCache
 	metadataCache     contentCache
+	readAhead         *readAheadStorage // nil if read-ahead is disabled
 	committedContents *committedContentIndex
+	cacheAutoSizer    *cacheAutoSizer     // nil if automatic sizing of caches is disabled
+	blockCache        *blockcache.Storage // nil if the data cache is not stored in a block cache file
 
 	checkInvariantsOnUnlock bool
 
@@ -47,6 +52,8 @@ type lockFreeManager struct {
 	timeNow           func() time.Time
 
 	repositoryFormatBytes []byte
+	verifyCriticalWrites  bool
+	lowMemory             bool
 
 	encryptionBufferPool *buf.Pool
 }
@@ -86,6 +93,16 @@ func writeRandomBytesToBuffer(b *gather.WriteBuffer, count int) error {
 	return nil
 }
 
+func totalIndexBlobLength(indexBlobs []IndexBlobInfo) int64 {
+	var total int64
+
+	for _, b := range indexBlobs {
+		total += b.Length
+	}
+
+	return total
+}
+
 func (bm *lockFreeManager) loadPackIndexesUnlocked(ctx context.Context) ([]IndexBlobInfo, bool, error) {
 	nextSleepTime := 100 * time.Millisecond //nolint:gomnd
 
@@ -106,6 +123,20 @@ func (bm *lockFreeManager) loadPackIndexesUnlocked(ctx context.Context) ([]Index
 			return nil, false, err
 		}
 
+		if bm.cacheAutoSizer != nil {
+			bm.cacheAutoSizer.setIndexBytes(totalIndexBlobLength(indexBlobs))
+		}
+
+		if bm.committedContents.lazy {
+			// only index blob metadata is loaded upfront, index blobs are fetched on first lookup miss.
+			updated, err := bm.committedContents.useLazy(ctx, indexBlobs)
+			if err != nil {
+				return nil, false, err
+			}
+
+			return indexBlobs, updated, nil
+		}
+
 		err = bm.tryLoadPackIndexBlobsUnlocked(ctx, indexBlobs)
 		if err == nil {
 			var indexBlobIDs []blob.ID
@@ -158,16 +189,10 @@ func (bm *lockFreeManager) tryLoadPackIndexBlobsUnlocked(ctx context.Context, in
 			defer wg.Done()
 
 			for indexBlobID := range ch {
-				data, err := bm.indexBlobManager.getIndexBlob(ctx, indexBlobID)
-				if err != nil {
+				if err := bm.committedContents.downloadToCache(ctx, indexBlobID, bm.indexBlobManager.getIndexBlob); err != nil {
 					errch <- err
 					return
 				}
-
-				if err := bm.committedContents.addContent(ctx, indexBlobID, data, false); err != nil {
-					errch <- errors.Wrap(err, "unable to add to committed content cache")
-					return
-				}
 			}
 		}()
 	}
@@ -231,31 +256,52 @@ func (bm *lockFreeManager) getCacheForContentID(id ID) contentCache {
 }
 
 func (bm *lockFreeManager) getContentDataUnlocked(ctx context.Context, pp *pendingPackInfo, bi *Info) ([]byte, error) {
-	var payload []byte
+	var hashBuf [maxHashSize]byte
+
+	iv, err := getPackedContentIV(hashBuf[:], bi.ID)
+	if err != nil {
+		return nil, err
+	}
 
 	if pp != nil && pp.packBlobID == bi.PackBlobID {
-		payload = pp.currentPackData.AppendSectionTo(nil, int(bi.PackOffset), int(bi.Length))
-	} else {
-		var err error
+		payload := pp.currentPackData.AppendSectionTo(nil, int(bi.PackOffset), int(bi.Length))
+		bm.Stats.readContent(len(payload))
 
-		payload, err = bm.getCacheForContentID(bi.ID).getContent(ctx, cacheKey(bi.ID), bi.PackBlobID, int64(bi.PackOffset), int64(bi.Length))
-		if err != nil {
-			return nil, err
-		}
+		return bm.decryptAndVerifyPayload(payload, iv, bi)
+	}
+
+	cache := bm.getCacheForContentID(bi.ID)
+
+	payload, err := cache.getContent(ctx, cacheKey(bi.ID), bi.PackBlobID, int64(bi.PackOffset), int64(bi.Length))
+	if err != nil {
+		return nil, err
 	}
 
 	bm.Stats.readContent(len(payload))
 
-	var hashBuf [maxHashSize]byte
+	decrypted, err := bm.decryptAndVerifyPayload(payload, iv, bi)
+	if err == nil {
+		return decrypted, nil
+	}
 
-	iv, err := getPackedContentIV(hashBuf[:], bi.ID)
+	// the payload may have been corrupted in the local cache, evict it and refetch from the storage.
+	log(ctx).Warningf("unable to verify content %v, refetching: %v", bi.ID, err)
+	cache.evictCorrupted(ctx, cacheKey(bi.ID), bi.PackBlobID)
+
+	payload, err = cache.getContent(ctx, cacheKey(bi.ID), bi.PackBlobID, int64(bi.PackOffset), int64(bi.Length))
 	if err != nil {
 		return nil, err
 	}
 
+	bm.Stats.readContent(len(payload))
+
+	return bm.decryptAndVerifyPayload(payload, iv, bi)
+}
+
+func (bm *lockFreeManager) decryptAndVerifyPayload(payload, iv []byte, bi *Info) ([]byte, error) {
 	decrypted, err := bm.decryptAndVerify(payload, iv)
 	if err != nil {
-		return nil, errors.Wrapf(err, "invalid checksum at %v offset %v length %v", bi.PackBlobID, bi.PackOffset, len(payload))
+		return nil, errors.Wrapf(err, "content at %v offset %v length %v", bi.PackBlobID, bi.PackOffset, len(payload))
 	}
 
 	return decrypted, nil
@@ -264,7 +310,7 @@ func (bm *lockFreeManager) getContentDataUnlocked(ctx context.Context, pp *pendi
 func (bm *lockFreeManager) decryptAndVerify(encrypted, iv []byte) ([]byte, error) {
 	decrypted, err := bm.encryptor.Decrypt(nil, encrypted, iv)
 	if err != nil {
-		return nil, errors.Wrap(err, "decrypt")
+		return nil, errors.Wrapf(ErrInvalidChecksum, "decrypt: %v", err)
 	}
 
 	bm.Stats.decrypted(len(decrypted))
@@ -339,7 +385,11 @@ func getPackedContentIV(output []byte, contentID ID) ([]byte, error) {
 func (bm *lockFreeManager) writePackFileNotLocked(ctx context.Context, packFile blob.ID, data gather.Bytes) error {
 	bm.Stats.wroteContent(data.Length())
 
-	return bm.st.PutBlob(ctx, packFile, data)
+	if bm.verifyCriticalWrites && strings.HasPrefix(string(packFile), string(PackBlobIDPrefixSpecial)) {
+		return blob.PutBlobAndVerify(ctx, bm.st, packFile, data, bm.Format.RetentionOptions())
+	}
+
+	return bm.st.PutBlob(ctx, packFile, data, bm.Format.RetentionOptions())
 }
 
 func (bm *lockFreeManager) hashData(output, data []byte) []byte {
@@ -358,7 +408,7 @@ func (bm *lockFreeManager) verifyChecksum(data, contentID []byte) error {
 
 	if !bytes.HasSuffix(contentID, expected) {
 		bm.Stats.foundInvalidContent()
-		return errors.Errorf("invalid checksum for blob %x, expected %x", contentID, expected)
+		return errors.Wrapf(ErrInvalidChecksum, "content %x, expected %x", contentID, expected)
 	}
 
 	bm.Stats.foundValidContent()
@@ -96,7 +96,7 @@ func (d *persistentOwnWritesCache) add(ctx context.Context, mb blob.Metadata) er
 		return errors.Wrap(err, "unable to marshal JSON")
 	}
 
-	return d.st.PutBlob(ctx, mb.BlobID, gather.FromSlice(j))
+	return d.st.PutBlob(ctx, mb.BlobID, gather.FromSlice(j), blob.PutOptions{})
 }
 
 func (d *persistentOwnWritesCache) merge(ctx context.Context, prefix blob.ID, source []blob.Metadata) ([]blob.Metadata, error) {
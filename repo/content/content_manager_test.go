@@ -8,7 +8,10 @@
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"sync"
@@ -322,7 +325,7 @@ func TestContentManagerFailedToWritePack(t *testing.T) {
 		MaxPackSize: maxPackSize,
 		HMACSecret:  []byte("foo"),
 		MasterKey:   []byte("0123456789abcdef0123456789abcdef"),
-	}, nil, faketime.Frozen(fakeTime), nil)
+	}, nil, ManagerOptions{TimeNow: faketime.Frozen(fakeTime)})
 	if err != nil {
 		t.Fatalf("can't create bm: %v", err)
 	}
@@ -347,6 +350,524 @@ func TestContentManagerFailedToWritePack(t *testing.T) {
 	verifyContent(ctx, t, bm, b1, seededRandomData(1, 10))
 }
 
+// lossyStorage acknowledges writes of blobs with the provided prefix without storing them.
+type lossyStorage struct {
+	blob.Storage
+
+	prefix blob.ID
+}
+
+func (s lossyStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	if strings.HasPrefix(string(id), string(s.prefix)) {
+		return nil
+	}
+
+	return s.Storage.PutBlob(ctx, id, data, opts)
+}
+
+func TestContentManagerVerifyCriticalWrites(t *testing.T) {
+	cases := []struct {
+		lostPrefix blob.ID
+		contentID  ID
+		verify     bool
+		wantErr    bool
+	}{
+		{lostPrefix: PackBlobIDPrefixSpecial, contentID: "k", verify: true, wantErr: true},
+		{lostPrefix: PackBlobIDPrefixSpecial, contentID: "k", verify: false, wantErr: false},
+		{lostPrefix: indexBlobPrefix, contentID: "", verify: true, wantErr: true},
+		{lostPrefix: indexBlobPrefix, contentID: "", verify: false, wantErr: false},
+
+		// regular packs are not verified.
+		{lostPrefix: PackBlobIDPrefixRegular, contentID: "", verify: true, wantErr: false},
+	}
+
+	for _, tc := range cases {
+		ctx := testlogging.Context(t)
+		st := lossyStorage{blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil), tc.lostPrefix}
+
+		bm, err := newManagerWithOptions(ctx, st, &FormattingOptions{
+			Hash:        "HMAC-SHA256",
+			Encryption:  "AES256-GCM-HMAC-SHA256",
+			HMACSecret:  hmacSecret,
+			MaxPackSize: maxPackSize,
+			Version:     1,
+		}, nil, ManagerOptions{TimeNow: faketime.AutoAdvance(fakeTime, 1*time.Second), VerifyCriticalWrites: tc.verify})
+		if err != nil {
+			t.Fatalf("can't create content manager: %v", err)
+		}
+
+		if _, err := bm.WriteContent(ctx, seededRandomData(1, 10), tc.contentID); err != nil {
+			t.Fatalf("can't write content: %v", err)
+		}
+
+		err = bm.Flush(ctx)
+		if got := errors.Is(err, blob.ErrWriteVerificationFailed); got != tc.wantErr {
+			t.Errorf("unexpected flush error for lost %q, content %q, verify %v: %v", tc.lostPrefix, tc.contentID, tc.verify, err)
+		}
+
+		bm.Close(ctx)
+	}
+}
+
+func TestContentManagerRefetchesCorruptedCacheEntries(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	cacheDir, err := ioutil.TempDir("", "kopia")
+	if err != nil {
+		t.Fatalf("error getting temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(cacheDir)
+
+	st := blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil)
+	bm := newTestContentManagerWithStorageAndCaching(t, st, &CachingOptions{
+		CacheDirectory:            cacheDir,
+		MaxCacheSizeBytes:         1e6,
+		MaxMetadataCacheSizeBytes: 1e6,
+		HMACSecret:                []byte("cache-secret"),
+	}, nil)
+
+	// one content is cached in the data cache, the others, including manifest contents, in the metadata cache.
+	dataContentID := writeContentAndVerify(ctx, t, bm, seededRandomData(1, 100))
+
+	metadataContentID, err := bm.WriteContent(ctx, seededRandomData(2, 100), "k")
+	if err != nil {
+		t.Fatalf("unable to write content: %v", err)
+	}
+
+	manifestContentID, err := bm.WriteContent(ctx, seededRandomData(3, 100), "m")
+	if err != nil {
+		t.Fatalf("unable to write content: %v", err)
+	}
+
+	if err = bm.Flush(ctx); err != nil {
+		t.Fatalf("unable to flush: %v", err)
+	}
+
+	verifyContent(ctx, t, bm, dataContentID, seededRandomData(1, 100))
+	verifyContent(ctx, t, bm, metadataContentID, seededRandomData(2, 100))
+	verifyContent(ctx, t, bm, manifestContentID, seededRandomData(3, 100))
+
+	// corrupt all cached entries.
+	for _, subdir := range []string{"contents", "metadata"} {
+		if err = filepath.Walk(filepath.Join(cacheDir, subdir), func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+
+			b, err := ioutil.ReadFile(path) //nolint:gosec
+			if err != nil {
+				return err
+			}
+
+			for i := range b {
+				b[i] ^= 0xff
+			}
+
+			return ioutil.WriteFile(path, b, 0o600)
+		}); err != nil {
+			t.Fatalf("unable to corrupt cache: %v", err)
+		}
+	}
+
+	verifyContent(ctx, t, bm, dataContentID, seededRandomData(1, 100))
+	verifyContent(ctx, t, bm, metadataContentID, seededRandomData(2, 100))
+	verifyContent(ctx, t, bm, manifestContentID, seededRandomData(3, 100))
+
+	if got, want := bm.Stats.CorruptCacheEntries(), uint32(2); got != want {
+		t.Errorf("unexpected number of corrupt cache entries: %v, want %v", got, want)
+	}
+
+	// corrupted entries have been replaced with valid ones.
+	verifyContent(ctx, t, bm, dataContentID, seededRandomData(1, 100))
+	verifyContent(ctx, t, bm, metadataContentID, seededRandomData(2, 100))
+	verifyContent(ctx, t, bm, manifestContentID, seededRandomData(3, 100))
+
+	if got, want := bm.Stats.CorruptCacheEntries(), uint32(2); got != want {
+		t.Errorf("unexpected number of corrupt cache entries after refetch: %v, want %v", got, want)
+	}
+
+	if err := bm.Close(ctx); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	// corrupted entries are added to the total count kept in the cache directory.
+	for i := 1; i <= 2; i++ {
+		bm2 := newTestContentManagerWithStorageAndCaching(t, st, &CachingOptions{
+			CacheDirectory:            cacheDir,
+			MaxCacheSizeBytes:         1e6,
+			MaxMetadataCacheSizeBytes: 1e6,
+			HMACSecret:                []byte("cache-secret"),
+		}, nil)
+
+		bm2.Stats.foundCorruptCacheEntry()
+
+		if err := bm2.Close(ctx); err != nil {
+			t.Fatalf("close error: %v", err)
+		}
+
+		total, err := CorruptCacheEntriesTotal(cacheDir)
+		if err != nil {
+			t.Fatalf("unable to get total corrupt cache entries: %v", err)
+		}
+
+		if got, want := total, int64(2+i); got != want {
+			t.Errorf("unexpected total number of corrupt cache entries: %v, want %v", got, want)
+		}
+	}
+}
+
+func TestContentManagerNegativeLookupCache(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	keyTime := map[blob.ID]time.Time{}
+	timeFunc := faketime.AutoAdvance(fakeTime, 1*time.Second)
+
+	bm1 := newTestContentManager(t, data, keyTime, timeFunc)
+	defer bm1.Close(ctx)
+
+	bm2 := newTestContentManager(t, data, keyTime, timeFunc)
+	defer bm2.Close(ctx)
+
+	writeContentAndVerify(ctx, t, bm1, seededRandomData(1, 100))
+	bm1.Flush(ctx)
+
+	contentID := writeContentAndVerify(ctx, t, bm2, seededRandomData(2, 100))
+
+	// bm1 has not seen the content yet, the negative result is remembered.
+	if _, err := bm1.ContentInfo(ctx, contentID); !errors.Is(err, ErrContentNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := len(bm1.committedContents.notFound); got != 1 {
+		t.Fatalf("unexpected number of negative lookup cache entries: %v", got)
+	}
+
+	bm2.Flush(ctx)
+
+	// refresh which picks up new index invalidates the cache.
+	if _, err := bm1.Refresh(ctx); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+
+	if got := len(bm1.committedContents.notFound); got != 0 {
+		t.Fatalf("negative lookup cache not invalidated: %v", got)
+	}
+
+	verifyContent(ctx, t, bm1, contentID, seededRandomData(2, 100))
+}
+
+func TestContentManagerMergedCommittedIndexes(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	keyTime := map[blob.ID]time.Time{}
+	timeFunc := faketime.AutoAdvance(fakeTime, 1*time.Second)
+	st := blobtesting.NewMapStorage(data, keyTime, timeFunc)
+
+	cacheDir, err := ioutil.TempDir("", "kopia")
+	if err != nil {
+		t.Fatalf("error getting temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(cacheDir)
+
+	bm1 := newTestContentManagerWithStorage(t, st, timeFunc)
+	defer bm1.Close(ctx)
+
+	var ids []ID
+
+	writeAndFlush := func(seed int) {
+		ids = append(ids, writeContentAndVerify(ctx, t, bm1, seededRandomData(seed, 100)))
+
+		if err := bm1.Flush(ctx); err != nil {
+			t.Fatalf("flush error: %v", err)
+		}
+	}
+
+	verifyAll := func(bm *Manager) {
+		for i, id := range ids {
+			verifyContent(ctx, t, bm, id, seededRandomData(i, 100))
+		}
+	}
+
+	verifyMerged := func(bm *Manager) {
+		t.Helper()
+
+		ndx, err := bm.IndexBlobs(ctx, false)
+		if err != nil {
+			t.Fatalf("error listing index blobs: %v", err)
+		}
+
+		cc := bm.committedContents
+		if len(cc.merged) != 1 || cc.mergedIndexFile == nil || len(cc.mergedBlobIDs) != len(ndx) {
+			t.Fatalf("committed indexes not merged: %v indexes, %v merged blobs, %v index blobs", len(cc.merged), len(cc.mergedBlobIDs), len(ndx))
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		writeAndFlush(i)
+	}
+
+	bm2 := newTestContentManagerWithStorageAndCaching(t, st, &CachingOptions{
+		CacheDirectory:        cacheDir,
+		MergeCommittedIndexes: true,
+	}, timeFunc)
+	defer bm2.Close(ctx)
+
+	verifyMerged(bm2)
+	verifyAll(bm2)
+
+	// new index blob causes incremental merge.
+	writeAndFlush(3)
+
+	if _, err = bm2.Refresh(ctx); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+
+	verifyMerged(bm2)
+	verifyAll(bm2)
+
+	// compaction removes index blobs, which requires full merge.
+	if err = bm1.CompactIndexes(ctx, CompactOptions{AllIndexes: true}); err != nil {
+		t.Fatalf("compaction error: %v", err)
+	}
+
+	writeAndFlush(4)
+
+	if _, err = bm2.Refresh(ctx); err != nil {
+		t.Fatalf("refresh error: %v", err)
+	}
+
+	verifyMerged(bm2)
+	verifyAll(bm2)
+
+	// merged index file is reused when opening the repository again.
+	bm3 := newTestContentManagerWithStorageAndCaching(t, st, &CachingOptions{
+		CacheDirectory:        cacheDir,
+		MergeCommittedIndexes: true,
+	}, timeFunc)
+	defer bm3.Close(ctx)
+
+	verifyMerged(bm3)
+	verifyAll(bm3)
+}
+
+func TestContentManagerLazyIndexLoading(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	keyTime := map[blob.ID]time.Time{}
+
+	// index blobs are written further apart than the assumed clock skew.
+	timeFunc := faketime.AutoAdvance(fakeTime, 2*lazyIndexLoadingClockSkew)
+	st := blobtesting.NewMapStorage(data, keyTime, timeFunc)
+
+	bm1 := newTestContentManagerWithStorage(t, st, timeFunc)
+	defer bm1.Close(ctx)
+
+	var ids []ID
+
+	for i := 0; i < 3; i++ {
+		ids = append(ids, writeContentAndVerify(ctx, t, bm1, seededRandomData(i, 100)))
+
+		if err := bm1.Flush(ctx); err != nil {
+			t.Fatalf("flush error: %v", err)
+		}
+	}
+
+	bm2 := newTestContentManagerWithStorageAndCaching(t, st, &CachingOptions{
+		LazyIndexLoading: true,
+	}, timeFunc)
+	defer bm2.Close(ctx)
+
+	cc := bm2.committedContents
+
+	if got, want := len(cc.pending), 3; got != want || len(cc.inUse) != 0 {
+		t.Fatalf("unexpected index blobs loaded when opening: %v pending, %v in use", got, len(cc.inUse))
+	}
+
+	// the most recent content only needs the most recent index blob.
+	verifyContent(ctx, t, bm2, ids[2], seededRandomData(2, 100))
+
+	if got, want := len(cc.pending), 2; got != want {
+		t.Fatalf("unexpected number of pending index blobs: %v, want %v", got, want)
+	}
+
+	verifyContent(ctx, t, bm2, ids[0], seededRandomData(0, 100))
+
+	if got, want := len(cc.pending), 0; got != want {
+		t.Fatalf("unexpected number of pending index blobs: %v, want %v", got, want)
+	}
+
+	// deletion recorded in a newer index blob takes precedence over older entries.
+	deleteContent(ctx, t, bm1, ids[1])
+
+	if err := bm1.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	bm3 := newTestContentManagerWithStorageAndCaching(t, st, &CachingOptions{
+		LazyIndexLoading: true,
+	}, timeFunc)
+	defer bm3.Close(ctx)
+
+	verifyDeletedContentRead(ctx, t, bm3, ids[1], seededRandomData(1, 100))
+	verifyContentNotFound(ctx, t, bm3, bm3.computeContentID(seededRandomData(10, 100), ""))
+
+	if got, want := len(bm3.committedContents.pending), 0; got != want {
+		t.Fatalf("unexpected number of pending index blobs after lookup miss: %v, want %v", got, want)
+	}
+
+	// listing contents loads all index blobs.
+	bm4 := newTestContentManagerWithStorageAndCaching(t, st, &CachingOptions{
+		LazyIndexLoading: true,
+	}, timeFunc)
+	defer bm4.Close(ctx)
+
+	var count int
+
+	if err := bm4.IterateContents(ctx, IterateOptions{}, func(i Info) error {
+		count++
+		return nil
+	}); err != nil {
+		t.Fatalf("iterate error: %v", err)
+	}
+
+	if got, want := count, 2; got != want {
+		t.Fatalf("unexpected number of contents: %v, want %v", got, want)
+	}
+
+	// prefetching loads all index blobs.
+	bm5 := newTestContentManagerWithStorageAndCaching(t, st, &CachingOptions{
+		LazyIndexLoading: true,
+	}, timeFunc)
+	defer bm5.Close(ctx)
+
+	if err := bm5.PrefetchIndexes(ctx); err != nil {
+		t.Fatalf("prefetch error: %v", err)
+	}
+
+	if got, want := len(bm5.committedContents.pending), 0; got != want {
+		t.Fatalf("unexpected number of pending index blobs after prefetch: %v, want %v", got, want)
+	}
+}
+
+func TestContentManagerLowMemoryWriteDoesNotLoadIndexesOnMiss(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	keyTime := map[blob.ID]time.Time{}
+	timeFunc := faketime.AutoAdvance(fakeTime, 2*lazyIndexLoadingClockSkew)
+	st := blobtesting.NewMapStorage(data, keyTime, timeFunc)
+
+	bm1 := newTestContentManagerWithStorage(t, st, timeFunc)
+	defer bm1.Close(ctx)
+
+	var ids []ID
+
+	for i := 0; i < 3; i++ {
+		ids = append(ids, writeContentAndVerify(ctx, t, bm1, seededRandomData(i, 100)))
+
+		if err := bm1.Flush(ctx); err != nil {
+			t.Fatalf("flush error: %v", err)
+		}
+	}
+
+	bm2 := newTestContentManagerWithStorageAndCaching(t, st, &CachingOptions{
+		LazyIndexLoading: true,
+	}, timeFunc)
+	defer bm2.Close(ctx)
+
+	bm2.lowMemory = true
+	cc := bm2.committedContents
+
+	// writing new content does not load pending index blobs.
+	writeContentAndVerify(ctx, t, bm2, seededRandomData(10, 100))
+
+	if got, want := len(cc.pending), 3; got != want {
+		t.Fatalf("unexpected number of pending index blobs after writing new content: %v, want %v", got, want)
+	}
+
+	// content which is only in pending index blobs is written again, but is still found by reads.
+	if got, err := bm2.WriteContent(ctx, seededRandomData(0, 100), ""); err != nil || got != ids[0] {
+		t.Fatalf("unexpected result of writing existing content: %v %v", got, err)
+	}
+
+	if got, want := len(cc.pending), 3; got != want {
+		t.Fatalf("unexpected number of pending index blobs after writing existing content: %v, want %v", got, want)
+	}
+
+	verifyContent(ctx, t, bm2, ids[1], seededRandomData(1, 100))
+}
+
+func TestContentManagerLazyIndexLoadingParallel(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	keyTime := map[blob.ID]time.Time{}
+	timeFunc := faketime.AutoAdvance(fakeTime, 2*lazyIndexLoadingClockSkew)
+	st := blobtesting.NewMapStorage(data, keyTime, timeFunc)
+
+	bm1 := newTestContentManagerWithStorage(t, st, timeFunc)
+	defer bm1.Close(ctx)
+
+	for i := 0; i < 10; i++ {
+		writeContentAndVerify(ctx, t, bm1, seededRandomData(i, 100))
+
+		if err := bm1.Flush(ctx); err != nil {
+			t.Fatalf("flush error: %v", err)
+		}
+	}
+
+	bm2 := newTestContentManagerWithStorageAndCaching(t, st, &CachingOptions{
+		LazyIndexLoading: true,
+	}, timeFunc)
+	defer bm2.Close(ctx)
+
+	cc := bm2.committedContents
+	fetch := cc.fetchIndexBlob
+
+	var active, maxActive int32
+
+	cc.fetchIndexBlob = func(ctx context.Context, indexBlob blob.ID) ([]byte, error) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+
+		for {
+			m := atomic.LoadInt32(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+				break
+			}
+		}
+
+		// index blobs are fetched without holding the lock.
+		locked := make(chan struct{})
+
+		go func() {
+			cc.mu.Lock()
+			cc.mu.Unlock() //nolint:staticcheck
+			close(locked)
+		}()
+
+		select {
+		case <-locked:
+		case <-time.After(5 * time.Second):
+			t.Errorf("index blob %v fetched while holding the lock", indexBlob)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+
+		return fetch(ctx, indexBlob)
+	}
+
+	verifyContentNotFound(ctx, t, bm2, bm2.computeContentID(seededRandomData(100, 100), ""))
+
+	if got, want := len(cc.pending), 0; got != want {
+		t.Fatalf("unexpected number of pending index blobs after lookup miss: %v, want %v", got, want)
+	}
+
+	if atomic.LoadInt32(&maxActive) < 2 {
+		t.Fatalf("index blobs were not fetched in parallel")
+	}
+}
+
 func TestIndexCompactionDropsContent(t *testing.T) {
 	ctx := testlogging.Context(t)
 	data := blobtesting.DataMap{}
@@ -383,6 +904,27 @@ func TestIndexCompactionDropsContent(t *testing.T) {
 	verifyContentNotFound(ctx, t, bm, content1)
 }
 
+func TestIndexCompactionReportsInvalidIndexBlobID(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	bm := newTestContentManager(t, blobtesting.DataMap{}, nil, nil)
+	defer bm.Close(ctx)
+
+	md, err := bm.indexBlobManager.writeIndexBlob(ctx, []byte("not an index"))
+	if err != nil {
+		t.Fatalf("unable to write index blob: %v", err)
+	}
+
+	err = bm.addIndexBlobsToBuilder(ctx, make(packIndexBuilder), IndexBlobInfo{Metadata: md})
+	if err == nil {
+		t.Fatalf("invalid index blob was accepted")
+	}
+
+	if got, want := err.Error(), fmt.Sprintf("unable to open index blob %q:", md.BlobID); !strings.HasPrefix(got, want) {
+		t.Errorf("unexpected error %q, want prefix %q", got, want)
+	}
+}
+
 func TestContentManagerConcurrency(t *testing.T) {
 	ctx := testlogging.Context(t)
 	data := blobtesting.DataMap{}
@@ -1687,8 +2229,215 @@ func TestContentReadAliasing(t *testing.T) {
 	verifyContent(ctx, t, bm, id1, contentData)
 }
 
+func TestLowMemoryManager(t *testing.T) {
+	ctx := testlogging.Context(t)
+	st := blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil)
+
+	bm, err := newManagerWithOptions(ctx, st, &FormattingOptions{
+		Hash:        "HMAC-SHA256",
+		Encryption:  "AES256-GCM-HMAC-SHA256",
+		HMACSecret:  hmacSecret,
+		MaxPackSize: maxPackSize,
+		Version:     1,
+	}, nil, ManagerOptions{TimeNow: faketime.Frozen(fakeTime), LowMemory: true})
+	if err != nil {
+		t.Fatalf("can't create content manager: %v", err)
+	}
+
+	defer bm.Close(ctx)
+
+	if got, want := bm.committedContents.maxNotFound, lowMemoryNegativeLookupCacheEntries; got != want {
+		t.Errorf("unexpected negative lookup cache size: %v, want %v", got, want)
+	}
+
+	// contents larger than encryption buffer pool segments are encrypted into buffers allocated on the heap.
+	small := writeContentAndVerify(ctx, t, bm, seededRandomData(1, 100))
+	large := writeContentAndVerify(ctx, t, bm, seededRandomData(2, 2*lowMemoryEncryptionBufferPoolSegmentSize))
+
+	if err := bm.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	verifyContent(ctx, t, bm, small, seededRandomData(1, 100))
+	verifyContent(ctx, t, bm, large, seededRandomData(2, 2*lowMemoryEncryptionBufferPoolSegmentSize))
+}
+
+func TestBackgroundPackUploads(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	st := &concurrencyTrackingStorage{Storage: blobtesting.NewMapStorage(data, nil, nil), delay: 50 * time.Millisecond}
+
+	const uploadConcurrency = 4
+
+	bm, err := newManagerWithOptions(ctx, st, &FormattingOptions{
+		Hash:        "HMAC-SHA256",
+		Encryption:  "AES256-GCM-HMAC-SHA256",
+		HMACSecret:  hmacSecret,
+		MaxPackSize: maxPackSize,
+		Version:     1,
+	}, nil, ManagerOptions{TimeNow: faketime.Frozen(fakeTime), UploadConcurrency: uploadConcurrency})
+	if err != nil {
+		t.Fatalf("can't create content manager: %v", err)
+	}
+
+	defer bm.Close(ctx)
+
+	var ids []ID
+
+	// each content fills a pack, so a single writer produces packs faster than they can be uploaded.
+	for i := 0; i < 20; i++ {
+		ids = append(ids, writeContentAndVerify(ctx, t, bm, seededRandomData(i, maxPackCapacity)))
+	}
+
+	if err := bm.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	if got := st.maxConcurrent(); got < 2 || got > uploadConcurrency {
+		t.Errorf("unexpected max concurrent uploads: %v, want between 2 and %v", got, uploadConcurrency)
+	}
+
+	bm2 := newTestContentManager(t, data, nil, nil)
+	defer bm2.Close(ctx)
+
+	for i, id := range ids {
+		verifyContent(ctx, t, bm2, id, seededRandomData(i, maxPackCapacity))
+	}
+}
+
+func TestBackgroundPackUploadsRetriedOnFlush(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	st := blobtesting.NewMapStorage(data, nil, nil)
+	fs := &blobtesting.FaultyStorage{
+		Base: st,
+		Faults: map[string][]*blobtesting.Fault{
+			"PutBlob": {
+				{Err: errors.Errorf("some write error")},
+			},
+		},
+	}
+
+	bm, err := newManagerWithOptions(ctx, fs, &FormattingOptions{
+		Hash:        "HMAC-SHA256",
+		Encryption:  "AES256-GCM-HMAC-SHA256",
+		HMACSecret:  hmacSecret,
+		MaxPackSize: maxPackSize,
+		Version:     1,
+	}, nil, ManagerOptions{TimeNow: faketime.Frozen(fakeTime), UploadConcurrency: 2, UploadMemoryBudget: maxPackSize})
+	if err != nil {
+		t.Fatalf("can't create content manager: %v", err)
+	}
+
+	defer bm.Close(ctx)
+
+	// the failed background upload is not reported to the writer.
+	id := writeContentAndVerify(ctx, t, bm, seededRandomData(1, maxPackCapacity))
+
+	if err := bm.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	bm2 := newTestContentManager(t, data, nil, nil)
+	defer bm2.Close(ctx)
+
+	verifyContent(ctx, t, bm2, id, seededRandomData(1, maxPackCapacity))
+}
+
+func TestBackgroundPackUploadsOutliveWriterContext(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	st := &concurrencyTrackingStorage{Storage: blobtesting.NewMapStorage(data, nil, nil), delay: 50 * time.Millisecond}
+
+	bm, err := newManagerWithOptions(ctx, st, &FormattingOptions{
+		Hash:        "HMAC-SHA256",
+		Encryption:  "AES256-GCM-HMAC-SHA256",
+		HMACSecret:  hmacSecret,
+		MaxPackSize: maxPackSize,
+		Version:     1,
+	}, nil, ManagerOptions{TimeNow: faketime.Frozen(fakeTime), UploadConcurrency: 2})
+	if err != nil {
+		t.Fatalf("can't create content manager: %v", err)
+	}
+
+	defer bm.Close(ctx)
+
+	writeCtx, cancel := context.WithCancel(ctx)
+
+	// the pack is uploaded in the background after the writer has returned and canceled its context.
+	id, err := bm.WriteContent(writeCtx, seededRandomData(1, maxPackCapacity), "")
+	if err != nil {
+		t.Fatalf("unable to write content: %v", err)
+	}
+
+	cancel()
+
+	if err := bm.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	if got := st.putCount(); got != 2 {
+		t.Errorf("unexpected number of uploads: %v, want pack and index", got)
+	}
+
+	bm2 := newTestContentManager(t, data, nil, nil)
+	defer bm2.Close(ctx)
+
+	verifyContent(ctx, t, bm2, id, seededRandomData(1, maxPackCapacity))
+}
+
+// concurrencyTrackingStorage records the maximum number of concurrent PutBlob calls.
+type concurrencyTrackingStorage struct {
+	blob.Storage
+	delay time.Duration
+
+	mu      sync.Mutex
+	current int
+	max     int
+	puts    int
+}
+
+func (s *concurrencyTrackingStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	s.mu.Lock()
+	s.current++
+	s.puts++
+
+	if s.current > s.max {
+		s.max = s.current
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.current--
+		s.mu.Unlock()
+	}()
+
+	time.Sleep(s.delay)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.Storage.PutBlob(ctx, id, data, opts)
+}
+
+func (s *concurrencyTrackingStorage) maxConcurrent() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.max
+}
+
+func (s *concurrencyTrackingStorage) putCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.puts
+}
+
 func TestVersionCompatibility(t *testing.T) {
-	for writeVer := minSupportedReadVersion; writeVer <= currentWriteVersion; writeVer++ {
+	for writeVer := minSupportedReadVersion; writeVer <= maxSupportedWriteVersion; writeVer++ {
 		writeVer := writeVer
 		t.Run(fmt.Sprintf("version-%v", writeVer), func(t *testing.T) {
 			verifyVersionCompat(t, writeVer)
@@ -1874,7 +2623,7 @@ func newTestContentManagerWithStorageAndCaching(t *testing.T, st blob.Storage, c
 		HMACSecret:  hmacSecret,
 		MaxPackSize: maxPackSize,
 		Version:     1,
-	}, co, timeFunc, nil)
+	}, co, ManagerOptions{TimeNow: timeFunc})
 	if err != nil {
 		panic("can't create content manager: " + err.Error())
 	}
@@ -2039,7 +2788,7 @@ func deleteContent(ctx context.Context, t *testing.T, bm *Manager, c ID) {
 func getContentInfo(t *testing.T, bm *Manager, c ID) Info {
 	t.Helper()
 
-	_, i, err := bm.getContentInfo(c)
+	_, i, err := bm.getContentInfo(testlogging.Context(t), c)
 	if err != nil {
 		t.Fatalf("Unable to get content info for %q: %v", c, err)
 	}
@@ -0,0 +1,80 @@
+package content
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/kopia/kopia/internal/ctxutil"
+)
+
+// packUploader limits the number of packs uploaded in the background and the total size of their data,
+// which is held in memory until the upload completes.
+type packUploader struct {
+	slots  chan struct{}
+	budget *semaphore.Weighted
+
+	maxBudget int64
+}
+
+func newPackUploader(concurrency int, memoryBudget int64) *packUploader {
+	return &packUploader{
+		slots:     make(chan struct{}, concurrency),
+		budget:    semaphore.NewWeighted(memoryBudget),
+		maxBudget: memoryBudget,
+	}
+}
+
+// acquire blocks until an upload slot and memory budget for a pack of the provided size are available
+// and returns the function that releases them.
+func (u *packUploader) acquire(ctx context.Context, size int64) (func(), error) {
+	// packs larger than the entire budget are uploaded when no other uploads are in progress.
+	if size > u.maxBudget {
+		size = u.maxBudget
+	}
+
+	if err := u.budget.Acquire(ctx, size); err != nil {
+		return nil, errors.Wrap(err, "unable to acquire upload memory budget")
+	}
+
+	select {
+	case u.slots <- struct{}{}:
+	case <-ctx.Done():
+		u.budget.Release(size)
+		return nil, errors.Wrap(ctx.Err(), "unable to acquire upload slot")
+	}
+
+	return func() {
+		<-u.slots
+		u.budget.Release(size)
+	}, nil
+}
+
+// writePackInBackground uploads the provided pack, which must be in writingPacks, in a separate goroutine
+// once an upload slot is available. Packs that fail to upload are retried by subsequent writes or Flush().
+// The upload outlives the write that triggered it, so it does not observe cancelation of the caller's context.
+func (bm *Manager) writePackInBackground(ctx context.Context, pp *pendingPackInfo) error {
+	release, err := bm.uploads.acquire(ctx, int64(pp.currentPackData.Length()))
+	if err != nil {
+		bm.lock()
+		bm.writingPacks = removePendingPack(bm.writingPacks, pp)
+		bm.failedPacks = append(bm.failedPacks, pp)
+		bm.cond.Broadcast()
+		bm.unlock()
+
+		return err
+	}
+
+	uploadCtx := ctxutil.Detach(ctx)
+
+	go func() {
+		defer release()
+
+		if err := bm.writePackAndAddToIndex(uploadCtx, pp, false); err != nil {
+			log(uploadCtx).Warningf("unable to upload pack %v, will retry: %v", pp.packBlobID, err)
+		}
+	}()
+
+	return nil
+}
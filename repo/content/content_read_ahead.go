@@ -0,0 +1,229 @@
+package content
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kopia/kopia/repo/blob"
+)
+
+const (
+	// maxReadAheadBlobs is the maximum number of pack blobs whose access pattern is tracked at the same time.
+	maxReadAheadBlobs = 8
+
+	// maxReadAheadChunksPerBlob is the maximum number of prefetched chunks held for each pack blob.
+	maxReadAheadChunksPerBlob = 2
+)
+
+// readAheadStorage wraps the storage to detect sequential reads of sections of pack blobs and fetch the following
+// sections in the background, which hides the latency of high-latency storage when reading large objects,
+// whose contents are usually stored next to each other in packs.
+type readAheadStorage struct {
+	blob.Storage
+
+	readAheadBytes int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	blobs   map[blob.ID]*readAheadBlob
+	useTick int64
+}
+
+// readAheadBlob tracks access pattern and prefetched chunks of a single pack blob.
+type readAheadBlob struct {
+	lastEnd  int64 // end offset of the last read, used to detect sequential access
+	length   int64 // length of the blob, -1 if not known yet
+	chunks   []*readAheadChunk
+	lastUsed int64
+}
+
+// readAheadChunk is a section of a blob being fetched in the background, data and err can only be accessed after done is closed.
+type readAheadChunk struct {
+	offset int64
+	length int64 // requested length, actual data may be shorter at the end of the blob
+	done   chan struct{}
+	data   []byte
+	err    error
+}
+
+func (c *readAheadChunk) end() int64 {
+	return c.offset + c.length
+}
+
+func (c *readAheadChunk) failed() bool {
+	select {
+	case <-c.done:
+		return c.err != nil
+	default:
+		return false
+	}
+}
+
+// slice returns the requested section from the fetched chunk data or nil if it's not available.
+func (c *readAheadChunk) slice(offset, length int64) []byte {
+	if c.err != nil || offset-c.offset+length > int64(len(c.data)) {
+		return nil
+	}
+
+	return append([]byte(nil), c.data[offset-c.offset:offset-c.offset+length]...)
+}
+
+func (r *readAheadStorage) GetBlob(ctx context.Context, id blob.ID, offset, length int64) ([]byte, error) {
+	if length <= 0 {
+		return r.Storage.GetBlob(ctx, id, offset, length)
+	}
+
+	r.mu.Lock()
+	b := r.blobLocked(id)
+	c := b.chunkContaining(offset, length)
+
+	if c != nil || offset == b.lastEnd {
+		r.readAheadLocked(id, b, offset+length)
+	}
+
+	b.lastEnd = offset + length
+	r.mu.Unlock()
+
+	if c != nil {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if data := c.slice(offset, length); data != nil {
+			return data, nil
+		}
+	}
+
+	return r.Storage.GetBlob(ctx, id, offset, length)
+}
+
+// blobLocked returns access state for the provided blob, evicting the least recently used one if needed.
+func (r *readAheadStorage) blobLocked(id blob.ID) *readAheadBlob {
+	r.useTick++
+
+	if b := r.blobs[id]; b != nil {
+		b.lastUsed = r.useTick
+		return b
+	}
+
+	if len(r.blobs) >= maxReadAheadBlobs {
+		var oldest blob.ID
+
+		for bid, b := range r.blobs {
+			if oldest == "" || b.lastUsed < r.blobs[oldest].lastUsed {
+				oldest = bid
+			}
+		}
+
+		delete(r.blobs, oldest)
+	}
+
+	b := &readAheadBlob{lastEnd: -1, length: -1, lastUsed: r.useTick}
+	r.blobs[id] = b
+
+	return b
+}
+
+func (b *readAheadBlob) chunkContaining(offset, length int64) *readAheadChunk {
+	for _, c := range b.chunks {
+		if c.offset <= offset && offset+length <= c.end() {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// readAheadLocked makes sure the data following the provided offset is being fetched in the background.
+func (r *readAheadStorage) readAheadLocked(id blob.ID, b *readAheadBlob, offset int64) {
+	// discard chunks which have already been read or failed.
+	var remaining []*readAheadChunk
+
+	start := offset
+
+	for _, c := range b.chunks {
+		if c.end() <= offset || c.failed() {
+			continue
+		}
+
+		remaining = append(remaining, c)
+
+		if c.end() > start {
+			start = c.end()
+		}
+	}
+
+	b.chunks = remaining
+
+	if start-offset >= r.readAheadBytes/2 || len(b.chunks) >= maxReadAheadChunksPerBlob {
+		// enough data is already being fetched.
+		return
+	}
+
+	if b.length >= 0 && start >= b.length {
+		return
+	}
+
+	c := &readAheadChunk{
+		offset: start,
+		length: r.readAheadBytes,
+		done:   make(chan struct{}),
+	}
+
+	b.chunks = append(b.chunks, c)
+
+	r.wg.Add(1)
+
+	go func(blobLength int64) {
+		defer r.wg.Done()
+		defer close(c.done)
+
+		if blobLength < 0 {
+			md, err := r.Storage.GetMetadata(r.ctx, id)
+			if err != nil {
+				c.err = err
+				return
+			}
+
+			r.mu.Lock()
+			b.length = md.Length
+			r.mu.Unlock()
+
+			blobLength = md.Length
+		}
+
+		n := c.length
+		if c.offset+n > blobLength {
+			n = blobLength - c.offset
+		}
+
+		if n <= 0 {
+			return
+		}
+
+		c.data, c.err = r.Storage.GetBlob(r.ctx, id, c.offset, n)
+	}(b.length)
+}
+
+// close stops all reads in progress.
+func (r *readAheadStorage) close() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+func newReadAheadStorage(st blob.Storage, readAheadBytes int64) *readAheadStorage {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &readAheadStorage{
+		Storage:        st,
+		readAheadBytes: readAheadBytes,
+		ctx:            ctx,
+		cancel:         cancel,
+		blobs:          map[blob.ID]*readAheadBlob{},
+	}
+}
@@ -0,0 +1,152 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// getBlobCountingStorage counts GetBlob calls made to the underlying storage.
+type getBlobCountingStorage struct {
+	blob.Storage
+
+	getBlobCount int32
+}
+
+func (s *getBlobCountingStorage) GetBlob(ctx context.Context, id blob.ID, offset, length int64) ([]byte, error) {
+	atomic.AddInt32(&s.getBlobCount, 1)
+	return s.Storage.GetBlob(ctx, id, offset, length)
+}
+
+func newReadAheadTestStorage(t *testing.T, blobLength int) (*getBlobCountingStorage, []byte) {
+	t.Helper()
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), blobLength/16)
+	st := &getBlobCountingStorage{Storage: blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil)}
+
+	require.NoError(t, st.PutBlob(testlogging.Context(t), "blob1", gather.FromSlice(data), blob.PutOptions{}))
+
+	return st, data
+}
+
+func TestReadAheadSequential(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	const (
+		blobLength  = 1 << 20
+		sectionSize = 1000
+		readAhead   = 64 << 10
+	)
+
+	st, data := newReadAheadTestStorage(t, blobLength)
+
+	ra := newReadAheadStorage(st, readAhead)
+	defer ra.close()
+
+	numReads := 0
+
+	for offset := int64(0); offset < blobLength; offset += sectionSize {
+		length := int64(sectionSize)
+		if offset+length > blobLength {
+			length = blobLength - offset
+		}
+
+		b, err := ra.GetBlob(ctx, "blob1", offset, length)
+		require.NoError(t, err)
+		require.Equal(t, data[offset:offset+length], b)
+
+		numReads++
+	}
+
+	// most sections were read from the chunks fetched in the background.
+	require.Less(t, int(atomic.LoadInt32(&st.getBlobCount)), 3*blobLength/readAhead)
+	require.Greater(t, numReads, 3*blobLength/readAhead)
+}
+
+func TestReadAheadRandom(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	st, data := newReadAheadTestStorage(t, 1<<20)
+
+	ra := newReadAheadStorage(st, 64<<10)
+	defer ra.close()
+
+	offsets := []int64{500000, 1000, 300000, 200, 900000}
+
+	for _, offset := range offsets {
+		b, err := ra.GetBlob(ctx, "blob1", offset, 100)
+		require.NoError(t, err)
+		require.Equal(t, data[offset:offset+100], b)
+	}
+
+	// nothing was fetched in the background.
+	require.Equal(t, len(offsets), int(atomic.LoadInt32(&st.getBlobCount)))
+}
+
+func TestReadAheadMissingBlob(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	st, _ := newReadAheadTestStorage(t, 1<<10)
+
+	ra := newReadAheadStorage(st, 64<<10)
+	defer ra.close()
+
+	for offset := int64(0); offset < 1000; offset += 100 {
+		_, err := ra.GetBlob(ctx, "no-such-blob", offset, 100)
+		require.True(t, errors.Is(err, blob.ErrBlobNotFound))
+	}
+}
+
+func TestContentManagerReadAhead(t *testing.T) {
+	ctx := testlogging.Context(t)
+	st := &getBlobCountingStorage{Storage: blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil)}
+
+	newManager := func(co *CachingOptions) *Manager {
+		bm, err := newManagerWithOptions(ctx, st, &FormattingOptions{
+			Hash:        "HMAC-SHA256",
+			Encryption:  "AES256-GCM-HMAC-SHA256",
+			HMACSecret:  hmacSecret,
+			MaxPackSize: 1 << 20,
+			Version:     1,
+		}, co, ManagerOptions{})
+		require.NoError(t, err)
+
+		return bm
+	}
+
+	bm := newManager(nil)
+
+	var ids []ID
+
+	for i := 0; i < 100; i++ {
+		id, err := bm.WriteContent(ctx, seededRandomData(i, 1000), "")
+		require.NoError(t, err)
+
+		ids = append(ids, id)
+	}
+
+	require.NoError(t, bm.Close(ctx))
+
+	bm2 := newManager(&CachingOptions{ReadAheadBytes: 64 << 10})
+	defer bm2.Close(ctx)
+
+	before := atomic.LoadInt32(&st.getBlobCount)
+
+	for i, id := range ids {
+		b, err := bm2.GetContent(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, seededRandomData(i, 1000), b)
+	}
+
+	// contents written together are stored sequentially in a pack and most of them were read ahead.
+	require.Less(t, int(atomic.LoadInt32(&st.getBlobCount)-before), len(ids)/2)
+}
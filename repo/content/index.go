@@ -82,6 +82,7 @@ func readHeader(readerAt io.ReaderAt) (headerInfo, error) {
 		entryCount: int(binary.BigEndian.Uint32(header[4:8])),
 	}
 
+	// on 32-bit platforms entry counts which don't fit in int become negative.
 	if hi.keySize <= 1 || hi.valueSize < 0 || hi.entryCount < 0 {
 		return headerInfo{}, errors.Errorf("invalid header")
 	}
@@ -89,6 +90,12 @@ func readHeader(readerAt io.ReaderAt) (headerInfo, error) {
 	return hi, nil
 }
 
+// entryOffset returns the offset of the entry at the provided position, computed using 64-bit
+// arithmetic to avoid overflows on 32-bit platforms.
+func (b *index) entryOffset(position int) int64 {
+	return packHeaderSize + int64(b.hdr.keySize+b.hdr.valueSize)*int64(position)
+}
+
 // Iterate invokes the provided callback function for a range of contents in the index, sorted alphabetically.
 // The iteration ends when the callback returns an error, which is propagated to the caller or when
 // all contents have been visited.
@@ -102,7 +109,7 @@ func (b *index) Iterate(r IDRange, cb func(Info) error) error {
 	entry := make([]byte, stride)
 
 	for i := startPos; i < b.hdr.entryCount; i++ {
-		n, err := b.readerAt.ReadAt(entry, int64(packHeaderSize+stride*i))
+		n, err := b.readerAt.ReadAt(entry, b.entryOffset(i))
 		if err != nil || n != len(entry) {
 			return errors.Wrap(err, "unable to read from index")
 		}
@@ -146,7 +153,7 @@ func (b *index) findEntryPosition(contentID ID) (int, error) {
 		if readErr != nil {
 			return false
 		}
-		_, err := b.readerAt.ReadAt(entryBuf, int64(packHeaderSize+stride*p))
+		_, err := b.readerAt.ReadAt(entryBuf, b.entryOffset(p))
 		if err != nil {
 			readErr = err
 			return false
@@ -159,15 +166,13 @@ func (b *index) findEntryPosition(contentID ID) (int, error) {
 }
 
 func (b *index) findEntryPositionExact(idBytes, entryBuf []byte) (int, error) {
-	stride := b.hdr.keySize + b.hdr.valueSize
-
 	var readErr error
 
 	pos := sort.Search(b.hdr.entryCount, func(p int) bool {
 		if readErr != nil {
 			return false
 		}
-		_, err := b.readerAt.ReadAt(entryBuf, int64(packHeaderSize+stride*p))
+		_, err := b.readerAt.ReadAt(entryBuf, b.entryOffset(p))
 		if err != nil {
 			readErr = err
 			return false
@@ -214,7 +219,7 @@ func (b *index) findEntry(output []byte, contentID ID) ([]byte, error) {
 		return nil, nil
 	}
 
-	if _, err := b.readerAt.ReadAt(entryBuf, int64(packHeaderSize+stride*position)); err != nil {
+	if _, err := b.readerAt.ReadAt(entryBuf, b.entryOffset(position)); err != nil {
 		return nil, err
 	}
 
@@ -67,6 +67,10 @@ type indexBlobManagerImpl struct {
 	timeNow                          func() time.Time
 	indexBlobCache                   contentCache
 	maxEventualConsistencySettleTime time.Duration
+	verifyWrites                     bool
+
+	// retention of index blobs, compaction logs and cleanup blobs are short-lived and never locked
+	retention blob.PutOptions
 }
 
 func (m *indexBlobManagerImpl) listIndexBlobs(ctx context.Context, includeInactive bool) ([]IndexBlobInfo, error) {
@@ -135,7 +139,7 @@ func (m *indexBlobManagerImpl) registerCompaction(ctx context.Context, inputs, o
 		return errors.Wrap(err, "unable to marshal log entry bytes")
 	}
 
-	compactionLogBlobMetadata, err := m.encryptAndWriteBlob(ctx, logEntryBytes, compactionLogBlobPrefix)
+	compactionLogBlobMetadata, err := m.encryptAndWriteBlob(ctx, logEntryBytes, compactionLogBlobPrefix, blob.PutOptions{})
 	if err != nil {
 		return errors.Wrap(err, "unable to write compaction log")
 	}
@@ -162,6 +166,19 @@ func (m *indexBlobManagerImpl) getIndexBlob(ctx context.Context, blobID blob.ID)
 }
 
 func (m *indexBlobManagerImpl) getEncryptedBlob(ctx context.Context, blobID blob.ID) ([]byte, error) {
+	payload, err := m.getAndDecryptBlob(ctx, blobID)
+	if !errors.Is(err, ErrInvalidChecksum) {
+		return payload, err
+	}
+
+	// the blob may have been corrupted in the local cache, evict it and refetch from the storage.
+	log(ctx).Warningf("unable to verify blob %v, refetching: %v", blobID, err)
+	m.indexBlobCache.evictCorrupted(ctx, cacheKey(blobID), blobID)
+
+	return m.getAndDecryptBlob(ctx, blobID)
+}
+
+func (m *indexBlobManagerImpl) getAndDecryptBlob(ctx context.Context, blobID blob.ID) ([]byte, error) {
 	payload, err := m.indexBlobCache.getContent(ctx, cacheKey(blobID), blobID, 0, -1)
 	if err != nil {
 		return nil, err
@@ -175,7 +192,7 @@ func (m *indexBlobManagerImpl) getEncryptedBlob(ctx context.Context, blobID blob
 	payload, err = m.encryptor.Decrypt(nil, payload, iv)
 
 	if err != nil {
-		return nil, errors.Wrap(err, "decrypt error")
+		return nil, errors.Wrapf(ErrInvalidChecksum, "decrypt error: %v", err)
 	}
 
 	// Since the encryption key is a function of data, we must be able to generate exactly the same key
@@ -194,17 +211,17 @@ func (m *indexBlobManagerImpl) verifyChecksum(data, contentID []byte) error {
 	expected = expected[len(expected)-aes.BlockSize:]
 
 	if !bytes.HasSuffix(contentID, expected) {
-		return errors.Errorf("invalid checksum for blob %x, expected %x", contentID, expected)
+		return errors.Wrapf(ErrInvalidChecksum, "blob %x, expected %x", contentID, expected)
 	}
 
 	return nil
 }
 
 func (m *indexBlobManagerImpl) writeIndexBlob(ctx context.Context, data []byte) (blob.Metadata, error) {
-	return m.encryptAndWriteBlob(ctx, data, indexBlobPrefix)
+	return m.encryptAndWriteBlob(ctx, data, indexBlobPrefix, m.retention)
 }
 
-func (m *indexBlobManagerImpl) encryptAndWriteBlob(ctx context.Context, data []byte, prefix blob.ID) (blob.Metadata, error) {
+func (m *indexBlobManagerImpl) encryptAndWriteBlob(ctx context.Context, data []byte, prefix blob.ID, opts blob.PutOptions) (blob.Metadata, error) {
 	var hashOutput [maxHashSize]byte
 
 	hash := m.hasher(hashOutput[:0], data)
@@ -222,7 +239,12 @@ func (m *indexBlobManagerImpl) encryptAndWriteBlob(ctx context.Context, data []b
 
 	m.listCache.deleteListCache(prefix)
 
-	err = m.st.PutBlob(ctx, blobID, gather.FromSlice(data2))
+	if m.verifyWrites {
+		err = blob.PutBlobAndVerify(ctx, m.st, blobID, gather.FromSlice(data2), opts)
+	} else {
+		err = m.st.PutBlob(ctx, blobID, gather.FromSlice(data2), opts)
+	}
+
 	if err != nil {
 		formatLog(ctx).Debugf("write-index-blob %v failed %v", blobID, err)
 		return blob.Metadata{}, err
@@ -307,7 +329,7 @@ func (m *indexBlobManagerImpl) deleteOldBlobs(ctx context.Context, latestBlob bl
 
 	// look for server-assigned timestamp of the compaction log entry we just wrote as a reference.
 	// we're assuming server-generated timestamps are somewhat reasonable and time is moving
-	compactionLogServerTimeCutoff := latestBlob.Timestamp.Add(-m.maxEventualConsistencySettleTime)
+	compactionLogServerTimeCutoff := latestBlob.Timestamp.Add(-m.minCompactionLogAge())
 	compactionBlobs := blobsOlderThan(allCompactionLogBlobs, compactionLogServerTimeCutoff)
 
 	log(ctx).Debugf("fetching %v/%v compaction logs older than %v", len(compactionBlobs), len(allCompactionLogBlobs), compactionLogServerTimeCutoff)
@@ -334,13 +356,24 @@ func (m *indexBlobManagerImpl) deleteOldBlobs(ctx context.Context, latestBlob bl
 	return nil
 }
 
+// minCompactionLogAge returns the minimum age of compaction logs whose input index blobs can be deleted.
+// Inputs of a compaction are always older than its log, so when index blobs are locked, waiting until
+// the log outlives the retention period guarantees that deletion of all inputs succeeds.
+func (m *indexBlobManagerImpl) minCompactionLogAge() time.Duration {
+	if m.retention.RetentionPeriod > m.maxEventualConsistencySettleTime {
+		return m.retention.RetentionPeriod
+	}
+
+	return m.maxEventualConsistencySettleTime
+}
+
 func (m *indexBlobManagerImpl) findIndexBlobsToDelete(ctx context.Context, latestServerBlobTime time.Time, entries map[blob.ID]*compactionLogEntry) []blob.ID {
 	tmp := map[blob.ID]bool{}
 
 	for _, cl := range entries {
 		// are the input index blobs in this compaction eligble for deletion?
-		if age := latestServerBlobTime.Sub(cl.metadata.Timestamp); age < m.maxEventualConsistencySettleTime {
-			log(ctx).Debugf("not deleting compacted index blob used as inputs for compaction %v, because it's too recent: %v < %v", cl.metadata.BlobID, age, m.maxEventualConsistencySettleTime)
+		if age := latestServerBlobTime.Sub(cl.metadata.Timestamp); age < m.minCompactionLogAge() {
+			log(ctx).Debugf("not deleting compacted index blob used as inputs for compaction %v, because it's too recent: %v < %v", cl.metadata.BlobID, age, m.minCompactionLogAge())
 			continue
 		}
 
@@ -395,7 +428,7 @@ func (m *indexBlobManagerImpl) delayCleanupBlobs(ctx context.Context, blobIDs []
 		return errors.Wrap(err, "unable to marshal cleanup log bytes")
 	}
 
-	if _, err := m.encryptAndWriteBlob(ctx, payload, cleanupBlobPrefix); err != nil {
+	if _, err := m.encryptAndWriteBlob(ctx, payload, cleanupBlobPrefix, blob.PutOptions{}); err != nil {
 		return errors.Wrap(err, "unable to cleanup log")
 	}
 
@@ -109,6 +109,44 @@ func TestIndexBlobManager(t *testing.T) {
 	}
 }
 
+func TestIndexBlobManagerRetention(t *testing.T) {
+	const retentionPeriod = time.Hour
+
+	storageData := blobtesting.DataMap{}
+
+	fakeLocalTime := faketime.NewTimeAdvance(fakeLocalStartTime, 0)
+	fakeStorageTime := faketime.NewTimeAdvance(fakeStoreStartTime, 0)
+
+	st := blobtesting.NewMapStorage(storageData, nil, fakeStorageTime.NowFunc())
+	m := newIndexBlobManagerForTesting(t, st, fakeLocalTime.NowFunc())
+	m.(*indexBlobManagerImpl).retention = blob.PutOptions{
+		RetentionMode:   blob.RetentionModeCompliance,
+		RetentionPeriod: retentionPeriod,
+	}
+
+	b1 := mustWriteIndexBlob(t, m, "index-1")
+	b2 := mustWriteIndexBlob(t, m, "index-2")
+	b3 := mustWriteIndexBlob(t, m, "index-3")
+	mustRegisterCompaction(t, m, []blob.Metadata{b1, b2}, []blob.Metadata{b3})
+	fakeStorageTime.Advance(testIndexBlobDeleteAge + 1*time.Second)
+
+	// compacted index blobs are still locked, so they must not be deleted yet.
+	b4 := mustWriteIndexBlob(t, m, "index-4")
+	b5 := mustWriteIndexBlob(t, m, "index-5")
+	mustRegisterCompaction(t, m, []blob.Metadata{b3, b4}, []blob.Metadata{b5})
+	assertIndexBlobList(t, m, b5)
+	assertBlobCounts(t, storageData, 5, 2, 0)
+
+	fakeStorageTime.Advance(retentionPeriod + 1*time.Second)
+
+	// once the retention expires, all compacted index blobs are deleted.
+	b6 := mustWriteIndexBlob(t, m, "index-6")
+	b7 := mustWriteIndexBlob(t, m, "index-7")
+	mustRegisterCompaction(t, m, []blob.Metadata{b5, b6}, []blob.Metadata{b7})
+	assertIndexBlobList(t, m, b7)
+	assertBlobCounts(t, storageData, 3, 3, 1)
+}
+
 type action int
 
 const (
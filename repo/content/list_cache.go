@@ -11,6 +11,7 @@
 
 	"github.com/natefinch/atomic"
 	"github.com/pkg/errors"
+	"go.opencensus.io/stats"
 
 	"github.com/kopia/kopia/internal/clock"
 	"github.com/kopia/kopia/internal/hmac"
@@ -92,6 +93,10 @@ func (c *listCache) readBlobsFromCache(ctx context.Context, prefix blob.ID) (*ca
 
 	data, err = hmac.VerifyAndStrip(data, c.hmacSecret)
 	if err != nil {
+		// remove corrupted file, so that the list is fetched from the storage and cached again.
+		stats.Record(ctx, metricContentCacheCorruptCount.M(1))
+		c.deleteListCache(prefix)
+
 		return nil, errors.Wrapf(err, "invalid file %v", fname)
 	}
 
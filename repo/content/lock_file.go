@@ -0,0 +1,162 @@
+package content
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+)
+
+// staleLockFileAge is the age after which lock files left behind by crashed processes are removed.
+const staleLockFileAge = 10 * time.Minute
+
+// fileLock is a lock shared by processes using the same cache directory.
+type fileLock interface {
+	TryLock() (bool, error)
+	TryLockContext(ctx context.Context, retryDelay time.Duration) (bool, error)
+	Unlock() error
+}
+
+// newFileLock returns advisory file lock, or a lock based on exclusively created files when useLockFiles
+// is set, because advisory locks are not reliable on many network filesystems.
+func newFileLock(path string, useLockFiles bool) fileLock {
+	if useLockFiles {
+		return &exclusiveFileLock{path: path}
+	}
+
+	return flock.New(path)
+}
+
+// exclusiveFileLock is held by the process which has created the lock file, which is safe on network filesystems
+// since exclusive creation of files is atomic on NFSv3 and later and SMB.
+type exclusiveFileLock struct {
+	path string
+
+	// owner is the content of the lock file written by this lock, empty when not locked.
+	owner string
+}
+
+func (l *exclusiveFileLock) TryLock() (bool, error) {
+	ok, err := l.tryCreate()
+	if err != nil || ok {
+		return ok, err
+	}
+
+	st, err := os.Stat(l.path)
+	if err != nil || clock.Since(st.ModTime()) < staleLockFileAge {
+		// lock is held by another process or was released in the meantime, in which case
+		// the caller will retry.
+		return false, nil
+	}
+
+	stale, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		return false, nil
+	}
+
+	if err := l.takeOver(string(stale)); err != nil {
+		return false, err
+	}
+
+	return l.tryCreate()
+}
+
+// takeOver removes the stale lock file with the provided content. Processes racing to remove the same stale
+// lock could otherwise remove the lock file created by the winner, so the lock file is first atomically renamed
+// to a name unique to this process and put back if it turns out to have been replaced in the meantime.
+func (l *exclusiveFileLock) takeOver(stale string) error {
+	hostname, _ := os.Hostname()
+	tmp := fmt.Sprintf("%v.%v-%v-%x", l.path, hostname, os.Getpid(), clock.Now().UnixNano())
+
+	if err := os.Rename(l.path, tmp); err != nil {
+		if os.IsNotExist(err) {
+			// another process has taken over the lock.
+			return nil
+		}
+
+		return errors.Wrap(err, "unable to rename stale lock file")
+	}
+
+	if b, err := ioutil.ReadFile(tmp); err == nil && string(b) != stale {
+		// the lock was taken over by another process after we've read the stale lock file,
+		// restore it unless yet another lock file has been created.
+		if err := os.Link(tmp, l.path); err != nil && !os.IsExist(err) {
+			log(context.Background()).Warningf("unable to restore lock file %v: %v", l.path, err)
+		}
+	}
+
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "unable to remove stale lock file")
+	}
+
+	return nil
+}
+
+func (l *exclusiveFileLock) tryCreate() (bool, error) {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600) //nolint:gosec
+	if err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+
+		return false, errors.Wrap(err, "unable to create lock file")
+	}
+
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("%v %v %x\n", hostname, os.Getpid(), clock.Now().UnixNano())
+
+	_, err = f.WriteString(owner)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(l.path) //nolint:errcheck
+		return false, errors.Wrap(err, "unable to write lock file")
+	}
+
+	l.owner = owner
+
+	return true, nil
+}
+
+func (l *exclusiveFileLock) TryLockContext(ctx context.Context, retryDelay time.Duration) (bool, error) {
+	for {
+		ok, err := l.TryLock()
+		if err != nil || ok {
+			return ok, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// Unlock removes the lock file unless it was removed as stale and created by another process in the meantime.
+func (l *exclusiveFileLock) Unlock() error {
+	owner := l.owner
+	if owner == "" {
+		return nil
+	}
+
+	l.owner = ""
+
+	if b, err := ioutil.ReadFile(l.path); err != nil || string(b) != owner {
+		return nil
+	}
+
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "unable to remove lock file")
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package content
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/clock"
+)
+
+func TestExclusiveFileLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+
+	l1 := newFileLock(path, true)
+	l2 := newFileLock(path, true)
+
+	if ok, err := l1.TryLock(); err != nil || !ok {
+		t.Fatalf("unable to acquire lock: %v %v", ok, err)
+	}
+
+	if ok, err := l2.TryLock(); err != nil || ok {
+		t.Fatalf("lock acquired twice: %v %v", ok, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if ok, err := l2.TryLockContext(ctx, 10*time.Millisecond); err == nil || ok {
+		t.Fatalf("lock acquired twice: %v %v", ok, err)
+	}
+
+	if err := l1.Unlock(); err != nil {
+		t.Fatalf("unable to unlock: %v", err)
+	}
+
+	if ok, err := l2.TryLockContext(context.Background(), 10*time.Millisecond); err != nil || !ok {
+		t.Fatalf("unable to acquire released lock: %v %v", ok, err)
+	}
+
+	// lock files left behind by crashed processes are eventually removed.
+	stale := clock.Now().Add(-2 * staleLockFileAge)
+	if err := os.Chtimes(path, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := l1.TryLock(); err != nil || !ok {
+		t.Fatalf("unable to acquire stale lock: %v %v", ok, err)
+	}
+
+	// unlocking a lock which was removed as stale does not remove the lock file of its new owner.
+	if err := l2.Unlock(); err != nil {
+		t.Fatalf("unable to unlock: %v", err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("lock file of another owner was removed: %v", err)
+	}
+
+	if err := l1.Unlock(); err != nil {
+		t.Fatalf("unable to unlock: %v", err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("lock file was not removed: %v", err)
+	}
+}
+
+func TestExclusiveFileLockTakeOverRace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+
+	l1 := &exclusiveFileLock{path: path}
+	l2 := &exclusiveFileLock{path: path}
+
+	if ok, err := l1.TryLock(); err != nil || !ok {
+		t.Fatalf("unable to acquire lock: %v %v", ok, err)
+	}
+
+	// l2 has observed a stale lock file, which was taken over by l1 before l2 removed it.
+	if err := l2.takeOver("stale lock\n"); err != nil {
+		t.Fatalf("unable to take over lock: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("lock file of another owner was removed: %v", err)
+	}
+
+	if got, want := string(b), l1.owner; got != want {
+		t.Fatalf("unexpected lock file owner %q, want %q", got, want)
+	}
+
+	if ok, err := l2.TryLock(); err != nil || ok {
+		t.Fatalf("lock acquired twice: %v %v", ok, err)
+	}
+
+	if err := l1.Unlock(); err != nil {
+		t.Fatalf("unable to unlock: %v", err)
+	}
+
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Errorf("temporary lock files were not removed: %v", matches)
+	}
+}
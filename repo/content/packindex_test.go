@@ -5,11 +5,13 @@
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
+	"math"
 	"math/rand"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/kopia/kopia/internal/testlogging"
 	"github.com/kopia/kopia/repo/blob"
 )
 
@@ -260,3 +262,62 @@ func fuzzTest(rnd *rand.Rand, originalData []byte, rounds int, callback func(d [
 		callback(data)
 	}
 }
+
+func TestPackIndexDiskCacheMmapAndFileReads(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	b := make(packIndexBuilder)
+
+	for i := 0; i < 100; i++ {
+		b.Add(Info{
+			TimestampSeconds: randomUnixTime(),
+			ID:               deterministicContentID("packed", i),
+			PackBlobID:       deterministicPackBlobID(i),
+			PackOffset:       deterministicPackedOffset(i),
+			Length:           deterministicPackedLength(i),
+			FormatVersion:    deterministicFormatVersion(i),
+		})
+	}
+
+	var buf bytes.Buffer
+
+	if err := b.Build(&buf); err != nil {
+		t.Fatalf("unable to build: %v", err)
+	}
+
+	for _, disableMmap := range []bool{false, true} {
+		c := &diskCommittedContentIndexCache{dirname: t.TempDir(), disableMmap: disableMmap}
+
+		if err := c.addContentToCache(ctx, "ndx1", buf.Bytes()); err != nil {
+			t.Fatalf("unable to add index to cache: %v", err)
+		}
+
+		ndx, err := c.openIndex(ctx, "ndx1")
+		if err != nil {
+			t.Fatalf("unable to open index (disableMmap=%v): %v", disableMmap, err)
+		}
+
+		for _, info := range b {
+			info2, err := ndx.GetInfo(info.ID)
+			if err != nil || info2 == nil {
+				t.Fatalf("unable to find %v (disableMmap=%v): %v", info.ID, disableMmap, err)
+			}
+
+			if !reflect.DeepEqual(*info, *info2) {
+				t.Errorf("invalid value retrieved (disableMmap=%v): %+v, wanted %+v", disableMmap, info2, info)
+			}
+		}
+
+		if err := ndx.Close(); err != nil {
+			t.Errorf("unable to close index: %v", err)
+		}
+	}
+}
+
+func TestPackIndexEntryOffsetDoesNotOverflow(t *testing.T) {
+	ndx := &index{hdr: headerInfo{keySize: 17, valueSize: entryFixedHeaderLength, entryCount: math.MaxInt32}}
+
+	if got, want := ndx.entryOffset(math.MaxInt32), int64(packHeaderSize)+37*int64(math.MaxInt32); got != want {
+		t.Errorf("invalid entry offset: %v, want %v", got, want)
+	}
+}
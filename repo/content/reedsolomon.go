@@ -0,0 +1,393 @@
+package content
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Reed-Solomon error correction over GF(2^8) used to protect pack index recovery data.
+// Data is split into blocks of up to (255-nsym) bytes, each protected by nsym parity bytes,
+// which allows correcting up to nsym/2 corrupted bytes in each block.
+//
+// Polynomials are represented as byte slices with the highest-degree coefficient first.
+
+const (
+	gfSize          = 255
+	gfPrimitivePoly = 0x11d
+
+	// MaxLocalIndexECCBytes is the maximum number of parity bytes protecting each block of local index in packs.
+	MaxLocalIndexECCBytes = 64
+)
+
+var errUncorrectable = errors.New("too many errors to correct")
+
+var (
+	gfExp [2 * gfSize]byte
+	gfLog [gfSize + 1]int
+)
+
+func init() {
+	x := 1
+
+	for i := 0; i < gfSize; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = i
+
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= gfPrimitivePoly
+		}
+	}
+
+	for i := gfSize; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-gfSize]
+	}
+}
+
+func gfMul(x, y byte) byte {
+	if x == 0 || y == 0 {
+		return 0
+	}
+
+	return gfExp[gfLog[x]+gfLog[y]]
+}
+
+func gfDiv(x, y byte) byte {
+	if x == 0 {
+		return 0
+	}
+
+	return gfExp[(gfLog[x]+gfSize-gfLog[y])%gfSize]
+}
+
+func gfPow(x byte, power int) byte {
+	e := (gfLog[x] * power) % gfSize
+	if e < 0 {
+		e += gfSize
+	}
+
+	return gfExp[e]
+}
+
+func gfInverse(x byte) byte {
+	return gfExp[gfSize-gfLog[x]]
+}
+
+func gfPolyScale(p []byte, x byte) []byte {
+	r := make([]byte, len(p))
+	for i := range p {
+		r[i] = gfMul(p[i], x)
+	}
+
+	return r
+}
+
+func gfPolyAdd(p, q []byte) []byte {
+	n := len(p)
+	if len(q) > n {
+		n = len(q)
+	}
+
+	r := make([]byte, n)
+
+	for i := range p {
+		r[i+n-len(p)] = p[i]
+	}
+
+	for i := range q {
+		r[i+n-len(q)] ^= q[i]
+	}
+
+	return r
+}
+
+func gfPolyMul(p, q []byte) []byte {
+	r := make([]byte, len(p)+len(q)-1)
+
+	for j := range q {
+		for i := range p {
+			r[i+j] ^= gfMul(p[i], q[j])
+		}
+	}
+
+	return r
+}
+
+func gfPolyEval(p []byte, x byte) byte {
+	y := p[0]
+	for i := 1; i < len(p); i++ {
+		y = gfMul(y, x) ^ p[i]
+	}
+
+	return y
+}
+
+// gfPolyRemainder returns the remainder of dividing p by a monic divisor.
+func gfPolyRemainder(p, divisor []byte) []byte {
+	out := append([]byte(nil), p...)
+
+	for i := 0; i < len(p)-(len(divisor)-1); i++ {
+		coef := out[i]
+		if coef == 0 {
+			continue
+		}
+
+		for j := 1; j < len(divisor); j++ {
+			out[i+j] ^= gfMul(divisor[j], coef)
+		}
+	}
+
+	sep := len(out) - (len(divisor) - 1)
+	if sep < 0 {
+		sep = 0
+	}
+
+	return out[sep:]
+}
+
+func rsGeneratorPoly(nsym int) []byte {
+	g := []byte{1}
+	for i := 0; i < nsym; i++ {
+		g = gfPolyMul(g, []byte{1, gfPow(2, i)})
+	}
+
+	return g
+}
+
+// rsEncodeBlock returns nsym parity bytes for the provided message of up to (255-nsym) bytes.
+func rsEncodeBlock(msg []byte, nsym int) []byte {
+	gen := rsGeneratorPoly(nsym)
+	out := make([]byte, len(msg)+nsym)
+	copy(out, msg)
+
+	for i := range msg {
+		coef := out[i]
+		if coef == 0 {
+			continue
+		}
+
+		for j := 1; j < len(gen); j++ {
+			out[i+j] ^= gfMul(gen[j], coef)
+		}
+	}
+
+	return out[len(msg):]
+}
+
+// rsSyndromes returns syndromes of the codeword, with a leading zero coefficient.
+func rsSyndromes(codeword []byte, nsym int) (synd []byte, hasErrors bool) {
+	synd = make([]byte, nsym+1)
+
+	for i := 0; i < nsym; i++ {
+		synd[i+1] = gfPolyEval(codeword, gfPow(2, i))
+		if synd[i+1] != 0 {
+			hasErrors = true
+		}
+	}
+
+	return synd, hasErrors
+}
+
+// rsErrorLocator computes the error locator polynomial using Berlekamp-Massey algorithm.
+func rsErrorLocator(synd []byte, nsym int) ([]byte, error) {
+	errLoc := []byte{1}
+	oldLoc := []byte{1}
+	syndShift := len(synd) - nsym
+
+	for i := 0; i < nsym; i++ {
+		k := i + syndShift
+		delta := synd[k]
+
+		for j := 1; j < len(errLoc); j++ {
+			delta ^= gfMul(errLoc[len(errLoc)-(j+1)], synd[k-j])
+		}
+
+		oldLoc = append(oldLoc, 0)
+
+		if delta != 0 {
+			if len(oldLoc) > len(errLoc) {
+				newLoc := gfPolyScale(oldLoc, delta)
+				oldLoc = gfPolyScale(errLoc, gfInverse(delta))
+				errLoc = newLoc
+			}
+
+			errLoc = gfPolyAdd(errLoc, gfPolyScale(oldLoc, delta))
+		}
+	}
+
+	for len(errLoc) > 0 && errLoc[0] == 0 {
+		errLoc = errLoc[1:]
+	}
+
+	if errs := len(errLoc) - 1; errs*2 > nsym {
+		return nil, errUncorrectable
+	}
+
+	return errLoc, nil
+}
+
+// rsErrorPositions finds positions of errors in the codeword using Chien search.
+func rsErrorPositions(errLoc []byte, n int) ([]int, error) {
+	reversed := make([]byte, len(errLoc))
+	for i := range errLoc {
+		reversed[len(errLoc)-1-i] = errLoc[i]
+	}
+
+	var pos []int
+
+	for i := 0; i < n; i++ {
+		if gfPolyEval(reversed, gfPow(2, i)) == 0 {
+			pos = append(pos, n-1-i)
+		}
+	}
+
+	if len(pos) != len(errLoc)-1 {
+		return nil, errUncorrectable
+	}
+
+	return pos, nil
+}
+
+// rsCorrectErrata fixes errors at the provided positions using Forney algorithm.
+func rsCorrectErrata(codeword, synd []byte, errPos []int) {
+	coefPos := make([]int, len(errPos))
+	for i, p := range errPos {
+		coefPos[i] = len(codeword) - 1 - p
+	}
+
+	errataLoc := []byte{1}
+	for _, p := range coefPos {
+		errataLoc = gfPolyMul(errataLoc, gfPolyAdd([]byte{1}, []byte{gfPow(2, p), 0}))
+	}
+
+	reversedSynd := make([]byte, len(synd))
+	for i := range synd {
+		reversedSynd[len(synd)-1-i] = synd[i]
+	}
+
+	divisor := make([]byte, len(errataLoc)+1)
+	divisor[0] = 1
+
+	errEval := gfPolyRemainder(gfPolyMul(reversedSynd, errataLoc), divisor)
+
+	x := make([]byte, len(coefPos))
+	for i, p := range coefPos {
+		x[i] = gfPow(2, p)
+	}
+
+	for i, xi := range x {
+		xiInv := gfInverse(xi)
+
+		locPrime := byte(1)
+
+		for j := range x {
+			if j != i {
+				locPrime = gfMul(locPrime, 1^gfMul(xiInv, x[j]))
+			}
+		}
+
+		y := gfMul(xi, gfPolyEval(errEval, xiInv))
+
+		codeword[errPos[i]] ^= gfDiv(y, locPrime)
+	}
+}
+
+// rsCorrectBlock corrects errors in the provided codeword (message followed by nsym parity bytes) in place.
+func rsCorrectBlock(codeword []byte, nsym int) error {
+	synd, hasErrors := rsSyndromes(codeword, nsym)
+	if !hasErrors {
+		return nil
+	}
+
+	errLoc, err := rsErrorLocator(synd, nsym)
+	if err != nil {
+		return err
+	}
+
+	errPos, err := rsErrorPositions(errLoc, len(codeword))
+	if err != nil {
+		return err
+	}
+
+	rsCorrectErrata(codeword, synd, errPos)
+
+	if _, hasErrors := rsSyndromes(codeword, nsym); hasErrors {
+		return errUncorrectable
+	}
+
+	return nil
+}
+
+// ValidateLocalIndexECCBytes returns an error if the provided number of parity bytes protecting local indexes
+// is not supported. Each pair of parity bytes corrects one corrupted byte, so the number must be even.
+func ValidateLocalIndexECCBytes(n int) error {
+	if n == 0 {
+		return nil
+	}
+
+	if n < 2 || n > MaxLocalIndexECCBytes || n%2 != 0 {
+		return errors.Errorf("invalid number of local index ECC bytes: %v, must be 0 (disabled) or an even number between 2 and %v", n, MaxLocalIndexECCBytes)
+	}
+
+	return nil
+}
+
+func rsBlockSize(nsym int) int {
+	return gfSize - nsym
+}
+
+// rsParityLength returns the number of parity bytes for data of the provided length.
+func rsParityLength(dataLength, nsym int) int {
+	bs := rsBlockSize(nsym)
+
+	return (dataLength + bs - 1) / bs * nsym
+}
+
+// rsEncode returns parity bytes protecting the provided data.
+func rsEncode(data []byte, nsym int) []byte {
+	var parity []byte
+
+	bs := rsBlockSize(nsym)
+
+	for len(data) > 0 {
+		n := bs
+		if n > len(data) {
+			n = len(data)
+		}
+
+		parity = append(parity, rsEncodeBlock(data[0:n], nsym)...)
+		data = data[n:]
+	}
+
+	return parity
+}
+
+// rsCorrect returns a copy of data with errors corrected using the provided parity bytes.
+func rsCorrect(data, parity []byte, nsym int) ([]byte, error) {
+	if len(parity) != rsParityLength(len(data), nsym) {
+		return nil, errors.Errorf("invalid parity length %v for %v bytes of data", len(parity), len(data))
+	}
+
+	result := make([]byte, 0, len(data))
+	bs := rsBlockSize(nsym)
+
+	for len(data) > 0 {
+		n := bs
+		if n > len(data) {
+			n = len(data)
+		}
+
+		codeword := make([]byte, n+nsym)
+		copy(codeword, data[0:n])
+		copy(codeword[n:], parity[0:nsym])
+
+		if err := rsCorrectBlock(codeword, nsym); err != nil {
+			return nil, err
+		}
+
+		result = append(result, codeword[0:n]...)
+		data = data[n:]
+		parity = parity[nsym:]
+	}
+
+	return result, nil
+}
@@ -0,0 +1,81 @@
+package content
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	for _, nsym := range []int{2, 8, 16, 32} {
+		for _, length := range []int{1, 10, 100, 247, 248, 1000, 5000} {
+			data := make([]byte, length)
+			rnd.Read(data)
+
+			parity := rsEncode(data, nsym)
+			if got, want := len(parity), rsParityLength(length, nsym); got != want {
+				t.Fatalf("unexpected parity length: %v, want %v", got, want)
+			}
+
+			// corrupt up to nsym/2 bytes in each block, including parity.
+			corrupted := append([]byte(nil), data...)
+			corruptedParity := append([]byte(nil), parity...)
+
+			bs := rsBlockSize(nsym)
+			for blk := 0; blk*bs < length; blk++ {
+				n := bs
+				if rem := length - blk*bs; rem < n {
+					n = rem
+				}
+
+				for i := 0; i < nsym/2; i++ {
+					p := rnd.Intn(n + nsym)
+					if p < n {
+						corrupted[blk*bs+p] ^= byte(1 + rnd.Intn(255))
+					} else {
+						corruptedParity[blk*nsym+p-n] ^= byte(1 + rnd.Intn(255))
+					}
+				}
+			}
+
+			fixed, err := rsCorrect(corrupted, corruptedParity, nsym)
+			if err != nil {
+				t.Fatalf("unable to correct (nsym=%v, length=%v): %v", nsym, length, err)
+			}
+
+			if !bytes.Equal(fixed, data) {
+				t.Fatalf("invalid correction (nsym=%v, length=%v)", nsym, length)
+			}
+		}
+	}
+}
+
+func TestReedSolomonUncorrectable(t *testing.T) {
+	data := bytes.Repeat([]byte{1, 2, 3, 4}, 50)
+	parity := rsEncode(data, 4)
+
+	corrupted := append([]byte(nil), data...)
+	for i := 0; i < 10; i++ {
+		corrupted[i*7] ^= 0xff
+	}
+
+	if fixed, err := rsCorrect(corrupted, parity, 4); err == nil && bytes.Equal(fixed, data) {
+		t.Fatalf("unexpected successful correction")
+	}
+}
+
+func TestValidateLocalIndexECCBytes(t *testing.T) {
+	for _, n := range []int{0, 2, 8, MaxLocalIndexECCBytes} {
+		if err := ValidateLocalIndexECCBytes(n); err != nil {
+			t.Errorf("unexpected error for %v: %v", n, err)
+		}
+	}
+
+	for _, n := range []int{-2, 1, 3, 7, MaxLocalIndexECCBytes + 2} {
+		if err := ValidateLocalIndexECCBytes(n); err == nil {
+			t.Errorf("expected error for %v", n)
+		}
+	}
+}
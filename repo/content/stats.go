@@ -19,6 +19,8 @@ type Stats struct {
 	hashedContents  uint32
 	invalidContents uint32
 	validContents   uint32
+
+	corruptCacheEntries uint32
 }
 
 // Reset clears all content statistics.
@@ -34,6 +36,7 @@ func (s *Stats) Reset() {
 	atomic.StoreUint32(&s.hashedContents, 0)
 	atomic.StoreUint32(&s.invalidContents, 0)
 	atomic.StoreUint32(&s.validContents, 0)
+	atomic.StoreUint32(&s.corruptCacheEntries, 0)
 }
 
 // ReadContent returns the approximate read content count and their total size in bytes.
@@ -71,6 +74,11 @@ func (s *Stats) ValidContents() uint32 {
 	return atomic.LoadUint32(&s.validContents)
 }
 
+// CorruptCacheEntries returns the approximate count of corrupted cache entries which have been evicted and refetched.
+func (s *Stats) CorruptCacheEntries() uint32 {
+	return atomic.LoadUint32(&s.corruptCacheEntries)
+}
+
 func (s *Stats) decrypted(size int) int64 {
 	return atomic.AddInt64(&s.decryptedBytes, int64(size))
 }
@@ -99,6 +107,10 @@ func (s *Stats) foundInvalidContent() uint32 {
 	return atomic.AddUint32(&s.invalidContents, 1)
 }
 
+func (s *Stats) foundCorruptCacheEntry() uint32 {
+	return atomic.AddUint32(&s.corruptCacheEntries, 1)
+}
+
 func updateCountSum(count *uint32, sum *int64, delta int) (updatedCount uint32, updatedSum int64) {
 	return atomic.AddUint32(count, 1), atomic.AddInt64(sum, int64(delta))
 }
@@ -9,6 +9,8 @@
 	"crypto/sha256"
 	"encoding/json"
 	"io"
+	"os"
+	"path/filepath"
 
 	"github.com/pkg/errors"
 
@@ -48,6 +50,13 @@ type formatBlob struct {
 	UniqueID               []byte `json:"uniqueID"`
 	KeyDerivationAlgorithm string `json:"keyAlgo"`
 
+	// Namespace of blob storage the repository was created in, empty if the repository does not use a namespace.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Generation is incremented each time the format blob is written, so that stale replicas are never
+	// preferred over, or used to overwrite, a newer format blob.
+	Generation int64 `json:"generation,omitempty"`
+
 	Version              string                  `json:"version"`
 	EncryptionAlgorithm  string                  `json:"encryption"`
 	EncryptedFormatBytes []byte                  `json:"encryptedBlockFormat,omitempty"`
@@ -156,7 +165,9 @@ func verifyFormatBlobChecksum(b []byte) ([]byte, bool) {
 	return data, true
 }
 
-func writeFormatBlob(ctx context.Context, st blob.Storage, f *formatBlob) error {
+func writeFormatBlob(ctx context.Context, st blob.Storage, f *formatBlob, verify bool) error {
+	f.Generation++
+
 	buf := gather.NewWriteBuffer()
 	e := json.NewEncoder(buf)
 	e.SetIndent("", "  ")
@@ -165,11 +176,7 @@ func writeFormatBlob(ctx context.Context, st blob.Storage, f *formatBlob) error
 		return errors.Wrap(err, "unable to marshal format blob")
 	}
 
-	if err := st.PutBlob(ctx, FormatBlobID, buf.Bytes); err != nil {
-		return errors.Wrap(err, "unable to write format blob")
-	}
-
-	return nil
+	return writeFormatBlobCopies(ctx, st, buf.Bytes, verify)
 }
 
 func (f *formatBlob) decryptFormatBytes(masterKey []byte) (*repositoryObjectFormat, error) {
@@ -280,3 +287,39 @@ func addFormatBlobChecksumAndLength(fb []byte) ([]byte, error) {
 
 	return result, nil
 }
+
+// writeUpdatedFormatBlob encrypts the provided repository config into the format blob of the repository
+// and writes it, making sure the updated format blob is used next time the repository is opened.
+func (r *DirectRepository) writeUpdatedFormatBlob(ctx context.Context, repoConfig *repositoryObjectFormat) error {
+	f := r.formatBlob
+
+	if err := encryptFormatBytes(f, repoConfig, r.masterKey, f.UniqueID); err != nil {
+		return errors.Errorf("unable to encrypt format bytes")
+	}
+
+	log(ctx).Infof("writing updated format content...")
+
+	if err := writeFormatBlob(ctx, r.Blobs, f, r.verifyCriticalWrites); err != nil {
+		return err
+	}
+
+	if cd := r.Content.CachingOptions.CacheDirectory; cd != "" {
+		if err := os.Remove(filepath.Join(cd, FormatBlobID)); err != nil && !os.IsNotExist(err) {
+			log(ctx).Warningf("unable to remove cached format blob: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// requireFormatVersion upgrades the content format version of the repository config to at least the provided
+// version, which makes versions of kopia that don't support it refuse to open the repository.
+func requireFormatVersion(ctx context.Context, repoConfig *repositoryObjectFormat, v int) {
+	if repoConfig.FormattingOptions.Version >= v {
+		return
+	}
+
+	log(ctx).Infof("upgrading repository format to version %v", v)
+
+	repoConfig.FormattingOptions.Version = v
+}
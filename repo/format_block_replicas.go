@@ -0,0 +1,210 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// FormatBlobReplicaIDs are identifiers of BLOBs holding copies of the format blob, which are used
+// when the format blob is missing or corrupt.
+var FormatBlobReplicaIDs = []blob.ID{
+	FormatBlobID + ".r1",
+	FormatBlobID + ".r2",
+}
+
+// IsFormatBlobID returns true if the provided blob ID is the format blob or one of its replicas.
+func IsFormatBlobID(id blob.ID) bool {
+	if id == FormatBlobID {
+		return true
+	}
+
+	for _, r := range FormatBlobReplicaIDs {
+		if id == r {
+			return true
+		}
+	}
+
+	return false
+}
+
+// formatBlobGeneration returns the generation of the provided format blob contents and false if it's not valid.
+func formatBlobGeneration(b []byte) (int64, bool) {
+	f, err := parseFormatBlob(b)
+	if err != nil || len(f.UniqueID) == 0 {
+		return 0, false
+	}
+
+	return f.Generation, true
+}
+
+// writeFormatBlobCopies writes the provided data to the format blob and all its replicas, optionally reading
+// each of them back to verify it. The format blob is written first, so it always holds the most recent version.
+func writeFormatBlobCopies(ctx context.Context, st blob.Storage, data blob.Bytes, verify bool) error {
+	for _, id := range append([]blob.ID{FormatBlobID}, FormatBlobReplicaIDs...) {
+		put := st.PutBlob
+		if verify {
+			put = func(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+				return blob.PutBlobAndVerify(ctx, st, id, data, opts)
+			}
+		}
+
+		if err := put(ctx, id, data, blob.PutOptions{}); err != nil {
+			return errors.Wrapf(err, "unable to write %v", id)
+		}
+	}
+
+	return nil
+}
+
+// WriteRecoveredFormatBlob writes the format blob recovered from a pack blob to the format blob and all its
+// replicas. The recovered format blob may be older than remaining copies, so its generation is bumped above
+// generations of all of them, which makes sure that repairs never roll it back to a stale copy.
+func WriteRecoveredFormatBlob(ctx context.Context, st blob.Storage, b []byte) error {
+	f, err := parseFormatBlob(b)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range append([]blob.ID{FormatBlobID}, FormatBlobReplicaIDs...) {
+		existing, err := st.GetBlob(ctx, id, 0, -1)
+		if err != nil {
+			// missing or unreadable copies are overwritten.
+			continue
+		}
+
+		if gen, ok := formatBlobGeneration(existing); ok && gen > f.Generation {
+			f.Generation = gen
+		}
+	}
+
+	// repairs are explicitly requested to make sure that all copies are intact, so they are always verified.
+	return writeFormatBlob(ctx, st, f, true)
+}
+
+// readFormatBlobBytes reads the format blob, falling back to the most recent of its replicas when it's missing
+// or corrupt. Copies are never modified, which is done by RepairFormatBlobCopies.
+func readFormatBlobBytes(ctx context.Context, st blob.Storage) ([]byte, error) {
+	b, err := st.GetBlob(ctx, FormatBlobID, 0, -1)
+
+	switch {
+	case err == nil:
+		if _, ok := formatBlobGeneration(b); ok {
+			return b, nil
+		}
+
+		log(ctx).Warningf("format blob is corrupt, looking for replicas")
+
+	case errors.Is(err, blob.ErrBlobNotFound):
+		log(ctx).Debugf("format blob not found, looking for replicas")
+
+	default:
+		// don't fall back to replicas on transient errors, they may be older than the format blob.
+		return nil, errors.Wrap(err, "unable to read format blob")
+	}
+
+	id, rb, ok := newestFormatBlobReplica(ctx, st)
+	if ok {
+		log(ctx).Warningf("using format blob replica %v, run 'kopia repository repair' to repair the format blob", id)
+
+		return rb, nil
+	}
+
+	if err != nil {
+		// not found, the repository is likely not initialized.
+		return nil, err
+	}
+
+	return nil, errors.Errorf("format blob is corrupt and no valid replica was found")
+}
+
+// newestFormatBlobReplica returns the valid replica of the format blob with the highest generation.
+func newestFormatBlobReplica(ctx context.Context, st blob.Storage) (blob.ID, []byte, bool) {
+	var (
+		bestID  blob.ID
+		best    []byte
+		bestGen int64 = -1
+	)
+
+	for _, id := range FormatBlobReplicaIDs {
+		rb, err := st.GetBlob(ctx, id, 0, -1)
+		if err != nil {
+			continue
+		}
+
+		if gen, ok := formatBlobGeneration(rb); ok && gen > bestGen {
+			bestID, best, bestGen = id, rb, gen
+		}
+	}
+
+	return bestID, best, best != nil
+}
+
+// RepairFormatBlobCopies rewrites the format blob and its replicas that are missing, corrupt or older than
+// the newest valid copy, preferring the format blob over replicas of the same generation. Copies are never
+// overwritten by an older generation. Returns blob.ErrBlobNotFound if there's no valid copy.
+func RepairFormatBlobCopies(ctx context.Context, st blob.Storage, dryRun bool) error {
+	ids := append([]blob.ID{FormatBlobID}, FormatBlobReplicaIDs...)
+
+	var (
+		copies  = map[blob.ID][]byte{}
+		gens    = map[blob.ID]int64{}
+		valid   []byte
+		bestGen int64 = -1
+	)
+
+	for _, id := range ids {
+		b, err := st.GetBlob(ctx, id, 0, -1)
+
+		switch {
+		case errors.Is(err, blob.ErrBlobNotFound):
+			continue
+		case err != nil:
+			// don't repair anything when the state of a copy is unknown.
+			return errors.Wrapf(err, "unable to read %v", id)
+		}
+
+		copies[id] = b
+
+		gen, ok := formatBlobGeneration(b)
+		if !ok {
+			gens[id] = -1
+			continue
+		}
+
+		gens[id] = gen
+
+		if gen > bestGen {
+			valid, bestGen = b, gen
+		}
+	}
+
+	if valid == nil {
+		return errors.Wrap(blob.ErrBlobNotFound, "no valid copy of the format blob found")
+	}
+
+	for _, id := range ids {
+		existing, found := copies[id]
+		if found && (bytes.Equal(existing, valid) || gens[id] > bestGen) {
+			continue
+		}
+
+		if dryRun {
+			log(ctx).Infof("would repair %v", id)
+			continue
+		}
+
+		// repairs are explicitly requested to make sure that all copies are intact, so they are always verified.
+		if err := blob.PutBlobAndVerify(ctx, st, id, gather.FromSlice(valid), blob.PutOptions{}); err != nil {
+			return errors.Wrapf(err, "unable to repair %v", id)
+		}
+
+		log(ctx).Infof("repaired %v", id)
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package repo
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func TestFormatBlobReplicas(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	st := blobtesting.NewMapStorage(data, nil, nil)
+
+	assertNoError(t, Initialize(ctx, st, &NewRepositoryOptions{}, "password"))
+
+	original := append([]byte(nil), data[FormatBlobID]...)
+
+	for _, id := range FormatBlobReplicaIDs {
+		if string(data[id]) != string(original) {
+			t.Fatalf("replica %v was not written", id)
+		}
+	}
+
+	if err := Initialize(ctx, st, &NewRepositoryOptions{}, "password"); !errors.Is(err, ErrAlreadyInitialized) {
+		t.Fatalf("unexpected error when initializing twice: %v", err)
+	}
+
+	cases := map[string]func(){
+		"missing format blob": func() { delete(data, FormatBlobID) },
+		"corrupt format blob": func() { data[FormatBlobID] = []byte("garbage") },
+		"corrupt replica":     func() { data[FormatBlobReplicaIDs[0]] = []byte("{}") },
+		"missing replicas": func() {
+			for _, id := range FormatBlobReplicaIDs {
+				delete(data, id)
+			}
+		},
+		"only last replica": func() {
+			delete(data, FormatBlobID)
+			data[FormatBlobReplicaIDs[0]] = original[0 : len(original)/2]
+		},
+	}
+
+	for name, damage := range cases {
+		damage()
+
+		damaged := blobtesting.DataMap{}
+		for k, v := range data {
+			damaged[k] = v
+		}
+
+		b, err := readFormatBlobBytes(ctx, st)
+		if err != nil {
+			t.Fatalf("%v: unable to read format blob: %v", name, err)
+		}
+
+		if string(b) != string(original) {
+			t.Fatalf("%v: unexpected format blob contents", name)
+		}
+
+		// reading never modifies the copies.
+		if !reflect.DeepEqual(damaged, data) {
+			t.Fatalf("%v: format blob copies modified while reading", name)
+		}
+
+		assertNoError(t, RepairFormatBlobCopies(ctx, st, false))
+
+		for _, id := range append([]blob.ID{FormatBlobID}, FormatBlobReplicaIDs...) {
+			if string(data[id]) != string(original) {
+				t.Errorf("%v: %v was not repaired", name, id)
+			}
+		}
+	}
+
+	delete(data, FormatBlobID)
+
+	for _, id := range FormatBlobReplicaIDs {
+		data[id] = []byte("garbage")
+	}
+
+	if _, err := readFormatBlobBytes(ctx, st); err == nil {
+		t.Fatalf("unexpected success reading corrupt format blobs")
+	}
+
+	for _, id := range FormatBlobReplicaIDs {
+		delete(data, id)
+	}
+
+	if _, err := readFormatBlobBytes(ctx, st); !errors.Is(err, blob.ErrBlobNotFound) {
+		t.Fatalf("unexpected error reading missing format blob: %v", err)
+	}
+
+	if err := RepairFormatBlobCopies(ctx, st, false); !errors.Is(err, blob.ErrBlobNotFound) {
+		t.Fatalf("unexpected error repairing missing format blob: %v", err)
+	}
+}
+
+func TestFormatBlobReplicaGenerations(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	st := blobtesting.NewMapStorage(data, nil, nil)
+
+	assertNoError(t, Initialize(ctx, st, &NewRepositoryOptions{}, "password"))
+
+	gen1 := append([]byte(nil), data[FormatBlobID]...)
+
+	f, err := parseFormatBlob(gen1)
+	assertNoError(t, err)
+	assertNoError(t, writeFormatBlob(ctx, st, f, false))
+
+	gen2 := append([]byte(nil), data[FormatBlobID]...)
+
+	// replica left behind by an interrupted write is older than the format blob and never used to overwrite it.
+	data[FormatBlobReplicaIDs[0]] = gen1
+
+	assertNoError(t, RepairFormatBlobCopies(ctx, st, false))
+
+	for _, id := range append([]blob.ID{FormatBlobID}, FormatBlobReplicaIDs...) {
+		if string(data[id]) != string(gen2) {
+			t.Errorf("%v does not hold the newest format blob", id)
+		}
+	}
+
+	// the newest replica is used when the format blob is missing.
+	delete(data, FormatBlobID)
+	data[FormatBlobReplicaIDs[0]] = gen1
+
+	b, err := readFormatBlobBytes(ctx, st)
+	assertNoError(t, err)
+
+	if string(b) != string(gen2) {
+		t.Fatalf("stale replica used instead of the newest one")
+	}
+}
+
+func TestWriteRecoveredFormatBlob(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	st := blobtesting.NewMapStorage(data, nil, nil)
+
+	assertNoError(t, Initialize(ctx, st, &NewRepositoryOptions{}, "password"))
+
+	// format blob recovered from a pack blob may be older than the remaining copies.
+	recovered := append([]byte(nil), data[FormatBlobID]...)
+
+	f, err := parseFormatBlob(recovered)
+	assertNoError(t, err)
+	assertNoError(t, writeFormatBlob(ctx, st, f, false))
+
+	delete(data, FormatBlobID)
+	delete(data, FormatBlobReplicaIDs[0])
+
+	assertNoError(t, WriteRecoveredFormatBlob(ctx, st, recovered))
+
+	written := append([]byte(nil), data[FormatBlobID]...)
+
+	if gen, _ := formatBlobGeneration(written); gen != f.Generation+1 {
+		t.Fatalf("unexpected generation of recovered format blob: %v, want %v", gen, f.Generation+1)
+	}
+
+	// all copies are rewritten, so that they are not repaired from the stale replica.
+	assertNoError(t, RepairFormatBlobCopies(ctx, st, false))
+
+	for _, id := range append([]blob.ID{FormatBlobID}, FormatBlobReplicaIDs...) {
+		if string(data[id]) != string(written) {
+			t.Errorf("%v does not hold the recovered format blob", id)
+		}
+	}
+}
+
+// formatBlobLosingStorage acknowledges writes of format blob copies without storing them.
+type formatBlobLosingStorage struct {
+	blob.Storage
+}
+
+func (s formatBlobLosingStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	if IsFormatBlobID(id) {
+		return nil
+	}
+
+	return s.Storage.PutBlob(ctx, id, data, opts)
+}
+
+func TestFormatBlobWriteVerification(t *testing.T) {
+	ctx := testlogging.Context(t)
+	st := formatBlobLosingStorage{blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, nil)}
+
+	// format blob is read back only when verification of critical writes is requested.
+	assertNoError(t, Initialize(ctx, st, &NewRepositoryOptions{}, "password"))
+
+	if err := Initialize(ctx, st, &NewRepositoryOptions{VerifyCriticalWrites: true}, "password"); !errors.Is(err, blob.ErrWriteVerificationFailed) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -4,10 +4,13 @@
 	"context"
 	"crypto/rand"
 	"io"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/namespace"
+	"github.com/kopia/kopia/repo/compression"
 	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/repo/encryption"
 	"github.com/kopia/kopia/repo/hashing"
@@ -34,6 +37,10 @@ type NewRepositoryOptions struct {
 	BlockFormat  content.FormattingOptions `json:"blockFormat"`
 	DisableHMAC  bool                      `json:"disableHMAC"`
 	ObjectFormat object.Format             `json:"objectFormat"` // object format
+
+	ManifestCompression compression.Name `json:"manifestCompression,omitempty"` // compressor used for manifests
+
+	VerifyCriticalWrites bool `json:"verifyCriticalWrites,omitempty"` // read back the format blob after upload
 }
 
 // ErrAlreadyInitialized indicates that repository has already been initialized.
@@ -45,34 +52,73 @@ func Initialize(ctx context.Context, st blob.Storage, opt *NewRepositoryOptions,
 		opt = &NewRepositoryOptions{}
 	}
 
-	// get the blob - expect ErrNotFound
-	_, err := st.GetBlob(ctx, FormatBlobID, 0, -1)
-	if err == nil {
-		return ErrAlreadyInitialized
+	// get the blob and its replicas - expect ErrNotFound
+	for _, id := range append([]blob.ID{FormatBlobID}, FormatBlobReplicaIDs...) {
+		_, err := st.GetBlob(ctx, id, 0, -1)
+		if err == nil {
+			return ErrAlreadyInitialized
+		}
+
+		if !errors.Is(err, blob.ErrBlobNotFound) {
+			return err
+		}
+	}
+
+	if opt.ManifestCompression != "" && compression.ByName[opt.ManifestCompression] == nil {
+		return errors.Errorf("unsupported manifest compressor: %v", opt.ManifestCompression)
+	}
+
+	if err := content.ValidateLocalIndexECCBytes(opt.BlockFormat.LocalIndexECCBytes); err != nil {
+		return err
 	}
 
-	if !errors.Is(err, blob.ErrBlobNotFound) {
+	if err := validateRetentionOptions(opt.BlockFormat.RetentionMode, opt.BlockFormat.RetentionPeriod); err != nil {
 		return err
 	}
 
 	format := formatBlobFromOptions(opt)
+	format.Namespace = namespace.Name(st)
 
 	masterKey, err := format.deriveMasterKeyFromPassword(password)
 	if err != nil {
 		return errors.Wrap(err, "unable to derive master key")
 	}
 
-	if err := encryptFormatBytes(format, repositoryObjectFormatFromOptions(opt), masterKey, format.UniqueID); err != nil {
+	repoConfig := repositoryObjectFormatFromOptions(opt)
+
+	if opt.ManifestCompression != "" || len(repoConfig.Format.PluginCompressors) > 0 {
+		// versions of kopia reading only gzip manifests or not knowing about plugin compressors
+		// must refuse to open the repository.
+		requireFormatVersion(ctx, repoConfig, content.FormatVersion2)
+	}
+
+	if err := encryptFormatBytes(format, repoConfig, masterKey, format.UniqueID); err != nil {
 		return errors.Wrap(err, "unable to encrypt format bytes")
 	}
 
-	if err := writeFormatBlob(ctx, st, format); err != nil {
+	if err := writeFormatBlob(ctx, st, format, opt.VerifyCriticalWrites); err != nil {
 		return errors.Wrap(err, "unable to write format blob")
 	}
 
 	return nil
 }
 
+func validateRetentionOptions(mode string, period time.Duration) error {
+	if err := blob.ValidateRetentionMode(mode); err != nil {
+		return err
+	}
+
+	if (mode == "") != (period == 0) {
+		return errors.New("retention mode and period must be specified together")
+	}
+
+	if period < 0 {
+		return errors.Errorf("invalid retention period: %v", period)
+	}
+
+	return nil
+}
+
 func formatBlobFromOptions(opt *NewRepositoryOptions) *formatBlob {
 	return &formatBlob{
 		Tool:                   "https://github.com/kopia/kopia",
@@ -94,10 +140,17 @@ func repositoryObjectFormatFromOptions(opt *NewRepositoryOptions) *repositoryObj
 			HMACSecret:  applyDefaultRandomBytes(opt.BlockFormat.HMACSecret, hmacSecretLength),
 			MasterKey:   applyDefaultRandomBytes(opt.BlockFormat.MasterKey, masterKeyLength),
 			MaxPackSize: applyDefaultInt(opt.BlockFormat.MaxPackSize, 20<<20), //nolint:gomnd
+
+			LocalIndexECCBytes: opt.BlockFormat.LocalIndexECCBytes,
+
+			RetentionMode:   opt.BlockFormat.RetentionMode,
+			RetentionPeriod: opt.BlockFormat.RetentionPeriod,
 		},
 		Format: object.Format{
-			Splitter: applyDefaultString(opt.ObjectFormat.Splitter, splitter.DefaultAlgorithm),
+			Splitter:          applyDefaultString(opt.ObjectFormat.Splitter, splitter.DefaultAlgorithm),
+			PluginCompressors: pluginCompressorsFromOptions(opt),
 		},
+		ManifestCompression: opt.ManifestCompression,
 	}
 
 	if opt.DisableHMAC {
@@ -7,6 +7,8 @@
 	"os"
 
 	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/staging"
+	"github.com/kopia/kopia/repo/compression"
 	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/repo/object"
 )
@@ -70,6 +72,9 @@ type LocalConfig struct {
 
 	Caching *content.CachingOptions `json:"caching,omitempty"`
 
+	// Staging is only provided when blob writes are staged locally and uploaded in the background.
+	Staging *staging.Options `json:"staging,omitempty"`
+
 	ClientOptions
 }
 
@@ -77,6 +82,9 @@ type LocalConfig struct {
 type repositoryObjectFormat struct {
 	content.FormattingOptions
 	object.Format
+
+	// compressor used for manifests, empty for legacy gzip format.
+	ManifestCompression compression.Name `json:"manifestCompression,omitempty"`
 }
 
 // Load reads local configuration from the specified reader.
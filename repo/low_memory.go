@@ -0,0 +1,27 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/object"
+)
+
+// applyLowMemoryOptions adjusts caching and object manager options to minimize memory usage.
+//
+// Indexes are loaded on demand and are not merged, because merging holds all index entries in memory,
+// while individual index files in the cache directory are memory-mapped and their pages can be evicted
+// by the kernel. Objects are neither cached in memory nor read ahead.
+func applyLowMemoryOptions(ctx context.Context, caching *content.CachingOptions, omOpts *object.ManagerOptions) {
+	if caching.CacheDirectory == "" {
+		log(ctx).Warningf("cache directory is not set, indexes will be kept in memory")
+	}
+
+	caching.LazyIndexLoading = true
+	caching.MergeCommittedIndexes = false
+	caching.ReadAheadBytes = 0
+
+	omOpts.ObjectCacheSize = 0
+	omOpts.MaxCachedObjectSize = 0
+	omOpts.ReadAheadChunks = -1
+}
@@ -0,0 +1,32 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/object"
+)
+
+func TestApplyLowMemoryOptions(t *testing.T) {
+	caching := &content.CachingOptions{
+		CacheDirectory:        t.TempDir(),
+		MergeCommittedIndexes: true,
+		ReadAheadBytes:        1 << 20,
+	}
+
+	omOpts := object.ManagerOptions{
+		ObjectCacheSize:     32 << 20,
+		MaxCachedObjectSize: 1 << 20,
+	}
+
+	applyLowMemoryOptions(testlogging.Context(t), caching, &omOpts)
+
+	if !caching.LazyIndexLoading || caching.MergeCommittedIndexes || caching.ReadAheadBytes != 0 {
+		t.Errorf("unexpected caching options: %+v", caching)
+	}
+
+	if omOpts.ObjectCacheSize != 0 || omOpts.MaxCachedObjectSize != 0 || omOpts.ReadAheadChunks >= 0 {
+		t.Errorf("unexpected object manager options: %+v", omOpts)
+	}
+}
@@ -7,6 +7,7 @@
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/kopia/kopia/internal/gather"
 	"github.com/kopia/kopia/internal/stats"
 	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo/blob"
@@ -17,12 +18,21 @@
 // will periodically flush its indexes more frequently than 1/hour.
 const defaultBlobGCMinAge = 2 * time.Hour
 
+// QuarantineBlobIDPrefix is the prefix of blobs moved to quarantine by garbage collection,
+// the rest of the blob ID is the ID of the original blob.
+const QuarantineBlobIDPrefix blob.ID = "z"
+
 // DeleteUnreferencedBlobsOptions provides option for blob garbage collection algorithm.
 type DeleteUnreferencedBlobsOptions struct {
 	Parallel int
 	Prefix   blob.ID
 	MinAge   time.Duration
 	DryRun   bool
+
+	// QuarantinePeriod, when non-zero, causes unreferenced blobs to be moved to quarantine instead of
+	// being deleted. Quarantined blobs are deleted once they've been in quarantine for longer than
+	// this period and can be restored using UnquarantineBlobs() until then.
+	QuarantinePeriod time.Duration
 }
 
 // DeleteUnreferencedBlobs deletes old blobs that are no longer referenced by index entries.
@@ -35,9 +45,16 @@ func DeleteUnreferencedBlobs(ctx context.Context, rep MaintainableRepository, op
 		opt.MinAge = defaultBlobGCMinAge
 	}
 
+	// delete blobs whose quarantine has expired before quarantining new ones.
+	if opt.QuarantinePeriod > 0 {
+		if err := deleteExpiredQuarantinedBlobs(ctx, rep, opt); err != nil {
+			return 0, err
+		}
+	}
+
 	const deleteQueueSize = 100
 
-	var unreferenced, deleted stats.CountSum
+	var unreferenced, deleted, retained stats.CountSum
 
 	var eg errgroup.Group
 
@@ -48,12 +65,12 @@ func DeleteUnreferencedBlobs(ctx context.Context, rep MaintainableRepository, op
 		for i := 0; i < opt.Parallel; i++ {
 			eg.Go(func() error {
 				for bm := range unused {
-					if err := rep.BlobStorage().DeleteBlob(ctx, bm.BlobID); err != nil {
-						return errors.Wrapf(err, "unable to delete blob %q", bm.BlobID)
+					if err := deleteOrQuarantineBlob(ctx, rep.BlobStorage(), bm.BlobID, opt.QuarantinePeriod > 0); err != nil {
+						return err
 					}
 					cnt, del := deleted.Add(bm.Length)
 					if cnt%100 == 0 {
-						log(ctx).Infof("  deleted %v unreferenced blobs (%v)", cnt, units.BytesStringBase10(del))
+						log(ctx).Infof("  %v %v unreferenced blobs (%v)", deletedVerb(opt), cnt, units.BytesStringBase10(del))
 					}
 				}
 
@@ -76,6 +93,13 @@ func DeleteUnreferencedBlobs(ctx context.Context, rep MaintainableRepository, op
 			return nil
 		}
 
+		if until, locked := blobLockedUntil(rep, bm); locked {
+			log(ctx).Debugf("  preserving %v because it's locked until %v", bm.BlobID, until)
+			retained.Add(bm.Length)
+
+			return nil
+		}
+
 		unreferenced.Add(bm.Length)
 
 		if !opt.DryRun {
@@ -92,6 +116,10 @@ func DeleteUnreferencedBlobs(ctx context.Context, rep MaintainableRepository, op
 	unreferencedCount, unreferencedSize := unreferenced.Approximate()
 	log(ctx).Debugf("Found %v blobs to delete (%v)", unreferencedCount, units.BytesStringBase10(unreferencedSize))
 
+	if retainedCount, retainedSize := retained.Approximate(); retainedCount > 0 {
+		log(ctx).Infof("Preserved %v unreferenced blobs (%v) which are still locked by the retention policy", retainedCount, units.BytesStringBase10(retainedSize))
+	}
+
 	// wait for all delete workers to finish.
 	if err := eg.Wait(); err != nil {
 		return 0, err
@@ -103,7 +131,113 @@ func DeleteUnreferencedBlobs(ctx context.Context, rep MaintainableRepository, op
 
 	del, cnt := deleted.Approximate()
 
-	log(ctx).Infof("Deleted total %v unreferenced blobs (%v)", del, units.BytesStringBase10(cnt))
+	if opt.QuarantinePeriod > 0 {
+		log(ctx).Infof("Quarantined total %v unreferenced blobs (%v)", del, units.BytesStringBase10(cnt))
+	} else {
+		log(ctx).Infof("Deleted total %v unreferenced blobs (%v)", del, units.BytesStringBase10(cnt))
+	}
 
 	return int(del), nil
 }
+
+func deletedVerb(opt DeleteUnreferencedBlobsOptions) string {
+	if opt.QuarantinePeriod > 0 {
+		return "quarantined"
+	}
+
+	return "deleted"
+}
+
+func deleteOrQuarantineBlob(ctx context.Context, st blob.Storage, id blob.ID, quarantine bool) error {
+	if quarantine {
+		return moveBlob(ctx, st, id, QuarantineBlobIDPrefix+id)
+	}
+
+	return errors.Wrapf(st.DeleteBlob(ctx, id), "unable to delete blob %q", id)
+}
+
+// moveBlob copies the blob to a new ID and deletes the original.
+func moveBlob(ctx context.Context, st blob.Storage, src, dst blob.ID) error {
+	data, err := st.GetBlob(ctx, src, 0, -1)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read blob %q", src)
+	}
+
+	if err := st.PutBlob(ctx, dst, gather.FromSlice(data), blob.PutOptions{}); err != nil {
+		return errors.Wrapf(err, "unable to write blob %q", dst)
+	}
+
+	return errors.Wrapf(st.DeleteBlob(ctx, src), "unable to delete blob %q", src)
+}
+
+// deleteExpiredQuarantinedBlobs deletes blobs which have been in quarantine for longer than the quarantine period.
+func deleteExpiredQuarantinedBlobs(ctx context.Context, rep MaintainableRepository, opt DeleteUnreferencedBlobsOptions) error {
+	var expired []blob.Metadata
+
+	if err := rep.BlobStorage().ListBlobs(ctx, QuarantineBlobIDPrefix+opt.Prefix, func(bm blob.Metadata) error {
+		if rep.Time().Sub(bm.Timestamp) >= opt.QuarantinePeriod {
+			expired = append(expired, bm)
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "error listing quarantined blobs")
+	}
+
+	if len(expired) == 0 {
+		return nil
+	}
+
+	if opt.DryRun {
+		log(ctx).Infof("Found %v quarantined blobs to delete", len(expired))
+		return nil
+	}
+
+	for _, bm := range expired {
+		if err := rep.BlobStorage().DeleteBlob(ctx, bm.BlobID); err != nil {
+			return errors.Wrapf(err, "unable to delete quarantined blob %q", bm.BlobID)
+		}
+	}
+
+	log(ctx).Infof("Deleted %v blobs whose quarantine period has expired", len(expired))
+
+	return nil
+}
+
+// UnquarantineBlobs restores quarantined blobs with provided original IDs or all quarantined blobs when no IDs are provided.
+// Returns the number of restored blobs.
+func UnquarantineBlobs(ctx context.Context, rep MaintainableRepository, ids []blob.ID) (int, error) {
+	st := rep.BlobStorage()
+
+	if len(ids) == 0 {
+		if err := st.ListBlobs(ctx, QuarantineBlobIDPrefix, func(bm blob.Metadata) error {
+			ids = append(ids, bm.BlobID[len(QuarantineBlobIDPrefix):])
+			return nil
+		}); err != nil {
+			return 0, errors.Wrap(err, "error listing quarantined blobs")
+		}
+	}
+
+	for i, id := range ids {
+		if err := moveBlob(ctx, st, QuarantineBlobIDPrefix+id, id); err != nil {
+			return i, err
+		}
+
+		log(ctx).Debugf("restored %v from quarantine", id)
+	}
+
+	return len(ids), nil
+}
+
+// blobLockedUntil returns the time until which the blob is locked against deletion by the retention
+// configured for the repository and whether it's still locked.
+func blobLockedUntil(rep MaintainableRepository, bm blob.Metadata) (time.Time, bool) {
+	period := rep.ContentManager().Format.RetentionOptions().RetentionPeriod
+	if period == 0 {
+		return time.Time{}, false
+	}
+
+	until := bm.Timestamp.Add(period)
+
+	return until, rep.Time().Before(until)
+}
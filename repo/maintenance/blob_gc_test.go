@@ -0,0 +1,137 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/faketime"
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func TestDeleteUnreferencedBlobsPreservesLockedBlobs(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	ft := faketime.NewTimeAdvance(time.Now(), 0)
+
+	var env repotesting.Environment
+	defer env.Setup(t, repotesting.Options{
+		OpenOptions: func(o *repo.Options) {
+			o.TimeNowFunc = ft.NowFunc()
+		},
+	}).Close(ctx, t)
+
+	// unreferenced pack blob, such as one left behind by an interrupted upload.
+	const packBlobID = "p0123456789abcdef0123456789abcdef"
+
+	if err := env.Repository.Blobs.PutBlob(ctx, packBlobID, gather.FromSlice([]byte("unreferenced")), blob.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cm := env.Repository.Content
+
+	// pretend the pack was written with retention, the filesystem storage doesn't support locking.
+	cm.Format.RetentionMode = blob.RetentionModeGovernance
+	cm.Format.RetentionPeriod = 24 * time.Hour
+
+	ft.Advance(3 * time.Hour)
+
+	n, err := DeleteUnreferencedBlobs(ctx, env.Repository, DeleteUnreferencedBlobsOptions{})
+	if err != nil {
+		t.Fatalf("unable to delete unreferenced blobs: %v", err)
+	}
+
+	if n != 0 {
+		t.Fatalf("deleted %v blobs, which are still locked", n)
+	}
+
+	if _, err := env.Repository.Blobs.GetMetadata(ctx, packBlobID); err != nil {
+		t.Fatalf("locked pack blob was deleted: %v", err)
+	}
+
+	ft.Advance(24 * time.Hour)
+
+	n, err = DeleteUnreferencedBlobs(ctx, env.Repository, DeleteUnreferencedBlobsOptions{})
+	if err != nil {
+		t.Fatalf("unable to delete unreferenced blobs: %v", err)
+	}
+
+	if n != 1 {
+		t.Fatalf("deleted %v blobs, wanted 1", n)
+	}
+}
+
+func TestDeleteUnreferencedBlobsWithQuarantine(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	ft := faketime.NewTimeAdvance(time.Now(), 0)
+
+	var env repotesting.Environment
+	defer env.Setup(t, repotesting.Options{
+		OpenOptions: func(o *repo.Options) {
+			o.TimeNowFunc = ft.NowFunc()
+		},
+	}).Close(ctx, t)
+
+	const (
+		packBlobID1 = "p0123456789abcdef0123456789abcdef"
+		packBlobID2 = "pfedcba9876543210fedcba9876543210"
+	)
+
+	for _, id := range []blob.ID{packBlobID1, packBlobID2} {
+		if err := env.Repository.Blobs.PutBlob(ctx, id, gather.FromSlice([]byte("unreferenced")), blob.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ft.Advance(3 * time.Hour)
+
+	opt := DeleteUnreferencedBlobsOptions{QuarantinePeriod: 24 * time.Hour}
+
+	n, err := DeleteUnreferencedBlobs(ctx, env.Repository, opt)
+	if err != nil {
+		t.Fatalf("unable to delete unreferenced blobs: %v", err)
+	}
+
+	if n != 2 {
+		t.Fatalf("quarantined %v blobs, wanted 2", n)
+	}
+
+	for _, id := range []blob.ID{packBlobID1, packBlobID2} {
+		if _, err = env.Repository.Blobs.GetMetadata(ctx, id); !errors.Is(err, blob.ErrBlobNotFound) {
+			t.Fatalf("blob %v was not removed: %v", id, err)
+		}
+
+		if _, err = env.Repository.Blobs.GetMetadata(ctx, QuarantineBlobIDPrefix+id); err != nil {
+			t.Fatalf("blob %v was not quarantined: %v", id, err)
+		}
+	}
+
+	if n, err = UnquarantineBlobs(ctx, env.Repository, []blob.ID{packBlobID1}); err != nil || n != 1 {
+		t.Fatalf("unable to unquarantine blob: %v %v", n, err)
+	}
+
+	if _, err = env.Repository.Blobs.GetMetadata(ctx, packBlobID1); err != nil {
+		t.Fatalf("blob was not restored: %v", err)
+	}
+
+	// quarantined blob is deleted after the quarantine period, the restored one is quarantined again.
+	ft.Advance(25 * time.Hour)
+
+	if _, err = DeleteUnreferencedBlobs(ctx, env.Repository, opt); err != nil {
+		t.Fatalf("unable to delete unreferenced blobs: %v", err)
+	}
+
+	if _, err = env.Repository.Blobs.GetMetadata(ctx, QuarantineBlobIDPrefix+packBlobID2); !errors.Is(err, blob.ErrBlobNotFound) {
+		t.Fatalf("expired quarantined blob was not deleted: %v", err)
+	}
+
+	if _, err = env.Repository.Blobs.GetMetadata(ctx, QuarantineBlobIDPrefix+packBlobID1); err != nil {
+		t.Fatalf("blob was not quarantined again: %v", err)
+	}
+}
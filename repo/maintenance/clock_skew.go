@@ -0,0 +1,89 @@
+package maintenance
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clockskew"
+)
+
+// ErrClockSkew is returned when maintenance is refused because the clock skew between the client
+// and the storage exceeds the configured maximum.
+var ErrClockSkew = errors.New("clock skew between client and storage is too large")
+
+// ClockSkewParams contains parameters for clock skew detection performed before maintenance.
+type ClockSkewParams struct {
+	// MaxSkew is the maximum tolerated clock skew, defaults to clockskew.DefaultMaxSkew.
+	MaxSkew time.Duration `json:"maxSkew,omitempty"`
+
+	// RefuseMaintenance causes maintenance to fail instead of warning when the skew exceeds MaxSkew.
+	RefuseMaintenance bool `json:"refuseMaintenance,omitempty"`
+}
+
+// MaxSkewOrDefault returns the maximum tolerated clock skew.
+func (p ClockSkewParams) MaxSkewOrDefault() time.Duration {
+	if p.MaxSkew > 0 {
+		return p.MaxSkew
+	}
+
+	return clockskew.DefaultMaxSkew
+}
+
+// ClockSkewInfo describes the clock skew between a client and the storage measured before maintenance.
+// Positive skew means the clock of the client is behind the storage.
+type ClockSkewInfo struct {
+	Time time.Time     `json:"time"`
+	Skew time.Duration `json:"skew"`
+}
+
+// checkClockSkew measures the clock skew between the local clock and the storage, records it in the
+// schedule and returns ErrClockSkew when it exceeds the maximum and maintenance should be refused.
+//
+// The measurement is skipped for repositories with blob retention, whose storage may lock the temporary
+// blob written by the probe against deletion.
+func checkClockSkew(ctx context.Context, rep MaintainableRepository, p ClockSkewParams) error {
+	if rep.ContentManager().Format.RetentionMode != "" {
+		log(ctx).Debugf("skipping clock skew measurement of storage with blob retention")
+		return nil
+	}
+
+	skew, err := clockskew.MeasureStorage(ctx, rep.BlobStorage(), rep.Time)
+	if err != nil {
+		log(ctx).Warningf("unable to measure clock skew: %v", err)
+		return nil
+	}
+
+	log(ctx).Debugf("clock skew between client and storage: %v", skew)
+
+	s, err := GetSchedule(ctx, rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get schedule")
+	}
+
+	if s.ClockSkew == nil {
+		s.ClockSkew = map[string]ClockSkewInfo{}
+	}
+
+	s.ClockSkew[rep.Username()+"@"+rep.Hostname()] = ClockSkewInfo{Time: rep.Time(), Skew: skew}
+
+	if err := SetSchedule(ctx, rep, s); err != nil {
+		return errors.Wrap(err, "unable to set schedule")
+	}
+
+	maxSkew := p.MaxSkewOrDefault()
+
+	if !clockskew.Exceeds(skew, maxSkew) {
+		return nil
+	}
+
+	log(ctx).Warningf("WARNING: The local clock differs from the storage clock by %v, which exceeds the maximum of %v.", skew, maxSkew)
+	log(ctx).Warningf("Maintenance with skewed clocks may prematurely delete data still in use, please synchronize the clock of this machine.")
+
+	if p.RefuseMaintenance {
+		return errors.Wrapf(ErrClockSkew, "skew %v exceeds %v", skew, maxSkew)
+	}
+
+	return nil
+}
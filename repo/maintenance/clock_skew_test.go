@@ -0,0 +1,86 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clockskew"
+	"github.com/kopia/kopia/internal/faketime"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func TestCheckClockSkew(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	// the local clock is two hours behind the filesystem storage.
+	ft := faketime.NewTimeAdvance(time.Now().Add(-2*time.Hour), 0)
+
+	var env repotesting.Environment
+	defer env.Setup(t, repotesting.Options{
+		OpenOptions: func(o *repo.Options) {
+			o.TimeNowFunc = ft.NowFunc()
+		},
+	}).Close(ctx, t)
+
+	if err := checkClockSkew(ctx, env.Repository, ClockSkewParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := checkClockSkew(ctx, env.Repository, ClockSkewParams{MaxSkew: 3 * time.Hour, RefuseMaintenance: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := checkClockSkew(ctx, env.Repository, ClockSkewParams{RefuseMaintenance: true}); !errors.Is(err, ErrClockSkew) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s, err := GetSchedule(ctx, env.Repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs, ok := s.ClockSkew[env.Repository.Username()+"@"+env.Repository.Hostname()]
+	if !ok {
+		t.Fatalf("clock skew was not recorded: %v", s.ClockSkew)
+	}
+
+	if cs.Skew < time.Hour {
+		t.Errorf("unexpected recorded skew: %v", cs.Skew)
+	}
+}
+
+func TestCheckClockSkewSkippedWithRetention(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+	defer env.Setup(t).Close(ctx, t)
+
+	// pretend the repository was created with retention, the filesystem storage doesn't support locking.
+	env.Repository.Content.Format.RetentionMode = blob.RetentionModeGovernance
+	env.Repository.Content.Format.RetentionPeriod = 24 * time.Hour
+
+	if err := checkClockSkew(ctx, env.Repository, ClockSkewParams{RefuseMaintenance: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := env.Repository.Blobs.ListBlobs(ctx, clockskew.BlobIDPrefix, func(bm blob.Metadata) error {
+		t.Errorf("clock skew blob was written: %v", bm.BlobID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := GetSchedule(ctx, env.Repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.ClockSkew) != 0 {
+		t.Errorf("unexpected clock skew recorded: %v", s.ClockSkew)
+	}
+}
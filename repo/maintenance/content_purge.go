@@ -0,0 +1,225 @@
+package maintenance
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/content"
+)
+
+// PurgeRecord is an audit record of contents that were forcibly removed from the repository.
+type PurgeRecord struct {
+	ContentIDs  []content.ID `json:"contentIDs"`
+	PackBlobIDs []blob.ID    `json:"packBlobIDs,omitempty"`
+
+	// References describes snapshot entries that were rewritten to remove references to the contents.
+	References []string `json:"references,omitempty"`
+
+	RequestedBy   string    `json:"requestedBy"`
+	RequestTime   time.Time `json:"requestTime"`
+	Reason        string    `json:"reason,omitempty"`
+	CompletedTime time.Time `json:"completedTime,omitempty"`
+
+	// NotPurged contains contents that were live again when the purge was completed and were kept.
+	NotPurged []content.ID `json:"notPurged,omitempty"`
+}
+
+// Pending returns true if the contents have not been physically removed yet.
+func (r *PurgeRecord) Pending() bool {
+	return r.CompletedTime.IsZero()
+}
+
+// pendingPurgeContentIDs returns the set of contents that are scheduled to be purged.
+func (s *Schedule) pendingPurgeContentIDs() map[content.ID]bool {
+	result := map[content.ID]bool{}
+
+	for _, r := range s.Purges {
+		if !r.Pending() {
+			continue
+		}
+
+		for _, cid := range r.ContentIDs {
+			result[cid] = true
+		}
+	}
+
+	return result
+}
+
+// SchedulePurge marks the contents in the provided record as deleted and schedules the packs
+// that contain them to be rewritten and deleted during next full maintenance.
+// Callers are responsible for removing all references to the contents before calling it.
+func SchedulePurge(ctx context.Context, rep MaintainableRepository, rec *PurgeRecord) error {
+	packs := map[blob.ID]bool{}
+
+	for _, cid := range rec.ContentIDs {
+		ci, err := rep.ContentManager().ContentInfo(ctx, cid)
+		if err != nil {
+			return errors.Wrapf(err, "unable to get info for content %v", cid)
+		}
+
+		if !packs[ci.PackBlobID] {
+			packs[ci.PackBlobID] = true
+
+			rec.PackBlobIDs = append(rec.PackBlobIDs, ci.PackBlobID)
+		}
+
+		if ci.Deleted {
+			continue
+		}
+
+		if err := rep.ContentManager().DeleteContent(ctx, cid); err != nil {
+			return errors.Wrapf(err, "unable to delete content %v", cid)
+		}
+	}
+
+	if err := rep.ContentManager().Flush(ctx); err != nil {
+		return errors.Wrap(err, "unable to flush content manager")
+	}
+
+	s, err := GetSchedule(ctx, rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get schedule")
+	}
+
+	rec.RequestedBy = rep.Username() + "@" + rep.Hostname()
+	rec.RequestTime = rep.Time()
+
+	s.Purges = append(s.Purges, rec)
+
+	return SetSchedule(ctx, rep, s)
+}
+
+// PurgeContents completes pending purges by rewriting all other contents out of the affected packs
+// and dropping the purged contents from the index, so that the packs become unreferenced and
+// are removed by subsequent blob garbage collection.
+func PurgeContents(ctx context.Context, rep MaintainableRepository) error {
+	s, err := GetSchedule(ctx, rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get schedule")
+	}
+
+	purged := s.pendingPurgeContentIDs()
+	if len(purged) == 0 {
+		return nil
+	}
+
+	log(ctx).Infof("Purging %v contents...", len(purged))
+
+	// contents that became live again (e.g. were uploaded again) must not be dropped.
+	live := map[content.ID]bool{}
+
+	for cid := range purged {
+		ci, err := rep.ContentManager().ContentInfo(ctx, cid)
+		if err != nil {
+			if errors.Is(err, content.ErrContentNotFound) {
+				continue
+			}
+
+			return errors.Wrapf(err, "unable to get info for content %v", cid)
+		}
+
+		if !ci.Deleted {
+			log(ctx).Infof("Content %v is in use again and will not be purged.", cid)
+
+			live[cid] = true
+		}
+	}
+
+	if err := rewritePurgedPacks(ctx, rep, s, purged); err != nil {
+		return err
+	}
+
+	var drop []content.ID
+
+	for cid := range purged {
+		if !live[cid] {
+			drop = append(drop, cid)
+		}
+	}
+
+	if err := rep.ContentManager().CompactIndexes(ctx, content.CompactOptions{
+		AllIndexes:   true,
+		DropContents: drop,
+	}); err != nil {
+		return errors.Wrap(err, "unable to drop purged contents from index")
+	}
+
+	// reload indexes so that subsequent blob garbage collection sees the packs as unreferenced.
+	if _, err := rep.ContentManager().Refresh(ctx); err != nil {
+		return errors.Wrap(err, "unable to refresh indexes")
+	}
+
+	// re-read the schedule, since it may have been updated since.
+	s, err = GetSchedule(ctx, rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get schedule")
+	}
+
+	for _, r := range s.Purges {
+		if !r.Pending() {
+			continue
+		}
+
+		for _, cid := range r.ContentIDs {
+			if live[cid] {
+				r.NotPurged = append(r.NotPurged, cid)
+			}
+		}
+
+		r.CompletedTime = rep.Time()
+	}
+
+	return SetSchedule(ctx, rep, s)
+}
+
+// rewritePurgedPacks rewrites all contents except the purged ones out of packs that contained purged contents.
+func rewritePurgedPacks(ctx context.Context, rep MaintainableRepository, s *Schedule, purged map[content.ID]bool) error {
+	packs := map[blob.ID]bool{}
+
+	for _, r := range s.Purges {
+		if !r.Pending() {
+			continue
+		}
+
+		for _, p := range r.PackBlobIDs {
+			packs[p] = true
+		}
+	}
+
+	var toRewrite []content.ID
+
+	if err := rep.ContentManager().IteratePacks(
+		ctx,
+		content.IteratePackOptions{
+			IncludePacksWithOnlyDeletedContent: true,
+			IncludeContentInfos:                true,
+		},
+		func(pi content.PackInfo) error {
+			if !packs[pi.PackID] {
+				return nil
+			}
+
+			for _, ci := range pi.ContentInfos {
+				if !purged[ci.ID] {
+					toRewrite = append(toRewrite, ci.ID)
+				}
+			}
+
+			return nil
+		},
+	); err != nil {
+		return errors.Wrap(err, "error iterating packs")
+	}
+
+	for _, cid := range toRewrite {
+		if err := rep.ContentManager().RewriteContent(ctx, cid); err != nil {
+			return errors.Wrapf(err, "unable to rewrite content %v", cid)
+		}
+	}
+
+	return errors.Wrap(rep.ContentManager().Flush(ctx), "unable to flush content manager")
+}
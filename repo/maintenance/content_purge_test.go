@@ -0,0 +1,82 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/faketime"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/content"
+)
+
+func TestPurgeContents(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	ft := faketime.NewTimeAdvance(time.Date(2020, 9, 10, 0, 0, 0, 0, time.UTC), time.Second)
+
+	var env repotesting.Environment
+	defer env.Setup(t, repotesting.Options{
+		OpenOptions: func(o *repo.Options) {
+			o.TimeNowFunc = ft.NowFunc()
+		},
+	}).Close(ctx, t)
+
+	cm := env.Repository.Content
+
+	keep, err := cm.WriteContent(ctx, []byte("keep me"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := cm.WriteContent(ctx, []byte("secret"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cm.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	keepInfo, err := cm.ContentInfo(ctx, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = SchedulePurge(ctx, env.Repository, &PurgeRecord{ContentIDs: []content.ID{secret}, Reason: "test"}); err != nil {
+		t.Fatalf("unable to schedule purge: %v", err)
+	}
+
+	if ci, err := cm.ContentInfo(ctx, secret); err != nil || !ci.Deleted {
+		t.Fatalf("purged content was not deleted: %v %v", ci, err)
+	}
+
+	if err = PurgeContents(ctx, env.Repository); err != nil {
+		t.Fatalf("unable to purge contents: %v", err)
+	}
+
+	if _, err = cm.ContentInfo(ctx, secret); !errors.Is(err, content.ErrContentNotFound) {
+		t.Errorf("purged content still in the index: %v", err)
+	}
+
+	ci, err := cm.ContentInfo(ctx, keep)
+	if err != nil {
+		t.Fatalf("unable to get info for content that was not purged: %v", err)
+	}
+
+	if ci.PackBlobID == keepInfo.PackBlobID {
+		t.Errorf("content was not rewritten out of purged pack %v", ci.PackBlobID)
+	}
+
+	s, err := GetSchedule(ctx, env.Repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.Purges) != 1 || s.Purges[0].Pending() || s.Purges[0].Reason != "test" || len(s.Purges[0].PackBlobIDs) != 1 {
+		t.Errorf("unexpected purge records: %v", toJSON(s.Purges))
+	}
+}
@@ -54,6 +54,14 @@ func RewriteContents(ctx context.Context, rep MaintainableRepository, opt *Rewri
 		log(ctx).Infof("Rewriting contents...")
 	}
 
+	s, err := GetSchedule(ctx, rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to get schedule")
+	}
+
+	// contents pending purge must never be copied to new packs.
+	purged := s.pendingPurgeContentIDs()
+
 	cnt := getContentToRewrite(ctx, rep, opt)
 
 	var (
@@ -83,6 +91,11 @@ func RewriteContents(ctx context.Context, rep MaintainableRepository, opt *Rewri
 					return
 				}
 
+				if purged[c.ID] {
+					log(ctx).Debugf("Not rewriting content %v, because it's pending purge.", c.ID)
+					continue
+				}
+
 				var optDeleted string
 				if c.Deleted {
 					optDeleted = " (deleted)"
@@ -16,3 +16,18 @@ func IndexCompaction(ctx context.Context, rep MaintainableRepository) error {
 		MaxSmallBlobs: maxSmallBlobsForIndexCompaction,
 	})
 }
+
+// SmallIndexCompaction merges small index blobs written in the same safety epoch, which is cheap enough
+// to run as part of quick maintenance.
+func SmallIndexCompaction(ctx context.Context, rep MaintainableRepository) error {
+	log(ctx).Infof("Merging small indexes...")
+
+	n, err := rep.ContentManager().CompactSmallIndexes(ctx, content.SmallIndexCompactionOptions{})
+	if err != nil {
+		return err
+	}
+
+	log(ctx).Debugf("merged %v small index blobs", n)
+
+	return nil
+}
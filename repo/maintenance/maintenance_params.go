@@ -21,6 +21,24 @@ type Params struct {
 	FullCycle  CycleParams `json:"full"`
 
 	SnapshotGC SnapshotGCParams `json:"snapshotGC"`
+
+	RestoreTest RestoreTestParams `json:"restoreTest"`
+
+	StorageBudget StorageBudgetParams `json:"storageBudget"`
+
+	Thinning ThinningParams `json:"thinning"`
+
+	ContentVerification ContentVerificationParams `json:"contentVerification"`
+
+	BlobGC BlobGCParams `json:"blobGC"`
+
+	ClockSkew ClockSkewParams `json:"clockSkew"`
+}
+
+// BlobGCParams contains parameters for deleting unreferenced blobs during maintenance.
+type BlobGCParams struct {
+	// QuarantinePeriod is the time unreferenced blobs spend in quarantine before being deleted, zero deletes them immediately.
+	QuarantinePeriod time.Duration `json:"quarantinePeriod,omitempty"`
 }
 
 // SnapshotGCParams contains parameters for Snapshot Garbage Collection
@@ -30,6 +48,50 @@ type SnapshotGCParams struct {
 	MinContentAge time.Duration `json:"minAge"`
 }
 
+// RestoreTestParams contains parameters for periodic test restores of random samples of files
+// from latest snapshots. Like Snapshot GC, restore tests are implemented outside of repository package.
+type RestoreTestParams struct {
+	Enabled  bool          `json:"enabled"`
+	Interval time.Duration `json:"interval"`
+
+	// SampleSize is the number of files restored during each test.
+	SampleSize int `json:"sampleSize"`
+
+	// ScratchDir is the local directory where files are restored, defaults to system temporary directory.
+	ScratchDir string `json:"scratchDir,omitempty"`
+}
+
+// StorageBudgetParams contains parameters for expiring the oldest snapshots when the storage used by
+// snapshots exceeds a budget. Like Snapshot GC, the budget is enforced outside of repository package.
+type StorageBudgetParams struct {
+	// MaxBytes is the maximum estimated storage used by snapshots, zero disables the budget.
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+}
+
+// ThinningParams contains parameters for thinning old snapshot history by deleting intermediate snapshots.
+// Like Snapshot GC, thinning is implemented outside of repository package.
+type ThinningParams struct {
+	Rules []ThinningRule `json:"rules,omitempty"`
+}
+
+// ThinningRule keeps at most one snapshot of each source per Interval among snapshots older than OlderThan.
+// When multiple rules apply to a snapshot, the rule with the largest OlderThan is used.
+type ThinningRule struct {
+	OlderThan time.Duration `json:"olderThan"`
+	Interval  time.Duration `json:"interval"`
+}
+
+// ContentVerificationParams contains parameters for periodic verification of a fraction of repository contents,
+// so that all contents are eventually read back and verified. Like restore tests, content verification is
+// implemented outside of repository package.
+type ContentVerificationParams struct {
+	Enabled  bool          `json:"enabled"`
+	Interval time.Duration `json:"interval"`
+
+	// Percent is the percentage of contents verified during each run.
+	Percent float64 `json:"percent"`
+}
+
 // DefaultParams represents default values of maintenance parameters.
 func DefaultParams() Params {
 	return Params{
@@ -45,6 +107,14 @@ func DefaultParams() Params {
 		SnapshotGC: SnapshotGCParams{
 			MinContentAge: 24 * time.Hour, //nolint:gomnd
 		},
+		RestoreTest: RestoreTestParams{
+			Interval:   24 * time.Hour, //nolint:gomnd
+			SampleSize: 10,             //nolint:gomnd
+		},
+		ContentVerification: ContentVerificationParams{
+			Interval: 24 * time.Hour, //nolint:gomnd
+			Percent:  1,              //nolint:gomnd
+		},
 	}
 }
 
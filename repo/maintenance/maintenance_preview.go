@@ -0,0 +1,343 @@
+package maintenance
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/content"
+)
+
+// DefaultPreviewSamples is the default number of sample IDs included in each part of the preview.
+const DefaultPreviewSamples = 10
+
+// PreviewItems summarizes items affected by a single maintenance action.
+type PreviewItems struct {
+	Count   int      `json:"count"`
+	Bytes   int64    `json:"bytes"`
+	Samples []string `json:"samples,omitempty"`
+
+	maxSamples int
+}
+
+// add records an affected item, retaining lexicographically smallest IDs as samples,
+// so that the preview does not depend on iteration order.
+func (p *PreviewItems) add(id string, length int64) {
+	p.Count++
+	p.Bytes += length
+
+	if p.maxSamples <= 0 {
+		return
+	}
+
+	i := sort.SearchStrings(p.Samples, id)
+	if i >= p.maxSamples {
+		return
+	}
+
+	p.Samples = append(p.Samples, "")
+	copy(p.Samples[i+1:], p.Samples[i:])
+	p.Samples[i] = id
+
+	if len(p.Samples) > p.maxSamples {
+		p.Samples = p.Samples[0:p.maxSamples]
+	}
+}
+
+// Preview describes changes that maintenance would make to the repository.
+type Preview struct {
+	Mode Mode      `json:"mode"`
+	Time time.Time `json:"time"`
+
+	// populated by snapshot thinning, which runs before full maintenance.
+	SnapshotsToThin *PreviewItems `json:"snapshotsToThin,omitempty"`
+
+	// populated by storage budget enforcement, which runs before full maintenance.
+	SnapshotsToExpire *PreviewItems `json:"snapshotsToExpire,omitempty"`
+
+	// populated by snapshot GC, which runs before full maintenance.
+	ContentsToMarkDeleted *PreviewItems `json:"contentsToMarkDeleted,omitempty"`
+	ContentsToUndelete    *PreviewItems `json:"contentsToUndelete,omitempty"`
+
+	SafeDropTime        time.Time    `json:"safeDropTime,omitempty"`
+	IndexBlobsToCompact PreviewItems `json:"indexBlobsToCompact"`
+	ContentsToDrop      PreviewItems `json:"contentsToDrop"`
+	ContentsToPurge     PreviewItems `json:"contentsToPurge"`
+	ContentsToRewrite   PreviewItems `json:"contentsToRewrite"`
+	BlobsToDelete       PreviewItems `json:"blobsToDelete"`
+}
+
+// ReclaimedBytes returns the number of bytes of storage that would be reclaimed.
+func (p *Preview) ReclaimedBytes() int64 {
+	return p.BlobsToDelete.Bytes
+}
+
+// PreviewOptions provides options for PreviewRun.
+type PreviewOptions struct {
+	Mode       Mode
+	MaxSamples int
+
+	// Descriptions of snapshots that snapshot thinning would delete before full maintenance.
+	ThinSnapshots []string
+
+	// IDs of snapshots that storage budget enforcement would expire before full maintenance.
+	ExpireSnapshots []string
+
+	// Contents that snapshot GC would mark as deleted or undelete before full maintenance.
+	MarkDeleted []content.Info
+	Undelete    []content.Info
+}
+
+// previewContent is the simulated state of a content index entry.
+type previewContent struct {
+	info    content.Info
+	deleted bool
+	ts      time.Time
+
+	// pack is empty after the content is dropped from the index or rewritten to a new pack.
+	pack blob.ID
+}
+
+// PreviewRun computes changes that maintenance in the provided mode would make, without
+// making any changes to the repository.
+func PreviewRun(ctx context.Context, rep MaintainableRepository, opt PreviewOptions) (*Preview, error) {
+	if opt.MaxSamples == 0 {
+		opt.MaxSamples = DefaultPreviewSamples
+	}
+
+	p := &Preview{
+		Mode: opt.Mode,
+		Time: rep.Time(),
+	}
+
+	for _, pi := range []*PreviewItems{&p.IndexBlobsToCompact, &p.ContentsToDrop, &p.ContentsToPurge, &p.ContentsToRewrite, &p.BlobsToDelete} {
+		pi.maxSamples = opt.MaxSamples
+	}
+
+	s, err := GetSchedule(ctx, rep)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get schedule")
+	}
+
+	contents := map[content.ID]*previewContent{}
+
+	if err := rep.ContentManager().IterateContents(ctx, content.IterateOptions{IncludeDeleted: true}, func(ci content.Info) error {
+		contents[ci.ID] = &previewContent{ci, ci.Deleted, ci.Timestamp(), ci.PackBlobID}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "error iterating contents")
+	}
+
+	switch opt.Mode {
+	case ModeQuick:
+		err = previewQuick(ctx, rep, p, s, contents)
+
+	case ModeFull:
+		err = previewFull(ctx, rep, p, s, contents, opt)
+
+	default:
+		err = errors.Errorf("unknown mode %q", opt.Mode)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func previewQuick(ctx context.Context, rep MaintainableRepository, p *Preview, s *Schedule, contents map[content.ID]*previewContent) error {
+	previewRewriteShortPacks(rep, p, contents, content.PackBlobIDPrefixSpecial, s.pendingPurgeContentIDs())
+
+	if err := previewDeleteBlobs(ctx, rep, p, contents, content.PackBlobIDPrefixSpecial); err != nil {
+		return err
+	}
+
+	return previewIndexCompaction(ctx, rep, p, content.CompactOptions{
+		MaxSmallBlobs: maxSmallBlobsForIndexCompaction,
+	})
+}
+
+func previewFull(ctx context.Context, rep MaintainableRepository, p *Preview, s *Schedule, contents map[content.ID]*previewContent, opt PreviewOptions) error {
+	now := rep.Time()
+
+	p.ContentsToMarkDeleted = &PreviewItems{maxSamples: opt.MaxSamples}
+	p.ContentsToUndelete = &PreviewItems{maxSamples: opt.MaxSamples}
+
+	if len(opt.ThinSnapshots) > 0 {
+		p.SnapshotsToThin = &PreviewItems{maxSamples: opt.MaxSamples}
+
+		for _, desc := range opt.ThinSnapshots {
+			p.SnapshotsToThin.add(desc, 0)
+		}
+	}
+
+	if len(opt.ExpireSnapshots) > 0 {
+		p.SnapshotsToExpire = &PreviewItems{maxSamples: opt.MaxSamples}
+
+		for _, id := range opt.ExpireSnapshots {
+			p.SnapshotsToExpire.add(id, 0)
+		}
+	}
+
+	for _, ci := range opt.MarkDeleted {
+		if c := contents[ci.ID]; c != nil {
+			c.deleted, c.ts = true, now
+			p.ContentsToMarkDeleted.add(string(ci.ID), int64(ci.Length))
+		}
+	}
+
+	for _, ci := range opt.Undelete {
+		if c := contents[ci.ID]; c != nil {
+			c.deleted, c.ts = false, now
+			p.ContentsToUndelete.add(string(ci.ID), int64(ci.Length))
+		}
+	}
+
+	// snapshot GC that precedes full maintenance counts as successful run when determining safe drop time.
+	runs := append(append([]RunInfo(nil), s.Runs["snapshot-gc"]...), RunInfo{Start: now, End: now, Success: true})
+
+	p.SafeDropTime = findSafeDropTime(runs)
+
+	if !p.SafeDropTime.IsZero() {
+		for _, c := range contents {
+			if c.pack != "" && c.deleted && c.ts.Before(p.SafeDropTime) {
+				p.ContentsToDrop.add(string(c.info.ID), int64(c.info.Length))
+				c.pack = ""
+			}
+		}
+	}
+
+	purged := previewPurge(p, s, contents)
+
+	if !p.SafeDropTime.IsZero() || len(purged) > 0 {
+		if err := previewIndexCompaction(ctx, rep, p, content.CompactOptions{
+			AllIndexes:        true,
+			DropDeletedBefore: p.SafeDropTime,
+			DropContents:      purged,
+		}); err != nil {
+			return err
+		}
+	}
+
+	// purges are completed at this point, so no contents are excluded from rewriting.
+	previewRewriteShortPacks(rep, p, contents, "", nil)
+
+	return previewDeleteBlobs(ctx, rep, p, contents, "")
+}
+
+// previewPurge simulates PurgeContents and returns IDs of contents that would be dropped from the index.
+func previewPurge(p *Preview, s *Schedule, contents map[content.ID]*previewContent) []content.ID {
+	pending := s.pendingPurgeContentIDs()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	packs := map[blob.ID]bool{}
+
+	for _, r := range s.Purges {
+		if r.Pending() {
+			for _, b := range r.PackBlobIDs {
+				packs[b] = true
+			}
+		}
+	}
+
+	var drop []content.ID
+
+	for _, c := range contents {
+		switch {
+		case c.pack == "":
+			continue
+
+		case pending[c.info.ID] && c.deleted:
+			p.ContentsToPurge.add(string(c.info.ID), int64(c.info.Length))
+			drop = append(drop, c.info.ID)
+			c.pack = ""
+
+		case packs[c.pack] && !pending[c.info.ID]:
+			p.ContentsToRewrite.add(string(c.info.ID), int64(c.info.Length))
+			c.pack = ""
+		}
+	}
+
+	return drop
+}
+
+// previewRewriteShortPacks simulates RewriteContents of short packs with the provided prefix.
+func previewRewriteShortPacks(rep MaintainableRepository, p *Preview, contents map[content.ID]*previewContent, prefix blob.ID, excluded map[content.ID]bool) {
+	threshold := int64(rep.ContentManager().Format.MaxPackSize * shortPackThresholdPercent / 100) //nolint:gomnd
+
+	packSizes := map[blob.ID]int64{}
+
+	for _, c := range contents {
+		if c.pack != "" && strings.HasPrefix(string(c.pack), string(prefix)) {
+			packSizes[c.pack] += int64(c.info.Length)
+		}
+	}
+
+	for _, c := range contents {
+		size, ok := packSizes[c.pack]
+		if !ok || size >= threshold || excluded[c.info.ID] {
+			continue
+		}
+
+		if rep.Time().Sub(c.ts) < defaultRewriteContentsMinAge {
+			continue
+		}
+
+		p.ContentsToRewrite.add(string(c.info.ID), int64(c.info.Length))
+		c.pack = ""
+	}
+}
+
+// previewDeleteBlobs simulates DeleteUnreferencedBlobs after all other changes have been applied.
+func previewDeleteBlobs(ctx context.Context, rep MaintainableRepository, p *Preview, contents map[content.ID]*previewContent, prefix blob.ID) error {
+	referenced := map[blob.ID]bool{}
+
+	for _, c := range contents {
+		if c.pack != "" {
+			referenced[c.pack] = true
+		}
+	}
+
+	prefixes := content.PackBlobIDPrefixes
+	if prefix != "" {
+		prefixes = []blob.ID{prefix}
+	}
+
+	for _, pr := range prefixes {
+		if err := rep.BlobStorage().ListBlobs(ctx, pr, func(bm blob.Metadata) error {
+			if referenced[bm.BlobID] || rep.Time().Sub(bm.Timestamp) < defaultBlobGCMinAge {
+				return nil
+			}
+
+			if _, locked := blobLockedUntil(rep, bm); !locked {
+				p.BlobsToDelete.add(string(bm.BlobID), bm.Length)
+			}
+
+			return nil
+		}); err != nil {
+			return errors.Wrap(err, "error listing blobs")
+		}
+	}
+
+	return nil
+}
+
+func previewIndexCompaction(ctx context.Context, rep MaintainableRepository, p *Preview, opt content.CompactOptions) error {
+	blobs, err := rep.ContentManager().IndexBlobsToCompact(ctx, opt)
+	if err != nil {
+		return errors.Wrap(err, "unable to determine index blobs to compact")
+	}
+
+	for _, b := range blobs {
+		p.IndexBlobsToCompact.add(string(b.BlobID), b.Length)
+	}
+
+	return nil
+}
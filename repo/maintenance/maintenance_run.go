@@ -198,6 +198,11 @@ func RunExclusive(ctx context.Context, rep MaintainableRepository, mode Mode, fo
 
 	defer l.Unlock() //nolint:errcheck
 
+	// maintenance deletes data based on timestamps, make sure the local clock can be trusted.
+	if err := checkClockSkew(ctx, rep, p.ClockSkew); err != nil {
+		return err
+	}
+
 	log(ctx).Infof("Running %v maintenance...", runParams.Mode)
 	defer log(ctx).Infof("Finished %v maintenance.", runParams.Mode)
 
@@ -234,13 +239,21 @@ func runQuickMaintenance(ctx context.Context, runParams RunParameters) error {
 	// delete orphaned 'q' packs after some time.
 	if err := ReportRun(ctx, runParams.rep, "quick-delete-blobs", func() error {
 		_, err := DeleteUnreferencedBlobs(ctx, runParams.rep, DeleteUnreferencedBlobsOptions{
-			Prefix: content.PackBlobIDPrefixSpecial,
+			Prefix:           content.PackBlobIDPrefixSpecial,
+			QuarantinePeriod: runParams.Params.BlobGC.QuarantinePeriod,
 		})
 		return err
 	}); err != nil {
 		return errors.Wrap(err, "error deleting unreferenced metadata blobs")
 	}
 
+	// merge small indexes written by individual flushes.
+	if err := ReportRun(ctx, runParams.rep, "small-index-compaction", func() error {
+		return SmallIndexCompaction(ctx, runParams.rep)
+	}); err != nil {
+		return errors.Wrap(err, "error merging small indexes")
+	}
+
 	// consolidate many smaller indexes into fewer larger ones.
 	if err := ReportRun(ctx, runParams.rep, "index-compaction", func() error {
 		return IndexCompaction(ctx, runParams.rep)
@@ -271,6 +284,14 @@ func runFullMaintenance(ctx context.Context, runParams RunParameters) error {
 		log(ctx).Infof("Not enough time has passed since previous successful Snapshot GC. Will try again next time.")
 	}
 
+	// rewrite packs containing purged contents and drop purged contents from the index,
+	// orphaning those packs.
+	if err := ReportRun(ctx, runParams.rep, "full-purge-contents", func() error {
+		return PurgeContents(ctx, runParams.rep)
+	}); err != nil {
+		return errors.Wrap(err, "error purging contents")
+	}
+
 	// find packs that are less than 80% full and rewrite contents in them into
 	// new consolidated packs, orphaning old packs in the process.
 	if err := ReportRun(ctx, runParams.rep, "full-rewrite-contents", func() error {
@@ -284,7 +305,9 @@ func runFullMaintenance(ctx context.Context, runParams RunParameters) error {
 
 	// delete orphaned packs after some time.
 	if err := ReportRun(ctx, runParams.rep, "full-delete-blobs", func() error {
-		_, err := DeleteUnreferencedBlobs(ctx, runParams.rep, DeleteUnreferencedBlobsOptions{})
+		_, err := DeleteUnreferencedBlobs(ctx, runParams.rep, DeleteUnreferencedBlobsOptions{
+			QuarantinePeriod: runParams.Params.BlobGC.QuarantinePeriod,
+		})
 		return err
 	}); err != nil {
 		return errors.Wrap(err, "error deleting unreferenced blobs")
@@ -41,6 +41,12 @@ type Schedule struct {
 	NextQuickMaintenanceTime time.Time `json:"nextQuickMaintenance"`
 
 	Runs map[string][]RunInfo `json:"runs"`
+
+	// Purges is the audit trail of contents forcibly removed from the repository.
+	Purges []*PurgeRecord `json:"purges,omitempty"`
+
+	// ClockSkew contains the most recent clock skew between each client running maintenance and the storage, keyed by user@host.
+	ClockSkew map[string]ClockSkewInfo `json:"clockSkew,omitempty"`
 }
 
 // ReportRun adds the provided run information to the history and discards oldest entried.
@@ -127,7 +133,7 @@ func SetSchedule(ctx context.Context, rep MaintainableRepository, s *Schedule) e
 	result := append([]byte(nil), nonce...)
 	ciphertext := c.Seal(result, nonce, v, maintenanceScheduleAEADExtraData)
 
-	return rep.BlobStorage().PutBlob(ctx, maintenanceScheduleBlobID, gather.FromSlice(ciphertext))
+	return rep.BlobStorage().PutBlob(ctx, maintenanceScheduleBlobID, gather.FromSlice(ciphertext), blob.PutOptions{})
 }
 
 // ReportRun reports timing of a maintenance run and persists it in repository.
@@ -148,7 +154,8 @@ func ReportRun(ctx context.Context, rep MaintainableRepository, runType string,
 
 	s, err := GetSchedule(ctx, rep)
 	if err != nil {
-		log(ctx).Warningf("unable to get schedule")
+		log(ctx).Warningf("unable to get schedule: %v", err)
+		return runErr
 	}
 
 	s.ReportRun(runType, ri)
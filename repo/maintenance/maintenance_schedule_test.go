@@ -6,9 +6,12 @@
 	"testing"
 
 	"github.com/kylelemons/godebug/pretty"
+	"github.com/pkg/errors"
 
 	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/gather"
 	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/repo/blob"
 )
 
 func TestMaintenanceSchedule(t *testing.T) {
@@ -56,3 +59,21 @@ func toJSON(v interface{}) string {
 	b, _ := json.MarshalIndent(v, "", "  ")
 	return string(b)
 }
+
+func TestReportRunWithUnreadableSchedule(t *testing.T) {
+	ctx := context.Background()
+
+	var env repotesting.Environment
+	defer env.Setup(t).Close(ctx, t)
+
+	if err := env.Repository.BlobStorage().PutBlob(ctx, maintenanceScheduleBlobID, gather.FromSlice(make([]byte, 100)), blob.PutOptions{}); err != nil {
+		t.Fatalf("unable to write schedule: %v", err)
+	}
+
+	runErr := errors.New("run failed")
+
+	// the result of the run is returned even though it can't be recorded.
+	if err := ReportRun(ctx, env.Repository, "foo", func() error { return runErr }); !errors.Is(err, runErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -15,6 +15,7 @@
 	"github.com/pkg/errors"
 
 	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/repo/compression"
 	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/repo/logging"
 )
@@ -59,6 +60,10 @@ type Manager struct {
 	committedEntries    map[ID]*manifestEntry
 	committedContentIDs map[content.ID]bool
 
+	// compressor used for writing manifest contents, nil for legacy gzip format.
+	compressorName compression.Name
+	compressor     compression.Compressor
+
 	timeNow func() time.Time // Time provider
 }
 
@@ -97,6 +102,50 @@ func (m *Manager) Put(ctx context.Context, labels map[string]string, payload int
 	return e.ID, nil
 }
 
+// Replace replaces the payload and labels of an existing manifest item, keeping its ID.
+// Returns ErrNotFound if the item does not exist.
+func (m *Manager) Replace(ctx context.Context, id ID, labels map[string]string, payload interface{}) error {
+	if labels[TypeLabelKey] == "" {
+		return errors.Errorf("'type' label is required")
+	}
+
+	if err := m.ensureInitialized(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev := m.pendingEntries[id]
+	if prev == nil {
+		prev = m.committedEntries[id]
+	}
+
+	if prev == nil || prev.Deleted {
+		return ErrNotFound
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "marshal error")
+	}
+
+	// entries with the same ID are merged by modification time, so the replacement must be newer.
+	modTime := m.timeNow().UTC()
+	if !modTime.After(prev.ModTime) {
+		modTime = prev.ModTime.Add(time.Nanosecond)
+	}
+
+	m.pendingEntries[id] = &manifestEntry{
+		ID:      id,
+		ModTime: modTime,
+		Labels:  copyLabels(labels),
+		Content: b,
+	}
+
+	return nil
+}
+
 // GetMetadata returns metadata about provided manifest item or ErrNotFound if the item can't be found.
 func (m *Manager) GetMetadata(ctx context.Context, id ID) (*EntryMetadata, error) {
 	if err := m.ensureInitialized(ctx); err != nil {
@@ -228,10 +277,22 @@ func (m *Manager) flushPendingEntriesLocked(ctx context.Context) (content.ID, er
 	}
 
 	var buf bytes.Buffer
-	gz := gzip.NewWriter(&buf)
-	mustSucceed(json.NewEncoder(gz).Encode(man))
-	mustSucceed(gz.Flush())
-	mustSucceed(gz.Close())
+
+	if m.compressor != nil {
+		b, err := json.Marshal(man)
+		if err != nil {
+			return "", errors.Wrap(err, "unable to serialize manifests")
+		}
+
+		if err := m.compressor.Compress(&buf, b); err != nil {
+			return "", errors.Wrap(err, "unable to compress manifests")
+		}
+	} else {
+		gz := gzip.NewWriter(&buf)
+		mustSucceed(json.NewEncoder(gz).Encode(man))
+		mustSucceed(gz.Flush())
+		mustSucceed(gz.Close())
+	}
 
 	contentID, err := m.b.WriteContent(ctx, buf.Bytes(), ContentPrefix)
 	if err != nil {
@@ -362,18 +423,57 @@ func (m *Manager) loadManifestContent(ctx context.Context, contentID content.ID)
 		return man, err
 	}
 
-	gz, err := gzip.NewReader(bytes.NewReader(blk))
+	if isGzipManifestContent(blk) {
+		gz, err := gzip.NewReader(bytes.NewReader(blk))
+		if err != nil {
+			return man, errors.Wrapf(err, "unable to unpack manifest data %q", contentID)
+		}
+
+		if err := json.NewDecoder(gz).Decode(&man); err != nil {
+			return man, errors.Wrapf(err, "unable to parse manifest %q", contentID)
+		}
+
+		return man, nil
+	}
+
+	// non-gzip manifests start with compression header identifying the compressor.
+	hid, err := compression.IDFromHeader(blk)
 	if err != nil {
+		return man, errors.Wrapf(err, "invalid compression header of manifest %q", contentID)
+	}
+
+	comp := compression.ByHeaderID[hid]
+	if comp == nil && compression.IsPluginHeaderID(hid) {
+		return man, errors.Errorf("compressor %x of manifest %q is provided by a plugin which is not available", hid, contentID)
+	}
+
+	if comp == nil {
+		return man, errors.Errorf("unsupported compressor %x in manifest %q", hid, contentID)
+	}
+
+	var buf bytes.Buffer
+
+	if err := comp.Decompress(&buf, blk); err != nil {
 		return man, errors.Wrapf(err, "unable to unpack manifest data %q", contentID)
 	}
 
-	if err := json.NewDecoder(gz).Decode(&man); err != nil {
+	if err := json.Unmarshal(buf.Bytes(), &man); err != nil {
 		return man, errors.Wrapf(err, "unable to parse manifest %q", contentID)
 	}
 
 	return man, nil
 }
 
+// isGzipManifestContent determines whether the manifest content is stored in legacy gzip format.
+func isGzipManifestContent(b []byte) bool {
+	const (
+		gzipID1 = 0x1f
+		gzipID2 = 0x8b
+	)
+
+	return len(b) >= 2 && b[0] == gzipID1 && b[1] == gzipID2
+}
+
 // Compact performs compaction of manifest contents.
 func (m *Manager) Compact(ctx context.Context) error {
 	m.mu.Lock()
@@ -476,9 +576,18 @@ func copyLabels(m map[string]string) map[string]string {
 	return r
 }
 
+// Compressor returns the name of compressor used for writing manifests or empty string for legacy gzip format.
+func (m *Manager) Compressor() compression.Name {
+	return m.compressorName
+}
+
 // ManagerOptions are optional parameters for Manager creation.
 type ManagerOptions struct {
 	TimeNow func() time.Time // Time provider
+
+	// Compressor used for writing manifest contents, when empty manifests are written using legacy gzip format,
+	// which is readable by all versions of kopia.
+	Compressor compression.Name
 }
 
 // NewManager returns new manifest manager for the provided content manager.
@@ -488,11 +597,22 @@ func NewManager(ctx context.Context, b contentManager, options ManagerOptions) (
 		timeNow = clock.Now
 	}
 
+	var comp compression.Compressor
+
+	if options.Compressor != "" {
+		comp = compression.ByName[options.Compressor]
+		if comp == nil {
+			return nil, errors.Errorf("unsupported manifest compressor %q, please upgrade kopia", options.Compressor)
+		}
+	}
+
 	m := &Manager{
 		b:                   b,
 		pendingEntries:      map[ID]*manifestEntry{},
 		committedEntries:    map[ID]*manifestEntry{},
 		committedContentIDs: map[content.ID]bool{},
+		compressorName:      options.Compressor,
+		compressor:          comp,
 		timeNow:             timeNow,
 	}
 
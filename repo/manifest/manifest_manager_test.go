@@ -9,6 +9,8 @@
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"github.com/kopia/kopia/internal/blobtesting"
 	"github.com/kopia/kopia/internal/testlogging"
 	"github.com/kopia/kopia/repo/content"
@@ -297,6 +299,10 @@ func sortIDs(s []ID) {
 }
 
 func newManagerForTesting(ctx context.Context, t *testing.T, data blobtesting.DataMap) *Manager {
+	return newManagerForTestingWithOptions(ctx, t, data, ManagerOptions{})
+}
+
+func newManagerForTestingWithOptions(ctx context.Context, t *testing.T, data blobtesting.DataMap, opt ManagerOptions) *Manager {
 	st := blobtesting.NewMapStorage(data, nil, nil)
 
 	bm, err := content.NewManager(ctx, st, &content.FormattingOptions{
@@ -309,7 +315,7 @@ func newManagerForTesting(ctx context.Context, t *testing.T, data blobtesting.Da
 		t.Fatalf("can't create content manager: %v", err)
 	}
 
-	mm, err := NewManager(ctx, bm, ManagerOptions{})
+	mm, err := NewManager(ctx, bm, opt)
 	if err != nil {
 		t.Fatalf("can't create manifest manager: %v", err)
 	}
@@ -339,6 +345,59 @@ func TestManifestInvalidPut(t *testing.T) {
 	}
 }
 
+func TestManifestReplace(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+	mgr := newManagerForTesting(ctx, t, data)
+
+	item1 := map[string]int{"foo": 1, "bar": 2}
+	item2 := map[string]int{"foo": 2, "bar": 3}
+	labels1 := map[string]string{"type": "item", "color": "red"}
+	labels2 := map[string]string{"type": "item", "color": "blue"}
+
+	if err := mgr.Replace(ctx, "no-such-id", labels1, item1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unexpected error when replacing non-existent item: %v", err)
+	}
+
+	id := addAndVerify(ctx, t, mgr, labels1, item1)
+
+	if err := mgr.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	if err := mgr.b.Flush(ctx); err != nil {
+		t.Fatalf("content flush error: %v", err)
+	}
+
+	// replace committed item from another manager.
+	mgr = newManagerForTesting(ctx, t, data)
+
+	if err := mgr.Replace(ctx, id, labels2, item2); err != nil {
+		t.Fatalf("replace error: %v", err)
+	}
+
+	verifyItem(ctx, t, mgr, id, labels2, item2)
+	verifyMatches(ctx, t, mgr, map[string]string{"type": "item"}, []ID{id})
+
+	if err := mgr.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	if err := mgr.b.Flush(ctx); err != nil {
+		t.Fatalf("content flush error: %v", err)
+	}
+
+	mgr = newManagerForTesting(ctx, t, data)
+	verifyItem(ctx, t, mgr, id, labels2, item2)
+	verifyMatches(ctx, t, mgr, map[string]string{"color": "red"}, nil)
+
+	if err := mgr.Compact(ctx); err != nil {
+		t.Fatalf("compaction error: %v", err)
+	}
+
+	verifyItem(ctx, t, mgr, id, labels2, item2)
+}
+
 func TestManifestAutoCompaction(t *testing.T) {
 	ctx := testlogging.Context(t)
 	data := blobtesting.DataMap{}
@@ -352,3 +411,68 @@ func TestManifestAutoCompaction(t *testing.T) {
 		mgr.Flush(ctx)
 	}
 }
+
+func TestManifestCompression(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data := blobtesting.DataMap{}
+
+	// write one manifest in legacy format and one using zstd.
+	mgr := newManagerForTesting(ctx, t, data)
+	item1 := map[string]int{"foo": 1, "bar": 2}
+	labels1 := map[string]string{"type": "item", "color": "red"}
+	id1 := addAndVerify(ctx, t, mgr, labels1, item1)
+
+	if err := mgr.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	if err := mgr.b.Flush(ctx); err != nil {
+		t.Fatalf("content flush error: %v", err)
+	}
+
+	mgr = newManagerForTestingWithOptions(ctx, t, data, ManagerOptions{Compressor: "zstd"})
+	item2 := map[string]int{"foo": 3, "bar": 4}
+	labels2 := map[string]string{"type": "item", "color": "blue"}
+	id2 := addAndVerify(ctx, t, mgr, labels2, item2)
+
+	if err := mgr.Flush(ctx); err != nil {
+		t.Fatalf("flush error: %v", err)
+	}
+
+	if err := mgr.b.Flush(ctx); err != nil {
+		t.Fatalf("content flush error: %v", err)
+	}
+
+	// both formats are readable regardless of the compressor used for writing.
+	mgr = newManagerForTesting(ctx, t, data)
+	verifyItem(ctx, t, mgr, id1, labels1, item1)
+	verifyItem(ctx, t, mgr, id2, labels2, item2)
+
+	if err := mgr.Compact(ctx); err != nil {
+		t.Fatalf("compaction error: %v", err)
+	}
+
+	if err := mgr.b.Flush(ctx); err != nil {
+		t.Fatalf("content flush error: %v", err)
+	}
+
+	mgr = newManagerForTestingWithOptions(ctx, t, data, ManagerOptions{Compressor: "zstd"})
+	verifyItem(ctx, t, mgr, id1, labels1, item1)
+	verifyItem(ctx, t, mgr, id2, labels2, item2)
+
+	st := blobtesting.NewMapStorage(data, nil, nil)
+
+	bm, err := content.NewManager(ctx, st, &content.FormattingOptions{
+		Hash:        hashing.DefaultAlgorithm,
+		Encryption:  encryption.DefaultAlgorithm,
+		MaxPackSize: 100000,
+		Version:     1,
+	}, nil, content.ManagerOptions{})
+	if err != nil {
+		t.Fatalf("can't create content manager: %v", err)
+	}
+
+	if _, err := NewManager(ctx, bm, ManagerOptions{Compressor: "no-such-compressor"}); err == nil {
+		t.Fatalf("expected error when using unsupported compressor")
+	}
+}
@@ -0,0 +1,70 @@
+package repo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kopia/kopia/internal/netfs"
+	"github.com/kopia/kopia/repo/content"
+)
+
+// applyNetworkFilesystemOptions adjusts caching options when cache directories are located on network filesystems.
+//
+// Memory-mapped files on network filesystems cause crashes when modified or removed remotely, so indexes are read
+// using regular file I/O, and the shared index cache is coordinated using lock files, because advisory locks are
+// not reliable there. Hosts sharing the cache directory would remove each other's files, including cached indexes,
+// so each host can be configured to use its own subdirectory, which is not done automatically because it changes
+// the location of cached files.
+func applyNetworkFilesystemOptions(ctx context.Context, caching *content.CachingOptions) {
+	if dir := caching.CacheDirectory; dir != "" {
+		if isOnNetworkFilesystem(ctx, dir) {
+			caching.DisableIndexMmap = true
+
+			if !caching.PerHostCacheDirectory {
+				log(ctx).Warningf("cache directory %v is on a network filesystem, which is slower than a local disk, "+
+					"use 'kopia cache set --per-host-cache-directory=true' if it's shared by multiple hosts", dir)
+			}
+		}
+
+		if caching.PerHostCacheDirectory {
+			caching.CacheDirectory = filepath.Join(dir, hostCacheSubdirectory())
+
+			log(ctx).Infof("using per-host cache directory %v", caching.CacheDirectory)
+		}
+	}
+
+	if dir := caching.SharedIndexCacheDirectory; dir != "" && isOnNetworkFilesystem(ctx, dir) {
+		caching.UseLockFiles = true
+		caching.DisableIndexMmap = true
+
+		log(ctx).Warningf("shared index cache directory %v is on a network filesystem, using lock files to coordinate access", dir)
+	}
+}
+
+func isOnNetworkFilesystem(ctx context.Context, dir string) bool {
+	fs, err := netfs.Detect(dir)
+	if err != nil {
+		log(ctx).Debugf("unable to determine filesystem of %v: %v", dir, err)
+		return false
+	}
+
+	return fs.Network
+}
+
+// hostCacheSubdirectory returns the name of cache subdirectory specific to the current host.
+func hostCacheSubdirectory() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "unknown"
+	}
+
+	return "host-" + strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+
+		return r
+	}, hostname)
+}
@@ -0,0 +1,50 @@
+package repo
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/content"
+)
+
+func TestApplyNetworkFilesystemOptionsLocalDirectory(t *testing.T) {
+	dir := t.TempDir()
+	caching := &content.CachingOptions{
+		CacheDirectory:            dir,
+		SharedIndexCacheDirectory: dir,
+	}
+
+	if isOnNetworkFilesystem(testlogging.Context(t), dir) {
+		t.Skip("temporary directory is on a network filesystem")
+	}
+
+	applyNetworkFilesystemOptions(testlogging.Context(t), caching)
+
+	if caching.CacheDirectory != dir || caching.DisableIndexMmap || caching.UseLockFiles {
+		t.Errorf("unexpected caching options for local directory: %+v", caching)
+	}
+}
+
+func TestHostCacheSubdirectory(t *testing.T) {
+	d := hostCacheSubdirectory()
+
+	if !strings.HasPrefix(d, "host-") || strings.ContainsAny(d, `/\:`) {
+		t.Errorf("invalid host cache subdirectory: %v", d)
+	}
+}
+
+func TestApplyNetworkFilesystemOptionsPerHostCacheDirectory(t *testing.T) {
+	dir := t.TempDir()
+	caching := &content.CachingOptions{
+		CacheDirectory:        dir,
+		PerHostCacheDirectory: true,
+	}
+
+	applyNetworkFilesystemOptions(testlogging.Context(t), caching)
+
+	if got, want := caching.CacheDirectory, filepath.Join(dir, hostCacheSubdirectory()); got != want {
+		t.Errorf("unexpected cache directory %v, want %v", got, want)
+	}
+}
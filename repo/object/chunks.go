@@ -0,0 +1,64 @@
+package object
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Chunk describes a range of an indirect object which is stored as a separate object.
+type Chunk struct {
+	Start  int64 `json:"start"`
+	Length int64 `json:"length"`
+	Object ID    `json:"object"`
+}
+
+// Opener opens objects for reading.
+type Opener interface {
+	OpenObject(ctx context.Context, id ID) (Reader, error)
+}
+
+// IndirectChunks returns chunks of the provided indirect object in order, expanding chunks which are
+// indirect objects themselves.
+func IndirectChunks(ctx context.Context, r Opener, oid ID) ([]Chunk, error) {
+	return appendIndirectChunks(ctx, r, nil, oid, 0)
+}
+
+func appendIndirectChunks(ctx context.Context, r Opener, result []Chunk, oid ID, startOffset int64) ([]Chunk, error) {
+	indexObjectID, ok := oid.IndexObjectID()
+	if !ok {
+		return nil, errors.Errorf("not an indirect object: %v", oid)
+	}
+
+	rd, err := r.OpenObject(ctx, indexObjectID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open index object %v", indexObjectID)
+	}
+	defer rd.Close() //nolint:errcheck
+
+	var ind indirectObject
+
+	if err := json.NewDecoder(rd).Decode(&ind); err != nil {
+		return nil, errors.Wrap(err, "invalid indirect object")
+	}
+
+	for _, e := range ind.Entries {
+		if _, isIndirect := e.Object.IndexObjectID(); isIndirect {
+			result, err = appendIndirectChunks(ctx, r, result, e.Object, startOffset+e.Start)
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		result = append(result, Chunk{
+			Start:  startOffset + e.Start,
+			Length: e.Length,
+			Object: e.Object,
+		})
+	}
+
+	return result, nil
+}
@@ -5,4 +5,7 @@ type indirectObjectEntry struct {
 	Start  int64 `json:"s,omitempty"`
 	Length int64 `json:"l,omitempty"`
 	Object ID    `json:"o,omitempty"`
+
+	// Fingerprint of the uncompressed chunk, only recorded by writers with delta uploads enabled.
+	Fingerprint string `json:"f,omitempty"`
 }
@@ -0,0 +1,118 @@
+package object
+
+import (
+	"container/list"
+	"sync"
+)
+
+// objectCache is an in-memory LRU cache of fully assembled (decrypted and decompressed) objects.
+// Since objects are immutable, cached data never needs to be invalidated.
+type objectCache struct {
+	maxObjectSize int64
+	maxTotalSize  int64
+
+	mu        sync.Mutex
+	totalSize int64
+	lru       *list.List // of *objectCacheEntry, most recently used first
+	entries   map[ID]*list.Element
+}
+
+type objectCacheEntry struct {
+	id   ID
+	data []byte
+}
+
+// get returns cached object data or nil if not found. The returned slice must not be modified.
+func (c *objectCache) get(id ID) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[id]
+	if !ok {
+		return nil
+	}
+
+	c.lru.MoveToFront(e)
+
+	return e.Value.(*objectCacheEntry).data
+}
+
+// add adds object data to the cache, evicting least recently used objects as needed.
+func (c *objectCache) add(id ID, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[id]; ok {
+		return
+	}
+
+	c.entries[id] = c.lru.PushFront(&objectCacheEntry{id, data})
+	c.totalSize += int64(len(data))
+
+	for c.totalSize > c.maxTotalSize {
+		oldest := c.lru.Back()
+		oe := oldest.Value.(*objectCacheEntry)
+
+		c.lru.Remove(oldest)
+		delete(c.entries, oe.id)
+		c.totalSize -= int64(len(oe.data))
+	}
+}
+
+// newObjectCache returns a new cache or nil if caching is disabled.
+func newObjectCache(maxObjectSize, maxTotalSize int64) *objectCache {
+	if maxObjectSize <= 0 || maxTotalSize <= 0 {
+		return nil
+	}
+
+	if maxObjectSize > maxTotalSize {
+		maxObjectSize = maxTotalSize
+	}
+
+	return &objectCache{
+		maxObjectSize: maxObjectSize,
+		maxTotalSize:  maxTotalSize,
+		lru:           list.New(),
+		entries:       map[ID]*list.Element{},
+	}
+}
+
+// cachingReader reads the object and adds its data to the cache when the whole object has been read
+// sequentially, so that opening an object does not read more than the caller needs.
+type cachingReader struct {
+	Reader
+
+	cache *objectCache
+	id    ID
+	data  []byte // data read so far, nil once reads stopped being sequential
+}
+
+func (r *cachingReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+
+	if r.data != nil {
+		r.data = append(r.data, b[0:n]...)
+
+		if int64(len(r.data)) == r.Length() {
+			r.cache.add(r.id, r.data)
+			r.data = nil
+		}
+	}
+
+	return n, err
+}
+
+func (r *cachingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.Reader.Seek(offset, whence)
+
+	// seeking anywhere but to the current position of sequential read stops caching.
+	if r.data != nil && pos != int64(len(r.data)) {
+		r.data = nil
+	}
+
+	return pos, err
+}
+
+func newCachingReader(r Reader, cache *objectCache, id ID) Reader {
+	return &cachingReader{r, cache, id, make([]byte, 0, r.Length())}
+}
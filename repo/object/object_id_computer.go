@@ -0,0 +1,52 @@
+package object
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/iocopy"
+	"github.com/kopia/kopia/repo/content"
+)
+
+// contentIDComputer is implemented by content managers that can compute content IDs without writing contents.
+type contentIDComputer interface {
+	ComputeContentID(data []byte, prefix content.ID) (content.ID, error)
+}
+
+// hashOnlyContentManager is a content manager that computes content IDs instead of writing contents.
+type hashOnlyContentManager struct {
+	contentManager
+	computer contentIDComputer
+}
+
+func (m hashOnlyContentManager) WriteContent(ctx context.Context, data []byte, prefix content.ID) (content.ID, error) {
+	return m.computer.ComputeContentID(data, prefix)
+}
+
+// ComputeObjectID returns the ID the object with the provided data would have if it was written
+// using provided options, without writing anything to the repository.
+func (om *Manager) ComputeObjectID(ctx context.Context, r io.Reader, opt WriterOptions) (ID, error) {
+	computer, ok := om.contentMgr.(contentIDComputer)
+	if !ok {
+		return "", errors.Errorf("content manager does not support computing content IDs")
+	}
+
+	hom := &Manager{
+		Format:      om.Format,
+		contentMgr:  hashOnlyContentManager{om.contentMgr, computer},
+		trace:       om.trace,
+		newSplitter: om.newSplitter,
+		bufferPool:  om.bufferPool,
+	}
+
+	w := hom.NewWriter(ctx, opt)
+	defer w.Close() //nolint:errcheck
+
+	if _, err := iocopy.Copy(w, r); err != nil {
+		return "", errors.Wrap(err, "unable to read data")
+	}
+
+	return w.Result()
+}
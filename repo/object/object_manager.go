@@ -18,6 +18,13 @@
 // maxCompressionOverheadPerSegment is maximum overhead that compression can incur.
 const maxCompressionOverheadPerSegment = 16384
 
+// defaultReadAheadChunks is the default number of chunks of large objects fetched in parallel when reading sequentially.
+const defaultReadAheadChunks = 4
+
+// maxReadAheadFetches is the maximum number of chunks fetched in the background by all readers of the manager,
+// chunks that can't be fetched in the background are fetched when they are read.
+const maxReadAheadFetches = 64
+
 // ErrObjectNotFound is returned when an object cannot be found.
 var ErrObjectNotFound = errors.New("object not found")
 
@@ -38,6 +45,14 @@ type contentManager interface {
 // Format describes the format of objects in a repository.
 type Format struct {
 	Splitter string `json:"splitter,omitempty"` // splitter used to break objects into pieces of content
+
+	// PluginCompressors are compressors provided by plugins that are allowed to be used in the repository.
+	// Clients that don't have all of them registered can't open the repository.
+	PluginCompressors map[compression.Name]compression.HeaderID `json:"pluginCompressors,omitempty"`
+
+	// ZstdDictionaryIDs are IDs of dictionaries trained on the repository data used by the "zstd-dictionary"
+	// compressor. The last one is used for compression and all of them are kept to decompress existing data.
+	ZstdDictionaryIDs []uint32 `json:"zstdDictionaries,omitempty"`
 }
 
 // Manager implements a content-addressable storage on top of blob storage.
@@ -50,6 +65,13 @@ type Manager struct {
 	newSplitter splitter.Factory
 
 	bufferPool *buf.Pool
+
+	cache *objectCache // nil when caching of small objects is disabled
+
+	readAheadChunks int
+	readAheadSem    chan struct{} // limits the number of chunks fetched in the background
+
+	dictionaryCompressor compression.Compressor // nil if the repository has no compression dictionaries
 }
 
 // NewWriter creates an ObjectWriter for writing to the repository.
@@ -60,7 +82,7 @@ func (om *Manager) NewWriter(ctx context.Context, opt WriterOptions) Writer {
 		splitter:    om.newSplitter(),
 		description: opt.Description,
 		prefix:      opt.Prefix,
-		compressor:  compression.ByName[opt.Compressor],
+		compressor:  om.compressorForWriting(opt.Compressor),
 	}
 
 	// point the slice at the embedded array, so that we avoid allocations most of the time
@@ -70,6 +92,14 @@ func (om *Manager) NewWriter(ctx context.Context, opt WriterOptions) Writer {
 		w.asyncWritesSemaphore = make(chan struct{}, opt.AsyncWrites)
 	}
 
+	if opt.Delta {
+		w.fingerprints = true
+
+		if opt.DeltaBase != "" {
+			w.deltaBase = om.loadDeltaBase(ctx, opt.DeltaBase)
+		}
+	}
+
 	w.initBuffer()
 
 	return w
@@ -77,7 +107,24 @@ func (om *Manager) NewWriter(ctx context.Context, opt WriterOptions) Writer {
 
 // Open creates new ObjectReader for reading given object from a repository.
 func (om *Manager) Open(ctx context.Context, objectID ID) (Reader, error) {
-	return om.openAndAssertLength(ctx, objectID, -1)
+	if om.cache == nil {
+		return om.openAndAssertLength(ctx, objectID, -1)
+	}
+
+	if data := om.cache.get(objectID); data != nil {
+		return newObjectReaderWithData(data), nil
+	}
+
+	r, err := om.openAndAssertLength(ctx, objectID, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Length() > om.cache.maxObjectSize {
+		return r, nil
+	}
+
+	return newCachingReader(r, om.cache, objectID), nil
 }
 
 // Concatenate creates an object that's a result of concatenation of other objects. This is more efficient than reading
@@ -168,9 +215,10 @@ func appendIndexEntries(indexEntries []indirectObjectEntry, startingLength int64
 
 	for _, inc := range incoming {
 		indexEntries = append(indexEntries, indirectObjectEntry{
-			Start:  inc.Start + startingLength,
-			Length: inc.Length,
-			Object: inc.Object,
+			Start:       inc.Start + startingLength,
+			Length:      inc.Length,
+			Object:      inc.Object,
+			Fingerprint: inc.Fingerprint,
 		})
 
 		totalLength += inc.Length
@@ -256,6 +304,18 @@ func nullTrace(message string, args ...interface{}) {
 // ManagerOptions specifies object manager options.
 type ManagerOptions struct {
 	Trace func(message string, args ...interface{})
+
+	// MaxCachedObjectSize is the maximum size of objects that are kept fully assembled
+	// in memory after being read from start to end, which speeds up repeated reads of small files.
+	// 0 (default) disables caching.
+	MaxCachedObjectSize int64
+
+	// ObjectCacheSize is the maximum total size of objects cached in memory.
+	ObjectCacheSize int64
+
+	// ReadAheadChunks is the number of chunks of large objects fetched in parallel ahead of the
+	// current position when reading sequentially. 0 uses the default, negative value disables read-ahead.
+	ReadAheadChunks int
 }
 
 // NewObjectManager creates an ObjectManager with the specified content manager and format.
@@ -276,7 +336,21 @@ func NewObjectManager(ctx context.Context, bm contentManager, f Format, opts Man
 		return nil, errors.Errorf("unsupported splitter %q", f.Splitter)
 	}
 
+	if err := VerifyPluginCompressors(f); err != nil {
+		return nil, err
+	}
+
 	om.newSplitter = splitter.Pooled(os)
+	om.cache = newObjectCache(opts.MaxCachedObjectSize, opts.ObjectCacheSize)
+
+	switch {
+	case opts.ReadAheadChunks == 0:
+		om.readAheadChunks = defaultReadAheadChunks
+	case opts.ReadAheadChunks > 0:
+		om.readAheadChunks = opts.ReadAheadChunks
+	}
+
+	om.readAheadSem = make(chan struct{}, maxReadAheadFetches)
 
 	om.bufferPool = buf.NewPool(ctx, om.newSplitter().MaxSegmentSize()+maxCompressionOverheadPerSegment, "object-manager")
 
@@ -360,6 +434,86 @@ func (om *Manager) newRawReader(ctx context.Context, objectID ID, assertLength i
 	return newObjectReaderWithData(payload), nil
 }
 
+// ErrPluginCompressorMissing is returned when the repository uses a plugin compressor that's not registered.
+var ErrPluginCompressorMissing = errors.New("compressor provided by a plugin is not available")
+
+// VerifyPluginCompressors ensures that all plugin compressors used in the repository are registered.
+func VerifyPluginCompressors(f Format) error {
+	for name, id := range f.PluginCompressors {
+		c := compression.ByName[name]
+		if c == nil {
+			return errors.Wrapf(ErrPluginCompressorMissing, "repository uses compressor %q (%x)", name, id)
+		}
+
+		if c.HeaderID() != id {
+			return errors.Errorf("compressor %q has HeaderID %x, but repository uses %x", name, c.HeaderID(), id)
+		}
+	}
+
+	return nil
+}
+
+// SetZstdDictionaries sets dictionaries used by the "zstd-dictionary" compressor, which are stored outside
+// of the repository format. It must not be called concurrently with writers or readers.
+func (om *Manager) SetZstdDictionaries(dicts []compression.ZstdDictionary) error {
+	om.dictionaryCompressor = nil
+
+	if len(dicts) == 0 {
+		return nil
+	}
+
+	c, err := compression.NewZstdDictionaryCompressor(dicts)
+	if err != nil {
+		return errors.Wrap(err, "unable to create dictionary compressor")
+	}
+
+	om.dictionaryCompressor = c
+
+	return nil
+}
+
+// compressorForWriting returns the compressor with a given name. Compressors provided by plugins must be
+// allowed in the repository format, otherwise clients lacking them wouldn't be able to read the data.
+func (om *Manager) compressorForWriting(name compression.Name) compression.Compressor {
+	if name == compression.ZstdDictionaryName {
+		if om.dictionaryCompressor == nil {
+			// no dictionary has been trained yet, compress without it.
+			return compression.ByName["zstd"]
+		}
+
+		return om.dictionaryCompressor
+	}
+
+	c := compression.ByName[name]
+	if c == nil || !compression.IsPluginHeaderID(c.HeaderID()) {
+		return c
+	}
+
+	if _, ok := om.Format.PluginCompressors[name]; !ok {
+		return disallowedCompressor{name, c.HeaderID()}
+	}
+
+	return c
+}
+
+// disallowedCompressor fails all compression attempts with an error explaining how to enable the compressor.
+type disallowedCompressor struct {
+	name     compression.Name
+	headerID compression.HeaderID
+}
+
+func (c disallowedCompressor) HeaderID() compression.HeaderID {
+	return c.headerID
+}
+
+func (c disallowedCompressor) Compress(output *bytes.Buffer, input []byte) error {
+	return errors.Errorf("compressor %q is provided by a plugin and must be enabled in the repository first", c.name)
+}
+
+func (c disallowedCompressor) Decompress(output *bytes.Buffer, input []byte) error {
+	return errors.Errorf("compressor %q is not enabled in the repository", c.name)
+}
+
 func (om *Manager) decompress(output *bytes.Buffer, b []byte) error {
 	compressorID, err := compression.IDFromHeader(b)
 	if err != nil {
@@ -367,7 +521,19 @@ func (om *Manager) decompress(output *bytes.Buffer, b []byte) error {
 	}
 
 	compressor := compression.ByHeaderID[compressorID]
+	if compressorID == compression.HeaderZstdDictionary {
+		if om.dictionaryCompressor == nil {
+			return errors.Errorf("data was compressed using a dictionary, but compression dictionaries were not loaded")
+		}
+
+		compressor = om.dictionaryCompressor
+	}
+
 	if compressor == nil {
+		if compression.IsPluginHeaderID(compressorID) {
+			return errors.Wrapf(ErrPluginCompressorMissing, "compressor %x", compressorID)
+		}
+
 		return errors.Errorf("unsupported compressor %x", compressorID)
 	}
 
@@ -5,6 +5,7 @@
 	"context"
 	cryptorand "crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -15,6 +16,7 @@
 	"runtime/debug"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
@@ -43,10 +45,15 @@ func (f *fakeContentManager) GetContent(ctx context.Context, contentID content.I
 	return nil, content.ErrContentNotFound
 }
 
-func (f *fakeContentManager) WriteContent(ctx context.Context, data []byte, prefix content.ID) (content.ID, error) {
+func (f *fakeContentManager) ComputeContentID(data []byte, prefix content.ID) (content.ID, error) {
 	h := sha256.New()
 	h.Write(data)
-	contentID := prefix + content.ID(hex.EncodeToString(h.Sum(nil)))
+
+	return prefix + content.ID(hex.EncodeToString(h.Sum(nil))), nil
+}
+
+func (f *fakeContentManager) WriteContent(ctx context.Context, data []byte, prefix content.ID) (content.ID, error) {
+	contentID, _ := f.ComputeContentID(data, prefix)
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -590,6 +597,43 @@ func TestReaderStoredBlockNotFound(t *testing.T) {
 	}
 }
 
+func TestComputeObjectID(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	for _, size := range []int{0, 1, 100, 1000000, 3000000} {
+		for _, comp := range []compression.Name{"", "gzip"} {
+			data, om := setupTest(t)
+
+			b := make([]byte, size)
+			cryptorand.Read(b)
+
+			// make the data compressible
+			copy(b[size/2:], make([]byte, size/2))
+
+			computed, err := om.ComputeObjectID(ctx, bytes.NewReader(b), WriterOptions{Compressor: comp})
+			if err != nil {
+				t.Fatalf("unable to compute object ID: %v", err)
+			}
+
+			if len(data) != 0 {
+				t.Fatalf("unexpected data written while computing object ID: %v", len(data))
+			}
+
+			w := om.NewWriter(ctx, WriterOptions{Compressor: comp})
+			w.Write(b)
+
+			written, err := w.Result()
+			if err != nil {
+				t.Fatalf("write error: %v", err)
+			}
+
+			if computed != written {
+				t.Errorf("invalid computed object ID for size %v compression %q: %v, want %v", size, comp, computed, written)
+			}
+		}
+	}
+}
+
 func TestEndToEndReadAndSeek(t *testing.T) {
 	for _, asyncWrites := range []int{0, 4, 8} {
 		asyncWrites := asyncWrites
@@ -792,3 +836,459 @@ func TestSeek(t *testing.T) {
 		}
 	}
 }
+
+func TestObjectCache(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data, om := setupTestWithData(t, map[content.ID][]byte{}, ManagerOptions{
+		MaxCachedObjectSize: 3 << 20,
+		ObjectCacheSize:     4 << 20,
+	})
+
+	small := []byte("small object")
+	partial := []byte("partially read object")
+	medium := makeMaybeCompressibleData(2500000, false)
+	large := makeMaybeCompressibleData(5000000, false)
+
+	smallID := mustWriteObject(t, om, small, "")
+	partialID := mustWriteObject(t, om, partial, "")
+	mediumID := mustWriteObject(t, om, medium, "")
+	largeID := mustWriteObject(t, om, large, "")
+
+	verifyFull(ctx, t, om, smallID, small)
+	verifyFull(ctx, t, om, mediumID, medium)
+	verifyFull(ctx, t, om, largeID, large)
+
+	// objects are cached only after they have been read in full.
+	r, err := om.Open(ctx, partialID)
+	verifyNoError(t, err)
+
+	_, err = r.Read(make([]byte, 5))
+	verifyNoError(t, err)
+	r.Close()
+
+	// remove all contents, cached objects remain readable.
+	for k := range data {
+		delete(data, k)
+	}
+
+	verify(ctx, t, om, smallID, small, "small-cached")
+	verify(ctx, t, om, mediumID, medium, "medium-cached")
+
+	if _, err := om.Open(ctx, largeID); err == nil {
+		t.Errorf("large object was unexpectedly cached")
+	}
+
+	if _, err := om.Open(ctx, partialID); err == nil {
+		t.Errorf("partially read object was unexpectedly cached")
+	}
+
+	// adding another object evicts the least recently used one.
+	other := makeMaybeCompressibleData(2000000, false)
+	otherID := mustWriteObject(t, om, other, "")
+
+	verifyFull(ctx, t, om, smallID, small)
+	verifyFull(ctx, t, om, otherID, other)
+
+	if _, err := om.Open(ctx, mediumID); err == nil {
+		t.Errorf("medium object was not evicted")
+	}
+
+	verify(ctx, t, om, smallID, small, "small-after-eviction")
+}
+
+func TestObjectCacheDisabledByDefault(t *testing.T) {
+	ctx := testlogging.Context(t)
+	data, om := setupTestWithData(t, map[content.ID][]byte{}, ManagerOptions{})
+
+	b := []byte("some object")
+	oid := mustWriteObject(t, om, b, "")
+
+	verifyFull(ctx, t, om, oid, b)
+
+	for k := range data {
+		delete(data, k)
+	}
+
+	if _, err := om.Open(ctx, oid); err == nil {
+		t.Errorf("object was cached without enabling the cache")
+	}
+}
+
+// slowContentManager delays reads and tracks the maximum number of concurrent reads.
+type slowContentManager struct {
+	*fakeContentManager
+
+	mu        sync.Mutex
+	active    int
+	maxActive int
+}
+
+func (f *slowContentManager) GetContent(ctx context.Context, contentID content.ID) ([]byte, error) {
+	f.mu.Lock()
+	f.active++
+
+	if f.active > f.maxActive {
+		f.maxActive = f.active
+	}
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.active--
+		f.mu.Unlock()
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+
+	return f.fakeContentManager.GetContent(ctx, contentID)
+}
+
+func TestReadAhead(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	for _, readAhead := range []int{-1, 1, 4} {
+		fcm := &slowContentManager{fakeContentManager: &fakeContentManager{data: map[content.ID][]byte{}}}
+
+		om, err := NewObjectManager(ctx, fcm, Format{Splitter: "FIXED-1M"}, ManagerOptions{ReadAheadChunks: readAhead})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := makeMaybeCompressibleData(20<<20+12345, false)
+		oid := mustWriteObject(t, om, data, "")
+
+		r, err := om.Open(ctx, oid)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(got, data) {
+			t.Errorf("invalid data read with read-ahead %v", readAhead)
+		}
+
+		// random seeks are still served correctly.
+		verify(ctx, t, om, oid, data, fmt.Sprintf("read-ahead-%v", readAhead))
+
+		wantMax := readAhead
+		if wantMax < 1 {
+			wantMax = 1
+		}
+
+		if fcm.maxActive > wantMax {
+			t.Errorf("too many concurrent reads with read-ahead %v: %v", readAhead, fcm.maxActive)
+		}
+
+		if readAhead > 1 && fcm.maxActive < 2 {
+			t.Errorf("chunks were not fetched in parallel with read-ahead %v", readAhead)
+		}
+	}
+}
+
+func TestReadAheadCloseAndLimit(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	fcm := &slowContentManager{fakeContentManager: &fakeContentManager{data: map[content.ID][]byte{}}}
+
+	om, err := NewObjectManager(ctx, fcm, Format{Splitter: "FIXED-1M"}, ManagerOptions{ReadAheadChunks: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// background fetches of all readers are limited.
+	om.readAheadSem = make(chan struct{}, 2)
+
+	data := makeMaybeCompressibleData(10<<20, false)
+	oid := mustWriteObject(t, om, data, "")
+
+	var readers []Reader
+
+	for i := 0; i < 3; i++ {
+		r, err := om.Open(ctx, oid)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		readers = append(readers, r)
+
+		if _, err := r.Read(make([]byte, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// closing the readers waits for their background fetches.
+	for _, r := range readers {
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fcm.mu.Lock()
+	defer fcm.mu.Unlock()
+
+	if fcm.active != 0 {
+		t.Errorf("background fetches are still running after close: %v", fcm.active)
+	}
+
+	// background fetches and the foreground read of the current reader.
+	if fcm.maxActive > 3 {
+		t.Errorf("too many concurrent reads: %v", fcm.maxActive)
+	}
+
+	if len(om.readAheadSem) != 0 {
+		t.Errorf("background fetch slots were not released: %v", len(om.readAheadSem))
+	}
+}
+
+// reheadedCompressor compresses data using gzip, but identifies it with its own HeaderID.
+type reheadedCompressor struct {
+	id compression.HeaderID
+}
+
+func (c reheadedCompressor) HeaderID() compression.HeaderID { return c.id }
+
+func (c reheadedCompressor) Compress(output *bytes.Buffer, input []byte) error {
+	var tmp bytes.Buffer
+
+	if err := compression.ByName["gzip"].Compress(&tmp, input); err != nil {
+		return err
+	}
+
+	b := tmp.Bytes()
+	binary.BigEndian.PutUint32(b, uint32(c.id))
+	output.Write(b)
+
+	return nil
+}
+
+func (c reheadedCompressor) Decompress(output *bytes.Buffer, input []byte) error {
+	b := append([]byte(nil), input...)
+	binary.BigEndian.PutUint32(b, uint32(compression.ByName["gzip"].HeaderID()))
+
+	return compression.ByName["gzip"].Decompress(output, b)
+}
+
+func TestPluginCompressors(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	const name compression.Name = "test-plugin"
+
+	id := compression.MinPluginHeaderID + 7
+	f := Format{Splitter: "FIXED-1M", PluginCompressors: map[compression.Name]compression.HeaderID{name: id}}
+	data := map[content.ID][]byte{}
+	payload := bytes.Repeat([]byte("hello"), 1000)
+
+	if _, err := NewObjectManager(ctx, &fakeContentManager{data: data}, f, ManagerOptions{}); !errors.Is(err, ErrPluginCompressorMissing) {
+		t.Fatalf("unexpected error opening repository without plugin compressor: %v", err)
+	}
+
+	if err := compression.RegisterPluginCompressor(name, reheadedCompressor{id}); err != nil {
+		t.Fatalf("unable to register compressor: %v", err)
+	}
+
+	defer func() {
+		delete(compression.ByName, name)
+		delete(compression.ByHeaderID, id)
+	}()
+
+	// repository which does not allow the compressor can't use it.
+	_, om := setupTestWithData(t, data, ManagerOptions{})
+
+	w := om.NewWriter(ctx, WriterOptions{Compressor: name})
+	w.Write(payload)
+
+	if _, err := w.Result(); err == nil {
+		t.Errorf("unexpected success writing using compressor which is not enabled")
+	}
+
+	w.Close()
+
+	om, err := NewObjectManager(ctx, &fakeContentManager{data: data}, f, ManagerOptions{})
+	if err != nil {
+		t.Fatalf("unable to open object manager: %v", err)
+	}
+
+	w = om.NewWriter(ctx, WriterOptions{Compressor: name})
+	w.Write(payload)
+
+	oid, err := w.Result()
+	if err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+
+	w.Close()
+
+	verify(ctx, t, om, oid, payload, "plugin-compressed")
+
+	// clients without the plugin fail to read the data with a clear error.
+	delete(compression.ByHeaderID, id)
+
+	_, om = setupTestWithData(t, data, ManagerOptions{})
+
+	if _, err := om.Open(ctx, oid); !errors.Is(err, ErrPluginCompressorMissing) {
+		t.Errorf("unexpected error reading data compressed by missing plugin: %v", err)
+	}
+}
+
+type countingContentManager struct {
+	fakeContentManager
+
+	writes int
+}
+
+func (f *countingContentManager) WriteContent(ctx context.Context, data []byte, prefix content.ID) (content.ID, error) {
+	f.mu.Lock()
+	f.writes++
+	f.mu.Unlock()
+
+	return f.fakeContentManager.WriteContent(ctx, data, prefix)
+}
+
+func TestDeltaWrites(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	cm := &countingContentManager{fakeContentManager: fakeContentManager{data: map[content.ID][]byte{}}}
+
+	om, err := NewObjectManager(ctx, cm, Format{Splitter: "FIXED-1M"}, ManagerOptions{})
+	if err != nil {
+		t.Fatalf("can't create object manager: %v", err)
+	}
+
+	const chunkSize = 1 << 20
+
+	data := make([]byte, 4*chunkSize)
+	cryptorand.Read(data)
+
+	write := func(opt WriterOptions, data []byte) ID {
+		t.Helper()
+
+		w := om.NewWriter(ctx, opt)
+		defer w.Close()
+
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+
+		oid, err := w.Result()
+		if err != nil {
+			t.Fatalf("result error: %v", err)
+		}
+
+		return oid
+	}
+
+	base := write(WriterOptions{Delta: true}, data)
+
+	modified := append([]byte(nil), data...)
+	modified[2*chunkSize+17]++
+
+	// only the modified chunk and the index are written.
+	cm.writes = 0
+	oid := write(WriterOptions{Delta: true, DeltaBase: base}, modified)
+	verify(ctx, t, om, oid, modified, "delta")
+
+	if got, want := cm.writes, 2; got != want {
+		t.Errorf("unexpected number of content writes: %v, want %v", got, want)
+	}
+
+	// chunks whose contents no longer exist are written again.
+	indexObjectID, _ := base.IndexObjectID()
+
+	entries, err := om.loadSeekTable(ctx, indexObjectID)
+	if err != nil {
+		t.Fatalf("unable to load seek table: %v", err)
+	}
+
+	for _, e := range entries {
+		if e.Fingerprint == "" {
+			t.Errorf("missing fingerprint of %v", e.Object)
+		}
+	}
+
+	contentID, _, _ := entries[0].Object.ContentID()
+	delete(cm.data, contentID)
+
+	cm.writes = 0
+	oid = write(WriterOptions{Delta: true, DeltaBase: base}, data)
+	verify(ctx, t, om, oid, data, "delta-missing-content")
+
+	if got, want := cm.writes, 2; got != want {
+		t.Errorf("unexpected number of content writes: %v, want %v", got, want)
+	}
+
+	// objects written without fingerprints can't be used as delta base.
+	plain := write(WriterOptions{}, data)
+
+	cm.writes = 0
+	write(WriterOptions{Delta: true, DeltaBase: plain}, modified)
+
+	if got, want := cm.writes, 5; got != want {
+		t.Errorf("unexpected number of content writes: %v, want %v", got, want)
+	}
+}
+
+type managerOpener struct {
+	om *Manager
+}
+
+func (o managerOpener) OpenObject(ctx context.Context, id ID) (Reader, error) {
+	return o.om.Open(ctx, id)
+}
+
+func TestIndirectChunks(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	_, om := setupTest(t)
+
+	contentBytes := make([]byte, 3005)
+	for i := range contentBytes {
+		contentBytes[i] = byte(i / 1000)
+	}
+
+	writer := om.NewWriter(ctx, WriterOptions{})
+	writer.(*objectWriter).splitter = splitter.Fixed(1000)()
+
+	if _, err := writer.Write(contentBytes); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+
+	oid, err := writer.Result()
+	if err != nil {
+		t.Fatalf("error getting writer results: %v", err)
+	}
+
+	chunks, err := IndirectChunks(ctx, managerOpener{om}, oid)
+	if err != nil {
+		t.Fatalf("unable to get chunks: %v", err)
+	}
+
+	if got, want := len(chunks), 4; got != want {
+		t.Fatalf("unexpected number of chunks: %v, want %v", got, want)
+	}
+
+	var offset int64
+
+	for _, c := range chunks {
+		if c.Start != offset {
+			t.Errorf("unexpected chunk start %v, want %v", c.Start, offset)
+		}
+
+		offset += c.Length
+	}
+
+	if offset != int64(len(contentBytes)) {
+		t.Errorf("unexpected total length of chunks: %v", offset)
+	}
+
+	if chunks[0].Object == chunks[1].Object || chunks[1].Object == chunks[2].Object {
+		t.Errorf("chunks with different data have the same object: %v", chunks)
+	}
+
+	if _, err := IndirectChunks(ctx, managerOpener{om}, chunks[0].Object); err == nil {
+		t.Errorf("expected error for direct object")
+	}
+}
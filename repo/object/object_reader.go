@@ -3,6 +3,7 @@
 import (
 	"context"
 	"io"
+	"sync"
 
 	"github.com/pkg/errors"
 )
@@ -23,6 +24,21 @@ type objectReader struct {
 	currentChunkIndex    int    // Index of current chunk in the seek table
 	currentChunkData     []byte // Current chunk data
 	currentChunkPosition int    // Read position in the current chunk
+
+	nextSequentialChunk int                 // Index of the chunk that follows the last opened one
+	readAhead           map[int]*chunkFetch // Chunks being fetched in the background, by index
+
+	// background fetches are canceled and awaited when the reader is closed.
+	fetchCtx    context.Context
+	cancelFetch context.CancelFunc
+	fetches     sync.WaitGroup
+}
+
+// chunkFetch is a chunk being fetched in the background.
+type chunkFetch struct {
+	done chan struct{}
+	data []byte
+	err  error
 }
 
 func (r *objectReader) Read(buffer []byte) (int, error) {
@@ -77,17 +93,41 @@ func (r *objectReader) Read(buffer []byte) (int, error) {
 }
 
 func (r *objectReader) openCurrentChunk() error {
-	st := r.seekTable[r.currentChunkIndex]
+	index := r.currentChunkIndex
+	window := r.repo.readAheadChunks
+
+	// when reading sequentially, fetch the following chunks in parallel
+	// and deliver them in order as the reader advances.
+	if window > 0 && index == r.nextSequentialChunk {
+		for i := index; i < index+window && i < len(r.seekTable); i++ {
+			r.startFetch(i)
+		}
+	}
 
-	rd, err := r.repo.openAndAssertLength(r.ctx, st.Object, st.Length)
-	if err != nil {
-		return err
+	// discard chunks outside of the window, which happens after seeking.
+	for i := range r.readAhead {
+		if i < index || i >= index+window {
+			delete(r.readAhead, i)
+		}
 	}
 
-	defer rd.Close() //nolint:errcheck
+	r.nextSequentialChunk = index + 1
 
-	b := make([]byte, st.Length)
-	if _, err := io.ReadFull(rd, b); err != nil {
+	var (
+		b   []byte
+		err error
+	)
+
+	if f, ok := r.readAhead[index]; ok {
+		delete(r.readAhead, index)
+		<-f.done
+
+		b, err = f.data, f.err
+	} else {
+		b, err = r.fetchChunk(r.ctx, index)
+	}
+
+	if err != nil {
 		return err
 	}
 
@@ -97,6 +137,56 @@ func (r *objectReader) openCurrentChunk() error {
 	return nil
 }
 
+// startFetch starts fetching the chunk with a given index in the background unless it's already in progress
+// or the limit of background fetches of the manager has been reached.
+func (r *objectReader) startFetch(index int) {
+	if _, ok := r.readAhead[index]; ok {
+		return
+	}
+
+	select {
+	case r.repo.readAheadSem <- struct{}{}:
+	default:
+		return
+	}
+
+	if r.readAhead == nil {
+		r.readAhead = map[int]*chunkFetch{}
+		r.fetchCtx, r.cancelFetch = context.WithCancel(r.ctx)
+	}
+
+	f := &chunkFetch{done: make(chan struct{})}
+	r.readAhead[index] = f
+
+	r.fetches.Add(1)
+
+	go func() {
+		defer r.fetches.Done()
+		defer func() { <-r.repo.readAheadSem }()
+		defer close(f.done)
+
+		f.data, f.err = r.fetchChunk(r.fetchCtx, index)
+	}()
+}
+
+func (r *objectReader) fetchChunk(ctx context.Context, index int) ([]byte, error) {
+	st := r.seekTable[index]
+
+	rd, err := r.repo.openAndAssertLength(ctx, st.Object, st.Length)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rd.Close() //nolint:errcheck
+
+	b := make([]byte, st.Length)
+	if _, err := io.ReadFull(rd, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
 func (r *objectReader) closeCurrentChunk() {
 	r.currentChunkData = nil
 }
@@ -166,6 +256,13 @@ func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
 }
 
 func (r *objectReader) Close() error {
+	if r.cancelFetch != nil {
+		r.cancelFetch()
+		r.fetches.Wait()
+	}
+
+	r.readAhead = nil
+
 	return nil
 }
 
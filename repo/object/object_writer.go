@@ -83,6 +83,9 @@ type objectWriter struct {
 
 	splitter splitter.Splitter
 
+	fingerprints bool                           // record fingerprints of chunks
+	deltaBase    map[string]indirectObjectEntry // chunks of the previous version of the object by fingerprint
+
 	// provides mutual exclusion of all public APIs (Write, Result, Checkpoint)
 	mu sync.Mutex
 
@@ -183,6 +186,21 @@ func (w *objectWriter) flushBuffer() error {
 }
 
 func (w *objectWriter) prepareAndWriteContentChunk(chunkID int, data []byte) error {
+	var fingerprint string
+
+	if w.fingerprints {
+		fingerprint = chunkFingerprint(data)
+
+		if oid, ok := w.reusableChunk(fingerprint, len(data)); ok {
+			w.indirectIndexGrowMutex.Lock()
+			w.indirectIndex[chunkID].Object = oid
+			w.indirectIndex[chunkID].Fingerprint = fingerprint
+			w.indirectIndexGrowMutex.Unlock()
+
+			return nil
+		}
+	}
+
 	// allocate buffer to hold either compressed bytes or the uncompressed
 	b := w.om.bufferPool.Allocate(len(data) + maxCompressionOverheadPerSegment)
 	defer b.Release()
@@ -201,6 +219,7 @@ func (w *objectWriter) prepareAndWriteContentChunk(chunkID int, data []byte) err
 	// update index under a lock
 	w.indirectIndexGrowMutex.Lock()
 	w.indirectIndex[chunkID].Object = maybeCompressedObjectID(contentID, isCompressed)
+	w.indirectIndex[chunkID].Fingerprint = fingerprint
 	w.indirectIndexGrowMutex.Unlock()
 
 	return nil
@@ -330,4 +349,13 @@ type WriterOptions struct {
 	Prefix      content.ID // empty string or a single-character ('g'..'z')
 	Compressor  compression.Name
 	AsyncWrites int // allow up to N content writes to be asynchronous
+
+	// Delta enables recording fingerprints of chunks, so that writers of later versions of the object
+	// can pass it as DeltaBase.
+	Delta bool
+
+	// DeltaBase is a previous version of the object written with Delta, whose chunks are reused
+	// when the new version contains chunks with identical fingerprints. Every chunk is still fingerprinted,
+	// reused chunks are not compressed, hashed with the content hash, encrypted and written again.
+	DeltaBase ID
 }
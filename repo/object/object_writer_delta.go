@@ -0,0 +1,65 @@
+package object
+
+import (
+	"context"
+	"encoding/hex"
+
+	"github.com/zeebo/blake3"
+)
+
+// fingerprintLength is the length of chunk fingerprints in bytes.
+const fingerprintLength = 16
+
+// chunkFingerprint returns the fingerprint of chunk data, which is computed for every chunk and is much cheaper
+// than compressing, hashing and encrypting the chunk, which is skipped for chunks reused from the delta base.
+func chunkFingerprint(data []byte) string {
+	h := blake3.New()
+	h.Write(data) //nolint:errcheck
+
+	return hex.EncodeToString(h.Sum(nil)[:fingerprintLength])
+}
+
+// loadDeltaBase returns the fingerprinted chunks of the provided object keyed by fingerprint.
+// Delta uploads are an optimization, so objects that can't be loaded are only logged.
+func (om *Manager) loadDeltaBase(ctx context.Context, base ID) map[string]indirectObjectEntry {
+	indexObjectID, ok := base.IndexObjectID()
+	if !ok {
+		return nil
+	}
+
+	entries, err := om.loadSeekTable(ctx, indexObjectID)
+	if err != nil {
+		log(ctx).Debugf("unable to load delta base %v: %v", base, err)
+		return nil
+	}
+
+	result := map[string]indirectObjectEntry{}
+
+	for _, e := range entries {
+		if e.Fingerprint != "" {
+			result[e.Fingerprint] = e
+		}
+	}
+
+	return result
+}
+
+// reusableChunk returns the ID of the chunk of the delta base with the provided fingerprint and length,
+// as long as its content still exists.
+func (w *objectWriter) reusableChunk(fingerprint string, length int) (ID, bool) {
+	e, ok := w.deltaBase[fingerprint]
+	if !ok || e.Length != int64(length) {
+		return "", false
+	}
+
+	contentID, _, ok := e.Object.ContentID()
+	if !ok {
+		return "", false
+	}
+
+	if ci, err := w.om.contentMgr.ContentInfo(w.ctx, contentID); err != nil || ci.Deleted {
+		return "", false
+	}
+
+	return e.Object, true
+}
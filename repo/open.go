@@ -14,7 +14,11 @@
 
 	"github.com/kopia/kopia/repo/blob"
 	loggingwrapper "github.com/kopia/kopia/repo/blob/logging"
+	metricswrapper "github.com/kopia/kopia/repo/blob/metrics"
 	"github.com/kopia/kopia/repo/blob/readonly"
+	"github.com/kopia/kopia/repo/blob/staging"
+	"github.com/kopia/kopia/repo/blob/throttling"
+	"github.com/kopia/kopia/repo/compression"
 	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/repo/logging"
 	"github.com/kopia/kopia/repo/manifest"
@@ -49,6 +53,12 @@ type Options struct {
 	TraceStorage         func(f string, args ...interface{}) // Logs all storage access using provided Printf-style function
 	ObjectManagerOptions object.ManagerOptions
 	TimeNowFunc          func() time.Time // Time provider
+	VerifyCriticalWrites bool             // Read back index, format and metadata blobs after upload
+	BackgroundPrefetch   bool             // Prefetch indexes, manifests and recent metadata in the background
+	LowMemory            bool             // Reduce memory usage at the expense of performance, for devices with little RAM
+	UploadConcurrency    int              // Number of packs uploaded in parallel in the background (0 = upload synchronously)
+	UploadMemoryBudget   int64            // Maximum total size of packs being uploaded in the background
+	EagerIndexCompaction bool             // Merge small index blobs whenever indexes are flushed
 }
 
 // ErrInvalidPassword is returned when repository password is invalid.
@@ -77,7 +87,16 @@ func Open(ctx context.Context, configFile, password string, options *Options) (r
 	}
 
 	if lc.APIServer != nil {
-		return openAPIServer(ctx, lc.APIServer, lc.ClientOptions, password)
+		var cacheDir string
+
+		if lc.Caching != nil && lc.Caching.CacheDirectory != "" {
+			cacheDir = lc.Caching.CacheDirectory
+			if !filepath.IsAbs(cacheDir) {
+				cacheDir = filepath.Join(filepath.Dir(configFile), cacheDir)
+			}
+		}
+
+		return openAPIServer(ctx, lc.APIServer, lc.ClientOptions, cacheDir, password)
 	}
 
 	return openDirect(ctx, configFile, lc, password, options)
@@ -98,6 +117,42 @@ func openDirect(ctx context.Context, configFile string, lc *LocalConfig, passwor
 		return nil, errors.Wrap(err, "cannot open storage")
 	}
 
+	st = metricswrapper.NewWrapper(st)
+
+	throttler := throttling.NewWrapper(st)
+	st = throttler
+
+	// staged blobs are uploaded through the throttler, which limits their bandwidth.
+	var stager staging.Storage
+
+	if lc.Staging != nil {
+		opt := *lc.Staging
+		if !filepath.IsAbs(opt.Directory) {
+			opt.Directory = filepath.Join(filepath.Dir(configFile), opt.Directory)
+		}
+
+		// only packs are staged, indexes, manifests and other blobs are written directly after the packs
+		// they reference have been uploaded, so that they become visible to other clients immediately.
+		opt.StagedPrefixes = content.PackBlobIDPrefixes
+
+		stager, err = staging.NewWrapper(ctx, st, opt)
+
+		switch {
+		case errors.Is(err, staging.ErrDirectoryInUse):
+			// the staging directory is used by another process, such as a long-running snapshot.
+			log(ctx).Warningf("staging directory %v is in use by another process, writing directly to the storage", opt.Directory)
+
+			stager = nil
+
+		case err != nil:
+			st.Close(ctx) //nolint:errcheck
+			return nil, errors.Wrap(err, "unable to open staging directory")
+
+		default:
+			st = stager
+		}
+	}
+
 	if options.TraceStorage != nil {
 		st = loggingwrapper.NewWrapper(st, options.TraceStorage, "[STORAGE] ")
 	}
@@ -114,6 +169,16 @@ func openDirect(ctx context.Context, configFile string, lc *LocalConfig, passwor
 
 	r.cliOpts = lc.ClientOptions.ApplyDefaults(ctx, "Repository in "+st.DisplayName())
 	r.ConfigFile = configFile
+	r.throttler = throttler
+
+	if stager != nil {
+		r.stager = stager
+	}
+
+	// prefetching would load all indexes, which defeats lazy index loading in low-memory mode.
+	if options.BackgroundPrefetch && !options.LowMemory {
+		r.StartBackgroundPrefetch(ctx)
+	}
 
 	return r, nil
 }
@@ -121,6 +186,13 @@ func openDirect(ctx context.Context, configFile string, lc *LocalConfig, passwor
 // OpenWithConfig opens the repository with a given configuration, avoiding the need for a config file.
 func OpenWithConfig(ctx context.Context, st blob.Storage, lc *LocalConfig, password string, options *Options, caching *content.CachingOptions) (*DirectRepository, error) {
 	caching = caching.CloneOrDefault()
+	omOpts := options.ObjectManagerOptions
+
+	applyNetworkFilesystemOptions(ctx, caching)
+
+	if options.LowMemory {
+		applyLowMemoryOptions(ctx, caching, &omOpts)
+	}
 
 	// Read format blob, potentially from cache.
 	fb, err := readAndCacheFormatBlobBytes(ctx, st, caching.CacheDirectory)
@@ -137,6 +209,14 @@ func OpenWithConfig(ctx context.Context, st blob.Storage, lc *LocalConfig, passw
 		return nil, errors.Wrap(err, "can't parse format blob")
 	}
 
+	if err = verifyNamespace(st, f); err != nil {
+		return nil, err
+	}
+
+	if err = verifyFormatVersion(f); err != nil {
+		return nil, err
+	}
+
 	fb, err = addFormatBlobChecksumAndLength(fb)
 	if err != nil {
 		return nil, errors.Errorf("unable to add checksum")
@@ -164,6 +244,11 @@ func OpenWithConfig(ctx context.Context, st blob.Storage, lc *LocalConfig, passw
 	cmOpts := content.ManagerOptions{
 		RepositoryFormatBytes: fb,
 		TimeNow:               defaultTime(options.TimeNowFunc),
+		VerifyCriticalWrites:  options.VerifyCriticalWrites,
+		LowMemory:             options.LowMemory,
+		UploadConcurrency:     options.UploadConcurrency,
+		UploadMemoryBudget:    options.UploadMemoryBudget,
+		EagerIndexCompaction:  options.EagerIndexCompaction,
 	}
 
 	cm, err := content.NewManager(ctx, st, fo, caching, cmOpts)
@@ -171,16 +256,32 @@ func OpenWithConfig(ctx context.Context, st blob.Storage, lc *LocalConfig, passw
 		return nil, errors.Wrap(err, "unable to open content manager")
 	}
 
-	om, err := object.NewObjectManager(ctx, cm, repoConfig.Format, options.ObjectManagerOptions)
+	om, err := object.NewObjectManager(ctx, cm, repoConfig.Format, omOpts)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to open object manager")
 	}
 
-	manifests, err := manifest.NewManager(ctx, cm, manifest.ManagerOptions{TimeNow: cmOpts.TimeNow})
+	manifests, err := manifest.NewManager(ctx, cm, manifest.ManagerOptions{
+		TimeNow:    cmOpts.TimeNow,
+		Compressor: repoConfig.ManifestCompression,
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to open manifests")
 	}
 
+	var dicts []compression.ZstdDictionary
+
+	if ids := repoConfig.Format.ZstdDictionaryIDs; len(ids) > 0 {
+		dicts, err = loadZstdDictionaries(ctx, st, masterKey, f.UniqueID, ids)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := om.SetZstdDictionaries(dicts); err != nil {
+			return nil, err
+		}
+	}
+
 	dr := &DirectRepository{
 		Content:   cm,
 		Objects:   om,
@@ -192,6 +293,9 @@ func OpenWithConfig(ctx context.Context, st blob.Storage, lc *LocalConfig, passw
 		masterKey:  masterKey,
 		timeNow:    cmOpts.TimeNow,
 
+		verifyCriticalWrites: options.VerifyCriticalWrites,
+		zstdDictionaries:     dicts,
+
 		closed: make(chan struct{}),
 	}
 
@@ -229,6 +333,22 @@ func writeCacheMarker(cacheDir string) error {
 	return f.Close()
 }
 
+// CachingConfig returns caching configuration stored in the configuration file, without adjustments
+// made when opening the repository, such as those for low-memory mode or network filesystems.
+func (r *DirectRepository) CachingConfig() (*content.CachingOptions, error) {
+	lc, err := loadConfigFromFile(r.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	opt := lc.Caching.CloneOrDefault()
+	if opt.CacheDirectory != "" && !filepath.IsAbs(opt.CacheDirectory) {
+		opt.CacheDirectory = filepath.Join(filepath.Dir(r.ConfigFile), opt.CacheDirectory)
+	}
+
+	return opt, nil
+}
+
 // SetCachingConfig changes caching configuration for a given repository.
 func (r *DirectRepository) SetCachingConfig(ctx context.Context, opt *content.CachingOptions) error {
 	lc, err := loadConfigFromFile(r.ConfigFile)
@@ -267,7 +387,7 @@ func readAndCacheFormatBlobBytes(ctx context.Context, st blob.Storage, cacheDire
 		}
 	}
 
-	b, err := st.GetBlob(ctx, FormatBlobID, 0, -1)
+	b, err := readFormatBlobBytes(ctx, st)
 	if err != nil {
 		return nil, err
 	}
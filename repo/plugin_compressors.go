@@ -0,0 +1,80 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/repo/content"
+)
+
+// EnablePluginCompressor allows data in the repository to be compressed using the provided compressor,
+// which must be registered by a plugin. Once enabled, clients that don't have the compressor registered
+// will fail to open the repository instead of failing to read individual objects.
+func (r *DirectRepository) EnablePluginCompressor(ctx context.Context, name compression.Name) error {
+	c := compression.ByName[name]
+	if c == nil {
+		return errors.Errorf("unknown compressor %q", name)
+	}
+
+	if !compression.IsPluginHeaderID(c.HeaderID()) {
+		return errors.Errorf("compressor %q is not provided by a plugin", name)
+	}
+
+	repoConfig, err := r.formatBlob.decryptFormatBytes(r.masterKey)
+	if err != nil {
+		return errors.Wrap(err, "unable to decrypt repository config")
+	}
+
+	if id, ok := repoConfig.Format.PluginCompressors[name]; ok {
+		if id != c.HeaderID() {
+			return errors.Errorf("compressor %q is already enabled with a different HeaderID %x", name, id)
+		}
+
+		log(ctx).Infof("compressor %v is already enabled", name)
+
+		return nil
+	}
+
+	for n, id := range repoConfig.Format.PluginCompressors {
+		if id == c.HeaderID() {
+			return errors.Errorf("HeaderID %x is already used by compressor %q", id, n)
+		}
+	}
+
+	repoConfig.Format.PluginCompressors = withPluginCompressor(repoConfig.Format.PluginCompressors, name, c.HeaderID())
+
+	// versions of kopia that don't know about plugin compressors must refuse to open the repository.
+	requireFormatVersion(ctx, repoConfig, content.FormatVersion2)
+
+	if err := r.writeUpdatedFormatBlob(ctx, repoConfig); err != nil {
+		return err
+	}
+
+	r.Objects.Format.PluginCompressors = withPluginCompressor(r.Objects.Format.PluginCompressors, name, c.HeaderID())
+
+	return nil
+}
+
+func withPluginCompressor(m map[compression.Name]compression.HeaderID, name compression.Name, id compression.HeaderID) map[compression.Name]compression.HeaderID {
+	result := map[compression.Name]compression.HeaderID{}
+
+	for k, v := range m {
+		result[k] = v
+	}
+
+	result[name] = id
+
+	return result
+}
+
+// pluginCompressorsFromOptions returns plugin compressors that must be enabled in a new repository.
+func pluginCompressorsFromOptions(opt *NewRepositoryOptions) map[compression.Name]compression.HeaderID {
+	c := compression.ByName[opt.ManifestCompression]
+	if c == nil || !compression.IsPluginHeaderID(c.HeaderID()) {
+		return nil
+	}
+
+	return withPluginCompressor(nil, opt.ManifestCompression, c.HeaderID())
+}
@@ -0,0 +1,53 @@
+package repo_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/repo/content"
+)
+
+type fakePluginCompressor struct{}
+
+func (fakePluginCompressor) HeaderID() compression.HeaderID { return compression.MinPluginHeaderID + 1 }
+
+func (fakePluginCompressor) Compress(output *bytes.Buffer, input []byte) error {
+	_, err := output.Write(input)
+	return err
+}
+
+func (fakePluginCompressor) Decompress(output *bytes.Buffer, input []byte) error {
+	_, err := output.Write(input)
+	return err
+}
+
+func TestEnablePluginCompressorRequiresFormatVersion(t *testing.T) {
+	const name compression.Name = "test-repo-plugin"
+
+	require.NoError(t, compression.RegisterPluginCompressor(name, fakePluginCompressor{}))
+
+	defer func() {
+		delete(compression.ByName, name)
+		delete(compression.ByHeaderID, fakePluginCompressor{}.HeaderID())
+	}()
+
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+
+	defer env.Setup(t).Close(ctx, t)
+
+	require.Equal(t, content.FormatVersion1, env.Repository.Content.Format.Version)
+	require.NoError(t, env.Repository.EnablePluginCompressor(ctx, name))
+
+	// versions of kopia that don't know about plugin compressors refuse to open the repository.
+	env.MustReopen(t)
+
+	require.Equal(t, content.FormatVersion2, env.Repository.Content.Format.Version)
+	require.Equal(t, fakePluginCompressor{}.HeaderID(), env.Repository.Objects.Format.PluginCompressors[name])
+}
@@ -0,0 +1,94 @@
+package repo
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/ctxutil"
+)
+
+// maxPrefetchMetadataBytes limits the total size of recent metadata blobs fetched by Prefetch.
+const maxPrefetchMetadataBytes = 100 << 20
+
+// connectPrefetches tracks prefetches started after connecting, which outlive Connect().
+var connectPrefetches sync.WaitGroup
+
+// Prefetch warms up local caches by loading committed indexes, manifests of sources owned by the
+// current user and recent metadata blobs, which are needed by most operations, such as listing
+// or creating snapshots.
+func (r *DirectRepository) Prefetch(ctx context.Context) error {
+	t0 := clock.Now()
+
+	if err := r.Content.PrefetchIndexes(ctx); err != nil {
+		return errors.Wrap(err, "unable to prefetch indexes")
+	}
+
+	// finding manifests loads all committed manifests.
+	if _, err := r.Manifests.Find(ctx, map[string]string{
+		"hostname": r.Hostname(),
+		"username": r.Username(),
+	}); err != nil {
+		return errors.Wrap(err, "unable to prefetch manifests")
+	}
+
+	maxBytes := r.Content.CachingOptions.MaxMetadataCacheSizeBytes
+	if maxBytes > maxPrefetchMetadataBytes {
+		maxBytes = maxPrefetchMetadataBytes
+	}
+
+	if err := r.Content.PrefetchRecentMetadata(ctx, maxBytes); err != nil {
+		return errors.Wrap(err, "unable to prefetch metadata")
+	}
+
+	log(ctx).Debugf("prefetch completed in %v", clock.Since(t0))
+
+	return nil
+}
+
+// StartBackgroundPrefetch starts prefetching in the background, which is stopped when the repository is closed.
+func (r *DirectRepository) StartBackgroundPrefetch(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctxutil.Detach(ctx))
+	done := make(chan struct{})
+
+	r.stopPrefetch = func() {
+		cancel()
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+
+		if err := r.Prefetch(ctx); err != nil && ctx.Err() == nil {
+			log(ctx).Warningf("unable to prefetch repository data: %v", err)
+		}
+	}()
+}
+
+// startPrefetchAfterConnect prefetches the newly connected repository in the background, using its own
+// connection, so that connecting does not wait for it. The prefetch is abandoned if the process exits first.
+func startPrefetchAfterConnect(ctx context.Context, configFile, password string) {
+	ctx = ctxutil.Detach(ctx)
+
+	connectPrefetches.Add(1)
+
+	go func() {
+		defer connectPrefetches.Done()
+
+		r, err := Open(ctx, configFile, password, nil)
+		if err != nil {
+			log(ctx).Warningf("unable to open repository for prefetch: %v", err)
+			return
+		}
+
+		defer r.Close(ctx) //nolint:errcheck
+
+		if dr, ok := r.(*DirectRepository); ok {
+			if err := dr.Prefetch(ctx); err != nil {
+				log(ctx).Warningf("unable to prefetch repository data: %v", err)
+			}
+		}
+	}()
+}
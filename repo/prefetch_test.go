@@ -0,0 +1,85 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob/filesystem"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/object"
+)
+
+func TestPrefetchAfterConnect(t *testing.T) {
+	ctx := testlogging.Context(t)
+	configFile := filepath.Join(t.TempDir(), "repo.config")
+	cacheDir := t.TempDir()
+
+	st, err := filesystem.New(ctx, &filesystem.Options{Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Initialize(ctx, st, &NewRepositoryOptions{}, "password"); err != nil {
+		t.Fatalf("unable to initialize: %v", err)
+	}
+
+	if err = Connect(ctx, configFile, st, "password", nil); err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+
+	r, err := Open(ctx, configFile, "password", nil)
+	if err != nil {
+		t.Fatalf("unable to open: %v", err)
+	}
+
+	w := r.NewObjectWriter(ctx, object.WriterOptions{Prefix: "k"})
+	if _, err = w.Write([]byte("metadata")); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+
+	if _, err = w.Result(); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+
+	if err = r.Close(ctx); err != nil {
+		t.Fatalf("unable to close: %v", err)
+	}
+
+	if err = Disconnect(ctx, configFile); err != nil {
+		t.Fatalf("unable to disconnect: %v", err)
+	}
+
+	if err = Connect(ctx, configFile, st, "password", &ConnectOptions{
+		Prefetch: true,
+		CachingOptions: content.CachingOptions{
+			CacheDirectory:            cacheDir,
+			MaxCacheSizeBytes:         1e6,
+			MaxMetadataCacheSizeBytes: 1e6,
+		},
+	}); err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+
+	// prefetch continues in the background after connecting.
+	connectPrefetches.Wait()
+
+	var packs int
+
+	if err := filepath.Walk(filepath.Join(cacheDir, "metadata"), func(path string, info os.FileInfo, err error) error {
+		// cache directory is sharded by the first characters of blob IDs and also contains index blobs.
+		if err == nil && !info.IsDir() && strings.HasPrefix(filepath.Base(filepath.Dir(path)), string(content.PackBlobIDPrefixSpecial)) {
+			packs++
+		}
+
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if packs == 0 {
+		t.Errorf("no metadata prefetched into cache")
+	}
+}
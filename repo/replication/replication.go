@@ -0,0 +1,506 @@
+// Package replication incrementally copies blobs of a repository to a secondary storage.
+//
+// Blobs copied to the destination are recorded in a local state file, so that subsequent runs only need
+// to list the source and copy blobs which were added or changed since, and interrupted runs resume where
+// they left off. Pack blobs are copied before index blobs which reference them and the format blob is
+// copied last, so that the destination never contains indexes pointing at missing packs.
+package replication
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/natefinch/atomic"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/throttling"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/logging"
+)
+
+var log = logging.GetContextLoggerFunc("kopia/replication")
+
+// Supported verification modes.
+const (
+	// VerifyNone trusts the destination to store blobs that were successfully written.
+	VerifyNone = "none"
+
+	// VerifyLength checks that the length of each copied blob in the destination matches the source.
+	VerifyLength = "length"
+
+	// VerifyFull reads back each copied blob and compares its contents with the source.
+	VerifyFull = "full"
+)
+
+const (
+	defaultParallel          = 4
+	defaultStateSaveInterval = 30 * time.Second
+)
+
+// ErrIncompatibleDestination is returned when the destination contains a different repository.
+var ErrIncompatibleDestination = errors.New("destination contains a different repository")
+
+// Options provides options for Replicate.
+type Options struct {
+	// StateFile is the path of the file in which blobs copied to the destination are recorded.
+	// When empty, the destination is listed on each run.
+	StateFile string
+
+	// Parallel is the number of blobs copied in parallel.
+	Parallel int
+
+	// Delete causes blobs previously replicated to the destination which no longer exist in the source to be deleted.
+	Delete bool
+
+	// Verify is the verification mode of copied blobs, one of VerifyNone, VerifyLength (default) or VerifyFull.
+	Verify string
+
+	// DryRun causes blobs to be compared without copying or deleting them.
+	DryRun bool
+
+	// MaxUploadBytesPerSecond and MaxDownloadBytesPerSecond limit the bandwidth used, zero means unlimited.
+	MaxUploadBytesPerSecond   int64
+	MaxDownloadBytesPerSecond int64
+
+	// StateSaveInterval is the frequency of saving the state while copying.
+	StateSaveInterval time.Duration
+
+	// Progress, if provided, is invoked after each blob is copied or deleted.
+	Progress func(s Stats)
+}
+
+// Stats describes the outcome of replication.
+type Stats struct {
+	SourceBlobs  int   `json:"sourceBlobs"`
+	SourceBytes  int64 `json:"sourceBytes"`
+	InSyncBlobs  int   `json:"inSyncBlobs"`
+	ToCopyBlobs  int   `json:"toCopyBlobs"`
+	ToCopyBytes  int64 `json:"toCopyBytes"`
+	CopiedBlobs  int   `json:"copiedBlobs"`
+	CopiedBytes  int64 `json:"copiedBytes"`
+	ToDelete     int   `json:"toDeleteBlobs"`
+	DeletedBlobs int   `json:"deletedBlobs"`
+}
+
+// State is the persistent state of replication to a single destination.
+type State struct {
+	// Destination identifies the destination storage the state describes.
+	Destination string `json:"destination"`
+
+	// Blobs maps IDs of blobs known to exist in the destination to the source metadata they were copied with.
+	Blobs map[blob.ID]BlobState `json:"blobs"`
+
+	// LastCompleted is the time when the last replication run completed successfully.
+	LastCompleted time.Time `json:"lastCompleted,omitempty"`
+}
+
+// BlobState describes a blob replicated to the destination.
+type BlobState struct {
+	Length    int64     `json:"length"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// DestinationID returns the identifier of the destination storage based on its connection info.
+func DestinationID(dst blob.Storage) string {
+	b, _ := json.Marshal(dst.ConnectionInfo())
+	h := sha256.Sum256(b)
+
+	return hex.EncodeToString(h[0:16])
+}
+
+// LoadState loads the state of replication from the provided file. Empty state is returned when
+// the file does not exist or describes another destination.
+func LoadState(fname, destinationID string) (*State, error) {
+	st := &State{Destination: destinationID, Blobs: map[blob.ID]BlobState{}}
+
+	if fname == "" {
+		return st, nil
+	}
+
+	f, err := os.Open(fname) //nolint:gosec
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open replication state")
+	}
+
+	defer f.Close() //nolint:errcheck
+
+	var loaded State
+
+	if err := json.NewDecoder(f).Decode(&loaded); err != nil {
+		return nil, errors.Wrap(err, "invalid replication state")
+	}
+
+	if loaded.Destination != destinationID || loaded.Blobs == nil {
+		return st, nil
+	}
+
+	return &loaded, nil
+}
+
+func saveState(fname string, st *State) error {
+	if fname == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(st)
+	if err != nil {
+		return errors.Wrap(err, "unable to serialize replication state")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fname), 0o700); err != nil {
+		return errors.Wrap(err, "unable to create replication state directory")
+	}
+
+	return errors.Wrap(atomic.WriteFile(fname, strings.NewReader(string(b))), "unable to write replication state")
+}
+
+type replicator struct {
+	src, dst blob.Storage
+	opt      Options
+
+	mu        sync.Mutex
+	state     *State
+	stats     Stats
+	lastSaved time.Time
+}
+
+// Replicate copies blobs which were added or changed since the last run from src to dst.
+func Replicate(ctx context.Context, src, dst blob.Storage, opt Options) (*Stats, error) {
+	if opt.Parallel <= 0 {
+		opt.Parallel = defaultParallel
+	}
+
+	if opt.Verify == "" {
+		opt.Verify = VerifyLength
+	}
+
+	if opt.StateSaveInterval == 0 {
+		opt.StateSaveInterval = defaultStateSaveInterval
+	}
+
+	state, err := LoadState(opt.StateFile, DestinationID(dst))
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.MaxDownloadBytesPerSecond > 0 {
+		t := throttling.NewWrapper(src)
+		t.SetLimits(throttling.Limits{DownloadBytesPerSecond: opt.MaxDownloadBytesPerSecond})
+		src = t
+	}
+
+	if opt.MaxUploadBytesPerSecond > 0 {
+		t := throttling.NewWrapper(dst)
+		t.SetLimits(throttling.Limits{UploadBytesPerSecond: opt.MaxUploadBytesPerSecond})
+		dst = t
+	}
+
+	r := &replicator{src: src, dst: dst, opt: opt, state: state, lastSaved: clock.Now()}
+
+	if err := r.run(ctx); err != nil {
+		// save progress made so far, so that the next run resumes where this one left off.
+		if serr := r.saveState(); serr != nil {
+			log(ctx).Warningf("unable to save replication state: %v", serr)
+		}
+
+		return &r.stats, err
+	}
+
+	return &r.stats, nil
+}
+
+func (r *replicator) run(ctx context.Context) error {
+	if err := r.checkFormatBlob(ctx); err != nil {
+		return err
+	}
+
+	if len(r.state.Blobs) == 0 {
+		// no state, seed it with blobs already present in the destination.
+		if err := r.seedStateFromDestination(ctx); err != nil {
+			return err
+		}
+	}
+
+	toCopy, toDelete, err := r.compare(ctx)
+	if err != nil {
+		return err
+	}
+
+	if r.opt.DryRun {
+		return nil
+	}
+
+	// copy pack blobs first, then indexes and other metadata referencing them and finally the format blob.
+	for _, phase := range splitByPhase(toCopy) {
+		if err := r.parallel(ctx, phase, r.copyBlob); err != nil {
+			return err
+		}
+	}
+
+	// delete index blobs before pack blobs they reference.
+	phases := splitByPhase(toDelete)
+	for i := len(phases) - 1; i >= 0; i-- {
+		if err := r.parallel(ctx, phases[i], r.deleteBlob); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	r.state.LastCompleted = clock.Now()
+	r.mu.Unlock()
+
+	return r.saveState()
+}
+
+// checkFormatBlob verifies that the destination is empty or contains the same repository as the source.
+func (r *replicator) checkFormatBlob(ctx context.Context) error {
+	srcData, err := r.src.GetBlob(ctx, repo.FormatBlobID, 0, -1)
+	if err != nil {
+		return errors.Wrap(err, "error reading format blob")
+	}
+
+	dstData, err := r.dst.GetBlob(ctx, repo.FormatBlobID, 0, -1)
+	if errors.Is(err, blob.ErrBlobNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "error reading destination format blob")
+	}
+
+	if string(srcData) != string(dstData) {
+		return ErrIncompatibleDestination
+	}
+
+	return nil
+}
+
+func (r *replicator) seedStateFromDestination(ctx context.Context) error {
+	log(ctx).Debugf("listing destination blobs")
+
+	return errors.Wrap(r.dst.ListBlobs(ctx, "", func(bm blob.Metadata) error {
+		r.state.Blobs[bm.BlobID] = BlobState{Length: bm.Length, Timestamp: bm.Timestamp}
+		return nil
+	}), "error listing destination blobs")
+}
+
+// compare lists the source and returns blobs which must be copied to or deleted from the destination.
+func (r *replicator) compare(ctx context.Context) (toCopy, toDelete []blob.Metadata, err error) {
+	seen := map[blob.ID]bool{}
+
+	if err := r.src.ListBlobs(ctx, "", func(bm blob.Metadata) error {
+		seen[bm.BlobID] = true
+		r.stats.SourceBlobs++
+		r.stats.SourceBytes += bm.Length
+
+		if isReplicated(bm, r.state.Blobs[bm.BlobID]) {
+			r.stats.InSyncBlobs++
+			return nil
+		}
+
+		toCopy = append(toCopy, bm)
+		r.stats.ToCopyBlobs++
+		r.stats.ToCopyBytes += bm.Length
+
+		return nil
+	}); err != nil {
+		return nil, nil, errors.Wrap(err, "error listing source blobs")
+	}
+
+	if r.opt.Delete {
+		for id, bs := range r.state.Blobs {
+			if !seen[id] {
+				toDelete = append(toDelete, blob.Metadata{BlobID: id, Length: bs.Length, Timestamp: bs.Timestamp})
+			}
+		}
+
+		r.stats.ToDelete = len(toDelete)
+	}
+
+	return toCopy, toDelete, nil
+}
+
+// isReplicated determines whether the blob has been copied to the destination and has not changed since.
+func isReplicated(bm blob.Metadata, bs BlobState) bool {
+	if bs.Timestamp.IsZero() || bs.Length != bm.Length {
+		return false
+	}
+
+	return !bm.Timestamp.After(bs.Timestamp)
+}
+
+// splitByPhase splits the blobs into pack blobs, other blobs and the format blob with its replicas, each sorted by ID.
+func splitByPhase(blobs []blob.Metadata) [][]blob.Metadata {
+	var packs, other, format []blob.Metadata
+
+	for _, bm := range blobs {
+		switch {
+		case repo.IsFormatBlobID(bm.BlobID):
+			format = append(format, bm)
+		case isPackBlob(bm.BlobID):
+			packs = append(packs, bm)
+		default:
+			other = append(other, bm)
+		}
+	}
+
+	result := [][]blob.Metadata{packs, other, format}
+
+	for _, p := range result {
+		p := p
+		sort.Slice(p, func(i, j int) bool { return p[i].BlobID < p[j].BlobID })
+	}
+
+	return result
+}
+
+func isPackBlob(id blob.ID) bool {
+	for _, prefix := range content.PackBlobIDPrefixes {
+		if strings.HasPrefix(string(id), string(prefix)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *replicator) parallel(ctx context.Context, blobs []blob.Metadata, cb func(ctx context.Context, bm blob.Metadata) error) error {
+	eg, ctx := errgroup.WithContext(ctx)
+	ch := make(chan blob.Metadata)
+
+	eg.Go(func() error {
+		defer close(ch)
+
+		for _, bm := range blobs {
+			select {
+			case ch <- bm:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	for i := 0; i < r.opt.Parallel; i++ {
+		eg.Go(func() error {
+			for bm := range ch {
+				if err := cb(ctx, bm); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+
+	return eg.Wait()
+}
+
+func (r *replicator) copyBlob(ctx context.Context, bm blob.Metadata) error {
+	data, err := r.src.GetBlob(ctx, bm.BlobID, 0, -1)
+	if errors.Is(err, blob.ErrBlobNotFound) {
+		// blob was deleted from the source after listing.
+		log(ctx).Debugf("ignoring blob not found in source: %v", bm.BlobID)
+		return nil
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "error reading %v from source", bm.BlobID)
+	}
+
+	if err := r.putBlob(ctx, bm.BlobID, data); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.state.Blobs[bm.BlobID] = BlobState{Length: int64(len(data)), Timestamp: bm.Timestamp}
+	r.stats.CopiedBlobs++
+	r.stats.CopiedBytes += int64(len(data))
+	r.mu.Unlock()
+
+	return r.progress()
+}
+
+func (r *replicator) putBlob(ctx context.Context, id blob.ID, data []byte) error {
+	switch r.opt.Verify {
+	case VerifyFull:
+		return errors.Wrapf(blob.PutBlobAndVerify(ctx, r.dst, id, gather.FromSlice(data), blob.PutOptions{}), "error writing %v to destination", id)
+
+	case VerifyLength:
+		if err := r.dst.PutBlob(ctx, id, gather.FromSlice(data), blob.PutOptions{}); err != nil {
+			return errors.Wrapf(err, "error writing %v to destination", id)
+		}
+
+		md, err := r.dst.GetMetadata(ctx, id)
+		if err != nil {
+			return errors.Wrapf(blob.ErrWriteVerificationFailed, "unable to get metadata of %v: %v", id, err)
+		}
+
+		if md.Length != int64(len(data)) {
+			return errors.Wrapf(blob.ErrWriteVerificationFailed, "%v has length %v, expected %v", id, md.Length, len(data))
+		}
+
+		return nil
+
+	default:
+		return errors.Wrapf(r.dst.PutBlob(ctx, id, gather.FromSlice(data), blob.PutOptions{}), "error writing %v to destination", id)
+	}
+}
+
+func (r *replicator) deleteBlob(ctx context.Context, bm blob.Metadata) error {
+	if err := r.dst.DeleteBlob(ctx, bm.BlobID); err != nil && !errors.Is(err, blob.ErrBlobNotFound) {
+		return errors.Wrapf(err, "error deleting %v from destination", bm.BlobID)
+	}
+
+	r.mu.Lock()
+	delete(r.state.Blobs, bm.BlobID)
+	r.stats.DeletedBlobs++
+	r.mu.Unlock()
+
+	return r.progress()
+}
+
+// progress reports progress and periodically saves the state.
+func (r *replicator) progress() error {
+	r.mu.Lock()
+	s := r.stats
+	save := clock.Since(r.lastSaved) >= r.opt.StateSaveInterval
+	r.mu.Unlock()
+
+	if r.opt.Progress != nil {
+		r.opt.Progress(s)
+	}
+
+	if !save {
+		return nil
+	}
+
+	return r.saveState()
+}
+
+func (r *replicator) saveState() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastSaved = clock.Now()
+
+	return saveState(r.opt.StateFile, r.state)
+}
@@ -0,0 +1,178 @@
+package replication
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/faketime"
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func TestReplicate(t *testing.T) {
+	ctx := testlogging.Context(t)
+	ta := faketime.NewTimeAdvance(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Second)
+
+	srcData := blobtesting.DataMap{}
+	dstData := blobtesting.DataMap{}
+	src := blobtesting.NewMapStorage(srcData, nil, ta.NowFunc())
+	dst := blobtesting.NewMapStorage(dstData, nil, ta.NowFunc())
+
+	for id, v := range map[blob.ID]string{
+		repo.FormatBlobID:   "format",
+		"p1":                "pack1",
+		"q2":                "pack2",
+		"n1":                "index1",
+		"kopia.maintenance": "maint",
+	} {
+		if err := src.PutBlob(ctx, id, gather.FromSlice([]byte(v)), blob.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opt := Options{
+		StateFile: filepath.Join(t.TempDir(), "state.json"),
+		Delete:    true,
+		Verify:    VerifyFull,
+	}
+
+	stats, err := Replicate(ctx, src, dst, opt)
+	if err != nil {
+		t.Fatalf("replicate error: %v", err)
+	}
+
+	if got, want := stats.CopiedBlobs, 5; got != want {
+		t.Errorf("unexpected number of copied blobs: %v, want %v", got, want)
+	}
+
+	for id, v := range srcData {
+		if got := string(dstData[id]); got != string(v) {
+			t.Errorf("invalid contents of %v: %q, want %q", id, got, v)
+		}
+	}
+
+	// second run without changes copies nothing.
+	stats, err = Replicate(ctx, src, dst, opt)
+	if err != nil {
+		t.Fatalf("replicate error: %v", err)
+	}
+
+	if stats.CopiedBlobs != 0 || stats.InSyncBlobs != 5 {
+		t.Errorf("unexpected stats of incremental run: %+v", stats)
+	}
+
+	// add, change and delete blobs in the source.
+	ta.Advance(time.Hour)
+
+	if err = src.PutBlob(ctx, "p3", gather.FromSlice([]byte("pack3")), blob.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = src.PutBlob(ctx, "n1", gather.FromSlice([]byte("index1-changed")), blob.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = src.DeleteBlob(ctx, "q2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// dry run does not modify the destination.
+	dryRun := opt
+	dryRun.DryRun = true
+
+	stats, err = Replicate(ctx, src, dst, dryRun)
+	if err != nil {
+		t.Fatalf("replicate error: %v", err)
+	}
+
+	if stats.ToCopyBlobs != 2 || stats.ToDelete != 1 || stats.CopiedBlobs != 0 || stats.DeletedBlobs != 0 {
+		t.Errorf("unexpected stats of dry run: %+v", stats)
+	}
+
+	if _, ok := dstData["p3"]; ok {
+		t.Errorf("dry run copied blob")
+	}
+
+	stats, err = Replicate(ctx, src, dst, opt)
+	if err != nil {
+		t.Fatalf("replicate error: %v", err)
+	}
+
+	if stats.CopiedBlobs != 2 || stats.DeletedBlobs != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	if got, want := string(dstData["n1"]), "index1-changed"; got != want {
+		t.Errorf("invalid contents of n1: %q, want %q", got, want)
+	}
+
+	if _, ok := dstData["q2"]; ok {
+		t.Errorf("deleted blob was not removed from destination")
+	}
+}
+
+func TestReplicateWithoutStateUsesDestinationListing(t *testing.T) {
+	ctx := testlogging.Context(t)
+	ta := faketime.NewTimeAdvance(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Second)
+
+	src := blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, ta.NowFunc())
+	dst := blobtesting.NewMapStorage(blobtesting.DataMap{}, nil, ta.NowFunc())
+
+	for _, id := range []blob.ID{repo.FormatBlobID, "p1", "n1"} {
+		if err := src.PutBlob(ctx, id, gather.FromSlice([]byte(id)), blob.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := Replicate(ctx, src, dst, Options{}); err != nil {
+		t.Fatalf("replicate error: %v", err)
+	}
+
+	stats, err := Replicate(ctx, src, dst, Options{})
+	if err != nil {
+		t.Fatalf("replicate error: %v", err)
+	}
+
+	if stats.CopiedBlobs != 0 || stats.InSyncBlobs != 3 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestReplicateIncompatibleDestination(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	src := blobtesting.NewMapStorage(blobtesting.DataMap{repo.FormatBlobID: []byte("format1")}, nil, nil)
+	dst := blobtesting.NewMapStorage(blobtesting.DataMap{repo.FormatBlobID: []byte("format2")}, nil, nil)
+
+	if _, err := Replicate(ctx, src, dst, Options{}); !errors.Is(err, ErrIncompatibleDestination) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSplitByPhase(t *testing.T) {
+	phases := splitByPhase([]blob.Metadata{
+		{BlobID: repo.FormatBlobID},
+		{BlobID: "n1"},
+		{BlobID: "q2"},
+		{BlobID: "p1"},
+		{BlobID: "xn0_1"},
+	})
+
+	if len(phases[0]) != 2 || phases[0][0].BlobID != "p1" || phases[0][1].BlobID != "q2" {
+		t.Errorf("unexpected pack blobs: %v", phases[0])
+	}
+
+	if len(phases[1]) != 2 || phases[1][0].BlobID != "n1" || phases[1][1].BlobID != "xn0_1" {
+		t.Errorf("unexpected other blobs: %v", phases[1])
+	}
+
+	if len(phases[2]) != 1 || phases[2][0].BlobID != repo.FormatBlobID {
+		t.Errorf("unexpected format blob: %v", phases[2])
+	}
+}
@@ -8,6 +8,9 @@
 
 	"github.com/kopia/kopia/internal/clock"
 	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/staging"
+	"github.com/kopia/kopia/repo/blob/throttling"
+	"github.com/kopia/kopia/repo/compression"
 	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/repo/manifest"
 	"github.com/kopia/kopia/repo/object"
@@ -21,6 +24,7 @@ type Repository interface {
 
 	GetManifest(ctx context.Context, id manifest.ID, data interface{}) (*manifest.EntryMetadata, error)
 	PutManifest(ctx context.Context, labels map[string]string, payload interface{}) (manifest.ID, error)
+	ReplaceManifest(ctx context.Context, id manifest.ID, labels map[string]string, payload interface{}) error
 	FindManifests(ctx context.Context, labels map[string]string) ([]*manifest.EntryMetadata, error)
 	DeleteManifest(ctx context.Context, id manifest.ID) error
 
@@ -50,6 +54,16 @@ type DirectRepository struct {
 	formatBlob *formatBlob
 	masterKey  []byte
 
+	verifyCriticalWrites bool
+
+	zstdDictionaries []compression.ZstdDictionary
+
+	// stopPrefetch stops background prefetch, if any.
+	stopPrefetch func()
+
+	throttler throttling.Throttler
+	stager    staging.Stager
+
 	closed chan struct{}
 }
 
@@ -74,6 +88,18 @@ func (r *DirectRepository) BlobStorage() blob.Storage {
 	return r.Blobs
 }
 
+// Throttler returns the throttler which limits bandwidth of blob storage operations or nil
+// if the repository was not opened from a configuration file.
+func (r *DirectRepository) Throttler() throttling.Throttler {
+	return r.throttler
+}
+
+// Stager returns the stager of blob writes which are uploaded in the background or nil
+// if the repository was not connected with a staging directory.
+func (r *DirectRepository) Stager() staging.Stager {
+	return r.stager
+}
+
 // ContentManager returns the content manager.
 func (r *DirectRepository) ContentManager() *content.Manager {
 	return r.Content
@@ -109,6 +135,11 @@ func (r *DirectRepository) PutManifest(ctx context.Context, labels map[string]st
 	return r.Manifests.Put(ctx, labels, payload)
 }
 
+// ReplaceManifest replaces the payload and labels of an existing manifest, keeping its ID.
+func (r *DirectRepository) ReplaceManifest(ctx context.Context, id manifest.ID, labels map[string]string, payload interface{}) error {
+	return r.Manifests.Replace(ctx, id, labels, payload)
+}
+
 // FindManifests returns metadata for manifests matching given set of labels.
 func (r *DirectRepository) FindManifests(ctx context.Context, labels map[string]string) ([]*manifest.EntryMetadata, error) {
 	return r.Manifests.Find(ctx, labels)
@@ -134,6 +165,10 @@ func (r *DirectRepository) Close(ctx context.Context) error {
 	default:
 	}
 
+	if r.stopPrefetch != nil {
+		r.stopPrefetch()
+	}
+
 	if err := r.Flush(ctx); err != nil {
 		return errors.Wrap(err, "error flushing")
 	}
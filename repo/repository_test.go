@@ -5,12 +5,22 @@
 	"context"
 	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"runtime/debug"
+	"strings"
 	"testing"
 
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/gather"
 	"github.com/kopia/kopia/internal/repotesting"
 	"github.com/kopia/kopia/internal/testlogging"
 	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/filesystem"
+	"github.com/kopia/kopia/repo/blob/namespace"
+	"github.com/kopia/kopia/repo/compression"
 	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/repo/object"
 )
@@ -117,7 +127,8 @@ func TestPackingSimple(t *testing.T) {
 		t.Errorf("oid3a(%q) != oid3b(%q)", got, want)
 	}
 
-	env.VerifyBlobCount(t, 3)
+	// format blob with its replicas, one pack and one index.
+	env.VerifyBlobCount(t, 3+len(repo.FormatBlobReplicaIDs))
 
 	env.MustReopen(t)
 
@@ -320,3 +331,201 @@ func TestFormats(t *testing.T) {
 		}
 	}
 }
+
+func TestNamespaces(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	tmpDir, err := ioutil.TempDir("", "kopia-namespace")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	configFile := filepath.Join(tmpDir, "repo.config")
+
+	// namespaced storage must be reopened from configuration, so it can't use in-memory storage.
+	st, err := filesystem.New(ctx, &filesystem.Options{Path: tmpDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nsA, err := namespace.NewWrapper(st, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = repo.Initialize(ctx, nsA, &repo.NewRepositoryOptions{}, "password"); err != nil {
+		t.Fatalf("unable to initialize: %v", err)
+	}
+
+	// the error names namespaces containing repositories, which are not visible without the namespace.
+	if err = repo.Connect(ctx, configFile, st, "password", nil); !errors.Is(err, repo.ErrRepositoryNotInitialized) || !strings.Contains(err.Error(), "namespaces: a") {
+		t.Fatalf("unexpected error connecting without namespace: %v", err)
+	}
+
+	if err = repo.Connect(ctx, configFile, nsA, "password", nil); err != nil {
+		t.Fatalf("unable to connect in namespace: %v", err)
+	}
+
+	r, err := repo.Open(ctx, configFile, "password", nil)
+	if err != nil {
+		t.Fatalf("unable to open: %v", err)
+	}
+
+	r.Close(ctx)
+
+	if err = repo.Disconnect(ctx, configFile); err != nil {
+		t.Fatalf("unable to disconnect: %v", err)
+	}
+
+	nsB, err := namespace.NewWrapper(st, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = repo.Connect(ctx, configFile, nsB, "password", nil); !errors.Is(err, repo.ErrRepositoryNotInitialized) || !strings.Contains(err.Error(), "namespaces: a") {
+		t.Fatalf("unexpected error connecting in other namespace: %v", err)
+	}
+
+	// copy format blob to another namespace, simulating repository that was moved.
+	fb, err := nsA.GetBlob(ctx, repo.FormatBlobID, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = nsB.PutBlob(ctx, repo.FormatBlobID, gather.FromSlice(fb), blob.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = repo.Connect(ctx, configFile, nsB, "password", nil); !errors.Is(err, repo.ErrNamespaceMismatch) {
+		t.Fatalf("unexpected error connecting in wrong namespace: %v", err)
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	ctx := testlogging.Context(t)
+	storageDir := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "repo.config")
+	cacheDir := t.TempDir()
+
+	st, err := filesystem.New(ctx, &filesystem.Options{Path: storageDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = repo.Initialize(ctx, st, &repo.NewRepositoryOptions{}, "password"); err != nil {
+		t.Fatalf("unable to initialize: %v", err)
+	}
+
+	if err = repo.Connect(ctx, configFile, st, "password", nil); err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+
+	r, err := repo.Open(ctx, configFile, "password", nil)
+	if err != nil {
+		t.Fatalf("unable to open: %v", err)
+	}
+
+	// write metadata object, which isn't read when opening the repository.
+	w := r.NewObjectWriter(ctx, object.WriterOptions{Prefix: "k"})
+	if _, err = w.Write([]byte("metadata")); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+
+	if _, err = w.Result(); err != nil {
+		t.Fatalf("unable to write: %v", err)
+	}
+
+	if err = r.Close(ctx); err != nil {
+		t.Fatalf("unable to close: %v", err)
+	}
+
+	if err = repo.Disconnect(ctx, configFile); err != nil {
+		t.Fatalf("unable to disconnect: %v", err)
+	}
+
+	if err = repo.Connect(ctx, configFile, st, "password", &repo.ConnectOptions{
+		CachingOptions: content.CachingOptions{
+			CacheDirectory:            cacheDir,
+			MaxCacheSizeBytes:         1e6,
+			MaxMetadataCacheSizeBytes: 1e6,
+			LazyIndexLoading:          true,
+		},
+	}); err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+
+	// prefetch fetches indexes and metadata into cache.
+	r, err = repo.Open(ctx, configFile, "password", nil)
+	if err != nil {
+		t.Fatalf("unable to open: %v", err)
+	}
+
+	if err = r.(*repo.DirectRepository).Prefetch(ctx); err != nil {
+		t.Fatalf("unable to prefetch: %v", err)
+	}
+
+	if err = r.Close(ctx); err != nil {
+		t.Fatalf("unable to close: %v", err)
+	}
+
+	if n := countCachedMetadataPacks(t, filepath.Join(cacheDir, "metadata")); n == 0 {
+		t.Errorf("no metadata prefetched into cache")
+	}
+
+	// background prefetch is stopped when the repository is closed.
+	r, err = repo.Open(ctx, configFile, "password", &repo.Options{BackgroundPrefetch: true})
+	if err != nil {
+		t.Fatalf("unable to open: %v", err)
+	}
+
+	if err = r.Close(ctx); err != nil {
+		t.Fatalf("unable to close: %v", err)
+	}
+}
+
+// countCachedMetadataPacks counts metadata packs in the cache directory, which also contains index blobs.
+func countCachedMetadataPacks(t *testing.T, dir string) int {
+	t.Helper()
+
+	var n int
+
+	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// cache directory is sharded by the first characters of blob IDs.
+		if !info.IsDir() && strings.HasPrefix(filepath.Base(filepath.Dir(path)), string(content.PackBlobIDPrefixSpecial)) {
+			n++
+		}
+
+		return nil
+	}); err != nil {
+		t.Fatalf("unable to walk %v: %v", dir, err)
+	}
+
+	return n
+}
+
+func TestManifestCompressionRequiresFormatVersion(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+
+	defer env.Setup(t, repotesting.Options{
+		NewRepositoryOptions: func(nro *repo.NewRepositoryOptions) {
+			nro.ManifestCompression = "zstd"
+		},
+	}).Close(ctx, t)
+
+	// versions of kopia that only read gzip manifests refuse to open the repository.
+	if got, want := env.Repository.Content.Format.Version, content.FormatVersion2; got != want {
+		t.Fatalf("unexpected format version: %v, want %v", got, want)
+	}
+
+	if got, want := env.Repository.Manifests.Compressor(), compression.Name("zstd"); got != want {
+		t.Fatalf("unexpected manifest compressor: %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,192 @@
+package splitter
+
+import (
+	"crypto/sha256"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/kopia/kopia/internal/clock"
+)
+
+const (
+	// benchmarkModificationInterval is the average distance between simulated modifications of sample data.
+	benchmarkModificationInterval = 16 << 20
+
+	// benchmarkModificationLength is the number of bytes inserted by each simulated modification.
+	benchmarkModificationLength = 16
+
+	// perSegmentOverhead is the approximate number of bytes of index and object metadata stored for each segment.
+	perSegmentOverhead = 150
+
+	// selectionTolerance is the relative difference of estimated storage cost below which the faster splitter is preferred.
+	selectionTolerance = 0.02
+)
+
+// BenchmarkResult describes the performance of a splitter on sample data.
+type BenchmarkResult struct {
+	Splitter string        `json:"splitter"`
+	Duration time.Duration `json:"duration"`
+	Segments int           `json:"segments"`
+
+	// TotalBytes is the number of bytes split, including simulated modifications of the sample data.
+	TotalBytes int64 `json:"totalBytes"`
+
+	// UniqueBytes is the number of bytes remaining after deduplicating identical segments.
+	UniqueBytes int64 `json:"uniqueBytes"`
+}
+
+// EstimatedCost returns the estimated number of bytes stored in the repository for the sample data.
+func (r BenchmarkResult) EstimatedCost() int64 {
+	return r.UniqueBytes + int64(r.Segments)*perSegmentOverhead
+}
+
+// BenchmarkAlgorithms returns the names of splitters considered by Benchmark, excluding legacy aliases.
+func BenchmarkAlgorithms() []string {
+	var result []string
+
+	for _, name := range SupportedAlgorithms() {
+		if name == "FIXED" || name == "DYNAMIC" {
+			continue
+		}
+
+		result = append(result, name)
+	}
+
+	return result
+}
+
+// Benchmark splits provided samples and their copies with simulated modifications using each of the provided
+// splitters and measures the time spent splitting and the effectiveness of deduplication between the
+// original and modified data, which approximates subsequent snapshots of changing files.
+// Results are sorted by estimated storage cost, then by duration.
+func Benchmark(algorithms []string, samples [][]byte, seed int64) []BenchmarkResult {
+	var data [][]byte
+
+	rnd := rand.New(rand.NewSource(seed)) //nolint:gosec
+
+	for _, s := range samples {
+		data = append(data, s, modifySample(rnd, s))
+	}
+
+	var results []BenchmarkResult
+
+	for _, name := range algorithms {
+		fact := GetFactory(name)
+		if fact == nil {
+			continue
+		}
+
+		results = append(results, benchmarkSplitter(name, fact, data))
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if ci, cj := results[i].EstimatedCost(), results[j].EstimatedCost(); ci != cj {
+			return ci < cj
+		}
+
+		return results[i].Duration < results[j].Duration
+	})
+
+	return results
+}
+
+func benchmarkSplitter(name string, fact Factory, data [][]byte) BenchmarkResult {
+	r := BenchmarkResult{Splitter: name}
+
+	var segmentLengths [][]int
+
+	t0 := clock.Now()
+
+	for _, d := range data {
+		s := fact()
+
+		var lengths []int
+
+		for len(d) > 0 {
+			n := s.NextSplitPoint(d)
+			if n < 0 {
+				lengths = append(lengths, len(d))
+				break
+			}
+
+			lengths = append(lengths, n)
+			d = d[n:]
+		}
+
+		s.Close()
+
+		segmentLengths = append(segmentLengths, lengths)
+	}
+
+	r.Duration = clock.Since(t0)
+
+	seen := map[[sha256.Size]byte]bool{}
+
+	for i, d := range data {
+		for _, n := range segmentLengths[i] {
+			h := sha256.Sum256(d[0:n])
+			d = d[n:]
+
+			r.Segments++
+			r.TotalBytes += int64(n)
+
+			if !seen[h] {
+				seen[h] = true
+				r.UniqueBytes += int64(n)
+			}
+		}
+	}
+
+	return r
+}
+
+// modifySample returns a copy of the sample with short random insertions, on average one per benchmarkModificationInterval bytes.
+func modifySample(rnd *rand.Rand, s []byte) []byte {
+	count := len(s)/benchmarkModificationInterval + 1
+
+	positions := make([]int, count)
+	for i := range positions {
+		positions[i] = rnd.Intn(len(s) + 1)
+	}
+
+	sort.Ints(positions)
+
+	result := make([]byte, 0, len(s)+count*benchmarkModificationLength)
+	last := 0
+
+	for _, p := range positions {
+		result = append(result, s[last:p]...)
+
+		insert := make([]byte, benchmarkModificationLength)
+		rnd.Read(insert) //nolint:errcheck
+
+		result = append(result, insert...)
+		last = p
+	}
+
+	return append(result, s[last:]...)
+}
+
+// SelectBest returns the name of the splitter with the lowest estimated storage cost, preferring faster
+// splitters among those whose cost is within a small tolerance of the lowest.
+func SelectBest(results []BenchmarkResult) string {
+	if len(results) == 0 {
+		return DefaultAlgorithm
+	}
+
+	lowest := results[0].EstimatedCost()
+	best := results[0]
+
+	for _, r := range results[1:] {
+		if float64(r.EstimatedCost()) > float64(lowest)*(1+selectionTolerance) {
+			continue
+		}
+
+		if r.Duration < best.Duration {
+			best = r
+		}
+	}
+
+	return best.Splitter
+}
@@ -0,0 +1,58 @@
+package splitter
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestBenchmarkPrefersContentDefinedSplitterForModifiedData(t *testing.T) {
+	sample := make([]byte, 16<<20)
+	rand.New(rand.NewSource(1)).Read(sample) //nolint:errcheck
+
+	results := Benchmark([]string{"FIXED-1M", "DYNAMIC-1M-BUZHASH", "no-such-splitter"}, [][]byte{sample}, 1)
+
+	if len(results) != 2 {
+		t.Fatalf("unexpected results: %v", results)
+	}
+
+	if got, want := results[0].Splitter, "DYNAMIC-1M-BUZHASH"; got != want {
+		t.Errorf("unexpected best splitter %v, want %v (%+v)", got, want, results)
+	}
+
+	for _, r := range results {
+		if r.TotalBytes != int64(2*len(sample)+benchmarkModificationLength*2) {
+			t.Errorf("unexpected total bytes of %v: %v", r.Splitter, r.TotalBytes)
+		}
+
+		if r.UniqueBytes <= int64(len(sample)) || r.UniqueBytes > r.TotalBytes {
+			t.Errorf("unexpected unique bytes of %v: %v", r.Splitter, r.UniqueBytes)
+		}
+	}
+
+	if got, want := SelectBest(results), "DYNAMIC-1M-BUZHASH"; got != want {
+		t.Errorf("unexpected selection %v, want %v", got, want)
+	}
+}
+
+func TestSelectBestPrefersFasterSplitterWithSimilarCost(t *testing.T) {
+	results := []BenchmarkResult{
+		{Splitter: "a", UniqueBytes: 1000000, Duration: 3 * time.Second},
+		{Splitter: "b", UniqueBytes: 1010000, Duration: 1 * time.Second},
+		{Splitter: "c", UniqueBytes: 2000000, Duration: 0},
+	}
+
+	if got, want := SelectBest(results), "b"; got != want {
+		t.Errorf("unexpected selection %v, want %v", got, want)
+	}
+
+	if got, want := SelectBest(nil), DefaultAlgorithm; got != want {
+		t.Errorf("unexpected selection %v, want %v", got, want)
+	}
+
+	for _, a := range BenchmarkAlgorithms() {
+		if a == "FIXED" || a == "DYNAMIC" {
+			t.Errorf("legacy alias %v included in benchmark", a)
+		}
+	}
+}
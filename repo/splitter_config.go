@@ -0,0 +1,38 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/splitter"
+)
+
+// SetSplitter changes the splitter used for new objects in the repository. Existing objects are not
+// affected, but their contents may no longer deduplicate with contents of new objects.
+// The new splitter takes effect next time the repository is opened.
+func (r *DirectRepository) SetSplitter(ctx context.Context, name string) error {
+	if splitter.GetFactory(name) == nil {
+		return errors.Errorf("unsupported splitter %q", name)
+	}
+
+	repoConfig, err := r.formatBlob.decryptFormatBytes(r.masterKey)
+	if err != nil {
+		return errors.Wrap(err, "unable to decrypt repository config")
+	}
+
+	if repoConfig.Format.Splitter == name {
+		log(ctx).Infof("splitter %v is already used", name)
+		return nil
+	}
+
+	repoConfig.Format.Splitter = name
+
+	if err := r.writeUpdatedFormatBlob(ctx, repoConfig); err != nil {
+		return err
+	}
+
+	r.Objects.Format.Splitter = name
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package repo_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+func TestSetSplitter(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	require.Error(t, env.Repository.SetSplitter(ctx, "no-such-splitter"))
+	require.NoError(t, env.Repository.SetSplitter(ctx, "FIXED-1M"))
+	require.Equal(t, "FIXED-1M", env.Repository.Objects.Format.Splitter)
+
+	env.MustReopen(t)
+
+	require.Equal(t, "FIXED-1M", env.Repository.Objects.Format.Splitter)
+}
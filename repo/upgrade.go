@@ -29,5 +29,5 @@ func (r *DirectRepository) Upgrade(ctx context.Context) error {
 
 	log(ctx).Infof("writing updated format content...")
 
-	return writeFormatBlob(ctx, r.Blobs, f)
+	return writeFormatBlob(ctx, r.Blobs, f, r.verifyCriticalWrites)
 }
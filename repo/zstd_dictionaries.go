@@ -0,0 +1,220 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/gather"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/repo/content"
+)
+
+const (
+	// zstdDictionaryBlobPrefix is the prefix of blobs storing compression dictionaries. Dictionaries are too big
+	// to be stored in the format blob, like the format blob they are never deleted by maintenance.
+	zstdDictionaryBlobPrefix = "kopia.dictionary."
+
+	// formatVersionZstdDictionaries is the format blob version written by previous versions of dictionary support.
+	formatVersionZstdDictionaries = 2
+
+	maxSupportedFormatVersion = formatVersionZstdDictionaries
+)
+
+// purposeZstdDictionaryKey is the purpose of the key used to encrypt compression dictionaries, which are derived
+// from the repository data.
+var purposeZstdDictionaryKey = []byte("zstd-dictionary")
+
+// ErrUnsupportedFormatVersion is returned when opening a repository using format not supported by this version of kopia.
+var ErrUnsupportedFormatVersion = errors.New("unsupported repository format version")
+
+func verifyFormatVersion(f *formatBlob) error {
+	v, err := strconv.Atoi(f.Version)
+	if err != nil {
+		return errors.Wrapf(ErrUnsupportedFormatVersion, "invalid format version %q", f.Version)
+	}
+
+	if v > maxSupportedFormatVersion {
+		return errors.Wrapf(ErrUnsupportedFormatVersion, "repository uses format version %v, this version of kopia supports versions up to %v, please upgrade", v, maxSupportedFormatVersion)
+	}
+
+	return nil
+}
+
+func zstdDictionaryBlobID(id uint32) blob.ID {
+	return blob.ID(zstdDictionaryBlobPrefix + strconv.FormatUint(uint64(id), 10))
+}
+
+func zstdDictionaryCipher(masterKey, uniqueID []byte) (cipher.AEAD, error) {
+	blk, err := aes.NewCipher(deriveKeyFromMasterKey(masterKey, uniqueID, purposeZstdDictionaryKey, 32)) //nolint:gomnd
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create cipher")
+	}
+
+	aead, err := cipher.NewGCM(blk)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create cipher")
+	}
+
+	return aead, nil
+}
+
+// writeZstdDictionary encrypts the dictionary and writes it to its blob, the ID of the dictionary
+// authenticates the blob, so that it can't be swapped with another dictionary.
+func writeZstdDictionary(ctx context.Context, st blob.Storage, masterKey, uniqueID []byte, d compression.ZstdDictionary) error {
+	aead, err := zstdDictionaryCipher(masterKey, uniqueID)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return errors.Wrap(err, "unable to generate nonce")
+	}
+
+	id := zstdDictionaryBlobID(d.ID)
+	b := aead.Seal(nonce, nonce, d.Data, []byte(id))
+
+	if err := st.PutBlob(ctx, id, gather.FromSlice(b), blob.PutOptions{}); err != nil {
+		return errors.Wrapf(err, "unable to write compression dictionary %v", d.ID)
+	}
+
+	return nil
+}
+
+// loadZstdDictionaries loads compression dictionaries with the provided IDs from their blobs.
+func loadZstdDictionaries(ctx context.Context, st blob.Storage, masterKey, uniqueID []byte, ids []uint32) ([]compression.ZstdDictionary, error) {
+	aead, err := zstdDictionaryCipher(masterKey, uniqueID)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []compression.ZstdDictionary
+
+	for _, id := range ids {
+		b, err := st.GetBlob(ctx, zstdDictionaryBlobID(id), 0, -1)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read compression dictionary %v", id)
+		}
+
+		if len(b) < aead.NonceSize() {
+			return nil, errors.Errorf("invalid compression dictionary %v", id)
+		}
+
+		data, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(zstdDictionaryBlobID(id)))
+		if err != nil {
+			return nil, errors.Errorf("unable to decrypt compression dictionary %v", id)
+		}
+
+		result = append(result, compression.ZstdDictionary{ID: id, Data: data})
+	}
+
+	return result, nil
+}
+
+// ZstdDictionaries returns dictionaries used by the "zstd-dictionary" compressor, to be served to API clients.
+func (r *DirectRepository) ZstdDictionaries() []compression.ZstdDictionary {
+	return r.zstdDictionaries
+}
+
+// AddZstdDictionary stores the provided dictionary in the repository and makes it the dictionary used for
+// compression by the "zstd-dictionary" compressor. Previously added dictionaries are kept to read existing data.
+// Adding the first dictionary upgrades the repository format, so that older clients refuse to open it.
+func (r *DirectRepository) AddZstdDictionary(ctx context.Context, d compression.ZstdDictionary) error {
+	repoConfig, err := r.formatBlob.decryptFormatBytes(r.masterKey)
+	if err != nil {
+		return errors.Wrap(err, "unable to decrypt repository config")
+	}
+
+	for _, id := range repoConfig.Format.ZstdDictionaryIDs {
+		if id == d.ID {
+			return errors.Errorf("compression dictionary %v already exists", d.ID)
+		}
+	}
+
+	// the dictionary must be persisted before the format refers to it.
+	if err := writeZstdDictionary(ctx, r.Blobs, r.masterKey, r.UniqueID, d); err != nil {
+		return err
+	}
+
+	ids := append(append([]uint32(nil), repoConfig.Format.ZstdDictionaryIDs...), d.ID)
+
+	dicts, err := loadZstdDictionaries(ctx, r.Blobs, r.masterKey, r.UniqueID, ids)
+	if err != nil {
+		return err
+	}
+
+	repoConfig.Format.ZstdDictionaryIDs = ids
+
+	requireFormatVersion(ctx, repoConfig, content.FormatVersion2)
+
+	if err := r.writeUpdatedFormatBlob(ctx, repoConfig); err != nil {
+		return err
+	}
+
+	r.Objects.Format.ZstdDictionaryIDs = ids
+	r.zstdDictionaries = dicts
+
+	return r.Objects.SetZstdDictionaries(dicts)
+}
+
+// ZstdDictionarySamples returns decompressed payloads of up to maxCount contents of the repository
+// with length up to maxLength, to be used for training compression dictionaries.
+func (r *DirectRepository) ZstdDictionarySamples(ctx context.Context, prefix content.ID, maxCount, maxLength int) ([][]byte, error) {
+	var samples [][]byte
+
+	errEnough := errors.New("enough samples")
+
+	err := r.Content.IterateContents(ctx, content.IterateOptions{Range: content.PrefixRange(prefix)}, func(ci content.Info) error {
+		if ci.ID.Prefix() != prefix || int(ci.Length) > maxLength {
+			return nil
+		}
+
+		data, err := r.Content.GetContent(ctx, ci.ID)
+		if err != nil {
+			return errors.Wrapf(err, "unable to read content %v", ci.ID)
+		}
+
+		samples = append(samples, maybeDecompressed(data))
+
+		if len(samples) >= maxCount {
+			return errEnough
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, errEnough) {
+		return nil, err
+	}
+
+	return samples, nil
+}
+
+// maybeDecompressed returns decompressed data if the provided content payload is compressed
+// and returns it unchanged otherwise.
+func maybeDecompressed(data []byte) []byte {
+	id, err := compression.IDFromHeader(data)
+	if err != nil {
+		return data
+	}
+
+	c := compression.ByHeaderID[id]
+	if c == nil {
+		return data
+	}
+
+	var buf bytes.Buffer
+
+	if err := c.Decompress(&buf, data); err != nil {
+		return data
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,117 @@
+package repo_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/object"
+)
+
+func writeTestObject(ctx context.Context, t *testing.T, rep *repo.DirectRepository, data []byte, opt object.WriterOptions) object.ID {
+	t.Helper()
+
+	w := rep.NewObjectWriter(ctx, opt)
+	defer w.Close()
+
+	_, err := w.Write(data)
+	require.NoError(t, err)
+
+	oid, err := w.Result()
+	require.NoError(t, err)
+
+	return oid
+}
+
+func verifyTestObject(ctx context.Context, t *testing.T, rep repo.Repository, oid object.ID, want []byte) {
+	t.Helper()
+
+	r, err := rep.OpenObject(ctx, oid)
+	require.NoError(t, err)
+
+	defer r.Close()
+
+	got, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+}
+
+func testDirectoryListing(i int) []byte {
+	return []byte(fmt.Sprintf(`{"stream":"kopia:directory","entries":[{"name":"file-%v.txt","type":"f","mode":"0644","mtime":"2020-01-01T10:%02d:00Z","uid":1000,"gid":1000,"obj":"%032x","size":%v}],"summary":{"size":%v,"files":1,"symlinks":0,"dirs":0,"maxTime":"2020-01-01T10:00:00Z","numFailed":0}}`,
+		i, i%60, i*7919, i*31, i*31))
+}
+
+func TestZstdDictionary(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	// before any dictionary is trained, data is compressed without it.
+	oidBefore := writeTestObject(ctx, t, env.Repository, testDirectoryListing(0), object.WriterOptions{Prefix: "k", Compressor: compression.ZstdDictionaryName})
+
+	for i := 1; i < 500; i++ {
+		writeTestObject(ctx, t, env.Repository, testDirectoryListing(i), object.WriterOptions{Prefix: "k"})
+	}
+
+	require.NoError(t, env.Repository.Flush(ctx))
+
+	samples, err := env.Repository.ZstdDictionarySamples(ctx, "k", 1000, 1<<16)
+	require.NoError(t, err)
+	require.Len(t, samples, 500)
+
+	d, err := compression.TrainZstdDictionary(samples, 16<<10)
+	require.NoError(t, err)
+
+	require.NoError(t, env.Repository.AddZstdDictionary(ctx, d))
+	require.Error(t, env.Repository.AddZstdDictionary(ctx, d))
+
+	// dictionaries are stored in their own blobs, which are never deleted, not in manifests.
+	_, err = env.Repository.Blobs.GetMetadata(ctx, blob.ID(fmt.Sprintf("kopia.dictionary.%v", d.ID)))
+	require.NoError(t, err)
+
+	data := testDirectoryListing(1000)
+	oid := writeTestObject(ctx, t, env.Repository, data, object.WriterOptions{Prefix: "k", Compressor: compression.ZstdDictionaryName})
+
+	require.NoError(t, env.Repository.Flush(ctx))
+
+	verifyTestObject(ctx, t, env.Repository, oid, data)
+
+	// dictionaries are loaded when the repository is opened, which requires support for the upgraded format.
+	env.MustReopen(t)
+
+	require.Equal(t, content.FormatVersion2, env.Repository.Content.Format.Version)
+	require.Equal(t, []compression.ZstdDictionary{d}, env.Repository.ZstdDictionaries())
+
+	verifyTestObject(ctx, t, env.Repository, oid, data)
+	verifyTestObject(ctx, t, env.Repository, oidBefore, testDirectoryListing(0))
+
+	cid, _, ok := oid.ContentID()
+	require.True(t, ok)
+
+	payload, err := env.Repository.Content.GetContent(ctx, cid)
+	require.NoError(t, err)
+
+	hid, err := compression.IDFromHeader(payload)
+	require.NoError(t, err)
+	require.Equal(t, compression.HeaderZstdDictionary, hid)
+
+	// second dictionary is used for new data, data compressed using the first one is still readable.
+	d2, err := compression.TrainZstdDictionary(samples, 16<<10)
+	require.NoError(t, err)
+	require.NoError(t, env.Repository.AddZstdDictionary(ctx, d2))
+
+	env.MustReopen(t)
+
+	verifyTestObject(ctx, t, env.Repository, oid, data)
+	require.Equal(t, []uint32{d.ID, d2.ID}, env.Repository.Objects.Format.ZstdDictionaryIDs)
+}
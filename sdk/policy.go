@@ -0,0 +1,132 @@
+package sdk
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+)
+
+// Policy contains the most commonly used snapshot policy settings of a source. Unset fields are
+// inherited from parent directories, the host, the user and ultimately the global policy.
+type Policy struct {
+	// Retention policy, number of snapshots to keep in each time period.
+	KeepLatest  *int `json:"keepLatest,omitempty"`
+	KeepHourly  *int `json:"keepHourly,omitempty"`
+	KeepDaily   *int `json:"keepDaily,omitempty"`
+	KeepWeekly  *int `json:"keepWeekly,omitempty"`
+	KeepMonthly *int `json:"keepMonthly,omitempty"`
+	KeepAnnual  *int `json:"keepAnnual,omitempty"`
+
+	// IgnoreRules contains gitignore-style patterns of files excluded from snapshots.
+	IgnoreRules []string `json:"ignoreRules,omitempty"`
+
+	// Compression is the name of the compression algorithm, such as "zstd" or "none".
+	Compression string `json:"compression,omitempty"`
+}
+
+func policyFromInternal(p *policy.Policy) *Policy {
+	return &Policy{
+		KeepLatest:  p.RetentionPolicy.KeepLatest,
+		KeepHourly:  p.RetentionPolicy.KeepHourly,
+		KeepDaily:   p.RetentionPolicy.KeepDaily,
+		KeepWeekly:  p.RetentionPolicy.KeepWeekly,
+		KeepMonthly: p.RetentionPolicy.KeepMonthly,
+		KeepAnnual:  p.RetentionPolicy.KeepAnnual,
+		IgnoreRules: p.FilesPolicy.IgnoreRules,
+		Compression: string(p.CompressionPolicy.CompressorName),
+	}
+}
+
+// applyTo replaces the settings of the provided policy with the settings of p, keeping other settings intact.
+func (p *Policy) applyTo(dst *policy.Policy) error {
+	if p.Compression != "" && p.Compression != "none" && compression.ByName[compression.Name(p.Compression)] == nil {
+		return errors.Errorf("unsupported compression algorithm: %v", p.Compression)
+	}
+
+	dst.RetentionPolicy.KeepLatest = p.KeepLatest
+	dst.RetentionPolicy.KeepHourly = p.KeepHourly
+	dst.RetentionPolicy.KeepDaily = p.KeepDaily
+	dst.RetentionPolicy.KeepWeekly = p.KeepWeekly
+	dst.RetentionPolicy.KeepMonthly = p.KeepMonthly
+	dst.RetentionPolicy.KeepAnnual = p.KeepAnnual
+	dst.FilesPolicy.IgnoreRules = p.IgnoreRules
+	dst.CompressionPolicy.CompressorName = compression.Name(p.Compression)
+
+	return nil
+}
+
+// policySource returns the source for the provided local path on this client, or the global policy source
+// when the path is empty.
+func (r *Repository) policySource(path string) (snapshot.SourceInfo, error) {
+	if path == "" {
+		return policy.GlobalPolicySourceInfo, nil
+	}
+
+	return r.sourceInfo(path)
+}
+
+// GetPolicy returns the policy defined for the provided local path on this client or the global policy when
+// the path is empty. When no policy is defined, an empty policy is returned.
+func (r *Repository) GetPolicy(ctx context.Context, path string) (*Policy, error) {
+	src, err := r.policySource(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := policy.GetDefinedPolicy(ctx, r.rep, src)
+	if errors.Is(err, policy.ErrPolicyNotFound) {
+		return &Policy{}, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get policy for %v", src)
+	}
+
+	return policyFromInternal(p), nil
+}
+
+// GetEffectivePolicy returns the policy in effect for the provided local path on this client, after
+// applying inherited settings.
+func (r *Repository) GetEffectivePolicy(ctx context.Context, path string) (*Policy, error) {
+	src, err := r.policySource(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p, _, err := policy.GetEffectivePolicy(ctx, r.rep, src)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get effective policy for %v", src)
+	}
+
+	return policyFromInternal(p), nil
+}
+
+// SetPolicy sets the policy for the provided local path on this client or the global policy when the path
+// is empty. Settings not covered by Policy that were defined by other tools are preserved.
+func (r *Repository) SetPolicy(ctx context.Context, path string, p *Policy) error {
+	src, err := r.policySource(path)
+	if err != nil {
+		return err
+	}
+
+	defined, err := policy.GetDefinedPolicy(ctx, r.rep, src)
+	if errors.Is(err, policy.ErrPolicyNotFound) {
+		defined = &policy.Policy{}
+	} else if err != nil {
+		return errors.Wrapf(err, "unable to get policy for %v", src)
+	}
+
+	if err := p.applyTo(defined); err != nil {
+		return err
+	}
+
+	if err := policy.SetPolicy(ctx, r.rep, src, defined); err != nil {
+		return errors.Wrapf(err, "unable to set policy for %v", src)
+	}
+
+	return errors.Wrap(r.rep.Flush(ctx), "flush error")
+}
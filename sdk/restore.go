@@ -0,0 +1,102 @@
+package sdk
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/restore"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+// RestoreOptions specifies options for restoring snapshots.
+type RestoreOptions struct {
+	// OverwriteFiles and OverwriteDirectories allow replacing existing files and restoring into existing directories.
+	OverwriteFiles       bool
+	OverwriteDirectories bool
+
+	// SkipOwners, SkipPermissions and SkipTimes skip restoring the corresponding file attributes.
+	SkipOwners      bool
+	SkipPermissions bool
+	SkipTimes       bool
+
+	// Parallel is the number of files restored in parallel, 0 uses the number of CPUs.
+	Parallel int
+
+	// Progress is invoked periodically with restore statistics.
+	Progress func(RestoreStats)
+}
+
+// RestoreStats describes progress of a restore.
+type RestoreStats struct {
+	RestoredBytes int64 `json:"restoredBytes"`
+	TotalBytes    int64 `json:"totalBytes"`
+
+	RestoredFiles       int64 `json:"restoredFiles"`
+	RestoredDirectories int64 `json:"restoredDirectories"`
+	RestoredSymlinks    int64 `json:"restoredSymlinks"`
+}
+
+func restoreStats(st restore.Stats) RestoreStats {
+	return RestoreStats{
+		RestoredBytes:       st.RestoredTotalFileSize,
+		TotalBytes:          st.EnqueuedTotalFileSize,
+		RestoredFiles:       int64(st.RestoredFileCount),
+		RestoredDirectories: int64(st.RestoredDirCount),
+		RestoredSymlinks:    int64(st.RestoredSymlinkCount),
+	}
+}
+
+// Restore restores the snapshot with the provided ID to the target path. Instead of a snapshot ID, the ID may be
+// the root ID of a snapshot optionally followed by a path within the snapshot, such as "ROOTID/dir/file".
+func (r *Repository) Restore(ctx context.Context, id, targetPath string, opt *RestoreOptions) (*RestoreStats, error) {
+	if opt == nil {
+		opt = &RestoreOptions{}
+	}
+
+	rootID := id
+
+	if m, err := snapshot.LoadSnapshot(ctx, r.rep, manifest.ID(id)); err == nil {
+		rootID = m.RootObjectID().String()
+	} else if !errors.Is(err, snapshot.ErrSnapshotNotFound) {
+		return nil, errors.Wrapf(err, "unable to load snapshot %v", id)
+	}
+
+	rootEntry, err := snapshotfs.FilesystemEntryFromIDWithPath(ctx, r.rep, rootID, false)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to find snapshot %v", id)
+	}
+
+	target, err := filepath.Abs(targetPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to determine absolute path")
+	}
+
+	output := &restore.FilesystemOutput{
+		TargetPath:           target,
+		OverwriteFiles:       opt.OverwriteFiles,
+		OverwriteDirectories: opt.OverwriteDirectories,
+		SkipOwners:           opt.SkipOwners,
+		SkipPermissions:      opt.SkipPermissions,
+		SkipTimes:            opt.SkipTimes,
+	}
+
+	st, err := restore.Entry(ctx, r.rep, output, rootEntry, restore.Options{
+		Parallel: opt.Parallel,
+		ProgressCallback: func(ctx context.Context, st restore.Stats) {
+			if opt.Progress != nil {
+				opt.Progress(restoreStats(st))
+			}
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "restore error")
+	}
+
+	result := restoreStats(st)
+
+	return &result, nil
+}
@@ -0,0 +1,91 @@
+// Package sdk provides a stable API for embedding kopia snapshots in other programs.
+//
+// The package wraps connecting to repositories, creating and listing snapshots, restoring them and managing
+// policies behind a small set of types that only change in backwards-compatible ways within a major Version,
+// so that programs embedding kopia do not need to import packages whose APIs change between releases.
+package sdk
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/content"
+)
+
+// Version is the semantic version of the SDK API. Breaking changes to the API increment the major version.
+const Version = "1.0.0"
+
+// ConnectOptions specifies options for connecting to a repository.
+type ConnectOptions struct {
+	// CacheDirectory is the local cache directory, defaults to a directory next to the config file.
+	CacheDirectory string
+
+	// MaxCacheSizeBytes is the maximum size of the local cache.
+	MaxCacheSizeBytes int64
+
+	// Hostname and Username identify the client in snapshots it creates, default to current host and user.
+	Hostname string
+	Username string
+}
+
+// Initialize creates a new repository in the provided storage, encrypted with the provided password.
+func Initialize(ctx context.Context, st *Storage, password string) error {
+	return repo.Initialize(ctx, st.st, &repo.NewRepositoryOptions{}, password)
+}
+
+// Connect connects to the repository in the provided storage and writes the configuration file, which is
+// then used to Open the repository.
+func Connect(ctx context.Context, configFile string, st *Storage, password string, opt *ConnectOptions) error {
+	if opt == nil {
+		opt = &ConnectOptions{}
+	}
+
+	return repo.Connect(ctx, configFile, st.st, password, &repo.ConnectOptions{
+		PersistCredentials: true,
+		ClientOptions: repo.ClientOptions{
+			Hostname: opt.Hostname,
+			Username: opt.Username,
+		},
+		CachingOptions: content.CachingOptions{
+			CacheDirectory:    opt.CacheDirectory,
+			MaxCacheSizeBytes: opt.MaxCacheSizeBytes,
+		},
+	})
+}
+
+// Disconnect removes the configuration file and the local cache of a connected repository.
+func Disconnect(ctx context.Context, configFile string) error {
+	return repo.Disconnect(ctx, configFile)
+}
+
+// Repository is an open repository.
+type Repository struct {
+	rep repo.Repository
+}
+
+// Open opens the repository connected using the provided configuration file. The password may be empty
+// when it was persisted by Connect.
+func Open(ctx context.Context, configFile, password string) (*Repository, error) {
+	if password == "" {
+		pass, ok := repo.GetPersistedPassword(ctx, configFile)
+		if !ok {
+			return nil, errors.Errorf("password not provided and not persisted for %v", configFile)
+		}
+
+		password = pass
+	}
+
+	rep, err := repo.Open(ctx, configFile, password, &repo.Options{})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open repository")
+	}
+
+	return &Repository{rep}, nil
+}
+
+// Close closes the repository.
+func (r *Repository) Close(ctx context.Context) error {
+	return r.rep.Close(ctx)
+}
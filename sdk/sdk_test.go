@@ -0,0 +1,89 @@
+package sdk_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/sdk"
+)
+
+func TestSnapshotAndRestore(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	storageDir := t.TempDir()
+	configFile := filepath.Join(t.TempDir(), "repo.config")
+	sourceDir := t.TempDir()
+	restoreDir := filepath.Join(t.TempDir(), "restored")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("hello"), 0o600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sourceDir, "b.log"), []byte("ignored"), 0o600))
+
+	st, err := sdk.NewFilesystemStorage(ctx, storageDir)
+	require.NoError(t, err)
+
+	require.NoError(t, sdk.Initialize(ctx, st, "password"))
+	require.NoError(t, sdk.Connect(ctx, configFile, st, "password", &sdk.ConnectOptions{
+		Hostname: "host",
+		Username: "user",
+	}))
+
+	rep, err := sdk.Open(ctx, configFile, "")
+	require.NoError(t, err)
+
+	defer rep.Close(ctx)
+
+	keepLatest := 1
+
+	require.NoError(t, rep.SetPolicy(ctx, sourceDir, &sdk.Policy{
+		KeepLatest:  &keepLatest,
+		IgnoreRules: []string{"*.log"},
+	}))
+
+	pol, err := rep.GetPolicy(ctx, sourceDir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"*.log"}, pol.IgnoreRules)
+
+	eff, err := rep.GetEffectivePolicy(ctx, sourceDir)
+	require.NoError(t, err)
+	require.Equal(t, 1, *eff.KeepLatest)
+	require.NotNil(t, eff.KeepDaily, "expected value inherited from global policy")
+
+	require.Error(t, rep.SetPolicy(ctx, sourceDir, &sdk.Policy{Compression: "no-such-algorithm"}))
+
+	snap1, err := rep.CreateSnapshot(ctx, sourceDir, &sdk.SnapshotOptions{Description: "first"})
+	require.NoError(t, err)
+	require.Equal(t, "host", snap1.Source.Host)
+	require.Equal(t, "first", snap1.Description)
+	require.EqualValues(t, 1, snap1.TotalFileCount)
+
+	snap2, err := rep.CreateSnapshot(ctx, sourceDir, nil)
+	require.NoError(t, err)
+
+	// the retention policy keeps only the latest snapshot.
+	snaps, err := rep.ListSnapshots(ctx, sourceDir)
+	require.NoError(t, err)
+	require.Len(t, snaps, 1)
+	require.Equal(t, snap2.ID, snaps[0].ID)
+
+	stats, err := rep.Restore(ctx, snap2.ID, restoreDir, nil)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, stats.RestoredFiles)
+
+	data, err := ioutil.ReadFile(filepath.Join(restoreDir, "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	require.NoFileExists(t, filepath.Join(restoreDir, "b.log"))
+
+	require.NoError(t, rep.DeleteSnapshot(ctx, snap2.ID))
+	require.True(t, errors.Is(rep.DeleteSnapshot(ctx, snap2.ID), sdk.ErrSnapshotNotFound))
+
+	snaps, err = rep.ListSnapshots(ctx, "")
+	require.NoError(t, err)
+	require.Empty(t, snaps)
+}
@@ -0,0 +1,199 @@
+package sdk
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotcreate"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+	"github.com/kopia/kopia/snapshot/snapshotpause"
+)
+
+var (
+	// ErrSnapshotNotFound is returned when a snapshot with the provided ID does not exist.
+	ErrSnapshotNotFound = snapshot.ErrSnapshotNotFound
+
+	// ErrSnapshotsPaused is returned when snapshots have been paused repository-wide.
+	ErrSnapshotsPaused = errors.New("snapshots have been paused")
+)
+
+// Source identifies the snapshotted directory or file on a particular client.
+type Source struct {
+	Host     string `json:"host"`
+	UserName string `json:"userName"`
+	Path     string `json:"path"`
+}
+
+// Snapshot describes a snapshot of a source.
+type Snapshot struct {
+	ID          string    `json:"id"`
+	Source      Source    `json:"source"`
+	Description string    `json:"description,omitempty"`
+	StartTime   time.Time `json:"startTime"`
+	EndTime     time.Time `json:"endTime"`
+
+	// IncompleteReason is non-empty when the snapshot was interrupted before all files were uploaded.
+	IncompleteReason string `json:"incompleteReason,omitempty"`
+
+	TotalFileSize  int64 `json:"totalFileSize"`
+	TotalFileCount int64 `json:"totalFileCount"`
+
+	// ErrorCount is the number of files and directories that could not be read and were skipped.
+	ErrorCount int `json:"errorCount"`
+
+	// RootID identifies the root of the snapshot, which can be used instead of the snapshot ID when restoring.
+	RootID string `json:"rootID"`
+}
+
+// SnapshotOptions specifies options for creating snapshots.
+type SnapshotOptions struct {
+	Description string
+
+	// ParallelUploads is the number of files uploaded in parallel, 0 uses the default.
+	ParallelUploads int
+
+	// IgnorePause creates the snapshot even if snapshots have been paused repository-wide.
+	IgnorePause bool
+}
+
+func snapshotFromManifest(m *snapshot.Manifest) *Snapshot {
+	s := &Snapshot{
+		ID: string(m.ID),
+		Source: Source{
+			Host:     m.Source.Host,
+			UserName: m.Source.UserName,
+			Path:     m.Source.Path,
+		},
+		Description:      m.Description,
+		StartTime:        m.StartTime,
+		EndTime:          m.EndTime,
+		IncompleteReason: m.IncompleteReason,
+		TotalFileSize:    m.Stats.TotalFileSize,
+		TotalFileCount:   int64(m.Stats.TotalFileCount),
+	}
+
+	if m.RootEntry != nil {
+		s.RootID = m.RootObjectID().String()
+
+		if ds := m.RootEntry.DirSummary; ds != nil {
+			s.ErrorCount = ds.NumFailed
+		}
+	}
+
+	return s
+}
+
+// sourceInfo returns the source for the provided local path on this client.
+func (r *Repository) sourceInfo(path string) (snapshot.SourceInfo, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return snapshot.SourceInfo{}, errors.Wrap(err, "unable to determine absolute path")
+	}
+
+	return snapshot.SourceInfo{
+		Host:     r.rep.ClientOptions().Hostname,
+		UserName: r.rep.ClientOptions().Username,
+		Path:     filepath.Clean(abs),
+	}, nil
+}
+
+// CreateSnapshot snapshots the provided local directory or file according to its effective policy,
+// the same way as 'kopia snapshot create', then applies the retention policy of the source.
+func (r *Repository) CreateSnapshot(ctx context.Context, path string, opt *SnapshotOptions) (*Snapshot, error) {
+	if opt == nil {
+		opt = &SnapshotOptions{}
+	}
+
+	src, err := r.sourceInfo(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !opt.IgnorePause {
+		st, err := snapshotpause.Get(ctx, r.rep)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to determine whether snapshots are paused")
+		}
+
+		if st != nil {
+			return nil, errors.Wrapf(ErrSnapshotsPaused, "paused by %v: %v", st.PausedBy, st.Reason)
+		}
+	}
+
+	u := snapshotfs.NewUploader(r.rep)
+	u.ParallelUploads = opt.ParallelUploads
+
+	p, err := snapshotcreate.Upload(ctx, r.rep, u, src, snapshotcreate.Options{
+		Description: opt.Description,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "upload error")
+	}
+
+	if _, err := snapshotcreate.Save(ctx, r.rep, p); err != nil {
+		return nil, err
+	}
+
+	if err := r.rep.Flush(ctx); err != nil {
+		return nil, errors.Wrap(err, "flush error")
+	}
+
+	return snapshotFromManifest(p.Manifest), nil
+}
+
+// ListSnapshots returns snapshots of the provided local path on this client, oldest first, or snapshots
+// of all sources when the path is empty.
+func (r *Repository) ListSnapshots(ctx context.Context, path string) ([]*Snapshot, error) {
+	var src *snapshot.SourceInfo
+
+	if path != "" {
+		si, err := r.sourceInfo(path)
+		if err != nil {
+			return nil, err
+		}
+
+		src = &si
+	}
+
+	ids, err := snapshot.ListSnapshotManifests(ctx, r.rep, src)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list snapshots")
+	}
+
+	manifests, err := snapshot.LoadSnapshots(ctx, r.rep, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load snapshots")
+	}
+
+	var result []*Snapshot
+
+	for _, m := range snapshot.SortByTime(manifests, false) {
+		result = append(result, snapshotFromManifest(m))
+	}
+
+	return result, nil
+}
+
+// DeleteSnapshot deletes the snapshot with the provided ID, unless it is protected by a legal hold.
+func (r *Repository) DeleteSnapshot(ctx context.Context, id string) error {
+	m, err := snapshot.LoadSnapshot(ctx, r.rep, manifest.ID(id))
+	if err != nil {
+		return errors.Wrapf(err, "unable to load snapshot %v", id)
+	}
+
+	if err := policy.EnsureNotHeld(ctx, r.rep, m); err != nil {
+		return err
+	}
+
+	if err := r.rep.DeleteManifest(ctx, manifest.ID(id)); err != nil {
+		return errors.Wrapf(err, "unable to delete snapshot %v", id)
+	}
+
+	return errors.Wrap(r.rep.Flush(ctx), "flush error")
+}
@@ -0,0 +1,131 @@
+package sdk
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/blob/azure"
+	"github.com/kopia/kopia/repo/blob/b2"
+	"github.com/kopia/kopia/repo/blob/filesystem"
+	"github.com/kopia/kopia/repo/blob/gcs"
+	"github.com/kopia/kopia/repo/blob/s3"
+)
+
+// Storage is a storage backend holding a repository, created by one of the New*Storage functions.
+type Storage struct {
+	st blob.Storage
+}
+
+// Close releases resources associated with the storage.
+func (s *Storage) Close(ctx context.Context) error {
+	return s.st.Close(ctx)
+}
+
+func newStorage(st blob.Storage, err error) (*Storage, error) {
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to connect to storage")
+	}
+
+	return &Storage{st}, nil
+}
+
+// NewFilesystemStorage returns storage in the provided local directory, which is created if needed.
+func NewFilesystemStorage(ctx context.Context, path string) (*Storage, error) {
+	if err := os.MkdirAll(path, 0o700); err != nil { //nolint:gomnd
+		return nil, errors.Wrap(err, "unable to create storage directory")
+	}
+
+	return newStorage(filesystem.New(ctx, &filesystem.Options{Path: path}))
+}
+
+// S3Options specifies the location and credentials of S3 or S3-compatible storage.
+type S3Options struct {
+	Bucket string
+	Prefix string
+
+	// Endpoint is the host name and optional port of the storage service, such as "s3.amazonaws.com".
+	Endpoint string
+	Region   string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	DisableTLS bool
+}
+
+// NewS3Storage returns storage in an S3 bucket.
+func NewS3Storage(ctx context.Context, opt *S3Options) (*Storage, error) {
+	return newStorage(s3.New(ctx, &s3.Options{
+		BucketName:      opt.Bucket,
+		Prefix:          opt.Prefix,
+		Endpoint:        opt.Endpoint,
+		Region:          opt.Region,
+		AccessKeyID:     opt.AccessKeyID,
+		SecretAccessKey: opt.SecretAccessKey,
+		SessionToken:    opt.SessionToken,
+		DoNotUseTLS:     opt.DisableTLS,
+	}))
+}
+
+// GCSOptions specifies the location and credentials of Google Cloud Storage.
+type GCSOptions struct {
+	Bucket string
+	Prefix string
+
+	// CredentialsFile is the name of the file with service account credentials, CredentialsJSON
+	// contains the credentials themselves. Application default credentials are used if neither is provided.
+	CredentialsFile string
+	CredentialsJSON []byte
+}
+
+// NewGCSStorage returns storage in a Google Cloud Storage bucket.
+func NewGCSStorage(ctx context.Context, opt *GCSOptions) (*Storage, error) {
+	return newStorage(gcs.New(ctx, &gcs.Options{
+		BucketName:                    opt.Bucket,
+		Prefix:                        opt.Prefix,
+		ServiceAccountCredentialsFile: opt.CredentialsFile,
+		ServiceAccountCredentialJSON:  opt.CredentialsJSON,
+	}))
+}
+
+// AzureOptions specifies the location and credentials of Azure blob storage.
+type AzureOptions struct {
+	Container string
+	Prefix    string
+
+	StorageAccount string
+	StorageKey     string
+}
+
+// NewAzureStorage returns storage in an Azure blob storage container.
+func NewAzureStorage(ctx context.Context, opt *AzureOptions) (*Storage, error) {
+	return newStorage(azure.New(ctx, &azure.Options{
+		Container:      opt.Container,
+		Prefix:         opt.Prefix,
+		StorageAccount: opt.StorageAccount,
+		StorageKey:     opt.StorageKey,
+	}))
+}
+
+// B2Options specifies the location and credentials of Backblaze B2 storage.
+type B2Options struct {
+	Bucket string
+	Prefix string
+
+	KeyID string
+	Key   string
+}
+
+// NewB2Storage returns storage in a Backblaze B2 bucket.
+func NewB2Storage(ctx context.Context, opt *B2Options) (*Storage, error) {
+	return newStorage(b2.New(ctx, &b2.Options{
+		BucketName: opt.Bucket,
+		Prefix:     opt.Prefix,
+		KeyID:      opt.KeyID,
+		Key:        opt.Key,
+	}))
+}
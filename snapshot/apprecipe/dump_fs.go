@@ -0,0 +1,206 @@
+package apprecipe
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+)
+
+// Root returns a virtual directory with the provided name containing one file per dump of the recipe.
+// Dump commands are started when their files are opened and their output is streamed as file contents.
+func (s *Session) Root(name string) fs.Directory {
+	d := &dumpDirectory{name: name, modTime: s.startTime}
+
+	for _, dump := range s.recipe.Dumps {
+		d.entries = append(d.entries, &dumpFile{s: s, dump: dump, modTime: s.startTime})
+	}
+
+	d.entries.Sort()
+
+	return d
+}
+
+// dumpDirectory is a virtual directory containing dump files.
+type dumpDirectory struct {
+	name    string
+	modTime time.Time
+	entries fs.Entries
+}
+
+func (d *dumpDirectory) IsDir() bool {
+	return true
+}
+
+func (d *dumpDirectory) Name() string {
+	return d.name
+}
+
+func (d *dumpDirectory) ModTime() time.Time {
+	return d.modTime
+}
+
+func (d *dumpDirectory) Mode() os.FileMode {
+	return 0o700 | os.ModeDir // nolint:gomnd
+}
+
+func (d *dumpDirectory) Size() int64 {
+	return 0
+}
+
+func (d *dumpDirectory) Owner() fs.OwnerInfo {
+	return fs.OwnerInfo{}
+}
+
+func (d *dumpDirectory) Device() fs.DeviceInfo {
+	return fs.DeviceInfo{}
+}
+
+func (d *dumpDirectory) Sys() interface{} {
+	return nil
+}
+
+func (d *dumpDirectory) Child(ctx context.Context, name string) (fs.Entry, error) {
+	return fs.ReadDirAndFindChild(ctx, d, name)
+}
+
+func (d *dumpDirectory) Readdir(ctx context.Context) (fs.Entries, error) {
+	return append(fs.Entries(nil), d.entries...), nil
+}
+
+// dumpFile is a virtual file whose contents are the output of a dump command. Its modification time
+// is the start of the session, so that dumps are never considered unchanged from previous snapshots.
+type dumpFile struct {
+	s       *Session
+	dump    Dump
+	modTime time.Time
+	size    int64
+}
+
+func (f *dumpFile) IsDir() bool {
+	return false
+}
+
+func (f *dumpFile) Name() string {
+	return f.dump.FileName
+}
+
+func (f *dumpFile) ModTime() time.Time {
+	return f.modTime
+}
+
+func (f *dumpFile) Mode() os.FileMode {
+	return 0o600 // nolint:gomnd
+}
+
+func (f *dumpFile) Size() int64 {
+	return f.size
+}
+
+func (f *dumpFile) Owner() fs.OwnerInfo {
+	return fs.OwnerInfo{}
+}
+
+func (f *dumpFile) Device() fs.DeviceInfo {
+	return fs.DeviceInfo{}
+}
+
+func (f *dumpFile) Sys() interface{} {
+	return nil
+}
+
+func (f *dumpFile) Open(ctx context.Context) (fs.Reader, error) {
+	cmd := f.s.command(ctx, f.dump.Step)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create pipe")
+	}
+
+	r := &dumpReader{f: f, cmd: cmd, stdout: stdout}
+	cmd.Stderr = &r.stderr
+
+	log(ctx).Debugf("running %v", cmd.Args)
+
+	if err := cmd.Start(); err != nil {
+		err = errors.Wrapf(err, "unable to start %v", cmd.Args[0])
+		f.s.setErr(err)
+
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// dumpReader streams the output of a running dump command.
+type dumpReader struct {
+	f      *dumpFile
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr tailBuffer
+	offset int64
+	done   bool
+}
+
+func (r *dumpReader) Read(b []byte) (int, error) {
+	n, err := r.stdout.Read(b)
+	r.offset += int64(n)
+
+	if err == io.EOF && !r.done {
+		// the dump is only complete if the command has succeeded.
+		r.done = true
+
+		if werr := r.cmd.Wait(); werr != nil {
+			err = commandError(r.cmd, werr, r.stderr.String())
+			r.f.s.setErr(err)
+
+			return n, err
+		}
+	}
+
+	return n, err // nolint:wrapcheck
+}
+
+// Seek only supports determining the current offset, since the output can't be read again.
+func (r *dumpReader) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekCurrent {
+		return r.offset, nil
+	}
+
+	return 0, errors.New("dump output is not seekable")
+}
+
+func (r *dumpReader) Entry() (fs.Entry, error) {
+	f := *r.f
+	f.size = r.offset
+
+	return &f, nil
+}
+
+func (r *dumpReader) Close() error {
+	if r.done {
+		return nil
+	}
+
+	// the output was not read completely, stop the command.
+	r.done = true
+
+	if r.cmd.Process != nil {
+		r.cmd.Process.Kill() //nolint:errcheck
+	}
+
+	r.cmd.Wait() //nolint:errcheck
+
+	return nil
+}
+
+var (
+	_ fs.Directory = (*dumpDirectory)(nil)
+	_ fs.File      = (*dumpFile)(nil)
+	_ fs.Reader    = (*dumpReader)(nil)
+)
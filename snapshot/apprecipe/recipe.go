@@ -0,0 +1,111 @@
+// Package apprecipe implements built-in recipes for application-consistent snapshots of databases.
+//
+// Instead of reading files of a running database, which are generally inconsistent, a recipe runs the
+// tools shipped with the database which produce consistent dumps and streams their output into files of
+// a virtual directory that is snapshotted in place of the source.
+package apprecipe
+
+import (
+	"sort"
+)
+
+// Built-in recipe names.
+const (
+	PostgreSQL = "postgresql"
+	MySQL      = "mysql"
+	MongoDB    = "mongodb"
+)
+
+// ArgsPlaceholder is replaced with the arguments of the policy, such as connection parameters, in commands
+// of recipes. Commands which don't contain it, such as ones printing versions of tools, receive no arguments.
+const ArgsPlaceholder = "{args}"
+
+// Step is a command executed by a recipe.
+type Step struct {
+	Command []string
+}
+
+// Dump is a command whose standard output is stored as a file of the snapshot.
+type Dump struct {
+	Step
+
+	FileName string
+}
+
+// Recipe describes how to take an application-consistent snapshot of an application.
+type Recipe struct {
+	Name        string
+	Description string
+
+	// Version prints the version of the application, which is recorded in snapshot manifests.
+	Version Step
+
+	// Before is executed before any dumps are started, e.g. to quiesce the application.
+	Before []Step
+
+	// Dumps produce the contents of the snapshot.
+	Dumps []Dump
+
+	// After is executed after the snapshot has been taken, even if it failed, e.g. to switch WAL segments.
+	After []Step
+}
+
+var recipes = map[string]*Recipe{
+	PostgreSQL: {
+		Name:        PostgreSQL,
+		Description: "Checkpoints and dumps all databases of a PostgreSQL 10+ cluster using pg_dumpall and switches to a new WAL segment afterwards.",
+		Version:     Step{Command: []string{"psql", "-X", "-A", "-t", "-c", "SHOW server_version", ArgsPlaceholder}},
+		Before: []Step{
+			{Command: []string{"psql", "-X", "-A", "-t", "-c", "CHECKPOINT", ArgsPlaceholder}},
+		},
+		Dumps: []Dump{
+			{Step: Step{Command: []string{"pg_dumpall", "--clean", "--if-exists", ArgsPlaceholder}}, FileName: "pg_dumpall.sql"},
+		},
+		After: []Step{
+			{Command: []string{"psql", "-X", "-A", "-t", "-c", "SELECT pg_switch_wal()", ArgsPlaceholder}},
+		},
+	},
+	MySQL: {
+		Name:        MySQL,
+		Description: "Flushes tables and dumps all databases of a MySQL or MariaDB server in a single transaction using mysqldump and rotates binary logs afterwards.",
+		Version:     Step{Command: []string{"mysql", ArgsPlaceholder, "--batch", "--skip-column-names", "-e", "SELECT VERSION()"}},
+		Before: []Step{
+			{Command: []string{"mysql", ArgsPlaceholder, "-e", "FLUSH TABLES"}},
+		},
+		Dumps: []Dump{
+			{Step: Step{Command: []string{"mysqldump", ArgsPlaceholder, "--all-databases", "--single-transaction", "--quick", "--routines", "--events", "--triggers", "--hex-blob"}}, FileName: "mysqldump.sql"},
+		},
+		After: []Step{
+			{Command: []string{"mysql", ArgsPlaceholder, "-e", "FLUSH BINARY LOGS"}},
+		},
+	},
+	MongoDB: {
+		Name:        MongoDB,
+		Description: "Flushes pending writes to disk and dumps a MongoDB replica set to a point-in-time consistent archive using mongodump --oplog.",
+		Version:     Step{Command: []string{"mongodump", "--version"}},
+		Before: []Step{
+			{Command: []string{"mongosh", "--quiet", "--eval", "db.adminCommand({fsync: 1})", ArgsPlaceholder}},
+		},
+		Dumps: []Dump{
+			{Step: Step{Command: []string{"mongodump", "--archive", "--oplog", ArgsPlaceholder}}, FileName: "mongodump.archive"},
+		},
+	},
+}
+
+// Lookup returns the recipe with the provided name or nil if not found.
+func Lookup(name string) *Recipe {
+	return recipes[name]
+}
+
+// Names returns sorted names of all recipes.
+func Names() []string {
+	var result []string
+
+	for n := range recipes {
+		result = append(result, n)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
@@ -0,0 +1,160 @@
+package apprecipe
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+func registerTestRecipe(t *testing.T, r *Recipe) {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("test recipes require a POSIX shell")
+	}
+
+	recipes[r.Name] = r
+
+	t.Cleanup(func() { delete(recipes, r.Name) })
+}
+
+func shell(script string) []string {
+	// the recipe arguments are available to the script as $1, $2, ...
+	return []string{"sh", "-c", script, "sh", ArgsPlaceholder}
+}
+
+func readDump(ctx context.Context, t *testing.T, d fs.Directory, name string) ([]byte, error) {
+	t.Helper()
+
+	e, err := d.Child(ctx, name)
+	require.NoError(t, err)
+
+	r, err := e.(fs.File).Open(ctx)
+	require.NoError(t, err)
+
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+func TestSession(t *testing.T) {
+	ctx := testlogging.Context(t)
+	afterFile := filepath.Join(t.TempDir(), "after")
+
+	registerTestRecipe(t, &Recipe{
+		Name:    "testdb",
+		Version: Step{Command: shell(`echo "testdb 1.2.3"; echo second line`)},
+		Dumps: []Dump{
+			{Step: Step{Command: shell(`echo "dump of $1"`)}, FileName: "a.sql"},
+			{Step: Step{Command: []string{"echo", "other"}}, FileName: "b.sql"},
+		},
+		After: []Step{{Command: shell(`echo done > ` + afterFile)}},
+	})
+
+	s, err := Start(ctx, "testdb", []string{"mydb"})
+	require.NoError(t, err)
+	require.Equal(t, "testdb", s.Info().Recipe)
+	require.Equal(t, "testdb 1.2.3", s.Info().Version)
+
+	root := s.Root("backup")
+	require.Equal(t, "backup", root.Name())
+
+	entries, err := root.Readdir(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	data, err := readDump(ctx, t, root, "a.sql")
+	require.NoError(t, err)
+	require.Equal(t, "dump of mydb\n", string(data))
+
+	// commands without the placeholder don't receive arguments.
+	data, err = readDump(ctx, t, root, "b.sql")
+	require.NoError(t, err)
+	require.Equal(t, "other\n", string(data))
+	require.NoError(t, s.Err())
+
+	s.Finish(ctx)
+
+	_, err = os.Stat(afterFile)
+	require.NoError(t, err)
+}
+
+func TestSessionDumpFailure(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	registerTestRecipe(t, &Recipe{
+		Name:    "testdb",
+		Version: Step{Command: shell(`echo 1.0`)},
+		Dumps: []Dump{
+			{Step: Step{Command: shell(`echo partial; echo connection refused >&2; exit 1`)}, FileName: "a.sql"},
+		},
+	})
+
+	s, err := Start(ctx, "testdb", nil)
+	require.NoError(t, err)
+
+	defer s.Finish(ctx)
+
+	_, err = readDump(ctx, t, s.Root("backup"), "a.sql")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "connection refused")
+	require.Error(t, s.Err())
+}
+
+func TestSessionBeforeFailure(t *testing.T) {
+	ctx := testlogging.Context(t)
+	afterFile := filepath.Join(t.TempDir(), "after")
+
+	registerTestRecipe(t, &Recipe{
+		Name:    "testdb",
+		Version: Step{Command: shell(`echo 1.0`)},
+		Before:  []Step{{Command: shell(`exit 1`)}},
+		After:   []Step{{Command: shell(`echo done > ` + afterFile)}},
+	})
+
+	_, err := Start(ctx, "testdb", nil)
+	require.Error(t, err)
+
+	// after steps are run to undo partially completed before steps.
+	_, err = os.Stat(afterFile)
+	require.NoError(t, err)
+}
+
+func TestCommandArgs(t *testing.T) {
+	ctx := testlogging.Context(t)
+	s := &Session{args: []string{"--host", "db1"}}
+
+	cmd := s.command(ctx, Step{Command: []string{"mysql", ArgsPlaceholder, "-e", "SELECT 1"}})
+	require.Equal(t, []string{"mysql", "--host", "db1", "-e", "SELECT 1"}, cmd.Args)
+
+	cmd = s.command(ctx, Step{Command: []string{"mongodump", "--version"}})
+	require.Equal(t, []string{"mongodump", "--version"}, cmd.Args)
+}
+
+func TestUnknownRecipe(t *testing.T) {
+	_, err := Start(testlogging.Context(t), "no-such-recipe", nil)
+	require.Error(t, err)
+}
+
+func TestBuiltinRecipes(t *testing.T) {
+	require.Equal(t, []string{MongoDB, MySQL, PostgreSQL}, Names())
+
+	for _, n := range Names() {
+		r := Lookup(n)
+		require.NotEmpty(t, r.Version.Command, n)
+		require.NotEmpty(t, r.Before, n)
+		require.NotEmpty(t, r.Dumps, n)
+
+		for _, d := range r.Dumps {
+			require.Contains(t, d.Command, ArgsPlaceholder, n)
+		}
+	}
+}
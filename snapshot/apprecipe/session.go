@@ -0,0 +1,181 @@
+package apprecipe
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/repo/logging"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+)
+
+var log = logging.GetContextLoggerFunc("kopia/apprecipe")
+
+// maxStderrLength is the maximum length of the error output of a command included in errors.
+const maxStderrLength = 4096
+
+// Session takes a single application-consistent snapshot using a recipe.
+type Session struct {
+	recipe    *Recipe
+	args      []string
+	startTime time.Time
+	version   string
+
+	mu  sync.Mutex
+	err error
+}
+
+// Start runs the steps of the recipe with the provided name that precede taking the snapshot.
+// The caller must call Finish() once the snapshot has been taken.
+func Start(ctx context.Context, name string, args []string) (*Session, error) {
+	r := Lookup(name)
+	if r == nil {
+		return nil, errors.Errorf("unknown application recipe %q, supported recipes: %v", name, strings.Join(Names(), ", "))
+	}
+
+	s := &Session{
+		recipe:    r,
+		args:      args,
+		startTime: clock.Now(),
+	}
+
+	out, err := s.run(ctx, r.Version)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to determine version of %v", r.Name)
+	}
+
+	s.version = firstLine(out)
+
+	for _, st := range r.Before {
+		if _, err := s.run(ctx, st); err != nil {
+			s.Finish(ctx)
+
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Info returns information about the application recorded in snapshot manifests.
+func (s *Session) Info() *snapshot.ApplicationInfo {
+	return &snapshot.ApplicationInfo{
+		Recipe:  s.recipe.Name,
+		Version: s.version,
+	}
+}
+
+// Err returns the first error encountered by a dump command of the session.
+// Snapshots of sessions whose dumps have failed are not application-consistent and should be discarded.
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+func (s *Session) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// Finish runs the steps of the recipe that follow taking the snapshot, failures are logged.
+func (s *Session) Finish(ctx context.Context) {
+	for _, st := range s.recipe.After {
+		if _, err := s.run(ctx, st); err != nil {
+			log(ctx).Warningf("%v", err)
+		}
+	}
+}
+
+func (s *Session) command(ctx context.Context, st Step) *exec.Cmd {
+	var args []string
+
+	for _, a := range st.Command[1:] {
+		if a == ArgsPlaceholder {
+			args = append(args, s.args...)
+		} else {
+			args = append(args, a)
+		}
+	}
+
+	return exec.CommandContext(ctx, st.Command[0], args...) //nolint:gosec
+}
+
+func (s *Session) run(ctx context.Context, st Step) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := s.command(ctx, st)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	log(ctx).Debugf("running %v", cmd.Args)
+
+	if err := cmd.Run(); err != nil {
+		return "", commandError(cmd, err, stderr.String())
+	}
+
+	return stdout.String(), nil
+}
+
+func commandError(cmd *exec.Cmd, err error, stderr string) error {
+	stderr = strings.TrimSpace(stderr)
+	if len(stderr) > maxStderrLength {
+		stderr = stderr[len(stderr)-maxStderrLength:]
+	}
+
+	if stderr == "" {
+		return errors.Wrapf(err, "%v failed", cmd.Args[0])
+	}
+
+	return errors.Wrapf(err, "%v failed: %v", cmd.Args[0], stderr)
+}
+
+// tailBuffer is an io.Writer that retains the last maxStderrLength bytes written to it.
+type tailBuffer struct {
+	b []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.b = append(t.b, p...)
+	if len(t.b) > maxStderrLength {
+		t.b = append(t.b[:0], t.b[len(t.b)-maxStderrLength:]...)
+	}
+
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	return string(t.b)
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+
+	if p := strings.IndexByte(s, '\n'); p >= 0 {
+		s = s[0:p]
+	}
+
+	return strings.TrimSpace(s)
+}
+
+// StartForPolicy starts a session using the application recipe selected by the provided policy.
+// It returns nil if the policy does not select a recipe.
+func StartForPolicy(ctx context.Context, pol *policy.Policy) (*Session, error) {
+	if pol.ApplicationPolicy.Recipe == "" {
+		return nil, nil
+	}
+
+	return Start(ctx, pol.ApplicationPolicy.Recipe, pol.ApplicationPolicy.Args)
+}
@@ -0,0 +1,149 @@
+// Package legalhold manages legal holds, which protect individual snapshots from being deleted,
+// expired or modified until the hold is cleared.
+package legalhold
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+)
+
+// ManifestType is the value of the "type" label for legal hold manifests.
+const ManifestType = "legalhold"
+
+const snapshotIDLabel = "snapshotID"
+
+// ErrHeld is returned when attempting to delete or modify a snapshot which is under legal hold.
+var ErrHeld = errors.New("snapshot is under legal hold")
+
+// Hold describes a legal hold of a single snapshot.
+type Hold struct {
+	SnapshotID manifest.ID         `json:"snapshotID"`
+	Source     snapshot.SourceInfo `json:"source"`
+	StartTime  time.Time           `json:"startTime"`
+
+	// RootEntry keeps the contents of the snapshot alive even if its manifest is removed
+	// using low-level commands.
+	RootEntry *snapshot.DirEntry `json:"rootEntry"`
+
+	Reason string    `json:"reason,omitempty"`
+	HeldBy string    `json:"heldBy"`
+	Since  time.Time `json:"since"`
+}
+
+func labels(snapshotID manifest.ID) map[string]string {
+	l := map[string]string{
+		manifest.TypeLabelKey: ManifestType,
+	}
+
+	if snapshotID != "" {
+		l[snapshotIDLabel] = string(snapshotID)
+	}
+
+	return l
+}
+
+// List returns all legal holds in the repository.
+func List(ctx context.Context, rep repo.Repository) ([]*Hold, error) {
+	return find(ctx, rep, "")
+}
+
+// Get returns the legal hold of the provided snapshot or nil if the snapshot is not held.
+func Get(ctx context.Context, rep repo.Repository, snapshotID manifest.ID) (*Hold, error) {
+	holds, err := find(ctx, rep, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(holds) == 0 {
+		return nil, nil
+	}
+
+	return holds[0], nil
+}
+
+// HeldSnapshotIDs returns the set of IDs of snapshots under legal hold.
+func HeldSnapshotIDs(ctx context.Context, rep repo.Repository) (map[manifest.ID]*Hold, error) {
+	holds, err := List(ctx, rep)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[manifest.ID]*Hold{}
+
+	for _, h := range holds {
+		result[h.SnapshotID] = h
+	}
+
+	return result, nil
+}
+
+func find(ctx context.Context, rep repo.Repository, snapshotID manifest.ID) ([]*Hold, error) {
+	entries, err := rep.FindManifests(ctx, labels(snapshotID))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to find legal hold manifests")
+	}
+
+	var result []*Hold
+
+	for _, e := range entries {
+		h := &Hold{}
+		if _, err := rep.GetManifest(ctx, e.ID, h); err != nil {
+			return nil, errors.Wrap(err, "unable to load legal hold manifest")
+		}
+
+		result = append(result, h)
+	}
+
+	return result, nil
+}
+
+// Set places the provided snapshot under legal hold, replacing its existing hold, if any.
+func Set(ctx context.Context, rep repo.Repository, m *snapshot.Manifest, reason string) (*Hold, error) {
+	if err := deleteAll(ctx, rep, m.ID); err != nil {
+		return nil, err
+	}
+
+	co := rep.ClientOptions()
+
+	h := &Hold{
+		SnapshotID: m.ID,
+		Source:     m.Source,
+		StartTime:  m.StartTime,
+		RootEntry:  m.RootEntry,
+		Reason:     reason,
+		HeldBy:     co.Username + "@" + co.Hostname,
+		Since:      rep.Time(),
+	}
+
+	if _, err := rep.PutManifest(ctx, labels(m.ID), h); err != nil {
+		return nil, errors.Wrap(err, "unable to save legal hold manifest")
+	}
+
+	return h, nil
+}
+
+// Clear releases the legal hold of the provided snapshot.
+func Clear(ctx context.Context, rep repo.Repository, snapshotID manifest.ID) error {
+	return deleteAll(ctx, rep, snapshotID)
+}
+
+func deleteAll(ctx context.Context, rep repo.Repository, snapshotID manifest.ID) error {
+	entries, err := rep.FindManifests(ctx, labels(snapshotID))
+	if err != nil {
+		return errors.Wrap(err, "unable to find legal hold manifests")
+	}
+
+	for _, e := range entries {
+		if err := rep.DeleteManifest(ctx, e.ID); err != nil {
+			return errors.Wrap(err, "unable to delete legal hold manifest")
+		}
+	}
+
+	return nil
+}
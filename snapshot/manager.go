@@ -125,6 +125,23 @@ func SaveSnapshot(ctx context.Context, rep repo.Repository, man *Manifest) (mani
 	return id, nil
 }
 
+// UpdateSnapshot replaces the manifest of an existing snapshot, keeping its ID.
+func UpdateSnapshot(ctx context.Context, rep repo.Repository, man *Manifest) error {
+	if man.ID == "" {
+		return errors.New("missing snapshot ID")
+	}
+
+	if err := rep.ReplaceManifest(ctx, man.ID, sourceInfoToLabels(man.Source), man); err != nil {
+		if errors.Is(err, manifest.ErrNotFound) {
+			return ErrSnapshotNotFound
+		}
+
+		return errors.Wrap(err, "unable to replace snapshot manifest")
+	}
+
+	return nil
+}
+
 // LoadSnapshots efficiently loads and parses a given list of snapshot IDs.
 func LoadSnapshots(ctx context.Context, rep repo.Repository, manifestIDs []manifest.ID) ([]*Manifest, error) {
 	result := make([]*Manifest, len(manifestIDs))
@@ -4,6 +4,7 @@
 	"encoding/json"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/kopia/kopia/fs"
@@ -25,9 +26,40 @@ type Manifest struct {
 
 	RootEntry *DirEntry `json:"rootEntry"`
 
+	// RedactedPaths contains paths that were removed from the snapshot after it had been taken.
+	RedactedPaths []string `json:"redactedPaths,omitempty"`
+
+	// Supersedes contains IDs of manifests that were replaced by this one when redacting the snapshot.
+	Supersedes []manifest.ID `json:"supersedes,omitempty"`
+
+	// Group is the name of the snapshot group the snapshot was created in, see package snapshotgroup.
+	Group string `json:"group,omitempty"`
+
+	// Application describes the application whose dumps were snapshotted using a recipe, see package apprecipe.
+	Application *ApplicationInfo `json:"application,omitempty"`
+
+	// Pins contains names of pins which protect the snapshot from being expired by the retention policy.
+	Pins []string `json:"pins,omitempty"`
+
+	// Anomalies contains unusual changes compared to previous snapshots of the source detected when the snapshot was taken.
+	Anomalies []Anomaly `json:"anomalies,omitempty"`
+
 	RetentionReasons []string `json:"-"`
 }
 
+// ApplicationInfo describes the application snapshotted using a recipe.
+type ApplicationInfo struct {
+	Recipe  string `json:"recipe"`
+	Version string `json:"version,omitempty"`
+}
+
+// Anomaly describes an unusual change of the source compared to its previous snapshots, such as mass deletion
+// or encryption of files.
+type Anomaly struct {
+	Kind        string `json:"kind"`
+	Description string `json:"description"`
+}
+
 // EntryType is a type of a filesystem entry.
 type EntryType string
 
@@ -82,6 +114,10 @@ type DirEntry struct {
 	GroupID     uint32               `json:"gid,omitempty"`
 	ObjectID    object.ID            `json:"obj,omitempty"`
 	DirSummary  *fs.DirectorySummary `json:"summ,omitempty"`
+
+	// SecurityDescriptor is the Windows security descriptor of the entry in SDDL format,
+	// only captured when enabled by the files policy.
+	SecurityDescriptor string `json:"sd,omitempty"`
 }
 
 // HasDirEntry is implemented by objects that have a DirEntry associated with them.
@@ -92,10 +128,16 @@ type HasDirEntry interface {
 // DirManifest represents serialized contents of a directory.
 // The entries are sorted lexicographically and summary only refers to properties of
 // entries, so directory with the same contents always serializes to exactly the same JSON.
+//
+// Delta manifests only store entries that were added or changed and names of entries that were removed
+// compared to the base directory object, while the summary always describes the entire directory.
 type DirManifest struct {
-	StreamType string               `json:"stream"` // legacy
-	Entries    []*DirEntry          `json:"entries"`
-	Summary    *fs.DirectorySummary `json:"summary"`
+	StreamType     string               `json:"stream"` // legacy
+	BaseObjectID   object.ID            `json:"base,omitempty"`
+	DeltaDepth     int                  `json:"deltaDepth,omitempty"`
+	Entries        []*DirEntry          `json:"entries"`
+	RemovedEntries []string             `json:"removed,omitempty"`
+	Summary        *fs.DirectorySummary `json:"summary"`
 }
 
 // RootObjectID returns the ID of a root object.
@@ -107,6 +149,40 @@ func (m *Manifest) RootObjectID() object.ID {
 	return ""
 }
 
+// UpdatePins adds and removes the provided pins and returns true if the pins have changed.
+// Pins are kept sorted and without duplicates.
+func (m *Manifest) UpdatePins(add, remove []string) bool {
+	pins := map[string]bool{}
+
+	for _, p := range m.Pins {
+		pins[p] = true
+	}
+
+	for _, p := range add {
+		pins[p] = true
+	}
+
+	for _, p := range remove {
+		delete(pins, p)
+	}
+
+	var result []string
+
+	for p := range pins {
+		result = append(result, p)
+	}
+
+	sort.Strings(result)
+
+	if strings.Join(result, "\x00") == strings.Join(m.Pins, "\x00") {
+		return false
+	}
+
+	m.Pins = result
+
+	return true
+}
+
 // GroupBySource returns a slice of slices, such that each result item contains manifests from a single source.
 func GroupBySource(manifests []*Manifest) [][]*Manifest {
 	resultMap := map[SourceInfo][]*Manifest{}
@@ -0,0 +1,244 @@
+package policy
+
+import (
+	"context"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/snapshothealth"
+)
+
+// ransomwareCheckSnapshots is the number of most recent complete snapshots of a source checked for signs of
+// ransomware before expiring its snapshots.
+const ransomwareCheckSnapshots = 3
+
+// AnomalyRetentionReason is the retention reason of snapshots protected because a later snapshot of the
+// same source has unacknowledged anomalies.
+const AnomalyRetentionReason = "anomaly-safeguard"
+
+// AnomalyPolicy controls detection of anomalies of snapshots, such as mass deletion or encryption of files.
+type AnomalyPolicy struct {
+	// Detect controls whether anomalies are detected when taking snapshots.
+	Detect *bool `json:"detect,omitempty"`
+
+	// MaxFileCountDropPercent is the maximum decrease of the number of files compared to previous snapshots.
+	MaxFileCountDropPercent *int `json:"maxFileCountDropPercent,omitempty"`
+
+	// MaxSizeDropPercent is the maximum decrease of the total size of files compared to previous snapshots.
+	MaxSizeDropPercent *int `json:"maxSizeDropPercent,omitempty"`
+
+	// MaxChangedFilesPercent is the maximum percentage of files changed since the previous snapshot.
+	MaxChangedFilesPercent *int `json:"maxChangedFilesPercent,omitempty"`
+
+	// MaxHighEntropyIncreasePercent is the maximum increase of the percentage of changed files that look encrypted.
+	MaxHighEntropyIncreasePercent *int `json:"maxHighEntropyIncreasePercent,omitempty"`
+
+	// MaxExtensionChangePercent is the maximum increase of the percentage of files renamed to a different extension.
+	MaxExtensionChangePercent *int `json:"maxExtensionChangePercent,omitempty"`
+
+	// PreventExpiration controls whether snapshots taken before a snapshot with unacknowledged anomalies are kept.
+	PreventExpiration *bool `json:"preventExpiration,omitempty"`
+
+	// PauseExpirationOnRansomware controls whether expiration of snapshots of a source is paused entirely
+	// while its most recent snapshots show unacknowledged signs of ransomware.
+	PauseExpirationOnRansomware *bool `json:"pauseExpirationOnRansomware,omitempty"`
+}
+
+// Merge applies default values from the provided policy.
+func (p *AnomalyPolicy) Merge(src AnomalyPolicy) {
+	if p.Detect == nil && src.Detect != nil {
+		p.Detect = newBool(*src.Detect)
+	}
+
+	if p.MaxFileCountDropPercent == nil && src.MaxFileCountDropPercent != nil {
+		p.MaxFileCountDropPercent = intPtr(*src.MaxFileCountDropPercent)
+	}
+
+	if p.MaxSizeDropPercent == nil && src.MaxSizeDropPercent != nil {
+		p.MaxSizeDropPercent = intPtr(*src.MaxSizeDropPercent)
+	}
+
+	if p.MaxChangedFilesPercent == nil && src.MaxChangedFilesPercent != nil {
+		p.MaxChangedFilesPercent = intPtr(*src.MaxChangedFilesPercent)
+	}
+
+	if p.MaxHighEntropyIncreasePercent == nil && src.MaxHighEntropyIncreasePercent != nil {
+		p.MaxHighEntropyIncreasePercent = intPtr(*src.MaxHighEntropyIncreasePercent)
+	}
+
+	if p.MaxExtensionChangePercent == nil && src.MaxExtensionChangePercent != nil {
+		p.MaxExtensionChangePercent = intPtr(*src.MaxExtensionChangePercent)
+	}
+
+	if p.PreventExpiration == nil && src.PreventExpiration != nil {
+		p.PreventExpiration = newBool(*src.PreventExpiration)
+	}
+
+	if p.PauseExpirationOnRansomware == nil && src.PauseExpirationOnRansomware != nil {
+		p.PauseExpirationOnRansomware = newBool(*src.PauseExpirationOnRansomware)
+	}
+}
+
+// DetectOrDefault returns the detect setting if set, and returns the passed default if not.
+func (p *AnomalyPolicy) DetectOrDefault(def bool) bool {
+	if p.Detect == nil {
+		return def
+	}
+
+	return *p.Detect
+}
+
+// PreventExpirationOrDefault returns the prevent-expiration setting if set, and returns the passed default if not.
+func (p *AnomalyPolicy) PreventExpirationOrDefault(def bool) bool {
+	if p.PreventExpiration == nil {
+		return def
+	}
+
+	return *p.PreventExpiration
+}
+
+// PauseExpirationOnRansomwareOrDefault returns the pause-expiration-on-ransomware setting if set, and returns
+// the passed default if not.
+func (p *AnomalyPolicy) PauseExpirationOnRansomwareOrDefault(def bool) bool {
+	if p.PauseExpirationOnRansomware == nil {
+		return def
+	}
+
+	return *p.PauseExpirationOnRansomware
+}
+
+// DetectionOptions returns anomaly detection thresholds, unset thresholds disable the corresponding check.
+func (p *AnomalyPolicy) DetectionOptions() snapshothealth.Options {
+	intOrZero := func(v *int) int {
+		if v == nil {
+			return 0
+		}
+
+		return *v
+	}
+
+	return snapshothealth.Options{
+		MaxFileCountDropPercent:       intOrZero(p.MaxFileCountDropPercent),
+		MaxSizeDropPercent:            intOrZero(p.MaxSizeDropPercent),
+		MaxChangedFilesPercent:        intOrZero(p.MaxChangedFilesPercent),
+		MaxHighEntropyIncreasePercent: intOrZero(p.MaxHighEntropyIncreasePercent),
+		MaxExtensionChangePercent:     intOrZero(p.MaxExtensionChangePercent),
+	}
+}
+
+// defaultAnomalyPolicy is the default anomaly policy, detection and expiration safeguard must be enabled explicitly.
+var defaultAnomalyPolicy = AnomalyPolicy{
+	Detect:                        newBool(false),
+	MaxFileCountDropPercent:       intPtr(50), // nolint:gomnd
+	MaxSizeDropPercent:            intPtr(50), // nolint:gomnd
+	MaxChangedFilesPercent:        intPtr(80), // nolint:gomnd
+	MaxHighEntropyIncreasePercent: intPtr(50), // nolint:gomnd
+	MaxExtensionChangePercent:     intPtr(20), // nolint:gomnd
+	PreventExpiration:             newBool(false),
+	PauseExpirationOnRansomware:   newBool(true),
+}
+
+// MarkAnomalousSnapshots adds anomaly safeguard retention reason to the provided snapshots of a single source
+// taken before its most recent snapshot with unacknowledged anomalies, so that the last good snapshots are not
+// expired after files have been deleted or encrypted.
+func MarkAnomalousSnapshots(ctx context.Context, rep repo.Repository, snapshots []*snapshot.Manifest, pol *Policy) error {
+	if !pol.AnomalyPolicy.PreventExpirationOrDefault(false) {
+		return nil
+	}
+
+	acked, err := snapshothealth.AcknowledgedSnapshotIDs(ctx, rep)
+	if err != nil {
+		return err
+	}
+
+	var newest *snapshot.Manifest
+
+	for _, s := range snapshots {
+		if len(s.Anomalies) == 0 || acked[s.ID] {
+			continue
+		}
+
+		if newest == nil || s.StartTime.After(newest.StartTime) {
+			newest = s
+		}
+	}
+
+	if newest == nil {
+		return nil
+	}
+
+	for _, s := range snapshots {
+		if s.StartTime.After(newest.StartTime) || hasRetentionReason(s, AnomalyRetentionReason) {
+			continue
+		}
+
+		s.RetentionReasons = append(s.RetentionReasons, AnomalyRetentionReason)
+	}
+
+	return nil
+}
+
+// RansomwareIndicators returns signs of ransomware, such as files rewritten with random-looking contents or renamed
+// to a different extension, in the provided snapshot compared to the history of its source. Anomalies recorded when
+// the snapshot was taken are combined with those found using the current policy, so that snapshots taken without
+// anomaly detection are checked as well.
+func RansomwareIndicators(m *snapshot.Manifest, history []*snapshot.Manifest, pol *Policy) []snapshot.Anomaly {
+	var (
+		result []snapshot.Anomaly
+		seen   = map[string]bool{}
+	)
+
+	candidates := append([]snapshot.Anomaly(nil), m.Anomalies...)
+	candidates = append(candidates, snapshothealth.Evaluate(m, history, pol.AnomalyPolicy.DetectionOptions()).Anomalies...)
+
+	for _, a := range candidates {
+		if !snapshothealth.IsRansomwareIndicator(a.Kind) || seen[a.Kind] {
+			continue
+		}
+
+		seen[a.Kind] = true
+
+		result = append(result, a)
+	}
+
+	return result
+}
+
+// suspectedRansomwareSnapshot returns the most recent of the latest complete snapshots of a single source that shows
+// unacknowledged signs of ransomware along with those signs, or nil if there is no such snapshot.
+func suspectedRansomwareSnapshot(ctx context.Context, rep repo.Repository, snapshots []*snapshot.Manifest, pol *Policy) (*snapshot.Manifest, []snapshot.Anomaly, error) {
+	if !pol.AnomalyPolicy.PauseExpirationOnRansomwareOrDefault(true) {
+		return nil, nil, nil
+	}
+
+	var latest []*snapshot.Manifest
+
+	for _, s := range snapshot.SortByTime(snapshots, true) {
+		if s.IncompleteReason != "" {
+			continue
+		}
+
+		latest = append(latest, s)
+
+		if len(latest) >= ransomwareCheckSnapshots {
+			break
+		}
+	}
+
+	acked, err := snapshothealth.AcknowledgedSnapshotIDs(ctx, rep)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, s := range latest {
+		if acked[s.ID] {
+			continue
+		}
+
+		if indicators := RansomwareIndicators(s, snapshots, pol); len(indicators) > 0 {
+			return s, indicators, nil
+		}
+	}
+
+	return nil, nil, nil
+}
@@ -0,0 +1,182 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/snapshothealth"
+)
+
+func TestAnomalyExpirationSafeguard(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	src := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/some/path"}
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var manifests []*snapshot.Manifest
+
+	for i := 0; i < 4; i++ {
+		m := &snapshot.Manifest{
+			Source:    src,
+			StartTime: base.Add(time.Duration(i) * time.Minute),
+			RootEntry: &snapshot.DirEntry{Type: snapshot.EntryTypeDirectory, ObjectID: "k1234"},
+		}
+
+		if i == 2 {
+			m.Anomalies = []snapshot.Anomaly{{Kind: snapshothealth.AnomalyFileCountDrop, Description: "files deleted"}}
+		}
+
+		if _, err := snapshot.SaveSnapshot(ctx, env.Repository, m); err != nil {
+			t.Fatal(err)
+		}
+
+		manifests = append(manifests, m)
+	}
+
+	retention := RetentionPolicy{
+		KeepLatest:  intPtr(1),
+		KeepHourly:  intPtr(0),
+		KeepDaily:   intPtr(0),
+		KeepWeekly:  intPtr(0),
+		KeepMonthly: intPtr(0),
+		KeepAnnual:  intPtr(0),
+	}
+
+	must(t, SetPolicy(ctx, env.Repository, src, &Policy{
+		RetentionPolicy: retention,
+	}))
+
+	expired, err := ApplyRetentionPolicy(ctx, env.Repository, src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the safeguard is opt-in.
+	if len(expired) != 3 {
+		t.Errorf("unexpected expired snapshots with default policy: %v", expired)
+	}
+
+	safeguard := &Policy{
+		RetentionPolicy: retention,
+		AnomalyPolicy:   AnomalyPolicy{PreventExpiration: newBool(true)},
+	}
+
+	must(t, SetPolicy(ctx, env.Repository, src, safeguard))
+
+	expired, err = ApplyRetentionPolicy(ctx, env.Repository, src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the anomalous snapshot and all snapshots before it are kept, the latest is retained by policy.
+	if len(expired) != 0 {
+		t.Errorf("unexpected expired snapshots: %v", expired)
+	}
+
+	must(t, snapshothealth.Acknowledge(ctx, env.Repository, manifests[2]))
+
+	expired, err = ApplyRetentionPolicy(ctx, env.Repository, src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expired) != 3 {
+		t.Errorf("unexpected expired snapshots after acknowledging anomalies: %v", expired)
+	}
+}
+
+func TestRansomwareExpirationGate(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	src := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/some/path"}
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var manifests []*snapshot.Manifest
+
+	for i := 0; i < 5; i++ {
+		m := &snapshot.Manifest{
+			Source:    src,
+			StartTime: base.Add(time.Duration(i) * time.Minute),
+			RootEntry: &snapshot.DirEntry{
+				Type:       snapshot.EntryTypeDirectory,
+				ObjectID:   "k1234",
+				DirSummary: &fs.DirectorySummary{TotalFileCount: 1000, TotalFileSize: 1e6},
+			},
+			Stats: snapshot.Stats{CachedFiles: 990, NonCachedFiles: 10},
+		}
+
+		// the latest snapshot was taken without anomaly detection while files were renamed by ransomware.
+		if i == 4 {
+			m.Stats = snapshot.Stats{CachedFiles: 400, NonCachedFiles: 600, ExtensionChangedFiles: 600}
+		}
+
+		if _, err := snapshot.SaveSnapshot(ctx, env.Repository, m); err != nil {
+			t.Fatal(err)
+		}
+
+		manifests = append(manifests, m)
+	}
+
+	retention := RetentionPolicy{
+		KeepLatest:  intPtr(1),
+		KeepHourly:  intPtr(0),
+		KeepDaily:   intPtr(0),
+		KeepWeekly:  intPtr(0),
+		KeepMonthly: intPtr(0),
+		KeepAnnual:  intPtr(0),
+	}
+
+	must(t, SetPolicy(ctx, env.Repository, src, &Policy{
+		RetentionPolicy: retention,
+	}))
+
+	expired, err := ApplyRetentionPolicy(ctx, env.Repository, src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expired) != 0 {
+		t.Errorf("unexpected expired snapshots: %v", expired)
+	}
+
+	must(t, SetPolicy(ctx, env.Repository, src, &Policy{
+		RetentionPolicy: retention,
+		AnomalyPolicy:   AnomalyPolicy{PauseExpirationOnRansomware: newBool(false)},
+	}))
+
+	expired, err = ApplyRetentionPolicy(ctx, env.Repository, src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expired) != 4 {
+		t.Errorf("unexpected expired snapshots with gate disabled: %v", expired)
+	}
+
+	must(t, SetPolicy(ctx, env.Repository, src, &Policy{
+		RetentionPolicy: retention,
+	}))
+
+	must(t, snapshothealth.Acknowledge(ctx, env.Repository, manifests[4]))
+
+	expired, err = ApplyRetentionPolicy(ctx, env.Repository, src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expired) != 4 {
+		t.Errorf("unexpected expired snapshots after acknowledging anomalies: %v", expired)
+	}
+}
@@ -0,0 +1,20 @@
+package policy
+
+// ApplicationPolicy selects a built-in recipe which takes application-consistent snapshots of a database
+// by streaming its dumps instead of reading files of the source.
+type ApplicationPolicy struct {
+	// Recipe is the name of the recipe, such as "postgresql", "mysql" or "mongodb".
+	Recipe string `json:"recipe,omitempty"`
+
+	// Args are passed to commands of the recipe which connect to the application, typically to specify connection parameters.
+	Args []string `json:"args,omitempty"`
+}
+
+// Merge applies default values from the provided policy.
+func (p *ApplicationPolicy) Merge(src ApplicationPolicy) {
+	// arguments only make sense for the recipe they were specified with.
+	if p.Recipe == "" {
+		p.Recipe = src.Recipe
+		p.Args = src.Args
+	}
+}
@@ -0,0 +1,209 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/pkg/errors"
+
+	kopiaunits "github.com/kopia/kopia/internal/units"
+)
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+const minutesPerDay = 24 * 60
+
+// BandwidthWindow limits upload and download speed during the time of day window on selected days of week.
+// Windows which end before they start span midnight, windows which end when they start last all day.
+type BandwidthWindow struct {
+	// Days contains abbreviated names of days of week ("mon", "tue", ...) when the window starts, all days if empty.
+	Days  []string  `json:"days,omitempty"`
+	Start TimeOfDay `json:"start"`
+	End   TimeOfDay `json:"end"`
+
+	// Maximum speed within the window, zero means unlimited.
+	MaxUploadBytesPerSecond   int64 `json:"maxUploadBytesPerSecond,omitempty"`
+	MaxDownloadBytesPerSecond int64 `json:"maxDownloadBytesPerSecond,omitempty"`
+}
+
+// Parse parses the bandwidth window in the format "[DAYS ]HH:MM-HH:MM [upload=SPEED] [download=SPEED]",
+// where DAYS is a comma-separated list of days of week or ranges, such as "mon-fri" or "sat,sun"
+// and SPEED is the number of bytes per second, such as "10MB" or "512KiB".
+func (w *BandwidthWindow) Parse(s string) error {
+	*w = BandwidthWindow{}
+
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return errors.New("empty bandwidth window")
+	}
+
+	if !strings.Contains(fields[0], ":") {
+		days, err := parseDaysOfWeek(fields[0])
+		if err != nil {
+			return err
+		}
+
+		w.Days = days
+		fields = fields[1:]
+	}
+
+	if len(fields) == 0 {
+		return errors.New("missing time range, must be HH:MM-HH:MM")
+	}
+
+	parts := strings.Split(fields[0], "-")
+	if len(parts) != 2 { // nolint:gomnd
+		return errors.Errorf("invalid time range %q, must be HH:MM-HH:MM", fields[0])
+	}
+
+	if err := w.Start.Parse(parts[0]); err != nil {
+		return err
+	}
+
+	if err := w.End.Parse(parts[1]); err != nil {
+		return err
+	}
+
+	for _, f := range fields[1:] {
+		kv := strings.SplitN(f, "=", 2) // nolint:gomnd
+		if len(kv) != 2 {               // nolint:gomnd
+			return errors.Errorf("invalid limit %q, must be upload=SPEED or download=SPEED", f)
+		}
+
+		v, err := units.ParseStrictBytes(kv[1])
+		if err != nil {
+			return errors.Wrapf(err, "invalid speed %q", kv[1])
+		}
+
+		if v < 0 {
+			return errors.Errorf("invalid speed %q", kv[1])
+		}
+
+		switch kv[0] {
+		case "upload":
+			w.MaxUploadBytesPerSecond = v
+		case "download":
+			w.MaxDownloadBytesPerSecond = v
+		default:
+			return errors.Errorf("invalid limit %q, must be upload=SPEED or download=SPEED", f)
+		}
+	}
+
+	return nil
+}
+
+func parseDaysOfWeek(s string) ([]string, error) {
+	selected := map[int]bool{}
+
+	for _, item := range strings.Split(strings.ToLower(s), ",") {
+		parts := strings.Split(item, "-")
+
+		first, ok := weekdayIndex(parts[0])
+		if !ok || len(parts) > 2 { // nolint:gomnd
+			return nil, errors.Errorf("invalid days of week %q, must be a list of days such as mon-fri or sat,sun", s)
+		}
+
+		last := first
+
+		if len(parts) == 2 { // nolint:gomnd
+			if last, ok = weekdayIndex(parts[1]); !ok {
+				return nil, errors.Errorf("invalid days of week %q, must be a list of days such as mon-fri or sat,sun", s)
+			}
+		}
+
+		// ranges such as fri-mon wrap around the end of the week.
+		for d := first; ; d = (d + 1) % len(weekdayNames) {
+			selected[d] = true
+
+			if d == last {
+				break
+			}
+		}
+	}
+
+	// list days starting on monday.
+	var result []string
+
+	for i := 1; i <= len(weekdayNames); i++ {
+		if d := i % len(weekdayNames); selected[d] {
+			result = append(result, weekdayNames[d])
+		}
+	}
+
+	return result, nil
+}
+
+func weekdayIndex(name string) (int, bool) {
+	for i, n := range weekdayNames {
+		if n == name {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// String returns string representation of the bandwidth window.
+func (w BandwidthWindow) String() string {
+	days := "every day"
+	if len(w.Days) > 0 {
+		days = strings.Join(w.Days, ",")
+	}
+
+	return fmt.Sprintf("%v %v-%v upload: %v download: %v", days, w.Start, w.End, speedString(w.MaxUploadBytesPerSecond), speedString(w.MaxDownloadBytesPerSecond))
+}
+
+func speedString(bytesPerSecond int64) string {
+	if bytesPerSecond <= 0 {
+		return "unlimited"
+	}
+
+	return kopiaunits.BytesStringBase10(bytesPerSecond) + "/s"
+}
+
+func (w BandwidthWindow) startsOn(d time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+
+	for _, n := range w.Days {
+		if n == weekdayNames[d] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Contains returns true if the provided local time falls within the window.
+func (w BandwidthWindow) Contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()             // nolint:gomnd
+	start := w.Start.Hour*60 + w.Start.Minute // nolint:gomnd
+	end := w.End.Hour*60 + w.End.Minute       // nolint:gomnd
+
+	if end <= start {
+		end += minutesPerDay
+	}
+
+	// the window may have started on the previous day.
+	if m < start {
+		m += minutesPerDay
+		t = t.AddDate(0, 0, -1)
+	}
+
+	return m < end && w.startsOn(t.Weekday())
+}
+
+// BandwidthLimitsAt returns the upload and download speed limits of the first window of the schedule
+// that contains the provided time, or zeros (unlimited) outside of all windows.
+func BandwidthLimitsAt(schedule []BandwidthWindow, t time.Time) (upload, download int64) {
+	for _, w := range schedule {
+		if w.Contains(t) {
+			return w.MaxUploadBytesPerSecond, w.MaxDownloadBytesPerSecond
+		}
+	}
+
+	return 0, 0
+}
@@ -0,0 +1,125 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBandwidthWindowParse(t *testing.T) {
+	cases := []struct {
+		input   string
+		want    BandwidthWindow
+		wantErr bool
+	}{
+		{
+			input: "mon-fri 08:00-18:00 upload=10MB",
+			want: BandwidthWindow{
+				Days:                    []string{"mon", "tue", "wed", "thu", "fri"},
+				Start:                   TimeOfDay{8, 0},
+				End:                     TimeOfDay{18, 0},
+				MaxUploadBytesPerSecond: 10000000,
+			},
+		},
+		{
+			input: "sun,fri-sat 22:30-06:00 download=1MiB upload=512KiB",
+			want: BandwidthWindow{
+				Days:                      []string{"fri", "sat", "sun"},
+				Start:                     TimeOfDay{22, 30},
+				End:                       TimeOfDay{6, 0},
+				MaxUploadBytesPerSecond:   512 << 10,
+				MaxDownloadBytesPerSecond: 1 << 20,
+			},
+		},
+		{
+			input: "00:00-00:00",
+			want:  BandwidthWindow{},
+		},
+		{input: "", wantErr: true},
+		{input: "mon-fri", wantErr: true},
+		{input: "monday 8:00-18:00", wantErr: true},
+		{input: "8:00 upload=1MB", wantErr: true},
+		{input: "8:00-25:00", wantErr: true},
+		{input: "8:00-18:00 upload=fast", wantErr: true},
+		{input: "8:00-18:00 both=1MB", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		var w BandwidthWindow
+
+		err := w.Parse(tc.input)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("expected error when parsing %q", tc.input)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("unable to parse %q: %v", tc.input, err)
+			continue
+		}
+
+		if diff := cmp.Diff(w, tc.want); diff != "" {
+			t.Errorf("unexpected result of parsing %q: %v", tc.input, diff)
+		}
+	}
+}
+
+func TestBandwidthLimitsAt(t *testing.T) {
+	var workHours, nights BandwidthWindow
+
+	if err := workHours.Parse("mon-fri 8:00-18:00 upload=10MB download=20MB"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := nights.Parse("fri 22:00-6:00 upload=1MB"); err != nil {
+		t.Fatal(err)
+	}
+
+	schedule := []BandwidthWindow{workHours, nights}
+
+	cases := []struct {
+		time         string
+		wantUpload   int64
+		wantDownload int64
+	}{
+		{"2021-03-01 08:00", 10000000, 20000000}, // monday
+		{"2021-03-01 17:59", 10000000, 20000000},
+		{"2021-03-01 18:00", 0, 0},
+		{"2021-03-01 07:59", 0, 0},
+		{"2021-03-05 23:00", 1000000, 0}, // friday night
+		{"2021-03-06 05:59", 1000000, 0}, // saturday morning, window started on friday
+		{"2021-03-06 06:00", 0, 0},
+		{"2021-03-06 12:00", 0, 0},
+		{"2021-03-04 23:00", 0, 0}, // thursday night
+	}
+
+	for _, tc := range cases {
+		ts, err := time.ParseInLocation("2006-01-02 15:04", tc.time, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		up, down := BandwidthLimitsAt(schedule, ts)
+		if up != tc.wantUpload || down != tc.wantDownload {
+			t.Errorf("unexpected limits at %v: %v/%v, want %v/%v", tc.time, up, down, tc.wantUpload, tc.wantDownload)
+		}
+	}
+}
+
+func TestUploadPolicyMergeBandwidthSchedule(t *testing.T) {
+	parent := UploadPolicy{BandwidthSchedule: []BandwidthWindow{{MaxUploadBytesPerSecond: 1}}}
+	child := UploadPolicy{BandwidthSchedule: []BandwidthWindow{{MaxUploadBytesPerSecond: 2}}}
+
+	var p UploadPolicy
+
+	p.Merge(child)
+	p.Merge(parent)
+
+	if diff := cmp.Diff(p.BandwidthSchedule, child.BandwidthSchedule); diff != "" {
+		t.Errorf("schedule of the child policy was not used: %v", diff)
+	}
+}
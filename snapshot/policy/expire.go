@@ -31,6 +31,62 @@ func ApplyRetentionPolicy(ctx context.Context, rep repo.Repository, sourceInfo s
 	return toDelete, nil
 }
 
+// ExpirationCandidates returns snapshots of a single source that may be expired regardless of retention rules,
+// such as to enforce a storage budget. Snapshots that are pinned, under legal hold or kept as anomaly safeguards
+// are never returned, neither is the latest complete snapshot, nor any snapshots while expiration of the source
+// is paused because of suspected ransomware. Retention reasons of the provided snapshots are overwritten.
+func ExpirationCandidates(ctx context.Context, rep repo.Repository, snapshots []*snapshot.Manifest) ([]*snapshot.Manifest, error) {
+	if len(snapshots) == 0 {
+		return nil, nil
+	}
+
+	pol, _, err := GetEffectivePolicy(ctx, rep, snapshots[0].Source)
+	if err != nil {
+		return nil, err
+	}
+
+	if pol.RetentionPolicy.LegalHoldOrDefault(false) {
+		return nil, nil
+	}
+
+	if suspect, _, err := suspectedRansomwareSnapshot(ctx, rep, snapshots, pol); err != nil || suspect != nil {
+		return nil, err
+	}
+
+	for _, s := range snapshots {
+		s.RetentionReasons = nil
+
+		if len(s.Pins) > 0 {
+			s.RetentionReasons = append(s.RetentionReasons, PinnedRetentionReason)
+		}
+	}
+
+	for _, s := range snapshot.SortByTime(snapshots, true) {
+		if s.IncompleteReason == "" {
+			s.RetentionReasons = append(s.RetentionReasons, "latest-1")
+			break
+		}
+	}
+
+	if err := MarkHeldSnapshots(ctx, rep, snapshots); err != nil {
+		return nil, err
+	}
+
+	if err := MarkAnomalousSnapshots(ctx, rep, snapshots, pol); err != nil {
+		return nil, err
+	}
+
+	var result []*snapshot.Manifest
+
+	for _, s := range snapshots {
+		if len(s.RetentionReasons) == 0 {
+			result = append(result, s)
+		}
+	}
+
+	return result, nil
+}
+
 func getExpiredSnapshots(ctx context.Context, rep repo.Repository, snapshots []*snapshot.Manifest) ([]*snapshot.Manifest, error) {
 	var toDelete []*snapshot.Manifest
 
@@ -56,6 +112,14 @@ func getExpiredSnapshotsForSource(ctx context.Context, rep repo.Repository, snap
 
 	pol.RetentionPolicy.ComputeRetentionReasons(snapshots)
 
+	if err := MarkHeldSnapshots(ctx, rep, snapshots); err != nil {
+		return nil, err
+	}
+
+	if err := MarkAnomalousSnapshots(ctx, rep, snapshots, pol); err != nil {
+		return nil, err
+	}
+
 	var toDelete []*snapshot.Manifest
 
 	for _, s := range snapshots {
@@ -67,5 +131,25 @@ func getExpiredSnapshotsForSource(ctx context.Context, rep repo.Repository, snap
 		}
 	}
 
+	if len(toDelete) == 0 {
+		return nil, nil
+	}
+
+	// expiring snapshots while files are being encrypted would rotate away the last clean copies.
+	suspect, indicators, err := suspectedRansomwareSnapshot(ctx, rep, snapshots, pol)
+	if err != nil {
+		return nil, err
+	}
+
+	if suspect != nil {
+		for _, a := range indicators {
+			log(ctx).Warningf("Possible ransomware activity in snapshot %v of %v: %v", suspect.ID, src, a.Description)
+		}
+
+		log(ctx).Warningf("Expiration of %v snapshots of %v is paused until the anomalies of snapshot %v are acknowledged.", len(toDelete), src, suspect.ID)
+
+		return nil, nil
+	}
+
 	return toDelete, nil
 }
@@ -13,6 +13,10 @@ type FilesPolicy struct {
 	MaxFileSize int64 `json:"maxFileSize,omitempty"`
 
 	OneFileSystem *bool `json:"oneFileSystem,omitempty"`
+
+	// SecurityDescriptors controls whether Windows security descriptors (owner, group, DACL and SACL)
+	// of files and directories are captured, so that they can be reapplied on restore.
+	SecurityDescriptors *bool `json:"securityDescriptors,omitempty"`
 }
 
 // Merge applies default values from the provided policy.
@@ -37,6 +41,10 @@ func (p *FilesPolicy) Merge(src FilesPolicy) {
 	if p.OneFileSystem == nil {
 		p.OneFileSystem = src.OneFileSystem
 	}
+
+	if p.SecurityDescriptors == nil {
+		p.SecurityDescriptors = src.SecurityDescriptors
+	}
 }
 
 // IgnoreCacheDirectoriesOrDefault gets the value of IgnoreCacheDirs or the provided default if not set.
@@ -57,6 +65,15 @@ func (p *FilesPolicy) OneFileSystemOrDefault(def bool) bool {
 	return *p.OneFileSystem
 }
 
+// SecurityDescriptorsOrDefault gets the value of SecurityDescriptors or the provided default if not set.
+func (p *FilesPolicy) SecurityDescriptorsOrDefault(def bool) bool {
+	if p.SecurityDescriptors == nil {
+		return def
+	}
+
+	return *p.SecurityDescriptors
+}
+
 // defaultFilesPolicy is the default file ignore policy.
 var defaultFilesPolicy = FilesPolicy{
 	DotIgnoreFiles: []string{".kopiaignore"},
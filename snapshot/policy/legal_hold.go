@@ -0,0 +1,66 @@
+package policy
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/legalhold"
+)
+
+// LegalHoldRetentionReason is the retention reason of snapshots under legal hold.
+const LegalHoldRetentionReason = "legal-hold"
+
+// MarkHeldSnapshots adds legal hold retention reason to the provided snapshots that are individually held.
+func MarkHeldSnapshots(ctx context.Context, rep repo.Repository, snapshots []*snapshot.Manifest) error {
+	held, err := legalhold.HeldSnapshotIDs(ctx, rep)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range snapshots {
+		if held[s.ID] == nil || hasRetentionReason(s, LegalHoldRetentionReason) {
+			continue
+		}
+
+		s.RetentionReasons = append(s.RetentionReasons, LegalHoldRetentionReason)
+	}
+
+	return nil
+}
+
+func hasRetentionReason(s *snapshot.Manifest, reason string) bool {
+	for _, r := range s.RetentionReasons {
+		if r == reason {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EnsureNotHeld returns an error wrapping legalhold.ErrHeld if the provided snapshot is under legal hold,
+// either individually or because the effective policy of its source has legal hold enabled.
+func EnsureNotHeld(ctx context.Context, rep repo.Repository, m *snapshot.Manifest) error {
+	h, err := legalhold.Get(ctx, rep, m.ID)
+	if err != nil {
+		return err
+	}
+
+	if h != nil {
+		return errors.Wrapf(legalhold.ErrHeld, "snapshot %v was put on hold by %v", m.ID, h.HeldBy)
+	}
+
+	pol, _, err := GetEffectivePolicy(ctx, rep, m.Source)
+	if err != nil {
+		return errors.Wrap(err, "unable to get effective policy")
+	}
+
+	if pol.RetentionPolicy.LegalHoldOrDefault(false) {
+		return errors.Wrapf(legalhold.ErrHeld, "source %v has legal hold enabled in its policy", m.Source)
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/legalhold"
+)
+
+func TestLegalHold(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	src := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/some/path"}
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var manifests []*snapshot.Manifest
+
+	for i := 0; i < 3; i++ {
+		m := &snapshot.Manifest{
+			Source:    src,
+			StartTime: base.Add(time.Duration(i) * time.Minute),
+			RootEntry: &snapshot.DirEntry{Type: snapshot.EntryTypeDirectory, ObjectID: "k1234"},
+		}
+
+		if _, err := snapshot.SaveSnapshot(ctx, env.Repository, m); err != nil {
+			t.Fatal(err)
+		}
+
+		manifests = append(manifests, m)
+	}
+
+	must(t, SetPolicy(ctx, env.Repository, src, &Policy{
+		RetentionPolicy: RetentionPolicy{
+			KeepLatest:  intPtr(1),
+			KeepHourly:  intPtr(0),
+			KeepDaily:   intPtr(0),
+			KeepWeekly:  intPtr(0),
+			KeepMonthly: intPtr(0),
+			KeepAnnual:  intPtr(0),
+		},
+	}))
+
+	held := manifests[0]
+
+	if _, err := legalhold.Set(ctx, env.Repository, held, "litigation"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EnsureNotHeld(ctx, env.Repository, held); !errors.Is(err, legalhold.ErrHeld) {
+		t.Errorf("unexpected error for held snapshot: %v", err)
+	}
+
+	if err := EnsureNotHeld(ctx, env.Repository, manifests[1]); err != nil {
+		t.Errorf("unexpected error for snapshot which is not held: %v", err)
+	}
+
+	expired, err := ApplyRetentionPolicy(ctx, env.Repository, src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the middle snapshot expires, the latest is retained by policy and the oldest is held.
+	if len(expired) != 1 || expired[0].ID != manifests[1].ID {
+		t.Errorf("unexpected expired snapshots: %v", expired)
+	}
+
+	must(t, SetPolicy(ctx, env.Repository, src, &Policy{
+		RetentionPolicy: RetentionPolicy{
+			KeepLatest: intPtr(1),
+			LegalHold:  newBool(true),
+		},
+	}))
+
+	if err := EnsureNotHeld(ctx, env.Repository, manifests[1]); !errors.Is(err, legalhold.ErrHeld) {
+		t.Errorf("unexpected error for snapshot of held source: %v", err)
+	}
+
+	expired, err = ApplyRetentionPolicy(ctx, env.Repository, src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expired) != 0 {
+		t.Errorf("snapshots of held source expired: %v", expired)
+	}
+
+	if err := legalhold.Clear(ctx, env.Repository, held.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if h, err := legalhold.Get(ctx, env.Repository, held.ID); err != nil || h != nil {
+		t.Errorf("legal hold was not cleared: %v %v", h, err)
+	}
+}
+
+func TestLegalHoldMerge(t *testing.T) {
+	cases := []struct {
+		child, parent *bool
+		want          bool
+	}{
+		{nil, nil, false},
+		{nil, newBool(true), true},
+		{newBool(true), nil, true},
+		{newBool(true), newBool(false), true},
+
+		// more specific policies can't release a hold placed by a parent policy.
+		{newBool(false), newBool(true), true},
+		{newBool(false), nil, false},
+	}
+
+	for i, tc := range cases {
+		var p RetentionPolicy
+
+		p.Merge(RetentionPolicy{LegalHold: tc.child})
+		p.Merge(RetentionPolicy{LegalHold: tc.parent})
+
+		if got := p.LegalHoldOrDefault(false); got != tc.want {
+			t.Errorf("case %v: unexpected merged legal hold: %v, want %v", i, got, tc.want)
+		}
+	}
+}
@@ -19,6 +19,9 @@ type Policy struct {
 	ErrorHandlingPolicy ErrorHandlingPolicy `json:"errorHandling,omitempty"`
 	SchedulingPolicy    SchedulingPolicy    `json:"scheduling,omitempty"`
 	CompressionPolicy   CompressionPolicy   `json:"compression,omitempty"`
+	UploadPolicy        UploadPolicy        `json:"upload,omitempty"`
+	AnomalyPolicy       AnomalyPolicy       `json:"anomaly,omitempty"`
+	ApplicationPolicy   ApplicationPolicy   `json:"application,omitempty"`
 	NoParent            bool                `json:"noParent,omitempty"`
 }
 
@@ -63,6 +66,9 @@ func MergePolicies(policies []*Policy) *Policy {
 		merged.ErrorHandlingPolicy.Merge(p.ErrorHandlingPolicy)
 		merged.SchedulingPolicy.Merge(p.SchedulingPolicy)
 		merged.CompressionPolicy.Merge(p.CompressionPolicy)
+		merged.UploadPolicy.Merge(p.UploadPolicy)
+		merged.AnomalyPolicy.Merge(p.AnomalyPolicy)
+		merged.ApplicationPolicy.Merge(p.ApplicationPolicy)
 	}
 
 	// Merge default expiration policy.
@@ -71,6 +77,8 @@ func MergePolicies(policies []*Policy) *Policy {
 	merged.ErrorHandlingPolicy.Merge(defaultErrorHandlingPolicy)
 	merged.SchedulingPolicy.Merge(defaultSchedulingPolicy)
 	merged.CompressionPolicy.Merge(defaultCompressionPolicy)
+	merged.UploadPolicy.Merge(defaultUploadPolicy)
+	merged.AnomalyPolicy.Merge(defaultAnomalyPolicy)
 
 	return &merged
 }
@@ -78,3 +86,7 @@ func MergePolicies(policies []*Policy) *Policy {
 func intPtr(n int) *int {
 	return &n
 }
+
+func int64Ptr(n int64) *int64 {
+	return &n
+}
@@ -15,6 +15,9 @@
 
 const typeKey = manifest.TypeLabelKey
 
+// ManifestType is the value of the "type" label for policy manifests.
+const ManifestType = "policy"
+
 // GlobalPolicySourceInfo is a source where global policy is attached.
 var GlobalPolicySourceInfo = snapshot.SourceInfo{}
 
@@ -160,7 +163,7 @@ func GetPolicyByID(ctx context.Context, rep repo.Repository, id manifest.ID) (*P
 // ListPolicies returns a list of all policies.
 func ListPolicies(ctx context.Context, rep repo.Repository) ([]*Policy, error) {
 	ids, err := rep.FindManifests(ctx, map[string]string{
-		typeKey: "policy",
+		typeKey: ManifestType,
 	})
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to list manifests")
@@ -211,7 +214,7 @@ func applicablePoliciesForSource(ctx context.Context, rep repo.Repository, si sn
 
 	// Find all policies for this host and user
 	policies, err := rep.FindManifests(ctx, map[string]string{
-		typeKey:      "policy",
+		typeKey:      ManifestType,
 		"policyType": "path",
 		"username":   si.UserName,
 		"hostname":   si.Host,
@@ -267,7 +270,7 @@ func labelsForSource(si snapshot.SourceInfo) map[string]string {
 	switch {
 	case si.Path != "":
 		return map[string]string{
-			typeKey:      "policy",
+			typeKey:      ManifestType,
 			"policyType": "path",
 			"username":   si.UserName,
 			"hostname":   si.Host,
@@ -275,20 +278,20 @@ func labelsForSource(si snapshot.SourceInfo) map[string]string {
 		}
 	case si.UserName != "":
 		return map[string]string{
-			typeKey:      "policy",
+			typeKey:      ManifestType,
 			"policyType": "user",
 			"username":   si.UserName,
 			"hostname":   si.Host,
 		}
 	case si.Host != "":
 		return map[string]string{
-			typeKey:      "policy",
+			typeKey:      ManifestType,
 			"policyType": "host",
 			"hostname":   si.Host,
 		}
 	default:
 		return map[string]string{
-			typeKey:      "policy",
+			typeKey:      ManifestType,
 			"policyType": "global",
 		}
 	}
@@ -0,0 +1,181 @@
+package policy
+
+import (
+	"context"
+	"path"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/snapshot"
+)
+
+// ProfileManifestType is the value of the "type" label for policy profile manifests.
+const ProfileManifestType = "policy-profile"
+
+// ErrProfileNotFound is returned when the policy profile is not found.
+var ErrProfileNotFound = errors.New("policy profile not found")
+
+// Profile is a named policy that clients connecting to the server adopt as their host-level policy
+// when their identity matches one of the profile patterns.
+type Profile struct {
+	Name string `json:"name"`
+
+	// Patterns of client identities (user@host) the profile applies to, as understood by path.Match.
+	Match []string `json:"match"`
+
+	Policy *Policy `json:"policy"`
+}
+
+// Matches returns true if the profile applies to the provided client identity.
+func (p *Profile) Matches(username, hostname string) bool {
+	id := username + "@" + hostname
+
+	for _, m := range p.Match {
+		if ok, _ := path.Match(m, id); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func labelsForProfile(name string) map[string]string {
+	return map[string]string{
+		typeKey: ProfileManifestType,
+		"name":  name,
+	}
+}
+
+// SetProfile saves the provided policy profile, replacing any existing profile with the same name.
+func SetProfile(ctx context.Context, rep repo.Repository, p *Profile) error {
+	if p.Name == "" {
+		return errors.New("missing profile name")
+	}
+
+	for _, m := range p.Match {
+		if _, err := path.Match(m, ""); err != nil {
+			return errors.Wrapf(err, "invalid pattern %q", m)
+		}
+	}
+
+	md, err := rep.FindManifests(ctx, labelsForProfile(p.Name))
+	if err != nil {
+		return errors.Wrapf(err, "unable to load manifests for profile %v", p.Name)
+	}
+
+	if _, err := rep.PutManifest(ctx, labelsForProfile(p.Name), p); err != nil {
+		return errors.Wrap(err, "unable to save profile")
+	}
+
+	for _, em := range md {
+		if err := rep.DeleteManifest(ctx, em.ID); err != nil {
+			return errors.Wrap(err, "unable to delete previous profile manifest")
+		}
+	}
+
+	return nil
+}
+
+// GetProfile returns the policy profile with a given name or ErrProfileNotFound.
+func GetProfile(ctx context.Context, rep repo.Repository, name string) (*Profile, error) {
+	md, err := rep.FindManifests(ctx, labelsForProfile(name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load manifests for profile %v", name)
+	}
+
+	if len(md) == 0 {
+		return nil, ErrProfileNotFound
+	}
+
+	p := &Profile{}
+	if _, err := rep.GetManifest(ctx, md[0].ID, p); err != nil {
+		return nil, errors.Wrapf(err, "unable to load profile %v", name)
+	}
+
+	return p, nil
+}
+
+// RemoveProfile removes the policy profile with a given name.
+func RemoveProfile(ctx context.Context, rep repo.Repository, name string) error {
+	md, err := rep.FindManifests(ctx, labelsForProfile(name))
+	if err != nil {
+		return errors.Wrapf(err, "unable to load manifests for profile %v", name)
+	}
+
+	if len(md) == 0 {
+		return ErrProfileNotFound
+	}
+
+	for _, em := range md {
+		if err := rep.DeleteManifest(ctx, em.ID); err != nil {
+			return errors.Wrap(err, "unable to delete profile manifest")
+		}
+	}
+
+	return nil
+}
+
+// ListProfiles returns all policy profiles sorted by name.
+func ListProfiles(ctx context.Context, rep repo.Repository) ([]*Profile, error) {
+	md, err := rep.FindManifests(ctx, map[string]string{
+		typeKey: ProfileManifestType,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list manifests")
+	}
+
+	var result []*Profile
+
+	for _, em := range md {
+		p := &Profile{}
+		if _, err := rep.GetManifest(ctx, em.ID, p); err != nil {
+			return nil, errors.Wrapf(err, "unable to load profile %v", em.ID)
+		}
+
+		result = append(result, p)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
+
+// ApplyProfile finds the first profile (by name) matching the provided client identity and sets its policy
+// as the host-level policy of the client, unless the host already has a policy defined.
+// Returns the applied profile or nil if none was applied.
+func ApplyProfile(ctx context.Context, rep repo.Repository, username, hostname string) (*Profile, error) {
+	profiles, err := ListProfiles(ctx, rep)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range profiles {
+		if !p.Matches(username, hostname) || p.Policy == nil {
+			continue
+		}
+
+		target := snapshot.SourceInfo{Host: hostname}
+
+		_, err := GetDefinedPolicy(ctx, rep, target)
+
+		switch {
+		case err == nil:
+			log(ctx).Debugf("not applying profile %v, %v already has a policy", p.Name, target)
+			return nil, nil
+		case !errors.Is(err, ErrPolicyNotFound):
+			return nil, errors.Wrap(err, "unable to get defined policy")
+		}
+
+		if err := SetPolicy(ctx, rep, target, p.Policy); err != nil {
+			return nil, errors.Wrapf(err, "unable to set policy for %v", target)
+		}
+
+		return p, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,78 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/snapshot"
+)
+
+func TestApplyProfile(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	laptop := &Profile{
+		Name:  "laptop",
+		Match: []string{"*@laptop-*"},
+		Policy: &Policy{
+			FilesPolicy: FilesPolicy{IgnoreRules: []string{"*.tmp"}},
+		},
+	}
+
+	dbServer := &Profile{
+		Name:  "db-server",
+		Match: []string{"postgres@*", "*@db-*"},
+		Policy: &Policy{
+			RetentionPolicy: RetentionPolicy{KeepDaily: intPtr(30)},
+		},
+	}
+
+	require.NoError(t, SetProfile(ctx, env.Repository, laptop))
+	require.NoError(t, SetProfile(ctx, env.Repository, dbServer))
+	require.Error(t, SetProfile(ctx, env.Repository, &Profile{Name: "bad", Match: []string{"["}}))
+
+	profiles, err := ListProfiles(ctx, env.Repository)
+	require.NoError(t, err)
+	require.Len(t, profiles, 2)
+	require.Equal(t, "db-server", profiles[0].Name)
+
+	// no matching profile.
+	p, err := ApplyProfile(ctx, env.Repository, "alice", "desktop-1")
+	require.NoError(t, err)
+	require.Nil(t, p)
+
+	// first matching profile by name wins.
+	p, err = ApplyProfile(ctx, env.Repository, "postgres", "laptop-1")
+	require.NoError(t, err)
+	require.Equal(t, "db-server", p.Name)
+
+	got, err := GetDefinedPolicy(ctx, env.Repository, snapshot.SourceInfo{Host: "laptop-1"})
+	require.NoError(t, err)
+	require.Equal(t, 30, *got.RetentionPolicy.KeepDaily)
+
+	// existing host policy is never replaced.
+	p, err = ApplyProfile(ctx, env.Repository, "alice", "laptop-1")
+	require.NoError(t, err)
+	require.Nil(t, p)
+
+	p, err = ApplyProfile(ctx, env.Repository, "alice", "laptop-2")
+	require.NoError(t, err)
+	require.Equal(t, "laptop", p.Name)
+
+	got, err = GetDefinedPolicy(ctx, env.Repository, snapshot.SourceInfo{Host: "laptop-2"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"*.tmp"}, got.FilesPolicy.IgnoreRules)
+
+	require.NoError(t, RemoveProfile(ctx, env.Repository, "laptop"))
+	require.True(t, errors.Is(RemoveProfile(ctx, env.Repository, "laptop"), ErrProfileNotFound))
+
+	_, err = GetProfile(ctx, env.Repository, "laptop")
+	require.True(t, errors.Is(err, ErrProfileNotFound))
+}
@@ -9,6 +9,8 @@
 	CompressionPolicy:   defaultCompressionPolicy,
 	ErrorHandlingPolicy: defaultErrorHandlingPolicy,
 	SchedulingPolicy:    defaultSchedulingPolicy,
+	UploadPolicy:        defaultUploadPolicy,
+	AnomalyPolicy:       defaultAnomalyPolicy,
 }
 
 // Tree represents a node in the policy tree, where a policy can be
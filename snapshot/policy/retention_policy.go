@@ -15,6 +15,9 @@
 	retainIncompleteSnapshotMinimumCount = 3
 )
 
+// PinnedRetentionReason is the retention reason of snapshots that have pins.
+const PinnedRetentionReason = "pinned"
+
 // RetentionPolicy describes snapshot retention policy.
 type RetentionPolicy struct {
 	KeepLatest  *int `json:"keepLatest,omitempty"`
@@ -23,6 +26,18 @@ type RetentionPolicy struct {
 	KeepWeekly  *int `json:"keepWeekly,omitempty"`
 	KeepMonthly *int `json:"keepMonthly,omitempty"`
 	KeepAnnual  *int `json:"keepAnnual,omitempty"`
+
+	// LegalHold freezes retention, so that no snapshots of the source are expired or deleted.
+	LegalHold *bool `json:"legalHold,omitempty"`
+}
+
+// LegalHoldOrDefault gets the value of LegalHold or the provided default if not set.
+func (r *RetentionPolicy) LegalHoldOrDefault(def bool) bool {
+	if r.LegalHold == nil {
+		return def
+	}
+
+	return *r.LegalHold
 }
 
 // ComputeRetentionReasons computes the reasons why each snapshot is retained, based on
@@ -81,6 +96,21 @@ func (r *RetentionPolicy) ComputeRetentionReasons(manifests []*snapshot.Manifest
 		}
 	}
 
+	// pinned snapshots are retained until they are explicitly unpinned.
+	for _, s := range sorted {
+		if len(s.Pins) > 0 {
+			s.RetentionReasons = append(s.RetentionReasons, PinnedRetentionReason)
+		}
+	}
+
+	if r.LegalHoldOrDefault(false) {
+		for _, s := range sorted {
+			s.RetentionReasons = append(s.RetentionReasons, LegalHoldRetentionReason)
+		}
+
+		return
+	}
+
 	// attach 'retention reason' tag to incomplete snapshots until we run into first complete one
 	// or we have enough incomplete ones and we run into an old one.
 	for i, s := range sorted {
@@ -208,4 +238,10 @@ func (r *RetentionPolicy) Merge(src RetentionPolicy) {
 	if r.KeepAnnual == nil {
 		r.KeepAnnual = src.KeepAnnual
 	}
+
+	// legal hold placed by a parent policy can't be released by more specific policies,
+	// only by the policy which defines it.
+	if r.LegalHold == nil || src.LegalHoldOrDefault(false) {
+		r.LegalHold = src.LegalHold
+	}
 }
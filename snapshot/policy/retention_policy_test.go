@@ -194,3 +194,34 @@ func TestRetentionPolicyTest(t *testing.T) {
 		})
 	}
 }
+
+func TestRetentionPolicyKeepsPinnedSnapshots(t *testing.T) {
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var manifests []*snapshot.Manifest
+
+	for i := 0; i < 4; i++ {
+		manifests = append(manifests, &snapshot.Manifest{
+			StartTime: base.Add(time.Duration(i) * time.Hour),
+		})
+	}
+
+	manifests[0].Pins = []string{"release-1.0"}
+	manifests[1].Pins = []string{"audit"}
+	manifests[1].IncompleteReason = "some-reason"
+
+	(&RetentionPolicy{KeepLatest: intPtr(1)}).ComputeRetentionReasons(manifests)
+
+	want := [][]string{
+		{PinnedRetentionReason},
+		{PinnedRetentionReason},
+		{},
+		{"latest-1"},
+	}
+
+	for i, m := range manifests {
+		if diff := cmp.Diff(m.RetentionReasons, want[i]); diff != "" {
+			t.Errorf("unexpected retention reasons for snapshot %v diff: %v", i, diff)
+		}
+	}
+}
@@ -16,7 +16,7 @@ type TimeOfDay struct {
 
 // Parse parses the time of day.
 func (t *TimeOfDay) Parse(s string) error {
-	if _, err := fmt.Sscanf(s, "%v:%02v", &t.Hour, &t.Minute); err != nil {
+	if _, err := fmt.Sscanf(s, "%d:%d", &t.Hour, &t.Minute); err != nil {
 		return errors.New("invalid time of day, must be HH:MM")
 	}
 
@@ -52,6 +52,17 @@ func SortAndDedupeTimesOfDay(tod []TimeOfDay) []TimeOfDay {
 type SchedulingPolicy struct {
 	IntervalSeconds int64       `json:"intervalSeconds,omitempty"`
 	TimesOfDay      []TimeOfDay `json:"timeOfDay,omitempty"`
+	Groups          []string    `json:"groups,omitempty"`
+
+	// MinBatteryPercent defers scheduled snapshots while running on battery with charge below the given percentage.
+	MinBatteryPercent *int `json:"minBatteryPercent,omitempty"`
+
+	// SkipOnMeteredConnection defers scheduled snapshots while the network connection is metered.
+	SkipOnMeteredConnection *bool `json:"skipOnMeteredConnection,omitempty"`
+
+	// MaxSnapshotAgeSeconds is the recovery point objective (RPO) - the source is reported as violating it
+	// when there has been no successful snapshot within this time.
+	MaxSnapshotAgeSeconds int64 `json:"maxSnapshotAgeSeconds,omitempty"`
 }
 
 // Interval returns the snapshot interval or zero if not specified.
@@ -64,14 +75,87 @@ func (p *SchedulingPolicy) SetInterval(d time.Duration) {
 	p.IntervalSeconds = int64(d.Seconds())
 }
 
+// MaxSnapshotAge returns the recovery point objective or zero if not specified.
+func (p *SchedulingPolicy) MaxSnapshotAge() time.Duration {
+	return time.Duration(p.MaxSnapshotAgeSeconds) * time.Second
+}
+
+// SetMaxSnapshotAge sets the recovery point objective (zero disables).
+func (p *SchedulingPolicy) SetMaxSnapshotAge(d time.Duration) {
+	p.MaxSnapshotAgeSeconds = int64(d.Seconds())
+}
+
 // Merge applies default values from the provided policy.
 func (p *SchedulingPolicy) Merge(src SchedulingPolicy) {
 	if p.IntervalSeconds == 0 {
 		p.IntervalSeconds = src.IntervalSeconds
 	}
 
+	if p.MaxSnapshotAgeSeconds == 0 {
+		p.MaxSnapshotAgeSeconds = src.MaxSnapshotAgeSeconds
+	}
+
 	p.TimesOfDay = SortAndDedupeTimesOfDay(
 		append(append([]TimeOfDay(nil), src.TimesOfDay...), p.TimesOfDay...))
+
+	p.Groups = sortAndDedupeStrings(append(append([]string(nil), src.Groups...), p.Groups...))
+
+	if p.MinBatteryPercent == nil && src.MinBatteryPercent != nil {
+		p.MinBatteryPercent = intPtr(*src.MinBatteryPercent)
+	}
+
+	if p.SkipOnMeteredConnection == nil && src.SkipOnMeteredConnection != nil {
+		p.SkipOnMeteredConnection = newBool(*src.SkipOnMeteredConnection)
+	}
+}
+
+// MinBatteryPercentOrDefault returns the minimum battery percentage if set,
+// and returns the passed default if not.
+func (p *SchedulingPolicy) MinBatteryPercentOrDefault(def int) int {
+	if p.MinBatteryPercent == nil {
+		return def
+	}
+
+	return *p.MinBatteryPercent
+}
+
+// SkipOnMeteredConnectionOrDefault returns the skip-on-metered-connection setting if set,
+// and returns the passed default if not.
+func (p *SchedulingPolicy) SkipOnMeteredConnectionOrDefault(def bool) bool {
+	if p.SkipOnMeteredConnection == nil {
+		return def
+	}
+
+	return *p.SkipOnMeteredConnection
+}
+
+// InGroup returns true if the policy places the source in the group with the provided name.
+func (p *SchedulingPolicy) InGroup(name string) bool {
+	for _, g := range p.Groups {
+		if g == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sortAndDedupeStrings(s []string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+
+	sort.Strings(s)
+
+	result := s[:1]
+
+	for _, v := range s[1:] {
+		if v != result[len(result)-1] {
+			result = append(result, v)
+		}
+	}
+
+	return result
 }
 
 var defaultSchedulingPolicy = SchedulingPolicy{}
@@ -0,0 +1,38 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSchedulingPolicyGroups(t *testing.T) {
+	var p SchedulingPolicy
+
+	p.Merge(SchedulingPolicy{Groups: []string{"nightly", "critical"}})
+	p.Merge(SchedulingPolicy{Groups: []string{"weekly", "nightly"}})
+
+	if diff := cmp.Diff(p.Groups, []string{"critical", "nightly", "weekly"}); diff != "" {
+		t.Errorf("unexpected groups: %v", diff)
+	}
+
+	if !p.InGroup("nightly") {
+		t.Errorf("expected source to be in 'nightly' group")
+	}
+
+	if p.InGroup("hourly") {
+		t.Errorf("unexpected source in 'hourly' group")
+	}
+}
+
+func TestSchedulingPolicyMaxSnapshotAge(t *testing.T) {
+	var p SchedulingPolicy
+
+	p.Merge(SchedulingPolicy{MaxSnapshotAgeSeconds: 3600})
+	p.Merge(SchedulingPolicy{MaxSnapshotAgeSeconds: 7200})
+
+	if got, want := p.MaxSnapshotAge(), time.Hour; got != want {
+		t.Errorf("unexpected max snapshot age: %v, want %v", got, want)
+	}
+}
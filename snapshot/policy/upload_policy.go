@@ -0,0 +1,132 @@
+package policy
+
+import "time"
+
+// IO priority levels supported by UploadPolicy.
+const (
+	IOPriorityNormal = "normal"
+	IOPriorityLow    = "low"
+	IOPriorityIdle   = "idle"
+)
+
+// Locations of upload hints supported by UploadPolicy.
+const (
+	UploadHintsNone       = "none"
+	UploadHintsLocal      = "local"
+	UploadHintsRepository = "repository"
+)
+
+// UploadPolicy controls the resources consumed while taking snapshots.
+type UploadPolicy struct {
+	// MaxParallelFileReads is the maximum number of files hashed and uploaded in parallel.
+	MaxParallelFileReads *int `json:"maxParallelFileReads,omitempty"`
+
+	// CPUNiceness is the Unix-style niceness (0-19) of the process while taking a snapshot.
+	CPUNiceness *int `json:"cpuNiceness,omitempty"`
+
+	// IOPriority is the IO priority of the process while taking a snapshot, one of "normal", "low" or "idle".
+	IOPriority string `json:"ioPriority,omitempty"`
+
+	// DeltaUpload controls whether chunks of changed files whose fingerprints match chunks of the same file
+	// in the previous snapshot are reused instead of being compressed, encrypted and uploaded again.
+	// All chunks are still read and fingerprinted.
+	DeltaUpload *bool `json:"deltaUpload,omitempty"`
+
+	// UploadHints controls where hints mapping file paths, sizes and modification times to previously uploaded
+	// objects are persisted, one of "none", "local" or "repository" (both locally and in the repository).
+	UploadHints string `json:"uploadHints,omitempty"`
+
+	// BandwidthSchedule limits upload and download speed by time of day, bandwidth is unlimited
+	// outside of the windows of the schedule. The schedule replaces schedules of parent policies.
+	BandwidthSchedule []BandwidthWindow `json:"bandwidthSchedule,omitempty"`
+
+	// MaxUploadBytesPerSecond limits the upload speed of snapshots at all times, zero means unlimited.
+	// When combined with the bandwidth schedule or limits of the repository, the lowest of the limits applies.
+	MaxUploadBytesPerSecond *int64 `json:"maxUploadBytesPerSecond,omitempty"`
+}
+
+// Merge applies default values from the provided policy.
+func (p *UploadPolicy) Merge(src UploadPolicy) {
+	if p.MaxParallelFileReads == nil && src.MaxParallelFileReads != nil {
+		p.MaxParallelFileReads = intPtr(*src.MaxParallelFileReads)
+	}
+
+	if p.CPUNiceness == nil && src.CPUNiceness != nil {
+		p.CPUNiceness = intPtr(*src.CPUNiceness)
+	}
+
+	if p.IOPriority == "" {
+		p.IOPriority = src.IOPriority
+	}
+
+	if p.DeltaUpload == nil && src.DeltaUpload != nil {
+		p.DeltaUpload = newBool(*src.DeltaUpload)
+	}
+
+	if p.UploadHints == "" {
+		p.UploadHints = src.UploadHints
+	}
+
+	if p.BandwidthSchedule == nil && src.BandwidthSchedule != nil {
+		p.BandwidthSchedule = append([]BandwidthWindow(nil), src.BandwidthSchedule...)
+	}
+
+	if p.MaxUploadBytesPerSecond == nil && src.MaxUploadBytesPerSecond != nil {
+		p.MaxUploadBytesPerSecond = int64Ptr(*src.MaxUploadBytesPerSecond)
+	}
+}
+
+// MaxParallelFileReadsOrDefault returns the maximum number of files read in parallel if set,
+// and returns the passed default if not.
+func (p *UploadPolicy) MaxParallelFileReadsOrDefault(def int) int {
+	if p.MaxParallelFileReads == nil {
+		return def
+	}
+
+	return *p.MaxParallelFileReads
+}
+
+// CPUNicenessOrDefault returns the CPU niceness if set, and returns the passed default if not.
+func (p *UploadPolicy) CPUNicenessOrDefault(def int) int {
+	if p.CPUNiceness == nil {
+		return def
+	}
+
+	return *p.CPUNiceness
+}
+
+// DeltaUploadOrDefault returns the delta upload setting if set, and returns the passed default if not.
+func (p *UploadPolicy) DeltaUploadOrDefault(def bool) bool {
+	if p.DeltaUpload == nil {
+		return def
+	}
+
+	return *p.DeltaUpload
+}
+
+// MaxUploadBytesPerSecondOrDefault returns the maximum upload speed if set, and returns the passed default if not.
+func (p *UploadPolicy) MaxUploadBytesPerSecondOrDefault(def int64) int64 {
+	if p.MaxUploadBytesPerSecond == nil {
+		return def
+	}
+
+	return *p.MaxUploadBytesPerSecond
+}
+
+// UploadLimitsAt returns the upload and download speed limits at the provided local time, combining
+// the bandwidth schedule with the maximum upload speed. Zero means unlimited, in which case only
+// limits of the repository apply.
+func (p *UploadPolicy) UploadLimitsAt(t time.Time) (upload, download int64) {
+	upload, download = BandwidthLimitsAt(p.BandwidthSchedule, t)
+
+	if max := p.MaxUploadBytesPerSecondOrDefault(0); max > 0 && (upload == 0 || max < upload) {
+		upload = max
+	}
+
+	return upload, download
+}
+
+var defaultUploadPolicy = UploadPolicy{
+	IOPriority:  IOPriorityNormal,
+	UploadHints: UploadHintsLocal,
+}
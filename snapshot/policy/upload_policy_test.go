@@ -0,0 +1,57 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUploadLimitsAt(t *testing.T) {
+	var night BandwidthWindow
+
+	if err := night.Parse("22:00-06:00 upload=10MB download=20MB"); err != nil {
+		t.Fatal(err)
+	}
+
+	noon := time.Date(2021, 1, 4, 12, 0, 0, 0, time.Local)
+	midnight := time.Date(2021, 1, 4, 0, 0, 0, 0, time.Local)
+
+	cases := []struct {
+		p            UploadPolicy
+		t            time.Time
+		wantUpload   int64
+		wantDownload int64
+	}{
+		{UploadPolicy{}, noon, 0, 0},
+		{UploadPolicy{MaxUploadBytesPerSecond: int64Ptr(5e6)}, noon, 5e6, 0},
+		{UploadPolicy{MaxUploadBytesPerSecond: int64Ptr(0)}, noon, 0, 0},
+		{UploadPolicy{BandwidthSchedule: []BandwidthWindow{night}}, midnight, 10e6, 20e6},
+		{UploadPolicy{BandwidthSchedule: []BandwidthWindow{night}, MaxUploadBytesPerSecond: int64Ptr(5e6)}, midnight, 5e6, 20e6},
+		{UploadPolicy{BandwidthSchedule: []BandwidthWindow{night}, MaxUploadBytesPerSecond: int64Ptr(50e6)}, midnight, 10e6, 20e6},
+		{UploadPolicy{BandwidthSchedule: []BandwidthWindow{night}, MaxUploadBytesPerSecond: int64Ptr(50e6)}, noon, 50e6, 0},
+	}
+
+	for i, tc := range cases {
+		up, down := tc.p.UploadLimitsAt(tc.t)
+		if up != tc.wantUpload || down != tc.wantDownload {
+			t.Errorf("case %v: got %v/%v, want %v/%v", i, up, down, tc.wantUpload, tc.wantDownload)
+		}
+	}
+}
+
+func TestUploadPolicyMergeMaxUploadSpeed(t *testing.T) {
+	var p UploadPolicy
+
+	p.Merge(UploadPolicy{MaxUploadBytesPerSecond: int64Ptr(1000)})
+
+	if got := p.MaxUploadBytesPerSecondOrDefault(0); got != 1000 {
+		t.Errorf("unexpected merged value: %v", got)
+	}
+
+	// explicit zero (unlimited) in child overrides the limit of the parent.
+	child := UploadPolicy{MaxUploadBytesPerSecond: int64Ptr(0)}
+	child.Merge(UploadPolicy{MaxUploadBytesPerSecond: int64Ptr(1000)})
+
+	if got := child.MaxUploadBytesPerSecondOrDefault(-1); got != 0 {
+		t.Errorf("unexpected merged value: %v", got)
+	}
+}
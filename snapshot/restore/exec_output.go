@@ -0,0 +1,76 @@
+package restore
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// execStdin writes to standard input of a running command and waits for the command to exit when closed.
+type execStdin struct {
+	io.WriteCloser
+
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+
+	waitOnce sync.Once
+	waitErr  error
+}
+
+// wait closes standard input and waits for the command to exit, returning its error along with its error output.
+func (w *execStdin) wait() error {
+	w.waitOnce.Do(func() {
+		w.WriteCloser.Close() //nolint:errcheck
+
+		if err := w.cmd.Wait(); err != nil {
+			w.waitErr = errors.Wrapf(err, "%v failed: %v", w.cmd.Path, strings.TrimSpace(w.stderr.String()))
+		}
+	})
+
+	return w.waitErr
+}
+
+func (w *execStdin) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	if err != nil {
+		// the command most likely exited prematurely, report its error instead of broken pipe.
+		if werr := w.wait(); werr != nil {
+			return n, werr
+		}
+
+		return n, errors.Wrap(err, "error writing to standard input")
+	}
+
+	return n, nil
+}
+
+func (w *execStdin) Close() error {
+	return w.wait()
+}
+
+// NewExecTarOutput starts the provided command and returns output which streams the restored files in tar
+// format to its standard input. The command is expected to extract the archive, such as 'tar -x' executed
+// in a container using 'kubectl exec' or 'docker exec', which avoids the need to mount volumes or install
+// kopia in the container.
+func NewExecTarOutput(cmd *exec.Cmd) (*TarOutput, error) {
+	w := &execStdin{cmd: cmd}
+
+	cmd.Stderr = &w.stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get standard input")
+	}
+
+	w.WriteCloser = stdin
+
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "unable to start %v", cmd.Path)
+	}
+
+	return NewTarOutput(w), nil
+}
@@ -0,0 +1,68 @@
+package restore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+func TestExecTarOutput(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not available")
+	}
+
+	ctx := testlogging.Context(t)
+
+	src := mockfs.NewDirectory()
+	src.AddFile("f1", []byte{1, 2, 3}, 0o644)
+	src.AddDir("d1", 0o755).AddFile("f2", []byte{4, 5}, 0o644)
+
+	td, err := ioutil.TempDir("", "kopia-exec-output")
+	require.NoError(t, err)
+
+	defer os.RemoveAll(td)
+
+	o, err := NewExecTarOutput(exec.Command("tar", "-x", "-f", "-", "-C", td))
+	require.NoError(t, err)
+
+	_, err = Entry(ctx, nil, o, src, Options{
+		ProgressCallback: func(ctx context.Context, s Stats) {},
+	})
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadFile(filepath.Join(td, "f1"))
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3}, data)
+
+	data, err = ioutil.ReadFile(filepath.Join(td, "d1", "f2"))
+	require.NoError(t, err)
+	require.Equal(t, []byte{4, 5}, data)
+}
+
+func TestExecTarOutputCommandFailure(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not available")
+	}
+
+	ctx := testlogging.Context(t)
+
+	src := mockfs.NewDirectory()
+	src.AddFile("f1", []byte{1, 2, 3}, 0o644)
+
+	o, err := NewExecTarOutput(exec.Command("tar", "-x", "-f", "-", "-C", "/no/such/directory"))
+	require.NoError(t, err)
+
+	_, err = Entry(ctx, nil, o, src, Options{
+		ProgressCallback: func(ctx context.Context, s Stats) {},
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "/no/such/directory")
+}
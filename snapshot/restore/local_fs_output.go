@@ -7,8 +7,9 @@
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 
-	"github.com/natefinch/atomic"
+	"github.com/efarrer/iothrottler"
 	"github.com/pkg/errors"
 
 	"github.com/kopia/kopia/fs"
@@ -41,6 +42,31 @@ type FilesystemOutput struct {
 
 	// SkipTimes when set to true causes restore to skip restoring modification times.
 	SkipTimes bool
+
+	// SkipSecurityDescriptors when set to true causes restore to skip restoring Windows security descriptors (ACLs).
+	SkipSecurityDescriptors bool
+
+	// WriteMetadataSidecars when set to true causes metadata that can't be applied on the current
+	// operating system (such as ownership on Windows) to be written to sidecar files in MetadataSidecarDir,
+	// so that it can be re-applied later using ApplySidecarMetadata().
+	WriteMetadataSidecars bool
+
+	// SyncBatchSize, when positive, causes files not to be synced to stable storage individually,
+	// but in batches once the total size of written files reaches the specified number of bytes.
+	SyncBatchSize int64
+
+	sidecarMutex   sync.Mutex
+	sidecarFile    *os.File
+	sidecarBaseDir string
+
+	throttleMutex          sync.Mutex
+	throttler              *iothrottler.IOThrottlerPool
+	throttleEnabled        bool
+	maxWriteBytesPerSecond int64
+
+	syncMutex        sync.Mutex
+	pendingSyncFiles []pendingSyncFile
+	pendingSyncBytes int64
 }
 
 // Parallelizable implements restore.Output interface.
@@ -62,6 +88,12 @@ func (o *FilesystemOutput) BeginDirectory(ctx context.Context, relativePath stri
 // FinishDirectory implements restore.Output interface.
 func (o *FilesystemOutput) FinishDirectory(ctx context.Context, relativePath string, e fs.Directory) error {
 	path := filepath.Join(o.TargetPath, filepath.FromSlash(relativePath))
+
+	// files are renamed into the directory when they are synced, which would change its modification time.
+	if err := o.syncPendingFilesIn(path); err != nil {
+		return err
+	}
+
 	if err := o.setAttributes(path, e); err != nil {
 		return errors.Wrap(err, "error setting attributes")
 	}
@@ -71,7 +103,13 @@ func (o *FilesystemOutput) FinishDirectory(ctx context.Context, relativePath str
 
 // Close implements restore.Output interface.
 func (o *FilesystemOutput) Close(ctx context.Context) error {
-	return nil
+	o.releaseThrottler()
+
+	if err := o.syncPendingFiles(); err != nil {
+		return err
+	}
+
+	return o.closeSidecarFile()
 }
 
 // WriteFile implements restore.Output interface.
@@ -79,10 +117,25 @@ func (o *FilesystemOutput) WriteFile(ctx context.Context, relativePath string, f
 	log(ctx).Debugf("WriteFile %v (%v bytes) %v", filepath.Join(o.TargetPath, relativePath), f.Size(), f.Mode())
 	path := filepath.Join(o.TargetPath, filepath.FromSlash(relativePath))
 
-	if err := o.copyFileContent(ctx, path, f); err != nil {
+	tmpPath, size, err := o.copyFileContent(ctx, path, f)
+	if err != nil {
 		return errors.Wrap(err, "error creating directory")
 	}
 
+	if o.SyncBatchSize > 0 {
+		// the file is renamed once it's synced, attributes of the temporary file are preserved by the rename.
+		if err := o.setAttributesOf(tmpPath, path, f); err != nil {
+			os.Remove(tmpPath) //nolint:errcheck
+			return errors.Wrap(err, "error setting attributes")
+		}
+
+		return o.addPendingSync(tmpPath, path, size)
+	}
+
+	if err := renameAndSyncDir(tmpPath, path); err != nil {
+		return err
+	}
+
 	if err := o.setAttributes(path, f); err != nil {
 		return errors.Wrap(err, "error setting attributes")
 	}
@@ -114,9 +167,14 @@ func (o *FilesystemOutput) CreateSymlink(ctx context.Context, relativePath strin
 
 // set permission, modification time and user/group ids on targetPath.
 func (o *FilesystemOutput) setAttributes(targetPath string, e fs.Entry) error {
-	le, err := localfs.NewEntry(targetPath)
+	return o.setAttributesOf(targetPath, targetPath, e)
+}
+
+// setAttributesOf sets attributes of the entry on the local file, which is renamed to targetPath later.
+func (o *FilesystemOutput) setAttributesOf(localPath, targetPath string, e fs.Entry) error {
+	le, err := localfs.NewEntry(localPath)
 	if err != nil {
-		return errors.Wrap(err, "could not create local FS entry for "+targetPath)
+		return errors.Wrap(err, "could not create local FS entry for "+localPath)
 	}
 
 	var (
@@ -135,25 +193,37 @@ func (o *FilesystemOutput) setAttributes(targetPath string, e fs.Entry) error {
 	// On Windows Chown is not supported. fs.OwnerInfo collected on Windows will always
 	// be zero-value for UID and GID, so the Chown operation is not performed.
 	if o.shouldUpdateOwner(le, e) {
-		if err = o.maybeIgnorePermissionError(osChown(targetPath, int(e.Owner().UserID), int(e.Owner().GroupID))); err != nil {
+		if err = o.maybeIgnorePermissionError(osChown(localPath, int(e.Owner().UserID), int(e.Owner().GroupID))); err != nil {
 			return errors.Wrap(err, "could not change owner/group for "+targetPath)
 		}
 	}
 
+	// Set Windows security descriptor (owner, group and ACLs) from e
+	sd, err := o.securityDescriptorToApply(e)
+	if err != nil {
+		return errors.Wrap(err, "unable to get security descriptor of "+targetPath)
+	}
+
+	if sd != "" && isWindows() {
+		if err = o.maybeIgnorePermissionError(setSecurityDescriptor(localPath, sd)); err != nil {
+			return errors.Wrap(err, "could not set security descriptor on "+targetPath)
+		}
+	}
+
 	// Set file permissions from e
 	if o.shouldUpdatePermissions(le, e) {
-		if err = o.maybeIgnorePermissionError(osChmod(targetPath, e.Mode()&modBits)); err != nil {
+		if err = o.maybeIgnorePermissionError(osChmod(localPath, e.Mode()&modBits)); err != nil {
 			return errors.Wrap(err, "could not change permissions on "+targetPath)
 		}
 	}
 
 	if o.shouldUpdateTimes(le, e) {
-		if err = o.maybeIgnorePermissionError(osChtimes(targetPath, e.ModTime(), e.ModTime())); err != nil {
+		if err = o.maybeIgnorePermissionError(osChtimes(localPath, e.ModTime(), e.ModTime())); err != nil {
 			return errors.Wrap(err, "could not change mod time on "+targetPath)
 		}
 	}
 
-	return nil
+	return o.maybeWriteSidecarMetadata(targetPath, e)
 }
 
 func isSymlink(e fs.Entry) bool {
@@ -181,6 +251,21 @@ func (o *FilesystemOutput) shouldUpdateOwner(local, remote fs.Entry) bool {
 	return local.Owner() != remote.Owner()
 }
 
+// securityDescriptorToApply returns the security descriptor of the entry in SDDL format or empty string if
+// it should not be restored.
+func (o *FilesystemOutput) securityDescriptorToApply(e fs.Entry) (string, error) {
+	if o.SkipSecurityDescriptors || isSymlink(e) {
+		return "", nil
+	}
+
+	sde, ok := e.(fs.EntryWithSecurityDescriptor)
+	if !ok {
+		return "", nil
+	}
+
+	return sde.SecurityDescriptor()
+}
+
 func (o *FilesystemOutput) shouldUpdatePermissions(local, remote fs.Entry) bool {
 	if o.SkipPermissions {
 		return false
@@ -222,28 +307,36 @@ func (o *FilesystemOutput) createDirectory(ctx context.Context, path string) err
 	}
 }
 
-func (o *FilesystemOutput) copyFileContent(ctx context.Context, targetPath string, f fs.File) error {
+// copyFileContent writes contents of the file to a temporary file next to targetPath and returns its name and size.
+// Unless files are synced in batches, the temporary file is synced before returning.
+func (o *FilesystemOutput) copyFileContent(ctx context.Context, targetPath string, f fs.File) (string, int64, error) {
 	switch _, err := os.Stat(targetPath); {
 	case os.IsNotExist(err): // copy file below
 	case err == nil:
 		if !o.OverwriteFiles {
-			return errors.Errorf("unable to create %q, it already exists", targetPath)
+			return "", 0, errors.Errorf("unable to create %q, it already exists", targetPath)
 		}
 
 		log(ctx).Debugf("Overwriting existing file: %v", targetPath)
 	default:
-		return errors.Wrap(err, "failed to stat "+targetPath)
+		return "", 0, errors.Wrap(err, "failed to stat "+targetPath)
 	}
 
 	r, err := f.Open(ctx)
 	if err != nil {
-		return errors.Wrap(err, "unable to open snapshot file for "+targetPath)
+		return "", 0, errors.Wrap(err, "unable to open snapshot file for "+targetPath)
+	}
+
+	rc, err := o.maybeThrottle(r)
+	if err != nil {
+		r.Close() //nolint:errcheck
+		return "", 0, err
 	}
-	defer r.Close() //nolint:errcheck
+	defer rc.Close() //nolint:errcheck
 
 	log(ctx).Debugf("copying file contents to: %v", targetPath)
 
-	return atomic.WriteFile(targetPath, r)
+	return writeTempFile(targetPath, rc, o.SyncBatchSize <= 0)
 }
 
 func isEmptyDirectory(name string) (bool, error) {
@@ -0,0 +1,8 @@
+// +build !windows
+
+package restore
+
+func setSecurityDescriptor(path, sddl string) error {
+	// security descriptors can only be applied on Windows, they are preserved in metadata sidecars instead.
+	return nil
+}
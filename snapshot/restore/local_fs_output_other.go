@@ -0,0 +1,22 @@
+// +build !linux,!darwin,!windows
+
+package restore
+
+import (
+	"os"
+	"time"
+)
+
+func symlinkChown(path string, uid, gid int) error {
+	return os.Lchown(path, uid, gid)
+}
+
+func symlinkChmod(path string, mode os.FileMode) error {
+	// changing permissions of symlinks without following them is not portable
+	return nil
+}
+
+func symlinkChtimes(linkPath string, atime, mtime time.Time) error {
+	// changing times of symlinks without following them is not portable
+	return nil
+}
@@ -0,0 +1,235 @@
+package restore
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/efarrer/iothrottler"
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/iocopy"
+)
+
+// SetMaxWriteSpeed limits the combined speed of writing file contents, 0 means unlimited.
+// It can be called while the restore is in progress.
+func (o *FilesystemOutput) SetMaxWriteSpeed(bytesPerSecond int64) {
+	o.throttleMutex.Lock()
+	defer o.throttleMutex.Unlock()
+
+	o.maxWriteBytesPerSecond = bytesPerSecond
+	o.throttleEnabled = true
+
+	if o.throttler != nil {
+		o.throttler.SetBandwidth(toBandwidth(bytesPerSecond))
+	}
+}
+
+// MaxWriteSpeed returns the current limit of write speed in bytes per second, 0 means unlimited.
+func (o *FilesystemOutput) MaxWriteSpeed() int64 {
+	o.throttleMutex.Lock()
+	defer o.throttleMutex.Unlock()
+
+	return o.maxWriteBytesPerSecond
+}
+
+func toBandwidth(bytesPerSecond int64) iothrottler.Bandwidth {
+	if bytesPerSecond <= 0 {
+		return iothrottler.Unlimited
+	}
+
+	return iothrottler.Bandwidth(bytesPerSecond) * iothrottler.BytesPerSecond
+}
+
+// maybeThrottle returns a reader whose reads are subject to the write speed limit,
+// once the limit has been set. The throttler remains in use even if the speed is later
+// set to unlimited, so that the limit can be adjusted again.
+func (o *FilesystemOutput) maybeThrottle(r io.ReadCloser) (io.ReadCloser, error) {
+	o.throttleMutex.Lock()
+	if !o.throttleEnabled {
+		o.throttleMutex.Unlock()
+		return r, nil
+	}
+
+	if o.throttler == nil {
+		o.throttler = iothrottler.NewIOThrottlerPool(toBandwidth(o.maxWriteBytesPerSecond))
+	}
+
+	t := o.throttler
+	o.throttleMutex.Unlock()
+
+	tr, err := t.AddReader(r)
+
+	return tr, errors.Wrap(err, "unable to throttle reader")
+}
+
+func (o *FilesystemOutput) releaseThrottler() {
+	o.throttleMutex.Lock()
+	defer o.throttleMutex.Unlock()
+
+	if o.throttler != nil {
+		o.throttler.ReleasePool()
+		o.throttler = nil
+	}
+}
+
+// pendingSyncFile is a file that has been written to a temporary file, which is renamed to the target path
+// once it's synced.
+type pendingSyncFile struct {
+	tmpPath    string
+	targetPath string
+	size       int64
+}
+
+// writeTempFile writes the contents to a new temporary file next to targetPath, optionally syncing it
+// to stable storage, and returns its name and size.
+func writeTempFile(targetPath string, r io.Reader, sync bool) (string, int64, error) {
+	f, err := ioutil.TempFile(filepath.Dir(targetPath), filepath.Base(targetPath)+".tmp")
+	if err != nil {
+		return "", 0, errors.Wrap(err, "unable to create temporary file")
+	}
+
+	tmpName := f.Name()
+
+	n, err := iocopy.Copy(f, r)
+	if err == nil && sync {
+		err = f.Sync()
+	}
+
+	if err != nil {
+		f.Close()          //nolint:errcheck
+		os.Remove(tmpName) //nolint:errcheck
+
+		return "", 0, errors.Wrap(err, "unable to write file contents")
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(tmpName) //nolint:errcheck
+		return "", 0, errors.Wrap(err, "unable to close file")
+	}
+
+	return tmpName, n, nil
+}
+
+// renameAndSyncDir renames the synced temporary file to the target path and syncs the directory
+// containing it, so that the rename itself survives a crash.
+func renameAndSyncDir(tmpPath, targetPath string) error {
+	if err := os.Rename(tmpPath, targetPath); err != nil {
+		os.Remove(tmpPath) //nolint:errcheck
+		return errors.Wrap(err, "unable to rename file")
+	}
+
+	return syncDirs(map[string]bool{filepath.Dir(targetPath): true})
+}
+
+// addPendingSync records a temporary file that needs to be synced and renamed and syncs all pending files
+// once their total size reaches SyncBatchSize.
+func (o *FilesystemOutput) addPendingSync(tmpPath, targetPath string, size int64) error {
+	o.syncMutex.Lock()
+
+	o.pendingSyncFiles = append(o.pendingSyncFiles, pendingSyncFile{tmpPath, targetPath, size})
+	o.pendingSyncBytes += size
+
+	if o.pendingSyncBytes < o.SyncBatchSize {
+		o.syncMutex.Unlock()
+		return nil
+	}
+
+	files := o.pendingSyncFiles
+	o.pendingSyncFiles = nil
+	o.pendingSyncBytes = 0
+	o.syncMutex.Unlock()
+
+	return syncFiles(files)
+}
+
+// syncPendingFiles syncs all files that have been written but not synced yet.
+func (o *FilesystemOutput) syncPendingFiles() error {
+	o.syncMutex.Lock()
+	files := o.pendingSyncFiles
+	o.pendingSyncFiles = nil
+	o.pendingSyncBytes = 0
+	o.syncMutex.Unlock()
+
+	return syncFiles(files)
+}
+
+// syncPendingFilesIn syncs files pending in the provided directory.
+func (o *FilesystemOutput) syncPendingFilesIn(dir string) error {
+	var files []pendingSyncFile
+
+	o.syncMutex.Lock()
+
+	remaining := o.pendingSyncFiles[:0]
+
+	for _, f := range o.pendingSyncFiles {
+		if filepath.Dir(f.targetPath) == dir {
+			files = append(files, f)
+			o.pendingSyncBytes -= f.size
+		} else {
+			remaining = append(remaining, f)
+		}
+	}
+
+	o.pendingSyncFiles = remaining
+	o.syncMutex.Unlock()
+
+	return syncFiles(files)
+}
+
+// syncFiles syncs the temporary files, renames them to their target paths and then syncs directories
+// containing them, so that a crash never leaves a target file whose contents have not been synced.
+func syncFiles(files []pendingSyncFile) error {
+	for i, f := range files {
+		if err := syncPath(f.tmpPath); err != nil {
+			removeTempFiles(files[i:])
+			return err
+		}
+	}
+
+	dirs := map[string]bool{}
+
+	for i, f := range files {
+		if err := os.Rename(f.tmpPath, f.targetPath); err != nil {
+			removeTempFiles(files[i:])
+			return errors.Wrap(err, "unable to rename file")
+		}
+
+		dirs[filepath.Dir(f.targetPath)] = true
+	}
+
+	return syncDirs(dirs)
+}
+
+func removeTempFiles(files []pendingSyncFile) {
+	for _, f := range files {
+		os.Remove(f.tmpPath) //nolint:errcheck
+	}
+}
+
+func syncDirs(dirs map[string]bool) error {
+	if isWindows() {
+		// directories can't be synced on Windows.
+		return nil
+	}
+
+	for d := range dirs {
+		if err := syncPath(d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func syncPath(fn string) error {
+	f, err := os.Open(fn) //nolint:gosec
+	if err != nil {
+		return errors.Wrap(err, "unable to open file for syncing")
+	}
+
+	defer f.Close() //nolint:errcheck,gosec
+
+	return errors.Wrapf(f.Sync(), "unable to sync %v", fn)
+}
@@ -0,0 +1,156 @@
+package restore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+func TestFilesystemOutputSyncBatchAndThrottle(t *testing.T) {
+	ctx := testlogging.Context(t)
+	dir := t.TempDir()
+
+	const (
+		fileCount = 3
+		fileSize  = 100000
+	)
+
+	src := mockfs.NewDirectory()
+	content := bytes.Repeat([]byte{1, 2, 3, 4}, fileSize/4)
+
+	for i := 0; i < fileCount; i++ {
+		src.AddFile(fileName(i), content, 0o600)
+	}
+
+	o := &FilesystemOutput{
+		TargetPath:    dir,
+		SkipOwners:    true,
+		SkipTimes:     true,
+		SyncBatchSize: fileSize * 2,
+	}
+
+	o.SetMaxWriteSpeed(fileSize)
+
+	t0 := clock.Now()
+
+	for i := 0; i < fileCount; i++ {
+		e, err := src.Child(ctx, fileName(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := o.WriteFile(ctx, fileName(i), e.(fs.File)); err != nil {
+			t.Fatal(err)
+		}
+
+		// the limit can be adjusted while the restore is in progress.
+		if i == 1 {
+			o.SetMaxWriteSpeed(0)
+		}
+	}
+
+	if dt := clock.Since(t0); dt < time.Second {
+		t.Errorf("restore was not throttled: %v", dt)
+	}
+
+	if got, want := len(o.pendingSyncFiles), 1; got != want {
+		t.Errorf("unexpected number of files pending sync: %v, want %v", got, want)
+	}
+
+	if err := o.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(o.pendingSyncFiles); got != 0 {
+		t.Errorf("files were not synced on close: %v", got)
+	}
+
+	for i := 0; i < fileCount; i++ {
+		b, err := ioutil.ReadFile(filepath.Join(dir, fileName(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(b, content) {
+			t.Errorf("invalid contents of %v", fileName(i))
+		}
+	}
+}
+
+func TestFilesystemOutputSyncBatchRenamesSyncedFiles(t *testing.T) {
+	ctx := testlogging.Context(t)
+	dir := t.TempDir()
+
+	src := mockfs.NewDirectory()
+	subdir := src.AddDir("subdir", 0o755)
+	src.AddFile("subdir/file", []byte("hello"), 0o640)
+
+	o := &FilesystemOutput{
+		TargetPath:    dir,
+		SkipOwners:    true,
+		SkipTimes:     true,
+		SyncBatchSize: 1 << 20,
+	}
+
+	if err := o.BeginDirectory(ctx, "subdir", subdir); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := subdir.Child(ctx, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.WriteFile(ctx, "subdir/file", e.(fs.File)); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(dir, "subdir", "file")
+
+	// files are renamed to their target paths only after they have been synced.
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("file was renamed before it was synced: %v", err)
+	}
+
+	// finishing the directory syncs and renames files in it before the attributes of the directory are set.
+	if err := o.FinishDirectory(ctx, "subdir", subdir); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(o.pendingSyncFiles); got != 0 {
+		t.Errorf("files were not synced when finishing directory: %v", got)
+	}
+
+	if got := o.pendingSyncBytes; got != 0 {
+		t.Errorf("unexpected size of files pending sync: %v", got)
+	}
+
+	st, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := st.Mode().Perm(), os.FileMode(0o640); !isWindows() && got != want {
+		t.Errorf("unexpected permissions %v, want %v", got, want)
+	}
+
+	if entries, _ := ioutil.ReadDir(filepath.Join(dir, "subdir")); len(entries) != 1 {
+		t.Errorf("temporary files were not renamed: %v", entries)
+	}
+
+	if err := o.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func fileName(i int) string {
+	return "file" + string(rune('a'+i))
+}
@@ -38,3 +38,67 @@ func symlinkChtimes(linkPath string, atime, mtime time.Time) error {
 
 	return windows.SetFileTime(h, &ftw, &fta, &ftw)
 }
+
+func setSecurityDescriptor(path, sddl string) error {
+	sd, err := windows.SecurityDescriptorFromString(sddl)
+	if err != nil {
+		return errors.Wrap(err, "invalid security descriptor")
+	}
+
+	control, _, err := sd.Control()
+	if err != nil {
+		return errors.Wrap(err, "unable to get security descriptor control")
+	}
+
+	var (
+		info windows.SECURITY_INFORMATION
+
+		owner, group *windows.SID
+		dacl, sacl   *windows.ACL
+	)
+
+	if owner, _, err = sd.Owner(); err == nil && owner != nil {
+		info |= windows.OWNER_SECURITY_INFORMATION
+	}
+
+	if group, _, err = sd.Group(); err == nil && group != nil {
+		info |= windows.GROUP_SECURITY_INFORMATION
+	}
+
+	if control&windows.SE_DACL_PRESENT != 0 {
+		if dacl, _, err = sd.DACL(); err != nil {
+			return errors.Wrap(err, "unable to get DACL")
+		}
+
+		info |= windows.DACL_SECURITY_INFORMATION
+
+		// preserve whether the ACL inherits entries from the parent directory.
+		if control&windows.SE_DACL_PROTECTED != 0 {
+			info |= windows.PROTECTED_DACL_SECURITY_INFORMATION
+		} else {
+			info |= windows.UNPROTECTED_DACL_SECURITY_INFORMATION
+		}
+	}
+
+	if control&windows.SE_SACL_PRESENT != 0 {
+		if sacl, _, err = sd.SACL(); err != nil {
+			return errors.Wrap(err, "unable to get SACL")
+		}
+
+		info |= windows.SACL_SECURITY_INFORMATION
+
+		if control&windows.SE_SACL_PROTECTED != 0 {
+			info |= windows.PROTECTED_SACL_SECURITY_INFORMATION
+		} else {
+			info |= windows.UNPROTECTED_SACL_SECURITY_INFORMATION
+		}
+	}
+
+	err = windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, info, owner, group, dacl, sacl)
+	if errors.Is(err, windows.ERROR_PRIVILEGE_NOT_HELD) || errors.Is(err, windows.ERROR_INVALID_OWNER) {
+		// setting arbitrary owner or SACL requires SeRestorePrivilege or SeSecurityPrivilege.
+		return &os.PathError{Op: "SetNamedSecurityInfo", Path: path, Err: os.ErrPermission}
+	}
+
+	return err
+}
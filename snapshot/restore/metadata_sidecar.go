@@ -0,0 +1,259 @@
+package restore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+)
+
+const (
+	// MetadataSidecarDir is the name of the directory in which metadata that can't be represented
+	// on the operating system performing the restore is stored.
+	MetadataSidecarDir = ".kopia-metadata"
+
+	metadataSidecarFile = "metadata.json"
+)
+
+// SidecarMetadata describes metadata of a single restored entry that could not be applied.
+type SidecarMetadata struct {
+	Path    string       `json:"path"`
+	UserID  *uint32      `json:"uid,omitempty"`
+	GroupID *uint32      `json:"gid,omitempty"`
+	Mode    *os.FileMode `json:"mode,omitempty"`
+
+	// SecurityDescriptor is the Windows security descriptor in SDDL format.
+	SecurityDescriptor string `json:"sd,omitempty"`
+}
+
+// ownerRepresentable returns true if ownership can be applied on the current operating system.
+func ownerRepresentable() bool {
+	return !isWindows()
+}
+
+// permissionsRepresentable returns true if all permission bits of the entry can be applied on the current operating system.
+func permissionsRepresentable(e fs.Entry) bool {
+	if isWindows() {
+		return false
+	}
+
+	// linux does not support permissions on symlinks
+	return !(isSymlink(e) && runtime.GOOS == "linux")
+}
+
+// maybeWriteSidecarMetadata records metadata of the provided entry that can't be applied on the current operating system.
+func (o *FilesystemOutput) maybeWriteSidecarMetadata(targetPath string, e fs.Entry) error {
+	if !o.WriteMetadataSidecars {
+		return nil
+	}
+
+	var md SidecarMetadata
+
+	if !o.SkipOwners && !ownerRepresentable() {
+		uid, gid := e.Owner().UserID, e.Owner().GroupID
+		md.UserID, md.GroupID = &uid, &gid
+	}
+
+	if !o.SkipPermissions && !permissionsRepresentable(e) {
+		mode := e.Mode() & modBits
+		md.Mode = &mode
+	}
+
+	if !isWindows() {
+		sd, err := o.securityDescriptorToApply(e)
+		if err != nil {
+			return errors.Wrap(err, "unable to get security descriptor")
+		}
+
+		md.SecurityDescriptor = sd
+	}
+
+	if md.UserID == nil && md.Mode == nil && md.SecurityDescriptor == "" {
+		return nil
+	}
+
+	o.sidecarMutex.Lock()
+	defer o.sidecarMutex.Unlock()
+
+	if o.sidecarFile == nil {
+		if err := o.openSidecarFileLocked(); err != nil {
+			return err
+		}
+	}
+
+	rel, err := filepath.Rel(o.sidecarBaseDir, targetPath)
+	if err != nil {
+		return errors.Wrap(err, "unable to determine relative path")
+	}
+
+	md.Path = filepath.ToSlash(rel)
+
+	return errors.Wrap(json.NewEncoder(o.sidecarFile).Encode(md), "unable to write sidecar metadata")
+}
+
+func (o *FilesystemOutput) openSidecarFileLocked() error {
+	o.sidecarBaseDir = o.TargetPath
+
+	// when restoring a single file, store metadata alongside it.
+	if st, err := os.Stat(o.TargetPath); err == nil && !st.IsDir() {
+		o.sidecarBaseDir = filepath.Dir(o.TargetPath)
+	}
+
+	dir := filepath.Join(o.sidecarBaseDir, MetadataSidecarDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return errors.Wrap(err, "unable to create sidecar metadata directory")
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, metadataSidecarFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600) //nolint:gosec
+	if err != nil {
+		return errors.Wrap(err, "unable to create sidecar metadata file")
+	}
+
+	o.sidecarFile = f
+
+	return nil
+}
+
+func (o *FilesystemOutput) closeSidecarFile() error {
+	o.sidecarMutex.Lock()
+	defer o.sidecarMutex.Unlock()
+
+	if o.sidecarFile == nil {
+		return nil
+	}
+
+	err := o.sidecarFile.Close()
+	o.sidecarFile = nil
+
+	return errors.Wrap(err, "unable to close sidecar metadata file")
+}
+
+// ApplySidecarMetadata applies metadata stored in sidecar files under the provided directory
+// during restore on another operating system and returns the number of entries updated.
+func ApplySidecarMetadata(ctx context.Context, dir string, ignorePermissionErrors bool) (int, error) {
+	f, err := os.Open(filepath.Join(dir, MetadataSidecarDir, metadataSidecarFile)) //nolint:gosec
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to open sidecar metadata")
+	}
+	defer f.Close() //nolint:errcheck,gosec
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	count := 0
+
+	for {
+		var md SidecarMetadata
+
+		if err := dec.Decode(&md); err != nil {
+			if errors.Is(err, io.EOF) {
+				return count, nil
+			}
+
+			return count, errors.Wrap(err, "invalid sidecar metadata")
+		}
+
+		path, err := sidecarEntryPath(dir, md.Path)
+		if err != nil {
+			return count, err
+		}
+
+		if err := applySidecarMetadata(ctx, path, &md); err != nil {
+			if ignorePermissionErrors && os.IsPermission(errors.Cause(err)) {
+				log(ctx).Warningf("unable to apply metadata of %v: %v", md.Path, err)
+				continue
+			}
+
+			return count, err
+		}
+
+		count++
+	}
+}
+
+// sidecarEntryPath returns the path of the entry described by sidecar metadata, ensuring that it refers
+// to an entry inside the provided directory which is not reached through symbolic links.
+func sidecarEntryPath(dir, relPath string) (string, error) {
+	rel := filepath.FromSlash(relPath)
+	if rel == "" || filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" {
+		return "", errors.Errorf("invalid path in sidecar metadata: %q", relPath)
+	}
+
+	rel = filepath.Clean(rel)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("path in sidecar metadata is outside of %v: %q", dir, relPath)
+	}
+
+	// verify that none of the parent directories is a symbolic link.
+	parent := dir
+
+	for _, p := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if p == "." {
+			break
+		}
+
+		parent = filepath.Join(parent, p)
+
+		st, err := os.Lstat(parent)
+		if err != nil {
+			return "", errors.Wrap(err, "unable to stat restored entry")
+		}
+
+		if !st.IsDir() {
+			return "", errors.Errorf("path in sidecar metadata traverses a symbolic link or file: %q", relPath)
+		}
+	}
+
+	return filepath.Join(dir, rel), nil
+}
+
+func applySidecarMetadata(ctx context.Context, path string, md *SidecarMetadata) error {
+	st, err := os.Lstat(path)
+	if err != nil {
+		return errors.Wrap(err, "unable to stat restored entry")
+	}
+
+	isLink := st.Mode()&os.ModeSymlink != 0
+
+	if md.UserID != nil && md.GroupID != nil && ownerRepresentable() {
+		log(ctx).Debugf("chown %v %v:%v", path, *md.UserID, *md.GroupID)
+
+		if err := os.Lchown(path, int(*md.UserID), int(*md.GroupID)); err != nil {
+			return errors.Wrapf(err, "could not change owner/group for %v", path)
+		}
+	}
+
+	if md.Mode != nil && !isWindows() {
+		log(ctx).Debugf("chmod %v %v", path, *md.Mode)
+
+		if err := chmodNoFollow(path, *md.Mode, isLink); err != nil {
+			return errors.Wrapf(err, "could not change permissions on %v", path)
+		}
+	}
+
+	if md.SecurityDescriptor != "" && !isLink && isWindows() {
+		log(ctx).Debugf("set security descriptor %v %v", path, md.SecurityDescriptor)
+
+		if err := setSecurityDescriptor(path, md.SecurityDescriptor); err != nil {
+			return errors.Wrapf(err, "could not set security descriptor on %v", path)
+		}
+	}
+
+	return nil
+}
+
+// chmodNoFollow changes permissions of the provided entry without following symbolic links,
+// permissions of symbolic links are only applied where the operating system supports them.
+func chmodNoFollow(path string, mode os.FileMode, isLink bool) error {
+	if isLink {
+		return symlinkChmod(path, mode)
+	}
+
+	return os.Chmod(path, mode)
+}
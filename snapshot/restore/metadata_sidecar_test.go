@@ -0,0 +1,185 @@
+package restore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+func TestMetadataSidecars(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("test relies on symlink permissions not being supported")
+	}
+
+	ctx := testlogging.Context(t)
+	dir := t.TempDir()
+
+	src := mockfs.NewDirectory()
+	src.AddSymlink("link", "target", 0o751)
+
+	sl, err := src.Child(ctx, "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := &FilesystemOutput{
+		TargetPath:            dir,
+		SkipOwners:            true,
+		SkipTimes:             true,
+		WriteMetadataSidecars: true,
+	}
+
+	if err = o.CreateSymlink(ctx, "link", sl.(fs.Symlink)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = o.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	sidecarFile := filepath.Join(dir, MetadataSidecarDir, metadataSidecarFile)
+
+	b, err := ioutil.ReadFile(sidecarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := string(b), `{"path":"link","mode":489}`+"\n"; got != want {
+		t.Fatalf("unexpected sidecar metadata: %q, want %q", got, want)
+	}
+
+	// simulate metadata written on another operating system.
+	if err = ioutil.WriteFile(filepath.Join(dir, "f"), []byte{1, 2, 3}, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(sidecarFile, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.WriteString(`{"path":"f","mode":416}` + "\n") //nolint:errcheck
+	f.Close()
+
+	n, err := ApplySidecarMetadata(ctx, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 2 {
+		t.Errorf("unexpected number of entries: %v", n)
+	}
+
+	st, err := os.Stat(filepath.Join(dir, "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := st.Mode().Perm(), os.FileMode(0o640); got != want {
+		t.Errorf("unexpected permissions %v, want %v", got, want)
+	}
+}
+
+type fileWithSecurityDescriptor struct {
+	fs.File
+	sd string
+}
+
+func (f fileWithSecurityDescriptor) SecurityDescriptor() (string, error) {
+	return f.sd, nil
+}
+
+func TestMetadataSidecarsSecurityDescriptor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("security descriptors are applied directly on Windows")
+	}
+
+	ctx := testlogging.Context(t)
+	dir := t.TempDir()
+
+	src := mockfs.NewDirectory()
+	src.AddFile("f", []byte{1, 2, 3}, 0o600)
+
+	f, err := src.Child(ctx, "f")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := &FilesystemOutput{
+		TargetPath:            dir,
+		SkipOwners:            true,
+		SkipTimes:             true,
+		WriteMetadataSidecars: true,
+	}
+
+	if err = o.WriteFile(ctx, "f", fileWithSecurityDescriptor{f.(fs.File), "O:BAG:SYD:(A;;FA;;;BA)"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = o.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, MetadataSidecarDir, metadataSidecarFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := string(b), `{"path":"f","sd":"O:BAG:SYD:(A;;FA;;;BA)"}`+"\n"; got != want {
+		t.Fatalf("unexpected sidecar metadata: %q, want %q", got, want)
+	}
+}
+
+func TestMetadataSidecarsRejectPathsOutsideDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges on Windows")
+	}
+
+	ctx := testlogging.Context(t)
+	outside := t.TempDir()
+	dir := t.TempDir()
+
+	if err := ioutil.WriteFile(filepath.Join(outside, "f"), []byte{1, 2, 3}, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, MetadataSidecarDir), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{
+		"../" + filepath.Base(outside) + "/f",
+		"a/../../f",
+		filepath.ToSlash(filepath.Join(outside, "f")),
+		"link/f",
+	} {
+		md := `{"path":"` + p + `","mode":511}` + "\n"
+
+		if err := ioutil.WriteFile(filepath.Join(dir, MetadataSidecarDir, metadataSidecarFile), []byte(md), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := ApplySidecarMetadata(ctx, dir, false); err == nil {
+			t.Errorf("path %q was not rejected", p)
+		}
+	}
+
+	st, err := os.Stat(filepath.Join(outside, "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := st.Mode().Perm(), os.FileMode(0o600); got != want {
+		t.Errorf("permissions of file outside of restore directory changed to %v", got)
+	}
+}
@@ -11,6 +11,7 @@
 	"github.com/kopia/kopia/fs"
 	"github.com/kopia/kopia/internal/parallelwork"
 	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
 	"github.com/kopia/kopia/repo/logging"
 )
 
@@ -37,6 +38,10 @@ type Stats struct {
 	EnqueuedFileCount    int32
 	EnqueuedDirCount     int32
 	EnqueuedSymlinkCount int32
+
+	// ArchivedFileCount is the number of files which were not restored because their data is stored
+	// in an archive tier and has to be rehydrated first.
+	ArchivedFileCount int32
 }
 
 func (s *Stats) clone() Stats {
@@ -49,6 +54,7 @@ func (s *Stats) clone() Stats {
 		EnqueuedFileCount:     atomic.LoadInt32(&s.EnqueuedFileCount),
 		EnqueuedDirCount:      atomic.LoadInt32(&s.EnqueuedDirCount),
 		EnqueuedSymlinkCount:  atomic.LoadInt32(&s.EnqueuedSymlinkCount),
+		ArchivedFileCount:     atomic.LoadInt32(&s.ArchivedFileCount),
 	}
 }
 
@@ -87,6 +93,10 @@ func Entry(ctx context.Context, rep repo.Repository, output Output, rootEntry fs
 		return Stats{}, errors.Wrap(err, "error closing output")
 	}
 
+	if c.stats.ArchivedFileCount > 0 {
+		return c.stats, errors.Wrapf(blob.ErrBlobArchived, "%v files were not restored", c.stats.ArchivedFileCount)
+	}
+
 	return c.stats, nil
 }
 
@@ -108,7 +118,16 @@ func (c *copier) copyEntry(ctx context.Context, e fs.Entry, targetPath string, o
 		atomic.AddInt64(&c.stats.RestoredTotalFileSize, e.Size())
 
 		if err := c.output.WriteFile(ctx, targetPath, e); err != nil {
-			return errors.Wrap(err, "copy file")
+			if !errors.Is(err, blob.ErrBlobArchived) {
+				return errors.Wrap(err, "copy file")
+			}
+
+			// keep going, so that rehydration of all archived data is initiated in a single pass.
+			log(ctx).Infof("unable to restore %v: %v", targetPath, err)
+
+			atomic.AddInt32(&c.stats.RestoredFileCount, -1)
+			atomic.AddInt64(&c.stats.RestoredTotalFileSize, -e.Size())
+			atomic.AddInt32(&c.stats.ArchivedFileCount, 1)
 		}
 
 		return onCompletion()
@@ -145,19 +164,20 @@ func (c *copier) copyDirectory(ctx context.Context, d fs.Directory, targetPath s
 }
 
 func (c *copier) copyDirectoryContent(ctx context.Context, d fs.Directory, targetPath string, onCompletion parallelwork.CallbackFunc) error {
-	entries, err := d.Readdir(ctx)
-	if err != nil {
-		return err
-	}
+	// number of outstanding items, including one for the directory listing itself, which ensures
+	// onCompletion is not invoked before all entries have been streamed and enqueued.
+	pending := int64(1)
 
-	if len(entries) == 0 {
-		return onCompletion()
-	}
+	onItemCompletion := func() error {
+		if atomic.AddInt64(&pending, -1) == 0 {
+			return onCompletion()
+		}
 
-	onItemCompletion := parallelwork.OnNthCompletion(len(entries), onCompletion)
+		return nil
+	}
 
-	for _, e := range entries {
-		e := e
+	if err := fs.IterateEntries(ctx, d, func(_ context.Context, e fs.Entry) error {
+		atomic.AddInt64(&pending, 1)
 
 		if e.IsDir() {
 			atomic.AddInt32(&c.stats.EnqueuedDirCount, 1)
@@ -178,7 +198,11 @@ func (c *copier) copyDirectoryContent(ctx context.Context, d fs.Directory, targe
 				return c.copyEntry(ctx, e, path.Join(targetPath, e.Name()), onItemCompletion)
 			})
 		}
+
+		return nil
+	}); err != nil {
+		return err
 	}
 
-	return nil
+	return onItemCompletion()
 }
@@ -0,0 +1,81 @@
+package restore
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// archivedFile is a file whose data is stored in an archive tier.
+type archivedFile struct {
+	*mockfs.File
+}
+
+func (f archivedFile) Open(ctx context.Context) (fs.Reader, error) {
+	return nil, errors.Wrap(blob.ErrBlobArchived, "pack is archived")
+}
+
+func TestRestoreContinuesPastArchivedFiles(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	src := mockfs.NewDirectory()
+	src.AddFile("f1", []byte{1, 2, 3}, 0o644)
+	src.AddFile("f3", []byte{4, 5}, 0o644)
+	src.AddDir("d1", 0o755).AddFile("f4", []byte{10}, 0o644)
+
+	archived := archivedFile{mockfs.NewDirectory().AddFile("f2", []byte{6, 7, 8, 9}, 0o644)}
+
+	dir := &dirWithExtraEntry{Directory: src, extra: archived}
+
+	var buf bytes.Buffer
+
+	st, err := Entry(ctx, nil, NewTarOutput(nopWriteCloser{&buf}), dir, Options{
+		ProgressCallback: func(ctx context.Context, s Stats) {},
+	})
+	require.True(t, errors.Is(err, blob.ErrBlobArchived), "unexpected error: %v", err)
+	require.EqualValues(t, 1, st.ArchivedFileCount)
+	require.EqualValues(t, 3, st.RestoredFileCount)
+	require.EqualValues(t, 6, st.RestoredTotalFileSize)
+
+	var names []string
+
+	tr := tar.NewReader(&buf)
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+
+		names = append(names, h.Name)
+	}
+
+	require.ElementsMatch(t, []string{"f1", "f3", "d1/", "d1/f4"}, names)
+}
+
+// dirWithExtraEntry is a directory which contains an additional entry.
+type dirWithExtraEntry struct {
+	*mockfs.Directory
+	extra fs.Entry
+}
+
+func (d *dirWithExtraEntry) Readdir(ctx context.Context) (fs.Entries, error) {
+	entries, err := d.Directory.Readdir(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(entries, d.extra), nil
+}
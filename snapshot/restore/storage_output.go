@@ -0,0 +1,132 @@
+package restore
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+// StorageOutput restores files as objects in blob storage, such as S3, GCS or Azure buckets,
+// using relative paths of files as object keys.
+//
+// Object storage has no notion of directories and symbolic links, so empty directories
+// and symbolic links are not restored. Files are streamed to storage without buffering them in memory.
+type StorageOutput struct {
+	st blob.Storage
+}
+
+// Parallelizable implements restore.Output interface.
+func (o *StorageOutput) Parallelizable() bool {
+	return true
+}
+
+// BeginDirectory implements restore.Output interface.
+func (o *StorageOutput) BeginDirectory(ctx context.Context, relativePath string, d fs.Directory) error {
+	return nil
+}
+
+// FinishDirectory implements restore.Output interface.
+func (o *StorageOutput) FinishDirectory(ctx context.Context, relativePath string, e fs.Directory) error {
+	return nil
+}
+
+// Close implements restore.Output interface.
+func (o *StorageOutput) Close(ctx context.Context) error {
+	return nil
+}
+
+// WriteFile implements restore.Output interface.
+func (o *StorageOutput) WriteFile(ctx context.Context, relativePath string, f fs.File) error {
+	if relativePath == "" {
+		// restoring a single file, use its name as the key.
+		relativePath = f.Name()
+	}
+
+	data := &fileBytes{ctx: ctx, f: f}
+	defer data.close()
+
+	if err := o.st.PutBlob(ctx, blob.ID(relativePath), data, blob.PutOptions{}); err != nil {
+		return errors.Wrapf(err, "error writing %v", relativePath)
+	}
+
+	return nil
+}
+
+// fileBytes exposes the contents of a file as blob.Bytes without buffering it, each call to Reader() or WriteTo()
+// reads the file from the beginning, which allows storage to retry the upload.
+type fileBytes struct {
+	ctx context.Context
+	f   fs.File
+
+	readers []fs.Reader
+}
+
+func (b *fileBytes) Length() int {
+	return int(b.f.Size())
+}
+
+func (b *fileBytes) Reader() io.Reader {
+	r, err := b.f.Open(b.ctx)
+	if err != nil {
+		return errorReader{errors.Wrap(err, "error opening file")}
+	}
+
+	b.readers = append(b.readers, r)
+
+	return &sizeCheckingReader{r: r, remaining: b.f.Size()}
+}
+
+func (b *fileBytes) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.Copy(w, b.Reader())
+
+	return n, errors.Wrap(err, "error reading file")
+}
+
+func (b *fileBytes) close() {
+	for _, r := range b.readers {
+		r.Close() //nolint:errcheck
+	}
+
+	b.readers = nil
+}
+
+// sizeCheckingReader fails when the file is shorter than its size recorded in the snapshot,
+// which would otherwise produce truncated objects.
+type sizeCheckingReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (r *sizeCheckingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.remaining -= int64(n)
+
+	if errors.Is(err, io.EOF) && r.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// CreateSymlink implements restore.Output interface.
+func (o *StorageOutput) CreateSymlink(ctx context.Context, relativePath string, l fs.Symlink) error {
+	log(ctx).Warningf("skipping symbolic link %v, which is not supported by storage", relativePath)
+	return nil
+}
+
+// NewStorageOutput creates new output which writes files to the provided storage.
+func NewStorageOutput(st blob.Storage) *StorageOutput {
+	return &StorageOutput{st}
+}
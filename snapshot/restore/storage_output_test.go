@@ -0,0 +1,92 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/blobtesting"
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+)
+
+func TestStorageOutput(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	src := mockfs.NewDirectory()
+	src.AddFile("f1", []byte{1, 2, 3}, 0o644)
+	src.AddDir("d1", 0o755).AddFile("f2", []byte{4, 5}, 0o644)
+	src.AddDir("empty", 0o755)
+	src.AddSymlink("link", "f1", 0o777)
+
+	data := blobtesting.DataMap{}
+
+	st, err := Entry(ctx, nil, NewStorageOutput(blobtesting.NewMapStorage(data, nil, nil)), src, Options{
+		ProgressCallback: func(ctx context.Context, s Stats) {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := st.RestoredFileCount, int32(2); got != want {
+		t.Fatalf("unexpected number of restored files: %v, want %v", got, want)
+	}
+
+	want := blobtesting.DataMap{
+		"f1":    []byte{1, 2, 3},
+		"d1/f2": []byte{4, 5},
+	}
+
+	if len(data) != len(want) {
+		t.Fatalf("unexpected objects: %v, want %v", data, want)
+	}
+
+	for k, v := range want {
+		if got := data[blob.ID(k)]; string(got) != string(v) {
+			t.Errorf("unexpected contents of %v: %v, want %v", k, got, v)
+		}
+	}
+}
+
+// retryingStorage reads blob data twice, like storage retrying failed uploads.
+type retryingStorage struct {
+	blob.Storage
+}
+
+func (s retryingStorage) PutBlob(ctx context.Context, id blob.ID, data blob.Bytes, opts blob.PutOptions) error {
+	first, err := ioutil.ReadAll(data.Reader())
+	if err != nil {
+		return err
+	}
+
+	if len(first) != data.Length() {
+		return errors.Errorf("unexpected length %v, want %v", len(first), data.Length())
+	}
+
+	return s.Storage.PutBlob(ctx, id, data, opts)
+}
+
+func TestStorageOutputRereadsFiles(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	content := bytes.Repeat([]byte{1, 2, 3}, 100000)
+
+	src := mockfs.NewDirectory()
+	src.AddFile("f1", content, 0o644)
+
+	data := blobtesting.DataMap{}
+
+	if _, err := Entry(ctx, nil, NewStorageOutput(retryingStorage{blobtesting.NewMapStorage(data, nil, nil)}), src, Options{
+		ProgressCallback: func(ctx context.Context, s Stats) {},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := data["f1"]; !bytes.Equal(got, content) {
+		t.Fatalf("unexpected contents of f1: %v bytes, want %v", len(got), len(content))
+	}
+}
@@ -4,12 +4,20 @@
 	"archive/tar"
 	"context"
 	"io"
+	"os"
 
 	"github.com/pkg/errors"
 
 	"github.com/kopia/kopia/fs"
 )
 
+// Special mode bits of tar headers.
+const (
+	tarModeSetuid = 0o4000
+	tarModeSetgid = 0o2000
+	tarModeSticky = 0o1000
+)
+
 // TarOutput contains the options for outputting a file system tree to a tar or .tar.gz file.
 type TarOutput struct {
 	w  io.Closer
@@ -30,7 +38,7 @@ func (o *TarOutput) BeginDirectory(ctx context.Context, relativePath string, d f
 	h := &tar.Header{
 		Name:     relativePath + "/",
 		ModTime:  d.ModTime(),
-		Mode:     int64(d.Mode()),
+		Mode:     tarMode(d.Mode()),
 		Uid:      int(d.Owner().UserID),
 		Gid:      int(d.Owner().GroupID),
 		Typeflag: tar.TypeDir,
@@ -69,7 +77,7 @@ func (o *TarOutput) WriteFile(ctx context.Context, relativePath string, f fs.Fil
 		Name:     relativePath,
 		ModTime:  f.ModTime(),
 		Size:     f.Size(),
-		Mode:     int64(f.Mode()),
+		Mode:     tarMode(f.Mode()),
 		Uid:      int(f.Owner().UserID),
 		Gid:      int(f.Owner().GroupID),
 		Typeflag: tar.TypeReg,
@@ -96,7 +104,7 @@ func (o *TarOutput) CreateSymlink(ctx context.Context, relativePath string, l fs
 	h := &tar.Header{
 		Name:     relativePath,
 		ModTime:  l.ModTime(),
-		Mode:     int64(l.Mode()),
+		Mode:     tarMode(l.Mode()),
 		Uid:      int(l.Owner().UserID),
 		Gid:      int(l.Owner().GroupID),
 		Typeflag: tar.TypeSymlink,
@@ -110,6 +118,25 @@ func (o *TarOutput) CreateSymlink(ctx context.Context, relativePath string, l fs
 	return nil
 }
 
+// tarMode converts the mode of the entry into tar mode bits, which represent special bits differently than os.FileMode.
+func tarMode(m os.FileMode) int64 {
+	mode := int64(m.Perm())
+
+	if m&os.ModeSetuid != 0 {
+		mode |= tarModeSetuid
+	}
+
+	if m&os.ModeSetgid != 0 {
+		mode |= tarModeSetgid
+	}
+
+	if m&os.ModeSticky != 0 {
+		mode |= tarModeSticky
+	}
+
+	return mode
+}
+
 // NewTarOutput creates new tar writer output.
 func NewTarOutput(w io.WriteCloser) *TarOutput {
 	return &TarOutput{w, tar.NewWriter(w)}
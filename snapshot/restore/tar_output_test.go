@@ -0,0 +1,73 @@
+package restore
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/testlogging"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestTarOutput(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	src := mockfs.NewDirectory()
+	src.AddFile("f1", []byte{1, 2, 3}, 0o644)
+	src.AddFile("suid", []byte{4}, 0o755|os.ModeSetuid)
+	src.AddDir("d1", 0o750|os.ModeSticky).AddFile("f2", []byte{4, 5}, 0o600)
+	src.AddSymlink("l1", "d1/f2", 0o777)
+
+	var buf bytes.Buffer
+
+	_, err := Entry(ctx, nil, NewTarOutput(nopWriteCloser{&buf}), src, Options{
+		ProgressCallback: func(ctx context.Context, s Stats) {},
+	})
+	require.NoError(t, err)
+
+	type tarEntry struct {
+		typeflag byte
+		mode     int64
+		linkname string
+		data     string
+	}
+
+	got := map[string]tarEntry{}
+	tr := tar.NewReader(&buf)
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+
+		data, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+
+		got[h.Name] = tarEntry{h.Typeflag, h.Mode, h.Linkname, string(data)}
+	}
+
+	require.Equal(t, map[string]tarEntry{
+		"f1":    {tar.TypeReg, 0o644, "", "\x01\x02\x03"},
+		"suid":  {tar.TypeReg, 0o4755, "", "\x04"},
+		"d1/":   {tar.TypeDir, 0o1750, "", ""},
+		"d1/f2": {tar.TypeReg, 0o600, "", "\x04\x05"},
+		"l1":    {tar.TypeSymlink, 0o777, "d1/f2", ""},
+	}, got)
+}
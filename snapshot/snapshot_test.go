@@ -246,3 +246,56 @@ func TestParseInvalidSourceInfo(t *testing.T) {
 		}
 	}
 }
+
+func TestUpdateSnapshotPins(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	src := snapshot.SourceInfo{
+		Host:     "host-1",
+		UserName: "user-1",
+		Path:     "/some/path",
+	}
+
+	id := mustSaveSnapshot(t, env.Repository, &snapshot.Manifest{Source: src, Description: "some-description"})
+
+	m, err := snapshot.LoadSnapshot(ctx, env.Repository, id)
+	if err != nil {
+		t.Fatalf("error loading snapshot: %v", err)
+	}
+
+	if !m.UpdatePins([]string{"b", "a", "b"}, nil) {
+		t.Fatalf("pins not updated")
+	}
+
+	if m.UpdatePins([]string{"a"}, []string{"c"}) {
+		t.Fatalf("pins unexpectedly updated")
+	}
+
+	if err = snapshot.UpdateSnapshot(ctx, env.Repository, m); err != nil {
+		t.Fatalf("error updating snapshot: %v", err)
+	}
+
+	// the snapshot keeps its ID.
+	verifySnapshotManifestIDs(t, env.Repository, &src, []manifest.ID{id})
+
+	got, err := snapshot.LoadSnapshot(ctx, env.Repository, id)
+	if err != nil {
+		t.Fatalf("error loading snapshot: %v", err)
+	}
+
+	if want := []string{"a", "b"}; !reflect.DeepEqual(got.Pins, want) {
+		t.Errorf("unexpected pins: %v, want %v", got.Pins, want)
+	}
+
+	if got.Description != "some-description" {
+		t.Errorf("unexpected description: %v", got.Description)
+	}
+
+	m.ID = "no-such-manifest-id"
+	if err := snapshot.UpdateSnapshot(ctx, env.Repository, m); !errors.Is(err, snapshot.ErrSnapshotNotFound) {
+		t.Errorf("unexpected error when updating snapshot that does not exist: %v", err)
+	}
+}
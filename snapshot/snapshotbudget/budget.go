@@ -0,0 +1,308 @@
+// Package snapshotbudget implements expiration of the oldest snapshots when the storage used by snapshots
+// exceeds the repository storage budget, in addition to count-based retention policies.
+package snapshotbudget
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/units"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/logging"
+	"github.com/kopia/kopia/repo/maintenance"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/repo/object"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/legalhold"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+var log = logging.GetContextLoggerFunc("snapshotbudget")
+
+// RunType is the name under which storage budget runs are recorded in maintenance schedule.
+const RunType = "storage-budget"
+
+// Result describes the snapshots expired to enforce the storage budget.
+type Result struct {
+	Budget int64 `json:"budget"`
+
+	// UsedBytes is the estimated storage used by contents of snapshots before expiration. When the total size
+	// of all contents fits in the budget, snapshots are not examined and the total size is reported instead.
+	UsedBytes int64 `json:"usedBytes"`
+
+	// ReclaimedBytes is the estimated storage used only by the expired snapshots, which is reclaimed
+	// by snapshot GC and maintenance after they are expired.
+	ReclaimedBytes int64 `json:"reclaimedBytes"`
+
+	Expired []*snapshot.Manifest `json:"expired"`
+}
+
+// RemainingBytes returns the estimated storage used by snapshots after expiration.
+func (r *Result) RemainingBytes() int64 {
+	return r.UsedBytes - r.ReclaimedBytes
+}
+
+// ExpiredIDs returns IDs of expired snapshots.
+func (r *Result) ExpiredIDs() []manifest.ID {
+	var result []manifest.ID
+
+	for _, m := range r.Expired {
+		result = append(result, m.ID)
+	}
+
+	return result
+}
+
+// Plan determines the oldest snapshots that need to be expired for the storage used by snapshots to fit
+// in the budget, without making any changes to the repository. Snapshots protected by pins, legal holds
+// and anomaly safeguards and the latest complete snapshot of each source are never expired,
+// so the budget may still be exceeded afterwards. Snapshots with IDs in excluded are treated as already deleted.
+func Plan(ctx context.Context, rep *repo.DirectRepository, p maintenance.StorageBudgetParams, excluded map[manifest.ID]bool) (*Result, error) {
+	res := &Result{Budget: p.MaxBytes}
+
+	if p.MaxBytes <= 0 {
+		return res, nil
+	}
+
+	lengths := map[content.ID]int64{}
+
+	if err := rep.Content.IterateContents(ctx, content.IterateOptions{}, func(ci content.Info) error {
+		if ci.ID.Prefix() == manifest.ContentPrefix {
+			return nil
+		}
+
+		lengths[ci.ID] = int64(ci.Length)
+		res.UsedBytes += int64(ci.Length)
+
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "error iterating contents")
+	}
+
+	if res.UsedBytes <= p.MaxBytes {
+		log(ctx).Debugf("contents use %v, within storage budget of %v", units.BytesStringBase10(res.UsedBytes), units.BytesStringBase10(p.MaxBytes))
+		return res, nil
+	}
+
+	snapshots, candidates, err := loadSnapshots(ctx, rep, excluded)
+	if err != nil {
+		return nil, err
+	}
+
+	log(ctx).Infof("contents use %v, which exceeds storage budget of %v, estimating storage used by %v snapshots",
+		units.BytesStringBase10(res.UsedBytes), units.BytesStringBase10(p.MaxBytes), len(snapshots))
+
+	refs, err := countReferences(ctx, rep, snapshots)
+	if err != nil {
+		return nil, err
+	}
+
+	res.UsedBytes = 0
+
+	for cid, n := range refs {
+		if n > 0 {
+			res.UsedBytes += lengths[cid]
+		}
+	}
+
+	for _, m := range candidates {
+		if res.RemainingBytes() <= p.MaxBytes {
+			break
+		}
+
+		used, err := rootContentIDs(ctx, rep, m.RootEntry)
+		if err != nil {
+			return nil, err
+		}
+
+		for cid := range used {
+			refs[cid]--
+
+			if refs[cid] == 0 {
+				res.ReclaimedBytes += lengths[cid]
+			}
+		}
+
+		res.Expired = append(res.Expired, m)
+	}
+
+	if res.RemainingBytes() > p.MaxBytes {
+		log(ctx).Warningf("snapshots use %v after expiring all eligible snapshots, which exceeds storage budget of %v",
+			units.BytesStringBase10(res.RemainingBytes()), units.BytesStringBase10(p.MaxBytes))
+	}
+
+	return res, nil
+}
+
+// Run expires the oldest snapshots as determined by Plan. Storage used only by the expired snapshots
+// is reclaimed by subsequent snapshot GC and maintenance.
+func Run(ctx context.Context, rep *repo.DirectRepository, p maintenance.StorageBudgetParams) (*Result, error) {
+	var res *Result
+
+	err := maintenance.ReportRun(ctx, rep, RunType, func() error {
+		var err error
+
+		res, err = Plan(ctx, rep, p, nil)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range res.Expired {
+			log(ctx).Infof("expiring snapshot %v of %v taken at %v to enforce storage budget", m.ID, m.Source, m.StartTime)
+
+			if err := rep.DeleteManifest(ctx, m.ID); err != nil {
+				return errors.Wrapf(err, "unable to delete snapshot %v", m.ID)
+			}
+		}
+
+		return nil
+	})
+
+	return res, err
+}
+
+// loadSnapshots returns all snapshots except excluded ones and those that may be expired, oldest first.
+func loadSnapshots(ctx context.Context, rep repo.Repository, excluded map[manifest.ID]bool) (all, candidates []*snapshot.Manifest, err error) {
+	allIDs, err := snapshot.ListSnapshotManifests(ctx, rep, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to list snapshots")
+	}
+
+	var ids []manifest.ID
+
+	for _, id := range allIDs {
+		if !excluded[id] {
+			ids = append(ids, id)
+		}
+	}
+
+	all, err = snapshot.LoadSnapshots(ctx, rep, ids)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to load snapshots")
+	}
+
+	for _, sources := range snapshot.GroupBySource(all) {
+		c, err := policy.ExpirationCandidates(ctx, rep, sources)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "unable to determine snapshots of %v that may be expired", sources[0].Source)
+		}
+
+		candidates = append(candidates, c...)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].StartTime.Before(candidates[j].StartTime)
+	})
+
+	return all, candidates, nil
+}
+
+// countReferences returns the number of snapshots referencing each content. Contents of snapshots under
+// legal hold are referenced even if their manifests were removed, since snapshot GC never collects them.
+func countReferences(ctx context.Context, rep repo.Repository, snapshots []*snapshot.Manifest) (map[content.ID]int, error) {
+	refs := map[content.ID]int{}
+
+	var roots []*snapshot.DirEntry
+
+	for _, m := range snapshots {
+		roots = append(roots, m.RootEntry)
+	}
+
+	holds, err := legalhold.List(ctx, rep)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list legal holds")
+	}
+
+	for _, h := range holds {
+		if h.RootEntry != nil {
+			roots = append(roots, h.RootEntry)
+		}
+	}
+
+	for _, de := range roots {
+		used, err := rootContentIDs(ctx, rep, de)
+		if err != nil {
+			return nil, err
+		}
+
+		for cid := range used {
+			refs[cid]++
+		}
+	}
+
+	return refs, nil
+}
+
+// rootContentIDs returns the set of contents referenced by the tree with the provided root.
+func rootContentIDs(ctx context.Context, rep repo.Repository, de *snapshot.DirEntry) (map[content.ID]bool, error) {
+	var (
+		mu   sync.Mutex
+		used = map[content.ID]bool{}
+	)
+
+	if de == nil {
+		return used, nil
+	}
+
+	root, err := snapshotfs.EntryFromDirEntry(rep, de)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get snapshot root")
+	}
+
+	markUsed := func(oid object.ID) error {
+		contentIDs, err := rep.VerifyObject(ctx, oid)
+		if err != nil {
+			return errors.Wrapf(err, "error verifying %v", oid)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, cid := range contentIDs {
+			used[cid] = true
+		}
+
+		return nil
+	}
+
+	w := snapshotfs.NewTreeWalker()
+	w.EntryID = func(e fs.Entry) interface{} { return e.(object.HasObjectID).ObjectID() }
+	w.RootEntries = []fs.Entry{root}
+	w.ObjectCallback = func(entry fs.Entry) error {
+		oid := entry.(object.HasObjectID).ObjectID()
+
+		if err := markUsed(oid); err != nil {
+			return err
+		}
+
+		if _, ok := entry.(fs.Directory); !ok {
+			return nil
+		}
+
+		// directories stored as deltas keep their base directory objects alive.
+		baseIDs, err := snapshotfs.DirectoryBaseObjectIDs(ctx, rep, oid)
+		if err != nil {
+			return errors.Wrapf(err, "error reading base directories of %v", oid)
+		}
+
+		for _, baseID := range baseIDs {
+			if err := markUsed(baseID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := w.Run(ctx); err != nil {
+		return nil, errors.Wrap(err, "error walking snapshot tree")
+	}
+
+	return used, nil
+}
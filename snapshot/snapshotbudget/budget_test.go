@@ -0,0 +1,108 @@
+package snapshotbudget_test
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/maintenance"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotbudget"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+const (
+	defaultPermissions = 0o777
+	fileSize           = 100000
+)
+
+var startTime = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// createSnapshots creates snapshots of a single source, each with a file of unique random contents.
+func createSnapshots(ctx context.Context, t *testing.T, env *repotesting.Environment, count int) []*snapshot.Manifest {
+	t.Helper()
+
+	var result []*snapshot.Manifest
+
+	src := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"}
+
+	for i := 0; i < count; i++ {
+		data := make([]byte, fileSize)
+		rand.Read(data)
+
+		sourceDir := mockfs.NewDirectory()
+		sourceDir.AddFile("f", data, defaultPermissions)
+
+		u := snapshotfs.NewUploader(env.Repository)
+
+		man, err := u.Upload(ctx, sourceDir, policy.BuildTree(nil, policy.DefaultPolicy), src)
+		require.NoError(t, err)
+
+		man.StartTime = startTime.Add(time.Duration(i) * time.Hour)
+
+		_, err = snapshot.SaveSnapshot(ctx, env.Repository, man)
+		require.NoError(t, err)
+
+		result = append(result, man)
+	}
+
+	require.NoError(t, env.Repository.Flush(ctx))
+
+	return result
+}
+
+func TestStorageBudget(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	snaps := createSnapshots(ctx, t, &env, 4)
+
+	// no budget
+	res, err := snapshotbudget.Plan(ctx, env.Repository, maintenance.StorageBudgetParams{}, nil)
+	require.NoError(t, err)
+	require.Empty(t, res.Expired)
+
+	// budget larger than the repository
+	res, err = snapshotbudget.Plan(ctx, env.Repository, maintenance.StorageBudgetParams{MaxBytes: 1e9}, nil)
+	require.NoError(t, err)
+	require.Empty(t, res.Expired)
+	require.Greater(t, res.UsedBytes, int64(4*fileSize))
+
+	// budget which requires expiring a single snapshot
+	res, err = snapshotbudget.Plan(ctx, env.Repository, maintenance.StorageBudgetParams{MaxBytes: 3*fileSize + fileSize/2}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []manifest.ID{snaps[0].ID}, res.ExpiredIDs())
+	require.Greater(t, res.ReclaimedBytes, int64(fileSize))
+
+	// pinned snapshots and the latest snapshot are never expired, even if the budget is exceeded.
+	snaps[1].UpdatePins([]string{"keep"}, nil)
+	require.NoError(t, snapshot.UpdateSnapshot(ctx, env.Repository, snaps[1]))
+
+	res, err = snapshotbudget.Plan(ctx, env.Repository, maintenance.StorageBudgetParams{MaxBytes: 1}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []manifest.ID{snaps[0].ID, snaps[2].ID}, res.ExpiredIDs())
+	require.Greater(t, res.RemainingBytes(), int64(2*fileSize))
+
+	// nothing was deleted by planning.
+	ids, err := snapshot.ListSnapshotManifests(ctx, env.Repository, nil)
+	require.NoError(t, err)
+	require.Len(t, ids, 4)
+
+	res, err = snapshotbudget.Run(ctx, env.Repository, maintenance.StorageBudgetParams{MaxBytes: 1})
+	require.NoError(t, err)
+	require.Len(t, res.Expired, 2)
+
+	ids, err = snapshot.ListSnapshotManifests(ctx, env.Repository, nil)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []manifest.ID{snaps[1].ID, snaps[3].ID}, ids)
+}
@@ -0,0 +1,494 @@
+// Package snapshotcatalog maintains searchable catalogs of files contained in snapshots.
+//
+// A catalog is a flat list of all entries of a single snapshot (path, type, size, modification time and owner)
+// stored as a repository object and referenced by a manifest. Searching catalogs only requires reading
+// a single object per snapshot instead of walking the entire snapshot tree.
+//
+// Optionally, lightweight metadata such as dates photos were taken or document titles can be extracted
+// from contents of files with selected extensions and stored in the catalog. This requires reading
+// the files and is disabled by default.
+package snapshotcatalog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/logging"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/repo/object"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+// ManifestType is the value of the "type" label for catalog manifests.
+const ManifestType = "catalog"
+
+const (
+	snapshotIDLabel = "snapshotID"
+
+	// catalog objects use a dedicated prefix, so they are not mistaken for directories ("k"),
+	// manifests ("m") or indirect objects ("x").
+	objectIDPrefixCatalog = "y"
+)
+
+var log = logging.GetContextLoggerFunc("kopia/snapshotcatalog")
+
+// Entry represents a single file, directory or symlink in a catalog.
+type Entry struct {
+	Path     string             `json:"p"`
+	Type     snapshot.EntryType `json:"t"`
+	FileSize int64              `json:"s,omitempty"`
+	ModTime  time.Time          `json:"m"`
+	UserID   uint32             `json:"u,omitempty"`
+	GroupID  uint32             `json:"g,omitempty"`
+	ObjectID object.ID          `json:"o,omitempty"`
+
+	// Metadata extracted from file contents, see Metadata* constants for keys.
+	Metadata map[string]string `json:"md,omitempty"`
+}
+
+// Manifest describes the catalog of a single snapshot.
+type Manifest struct {
+	ID manifest.ID `json:"-"`
+
+	SnapshotID manifest.ID         `json:"snapshotID"`
+	Source     snapshot.SourceInfo `json:"source"`
+	StartTime  time.Time           `json:"startTime"`
+	ObjectID   object.ID           `json:"objectID"`
+	EntryCount int64               `json:"entryCount"`
+
+	// MetadataExtensions lists extensions of files whose metadata was extracted into the catalog.
+	MetadataExtensions []string `json:"metadataExtensions,omitempty"`
+}
+
+// BuildOptions specifies options for building catalogs.
+type BuildOptions struct {
+	// MetadataExtensions lists extensions of files whose metadata is extracted into the catalog,
+	// as returned by ParseMetadataExtensions().
+	MetadataExtensions []string
+}
+
+// Query specifies the criteria for finding entries in catalogs.
+type Query struct {
+	// Pattern is matched against entry base name using path.Match() syntax. If the pattern contains
+	// a slash, it's matched against the full path of the entry relative to snapshot root instead.
+	// Empty pattern matches all entries.
+	Pattern string
+
+	// ObjectIDs, if not empty, limits the search to entries with one of the provided object IDs.
+	ObjectIDs []object.ID
+
+	// Source, if non-nil, limits the search to snapshots of the provided source.
+	Source *snapshot.SourceInfo
+
+	// Before and After, if non-zero, limit the search to snapshots started in the provided time range.
+	Before time.Time
+	After  time.Time
+
+	// Metadata, if not empty, limits the search to entries with metadata matching all provided patterns,
+	// using path.Match() syntax. For example {"dateTaken":"2019-*"} finds photos taken in 2019.
+	Metadata map[string]string
+}
+
+func labelsForSource(si *snapshot.SourceInfo) map[string]string {
+	m := map[string]string{
+		manifest.TypeLabelKey: ManifestType,
+	}
+
+	if si == nil {
+		return m
+	}
+
+	m["hostname"] = si.Host
+
+	if si.UserName != "" {
+		m["username"] = si.UserName
+	}
+
+	if si.Path != "" {
+		m["path"] = si.Path
+	}
+
+	return m
+}
+
+// Build writes the catalog of the provided snapshot and returns its manifest.
+func Build(ctx context.Context, rep repo.Repository, man *snapshot.Manifest, opt BuildOptions) (*Manifest, error) {
+	if man.ID == "" {
+		return nil, errors.New("snapshot has not been saved")
+	}
+
+	root, err := snapshotfs.SnapshotRoot(rep, man)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get snapshot root")
+	}
+
+	w := rep.NewObjectWriter(ctx, object.WriterOptions{
+		Description: "CATALOG:" + string(man.ID),
+		Prefix:      objectIDPrefixCatalog,
+	})
+	defer w.Close() //nolint:errcheck
+
+	cm := &Manifest{
+		SnapshotID:         man.ID,
+		Source:             man.Source,
+		StartTime:          man.StartTime,
+		MetadataExtensions: opt.MetadataExtensions,
+	}
+
+	cw := &catalogWriter{
+		enc:        json.NewEncoder(w),
+		extensions: map[string]bool{},
+	}
+
+	for _, ext := range opt.MetadataExtensions {
+		cw.extensions[ext] = true
+	}
+
+	if dir, ok := root.(fs.Directory); ok {
+		if err := cw.writeEntries(ctx, dir, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	cm.EntryCount = cw.count
+
+	cm.ObjectID, err = w.Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to write catalog")
+	}
+
+	labels := labelsForSource(&man.Source)
+	labels[snapshotIDLabel] = string(man.ID)
+
+	cm.ID, err = rep.PutManifest(ctx, labels, cm)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to save catalog manifest")
+	}
+
+	return cm, nil
+}
+
+type catalogWriter struct {
+	enc        *json.Encoder
+	extensions map[string]bool // extensions of files to extract metadata from
+	count      int64
+}
+
+func (cw *catalogWriter) writeEntries(ctx context.Context, dir fs.Directory, prefix string) error {
+	return fs.IterateEntries(ctx, dir, func(ctx context.Context, e fs.Entry) error {
+		ce := &Entry{
+			Path:     prefix + e.Name(),
+			FileSize: e.Size(),
+			ModTime:  e.ModTime(),
+			UserID:   e.Owner().UserID,
+			GroupID:  e.Owner().GroupID,
+		}
+
+		if h, ok := e.(object.HasObjectID); ok {
+			ce.ObjectID = h.ObjectID()
+		}
+
+		switch e := e.(type) {
+		case fs.Directory:
+			ce.Type = snapshot.EntryTypeDirectory
+		case fs.Symlink:
+			ce.Type = snapshot.EntryTypeSymlink
+		case fs.File:
+			ce.Type = snapshot.EntryTypeFile
+			ce.Metadata = extractMetadata(ctx, e, cw.extensions)
+		}
+
+		if err := cw.enc.Encode(ce); err != nil {
+			return errors.Wrap(err, "unable to write catalog entry")
+		}
+
+		cw.count++
+
+		if sd, ok := e.(fs.Directory); ok {
+			return cw.writeEntries(ctx, sd, ce.Path+"/")
+		}
+
+		return nil
+	})
+}
+
+// List returns catalogs of snapshots of the provided source or all catalogs if source is nil.
+func List(ctx context.Context, rep repo.Repository, src *snapshot.SourceInfo) ([]*Manifest, error) {
+	entries, err := rep.FindManifests(ctx, labelsForSource(src))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to find catalog manifests")
+	}
+
+	var result []*Manifest
+
+	for _, e := range entries {
+		cm := &Manifest{}
+		if _, err := rep.GetManifest(ctx, e.ID, cm); err != nil {
+			return nil, errors.Wrapf(err, "unable to load catalog manifest %v", e.ID)
+		}
+
+		cm.ID = e.ID
+		result = append(result, cm)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartTime.Before(result[j].StartTime)
+	})
+
+	return result, nil
+}
+
+// BuildMissing builds catalogs for all provided snapshots that don't have one yet or whose catalog
+// does not include metadata of all requested extensions, and returns the number of catalogs that were built.
+func BuildMissing(ctx context.Context, rep repo.Repository, snapshots []*snapshot.Manifest, opt BuildOptions) (int, error) {
+	existing, err := List(ctx, rep, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	catalogs := map[manifest.ID][]*Manifest{}
+	for _, cm := range existing {
+		catalogs[cm.SnapshotID] = append(catalogs[cm.SnapshotID], cm)
+	}
+
+	built := 0
+
+	for _, m := range snapshots {
+		if m.IncompleteReason != "" || hasCatalogWithMetadata(catalogs[m.ID], opt.MetadataExtensions) {
+			continue
+		}
+
+		log(ctx).Debugf("building catalog of snapshot %v of %v", m.ID, m.Source)
+
+		if _, err := Build(ctx, rep, m, opt); err != nil {
+			return built, errors.Wrapf(err, "unable to build catalog of snapshot %v", m.ID)
+		}
+
+		// remove catalogs superseded by the new one.
+		for _, cm := range catalogs[m.ID] {
+			if err := rep.DeleteManifest(ctx, cm.ID); err != nil {
+				return built, errors.Wrapf(err, "unable to delete catalog %v", cm.ID)
+			}
+		}
+
+		built++
+	}
+
+	return built, nil
+}
+
+func hasCatalogWithMetadata(catalogs []*Manifest, extensions []string) bool {
+	for _, cm := range catalogs {
+		have := map[string]bool{}
+		for _, ext := range cm.MetadataExtensions {
+			have[ext] = true
+		}
+
+		missing := false
+
+		for _, ext := range extensions {
+			if !have[ext] {
+				missing = true
+			}
+		}
+
+		if !missing {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Find invokes the provided callback for each catalog entry matching the query.
+// Catalogs of snapshots that no longer exist are skipped.
+func Find(ctx context.Context, rep repo.Repository, q Query, cb func(cm *Manifest, e *Entry) error) error {
+	if _, err := path.Match(q.Pattern, ""); err != nil {
+		return errors.Wrap(err, "invalid pattern")
+	}
+
+	for k, v := range q.Metadata {
+		if _, err := path.Match(v, ""); err != nil {
+			return errors.Wrapf(err, "invalid pattern of metadata %q", k)
+		}
+	}
+
+	catalogs, err := List(ctx, rep, q.Source)
+	if err != nil {
+		return err
+	}
+
+	live, err := liveSnapshotIDs(ctx, rep)
+	if err != nil {
+		return err
+	}
+
+	if err := warnAboutSnapshotsWithoutCatalog(ctx, rep, q.Source, catalogs); err != nil {
+		return err
+	}
+
+	for _, cm := range catalogs {
+		if !live[cm.SnapshotID] {
+			continue
+		}
+
+		if !q.Before.IsZero() && !cm.StartTime.Before(q.Before) {
+			continue
+		}
+
+		if !q.After.IsZero() && !cm.StartTime.After(q.After) {
+			continue
+		}
+
+		if err := findInCatalog(ctx, rep, cm, q, cb); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func warnAboutSnapshotsWithoutCatalog(ctx context.Context, rep repo.Repository, src *snapshot.SourceInfo, catalogs []*Manifest) error {
+	ids, err := snapshot.ListSnapshotManifests(ctx, rep, src)
+	if err != nil {
+		return errors.Wrap(err, "unable to list snapshots")
+	}
+
+	hasCatalog := map[manifest.ID]bool{}
+	for _, cm := range catalogs {
+		hasCatalog[cm.SnapshotID] = true
+	}
+
+	missing := 0
+
+	for _, id := range ids {
+		if !hasCatalog[id] {
+			missing++
+		}
+	}
+
+	if missing > 0 {
+		log(ctx).Warningf("%v snapshots don't have a catalog and were not searched, use 'kopia catalog build' to build them.", missing)
+	}
+
+	return nil
+}
+
+func (q *Query) matches(e *Entry) bool {
+	if len(q.ObjectIDs) > 0 && !containsObjectID(q.ObjectIDs, e.ObjectID) {
+		return false
+	}
+
+	for k, pattern := range q.Metadata {
+		v, ok := e.Metadata[k]
+		if !ok {
+			return false
+		}
+
+		if ok, _ := path.Match(pattern, v); !ok {
+			return false
+		}
+	}
+
+	if q.Pattern == "" {
+		return true
+	}
+
+	name := e.Path
+	if !strings.Contains(q.Pattern, "/") {
+		name = path.Base(name)
+	}
+
+	ok, _ := path.Match(q.Pattern, name)
+
+	return ok
+}
+
+func containsObjectID(oids []object.ID, oid object.ID) bool {
+	for _, o := range oids {
+		if o == oid {
+			return true
+		}
+	}
+
+	return false
+}
+
+func findInCatalog(ctx context.Context, rep repo.Repository, cm *Manifest, q Query, cb func(cm *Manifest, e *Entry) error) error {
+	r, err := rep.OpenObject(ctx, cm.ObjectID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to open catalog %v", cm.ObjectID)
+	}
+	defer r.Close() //nolint:errcheck
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	for {
+		var e Entry
+
+		err := dec.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "unable to read catalog %v", cm.ObjectID)
+		}
+
+		if !q.matches(&e) {
+			continue
+		}
+
+		if err := cb(cm, &e); err != nil {
+			return err
+		}
+	}
+}
+
+func liveSnapshotIDs(ctx context.Context, rep repo.Repository) (map[manifest.ID]bool, error) {
+	ids, err := snapshot.ListSnapshotManifests(ctx, rep, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list snapshots")
+	}
+
+	result := map[manifest.ID]bool{}
+	for _, id := range ids {
+		result[id] = true
+	}
+
+	return result, nil
+}
+
+// ListLiveAndOrphaned returns catalogs of existing snapshots and catalogs whose snapshots no longer exist.
+func ListLiveAndOrphaned(ctx context.Context, rep repo.Repository) (live, orphaned []*Manifest, err error) {
+	catalogs, err := List(ctx, rep, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	liveIDs, err := liveSnapshotIDs(ctx, rep)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, cm := range catalogs {
+		if liveIDs[cm.SnapshotID] {
+			live = append(live, cm)
+		} else {
+			orphaned = append(orphaned, cm)
+		}
+	}
+
+	return live, orphaned, nil
+}
@@ -0,0 +1,254 @@
+package snapshotcatalog_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotcatalog"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+const defaultPermissions = 0777
+
+func TestCatalogFind(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	si := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"}
+
+	sourceDir := mockfs.NewDirectory()
+	sourceDir.AddFile("report1.xlsx", []byte{1, 2, 3}, defaultPermissions)
+	sourceDir.AddFile("notes.txt", []byte{1}, defaultPermissions)
+	d1 := sourceDir.AddDir("d1", defaultPermissions)
+	d1.AddFile("report2.xlsx", []byte{1, 2, 3, 4, 5}, defaultPermissions)
+	d1.AddDir("d2", defaultPermissions).AddFile("report3.xlsx", []byte{1}, defaultPermissions)
+
+	s1 := mustSnapshot(ctx, t, env.Repository, sourceDir, si)
+
+	cm, err := snapshotcatalog.Build(ctx, env.Repository, s1, snapshotcatalog.BuildOptions{})
+	require.NoError(t, err)
+	require.Equal(t, int64(6), cm.EntryCount)
+	require.False(t, snapshotfs.IsDirectoryID(cm.ObjectID), "catalog must not look like a directory")
+
+	require.Equal(t, []string{"d1/d2/report3.xlsx", "d1/report2.xlsx", "report1.xlsx"},
+		mustFind(ctx, t, env.Repository, snapshotcatalog.Query{Pattern: "report*.xlsx"}))
+	require.Equal(t, []string{"d1/report2.xlsx"},
+		mustFind(ctx, t, env.Repository, snapshotcatalog.Query{Pattern: "d1/*.xlsx"}))
+	require.Equal(t, []string{"d1", "d1/d2"},
+		mustFind(ctx, t, env.Repository, snapshotcatalog.Query{Pattern: "d*"}))
+
+	require.Empty(t, mustFind(ctx, t, env.Repository, snapshotcatalog.Query{Pattern: "*.xlsx", Before: s1.StartTime}))
+	require.Empty(t, mustFind(ctx, t, env.Repository, snapshotcatalog.Query{Pattern: "*.xlsx", After: s1.StartTime.Add(time.Second)}))
+	require.Len(t, mustFind(ctx, t, env.Repository, snapshotcatalog.Query{Pattern: "*.xlsx", Before: s1.StartTime.Add(time.Second)}), 3)
+
+	otherSource := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/other"}
+	require.Empty(t, mustFind(ctx, t, env.Repository, snapshotcatalog.Query{Pattern: "*.xlsx", Source: &otherSource}))
+	require.Len(t, mustFind(ctx, t, env.Repository, snapshotcatalog.Query{Pattern: "*.xlsx", Source: &si}), 3)
+
+	// catalog is only built once.
+	built, err := snapshotcatalog.BuildMissing(ctx, env.Repository, []*snapshot.Manifest{s1}, snapshotcatalog.BuildOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 0, built)
+
+	// catalogs of deleted snapshots are ignored and reported as orphaned.
+	require.NoError(t, env.Repository.DeleteManifest(ctx, s1.ID))
+	require.Empty(t, mustFind(ctx, t, env.Repository, snapshotcatalog.Query{Pattern: "*"}))
+
+	live, orphaned, err := snapshotcatalog.ListLiveAndOrphaned(ctx, env.Repository)
+	require.NoError(t, err)
+	require.Empty(t, live)
+	require.Len(t, orphaned, 1)
+}
+
+func TestCatalogInvalidPattern(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	require.Error(t, snapshotcatalog.Find(ctx, env.Repository, snapshotcatalog.Query{Pattern: "["}, nil))
+}
+
+func TestCatalogMetadata(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	si := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"}
+
+	sourceDir := mockfs.NewDirectory()
+	sourceDir.AddFile("IMG_0001.JPG", jpegWithExif("Test Camera", "2019:07:04 10:11:12"), defaultPermissions)
+	sourceDir.AddFile("IMG_0002.jpg", jpegWithExif("Test Camera", "2020:01:02 03:04:05"), defaultPermissions)
+	sourceDir.AddFile("broken.jpg", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0xFF}, defaultPermissions)
+	sourceDir.AddFile("index.html", []byte("<html><head><TITLE>Family &amp; Friends</TITLE></head></html>"), defaultPermissions)
+	sourceDir.AddFile("report.pdf", []byte("%PDF-1.4\n1 0 obj << /Title (Quarterly \\(Q3\\) Budget) >> endobj\n%%EOF"), defaultPermissions)
+	sourceDir.AddFile("plan.docx", docxWithTitle(t, "Project Plan"), defaultPermissions)
+	sourceDir.AddFile("notes.txt", []byte("<title>not extracted</title>"), defaultPermissions)
+
+	s1 := mustSnapshot(ctx, t, env.Repository, sourceDir, si)
+
+	// metadata is not extracted by default.
+	cm, err := snapshotcatalog.Build(ctx, env.Repository, s1, snapshotcatalog.BuildOptions{})
+	require.NoError(t, err)
+	require.Empty(t, mustFind(ctx, t, env.Repository, snapshotcatalog.Query{Metadata: map[string]string{"title": "*"}}))
+
+	_, err = snapshotcatalog.ParseMetadataExtensions([]string{"jpg,exe"})
+	require.Error(t, err)
+
+	exts, err := snapshotcatalog.ParseMetadataExtensions([]string{"JPG,.html", "pdf", "docx"})
+	require.NoError(t, err)
+	require.Equal(t, []string{".docx", ".html", ".jpg", ".pdf"}, exts)
+
+	// rebuilding the catalog with metadata replaces the previous one.
+	built, err := snapshotcatalog.BuildMissing(ctx, env.Repository, []*snapshot.Manifest{s1}, snapshotcatalog.BuildOptions{MetadataExtensions: exts})
+	require.NoError(t, err)
+	require.Equal(t, 1, built)
+
+	catalogs, err := snapshotcatalog.List(ctx, env.Repository, nil)
+	require.NoError(t, err)
+	require.Len(t, catalogs, 1)
+	require.NotEqual(t, cm.ID, catalogs[0].ID)
+	require.Equal(t, exts, catalogs[0].MetadataExtensions)
+
+	built, err = snapshotcatalog.BuildMissing(ctx, env.Repository, []*snapshot.Manifest{s1}, snapshotcatalog.BuildOptions{MetadataExtensions: []string{".jpg"}})
+	require.NoError(t, err)
+	require.Equal(t, 0, built)
+
+	require.Equal(t, []string{"IMG_0001.JPG"},
+		mustFind(ctx, t, env.Repository, snapshotcatalog.Query{Metadata: map[string]string{snapshotcatalog.MetadataDateTaken: "2019-*"}}))
+	require.Equal(t, []string{"IMG_0001.JPG", "IMG_0002.jpg"},
+		mustFind(ctx, t, env.Repository, snapshotcatalog.Query{Metadata: map[string]string{snapshotcatalog.MetadataCameraModel: "Test Camera"}}))
+	require.Equal(t, []string{"IMG_0002.jpg"},
+		mustFind(ctx, t, env.Repository, snapshotcatalog.Query{Pattern: "*.jpg", Metadata: map[string]string{snapshotcatalog.MetadataDateTaken: "2020-01-02T03:04:05"}}))
+
+	titles := map[string]string{}
+
+	require.NoError(t, snapshotcatalog.Find(ctx, env.Repository, snapshotcatalog.Query{Metadata: map[string]string{snapshotcatalog.MetadataTitle: "*"}}, func(cm *snapshotcatalog.Manifest, e *snapshotcatalog.Entry) error {
+		titles[e.Path] = e.Metadata[snapshotcatalog.MetadataTitle]
+		return nil
+	}))
+
+	require.Equal(t, map[string]string{
+		"index.html": "Family & Friends",
+		"report.pdf": "Quarterly (Q3) Budget",
+		"plan.docx":  "Project Plan",
+	}, titles)
+
+	require.Error(t, snapshotcatalog.Find(ctx, env.Repository, snapshotcatalog.Query{Metadata: map[string]string{"title": "["}}, nil))
+}
+
+// jpegWithExif returns a minimal JPEG file with EXIF data containing the provided camera model and date.
+func jpegWithExif(model, date string) []byte {
+	var tiff bytes.Buffer
+
+	model += "\x00"
+	date += "\x00"
+
+	ifd0Offset := uint32(8)
+	exifIFDOffset := ifd0Offset + 2 + 2*12 + 4
+	modelOffset := exifIFDOffset + 2 + 12 + 4
+	dateOffset := modelOffset + uint32(len(model))
+
+	be := binary.BigEndian
+
+	tiff.WriteString("MM")
+	binary.Write(&tiff, be, uint16(42))
+	binary.Write(&tiff, be, ifd0Offset)
+
+	// IFD0 with camera model and pointer to EXIF IFD.
+	binary.Write(&tiff, be, uint16(2))
+	binary.Write(&tiff, be, []uint16{0x0110, 2})
+	binary.Write(&tiff, be, []uint32{uint32(len(model)), modelOffset})
+	binary.Write(&tiff, be, []uint16{0x8769, 4})
+	binary.Write(&tiff, be, []uint32{1, exifIFDOffset})
+	binary.Write(&tiff, be, uint32(0))
+
+	// EXIF IFD with original date.
+	binary.Write(&tiff, be, uint16(1))
+	binary.Write(&tiff, be, []uint16{0x9003, 2})
+	binary.Write(&tiff, be, []uint32{uint32(len(date)), dateOffset})
+	binary.Write(&tiff, be, uint32(0))
+
+	tiff.WriteString(model)
+	tiff.WriteString(date)
+
+	var b bytes.Buffer
+
+	b.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(&b, be, uint16(2+6+tiff.Len()))
+	b.WriteString("Exif\x00\x00")
+	b.Write(tiff.Bytes())
+	b.Write([]byte{0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9})
+
+	return b.Bytes()
+}
+
+// docxWithTitle returns a minimal office document with the provided title.
+func docxWithTitle(t *testing.T, title string) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	zw := zip.NewWriter(&b)
+
+	w, err := zw.Create("docProps/core.xml")
+	require.NoError(t, err)
+
+	_, err = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:title>` + title + `</dc:title>
+</cp:coreProperties>`))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	return b.Bytes()
+}
+
+func mustFind(ctx context.Context, t *testing.T, rep repo.Repository, q snapshotcatalog.Query) []string {
+	t.Helper()
+
+	var result []string
+
+	require.NoError(t, snapshotcatalog.Find(ctx, rep, q, func(cm *snapshotcatalog.Manifest, e *snapshotcatalog.Entry) error {
+		result = append(result, e.Path)
+		return nil
+	}))
+
+	sort.Strings(result)
+
+	return result
+}
+
+func mustSnapshot(ctx context.Context, t *testing.T, rep repo.Repository, source *mockfs.Directory, si snapshot.SourceInfo) *snapshot.Manifest {
+	t.Helper()
+
+	policyTree, err := policy.TreeForSource(ctx, rep, si)
+	require.NoError(t, err)
+
+	man, err := snapshotfs.NewUploader(rep).Upload(ctx, source, policyTree, si)
+	require.NoError(t, err)
+
+	_, err = snapshot.SaveSnapshot(ctx, rep, man)
+	require.NoError(t, err)
+
+	return man
+}
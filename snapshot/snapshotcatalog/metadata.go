@@ -0,0 +1,542 @@
+package snapshotcatalog
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"html"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+)
+
+// Keys of metadata extracted from file contents.
+const (
+	MetadataDateTaken   = "dateTaken"   // date the photo was taken in the form 2006-01-02T15:04:05
+	MetadataCameraModel = "cameraModel" // camera model that took the photo
+	MetadataTitle       = "title"       // title of the document
+)
+
+// AllMetadataExtensions can be passed to ParseMetadataExtensions to select all supported extensions.
+const AllMetadataExtensions = "all"
+
+const (
+	// maximum number of bytes read from the beginning or end of a file when looking for metadata.
+	maxMetadataScanBytes = 64 << 10
+
+	// maximum size of document properties read from office documents.
+	maxOfficePropertiesBytes = 1 << 20
+)
+
+// metadataExtractor returns metadata of the file read by r, which is of the provided size.
+type metadataExtractor func(r io.ReadSeeker, size int64) (map[string]string, error)
+
+var metadataExtractors = map[string]metadataExtractor{
+	".jpg":  exifMetadata,
+	".jpeg": exifMetadata,
+	".htm":  htmlMetadata,
+	".html": htmlMetadata,
+	".pdf":  pdfMetadata,
+	".docx": officeMetadata,
+	".xlsx": officeMetadata,
+	".pptx": officeMetadata,
+}
+
+// SupportedMetadataExtensions returns the sorted list of file extensions metadata can be extracted from.
+func SupportedMetadataExtensions() []string {
+	var result []string
+
+	for ext := range metadataExtractors {
+		result = append(result, ext)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// ParseMetadataExtensions normalizes the provided list of extensions (which may be comma-separated
+// and omit the leading dot) and verifies that metadata can be extracted from all of them.
+func ParseMetadataExtensions(exts []string) ([]string, error) {
+	seen := map[string]bool{}
+
+	var result []string
+
+	for _, e := range exts {
+		for _, ext := range strings.Split(e, ",") {
+			ext = strings.ToLower(strings.TrimSpace(ext))
+
+			switch {
+			case ext == "":
+				continue
+
+			case ext == AllMetadataExtensions:
+				return SupportedMetadataExtensions(), nil
+
+			case !strings.HasPrefix(ext, "."):
+				ext = "." + ext
+			}
+
+			if metadataExtractors[ext] == nil {
+				return nil, errors.Errorf("extracting metadata of %q files is not supported, supported extensions are: %v", ext, strings.Join(SupportedMetadataExtensions(), ", "))
+			}
+
+			if !seen[ext] {
+				seen[ext] = true
+				result = append(result, ext)
+			}
+		}
+	}
+
+	sort.Strings(result)
+
+	return result, nil
+}
+
+func extractMetadata(ctx context.Context, f fs.File, extensions map[string]bool) map[string]string {
+	ext := strings.ToLower(path.Ext(f.Name()))
+	if !extensions[ext] {
+		return nil
+	}
+
+	r, err := f.Open(ctx)
+	if err != nil {
+		log(ctx).Debugf("unable to open %v to extract metadata: %v", f.Name(), err)
+		return nil
+	}
+	defer r.Close() //nolint:errcheck
+
+	md, err := metadataExtractors[ext](r, f.Size())
+	if err != nil {
+		// files with unexpected contents are not an error, they just have no metadata.
+		log(ctx).Debugf("unable to extract metadata of %v: %v", f.Name(), err)
+		return nil
+	}
+
+	if len(md) == 0 {
+		return nil
+	}
+
+	return md
+}
+
+// exifMetadata extracts the date and camera model from EXIF data of JPEG files.
+func exifMetadata(r io.ReadSeeker, _ int64) (map[string]string, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxMetadataScanBytes))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read file")
+	}
+
+	tiff, err := findExifSegment(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseExif(tiff)
+}
+
+// findExifSegment returns the TIFF structure of the EXIF segment of the JPEG file.
+func findExifSegment(b []byte) ([]byte, error) {
+	const (
+		markerSOS  = 0xDA
+		markerAPP1 = 0xE1
+	)
+
+	if len(b) < 4 || b[0] != 0xFF || b[1] != 0xD8 {
+		return nil, errors.New("not a JPEG file")
+	}
+
+	b = b[2:]
+
+	for len(b) >= 4 && b[0] == 0xFF {
+		marker := b[1]
+		length := int(binary.BigEndian.Uint16(b[2:]))
+
+		if marker == markerSOS || length < 2 || len(b) < 2+length {
+			break
+		}
+
+		segment := b[4 : 2+length]
+		if marker == markerAPP1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+
+		b = b[2+length:]
+	}
+
+	return nil, errors.New("no EXIF data")
+}
+
+func parseExif(tiff []byte) (map[string]string, error) {
+	const (
+		tagModel            = 0x0110
+		tagDateTime         = 0x0132
+		tagExifIFD          = 0x8769
+		tagDateTimeOriginal = 0x9003
+	)
+
+	if len(tiff) < 8 {
+		return nil, errors.New("invalid EXIF header")
+	}
+
+	var bo binary.ByteOrder
+
+	switch string(tiff[0:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return nil, errors.New("invalid EXIF byte order")
+	}
+
+	ifd0, err := readIFD(tiff, bo, bo.Uint32(tiff[4:]))
+	if err != nil {
+		return nil, err
+	}
+
+	md := map[string]string{}
+
+	if v := ifd0.ascii(tagModel); v != "" {
+		md[MetadataCameraModel] = v
+	}
+
+	date := ifd0.ascii(tagDateTime)
+
+	if off, ok := ifd0.long(tagExifIFD); ok {
+		if exif, err := readIFD(tiff, bo, off); err == nil {
+			if v := exif.ascii(tagDateTimeOriginal); v != "" {
+				date = v
+			}
+		}
+	}
+
+	// EXIF dates are in the form "2006:01:02 15:04:05".
+	if len(date) == len("2006:01:02 15:04:05") {
+		md[MetadataDateTaken] = strings.Replace(strings.Replace(date, ":", "-", 2), " ", "T", 1) //nolint:gomnd
+	}
+
+	return md, nil
+}
+
+// ifd is a parsed TIFF image file directory.
+type ifd struct {
+	tiff    []byte
+	bo      binary.ByteOrder
+	entries map[uint16][]byte // tag => 12-byte directory entry
+}
+
+func readIFD(tiff []byte, bo binary.ByteOrder, offset uint32) (*ifd, error) {
+	const entrySize = 12
+
+	if int64(offset)+2 > int64(len(tiff)) {
+		return nil, errors.New("invalid IFD offset")
+	}
+
+	n := int(bo.Uint16(tiff[offset:]))
+	start := int(offset) + 2 //nolint:gomnd
+
+	if start+n*entrySize > len(tiff) {
+		return nil, errors.New("truncated IFD")
+	}
+
+	d := &ifd{tiff, bo, map[uint16][]byte{}}
+
+	for i := 0; i < n; i++ {
+		e := tiff[start+i*entrySize : start+(i+1)*entrySize]
+		d.entries[bo.Uint16(e)] = e
+	}
+
+	return d, nil
+}
+
+func (d *ifd) ascii(tag uint16) string {
+	const typeASCII = 2
+
+	e := d.entries[tag]
+	if e == nil || d.bo.Uint16(e[2:]) != typeASCII {
+		return ""
+	}
+
+	count := d.bo.Uint32(e[4:])
+	v := e[8:12]
+
+	if count > 4 { //nolint:gomnd
+		off := d.bo.Uint32(e[8:])
+		if int64(off)+int64(count) > int64(len(d.tiff)) {
+			return ""
+		}
+
+		v = d.tiff[off : off+count]
+	} else {
+		v = v[:count]
+	}
+
+	return strings.TrimSpace(strings.TrimRight(string(v), "\x00"))
+}
+
+func (d *ifd) long(tag uint16) (uint32, bool) {
+	const typeLong = 4
+
+	e := d.entries[tag]
+	if e == nil || d.bo.Uint16(e[2:]) != typeLong {
+		return 0, false
+	}
+
+	return d.bo.Uint32(e[8:]), true
+}
+
+// htmlMetadata extracts the title of HTML documents.
+func htmlMetadata(r io.ReadSeeker, _ int64) (map[string]string, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxMetadataScanBytes))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read file")
+	}
+
+	lower := bytes.ToLower(b)
+
+	start := bytes.Index(lower, []byte("<title"))
+	if start < 0 {
+		return nil, nil
+	}
+
+	start += bytes.IndexByte(lower[start:], '>') + 1
+
+	end := bytes.Index(lower[start:], []byte("</title>"))
+	if end < 0 {
+		return nil, nil
+	}
+
+	return titleMetadata(html.UnescapeString(string(b[start : start+end]))), nil
+}
+
+// pdfMetadata extracts the title from the document information dictionary of PDF files,
+// which is usually found near the beginning or the end of the file.
+func pdfMetadata(r io.ReadSeeker, size int64) (map[string]string, error) {
+	head, err := ioutil.ReadAll(io.LimitReader(r, maxMetadataScanBytes))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read file")
+	}
+
+	if !bytes.HasPrefix(head, []byte("%PDF-")) {
+		return nil, errors.New("not a PDF file")
+	}
+
+	if t, ok := pdfTitle(head); ok {
+		return titleMetadata(t), nil
+	}
+
+	if size <= maxMetadataScanBytes {
+		return nil, nil
+	}
+
+	if _, err := r.Seek(size-maxMetadataScanBytes, io.SeekStart); err != nil {
+		return nil, errors.Wrap(err, "unable to seek")
+	}
+
+	tail, err := ioutil.ReadAll(io.LimitReader(r, maxMetadataScanBytes))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read file")
+	}
+
+	if t, ok := pdfTitle(tail); ok {
+		return titleMetadata(t), nil
+	}
+
+	return nil, nil
+}
+
+func pdfTitle(b []byte) (string, bool) {
+	p := bytes.LastIndex(b, []byte("/Title"))
+	if p < 0 {
+		return "", false
+	}
+
+	s := bytes.TrimLeft(b[p+len("/Title"):], " \t\r\n")
+
+	switch {
+	case bytes.HasPrefix(s, []byte("(")):
+		return decodePDFText(pdfLiteralString(s[1:])), true
+
+	case bytes.HasPrefix(s, []byte("<")):
+		end := bytes.IndexByte(s, '>')
+		if end < 0 {
+			return "", false
+		}
+
+		return decodePDFText(pdfHexString(s[1:end])), true
+
+	default:
+		return "", false
+	}
+}
+
+// pdfLiteralString decodes the PDF literal string, whose opening parenthesis has been consumed.
+func pdfLiteralString(s []byte) []byte {
+	var result []byte
+
+	depth := 0
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+
+			switch s[i] {
+			case 'n':
+				result = append(result, '\n')
+			case 'r':
+				result = append(result, '\r')
+			case 't':
+				result = append(result, '\t')
+			default:
+				result = append(result, s[i])
+			}
+
+		case c == '(':
+			depth++
+
+			result = append(result, c)
+
+		case c == ')':
+			if depth == 0 {
+				return result
+			}
+
+			depth--
+
+			result = append(result, c)
+
+		default:
+			result = append(result, c)
+		}
+	}
+
+	return result
+}
+
+func pdfHexString(s []byte) []byte {
+	var digits []byte
+
+	for _, c := range s {
+		if v, ok := hexDigit(c); ok {
+			digits = append(digits, v)
+		}
+	}
+
+	if len(digits)%2 == 1 {
+		digits = append(digits, 0)
+	}
+
+	result := make([]byte, len(digits)/2) //nolint:gomnd
+	for i := range result {
+		result[i] = digits[2*i]<<4 | digits[2*i+1]
+	}
+
+	return result
+}
+
+func hexDigit(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true //nolint:gomnd
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true //nolint:gomnd
+	default:
+		return 0, false
+	}
+}
+
+// decodePDFText decodes PDF text string, which is either UTF-16BE with byte order mark or
+// PDFDocEncoding, which is treated as Latin-1.
+func decodePDFText(b []byte) string {
+	if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+		var u []uint16
+
+		for i := 2; i+1 < len(b); i += 2 {
+			u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+
+		return string(utf16.Decode(u))
+	}
+
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+
+	return string(r)
+}
+
+// officeMetadata extracts the title from document properties of Office Open XML documents.
+func officeMetadata(r io.ReadSeeker, size int64) (map[string]string, error) {
+	zr, err := zip.NewReader(&seekingReaderAt{r}, size)
+	if err != nil {
+		return nil, errors.Wrap(err, "not an office document")
+	}
+
+	for _, f := range zr.File {
+		if f.Name != "docProps/core.xml" {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to open document properties")
+		}
+
+		defer rc.Close() //nolint:errcheck
+
+		var props struct {
+			Title string `xml:"title"`
+		}
+
+		if err := xml.NewDecoder(io.LimitReader(rc, maxOfficePropertiesBytes)).Decode(&props); err != nil {
+			return nil, errors.Wrap(err, "invalid document properties")
+		}
+
+		return titleMetadata(props.Title), nil
+	}
+
+	return nil, nil
+}
+
+// seekingReaderAt implements io.ReaderAt on top of io.ReadSeeker. It's not safe for concurrent use.
+type seekingReaderAt struct {
+	r io.ReadSeeker
+}
+
+func (s *seekingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := s.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(s.r, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func titleMetadata(title string) map[string]string {
+	title = strings.Join(strings.Fields(title), " ")
+	if title == "" {
+		return nil
+	}
+
+	return map[string]string{MetadataTitle: title}
+}
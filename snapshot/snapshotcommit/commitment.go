@@ -0,0 +1,307 @@
+// Package snapshotcommit computes compact cryptographic commitments to snapshots.
+//
+// A commitment identifies the repository, the snapshot manifest and all contents referenced by the snapshot
+// and is summarized by a single digest. The digest can be anchored in an external transparency log
+// when the snapshot is created, which allows auditors to later prove that the snapshot existed at that time
+// and has not been modified since, by verifying the commitment against the repository.
+package snapshotcommit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/repo/object"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+// Version is the version of the commitment format, which is incremented whenever the way
+// commitments are computed changes.
+const Version = 1
+
+// ErrMismatch is returned when a commitment does not match the repository.
+var ErrMismatch = errors.New("commitment does not match")
+
+// Commitment is a compact cryptographic commitment to a snapshot.
+type Commitment struct {
+	Version      int                 `json:"version"`
+	RepositoryID string              `json:"repositoryID"`
+	SnapshotID   manifest.ID         `json:"snapshotID"`
+	Source       snapshot.SourceInfo `json:"source"`
+	StartTime    time.Time           `json:"startTime"`
+	EndTime      time.Time           `json:"endTime"`
+
+	// ManifestHash is SHA-256 of the snapshot manifest as stored in the repository.
+	ManifestHash string `json:"manifestHash"`
+
+	// RootObjectID is the root of the hash tree of snapshot contents.
+	RootObjectID object.ID `json:"rootObjectID"`
+
+	// ContentCount and IndexChecksum describe all contents referenced by the snapshot. The checksum is
+	// SHA-256 of index entries (content ID and stored length) of those contents, sorted by content ID.
+	ContentCount  int    `json:"contentCount"`
+	IndexChecksum string `json:"indexChecksum"`
+
+	// Digest is SHA-256 of all other fields, which is suitable for anchoring in a transparency log.
+	Digest string `json:"digest"`
+}
+
+func (c *Commitment) computeDigest() (string, error) {
+	c2 := *c
+	c2.Digest = ""
+
+	b, err := json.Marshal(c2)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to marshal commitment")
+	}
+
+	h := sha256.Sum256(b)
+
+	return hex.EncodeToString(h[:]), nil
+}
+
+// Compute computes the commitment to the provided snapshot.
+func Compute(ctx context.Context, rep *repo.DirectRepository, snapshotID manifest.ID) (*Commitment, error) {
+	return compute(ctx, rep, snapshotID, nil)
+}
+
+// maxCachedSummaries is the maximum number of content summaries remembered by Cache.
+const maxCachedSummaries = 1000
+
+// contentSummary describes all contents referenced by a snapshot tree.
+type contentSummary struct {
+	count    int
+	checksum string
+}
+
+// Cache computes commitments remembering summaries of contents referenced by snapshot trees by their
+// root object IDs, so that repeated commitments to snapshots with the same root don't walk the tree again.
+type Cache struct {
+	mu        sync.Mutex
+	summaries map[object.ID]contentSummary
+}
+
+// NewCache returns a new Cache.
+func NewCache() *Cache {
+	return &Cache{summaries: map[object.ID]contentSummary{}}
+}
+
+// Compute computes the commitment to the provided snapshot, using the cached summary of its contents if any.
+func (c *Cache) Compute(ctx context.Context, rep *repo.DirectRepository, snapshotID manifest.ID) (*Commitment, error) {
+	return compute(ctx, rep, snapshotID, c)
+}
+
+func (c *Cache) get(oid object.ID) (contentSummary, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cs, ok := c.summaries[oid]
+
+	return cs, ok
+}
+
+func (c *Cache) add(oid object.ID, cs contentSummary) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.summaries) >= maxCachedSummaries {
+		c.summaries = map[object.ID]contentSummary{}
+	}
+
+	c.summaries[oid] = cs
+}
+
+func compute(ctx context.Context, rep *repo.DirectRepository, snapshotID manifest.ID, cache *Cache) (*Commitment, error) {
+	man, err := snapshot.LoadSnapshot(ctx, rep, snapshotID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load snapshot %v", snapshotID)
+	}
+
+	var payload json.RawMessage
+
+	if _, err := rep.GetManifest(ctx, snapshotID, &payload); err != nil {
+		return nil, errors.Wrapf(err, "unable to get manifest %v", snapshotID)
+	}
+
+	if man.RootEntry == nil {
+		return nil, errors.Errorf("snapshot %v has no root", snapshotID)
+	}
+
+	manifestHash := sha256.Sum256(payload)
+
+	c := &Commitment{
+		Version:      Version,
+		RepositoryID: hex.EncodeToString(rep.UniqueID),
+		SnapshotID:   snapshotID,
+		Source:       man.Source,
+		StartTime:    man.StartTime.UTC(),
+		EndTime:      man.EndTime.UTC(),
+		ManifestHash: hex.EncodeToString(manifestHash[:]),
+		RootObjectID: man.RootObjectID(),
+	}
+
+	cs, ok := contentSummary{}, false
+	if cache != nil {
+		cs, ok = cache.get(c.RootObjectID)
+	}
+
+	if !ok {
+		if cs, err = summarizeContents(ctx, rep, man); err != nil {
+			return nil, err
+		}
+
+		if cache != nil {
+			cache.add(c.RootObjectID, cs)
+		}
+	}
+
+	c.ContentCount = cs.count
+	c.IndexChecksum = cs.checksum
+
+	if c.Digest, err = c.computeDigest(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func summarizeContents(ctx context.Context, rep *repo.DirectRepository, man *snapshot.Manifest) (contentSummary, error) {
+	contentIDs, err := snapshotContentIDs(ctx, rep, man)
+	if err != nil {
+		return contentSummary{}, err
+	}
+
+	h := sha256.New()
+
+	for _, cid := range contentIDs {
+		ci, err := rep.Content.ContentInfo(ctx, cid)
+		if err != nil {
+			return contentSummary{}, errors.Wrapf(err, "unable to get info of content %v", cid)
+		}
+
+		fmt.Fprintf(h, "%v:%v\n", ci.ID, ci.Length)
+	}
+
+	return contentSummary{len(contentIDs), hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// snapshotContentIDs returns sorted IDs of all contents referenced by the snapshot.
+func snapshotContentIDs(ctx context.Context, rep *repo.DirectRepository, man *snapshot.Manifest) ([]content.ID, error) {
+	root, err := snapshotfs.SnapshotRoot(rep, man)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get snapshot root")
+	}
+
+	var (
+		mu   sync.Mutex
+		used = map[content.ID]bool{}
+	)
+
+	addObject := func(oid object.ID) error {
+		contentIDs, err := rep.VerifyObject(ctx, oid)
+		if err != nil {
+			return errors.Wrapf(err, "error verifying %v", oid)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, cid := range contentIDs {
+			used[cid] = true
+		}
+
+		return nil
+	}
+
+	w := snapshotfs.NewTreeWalker()
+	w.RootEntries = []fs.Entry{root}
+	w.EntryID = func(e fs.Entry) interface{} { return e.(object.HasObjectID).ObjectID() }
+	w.ObjectCallback = func(entry fs.Entry) error {
+		oid := entry.(object.HasObjectID).ObjectID()
+
+		if err := addObject(oid); err != nil {
+			return err
+		}
+
+		if _, ok := entry.(fs.Directory); !ok {
+			return nil
+		}
+
+		// directories stored as deltas depend on their base directory objects.
+		baseIDs, err := snapshotfs.DirectoryBaseObjectIDs(ctx, rep, oid)
+		if err != nil {
+			return errors.Wrapf(err, "error reading base directories of %v", oid)
+		}
+
+		for _, baseID := range baseIDs {
+			if err := addObject(baseID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := w.Run(ctx); err != nil {
+		return nil, errors.Wrap(err, "error walking snapshot tree")
+	}
+
+	var result []content.ID
+	for cid := range used {
+		result = append(result, cid)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i] < result[j]
+	})
+
+	return result, nil
+}
+
+// Verify ensures that the provided commitment is internally consistent and matches the repository.
+func Verify(ctx context.Context, rep *repo.DirectRepository, c *Commitment) error {
+	if c.Version != Version {
+		return errors.Errorf("unsupported commitment version %v", c.Version)
+	}
+
+	digest, err := c.computeDigest()
+	if err != nil {
+		return err
+	}
+
+	if digest != c.Digest {
+		return errors.Wrap(ErrMismatch, "digest is invalid, commitment was modified")
+	}
+
+	actual, err := Compute(ctx, rep, c.SnapshotID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case actual.RepositoryID != c.RepositoryID:
+		return errors.Wrapf(ErrMismatch, "repository ID is %v, expected %v", actual.RepositoryID, c.RepositoryID)
+	case actual.ManifestHash != c.ManifestHash:
+		return errors.Wrap(ErrMismatch, "snapshot manifest was modified")
+	case actual.RootObjectID != c.RootObjectID:
+		return errors.Wrapf(ErrMismatch, "root object is %v, expected %v", actual.RootObjectID, c.RootObjectID)
+	case actual.ContentCount != c.ContentCount || actual.IndexChecksum != c.IndexChecksum:
+		return errors.Wrap(ErrMismatch, "snapshot contents were modified")
+	case actual.Digest != c.Digest:
+		return errors.Wrap(ErrMismatch, "snapshot was modified")
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package snapshotcommit_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotcommit"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+const defaultPermissions = 0777
+
+func TestCommitment(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	si := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"}
+
+	sourceDir := mockfs.NewDirectory()
+	sourceDir.AddFile("f1", []byte{1, 2, 3}, defaultPermissions)
+	sourceDir.AddDir("d1", defaultPermissions).AddFile("f2", []byte{4, 5, 6, 7}, defaultPermissions)
+
+	s1 := mustSnapshot(ctx, t, env.Repository, sourceDir, si)
+
+	c, err := snapshotcommit.Compute(ctx, env.Repository, s1.ID)
+	require.NoError(t, err)
+	require.Equal(t, s1.RootObjectID(), c.RootObjectID)
+	require.Equal(t, 4, c.ContentCount) // 2 directories and 2 files
+	require.Len(t, c.Digest, 64)
+
+	// commitments are deterministic and survive JSON round trip.
+	c2, err := snapshotcommit.Compute(ctx, env.Repository, s1.ID)
+	require.NoError(t, err)
+	require.Equal(t, c, c2)
+
+	b, err := json.Marshal(c)
+	require.NoError(t, err)
+
+	var c3 snapshotcommit.Commitment
+
+	require.NoError(t, json.Unmarshal(b, &c3))
+	require.NoError(t, snapshotcommit.Verify(ctx, env.Repository, &c3))
+
+	// tampering with the commitment is detected.
+	c3.RootObjectID = "k1234"
+	require.True(t, errors.Is(snapshotcommit.Verify(ctx, env.Repository, &c3), snapshotcommit.ErrMismatch))
+
+	// commitment of another snapshot doesn't match.
+	sourceDir.AddFile("f3", []byte{8}, defaultPermissions)
+
+	s2 := mustSnapshot(ctx, t, env.Repository, sourceDir, si)
+
+	c4, err := snapshotcommit.Compute(ctx, env.Repository, s2.ID)
+	require.NoError(t, err)
+	require.NotEqual(t, c.Digest, c4.Digest)
+	require.NotEqual(t, c.IndexChecksum, c4.IndexChecksum)
+
+	// missing contents are detected.
+	require.NoError(t, env.Repository.Content.DeleteContent(ctx, content.ID(s1.RootObjectID())))
+	require.Error(t, snapshotcommit.Verify(ctx, env.Repository, c))
+
+	_, err = snapshotcommit.Compute(ctx, env.Repository, "no-such-snapshot")
+	require.True(t, errors.Is(err, snapshot.ErrSnapshotNotFound))
+}
+
+func TestCommitmentCache(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	si := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"}
+
+	sourceDir := mockfs.NewDirectory()
+	sourceDir.AddFile("f1", []byte{1, 2, 3}, defaultPermissions)
+
+	s1 := mustSnapshot(ctx, t, env.Repository, sourceDir, si)
+	s2 := mustSnapshot(ctx, t, env.Repository, sourceDir, si)
+	require.Equal(t, s1.RootObjectID(), s2.RootObjectID())
+
+	cache := snapshotcommit.NewCache()
+
+	c1, err := cache.Compute(ctx, env.Repository, s1.ID)
+	require.NoError(t, err)
+
+	want, err := snapshotcommit.Compute(ctx, env.Repository, s1.ID)
+	require.NoError(t, err)
+	require.Equal(t, want, c1)
+
+	// summary of contents of the shared root is not computed again, which would fail.
+	require.NoError(t, env.Repository.Content.DeleteContent(ctx, content.ID(s1.RootObjectID())))
+
+	_, err = snapshotcommit.Compute(ctx, env.Repository, s2.ID)
+	require.Error(t, err)
+
+	c2, err := cache.Compute(ctx, env.Repository, s2.ID)
+	require.NoError(t, err)
+	require.Equal(t, s2.ID, c2.SnapshotID)
+	require.Equal(t, c1.IndexChecksum, c2.IndexChecksum)
+	require.NotEqual(t, c1.Digest, c2.Digest)
+}
+
+func mustSnapshot(ctx context.Context, t *testing.T, rep repo.Repository, source *mockfs.Directory, si snapshot.SourceInfo) *snapshot.Manifest {
+	t.Helper()
+
+	policyTree, err := policy.TreeForSource(ctx, rep, si)
+	require.NoError(t, err)
+
+	man, err := snapshotfs.NewUploader(rep).Upload(ctx, source, policyTree, si)
+	require.NoError(t, err)
+
+	_, err = snapshot.SaveSnapshot(ctx, rep, man)
+	require.NoError(t, err)
+
+	return man
+}
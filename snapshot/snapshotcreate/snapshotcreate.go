@@ -0,0 +1,239 @@
+// Package snapshotcreate implements uploading snapshots of local sources according to their policies,
+// which is shared by the command line and programs embedding kopia.
+package snapshotcreate
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/fs/localfs"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/logging"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/apprecipe"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+	"github.com/kopia/kopia/snapshot/snapshothealth"
+	"github.com/kopia/kopia/snapshot/uploadhints"
+)
+
+var log = logging.GetContextLoggerFunc("kopia/snapshotcreate")
+
+// Options specifies options for uploading a snapshot.
+type Options struct {
+	// Description is the free-form description of the snapshot.
+	Description string
+
+	// StartTime and EndTime override the timestamps of the snapshot, when only one of them is provided,
+	// the other one is adjusted to preserve the duration of the snapshot.
+	StartTime time.Time
+	EndTime   time.Time
+
+	// Entry replaces the local filesystem entry of the source, such as a file read from standard input.
+	Entry fs.Entry
+
+	// LocalEntry returns the local filesystem entry of the source, defaults to localfs.NewEntry().
+	LocalEntry func(ctx context.Context, path string) (fs.Entry, error)
+}
+
+// Pending is a snapshot that has been uploaded, but whose manifest has not been saved yet.
+type Pending struct {
+	Manifest *snapshot.Manifest
+
+	// HealthReport contains anomalies detected in the snapshot, nil if detection is disabled by the policy.
+	HealthReport *snapshothealth.Report
+}
+
+// Upload uploads the source according to its effective policy, which includes applications dumped by recipes,
+// upload hints and detection of anomalies, and returns the snapshot whose manifest has not been saved yet.
+func Upload(ctx context.Context, rep repo.Repository, u *snapshotfs.Uploader, sourceInfo snapshot.SourceInfo, opt Options) (*Pending, error) {
+	previous, err := FindPreviousManifests(ctx, rep, sourceInfo, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	policyTree, err := policy.TreeForSource(ctx, rep, sourceInfo)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get policy tree")
+	}
+
+	sess, err := apprecipe.StartForPolicy(ctx, policyTree.EffectivePolicy())
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to prepare application for snapshot")
+	}
+
+	var localEntry fs.Entry
+
+	switch {
+	case sess != nil:
+		defer sess.Finish(ctx)
+
+		localEntry = sess.Root(filepath.Base(sourceInfo.Path))
+
+	case opt.Entry != nil:
+		localEntry = opt.Entry
+
+	case opt.LocalEntry != nil:
+		localEntry, err = opt.LocalEntry(ctx, sourceInfo.Path)
+
+	default:
+		localEntry, err = localfs.NewEntry(sourceInfo.Path)
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get local filesystem entry")
+	}
+
+	hints, err := uploadhints.Open(ctx, rep, sourceInfo, policyTree.EffectivePolicy())
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load upload hints")
+	}
+
+	u.Hints = hints
+	defer func() { u.Hints = nil }()
+
+	log(ctx).Debugf("uploading %v using %v previous manifests", sourceInfo, len(previous))
+
+	man, err := u.Upload(ctx, localEntry, policyTree, sourceInfo, previous...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := hints.Save(ctx, rep, man); err != nil {
+		log(ctx).Warningf("unable to save upload hints of %v: %v", sourceInfo, err)
+	}
+
+	if sess != nil {
+		if err := sess.Err(); err != nil {
+			return nil, errors.Wrap(err, "application dump failed")
+		}
+
+		man.Application = sess.Info()
+	}
+
+	man.Description = opt.Description
+	overrideTimes(man, opt.StartTime, opt.EndTime)
+
+	healthReport, err := DetectAnomalies(ctx, rep, man, policyTree.EffectivePolicy())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pending{man, healthReport}, nil
+}
+
+func overrideTimes(man *snapshot.Manifest, startTime, endTime time.Time) {
+	if !startTime.IsZero() {
+		if endTime.IsZero() {
+			// Calculate the correct end time based on current duration if they're not specified
+			duration := man.EndTime.Sub(man.StartTime)
+			man.EndTime = startTime.Add(duration)
+		}
+
+		man.StartTime = startTime
+	}
+
+	if !endTime.IsZero() {
+		if startTime.IsZero() {
+			inverseDuration := man.StartTime.Sub(man.EndTime)
+			man.StartTime = endTime.Add(inverseDuration)
+		}
+
+		man.EndTime = endTime
+	}
+}
+
+// Save saves the manifest of the pending snapshot and applies the retention policy of its source.
+// The caller is responsible for flushing the repository.
+func Save(ctx context.Context, rep repo.Repository, p *Pending) (manifest.ID, error) {
+	snapID, err := snapshot.SaveSnapshot(ctx, rep, p.Manifest)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot save manifest")
+	}
+
+	if err := ApplyRetentionPolicy(ctx, rep, p.Manifest.Source); err != nil {
+		return "", err
+	}
+
+	return snapID, nil
+}
+
+// ApplyRetentionPolicy deletes snapshots of the source that are no longer retained by its policy.
+func ApplyRetentionPolicy(ctx context.Context, rep repo.Repository, sourceInfo snapshot.SourceInfo) error {
+	if _, err := policy.ApplyRetentionPolicy(ctx, rep, sourceInfo, true); err != nil {
+		return errors.Wrap(err, "unable to apply retention policy")
+	}
+
+	return nil
+}
+
+// DetectAnomalies records anomalies of the provided snapshot, which has not been saved yet, in its manifest.
+func DetectAnomalies(ctx context.Context, rep repo.Repository, man *snapshot.Manifest, pol *policy.Policy) (*snapshothealth.Report, error) {
+	if !pol.AnomalyPolicy.DetectOrDefault(false) || man.IncompleteReason != "" {
+		return nil, nil
+	}
+
+	r, err := snapshothealth.DetectAnomalies(ctx, rep, man, pol.AnomalyPolicy.DetectionOptions())
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to detect anomalies")
+	}
+
+	for _, a := range r.Anomalies {
+		log(ctx).Warningf("Anomaly detected in %v: %v", man.Source, a.Description)
+	}
+
+	if len(r.Anomalies) > 0 && pol.AnomalyPolicy.PreventExpirationOrDefault(false) {
+		log(ctx).Warningf("Previous snapshots of %v will not expire until the anomalies are acknowledged using 'kopia snapshot health acknowledge'.", man.Source)
+	}
+
+	return r, nil
+}
+
+// FindPreviousManifests returns the list of previous snapshots for a given source, including
+// last complete snapshot and possibly some number of incomplete snapshots following it.
+func FindPreviousManifests(ctx context.Context, rep repo.Repository, sourceInfo snapshot.SourceInfo, noLaterThan *time.Time) ([]*snapshot.Manifest, error) {
+	man, err := snapshot.ListSnapshots(ctx, rep, sourceInfo)
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing previous snapshots")
+	}
+
+	// phase 1 - find latest complete snapshot.
+	var previousComplete *snapshot.Manifest
+
+	var previousCompleteStartTime time.Time
+
+	var result []*snapshot.Manifest
+
+	for _, p := range man {
+		if noLaterThan != nil && p.StartTime.After(*noLaterThan) {
+			continue
+		}
+
+		if p.IncompleteReason == "" && (previousComplete == nil || p.StartTime.After(previousComplete.StartTime)) {
+			previousComplete = p
+			previousCompleteStartTime = p.StartTime
+		}
+	}
+
+	if previousComplete != nil {
+		result = append(result, previousComplete)
+	}
+
+	// add all incomplete snapshots after that
+	for _, p := range man {
+		if noLaterThan != nil && p.StartTime.After(*noLaterThan) {
+			continue
+		}
+
+		if p.IncompleteReason != "" && p.StartTime.After(previousCompleteStartTime) {
+			result = append(result, p)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,135 @@
+package snapshotfs
+
+import (
+	"context"
+	"path"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/object"
+	"github.com/kopia/kopia/snapshot"
+)
+
+// ContentReferenceFinder finds entries of snapshots whose objects are stored using any of the provided contents.
+// Results of object lookups are cached, so a single finder should be used to search multiple snapshots.
+type ContentReferenceFinder struct {
+	rep        repo.Repository
+	contentIDs map[content.ID]bool
+	objects    map[object.ID]bool
+}
+
+// NewContentReferenceFinder returns a ContentReferenceFinder looking for the provided content IDs.
+func NewContentReferenceFinder(rep repo.Repository, contentIDs []content.ID) *ContentReferenceFinder {
+	f := &ContentReferenceFinder{
+		rep:        rep,
+		contentIDs: map[content.ID]bool{},
+		objects:    map[object.ID]bool{},
+	}
+
+	for _, cid := range contentIDs {
+		f.contentIDs[cid] = true
+	}
+
+	return f
+}
+
+// FindReferences returns paths (relative to the snapshot root) of entries of the provided snapshot
+// that reference any of the contents. A directory whose own object or any of its delta base objects
+// reference the contents is returned as a whole without examining its children.
+// The root directory of the snapshot is returned as "/".
+func (f *ContentReferenceFinder) FindReferences(ctx context.Context, man *snapshot.Manifest) ([]string, error) {
+	if man.RootEntry == nil {
+		return nil, nil
+	}
+
+	var result []string
+
+	if err := f.findInEntry(ctx, man.RootEntry, "", &result); err != nil {
+		return nil, err
+	}
+
+	for i, p := range result {
+		if p == "" {
+			result[i] = "/"
+		}
+	}
+
+	return result, nil
+}
+
+func (f *ContentReferenceFinder) findInEntry(ctx context.Context, de *snapshot.DirEntry, relPath string, result *[]string) error {
+	ref, err := f.objectReferencesContents(ctx, de.ObjectID)
+	if err != nil {
+		return errors.Wrapf(err, "error verifying %q", relPath)
+	}
+
+	if de.Type == snapshot.EntryTypeDirectory && !ref {
+		ref, err = f.baseObjectsReferenceContents(ctx, de.ObjectID)
+		if err != nil {
+			return errors.Wrapf(err, "error verifying base directories of %q", relPath)
+		}
+	}
+
+	if ref {
+		*result = append(*result, relPath)
+		return nil
+	}
+
+	if de.Type != snapshot.EntryTypeDirectory {
+		return nil
+	}
+
+	r, err := f.rep.OpenObject(ctx, de.ObjectID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to open directory %q", relPath)
+	}
+	defer r.Close() //nolint:errcheck
+
+	_, err = iterateDirEntries(ctx, f.rep, r, func(child *snapshot.DirEntry) error {
+		return f.findInEntry(ctx, child, path.Join(relPath, child.Name), result)
+	})
+
+	return err
+}
+
+func (f *ContentReferenceFinder) baseObjectsReferenceContents(ctx context.Context, oid object.ID) (bool, error) {
+	baseIDs, err := DirectoryBaseObjectIDs(ctx, f.rep, oid)
+	if err != nil {
+		return false, err
+	}
+
+	for _, baseID := range baseIDs {
+		ref, err := f.objectReferencesContents(ctx, baseID)
+		if err != nil || ref {
+			return ref, err
+		}
+	}
+
+	return false, nil
+}
+
+func (f *ContentReferenceFinder) objectReferencesContents(ctx context.Context, oid object.ID) (bool, error) {
+	if ref, ok := f.objects[oid]; ok {
+		return ref, nil
+	}
+
+	contentIDs, err := f.rep.VerifyObject(ctx, oid)
+	if err != nil {
+		return false, err
+	}
+
+	ref := false
+
+	for _, cid := range contentIDs {
+		if f.contentIDs[cid] {
+			ref = true
+			break
+		}
+	}
+
+	f.objects[oid] = ref
+
+	return ref, nil
+}
@@ -0,0 +1,67 @@
+package snapshotfs
+
+import (
+	"testing"
+
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+)
+
+func TestContentReferenceFinder(t *testing.T) {
+	ctx := testlogging.Context(t)
+	th := newUploadTestHarness(ctx)
+
+	defer th.cleanup()
+
+	th.sourceDir.AddFile("d2/d1/secret", []byte{9, 9, 9}, defaultPermissions)
+	th.sourceDir.AddFile("d1/d1/secret-copy", []byte{9, 9, 9}, defaultPermissions)
+
+	u := NewUploader(th.repo)
+	policyTree := policy.BuildTree(nil, policy.DefaultPolicy)
+
+	man, err := u.Upload(ctx, th.sourceDir, policyTree, snapshot.SourceInfo{})
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+
+	secretOID, err := ParseObjectIDWithPath(ctx, th.repo, string(man.RootObjectID())+"/d2/d1/secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contentIDs, err := th.repo.VerifyObject(ctx, secretOID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := NewContentReferenceFinder(th.repo, contentIDs).FindReferences(ctx, man)
+	if err != nil {
+		t.Fatalf("unable to find references: %v", err)
+	}
+
+	if got, want := paths, []string{"d1/d1/secret-copy", "d2/d1/secret"}; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("unexpected references: %v, want %v", got, want)
+	}
+
+	// contents of the root directory are reported as the root.
+	rootContents, err := th.repo.VerifyObject(ctx, man.RootObjectID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err = NewContentReferenceFinder(th.repo, rootContents).FindReferences(ctx, man)
+	if err != nil {
+		t.Fatalf("unable to find references: %v", err)
+	}
+
+	if len(paths) != 1 || paths[0] != "/" {
+		t.Errorf("unexpected references to root: %v", paths)
+	}
+
+	paths, err = NewContentReferenceFinder(th.repo, []content.ID{"no-such-content"}).FindReferences(ctx, man)
+	if err != nil || len(paths) != 0 {
+		t.Errorf("unexpected references: %v %v", paths, err)
+	}
+}
@@ -1,28 +1,306 @@
 package snapshotfs
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 
 	"github.com/pkg/errors"
 
 	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/object"
 	"github.com/kopia/kopia/snapshot"
 )
 
-const directoryStreamType = "kopia:directory"
+const (
+	directoryStreamType      = "kopia:directory"
+	directoryDeltaStreamType = "kopia:directory-delta"
+)
+
+// maxDirectoryDeltaDepth is the maximum length of a chain of delta directory manifests,
+// after which a full directory manifest is written.
+const maxDirectoryDeltaDepth = 10
+
+// dirManifestHeader contains fields of directory manifest that precede the list of entries.
+type dirManifestHeader struct {
+	streamType   string
+	baseObjectID object.ID
+	deltaDepth   int
+}
 
 // readDirEntries reads all directory entries from the specified reader.
-func readDirEntries(r io.Reader) ([]*snapshot.DirEntry, *fs.DirectorySummary, error) {
-	var dir snapshot.DirManifest
+func readDirEntries(ctx context.Context, rep repo.Repository, r io.Reader) ([]*snapshot.DirEntry, *fs.DirectorySummary, error) {
+	var entries []*snapshot.DirEntry
+
+	summ, err := iterateDirEntries(ctx, rep, r, func(de *snapshot.DirEntry) error {
+		entries = append(entries, de)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return entries, summ, nil
+}
+
+// iterateDirEntries decodes directory manifest from the specified reader one entry at a time,
+// invoking the provided callback for each entry and returning the directory summary.
+// Only a single entry is held in memory at a time, which allows listing very large directories.
+// Delta manifests are transparently merged with their base directory objects.
+func iterateDirEntries(ctx context.Context, rep repo.Repository, r io.Reader, cb func(de *snapshot.DirEntry) error) (*fs.DirectorySummary, error) {
+	return iterateDirEntriesWithDepth(ctx, rep, r, maxDirectoryDeltaDepth, cb)
+}
+
+// nolint:gocognit,gocyclo
+func iterateDirEntriesWithDepth(ctx context.Context, rep repo.Repository, r io.Reader, remainingDepth int, cb func(de *snapshot.DirEntry) error) (*fs.DirectorySummary, error) {
+	var (
+		hdr  dirManifestHeader
+		summ *fs.DirectorySummary
+
+		// for delta manifests only
+		changed = map[string]bool{}
+		removed = map[string]bool{}
+		delta   []*snapshot.DirEntry
+	)
+
+	dec := json.NewDecoder(r)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, errors.Wrap(err, "unable to parse directory object")
+	}
+
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse directory object")
+		}
+
+		switch t {
+		case "stream", "base", "deltaDepth":
+			if err := decodeHeaderField(dec, t.(string), &hdr); err != nil {
+				return nil, err
+			}
+
+		case "entries":
+			switch hdr.streamType {
+			case directoryStreamType:
+				if err := decodeDirEntries(dec, cb); err != nil {
+					return nil, err
+				}
+
+			case directoryDeltaStreamType:
+				// delta entries are small by construction, keep them until the base has been read.
+				if err := decodeDirEntries(dec, func(de *snapshot.DirEntry) error {
+					changed[de.Name] = true
+					delta = append(delta, de)
+
+					return nil
+				}); err != nil {
+					return nil, err
+				}
+
+			default:
+				// stream type is always serialized before entries, verify it before handing out any entries.
+				return nil, errors.Errorf("invalid directory stream type")
+			}
 
-	if err := json.NewDecoder(r).Decode(&dir); err != nil {
-		return nil, nil, errors.Wrap(err, "unable to parse directory object")
+		case "removed":
+			var names []string
+			if err := dec.Decode(&names); err != nil {
+				return nil, errors.Wrap(err, "unable to parse removed entries")
+			}
+
+			for _, n := range names {
+				removed[n] = true
+			}
+
+		case "summary":
+			if err := dec.Decode(&summ); err != nil {
+				return nil, errors.Wrap(err, "unable to parse directory summary")
+			}
+
+		default:
+			var ignored json.RawMessage
+			if err := dec.Decode(&ignored); err != nil {
+				return nil, errors.Wrap(err, "unable to parse directory object")
+			}
+		}
 	}
 
-	if dir.StreamType != directoryStreamType {
-		return nil, nil, errors.Errorf("invalid directory stream type")
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, errors.Wrap(err, "unable to parse directory object")
+	}
+
+	switch hdr.streamType {
+	case directoryStreamType:
+		return summ, nil
+
+	case directoryDeltaStreamType:
+		if err := iterateBaseDirEntries(ctx, rep, hdr.baseObjectID, remainingDepth, func(de *snapshot.DirEntry) error {
+			if changed[de.Name] || removed[de.Name] {
+				return nil
+			}
+
+			return cb(de)
+		}); err != nil {
+			return nil, err
+		}
+
+		for _, de := range delta {
+			if err := cb(de); err != nil {
+				return nil, err
+			}
+		}
+
+		return summ, nil
+
+	default:
+		return nil, errors.Errorf("invalid directory stream type")
+	}
+}
+
+func iterateBaseDirEntries(ctx context.Context, rep repo.Repository, baseOID object.ID, remainingDepth int, cb func(de *snapshot.DirEntry) error) error {
+	if remainingDepth <= 0 {
+		return errors.Errorf("directory delta chain is too long")
+	}
+
+	if baseOID == "" {
+		return errors.Errorf("missing base object of directory delta")
+	}
+
+	br, err := rep.OpenObject(ctx, baseOID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to open base directory %v", baseOID)
+	}
+	defer br.Close() //nolint:errcheck
+
+	_, err = iterateDirEntriesWithDepth(ctx, rep, br, remainingDepth-1, cb)
+
+	return errors.Wrapf(err, "unable to read base directory %v", baseOID)
+}
+
+// readDirManifestHeader reads the fields of directory manifest preceding the list of entries.
+func readDirManifestHeader(r io.Reader) (dirManifestHeader, error) {
+	var hdr dirManifestHeader
+
+	dec := json.NewDecoder(r)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return hdr, errors.Wrap(err, "unable to parse directory object")
+	}
+
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return hdr, errors.Wrap(err, "unable to parse directory object")
+		}
+
+		switch t {
+		case "stream", "base", "deltaDepth":
+			if err := decodeHeaderField(dec, t.(string), &hdr); err != nil {
+				return hdr, err
+			}
+
+		case "entries":
+			return hdr, nil
+
+		default:
+			var ignored json.RawMessage
+			if err := dec.Decode(&ignored); err != nil {
+				return hdr, errors.Wrap(err, "unable to parse directory object")
+			}
+		}
+	}
+
+	return hdr, nil
+}
+
+func decodeHeaderField(dec *json.Decoder, name string, hdr *dirManifestHeader) error {
+	var err error
+
+	switch name {
+	case "stream":
+		err = dec.Decode(&hdr.streamType)
+	case "base":
+		err = dec.Decode(&hdr.baseObjectID)
+	case "deltaDepth":
+		err = dec.Decode(&hdr.deltaDepth)
+	}
+
+	return errors.Wrapf(err, "unable to parse directory %v", name)
+}
+
+func decodeDirEntries(dec *json.Decoder, cb func(de *snapshot.DirEntry) error) error {
+	t, err := dec.Token()
+	if err != nil {
+		return errors.Wrap(err, "unable to parse directory entries")
+	}
+
+	if t == nil {
+		// "entries": null
+		return nil
+	}
+
+	if t != json.Delim('[') {
+		return errors.Errorf("unexpected token %v, expected array of directory entries", t)
+	}
+
+	for dec.More() {
+		de := &snapshot.DirEntry{}
+		if err := dec.Decode(de); err != nil {
+			return errors.Wrap(err, "unable to parse directory entry")
+		}
+
+		if err := cb(de); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, ']')
+}
+
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if t != d {
+		return errors.Errorf("unexpected token %v, expected %v", t, d)
+	}
+
+	return nil
+}
+
+// DirectoryBaseObjectIDs returns IDs of all directory objects the specified directory object
+// depends on because it is stored as a delta. The result is empty for full directory manifests.
+func DirectoryBaseObjectIDs(ctx context.Context, rep repo.Repository, oid object.ID) ([]object.ID, error) {
+	var result []object.ID
+
+	for i := 0; i <= maxDirectoryDeltaDepth; i++ {
+		hdr, err := readDirectoryObjectHeader(ctx, rep, oid)
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.streamType != directoryDeltaStreamType {
+			return result, nil
+		}
+
+		oid = hdr.baseObjectID
+		result = append(result, oid)
+	}
+
+	return nil, errors.Errorf("directory delta chain is too long")
+}
+
+func readDirectoryObjectHeader(ctx context.Context, rep repo.Repository, oid object.ID) (dirManifestHeader, error) {
+	r, err := rep.OpenObject(ctx, oid)
+	if err != nil {
+		return dirManifestHeader{}, err
 	}
+	defer r.Close() //nolint:errcheck
 
-	return dir.Entries, dir.Summary, nil
+	return readDirManifestHeader(r)
 }
@@ -0,0 +1,259 @@
+package snapshotfs
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/repo/object"
+	"github.com/kopia/kopia/snapshot"
+)
+
+// redactTree is a tree of path components to be removed from a snapshot.
+type redactTree struct {
+	remove   bool
+	children map[string]*redactTree
+}
+
+func newRedactTree(paths []string) (*redactTree, error) {
+	root := &redactTree{}
+
+	for _, p := range paths {
+		clean := strings.Trim(path.Clean("/"+p), "/")
+		if clean == "" {
+			return nil, errors.Errorf("can't redact the root of a snapshot")
+		}
+
+		n := root
+
+		for _, part := range strings.Split(clean, "/") {
+			if n.children == nil {
+				n.children = map[string]*redactTree{}
+			}
+
+			if n.children[part] == nil {
+				n.children[part] = &redactTree{}
+			}
+
+			n = n.children[part]
+		}
+
+		n.remove = true
+	}
+
+	return root, nil
+}
+
+// RedactResult describes the result of redacting a snapshot.
+type RedactResult struct {
+	// Manifest is the new snapshot manifest, not saved yet.
+	Manifest *snapshot.Manifest
+
+	// RemovedPaths contains paths that existed in the snapshot and were removed.
+	RemovedPaths []string
+
+	// RewrittenDirectories is the number of directory objects that were rewritten.
+	RewrittenDirectories int
+}
+
+// Redact writes new directory objects of the provided snapshot with the provided paths
+// (relative to the snapshot root) removed and returns the new snapshot manifest.
+// Directories on the path to removed entries are always written as full directory manifests,
+// so that they don't reference the removed entries through delta base objects.
+// Paths that don't exist in the snapshot are ignored, if none of them exist, the resulting
+// manifest is nil.
+func Redact(ctx context.Context, rep repo.Repository, man *snapshot.Manifest, paths []string) (*RedactResult, error) {
+	tree, err := newRedactTree(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	if man.RootEntry == nil || man.RootEntry.Type != snapshot.EntryTypeDirectory {
+		return nil, errors.Errorf("snapshot root is not a directory")
+	}
+
+	res := &RedactResult{}
+
+	newRoot, err := redactDirectory(ctx, rep, man.RootEntry, tree, "", res)
+	if err != nil {
+		return nil, err
+	}
+
+	if newRoot == nil {
+		return res, nil
+	}
+
+	nm := *man
+	nm.ID = ""
+	nm.RootEntry = newRoot
+	nm.RedactedPaths = append(append([]string(nil), man.RedactedPaths...), res.RemovedPaths...)
+	nm.Supersedes = append(append([]manifest.ID(nil), man.Supersedes...), man.ID)
+
+	if before, after := man.RootEntry.DirSummary, newRoot.DirSummary; before != nil && after != nil {
+		nm.Stats.TotalFileCount -= int32(before.TotalFileCount - after.TotalFileCount)
+		nm.Stats.TotalFileSize -= before.TotalFileSize - after.TotalFileSize
+		nm.Stats.TotalDirectoryCount -= int32(before.TotalDirCount - after.TotalDirCount)
+	}
+
+	res.Manifest = &nm
+
+	return res, nil
+}
+
+// redactDirectory returns the new directory entry with redacted contents or nil if nothing was removed.
+// nolint:gocyclo
+func redactDirectory(ctx context.Context, rep repo.Repository, dirEntry *snapshot.DirEntry, tree *redactTree, relPath string, res *RedactResult) (*snapshot.DirEntry, error) {
+	r, err := rep.OpenObject(ctx, dirEntry.ObjectID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open directory %q", relPath)
+	}
+	defer r.Close() //nolint:errcheck
+
+	entries, summ, err := readDirEntries(ctx, rep, r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read directory %q", relPath)
+	}
+
+	var (
+		newSumm    fs.DirectorySummary
+		newEntries []*snapshot.DirEntry
+		changed    bool
+	)
+
+	if summ != nil {
+		newSumm = summ.Clone()
+	}
+
+	for _, de := range entries {
+		child := tree.children[de.Name]
+		if child == nil {
+			newEntries = append(newEntries, de)
+			continue
+		}
+
+		childPath := path.Join(relPath, de.Name)
+
+		if child.remove {
+			subtractEntryFromSummary(&newSumm, de)
+			removeFailedEntries(&newSumm, childPath)
+
+			res.RemovedPaths = append(res.RemovedPaths, childPath)
+			changed = true
+
+			continue
+		}
+
+		if de.Type != snapshot.EntryTypeDirectory {
+			newEntries = append(newEntries, de)
+			continue
+		}
+
+		nde, err := redactDirectory(ctx, rep, de, child, childPath, res)
+		if err != nil {
+			return nil, err
+		}
+
+		if nde == nil {
+			newEntries = append(newEntries, de)
+			continue
+		}
+
+		subtractEntryFromSummary(&newSumm, de)
+		addDirectoryToSummary(&newSumm, nde)
+		removeFailedEntries(&newSumm, childPath)
+
+		if nde.DirSummary != nil {
+			newSumm.FailedEntries = append(newSumm.FailedEntries, nde.DirSummary.FailedEntries...)
+		}
+
+		newEntries = append(newEntries, nde)
+		changed = true
+	}
+
+	if !changed {
+		return nil, nil
+	}
+
+	oid, err := writeRedactedDirectory(ctx, rep, relPath, &snapshot.DirManifest{
+		StreamType: directoryStreamType,
+		Entries:    newEntries,
+		Summary:    &newSumm,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res.RewrittenDirectories++
+
+	nde := *dirEntry
+	nde.ObjectID = oid
+	nde.DirSummary = &newSumm
+
+	return &nde, nil
+}
+
+func subtractEntryFromSummary(s *fs.DirectorySummary, de *snapshot.DirEntry) {
+	// nolint:exhaustive
+	switch de.Type {
+	case snapshot.EntryTypeSymlink:
+		s.TotalSymlinkCount--
+
+	case snapshot.EntryTypeFile:
+		s.TotalFileCount--
+		s.TotalFileSize -= de.FileSize
+
+	case snapshot.EntryTypeDirectory:
+		if cs := de.DirSummary; cs != nil {
+			s.TotalFileCount -= cs.TotalFileCount
+			s.TotalFileSize -= cs.TotalFileSize
+			s.TotalDirCount -= cs.TotalDirCount
+			s.NumFailed -= cs.NumFailed
+		}
+	}
+}
+
+func addDirectoryToSummary(s *fs.DirectorySummary, de *snapshot.DirEntry) {
+	if cs := de.DirSummary; cs != nil {
+		s.TotalFileCount += cs.TotalFileCount
+		s.TotalFileSize += cs.TotalFileSize
+		s.TotalDirCount += cs.TotalDirCount
+		s.NumFailed += cs.NumFailed
+	}
+}
+
+// removeFailedEntries removes failed entries at or below the provided path.
+func removeFailedEntries(s *fs.DirectorySummary, relPath string) {
+	var result []*fs.EntryWithError
+
+	for _, fe := range s.FailedEntries {
+		if fe.EntryPath == relPath || strings.HasPrefix(fe.EntryPath, relPath+"/") {
+			continue
+		}
+
+		result = append(result, fe)
+	}
+
+	s.FailedEntries = result
+}
+
+func writeRedactedDirectory(ctx context.Context, rep repo.Repository, relPath string, dm *snapshot.DirManifest) (object.ID, error) {
+	w := rep.NewObjectWriter(ctx, object.WriterOptions{
+		Description: "DIR:" + relPath,
+		Prefix:      objectIDPrefixDirectory,
+	})
+	defer w.Close() //nolint:errcheck
+
+	if err := json.NewEncoder(w).Encode(dm); err != nil {
+		return "", errors.Wrap(err, "unable to encode directory JSON")
+	}
+
+	oid, err := w.Result()
+
+	return oid, errors.Wrap(err, "unable to write directory")
+}
@@ -0,0 +1,82 @@
+package snapshotfs
+
+import (
+	"testing"
+
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+)
+
+func TestRedact(t *testing.T) {
+	ctx := testlogging.Context(t)
+	th := newUploadTestHarness(ctx)
+
+	defer th.cleanup()
+
+	u := NewUploader(th.repo)
+	policyTree := policy.BuildTree(nil, policy.DefaultPolicy)
+
+	s1, err := u.Upload(ctx, th.sourceDir, policyTree, snapshot.SourceInfo{})
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+
+	th.sourceDir.AddFile("d2/d1/secret", []byte{9, 9, 9}, defaultPermissions)
+
+	s2, err := u.Upload(ctx, th.sourceDir, policyTree, snapshot.SourceInfo{}, s1)
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+
+	s2.ID = "s2"
+
+	res, err := Redact(ctx, th.repo, s2, []string{"d2/d1/secret", "/no-such-file", "f1/not-a-dir"})
+	if err != nil {
+		t.Fatalf("redact failed: %v", err)
+	}
+
+	if res.Manifest == nil {
+		t.Fatalf("nothing was redacted")
+	}
+
+	if got, want := res.RemovedPaths, []string{"d2/d1/secret"}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("unexpected removed paths: %v, want %v", got, want)
+	}
+
+	// ./d2/d1, ./d2 and ./
+	if got, want := res.RewrittenDirectories, 3; got != want {
+		t.Errorf("unexpected number of rewritten directories: %v, want %v", got, want)
+	}
+
+	// removing the added file produces the same directory tree as before it was added.
+	if got, want := res.Manifest.RootObjectID(), s1.RootObjectID(); got != want {
+		t.Errorf("unexpected root after redaction: %v, want %v", got, want)
+	}
+
+	if got, want := res.Manifest.Stats.TotalFileCount, s2.Stats.TotalFileCount-1; got != want {
+		t.Errorf("unexpected file count: %v, want %v", got, want)
+	}
+
+	if got, want := res.Manifest.RedactedPaths, []string{"d2/d1/secret"}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("unexpected redacted paths: %v, want %v", got, want)
+	}
+
+	if got := res.Manifest.Supersedes; len(got) != 1 || got[0] != "s2" {
+		t.Errorf("unexpected superseded manifests: %v", got)
+	}
+
+	// redacting paths that don't exist does not produce a new manifest.
+	res, err = Redact(ctx, th.repo, s1, []string{"d2/d1/secret"})
+	if err != nil {
+		t.Fatalf("redact failed: %v", err)
+	}
+
+	if res.Manifest != nil {
+		t.Errorf("unexpected redacted manifest")
+	}
+
+	if _, err := Redact(ctx, th.repo, s1, []string{"/"}); err == nil {
+		t.Errorf("expected error when redacting snapshot root")
+	}
+}
@@ -79,6 +79,10 @@ func (e *repositoryEntry) DirEntry() *snapshot.DirEntry {
 	return e.metadata
 }
 
+func (e *repositoryEntry) SecurityDescriptor() (string, error) {
+	return e.metadata.SecurityDescriptor, nil
+}
+
 type repositoryDirectory struct {
 	repositoryEntry
 	summary *fs.DirectorySummary
@@ -103,12 +107,9 @@ func (rd *repositoryDirectory) Summary(ctx context.Context) (*fs.DirectorySummar
 	}
 	defer r.Close() //nolint:errcheck
 
-	_, summ, err := readDirEntries(r)
-	if err != nil {
-		return nil, err
-	}
-
-	return summ, nil
+	return iterateDirEntries(ctx, rd.repo, r, func(de *snapshot.DirEntry) error {
+		return nil
+	})
 }
 
 func (rd *repositoryDirectory) Child(ctx context.Context, name string) (fs.Entry, error) {
@@ -122,7 +123,7 @@ func (rd *repositoryDirectory) Readdir(ctx context.Context) (fs.Entries, error)
 	}
 	defer r.Close() //nolint:errcheck
 
-	metadata, _, err := readDirEntries(r)
+	metadata, _, err := readDirEntries(ctx, rd.repo, r)
 	if err != nil {
 		return nil, err
 	}
@@ -140,6 +141,27 @@ func (rd *repositoryDirectory) Readdir(ctx context.Context) (fs.Entries, error)
 	return entries, nil
 }
 
+// IterateEntries streams directory entries in the order they were stored in the directory manifest
+// without loading the entire listing into memory.
+func (rd *repositoryDirectory) IterateEntries(ctx context.Context, cb func(ctx context.Context, e fs.Entry) error) error {
+	r, err := rd.repo.OpenObject(ctx, rd.metadata.ObjectID)
+	if err != nil {
+		return err
+	}
+	defer r.Close() //nolint:errcheck
+
+	_, err = iterateDirEntries(ctx, rd.repo, r, func(de *snapshot.DirEntry) error {
+		e, err := EntryFromDirEntry(rd.repo, de)
+		if err != nil {
+			return errors.Wrapf(err, "error parsing entry %v", de)
+		}
+
+		return cb(ctx, e)
+	})
+
+	return err
+}
+
 func (rf *repositoryFile) Open(ctx context.Context) (fs.Reader, error) {
 	r, err := rf.repo.OpenObject(ctx, rf.metadata.ObjectID)
 	if err != nil {
@@ -282,9 +304,10 @@ func IsDirectoryID(oid object.ID) bool {
 }
 
 var (
-	_ fs.Directory = (*repositoryDirectory)(nil)
-	_ fs.File      = (*repositoryFile)(nil)
-	_ fs.Symlink   = (*repositorySymlink)(nil)
+	_ fs.Directory         = (*repositoryDirectory)(nil)
+	_ fs.DirectoryIterator = (*repositoryDirectory)(nil)
+	_ fs.File              = (*repositoryFile)(nil)
+	_ fs.Symlink           = (*repositorySymlink)(nil)
 )
 
 var (
@@ -0,0 +1,370 @@
+package snapshotfs
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/object"
+)
+
+// Kinds of differences reported by DiffEntries.
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+)
+
+// Names of attributes reported in DiffEntry.Attributes.
+const (
+	DiffAttributeMode    = "mode"
+	DiffAttributeModTime = "modTime"
+	DiffAttributeOwner   = "owner"
+)
+
+// DiffOptions provides options for DiffEntries.
+type DiffOptions struct {
+	// CompareContents computes the number of bytes of added and modified files which are not shared
+	// with the previous version of the file, by comparing chunks of both versions.
+	CompareContents bool
+}
+
+// DiffEntry describes a single difference between two snapshots.
+type DiffEntry struct {
+	Path      string `json:"path"`
+	Kind      string `json:"kind"`
+	Directory bool   `json:"directory,omitempty"`
+
+	OldSize   int64 `json:"oldSize"`
+	NewSize   int64 `json:"newSize"`
+	SizeDelta int64 `json:"sizeDelta"`
+
+	// ContentChanged is true when contents of a modified file differ, modified files
+	// where it's false only have different attributes.
+	ContentChanged bool `json:"contentChanged,omitempty"`
+
+	// Attributes contains names of changed attributes of modified entries.
+	Attributes []string `json:"attributes,omitempty"`
+
+	// ChangedBytes is the number of bytes of the new version which are not shared with the previous version,
+	// only set when DiffOptions.CompareContents is used.
+	ChangedBytes *int64 `json:"changedBytes,omitempty"`
+}
+
+// DiffStats summarizes differences between two snapshots.
+type DiffStats struct {
+	AddedFiles    int `json:"addedFiles"`
+	RemovedFiles  int `json:"removedFiles"`
+	ModifiedFiles int `json:"modifiedFiles"`
+
+	AddedDirectories   int `json:"addedDirectories"`
+	RemovedDirectories int `json:"removedDirectories"`
+
+	SizeDelta int64 `json:"sizeDelta"`
+
+	// ChangedBytes is the total number of bytes of added and modified files which are not shared with
+	// previous versions, only set when DiffOptions.CompareContents is used.
+	ChangedBytes int64 `json:"changedBytes,omitempty"`
+}
+
+type differ struct {
+	rep   repo.Repository
+	opt   DiffOptions
+	cb    func(e *DiffEntry) error
+	stats DiffStats
+}
+
+// DiffEntries walks the provided snapshot trees and invokes the callback for each added, removed or modified
+// entry, skipping subtrees with identical object IDs, which have identical contents. Added and removed
+// directories are reported along with all their entries.
+func DiffEntries(ctx context.Context, rep repo.Repository, oldEntry, newEntry fs.Entry, opt DiffOptions, cb func(e *DiffEntry) error) (*DiffStats, error) {
+	d := &differ{rep: rep, opt: opt, cb: cb}
+
+	if err := d.diff(ctx, ".", oldEntry, newEntry); err != nil {
+		return nil, err
+	}
+
+	return &d.stats, nil
+}
+
+func (d *differ) diff(ctx context.Context, path string, e1, e2 fs.Entry) error {
+	if e1 == nil && e2 == nil {
+		return nil
+	}
+
+	if e1 == nil {
+		return d.added(ctx, path, e2)
+	}
+
+	if e2 == nil {
+		return d.removed(ctx, path, e1)
+	}
+
+	dir1, isDir1 := e1.(fs.Directory)
+	dir2, isDir2 := e2.(fs.Directory)
+
+	if isDir1 != isDir2 {
+		// entry changed between directory and non-directory.
+		if err := d.removed(ctx, path, e1); err != nil {
+			return err
+		}
+
+		return d.added(ctx, path, e2)
+	}
+
+	if isDir1 {
+		if sameObject(e1, e2) {
+			return nil
+		}
+
+		return d.diffDirectories(ctx, path, dir1, dir2)
+	}
+
+	return d.modified(ctx, path, e1, e2)
+}
+
+func (d *differ) diffDirectories(ctx context.Context, path string, dir1, dir2 fs.Directory) error {
+	entries1, err := readDirForDiff(ctx, dir1, path)
+	if err != nil {
+		return err
+	}
+
+	entries2, err := readDirForDiff(ctx, dir2, path)
+	if err != nil {
+		return err
+	}
+
+	byName1 := map[string]fs.Entry{}
+	byName2 := map[string]fs.Entry{}
+
+	var names []string
+
+	for _, e := range entries1 {
+		byName1[e.Name()] = e
+		names = append(names, e.Name())
+	}
+
+	for _, e := range entries2 {
+		if _, ok := byName1[e.Name()]; !ok {
+			names = append(names, e.Name())
+		}
+
+		byName2[e.Name()] = e
+	}
+
+	sort.Strings(names)
+
+	for _, n := range names {
+		if err := d.diff(ctx, path+"/"+n, byName1[n], byName2[n]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readDirForDiff(ctx context.Context, dir fs.Directory, path string) (fs.Entries, error) {
+	if dir == nil {
+		return nil, nil
+	}
+
+	entries, err := dir.Readdir(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read directory %v", path)
+	}
+
+	return entries, nil
+}
+
+func (d *differ) added(ctx context.Context, path string, e fs.Entry) error {
+	de := &DiffEntry{
+		Path: path,
+		Kind: DiffAdded,
+	}
+
+	if dir, ok := e.(fs.Directory); ok {
+		de.Directory = true
+		d.stats.AddedDirectories++
+
+		if err := d.cb(de); err != nil {
+			return err
+		}
+
+		return d.diffDirectories(ctx, path, nil, dir)
+	}
+
+	de.NewSize = e.Size()
+	de.SizeDelta = de.NewSize
+	d.stats.AddedFiles++
+
+	if d.opt.CompareContents {
+		d.setChangedBytes(ctx, de, nil, e)
+	}
+
+	return d.report(de)
+}
+
+func (d *differ) removed(ctx context.Context, path string, e fs.Entry) error {
+	de := &DiffEntry{
+		Path: path,
+		Kind: DiffRemoved,
+	}
+
+	if dir, ok := e.(fs.Directory); ok {
+		de.Directory = true
+		d.stats.RemovedDirectories++
+
+		if err := d.cb(de); err != nil {
+			return err
+		}
+
+		return d.diffDirectories(ctx, path, dir, nil)
+	}
+
+	de.OldSize = e.Size()
+	de.SizeDelta = -de.OldSize
+	d.stats.RemovedFiles++
+
+	if d.opt.CompareContents {
+		var zero int64
+
+		de.ChangedBytes = &zero
+	}
+
+	return d.report(de)
+}
+
+func (d *differ) modified(ctx context.Context, path string, e1, e2 fs.Entry) error {
+	de := &DiffEntry{
+		Path:           path,
+		Kind:           DiffModified,
+		OldSize:        e1.Size(),
+		NewSize:        e2.Size(),
+		SizeDelta:      e2.Size() - e1.Size(),
+		ContentChanged: !sameObject(e1, e2),
+		Attributes:     changedAttributes(e1, e2),
+	}
+
+	if !de.ContentChanged && len(de.Attributes) == 0 {
+		return nil
+	}
+
+	d.stats.ModifiedFiles++
+
+	if d.opt.CompareContents {
+		d.setChangedBytes(ctx, de, e1, e2)
+	}
+
+	return d.report(de)
+}
+
+func (d *differ) report(de *DiffEntry) error {
+	d.stats.SizeDelta += de.SizeDelta
+
+	if de.ChangedBytes != nil {
+		d.stats.ChangedBytes += *de.ChangedBytes
+	}
+
+	return d.cb(de)
+}
+
+// setChangedBytes computes the number of bytes of the new entry stored in chunks which are not part of the old entry.
+func (d *differ) setChangedBytes(ctx context.Context, de *DiffEntry, e1, e2 fs.Entry) {
+	var changed int64
+
+	if !sameObject(e1, e2) {
+		oldChunks := map[object.ID]bool{}
+
+		for _, c := range d.chunksOf(ctx, e1) {
+			oldChunks[c.Object] = true
+		}
+
+		for _, c := range d.chunksOf(ctx, e2) {
+			if c.Object == "" || !oldChunks[c.Object] {
+				changed += c.Length
+			}
+		}
+	}
+
+	de.ChangedBytes = &changed
+}
+
+// chunksOf returns chunks of the object backing the provided entry, treating entries without object IDs
+// and direct objects as a single chunk.
+func (d *differ) chunksOf(ctx context.Context, e fs.Entry) []object.Chunk {
+	if e == nil {
+		return nil
+	}
+
+	h, ok := e.(object.HasObjectID)
+	if !ok {
+		return []object.Chunk{{Length: e.Size()}}
+	}
+
+	oid := h.ObjectID()
+
+	if _, isIndirect := oid.IndexObjectID(); !isIndirect {
+		return []object.Chunk{{Length: e.Size(), Object: oid}}
+	}
+
+	chunks, err := object.IndirectChunks(ctx, d.rep, oid)
+	if err != nil {
+		log(ctx).Warningf("unable to read chunks of %v: %v", oid, err)
+		return []object.Chunk{{Length: e.Size()}}
+	}
+
+	return chunks
+}
+
+func sameObject(e1, e2 fs.Entry) bool {
+	h1, ok1 := e1.(object.HasObjectID)
+	h2, ok2 := e2.(object.HasObjectID)
+
+	return ok1 && ok2 && h1.ObjectID() == h2.ObjectID()
+}
+
+func changedAttributes(e1, e2 fs.Entry) []string {
+	var result []string
+
+	if e1.Mode() != e2.Mode() {
+		result = append(result, DiffAttributeMode)
+	}
+
+	if !e1.ModTime().Equal(e2.ModTime()) {
+		result = append(result, DiffAttributeModTime)
+	}
+
+	if e1.Owner() != e2.Owner() {
+		result = append(result, DiffAttributeOwner)
+	}
+
+	return result
+}
+
+// DiffResult contains all differences between two snapshots.
+type DiffResult struct {
+	Entries []*DiffEntry `json:"entries,omitempty"`
+	Stats   *DiffStats   `json:"stats"`
+}
+
+// Diff returns all differences between the provided snapshot trees.
+func Diff(ctx context.Context, rep repo.Repository, oldEntry, newEntry fs.Entry, opt DiffOptions) (*DiffResult, error) {
+	result := &DiffResult{
+		Entries: []*DiffEntry{},
+	}
+
+	stats, err := DiffEntries(ctx, rep, oldEntry, newEntry, opt, func(e *DiffEntry) error {
+		result.Entries = append(result.Entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.Stats = stats
+
+	return result, nil
+}
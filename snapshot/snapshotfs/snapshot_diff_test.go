@@ -0,0 +1,133 @@
+package snapshotfs
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+)
+
+func TestDiffEntries(t *testing.T) {
+	ctx := testlogging.Context(t)
+	th := newUploadTestHarness(ctx)
+
+	defer th.cleanup()
+
+	big := make([]byte, 20<<20)
+	rand.New(rand.NewSource(1)).Read(big) //nolint:gosec
+	th.sourceDir.AddFile("big", big, defaultPermissions)
+
+	policyTree := policy.BuildTree(nil, policy.DefaultPolicy)
+	si := snapshot.SourceInfo{UserName: "user", Host: "host", Path: "path"}
+
+	u := NewUploader(th.repo)
+
+	s1, err := u.Upload(ctx, th.sourceDir, policyTree, si)
+	if err != nil {
+		t.Fatalf("upload error: %v", err)
+	}
+
+	// modify the beginning of the big file, so that only its first chunk changes.
+	big2 := append([]byte(nil), big...)
+	big2[0]++
+
+	th.sourceDir.Remove("big")
+	th.sourceDir.AddFile("big", big2, defaultPermissions)
+	th.sourceDir.Remove("f1")
+	th.sourceDir.AddFile("f4", []byte{1, 2, 3, 4, 5, 6}, defaultPermissions)
+	th.sourceDir.Subdir("d1").Remove("f2")
+	th.sourceDir.Subdir("d1").AddFile("f2", []byte{9, 9}, defaultPermissions)
+	th.sourceDir.Remove("d2")
+	th.sourceDir.AddDir("d3", defaultPermissions)
+	th.sourceDir.AddFile("d3/f1", []byte{1}, defaultPermissions)
+
+	s2, err := u.Upload(ctx, th.sourceDir, policyTree, si)
+	if err != nil {
+		t.Fatalf("upload error: %v", err)
+	}
+
+	root1, err := SnapshotRoot(th.repo, s1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root2, err := SnapshotRoot(th.repo, s2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []*DiffEntry
+
+	stats, err := DiffEntries(ctx, th.repo, root1, root2, DiffOptions{CompareContents: true}, func(e *DiffEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("diff error: %v", err)
+	}
+
+	var summary []string
+
+	byPath := map[string]*DiffEntry{}
+
+	for _, e := range entries {
+		summary = append(summary, e.Kind+" "+e.Path)
+		byPath[e.Path] = e
+	}
+
+	if diff := cmp.Diff(summary, []string{
+		"modified ./big",
+		"modified ./d1/f2",
+		"removed ./d2",
+		"removed ./d2/d1",
+		"removed ./d2/d1/f1",
+		"removed ./d2/d1/f2",
+		"added ./d3",
+		"added ./d3/f1",
+		"removed ./f1",
+		"added ./f4",
+	}); diff != "" {
+		t.Fatalf("unexpected differences: %v", diff)
+	}
+
+	if got, want := *stats, (DiffStats{
+		AddedFiles:         2,
+		RemovedFiles:       3,
+		ModifiedFiles:      2,
+		AddedDirectories:   1,
+		RemovedDirectories: 2,
+		SizeDelta:          -2 + 1 - 3 - 4 - 3 + 6,
+		ChangedBytes:       stats.ChangedBytes,
+	}); got != want {
+		t.Errorf("unexpected stats: %+v, want %+v", got, want)
+	}
+
+	if e := byPath["./d1/f2"]; !e.ContentChanged || e.SizeDelta != -2 || *e.ChangedBytes != 2 {
+		t.Errorf("unexpected entry for modified file: %+v", e)
+	}
+
+	if e := byPath["./f4"]; *e.ChangedBytes != 6 {
+		t.Errorf("unexpected changed bytes of added file: %v", *e.ChangedBytes)
+	}
+
+	if e := byPath["./big"]; *e.ChangedBytes == 0 || *e.ChangedBytes >= int64(len(big)) {
+		t.Errorf("unexpected changed bytes of big file: %v", *e.ChangedBytes)
+	}
+
+	// identical snapshots have no differences.
+	stats, err = DiffEntries(ctx, th.repo, root2, root2, DiffOptions{}, func(e *DiffEntry) error {
+		t.Errorf("unexpected difference: %+v", e)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("diff error: %v", err)
+	}
+
+	if *stats != (DiffStats{}) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
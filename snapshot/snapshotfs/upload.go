@@ -3,7 +3,6 @@
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"io"
 	"math/rand"
 	"os"
@@ -11,6 +10,7 @@
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,11 +20,15 @@
 
 	"github.com/kopia/kopia/fs"
 	"github.com/kopia/kopia/fs/ignorefs"
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/ospriority"
 	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/repo/logging"
 	"github.com/kopia/kopia/repo/object"
 	"github.com/kopia/kopia/snapshot"
 	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/uploadhints"
 )
 
 // DefaultCheckpointInterval is the default frequency of mid-upload checkpointing.
@@ -67,6 +71,16 @@ type Uploader struct {
 	// How frequently to create checkpoint snapshot entries.
 	CheckpointInterval time.Duration
 
+	// Write manifests of large, slightly changed directories as deltas against previous snapshot.
+	UseDirectoryDeltas bool
+
+	// Hints of unchanged files used when previous snapshots don't have a matching entry, updated with
+	// all files of the snapshot. May be nil.
+	Hints *uploadhints.Hints
+
+	// Profile collects per-directory and per-file timings of the upload. May be nil.
+	Profile *PathProfile
+
 	repo repo.Repository
 
 	// stats must be allocated on heap to enforce 64-bit alignment due to atomic access on ARM.
@@ -75,6 +89,9 @@ type Uploader struct {
 
 	uploadBufPool sync.Pool
 
+	// maximum number of files read in parallel according to the upload policy of the source.
+	policyParallelUploads int
+
 	getTicker func(time.Duration) <-chan time.Time
 
 	// for testing only, when set will write to a given channel whenever checkpoint completes
@@ -103,20 +120,26 @@ func (u *Uploader) incompleteReason() string {
 	return ""
 }
 
-func (u *Uploader) uploadFileInternal(ctx context.Context, parentCheckpointRegistry *checkpointRegistry, relativePath string, f fs.File, pol *policy.Policy, asyncWrites int) (*snapshot.DirEntry, error) {
+func (u *Uploader) uploadFileInternal(ctx context.Context, parentCheckpointRegistry *checkpointRegistry, relativePath string, f fs.File, pol *policy.Policy, asyncWrites int, deltaBase object.ID) (*snapshot.DirEntry, error) {
 	u.Progress.HashingFile(relativePath)
 	defer u.Progress.FinishedHashingFile(relativePath, f.Size())
 
+	t0 := clock.Now()
+
 	file, err := f.Open(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to open file")
 	}
 	defer file.Close() //nolint:errcheck
 
+	timer := &timingReader{Reader: file, elapsed: clock.Since(t0)}
+
 	writer := u.repo.NewObjectWriter(ctx, object.WriterOptions{
 		Description: "FILE:" + f.Name(),
 		Compressor:  pol.CompressionPolicy.CompressorForFile(f),
 		AsyncWrites: asyncWrites,
+		Delta:       pol.UploadPolicy.DeltaUploadOrDefault(false),
+		DeltaBase:   deltaBase,
 	})
 	defer writer.Close() //nolint:errcheck
 
@@ -136,7 +159,9 @@ func (u *Uploader) uploadFileInternal(ctx context.Context, parentCheckpointRegis
 
 	defer parentCheckpointRegistry.removeCheckpointCallback(f)
 
-	written, err := u.copyWithProgress(writer, file, 0, f.Size())
+	sampler := &entropySampler{Reader: timer}
+
+	written, err := u.copyWithProgress(writer, sampler, 0, f.Size())
 	if err != nil {
 		return nil, err
 	}
@@ -158,9 +183,26 @@ func (u *Uploader) uploadFileInternal(ctx context.Context, parentCheckpointRegis
 
 	de.FileSize = written
 
+	maybeAddSecurityDescriptor(ctx, de, f, pol)
+
+	u.Profile.recordFile(FileProfile{
+		Path:     relativePath,
+		Bytes:    written,
+		ReadTime: timer.elapsed,
+		HashTime: clock.Since(t0) - timer.elapsed,
+	})
+
 	atomic.AddInt32(&u.stats.TotalFileCount, 1)
 	atomic.AddInt64(&u.stats.TotalFileSize, de.FileSize)
 
+	if e, ok := sampler.entropy(); ok {
+		atomic.AddInt32(&u.stats.EntropySampledFiles, 1)
+
+		if e >= highEntropyBitsPerByte {
+			atomic.AddInt32(&u.stats.HighEntropyFiles, 1)
+		}
+	}
+
 	return de, nil
 }
 
@@ -285,8 +327,34 @@ func newDirEntry(md fs.Entry, oid object.ID) (*snapshot.DirEntry, error) {
 	}, nil
 }
 
+// maybeAddSecurityDescriptor records the Windows security descriptor of a file or directory when enabled by the policy.
+// Failures to read the descriptor are logged and don't fail the snapshot.
+func maybeAddSecurityDescriptor(ctx context.Context, de *snapshot.DirEntry, e fs.Entry, pol *policy.Policy) {
+	if !pol.FilesPolicy.SecurityDescriptorsOrDefault(false) {
+		return
+	}
+
+	// security descriptors of symlinks can't be read or applied without following them.
+	if _, ok := e.(fs.Symlink); ok {
+		return
+	}
+
+	sde, ok := e.(fs.EntryWithSecurityDescriptor)
+	if !ok {
+		return
+	}
+
+	sd, err := sde.SecurityDescriptor()
+	if err != nil {
+		log(ctx).Warningf("unable to capture security descriptor of %v: %v", e.Name(), err)
+		return
+	}
+
+	de.SecurityDescriptor = sd
+}
+
 // uploadFileWithCheckpointing uploads the specified File to the repository.
-func (u *Uploader) uploadFileWithCheckpointing(ctx context.Context, relativePath string, file fs.File, pol *policy.Policy, sourceInfo snapshot.SourceInfo) (*snapshot.DirEntry, error) {
+func (u *Uploader) uploadFileWithCheckpointing(ctx context.Context, relativePath string, file fs.File, pol *policy.Policy, sourceInfo snapshot.SourceInfo, deltaBase object.ID) (*snapshot.DirEntry, error) {
 	par := u.effectiveParallelUploads()
 	if par == 1 {
 		par = 0
@@ -297,16 +365,24 @@ func (u *Uploader) uploadFileWithCheckpointing(ctx context.Context, relativePath
 	cancelCheckpointer := u.periodicallyCheckpoint(ctx, &cp, &snapshot.Manifest{Source: sourceInfo})
 	defer cancelCheckpointer()
 
-	res, err := u.uploadFileInternal(ctx, &cp, relativePath, file, pol, par)
+	res, err := u.uploadFileInternal(ctx, &cp, relativePath, file, pol, par, deltaBase)
 	if err != nil {
 		return nil, err
 	}
 
-	return newDirEntryWithSummary(file, res.ObjectID, &fs.DirectorySummary{
+	de, err := newDirEntryWithSummary(file, res.ObjectID, &fs.DirectorySummary{
 		TotalFileCount: 1,
 		TotalFileSize:  res.FileSize,
 		MaxModTime:     res.ModTime,
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the size is only known after reading files whose contents are streamed.
+	de.FileSize = res.FileSize
+
+	return de, nil
 }
 
 // checkpointRoot invokes checkpoints on the provided registry and if a checkpoint entry was generated,
@@ -458,8 +534,13 @@ func rootCauseError(err error) error {
 type dirManifestBuilder struct {
 	mu sync.Mutex
 
-	summary fs.DirectorySummary
-	entries []*snapshot.DirEntry
+	summary    fs.DirectorySummary
+	entries    []*snapshot.DirEntry
+	entryCount int
+
+	runs       []string // temporary files with sorted entries spilled to disk, shared with clones
+	ownRuns    []string // temporary files written by this builder
+	spillError bool     // spilling failed, remaining entries are kept in memory
 }
 
 // Clone clones the current state of dirManifestBuilder.
@@ -468,8 +549,11 @@ func (b *dirManifestBuilder) Clone() *dirManifestBuilder {
 	defer b.mu.Unlock()
 
 	return &dirManifestBuilder{
-		summary: b.summary.Clone(),
-		entries: append([]*snapshot.DirEntry(nil), b.entries...),
+		summary:    b.summary.Clone(),
+		entries:    append([]*snapshot.DirEntry(nil), b.entries...),
+		entryCount: b.entryCount,
+		runs:       append([]string(nil), b.runs...),
+		spillError: b.spillError,
 	}
 }
 
@@ -478,6 +562,15 @@ func (b *dirManifestBuilder) addEntry(de *snapshot.DirEntry) {
 	defer b.mu.Unlock()
 
 	b.entries = append(b.entries, de)
+	b.entryCount++
+
+	if len(b.entries) >= maxInMemoryDirEntries && !b.spillError {
+		if err := b.spillLocked(); err != nil {
+			log(context.Background()).Warningf("unable to spill directory entries to disk, keeping them in memory: %v", err)
+
+			b.spillError = true
+		}
+	}
 
 	if de.ModTime.After(b.summary.MaxModTime) {
 		b.summary.MaxModTime = de.ModTime
@@ -525,7 +618,7 @@ func (b *dirManifestBuilder) Build(dirModTime time.Time, incompleteReason string
 	s := b.summary
 	s.TotalDirCount++
 
-	if len(b.entries) == 0 {
+	if b.entryCount == 0 {
 		s.MaxModTime = dirModTime
 	}
 
@@ -542,15 +635,15 @@ func (b *dirManifestBuilder) Build(dirModTime time.Time, incompleteReason string
 		}
 	}
 
-	// sort the result, directories first, then non-directories, ordered by name
-	sort.Slice(b.entries, func(i, j int) bool {
-		if leftDir, rightDir := isDir(b.entries[i]), isDir(b.entries[j]); leftDir != rightDir {
-			// directories get sorted before non-directories
-			return leftDir
-		}
+	sortDirEntries(b.entries)
 
-		return b.entries[i].Name < b.entries[j].Name
-	})
+	if len(b.runs) > 0 {
+		// entries are merged with the spilled ones when the manifest is written, see forEachEntry().
+		return &snapshot.DirManifest{
+			StreamType: directoryStreamType,
+			Summary:    &s,
+		}
+	}
 
 	return &snapshot.DirManifest{
 		StreamType: directoryStreamType,
@@ -559,16 +652,26 @@ func (b *dirManifestBuilder) Build(dirModTime time.Time, incompleteReason string
 	}
 }
 
+// sortDirEntries sorts entries in the order of directory manifest.
+func sortDirEntries(entries []*snapshot.DirEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return dirEntryLess(entries[i], entries[j])
+	})
+}
+
 func isDir(e *snapshot.DirEntry) bool {
 	return e.Type == snapshot.EntryTypeDirectory
 }
 
-func (u *Uploader) processChildren(ctx context.Context, parentDirCheckpointRegistry *checkpointRegistry, parentDirBuilder *dirManifestBuilder, relativePath string, entries fs.Entries, policyTree *policy.Tree, previousEntries []fs.Entries) error {
-	if err := u.processSubdirectories(ctx, parentDirCheckpointRegistry, parentDirBuilder, relativePath, entries, policyTree, previousEntries); err != nil {
+// processChildren streams the directory listing once, uploading non-directories as they are listed
+// and then processing subdirectories, so that the full listing is never held in memory.
+func (u *Uploader) processChildren(ctx context.Context, parentDirCheckpointRegistry *checkpointRegistry, parentDirBuilder *dirManifestBuilder, relativePath string, directory fs.Directory, policyTree *policy.Tree, previousEntries []fs.Entries) error {
+	subdirs, err := u.processNonDirectories(ctx, parentDirCheckpointRegistry, parentDirBuilder, relativePath, directory, policyTree, previousEntries)
+	if err != nil {
 		return err
 	}
 
-	if err := u.processNonDirectories(ctx, parentDirCheckpointRegistry, parentDirBuilder, relativePath, entries, policyTree, previousEntries); err != nil {
+	if err := u.processSubdirectories(ctx, parentDirCheckpointRegistry, parentDirBuilder, relativePath, subdirs, policyTree, previousEntries); err != nil {
 		return err
 	}
 
@@ -661,6 +764,203 @@ func findCachedEntry(ctx context.Context, entry fs.Entry, prevEntries []fs.Entri
 	return nil
 }
 
+// findDeltaBase returns the object ID of the file with the same name in previous snapshots,
+// whose unchanged chunks can be reused by delta uploads.
+func findDeltaBase(entry fs.Entry, prevEntries []fs.Entries) object.ID {
+	for _, e := range prevEntries {
+		if prev, ok := e.FindByName(entry.Name()).(fs.File); ok {
+			if h, ok := prev.(object.HasObjectID); ok {
+				return h.ObjectID()
+			}
+		}
+	}
+
+	return ""
+}
+
+// extensionChangeSources returns names of files in previous snapshots that the file with the provided name may
+// have been renamed from by changing or adding an extension, such as when ransomware renames "report.docx" to
+// "report.docx.locked" or "report.locked". The file must not have existed in previous snapshots.
+func extensionChangeSources(name string, prevEntries []fs.Entries) []string {
+	base := strings.TrimSuffix(name, path.Ext(name))
+	if base == "" || base == name {
+		return nil
+	}
+
+	for _, e := range prevEntries {
+		if e.FindByName(name) != nil {
+			return nil
+		}
+	}
+
+	var result []string
+
+	prefix := base + "."
+
+	for _, e := range prevEntries {
+		if ent := e.FindByName(base); ent != nil && !ent.IsDir() {
+			result = append(result, base)
+		}
+
+		// entries are sorted by name, so entries with the same base name and any extension follow the prefix.
+		for i := sort.Search(len(e), func(i int) bool {
+			return e[i].Name() >= prefix
+		}); i < len(e) && strings.HasPrefix(e[i].Name(), prefix); i++ {
+			if !e[i].IsDir() {
+				result = append(result, e[i].Name())
+			}
+		}
+	}
+
+	return result
+}
+
+// extensionChanges counts new files of a directory renamed from files in previous snapshots by changing their
+// extension. This is only known once the whole directory has been listed, since a file with a new extension
+// is not a rename while the original file still exists, for example when it's a copy or a conversion.
+type extensionChanges struct {
+	prevEntries []fs.Entries
+
+	// names of files from previous snapshots present in the directory, only updated while listing the directory.
+	retained map[string]bool
+
+	mu         sync.Mutex
+	candidates [][]string // for each new file, the names of files it may have been renamed from
+}
+
+func newExtensionChanges(prevEntries []fs.Entries) *extensionChanges {
+	return &extensionChanges{prevEntries: prevEntries, retained: map[string]bool{}}
+}
+
+// listed records an entry of the directory listing.
+func (c *extensionChanges) listed(name string) {
+	for _, e := range c.prevEntries {
+		if e.FindByName(name) != nil {
+			c.retained[name] = true
+			return
+		}
+	}
+}
+
+// added records a file not found in previous snapshots.
+func (c *extensionChanges) added(name string) {
+	src := extensionChangeSources(name, c.prevEntries)
+	if len(src) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.candidates = append(c.candidates, src)
+}
+
+// count returns the number of files renamed from files no longer present in the directory,
+// it must be called after the directory was listed.
+func (c *extensionChanges) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+
+	for _, src := range c.candidates {
+		for _, name := range src {
+			if !c.retained[name] {
+				n++
+				break
+			}
+		}
+	}
+
+	return n
+}
+
+// addCachedEntry adds the entry for an unchanged file reusing the provided object without reading the file.
+func (u *Uploader) addCachedEntry(ctx context.Context, parentDirBuilder *dirManifestBuilder, dirRelativePath, entryRelativePath string, entry fs.Entry, oid object.ID, pol *policy.Policy) error {
+	atomic.AddInt32(&u.stats.CachedFiles, 1)
+	atomic.AddInt64(&u.stats.TotalFileSize, entry.Size())
+	u.Progress.CachedFile(filepath.Join(dirRelativePath, entry.Name()), entry.Size())
+
+	cachedDirEntry, err := newDirEntry(entry, oid)
+	if err != nil {
+		return errors.Wrap(err, "unable to create dir entry")
+	}
+
+	if _, ok := entry.(fs.File); ok {
+		u.Hints.Record(entryRelativePath, entry, oid)
+	}
+
+	maybeAddSecurityDescriptor(ctx, cachedDirEntry, entry, pol)
+
+	parentDirBuilder.addEntry(cachedDirEntry)
+
+	return nil
+}
+
+// findHintedObject returns the object recorded in upload hints for the provided file if it's unchanged
+// and the object still exists in the repository.
+func (u *Uploader) findHintedObject(ctx context.Context, entryRelativePath string, entry fs.Entry) object.ID {
+	if _, ok := entry.(fs.File); !ok {
+		return ""
+	}
+
+	oid := u.Hints.Lookup(entryRelativePath, entry)
+	if oid == "" {
+		return ""
+	}
+
+	if rand.Intn(100) < u.ForceHashPercentage { // nolint:gomnd,gosec
+		log(ctx).Debugf("re-hashing hinted object: %v", oid)
+		return ""
+	}
+
+	contentIDs, err := u.repo.VerifyObject(ctx, oid)
+	if err != nil {
+		log(ctx).Debugf("hinted object %v of %v is no longer available: %v", oid, entryRelativePath, err)
+		return ""
+	}
+
+	// contents that were marked as deleted by GC would be removed by blob GC even though the new
+	// snapshot references them, so such objects are uploaded again, which undeletes their contents.
+	if err := u.verifyContentsNotDeleted(ctx, contentIDs); err != nil {
+		log(ctx).Debugf("hinted object %v of %v can't be reused: %v", oid, entryRelativePath, err)
+		return ""
+	}
+
+	return oid
+}
+
+// contentInfoGetter is implemented by repositories that provide information about individual contents.
+type contentInfoGetter interface {
+	ContentInfo(ctx context.Context, contentID content.ID) (content.Info, error)
+}
+
+func (u *Uploader) verifyContentsNotDeleted(ctx context.Context, contentIDs []content.ID) error {
+	var cig contentInfoGetter
+
+	switch r := u.repo.(type) {
+	case *repo.DirectRepository:
+		cig = r.Content
+	case contentInfoGetter:
+		cig = r
+	default:
+		return errors.Errorf("unable to verify contents")
+	}
+
+	for _, cid := range contentIDs {
+		ci, err := cig.ContentInfo(ctx, cid)
+		if err != nil {
+			return errors.Wrapf(err, "unable to get content info for %v", cid)
+		}
+
+		if ci.Deleted {
+			return errors.Errorf("content %v is deleted", cid)
+		}
+	}
+
+	return nil
+}
+
 func (u *Uploader) maybeIgnoreCachedEntry(ctx context.Context, ent fs.Entry) fs.Entry {
 	if h, ok := ent.(object.HasObjectID); ok {
 		if rand.Intn(100) < u.ForceHashPercentage { // nolint:gomnd,gosec
@@ -674,8 +974,31 @@ func (u *Uploader) maybeIgnoreCachedEntry(ctx context.Context, ent fs.Entry) fs.
 	return nil
 }
 
+// lowerProcessPriority lowers CPU and IO priority of the process according to the upload policy
+// and returns a function that restores it. Failures are not fatal, since the snapshot can still be taken.
+func (u *Uploader) lowerProcessPriority(ctx context.Context, up policy.UploadPolicy) func() {
+	restore, err := ospriority.Lower(ospriority.Settings{
+		Niceness: up.CPUNicenessOrDefault(0),
+		IOClass:  ospriority.IOClass(up.IOPriority),
+	})
+	if err != nil {
+		log(ctx).Warningf("unable to lower process priority: %v", err)
+		return func() {}
+	}
+
+	return func() {
+		if err := restore(); err != nil {
+			log(ctx).Warningf("unable to restore process priority: %v", err)
+		}
+	}
+}
+
 func (u *Uploader) effectiveParallelUploads() int {
 	p := u.ParallelUploads
+	if p == 0 {
+		p = u.policyParallelUploads
+	}
+
 	if p == 0 {
 		p = runtime.NumCPU()
 	}
@@ -683,43 +1006,33 @@ func (u *Uploader) effectiveParallelUploads() int {
 	return p
 }
 
-func (u *Uploader) processNonDirectories(ctx context.Context, parentCheckpointRegistry *checkpointRegistry, parentDirBuilder *dirManifestBuilder, dirRelativePath string, entries fs.Entries, policyTree *policy.Tree, prevEntries []fs.Entries) error {
-	workerCount := u.effectiveParallelUploads()
-
-	var asyncWritesPerFile int
+// processNonDirectories streams entries of the directory, uploading non-directories in parallel and
+// returning subdirectories, which are processed separately.
+func (u *Uploader) processNonDirectories(ctx context.Context, parentCheckpointRegistry *checkpointRegistry, parentDirBuilder *dirManifestBuilder, dirRelativePath string, directory fs.Directory, policyTree *policy.Tree, prevEntries []fs.Entries) (fs.Entries, error) {
+	var (
+		subdirs fs.Entries
 
-	if len(entries) < workerCount {
-		if len(entries) > 0 {
-			asyncWritesPerFile = workerCount / len(entries)
-			if asyncWritesPerFile == 1 {
-				asyncWritesPerFile = 0
-			}
-		}
+		// set before the first entry is sent to workers, only when the directory has fewer entries than workers.
+		asyncWritesPerFile int
+	)
 
-		workerCount = len(entries)
-	}
+	workerCount := u.effectiveParallelUploads()
+	extChanges := newExtensionChanges(prevEntries)
 
-	return u.foreachEntryUnlessCanceled(ctx, workerCount, dirRelativePath, entries, func(ctx context.Context, entry fs.Entry, entryRelativePath string) error {
+	processEntry := func(ctx context.Context, entry fs.Entry, entryRelativePath string) error {
 		// note this function runs in parallel and updates 'u.stats', which must be done using atomic operations.
-		if _, ok := entry.(fs.Directory); ok {
-			// skip directories
-			return nil
-		}
+
+		entryPolicy := policyTree.Child(entry.Name()).EffectivePolicy()
 
 		// See if we had this name during either of previous passes.
 		if cachedEntry := u.maybeIgnoreCachedEntry(ctx, findCachedEntry(ctx, entry, prevEntries)); cachedEntry != nil {
-			atomic.AddInt32(&u.stats.CachedFiles, 1)
-			atomic.AddInt64(&u.stats.TotalFileSize, entry.Size())
-			u.Progress.CachedFile(filepath.Join(dirRelativePath, entry.Name()), entry.Size())
-
 			// compute entryResult now, cachedEntry is short-lived
-			cachedDirEntry, err := newDirEntry(entry, cachedEntry.(object.HasObjectID).ObjectID())
-			if err != nil {
-				return errors.Wrap(err, "unable to create dir entry")
-			}
+			return u.addCachedEntry(ctx, parentDirBuilder, dirRelativePath, entryRelativePath, entry, cachedEntry.(object.HasObjectID).ObjectID(), entryPolicy)
+		}
 
-			parentDirBuilder.addEntry(cachedDirEntry)
-			return nil
+		// Fall back to upload hints when previous snapshots don't have a matching entry.
+		if oid := u.findHintedObject(ctx, entryRelativePath, entry); oid != "" {
+			return u.addCachedEntry(ctx, parentDirBuilder, dirRelativePath, entryRelativePath, entry, oid, entryPolicy)
 		}
 
 		switch entry := entry.(type) {
@@ -734,18 +1047,130 @@ func (u *Uploader) processNonDirectories(ctx context.Context, parentCheckpointRe
 
 		case fs.File:
 			atomic.AddInt32(&u.stats.NonCachedFiles, 1)
-			de, err := u.uploadFileInternal(ctx, parentCheckpointRegistry, entryRelativePath, entry, policyTree.Child(entry.Name()).EffectivePolicy(), asyncWritesPerFile)
+
+			extChanges.added(entry.Name())
+
+			de, err := u.uploadFileInternal(ctx, parentCheckpointRegistry, entryRelativePath, entry, entryPolicy, asyncWritesPerFile, findDeltaBase(entry, prevEntries))
 			if err != nil {
 				return u.maybeIgnoreFileReadError(err, parentDirBuilder, entryRelativePath, policyTree)
 			}
 
+			u.Hints.Record(entryRelativePath, entry, de.ObjectID)
 			parentDirBuilder.addEntry(de)
 			return nil
 
 		default:
 			return errors.Errorf("file type not supported: %v", entry.Mode())
 		}
+	}
+
+	ch := make(chan fs.Entry)
+	eg, ctx := errgroup.WithContext(ctx)
+
+	// one goroutine to stream entries into channel until ctx is closed.
+	eg.Go(func() error {
+		defer close(ch)
+
+		var (
+			// entries are held back until we know whether the directory has fewer entries than workers,
+			// in which case each file gets to use multiple async writes.
+			lookahead  fs.Entries
+			numEntries int
+		)
+
+		// time spent waiting for workers, excluded from the scan time of the directory.
+		var sendTime time.Duration
+
+		send := func(e fs.Entry) error {
+			t0 := clock.Now()
+			defer func() { sendTime += clock.Since(t0) }()
+
+			select {
+			case ch <- e: // sent to channel
+				return nil
+			case <-ctx.Done(): // context closed
+				return ctx.Err()
+			}
+		}
+
+		scanStart := clock.Now()
+
+		err := fs.IterateEntries(ctx, directory, func(ctx context.Context, e fs.Entry) error {
+			numEntries++
+
+			extChanges.listed(e.Name())
+
+			if _, ok := e.(fs.Directory); ok {
+				subdirs = append(subdirs, e)
+			} else {
+				lookahead = append(lookahead, e)
+			}
+
+			if numEntries < workerCount {
+				return nil
+			}
+
+			for _, le := range lookahead {
+				if err := send(le); err != nil {
+					return err
+				}
+			}
+
+			lookahead = nil
+
+			return nil
+		})
+
+		u.Profile.recordScan(dirRelativePath, clock.Since(scanStart)-sendTime)
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return dirReadError{err}
+		}
+
+		if numEntries < workerCount && numEntries > 0 {
+			asyncWritesPerFile = workerCount / numEntries
+			if asyncWritesPerFile == 1 {
+				asyncWritesPerFile = 0
+			}
+		}
+
+		for _, le := range lookahead {
+			if err := send(le); err != nil {
+				return nil
+			}
+		}
+
+		return nil
 	})
+
+	// launch N workers in parallel
+	for i := 0; i < workerCount; i++ {
+		eg.Go(func() error {
+			for entry := range ch {
+				if u.IsCanceled() {
+					return errCanceled
+				}
+
+				if err := processEntry(ctx, entry, path.Join(dirRelativePath, entry.Name())); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	atomic.AddInt32(&u.stats.ExtensionChangedFiles, int32(extChanges.count()))
+
+	return subdirs, nil
 }
 
 func maybeReadDirectoryEntries(ctx context.Context, dir fs.Directory) fs.Entries {
@@ -804,14 +1229,6 @@ func uploadDirInternal(
 	u.Progress.StartedDirectory(dirRelativePath)
 	defer u.Progress.FinishedDirectory(dirRelativePath)
 
-	t0 := u.repo.Time()
-	entries, direrr := directory.Readdir(ctx)
-	log(ctx).Debugf("finished reading directory %v in %v", dirRelativePath, u.repo.Time().Sub(t0))
-
-	if direrr != nil {
-		return nil, dirReadError{direrr}
-	}
-
 	var prevEntries []fs.Entries
 
 	for _, d := range uniqueDirectories(previousDirs) {
@@ -820,6 +1237,9 @@ func uploadDirInternal(
 		}
 	}
 
+	// checkpoints of the directory share spilled entries, they are removed after the checkpoint callback.
+	defer thisDirBuilder.removeSpilledEntries()
+
 	childCheckpointRegistry := &checkpointRegistry{}
 
 	thisCheckpointRegistry.addCheckpointCallback(directory, func() (*snapshot.DirEntry, error) {
@@ -832,31 +1252,62 @@ func uploadDirInternal(
 			return nil, errors.Wrapf(err, "error checkpointing children")
 		}
 
+		defer thisCheckpointBuilder.removeSpilledEntries()
+
 		checkpointManifest := thisCheckpointBuilder.Build(directory.ModTime(), IncompleteReasonCheckpoint)
-		oid, err := u.writeDirManifest(ctx, dirRelativePath, checkpointManifest)
+		oid, err := u.writeDirManifest(ctx, dirRelativePath, checkpointManifest, thisCheckpointBuilder.forEachEntry)
 		if err != nil {
 			return nil, errors.Wrap(err, "error writing dir manifest")
 		}
 
-		return newDirEntryWithSummary(directory, oid, checkpointManifest.Summary)
+		de, err := newDirEntryWithSummary(directory, oid, checkpointManifest.Summary)
+		if err != nil {
+			return nil, err
+		}
+
+		maybeAddSecurityDescriptor(ctx, de, directory, policyTree.EffectivePolicy())
+
+		return de, nil
 	})
 	defer thisCheckpointRegistry.removeCheckpointCallback(directory)
 
-	if err := u.processChildren(ctx, childCheckpointRegistry, thisDirBuilder, dirRelativePath, entries, policyTree, prevEntries); err != nil && !errors.Is(err, errCanceled) {
+	t0 := u.repo.Time()
+
+	if err := u.processChildren(ctx, childCheckpointRegistry, thisDirBuilder, dirRelativePath, directory, policyTree, prevEntries); err != nil && !errors.Is(err, errCanceled) {
 		return nil, err
 	}
 
+	log(ctx).Debugf("finished processing directory %v in %v", dirRelativePath, u.repo.Time().Sub(t0))
+
 	dirManifest := thisDirBuilder.Build(directory.ModTime(), u.incompleteReason())
 
-	oid, err := u.writeDirManifest(ctx, dirRelativePath, dirManifest)
+	manifestToWrite, forEachEntry := dirManifest, thisDirBuilder.forEachEntry
+
+	// deltas are not written for directories with entries spilled to disk, which are not in the manifest.
+	if u.UseDirectoryDeltas && dirManifest.Summary.IncompleteReason == "" && dirManifest.Entries != nil {
+		if delta := u.maybeBuildDirectoryDelta(ctx, dirManifest, uniqueDirectories(previousDirs)); delta != nil {
+			manifestToWrite, forEachEntry = delta, nil
+		}
+	}
+
+	oid, err := u.writeDirManifest(ctx, dirRelativePath, manifestToWrite, forEachEntry)
 	if err != nil {
 		return nil, errors.Wrapf(err, "error writing dir manifest: %v", directory.Name())
 	}
 
-	return newDirEntryWithSummary(directory, oid, dirManifest.Summary)
+	de, err := newDirEntryWithSummary(directory, oid, dirManifest.Summary)
+	if err != nil {
+		return nil, err
+	}
+
+	maybeAddSecurityDescriptor(ctx, de, directory, policyTree.EffectivePolicy())
+
+	return de, nil
 }
 
-func (u *Uploader) writeDirManifest(ctx context.Context, dirRelativePath string, dirManifest *snapshot.DirManifest) (object.ID, error) {
+// writeDirManifest writes the directory manifest with entries provided by forEachEntry or the entries
+// of the manifest if nil.
+func (u *Uploader) writeDirManifest(ctx context.Context, dirRelativePath string, dirManifest *snapshot.DirManifest, forEachEntry func(cb func(de *snapshot.DirEntry) error) error) (object.ID, error) {
 	writer := u.repo.NewObjectWriter(ctx, object.WriterOptions{
 		Description: "DIR:" + dirRelativePath,
 		Prefix:      objectIDPrefixDirectory,
@@ -864,7 +1315,7 @@ func (u *Uploader) writeDirManifest(ctx context.Context, dirRelativePath string,
 
 	defer writer.Close() //nolint:errcheck
 
-	if err := json.NewEncoder(writer).Encode(dirManifest); err != nil {
+	if err := encodeDirManifest(writer, dirManifest, forEachEntry); err != nil {
 		return "", errors.Wrap(err, "unable to encode directory JSON")
 	}
 
@@ -966,6 +1417,12 @@ func (u *Uploader) Upload(
 	u.stats = &snapshot.Stats{}
 	u.totalWrittenBytes = 0
 
+	uploadPolicy := policyTree.EffectivePolicy().UploadPolicy
+	u.policyParallelUploads = uploadPolicy.MaxParallelFileReadsOrDefault(0)
+
+	defer u.lowerProcessPriority(ctx, uploadPolicy)()
+	defer u.applyBandwidthLimits(ctx, uploadPolicy)()
+
 	var err error
 
 	s.StartTime = u.repo.Time()
@@ -1004,7 +1461,16 @@ func (u *Uploader) Upload(
 
 	case fs.File:
 		u.Progress.EstimatedDataSize(1, entry.Size())
-		s.RootEntry, err = u.uploadFileWithCheckpointing(ctx, entry.Name(), entry, policyTree.EffectivePolicy(), sourceInfo)
+		var deltaBase object.ID
+
+		for _, m := range previousManifests {
+			if m.RootEntry != nil && m.RootEntry.Type == snapshot.EntryTypeFile {
+				deltaBase = m.RootEntry.ObjectID
+				break
+			}
+		}
+
+		s.RootEntry, err = u.uploadFileWithCheckpointing(ctx, entry.Name(), entry, policyTree.EffectivePolicy(), sourceInfo, deltaBase)
 
 	default:
 		return nil, errors.Errorf("unsupported source: %v", s.Source)
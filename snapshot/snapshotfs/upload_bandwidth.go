@@ -0,0 +1,83 @@
+package snapshotfs
+
+import (
+	"context"
+	"time"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/units"
+	"github.com/kopia/kopia/repo/blob/throttling"
+	"github.com/kopia/kopia/snapshot/policy"
+)
+
+// bandwidthScheduleCheckInterval is the frequency of re-evaluating the bandwidth schedule during upload.
+const bandwidthScheduleCheckInterval = time.Minute
+
+// throttledRepository is implemented by repositories that limit bandwidth of blob storage operations.
+type throttledRepository interface {
+	Throttler() throttling.Throttler
+}
+
+// applyBandwidthLimits applies bandwidth limits of the upload policy to the repository in addition to its own limits
+// and keeps adjusting them as windows of the bandwidth schedule begin and end, until the returned function is called.
+// Concurrent uploads each apply their own limits, the lowest of which applies.
+func (u *Uploader) applyBandwidthLimits(ctx context.Context, up policy.UploadPolicy) func() {
+	tr, ok := u.repo.(throttledRepository)
+	if !ok || (len(up.BandwidthSchedule) == 0 && up.MaxUploadBytesPerSecondOrDefault(0) <= 0) {
+		return func() {}
+	}
+
+	t := tr.Throttler()
+	if t == nil {
+		return func() {}
+	}
+
+	added := t.AddLimits(throttling.Limits{})
+
+	apply := func() {
+		upload, download := up.UploadLimitsAt(clock.Now())
+
+		l := throttling.Limits{UploadBytesPerSecond: upload, DownloadBytesPerSecond: download}
+		if l == added.Limits() {
+			return
+		}
+
+		log(ctx).Infof("Bandwidth limits changed, upload: %v download: %v", bandwidthString(upload), bandwidthString(download))
+		added.Set(l)
+	}
+
+	apply()
+
+	shutdown := make(chan struct{})
+	stopped := make(chan struct{})
+	ch := u.getTicker(bandwidthScheduleCheckInterval)
+
+	go func() {
+		defer close(stopped)
+
+		for {
+			select {
+			case <-shutdown:
+				return
+
+			case <-ch:
+				apply()
+			}
+		}
+	}()
+
+	return func() {
+		close(shutdown)
+		<-stopped
+
+		added.Remove()
+	}
+}
+
+func bandwidthString(bytesPerSecond int64) string {
+	if bytesPerSecond == 0 {
+		return "unlimited"
+	}
+
+	return units.BytesStringBase10(bytesPerSecond) + "/s"
+}
@@ -0,0 +1,124 @@
+package snapshotfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/repo/object"
+	"github.com/kopia/kopia/snapshot"
+)
+
+const (
+	// minEntriesForDirectoryDelta is the minimum number of directory entries for which delta manifests are written,
+	// small directories are cheap to store in full and faster to read.
+	minEntriesForDirectoryDelta = 1000
+
+	// maxDirectoryDeltaChangePercent is the maximum percentage of added, changed or removed entries,
+	// above which a full directory manifest is written.
+	maxDirectoryDeltaChangePercent = 10
+)
+
+// maybeBuildDirectoryDelta returns a delta manifest that represents the provided full directory manifest
+// relative to one of the previous directories or nil if writing a delta is not beneficial.
+func (u *Uploader) maybeBuildDirectoryDelta(ctx context.Context, full *snapshot.DirManifest, previousDirs []fs.Directory) *snapshot.DirManifest {
+	if len(full.Entries) < minEntriesForDirectoryDelta {
+		return nil
+	}
+
+	for _, pd := range previousDirs {
+		h, ok := pd.(object.HasObjectID)
+		if !ok {
+			continue
+		}
+
+		if delta := u.buildDirectoryDelta(ctx, full, h.ObjectID()); delta != nil {
+			return delta
+		}
+	}
+
+	return nil
+}
+
+func (u *Uploader) buildDirectoryDelta(ctx context.Context, full *snapshot.DirManifest, baseOID object.ID) *snapshot.DirManifest {
+	hdr, err := readDirectoryObjectHeader(ctx, u.repo, baseOID)
+	if err != nil {
+		log(ctx).Debugf("unable to read base directory header %v: %v", baseOID, err)
+		return nil
+	}
+
+	if hdr.deltaDepth >= maxDirectoryDeltaDepth {
+		// chain is too long, force full manifest.
+		return nil
+	}
+
+	r, err := u.repo.OpenObject(ctx, baseOID)
+	if err != nil {
+		log(ctx).Debugf("unable to open base directory %v: %v", baseOID, err)
+		return nil
+	}
+	defer r.Close() //nolint:errcheck
+
+	baseByName := map[string][]byte{}
+
+	if _, err = iterateDirEntries(ctx, u.repo, r, func(de *snapshot.DirEntry) error {
+		b, err := json.Marshal(de)
+		if err != nil {
+			return err
+		}
+
+		baseByName[de.Name] = b
+
+		return nil
+	}); err != nil {
+		log(ctx).Debugf("unable to read base directory %v: %v", baseOID, err)
+		return nil
+	}
+
+	maxChanges := len(full.Entries) * maxDirectoryDeltaChangePercent / 100 //nolint:gomnd
+
+	var (
+		changed []*snapshot.DirEntry
+		removed []string
+	)
+
+	for _, de := range full.Entries {
+		b, err := json.Marshal(de)
+		if err != nil {
+			return nil
+		}
+
+		if bytes.Equal(baseByName[de.Name], b) {
+			delete(baseByName, de.Name)
+			continue
+		}
+
+		delete(baseByName, de.Name)
+
+		if changed = append(changed, de); len(changed) > maxChanges {
+			return nil
+		}
+	}
+
+	// whatever remains in the base was removed.
+	for n := range baseByName {
+		removed = append(removed, n)
+	}
+
+	if len(changed)+len(removed) > maxChanges {
+		return nil
+	}
+
+	sort.Strings(removed)
+
+	return &snapshot.DirManifest{
+		StreamType:     directoryDeltaStreamType,
+		BaseObjectID:   baseOID,
+		DeltaDepth:     hdr.deltaDepth + 1,
+		Entries:        changed,
+		RemovedEntries: removed,
+		Summary:        full.Summary,
+	}
+}
@@ -0,0 +1,277 @@
+package snapshotfs
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/snapshot"
+)
+
+// maxInMemoryDirEntries is the number of entries of a single directory kept in memory during upload.
+// Entries of larger directories are spilled to sorted temporary files, which are merged while
+// the directory manifest is written.
+var maxInMemoryDirEntries = 100000
+
+// dirEntryLess defines the order of entries in directory manifests, directories first, then non-directories,
+// ordered by name.
+func dirEntryLess(a, b *snapshot.DirEntry) bool {
+	if leftDir, rightDir := isDir(a), isDir(b); leftDir != rightDir {
+		// directories get sorted before non-directories
+		return leftDir
+	}
+
+	return a.Name < b.Name
+}
+
+// spillLocked writes sorted in-memory entries to a temporary file and releases them.
+func (b *dirManifestBuilder) spillLocked() error {
+	sortDirEntries(b.entries)
+
+	f, err := ioutil.TempFile("", "kopia-dir-entries")
+	if err != nil {
+		return errors.Wrap(err, "unable to create temporary file")
+	}
+
+	defer f.Close() //nolint:errcheck,gosec
+
+	bw := bufio.NewWriter(f)
+	enc := json.NewEncoder(bw)
+
+	for _, de := range b.entries {
+		if err := enc.Encode(de); err != nil {
+			os.Remove(f.Name()) //nolint:errcheck,gosec
+			return errors.Wrap(err, "unable to write directory entry")
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		os.Remove(f.Name()) //nolint:errcheck,gosec
+		return errors.Wrap(err, "unable to write directory entries")
+	}
+
+	b.runs = append(b.runs, f.Name())
+	b.ownRuns = append(b.ownRuns, f.Name())
+	b.entries = nil
+
+	return nil
+}
+
+// removeSpilledEntries removes temporary files written by the builder, files shared with clones
+// are removed by the builder that wrote them.
+func (b *dirManifestBuilder) removeSpilledEntries() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, fname := range b.ownRuns {
+		if err := os.Remove(fname); err != nil && !os.IsNotExist(err) {
+			log(context.Background()).Warningf("unable to remove temporary file: %v", err)
+		}
+	}
+
+	b.ownRuns = nil
+}
+
+// forEachEntry invokes the callback for all entries in the order of directory manifest, merging
+// spilled entries with the entries in memory. Must be called after Build().
+func (b *dirManifestBuilder) forEachEntry(cb func(de *snapshot.DirEntry) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.runs) == 0 {
+		for _, de := range b.entries {
+			if err := cb(de); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	var (
+		h       dirEntryHeap
+		sources []*dirEntrySource
+	)
+
+	for _, fname := range b.runs {
+		f, err := os.Open(fname) //nolint:gosec
+		if err != nil {
+			return errors.Wrap(err, "unable to open spilled directory entries")
+		}
+
+		defer f.Close() //nolint:errcheck,gosec
+
+		sources = append(sources, &dirEntrySource{dec: json.NewDecoder(bufio.NewReader(f))})
+	}
+
+	sources = append(sources, &dirEntrySource{remaining: b.entries})
+
+	for _, src := range sources {
+		de, err := src.next()
+		if errors.Is(err, io.EOF) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		src.current = de
+		heap.Push(&h, src)
+	}
+
+	for h.Len() > 0 {
+		src := h[0]
+
+		if err := cb(src.current); err != nil {
+			return err
+		}
+
+		de, err := src.next()
+
+		switch {
+		case errors.Is(err, io.EOF):
+			heap.Pop(&h)
+		case err != nil:
+			return err
+		default:
+			src.current = de
+			heap.Fix(&h, 0)
+		}
+	}
+
+	return nil
+}
+
+// encodeDirManifest writes the same JSON as json.Encoder, but obtains entries from forEachEntry if provided,
+// so that entries spilled to disk don't need to be loaded into memory.
+func encodeDirManifest(w io.Writer, man *snapshot.DirManifest, forEachEntry func(cb func(de *snapshot.DirEntry) error) error) error {
+	bw := bufio.NewWriter(w)
+
+	writeField := func(prefix string, v interface{}) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return errors.Wrap(err, "unable to encode directory manifest")
+		}
+
+		bw.WriteString(prefix) //nolint:errcheck
+		bw.Write(b)            //nolint:errcheck
+
+		return nil
+	}
+
+	if err := writeField(`{"stream":`, man.StreamType); err != nil {
+		return err
+	}
+
+	if man.BaseObjectID != "" {
+		if err := writeField(`,"base":`, man.BaseObjectID); err != nil {
+			return err
+		}
+	}
+
+	if man.DeltaDepth != 0 {
+		if err := writeField(`,"deltaDepth":`, man.DeltaDepth); err != nil {
+			return err
+		}
+	}
+
+	if forEachEntry == nil {
+		if err := writeField(`,"entries":`, man.Entries); err != nil {
+			return err
+		}
+	} else {
+		n := 0
+
+		if err := forEachEntry(func(de *snapshot.DirEntry) error {
+			prefix := ","
+			if n == 0 {
+				prefix = `,"entries":[`
+			}
+
+			n++
+
+			return writeField(prefix, de)
+		}); err != nil {
+			return err
+		}
+
+		if n == 0 {
+			// manifests without entries have null entries.
+			bw.WriteString(`,"entries":null`) //nolint:errcheck
+		} else {
+			bw.WriteString("]") //nolint:errcheck
+		}
+	}
+
+	if len(man.RemovedEntries) > 0 {
+		if err := writeField(`,"removed":`, man.RemovedEntries); err != nil {
+			return err
+		}
+	}
+
+	if err := writeField(`,"summary":`, man.Summary); err != nil {
+		return err
+	}
+
+	bw.WriteString("}\n") //nolint:errcheck
+
+	return errors.Wrap(bw.Flush(), "unable to write directory manifest")
+}
+
+// dirEntrySource provides sorted entries from a spilled file or from memory.
+type dirEntrySource struct {
+	dec       *json.Decoder
+	remaining []*snapshot.DirEntry
+	current   *snapshot.DirEntry
+}
+
+func (s *dirEntrySource) next() (*snapshot.DirEntry, error) {
+	if s.dec == nil {
+		if len(s.remaining) == 0 {
+			return nil, io.EOF
+		}
+
+		de := s.remaining[0]
+		s.remaining = s.remaining[1:]
+
+		return de, nil
+	}
+
+	de := &snapshot.DirEntry{}
+	if err := s.dec.Decode(de); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+
+		return nil, errors.Wrap(err, "unable to read spilled directory entry")
+	}
+
+	return de, nil
+}
+
+// dirEntryHeap orders sources by their current entries.
+type dirEntryHeap []*dirEntrySource
+
+func (h dirEntryHeap) Len() int           { return len(h) }
+func (h dirEntryHeap) Less(i, j int) bool { return dirEntryLess(h[i].current, h[j].current) }
+func (h dirEntryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *dirEntryHeap) Push(x interface{}) {
+	*h = append(*h, x.(*dirEntrySource))
+}
+
+func (h *dirEntryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+
+	return x
+}
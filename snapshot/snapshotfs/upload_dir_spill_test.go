@@ -0,0 +1,175 @@
+package snapshotfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+)
+
+func TestEncodeDirManifest(t *testing.T) {
+	summ := &fs.DirectorySummary{TotalFileCount: 2, TotalFileSize: 3}
+
+	cases := []*snapshot.DirManifest{
+		{StreamType: directoryStreamType, Summary: summ},
+		{StreamType: directoryStreamType, Entries: []*snapshot.DirEntry{}},
+		{
+			StreamType: directoryStreamType,
+			Entries: []*snapshot.DirEntry{
+				{Name: "<dir>", Type: snapshot.EntryTypeDirectory, ObjectID: "k1234"},
+				{Name: "a&b", Type: snapshot.EntryTypeFile, FileSize: 3, ObjectID: "1234"},
+			},
+			Summary: summ,
+		},
+		{
+			StreamType:     directoryDeltaStreamType,
+			BaseObjectID:   "k5678",
+			DeltaDepth:     2,
+			Entries:        []*snapshot.DirEntry{{Name: "x", Type: snapshot.EntryTypeFile}},
+			RemovedEntries: []string{"y"},
+		},
+	}
+
+	for _, man := range cases {
+		var want, got bytes.Buffer
+
+		if err := json.NewEncoder(&want).Encode(man); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := encodeDirManifest(&got, man, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if got.String() != want.String() {
+			t.Errorf("unexpected encoding %v, want %v", got.String(), want.String())
+		}
+	}
+}
+
+func TestDirManifestBuilderSpill(t *testing.T) {
+	defer func(v int) { maxInMemoryDirEntries = v }(maxInMemoryDirEntries)
+
+	maxInMemoryDirEntries = 3
+
+	var (
+		spilled  dirManifestBuilder
+		inMemory dirManifestBuilder
+	)
+
+	for i := 0; i < 20; i++ {
+		// alternate directories and files in reverse order.
+		de := &snapshot.DirEntry{Name: fmt.Sprintf("e%02v", 20-i), Type: snapshot.EntryTypeFile, FileSize: int64(i)}
+		if i%3 == 0 {
+			de.Type = snapshot.EntryTypeDirectory
+		}
+
+		spilled.addEntry(de)
+	}
+
+	if len(spilled.runs) == 0 || len(spilled.entries) >= maxInMemoryDirEntries {
+		t.Fatalf("entries were not spilled: %v runs, %v in memory", len(spilled.runs), len(spilled.entries))
+	}
+
+	defer spilled.removeSpilledEntries()
+
+	// checkpoints share spilled entries.
+	clone := spilled.Clone()
+	clone.addEntry(&snapshot.DirEntry{Name: "checkpointed", Type: snapshot.EntryTypeFile})
+
+	maxInMemoryDirEntries = 1000
+
+	spilledManifest := spilled.Build(clock.Now(), "")
+
+	if err := spilled.forEachEntry(func(de *snapshot.DirEntry) error {
+		inMemory.addEntry(de)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	inMemoryManifest := inMemory.Build(clock.Now(), "")
+
+	var want, got bytes.Buffer
+
+	if err := encodeDirManifest(&want, inMemoryManifest, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := encodeDirManifest(&got, spilledManifest, spilled.forEachEntry); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.String() != want.String() {
+		t.Fatalf("unexpected manifest of spilled entries %v, want %v", got.String(), want.String())
+	}
+
+	clone.Build(clock.Now(), "")
+
+	n := 0
+
+	if err := clone.forEachEntry(func(de *snapshot.DirEntry) error {
+		n++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := n, 21; got != want {
+		t.Fatalf("unexpected number of entries in clone: %v, want %v", got, want)
+	}
+
+	runs := spilled.runs
+	spilled.removeSpilledEntries()
+
+	for _, r := range runs {
+		if _, err := os.Stat(r); !os.IsNotExist(err) {
+			t.Fatalf("spilled entries were not removed: %v", r)
+		}
+	}
+}
+
+func TestUploadSpilledDirectory(t *testing.T) {
+	ctx := testlogging.Context(t)
+	th := newUploadTestHarness(ctx)
+
+	defer th.cleanup()
+
+	for i := 0; i < 50; i++ {
+		th.sourceDir.AddFile(fmt.Sprintf("file%v", i), []byte{byte(i)}, defaultPermissions)
+	}
+
+	before, _ := filepath.Glob(filepath.Join(os.TempDir(), "kopia-dir-entries*"))
+
+	man1, err := NewUploader(th.repo).Upload(ctx, th.sourceDir, policy.BuildTree(nil, policy.DefaultPolicy), snapshot.SourceInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(v int) { maxInMemoryDirEntries = v }(maxInMemoryDirEntries)
+
+	maxInMemoryDirEntries = 4
+
+	man2, err := NewUploader(th.repo).Upload(ctx, th.sourceDir, policy.BuildTree(nil, policy.DefaultPolicy), snapshot.SourceInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// spilling entries does not change directory manifests.
+	if got, want := man2.RootObjectID(), man1.RootObjectID(); got != want {
+		t.Fatalf("unexpected root object %v, want %v", got, want)
+	}
+
+	after, _ := filepath.Glob(filepath.Join(os.TempDir(), "kopia-dir-entries*"))
+	if len(after) != len(before) {
+		t.Fatalf("temporary files were not removed: %v", after)
+	}
+}
@@ -0,0 +1,62 @@
+package snapshotfs
+
+import (
+	"io"
+	"math"
+)
+
+const (
+	// entropySampleBytes is the number of bytes at the beginning of each file used to estimate its entropy.
+	entropySampleBytes = 64 << 10
+
+	// minEntropySampleBytes is the minimum sample size for which entropy is estimated, shorter samples are too noisy.
+	minEntropySampleBytes = 4096
+
+	// highEntropyBitsPerByte is the entropy above which file contents look random, which is typical for
+	// compressed or encrypted data.
+	highEntropyBitsPerByte = 7.5
+)
+
+// entropySampler wraps a reader and computes byte histogram of the data read from its beginning.
+type entropySampler struct {
+	io.Reader
+
+	histogram [256]int
+	sampled   int
+}
+
+func (s *entropySampler) Read(p []byte) (int, error) {
+	n, err := s.Reader.Read(p)
+
+	for _, b := range p[0:n] {
+		if s.sampled >= entropySampleBytes {
+			break
+		}
+
+		s.histogram[b]++
+		s.sampled++
+	}
+
+	return n, err
+}
+
+// entropy returns Shannon entropy of the sampled data in bits per byte and a flag indicating whether
+// enough data was sampled for the estimate to be meaningful.
+func (s *entropySampler) entropy() (float64, bool) {
+	if s.sampled < minEntropySampleBytes {
+		return 0, false
+	}
+
+	var result float64
+
+	for _, cnt := range s.histogram {
+		if cnt == 0 {
+			continue
+		}
+
+		p := float64(cnt) / float64(s.sampled)
+		result -= p * math.Log2(p)
+	}
+
+	return result, true
+}
@@ -0,0 +1,39 @@
+package snapshotfs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sampleEntropy(t *testing.T, data []byte) (float64, bool) {
+	t.Helper()
+
+	s := &entropySampler{Reader: bytes.NewReader(data)}
+
+	n, err := io.Copy(ioutil.Discard, s)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+
+	return s.entropy()
+}
+
+func TestEntropySampler(t *testing.T) {
+	random := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	e, ok := sampleEntropy(t, random)
+	require.True(t, ok)
+	require.Greater(t, e, highEntropyBitsPerByte)
+
+	e, ok = sampleEntropy(t, bytes.Repeat([]byte("hello world, this is some text. "), 10000))
+	require.True(t, ok)
+	require.Less(t, e, highEntropyBitsPerByte)
+
+	_, ok = sampleEntropy(t, random[0:100])
+	require.False(t, ok)
+}
@@ -0,0 +1,178 @@
+package snapshotfs
+
+import (
+	"io"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kopia/kopia/internal/clock"
+)
+
+// DirectoryProfile describes the time spent processing the files of a single directory.
+// Files of subdirectories are not included.
+type DirectoryProfile struct {
+	Path     string        `json:"path"`
+	Files    int           `json:"files"`
+	Bytes    int64         `json:"bytes"`
+	ScanTime time.Duration `json:"scanTime"`
+	ReadTime time.Duration `json:"readTime"`
+	HashTime time.Duration `json:"hashTime"`
+}
+
+// TotalTime returns the total time spent processing the directory.
+func (p DirectoryProfile) TotalTime() time.Duration {
+	return p.ScanTime + p.ReadTime + p.HashTime
+}
+
+// FileProfile describes the time spent uploading a single file.
+type FileProfile struct {
+	Path     string        `json:"path"`
+	Bytes    int64         `json:"bytes"`
+	ReadTime time.Duration `json:"readTime"`
+	HashTime time.Duration `json:"hashTime"`
+}
+
+// TotalTime returns the total time spent uploading the file.
+func (p FileProfile) TotalTime() time.Duration {
+	return p.ReadTime + p.HashTime
+}
+
+// PathProfile collects per-directory and per-file timings of an upload.
+// Scan time is the time spent listing the directory, read time is the time spent opening and reading
+// source files and hash time is the time spent hashing, compressing and writing their contents to the repository.
+//
+// Only files that are actually read are measured, cached files don't contribute to the profile.
+type PathProfile struct {
+	mu       sync.Mutex
+	dirs     map[string]*DirectoryProfile
+	files    []FileProfile
+	maxFiles int
+}
+
+// NewPathProfile returns a new PathProfile that remembers up to maxFiles slowest files.
+func NewPathProfile(maxFiles int) *PathProfile {
+	return &PathProfile{
+		dirs:     map[string]*DirectoryProfile{},
+		maxFiles: maxFiles,
+	}
+}
+
+func (p *PathProfile) dirLocked(relativePath string) *DirectoryProfile {
+	d := p.dirs[relativePath]
+	if d == nil {
+		d = &DirectoryProfile{Path: relativePath}
+		p.dirs[relativePath] = d
+	}
+
+	return d
+}
+
+func (p *PathProfile) recordScan(dirRelativePath string, dur time.Duration) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.dirLocked(dirRelativePath).ScanTime += dur
+}
+
+func (p *PathProfile) recordFile(fp FileProfile) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	d := p.dirLocked(path.Dir(fp.Path))
+	d.Files++
+	d.Bytes += fp.Bytes
+	d.ReadTime += fp.ReadTime
+	d.HashTime += fp.HashTime
+
+	if p.maxFiles <= 0 {
+		return
+	}
+
+	p.files = append(p.files, fp)
+
+	// trim the list of files to maxFiles slowest whenever it grows twice as big.
+	if len(p.files) >= 2*p.maxFiles {
+		sortFileProfiles(p.files)
+		p.files = append([]FileProfile(nil), p.files[0:p.maxFiles]...)
+	}
+}
+
+// SlowestDirectories returns up to n directories that took the most time to process, slowest first.
+func (p *PathProfile) SlowestDirectories(n int) []DirectoryProfile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var result []DirectoryProfile
+	for _, d := range p.dirs {
+		result = append(result, *d)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if ti, tj := result[i].TotalTime(), result[j].TotalTime(); ti != tj {
+			return ti > tj
+		}
+
+		return result[i].Path < result[j].Path
+	})
+
+	if len(result) > n {
+		result = result[0:n]
+	}
+
+	return result
+}
+
+// SlowestFiles returns up to n files that took the most time to upload, slowest first.
+// At most the number of files passed to NewPathProfile() is returned.
+func (p *PathProfile) SlowestFiles(n int) []FileProfile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := append([]FileProfile(nil), p.files...)
+	sortFileProfiles(result)
+
+	if len(result) > n {
+		result = result[0:n]
+	}
+
+	if len(result) > p.maxFiles {
+		result = result[0:p.maxFiles]
+	}
+
+	return result
+}
+
+func sortFileProfiles(files []FileProfile) {
+	sort.Slice(files, func(i, j int) bool {
+		if ti, tj := files[i].TotalTime(), files[j].TotalTime(); ti != tj {
+			return ti > tj
+		}
+
+		return files[i].Path < files[j].Path
+	})
+}
+
+// timingReader wraps a reader and measures the time spent in Read().
+type timingReader struct {
+	io.Reader
+
+	elapsed time.Duration
+}
+
+func (r *timingReader) Read(p []byte) (int, error) {
+	t0 := clock.Now()
+	n, err := r.Reader.Read(p)
+	r.elapsed += clock.Since(t0)
+
+	return n, err
+}
@@ -0,0 +1,94 @@
+package snapshotfs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+)
+
+func TestUploadWithPathProfile(t *testing.T) {
+	ctx := testlogging.Context(t)
+	th := newUploadTestHarness(ctx)
+
+	defer th.cleanup()
+
+	u := NewUploader(th.repo)
+	u.Profile = NewPathProfile(3)
+
+	policyTree := policy.BuildTree(nil, policy.DefaultPolicy)
+
+	if _, err := u.Upload(ctx, th.sourceDir, policyTree, snapshot.SourceInfo{}); err != nil {
+		t.Fatalf("upload error: %v", err)
+	}
+
+	filesPerDir := map[string]int{}
+	for _, d := range u.Profile.SlowestDirectories(100) {
+		filesPerDir[d.Path] = d.Files
+	}
+
+	want := map[string]int{".": 3, "d1": 1, "d1/d1": 2, "d1/d2": 2, "d2": 0, "d2/d1": 2}
+
+	for p, n := range want {
+		got, ok := filesPerDir[p]
+		if !ok {
+			t.Errorf("missing profile of %v", p)
+			continue
+		}
+
+		if got != n {
+			t.Errorf("invalid number of files in %v: %v, want %v", p, got, n)
+		}
+	}
+
+	if got, want := len(u.Profile.SlowestDirectories(2)), 2; got != want {
+		t.Errorf("invalid number of slowest directories: %v, want %v", got, want)
+	}
+
+	if got, want := len(u.Profile.SlowestFiles(100)), 3; got != want {
+		t.Errorf("invalid number of slowest files: %v, want %v", got, want)
+	}
+}
+
+func TestPathProfileSlowestFiles(t *testing.T) {
+	p := NewPathProfile(2)
+
+	for i := 0; i < 10; i++ {
+		p.recordFile(FileProfile{
+			Path:     fmt.Sprintf("dir/f%v", i),
+			Bytes:    1,
+			ReadTime: time.Duration(i) * time.Second,
+			HashTime: time.Second,
+		})
+	}
+
+	p.recordScan("dir", time.Second)
+	p.recordScan("other", 100*time.Second)
+
+	files := p.SlowestFiles(5)
+	if len(files) != 2 || files[0].Path != "dir/f9" || files[1].Path != "dir/f8" {
+		t.Errorf("unexpected slowest files: %v", files)
+	}
+
+	dirs := p.SlowestDirectories(5)
+	if len(dirs) != 2 || dirs[0].Path != "other" || dirs[1].Path != "dir" {
+		t.Fatalf("unexpected slowest directories: %v", dirs)
+	}
+
+	if got, want := dirs[1].TotalTime(), 56*time.Second; got != want {
+		t.Errorf("invalid total time of dir: %v, want %v", got, want)
+	}
+
+	if got, want := dirs[1].Files, 10; got != want {
+		t.Errorf("invalid number of files: %v, want %v", got, want)
+	}
+
+	// nil profile is a no-op.
+	var np *PathProfile
+
+	np.recordFile(FileProfile{Path: "x"})
+	np.recordScan("x", time.Second)
+}
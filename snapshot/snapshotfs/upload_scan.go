@@ -20,15 +20,10 @@ func (u *Uploader) scanDirectory(ctx context.Context, dir fs.Directory) (scanRes
 		return res, nil
 	}
 
-	entries, err := dir.Readdir(ctx)
-	if err != nil {
-		return res, err
-	}
-
-	for _, e := range entries {
+	err := fs.IterateEntries(ctx, dir, func(ctx context.Context, e fs.Entry) error {
 		if err := ctx.Err(); err != nil {
 			// terminate early if context got canceled
-			return res, err
+			return err
 		}
 
 		switch e := e.(type) {
@@ -38,13 +33,18 @@ func (u *Uploader) scanDirectory(ctx context.Context, dir fs.Directory) (scanRes
 			res.totalFileSize += dr.totalFileSize
 
 			if err != nil {
-				return res, err
+				return err
 			}
 
 		case fs.File:
 			res.numFiles++
 			res.totalFileSize += e.Size()
 		}
+
+		return nil
+	})
+	if err != nil {
+		return res, err
 	}
 
 	return res, nil
@@ -1,8 +1,11 @@
 package snapshotfs
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -13,12 +16,14 @@
 	"github.com/pkg/errors"
 
 	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/fs/virtualfs"
 	"github.com/kopia/kopia/internal/clock"
 	"github.com/kopia/kopia/internal/faketime"
 	"github.com/kopia/kopia/internal/mockfs"
 	"github.com/kopia/kopia/internal/testlogging"
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/repo/blob/filesystem"
+	"github.com/kopia/kopia/repo/blob/throttling"
 	"github.com/kopia/kopia/repo/object"
 	"github.com/kopia/kopia/snapshot"
 	"github.com/kopia/kopia/snapshot/policy"
@@ -345,3 +350,358 @@ func TestUploadWithCheckpointing(t *testing.T) {
 		}
 	}
 }
+
+func TestUploadWithBandwidthSchedule(t *testing.T) {
+	ctx := testlogging.Context(t)
+	th := newUploadTestHarness(ctx)
+
+	defer th.cleanup()
+
+	throttler := th.repo.(*repo.DirectRepository).Throttler()
+	if throttler == nil {
+		t.Fatalf("repository is not throttled")
+	}
+
+	var allDay policy.BandwidthWindow
+
+	if err := allDay.Parse("00:00-00:00 upload=100MB download=200MB"); err != nil {
+		t.Fatal(err)
+	}
+
+	pol := *policy.DefaultPolicy
+	pol.UploadPolicy.BandwidthSchedule = []policy.BandwidthWindow{allDay}
+
+	var limitsDuringUpload throttling.Limits
+
+	th.sourceDir.Subdir("d1").OnReaddir(func() {
+		limitsDuringUpload = throttler.EffectiveLimits()
+	})
+
+	u := NewUploader(th.repo)
+	if _, err := u.Upload(ctx, th.sourceDir, policy.BuildTree(nil, &pol), snapshot.SourceInfo{}); err != nil {
+		t.Fatalf("upload error: %v", err)
+	}
+
+	if got, want := limitsDuringUpload, (throttling.Limits{UploadBytesPerSecond: 100e6, DownloadBytesPerSecond: 200e6}); got != want {
+		t.Errorf("unexpected limits during upload: %v, want %v", got, want)
+	}
+
+	if got, want := throttler.EffectiveLimits(), (throttling.Limits{}); got != want {
+		t.Errorf("limits were not removed after upload: %v", got)
+	}
+}
+
+func TestUploadWithMaxUploadSpeed(t *testing.T) {
+	ctx := testlogging.Context(t)
+	th := newUploadTestHarness(ctx)
+
+	defer th.cleanup()
+
+	throttler := th.repo.(*repo.DirectRepository).Throttler()
+	if throttler == nil {
+		t.Fatalf("repository is not throttled")
+	}
+
+	maxUploadSpeed := int64(50e6)
+
+	// limits of the repository still apply when they are lower.
+	throttler.SetLimits(throttling.Limits{UploadBytesPerSecond: 80e6, DownloadBytesPerSecond: 30e6})
+
+	pol := *policy.DefaultPolicy
+	pol.UploadPolicy.MaxUploadBytesPerSecond = &maxUploadSpeed
+
+	var limitsDuringUpload throttling.Limits
+
+	th.sourceDir.Subdir("d1").OnReaddir(func() {
+		limitsDuringUpload = throttler.EffectiveLimits()
+	})
+
+	u := NewUploader(th.repo)
+	if _, err := u.Upload(ctx, th.sourceDir, policy.BuildTree(nil, &pol), snapshot.SourceInfo{}); err != nil {
+		t.Fatalf("upload error: %v", err)
+	}
+
+	if got, want := limitsDuringUpload, (throttling.Limits{UploadBytesPerSecond: 50e6, DownloadBytesPerSecond: 30e6}); got != want {
+		t.Errorf("unexpected limits during upload: %v, want %v", got, want)
+	}
+
+	if got, want := throttler.EffectiveLimits(), (throttling.Limits{UploadBytesPerSecond: 80e6, DownloadBytesPerSecond: 30e6}); got != want {
+		t.Errorf("limits were not restored after upload: %v", got)
+	}
+}
+
+func TestUploadStreamingFile(t *testing.T) {
+	ctx := testlogging.Context(t)
+	th := newUploadTestHarness(ctx)
+
+	defer th.cleanup()
+
+	data := bytes.Repeat([]byte("streamed data\n"), 10000)
+	f := virtualfs.NewStreamingFile("dump.sql", clock.Now(), bytes.NewReader(data))
+
+	u := NewUploader(th.repo)
+
+	man, err := u.Upload(ctx, f, policy.BuildTree(nil, policy.DefaultPolicy), snapshot.SourceInfo{Path: "/dumps/dump.sql"})
+	if err != nil {
+		t.Fatalf("upload error: %v", err)
+	}
+
+	if got, want := man.RootEntry.FileSize, int64(len(data)); got != want {
+		t.Errorf("unexpected root entry size: %v, want %v", got, want)
+	}
+
+	r, err := th.repo.OpenObject(ctx, man.RootObjectID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close() //nolint:errcheck
+
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Errorf("unexpected contents of uploaded stream")
+	}
+}
+
+func TestUploadWithDirectoryDeltas(t *testing.T) {
+	ctx := testlogging.Context(t)
+	th := newUploadTestHarness(ctx)
+
+	defer th.cleanup()
+
+	th.sourceDir.AddDir("big", defaultPermissions)
+
+	for i := 0; i < minEntriesForDirectoryDelta; i++ {
+		th.sourceDir.AddFile(fmt.Sprintf("big/f%v", i), []byte{byte(i)}, defaultPermissions)
+	}
+
+	u := NewUploader(th.repo)
+	u.UseDirectoryDeltas = true
+
+	policyTree := policy.BuildTree(nil, policy.DefaultPolicy)
+
+	s1, err := u.Upload(ctx, th.sourceDir, policyTree, snapshot.SourceInfo{})
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	th.sourceDir.Subdir("big").Remove("f1")
+	th.sourceDir.Subdir("big").Remove("f2")
+	th.sourceDir.AddFile("big/f2", []byte{1, 2, 3}, defaultPermissions)
+	th.sourceDir.AddFile("big/new", []byte{1, 2, 3, 4}, defaultPermissions)
+
+	s2, err := u.Upload(ctx, th.sourceDir, policyTree, snapshot.SourceInfo{}, s1)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	root2, err := SnapshotRoot(th.repo, s2)
+	if err != nil {
+		t.Fatalf("unable to open root: %v", err)
+	}
+
+	big, err := root2.(fs.Directory).Child(ctx, "big")
+	if err != nil {
+		t.Fatalf("unable to get child: %v", err)
+	}
+
+	baseIDs, err := DirectoryBaseObjectIDs(ctx, th.repo, big.(object.HasObjectID).ObjectID())
+	if err != nil {
+		t.Fatalf("unable to get base object IDs: %v", err)
+	}
+
+	if got, want := len(baseIDs), 1; got != want {
+		t.Fatalf("unexpected number of base object IDs: %v, want %v", got, want)
+	}
+
+	entries, err := big.(fs.Directory).Readdir(ctx)
+	if err != nil {
+		t.Fatalf("unable to read directory: %v", err)
+	}
+
+	if got, want := len(entries), minEntriesForDirectoryDelta; got != want {
+		t.Errorf("unexpected number of entries: %v, want %v", got, want)
+	}
+
+	if entries.FindByName("f1") != nil {
+		t.Errorf("removed entry was found")
+	}
+
+	if got, want := entries.FindByName("f2").Size(), int64(3); got != want {
+		t.Errorf("unexpected size of changed entry: %v, want %v", got, want)
+	}
+
+	if entries.FindByName("new") == nil {
+		t.Errorf("added entry was not found")
+	}
+
+	// small directories are never stored as deltas.
+	d1, err := root2.(fs.Directory).Child(ctx, "d1")
+	if err != nil {
+		t.Fatalf("unable to get child: %v", err)
+	}
+
+	if baseIDs, err := DirectoryBaseObjectIDs(ctx, th.repo, d1.(object.HasObjectID).ObjectID()); err != nil || len(baseIDs) != 0 {
+		t.Errorf("unexpected base object IDs of small directory: %v, %v", baseIDs, err)
+	}
+}
+
+func TestExtensionChanges(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	prev := mockfs.NewDirectory()
+	prev.AddFile("report.docx", []byte{1}, 0o644)
+	prev.AddFile("notes", []byte{1}, 0o644)
+	prev.AddFile("photo.jpg", []byte{1}, 0o644)
+	prev.AddDir("src", 0o755)
+
+	prevEntries, err := prev.Readdir(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"report.docx":        false,
+		"report.docx.locked": true,
+		"report.locked":      true,
+		"notes.enc":          true,
+		"photo.jpg":          false,
+		"photo2.jpg":         false,
+		"src.zip":            false,
+		"new.txt":            false,
+		".bashrc":            false,
+	}
+
+	for name, want := range cases {
+		if got := len(extensionChangeSources(name, []fs.Entries{prevEntries})) > 0; got != want {
+			t.Errorf("extensionChangeSources(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	if extensionChangeSources("report.locked", nil) != nil {
+		t.Errorf("extension change detected without previous snapshot")
+	}
+
+	// files are only counted as renamed when the original file is gone from the directory.
+	c := newExtensionChanges([]fs.Entries{prevEntries})
+
+	for _, name := range []string{"report.docx", "report.docx.bak", "notes.enc", "photo.jpg"} {
+		c.listed(name)
+	}
+
+	c.added("report.docx.bak")
+	c.added("notes.enc")
+
+	if got := c.count(); got != 1 {
+		t.Errorf("unexpected number of extension changes: %v", got)
+	}
+}
+
+func TestUploadWithDeltaUpload(t *testing.T) {
+	ctx := testlogging.Context(t)
+	th := newUploadTestHarness(ctx)
+
+	defer th.cleanup()
+
+	data := make([]byte, 16<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	th.sourceDir.AddFile("big", data, defaultPermissions)
+
+	deltaUpload := true
+	policyTree := policy.BuildTree(nil, &policy.Policy{
+		UploadPolicy: policy.UploadPolicy{
+			DeltaUpload: &deltaUpload,
+		},
+	})
+
+	u := NewUploader(th.repo)
+
+	s1, err := u.Upload(ctx, th.sourceDir, policyTree, snapshot.SourceInfo{})
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	modified := append(append([]byte(nil), data...), "appended"...)
+
+	th.sourceDir.Remove("big")
+	th.sourceDir.AddFile("big", modified, defaultPermissions)
+
+	s2, err := u.Upload(ctx, th.sourceDir, policyTree, snapshot.SourceInfo{}, s1)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if got, want := s2.Stats.NonCachedFiles, int32(1); got != want {
+		t.Errorf("unexpected number of non-cached files: %v, want %v", got, want)
+	}
+
+	root2, err := SnapshotRoot(th.repo, s2)
+	if err != nil {
+		t.Fatalf("unable to open root: %v", err)
+	}
+
+	big, err := root2.(fs.Directory).Child(ctx, "big")
+	if err != nil {
+		t.Fatalf("unable to get child: %v", err)
+	}
+
+	r, err := big.(fs.File).Open(ctx)
+	if err != nil {
+		t.Fatalf("unable to open file: %v", err)
+	}
+
+	defer r.Close()
+
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unable to read file: %v", err)
+	}
+
+	if !bytes.Equal(got, modified) {
+		t.Errorf("unexpected contents of file uploaded using delta")
+	}
+}
+
+type fileWithSecurityDescriptor struct {
+	fs.File
+	sd string
+}
+
+func (f fileWithSecurityDescriptor) SecurityDescriptor() (string, error) {
+	return f.sd, nil
+}
+
+func TestMaybeAddSecurityDescriptor(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	dir := mockfs.NewDirectory()
+	f := fileWithSecurityDescriptor{dir.AddFile("f", []byte{1, 2, 3}, defaultPermissions), "O:BAG:SYD:(A;;FA;;;BA)"}
+
+	enabled := true
+
+	cases := []struct {
+		pol  *policy.Policy
+		want string
+	}{
+		{policy.DefaultPolicy, ""},
+		{&policy.Policy{FilesPolicy: policy.FilesPolicy{SecurityDescriptors: &enabled}}, f.sd},
+	}
+
+	for _, tc := range cases {
+		de, err := newDirEntry(f, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		maybeAddSecurityDescriptor(ctx, de, f, tc.pol)
+
+		if got := de.SecurityDescriptor; got != tc.want {
+			t.Errorf("unexpected security descriptor: %q, want %q", got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package snapshotfsck
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/parallelwork"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/object"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/legalhold"
+	"github.com/kopia/kopia/snapshot/snapshotcatalog"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+// unreferencedBlobMinAge is the age after which pack blobs not referenced by any index are reported,
+// younger blobs may belong to writers which have not flushed their indexes yet.
+const unreferencedBlobMinAge = 2 * time.Hour
+
+// root is a root of a tree of objects referenced by a manifest.
+type root struct {
+	desc  string
+	entry *snapshot.DirEntry
+}
+
+// checkBlobsAndIndexes cross-checks pack blobs against index entries.
+func checkBlobsAndIndexes(ctx context.Context, rep *repo.DirectRepository, r *Report) error {
+	blobs := map[blob.ID]blob.Metadata{}
+
+	for _, prefix := range content.PackBlobIDPrefixes {
+		if err := rep.Blobs.ListBlobs(ctx, prefix, func(bm blob.Metadata) error {
+			blobs[bm.BlobID] = bm
+			return nil
+		}); err != nil {
+			return errors.Wrap(err, "unable to list pack blobs")
+		}
+	}
+
+	r.checked("blobs", int64(len(blobs)))
+
+	var mu sync.Mutex
+
+	referenced := map[blob.ID]bool{}
+
+	if err := rep.Content.IterateContents(ctx, content.IterateOptions{
+		IncludeDeleted: true,
+	}, func(ci content.Info) error {
+		r.checked("indexEntries", 1)
+
+		mu.Lock()
+		referenced[ci.PackBlobID] = true
+		mu.Unlock()
+
+		bm, ok := blobs[ci.PackBlobID]
+
+		switch {
+		case !ok && ci.Deleted:
+			r.add(SeverityWarning, LayerIndex, string(ci.ID), "deleted content refers to missing pack blob %v", ci.PackBlobID)
+
+		case !ok:
+			r.add(SeverityCritical, LayerIndex, string(ci.ID), "content refers to missing pack blob %v", ci.PackBlobID)
+
+		case int64(ci.PackOffset+ci.Length) > bm.Length:
+			r.add(SeverityCritical, LayerIndex, string(ci.ID), "content extends beyond the end of pack blob %v (%v > %v)", ci.PackBlobID, ci.PackOffset+ci.Length, bm.Length)
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "unable to iterate contents")
+	}
+
+	for id, bm := range blobs {
+		if referenced[id] || rep.Time().Sub(bm.Timestamp) < unreferencedBlobMinAge {
+			continue
+		}
+
+		r.add(SeverityInfo, LayerBlob, string(id), "pack blob is not referenced by any index entry and will be removed by maintenance")
+	}
+
+	return nil
+}
+
+// checkManifests verifies that root objects of snapshots, catalogs and legal holds exist and returns
+// the roots of object trees that are referenced by them.
+func checkManifests(ctx context.Context, rep *repo.DirectRepository, r *Report) ([]root, error) {
+	ids, err := snapshot.ListSnapshotManifests(ctx, rep, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list snapshot manifests")
+	}
+
+	var roots []root
+
+	snapshotIDs := map[string]bool{}
+
+	for _, id := range ids {
+		r.checked("snapshots", 1)
+
+		m, err := snapshot.LoadSnapshot(ctx, rep, id)
+		if err != nil {
+			r.add(SeverityError, LayerManifest, string(id), "unable to load snapshot manifest: %v", err)
+			continue
+		}
+
+		snapshotIDs[string(id)] = true
+		desc := "snapshot " + string(id) + " of " + m.Source.String()
+
+		if m.RootEntry == nil {
+			r.add(SeverityCritical, LayerManifest, desc, "snapshot has no root entry")
+			continue
+		}
+
+		if _, err := rep.VerifyObject(ctx, m.RootObjectID()); err != nil {
+			r.add(SeverityCritical, LayerManifest, desc, "root object %v is invalid: %v", m.RootObjectID(), err)
+			continue
+		}
+
+		roots = append(roots, root{desc, m.RootEntry})
+	}
+
+	live, orphaned, err := snapshotcatalog.ListLiveAndOrphaned(ctx, rep)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list catalogs")
+	}
+
+	for _, cm := range live {
+		r.checked("catalogs", 1)
+
+		if _, err := rep.VerifyObject(ctx, cm.ObjectID); err != nil {
+			r.add(SeverityWarning, LayerManifest, "catalog of snapshot "+string(cm.SnapshotID), "catalog object %v is invalid and needs to be rebuilt: %v", cm.ObjectID, err)
+		}
+	}
+
+	for _, cm := range orphaned {
+		r.add(SeverityInfo, LayerManifest, "catalog of snapshot "+string(cm.SnapshotID), "catalog of deleted snapshot will be removed by maintenance")
+	}
+
+	holds, err := legalhold.List(ctx, rep)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list legal holds")
+	}
+
+	for _, h := range holds {
+		r.checked("legalHolds", 1)
+
+		desc := "legal hold of snapshot " + string(h.SnapshotID)
+
+		if !snapshotIDs[string(h.SnapshotID)] {
+			r.add(SeverityWarning, LayerManifest, desc, "snapshot under legal hold no longer exists")
+		}
+
+		if h.RootEntry == nil {
+			continue
+		}
+
+		if _, err := rep.VerifyObject(ctx, h.RootEntry.ObjectID); err != nil {
+			r.add(SeverityCritical, LayerManifest, desc, "root object %v is invalid: %v", h.RootEntry.ObjectID, err)
+			continue
+		}
+
+		roots = append(roots, root{desc, h.RootEntry})
+	}
+
+	return roots, nil
+}
+
+// objectChecker verifies that all objects reachable from the roots are backed by live contents.
+type objectChecker struct {
+	rep   *repo.DirectRepository
+	r     *Report
+	queue *parallelwork.Queue
+
+	mu   sync.Mutex
+	seen map[object.ID]bool
+}
+
+func (c *objectChecker) enqueue(ctx context.Context, oid object.ID, isDir bool, path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.seen[oid] {
+		return
+	}
+
+	c.seen[oid] = true
+
+	if isDir {
+		// process directories first to discover all objects early.
+		c.queue.EnqueueFront(ctx, func() error {
+			return c.checkObject(ctx, oid, true, path)
+		})
+
+		return
+	}
+
+	c.queue.EnqueueBack(ctx, func() error {
+		return c.checkObject(ctx, oid, false, path)
+	})
+}
+
+func (c *objectChecker) checkObject(ctx context.Context, oid object.ID, isDir bool, path string) error {
+	c.r.checked("objects", 1)
+
+	contentIDs, err := c.rep.VerifyObject(ctx, oid)
+	if err != nil {
+		c.r.add(SeverityCritical, LayerObject, path, "object %v is invalid: %v", oid, err)
+		return nil
+	}
+
+	for _, cid := range contentIDs {
+		ci, err := c.rep.Content.ContentInfo(ctx, cid)
+		if err != nil {
+			c.r.add(SeverityCritical, LayerObject, path, "object %v refers to missing content %v", oid, cid)
+			continue
+		}
+
+		if ci.Deleted {
+			c.r.add(SeverityError, LayerObject, path, "object %v refers to content %v which is marked as deleted", oid, cid)
+		}
+	}
+
+	if !isDir {
+		return nil
+	}
+
+	entries, err := snapshotfs.DirectoryEntry(c.rep, oid, nil).Readdir(ctx)
+	if err != nil {
+		c.r.add(SeverityCritical, LayerObject, path, "unable to read directory %v: %v", oid, err)
+		return nil
+	}
+
+	for _, e := range entries {
+		h, ok := e.(object.HasObjectID)
+		if !ok {
+			continue
+		}
+
+		c.enqueue(ctx, h.ObjectID(), e.IsDir(), path+"/"+e.Name())
+	}
+
+	return nil
+}
+
+func checkObjects(ctx context.Context, rep *repo.DirectRepository, r *Report, roots []root, parallel int) error {
+	c := &objectChecker{
+		rep:   rep,
+		r:     r,
+		queue: parallelwork.NewQueue(),
+		seen:  map[object.ID]bool{},
+	}
+
+	for _, rt := range roots {
+		c.enqueue(ctx, rt.entry.ObjectID, rt.entry.Type == snapshot.EntryTypeDirectory, rt.desc)
+	}
+
+	c.queue.ProgressCallback = func(ctx context.Context, enqueued, active, completed int64) {
+		log(ctx).Infof("  checked %v/%v objects", completed, enqueued)
+	}
+
+	return errors.Wrap(c.queue.Process(ctx, parallel), "error checking objects")
+}
+
+// checkContents downloads and verifies all contents that are not deleted.
+func checkContents(ctx context.Context, rep *repo.DirectRepository, r *Report, parallel int) error {
+	ctx = content.UsingContentCache(ctx, false)
+
+	var cnt int64
+
+	err := rep.Content.IterateContents(ctx, content.IterateOptions{
+		Parallel: parallel,
+	}, func(ci content.Info) error {
+		r.checked("contents", 1)
+
+		if _, err := rep.Content.GetContent(ctx, ci.ID); err != nil {
+			r.add(SeverityCritical, LayerIndex, string(ci.ID), "content is unreadable: %v", err)
+		}
+
+		if n := atomic.AddInt64(&cnt, 1); n%10000 == 0 {
+			log(ctx).Infof("  verified %v contents", n)
+		}
+
+		return nil
+	})
+
+	return errors.Wrap(err, "unable to iterate contents")
+}
@@ -0,0 +1,236 @@
+// Package snapshotfsck implements end-to-end consistency check of a repository, which cross-checks
+// blobs, indexes, objects and manifests.
+package snapshotfsck
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/logging"
+)
+
+var log = logging.GetContextLoggerFunc("snapshotfsck")
+
+// Level determines how thorough the check is.
+type Level string
+
+// Supported check levels.
+const (
+	// LevelQuick cross-checks blobs against indexes and manifests against their root objects.
+	LevelQuick Level = "quick"
+
+	// LevelStandard additionally verifies that all objects reachable from manifests are backed by contents.
+	LevelStandard Level = "standard"
+
+	// LevelFull additionally downloads and verifies all contents.
+	LevelFull Level = "full"
+)
+
+// Levels contains all supported levels in the order of increasing thoroughness.
+var Levels = []Level{LevelQuick, LevelStandard, LevelFull}
+
+func (l Level) includes(other Level) bool {
+	return l.rank() >= other.rank()
+}
+
+func (l Level) rank() int {
+	for i, v := range Levels {
+		if v == l {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Severity describes the impact of a finding.
+type Severity int
+
+// Supported severities, in the order of increasing impact.
+const (
+	// SeverityInfo findings are expected to be resolved by regular maintenance.
+	SeverityInfo Severity = iota
+
+	// SeverityWarning findings don't affect snapshots but indicate inconsistency.
+	SeverityWarning
+
+	// SeverityError findings may affect future operations on the repository.
+	SeverityError
+
+	// SeverityCritical findings mean that some snapshot data can't be restored.
+	SeverityCritical
+)
+
+var severityNames = map[Severity]string{
+	SeverityInfo:     "info",
+	SeverityWarning:  "warning",
+	SeverityError:    "error",
+	SeverityCritical: "critical",
+}
+
+func (s Severity) String() string {
+	if n, ok := severityNames[s]; ok {
+		return n
+	}
+
+	return fmt.Sprintf("severity-%d", int(s))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Layers of the repository that are checked.
+const (
+	LayerBlob     = "blob"
+	LayerIndex    = "index"
+	LayerObject   = "object"
+	LayerManifest = "manifest"
+)
+
+// Finding describes a single problem found during the check.
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Layer    string   `json:"layer"`
+	Subject  string   `json:"subject"`
+	Message  string   `json:"message"`
+}
+
+// Report is the result of a consistency check.
+type Report struct {
+	Level     Level            `json:"level"`
+	StartTime time.Time        `json:"startTime"`
+	EndTime   time.Time        `json:"endTime"`
+	Checked   map[string]int64 `json:"checked"`
+	Findings  []Finding        `json:"findings"`
+
+	mu sync.Mutex
+}
+
+func (r *Report) add(sev Severity, layer, subject, format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Findings = append(r.Findings, Finding{
+		Severity: sev,
+		Layer:    layer,
+		Subject:  subject,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (r *Report) checked(what string, n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Checked[what] += n
+}
+
+// MaxSeverity returns the highest severity of all findings or -1 if there are none.
+func (r *Report) MaxSeverity() Severity {
+	result := Severity(-1)
+
+	for _, f := range r.Findings {
+		if f.Severity > result {
+			result = f.Severity
+		}
+	}
+
+	return result
+}
+
+// CountBySeverity returns the number of findings of the provided severity.
+func (r *Report) CountBySeverity(sev Severity) int {
+	cnt := 0
+
+	for _, f := range r.Findings {
+		if f.Severity == sev {
+			cnt++
+		}
+	}
+
+	return cnt
+}
+
+// sortFindings sorts findings so that the most severe ones come first.
+func (r *Report) sortFindings() {
+	sort.SliceStable(r.Findings, func(i, j int) bool {
+		a, b := r.Findings[i], r.Findings[j]
+
+		if a.Severity != b.Severity {
+			return a.Severity > b.Severity
+		}
+
+		if a.Layer != b.Layer {
+			return a.Layer < b.Layer
+		}
+
+		return a.Subject < b.Subject
+	})
+}
+
+// Options provides options for Run.
+type Options struct {
+	Level    Level
+	Parallel int
+}
+
+// Run performs consistency check of the repository at the provided level.
+func Run(ctx context.Context, rep *repo.DirectRepository, opt Options) (*Report, error) {
+	if opt.Level.rank() < 0 {
+		return nil, errors.Errorf("unsupported level %q", opt.Level)
+	}
+
+	if opt.Parallel <= 0 {
+		opt.Parallel = runtime.NumCPU()
+	}
+
+	r := &Report{
+		Level:     opt.Level,
+		StartTime: rep.Time(),
+		Checked:   map[string]int64{},
+		Findings:  []Finding{},
+	}
+
+	log(ctx).Infof("Checking blobs and indexes...")
+
+	if err := checkBlobsAndIndexes(ctx, rep, r); err != nil {
+		return nil, err
+	}
+
+	log(ctx).Infof("Checking manifests...")
+
+	roots, err := checkManifests(ctx, rep, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.Level.includes(LevelStandard) {
+		log(ctx).Infof("Checking objects...")
+
+		if err := checkObjects(ctx, rep, r, roots, opt.Parallel); err != nil {
+			return nil, err
+		}
+	}
+
+	if opt.Level.includes(LevelFull) {
+		log(ctx).Infof("Verifying contents...")
+
+		if err := checkContents(ctx, rep, r, opt.Parallel); err != nil {
+			return nil, err
+		}
+	}
+
+	r.EndTime = rep.Time()
+	r.sortFindings()
+
+	return r, nil
+}
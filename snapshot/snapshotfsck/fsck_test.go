@@ -0,0 +1,83 @@
+package snapshotfsck_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+	"github.com/kopia/kopia/snapshot/snapshotfsck"
+)
+
+const defaultPermissions = 0o777
+
+func TestFsck(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	sourceDir := mockfs.NewDirectory()
+	sourceDir.AddFile("f1", []byte{1, 2, 3}, defaultPermissions)
+	sourceDir.AddDir("d1", defaultPermissions)
+	sourceDir.AddFile("d1/f2", []byte{4, 5, 6, 7}, defaultPermissions)
+
+	u := snapshotfs.NewUploader(env.Repository)
+
+	man, err := u.Upload(ctx, sourceDir, policy.BuildTree(nil, policy.DefaultPolicy), snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"})
+	require.NoError(t, err)
+
+	_, err = snapshot.SaveSnapshot(ctx, env.Repository, man)
+	require.NoError(t, err)
+	require.NoError(t, env.Repository.Flush(ctx))
+
+	for _, level := range snapshotfsck.Levels {
+		r, err := snapshotfsck.Run(ctx, env.Repository, snapshotfsck.Options{Level: level})
+		require.NoError(t, err)
+		require.Empty(t, r.Findings, "level %v", level)
+		require.NotZero(t, r.Checked["indexEntries"], "level %v", level)
+	}
+
+	_, err = snapshotfsck.Run(ctx, env.Repository, snapshotfsck.Options{Level: "bogus"})
+	require.Error(t, err)
+
+	// remove data pack blobs, which makes file contents unreachable.
+	deletePackBlobs(ctx, t, env, content.PackBlobIDPrefixRegular)
+
+	r, err := snapshotfsck.Run(ctx, env.Repository, snapshotfsck.Options{Level: snapshotfsck.LevelStandard})
+	require.NoError(t, err)
+	require.Equal(t, snapshotfsck.SeverityCritical, r.MaxSeverity())
+	require.Equal(t, snapshotfsck.SeverityCritical, r.Findings[0].Severity)
+
+	layers := map[string]bool{}
+	for _, f := range r.Findings {
+		layers[f.Layer] = true
+	}
+
+	require.True(t, layers[snapshotfsck.LayerIndex], "missing index findings: %v", r.Findings)
+}
+
+func deletePackBlobs(ctx context.Context, t *testing.T, env repotesting.Environment, prefix blob.ID) {
+	t.Helper()
+
+	var ids []blob.ID
+
+	require.NoError(t, env.Repository.Blobs.ListBlobs(ctx, prefix, func(bm blob.Metadata) error {
+		ids = append(ids, bm.BlobID)
+		return nil
+	}))
+
+	require.NotEmpty(t, ids)
+
+	for _, id := range ids {
+		require.NoError(t, env.Repository.Blobs.DeleteBlob(ctx, id))
+	}
+}
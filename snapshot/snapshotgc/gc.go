@@ -17,7 +17,10 @@
 	"github.com/kopia/kopia/repo/manifest"
 	"github.com/kopia/kopia/repo/object"
 	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/legalhold"
+	"github.com/kopia/kopia/snapshot/snapshotcatalog"
 	"github.com/kopia/kopia/snapshot/snapshotfs"
+	"github.com/kopia/kopia/snapshot/uploadhints"
 )
 
 var log = logging.GetContextLoggerFunc("snapshotgc")
@@ -26,12 +29,20 @@ func oidOf(entry fs.Entry) object.ID {
 	return entry.(object.HasObjectID).ObjectID()
 }
 
-func findInUseContentIDs(ctx context.Context, rep repo.Repository, used *sync.Map) error {
-	ids, err := snapshot.ListSnapshotManifests(ctx, rep, nil)
+func findInUseContentIDs(ctx context.Context, rep repo.Repository, used *sync.Map, excluded map[manifest.ID]bool) error {
+	var ids []manifest.ID
+
+	all, err := snapshot.ListSnapshotManifests(ctx, rep, nil)
 	if err != nil {
 		return errors.Wrap(err, "unable to list snapshot manifest IDs")
 	}
 
+	for _, id := range all {
+		if !excluded[id] {
+			ids = append(ids, id)
+		}
+	}
+
 	manifests, err := snapshot.LoadSnapshots(ctx, rep, ids)
 	if err != nil {
 		return errors.Wrap(err, "unable to load manifest IDs")
@@ -49,6 +60,25 @@ func findInUseContentIDs(ctx context.Context, rep repo.Repository, used *sync.Ma
 		w.RootEntries = append(w.RootEntries, root)
 	}
 
+	// contents of snapshots under legal hold are never collected, even if their manifests were removed.
+	holds, err := legalhold.List(ctx, rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to list legal holds")
+	}
+
+	for _, h := range holds {
+		if h.RootEntry == nil {
+			continue
+		}
+
+		root, err := snapshotfs.EntryFromDirEntry(rep, h.RootEntry)
+		if err != nil {
+			return errors.Wrap(err, "unable to get root of snapshot under legal hold")
+		}
+
+		w.RootEntries = append(w.RootEntries, root)
+	}
+
 	w.ObjectCallback = func(entry fs.Entry) error {
 		oid := oidOf(entry)
 
@@ -61,6 +91,27 @@ func findInUseContentIDs(ctx context.Context, rep repo.Repository, used *sync.Ma
 			used.Store(cid, nil)
 		}
 
+		if _, ok := entry.(fs.Directory); !ok {
+			return nil
+		}
+
+		// directories stored as deltas keep their base directory objects alive.
+		baseIDs, err := snapshotfs.DirectoryBaseObjectIDs(ctx, rep, oid)
+		if err != nil {
+			return errors.Wrapf(err, "error reading base directories of %v", oid)
+		}
+
+		for _, baseID := range baseIDs {
+			contentIDs, err := rep.VerifyObject(ctx, baseID)
+			if err != nil {
+				return errors.Wrapf(err, "error verifying %v", baseID)
+			}
+
+			for _, cid := range contentIDs {
+				used.Store(cid, nil)
+			}
+		}
+
 		return nil
 	}
 
@@ -73,6 +124,61 @@ func findInUseContentIDs(ctx context.Context, rep repo.Repository, used *sync.Ma
 	return nil
 }
 
+// findInUseCatalogContentIDs marks contents of catalogs of existing snapshots as used and
+// deletes manifests of catalogs whose snapshots no longer exist.
+func findInUseCatalogContentIDs(ctx context.Context, rep repo.Repository, used *sync.Map, gcDelete bool) error {
+	live, orphaned, err := snapshotcatalog.ListLiveAndOrphaned(ctx, rep)
+	if err != nil {
+		return errors.Wrap(err, "unable to list catalogs")
+	}
+
+	for _, cm := range live {
+		contentIDs, err := rep.VerifyObject(ctx, cm.ObjectID)
+		if err != nil {
+			return errors.Wrapf(err, "error verifying catalog %v", cm.ObjectID)
+		}
+
+		for _, cid := range contentIDs {
+			used.Store(cid, nil)
+		}
+	}
+
+	for _, cm := range orphaned {
+		log(ctx).Debugf("orphaned catalog %v of snapshot %v", cm.ID, cm.SnapshotID)
+
+		if !gcDelete {
+			continue
+		}
+
+		if err := rep.DeleteManifest(ctx, cm.ID); err != nil {
+			return errors.Wrapf(err, "unable to delete catalog %v", cm.ID)
+		}
+	}
+
+	return nil
+}
+
+// findInUseUploadHintsContentIDs marks contents of upload hints stored in the repository as used.
+func findInUseUploadHintsContentIDs(ctx context.Context, rep repo.Repository, used *sync.Map) error {
+	manifests, err := uploadhints.List(ctx, rep, nil)
+	if err != nil {
+		return errors.Wrap(err, "unable to list upload hints")
+	}
+
+	for _, hm := range manifests {
+		contentIDs, err := rep.VerifyObject(ctx, hm.ObjectID)
+		if err != nil {
+			return errors.Wrapf(err, "error verifying upload hints %v", hm.ObjectID)
+		}
+
+		for _, cid := range contentIDs {
+			used.Store(cid, nil)
+		}
+	}
+
+	return nil
+}
+
 // Run performs garbage collection on all the snapshots in the repository.
 // nolint:gocognit
 func Run(ctx context.Context, rep *repo.DirectRepository, params maintenance.SnapshotGCParams, gcDelete bool) (Stats, error) {
@@ -85,6 +191,88 @@ func Run(ctx context.Context, rep *repo.DirectRepository, params maintenance.Sna
 	return st, err
 }
 
+// action is the action snapshot GC takes on a single content.
+type action int
+
+const (
+	actionAlreadyDeleted action = iota
+	actionSystem
+	actionInUse
+	actionUndelete
+	actionTooRecent
+	actionDelete
+)
+
+// classify determines what snapshot GC does with the provided content.
+func classify(ctx context.Context, rep *repo.DirectRepository, params maintenance.SnapshotGCParams, used *sync.Map, ci content.Info) action {
+	if manifest.ContentPrefix == ci.ID.Prefix() {
+		return actionSystem
+	}
+
+	if _, ok := used.Load(ci.ID); ok {
+		if ci.Deleted {
+			return actionUndelete
+		}
+
+		return actionInUse
+	}
+
+	if rep.Time().Sub(ci.Timestamp()) < params.MinContentAge {
+		log(ctx).Debugf("recent unreferenced content %v (%v bytes, modified %v)", ci.ID, ci.Length, ci.Timestamp())
+		return actionTooRecent
+	}
+
+	if ci.Deleted {
+		return actionAlreadyDeleted
+	}
+
+	return actionDelete
+}
+
+func findUsed(ctx context.Context, rep *repo.DirectRepository, used *sync.Map, gcDelete bool, excluded map[manifest.ID]bool) error {
+	if err := findInUseContentIDs(ctx, rep, used, excluded); err != nil {
+		return errors.Wrap(err, "unable to find in-use content ID")
+	}
+
+	if err := findInUseCatalogContentIDs(ctx, rep, used, gcDelete); err != nil {
+		return errors.Wrap(err, "unable to find in-use catalog content ID")
+	}
+
+	if err := findInUseUploadHintsContentIDs(ctx, rep, used); err != nil {
+		return errors.Wrap(err, "unable to find in-use upload hints content ID")
+	}
+
+	return nil
+}
+
+// FindChanges returns contents that snapshot GC would mark as deleted and deleted contents
+// that it would undelete, without making any changes to the repository. Snapshots with excluded IDs
+// are treated as if they had already been deleted.
+func FindChanges(ctx context.Context, rep *repo.DirectRepository, params maintenance.SnapshotGCParams, excluded map[manifest.ID]bool) (toDelete, toUndelete []content.Info, err error) {
+	var used sync.Map
+
+	if err := findUsed(ctx, rep, &used, false, excluded); err != nil {
+		return nil, nil, err
+	}
+
+	log(ctx).Infof("looking for unreferenced contents")
+
+	if err := rep.Content.IterateContents(ctx, content.IterateOptions{IncludeDeleted: true}, func(ci content.Info) error {
+		switch classify(ctx, rep, params, &used, ci) {
+		case actionDelete:
+			toDelete = append(toDelete, ci)
+		case actionUndelete:
+			toUndelete = append(toUndelete, ci)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, nil, errors.Wrap(err, "error iterating contents")
+	}
+
+	return toDelete, toUndelete, nil
+}
+
 func runInternal(ctx context.Context, rep *repo.DirectRepository, params maintenance.SnapshotGCParams, gcDelete bool, st *Stats) error {
 	var (
 		used sync.Map
@@ -92,8 +280,8 @@ func runInternal(ctx context.Context, rep *repo.DirectRepository, params mainten
 		unused, inUse, system, tooRecent, undeleted stats.CountSum
 	)
 
-	if err := findInUseContentIDs(ctx, rep, &used); err != nil {
-		return errors.Wrap(err, "unable to find in-use content ID")
+	if err := findUsed(ctx, rep, &used, gcDelete, nil); err != nil {
+		return err
 	}
 
 	log(ctx).Infof("looking for unreferenced contents")
@@ -101,29 +289,30 @@ func runInternal(ctx context.Context, rep *repo.DirectRepository, params mainten
 	// Ensure that the iteration includes deleted contents, so those can be
 	// undeleted (recovered).
 	err := rep.Content.IterateContents(ctx, content.IterateOptions{IncludeDeleted: true}, func(ci content.Info) error {
-		if manifest.ContentPrefix == ci.ID.Prefix() {
+		switch classify(ctx, rep, params, &used, ci) {
+		case actionSystem:
 			system.Add(int64(ci.Length))
 			return nil
-		}
 
-		if _, ok := used.Load(ci.ID); ok {
-			if ci.Deleted {
-				if err := rep.Content.UndeleteContent(ctx, ci.ID); err != nil {
-					return errors.Wrapf(err, "Could not undelete referenced content: %v", ci)
-				}
-				undeleted.Add(int64(ci.Length))
+		case actionUndelete:
+			if err := rep.Content.UndeleteContent(ctx, ci.ID); err != nil {
+				return errors.Wrapf(err, "Could not undelete referenced content: %v", ci)
 			}
+			undeleted.Add(int64(ci.Length))
+			inUse.Add(int64(ci.Length))
+			return nil
 
+		case actionInUse:
 			inUse.Add(int64(ci.Length))
 			return nil
-		}
 
-		if rep.Time().Sub(ci.Timestamp()) < params.MinContentAge {
-			log(ctx).Debugf("recent unreferenced content %v (%v bytes, modified %v)", ci.ID, ci.Length, ci.Timestamp())
+		case actionTooRecent:
 			tooRecent.Add(int64(ci.Length))
 			return nil
 		}
 
+		// the content is unreferenced and old enough to be deleted.
+
 		log(ctx).Debugf("unreferenced %v (%v bytes, modified %v)", ci.ID, ci.Length, ci.Timestamp())
 		cnt, totalSize := unused.Add(int64(ci.Length))
 
@@ -0,0 +1,275 @@
+// Package snapshotgroup manages groups of snapshots of multiple sources that belong to a single logical
+// application, such as its configuration, data and database dump.
+//
+// Member snapshots of a group are saved before the group manifest. If any of them can't be saved, member
+// snapshots saved so far are deleted, so that incomplete groups don't remain in the repository.
+package snapshotgroup
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/logging"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+)
+
+var log = logging.GetContextLoggerFunc("kopia/snapshotgroup")
+
+// ManifestType is the value of the "type" label for snapshot group manifests.
+const ManifestType = "snapshot-group"
+
+const (
+	nameLabel     = "name"
+	usernameLabel = "username"
+	hostnameLabel = "hostname"
+)
+
+// ErrGroupNotFound is returned when a snapshot group is not found.
+var ErrGroupNotFound = errors.New("snapshot group not found")
+
+// Member describes a single snapshot belonging to a group.
+type Member struct {
+	Source     snapshot.SourceInfo `json:"source"`
+	SnapshotID manifest.ID         `json:"snapshotID"`
+}
+
+// Group describes snapshots of multiple sources created together.
+type Group struct {
+	ID          manifest.ID `json:"-"`
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	StartTime   time.Time   `json:"startTime"`
+	EndTime     time.Time   `json:"endTime"`
+	Members     []Member    `json:"members"`
+}
+
+func labels(name string) map[string]string {
+	l := map[string]string{
+		manifest.TypeLabelKey: ManifestType,
+	}
+
+	if name != "" {
+		l[nameLabel] = name
+	}
+
+	return l
+}
+
+// ownerLabels returns labels of the group manifest, which is owned by the user owning its members,
+// so that it's visible to the user when accessing the repository through the API server.
+func ownerLabels(name string, owner snapshot.SourceInfo) map[string]string {
+	l := labels(name)
+	l[usernameLabel] = owner.UserName
+	l[hostnameLabel] = owner.Host
+
+	return l
+}
+
+// Commit saves the provided complete snapshots as members of a new group with the provided name and flushes
+// the repository. All members must belong to the same user and host. On failure, members saved so far
+// are deleted.
+func Commit(ctx context.Context, rep repo.Repository, name, description string, members []*snapshot.Manifest) (*Group, error) {
+	if name == "" {
+		return nil, errors.New("missing group name")
+	}
+
+	if len(members) == 0 {
+		return nil, errors.New("no group members")
+	}
+
+	seen := map[snapshot.SourceInfo]bool{}
+
+	for _, m := range members {
+		if m.IncompleteReason != "" {
+			return nil, errors.Errorf("snapshot of %v is incomplete: %v", m.Source, m.IncompleteReason)
+		}
+
+		if seen[m.Source] {
+			return nil, errors.Errorf("duplicate group member %v", m.Source)
+		}
+
+		if m.Source.UserName != members[0].Source.UserName || m.Source.Host != members[0].Source.Host {
+			return nil, errors.Errorf("group member %v belongs to a different user than %v", m.Source, members[0].Source)
+		}
+
+		seen[m.Source] = true
+	}
+
+	g := &Group{
+		Name:        name,
+		Description: description,
+		StartTime:   members[0].StartTime,
+		EndTime:     members[0].EndTime,
+	}
+
+	if err := saveGroup(ctx, rep, g, members); err != nil {
+		deleteMembers(ctx, rep, g)
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// saveGroup saves members and the group, on failure the group contains members saved so far.
+func saveGroup(ctx context.Context, rep repo.Repository, g *Group, members []*snapshot.Manifest) error {
+	for _, m := range members {
+		m.Group = g.Name
+
+		id, err := snapshot.SaveSnapshot(ctx, rep, m)
+		if err != nil {
+			return errors.Wrapf(err, "unable to save snapshot of %v", m.Source)
+		}
+
+		g.Members = append(g.Members, Member{Source: m.Source, SnapshotID: id})
+
+		if m.StartTime.Before(g.StartTime) {
+			g.StartTime = m.StartTime
+		}
+
+		if m.EndTime.After(g.EndTime) {
+			g.EndTime = m.EndTime
+		}
+	}
+
+	id, err := rep.PutManifest(ctx, ownerLabels(g.Name, members[0].Source), g)
+	if err != nil {
+		return errors.Wrap(err, "unable to save group manifest")
+	}
+
+	g.ID = id
+
+	if err := rep.Flush(ctx); err != nil {
+		return errors.Wrap(err, "unable to flush repository")
+	}
+
+	return nil
+}
+
+// deleteMembers deletes member snapshots and the manifest of a group that could not be saved.
+func deleteMembers(ctx context.Context, rep repo.Repository, g *Group) {
+	ids := []manifest.ID{g.ID}
+
+	for _, m := range g.Members {
+		ids = append(ids, m.SnapshotID)
+	}
+
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+
+		if err := rep.DeleteManifest(ctx, id); err != nil {
+			log(ctx).Errorf("unable to delete manifest %v of incomplete snapshot group %v: %v", id, g.Name, err)
+		}
+	}
+
+	if err := rep.Flush(ctx); err != nil {
+		log(ctx).Errorf("unable to flush repository after deleting incomplete snapshot group %v: %v", g.Name, err)
+	}
+}
+
+// List returns snapshot groups with the provided name or all groups if the name is empty, sorted by start time.
+func List(ctx context.Context, rep repo.Repository, name string) ([]*Group, error) {
+	entries, err := rep.FindManifests(ctx, labels(name))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to find snapshot group manifests")
+	}
+
+	var result []*Group
+
+	for _, e := range entries {
+		g, err := Load(ctx, rep, e.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, g)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartTime.Before(result[j].StartTime)
+	})
+
+	return result, nil
+}
+
+// Load loads the snapshot group with the provided manifest ID.
+func Load(ctx context.Context, rep repo.Repository, id manifest.ID) (*Group, error) {
+	g := &Group{}
+
+	em, err := rep.GetManifest(ctx, id, g)
+	if err != nil {
+		if errors.Is(err, manifest.ErrNotFound) {
+			return nil, ErrGroupNotFound
+		}
+
+		return nil, errors.Wrap(err, "unable to load snapshot group manifest")
+	}
+
+	if em.Labels[manifest.TypeLabelKey] != ManifestType {
+		return nil, errors.Errorf("manifest is not a snapshot group")
+	}
+
+	g.ID = id
+
+	return g, nil
+}
+
+// Find returns the group with the provided manifest ID or the latest group with the provided name.
+func Find(ctx context.Context, rep repo.Repository, idOrName string) (*Group, error) {
+	if g, err := Load(ctx, rep, manifest.ID(idOrName)); err == nil {
+		return g, nil
+	}
+
+	groups, err := List(ctx, rep, idOrName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(groups) == 0 {
+		return nil, ErrGroupNotFound
+	}
+
+	return groups[len(groups)-1], nil
+}
+
+// Snapshots loads snapshot manifests of all members of the group.
+func (g *Group) Snapshots(ctx context.Context, rep repo.Repository) ([]*snapshot.Manifest, error) {
+	var result []*snapshot.Manifest
+
+	for _, m := range g.Members {
+		man, err := snapshot.LoadSnapshot(ctx, rep, m.SnapshotID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to load snapshot of %v", m.Source)
+		}
+
+		result = append(result, man)
+	}
+
+	return result, nil
+}
+
+// MemberDirectoryName returns the name of the directory in which the member is restored, which is
+// derived from the path of its source.
+func MemberDirectoryName(src snapshot.SourceInfo) string {
+	name := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':':
+			return '_'
+		default:
+			return r
+		}
+	}, strings.TrimLeft(src.Path, `/\`))
+
+	if name == "" {
+		return "_"
+	}
+
+	return name
+}
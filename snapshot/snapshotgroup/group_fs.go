@@ -0,0 +1,90 @@
+package snapshotgroup
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+// groupDirectory is a virtual directory containing root entries of all members of a group.
+type groupDirectory struct {
+	g       *Group
+	entries fs.Entries
+}
+
+func (d *groupDirectory) IsDir() bool {
+	return true
+}
+
+func (d *groupDirectory) Name() string {
+	return d.g.Name
+}
+
+func (d *groupDirectory) ModTime() time.Time {
+	return d.g.EndTime
+}
+
+func (d *groupDirectory) Mode() os.FileMode {
+	return 0o755 | os.ModeDir // nolint:gomnd
+}
+
+func (d *groupDirectory) Size() int64 {
+	return 0
+}
+
+func (d *groupDirectory) Owner() fs.OwnerInfo {
+	return fs.OwnerInfo{}
+}
+
+func (d *groupDirectory) Device() fs.DeviceInfo {
+	return fs.DeviceInfo{}
+}
+
+func (d *groupDirectory) Sys() interface{} {
+	return nil
+}
+
+func (d *groupDirectory) Child(ctx context.Context, name string) (fs.Entry, error) {
+	return fs.ReadDirAndFindChild(ctx, d, name)
+}
+
+func (d *groupDirectory) Readdir(ctx context.Context) (fs.Entries, error) {
+	return append(fs.Entries(nil), d.entries...), nil
+}
+
+// RootEntry returns a directory that contains root entries of snapshots of all group members, each in
+// a subdirectory named after the path of its source, which allows the group to be restored as a single unit.
+func (g *Group) RootEntry(ctx context.Context, rep repo.Repository) (fs.Directory, error) {
+	mans, err := g.Snapshots(ctx, rep)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &groupDirectory{g: g}
+
+	for _, man := range mans {
+		if man.RootEntry == nil {
+			return nil, errors.Errorf("snapshot of %v has no root entry", man.Source)
+		}
+
+		de := *man.RootEntry
+		de.Name = MemberDirectoryName(man.Source)
+
+		e, err := snapshotfs.EntryFromDirEntry(rep, &de)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to get root entry of %v", man.Source)
+		}
+
+		d.entries = append(d.entries, e)
+	}
+
+	d.entries.Sort()
+
+	return d, nil
+}
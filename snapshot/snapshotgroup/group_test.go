@@ -0,0 +1,211 @@
+package snapshotgroup_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+	"github.com/kopia/kopia/snapshot/snapshotgroup"
+)
+
+const defaultPermissions = 0o777
+
+func TestGroupCommit(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	other := env.MustOpenAnother(t)
+
+	srcConfig := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/etc/app"}
+	srcData := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/var/lib/app"}
+
+	configDir := mockfs.NewDirectory()
+	configDir.AddFile("app.conf", []byte{1, 2, 3}, defaultPermissions)
+
+	dataDir := mockfs.NewDirectory()
+	dataDir.AddDir("db", defaultPermissions).AddFile("data", []byte{4, 5, 6, 7}, defaultPermissions)
+
+	m1 := mustUpload(ctx, t, env.Repository, configDir, srcConfig)
+	m2 := mustUpload(ctx, t, env.Repository, dataDir, srcData)
+
+	// uploaded snapshots are not visible until the group is committed.
+	require.NoError(t, env.Repository.Flush(ctx))
+	require.NoError(t, other.Refresh(ctx))
+	requireSnapshotCount(ctx, t, other, srcConfig, 0)
+
+	g, err := snapshotgroup.Commit(ctx, env.Repository, "app", "nightly", []*snapshot.Manifest{m1, m2})
+	require.NoError(t, err)
+	require.Len(t, g.Members, 2)
+
+	require.NoError(t, other.Refresh(ctx))
+	requireSnapshotCount(ctx, t, other, srcConfig, 1)
+	requireSnapshotCount(ctx, t, other, srcData, 1)
+
+	snaps, err := snapshot.ListSnapshots(ctx, other, srcData)
+	require.NoError(t, err)
+	require.Equal(t, "app", snaps[0].Group)
+
+	byName, err := snapshotgroup.Find(ctx, other, "app")
+	require.NoError(t, err)
+	require.Equal(t, g.ID, byName.ID)
+	require.Equal(t, g.Members, byName.Members)
+
+	byID, err := snapshotgroup.Find(ctx, other, string(g.ID))
+	require.NoError(t, err)
+	require.Equal(t, "nightly", byID.Description)
+
+	_, err = snapshotgroup.Find(ctx, other, "no-such-group")
+	require.True(t, errors.Is(err, snapshotgroup.ErrGroupNotFound))
+
+	// the group can be restored as a single directory.
+	root, err := byName.RootEntry(ctx, other)
+	require.NoError(t, err)
+
+	entries, err := root.Readdir(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "etc_app", entries[0].Name())
+	require.Equal(t, "var_lib_app", entries[1].Name())
+
+	db, err := snapshotfs.GetNestedEntry(ctx, root, []string{"var_lib_app", "db", "data"})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), db.Size())
+}
+
+func TestGroupCommitIncompleteMember(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	src1 := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src1"}
+	src2 := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src2"}
+
+	dir := mockfs.NewDirectory()
+	dir.AddFile("f1", []byte{1, 2, 3}, defaultPermissions)
+
+	m1 := mustUpload(ctx, t, env.Repository, dir, src1)
+	m2 := mustUpload(ctx, t, env.Repository, dir, src2)
+	m2.IncompleteReason = snapshotfs.IncompleteReasonCanceled
+
+	_, err := snapshotgroup.Commit(ctx, env.Repository, "app", "", []*snapshot.Manifest{m1, m2})
+	require.Error(t, err)
+
+	// no member is saved when any of them fails.
+	requireSnapshotCount(ctx, t, env.Repository, src1, 0)
+	requireSnapshotCount(ctx, t, env.Repository, src2, 0)
+
+	groups, err := snapshotgroup.List(ctx, env.Repository, "")
+	require.NoError(t, err)
+	require.Empty(t, groups)
+}
+
+// failingGroupRepository fails to save snapshot group manifests.
+type failingGroupRepository struct {
+	repo.Repository
+}
+
+func (r failingGroupRepository) PutManifest(ctx context.Context, labels map[string]string, payload interface{}) (manifest.ID, error) {
+	if labels[manifest.TypeLabelKey] == snapshotgroup.ManifestType {
+		return "", errors.New("some error")
+	}
+
+	return r.Repository.PutManifest(ctx, labels, payload)
+}
+
+func TestGroupCommitFailureDeletesMembers(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	other := env.MustOpenAnother(t)
+
+	src1 := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src1"}
+	src2 := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src2"}
+
+	dir := mockfs.NewDirectory()
+	dir.AddFile("f1", []byte{1, 2, 3}, defaultPermissions)
+
+	m1 := mustUpload(ctx, t, env.Repository, dir, src1)
+	m2 := mustUpload(ctx, t, env.Repository, dir, src2)
+
+	_, err := snapshotgroup.Commit(ctx, failingGroupRepository{env.Repository}, "app", "", []*snapshot.Manifest{m1, m2})
+	require.Error(t, err)
+
+	// members saved before the failure are deleted.
+	requireSnapshotCount(ctx, t, env.Repository, src1, 0)
+	requireSnapshotCount(ctx, t, env.Repository, src2, 0)
+
+	require.NoError(t, env.Repository.Flush(ctx))
+	require.NoError(t, other.Refresh(ctx))
+	requireSnapshotCount(ctx, t, other, src1, 0)
+	requireSnapshotCount(ctx, t, other, src2, 0)
+}
+
+func TestGroupCommitDifferentUsers(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	src1 := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src1"}
+	src2 := snapshot.SourceInfo{Host: "host", UserName: "another-user", Path: "/src2"}
+
+	dir := mockfs.NewDirectory()
+	dir.AddFile("f1", []byte{1, 2, 3}, defaultPermissions)
+
+	m1 := mustUpload(ctx, t, env.Repository, dir, src1)
+	m2 := mustUpload(ctx, t, env.Repository, dir, src2)
+
+	_, err := snapshotgroup.Commit(ctx, env.Repository, "app", "", []*snapshot.Manifest{m1, m2})
+	require.Error(t, err)
+}
+
+func TestMemberDirectoryName(t *testing.T) {
+	cases := map[string]string{
+		"/etc/app":         "etc_app",
+		`C:\Users\me\Docs`: "C__Users_me_Docs",
+		"/":                "_",
+	}
+
+	for path, want := range cases {
+		require.Equal(t, want, snapshotgroup.MemberDirectoryName(snapshot.SourceInfo{Path: path}), path)
+	}
+}
+
+func mustUpload(ctx context.Context, t *testing.T, rep repo.Repository, source *mockfs.Directory, si snapshot.SourceInfo) *snapshot.Manifest {
+	t.Helper()
+
+	policyTree, err := policy.TreeForSource(ctx, rep, si)
+	require.NoError(t, err)
+
+	man, err := snapshotfs.NewUploader(rep).Upload(ctx, source, policyTree, si)
+	require.NoError(t, err)
+
+	return man
+}
+
+func requireSnapshotCount(ctx context.Context, t *testing.T, rep repo.Repository, si snapshot.SourceInfo, want int) {
+	t.Helper()
+
+	snaps, err := snapshot.ListSnapshots(ctx, rep, si)
+	require.NoError(t, err)
+	require.Len(t, snaps, want)
+}
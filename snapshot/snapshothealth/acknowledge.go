@@ -0,0 +1,77 @@
+package snapshothealth
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+)
+
+// ManifestType is the value of the "type" label for manifests acknowledging anomalies of snapshots.
+const ManifestType = "anomalyack"
+
+const snapshotIDLabel = "snapshotID"
+
+// Acknowledgement records that anomalies of a snapshot were reviewed and are expected.
+type Acknowledgement struct {
+	SnapshotID     manifest.ID         `json:"snapshotID"`
+	Source         snapshot.SourceInfo `json:"source"`
+	AcknowledgedBy string              `json:"acknowledgedBy"`
+	Since          time.Time           `json:"since"`
+}
+
+func labels(snapshotID manifest.ID) map[string]string {
+	l := map[string]string{
+		manifest.TypeLabelKey: ManifestType,
+	}
+
+	if snapshotID != "" {
+		l[snapshotIDLabel] = string(snapshotID)
+	}
+
+	return l
+}
+
+// Acknowledge marks anomalies of the provided snapshot as expected, which stops them from
+// preventing expiration of earlier snapshots.
+func Acknowledge(ctx context.Context, rep repo.Repository, m *snapshot.Manifest) error {
+	co := rep.ClientOptions()
+
+	a := &Acknowledgement{
+		SnapshotID:     m.ID,
+		Source:         m.Source,
+		AcknowledgedBy: co.Username + "@" + co.Hostname,
+		Since:          rep.Time(),
+	}
+
+	// acknowledgements are owned by the user of the snapshot, which makes them visible to its API clients.
+	l := labels(m.ID)
+	l["username"] = m.Source.UserName
+	l["hostname"] = m.Source.Host
+
+	if _, err := rep.PutManifest(ctx, l, a); err != nil {
+		return errors.Wrap(err, "unable to save anomaly acknowledgement manifest")
+	}
+
+	return nil
+}
+
+// AcknowledgedSnapshotIDs returns the set of IDs of snapshots whose anomalies were acknowledged.
+func AcknowledgedSnapshotIDs(ctx context.Context, rep repo.Repository) (map[manifest.ID]bool, error) {
+	entries, err := rep.FindManifests(ctx, labels(""))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to find anomaly acknowledgement manifests")
+	}
+
+	result := map[manifest.ID]bool{}
+
+	for _, e := range entries {
+		result[manifest.ID(e.Labels[snapshotIDLabel])] = true
+	}
+
+	return result, nil
+}
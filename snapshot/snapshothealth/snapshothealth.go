@@ -0,0 +1,279 @@
+// Package snapshothealth detects anomalies of snapshots, such as mass deletion or encryption of files,
+// by comparing statistics of each snapshot against the recent history of its source.
+package snapshothealth
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/snapshot"
+)
+
+// Kinds of detected anomalies.
+const (
+	AnomalyFileCountDrop   = "file-count-drop"
+	AnomalySizeDrop        = "size-drop"
+	AnomalyChangedFiles    = "changed-files"
+	AnomalyEntropyIncrease = "entropy-increase"
+	AnomalyExtensionChurn  = "extension-churn"
+)
+
+// MaxScore is the health score of a snapshot consistent with the history of its source.
+const MaxScore = 100
+
+const (
+	// maxHistorySnapshots is the number of most recent complete snapshots forming the baseline.
+	maxHistorySnapshots = 10
+
+	// minHistorySnapshots is the minimum number of previous snapshots needed to evaluate a snapshot.
+	minHistorySnapshots = 3
+
+	// minBaselineFiles is the minimum number of files in the baseline, tiny sources change too erratically.
+	minBaselineFiles = 100
+
+	// minEntropySampledFiles is the minimum number of sampled files needed to compare entropy.
+	minEntropySampledFiles = 20
+)
+
+// Options specifies thresholds of anomaly detection in percent, zero disables the corresponding check.
+type Options struct {
+	// MaxFileCountDropPercent is the maximum decrease of the number of files compared to the baseline.
+	MaxFileCountDropPercent int
+
+	// MaxSizeDropPercent is the maximum decrease of the total size of files compared to the baseline.
+	MaxSizeDropPercent int
+
+	// MaxChangedFilesPercent is the maximum percentage of files that were changed since the previous snapshot.
+	MaxChangedFilesPercent int
+
+	// MaxHighEntropyIncreasePercent is the maximum increase of the percentage of changed files whose contents
+	// look random, which is typical for files encrypted by ransomware.
+	MaxHighEntropyIncreasePercent int
+
+	// MaxExtensionChangePercent is the maximum increase of the percentage of files renamed to a different
+	// extension, which is typical for files encrypted by ransomware.
+	MaxExtensionChangePercent int
+}
+
+// Baseline describes the usual state of a source, each value is the median of its recent snapshots.
+type Baseline struct {
+	SnapshotCount       int     `json:"snapshotCount"`
+	FileCount           float64 `json:"fileCount"`
+	TotalSize           float64 `json:"totalSize"`
+	ChangedFilesPercent float64 `json:"changedFilesPercent"`
+
+	// HighEntropyPercent is only valid if EntropySnapshotCount is non-zero, snapshots created
+	// by older versions of kopia don't have entropy statistics.
+	HighEntropyPercent   float64 `json:"highEntropyPercent"`
+	EntropySnapshotCount int     `json:"entropySnapshotCount"`
+
+	ExtensionChangedPercent float64 `json:"extensionChangedPercent"`
+}
+
+// Report describes health of a single snapshot.
+type Report struct {
+	// Score ranges from 0 to MaxScore, where MaxScore means the snapshot is consistent with the history of its source.
+	Score int `json:"score"`
+
+	// Baseline is nil if the history of the source is too short to evaluate the snapshot.
+	Baseline  *Baseline          `json:"baseline,omitempty"`
+	Anomalies []snapshot.Anomaly `json:"anomalies,omitempty"`
+}
+
+// metrics are values of a single snapshot compared against the baseline.
+type metrics struct {
+	fileCount           float64
+	totalSize           float64
+	changedFilesPercent float64
+	highEntropyPercent  float64
+	hasEntropy          bool
+
+	extensionChangedPercent float64
+}
+
+func snapshotMetrics(m *snapshot.Manifest) metrics {
+	var res metrics
+
+	if m.RootEntry != nil && m.RootEntry.DirSummary != nil {
+		res.fileCount = float64(m.RootEntry.DirSummary.TotalFileCount)
+		res.totalSize = float64(m.RootEntry.DirSummary.TotalFileSize)
+	} else {
+		res.fileCount = float64(m.Stats.CachedFiles + m.Stats.NonCachedFiles)
+		res.totalSize = float64(m.Stats.TotalFileSize)
+	}
+
+	if res.fileCount > 0 {
+		res.changedFilesPercent = 100 * float64(m.Stats.NonCachedFiles) / res.fileCount
+		res.extensionChangedPercent = 100 * float64(m.Stats.ExtensionChangedFiles) / res.fileCount
+	}
+
+	if m.Stats.EntropySampledFiles >= minEntropySampledFiles {
+		res.highEntropyPercent = 100 * float64(m.Stats.HighEntropyFiles) / float64(m.Stats.EntropySampledFiles)
+		res.hasEntropy = true
+	}
+
+	return res
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sort.Float64s(values)
+
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// computeBaseline returns the baseline of the most recent complete snapshots taken before the provided one
+// or nil if there are not enough of them.
+func computeBaseline(m *snapshot.Manifest, history []*snapshot.Manifest) *Baseline {
+	var previous []*snapshot.Manifest
+
+	for _, h := range history {
+		if h.ID == m.ID || h.IncompleteReason != "" || !h.StartTime.Before(m.StartTime) {
+			continue
+		}
+
+		previous = append(previous, h)
+	}
+
+	previous = snapshot.SortByTime(previous, true)
+	if len(previous) > maxHistorySnapshots {
+		previous = previous[0:maxHistorySnapshots]
+	}
+
+	if len(previous) < minHistorySnapshots {
+		return nil
+	}
+
+	var fileCounts, sizes, changed, entropy, extensionChanged []float64
+
+	for _, h := range previous {
+		hm := snapshotMetrics(h)
+
+		fileCounts = append(fileCounts, hm.fileCount)
+		sizes = append(sizes, hm.totalSize)
+		changed = append(changed, hm.changedFilesPercent)
+		extensionChanged = append(extensionChanged, hm.extensionChangedPercent)
+
+		if hm.hasEntropy {
+			entropy = append(entropy, hm.highEntropyPercent)
+		}
+	}
+
+	b := &Baseline{
+		SnapshotCount:       len(previous),
+		FileCount:           median(fileCounts),
+		TotalSize:           median(sizes),
+		ChangedFilesPercent: median(changed),
+
+		ExtensionChangedPercent: median(extensionChanged),
+	}
+
+	if len(entropy) >= minHistorySnapshots {
+		b.EntropySnapshotCount = len(entropy)
+		b.HighEntropyPercent = median(entropy)
+	}
+
+	return b
+}
+
+// Evaluate compares the provided snapshot against the history of its source and returns its health report.
+func Evaluate(m *snapshot.Manifest, history []*snapshot.Manifest, opt Options) *Report {
+	r := &Report{
+		Score:    MaxScore,
+		Baseline: computeBaseline(m, history),
+	}
+
+	if r.Baseline == nil || r.Baseline.FileCount < minBaselineFiles {
+		return r
+	}
+
+	cur := snapshotMetrics(m)
+	b := r.Baseline
+
+	// worst is the highest ratio of deviation to its threshold, 1.0 or more means the threshold was exceeded.
+	var worst float64
+
+	check := func(kind string, deviation float64, threshold int, desc string) {
+		if threshold <= 0 || deviation <= 0 {
+			return
+		}
+
+		ratio := deviation / float64(threshold)
+		if ratio > worst {
+			worst = ratio
+		}
+
+		if ratio >= 1 {
+			r.Anomalies = append(r.Anomalies, snapshot.Anomaly{Kind: kind, Description: desc})
+		}
+	}
+
+	if drop := 100 * (b.FileCount - cur.fileCount) / b.FileCount; drop > 0 {
+		check(AnomalyFileCountDrop, drop, opt.MaxFileCountDropPercent,
+			fmt.Sprintf("number of files dropped by %.0f%% (from %.0f to %.0f)", drop, b.FileCount, cur.fileCount))
+	}
+
+	if b.TotalSize > 0 {
+		if drop := 100 * (b.TotalSize - cur.totalSize) / b.TotalSize; drop > 0 {
+			check(AnomalySizeDrop, drop, opt.MaxSizeDropPercent,
+				fmt.Sprintf("total size of files dropped by %.0f%% (from %.0f to %.0f bytes)", drop, b.TotalSize, cur.totalSize))
+		}
+	}
+
+	// sources where most files usually change are not checked for change rate.
+	if opt.MaxChangedFilesPercent > 0 && b.ChangedFilesPercent < float64(opt.MaxChangedFilesPercent)/2 {
+		check(AnomalyChangedFiles, cur.changedFilesPercent, opt.MaxChangedFilesPercent,
+			fmt.Sprintf("%.0f%% of files changed (usually %.0f%%)", cur.changedFilesPercent, b.ChangedFilesPercent))
+	}
+
+	if cur.hasEntropy && b.EntropySnapshotCount > 0 {
+		increase := cur.highEntropyPercent - b.HighEntropyPercent
+		check(AnomalyEntropyIncrease, increase, opt.MaxHighEntropyIncreasePercent,
+			fmt.Sprintf("%.0f%% of changed files look encrypted or compressed (usually %.0f%%)", cur.highEntropyPercent, b.HighEntropyPercent))
+	}
+
+	if increase := cur.extensionChangedPercent - b.ExtensionChangedPercent; increase > 0 {
+		check(AnomalyExtensionChurn, increase, opt.MaxExtensionChangePercent,
+			fmt.Sprintf("%.0f%% of files were renamed to a different extension (usually %.0f%%)", cur.extensionChangedPercent, b.ExtensionChangedPercent))
+	}
+
+	if worst > 1 {
+		worst = 1
+	}
+
+	r.Score = MaxScore - int(worst*MaxScore)
+
+	return r
+}
+
+// IsRansomwareIndicator returns true if the provided kind of anomaly is typical for files being
+// encrypted by ransomware, as opposed to being deleted or modified in bulk.
+func IsRansomwareIndicator(kind string) bool {
+	return kind == AnomalyEntropyIncrease || kind == AnomalyExtensionChurn
+}
+
+// DetectAnomalies evaluates the provided snapshot against the existing snapshots of its source
+// and records detected anomalies in the manifest.
+func DetectAnomalies(ctx context.Context, rep repo.Repository, m *snapshot.Manifest, opt Options) (*Report, error) {
+	history, err := snapshot.ListSnapshots(ctx, rep, m.Source)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list previous snapshots")
+	}
+
+	r := Evaluate(m, history, opt)
+	m.Anomalies = r.Anomalies
+
+	return r, nil
+}
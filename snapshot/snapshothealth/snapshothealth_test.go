@@ -0,0 +1,207 @@
+package snapshothealth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+)
+
+var defaultTestOptions = Options{
+	MaxFileCountDropPercent:       50,
+	MaxSizeDropPercent:            50,
+	MaxChangedFilesPercent:        80,
+	MaxHighEntropyIncreasePercent: 50,
+	MaxExtensionChangePercent:     20,
+}
+
+func newTestManifest(n int, files, size int64, changed, sampled, highEntropy int32) *snapshot.Manifest {
+	return &snapshot.Manifest{
+		ID:        manifest.ID(string(rune('a' + n))),
+		StartTime: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(n) * time.Hour),
+		Stats: snapshot.Stats{
+			NonCachedFiles:      changed,
+			CachedFiles:         int32(files) - changed,
+			EntropySampledFiles: sampled,
+			HighEntropyFiles:    highEntropy,
+		},
+		RootEntry: &snapshot.DirEntry{
+			DirSummary: &fs.DirectorySummary{
+				TotalFileCount: files,
+				TotalFileSize:  size,
+			},
+		},
+	}
+}
+
+func withExtensionChanges(m *snapshot.Manifest, n int32) *snapshot.Manifest {
+	m.Stats.ExtensionChangedFiles = n
+	return m
+}
+
+func testHistory() []*snapshot.Manifest {
+	return []*snapshot.Manifest{
+		newTestManifest(0, 1000, 1e6, 10, 10, 1),
+		newTestManifest(1, 1010, 1.01e6, 20, 20, 2),
+		newTestManifest(2, 1020, 1.02e6, 30, 30, 3),
+		newTestManifest(3, 1030, 1.03e6, 40, 40, 4),
+	}
+}
+
+func anomalyKinds(r *Report) []string {
+	var kinds []string
+
+	for _, a := range r.Anomalies {
+		kinds = append(kinds, a.Kind)
+	}
+
+	return kinds
+}
+
+func TestEvaluate(t *testing.T) {
+	cases := []struct {
+		desc      string
+		m         *snapshot.Manifest
+		wantKinds []string
+		minScore  int
+		maxScore  int
+	}{
+		{
+			desc:     "healthy",
+			m:        newTestManifest(10, 1040, 1.04e6, 40, 40, 4),
+			minScore: 90,
+			maxScore: MaxScore,
+		},
+		{
+			desc:     "some files deleted",
+			m:        newTestManifest(10, 800, 0.8e6, 10, 10, 1),
+			minScore: 50,
+			maxScore: 70,
+		},
+		{
+			desc:      "mass deletion",
+			m:         newTestManifest(10, 100, 0.1e6, 10, 10, 1),
+			wantKinds: []string{AnomalyFileCountDrop, AnomalySizeDrop},
+			maxScore:  0,
+		},
+		{
+			desc:      "ransomware",
+			m:         newTestManifest(10, 1040, 1.1e6, 1000, 1000, 950),
+			wantKinds: []string{AnomalyChangedFiles, AnomalyEntropyIncrease},
+			maxScore:  0,
+		},
+		{
+			desc:      "entropy increase only",
+			m:         newTestManifest(10, 1040, 1.04e6, 100, 100, 80),
+			wantKinds: []string{AnomalyEntropyIncrease},
+			maxScore:  0,
+		},
+		{
+			desc:      "extension churn only",
+			m:         withExtensionChanges(newTestManifest(10, 1040, 1.04e6, 300, 0, 0), 300),
+			wantKinds: []string{AnomalyExtensionChurn},
+			maxScore:  0,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		t.Run(tc.desc, func(t *testing.T) {
+			r := Evaluate(tc.m, testHistory(), defaultTestOptions)
+			require.NotNil(t, r.Baseline)
+			require.Equal(t, 4, r.Baseline.SnapshotCount)
+			require.Equal(t, tc.wantKinds, anomalyKinds(r))
+			require.GreaterOrEqual(t, r.Score, tc.minScore)
+			require.LessOrEqual(t, r.Score, tc.maxScore)
+		})
+	}
+}
+
+func TestEvaluateInsufficientHistory(t *testing.T) {
+	m := newTestManifest(10, 1, 1, 1, 0, 0)
+
+	// not enough snapshots
+	r := Evaluate(m, testHistory()[0:2], defaultTestOptions)
+	require.Nil(t, r.Baseline)
+	require.Equal(t, MaxScore, r.Score)
+	require.Empty(t, r.Anomalies)
+
+	// snapshots taken after the evaluated one and incomplete snapshots are ignored.
+	history := testHistory()
+	history[0].IncompleteReason = "canceled"
+	history[1].StartTime = m.StartTime.Add(time.Hour)
+
+	r = Evaluate(m, history, defaultTestOptions)
+	require.Nil(t, r.Baseline)
+
+	// tiny sources are not evaluated.
+	small := []*snapshot.Manifest{
+		newTestManifest(0, 10, 100, 1, 0, 0),
+		newTestManifest(1, 10, 100, 1, 0, 0),
+		newTestManifest(2, 10, 100, 1, 0, 0),
+	}
+
+	r = Evaluate(m, small, defaultTestOptions)
+	require.NotNil(t, r.Baseline)
+	require.Equal(t, MaxScore, r.Score)
+	require.Empty(t, r.Anomalies)
+}
+
+func TestEvaluateDisabledChecks(t *testing.T) {
+	r := Evaluate(newTestManifest(10, 100, 0.1e6, 1000, 1000, 950), testHistory(), Options{})
+	require.Empty(t, r.Anomalies)
+	require.Equal(t, MaxScore, r.Score)
+}
+
+func TestEvaluateWithoutEntropyHistory(t *testing.T) {
+	history := testHistory()
+	for _, h := range history {
+		h.Stats.EntropySampledFiles = 0
+		h.Stats.HighEntropyFiles = 0
+	}
+
+	// snapshots without entropy statistics don't trigger entropy anomaly.
+	r := Evaluate(newTestManifest(10, 1040, 1.04e6, 100, 100, 80), history, defaultTestOptions)
+	require.Empty(t, r.Anomalies)
+}
+
+func TestIsRansomwareIndicator(t *testing.T) {
+	require.True(t, IsRansomwareIndicator(AnomalyEntropyIncrease))
+	require.True(t, IsRansomwareIndicator(AnomalyExtensionChurn))
+	require.False(t, IsRansomwareIndicator(AnomalyFileCountDrop))
+	require.False(t, IsRansomwareIndicator(AnomalyChangedFiles))
+}
+
+func TestAcknowledgeOwnedBySnapshotUser(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	m := &snapshot.Manifest{
+		ID:     "snap1",
+		Source: snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/some/path"},
+	}
+
+	require.NoError(t, Acknowledge(ctx, env.Repository, m))
+
+	entries, err := env.Repository.FindManifests(ctx, map[string]string{
+		manifest.TypeLabelKey: ManifestType,
+		"username":            "user",
+		"hostname":            "host",
+	})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	acked, err := AcknowledgedSnapshotIDs(ctx, env.Repository)
+	require.NoError(t, err)
+	require.True(t, acked["snap1"])
+}
@@ -8,7 +8,12 @@
 
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/repo/maintenance"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot/snapshotbudget"
 	"github.com/kopia/kopia/snapshot/snapshotgc"
+	"github.com/kopia/kopia/snapshot/snapshotrestoretest"
+	"github.com/kopia/kopia/snapshot/snapshotscrub"
+	"github.com/kopia/kopia/snapshot/snapshotthin"
 )
 
 // Run runs the complete snapshot and repository maintenance.
@@ -20,13 +25,126 @@ func Run(ctx context.Context, rep repo.Repository, mode maintenance.Mode, force
 
 	return maintenance.RunExclusive(ctx, dr, mode, force,
 		func(runParams maintenance.RunParameters) error {
-			// run snapshot GC before full maintenance
+			// thin snapshot history, enforce storage budget and run snapshot GC before full maintenance
 			if runParams.Mode == maintenance.ModeFull {
+				if len(runParams.Params.Thinning.Rules) > 0 {
+					if _, err := snapshotthin.Run(ctx, dr, runParams.Params.Thinning); err != nil {
+						return errors.Wrap(err, "snapshot thinning failure")
+					}
+				}
+
+				if runParams.Params.StorageBudget.MaxBytes > 0 {
+					if _, err := snapshotbudget.Run(ctx, dr, runParams.Params.StorageBudget); err != nil {
+						return errors.Wrap(err, "storage budget failure")
+					}
+				}
+
 				if _, err := snapshotgc.Run(ctx, dr, runParams.Params.SnapshotGC, true); err != nil {
 					return errors.Wrap(err, "snapshot GC failure")
 				}
 			}
 
-			return maintenance.Run(ctx, runParams)
+			if err := maintenance.Run(ctx, runParams); err != nil {
+				return err
+			}
+
+			if err := maybeRunRestoreTest(ctx, dr, runParams.Params.RestoreTest); err != nil {
+				return err
+			}
+
+			return maybeRunContentVerification(ctx, dr, runParams.Params.ContentVerification)
 		})
 }
+
+// maybeRunRestoreTest runs the restore test if it's due and records the outcome in maintenance schedule.
+func maybeRunRestoreTest(ctx context.Context, dr *repo.DirectRepository, p maintenance.RestoreTestParams) error {
+	s, err := maintenance.GetSchedule(ctx, dr)
+	if err != nil {
+		return errors.Wrap(err, "unable to get schedule")
+	}
+
+	if !snapshotrestoretest.IsDue(p, s, dr.Time()) {
+		return nil
+	}
+
+	return maintenance.ReportRun(ctx, dr, snapshotrestoretest.RunType, func() error {
+		res, err := snapshotrestoretest.Run(ctx, dr, p)
+		if err != nil {
+			return errors.Wrap(err, "restore test failure")
+		}
+
+		return res.Err()
+	})
+}
+
+// maybeRunContentVerification verifies a fraction of contents if it's due and records the outcome in maintenance schedule.
+func maybeRunContentVerification(ctx context.Context, dr *repo.DirectRepository, p maintenance.ContentVerificationParams) error {
+	s, err := maintenance.GetSchedule(ctx, dr)
+	if err != nil {
+		return errors.Wrap(err, "unable to get schedule")
+	}
+
+	if !snapshotscrub.IsDue(p, s, dr.Time()) {
+		return nil
+	}
+
+	return maintenance.ReportRun(ctx, dr, snapshotscrub.RunType, func() error {
+		res, err := snapshotscrub.Run(ctx, dr, snapshotscrub.OptionsFromParams(p))
+		if err != nil {
+			return errors.Wrap(err, "content verification failure")
+		}
+
+		return res.Err()
+	})
+}
+
+// Preview computes changes that Run would make to the repository in the provided mode,
+// including snapshot thinning, storage budget enforcement and snapshot GC before full maintenance,
+// without making any changes.
+func Preview(ctx context.Context, rep repo.Repository, mode maintenance.Mode, maxSamples int) (*maintenance.Preview, error) {
+	dr, ok := rep.(*repo.DirectRepository)
+	if !ok {
+		return nil, errors.Errorf("maintenance preview is only supported for direct repositories")
+	}
+
+	opt := maintenance.PreviewOptions{
+		Mode:       mode,
+		MaxSamples: maxSamples,
+	}
+
+	if mode == maintenance.ModeFull {
+		p, err := maintenance.GetParams(ctx, dr)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to get maintenance params")
+		}
+
+		thin, err := snapshotthin.Plan(ctx, dr, p.Thinning)
+		if err != nil {
+			return nil, errors.Wrap(err, "snapshot thinning preview failure")
+		}
+
+		excluded := map[manifest.ID]bool{}
+
+		for _, t := range thin.Thinned {
+			excluded[t.Manifest.ID] = true
+			opt.ThinSnapshots = append(opt.ThinSnapshots, t.String())
+		}
+
+		budget, err := snapshotbudget.Plan(ctx, dr, p.StorageBudget, excluded)
+		if err != nil {
+			return nil, errors.Wrap(err, "storage budget preview failure")
+		}
+
+		for _, id := range budget.ExpiredIDs() {
+			excluded[id] = true
+			opt.ExpireSnapshots = append(opt.ExpireSnapshots, string(id))
+		}
+
+		opt.MarkDeleted, opt.Undelete, err = snapshotgc.FindChanges(ctx, dr, p.SnapshotGC, excluded)
+		if err != nil {
+			return nil, errors.Wrap(err, "snapshot GC preview failure")
+		}
+	}
+
+	return maintenance.PreviewRun(ctx, dr, opt)
+}
@@ -1,6 +1,7 @@
 package snapshotmaintenance_test
 
 import (
+	"sort"
 	"testing"
 	"time"
 
@@ -13,6 +14,7 @@
 	"github.com/kopia/kopia/internal/repotesting"
 	"github.com/kopia/kopia/internal/testlogging"
 	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
 	"github.com/kopia/kopia/repo/content"
 	"github.com/kopia/kopia/repo/maintenance"
 	"github.com/kopia/kopia/snapshot"
@@ -210,3 +212,86 @@ func mustSnapshot(t *testing.T, r repo.Repository, source fs.Entry, si snapshot.
 
 	return s1
 }
+
+func TestMaintenancePreview(t *testing.T) {
+	ctx := testlogging.Context(t)
+	th := newTestHarness(t)
+
+	th.sourceDir.AddDir("d1", defaultPermissions)
+	th.sourceDir.AddFile("d1/f1", []byte{1, 2, 3}, defaultPermissions)
+	th.sourceDir.AddFile("f2", []byte{4, 5, 6, 7}, defaultPermissions)
+
+	si := snapshot.SourceInfo{
+		Host:     "host",
+		UserName: "user",
+		Path:     "/foo",
+	}
+
+	s1 := mustSnapshot(t, th.Repository, th.sourceDir, si)
+	mustFlush(t, th.Repository)
+
+	require.NoError(t, th.Repository.Manifests.Delete(ctx, s1.ID))
+	mustFlush(t, th.Repository)
+
+	th.fakeTime.Advance(maintenance.DefaultParams().SnapshotGC.MinContentAge + time.Hour)
+
+	before := listPackBlobs(t, th.Repository)
+
+	p, err := snapshotmaintenance.Preview(ctx, th.Repository, maintenance.ModeFull, 1000)
+	require.NoError(t, err)
+	require.NotZero(t, p.ContentsToMarkDeleted.Count)
+	require.True(t, p.SafeDropTime.IsZero())
+	require.Zero(t, p.ContentsToDrop.Count)
+
+	// preview must not make any changes.
+	require.Equal(t, before, listPackBlobs(t, th.Repository))
+
+	// two snapshot GC cycles far enough apart are needed before deleted contents can be dropped.
+	require.NoError(t, snapshotmaintenance.Run(ctx, th.Repository, maintenance.ModeFull, true))
+	th.fakeTime.Advance(5 * time.Hour)
+	require.NoError(t, snapshotmaintenance.Run(ctx, th.Repository, maintenance.ModeFull, true))
+	th.fakeTime.Advance(5 * time.Hour)
+
+	before = listPackBlobs(t, th.Repository)
+
+	p, err = snapshotmaintenance.Preview(ctx, th.Repository, maintenance.ModeFull, 1000)
+	require.NoError(t, err)
+	require.False(t, p.SafeDropTime.IsZero())
+	require.NotZero(t, p.ContentsToRewrite.Count)
+	require.Equal(t, before, listPackBlobs(t, th.Repository))
+
+	// actual maintenance deletes exactly the blobs from the preview.
+	require.NoError(t, snapshotmaintenance.Run(ctx, th.Repository, maintenance.ModeFull, true))
+
+	after := map[blob.ID]bool{}
+	for _, id := range listPackBlobs(t, th.Repository) {
+		after[id] = true
+	}
+
+	var deleted []string
+
+	for _, id := range before {
+		if !after[id] {
+			deleted = append(deleted, string(id))
+		}
+	}
+
+	require.ElementsMatch(t, p.BlobsToDelete.Samples, deleted)
+}
+
+func listPackBlobs(t *testing.T, r *repo.DirectRepository) []blob.ID {
+	t.Helper()
+
+	var result []blob.ID
+
+	for _, prefix := range content.PackBlobIDPrefixes {
+		require.NoError(t, r.Blobs.ListBlobs(testlogging.Context(t), prefix, func(bm blob.Metadata) error {
+			result = append(result, bm.BlobID)
+			return nil
+		}))
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+
+	return result
+}
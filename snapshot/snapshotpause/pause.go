@@ -0,0 +1,123 @@
+// Package snapshotpause manages the repository-wide switch which pauses taking snapshots,
+// for example during storage maintenance windows.
+package snapshotpause
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/manifest"
+)
+
+// ManifestType is the value of the "type" label for pause manifests.
+const ManifestType = "pause"
+
+// State describes the pause of snapshots.
+type State struct {
+	Reason   string    `json:"reason,omitempty"`
+	PausedBy string    `json:"pausedBy"`
+	Since    time.Time `json:"since"`
+}
+
+func labels() map[string]string {
+	return map[string]string{
+		manifest.TypeLabelKey: ManifestType,
+	}
+}
+
+// Get returns the current pause state or nil if snapshots are not paused.
+func Get(ctx context.Context, rep repo.Repository) (*State, error) {
+	entries, err := rep.FindManifests(ctx, labels())
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to find pause manifests")
+	}
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	latest := entries[0]
+
+	for _, e := range entries[1:] {
+		if e.ModTime.After(latest.ModTime) {
+			latest = e
+		}
+	}
+
+	st := &State{}
+	if _, err := rep.GetManifest(ctx, latest.ID, st); err != nil {
+		return nil, errors.Wrap(err, "unable to load pause manifest")
+	}
+
+	return st, nil
+}
+
+// Pause pauses taking snapshots by all clients of the repository.
+func Pause(ctx context.Context, rep repo.Repository, reason string) (*State, error) {
+	if err := deleteAll(ctx, rep); err != nil {
+		return nil, err
+	}
+
+	co := rep.ClientOptions()
+
+	st := &State{
+		Reason:   reason,
+		PausedBy: co.Username + "@" + co.Hostname,
+		Since:    rep.Time(),
+	}
+
+	if _, err := rep.PutManifest(ctx, labels(), st); err != nil {
+		return nil, errors.Wrap(err, "unable to save pause manifest")
+	}
+
+	return st, errors.Wrap(rep.Flush(ctx), "unable to flush repository")
+}
+
+// Resume resumes taking snapshots.
+func Resume(ctx context.Context, rep repo.Repository) error {
+	if err := deleteAll(ctx, rep); err != nil {
+		return err
+	}
+
+	return errors.Wrap(rep.Flush(ctx), "unable to flush repository")
+}
+
+func deleteAll(ctx context.Context, rep repo.Repository) error {
+	entries, err := rep.FindManifests(ctx, labels())
+	if err != nil {
+		return errors.Wrap(err, "unable to find pause manifests")
+	}
+
+	for _, e := range entries {
+		if err := rep.DeleteManifest(ctx, e.ID); err != nil {
+			return errors.Wrap(err, "unable to delete pause manifest")
+		}
+	}
+
+	return nil
+}
+
+// Watch periodically refreshes the repository and invokes the provided callback once
+// snapshots become paused. It returns when the context is canceled or the callback has been invoked.
+func Watch(ctx context.Context, rep repo.Repository, interval time.Duration, onPause func(st *State)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-time.After(interval):
+		}
+
+		if err := rep.Refresh(ctx); err != nil {
+			continue
+		}
+
+		if st, err := Get(ctx, rep); err == nil && st != nil {
+			onPause(st)
+			return
+		}
+	}
+}
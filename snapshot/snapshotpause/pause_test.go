@@ -0,0 +1,49 @@
+package snapshotpause_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/snapshot/snapshotpause"
+)
+
+func TestPauseResume(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	st, err := snapshotpause.Get(ctx, env.Repository)
+	require.NoError(t, err)
+	require.Nil(t, st)
+
+	_, err = snapshotpause.Pause(ctx, env.Repository, "first")
+	require.NoError(t, err)
+
+	_, err = snapshotpause.Pause(ctx, env.Repository, "storage maintenance")
+	require.NoError(t, err)
+
+	st, err = snapshotpause.Get(ctx, env.Repository)
+	require.NoError(t, err)
+	require.NotNil(t, st)
+	require.Equal(t, "storage maintenance", st.Reason)
+	require.NotEmpty(t, st.PausedBy)
+
+	// pause is visible to other clients of the repository.
+	r2 := env.MustOpenAnother(t)
+	defer r2.Close(ctx)
+
+	st, err = snapshotpause.Get(ctx, r2)
+	require.NoError(t, err)
+	require.NotNil(t, st)
+
+	require.NoError(t, snapshotpause.Resume(ctx, env.Repository))
+
+	st, err = snapshotpause.Get(ctx, env.Repository)
+	require.NoError(t, err)
+	require.Nil(t, st)
+}
@@ -0,0 +1,82 @@
+package snapshotreport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PostWebhook posts JSON-encoded report to the provided URL.
+func PostWebhook(ctx context.Context, url string, r *Report) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "unable to encode report")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "unable to create request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "unable to post report")
+	}
+
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode/100 != 2 { //nolint:gomnd
+		return errors.Errorf("webhook returned %v", resp.Status)
+	}
+
+	return nil
+}
+
+// EmailOptions specifies how to send reports by email.
+type EmailOptions struct {
+	SMTPServer string // host:port
+	Username   string
+	Password   string
+	From       string
+	To         []string
+}
+
+// SendEmail sends the report as plain text email.
+func SendEmail(opt EmailOptions, r *Report) error {
+	if len(opt.To) == 0 {
+		return errors.Errorf("no recipients")
+	}
+
+	host, _, err := net.SplitHostPort(opt.SMTPServer)
+	if err != nil {
+		return errors.Wrap(err, "invalid SMTP server address")
+	}
+
+	var auth smtp.Auth
+	if opt.Username != "" {
+		auth = smtp.PlainAuth("", opt.Username, opt.Password, host)
+	}
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %v\r\n", opt.From)                          //nolint:errcheck
+	fmt.Fprintf(&buf, "To: %v\r\n", strings.Join(opt.To, ", "))          //nolint:errcheck
+	fmt.Fprintf(&buf, "Subject: %v\r\n", r.Subject())                    //nolint:errcheck
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n\r\n") //nolint:errcheck
+	r.WriteText(&buf)
+
+	if err := smtp.SendMail(opt.SMTPServer, auth, opt.From, opt.To, buf.Bytes()); err != nil {
+		return errors.Wrap(err, "unable to send email")
+	}
+
+	return nil
+}
@@ -0,0 +1,262 @@
+// Package snapshotreport aggregates snapshot outcomes of all sources over a period of time
+// into a summary report suitable for periodic digests.
+package snapshotreport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/units"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/snapshot"
+)
+
+// DefaultMaxTopErrors is the default number of most frequent error messages included in the report.
+const DefaultMaxTopErrors = 10
+
+// Options provides options for Generate.
+type Options struct {
+	Start time.Time
+	End   time.Time
+
+	// MaxTopErrors is the maximum number of most frequent error messages to include.
+	MaxTopErrors int
+}
+
+// SourceReport summarizes snapshots of a single source taken during the reporting period.
+type SourceReport struct {
+	Source    snapshot.SourceInfo `json:"source"`
+	Successes int                 `json:"successes"`
+	Failures  int                 `json:"failures"`
+
+	// LastSnapshotTime is the start time of the most recent snapshot of the source, if any.
+	LastSnapshotTime *time.Time `json:"lastSnapshotTime,omitempty"`
+
+	// TotalSize is the size of the most recent complete snapshot.
+	TotalSize int64 `json:"totalSize"`
+
+	// Growth is the difference in size between the most recent complete snapshot and the last
+	// complete snapshot taken before the reporting period (or the first one taken during it).
+	Growth int64 `json:"growth"`
+}
+
+// ErrorCount describes how many times an error message was encountered.
+type ErrorCount struct {
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+// Report summarizes snapshot outcomes across all sources during the reporting period.
+type Report struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	Sources   []*SourceReport `json:"sources"`
+	Successes int             `json:"successes"`
+	Failures  int             `json:"failures"`
+	Growth    int64           `json:"growth"`
+
+	// Idle lists sources that have not been snapshotted during the reporting period.
+	Idle []snapshot.SourceInfo `json:"idle,omitempty"`
+
+	TopErrors []*ErrorCount `json:"topErrors,omitempty"`
+}
+
+// Subject returns one-line summary of the report.
+func (r *Report) Subject() string {
+	return fmt.Sprintf("Kopia backup report: %v successful, %v failed snapshots since %v",
+		r.Successes, r.Failures, r.Start.Local().Format("2006-01-02 15:04"))
+}
+
+// isFailed returns true if the snapshot is incomplete or some of its entries could not be read.
+func isFailed(m *snapshot.Manifest) bool {
+	if m.IncompleteReason != "" {
+		return true
+	}
+
+	return m.RootEntry != nil && m.RootEntry.DirSummary != nil && m.RootEntry.DirSummary.NumFailed > 0
+}
+
+func errorMessages(m *snapshot.Manifest) []string {
+	var result []string
+
+	if m.IncompleteReason != "" {
+		result = append(result, "snapshot incomplete: "+m.IncompleteReason)
+	}
+
+	if m.RootEntry != nil && m.RootEntry.DirSummary != nil {
+		for _, e := range m.RootEntry.DirSummary.FailedEntries {
+			result = append(result, e.Error)
+		}
+	}
+
+	return result
+}
+
+// Generate produces the report of snapshots of all sources taken during the provided period.
+func Generate(ctx context.Context, rep repo.Repository, opt Options) (*Report, error) {
+	if opt.MaxTopErrors == 0 {
+		opt.MaxTopErrors = DefaultMaxTopErrors
+	}
+
+	sources, err := snapshot.ListSources(ctx, rep)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list sources")
+	}
+
+	r := &Report{
+		Start: opt.Start,
+		End:   opt.End,
+	}
+
+	errorCounts := map[string]int{}
+
+	for _, src := range sources {
+		manifests, err := snapshot.ListSnapshots(ctx, rep, src)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to list snapshots of %v", src)
+		}
+
+		sr := summarizeSource(src, manifests, opt, errorCounts)
+		if sr == nil {
+			r.Idle = append(r.Idle, src)
+			continue
+		}
+
+		r.Sources = append(r.Sources, sr)
+		r.Successes += sr.Successes
+		r.Failures += sr.Failures
+		r.Growth += sr.Growth
+	}
+
+	r.TopErrors = topErrors(errorCounts, opt.MaxTopErrors)
+
+	return r, nil
+}
+
+// summarizeSource returns the report of a single source or nil if it has no snapshots in the period.
+func summarizeSource(src snapshot.SourceInfo, manifests []*snapshot.Manifest, opt Options, errorCounts map[string]int) *SourceReport {
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].StartTime.Before(manifests[j].StartTime)
+	})
+
+	sr := &SourceReport{Source: src}
+
+	var baseline, latest *snapshot.Manifest
+
+	for _, m := range manifests {
+		if m.StartTime.Before(opt.Start) {
+			if !isFailed(m) {
+				baseline = m
+			}
+
+			continue
+		}
+
+		if m.StartTime.After(opt.End) {
+			break
+		}
+
+		t := m.StartTime
+		sr.LastSnapshotTime = &t
+
+		if isFailed(m) {
+			sr.Failures++
+
+			for _, msg := range errorMessages(m) {
+				errorCounts[msg]++
+			}
+		} else {
+			sr.Successes++
+
+			if baseline == nil {
+				baseline = m
+			}
+
+			latest = m
+		}
+	}
+
+	if sr.LastSnapshotTime == nil {
+		return nil
+	}
+
+	if latest != nil {
+		sr.TotalSize = latest.Stats.TotalFileSize
+		sr.Growth = latest.Stats.TotalFileSize - baseline.Stats.TotalFileSize
+	}
+
+	return sr
+}
+
+func topErrors(counts map[string]int, max int) []*ErrorCount {
+	var result []*ErrorCount
+
+	for msg, cnt := range counts {
+		result = append(result, &ErrorCount{msg, cnt})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+
+		return result[i].Message < result[j].Message
+	})
+
+	if len(result) > max {
+		result = result[0:max]
+	}
+
+	return result
+}
+
+func formatGrowth(v int64) string {
+	if v < 0 {
+		return "-" + units.BytesStringBase10(-v)
+	}
+
+	return "+" + units.BytesStringBase10(v)
+}
+
+// WriteText writes human-readable report to the provided writer.
+func (r *Report) WriteText(w io.Writer) {
+	const timeFormat = "2006-01-02 15:04:05 MST"
+
+	fmt.Fprintf(w, "Snapshots between %v and %v\n\n", r.Start.Local().Format(timeFormat), r.End.Local().Format(timeFormat)) //nolint:errcheck
+
+	fmt.Fprintf(w, "Successful:  %v\n", r.Successes)            //nolint:errcheck
+	fmt.Fprintf(w, "Failed:      %v\n", r.Failures)             //nolint:errcheck
+	fmt.Fprintf(w, "Data growth: %v\n", formatGrowth(r.Growth)) //nolint:errcheck
+
+	if len(r.Sources) > 0 {
+		fmt.Fprintf(w, "\nSources:\n") //nolint:errcheck
+
+		for _, s := range r.Sources {
+			fmt.Fprintf(w, "  %v\n    %v successful, %v failed, size %v (%v), last snapshot %v\n", //nolint:errcheck
+				s.Source, s.Successes, s.Failures, units.BytesStringBase10(s.TotalSize), formatGrowth(s.Growth),
+				s.LastSnapshotTime.Local().Format(timeFormat))
+		}
+	}
+
+	if len(r.Idle) > 0 {
+		fmt.Fprintf(w, "\nSources without snapshots:\n") //nolint:errcheck
+
+		for _, s := range r.Idle {
+			fmt.Fprintf(w, "  %v\n", s) //nolint:errcheck
+		}
+	}
+
+	if len(r.TopErrors) > 0 {
+		fmt.Fprintf(w, "\nTop errors:\n") //nolint:errcheck
+
+		for _, e := range r.TopErrors {
+			fmt.Fprintf(w, "  %5v x %v\n", e.Count, e.Message) //nolint:errcheck
+		}
+	}
+}
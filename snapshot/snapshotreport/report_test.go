@@ -0,0 +1,79 @@
+package snapshotreport_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/snapshotreport"
+)
+
+func TestGenerate(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	now := time.Date(2021, 1, 10, 12, 0, 0, 0, time.UTC)
+	src1 := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src1"}
+	src2 := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src2"}
+
+	save := func(src snapshot.SourceInfo, age time.Duration, size int64, incomplete string, failed ...string) {
+		t.Helper()
+
+		summ := &fs.DirectorySummary{NumFailed: len(failed)}
+		for _, e := range failed {
+			summ.FailedEntries = append(summ.FailedEntries, &fs.EntryWithError{EntryPath: "x", Error: e})
+		}
+
+		_, err := snapshot.SaveSnapshot(ctx, env.Repository, &snapshot.Manifest{
+			Source:           src,
+			StartTime:        now.Add(-age),
+			EndTime:          now.Add(-age),
+			Stats:            snapshot.Stats{TotalFileSize: size},
+			IncompleteReason: incomplete,
+			RootEntry:        &snapshot.DirEntry{Type: snapshot.EntryTypeDirectory, ObjectID: "kabcd", DirSummary: summ},
+		})
+		require.NoError(t, err)
+	}
+
+	// src1: baseline before the period, two successes and two failures during it.
+	save(src1, 48*time.Hour, 1000, "")
+	save(src1, 20*time.Hour, 1500, "")
+	save(src1, 10*time.Hour, 1700, "", "permission denied", "permission denied")
+	save(src1, 5*time.Hour, 1600, "")
+	save(src1, 2*time.Hour, 0, "canceled", "permission denied")
+
+	// src2: no snapshots during the period.
+	save(src2, 72*time.Hour, 500, "")
+
+	r, err := snapshotreport.Generate(ctx, env.Repository, snapshotreport.Options{
+		Start: now.Add(-24 * time.Hour),
+		End:   now,
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, 2, r.Successes)
+	require.Equal(t, 2, r.Failures)
+	require.Equal(t, int64(600), r.Growth)
+	require.Len(t, r.Sources, 1)
+	require.Equal(t, src1, r.Sources[0].Source)
+	require.Equal(t, int64(1600), r.Sources[0].TotalSize)
+	require.Equal(t, []snapshot.SourceInfo{src2}, r.Idle)
+	require.Equal(t, []*snapshotreport.ErrorCount{
+		{Message: "permission denied", Count: 3},
+		{Message: "snapshot incomplete: canceled", Count: 1},
+	}, r.TopErrors)
+
+	var buf bytes.Buffer
+
+	r.WriteText(&buf)
+	require.True(t, strings.Contains(buf.String(), "3 x permission denied"), buf.String())
+}
@@ -0,0 +1,342 @@
+// Package snapshotrestoretest implements periodic test restores of random samples of files
+// from the latest snapshots, which prove that snapshot data can actually be restored.
+package snapshotrestoretest
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/iocopy"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/repo/logging"
+	"github.com/kopia/kopia/repo/maintenance"
+	"github.com/kopia/kopia/repo/object"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+var log = logging.GetContextLoggerFunc("snapshotrestoretest")
+
+// RunType is the name under which restore test runs are recorded in maintenance schedule.
+const RunType = "restore-test"
+
+// maxAttemptsPerFile is the number of random walks from snapshot roots made for each sampled file,
+// walks may end in empty directories or in files that have already been picked.
+const maxAttemptsPerFile = 10
+
+// sampledFile is a file picked for restore along with the snapshot it came from.
+type sampledFile struct {
+	manifest *snapshot.Manifest
+	path     string
+	entry    fs.File
+}
+
+// Result describes the outcome of a restore test.
+type Result struct {
+	Restored []string
+	Failed   map[string]error
+}
+
+// Err returns an error summarizing failed restores, if any.
+func (r *Result) Err() error {
+	if len(r.Failed) == 0 {
+		return nil
+	}
+
+	var msgs []string
+
+	for path, err := range r.Failed {
+		msgs = append(msgs, path+": "+err.Error())
+	}
+
+	sort.Strings(msgs)
+
+	return errors.Errorf("unable to restore %v of %v sampled files: %v", len(r.Failed), len(r.Failed)+len(r.Restored), strings.Join(msgs, "; "))
+}
+
+// IsDue returns true if the restore test is enabled and the last run is older than the configured interval.
+func IsDue(p maintenance.RestoreTestParams, s *maintenance.Schedule, now time.Time) bool {
+	if !p.Enabled {
+		return false
+	}
+
+	runs := s.Runs[RunType]
+	if len(runs) == 0 {
+		return true
+	}
+
+	return now.Sub(runs[0].Start) >= p.Interval
+}
+
+// Run restores a random sample of files from the latest complete snapshots of all sources
+// into a scratch directory and verifies that the restored files have the size and object IDs
+// recorded in their snapshots, which proves that they match the original files.
+func Run(ctx context.Context, rep *repo.DirectRepository, p maintenance.RestoreTestParams) (*Result, error) {
+	sample, err := pickSample(ctx, rep, p.SampleSize)
+	if err != nil {
+		return nil, err
+	}
+
+	compressors, err := usedCompressors(ctx, rep)
+	if err != nil {
+		return nil, err
+	}
+
+	scratch, err := ioutil.TempDir(p.ScratchDir, "kopia-restore-test")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create scratch directory")
+	}
+
+	defer os.RemoveAll(scratch) //nolint:errcheck
+
+	res := &Result{Failed: map[string]error{}}
+
+	for i, sf := range sample {
+		desc := sf.manifest.Source.String() + sf.path
+
+		if err := restoreAndVerify(ctx, rep, sf.entry, filepath.Join(scratch, "file"+strconv.Itoa(i)), compressors); err != nil {
+			log(ctx).Warningf("restore test of %v from snapshot %v failed: %v", desc, sf.manifest.ID, err)
+			res.Failed[desc] = err
+
+			continue
+		}
+
+		log(ctx).Debugf("restore test of %v succeeded", desc)
+		res.Restored = append(res.Restored, desc)
+	}
+
+	log(ctx).Infof("Restore test finished: %v files restored, %v failed.", len(res.Restored), len(res.Failed))
+
+	return res, nil
+}
+
+// pickSample returns up to n distinct files from the latest complete snapshots of all sources, each of them
+// found by a random walk from the root of a random snapshot, so that only directories along the walked paths
+// are read.
+func pickSample(ctx context.Context, rep repo.Repository, n int) ([]sampledFile, error) {
+	sources, err := snapshot.ListSources(ctx, rep)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list sources")
+	}
+
+	var manifests []*snapshot.Manifest
+
+	for _, src := range sources {
+		man, err := latestCompleteSnapshot(ctx, rep, src)
+		if err != nil {
+			return nil, err
+		}
+
+		if man != nil {
+			manifests = append(manifests, man)
+		}
+	}
+
+	if len(manifests) == 0 {
+		return nil, nil
+	}
+
+	rnd := rand.New(rand.NewSource(clock.Now().UnixNano())) //nolint:gosec
+	w := &randomWalker{rnd: rnd, dirs: map[object.ID]fs.Entries{}}
+
+	var sample []sampledFile
+
+	picked := map[string]bool{}
+
+	for attempt := 0; attempt < n*maxAttemptsPerFile && len(sample) < n; attempt++ {
+		man := manifests[rnd.Intn(len(manifests))]
+
+		root, err := snapshotfs.SnapshotRoot(rep, man)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to open snapshot of %v", man.Source)
+		}
+
+		path, f, err := w.walk(ctx, root)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read snapshot of %v", man.Source)
+		}
+
+		if f == nil || picked[string(man.ID)+path] {
+			continue
+		}
+
+		picked[string(man.ID)+path] = true
+
+		sample = append(sample, sampledFile{man, path, f})
+	}
+
+	return sample, nil
+}
+
+// randomWalker descends from snapshot roots into random children until it reaches a file,
+// remembering the contents of directories it has read.
+type randomWalker struct {
+	rnd  *rand.Rand
+	dirs map[object.ID]fs.Entries
+}
+
+// walk returns the path and the file found by a random walk from e, nil if the walk ended
+// in an empty directory or an entry that is not a file.
+func (w *randomWalker) walk(ctx context.Context, e fs.Entry) (string, fs.File, error) {
+	var path string
+
+	for {
+		switch e2 := e.(type) {
+		case fs.File:
+			return path, e2, nil
+
+		case fs.Directory:
+			entries, err := w.readdir(ctx, e2)
+			if err != nil {
+				return "", nil, errors.Wrapf(err, "unable to read directory %q", path)
+			}
+
+			if len(entries) == 0 {
+				return "", nil, nil
+			}
+
+			e = entries[w.rnd.Intn(len(entries))]
+			path += "/" + e.Name()
+
+		default:
+			return "", nil, nil
+		}
+	}
+}
+
+func (w *randomWalker) readdir(ctx context.Context, d fs.Directory) (fs.Entries, error) {
+	hde, ok := d.(snapshot.HasDirEntry)
+	if !ok {
+		return d.Readdir(ctx)
+	}
+
+	oid := hde.DirEntry().ObjectID
+	if entries, ok := w.dirs[oid]; ok {
+		return entries, nil
+	}
+
+	entries, err := d.Readdir(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	w.dirs[oid] = entries
+
+	return entries, nil
+}
+
+func latestCompleteSnapshot(ctx context.Context, rep repo.Repository, src snapshot.SourceInfo) (*snapshot.Manifest, error) {
+	manifests, err := snapshot.ListSnapshots(ctx, rep, src)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list snapshots of %v", src)
+	}
+
+	var latest *snapshot.Manifest
+
+	for _, m := range manifests {
+		if m.IncompleteReason != "" || m.RootObjectID() == "" {
+			continue
+		}
+
+		if latest == nil || m.StartTime.After(latest.StartTime) {
+			latest = m
+		}
+	}
+
+	return latest, nil
+}
+
+// usedCompressors returns compressors that may have been used to write files, since object IDs
+// of compressed files depend on the compressor.
+func usedCompressors(ctx context.Context, rep repo.Repository) ([]compression.Name, error) {
+	pols, err := policy.ListPolicies(ctx, rep)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list policies")
+	}
+
+	result := []compression.Name{""}
+	seen := map[compression.Name]bool{"": true}
+
+	for _, pol := range pols {
+		if c := pol.CompressionPolicy.CompressorName; c != "none" && !seen[c] {
+			seen[c] = true
+			result = append(result, c)
+		}
+	}
+
+	return result, nil
+}
+
+// restoreAndVerify restores the file to the provided path, then reads it back from the disk and
+// verifies that its size and object ID computed from the restored data match the snapshot.
+func restoreAndVerify(ctx context.Context, rep *repo.DirectRepository, f fs.File, targetPath string, compressors []compression.Name) error {
+	hde, ok := f.(snapshot.HasDirEntry)
+	if !ok {
+		return errors.Errorf("file does not come from a snapshot")
+	}
+
+	de := hde.DirEntry()
+
+	r, err := f.Open(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to open file in repository")
+	}
+	defer r.Close() //nolint:errcheck
+
+	out, err := os.Create(targetPath)
+	if err != nil {
+		return errors.Wrap(err, "unable to create scratch file")
+	}
+	defer out.Close() //nolint:errcheck
+
+	n, err := iocopy.Copy(out, r)
+	if err != nil {
+		return errors.Wrap(err, "unable to restore file contents")
+	}
+
+	if err := out.Close(); err != nil {
+		return errors.Wrap(err, "unable to close scratch file")
+	}
+
+	if n != de.FileSize {
+		return errors.Errorf("restored %v bytes, expected %v", n, de.FileSize)
+	}
+
+	for _, comp := range compressors {
+		oid, err := computeFileObjectID(ctx, rep, targetPath, comp)
+		if err != nil {
+			return err
+		}
+
+		if oid == de.ObjectID {
+			return nil
+		}
+	}
+
+	return errors.Errorf("restored file does not match object %v", de.ObjectID)
+}
+
+func computeFileObjectID(ctx context.Context, rep *repo.DirectRepository, fname string, comp compression.Name) (object.ID, error) {
+	f, err := os.Open(fname) //nolint:gosec
+	if err != nil {
+		return "", errors.Wrap(err, "unable to open restored file")
+	}
+	defer f.Close() //nolint:errcheck
+
+	oid, err := rep.Objects.ComputeObjectID(ctx, f, object.WriterOptions{Compressor: comp})
+
+	return oid, errors.Wrap(err, "unable to compute object ID of restored file")
+}
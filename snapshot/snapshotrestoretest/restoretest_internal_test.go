@@ -0,0 +1,69 @@
+package snapshotrestoretest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/compression"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+// fileWithDirEntry is a file from a snapshot with different directory entry.
+type fileWithDirEntry struct {
+	fs.File
+	de *snapshot.DirEntry
+}
+
+func (f fileWithDirEntry) DirEntry() *snapshot.DirEntry {
+	return f.de
+}
+
+func TestRestoreAndVerifyComparesWithSnapshot(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	sourceDir := mockfs.NewDirectory()
+	sourceDir.AddFile("f1", []byte{1, 2, 3}, 0o777)
+	sourceDir.AddFile("f2", []byte{4, 5, 6}, 0o777)
+	sourceDir.AddDir("empty", 0o777)
+
+	man, err := snapshotfs.NewUploader(env.Repository).Upload(ctx, sourceDir, policy.BuildTree(nil, policy.DefaultPolicy), snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"})
+	require.NoError(t, err)
+
+	_, err = snapshot.SaveSnapshot(ctx, env.Repository, man)
+	require.NoError(t, err)
+
+	root, err := snapshotfs.SnapshotRoot(env.Repository, man)
+	require.NoError(t, err)
+
+	f1, err := root.(fs.Directory).Child(ctx, "f1")
+	require.NoError(t, err)
+
+	f2, err := root.(fs.Directory).Child(ctx, "f2")
+	require.NoError(t, err)
+
+	compressors := []compression.Name{""}
+	dir := t.TempDir()
+
+	require.NoError(t, restoreAndVerify(ctx, env.Repository, f1.(fs.File), filepath.Join(dir, "ok"), compressors))
+
+	// contents of f1 don't match the object recorded for f2, even though they have the same size.
+	mismatched := fileWithDirEntry{f1.(fs.File), f2.(snapshot.HasDirEntry).DirEntry()}
+	require.Error(t, restoreAndVerify(ctx, env.Repository, mismatched, filepath.Join(dir, "mismatched"), compressors))
+
+	// walks ending in empty directory don't sample anything, files are sampled at most once.
+	sample, err := pickSample(ctx, env.Repository, 5)
+	require.NoError(t, err)
+	require.Len(t, sample, 2)
+	require.NotEqual(t, sample[0].path, sample[1].path)
+}
@@ -0,0 +1,104 @@
+package snapshotrestoretest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/maintenance"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+	"github.com/kopia/kopia/snapshot/snapshotrestoretest"
+)
+
+const defaultPermissions = 0o777
+
+func TestRestoreTest(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	sourceDir := mockfs.NewDirectory()
+	sourceDir.AddFile("f1", []byte{1, 2, 3}, defaultPermissions)
+	sourceDir.AddDir("d1", defaultPermissions)
+	sourceDir.AddFile("d1/f2", []byte{4, 5, 6, 7}, defaultPermissions)
+	sourceDir.AddFile("d1/f3", []byte{8, 9}, defaultPermissions)
+
+	u := snapshotfs.NewUploader(env.Repository)
+
+	man, err := u.Upload(ctx, sourceDir, policy.BuildTree(nil, policy.DefaultPolicy), snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"})
+	require.NoError(t, err)
+
+	_, err = snapshot.SaveSnapshot(ctx, env.Repository, man)
+	require.NoError(t, err)
+	require.NoError(t, env.Repository.Flush(ctx))
+
+	p := maintenance.RestoreTestParams{
+		Enabled:    true,
+		Interval:   time.Hour,
+		SampleSize: 2,
+		ScratchDir: t.TempDir(),
+	}
+
+	res, err := snapshotrestoretest.Run(ctx, env.Repository, p)
+	require.NoError(t, err)
+	require.Len(t, res.Restored, 2)
+	require.NoError(t, res.Err())
+
+	// sample size larger than the number of files restores all of them.
+	p.SampleSize = 10
+
+	res, err = snapshotrestoretest.Run(ctx, env.Repository, p)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"user@host:/src/f1", "user@host:/src/d1/f2", "user@host:/src/d1/f3"}, res.Restored)
+
+	// remove data pack blobs, which makes file contents unreadable.
+	deletePackBlobs(ctx, t, &env, content.PackBlobIDPrefixRegular)
+	env.MustReopen(t)
+
+	res, err = snapshotrestoretest.Run(ctx, env.Repository, p)
+	require.NoError(t, err)
+	require.Len(t, res.Failed, 3)
+	require.Error(t, res.Err())
+}
+
+func TestIsDue(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := maintenance.RestoreTestParams{Interval: time.Hour}
+	s := &maintenance.Schedule{}
+
+	require.False(t, snapshotrestoretest.IsDue(p, s, now))
+
+	p.Enabled = true
+	require.True(t, snapshotrestoretest.IsDue(p, s, now))
+
+	s.ReportRun(snapshotrestoretest.RunType, maintenance.RunInfo{Start: now.Add(-30 * time.Minute)})
+	require.False(t, snapshotrestoretest.IsDue(p, s, now))
+	require.True(t, snapshotrestoretest.IsDue(p, s, now.Add(time.Hour)))
+}
+
+func deletePackBlobs(ctx context.Context, t *testing.T, env *repotesting.Environment, prefix blob.ID) {
+	t.Helper()
+
+	var ids []blob.ID
+
+	require.NoError(t, env.Repository.Blobs.ListBlobs(ctx, prefix, func(bm blob.Metadata) error {
+		ids = append(ids, bm.BlobID)
+		return nil
+	}))
+
+	require.NotEmpty(t, ids)
+
+	for _, id := range ids {
+		require.NoError(t, env.Repository.Blobs.DeleteBlob(ctx, id))
+	}
+}
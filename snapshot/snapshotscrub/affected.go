@@ -0,0 +1,132 @@
+package snapshotscrub
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/object"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+)
+
+// affectedFinder determines which corrupt contents are reachable from objects. Results for directories
+// are cached, since most directories are shared between consecutive snapshots of a source.
+type affectedFinder struct {
+	rep     *repo.DirectRepository
+	corrupt map[content.ID]*CorruptContent
+	dirs    map[object.ID][]content.ID
+}
+
+func (f *affectedFinder) corruptContentsOf(ctx context.Context, oid object.ID) []content.ID {
+	contentIDs, err := f.rep.VerifyObject(ctx, oid)
+	if err != nil {
+		log(ctx).Debugf("unable to verify object %v: %v", oid, err)
+		return nil
+	}
+
+	var result []content.ID
+
+	for _, cid := range contentIDs {
+		if f.corrupt[cid] != nil {
+			result = append(result, cid)
+		}
+	}
+
+	return result
+}
+
+// find returns IDs of corrupt contents reachable from the provided object.
+func (f *affectedFinder) find(ctx context.Context, oid object.ID, isDir bool) []content.ID {
+	if !isDir {
+		return f.corruptContentsOf(ctx, oid)
+	}
+
+	if v, ok := f.dirs[oid]; ok {
+		return v
+	}
+
+	found := map[content.ID]bool{}
+
+	for _, cid := range f.corruptContentsOf(ctx, oid) {
+		found[cid] = true
+	}
+
+	baseIDs, err := snapshotfs.DirectoryBaseObjectIDs(ctx, f.rep, oid)
+	if err != nil {
+		log(ctx).Debugf("unable to read base directories of %v: %v", oid, err)
+	}
+
+	for _, baseID := range baseIDs {
+		for _, cid := range f.corruptContentsOf(ctx, baseID) {
+			found[cid] = true
+		}
+	}
+
+	// entries of directories stored in corrupt contents can't be read.
+	if len(found) == 0 {
+		entries, err := snapshotfs.DirectoryEntry(f.rep, oid, nil).Readdir(ctx)
+		if err != nil {
+			log(ctx).Debugf("unable to read directory %v: %v", oid, err)
+		}
+
+		for _, e := range entries {
+			h, ok := e.(object.HasObjectID)
+			if !ok {
+				continue
+			}
+
+			for _, cid := range f.find(ctx, h.ObjectID(), e.IsDir()) {
+				found[cid] = true
+			}
+		}
+	}
+
+	result := make([]content.ID, 0, len(found))
+	for cid := range found {
+		result = append(result, cid)
+	}
+
+	f.dirs[oid] = result
+
+	return result
+}
+
+// findAffectedSnapshots fills in descriptions of snapshots that reference the provided corrupt contents.
+func findAffectedSnapshots(ctx context.Context, rep *repo.DirectRepository, corrupt map[content.ID]*CorruptContent) error {
+	ids, err := snapshot.ListSnapshotManifests(ctx, rep, nil)
+	if err != nil {
+		return errors.Wrap(err, "unable to list snapshot manifests")
+	}
+
+	manifests, err := snapshot.LoadSnapshots(ctx, rep, ids)
+	if err != nil {
+		return errors.Wrap(err, "unable to load snapshot manifests")
+	}
+
+	log(ctx).Infof("Looking for snapshots affected by %v corrupt contents...", len(corrupt))
+
+	f := &affectedFinder{
+		rep:     rep,
+		corrupt: corrupt,
+		dirs:    map[object.ID][]content.ID{},
+	}
+
+	for _, m := range snapshot.SortByTime(manifests, false) {
+		if m.RootEntry == nil {
+			continue
+		}
+
+		desc := fmt.Sprintf("%v %v (%v)", m.Source, m.StartTime.UTC().Format(time.RFC3339), m.ID)
+
+		for _, cid := range f.find(ctx, m.RootEntry.ObjectID, m.RootEntry.Type == snapshot.EntryTypeDirectory) {
+			corrupt[cid].Snapshots = append(corrupt[cid].Snapshots, desc)
+		}
+	}
+
+	return nil
+}
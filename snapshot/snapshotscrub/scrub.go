@@ -0,0 +1,312 @@
+// Package snapshotscrub implements continuous verification of repository contents. Each run downloads and
+// verifies a fraction of contents, starting with those that were verified least recently, so that all contents
+// are eventually read back. Corrupt contents are reported along with snapshots that reference them.
+package snapshotscrub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/natefinch/atomic"
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/internal/parallelwork"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/logging"
+	"github.com/kopia/kopia/repo/maintenance"
+)
+
+var log = logging.GetContextLoggerFunc("snapshotscrub")
+
+// RunType is the name under which content verification runs are recorded in maintenance schedule.
+const RunType = "content-verification"
+
+const defaultParallel = 8
+
+// Options provides options for Run.
+type Options struct {
+	// Percent is the percentage of contents verified, values of 100 or more verify all contents.
+	Percent float64
+
+	// Parallel is the number of contents verified in parallel.
+	Parallel int
+}
+
+// CorruptContent describes a content that could not be read back along with snapshots that reference it.
+type CorruptContent struct {
+	ContentID  content.ID `json:"contentID"`
+	PackBlobID blob.ID    `json:"packBlobID"`
+	Error      string     `json:"error"`
+
+	// Snapshots contains descriptions of snapshots that reference the content.
+	Snapshots []string `json:"snapshots,omitempty"`
+}
+
+// Result describes the outcome of content verification.
+type Result struct {
+	TotalContents int `json:"totalContents"`
+	Verified      int `json:"verified"`
+
+	// NeverVerified is the number of contents that have not been verified on this machine yet.
+	NeverVerified int `json:"neverVerified"`
+
+	// OldestVerification is the time of the least recent verification of a content that has been verified.
+	OldestVerification time.Time `json:"oldestVerification,omitempty"`
+
+	Corrupt []*CorruptContent `json:"corrupt,omitempty"`
+}
+
+// Err returns an error summarizing corrupt contents, if any.
+func (r *Result) Err() error {
+	if len(r.Corrupt) == 0 {
+		return nil
+	}
+
+	return errors.Errorf("found %v corrupt contents among %v verified", len(r.Corrupt), r.Verified)
+}
+
+// IsDue returns true if content verification is enabled and the last run is older than the configured interval.
+func IsDue(p maintenance.ContentVerificationParams, s *maintenance.Schedule, now time.Time) bool {
+	if !p.Enabled {
+		return false
+	}
+
+	runs := s.Runs[RunType]
+	if len(runs) == 0 {
+		return true
+	}
+
+	return now.Sub(runs[0].Start) >= p.Interval
+}
+
+// OptionsFromParams returns options for Run based on maintenance parameters.
+func OptionsFromParams(p maintenance.ContentVerificationParams) Options {
+	percent := p.Percent
+	if percent <= 0 {
+		percent = maintenance.DefaultParams().ContentVerification.Percent
+	}
+
+	return Options{Percent: percent}
+}
+
+// StateFile returns the local file where times of last verification of contents of the provided repository
+// are stored or an empty string if the repository is not connected using a configuration file.
+func StateFile(rep *repo.DirectRepository) string {
+	if rep.ConfigFile == "" {
+		return ""
+	}
+
+	return rep.ConfigFile + ".verify-state"
+}
+
+// Run downloads and verifies the provided percentage of contents that were verified least recently,
+// records successful verifications in the local state file and looks up snapshots affected by corrupt contents.
+func Run(ctx context.Context, rep *repo.DirectRepository, opt Options) (*Result, error) {
+	stateFile := StateFile(rep)
+
+	lastVerified, err := loadState(stateFile)
+	if err != nil {
+		log(ctx).Warningf("unable to load content verification state, starting over: %v", err)
+
+		lastVerified = map[content.ID]int64{}
+	}
+
+	var all []content.Info
+
+	if err := rep.Content.IterateContents(ctx, content.IterateOptions{}, func(ci content.Info) error {
+		all = append(all, ci)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "unable to iterate contents")
+	}
+
+	// verify contents that were never verified first, then the ones verified least recently.
+	sort.Slice(all, func(i, j int) bool {
+		ti, tj := lastVerified[all[i].ID], lastVerified[all[j].ID]
+		if ti != tj {
+			return ti < tj
+		}
+
+		return all[i].ID < all[j].ID
+	})
+
+	toVerify := all[0:contentsToVerify(len(all), opt.Percent)]
+
+	log(ctx).Infof("Verifying %v of %v contents...", len(toVerify), len(all))
+
+	res := &Result{
+		TotalContents: len(all),
+		Verified:      len(toVerify),
+	}
+
+	corrupt := verifyContents(ctx, rep, toVerify, opt.Parallel)
+
+	now := rep.Time().Unix()
+	newState := map[content.ID]int64{}
+
+	// drop state of contents that no longer exist.
+	for _, ci := range all {
+		if t, ok := lastVerified[ci.ID]; ok {
+			newState[ci.ID] = t
+		}
+	}
+
+	// corrupt contents are forgotten, so that they are verified again first during the next run.
+	for _, ci := range toVerify {
+		if _, ok := corrupt[ci.ID]; ok {
+			delete(newState, ci.ID)
+		} else {
+			newState[ci.ID] = now
+		}
+	}
+
+	for _, ci := range all {
+		t, ok := newState[ci.ID]
+		if !ok {
+			res.NeverVerified++
+			continue
+		}
+
+		if ts := time.Unix(t, 0); res.OldestVerification.IsZero() || ts.Before(res.OldestVerification) {
+			res.OldestVerification = ts
+		}
+	}
+
+	if len(corrupt) > 0 {
+		for _, cc := range corrupt {
+			res.Corrupt = append(res.Corrupt, cc)
+		}
+
+		sort.Slice(res.Corrupt, func(i, j int) bool {
+			return res.Corrupt[i].ContentID < res.Corrupt[j].ContentID
+		})
+
+		if err := findAffectedSnapshots(ctx, rep, corrupt); err != nil {
+			return nil, err
+		}
+	}
+
+	if stateFile != "" {
+		if err := saveState(stateFile, newState); err != nil {
+			return nil, errors.Wrap(err, "unable to save content verification state")
+		}
+	}
+
+	log(ctx).Infof("Verified %v contents, found %v corrupt, %v contents not verified yet.", res.Verified, len(res.Corrupt), res.NeverVerified)
+
+	return res, nil
+}
+
+// contentsToVerify returns the number of contents out of total that make up the provided percentage,
+// verifying at least one content per run.
+func contentsToVerify(total int, percent float64) int {
+	if percent >= 100 { //nolint:gomnd
+		return total
+	}
+
+	n := int(math.Ceil(float64(total) * percent / 100)) //nolint:gomnd
+	if n > total {
+		n = total
+	}
+
+	if n < 1 && total > 0 {
+		n = 1
+	}
+
+	return n
+}
+
+// verifyContents downloads the provided contents bypassing content cache and returns the ones that can't be read.
+func verifyContents(ctx context.Context, rep *repo.DirectRepository, infos []content.Info, parallel int) map[content.ID]*CorruptContent {
+	if parallel <= 0 {
+		parallel = defaultParallel
+	}
+
+	ctx = content.UsingContentCache(ctx, false)
+
+	var mu sync.Mutex
+
+	corrupt := map[content.ID]*CorruptContent{}
+
+	q := parallelwork.NewQueue()
+
+	for _, ci := range infos {
+		ci := ci
+
+		q.EnqueueBack(ctx, func() error {
+			if _, err := rep.Content.GetContent(ctx, ci.ID); err != nil {
+				log(ctx).Warningf("content %v in pack %v is corrupt: %v", ci.ID, ci.PackBlobID, err)
+
+				mu.Lock()
+				corrupt[ci.ID] = &CorruptContent{
+					ContentID:  ci.ID,
+					PackBlobID: ci.PackBlobID,
+					Error:      err.Error(),
+				}
+				mu.Unlock()
+			}
+
+			return nil
+		})
+	}
+
+	// errors are collected above, so processing never fails.
+	q.Process(ctx, parallel) //nolint:errcheck
+
+	return corrupt
+}
+
+func loadState(fname string) (map[content.ID]int64, error) {
+	result := map[content.ID]int64{}
+
+	if fname == "" {
+		return result, nil
+	}
+
+	f, err := os.Open(fname) //nolint:gosec
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close() //nolint:errcheck
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid state file")
+	}
+
+	if err := json.NewDecoder(gz).Decode(&result); err != nil {
+		return nil, errors.Wrap(err, "invalid state file")
+	}
+
+	return result, nil
+}
+
+func saveState(fname string, state map[content.ID]int64) error {
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+
+	if err := json.NewEncoder(gz).Encode(state); err != nil {
+		return errors.Wrap(err, "unable to encode state")
+	}
+
+	if err := gz.Close(); err != nil {
+		return errors.Wrap(err, "unable to compress state")
+	}
+
+	return atomic.WriteFile(fname, &buf)
+}
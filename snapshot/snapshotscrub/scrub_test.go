@@ -0,0 +1,96 @@
+package snapshotscrub_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/blob"
+	"github.com/kopia/kopia/repo/content"
+	"github.com/kopia/kopia/repo/maintenance"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+	"github.com/kopia/kopia/snapshot/snapshotscrub"
+)
+
+const defaultPermissions = 0o777
+
+func TestScrub(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	sourceDir := mockfs.NewDirectory()
+	sourceDir.AddFile("f1", []byte{1, 2, 3}, defaultPermissions)
+	sourceDir.AddDir("d1", defaultPermissions)
+	sourceDir.AddFile("d1/f2", []byte{4, 5, 6, 7}, defaultPermissions)
+	sourceDir.AddFile("d1/f3", []byte{8, 9}, defaultPermissions)
+
+	u := snapshotfs.NewUploader(env.Repository)
+
+	man, err := u.Upload(ctx, sourceDir, policy.BuildTree(nil, policy.DefaultPolicy), snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"})
+	require.NoError(t, err)
+
+	_, err = snapshot.SaveSnapshot(ctx, env.Repository, man)
+	require.NoError(t, err)
+	require.NoError(t, env.Repository.Flush(ctx))
+
+	// each run verifies contents that were verified least recently, until all contents have been verified.
+	res, err := snapshotscrub.Run(ctx, env.Repository, snapshotscrub.Options{Percent: 50})
+	require.NoError(t, err)
+	require.NoError(t, res.Err())
+	require.NotZero(t, res.Verified)
+	require.Less(t, res.Verified, res.TotalContents)
+	require.Equal(t, res.TotalContents-res.Verified, res.NeverVerified)
+
+	res, err = snapshotscrub.Run(ctx, env.Repository, snapshotscrub.Options{Percent: 50})
+	require.NoError(t, err)
+	require.Zero(t, res.NeverVerified)
+	require.False(t, res.OldestVerification.IsZero())
+
+	// remove data pack blobs, which makes file contents unreadable.
+	var packs []blob.ID
+
+	require.NoError(t, env.Repository.Blobs.ListBlobs(ctx, content.PackBlobIDPrefixRegular, func(bm blob.Metadata) error {
+		packs = append(packs, bm.BlobID)
+		return nil
+	}))
+
+	for _, id := range packs {
+		require.NoError(t, env.Repository.Blobs.DeleteBlob(ctx, id))
+	}
+
+	res, err = snapshotscrub.Run(ctx, env.Repository, snapshotscrub.Options{Percent: 100})
+	require.NoError(t, err)
+	require.Error(t, res.Err())
+	require.Len(t, res.Corrupt, 3)
+
+	for _, cc := range res.Corrupt {
+		require.Len(t, cc.Snapshots, 1)
+		require.Contains(t, cc.Snapshots[0], string(man.ID))
+	}
+
+	// corrupt contents are forgotten, so that they are verified first during the next run.
+	require.Equal(t, 3, res.NeverVerified)
+}
+
+func TestIsDue(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := maintenance.ContentVerificationParams{Enabled: true, Interval: 24 * time.Hour}
+	s := &maintenance.Schedule{}
+
+	require.True(t, snapshotscrub.IsDue(p, s, now))
+
+	s.ReportRun(snapshotscrub.RunType, maintenance.RunInfo{Start: now.Add(-time.Hour)})
+	require.False(t, snapshotscrub.IsDue(p, s, now))
+	require.True(t, snapshotscrub.IsDue(p, s, now.Add(24*time.Hour)))
+
+	p.Enabled = false
+	require.False(t, snapshotscrub.IsDue(p, s, now.Add(24*time.Hour)))
+}
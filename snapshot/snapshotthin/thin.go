@@ -0,0 +1,214 @@
+// Package snapshotthin implements thinning of old snapshot history, such as keeping only daily snapshots
+// among snapshots older than 30 days, by deleting intermediate snapshots during maintenance.
+package snapshotthin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/logging"
+	"github.com/kopia/kopia/repo/maintenance"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+)
+
+var log = logging.GetContextLoggerFunc("snapshotthin")
+
+// RunType is the name under which snapshot thinning runs are recorded in maintenance schedule.
+const RunType = "snapshot-thinning"
+
+// ThinnedSnapshot describes a snapshot deleted by thinning.
+type ThinnedSnapshot struct {
+	Manifest *snapshot.Manifest `json:"manifest"`
+
+	// KeptInstead is the snapshot kept in place of the thinned snapshot.
+	KeptInstead *snapshot.Manifest `json:"keptInstead"`
+
+	Rule maintenance.ThinningRule `json:"rule"`
+}
+
+// String returns a description of the thinned snapshot.
+func (t ThinnedSnapshot) String() string {
+	return fmt.Sprintf("%v %v (%v), superseded by %v per rule older-than=%v interval=%v",
+		t.Manifest.Source,
+		t.Manifest.StartTime.UTC().Format(time.RFC3339),
+		t.Manifest.ID,
+		t.KeptInstead.StartTime.UTC().Format(time.RFC3339),
+		t.Rule.OlderThan,
+		t.Rule.Interval)
+}
+
+// Result describes the snapshots deleted by thinning.
+type Result struct {
+	Thinned []ThinnedSnapshot `json:"thinned"`
+}
+
+// ThinnedIDs returns IDs of thinned snapshots.
+func (r *Result) ThinnedIDs() []manifest.ID {
+	var result []manifest.ID
+
+	for _, t := range r.Thinned {
+		result = append(result, t.Manifest.ID)
+	}
+
+	return result
+}
+
+// Validate checks that the provided thinning rules are well-formed.
+func Validate(rules []maintenance.ThinningRule) error {
+	for _, r := range rules {
+		if r.OlderThan < 0 {
+			return errors.Errorf("invalid thinning rule age: %v", r.OlderThan)
+		}
+
+		if r.Interval <= 0 {
+			return errors.Errorf("invalid thinning rule interval: %v", r.Interval)
+		}
+	}
+
+	return nil
+}
+
+// Plan determines the snapshots that need to be deleted to thin snapshot history according to the provided
+// rules, without making any changes to the repository. Among snapshots of each source falling into the same
+// interval, the latest complete snapshot is kept. Snapshots protected by pins, legal holds and anomaly
+// safeguards and the latest complete snapshot of each source are never deleted.
+func Plan(ctx context.Context, rep repo.Repository, p maintenance.ThinningParams) (*Result, error) {
+	res := &Result{}
+
+	if len(p.Rules) == 0 {
+		return res, nil
+	}
+
+	if err := Validate(p.Rules); err != nil {
+		return nil, err
+	}
+
+	ids, err := snapshot.ListSnapshotManifests(ctx, rep, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list snapshots")
+	}
+
+	snapshots, err := snapshot.LoadSnapshots(ctx, rep, ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load snapshots")
+	}
+
+	// rules ordered by decreasing age, so that the first matching rule is the one with the largest age.
+	rules := append([]maintenance.ThinningRule(nil), p.Rules...)
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].OlderThan > rules[j].OlderThan
+	})
+
+	now := rep.Time()
+
+	for _, sources := range snapshot.GroupBySource(snapshots) {
+		thinned, err := planSource(ctx, rep, sources, rules, now)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to thin snapshots of %v", sources[0].Source)
+		}
+
+		res.Thinned = append(res.Thinned, thinned...)
+	}
+
+	sort.Slice(res.Thinned, func(i, j int) bool {
+		return res.Thinned[i].Manifest.StartTime.Before(res.Thinned[j].Manifest.StartTime)
+	})
+
+	return res, nil
+}
+
+type bucketKey struct {
+	rule  int
+	start time.Time
+}
+
+func planSource(ctx context.Context, rep repo.Repository, snapshots []*snapshot.Manifest, rules []maintenance.ThinningRule, now time.Time) ([]ThinnedSnapshot, error) {
+	candidates, err := policy.ExpirationCandidates(ctx, rep, snapshots)
+	if err != nil {
+		return nil, err
+	}
+
+	deletable := map[manifest.ID]bool{}
+	for _, m := range candidates {
+		deletable[m.ID] = true
+	}
+
+	kept := map[bucketKey]*snapshot.Manifest{}
+
+	var result []ThinnedSnapshot
+
+	// newest first, complete snapshots before incomplete ones, so that the latest complete snapshot
+	// in each interval is kept.
+	sorted := snapshot.SortByTime(snapshots, true)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].IncompleteReason == "" && sorted[j].IncompleteReason != ""
+	})
+
+	for _, m := range sorted {
+		ri := matchingRule(rules, now.Sub(m.StartTime))
+		if ri < 0 {
+			continue
+		}
+
+		key := bucketKey{ri, m.StartTime.Truncate(rules[ri].Interval)}
+
+		k := kept[key]
+		if k == nil {
+			kept[key] = m
+			continue
+		}
+
+		if !deletable[m.ID] {
+			log(ctx).Debugf("not thinning protected snapshot %v of %v taken at %v", m.ID, m.Source, m.StartTime)
+			continue
+		}
+
+		result = append(result, ThinnedSnapshot{m, k, rules[ri]})
+	}
+
+	return result, nil
+}
+
+// matchingRule returns the index of the first rule that applies to a snapshot of the provided age or -1.
+func matchingRule(rules []maintenance.ThinningRule, age time.Duration) int {
+	for i, r := range rules {
+		if age > r.OlderThan {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Run deletes snapshots as determined by Plan.
+func Run(ctx context.Context, rep *repo.DirectRepository, p maintenance.ThinningParams) (*Result, error) {
+	var res *Result
+
+	err := maintenance.ReportRun(ctx, rep, RunType, func() error {
+		var err error
+
+		res, err = Plan(ctx, rep, p)
+		if err != nil {
+			return err
+		}
+
+		for _, t := range res.Thinned {
+			log(ctx).Infof("thinning snapshot %v", t)
+
+			if err := rep.DeleteManifest(ctx, t.Manifest.ID); err != nil {
+				return errors.Wrapf(err, "unable to delete snapshot %v", t.Manifest.ID)
+			}
+		}
+
+		return nil
+	})
+
+	return res, err
+}
@@ -0,0 +1,124 @@
+package snapshotthin_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo/maintenance"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+	"github.com/kopia/kopia/snapshot/snapshotthin"
+)
+
+const defaultPermissions = 0o777
+
+// createSnapshots creates snapshots of a single source taken at the provided times.
+func createSnapshots(ctx context.Context, t *testing.T, env *repotesting.Environment, times []time.Time) []*snapshot.Manifest {
+	t.Helper()
+
+	var result []*snapshot.Manifest
+
+	src := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"}
+
+	for _, ts := range times {
+		sourceDir := mockfs.NewDirectory()
+		sourceDir.AddFile("f", []byte(ts.String()), defaultPermissions)
+
+		u := snapshotfs.NewUploader(env.Repository)
+
+		man, err := u.Upload(ctx, sourceDir, policy.BuildTree(nil, policy.DefaultPolicy), src)
+		require.NoError(t, err)
+
+		man.StartTime = ts
+
+		_, err = snapshot.SaveSnapshot(ctx, env.Repository, man)
+		require.NoError(t, err)
+
+		result = append(result, man)
+	}
+
+	require.NoError(t, env.Repository.Flush(ctx))
+
+	return result
+}
+
+func TestThinning(t *testing.T) {
+	var env repotesting.Environment
+
+	ctx := testlogging.Context(t)
+	defer env.Setup(t).Close(ctx, t)
+
+	now := env.Repository.Time()
+	base := now.Truncate(24 * time.Hour).Add(-5 * 24 * time.Hour)
+
+	// 4 snapshots per day for two days, followed by a recent snapshot.
+	var times []time.Time
+	for i := 0; i < 8; i++ {
+		times = append(times, base.Add(time.Duration(i)*6*time.Hour))
+	}
+
+	times = append(times, now.Add(-time.Hour))
+
+	snaps := createSnapshots(ctx, t, &env, times)
+
+	// no rules
+	res, err := snapshotthin.Plan(ctx, env.Repository, maintenance.ThinningParams{})
+	require.NoError(t, err)
+	require.Empty(t, res.Thinned)
+
+	params := maintenance.ThinningParams{
+		Rules: []maintenance.ThinningRule{
+			{OlderThan: 24 * time.Hour, Interval: 24 * time.Hour},
+		},
+	}
+
+	// the latest snapshot of each day is kept.
+	res, err = snapshotthin.Plan(ctx, env.Repository, params)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []manifest.ID{
+		snaps[0].ID, snaps[1].ID, snaps[2].ID,
+		snaps[4].ID, snaps[5].ID, snaps[6].ID,
+	}, res.ThinnedIDs())
+
+	for _, th := range res.Thinned {
+		require.Contains(t, []manifest.ID{snaps[3].ID, snaps[7].ID}, th.KeptInstead.ID)
+	}
+
+	// pinned snapshots are never thinned.
+	snaps[1].UpdatePins([]string{"keep"}, nil)
+	require.NoError(t, snapshot.UpdateSnapshot(ctx, env.Repository, snaps[1]))
+
+	res, err = snapshotthin.Plan(ctx, env.Repository, params)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []manifest.ID{
+		snaps[0].ID, snaps[2].ID,
+		snaps[4].ID, snaps[5].ID, snaps[6].ID,
+	}, res.ThinnedIDs())
+
+	// nothing was deleted by planning.
+	ids, err := snapshot.ListSnapshotManifests(ctx, env.Repository, nil)
+	require.NoError(t, err)
+	require.Len(t, ids, len(snaps))
+
+	res, err = snapshotthin.Run(ctx, env.Repository, params)
+	require.NoError(t, err)
+	require.Len(t, res.Thinned, 5)
+
+	ids, err = snapshot.ListSnapshotManifests(ctx, env.Repository, nil)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []manifest.ID{snaps[1].ID, snaps[3].ID, snaps[7].ID, snaps[8].ID}, ids)
+
+	// invalid rules are rejected.
+	_, err = snapshotthin.Plan(ctx, env.Repository, maintenance.ThinningParams{
+		Rules: []maintenance.ThinningRule{{OlderThan: time.Hour}},
+	})
+	require.Error(t, err)
+}
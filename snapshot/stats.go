@@ -21,6 +21,15 @@ type Stats struct {
 
 	ExcludedFileCount int32 `json:"excludedFileCount"`
 	ExcludedDirCount  int32 `json:"excludedDirCount"`
+
+	// EntropySampledFiles is the number of uploaded files large enough to estimate entropy of their contents,
+	// HighEntropyFiles is the number of those whose contents look random, such as compressed or encrypted files.
+	EntropySampledFiles int32 `json:"entropySampledFiles,omitempty"`
+	HighEntropyFiles    int32 `json:"highEntropyFiles,omitempty"`
+
+	// ExtensionChangedFiles is the number of new files that replaced a file from the previous snapshot
+	// with the same name and a different or additional extension.
+	ExtensionChangedFiles int32 `json:"extensionChangedFiles,omitempty"`
 }
 
 // AddExcluded adds the information about excluded file to the statistics.
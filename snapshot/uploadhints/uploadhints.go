@@ -0,0 +1,416 @@
+// Package uploadhints persists hints mapping paths, sizes and modification times of files of a source to
+// objects they were uploaded as.
+//
+// Hints allow unchanged files to be reused without reading and hashing them when previous snapshots of the
+// source can't be used, such as after the local cache was lost or the client was reinstalled. Hints are stored
+// in a local file next to the repository configuration and optionally also in the repository, as an object
+// referenced by a manifest, so that they survive loss of the local machine.
+package uploadhints
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/logging"
+	"github.com/kopia/kopia/repo/manifest"
+	"github.com/kopia/kopia/repo/object"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+)
+
+// ManifestType is the value of the "type" label for upload hint manifests.
+const ManifestType = "uploadhints"
+
+// hint objects use a dedicated prefix, so they are not mistaken for directories ("k") or catalogs ("y").
+const objectIDPrefixHints = "h"
+
+var log = logging.GetContextLoggerFunc("kopia/uploadhints")
+
+// entry is a single hint, persisted as a line of JSON.
+type entry struct {
+	Path     string    `json:"p"`
+	Size     int64     `json:"s"`
+	ModTime  int64     `json:"m"`
+	ObjectID object.ID `json:"o"`
+}
+
+// Manifest describes upload hints of a source stored in the repository.
+type Manifest struct {
+	ID manifest.ID `json:"-"`
+
+	Source     snapshot.SourceInfo `json:"source"`
+	Time       time.Time           `json:"time"`
+	ObjectID   object.ID           `json:"objectID"`
+	EntryCount int                 `json:"entryCount"`
+}
+
+// Hints holds hints of a single source loaded before a snapshot and collects hints for files of the new snapshot.
+// The nil value is valid and has no hints.
+type Hints struct {
+	mode     string
+	localDir string
+	source   snapshot.SourceInfo
+
+	mu   sync.Mutex
+	prev map[string]entry
+	next map[string]entry
+}
+
+// LocalDir returns the local directory where hints of the provided repository are stored or an empty string
+// if the repository is not connected using a configuration file.
+func LocalDir(rep repo.Repository) string {
+	dr, ok := rep.(*repo.DirectRepository)
+	if !ok || dr.ConfigFile == "" {
+		return ""
+	}
+
+	return dr.ConfigFile + ".upload-hints"
+}
+
+// Open loads hints of the provided source according to its upload policy. It returns nil when hints are disabled.
+// Hints are loaded from the local file and, if it does not exist and the policy persists hints in the repository,
+// from the repository.
+func Open(ctx context.Context, rep repo.Repository, src snapshot.SourceInfo, pol *policy.Policy) (*Hints, error) {
+	mode := pol.UploadPolicy.UploadHints
+	localDir := LocalDir(rep)
+
+	switch {
+	case mode == policy.UploadHintsNone || mode == "":
+		return nil, nil
+	case mode == policy.UploadHintsLocal && localDir == "":
+		return nil, nil
+	}
+
+	h := &Hints{
+		mode:     mode,
+		localDir: localDir,
+		source:   src,
+		next:     map[string]entry{},
+	}
+
+	var err error
+
+	if localDir != "" {
+		h.prev, err = loadLocal(h.localFile())
+		if err != nil {
+			log(ctx).Warningf("unable to load local upload hints of %v: %v", src, err)
+		}
+	}
+
+	if h.prev == nil && mode == policy.UploadHintsRepository {
+		h.prev, err = loadFromRepository(ctx, rep, src)
+		if err != nil {
+			return nil, err
+		}
+
+		if h.prev != nil {
+			log(ctx).Infof("loaded %v upload hints of %v from the repository", len(h.prev), src)
+		}
+	}
+
+	return h, nil
+}
+
+// Lookup returns the object ID of the file at the provided path relative to the source root if its size and
+// modification time are unchanged since it was recorded, or an empty ID otherwise.
+func (h *Hints) Lookup(relativePath string, e fs.Entry) object.ID {
+	if h == nil {
+		return ""
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	he, ok := h.prev[relativePath]
+	if !ok || he.Size != e.Size() || he.ModTime != e.ModTime().UnixNano() {
+		return ""
+	}
+
+	return he.ObjectID
+}
+
+// Record records the object ID of the file at the provided path relative to the source root.
+func (h *Hints) Record(relativePath string, e fs.Entry, oid object.ID) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.next[relativePath] = entry{
+		Path:     relativePath,
+		Size:     e.Size(),
+		ModTime:  e.ModTime().UnixNano(),
+		ObjectID: oid,
+	}
+}
+
+// Save persists hints recorded for the provided snapshot. Hints of an incomplete snapshot are merged with
+// previous hints, since not all files have been processed.
+func (h *Hints) Save(ctx context.Context, rep repo.Repository, man *snapshot.Manifest) error {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hints := h.next
+
+	if man.IncompleteReason != "" {
+		hints = map[string]entry{}
+
+		for k, v := range h.prev {
+			hints[k] = v
+		}
+
+		for k, v := range h.next {
+			hints[k] = v
+		}
+	}
+
+	if h.localDir != "" {
+		if err := saveLocal(h.localDir, h.localFile(), hints); err != nil {
+			return errors.Wrap(err, "unable to save local upload hints")
+		}
+	}
+
+	if h.mode == policy.UploadHintsRepository {
+		if err := saveToRepository(ctx, rep, h.source, hints); err != nil {
+			return errors.Wrap(err, "unable to save upload hints in the repository")
+		}
+	}
+
+	return nil
+}
+
+func (h *Hints) localFile() string {
+	sum := sha256.Sum256([]byte(h.source.String()))
+
+	return filepath.Join(h.localDir, hex.EncodeToString(sum[:16])+".json.gz") //nolint:gomnd
+}
+
+func writeEntries(w io.Writer, hints map[string]entry) error {
+	paths := make([]string, 0, len(hints))
+	for p := range hints {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	enc := json.NewEncoder(w)
+
+	for _, p := range paths {
+		if err := enc.Encode(hints[p]); err != nil {
+			return errors.Wrap(err, "unable to write upload hint")
+		}
+	}
+
+	return nil
+}
+
+func readEntries(r io.Reader) (map[string]entry, error) {
+	result := map[string]entry{}
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	for {
+		var e entry
+
+		err := dec.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read upload hint")
+		}
+
+		result[e.Path] = e
+	}
+}
+
+func loadLocal(fname string) (map[string]entry, error) {
+	f, err := os.Open(fname) //nolint:gosec
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close() //nolint:errcheck
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid upload hints file")
+	}
+
+	return readEntries(gz)
+}
+
+func saveLocal(dir, fname string, hints map[string]entry) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil { //nolint:gomnd
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name()) //nolint:errcheck
+
+	gz := gzip.NewWriter(f)
+
+	if err := writeEntries(gz, hints); err != nil {
+		f.Close() //nolint:errcheck
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		f.Close() //nolint:errcheck
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), fname)
+}
+
+func labelsForSource(si *snapshot.SourceInfo) map[string]string {
+	m := map[string]string{
+		manifest.TypeLabelKey: ManifestType,
+	}
+
+	if si == nil {
+		return m
+	}
+
+	m["hostname"] = si.Host
+
+	if si.UserName != "" {
+		m["username"] = si.UserName
+	}
+
+	if si.Path != "" {
+		m["path"] = si.Path
+	}
+
+	return m
+}
+
+// List returns manifests of upload hints stored in the repository for the provided source or all sources if nil.
+func List(ctx context.Context, rep repo.Repository, src *snapshot.SourceInfo) ([]*Manifest, error) {
+	entries, err := rep.FindManifests(ctx, labelsForSource(src))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to find upload hint manifests")
+	}
+
+	var result []*Manifest
+
+	for _, e := range entries {
+		hm := &Manifest{}
+		if _, err := rep.GetManifest(ctx, e.ID, hm); err != nil {
+			return nil, errors.Wrapf(err, "unable to load upload hint manifest %v", e.ID)
+		}
+
+		hm.ID = e.ID
+		result = append(result, hm)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+
+	return result, nil
+}
+
+func loadFromRepository(ctx context.Context, rep repo.Repository, src snapshot.SourceInfo) (map[string]entry, error) {
+	manifests, err := List(ctx, rep, &src)
+	if err != nil {
+		return nil, err
+	}
+
+	// List returns exact matches of labels, but hints of a host or user also match sources with paths.
+	for i := len(manifests) - 1; i >= 0; i-- {
+		hm := manifests[i]
+		if hm.Source != src {
+			continue
+		}
+
+		r, err := rep.OpenObject(ctx, hm.ObjectID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to open upload hints %v", hm.ObjectID)
+		}
+
+		defer r.Close() //nolint:errcheck
+
+		return readEntries(r)
+	}
+
+	return nil, nil
+}
+
+func saveToRepository(ctx context.Context, rep repo.Repository, src snapshot.SourceInfo, hints map[string]entry) error {
+	existing, err := List(ctx, rep, &src)
+	if err != nil {
+		return err
+	}
+
+	w := rep.NewObjectWriter(ctx, object.WriterOptions{
+		Description: "UPLOADHINTS:" + src.String(),
+		Prefix:      objectIDPrefixHints,
+	})
+	defer w.Close() //nolint:errcheck
+
+	if err := writeEntries(w, hints); err != nil {
+		return err
+	}
+
+	oid, err := w.Result()
+	if err != nil {
+		return errors.Wrap(err, "unable to write upload hints")
+	}
+
+	if _, err := rep.PutManifest(ctx, labelsForSource(&src), &Manifest{
+		Source:     src,
+		Time:       clock.Now(),
+		ObjectID:   oid,
+		EntryCount: len(hints),
+	}); err != nil {
+		return errors.Wrap(err, "unable to save upload hint manifest")
+	}
+
+	for _, hm := range existing {
+		if hm.Source != src {
+			continue
+		}
+
+		if err := rep.DeleteManifest(ctx, hm.ID); err != nil {
+			return errors.Wrap(err, "unable to delete previous upload hint manifest")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package uploadhints_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/kopia/kopia/internal/clock"
+	"github.com/kopia/kopia/internal/faketime"
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/internal/repotesting"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/repo/object"
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/snapshot/policy"
+	"github.com/kopia/kopia/snapshot/snapshotfs"
+	"github.com/kopia/kopia/snapshot/uploadhints"
+)
+
+const defaultPermissions = 0777
+
+func TestUploadHints(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	si := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"}
+
+	sourceDir := mockfs.NewDirectory()
+	sourceDir.AddFile("a.txt", []byte{1, 2, 3}, defaultPermissions)
+	sourceDir.AddDir("sub", defaultPermissions).AddFile("b.txt", []byte{4, 5}, defaultPermissions)
+
+	pol := policy.DefaultPolicy
+	pol.UploadPolicy.UploadHints = policy.UploadHintsRepository
+
+	require.NoError(t, policy.SetPolicy(ctx, env.Repository, si, pol))
+
+	st := mustUpload(ctx, t, env.Repository, sourceDir, si)
+	require.EqualValues(t, 0, st.CachedFiles)
+	require.EqualValues(t, 2, st.NonCachedFiles)
+
+	manifests, err := uploadhints.List(ctx, env.Repository, &si)
+	require.NoError(t, err)
+	require.Len(t, manifests, 1)
+	require.Equal(t, 2, manifests[0].EntryCount)
+
+	// unchanged files are reused using local hints, even without previous snapshots.
+	st = mustUpload(ctx, t, env.Repository, sourceDir, si)
+	require.EqualValues(t, 2, st.CachedFiles)
+	require.EqualValues(t, 0, st.NonCachedFiles)
+
+	// after local hints are lost, hints are loaded from the repository.
+	require.NoError(t, os.RemoveAll(uploadhints.LocalDir(env.Repository)))
+
+	sourceDir.Remove("a.txt")
+	sourceDir.AddFile("a.txt", []byte{1, 2, 3, 4}, defaultPermissions)
+
+	st = mustUpload(ctx, t, env.Repository, sourceDir, si)
+	require.EqualValues(t, 1, st.CachedFiles)
+	require.EqualValues(t, 1, st.NonCachedFiles)
+
+	// older hint manifests are replaced.
+	manifests, err = uploadhints.List(ctx, env.Repository, &si)
+	require.NoError(t, err)
+	require.Len(t, manifests, 1)
+}
+
+func TestUploadHintsIgnoreDeletedContents(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	// deletion markers must be newer than contents they apply to.
+	ta := faketime.NewTimeAdvance(clock.Now(), time.Second)
+
+	defer env.Setup(t, repotesting.Options{
+		OpenOptions: func(o *repo.Options) {
+			o.TimeNowFunc = ta.NowFunc()
+		},
+	}).Close(ctx, t)
+
+	si := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"}
+
+	sourceDir := mockfs.NewDirectory()
+	sourceDir.AddFile("a.txt", []byte{1, 2, 3}, defaultPermissions)
+
+	pol := policy.DefaultPolicy
+	pol.UploadPolicy.UploadHints = policy.UploadHintsRepository
+
+	require.NoError(t, policy.SetPolicy(ctx, env.Repository, si, pol))
+
+	mustUpload(ctx, t, env.Repository, sourceDir, si)
+
+	// simulate GC marking contents of the file as deleted.
+	w := env.Repository.NewObjectWriter(ctx, object.WriterOptions{})
+	_, err := w.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+
+	oid, err := w.Result()
+	require.NoError(t, err)
+
+	contentIDs, err := env.Repository.VerifyObject(ctx, oid)
+	require.NoError(t, err)
+
+	for _, cid := range contentIDs {
+		require.NoError(t, env.Repository.Content.DeleteContent(ctx, cid))
+	}
+
+	require.NoError(t, env.Repository.Flush(ctx))
+
+	// the hinted object is not reused, so its contents are written again.
+	st := mustUpload(ctx, t, env.Repository, sourceDir, si)
+	require.EqualValues(t, 0, st.CachedFiles)
+	require.EqualValues(t, 1, st.NonCachedFiles)
+
+	for _, cid := range contentIDs {
+		ci, err := env.Repository.Content.ContentInfo(ctx, cid)
+		require.NoError(t, err)
+		require.False(t, ci.Deleted)
+	}
+}
+
+func TestUploadHintsDisabled(t *testing.T) {
+	ctx := testlogging.Context(t)
+
+	var env repotesting.Environment
+
+	defer env.Setup(t).Close(ctx, t)
+
+	si := snapshot.SourceInfo{Host: "host", UserName: "user", Path: "/src"}
+
+	sourceDir := mockfs.NewDirectory()
+	sourceDir.AddFile("a.txt", []byte{1, 2, 3}, defaultPermissions)
+
+	pol := policy.DefaultPolicy
+	pol.UploadPolicy.UploadHints = policy.UploadHintsNone
+
+	require.NoError(t, policy.SetPolicy(ctx, env.Repository, si, pol))
+
+	mustUpload(ctx, t, env.Repository, sourceDir, si)
+
+	st := mustUpload(ctx, t, env.Repository, sourceDir, si)
+	require.EqualValues(t, 0, st.CachedFiles)
+
+	manifests, err := uploadhints.List(ctx, env.Repository, &si)
+	require.NoError(t, err)
+	require.Empty(t, manifests)
+}
+
+// mustUpload uploads the directory without previous snapshots, so that only upload hints can be used
+// to reuse files.
+func mustUpload(ctx context.Context, t *testing.T, rep *repo.DirectRepository, source *mockfs.Directory, si snapshot.SourceInfo) snapshot.Stats {
+	t.Helper()
+
+	policyTree, err := policy.TreeForSource(ctx, rep, si)
+	require.NoError(t, err)
+
+	hints, err := uploadhints.Open(ctx, rep, si, policyTree.EffectivePolicy())
+	require.NoError(t, err)
+
+	u := snapshotfs.NewUploader(rep)
+	u.Hints = hints
+
+	man, err := u.Upload(ctx, source, policyTree, si)
+	require.NoError(t, err)
+
+	require.NoError(t, hints.Save(ctx, rep, man))
+	require.NoError(t, rep.Flush(ctx))
+
+	return man.Stats
+}
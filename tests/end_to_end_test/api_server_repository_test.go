@@ -34,6 +34,11 @@ func TestAPIServerRepository(t *testing.T) {
 	originalPBlobCount := len(e1.RunAndExpectSuccess(t, "blob", "list", "--prefix=p"))
 	originalQBlobCount := len(e1.RunAndExpectSuccess(t, "blob", "list", "--prefix=q"))
 
+	// define policy profile adopted by foo@* clients on first connect.
+	profilePolicyFile := filepath.Join(e.ConfigDir, "profile-policy.json")
+	ioutil.WriteFile(profilePolicyFile, []byte(`{"retention":{"keepDaily":77}}`), 0o600)
+	e.RunAndExpectSuccess(t, "policy", "profile", "set", "laptop", "--match", "foo@*", "--from-file", profilePolicyFile)
+
 	htpasswordFile := filepath.Join(e.ConfigDir, "htpasswd.txt")
 	ioutil.WriteFile(htpasswordFile, htpasswdFileContents, 0o755)
 
@@ -112,4 +117,27 @@ func TestAPIServerRepository(t *testing.T) {
 	if got, want := len(snapshots), 3; got != want {
 		t.Errorf("invalid number of snapshots for foo@bar")
 	}
+
+	// the client should have reported itself to the server.
+	clients, err := serverapi.ListClients(ctx, cli)
+	if err != nil {
+		t.Fatalf("unable to list clients: %v", err)
+	}
+
+	if got, want := len(clients.Clients), 1; got != want {
+		t.Fatalf("unexpected number of clients: %v, want %v", got, want)
+	}
+
+	if c := clients.Clients[0]; c.Username != "foo" || c.Hostname != "bar" || c.Version != clients.ServerVersion || c.LastSnapshot == nil {
+		t.Errorf("unexpected client status: %#v", c)
+	}
+
+	if got, want := clients.Clients[0].PolicyProfile, "laptop"; got != want {
+		t.Errorf("unexpected policy profile: %v, want %v", got, want)
+	}
+
+	// the profile policy got applied to the host of the client.
+	if !containsLineWithSuffix(e.RunAndExpectSuccess(t, "policy", "list"), " @bar") {
+		t.Errorf("host policy was not created from profile")
+	}
 }
@@ -0,0 +1,55 @@
+package endtoend_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kopia/kopia/tests/testenv"
+)
+
+func TestCacheInfoReportsCorruptEntries(t *testing.T) {
+	t.Parallel()
+
+	e := testenv.NewCLITest(t)
+	defer e.RunAndExpectSuccess(t, "repo", "disconnect")
+
+	e.RunAndExpectSuccess(t, "repo", "create", "filesystem", "--path", e.RepoDir)
+
+	dataDir := t.TempDir()
+	testenv.AssertNoError(t, ioutil.WriteFile(filepath.Join(dataDir, "some-file1"), []byte("some data"), 0o600))
+
+	e.RunAndExpectSuccess(t, "snapshot", "create", dataDir)
+	sources := e.ListSnapshotsAndExpectSuccess(t)
+	entries := e.ListDirectory(t, sources[0].Snapshots[0].ObjectID)
+
+	// populate the cache.
+	e.RunAndExpectSuccess(t, "show", entries[0].ObjectID)
+
+	lines := e.RunAndExpectSuccess(t, "cache", "info")
+	cachePath := filepath.Dir(strings.Split(lines[0], ": ")[0])
+
+	if got, want := lines[len(lines)-1], "Corrupted entries found and fetched again: 0"; got != want {
+		t.Fatalf("unexpected cache info: %v, want %v", got, want)
+	}
+
+	testenv.AssertNoError(t, filepath.Walk(filepath.Join(cachePath, "contents"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		return ioutil.WriteFile(path, []byte("garbage"), 0o600)
+	}))
+
+	_, stderr := e.RunAndExpectSuccessWithErrOut(t, "show", entries[0].ObjectID)
+	if !strings.Contains(strings.Join(stderr, "\n"), "corrupted cache entries") {
+		t.Errorf("corrupted cache entries were not reported: %v", stderr)
+	}
+
+	lines = e.RunAndExpectSuccess(t, "cache", "info")
+	if got := lines[len(lines)-1]; got == "Corrupted entries found and fetched again: 0" {
+		t.Fatalf("corrupted cache entries were not counted: %v", got)
+	}
+}
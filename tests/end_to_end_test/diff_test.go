@@ -1,11 +1,14 @@
 package endtoend_test
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/kopia/kopia/snapshot/snapshotfs"
 	"github.com/kopia/kopia/tests/testenv"
 )
 
@@ -67,3 +70,36 @@ func TestDiff(t *testing.T) {
 		}
 	}
 }
+
+func TestDiffJSON(t *testing.T) {
+	t.Parallel()
+
+	e := testenv.NewCLITest(t)
+	defer e.RunAndExpectSuccess(t, "repo", "disconnect")
+
+	e.RunAndExpectSuccess(t, "repo", "create", "filesystem", "--path", e.RepoDir)
+
+	dataDir := t.TempDir()
+
+	e.RunAndExpectSuccess(t, "snapshot", "create", dataDir)
+
+	testenv.AssertNoError(t, os.MkdirAll(filepath.Join(dataDir, "foo"), 0o700))
+	testenv.AssertNoError(t, ioutil.WriteFile(filepath.Join(dataDir, "foo", "some-file1"), []byte("hello world"), 0o600))
+	e.RunAndExpectSuccess(t, "snapshot", "create", dataDir)
+
+	si := e.ListSnapshotsAndExpectSuccess(t, dataDir)
+	snapshots := si[0].Snapshots
+
+	var res snapshotfs.DiffResult
+
+	out := e.RunAndExpectSuccess(t, "diff", "--json", "--content", snapshots[0].SnapshotID, snapshots[1].SnapshotID)
+	testenv.AssertNoError(t, json.Unmarshal([]byte(strings.Join(out, "\n")), &res))
+
+	if got, want := *res.Stats, (snapshotfs.DiffStats{AddedFiles: 1, AddedDirectories: 1, SizeDelta: 11, ChangedBytes: 11}); got != want {
+		t.Errorf("unexpected stats: %+v, want %+v", got, want)
+	}
+
+	if got, want := len(res.Entries), 2; got != want {
+		t.Errorf("unexpected number of entries: %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package endtoend_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kopia/kopia/tests/testenv"
+)
+
+func TestFind(t *testing.T) {
+	t.Parallel()
+
+	e := testenv.NewCLITest(t)
+	defer e.RunAndExpectSuccess(t, "repo", "disconnect")
+
+	e.RunAndExpectSuccess(t, "repo", "create", "filesystem", "--path", e.RepoDir)
+	e.RunAndExpectSuccess(t, "policy", "set", "--global", "--compression=gzip")
+
+	dataDir := t.TempDir()
+	otherDir := t.TempDir()
+
+	compressible := []byte(strings.Repeat("some compressible data\n", 1000))
+
+	testenv.AssertNoError(t, ioutil.WriteFile(filepath.Join(dataDir, "report1.xlsx"), []byte("report1"), 0o600))
+	testenv.AssertNoError(t, ioutil.WriteFile(filepath.Join(dataDir, "report2.xlsx"), compressible, 0o600))
+	testenv.AssertNoError(t, ioutil.WriteFile(filepath.Join(dataDir, "notes.txt"), []byte("notes"), 0o600))
+	testenv.AssertNoError(t, ioutil.WriteFile(filepath.Join(otherDir, "copy-of-report2"), compressible, 0o600))
+
+	// snapshot without catalog is not searched.
+	e.RunAndExpectSuccess(t, "snapshot", "create", dataDir)
+	e.RunAndVerifyOutputLineCount(t, 0, "find", "report*.xlsx")
+
+	e.RunAndExpectSuccess(t, "catalog", "build")
+	e.RunAndVerifyOutputLineCount(t, 2, "find", "report*.xlsx")
+
+	e.RunAndExpectSuccess(t, "snapshot", "create", dataDir, "--catalog")
+	e.RunAndVerifyOutputLineCount(t, 4, "find", "report*.xlsx")
+	e.RunAndVerifyOutputLineCount(t, 2, "find", "*.txt")
+	e.RunAndVerifyOutputLineCount(t, 0, "find", "*.txt", "--before=2000-01-01")
+
+	lines := e.RunAndVerifyOutputLineCount(t, 2, "find", "--by-hash", filepath.Join(otherDir, "copy-of-report2"))
+	for _, l := range lines {
+		if !strings.HasSuffix(l, "/report2.xlsx") {
+			t.Errorf("unexpected find result: %v", l)
+		}
+	}
+
+	e.RunAndVerifyOutputLineCount(t, 0, "find", "--by-hash", filepath.Join(otherDir, "copy-of-report2"), "notes.txt")
+	e.RunAndExpectFailure(t, "find", "--by-hash", filepath.Join(otherDir, "no-such-file"))
+	e.RunAndExpectFailure(t, "find")
+}
+
+func TestFindByMetadata(t *testing.T) {
+	t.Parallel()
+
+	e := testenv.NewCLITest(t)
+	defer e.RunAndExpectSuccess(t, "repo", "disconnect")
+
+	e.RunAndExpectSuccess(t, "repo", "create", "filesystem", "--path", e.RepoDir)
+
+	dataDir := t.TempDir()
+
+	testenv.AssertNoError(t, ioutil.WriteFile(filepath.Join(dataDir, "budget.html"), []byte("<html><title>2019 Budget</title></html>"), 0o600))
+	testenv.AssertNoError(t, ioutil.WriteFile(filepath.Join(dataDir, "plan.html"), []byte("<html><title>Project plan</title></html>"), 0o600))
+
+	e.RunAndExpectFailure(t, "snapshot", "create", dataDir, "--catalog-metadata=exe")
+
+	e.RunAndExpectSuccess(t, "snapshot", "create", dataDir, "--catalog")
+	e.RunAndVerifyOutputLineCount(t, 0, "find", "--metadata", "title=*Budget*")
+
+	// rebuilding catalogs with metadata makes them searchable.
+	e.RunAndExpectSuccess(t, "catalog", "build", "--metadata=html")
+
+	lines := e.RunAndVerifyOutputLineCount(t, 1, "find", "--metadata", "title=*Budget*", "--long")
+	if !strings.Contains(lines[0], `title="2019 Budget"`) {
+		t.Errorf("unexpected find result: %v", lines[0])
+	}
+
+	e.RunAndExpectSuccess(t, "snapshot", "create", dataDir, "--catalog-metadata=all")
+	e.RunAndVerifyOutputLineCount(t, 2, "find", "*.html", "--metadata", "title=Project*")
+	e.RunAndExpectFailure(t, "find", "--metadata", "title")
+}
@@ -0,0 +1,27 @@
+package endtoend_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kopia/kopia/tests/testenv"
+)
+
+func TestMaintenanceDryRunDoesNotWrite(t *testing.T) {
+	t.Parallel()
+
+	e := testenv.NewCLITest(t)
+	defer e.RunAndExpectSuccess(t, "repo", "disconnect")
+
+	e.RunAndExpectSuccess(t, "repo", "create", "filesystem", "--path", e.RepoDir, "--no-auto-maintenance")
+	e.RunAndExpectSuccess(t, "snapshot", "create", t.TempDir(), "--no-auto-maintenance")
+
+	before := e.RunAndExpectSuccess(t, "blob", "list", "--no-auto-maintenance")
+
+	e.RunAndExpectSuccess(t, "maintenance", "run", "--dry-run")
+	e.RunAndExpectSuccess(t, "maintenance", "run", "--dry-run", "--full")
+
+	if after := e.RunAndExpectSuccess(t, "blob", "list", "--no-auto-maintenance"); !reflect.DeepEqual(before, after) {
+		t.Fatalf("dry run modified repository blobs: %v, was %v", after, before)
+	}
+}
@@ -23,8 +23,10 @@ func TestRepositoryRepair(t *testing.T) {
 	e.RunAndExpectSuccess(t, "snapshot", "create", sharedTestDataDir2)
 	e.RunAndExpectSuccess(t, "snapshot", "create", sharedTestDataDir2)
 
-	// remove kopia.repository
+	// remove kopia.repository and its replicas
 	e.RunAndExpectSuccess(t, "blob", "rm", "kopia.repository")
+	e.RunAndExpectSuccess(t, "blob", "rm", "kopia.repository.r1")
+	e.RunAndExpectSuccess(t, "blob", "rm", "kopia.repository.r2")
 	e.RunAndExpectSuccess(t, "repo", "disconnect")
 
 	// this will fail because the format blob in the repository is not found
@@ -35,4 +37,9 @@ func TestRepositoryRepair(t *testing.T) {
 
 	// now connect can succeed
 	e.RunAndExpectSuccess(t, "repo", "connect", "filesystem", "--path", e.RepoDir)
+
+	// replicas of the format blob are recovered too.
+	if got, want := len(e.RunAndExpectSuccess(t, "blob", "list", "--prefix=kopia.repository")), 3; got != want {
+		t.Errorf("unexpected number of format blob copies: %v, want %v", got, want)
+	}
 }
@@ -0,0 +1,111 @@
+package endtoend_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/kopia/kopia/internal/apiclient"
+	"github.com/kopia/kopia/internal/serverapi"
+	"github.com/kopia/kopia/internal/testlogging"
+	"github.com/kopia/kopia/tests/testenv"
+)
+
+// authHookScript authenticates foo@bar with password baz and allows all operations except deleting snapshots.
+const authHookScript = `#!/bin/sh
+req=$(cat)
+
+case "$req" in
+  *'"action":"authenticate"'*)
+    case "$req" in
+      *'"username":"foo@bar","password":"baz"'*) exit 0 ;;
+    esac
+    exit 1
+    ;;
+  *'"operation":"DELETE /api/v1/manifests/{manifestID}"'*'"type":"snapshot"'*)
+    exit 1
+    ;;
+esac
+
+exit 0
+`
+
+func TestServerAuthHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses shell script")
+	}
+
+	ctx := testlogging.Context(t)
+
+	e := testenv.NewCLITest(t)
+	defer e.RunAndExpectSuccess(t, "repo", "disconnect")
+
+	e.RunAndExpectSuccess(t, "repo", "create", "filesystem", "--path", e.RepoDir, "--override-username", "foo", "--override-hostname", "bar")
+	e.RunAndExpectSuccess(t, "snapshot", "create", sharedTestDataDir1)
+
+	hookScript := filepath.Join(e.ConfigDir, "auth-hook.sh")
+	ioutil.WriteFile(hookScript, []byte(authHookScript), 0o700)
+
+	var sp serverParameters
+
+	e.RunAndProcessStderr(t, sp.ProcessOutput,
+		"server", "start",
+		"--address=localhost:0",
+		"--tls-generate-cert",
+		"--auto-shutdown=60s",
+		"--auth-hook-command", hookScript,
+		"--auth-hook-authenticate",
+	)
+	t.Logf("detected server parameters %#v", sp)
+
+	cli, err := apiclient.NewKopiaAPIClient(apiclient.Options{
+		BaseURL:                             sp.baseURL,
+		Username:                            "foo@bar",
+		Password:                            "baz",
+		TrustedServerCertificateFingerprint: sp.sha256Fingerprint,
+		LogRequests:                         true,
+	})
+	if err != nil {
+		t.Fatalf("unable to create API apiclient")
+	}
+
+	defer serverapi.Shutdown(ctx, cli)
+
+	waitUntilServerStarted(ctx, t, cli)
+
+	e2 := testenv.NewCLITest(t)
+	defer e2.RunAndExpectSuccess(t, "repo", "disconnect")
+
+	e2.RunAndExpectFailure(t, "repo", "connect", "server",
+		"--url", sp.baseURL+"/",
+		"--server-cert-fingerprint", sp.sha256Fingerprint,
+		"--override-username", "foo",
+		"--override-hostname", "bar",
+		"--password", "wrong",
+	)
+
+	e2.RunAndExpectSuccess(t, "repo", "connect", "server",
+		"--url", sp.baseURL+"/",
+		"--server-cert-fingerprint", sp.sha256Fingerprint,
+		"--override-username", "foo",
+		"--override-hostname", "bar",
+		"--password", "baz",
+	)
+
+	snapshots := e2.ListSnapshotsAndExpectSuccess(t)
+	if got, want := len(snapshots), 1; got != want {
+		t.Fatalf("invalid number of snapshots for foo@bar: %v, want %v", got, want)
+	}
+
+	// the hook does not permit deleting snapshots.
+	_, stderr, _ := e2.Run(t, true, "snapshot", "delete", snapshots[0].Snapshots[0].SnapshotID, "--delete")
+	if !strings.Contains(strings.Join(stderr, "\n"), "operation not permitted") {
+		t.Errorf("unexpected error when deleting snapshot: %v", stderr)
+	}
+
+	if got, want := len(e2.ListSnapshotsAndExpectSuccess(t)[0].Snapshots), 1; got != want {
+		t.Errorf("snapshot was deleted despite the auth hook")
+	}
+}
@@ -150,6 +150,19 @@ func TestServerStart(t *testing.T) {
 		t.Fatalf("invalid JSON received: %v", err)
 	}
 
+	commitment, err := serverapi.GetSnapshotCommitment(ctx, cli, snaps[0].ID)
+	if err != nil {
+		t.Fatalf("unable to get snapshot commitment: %v", err)
+	}
+
+	if commitment.RootObjectID.String() != snaps[0].RootEntry || commitment.Digest == "" {
+		t.Errorf("unexpected commitment: %v", commitment)
+	}
+
+	if _, err = serverapi.GetSnapshotCommitment(ctx, cli, "no-such-snapshot"); !errors.Is(err, snapshot.ErrSnapshotNotFound) {
+		t.Errorf("unexpected error getting commitment of missing snapshot: %v", err)
+	}
+
 	keepDaily := 77
 
 	createResp, err = serverapi.CreateSnapshotSource(ctx, cli, &serverapi.CreateSnapshotSourceRequest{
@@ -0,0 +1,48 @@
+package endtoend_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kopia/kopia/tests/testenv"
+)
+
+func TestShowRange(t *testing.T) {
+	t.Parallel()
+
+	e := testenv.NewCLITest(t)
+	defer e.RunAndExpectSuccess(t, "repo", "disconnect")
+
+	e.RunAndExpectSuccess(t, "repo", "create", "filesystem", "--path", e.RepoDir)
+
+	dataDir := t.TempDir()
+
+	testenv.AssertNoError(t, ioutil.WriteFile(filepath.Join(dataDir, "some-file1"), []byte("line1\nline2\nline3\n"), 0o600))
+
+	e.RunAndExpectSuccess(t, "snapshot", "create", dataDir)
+	sources := e.ListSnapshotsAndExpectSuccess(t)
+	oid := sources[0].Snapshots[0].ObjectID
+	entries := e.ListDirectory(t, oid)
+
+	cases := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"--offset=6"}, []string{"line2", "line3"}},
+		{[]string{"--offset=6", "--length=6"}, []string{"line2"}},
+		{[]string{"--length=5"}, []string{"line1"}},
+		{[]string{"--offset=18"}, nil},
+	}
+
+	for _, tc := range cases {
+		args := append([]string{"show", entries[0].ObjectID}, tc.args...)
+
+		if lines := e.RunAndExpectSuccess(t, args...); !reflect.DeepEqual(lines, tc.want) {
+			t.Errorf("invalid output of %v: %v, want %v", tc.args, lines, tc.want)
+		}
+	}
+
+	e.RunAndExpectFailure(t, "show", entries[0].ObjectID, "--offset=100")
+}
@@ -0,0 +1,53 @@
+package endtoend_test
+
+import (
+	"testing"
+
+	"github.com/kopia/kopia/snapshot"
+	"github.com/kopia/kopia/tests/testenv"
+)
+
+func TestSnapshotClone(t *testing.T) {
+	t.Parallel()
+
+	e := testenv.NewCLITest(t)
+
+	defer e.RunAndExpectSuccess(t, "repo", "disconnect")
+
+	e.RunAndExpectSuccess(t, "repo", "create", "filesystem", "--path", e.RepoDir, "--override-hostname=host1", "--override-username=user1")
+	e.RunAndExpectSuccess(t, "snapshot", "create", sharedTestDataDir1)
+
+	si := e.ListSnapshotsAndExpectSuccess(t, sharedTestDataDir1)
+	if got, want := len(si), 1; got != want {
+		t.Fatalf("got %v sources, wanted %v", got, want)
+	}
+
+	snapID := si[0].Snapshots[0].SnapshotID
+	rootID := si[0].Snapshots[0].ObjectID
+
+	// cloning to the same source is not allowed.
+	e.RunAndExpectFailure(t, "snapshot", "clone", snapID, "--as", "user1@host1:"+sharedTestDataDir1)
+
+	e.RunAndExpectSuccess(t, "snapshot", "clone", snapID, "--as", "user2@host2:/newpath", "--dry-run")
+	assertSnapshotCount(t, e, map[snapshot.SourceInfo]int{
+		{Host: "host1", UserName: "user1", Path: sharedTestDataDir1}: 1,
+	})
+
+	e.RunAndExpectSuccess(t, "snapshot", "clone", snapID, "--as", "user2@host2:/newpath")
+	e.RunAndExpectSuccess(t, "snapshot", "clone", snapID, "--as", "user3@host3")
+
+	// cloning again is a no-op.
+	e.RunAndExpectSuccess(t, "snapshot", "clone", snapID, "--as", "user3@host3")
+
+	assertSnapshotCount(t, e, map[snapshot.SourceInfo]int{
+		{Host: "host1", UserName: "user1", Path: sharedTestDataDir1}: 1,
+		{Host: "host2", UserName: "user2", Path: "/newpath"}:         1,
+		{Host: "host3", UserName: "user3", Path: sharedTestDataDir1}: 1,
+	})
+
+	for _, src := range e.ListSnapshotsAndExpectSuccess(t, "-a") {
+		if got := src.Snapshots[0].ObjectID; got != rootID {
+			t.Errorf("unexpected root object of %v@%v:%v: %v, want %v", src.User, src.Host, src.Path, got, rootID)
+		}
+	}
+}
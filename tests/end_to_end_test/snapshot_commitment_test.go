@@ -0,0 +1,41 @@
+package endtoend_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kopia/kopia/tests/testenv"
+)
+
+func TestSnapshotCommitment(t *testing.T) {
+	t.Parallel()
+
+	e := testenv.NewCLITest(t)
+	defer e.RunAndExpectSuccess(t, "repo", "disconnect")
+
+	e.RunAndExpectSuccess(t, "repo", "create", "filesystem", "--path", e.RepoDir)
+	e.RunAndExpectSuccess(t, "snapshot", "create", sharedTestDataDir1)
+
+	snapshots := e.ListSnapshotsAndExpectSuccess(t, sharedTestDataDir1)
+	snapID := snapshots[0].Snapshots[0].SnapshotID
+
+	commitment := e.RunAndExpectSuccess(t, "snapshot", "commitment", "show", snapID)
+	commitmentFile := filepath.Join(t.TempDir(), "commitment.json")
+	testenv.AssertNoError(t, ioutil.WriteFile(commitmentFile, []byte(strings.Join(commitment, "\n")), 0o600))
+
+	digest := e.RunAndExpectSuccess(t, "snapshot", "commitment", "show", snapID, "--digest-only")
+	if !strings.Contains(strings.Join(commitment, "\n"), `"digest": "`+digest[0]+`"`) {
+		t.Errorf("digest %v not found in commitment: %v", digest, commitment)
+	}
+
+	e.RunAndExpectSuccess(t, "snapshot", "commitment", "verify", commitmentFile)
+
+	// commitment modified after it has been anchored is rejected.
+	tampered := strings.Replace(strings.Join(commitment, "\n"), `"contentCount": `, `"contentCount": 1`, 1)
+	testenv.AssertNoError(t, ioutil.WriteFile(commitmentFile, []byte(tampered), 0o600))
+	e.RunAndExpectFailure(t, "snapshot", "commitment", "verify", commitmentFile)
+
+	e.RunAndExpectFailure(t, "snapshot", "commitment", "show", "no-such-snapshot")
+}
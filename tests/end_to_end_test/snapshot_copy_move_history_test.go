@@ -1,6 +1,7 @@
 package endtoend_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/kopia/kopia/snapshot"
@@ -109,3 +110,53 @@ func assertSnapshotCount(t *testing.T, e *testenv.CLITest, wantSnapshotCounts ma
 		t.Fatalf("unexpected number of sources: %v, want %v", got, want)
 	}
 }
+
+func TestSnapshotMoveHistoryWithPolicies(t *testing.T) {
+	t.Parallel()
+
+	e := testenv.NewCLITest(t)
+
+	defer e.RunAndExpectSuccess(t, "repo", "disconnect")
+
+	e.RunAndExpectSuccess(t, "repo", "create", "filesystem", "--path", e.RepoDir, "--override-hostname=host1", "--override-username=user1")
+	e.RunAndExpectSuccess(t, "snapshot", "create", sharedTestDataDir1)
+	e.RunAndExpectSuccess(t, "policy", "set", sharedTestDataDir1, "--keep-latest=7")
+	e.RunAndExpectSuccess(t, "policy", "set", "@host1", "--keep-daily=3")
+
+	// source can't be specified both as a flag and an argument.
+	e.RunAndExpectFailure(t, "snapshot", "move-history", "--from", "@host1", "@host1", "@host2")
+	e.RunAndExpectFailure(t, "snapshot", "move-history", "--to", "@host2", "@host1", "@host2")
+
+	// policies are not moved by default.
+	e.RunAndExpectSuccess(t, "snapshot", "copy-history", "--from", "@host1", "--to", "@host3")
+	assertSnapshotCount(t, e, map[snapshot.SourceInfo]int{
+		{Host: "host1", UserName: "user1", Path: sharedTestDataDir1}: 1,
+		{Host: "host3", UserName: "user1", Path: sharedTestDataDir1}: 1,
+	})
+
+	if policies := e.RunAndExpectSuccess(t, "policy", "list"); containsLineWithSuffix(policies, " @host3") {
+		t.Errorf("policies were copied without --policies: %v", policies)
+	}
+
+	e.RunAndExpectSuccess(t, "snapshot", "move-history", "--from", "@host1", "--to", "@host2", "--policies")
+	assertSnapshotCount(t, e, map[snapshot.SourceInfo]int{
+		{Host: "host2", UserName: "user1", Path: sharedTestDataDir1}: 1,
+		{Host: "host3", UserName: "user1", Path: sharedTestDataDir1}: 1,
+	})
+
+	// policies have been moved along with the snapshots.
+	policies := e.RunAndExpectSuccess(t, "policy", "list")
+	if len(policies) != 3 || !containsLineWithSuffix(policies, " user1@host2:"+sharedTestDataDir1) || !containsLineWithSuffix(policies, " @host2") {
+		t.Errorf("policies were not moved: %v", policies)
+	}
+}
+
+func containsLineWithSuffix(lines []string, suffix string) bool {
+	for _, l := range lines {
+		if strings.HasSuffix(l, suffix) {
+			return true
+		}
+	}
+
+	return false
+}